@@ -0,0 +1,417 @@
+// Package bake generates texture maps (e.g. ambient occlusion and curvature)
+// for the meshes of a compiled scene. Maps are rasterized in the uv space of
+// the baked mesh using the same uv convention as the tracer, so v=0 maps to
+// the top row of the generated image.
+package bake
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Defaults for baked maps.
+const (
+	defaultMapSize   uint32 = 1024
+	defaultAOSamples        = 64
+
+	// The default AO ray length and cage offset as a fraction of the
+	// bounding box diagonal of the baked mesh instance.
+	defaultAODistanceScale  float32 = 0.25
+	defaultCageOffsetScale  float32 = 1e-4
+	vertexWeldDistanceScale float32 = 1e-6
+)
+
+// Options for baking texture maps.
+type Options struct {
+	// The dims of the generated map. Defaults to 1024x1024.
+	Width  uint32
+	Height uint32
+
+	// Number of hemisphere rays per texel for AO maps. Defaults to 64.
+	Samples int
+
+	// The max distance for AO occlusion tests. Geometry further away does
+	// not occlude the texel. Defaults to 25% of the bounding box diagonal
+	// of the baked mesh instance.
+	MaxDistance float32
+
+	// The distance that AO ray origins are pushed along the surface normal
+	// (the cage). Increasing the offset helps to avoid artifacts caused by
+	// self-intersections on low-poly geometry. Defaults to a small fraction
+	// of the bounding box diagonal of the baked mesh instance.
+	CageOffset float32
+
+	// If set, only the baked mesh instance occludes AO rays; otherwise
+	// all scene geometry is considered.
+	SelfOcclusionOnly bool
+
+	// The curvature (in inverse scene units) that maps to white/black in
+	// curvature maps. If zero, the max absolute curvature of the mesh is used.
+	CurvatureRange float32
+
+	// Number of texel rings to extend the baked texels across uv seams
+	// and into empty areas. This prevents seams from showing up when the
+	// map is sampled using bilinear filtering or mip-mapping.
+	Dilation int
+
+	// The seed for the AO sampler.
+	Seed int64
+
+	// The max angle (in degrees) between the normals of the triangles in
+	// a generated lightmap uv chart. Defaults to 66 degrees.
+	ChartAngle float32
+
+	// Number of texels to keep between generated lightmap uv charts.
+	// Defaults to 4.
+	ChartPadding int
+}
+
+// Apply default values for unset options.
+func (opts *Options) applyDefaults(diag float32) {
+	if opts.Width == 0 {
+		opts.Width = defaultMapSize
+	}
+	if opts.Height == 0 {
+		opts.Height = defaultMapSize
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = defaultAOSamples
+	}
+	if opts.MaxDistance <= 0 {
+		opts.MaxDistance = diag * defaultAODistanceScale
+	}
+	if opts.CageOffset <= 0 {
+		opts.CageOffset = diag * defaultCageOffsetScale
+	}
+	if opts.ChartAngle <= 0 {
+		opts.ChartAngle = defaultChartAngle
+	}
+	if opts.ChartPadding <= 0 {
+		opts.ChartPadding = defaultChartPadding
+	}
+}
+
+// A mesh triangle in world space.
+type triangle struct {
+	prim uint32
+	pos  [3]types.Vec3
+	n    [3]types.Vec3
+	uv   [3]types.Vec2
+}
+
+// A texel covered by a mesh triangle.
+type texel struct {
+	index int
+	tri   *triangle
+	bc    types.Vec3
+}
+
+// Bake an ambient occlusion map for a mesh instance. White texels are fully
+// unoccluded while black texels are fully occluded.
+func AmbientOcclusion(sc *scene.Scene, instance int, opts Options) (*image.Gray, error) {
+	tris, diag, err := instanceTriangles(sc, instance)
+	if err != nil {
+		return nil, err
+	} else if !hasUVs(tris) {
+		return nil, ErrMissingUVs
+	}
+	opts.applyDefaults(diag)
+
+	rc := &rayCaster{sc: sc, onlyInstance: -1}
+	if opts.SelfOcclusionOnly {
+		rc.onlyInstance = instance
+	}
+
+	texels, err := rasterize(tris, opts.Width, opts.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]float32, opts.Width*opts.Height)
+	parallelize(len(texels), func(index int) {
+		t := texels[index]
+		pos := interpolate3(t.tri.pos, t.bc)
+		normal := interpolate3(t.tri.n, t.bc).Normalize()
+		origin := pos.Add(normal.Mul(opts.CageOffset))
+
+		rng := newSampler(opts.Seed, t.index)
+		var visible int
+		for sample := 0; sample < opts.Samples; sample++ {
+			dir := cosineSampleHemisphere(normal, rng.next(), rng.next())
+			if !rc.occluded(origin, dir, opts.MaxDistance) {
+				visible++
+			}
+		}
+		values[t.index] = float32(visible) / float32(opts.Samples)
+	})
+
+	return toImage(values, coverage(texels, len(values)), opts), nil
+}
+
+// Bake a curvature map for a mesh instance. Curvature is estimated from the
+// variation of the shading normals across the mesh. Flat areas are mapped to
+// mid-gray, convex areas to lighter values and concave areas to darker values.
+func Curvature(sc *scene.Scene, instance int, opts Options) (*image.Gray, error) {
+	tris, diag, err := instanceTriangles(sc, instance)
+	if err != nil {
+		return nil, err
+	} else if !hasUVs(tris) {
+		return nil, ErrMissingUVs
+	}
+	opts.applyDefaults(diag)
+
+	texels, err := rasterize(tris, opts.Width, opts.Height)
+	if err != nil {
+		return nil, err
+	}
+
+	vertexCurvature := estimateCurvature(tris, diag*vertexWeldDistanceScale)
+	curvRange := opts.CurvatureRange
+	if curvRange <= 0 {
+		for _, k := range vertexCurvature {
+			for _, vk := range k {
+				curvRange = float32(math.Max(float64(curvRange), math.Abs(float64(vk))))
+			}
+		}
+	}
+
+	values := make([]float32, opts.Width*opts.Height)
+	for _, t := range texels {
+		value := float32(0.5)
+		if curvRange > 0 {
+			k := vertexCurvature[t.tri]
+			value = 0.5 + 0.5*clamp(k.Dot(t.bc)/curvRange, -1, 1)
+		}
+		values[t.index] = value
+	}
+
+	return toImage(values, coverage(texels, len(values)), opts), nil
+}
+
+// Collect the triangles of a mesh instance in world space and calculate the
+// length of their bounding box diagonal.
+func instanceTriangles(sc *scene.Scene, instance int) ([]*triangle, float32, error) {
+	if instance < 0 || instance >= len(sc.MeshInstanceList) {
+		return nil, 0, fmt.Errorf("%s: scene defines %d mesh instances; got index %d", ErrInvalidMeshInstance.Error(), len(sc.MeshInstanceList), instance)
+	}
+
+	mi := sc.MeshInstanceList[instance]
+	toWorld := mi.MeshToWorld()
+
+	// Normals are transformed using the inverse transpose of the mesh to
+	// world transformation, i.e. the transpose of the instance transform
+	normalMat := mi.WorldToMesh().Mat3()
+
+	maxF := float32(math.MaxFloat32)
+	bbox := [2]types.Vec3{{maxF, maxF, maxF}, {-maxF, -maxF, -maxF}}
+
+	var tris []*triangle
+	stack := []int32{int32(mi.BvhRoot)}
+	for len(stack) > 0 {
+		node := sc.BvhNodeList[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if node.LData > 0 {
+			stack = append(stack, node.LData, node.RData)
+			continue
+		}
+
+		first, count := node.GetPrimitives()
+		for prim := first; prim < first+count; prim++ {
+			tri := &triangle{prim: prim}
+			for v := uint32(0); v < 3; v++ {
+				tri.pos[v] = toWorld.Mul4x1(sc.VertexList[prim*3+v].Vec3().Vec4(1)).Vec3()
+				tri.n[v] = transposeMul(normalMat, sc.NormalList[prim*3+v].Vec3()).Normalize()
+				tri.uv[v] = sc.UvList[prim*3+v]
+				bbox[0] = types.MinVec3(bbox[0], tri.pos[v])
+				bbox[1] = types.MaxVec3(bbox[1], tri.pos[v])
+			}
+			tris = append(tris, tri)
+		}
+	}
+
+	if len(tris) == 0 {
+		return nil, 0, ErrNoTexels
+	}
+	return tris, bbox[1].Sub(bbox[0]).Len(), nil
+}
+
+// Find the texels whose centers are covered by the uv-space projection of
+// each triangle. If multiple triangles cover the same texel, the last one wins.
+func rasterize(tris []*triangle, width, height uint32) ([]texel, error) {
+	owners := make([]int, width*height)
+	for index := range owners {
+		owners[index] = -1
+	}
+
+	var texels []texel
+	for _, tri := range tris {
+		var p [3]types.Vec2
+		for v := 0; v < 3; v++ {
+			p[v] = types.Vec2{tri.uv[v][0] * float32(width), tri.uv[v][1] * float32(height)}
+		}
+
+		area := edgeFunc(p[0], p[1], p[2])
+		if area == 0 {
+			continue
+		}
+
+		minX, maxX := texelRange(p[0][0], p[1][0], p[2][0], width)
+		minY, maxY := texelRange(p[0][1], p[1][1], p[2][1], height)
+		for y := minY; y <= maxY; y++ {
+			for x := minX; x <= maxX; x++ {
+				c := types.Vec2{float32(x) + 0.5, float32(y) + 0.5}
+				bc := types.Vec3{edgeFunc(p[1], p[2], c) / area, edgeFunc(p[2], p[0], c) / area, edgeFunc(p[0], p[1], c) / area}
+				if bc[0] < 0 || bc[1] < 0 || bc[2] < 0 {
+					continue
+				}
+
+				index := y*int(width) + x
+				t := texel{index: index, tri: tri, bc: bc}
+				if owner := owners[index]; owner >= 0 {
+					texels[owner] = t
+				} else {
+					owners[index] = len(texels)
+					texels = append(texels, t)
+				}
+			}
+		}
+	}
+
+	if len(texels) == 0 {
+		return nil, ErrNoTexels
+	}
+	return texels, nil
+}
+
+// Estimate the mean curvature at each triangle vertex. For each mesh edge
+// the curvature is approximated by the change of the normal along the edge
+// divided by the edge length. Vertices closer than weldDist are treated as
+// the same vertex so that the estimates are averaged across adjacent
+// triangles. The returned map contains the vertex curvatures for each triangle.
+func estimateCurvature(tris []*triangle, weldDist float32) map[*triangle]types.Vec3 {
+	key := func(p types.Vec3) [3]int64 { return weldKey(p, weldDist) }
+
+	type estimate struct {
+		sum   float32
+		count int
+	}
+	estimates := make(map[[3]int64]*estimate)
+	for _, tri := range tris {
+		for v := 0; v < 3; v++ {
+			k := key(tri.pos[v])
+			est := estimates[k]
+			if est == nil {
+				est = &estimate{}
+				estimates[k] = est
+			}
+
+			for _, other := range []int{(v + 1) % 3, (v + 2) % 3} {
+				edge := tri.pos[other].Sub(tri.pos[v])
+				if lenSq := edge.Dot(edge); lenSq > 0 {
+					est.sum += tri.n[other].Sub(tri.n[v]).Dot(edge) / lenSq
+					est.count++
+				}
+			}
+		}
+	}
+
+	curvature := make(map[*triangle]types.Vec3, len(tris))
+	for _, tri := range tris {
+		var k types.Vec3
+		for v := 0; v < 3; v++ {
+			if est := estimates[key(tri.pos[v])]; est.count > 0 {
+				k[v] = est.sum / float32(est.count)
+			}
+		}
+		curvature[tri] = k
+	}
+	return curvature
+}
+
+// Quantize a vertex position so that vertices closer than weldDist map to
+// the same key.
+func weldKey(p types.Vec3, weldDist float32) [3]int64 {
+	if weldDist <= 0 {
+		weldDist = 1e-6
+	}
+	return [3]int64{
+		int64(math.Floor(float64(p[0] / weldDist))),
+		int64(math.Floor(float64(p[1] / weldDist))),
+		int64(math.Floor(float64(p[2] / weldDist))),
+	}
+}
+
+// Build a mask of the texels that are covered by a triangle.
+func coverage(texels []texel, numTexels int) []bool {
+	mask := make([]bool, numTexels)
+	for _, t := range texels {
+		mask[t.index] = true
+	}
+	return mask
+}
+
+// Dilate the baked values and convert them to a grayscale image.
+func toImage(values []float32, mask []bool, opts Options) *image.Gray {
+	dilate(values, mask, int(opts.Width), int(opts.Height), opts.Dilation)
+
+	im := image.NewGray(image.Rect(0, 0, int(opts.Width), int(opts.Height)))
+	for index, value := range values {
+		im.Pix[index] = uint8(clamp(value, 0, 1)*255 + 0.5)
+	}
+	return im
+}
+
+// Run fn for each index in [0, count) using all available CPUs.
+func parallelize(count int, fn func(index int)) {
+	workers := runtime.NumCPU()
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for worker := 0; worker < workers; worker++ {
+		go func(worker int) {
+			defer wg.Done()
+			for index := worker; index < count; index += workers {
+				fn(index)
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
+
+// Get the range of texels whose centers may be covered by a triangle with
+// the given coordinates along one axis.
+func texelRange(a, b, c float32, size uint32) (int, int) {
+	lo := math.Min(float64(a), math.Min(float64(b), float64(c)))
+	hi := math.Max(float64(a), math.Max(float64(b), float64(c)))
+	return int(math.Max(math.Floor(lo-0.5), 0)), int(math.Min(math.Ceil(hi-0.5), float64(size-1)))
+}
+
+// Calculate the signed area of the parallelogram defined by the vectors
+// (b - a) and (c - a).
+func edgeFunc(a, b, c types.Vec2) float32 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// Interpolate a triangle attribute using barycentric coordinates.
+func interpolate3(attr [3]types.Vec3, bc types.Vec3) types.Vec3 {
+	return attr[0].Mul(bc[0]).Add(attr[1].Mul(bc[1])).Add(attr[2].Mul(bc[2]))
+}
+
+// Multiply a vector with the transpose of a 3x3 matrix.
+func transposeMul(m types.Mat3, v types.Vec3) types.Vec3 {
+	return types.Vec3{
+		m[0]*v[0] + m[1]*v[1] + m[2]*v[2],
+		m[3]*v[0] + m[4]*v[1] + m[5]*v[2],
+		m[6]*v[0] + m[7]*v[1] + m[8]*v[2],
+	}
+}
+
+// Clamp a value to the [min, max] range.
+func clamp(v, min, max float32) float32 {
+	return float32(math.Max(float64(min), math.Min(float64(max), float64(v))))
+}
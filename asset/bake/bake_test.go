@@ -0,0 +1,254 @@
+package bake
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/stattest"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Create a scene with two instances of a unit quad facing +Y. The first
+// instance lies on the XZ plane and the second one floats above it at the
+// specified height.
+func bakeTestScene(coverHeight float32) *scene.Scene {
+	quadVerts := []types.Vec4{
+		{0, 0, 0, 0}, {1, 0, 1, 0}, {1, 0, 0, 0},
+		{0, 0, 0, 0}, {0, 0, 1, 0}, {1, 0, 1, 0},
+	}
+	quadUVs := []types.Vec2{
+		{0, 0}, {1, 1}, {1, 0},
+		{0, 0}, {0, 1}, {1, 1},
+	}
+	up := types.Vec4{0, 1, 0, 0}
+
+	sc := &scene.Scene{
+		VertexList: quadVerts,
+		NormalList: []types.Vec4{up, up, up, up, up, up},
+		UvList:     quadUVs,
+		MeshInstanceList: []scene.MeshInstance{
+			{BvhRoot: 3, Transform: types.Ident4()},
+			// Raise the cover quad to coverHeight in world space
+			{BvhRoot: 3, Transform: types.Translate4(types.Vec3{0, -coverHeight, 0})},
+		},
+	}
+
+	sc.BvhNodeList = make([]scene.BvhNode, 4)
+	sc.BvhNodeList[0] = scene.BvhNode{Min: types.Vec3{0, 0, 0}, Max: types.Vec3{1, coverHeight, 1}}
+	sc.BvhNodeList[0].SetChildNodes(1, 2)
+	sc.BvhNodeList[1] = scene.BvhNode{Min: types.Vec3{0, 0, 0}, Max: types.Vec3{1, 0, 1}}
+	sc.BvhNodeList[1].SetMeshIndex(0)
+	sc.BvhNodeList[2] = scene.BvhNode{Min: types.Vec3{0, coverHeight, 0}, Max: types.Vec3{1, coverHeight, 1}}
+	sc.BvhNodeList[2].SetMeshIndex(1)
+	sc.BvhNodeList[3] = scene.BvhNode{Min: types.Vec3{0, 0, 0}, Max: types.Vec3{1, 0, 1}}
+	sc.BvhNodeList[3].SetPrimitives(0, 2)
+
+	return sc
+}
+
+func TestAmbientOcclusion(t *testing.T) {
+	sc := bakeTestScene(0.05)
+	opts := Options{Width: 8, Height: 8, Samples: 16, MaxDistance: 10}
+
+	// The cover quad occludes most of the hemisphere above the ground quad
+	im, err := AmbientOcclusion(sc, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if center := im.GrayAt(4, 4).Y; center > 64 {
+		t.Fatalf("expected the center texel to be mostly occluded; got %d", center)
+	}
+
+	// Rays should not reach the cover quad when it lies beyond the max distance
+	opts.MaxDistance = 0.01
+	im, err = AmbientOcclusion(sc, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if center := im.GrayAt(4, 4).Y; center != 255 {
+		t.Fatalf("expected the center texel to be unoccluded; got %d", center)
+	}
+
+	// Self-occlusion only bakes ignore the cover quad
+	opts.MaxDistance = 10
+	opts.SelfOcclusionOnly = true
+	im, err = AmbientOcclusion(sc, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if center := im.GrayAt(4, 4).Y; center != 255 {
+		t.Fatalf("expected the center texel to be unoccluded; got %d", center)
+	}
+
+	_, err = AmbientOcclusion(sc, 2, opts)
+	if err == nil {
+		t.Fatal("expected to get an error for an invalid mesh instance")
+	}
+}
+
+func TestCurvature(t *testing.T) {
+	sc := bakeTestScene(1)
+
+	// A flat quad has zero curvature
+	im, err := Curvature(sc, 0, Options{Width: 4, Height: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, value := range im.Pix {
+		if value != 128 {
+			t.Fatalf("expected texel %d to be mid-gray; got %d", index, value)
+		}
+	}
+
+	// Tilt the normals of the far edge outwards to create a convex bend
+	for _, v := range []int{1, 4, 5} {
+		sc.NormalList[v] = types.Vec4{0, 1, 1, 0}.Normalize()
+	}
+	im, err = Curvature(sc, 0, Options{Width: 4, Height: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := im.GrayAt(2, 2).Y; value <= 128 {
+		t.Fatalf("expected convex areas to be lighter than mid-gray; got %d", value)
+	}
+}
+
+func TestDilate(t *testing.T) {
+	values := []float32{
+		0, 0, 0, 0,
+		0, 1, 0, 0,
+		0, 0, 0, 0,
+		0, 0, 0, 0,
+	}
+	mask := make([]bool, len(values))
+	mask[5] = true
+
+	dilate(values, mask, 4, 4, 1)
+	for index, value := range values {
+		x, y := index%4, index/4
+		exp := float32(0)
+		if x <= 2 && y <= 2 {
+			exp = 1
+		}
+		if value != exp {
+			t.Errorf("expected texel (%d, %d) to be %f after 1 pass; got %f", x, y, exp, value)
+		}
+	}
+
+	dilate(values, mask, 4, 4, 2)
+	for index, value := range values {
+		if value != 1 {
+			t.Errorf("expected texel %d to be covered after 2 passes; got %f", index, value)
+		}
+	}
+}
+
+func TestGenerateLightmapUVs(t *testing.T) {
+	// Build a unit cube without texture coordinates
+	corners := func(i int) types.Vec4 {
+		return types.Vec4{float32(i & 1), float32((i >> 1) & 1), float32((i >> 2) & 1), 0}
+	}
+	faces := [][4]int{
+		{0, 2, 3, 1}, {4, 5, 7, 6}, {0, 1, 5, 4},
+		{2, 6, 7, 3}, {0, 4, 6, 2}, {1, 3, 7, 5},
+	}
+	sc := &scene.Scene{
+		MeshInstanceList: []scene.MeshInstance{
+			{BvhRoot: 0, Transform: types.Ident4()},
+		},
+	}
+	for _, f := range faces {
+		for _, v := range []int{f[0], f[1], f[2], f[0], f[2], f[3]} {
+			sc.VertexList = append(sc.VertexList, corners(v))
+			sc.NormalList = append(sc.NormalList, types.Vec4{})
+		}
+	}
+	sc.UvList = make([]types.Vec2, len(sc.VertexList))
+	sc.BvhNodeList = make([]scene.BvhNode, 1)
+	sc.BvhNodeList[0] = scene.BvhNode{Min: types.Vec3{0, 0, 0}, Max: types.Vec3{1, 1, 1}}
+	sc.BvhNodeList[0].SetPrimitives(0, 12)
+
+	if hasUVs, err := HasUVs(sc, 0); err != nil || hasUVs {
+		t.Fatalf("expected mesh to lack uvs; got %t, %v", hasUVs, err)
+	}
+	if _, err := Curvature(sc, 0, Options{Width: 4, Height: 4}); err != ErrMissingUVs {
+		t.Fatalf("expected to get ErrMissingUVs; got %v", err)
+	}
+
+	opts := Options{Width: 64, Height: 64, ChartPadding: 2}
+	numCharts, err := GenerateLightmapUVs(sc, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numCharts != 6 {
+		t.Fatalf("expected 6 charts; got %d", numCharts)
+	}
+
+	// Rasterize the uvs and make sure that charts do not overlap
+	tris, _, err := instanceTriangles(sc, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners := make(map[int]int)
+	for index, tri := range tris {
+		for _, uv := range tri.uv {
+			if uv[0] < 0 || uv[0] > 1 || uv[1] < 0 || uv[1] > 1 {
+				t.Fatalf("expected uv %v of triangle %d to be in the [0, 1] range", uv, index)
+			}
+		}
+		texels, err := rasterize([]*triangle{tri}, opts.Width, opts.Height)
+		if err != nil {
+			t.Fatalf("expected triangle %d to cover some texels: %v", index, err)
+		}
+		for _, texel := range texels {
+			if owner, exists := owners[texel.index]; exists && owner/2 != index/2 {
+				t.Fatalf("expected texel %d to be covered by a single chart; triangles %d and %d overlap", texel.index, owner, index)
+			}
+			owners[texel.index] = index
+		}
+	}
+}
+
+func TestCosineSampleHemisphere(t *testing.T) {
+	// For cosine-weighted directions sin^2(theta) and phi are uniformly
+	// distributed; bin the samples over both and check for uniformity.
+	const thetaBins, phiBins = 8, 16
+	normals := []types.Vec3{{0, 0, 1}, {1, 0, 0}, types.Vec3{1, -2, 3}.Normalize()}
+	significance := stattest.SidakSignificance(0.001, len(normals))
+
+	for _, normal := range normals {
+		tangent := types.Vec3{1, 0, 0}
+		if math.Abs(float64(normal[0])) > 0.9 {
+			tangent = types.Vec3{0, 1, 0}
+		}
+		tangent = normal.Cross(tangent).Normalize()
+		bitangent := normal.Cross(tangent)
+
+		observed := make([]int, thetaBins*phiBins)
+		rng := newSampler(1, 0)
+		for sample := 0; sample < 50000; sample++ {
+			dir := cosineSampleHemisphere(normal, rng.next(), rng.next())
+			cosTheta := float64(dir.Dot(normal))
+			if cosTheta < 0 {
+				t.Fatalf("expected direction %v to lie in the hemisphere around %v", dir, normal)
+			}
+
+			phi := math.Atan2(float64(dir.Dot(bitangent)), float64(dir.Dot(tangent)))
+			if phi < 0 {
+				phi += 2 * math.Pi
+			}
+			thetaBin := int(math.Min((1-cosTheta*cosTheta)*thetaBins, thetaBins-1))
+			phiBin := int(math.Min(phi/(2*math.Pi)*phiBins, phiBins-1))
+			observed[thetaBin*phiBins+phiBin]++
+		}
+
+		res, err := stattest.ChiSquareUniform(observed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reject(significance) {
+			t.Errorf("expected directions around %v to be cosine-distributed; %s", normal, res)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package bake
+
+// Extend the covered texels of a map into the surrounding empty texels. Each
+// pass assigns the average of the covered 8-neighbors to every empty texel
+// next to a covered texel and marks it as covered.
+func dilate(values []float32, mask []bool, width, height, passes int) {
+	mask = append([]bool(nil), mask...)
+	next := make([]bool, len(mask))
+	for pass := 0; pass < passes; pass++ {
+		copy(next, mask)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if mask[y*width+x] {
+					continue
+				}
+
+				var sum float32
+				var count int
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := x+dx, y+dy
+						if nx < 0 || ny < 0 || nx >= width || ny >= height || !mask[ny*width+nx] {
+							continue
+						}
+						sum += values[ny*width+nx]
+						count++
+					}
+				}
+
+				if count > 0 {
+					values[y*width+x] = sum / float32(count)
+					next[y*width+x] = true
+				}
+			}
+		}
+		mask, next = next, mask
+	}
+}
@@ -0,0 +1,9 @@
+package bake
+
+import "errors"
+
+var (
+	ErrInvalidMeshInstance = errors.New("bake: invalid mesh instance")
+	ErrMissingUVs          = errors.New("bake: mesh does not define texture coordinates; use GenerateLightmapUVs to generate them")
+	ErrNoTexels            = errors.New("bake: mesh instance does not cover any texels; check that the mesh defines texture coordinates")
+)
@@ -0,0 +1,147 @@
+package bake
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The max depth of the BVH traversal stack.
+const maxTraversalDepth = 64
+
+// A rayCaster performs occlusion queries against the geometry of a compiled
+// scene by traversing its two-level BVH on the host.
+type rayCaster struct {
+	sc *scene.Scene
+
+	// If non-negative, only the mesh instance with this index is tested
+	// for occlusion.
+	onlyInstance int
+}
+
+// Check whether a ray intersects any scene geometry within maxDist. The ray
+// direction does not need to be normalized; maxDist is measured in units of
+// the direction length.
+func (rc *rayCaster) occluded(origin, dir types.Vec3, maxDist float32) bool {
+	if len(rc.sc.BvhNodeList) == 0 {
+		return false
+	}
+
+	var stack [maxTraversalDepth]int32
+	stack[0] = 0
+	for top := 1; top > 0; {
+		top--
+		node := &rc.sc.BvhNodeList[stack[top]]
+		if !intersectBox(node, origin, dir, maxDist) {
+			continue
+		}
+
+		if node.LData > 0 {
+			if top+2 <= maxTraversalDepth {
+				stack[top], stack[top+1] = node.LData, node.RData
+				top += 2
+			}
+			continue
+		}
+
+		instIndex := int(node.GetMeshIndex())
+		if rc.onlyInstance >= 0 && instIndex != rc.onlyInstance {
+			continue
+		}
+
+		mi := &rc.sc.MeshInstanceList[instIndex]
+		toMesh := mi.WorldToMesh()
+		meshOrigin := toMesh.Mul4x1(origin.Vec4(1)).Vec3()
+		meshDir := toMesh.Mul4x1(dir.Vec4(0)).Vec3()
+		if rc.meshOccluded(mi.BvhRoot, meshOrigin, meshDir, maxDist) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check whether a ray in mesh space intersects any of the mesh triangles.
+func (rc *rayCaster) meshOccluded(root uint32, origin, dir types.Vec3, maxDist float32) bool {
+	var stack [maxTraversalDepth]int32
+	stack[0] = int32(root)
+	for top := 1; top > 0; {
+		top--
+		node := &rc.sc.BvhNodeList[stack[top]]
+		if !intersectBox(node, origin, dir, maxDist) {
+			continue
+		}
+
+		if node.LData > 0 {
+			if top+2 <= maxTraversalDepth {
+				stack[top], stack[top+1] = node.LData, node.RData
+				top += 2
+			}
+			continue
+		}
+
+		first, count := node.GetPrimitives()
+		for prim := first; prim < first+count; prim++ {
+			v := rc.sc.VertexList[prim*3 : prim*3+3]
+			if t := intersectTriangle(v[0].Vec3(), v[1].Vec3(), v[2].Vec3(), origin, dir); t > 0 && t < maxDist {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Check whether a ray intersects the bounding box of a BVH node within maxDist.
+func intersectBox(node *scene.BvhNode, origin, dir types.Vec3, maxDist float32) bool {
+	tMin, tMax := float32(0), maxDist
+	for axis := 0; axis < 3; axis++ {
+		if dir[axis] == 0 {
+			if origin[axis] < node.Min[axis] || origin[axis] > node.Max[axis] {
+				return false
+			}
+			continue
+		}
+
+		invDir := 1 / dir[axis]
+		t0, t1 := (node.Min[axis]-origin[axis])*invDir, (node.Max[axis]-origin[axis])*invDir
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tMin = float32(math.Max(float64(tMin), float64(t0)))
+		tMax = float32(math.Min(float64(tMax), float64(t1)))
+		if tMin > tMax {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Intersect a ray with a triangle using the Moller-Trumbore algorithm. Returns
+// the distance to the intersection or a negative value if the ray misses the
+// triangle.
+func intersectTriangle(v0, v1, v2, origin, dir types.Vec3) float32 {
+	edge1, edge2 := v1.Sub(v0), v2.Sub(v0)
+	pVec := dir.Cross(edge2)
+	det := edge1.Dot(pVec)
+	if det > -1e-12 && det < 1e-12 {
+		return -1
+	}
+
+	invDet := 1 / det
+	tVec := origin.Sub(v0)
+	u := tVec.Dot(pVec) * invDet
+	if u < 0 || u > 1 {
+		return -1
+	}
+
+	qVec := tVec.Cross(edge1)
+	v := dir.Dot(qVec) * invDet
+	if v < 0 || u+v > 1 {
+		return -1
+	}
+
+	return edge2.Dot(qVec) * invDet
+}
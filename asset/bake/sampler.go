@@ -0,0 +1,53 @@
+package bake
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// A xorshift PRNG. Each texel uses its own sampler so that baked maps do not
+// depend on the order in which texels are processed.
+type sampler struct {
+	state uint32
+}
+
+// Create a sampler for a texel.
+func newSampler(seed int64, texelIndex int) *sampler {
+	// Scramble the seed and texel index using the wang hash
+	state := uint32(seed) ^ uint32(seed>>32) ^ uint32(texelIndex)*0x9e3779b9
+	state = (state ^ 61) ^ (state >> 16)
+	state *= 9
+	state ^= state >> 4
+	state *= 0x27d4eb2d
+	state ^= state >> 15
+	if state == 0 {
+		state = 1
+	}
+	return &sampler{state: state}
+}
+
+// Generate a random number in the [0, 1) range.
+func (s *sampler) next() float32 {
+	s.state ^= s.state << 13
+	s.state ^= s.state >> 17
+	s.state ^= s.state << 5
+	return float32(s.state>>8) / float32(1<<24)
+}
+
+// Generate a cosine-weighted direction in the hemisphere around a normal.
+func cosineSampleHemisphere(normal types.Vec3, u1, u2 float32) types.Vec3 {
+	// Build an orthonormal basis around the normal
+	tangent := types.Vec3{1, 0, 0}
+	if math.Abs(float64(normal[0])) > 0.9 {
+		tangent = types.Vec3{0, 1, 0}
+	}
+	tangent = normal.Cross(tangent).Normalize()
+	bitangent := normal.Cross(tangent)
+
+	r := float32(math.Sqrt(float64(u1)))
+	phi := 2 * math.Pi * float64(u2)
+	x, y := r*float32(math.Cos(phi)), r*float32(math.Sin(phi))
+	z := float32(math.Sqrt(math.Max(0, float64(1-u1))))
+	return tangent.Mul(x).Add(bitangent.Mul(y)).Add(normal.Mul(z))
+}
@@ -0,0 +1,238 @@
+package bake
+
+import (
+	"math"
+	"sort"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Defaults for lightmap uv generation.
+const (
+	defaultChartAngle   float32 = 66
+	defaultChartPadding         = 4
+
+	// Number of iterations for the search of the chart scale that fills
+	// the lightmap.
+	packIterations = 32
+)
+
+// A chart is a group of connected triangles with similar orientation that
+// are projected to the same plane.
+type chart struct {
+	tris []*triangle
+	uv   [][3]types.Vec2
+
+	// The chart extents in the projection plane.
+	w, h float32
+
+	// The chart placement in the lightmap (in texels) and the texel scale.
+	x, y  float32
+	scale float32
+}
+
+// Check whether the mesh used by a mesh instance defines texture coordinates
+// that can be used for baking maps.
+func HasUVs(sc *scene.Scene, instance int) (bool, error) {
+	tris, _, err := instanceTriangles(sc, instance)
+	if err != nil {
+		return false, err
+	}
+	return hasUVs(tris), nil
+}
+
+// Generate non-overlapping lightmap texture coordinates for the mesh used by a
+// mesh instance and replace the mesh uvs in the scene uv list. The mesh is
+// split into charts of connected triangles whose normals deviate less than
+// opts.ChartAngle degrees from the chart seed triangle. Each chart is projected
+// to the plane perpendicular to the seed normal and the charts are packed into
+// a map with the dims specified by opts keeping opts.ChartPadding texels
+// between them. As the scene uv list is shared by all instances of the mesh,
+// the generated uvs replace any existing texture coordinates for all of them.
+// The mesh tangents are aligned to the replaced uvs so they are reset; the
+// renderers then fall back to an arbitrary tangent frame for normal and bump
+// maps. Returns the number of generated charts.
+func GenerateLightmapUVs(sc *scene.Scene, instance int, opts Options) (int, error) {
+	tris, diag, err := instanceTriangles(sc, instance)
+	if err != nil {
+		return 0, err
+	}
+	opts.applyDefaults(diag)
+
+	charts := buildCharts(tris, diag*vertexWeldDistanceScale, opts.ChartAngle)
+	packCharts(charts, opts)
+
+	for _, c := range charts {
+		for index, tri := range c.tris {
+			for v := 0; v < 3; v++ {
+				uv := c.uv[index][v]
+				sc.UvList[tri.prim*3+uint32(v)] = types.Vec2{
+					(c.x + uv[0]*c.scale) / float32(opts.Width),
+					(c.y + uv[1]*c.scale) / float32(opts.Height),
+				}
+				if len(sc.TangentList) == len(sc.UvList) {
+					sc.TangentList[tri.prim*3+uint32(v)] = types.Vec4{}
+				}
+			}
+		}
+	}
+
+	return len(charts), nil
+}
+
+// Check whether any of the triangles covers a non-zero uv area.
+func hasUVs(tris []*triangle) bool {
+	for _, tri := range tris {
+		if edgeFunc(tri.uv[0], tri.uv[1], tri.uv[2]) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Split the triangles into charts by growing regions of triangles that share
+// an edge and whose normals deviate less than maxAngle degrees from the normal
+// of the region seed triangle.
+func buildCharts(tris []*triangle, weldDist, maxAngle float32) []*chart {
+	type edgeKey [2][3]int64
+	makeEdgeKey := func(a, b types.Vec3) edgeKey {
+		ka, kb := weldKey(a, weldDist), weldKey(b, weldDist)
+		if ka[0] > kb[0] || (ka[0] == kb[0] && (ka[1] > kb[1] || (ka[1] == kb[1] && ka[2] > kb[2]))) {
+			ka, kb = kb, ka
+		}
+		return edgeKey{ka, kb}
+	}
+
+	edgeTris := make(map[edgeKey][]int)
+	normals := make([]types.Vec3, len(tris))
+	for index, tri := range tris {
+		normals[index] = tri.pos[1].Sub(tri.pos[0]).Cross(tri.pos[2].Sub(tri.pos[0])).Normalize()
+		for v := 0; v < 3; v++ {
+			k := makeEdgeKey(tri.pos[v], tri.pos[(v+1)%3])
+			edgeTris[k] = append(edgeTris[k], index)
+		}
+	}
+
+	minCos := float32(math.Cos(float64(maxAngle) * math.Pi / 180))
+	assigned := make([]bool, len(tris))
+	var charts []*chart
+	for seed := range tris {
+		if assigned[seed] {
+			continue
+		}
+
+		axis := normals[seed]
+		c := &chart{}
+		assigned[seed] = true
+		for queue := []int{seed}; len(queue) > 0; {
+			index := queue[0]
+			queue = queue[1:]
+			c.tris = append(c.tris, tris[index])
+
+			tri := tris[index]
+			for v := 0; v < 3; v++ {
+				for _, neighbor := range edgeTris[makeEdgeKey(tri.pos[v], tri.pos[(v+1)%3])] {
+					if assigned[neighbor] || normals[neighbor].Dot(axis) < minCos {
+						continue
+					}
+					assigned[neighbor] = true
+					queue = append(queue, neighbor)
+				}
+			}
+		}
+
+		c.project(axis)
+		charts = append(charts, c)
+	}
+
+	return charts
+}
+
+// Project the chart triangles to the plane perpendicular to axis and move
+// the projected coordinates so that the chart bounding box starts at the
+// origin. Charts are rotated so that their longest side is horizontal.
+func (c *chart) project(axis types.Vec3) {
+	up := types.Vec3{0, 1, 0}
+	if math.Abs(float64(axis[1])) > 0.9 {
+		up = types.Vec3{1, 0, 0}
+	}
+	tangent := up.Cross(axis).Normalize()
+	bitangent := axis.Cross(tangent)
+
+	maxF := float32(math.MaxFloat32)
+	min, max := types.Vec2{maxF, maxF}, types.Vec2{-maxF, -maxF}
+	c.uv = make([][3]types.Vec2, len(c.tris))
+	for index, tri := range c.tris {
+		for v := 0; v < 3; v++ {
+			uv := types.Vec2{tri.pos[v].Dot(tangent), tri.pos[v].Dot(bitangent)}
+			min = types.Vec2{float32(math.Min(float64(min[0]), float64(uv[0]))), float32(math.Min(float64(min[1]), float64(uv[1])))}
+			max = types.Vec2{float32(math.Max(float64(max[0]), float64(uv[0]))), float32(math.Max(float64(max[1]), float64(uv[1])))}
+			c.uv[index][v] = uv
+		}
+	}
+
+	c.w, c.h = max[0]-min[0], max[1]-min[1]
+	rotate := c.h > c.w
+	if rotate {
+		c.w, c.h = c.h, c.w
+	}
+	for index := range c.uv {
+		for v := 0; v < 3; v++ {
+			uv := c.uv[index][v].Sub(min)
+			if rotate {
+				uv = types.Vec2{uv[1], c.h - uv[0]}
+			}
+			c.uv[index][v] = uv
+		}
+	}
+}
+
+// Pack the charts into a map with the dims specified by opts using a shelf
+// packer. The charts are scaled uniformly so that they fill as much of the map
+// as possible while keeping opts.ChartPadding texels between them.
+func packCharts(charts []*chart, opts Options) {
+	sort.SliceStable(charts, func(i, j int) bool { return charts[i].h > charts[j].h })
+
+	padding := float32(opts.ChartPadding)
+	width, height := float32(opts.Width), float32(opts.Height)
+
+	// Place the charts using the given scale and report whether they fit
+	place := func(scale float32) bool {
+		var x, y, shelfH float32
+		for _, c := range charts {
+			w, h := c.w*scale+padding, c.h*scale+padding
+			if x+w > width {
+				x, y, shelfH = 0, y+shelfH, 0
+			}
+			c.x, c.y, c.scale = x+padding/2, y+padding/2, scale
+			x += w
+			if h > shelfH {
+				shelfH = h
+			}
+		}
+		return x <= width && y+shelfH <= height
+	}
+
+	var maxDim float32
+	for _, c := range charts {
+		if c.w > maxDim {
+			maxDim = c.w
+		}
+	}
+	if maxDim == 0 {
+		place(0)
+		return
+	}
+
+	lo, hi := float32(0), float32(math.Min(float64(width), float64(height)))/maxDim
+	for iteration := 0; iteration < packIterations; iteration++ {
+		mid := (lo + hi) / 2
+		if place(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	place(lo)
+}
@@ -1,7 +1,11 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"strings"
 	"time"
 
@@ -16,9 +20,12 @@ import (
 )
 
 const (
-	minPrimitivesPerLeaf      = 10
-	SceneDiffuseMaterialName  = "scene_diffuse_material"
-	SceneEmissiveMaterialName = "scene_emissive_material"
+	minPrimitivesPerLeaf       = 10
+	SceneDiffuseMaterialName   = "scene_diffuse_material"
+	SceneEmissiveMaterialName  = "scene_emissive_material"
+	SceneBackplateMaterialName = "scene_backplate_material"
+	SceneDefaultMaterialName   = "scene_default_material"
+	SceneMediumMaterialName    = "scene_medium_material"
 )
 
 type sceneCompiler struct {
@@ -29,27 +36,131 @@ type sceneCompiler struct {
 	// A map of material indices to their layered material tree roots.
 	matIndexToMatRoot map[int]int32
 
-	// A map of a texture path to its index. This cache allows us to
-	// re-use already loaded textures when referenced by multiple materials.
-	texIndexCache map[string]int32
+	// A map of a texture path and color space to its index. This cache
+	// allows us to re-use already loaded textures when referenced by
+	// multiple materials.
+	texIndexCache map[texturePathKey]int32
+
+	// A map of loaded textures to their index. Textures with identical
+	// contents are shared by the texture cache and get the same index
+	// as long as they are used with the same color space.
+	texIndexByData map[textureRef]int32
+
+	// The texture cache and the list of loaded textures. Texture data is
+	// packed into the optimized scene after all materials are processed.
+	texCache *texture.Cache
+	textures []textureRef
+
+	// The color space for textures that do not specify one; see Options.
+	textureColorSpace texture.ColorSpace
+
+	// A map of a volume path and grid name to the packed volume grid. The
+	// cached grids do not define a scale.
+	volumeGridCache map[volumePathKey]scene.VolumeGrid
 
 	// A map of material indices to an emissive layered material tree node.
 	emissiveIndexCache map[int]int32
 
 	// A list of material references for detecting circular loops.
 	matRefList []string
+
+	// Collects progress and non-fatal compilation issues.
+	report *Report
+
+	// Settings for handling shading normals; see Options.
+	shadingNormalFix      ShadingNormalFix
+	maxShadingNormalAngle float32
+
+	// The roughness convention for materials that do not specify one; see Options.
+	roughnessConvention material.RoughnessConvention
+
+	// The policy for primitives without a material and the root node of
+	// the scene default material (or -1 if not defined); see Options.
+	missingMaterialPolicy MissingMaterialPolicy
+	sceneDefaultMatRoot   int32
+}
+
+// A loaded texture, the color space of its texel values and the roughness
+// convention that its values are remapped from. The roughness convention is
+// only set for roughness textures that need to be remapped.
+type textureRef struct {
+	tex        *texture.Texture
+	colorSpace texture.ColorSpace
+	roughness  material.RoughnessConvention
+}
+
+type texturePathKey struct {
+	path       string
+	colorSpace texture.ColorSpace
+	roughness  material.RoughnessConvention
+}
+
+// Options for customizing the scene compiler.
+type Options struct {
+	// Collects progress updates and non-fatal issues (e.g. missing
+	// textures or invalid material expressions); may be nil.
+	Report *Report
+
+	// The max number of bytes that can be used by texture data. Textures
+	// are downscaled until they fit the budget. A zero value disables
+	// the budget.
+	TextureBudget int
+
+	// Controls how shading normals that deviate more than MaxShadingNormalAngle
+	// degrees from the geometric normal of their primitive are handled. By
+	// default, the affected primitives are reported as warnings.
+	ShadingNormalFix ShadingNormalFix
+
+	// The max angle in degrees between a shading normal and the geometric
+	// normal. If zero, DefaultMaxShadingNormalAngle is used.
+	MaxShadingNormalAngle float32
+
+	// The color space for textures whose color space is not specified by
+	// their material. By default, 8-bit textures that define colors (e.g.
+	// reflectance maps) are treated as sRGB while textures that define
+	// surface data (e.g. normal, bump and roughness maps) and float
+	// textures are treated as linear.
+	TextureColorSpace texture.ColorSpace
+
+	// The convention used by roughness values and textures of materials
+	// that do not specify one. Roughness is remapped to the GGX alpha
+	// value expected by the renderer when the scene is compiled. By
+	// default, roughness values are treated as alpha values.
+	RoughnessConvention material.RoughnessConvention
+
+	// Controls how primitives without a material are handled. By default,
+	// they are assigned the scene default material.
+	MissingMaterialPolicy MissingMaterialPolicy
 }
 
 // Compile a scene representation parsed by a scene reader into a GPU-friendly
 // optimized scene format.
 func Compile(parsedScene *input.Scene) (*scene.Scene, error) {
+	return CompileWithOptions(parsedScene, Options{})
+}
+
+// Compile a scene representation parsed by a scene reader into a GPU-friendly
+// optimized scene format using the supplied options.
+func CompileWithOptions(parsedScene *input.Scene, opts Options) (*scene.Scene, error) {
 	compiler := &sceneCompiler{
 		parsedScene: parsedScene,
 		optimizedScene: &scene.Scene{
-			SceneDiffuseMatIndex:  -1,
-			SceneEmissiveMatIndex: -1,
+			LayoutVersion:          scene.LayoutVersion,
+			SceneDiffuseMatIndex:   -1,
+			SceneEmissiveMatIndex:  -1,
+			SceneBackplateMatIndex: -1,
+			SceneMediumMatIndex:    -1,
 		},
-		logger: log.New("scene compiler"),
+		logger:   log.New("scene compiler"),
+		report:   opts.Report,
+		texCache: texture.NewCache(opts.TextureBudget),
+		//
+		shadingNormalFix:      opts.ShadingNormalFix,
+		maxShadingNormalAngle: opts.MaxShadingNormalAngle,
+		textureColorSpace:     opts.TextureColorSpace,
+		roughnessConvention:   opts.RoughnessConvention,
+		missingMaterialPolicy: opts.MissingMaterialPolicy,
+		sceneDefaultMatRoot:   -1,
 	}
 
 	start := time.Now()
@@ -61,6 +172,16 @@ func Compile(parsedScene *input.Scene) (*scene.Scene, error) {
 		return nil, err
 	}
 
+	err = compiler.resolveMissingMaterials()
+	if err != nil {
+		return nil, err
+	}
+
+	err = compiler.packTextures()
+	if err != nil {
+		return nil, err
+	}
+
 	err = compiler.partitionGeometry()
 	if err != nil {
 		return nil, err
@@ -100,8 +221,8 @@ func (sc *sceneCompiler) partitionGeometry() error {
 		}
 	}, bvh.SurfaceAreaHeuristic)
 
-	// Scan all meshes and calculate the size of material, vertex, normal
-	// and uv lists; then pre-allocate them.
+	// Scan all meshes and calculate the size of material, vertex, normal,
+	// tangent and uv lists; then pre-allocate them.
 	totalVertices := 0
 	for _, pm := range sc.parsedScene.Meshes {
 		totalVertices += 3 * len(pm.Primitives)
@@ -109,6 +230,7 @@ func (sc *sceneCompiler) partitionGeometry() error {
 
 	sc.optimizedScene.VertexList = make([]types.Vec4, totalVertices)
 	sc.optimizedScene.NormalList = make([]types.Vec4, totalVertices)
+	sc.optimizedScene.TangentList = make([]types.Vec4, totalVertices)
 	sc.optimizedScene.UvList = make([]types.Vec2, totalVertices)
 	sc.optimizedScene.MaterialIndex = make([]uint32, totalVertices/3)
 
@@ -116,14 +238,25 @@ func (sc *sceneCompiler) partitionGeometry() error {
 	var vertexOffset uint32 = 0
 	var primOffset uint32 = 0
 	meshBvhRoots := make([]uint32, len(sc.parsedScene.Meshes))
+	meshHasAlphaCutout := make([]bool, len(sc.parsedScene.Meshes))
 	meshEmissivePrimitives := make([]*scene.EmissivePrimitive, 0)
 	emissiveIndexToMeshIndexMap := make(map[int]uint32, 0)
 	for mIndex, pm := range sc.parsedScene.Meshes {
+		err := sc.checkShadingNormals(pm)
+		if err != nil {
+			return err
+		}
+
+		if count := generateTangents(pm); count != 0 {
+			sc.logger.Debugf(`generated tangents for %d/%d primitives in mesh "%s"`, count, len(pm.Primitives), pm.Name)
+		}
+
 		volList := make([]bvh.BoundedVolume, len(pm.Primitives))
 		for index, prim := range pm.Primitives {
 			volList[index] = prim
 		}
 
+		sc.report.Progress(SectionGeometry, mIndex, len(sc.parsedScene.Meshes))
 		sc.logger.Infof(`building BVH tree for "%s" (%d primitives)`, pm.Name, len(pm.Primitives))
 		bvhNodes := bvh.Build(volList, minPrimitivesPerLeaf, func(node *scene.BvhNode, workList []bvh.BoundedVolume) {
 			node.SetPrimitives(primOffset, uint32(len(workList)))
@@ -141,6 +274,10 @@ func (sc *sceneCompiler) partitionGeometry() error {
 				sc.optimizedScene.NormalList[vertexOffset+1] = prim.Normals[1].Vec4(0)
 				sc.optimizedScene.NormalList[vertexOffset+2] = prim.Normals[2].Vec4(0)
 
+				sc.optimizedScene.TangentList[vertexOffset+0] = prim.Tangents[0]
+				sc.optimizedScene.TangentList[vertexOffset+1] = prim.Tangents[1]
+				sc.optimizedScene.TangentList[vertexOffset+2] = prim.Tangents[2]
+
 				sc.optimizedScene.UvList[vertexOffset+0] = prim.UVs[0]
 				sc.optimizedScene.UvList[vertexOffset+1] = prim.UVs[1]
 				sc.optimizedScene.UvList[vertexOffset+2] = prim.UVs[2]
@@ -148,6 +285,9 @@ func (sc *sceneCompiler) partitionGeometry() error {
 				// Lookup root material node for primitive material index
 				matNodeIndex := sc.matIndexToMatRoot[prim.MaterialIndex]
 				sc.optimizedScene.MaterialIndex[primOffset] = uint32(matNodeIndex)
+				if sc.isAlphaCutout(matNodeIndex) {
+					meshHasAlphaCutout[mIndex] = true
+				}
 
 				// Check if this an emissive primitive and keep track of it
 				// Since we may use multiple instances of this mesh we need a
@@ -178,6 +318,7 @@ func (sc *sceneCompiler) partitionGeometry() error {
 		sc.optimizedScene.BvhNodeList = append(sc.optimizedScene.BvhNodeList, bvhNodes...)
 	}
 
+	sc.report.Progress(SectionGeometry, len(sc.parsedScene.Meshes), len(sc.parsedScene.Meshes))
 	sc.logger.Infof("processing %d mesh instances", len(sc.parsedScene.MeshInstances))
 
 	// Process each mesh instance
@@ -186,6 +327,19 @@ func (sc *sceneCompiler) partitionGeometry() error {
 		mi := &sc.optimizedScene.MeshInstanceList[index]
 		mi.MeshIndex = pmi.MeshIndex
 		mi.BvhRoot = meshBvhRoots[pmi.MeshIndex]
+		mi.RayBias = sc.parsedScene.Meshes[pmi.MeshIndex].RayBias
+		if sc.parsedScene.Meshes[pmi.MeshIndex].CameraInvisible {
+			mi.Flags |= scene.CameraInvisible
+		}
+		if sc.parsedScene.Meshes[pmi.MeshIndex].ShadowCatcher {
+			mi.Flags |= scene.ShadowCatcher
+			if sc.parsedScene.Meshes[pmi.MeshIndex].CatcherReflections {
+				mi.Flags |= scene.CatcherReflections
+			}
+		}
+		if meshHasAlphaCutout[pmi.MeshIndex] {
+			mi.Flags |= scene.AlphaCutout
+		}
 
 		// We need to invert the transformation matrix when performing ray traversal
 		mi.Transform = pmi.Transform.Inv()
@@ -230,12 +384,63 @@ func (sc *sceneCompiler) partitionGeometry() error {
 	return nil
 }
 
-// Initialize and position the camera for the scene.
+// Initialize and position the scene cameras.
 func (sc *sceneCompiler) setupCamera() error {
-	sc.optimizedScene.Camera = scene.NewCamera(sc.parsedScene.Camera.FOV)
-	sc.optimizedScene.Camera.Position = sc.parsedScene.Camera.Eye
-	sc.optimizedScene.Camera.LookAt = sc.parsedScene.Camera.Look
-	sc.optimizedScene.Camera.Up = sc.parsedScene.Camera.Up
+	sc.optimizedScene.Cameras = make([]*scene.Camera, 0, len(sc.parsedScene.Cameras))
+	for _, parsedCam := range sc.parsedScene.Cameras {
+		cam := scene.NewCamera(parsedCam.FOV)
+		cam.Name = parsedCam.Name
+		cam.Position = parsedCam.Eye
+		cam.LookAt = parsedCam.Look
+		cam.Up = parsedCam.Up
+		cam.Overscan = parsedCam.Overscan
+		cam.PixelAspect = parsedCam.PixelAspect
+		cam.ApertureRadius = parsedCam.ApertureRadius
+		cam.FocusDistance = parsedCam.FocusDistance
+		cam.ApertureBlades = parsedCam.ApertureBlades
+		cam.ApertureRotation = parsedCam.ApertureRotation
+		cam.ShiftX, cam.ShiftY = parsedCam.ShiftX, parsedCam.ShiftY
+		cam.TiltX, cam.TiltY = parsedCam.TiltX, parsedCam.TiltY
+
+		if parsedCam.BokehMask != "" {
+			err := sc.loadBokehMask(parsedCam, cam)
+			if err != nil {
+				return err
+			}
+		}
+
+		sc.optimizedScene.Cameras = append(sc.optimizedScene.Cameras, cam)
+		if parsedCam == sc.parsedScene.Camera {
+			sc.optimizedScene.Camera = cam
+		}
+	}
+
+	if sc.optimizedScene.Camera == nil {
+		return errors.New("active camera is not part of the scene camera list")
+	}
+
+	return nil
+}
+
+// Load the bokeh mask for a camera and generate the lens samples for it.
+// Missing or unreadable masks are reported as warnings and the camera falls
+// back to the aperture blade settings.
+func (sc *sceneCompiler) loadBokehMask(parsedCam *input.Camera, cam *scene.Camera) error {
+	res, err := asset.NewResource(parsedCam.BokehMask, parsedCam.AssetRelPath)
+	if err != nil {
+		return sc.warn(SectionCamera, "camera %q: skipping missing bokeh mask %q", parsedCam.Name, parsedCam.BokehMask)
+	}
+	defer res.Close()
+
+	im, _, err := image.Decode(res)
+	if err != nil {
+		return sc.warn(SectionCamera, "camera %q: skipping unreadable bokeh mask %q: %v", parsedCam.Name, parsedCam.BokehMask, err)
+	}
+
+	cam.BokehSamples, cam.BokehJitter, err = scene.BokehSamplesFromImage(im, scene.DefaultBokehSamples)
+	if err != nil {
+		return sc.warn(SectionCamera, "camera %q: skipping bokeh mask %q: %v", parsedCam.Name, parsedCam.BokehMask, err)
+	}
 
 	return nil
 }
@@ -253,27 +458,73 @@ func (sc *sceneCompiler) findMaterialNodeByBxdf(nodeIndex uint32, bxdf material.
 		return -1
 	}
 
-	// This is a op node. Scan left arg first
+	// This is a op node. Scan left arg first; media without a surface
+	// expression do not have one.
+	if node.Union1[1] < 0 {
+		return -1
+	}
 	out := sc.findMaterialNodeByBxdf(uint32(node.Union1[1]), bxdf)
 	if out != -1 {
 		return out
 	}
 
 	// If this is a mix node descend into the right child
-	if nodeType == uint32(material.OpMix) {
+	if nodeType == uint32(material.OpMix) || nodeType == uint32(material.OpMixCurvature) || nodeType == uint32(material.OpMixOcclusion) {
 		out = sc.findMaterialNodeByBxdf(uint32(node.Union1[2]), bxdf)
 	}
 
 	return out
 }
 
+// Check whether a material tree root node defines an alpha cutout.
+func (sc *sceneCompiler) isAlphaCutout(nodeIndex int32) bool {
+	return nodeIndex >= 0 && sc.optimizedScene.MaterialNodeList[nodeIndex].Union1[0] == int32(material.OpAlphaCutout)
+}
+
+// Check whether a material tree root node defines a participating medium.
+func (sc *sceneCompiler) isMedium(nodeIndex int32) bool {
+	return nodeIndex >= 0 && sc.optimizedScene.MaterialNodeList[nodeIndex].Union1[0] == int32(material.OpMedium)
+}
+
+// Wrap the tree of a material that uses a subsurface bxdf in a medium node
+// whose coefficients are derived from the bxdf parameters and return back the
+// index of the new root. If the tree uses multiple subsurface bxdfs, the first
+// one defines the medium. Materials that define their own medium keep it.
+func (sc *sceneCompiler) attachSubsurfaceMedium(mat *input.Material, root int32) (int32, error) {
+	if sc.isMedium(root) {
+		return root, nil
+	}
+	subsurface := sc.findMaterialNodeByBxdf(uint32(root), material.BxdfSubsurface)
+	if subsurface == -1 {
+		return root, nil
+	}
+	if sc.isAlphaCutout(root) {
+		return root, sc.warn(SectionMaterials, "material %q: subsurface scattering is not supported by alpha cutout materials; ignoring the medium below the surface", mat.Name)
+	}
+
+	bxdfNode := sc.optimizedScene.MaterialNodeList[subsurface]
+	absorption, scattering := material.SubsurfaceCoefficients(bxdfNode.Union2.Vec3(), bxdfNode.Union3.Vec3())
+	node := scene.MaterialNode{
+		Union1: [4]int32{int32(material.OpMedium), root, -1, -1},
+		Union2: absorption.Vec4(0),
+		Union3: scattering.Vec4(0),
+		Union4: types.Vec3{material.DefaultIntIOR, material.DefaultExtIOR, bxdfNode.Union3[3]},
+		Union5: [1]int32{-1},
+	}
+
+	sc.optimizedScene.MaterialNodeList = append(sc.optimizedScene.MaterialNodeList, node)
+	return int32(len(sc.optimizedScene.MaterialNodeList) - 1), nil
+}
+
 // Parse material definitions into a node-based structure that models a layered material.
 func (sc *sceneCompiler) createLayeredMaterialTrees() error {
 	start := time.Now()
 	sc.logger.Noticef("processing %d materials", len(sc.parsedScene.Materials))
 
 	sc.matIndexToMatRoot = make(map[int]int32, 0)
-	sc.texIndexCache = make(map[string]int32, 0)
+	sc.texIndexCache = make(map[texturePathKey]int32, 0)
+	sc.texIndexByData = make(map[textureRef]int32, 0)
+	sc.volumeGridCache = make(map[volumePathKey]scene.VolumeGrid, 0)
 	sc.emissiveIndexCache = make(map[int]int32, 0)
 	sc.optimizedScene.MaterialNodeList = make([]scene.MaterialNode, 0)
 	sc.optimizedScene.TextureData = make([]byte, 0)
@@ -281,6 +532,8 @@ func (sc *sceneCompiler) createLayeredMaterialTrees() error {
 
 	var err error
 	for matIndex, mat := range sc.parsedScene.Materials {
+		sc.report.Progress(SectionMaterials, matIndex, len(sc.parsedScene.Materials))
+
 		// Skip unused materials; those materials may be indirectly
 		// referenced from other used materials and will be lazilly
 		// processed while compiling material expressions
@@ -292,8 +545,18 @@ func (sc *sceneCompiler) createLayeredMaterialTrees() error {
 
 		sc.matRefList = make([]string, 0)
 		sc.matIndexToMatRoot[matIndex], err = sc.generateMaterial(mat)
+		if err == nil {
+			sc.matIndexToMatRoot[matIndex], err = sc.attachSubsurfaceMedium(mat, sc.matIndexToMatRoot[matIndex])
+		}
+		if err == nil && mat.Name != SceneMediumMaterialName && sc.isMedium(sc.matIndexToMatRoot[matIndex]) && sc.optimizedScene.MaterialNodeList[sc.matIndexToMatRoot[matIndex]].Union1[1] == -1 {
+			err = fmt.Errorf("material %q: media without a surface expression can only be used by the %q material", mat.Name, SceneMediumMaterialName)
+		}
 		if err != nil {
-			return err
+			// Replace broken materials with a default diffuse material
+			if err = sc.warn(SectionMaterials, "%v; using default material", err); err != nil {
+				return err
+			}
+			sc.matIndexToMatRoot[matIndex] = sc.generateDefaultMaterial()
 		}
 
 		sc.emissiveIndexCache[matIndex] = sc.findMaterialNodeByBxdf(uint32(sc.matIndexToMatRoot[matIndex]), material.BxdfEmissive)
@@ -302,13 +565,53 @@ func (sc *sceneCompiler) createLayeredMaterialTrees() error {
 			sc.optimizedScene.SceneDiffuseMatIndex = sc.matIndexToMatRoot[matIndex]
 		} else if mat.Name == SceneEmissiveMaterialName {
 			sc.optimizedScene.SceneEmissiveMatIndex = sc.matIndexToMatRoot[matIndex]
+		} else if mat.Name == SceneBackplateMaterialName {
+			sc.optimizedScene.SceneBackplateMatIndex = sc.matIndexToMatRoot[matIndex]
+		} else if mat.Name == SceneDefaultMaterialName {
+			sc.sceneDefaultMatRoot = sc.matIndexToMatRoot[matIndex]
+		} else if mat.Name == SceneMediumMaterialName {
+			if !sc.isMedium(sc.matIndexToMatRoot[matIndex]) {
+				if err = sc.warn(SectionMaterials, "material %q does not define a medium; ignoring it", mat.Name); err != nil {
+					return err
+				}
+				continue
+			}
+			sc.optimizedScene.SceneMediumMatIndex = sc.matIndexToMatRoot[matIndex]
 		}
 	}
 
+	sc.report.Progress(SectionMaterials, len(sc.parsedScene.Materials), len(sc.parsedScene.Materials))
 	sc.logger.Noticef("processed %d materials in %d ms", len(sc.parsedScene.Materials), time.Since(start).Nanoseconds()/1e6)
 	return nil
 }
 
+// Append a diffuse material node with the default reflectance and return
+// back its index.
+func (sc *sceneCompiler) generateDefaultMaterial() int32 {
+	return sc.generateDiffuseMaterial(material.DefaultReflectance)
+}
+
+// Append a diffuse material node with the given reflectance and return back
+// its index.
+func (sc *sceneCompiler) generateDiffuseMaterial(reflectance types.Vec4) int32 {
+	node := scene.MaterialNode{
+		Union1: [4]int32{int32(material.BxdfDiffuse), -1, -1, -1},
+		Union2: reflectance,
+		Union4: types.Vec3{material.DefaultIntIOR, material.DefaultExtIOR, 0.0},
+		Union5: [1]int32{-1},
+	}
+
+	sc.optimizedScene.MaterialNodeList = append(sc.optimizedScene.MaterialNodeList, node)
+	return int32(len(sc.optimizedScene.MaterialNodeList) - 1)
+}
+
+// Log a non-fatal issue and record it to the compiler report. Returns an
+// error if the report operates in strict mode.
+func (sc *sceneCompiler) warn(section, msgFormat string, args ...interface{}) error {
+	sc.logger.Warningf(msgFormat, args...)
+	return sc.report.Warn(section, msgFormat, args...)
+}
+
 // Compile material expression and generate a layered material tree from it. This
 // method returns back the root material tree node index.
 func (sc *sceneCompiler) generateMaterial(mat *input.Material) (int32, error) {
@@ -349,10 +652,31 @@ func (sc *sceneCompiler) generateMaterialTree(mat *input.Material, exprNode mate
 			}
 		}
 
-		for _, mat := range sc.parsedScene.Materials {
-			if mat.Name == matRefName {
-				return sc.generateMaterial(mat)
+		for _, refMat := range sc.parsedScene.Materials {
+			if refMat.Name != matRefName {
+				continue
 			}
+
+			refRoot, err := sc.generateMaterial(refMat)
+			if err != nil || (!sc.isAlphaCutout(refRoot) && !sc.isMedium(refRoot)) {
+				return refRoot, err
+			}
+
+			// Material references are always nested inside another
+			// expression whereas alpha cutouts and media must be
+			// defined at the root of the material tree.
+			opName := "alpha cutout"
+			if sc.isMedium(refRoot) {
+				opName = "medium"
+			}
+			surfaceRoot := sc.optimizedScene.MaterialNodeList[refRoot].Union1[1]
+			if surfaceRoot == -1 {
+				return -1, fmt.Errorf("material %q references medium %q which does not define a surface expression", mat.Name, matRefName)
+			}
+			if err = sc.warn(SectionMaterials, "material %q: ignoring %s of referenced material %q", mat.Name, opName, matRefName); err != nil {
+				return -1, err
+			}
+			return surfaceRoot, nil
 		}
 
 		return -1, fmt.Errorf("material %q references undefined material %q", mat.Name, matRefName)
@@ -384,6 +708,20 @@ func (sc *sceneCompiler) generateMaterialTree(mat *input.Material, exprNode mate
 			// Default radiance and scaler
 			node.Union2 = material.DefaultRadiance
 			node.Union4[2] = material.DefaultRadianceScaler
+		case material.BxdfPrincipled:
+			// Default base color, specular and roughness; the remaining
+			// lobe weights (metallic, sheen, clearcoat, transmission)
+			// default to 0
+			node.Union2 = material.DefaultBaseColor
+			node.Union3[1] = material.DefaultSpecular
+			node.Union4[2] = material.DefaultRoughness
+		case material.BxdfSubsurface:
+			// Default albedo, mean free path and roughness; the phase
+			// function anisotropy is stored in Union3[3] and
+			// defaults to 0
+			node.Union2 = material.DefaultSubsurfaceAlbedo
+			node.Union3 = material.DefaultSubsurfaceRadius
+			node.Union4[2] = material.DefaultRoughness
 		}
 
 		// Apply parameters
@@ -416,10 +754,34 @@ func (sc *sceneCompiler) generateMaterialTree(mat *input.Material, exprNode mate
 			return -1, err
 		}
 
-		node.Union1[3], err = sc.bakeTexture(mat, t.Texture)
+		node.Union1[3], err = sc.bakeTexture(mat, t.Texture, texture.ColorSpaceLinear)
+		if err != nil {
+			return -1, err
+		}
+	case material.MixCurvatureNode:
+		node.Union1[0] = int32(material.OpMixCurvature)
+		node.Union1[1], err = sc.generateMaterialTree(mat, t.Expressions[0])
+		if err != nil {
+			return -1, err
+		}
+		node.Union1[2], err = sc.generateMaterialTree(mat, t.Expressions[1])
+		if err != nil {
+			return -1, err
+		}
+
+		node.Union2[0] = t.Scale
+	case material.MixOcclusionNode:
+		node.Union1[0] = int32(material.OpMixOcclusion)
+		node.Union1[1], err = sc.generateMaterialTree(mat, t.Expressions[0])
+		if err != nil {
+			return -1, err
+		}
+		node.Union1[2], err = sc.generateMaterialTree(mat, t.Expressions[1])
 		if err != nil {
 			return -1, err
 		}
+
+		node.Union2[0] = t.Radius
 	case material.BumpMapNode:
 		node.Union1[0] = int32(material.OpBumpMap)
 		node.Union1[1], err = sc.generateMaterialTree(mat, t.Expression)
@@ -427,7 +789,7 @@ func (sc *sceneCompiler) generateMaterialTree(mat *input.Material, exprNode mate
 			return -1, err
 		}
 
-		node.Union1[3], err = sc.bakeTexture(mat, t.Texture)
+		node.Union1[3], err = sc.bakeTexture(mat, t.Texture, texture.ColorSpaceLinear)
 		if err != nil {
 			return -1, err
 		}
@@ -438,10 +800,69 @@ func (sc *sceneCompiler) generateMaterialTree(mat *input.Material, exprNode mate
 			return -1, err
 		}
 
-		node.Union1[3], err = sc.bakeTexture(mat, t.Texture)
+		node.Union1[3], err = sc.bakeTexture(mat, t.Texture, texture.ColorSpaceLinear)
+		if err != nil {
+			return -1, err
+		}
+	case material.AlphaCutoutNode:
+		node.Union1[0] = int32(material.OpAlphaCutout)
+		node.Union1[1], err = sc.generateMaterialTree(mat, t.Expression)
+		if err != nil {
+			return -1, err
+		}
+
+		node.Union1[3], err = sc.bakeTexture(mat, t.Texture, texture.ColorSpaceLinear)
 		if err != nil {
 			return -1, err
 		}
+
+		// Skip the cutout if its opacity texture could not be loaded
+		if node.Union1[3] == -1 {
+			return node.Union1[1], nil
+		}
+		node.Union2[0] = t.Cutoff
+	case material.MediumNode:
+		node.Union1[0] = int32(material.OpMedium)
+		if t.Expression != nil {
+			node.Union1[1], err = sc.generateMaterialTree(mat, t.Expression)
+			if err != nil {
+				return -1, err
+			}
+		}
+
+		node.Union2, node.Union3 = types.Vec4{}, types.Vec4{}
+		node.Union4[2] = material.DefaultAnisotropy
+		var density, emission material.VolumeNode
+		radiance := types.Vec3{1, 1, 1}
+		for _, param := range t.Parameters {
+			switch param.Name {
+			case material.ParamAbsorption:
+				node.Union2 = types.Vec3(param.Value.(material.Vec3Node)).Vec4(0)
+			case material.ParamScattering:
+				node.Union3 = types.Vec3(param.Value.(material.Vec3Node)).Vec4(0)
+			case material.ParamAnisotropy:
+				node.Union4[2] = float32(param.Value.(material.FloatNode))
+			case material.ParamDensity:
+				density = param.Value.(material.VolumeNode)
+			case material.ParamEmission:
+				emission = param.Value.(material.VolumeNode)
+			case material.ParamRadiance:
+				radiance = types.Vec3(param.Value.(material.Vec3Node))
+			}
+		}
+
+		if density != "" {
+			node.Union1[2], err = sc.loadVolumeGrid(mat, density, types.Vec3{1, 1, 1})
+			if err != nil {
+				return -1, err
+			}
+		}
+		if emission != "" {
+			node.Union1[3], err = sc.loadVolumeGrid(mat, emission, radiance)
+			if err != nil {
+				return -1, err
+			}
+		}
 	case material.DisperseNode:
 		node.Union1[0] = int32(material.OpDisperse)
 		node.Union1[1], err = sc.generateMaterialTree(mat, t.Expression)
@@ -467,14 +888,42 @@ func (sc *sceneCompiler) setMaterialNodeParameter(mat *input.Material, node *sce
 		case material.Vec3Node:
 			node.Union2 = types.Vec3(t).Vec4(0.0)
 		case material.TextureNode:
-			node.Union1[3], err = sc.bakeTexture(mat, t)
+			node.Union1[3], err = sc.bakeTexture(mat, t, texture.ColorSpaceSRGB)
+		}
+	case material.ParamBaseColor:
+		// The base color shares Union2 with the transmission weight
+		switch t := param.Value.(type) {
+		case material.Vec3Node:
+			node.Union2 = types.Vec3(t).Vec4(node.Union2[3])
+		case material.TextureNode:
+			node.Union1[3], err = sc.bakeTexture(mat, t, texture.ColorSpaceSRGB)
+		}
+	case material.ParamMetallic:
+		switch t := param.Value.(type) {
+		case material.FloatNode:
+			node.Union3[0] = float32(t)
+		case material.TextureNode:
+			node.Union1[2], err = sc.bakeTexture(mat, t, texture.ColorSpaceLinear)
 		}
+	case material.ParamSpecular:
+		node.Union3[1] = float32(param.Value.(material.FloatNode))
+	case material.ParamSheen:
+		node.Union3[2] = float32(param.Value.(material.FloatNode))
+	case material.ParamClearcoat:
+		node.Union3[3] = float32(param.Value.(material.FloatNode))
+	case material.ParamTransmission:
+		node.Union2[3] = float32(param.Value.(material.FloatNode))
+	case material.ParamRadius:
+		// The radius shares Union3 with the subsurface anisotropy
+		node.Union3 = types.Vec3(param.Value.(material.Vec3Node)).Vec4(node.Union3[3])
+	case material.ParamAnisotropy:
+		node.Union3[3] = float32(param.Value.(material.FloatNode))
 	case material.ParamTransmittance:
 		switch t := param.Value.(type) {
 		case material.Vec3Node:
 			node.Union3 = types.Vec3(t).Vec4(0.0)
 		case material.TextureNode:
-			node.Union1[2], err = sc.bakeTexture(mat, t)
+			node.Union1[2], err = sc.bakeTexture(mat, t, texture.ColorSpaceSRGB)
 		}
 	case material.ParamIntIOR, material.ParamExtIOR:
 		index := 0
@@ -490,74 +939,152 @@ func (sc *sceneCompiler) setMaterialNodeParameter(mat *input.Material, node *sce
 		}
 	case material.ParamScale:
 		node.Union4[2] = float32(param.Value.(material.FloatNode))
+	case material.ParamTemperature:
+		node.Union2 = material.Blackbody(float32(param.Value.(material.FloatNode))).Vec4(0.0)
 	case material.ParamRoughness:
+		conv := mat.RoughnessConvention
+		if conv == material.RoughnessAuto {
+			conv = sc.roughnessConvention
+		}
+
 		switch t := param.Value.(type) {
 		case material.FloatNode:
-			node.Union4[2] = float32(t)
+			node.Union4[2] = conv.ToAlpha(float32(t))
 		case material.TextureNode:
-			node.Union5[0], err = sc.bakeTexture(mat, t)
+			node.Union5[0], err = sc.bakeRoughnessTexture(mat, t, conv)
 		}
 	}
 
 	return err
 }
 
-// Load a texture resource and store its metadata/data into the optimized scene.
-// Texture data is always aligned on a dword boundary.
-func (sc *sceneCompiler) bakeTexture(mat *input.Material, texNode material.TextureNode) (int32, error) {
+// Load a texture resource via the texture cache and return back its index.
+// The usageColorSpace argument specifies the color space implied by the way
+// that the texture is used by the material; it is overridden by the color
+// space specified by the material or the compiler options. The texture data
+// is packed into the optimized scene by packTextures.
+func (sc *sceneCompiler) bakeTexture(mat *input.Material, texNode material.TextureNode, usageColorSpace texture.ColorSpace) (int32, error) {
+	return sc.loadTexture(mat, texNode, usageColorSpace, material.RoughnessAlpha)
+}
+
+// Load a roughness texture whose values use the given convention. Textures
+// that do not store alpha values are remapped when they are packed.
+func (sc *sceneCompiler) bakeRoughnessTexture(mat *input.Material, texNode material.TextureNode, conv material.RoughnessConvention) (int32, error) {
+	return sc.loadTexture(mat, texNode, texture.ColorSpaceLinear, conv)
+}
+
+// Implements bakeTexture and bakeRoughnessTexture.
+func (sc *sceneCompiler) loadTexture(mat *input.Material, texNode material.TextureNode, usageColorSpace texture.ColorSpace, roughness material.RoughnessConvention) (int32, error) {
 	texPath := string(texNode)
 	res, err := asset.NewResource(texPath, mat.AssetRelPath)
 	if err != nil {
-		sc.logger.Warningf("%q: skipping missing texture %q", mat.Name, texPath)
-		return -1, nil
+		return -1, sc.warn(SectionMaterials, "%q: skipping missing texture %q", mat.Name, texPath)
+	}
+	defer res.Close()
+
+	colorSpace := usageColorSpace
+	if cs := mat.TextureColorSpaces[texPath]; cs != texture.ColorSpaceAuto {
+		colorSpace = cs
+	} else if sc.textureColorSpace != texture.ColorSpaceAuto {
+		colorSpace = sc.textureColorSpace
+	}
+
+	if !roughness.NeedsRemap() {
+		roughness = material.RoughnessAlpha
 	}
 
 	// Check if texture is already loaded
-	if texIndex, exists := sc.texIndexCache[res.Path()]; exists {
+	pathKey := texturePathKey{res.Path(), colorSpace, roughness}
+	if texIndex, exists := sc.texIndexCache[pathKey]; exists {
 		sc.logger.Infof("%q: re-using already loaded texture %q", mat.Name, texPath)
 		return texIndex, nil
 	}
 
 	sc.logger.Infof("%q: processing texture %q", mat.Name, texPath)
 
-	tex, err := texture.New(res)
+	tex, err := sc.texCache.Load(res)
 	if err != nil {
-		return -1, fmt.Errorf("%q: %v", mat.Name, err)
+		return -1, sc.warn(SectionMaterials, "%q: skipping unreadable texture %q: %v", mat.Name, texPath, err)
 	}
 
-	dataOffset := len(sc.optimizedScene.TextureData)
-	realLen := len(tex.Data)
-	alignedLen := align4(realLen)
-
-	// Copy data and add alignment padding
-	sc.optimizedScene.TextureData = append(sc.optimizedScene.TextureData, tex.Data...)
-	if alignedLen > realLen {
-		pad := make([]byte, alignedLen-realLen)
-		sc.optimizedScene.TextureData = append(sc.optimizedScene.TextureData, pad...)
+	// Float textures always store linear values
+	if tex.Format.WithColorSpace(colorSpace) == tex.Format {
+		colorSpace = tex.Format.ColorSpace()
 	}
 
-	// Setup metadata
-	sc.optimizedScene.TextureMetadata = append(
-		sc.optimizedScene.TextureMetadata,
-		scene.TextureMetadata{
-			Format:     tex.Format,
-			Width:      tex.Width,
-			Height:     tex.Height,
-			DataOffset: uint32(dataOffset),
-		},
-	)
+	// Check if another texture with the same contents is already loaded
+	ref := textureRef{tex, colorSpace, roughness}
+	texIndex, exists := sc.texIndexByData[ref]
+	if exists {
+		sc.logger.Infof("%q: sharing texture %q with identical contents", mat.Name, texPath)
+	} else {
+		sc.textures = append(sc.textures, ref)
+		texIndex = int32(len(sc.textures) - 1)
+		sc.texIndexByData[ref] = texIndex
+		sc.logger.Infof("%q: using the %s color space for texture %q", mat.Name, colorSpace, texPath)
+		if roughness.NeedsRemap() {
+			sc.logger.Infof("%q: remapping %s roughness texture %q to alpha", mat.Name, roughness, texPath)
+		}
+	}
 
-	texIndex := int32(len(sc.optimizedScene.TextureMetadata) - 1)
-	sc.texIndexCache[res.Path()] = texIndex
+	sc.texIndexCache[pathKey] = texIndex
 	return texIndex, nil
 }
 
-// Adjust value so its divisible by 4.
-func align4(value int) int {
-	for {
-		if value%4 == 0 {
-			return value
+// Downscale the loaded textures so they fit the texture budget and store
+// their metadata/data into the optimized scene. Each texture is stored
+// together with its mip chain; the data for each mip level is always
+// aligned on a dword boundary.
+func (sc *sceneCompiler) packTextures() error {
+	downscaled, err := sc.texCache.FitBudget()
+	for _, ds := range downscaled {
+		werr := sc.warn(SectionTextures, "downscaled %s from %dx%d to %dx%d to fit the texture budget", strings.Join(ds.Paths, ", "), ds.FromW, ds.FromH, ds.ToW, ds.ToH)
+		if werr != nil {
+			return werr
+		}
+	}
+	if err != nil {
+		if err = sc.warn(SectionTextures, "%v", err); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range sc.textures {
+		// Textures that are used with multiple color spaces are packed
+		// once for each color space
+		tex := &texture.Texture{
+			Format: ref.tex.Format.WithColorSpace(ref.colorSpace),
+			Width:  ref.tex.Width,
+			Height: ref.tex.Height,
+			Data:   ref.tex.Data,
+		}
+		if ref.roughness.NeedsRemap() {
+			conv := ref.roughness
+			tex = tex.Remap(func(v float64) float64 { return float64(conv.ToAlpha(float32(v))) })
+		}
+
+		levels := tex.MipChain()
+		meta := scene.TextureMetadata{
+			Format:     tex.Format,
+			Width:      tex.Width,
+			Height:     tex.Height,
+			DataOffset: uint32(len(sc.optimizedScene.TextureData)),
+			MipLevels:  uint32(len(levels)),
+		}
+		for index, level := range levels {
+			realLen := len(level.Data)
+			alignedLen := int(meta.MipLevelSize(uint32(index)))
+
+			// Copy data and add alignment padding
+			sc.optimizedScene.TextureData = append(sc.optimizedScene.TextureData, level.Data...)
+			if alignedLen > realLen {
+				pad := make([]byte, alignedLen-realLen)
+				sc.optimizedScene.TextureData = append(sc.optimizedScene.TextureData, pad...)
+			}
 		}
-		value++
+
+		sc.optimizedScene.TextureMetadata = append(sc.optimizedScene.TextureMetadata, meta)
 	}
+
+	return nil
 }
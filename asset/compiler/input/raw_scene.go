@@ -4,6 +4,8 @@ import (
 	"math"
 
 	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/texure"
 	"github.com/achilleasa/polaris/types"
 )
 
@@ -16,17 +18,39 @@ type Material struct {
 	// Relative path for textures.
 	AssetRelPath *asset.Resource
 
+	// The color spaces of textures (indexed by their path in the material
+	// expression) that override the color space implied by their usage.
+	TextureColorSpaces map[string]texture.ColorSpace
+
+	// The convention used by the roughness values and textures of the
+	// material. Importers set this to the convention of their source
+	// format; if set to RoughnessAuto the compiler options are used.
+	RoughnessConvention material.RoughnessConvention
+
 	// True if material is referenced by scene geometry.
 	Used bool
 }
 
+// The material index of primitives that do not define a material. The
+// compiler assigns a material to such primitives according to its missing
+// material policy.
+const NoMaterial = -1
+
 // A triangle primitive
 type Primitive struct {
-	Vertices      [3]types.Vec3
-	Normals       [3]types.Vec3
-	UVs           [3]types.Vec2
+	Vertices [3]types.Vec3
+	Normals  [3]types.Vec3
+	UVs      [3]types.Vec2
+
+	// An index into the scene material list or NoMaterial.
 	MaterialIndex int
 
+	// Optional per-vertex tangents. The w component stores the handedness
+	// (+1 or -1) of the bitangent which is calculated as
+	// cross(normal, tangent) * w. If the tangents are not defined (all
+	// zero), the compiler generates them from the primitive uv coords.
+	Tangents [3]types.Vec4
+
 	bbox   [2]types.Vec3
 	center types.Vec3
 }
@@ -56,6 +80,18 @@ type Mesh struct {
 	Name       string
 	Primitives []*Primitive
 
+	// The distance that secondary ray origins are offset from the surface
+	// of this mesh. If zero, the default intersection epsilon is used.
+	RayBias float32
+
+	// If set, the mesh is not visible to camera rays.
+	CameraInvisible bool
+
+	// If set, the mesh acts as a shadow catcher for camera rays. Shadow
+	// catchers may optionally reflect the scene.
+	ShadowCatcher      bool
+	CatcherReflections bool
+
 	bbox            [2]types.Vec3
 	bboxNeedsUpdate bool
 }
@@ -128,12 +164,125 @@ func NewMesh(name string) *Mesh {
 	}
 }
 
+// The name of the mesh generated by AddGroundPlane.
+const GroundPlaneMeshName = "ground_plane"
+
+// The ground plane extent as a multiple of the scene's largest horizontal
+// dimension. This makes the plane appear infinite for typical camera setups.
+const groundPlaneScale = 100.0
+
+// Add a large horizontal shadow catcher plane that is aligned to the bottom
+// of the scene bounding box and an instance for it. The scene bounding box is
+// calculated from the defined mesh instances so this method must be invoked
+// after all instances have been defined. The plane uses the supplied material
+// index and, if reflective is true, reflects the scene. Returns nil if the
+// scene does not contain any mesh instances.
+func (sc *Scene) AddGroundPlane(materialIndex int, reflective bool) *Mesh {
+	if len(sc.MeshInstances) == 0 {
+		return nil
+	}
+
+	bbox := sc.MeshInstances[0].BBox()
+	for _, mi := range sc.MeshInstances[1:] {
+		miBBox := mi.BBox()
+		bbox[0] = types.MinVec3(bbox[0], miBBox[0])
+		bbox[1] = types.MaxVec3(bbox[1], miBBox[1])
+	}
+
+	center := bbox[0].Add(bbox[1]).Mul(0.5)
+	extent := float32(math.Max(float64(bbox[1][0]-bbox[0][0]), float64(bbox[1][2]-bbox[0][2])))
+	if extent <= 0 {
+		extent = 1
+	}
+	extent *= groundPlaneScale
+
+	y := bbox[0][1]
+	corners := [4]types.Vec3{
+		{center[0] - extent, y, center[2] - extent},
+		{center[0] - extent, y, center[2] + extent},
+		{center[0] + extent, y, center[2] + extent},
+		{center[0] + extent, y, center[2] - extent},
+	}
+	uvs := [4]types.Vec2{{0, 0}, {0, 1}, {1, 1}, {1, 0}}
+	up := types.Vec3{0, 1, 0}
+
+	mesh := NewMesh(GroundPlaneMeshName)
+	mesh.ShadowCatcher = true
+	mesh.CatcherReflections = reflective
+	for _, tri := range [2][3]int{{0, 1, 2}, {0, 2, 3}} {
+		prim := &Primitive{
+			Vertices:      [3]types.Vec3{corners[tri[0]], corners[tri[1]], corners[tri[2]]},
+			Normals:       [3]types.Vec3{up, up, up},
+			UVs:           [3]types.Vec2{uvs[tri[0]], uvs[tri[1]], uvs[tri[2]]},
+			MaterialIndex: materialIndex,
+		}
+		prim.SetBBox([2]types.Vec3{
+			types.MinVec3(prim.Vertices[0], types.MinVec3(prim.Vertices[1], prim.Vertices[2])),
+			types.MaxVec3(prim.Vertices[0], types.MaxVec3(prim.Vertices[1], prim.Vertices[2])),
+		})
+		prim.SetCenter(prim.Vertices[0].Add(prim.Vertices[1]).Add(prim.Vertices[2]).Mul(1.0 / 3.0))
+		mesh.Primitives = append(mesh.Primitives, prim)
+	}
+
+	sc.Meshes = append(sc.Meshes, mesh)
+	meshBBox := mesh.BBox()
+	inst := &MeshInstance{
+		MeshIndex: uint32(len(sc.Meshes) - 1),
+		Transform: types.Ident4(),
+	}
+	inst.SetBBox(meshBBox)
+	inst.SetCenter(meshBBox[0].Add(meshBBox[1]).Mul(0.5))
+	sc.MeshInstances = append(sc.MeshInstances, inst)
+
+	return mesh
+}
+
+// The name of the camera that is used when a scene does not define any named cameras.
+const DefaultCameraName = "default"
+
 // Camera settings.
 type Camera struct {
+	Name string
+
 	FOV  float32
 	Eye  types.Vec3
 	Look types.Vec3
 	Up   types.Vec3
+
+	// Overscan percentage.
+	Overscan float32
+
+	// Pixel width to height ratio.
+	PixelAspect float32
+
+	// Depth of field settings.
+	ApertureRadius   float32
+	FocusDistance    float32
+	ApertureBlades   uint32
+	ApertureRotation float32
+
+	// Lens shift (fraction of frame dims) and tilt (degrees).
+	ShiftX float32
+	ShiftY float32
+	TiltX  float32
+	TiltY  float32
+
+	// An optional bokeh mask image for shaping the aperture. The path is
+	// resolved relative to AssetRelPath.
+	BokehMask    string
+	AssetRelPath *asset.Resource
+}
+
+// Create a new camera with default settings.
+func NewCamera(name string) *Camera {
+	return &Camera{
+		Name:        name,
+		FOV:         45.0,
+		Eye:         types.Vec3{0, 0, 0},
+		Look:        types.Vec3{0, 0, -1},
+		Up:          types.Vec3{0, 1, 0},
+		PixelAspect: 1.0,
+	}
 }
 
 // The scene contains all elements that are processed and optimized by the scene compiler.
@@ -142,20 +291,33 @@ type Scene struct {
 	Meshes        []*Mesh
 	MeshInstances []*MeshInstance
 	Materials     []*Material
-	Camera        *Camera
+
+	// The active camera. It always points to one of the entries in the
+	// scene camera list.
+	Camera *Camera
+
+	// The list of cameras defined by the scene.
+	Cameras []*Camera
+}
+
+// Get a camera by name. Returns nil if no camera with this name exists.
+func (sc *Scene) FindCamera(name string) *Camera {
+	for _, cam := range sc.Cameras {
+		if cam.Name == name {
+			return cam
+		}
+	}
+	return nil
 }
 
 // Create a new scene.
 func NewScene() *Scene {
+	cam := NewCamera(DefaultCameraName)
 	return &Scene{
 		Meshes:        make([]*Mesh, 0),
 		MeshInstances: make([]*MeshInstance, 0),
 		Materials:     make([]*Material, 0),
-		Camera: &Camera{
-			FOV:  45.0,
-			Eye:  types.Vec3{0, 0, 0},
-			Look: types.Vec3{0, 0, -1},
-			Up:   types.Vec3{0, 1, 0},
-		},
+		Camera:        cam,
+		Cameras:       []*Camera{cam},
 	}
 }
@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrMissingMaterial = errors.New("compiler: primitives without a material")
+)
+
+// Controls how the compiler handles primitives that lack a material; for
+// example, wavefront faces that are defined before any usemtl command or
+// faces that reference an undefined material.
+type MissingMaterialPolicy uint8
+
+// The supported missing material policies.
+const (
+	// Assign the scene default material. Scenes can define it using a
+	// material named SceneDefaultMaterialName; otherwise a neutral grey
+	// diffuse material is used.
+	UseSceneDefaultMaterial MissingMaterialPolicy = iota
+
+	// Assign a bright pink diffuse material so that the affected
+	// primitives stand out in renders.
+	UseDebugMaterial
+
+	// Fail the compilation.
+	FailOnMissingMaterial
+)
+
+var (
+	// The names used by ParseMissingMaterialPolicy and String.
+	missingMaterialPolicyNames = []string{"default", "debug", "error"}
+
+	// The reflectance of the fallback default material and the debug material.
+	missingMaterialReflectance = types.Vec4{0.7, 0.7, 0.7, 0.0}
+	debugMaterialReflectance   = types.Vec4{1.0, 0.0, 1.0, 0.0}
+)
+
+// Get the name of the policy.
+func (p MissingMaterialPolicy) String() string {
+	if int(p) < len(missingMaterialPolicyNames) {
+		return missingMaterialPolicyNames[p]
+	}
+	return fmt.Sprintf("MissingMaterialPolicy(%d)", uint8(p))
+}
+
+// Lookup a missing material policy by its name.
+func ParseMissingMaterialPolicy(name string) (MissingMaterialPolicy, error) {
+	for index, policyName := range missingMaterialPolicyNames {
+		if name == policyName {
+			return MissingMaterialPolicy(index), nil
+		}
+	}
+	return UseSceneDefaultMaterial, fmt.Errorf("compiler: unknown missing material policy %q; supported values are default, debug and error", name)
+}
+
+// Check whether the material of a primitive has been compiled.
+func (sc *sceneCompiler) hasMaterial(prim *input.Primitive) bool {
+	_, exists := sc.matIndexToMatRoot[prim.MaterialIndex]
+	return prim.MaterialIndex != input.NoMaterial && exists
+}
+
+// Assign a material to primitives that lack one according to the missing
+// material policy. The affected primitives are updated to use the
+// input.NoMaterial index which is mapped to the material selected by the
+// policy.
+func (sc *sceneCompiler) resolveMissingMaterials() error {
+	var total int
+	for _, pm := range sc.parsedScene.Meshes {
+		var count int
+		for _, prim := range pm.Primitives {
+			if !sc.hasMaterial(prim) {
+				prim.MaterialIndex = input.NoMaterial
+				count++
+			}
+		}
+		if count == 0 {
+			continue
+		}
+
+		if sc.missingMaterialPolicy == FailOnMissingMaterial {
+			return fmt.Errorf("%s: %d/%d primitives of mesh %q", ErrMissingMaterial.Error(), count, len(pm.Primitives), pm.Name)
+		}
+		sc.logger.Infof(`%d/%d primitives of mesh "%s" lack a material`, count, len(pm.Primitives), pm.Name)
+		total += count
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	var matNodeIndex int32
+	switch {
+	case sc.missingMaterialPolicy == UseDebugMaterial:
+		sc.logger.Noticef("using debug material for %d primitives without a material", total)
+		matNodeIndex = sc.generateDiffuseMaterial(debugMaterialReflectance)
+	case sc.sceneDefaultMatRoot != -1:
+		sc.logger.Noticef("using scene default material for %d primitives without a material", total)
+		matNodeIndex = sc.sceneDefaultMatRoot
+	default:
+		sc.logger.Noticef("using default material for %d primitives without a material", total)
+		matNodeIndex = sc.generateDiffuseMaterial(missingMaterialReflectance)
+	}
+
+	sc.matIndexToMatRoot[input.NoMaterial] = matNodeIndex
+	sc.emissiveIndexCache[input.NoMaterial] = sc.findMaterialNodeByBxdf(uint32(matNodeIndex), material.BxdfEmissive)
+	return nil
+}
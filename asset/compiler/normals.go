@@ -0,0 +1,118 @@
+package compiler
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The default max angle (in degrees) between a shading normal and the
+// geometric normal of its triangle.
+const DefaultMaxShadingNormalAngle float32 = 80
+
+// Controls how the compiler handles shading (vertex) normals that disagree
+// strongly with the geometric normal of their triangle. Such normals are
+// common in low-poly imported assets and cause black fringes as rays that hit
+// the front side of a triangle end up below the shading hemisphere.
+type ShadingNormalFix uint8
+
+// The supported shading normal fixes.
+const (
+	// Report the number of affected triangles for each mesh as a warning.
+	ReportShadingNormals ShadingNormalFix = iota
+
+	// Rotate affected shading normals towards the geometric normal until
+	// the angle between them equals the max allowed angle.
+	ClampShadingNormals
+)
+
+// Get the geometric normal of a primitive oriented so that it points to the
+// same side as its shading normals. Returns false for degenerate primitives.
+func geometricNormal(prim *input.Primitive) (types.Vec3, bool) {
+	ng := prim.Vertices[1].Sub(prim.Vertices[0]).Cross(prim.Vertices[2].Sub(prim.Vertices[0]))
+	if ng.Len() == 0 {
+		return ng, false
+	}
+	ng = ng.Normalize()
+
+	// The winding order of imported triangles is not always consistent
+	// so we orient the normal using the majority of the shading normals
+	if ng.Dot(prim.Normals[0].Add(prim.Normals[1]).Add(prim.Normals[2])) < 0 {
+		ng = ng.Mul(-1)
+	}
+	return ng, true
+}
+
+// Check the shading normals of a primitive against its geometric normal and,
+// if clamp is true, rotate any normals that exceed maxAngle (in degrees)
+// towards the geometric normal. Returns true if any of the primitive normals
+// exceed maxAngle.
+func fixShadingNormals(prim *input.Primitive, maxAngle float32, clamp bool) bool {
+	ng, valid := geometricNormal(prim)
+	if !valid {
+		return false
+	}
+
+	maxAngleRad := float64(maxAngle) * math.Pi / 180.0
+	cosMax, sinMax := float32(math.Cos(maxAngleRad)), float32(math.Sin(maxAngleRad))
+
+	var exceeded bool
+	for index, n := range prim.Normals {
+		if n.Len() == 0 {
+			continue
+		}
+
+		n = n.Normalize()
+		nDotNg := n.Dot(ng)
+		if nDotNg >= cosMax-1e-5 {
+			continue
+		}
+
+		exceeded = true
+		if !clamp {
+			continue
+		}
+
+		// Keep the tangential direction of the normal and adjust its
+		// elevation so it lies on the cone around the geometric normal.
+		// If the normal is the exact opposite of the geometric normal
+		// there is no tangential direction so we use the geometric normal.
+		tangent := n.Sub(ng.Mul(nDotNg))
+		if tangent.Len() < 1e-5 {
+			prim.Normals[index] = ng
+			continue
+		}
+		prim.Normals[index] = ng.Mul(cosMax).Add(tangent.Normalize().Mul(sinMax)).Normalize()
+	}
+
+	return exceeded
+}
+
+// Check the shading normals of each mesh primitive and, depending on the
+// compiler options, report or clamp normals that disagree with the geometric
+// normal of their primitive.
+func (sc *sceneCompiler) checkShadingNormals(mesh *input.Mesh) error {
+	maxAngle := sc.maxShadingNormalAngle
+	if maxAngle <= 0 {
+		maxAngle = DefaultMaxShadingNormalAngle
+	}
+
+	clamp := sc.shadingNormalFix == ClampShadingNormals
+	var count int
+	for _, prim := range mesh.Primitives {
+		if fixShadingNormals(prim, maxAngle, clamp) {
+			count++
+		}
+	}
+
+	switch {
+	case count == 0:
+		return nil
+	case clamp:
+		sc.logger.Infof(`clamped the shading normals of %d/%d primitives in mesh "%s"`, count, len(mesh.Primitives), mesh.Name)
+		return nil
+	}
+
+	return sc.warn(SectionGeometry, `%d/%d primitives in mesh "%s" have shading normals that deviate more than %.0f degrees from the geometric normal; rendering may exhibit black fringes`, count, len(mesh.Primitives), mesh.Name, maxAngle)
+}
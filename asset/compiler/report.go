@@ -0,0 +1,169 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// The scene loading sections that are reported to progress callbacks.
+const (
+	SectionParse     = "parse"
+	SectionMaterials = "materials"
+	SectionTextures  = "textures"
+	SectionGeometry  = "geometry"
+	SectionCamera    = "camera"
+)
+
+// A callback for receiving scene loading progress updates. The total value
+// is set to 0 if the total amount of work for a section is not known.
+type ProgressFunc func(section string, done, total int)
+
+// A non-fatal issue that was detected while loading a scene.
+type Warning struct {
+	// The loading section that generated the warning.
+	Section string
+
+	// A description of the issue.
+	Message string
+}
+
+// Implements Stringer.
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s", w.Section, w.Message)
+}
+
+// Describes how an importer handled a source material property that has no
+// direct equivalent in polaris.
+type ConversionAction string
+
+// The supported conversion actions.
+const (
+	// The property was mapped to a polaris feature that produces a
+	// similar but not identical result.
+	ConversionApproximated ConversionAction = "approximated"
+
+	// The property was ignored.
+	ConversionDropped ConversionAction = "dropped"
+)
+
+// A MaterialConversion records a source material property that was
+// approximated or dropped while importing a scene.
+type MaterialConversion struct {
+	// The name of the material.
+	Material string
+
+	// The source property (e.g. "Ns" or "map_d" for MTL files).
+	Property string
+
+	Action ConversionAction
+
+	// A description of the conversion.
+	Details string
+}
+
+// Implements Stringer.
+func (c MaterialConversion) String() string {
+	return fmt.Sprintf("[%s] %s %s: %s", c.Material, c.Property, c.Action, c.Details)
+}
+
+// A Report collects progress updates and non-fatal issues while a scene is
+// being loaded. All report methods can be safely invoked on a nil Report.
+type Report struct {
+	// The list of issues that were encountered while loading the scene.
+	Warnings []Warning
+
+	// The list of source material properties that were approximated or
+	// dropped while importing the scene.
+	Conversions []MaterialConversion
+
+	// An optional progress callback.
+	progress ProgressFunc
+
+	// If set, warnings are treated as errors.
+	strict bool
+}
+
+// Create a new report. If strict is true, the first recorded warning
+// aborts the loading process.
+func NewReport(progress ProgressFunc, strict bool) *Report {
+	return &Report{
+		Warnings:    make([]Warning, 0),
+		Conversions: make([]MaterialConversion, 0),
+		progress:    progress,
+		strict:      strict,
+	}
+}
+
+// Report the progress for a loading section.
+func (r *Report) Progress(section string, done, total int) {
+	if r == nil || r.progress == nil {
+		return
+	}
+
+	r.progress(section, done, total)
+}
+
+// Record a non-fatal issue. If the report operates in strict mode, Warn
+// returns the issue as an error which the caller must propagate.
+func (r *Report) Warn(section, msgFormat string, args ...interface{}) error {
+	msg := fmt.Sprintf(msgFormat, args...)
+	if r == nil {
+		return nil
+	}
+
+	if r.strict {
+		return fmt.Errorf("%s", msg)
+	}
+
+	r.Warnings = append(r.Warnings, Warning{Section: section, Message: msg})
+	return nil
+}
+
+// Record a material property conversion. Conversions describe expected
+// differences between the source material and its polaris equivalent so
+// they never abort the loading process, even in strict mode.
+func (r *Report) Convert(material, property string, action ConversionAction, detailsFormat string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	r.Conversions = append(r.Conversions, MaterialConversion{
+		Material: material,
+		Property: property,
+		Action:   action,
+		Details:  fmt.Sprintf(detailsFormat, args...),
+	})
+}
+
+// Build a tabular representation of the material conversions grouped by
+// material name. Returns an empty string if no conversions were recorded.
+func (r *Report) ConversionTable() string {
+	if r == nil || len(r.Conversions) == 0 {
+		return ""
+	}
+
+	conversions := make([]MaterialConversion, len(r.Conversions))
+	copy(conversions, r.Conversions)
+	sort.SliceStable(conversions, func(i, j int) bool {
+		return conversions[i].Material < conversions[j].Material
+	})
+
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Material", "Property", "Action", "Details"})
+	for index, c := range conversions {
+		matName := c.Material
+		if index > 0 && conversions[index-1].Material == c.Material {
+			matName = ""
+		}
+		table.Append([]string{matName, c.Property, string(c.Action), c.Details})
+	}
+	table.Render()
+	return buf.String()
+}
@@ -0,0 +1,111 @@
+package compiler
+
+import (
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The min squared length of an accumulated tangent that is considered valid.
+const minTangentLenSq = 1e-12
+
+// Identifies vertices that are shared by adjacent primitives. Vertices are
+// only shared if they have the same position, normal and uv coords so that
+// tangents are not smoothed across uv seams or hard edges.
+type tangentVertexKey struct {
+	position types.Vec3
+	normal   types.Vec3
+	uv       types.Vec2
+}
+
+// The accumulated uv derivatives of a shared vertex.
+type tangentAccumulator struct {
+	tangent   types.Vec3
+	bitangent types.Vec3
+}
+
+// Get the derivatives of the primitive position with respect to its u and v
+// coords. Returns false if the primitive uv coords are degenerate.
+func uvDerivatives(prim *input.Primitive) (dpdu, dpdv types.Vec3, valid bool) {
+	e1 := prim.Vertices[1].Sub(prim.Vertices[0])
+	e2 := prim.Vertices[2].Sub(prim.Vertices[0])
+	duv1 := prim.UVs[1].Sub(prim.UVs[0])
+	duv2 := prim.UVs[2].Sub(prim.UVs[0])
+
+	det := duv1[0]*duv2[1] - duv2[0]*duv1[1]
+	if det == 0 {
+		return dpdu, dpdv, false
+	}
+
+	r := 1.0 / det
+	dpdu = e1.Mul(duv2[1] * r).Sub(e2.Mul(duv1[1] * r))
+	dpdv = e2.Mul(duv1[0] * r).Sub(e1.Mul(duv2[0] * r))
+	return dpdu, dpdv, true
+}
+
+// Check whether all tangents of a primitive are defined.
+func hasTangents(prim *input.Primitive) bool {
+	for _, t := range prim.Tangents {
+		if t.Vec3().Len() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate the tangents of the mesh primitives that do not define them. The
+// uv derivatives of the primitives are accumulated for each shared vertex
+// and orthogonalized against the vertex normal. The tangents of vertices
+// whose primitives have degenerate uv coords are set to zero which instructs
+// the renderers to fall back to an arbitrary tangent frame. Returns the
+// number of primitives whose tangents were generated.
+func generateTangents(mesh *input.Mesh) int {
+	accumulators := make(map[tangentVertexKey]*tangentAccumulator)
+	var pending []*input.Primitive
+	for _, prim := range mesh.Primitives {
+		if hasTangents(prim) {
+			continue
+		}
+		pending = append(pending, prim)
+
+		dpdu, dpdv, valid := uvDerivatives(prim)
+		if !valid {
+			continue
+		}
+		for v := 0; v < 3; v++ {
+			key := tangentVertexKey{prim.Vertices[v], prim.Normals[v], prim.UVs[v]}
+			acc := accumulators[key]
+			if acc == nil {
+				acc = &tangentAccumulator{}
+				accumulators[key] = acc
+			}
+			acc.tangent = acc.tangent.Add(dpdu)
+			acc.bitangent = acc.bitangent.Add(dpdv)
+		}
+	}
+
+	for _, prim := range pending {
+		for v := 0; v < 3; v++ {
+			prim.Tangents[v] = types.Vec4{}
+			acc := accumulators[tangentVertexKey{prim.Vertices[v], prim.Normals[v], prim.UVs[v]}]
+			if acc == nil {
+				continue
+			}
+
+			// Gram-Schmidt orthogonalize the tangent against the normal
+			n := prim.Normals[v].Normalize()
+			t := acc.tangent.Sub(n.Mul(n.Dot(acc.tangent)))
+			if t.Dot(t) < minTangentLenSq {
+				continue
+			}
+			t = t.Normalize()
+
+			var handedness float32 = 1
+			if n.Cross(t).Dot(acc.bitangent) < 0 {
+				handedness = -1
+			}
+			prim.Tangents[v] = t.Vec4(handedness)
+		}
+	}
+
+	return len(pending)
+}
@@ -0,0 +1,108 @@
+package compiler
+
+import (
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/volume"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The max number of voxels stored for each volume grid. Grids whose active
+// region does not fit are downsampled when the scene is compiled.
+const maxVolumeGridVoxels = 1 << 24
+
+type volumePathKey struct {
+	path string
+	grid string
+}
+
+// Load a volume grid referenced by a medium and return back the index of a
+// scene volume grid that scales the grid values by the given color. Grids
+// referenced by multiple media share their voxel data.
+func (sc *sceneCompiler) loadVolumeGrid(mat *input.Material, volNode material.VolumeNode, scale types.Vec3) (int32, error) {
+	volPath, gridName := volNode.Split()
+	res, err := asset.NewResource(volPath, mat.AssetRelPath)
+	if err != nil {
+		return -1, sc.warn(SectionMaterials, "%q: skipping missing volume %q", mat.Name, volPath)
+	}
+	defer res.Close()
+
+	pathKey := volumePathKey{res.Path(), gridName}
+	grid, exists := sc.volumeGridCache[pathKey]
+	if exists {
+		sc.logger.Infof("%q: re-using already loaded volume grid %q", mat.Name, string(volNode))
+	} else {
+		sc.logger.Infof("%q: processing volume grid %q", mat.Name, string(volNode))
+
+		grid, err = sc.packVolumeGrid(mat, res, string(volNode), gridName)
+		if err != nil {
+			return -1, err
+		}
+		sc.volumeGridCache[pathKey] = grid
+	}
+
+	// Grids that could not be loaded do not affect the medium
+	if grid.MaxValue == 0 {
+		return -1, nil
+	}
+
+	grid.Scale = scale
+	sc.optimizedScene.VolumeGrids = append(sc.optimizedScene.VolumeGrids, grid)
+	return int32(len(sc.optimizedScene.VolumeGrids) - 1), nil
+}
+
+// Read a grid from a NanoVDB resource, convert it into a dense grid and append
+// its voxel data to the optimized scene. Negative voxel values are clamped to
+// zero. If the grid cannot be loaded, a warning is reported and a grid with a
+// zero MaxValue is returned.
+func (sc *sceneCompiler) packVolumeGrid(mat *input.Material, res *asset.Resource, volRef, gridName string) (scene.VolumeGrid, error) {
+	grids, err := volume.ReadNanoVDB(res)
+	if err != nil {
+		return scene.VolumeGrid{}, sc.warn(SectionMaterials, "%q: skipping unreadable volume %q: %v", mat.Name, volRef, err)
+	}
+
+	var grid *volume.Grid
+	for _, candidate := range grids {
+		if gridName == "" || candidate.Name == gridName {
+			grid = candidate
+			break
+		}
+	}
+	if grid == nil {
+		return scene.VolumeGrid{}, sc.warn(SectionMaterials, "%q: skipping volume %q: no float grid named %q", mat.Name, volRef, gridName)
+	}
+
+	dense, factor, err := grid.Dense(maxVolumeGridVoxels)
+	if err != nil {
+		return scene.VolumeGrid{}, sc.warn(SectionMaterials, "%q: skipping volume %q: %v", mat.Name, volRef, err)
+	}
+	if factor > 1 {
+		err = sc.warn(SectionMaterials, "%q: downsampled volume %q by a factor of %d to %dx%dx%d voxels", mat.Name, volRef, factor, dense.Dims[0], dense.Dims[1], dense.Dims[2])
+		if err != nil {
+			return scene.VolumeGrid{}, err
+		}
+	}
+
+	out := scene.VolumeGrid{
+		Transform:  dense.WorldToGrid,
+		Dims:       dense.Dims,
+		DataOffset: uint32(len(sc.optimizedScene.VolumeData)),
+	}
+	for _, value := range dense.Data {
+		if value < 0 {
+			value = 0
+		}
+		if value > out.MaxValue {
+			out.MaxValue = value
+		}
+		sc.optimizedScene.VolumeData = append(sc.optimizedScene.VolumeData, value)
+	}
+
+	if out.MaxValue == 0 {
+		sc.optimizedScene.VolumeData = sc.optimizedScene.VolumeData[:out.DataOffset]
+		return out, sc.warn(SectionMaterials, "%q: skipping volume %q: the grid does not contain any positive values", mat.Name, volRef)
+	}
+	return out, nil
+}
@@ -0,0 +1,93 @@
+package material
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	// Physical constants used by Planck's law.
+	planckConstant    = 6.62607015e-34
+	speedOfLight      = 2.99792458e8
+	boltzmannConstant = 1.380649e-23
+
+	// The wavelength range (in nm) and step used for integrating the
+	// blackbody spectrum.
+	blackbodyMinWavelength  = 380.0
+	blackbodyMaxWavelength  = 780.0
+	blackbodyWavelengthStep = 5.0
+)
+
+// Calculate the spectral radiance of a blackbody at the given wavelength (in nm)
+// and temperature (in Kelvin) using Planck's law.
+func planck(wavelength, kelvin float64) float64 {
+	l := wavelength * 1e-9
+	return (2.0 * planckConstant * speedOfLight * speedOfLight) /
+		(math.Pow(l, 5) * (math.Exp((planckConstant*speedOfLight)/(l*boltzmannConstant*kelvin)) - 1.0))
+}
+
+// A piecewise gaussian used by the analytic CIE matching function fit.
+func cieGaussian(x, mu, sigma1, sigma2 float64) float64 {
+	sigma := sigma2
+	if x < mu {
+		sigma = sigma1
+	}
+	t := (x - mu) / sigma
+	return math.Exp(-0.5 * t * t)
+}
+
+// Evaluate the CIE 1931 2-degree color matching functions at the given
+// wavelength (in nm) using the multi-lobe fit from Wyman et al. 2013,
+// "Simple Analytic Approximations to the CIE XYZ Color Matching Functions".
+func cieXYZ(wavelength float64) (x, y, z float64) {
+	x = 1.056*cieGaussian(wavelength, 599.8, 37.9, 31.0) +
+		0.362*cieGaussian(wavelength, 442.0, 16.0, 26.7) -
+		0.065*cieGaussian(wavelength, 501.1, 20.4, 26.2)
+	y = 0.821*cieGaussian(wavelength, 568.8, 46.9, 40.5) +
+		0.286*cieGaussian(wavelength, 530.9, 16.3, 31.1)
+	z = 1.217*cieGaussian(wavelength, 437.0, 11.8, 36.0) +
+		0.681*cieGaussian(wavelength, 459.0, 26.0, 13.8)
+	return x, y, z
+}
+
+// Convert a blackbody temperature (in Kelvin) to a linear sRGB color. The
+// blackbody spectrum is projected to CIE XYZ and then converted to linear
+// sRGB. Out of gamut components are clamped to zero and the returned color
+// is normalized so that its max component equals 1; the emitted intensity
+// should be controlled via the emissive scale parameter.
+func Blackbody(kelvin float32) types.Vec3 {
+	if kelvin <= 0 {
+		return types.Vec3{}
+	}
+
+	var X, Y, Z float64
+	for l := blackbodyMinWavelength; l <= blackbodyMaxWavelength; l += blackbodyWavelengthStep {
+		b := planck(l, float64(kelvin))
+		x, y, z := cieXYZ(l)
+		X += b * x
+		Y += b * y
+		Z += b * z
+	}
+
+	rgb := [3]float64{
+		3.2404542*X - 1.5371385*Y - 0.4985314*Z,
+		-0.9692660*X + 1.8760108*Y + 0.0415560*Z,
+		0.0556434*X - 0.2040259*Y + 1.0572252*Z,
+	}
+
+	maxComponent := 0.0
+	for index := range rgb {
+		rgb[index] = math.Max(0, rgb[index])
+		maxComponent = math.Max(maxComponent, rgb[index])
+	}
+	if maxComponent == 0 || math.IsInf(maxComponent, 0) || math.IsNaN(maxComponent) {
+		return types.Vec3{}
+	}
+
+	return types.XYZ(
+		float32(rgb[0]/maxComponent),
+		float32(rgb[1]/maxComponent),
+		float32(rgb[2]/maxComponent),
+	)
+}
@@ -0,0 +1,38 @@
+package material
+
+import "testing"
+
+func TestBlackbody(t *testing.T) {
+	// D65 is approximately a 6504K blackbody so it should map close to white
+	white := Blackbody(6504)
+	for index, v := range white {
+		if v < 0.9 {
+			t.Errorf("expected component %d of the 6504K color to be close to 1; got %f", index, v)
+		}
+	}
+
+	// Low temperatures should be reddish and high temperatures bluish
+	candle := Blackbody(1850)
+	if !(candle[0] == 1.0 && candle[0] > candle[1] && candle[1] > candle[2]) {
+		t.Errorf("expected 1850K color to be dominated by red; got %v", candle)
+	}
+
+	sky := Blackbody(15000)
+	if !(sky[2] == 1.0 && sky[2] > sky[1] && sky[1] > sky[0]) {
+		t.Errorf("expected 15000K color to be dominated by blue; got %v", sky)
+	}
+
+	// The blue component should increase along with the temperature
+	var lastBlue float32
+	for kelvin := float32(1000); kelvin <= 6500; kelvin += 500 {
+		rgb := Blackbody(kelvin)
+		if rgb[2] < lastBlue {
+			t.Errorf("expected blue component to increase with temperature; got %f at %fK (previous %f)", rgb[2], kelvin, lastBlue)
+		}
+		lastBlue = rgb[2]
+	}
+
+	if rgb := Blackbody(0); rgb.MaxComponent() != 0 {
+		t.Errorf("expected a zero temperature to produce black; got %v", rgb)
+	}
+}
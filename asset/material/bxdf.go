@@ -12,6 +12,8 @@ const (
 	BxdfRoughtConductor
 	BxdfDielectric
 	BxdfRoughDielectric
+	BxdfPrincipled
+	BxdfSubsurface
 	//
 	bxdfLastEntry
 )
@@ -31,6 +33,10 @@ func bxdfTypeFromName(name string) BxdfType {
 		return BxdfDielectric
 	case "roughDielectric":
 		return BxdfRoughDielectric
+	case "principled":
+		return BxdfPrincipled
+	case "subsurface":
+		return BxdfSubsurface
 	}
 
 	return bxdfInvalid
@@ -50,6 +56,10 @@ func (t BxdfType) String() string {
 		return "dielectric"
 	case BxdfRoughDielectric:
 		return "roughDielectric"
+	case BxdfPrincipled:
+		return "principled"
+	case BxdfSubsurface:
+		return "subsurface"
 	}
 
 	return "invalid"
@@ -11,4 +11,13 @@ var (
 	DefaultRadianceScaler float32 = 1.0
 	DefaultIntIOR                 = KnownIORs["Glass"]
 	DefaultExtIOR                 = KnownIORs["Air"]
+	DefaultBaseColor              = types.Vec4{0.8, 0.8, 0.8, 0.0}
+	DefaultSpecular       float32 = 0.5
+	DefaultAlphaCutoff    float32 = 0.5
+	DefaultAnisotropy     float32 = 0.0
+
+	// Default multiple-scattering albedo and mean free path (in scene units)
+	// for subsurface materials
+	DefaultSubsurfaceAlbedo = types.Vec4{0.8, 0.8, 0.8, 0.0}
+	DefaultSubsurfaceRadius = types.Vec4{1.0, 1.0, 1.0, 0.0}
 )
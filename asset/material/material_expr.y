@@ -29,6 +29,7 @@ import (
 %token <fVal> tokFLOAT
 %token <sVal> tokMATERIAL_NAME   /* string enclosed in quotes */
 %token <sVal> tokTEXTURE	/* texture filename */
+%token <sVal> tokVOLUME		/* volume grid filename */
 
 /* tokParameter names */
 %token <sVal> tokREFLECTANCE
@@ -39,6 +40,19 @@ import (
 %token <sVal> tokEXT_IOR
 %token <sVal> tokSCALE 
 %token <sVal> tokROUGHNESS
+%token <sVal> tokTEMPERATURE
+%token <sVal> tokBASE_COLOR
+%token <sVal> tokMETALLIC
+%token <sVal> tokSPECULAR
+%token <sVal> tokSHEEN
+%token <sVal> tokCLEARCOAT
+%token <sVal> tokTRANSMISSION
+%token <sVal> tokABSORPTION
+%token <sVal> tokSCATTERING
+%token <sVal> tokANISOTROPY
+%token <sVal> tokRADIUS
+%token <sVal> tokDENSITY
+%token <sVal> tokEMISSION
 
 /* tokBxDF types */
 %token <sVal> tokDIFFUSE 
@@ -47,6 +61,8 @@ import (
 %token <sVal> tokDIELECTRIC
 %token <sVal> tokROUGH_DIELECTRIC
 %token <sVal> tokEMISSIVE 
+%token <sVal> tokPRINCIPLED
+%token <sVal> tokSUBSURFACE
 
 /* tokBlend functions */
 %token <sVal> tokMIX
@@ -54,6 +70,10 @@ import (
 %token <sVal> tokBUMP_MAP
 %token <sVal> tokNORMAL_MAP
 %token <sVal> tokDISPERSE
+%token <sVal> tokMIX_CURVATURE
+%token <sVal> tokMIX_OCCLUSION
+%token <sVal> tokALPHA_CUTOUT
+%token <sVal> tokMEDIUM
 
 /* types for non-token items */
 %type <node> material_def
@@ -63,6 +83,10 @@ import (
 %type <node> float_or_name
 %type <node> float_or_texture
 %type <node> op_spec
+%type <node> alpha_cutout_spec
+%type <node> medium_spec
+%type <node> medium_parameter_list
+%type <node> medium_parameter
 %type <node> opt_bxdf_parameter_list
 %type <node> bxdf_parameter_list
 %type <node> bxdf_spec
@@ -77,6 +101,61 @@ material_def: bxdf_spec
 	    { exprlex.(*matExprLexer).parsedExpression = $1 }
 	    | op_spec
 	    { exprlex.(*matExprLexer).parsedExpression = $1 } 
+	    | alpha_cutout_spec
+	    { exprlex.(*matExprLexer).parsedExpression = $1 } 
+	    | medium_spec
+	    { exprlex.(*matExprLexer).parsedExpression = $1 } 
+
+/* alpha cutouts are only allowed at the root of the expression */
+alpha_cutout_spec: tokALPHA_CUTOUT tokLPAREN bxdf_or_op_spec tokCOMMA tokTEXTURE tokRPAREN
+		 {
+		 	$$ = AlphaCutoutNode{
+				Expression: $3,
+				Texture: TextureNode($5),
+				Cutoff: DefaultAlphaCutoff,
+			}
+		 }
+		 | tokALPHA_CUTOUT tokLPAREN bxdf_or_op_spec tokCOMMA tokTEXTURE tokCOMMA tokFLOAT tokRPAREN
+		 {
+		 	$$ = AlphaCutoutNode{
+				Expression: $3,
+				Texture: TextureNode($5),
+				Cutoff: $7,
+			}
+		 }
+
+/* media are only allowed at the root of the expression */
+medium_spec: tokMEDIUM tokLPAREN medium_parameter_list tokRPAREN
+	   {
+	   	$$ = MediumNode{
+			Parameters: $3.(BxdfParameterList),
+		}
+	   }
+	   | tokMEDIUM tokLPAREN bxdf_or_op_spec tokCOMMA medium_parameter_list tokRPAREN
+	   {
+	   	$$ = MediumNode{
+			Expression: $3,
+			Parameters: $5.(BxdfParameterList),
+		}
+	   }
+
+medium_parameter_list: medium_parameter
+		     { $$ = BxdfParameterList{$1.(BxdfParamNode)} }
+		     | medium_parameter_list tokCOMMA medium_parameter
+		     { $$ = append($1.(BxdfParameterList), $3.(BxdfParamNode)) }
+
+medium_parameter: tokABSORPTION tokCOLON float3
+		{ $$ = BxdfParamNode{Name: $1, Value: $3} }
+		| tokSCATTERING tokCOLON float3
+		{ $$ = BxdfParamNode{Name: $1, Value: $3} }
+		| tokANISOTROPY tokCOLON tokFLOAT
+		{ $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+		| tokDENSITY tokCOLON tokVOLUME
+		{ $$ = BxdfParamNode{Name: $1, Value: VolumeNode($3)} }
+		| tokEMISSION tokCOLON tokVOLUME
+		{ $$ = BxdfParamNode{Name: $1, Value: VolumeNode($3)} }
+		| tokRADIANCE tokCOLON float3
+		{ $$ = BxdfParamNode{Name: $1, Value: $3} }
 
 bxdf_spec: bxdf_type tokLPAREN opt_bxdf_parameter_list tokRPAREN
 	 { 
@@ -92,6 +171,8 @@ bxdf_type: tokDIFFUSE
 	 | tokDIELECTRIC
 	 | tokROUGH_DIELECTRIC
 	 | tokEMISSIVE
+	 | tokPRINCIPLED
+	 | tokSUBSURFACE
 
 opt_bxdf_parameter_list: /* empty */
 		       { $$ = make(BxdfParameterList, 0) }
@@ -118,6 +199,24 @@ bxdf_parameter: tokREFLECTANCE tokCOLON float3_or_texture
 	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
 	      | tokROUGHNESS tokCOLON float_or_texture
 	      { $$ = BxdfParamNode{Name: $1, Value: $3} }
+	      | tokTEMPERATURE tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+	      | tokBASE_COLOR tokCOLON float3_or_texture
+	      { $$ = BxdfParamNode{Name: $1, Value: $3} }
+	      | tokMETALLIC tokCOLON float_or_texture
+	      { $$ = BxdfParamNode{Name: $1, Value: $3} }
+	      | tokSPECULAR tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+	      | tokSHEEN tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+	      | tokCLEARCOAT tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+	      | tokTRANSMISSION tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
+	      | tokRADIUS tokCOLON float3
+	      { $$ = BxdfParamNode{Name: $1, Value: $3} }
+	      | tokANISOTROPY tokCOLON tokFLOAT
+	      { $$ = BxdfParamNode{Name: $1, Value: FloatNode($3)} }
 
 float3_or_texture: float3
 		 | tokTEXTURE { $$ = TextureNode($1) }
@@ -145,6 +244,20 @@ op_spec: tokMIX tokLPAREN bxdf_or_op_spec tokCOMMA bxdf_or_op_spec tokCOMMA tokF
 			Texture: TextureNode($7),
 		}
 	  }
+	  | tokMIX_CURVATURE tokLPAREN bxdf_or_op_spec tokCOMMA bxdf_or_op_spec tokCOMMA tokFLOAT tokRPAREN
+	  { 
+	  	$$ = MixCurvatureNode{ 
+	  		Expressions: [2]ExprNode{$3, $5},
+			Scale: $7,
+		}
+	  }
+	  | tokMIX_OCCLUSION tokLPAREN bxdf_or_op_spec tokCOMMA bxdf_or_op_spec tokCOMMA tokFLOAT tokRPAREN
+	  { 
+	  	$$ = MixOcclusionNode{ 
+	  		Expressions: [2]ExprNode{$3, $5},
+			Radius: $7,
+		}
+	  }
 	  | tokBUMP_MAP tokLPAREN bxdf_or_op_spec tokCOMMA tokTEXTURE tokRPAREN
 	  {
 	  	$$ = BumpMapNode {
@@ -198,7 +311,7 @@ func (x *matExprLexer) Lex(yylval *exprSymType) int {
 		switch c {
 		case tokEOF:
 			return tokEOF
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.', '-':
 			x.tokenBuf.Reset()
 			return x.lexFloat32(c, yylval)
 		case '"':
@@ -270,6 +383,9 @@ func (x *matExprLexer) lexLiteral(c rune, yylval *exprSymType) int {
 	if supportedImageRegex.MatchString(yylval.sVal) {
 		return tokTEXTURE
 	}
+	if supportedVolumeRegex.MatchString(yylval.sVal) {
+		return tokVOLUME
+	}
 
 	return tokMATERIAL_NAME
 }
@@ -300,12 +416,18 @@ func (x *matExprLexer) lexIdentifier(c rune, yylval *exprSymType) int {
 	case "dielectric": return tokDIELECTRIC
 	case "roughDielectric": return tokROUGH_DIELECTRIC
 	case "emissive": return tokEMISSIVE
+	case "principled": return tokPRINCIPLED
+	case "subsurface": return tokSUBSURFACE
 	// Operators
 	case "mix": return tokMIX
 	case "mixMap": return tokMIX_MAP
 	case "bumpMap": return tokBUMP_MAP
 	case "normalMap": return tokNORMAL_MAP
 	case "disperse": return tokDISPERSE
+	case "mixCurvature": return tokMIX_CURVATURE
+	case "mixOcclusion": return tokMIX_OCCLUSION
+	case "alphaCutout": return tokALPHA_CUTOUT
+	case "medium": return tokMEDIUM
 	// Parameters
 	case ParamReflectance: return tokREFLECTANCE
 	case ParamSpecularity: return tokSPECULARITY
@@ -315,6 +437,19 @@ func (x *matExprLexer) lexIdentifier(c rune, yylval *exprSymType) int {
 	case ParamExtIOR: return tokEXT_IOR
 	case ParamScale: return tokSCALE
 	case ParamRoughness: return tokROUGHNESS
+	case ParamTemperature: return tokTEMPERATURE
+	case ParamBaseColor: return tokBASE_COLOR
+	case ParamMetallic: return tokMETALLIC
+	case ParamSpecular: return tokSPECULAR
+	case ParamSheen: return tokSHEEN
+	case ParamClearcoat: return tokCLEARCOAT
+	case ParamTransmission: return tokTRANSMISSION
+	case ParamAbsorption: return tokABSORPTION
+	case ParamScattering: return tokSCATTERING
+	case ParamAnisotropy: return tokANISOTROPY
+	case ParamRadius: return tokRADIUS
+	case ParamDensity: return tokDENSITY
+	case ParamEmission: return tokEMISSION
 	default:
 		x.Error(fmt.Sprintf("invalid expression %q", yylval.sVal))
 		return tokEOF
@@ -1,11 +1,13 @@
-//line material_expr.y:2
+// Code generated by goyacc -o material_expr.y.go -p expr material_expr.y. DO NOT EDIT.
 
+//line material_expr.y:2
 //go:generate go tool yacc -o material_expr.y.go -p expr material_expr.y
 package material
 
 import __yyfmt__ "fmt"
 
 //line material_expr.y:3
+
 import (
 	"bytes"
 	"fmt"
@@ -31,25 +33,45 @@ const tokCOLON = 57351
 const tokFLOAT = 57352
 const tokMATERIAL_NAME = 57353
 const tokTEXTURE = 57354
-const tokREFLECTANCE = 57355
-const tokSPECULARITY = 57356
-const tokTRANSMITTANCE = 57357
-const tokRADIANCE = 57358
-const tokINT_IOR = 57359
-const tokEXT_IOR = 57360
-const tokSCALE = 57361
-const tokROUGHNESS = 57362
-const tokDIFFUSE = 57363
-const tokCONDUCTOR = 57364
-const tokROUGH_CONDUCTOR = 57365
-const tokDIELECTRIC = 57366
-const tokROUGH_DIELECTRIC = 57367
-const tokEMISSIVE = 57368
-const tokMIX = 57369
-const tokMIX_MAP = 57370
-const tokBUMP_MAP = 57371
-const tokNORMAL_MAP = 57372
-const tokDISPERSE = 57373
+const tokVOLUME = 57355
+const tokREFLECTANCE = 57356
+const tokSPECULARITY = 57357
+const tokTRANSMITTANCE = 57358
+const tokRADIANCE = 57359
+const tokINT_IOR = 57360
+const tokEXT_IOR = 57361
+const tokSCALE = 57362
+const tokROUGHNESS = 57363
+const tokTEMPERATURE = 57364
+const tokBASE_COLOR = 57365
+const tokMETALLIC = 57366
+const tokSPECULAR = 57367
+const tokSHEEN = 57368
+const tokCLEARCOAT = 57369
+const tokTRANSMISSION = 57370
+const tokABSORPTION = 57371
+const tokSCATTERING = 57372
+const tokANISOTROPY = 57373
+const tokRADIUS = 57374
+const tokDENSITY = 57375
+const tokEMISSION = 57376
+const tokDIFFUSE = 57377
+const tokCONDUCTOR = 57378
+const tokROUGH_CONDUCTOR = 57379
+const tokDIELECTRIC = 57380
+const tokROUGH_DIELECTRIC = 57381
+const tokEMISSIVE = 57382
+const tokPRINCIPLED = 57383
+const tokSUBSURFACE = 57384
+const tokMIX = 57385
+const tokMIX_MAP = 57386
+const tokBUMP_MAP = 57387
+const tokNORMAL_MAP = 57388
+const tokDISPERSE = 57389
+const tokMIX_CURVATURE = 57390
+const tokMIX_OCCLUSION = 57391
+const tokALPHA_CUTOUT = 57392
+const tokMEDIUM = 57393
 
 var exprToknames = [...]string{
 	"$end",
@@ -64,6 +86,7 @@ var exprToknames = [...]string{
 	"tokFLOAT",
 	"tokMATERIAL_NAME",
 	"tokTEXTURE",
+	"tokVOLUME",
 	"tokREFLECTANCE",
 	"tokSPECULARITY",
 	"tokTRANSMITTANCE",
@@ -72,25 +95,45 @@ var exprToknames = [...]string{
 	"tokEXT_IOR",
 	"tokSCALE",
 	"tokROUGHNESS",
+	"tokTEMPERATURE",
+	"tokBASE_COLOR",
+	"tokMETALLIC",
+	"tokSPECULAR",
+	"tokSHEEN",
+	"tokCLEARCOAT",
+	"tokTRANSMISSION",
+	"tokABSORPTION",
+	"tokSCATTERING",
+	"tokANISOTROPY",
+	"tokRADIUS",
+	"tokDENSITY",
+	"tokEMISSION",
 	"tokDIFFUSE",
 	"tokCONDUCTOR",
 	"tokROUGH_CONDUCTOR",
 	"tokDIELECTRIC",
 	"tokROUGH_DIELECTRIC",
 	"tokEMISSIVE",
+	"tokPRINCIPLED",
+	"tokSUBSURFACE",
 	"tokMIX",
 	"tokMIX_MAP",
 	"tokBUMP_MAP",
 	"tokNORMAL_MAP",
 	"tokDISPERSE",
+	"tokMIX_CURVATURE",
+	"tokMIX_OCCLUSION",
+	"tokALPHA_CUTOUT",
+	"tokMEDIUM",
 }
+
 var exprStatenames = [...]string{}
 
 const exprEofCode = 1
 const exprErrCode = 2
 const exprInitialStackSize = 16
 
-//line material_expr.y:177
+//line material_expr.y:290
 
 // The parser expects the lexer to return 0 on kEOF.
 const tokEOF = 0
@@ -114,7 +157,7 @@ func (x *matExprLexer) Lex(yylval *exprSymType) int {
 		switch c {
 		case tokEOF:
 			return tokEOF
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.':
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.', '-':
 			x.tokenBuf.Reset()
 			return x.lexFloat32(c, yylval)
 		case '"':
@@ -186,6 +229,9 @@ func (x *matExprLexer) lexLiteral(c rune, yylval *exprSymType) int {
 	if supportedImageRegex.MatchString(yylval.sVal) {
 		return tokTEXTURE
 	}
+	if supportedVolumeRegex.MatchString(yylval.sVal) {
+		return tokVOLUME
+	}
 
 	return tokMATERIAL_NAME
 }
@@ -222,6 +268,10 @@ func (x *matExprLexer) lexIdentifier(c rune, yylval *exprSymType) int {
 		return tokROUGH_DIELECTRIC
 	case "emissive":
 		return tokEMISSIVE
+	case "principled":
+		return tokPRINCIPLED
+	case "subsurface":
+		return tokSUBSURFACE
 	// Operators
 	case "mix":
 		return tokMIX
@@ -233,6 +283,14 @@ func (x *matExprLexer) lexIdentifier(c rune, yylval *exprSymType) int {
 		return tokNORMAL_MAP
 	case "disperse":
 		return tokDISPERSE
+	case "mixCurvature":
+		return tokMIX_CURVATURE
+	case "mixOcclusion":
+		return tokMIX_OCCLUSION
+	case "alphaCutout":
+		return tokALPHA_CUTOUT
+	case "medium":
+		return tokMEDIUM
 	// Parameters
 	case ParamReflectance:
 		return tokREFLECTANCE
@@ -250,6 +308,32 @@ func (x *matExprLexer) lexIdentifier(c rune, yylval *exprSymType) int {
 		return tokSCALE
 	case ParamRoughness:
 		return tokROUGHNESS
+	case ParamTemperature:
+		return tokTEMPERATURE
+	case ParamBaseColor:
+		return tokBASE_COLOR
+	case ParamMetallic:
+		return tokMETALLIC
+	case ParamSpecular:
+		return tokSPECULAR
+	case ParamSheen:
+		return tokSHEEN
+	case ParamClearcoat:
+		return tokCLEARCOAT
+	case ParamTransmission:
+		return tokTRANSMISSION
+	case ParamAbsorption:
+		return tokABSORPTION
+	case ParamScattering:
+		return tokSCATTERING
+	case ParamAnisotropy:
+		return tokANISOTROPY
+	case ParamRadius:
+		return tokRADIUS
+	case ParamDensity:
+		return tokDENSITY
+	case ParamEmission:
+		return tokEMISSION
 	default:
 		x.Error(fmt.Sprintf("invalid expression %q", yylval.sVal))
 		return tokEOF
@@ -300,103 +384,146 @@ func ParseExpression(input string) (ExprNode, error) {
 }
 
 //line yacctab:1
-var exprExca = [...]int{
+var exprExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
 }
 
-const exprNprod = 37
 const exprPrivate = 57344
 
-var exprTokenNames []string
-var exprStates []string
-
-const exprLast = 109
-
-var exprAct = [...]int{
-
-	58, 33, 64, 57, 24, 25, 26, 27, 28, 29,
-	30, 31, 32, 92, 36, 76, 70, 85, 71, 75,
-	37, 38, 39, 40, 10, 11, 12, 13, 14, 15,
-	5, 6, 7, 8, 9, 10, 11, 12, 13, 14,
-	15, 5, 6, 7, 8, 9, 60, 56, 61, 62,
-	63, 67, 59, 72, 73, 74, 65, 66, 93, 87,
-	84, 77, 68, 94, 82, 50, 49, 48, 47, 46,
-	45, 44, 43, 91, 90, 83, 79, 78, 55, 54,
-	53, 52, 51, 86, 42, 95, 60, 97, 89, 88,
-	81, 80, 41, 21, 20, 96, 19, 18, 17, 16,
-	34, 2, 35, 3, 4, 23, 22, 69, 1,
-}
-var exprPact = [...]int{
-
-	14, -1000, -1000, -1000, 95, 94, 93, 92, 90, 89,
-	-1000, -1000, -1000, -1000, -1000, -1000, -8, 3, 3, 3,
-	3, 3, 87, 76, -1000, 63, 62, 61, 60, 59,
-	58, 57, 56, 74, -1000, -1000, -1000, 73, 72, 71,
-	70, -1000, -8, 40, 40, 40, 40, 46, 46, 52,
-	6, 3, 3, 43, 7, -2, -1000, -1000, -1000, -1000,
-	51, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, 69, 68, 86, 85, 55, 67, 50, 5,
-	-1000, -1000, 80, 49, 84, 83, 66, 65, -1000, -1000,
-	-5, 48, 54, 78, 80, -1000, 82, -1000,
-}
-var exprPgo = [...]int{
-
-	0, 108, 0, 4, 3, 2, 107, 102, 106, 105,
-	100, 1, 104,
-}
-var exprR1 = [...]int{
-
-	0, 1, 1, 10, 12, 12, 12, 12, 12, 12,
-	8, 8, 9, 9, 3, 3, 3, 3, 3, 3,
-	3, 3, 4, 4, 2, 5, 5, 6, 6, 7,
-	7, 7, 7, 7, 11, 11, 11,
-}
-var exprR2 = [...]int{
-
-	0, 1, 1, 4, 1, 1, 1, 1, 1, 1,
-	0, 1, 1, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 1, 1, 7, 1, 1, 1, 1, 8,
-	8, 6, 6, 12, 1, 1, 1,
-}
-var exprChk = [...]int{
-
-	-1000, -1, -10, -7, -12, 27, 28, 29, 30, 31,
-	21, 22, 23, 24, 25, 26, 4, 4, 4, 4,
-	4, 4, -8, -9, -3, 13, 14, 15, 16, 17,
-	18, 19, 20, -11, -10, -7, 11, -11, -11, -11,
-	-11, 5, 8, 9, 9, 9, 9, 9, 9, 9,
-	9, 8, 8, 8, 8, 8, -3, -4, -2, 12,
-	6, -4, -4, -4, -5, 10, 11, -5, 10, -6,
-	10, 12, -11, -11, 12, 12, 17, 10, 8, 8,
-	5, 5, 9, 8, 10, 12, -2, 10, 5, 5,
-	8, 8, 18, 10, 9, 7, -2, 5,
-}
-var exprDef = [...]int{
-
-	0, -2, 1, 2, 0, 0, 0, 0, 0, 0,
-	4, 5, 6, 7, 8, 9, 10, 0, 0, 0,
-	0, 0, 0, 11, 12, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 34, 35, 36, 0, 0, 0,
-	0, 3, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 13, 14, 22, 23,
-	0, 15, 16, 17, 18, 25, 26, 19, 20, 21,
-	27, 28, 0, 0, 0, 0, 0, 0, 0, 0,
-	31, 32, 0, 0, 0, 0, 0, 0, 29, 30,
-	0, 0, 0, 0, 0, 24, 0, 33,
-}
-var exprTok1 = [...]int{
+const exprLast = 221
+
+var exprAct = [...]uint8{
+	112, 54, 111, 65, 123, 67, 177, 118, 36, 73,
+	149, 141, 148, 124, 114, 125, 164, 142, 57, 178,
+	113, 68, 69, 70, 73, 71, 72, 140, 58, 59,
+	60, 61, 62, 63, 64, 66, 68, 69, 70, 139,
+	71, 72, 16, 17, 18, 19, 20, 21, 22, 23,
+	7, 8, 11, 12, 13, 9, 10, 16, 17, 18,
+	19, 20, 21, 22, 23, 7, 8, 11, 12, 13,
+	9, 10, 14, 15, 119, 120, 169, 168, 166, 165,
+	115, 116, 117, 163, 110, 151, 179, 57, 127, 121,
+	147, 128, 133, 134, 132, 135, 136, 137, 138, 131,
+	130, 129, 126, 122, 158, 145, 146, 144, 143, 109,
+	150, 16, 17, 18, 19, 20, 21, 22, 23, 7,
+	8, 11, 12, 13, 9, 10, 37, 38, 39, 40,
+	41, 42, 43, 44, 45, 46, 47, 48, 49, 50,
+	51, 108, 161, 53, 52, 102, 159, 107, 106, 160,
+	101, 105, 104, 102, 33, 92, 91, 90, 89, 167,
+	88, 87, 86, 85, 84, 83, 82, 81, 80, 79,
+	78, 77, 76, 176, 174, 162, 155, 154, 153, 152,
+	181, 103, 100, 99, 98, 97, 96, 95, 94, 93,
+	75, 180, 114, 182, 175, 173, 172, 171, 170, 157,
+	156, 74, 32, 31, 30, 29, 28, 27, 26, 25,
+	24, 55, 2, 56, 3, 6, 35, 34, 5, 4,
+	1,
+}
+
+var exprPact = [...]int16{
+	22, -1000, -1000, -1000, -1000, -1000, 206, 205, 204, 203,
+	202, 201, 200, 199, 198, 150, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, 112, 76, 76, 76, 76, 76,
+	76, 76, 76, 7, 196, 182, -1000, 163, 162, 161,
+	160, 159, 158, 157, 156, 155, 154, 153, 152, 151,
+	149, 148, 147, 146, 181, -1000, -1000, -1000, 180, 179,
+	178, 177, 176, 175, 174, 145, 173, -1000, 143, 142,
+	139, 138, 132, 100, -1000, 112, 8, 8, 8, 8,
+	64, 64, 93, 3, 92, 8, 3, 91, 90, 89,
+	84, 186, 83, 76, 76, 76, 76, 27, 15, -7,
+	5, -1000, -8, -8, 186, 186, 80, -1, -3, 186,
+	-1000, -1000, -1000, -1000, 75, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, 171, 170, 169, 168, 195,
+	194, 95, 141, -1000, 137, -1000, -1000, -1000, -1000, -1000,
+	-1000, 167, 73, 4, 69, 68, -1000, -1000, 186, -1000,
+	67, -1000, 66, 193, 192, 191, 190, 166, 189, 165,
+	-1000, -1000, -1000, -1000, -13, -1000, 9, 77, 184, 186,
+	-1000, 188, -1000,
+}
+
+var exprPgo = [...]uint8{
+	0, 220, 0, 8, 2, 7, 4, 213, 219, 218,
+	3, 5, 217, 216, 211, 1, 215,
+}
+
+var exprR1 = [...]int8{
+	0, 1, 1, 1, 1, 8, 8, 9, 9, 10,
+	10, 11, 11, 11, 11, 11, 11, 14, 16, 16,
+	16, 16, 16, 16, 16, 16, 12, 12, 13, 13,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 4, 4, 2,
+	5, 5, 6, 6, 7, 7, 7, 7, 7, 7,
+	7, 15, 15, 15,
+}
+
+var exprR2 = [...]int8{
+	0, 1, 1, 1, 1, 6, 8, 4, 6, 1,
+	3, 3, 3, 3, 3, 3, 3, 4, 1, 1,
+	1, 1, 1, 1, 1, 1, 0, 1, 1, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 1, 1, 7,
+	1, 1, 1, 1, 8, 8, 8, 8, 6, 6,
+	12, 1, 1, 1,
+}
 
+var exprChk = [...]int16{
+	-1000, -1, -14, -7, -8, -9, -16, 43, 44, 48,
+	49, 45, 46, 47, 50, 51, 35, 36, 37, 38,
+	39, 40, 41, 42, 4, 4, 4, 4, 4, 4,
+	4, 4, 4, 4, -12, -13, -3, 14, 15, 16,
+	17, 18, 19, 20, 21, 22, 23, 24, 25, 26,
+	27, 28, 32, 31, -15, -14, -7, 11, -15, -15,
+	-15, -15, -15, -15, -15, -10, -15, -11, 29, 30,
+	31, 33, 34, 17, 5, 8, 9, 9, 9, 9,
+	9, 9, 9, 9, 9, 9, 9, 9, 9, 9,
+	9, 9, 9, 8, 8, 8, 8, 8, 8, 8,
+	8, 5, 8, 8, 9, 9, 9, 9, 9, 9,
+	-3, -4, -2, 12, 6, -4, -4, -4, -5, 10,
+	11, -5, 10, -6, 10, 12, 10, -4, -6, 10,
+	10, 10, 10, -2, 10, -15, -15, -15, -15, 12,
+	12, 18, 12, -11, -10, -2, -2, 10, 13, 13,
+	-2, 10, 8, 8, 8, 8, 5, 5, 9, 5,
+	8, 5, 8, 10, 12, 10, 10, -2, 10, 10,
+	5, 5, 5, 5, 8, 5, 8, 19, 10, 9,
+	7, -2, 5,
+}
+
+var exprDef = [...]int8{
+	0, -2, 1, 2, 3, 4, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 18, 19, 20, 21,
+	22, 23, 24, 25, 26, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 27, 28, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 61, 62, 63, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 9, 0, 0,
+	0, 0, 0, 0, 17, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 7, 0, 0, 0, 0, 0, 0, 0, 0,
+	29, 30, 47, 48, 0, 31, 32, 33, 34, 50,
+	51, 35, 36, 37, 52, 53, 38, 39, 40, 41,
+	42, 43, 44, 45, 46, 0, 0, 0, 0, 0,
+	0, 0, 0, 10, 0, 11, 12, 13, 14, 15,
+	16, 0, 0, 0, 0, 0, 58, 59, 0, 5,
+	0, 8, 0, 0, 0, 0, 0, 0, 0, 0,
+	54, 55, 56, 57, 0, 6, 0, 0, 0, 0,
+	49, 0, 60,
+}
+
+var exprTok1 = [...]int8{
 	1,
 }
-var exprTok2 = [...]int{
 
+var exprTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
+	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
 }
-var exprTok3 = [...]int{
+
+var exprTok3 = [...]int8{
 	0,
 }
 
@@ -478,9 +605,9 @@ func exprErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := exprPact[state]
+	base := int(exprPact[state])
 	for tok := TOKSTART; tok-1 < len(exprToknames); tok++ {
-		if n := base + tok; n >= 0 && n < exprLast && exprChk[exprAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < exprLast && int(exprChk[int(exprAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -490,13 +617,13 @@ func exprErrorMessage(state, lookAhead int) string {
 
 	if exprDef[state] == -2 {
 		i := 0
-		for exprExca[i] != -1 || exprExca[i+1] != state {
+		for exprExca[i] != -1 || int(exprExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; exprExca[i] >= 0; i += 2 {
-			tok := exprExca[i]
+			tok := int(exprExca[i])
 			if tok < TOKSTART || exprExca[i+1] == 0 {
 				continue
 			}
@@ -527,30 +654,30 @@ func exprlex1(lex exprLexer, lval *exprSymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = exprTok1[0]
+		token = int(exprTok1[0])
 		goto out
 	}
 	if char < len(exprTok1) {
-		token = exprTok1[char]
+		token = int(exprTok1[char])
 		goto out
 	}
 	if char >= exprPrivate {
 		if char < exprPrivate+len(exprTok2) {
-			token = exprTok2[char-exprPrivate]
+			token = int(exprTok2[char-exprPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(exprTok3); i += 2 {
-		token = exprTok3[i+0]
+		token = int(exprTok3[i+0])
 		if token == char {
-			token = exprTok3[i+1]
+			token = int(exprTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = exprTok2[1] /* unknown char */
+		token = int(exprTok2[1]) /* unknown char */
 	}
 	if exprDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", exprTokname(token), uint(char))
@@ -605,7 +732,7 @@ exprstack:
 	exprS[exprp].yys = exprstate
 
 exprnewstate:
-	exprn = exprPact[exprstate]
+	exprn = int(exprPact[exprstate])
 	if exprn <= exprFlag {
 		goto exprdefault /* simple state */
 	}
@@ -616,8 +743,8 @@ exprnewstate:
 	if exprn < 0 || exprn >= exprLast {
 		goto exprdefault
 	}
-	exprn = exprAct[exprn]
-	if exprChk[exprn] == exprtoken { /* valid shift */
+	exprn = int(exprAct[exprn])
+	if int(exprChk[exprn]) == exprtoken { /* valid shift */
 		exprrcvr.char = -1
 		exprtoken = -1
 		exprVAL = exprrcvr.lval
@@ -630,7 +757,7 @@ exprnewstate:
 
 exprdefault:
 	/* default state action */
-	exprn = exprDef[exprstate]
+	exprn = int(exprDef[exprstate])
 	if exprn == -2 {
 		if exprrcvr.char < 0 {
 			exprrcvr.char, exprtoken = exprlex1(exprlex, &exprrcvr.lval)
@@ -639,18 +766,18 @@ exprdefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if exprExca[xi+0] == -1 && exprExca[xi+1] == exprstate {
+			if exprExca[xi+0] == -1 && int(exprExca[xi+1]) == exprstate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			exprn = exprExca[xi+0]
+			exprn = int(exprExca[xi+0])
 			if exprn < 0 || exprn == exprtoken {
 				break
 			}
 		}
-		exprn = exprExca[xi+1]
+		exprn = int(exprExca[xi+1])
 		if exprn < 0 {
 			goto ret0
 		}
@@ -672,10 +799,10 @@ exprdefault:
 
 			/* find a state where "error" is a legal shift action */
 			for exprp >= 0 {
-				exprn = exprPact[exprS[exprp].yys] + exprErrCode
+				exprn = int(exprPact[exprS[exprp].yys]) + exprErrCode
 				if exprn >= 0 && exprn < exprLast {
-					exprstate = exprAct[exprn] /* simulate a shift of "error" */
-					if exprChk[exprstate] == exprErrCode {
+					exprstate = int(exprAct[exprn]) /* simulate a shift of "error" */
+					if int(exprChk[exprstate]) == exprErrCode {
 						goto exprstack
 					}
 				}
@@ -711,7 +838,7 @@ exprdefault:
 	exprpt := exprp
 	_ = exprpt // guard against "declared and not used"
 
-	exprp -= exprR2[exprn]
+	exprp -= int(exprR2[exprn])
 	// exprp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if exprp+1 >= len(exprS) {
@@ -722,16 +849,16 @@ exprdefault:
 	exprVAL = exprS[exprp+1]
 
 	/* consult goto table to find next state */
-	exprn = exprR1[exprn]
-	exprg := exprPgo[exprn]
+	exprn = int(exprR1[exprn])
+	exprg := int(exprPgo[exprn])
 	exprj := exprg + exprS[exprp].yys + 1
 
 	if exprj >= exprLast {
-		exprstate = exprAct[exprg]
+		exprstate = int(exprAct[exprg])
 	} else {
-		exprstate = exprAct[exprj]
-		if exprChk[exprstate] != -exprn {
-			exprstate = exprAct[exprg]
+		exprstate = int(exprAct[exprj])
+		if int(exprChk[exprstate]) != -exprn {
+			exprstate = int(exprAct[exprg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -739,166 +866,335 @@ exprdefault:
 
 	case 1:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:77
+//line material_expr.y:101
 		{
 			exprlex.(*matExprLexer).parsedExpression = exprDollar[1].node
 		}
 	case 2:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:79
+//line material_expr.y:103
 		{
 			exprlex.(*matExprLexer).parsedExpression = exprDollar[1].node
 		}
 	case 3:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+//line material_expr.y:105
+		{
+			exprlex.(*matExprLexer).parsedExpression = exprDollar[1].node
+		}
+	case 4:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+//line material_expr.y:107
+		{
+			exprlex.(*matExprLexer).parsedExpression = exprDollar[1].node
+		}
+	case 5:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+//line material_expr.y:111
+		{
+			exprVAL.node = AlphaCutoutNode{
+				Expression: exprDollar[3].node,
+				Texture:    TextureNode(exprDollar[5].sVal),
+				Cutoff:     DefaultAlphaCutoff,
+			}
+		}
+	case 6:
+		exprDollar = exprS[exprpt-8 : exprpt+1]
+//line material_expr.y:119
+		{
+			exprVAL.node = AlphaCutoutNode{
+				Expression: exprDollar[3].node,
+				Texture:    TextureNode(exprDollar[5].sVal),
+				Cutoff:     exprDollar[7].fVal,
+			}
+		}
+	case 7:
+		exprDollar = exprS[exprpt-4 : exprpt+1]
+//line material_expr.y:129
+		{
+			exprVAL.node = MediumNode{
+				Parameters: exprDollar[3].node.(BxdfParameterList),
+			}
+		}
+	case 8:
+		exprDollar = exprS[exprpt-6 : exprpt+1]
+//line material_expr.y:135
+		{
+			exprVAL.node = MediumNode{
+				Expression: exprDollar[3].node,
+				Parameters: exprDollar[5].node.(BxdfParameterList),
+			}
+		}
+	case 9:
+		exprDollar = exprS[exprpt-1 : exprpt+1]
+//line material_expr.y:143
+		{
+			exprVAL.node = BxdfParameterList{exprDollar[1].node.(BxdfParamNode)}
+		}
+	case 10:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:145
+		{
+			exprVAL.node = append(exprDollar[1].node.(BxdfParameterList), exprDollar[3].node.(BxdfParamNode))
+		}
+	case 11:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:148
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 12:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:150
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 13:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:152
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 14:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:154
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: VolumeNode(exprDollar[3].sVal)}
+		}
+	case 15:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:156
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: VolumeNode(exprDollar[3].sVal)}
+		}
+	case 16:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:158
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 17:
 		exprDollar = exprS[exprpt-4 : exprpt+1]
-		//line material_expr.y:82
+//line material_expr.y:161
 		{
 			exprVAL.node = BxdfNode{
 				Type:       bxdfTypeFromName(exprDollar[1].sVal),
 				Parameters: exprDollar[3].node.(BxdfParameterList),
 			}
 		}
-	case 10:
+	case 26:
 		exprDollar = exprS[exprpt-0 : exprpt+1]
-		//line material_expr.y:97
+//line material_expr.y:178
 		{
 			exprVAL.node = make(BxdfParameterList, 0)
 		}
-	case 12:
+	case 28:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:101
+//line material_expr.y:182
 		{
 			exprVAL.node = BxdfParameterList{exprDollar[1].node.(BxdfParamNode)}
 		}
-	case 13:
+	case 29:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:103
+//line material_expr.y:184
 		{
 			exprVAL.node = append(exprDollar[1].node.(BxdfParameterList), exprDollar[3].node.(BxdfParamNode))
 		}
-	case 14:
+	case 30:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:106
+//line material_expr.y:187
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 15:
+	case 31:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:108
+//line material_expr.y:189
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 16:
+	case 32:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:110
+//line material_expr.y:191
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 17:
+	case 33:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:193
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 34:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:195
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 35:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:197
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
+		}
+	case 36:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:199
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 37:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:112
+//line material_expr.y:201
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 18:
+	case 38:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:203
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 39:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:114
+//line material_expr.y:205
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 19:
+	case 40:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:116
+//line material_expr.y:207
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 20:
+	case 41:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:209
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 42:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:211
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 43:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:213
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 44:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:118
+//line material_expr.y:215
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
 		}
-	case 21:
+	case 45:
 		exprDollar = exprS[exprpt-3 : exprpt+1]
-		//line material_expr.y:120
+//line material_expr.y:217
 		{
 			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: exprDollar[3].node}
 		}
-	case 23:
+	case 46:
+		exprDollar = exprS[exprpt-3 : exprpt+1]
+//line material_expr.y:219
+		{
+			exprVAL.node = BxdfParamNode{Name: exprDollar[1].sVal, Value: FloatNode(exprDollar[3].fVal)}
+		}
+	case 48:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:123
+//line material_expr.y:222
 		{
 			exprVAL.node = TextureNode(exprDollar[1].sVal)
 		}
-	case 24:
+	case 49:
 		exprDollar = exprS[exprpt-7 : exprpt+1]
-		//line material_expr.y:126
+//line material_expr.y:225
 		{
 			exprVAL.node = Vec3Node{exprDollar[2].fVal, exprDollar[4].fVal, exprDollar[6].fVal}
 		}
-	case 25:
+	case 50:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:128
+//line material_expr.y:227
 		{
 			exprVAL.node = FloatNode(exprDollar[1].fVal)
 		}
-	case 26:
+	case 51:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:129
+//line material_expr.y:228
 		{
 			exprVAL.node = MaterialNameNode(exprDollar[1].sVal)
 		}
-	case 27:
+	case 52:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:131
+//line material_expr.y:230
 		{
 			exprVAL.node = FloatNode(exprDollar[1].fVal)
 		}
-	case 28:
+	case 53:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:132
+//line material_expr.y:231
 		{
 			exprVAL.node = TextureNode(exprDollar[1].sVal)
 		}
-	case 29:
+	case 54:
 		exprDollar = exprS[exprpt-8 : exprpt+1]
-		//line material_expr.y:135
+//line material_expr.y:234
 		{
 			exprVAL.node = MixNode{
 				Expressions: [2]ExprNode{exprDollar[3].node, exprDollar[5].node},
 				Weight:      exprDollar[7].fVal,
 			}
 		}
-	case 30:
+	case 55:
 		exprDollar = exprS[exprpt-8 : exprpt+1]
-		//line material_expr.y:142
+//line material_expr.y:241
 		{
 			exprVAL.node = MixMapNode{
 				Expressions: [2]ExprNode{exprDollar[3].node, exprDollar[5].node},
 				Texture:     TextureNode(exprDollar[7].sVal),
 			}
 		}
-	case 31:
+	case 56:
+		exprDollar = exprS[exprpt-8 : exprpt+1]
+//line material_expr.y:248
+		{
+			exprVAL.node = MixCurvatureNode{
+				Expressions: [2]ExprNode{exprDollar[3].node, exprDollar[5].node},
+				Scale:       exprDollar[7].fVal,
+			}
+		}
+	case 57:
+		exprDollar = exprS[exprpt-8 : exprpt+1]
+//line material_expr.y:255
+		{
+			exprVAL.node = MixOcclusionNode{
+				Expressions: [2]ExprNode{exprDollar[3].node, exprDollar[5].node},
+				Radius:      exprDollar[7].fVal,
+			}
+		}
+	case 58:
 		exprDollar = exprS[exprpt-6 : exprpt+1]
-		//line material_expr.y:149
+//line material_expr.y:262
 		{
 			exprVAL.node = BumpMapNode{
 				Expression: exprDollar[3].node,
 				Texture:    TextureNode(exprDollar[5].sVal),
 			}
 		}
-	case 32:
+	case 59:
 		exprDollar = exprS[exprpt-6 : exprpt+1]
-		//line material_expr.y:156
+//line material_expr.y:269
 		{
 			exprVAL.node = NormalMapNode{
 				Expression: exprDollar[3].node,
 				Texture:    TextureNode(exprDollar[5].sVal),
 			}
 		}
-	case 33:
+	case 60:
 		exprDollar = exprS[exprpt-12 : exprpt+1]
-		//line material_expr.y:163
+//line material_expr.y:276
 		{
 			exprVAL.node = DisperseNode{
 				Expression: exprDollar[3].node,
@@ -906,9 +1202,9 @@ exprdefault:
 				ExtIOR:     exprDollar[11].node.(Vec3Node),
 			}
 		}
-	case 36:
+	case 63:
 		exprDollar = exprS[exprpt-1 : exprpt+1]
-		//line material_expr.y:174
+//line material_expr.y:287
 		{
 			exprVAL.node = MaterialRefNode(exprDollar[1].sVal)
 		}
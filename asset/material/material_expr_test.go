@@ -13,9 +13,27 @@ func TestParser(t *testing.T) {
 		`conductor(specularity: "texture.jpg")`,
 		`roughConductor(specularity: {.3,.3,.3}, intIOR: "gold", roughness: 1)`,
 		`emissive(radiance: {1,1,1}, scale: 10)`,
+		`emissive(temperature: 1850, scale: 10)`,
 		`bumpMap(conductor(specularity: "texture.jpg"), "foo.jpg")`,
 		`normalMap(conductor(specularity: "texture.jpg"), "foo.jpg")`,
 		`mix(diffuse(reflectance:{0.2, 0.2, 0.2}), conductor(specularity: "texture.jpg"), 0.2, 0.8)`,
+		`mixCurvature(diffuse(reflectance: {0.8, 0.8, 0.8}), "painted", 4)`,
+		`mixCurvature("dirt", "painted", -2.5)`,
+		`mixOcclusion(diffuse(reflectance: {0.1, 0.1, 0.1}), mixCurvature("worn", "painted", 4), 0.05)`,
+		`principled()`,
+		`principled(baseColor: {0.8, 0.2, 0.1}, metallic: 1, roughness: 0.4)`,
+		`principled(baseColor: "albedo.png", metallic: "metallic.png", roughness: "roughness.png", specular: 0.5)`,
+		`principled(baseColor: {1, 1, 1}, sheen: 0.3, clearcoat: 1, transmission: 0.9, intIOR: 1.45, extIOR: "air")`,
+		`alphaCutout(diffuse(reflectance: "leaf.png"), "leaf_opacity.png")`,
+		`alphaCutout(normalMap("bark", "normal.png"), "opacity.png", 0.25)`,
+		`medium(dielectric(), absorption: {0.5, 0.1, 0.1})`,
+		`medium("glass", absorption: {0.1, 0.1, 0.1}, scattering: {1, 1, 1}, anisotropy: 0.7)`,
+		`medium(scattering: {0.05, 0.05, 0.05}, anisotropy: -0.3)`,
+		`medium(absorption: {0.5, 0.5, 0.5}, scattering: {4, 4, 4}, density: "smoke.nvdb")`,
+		`medium(absorption: {1, 1, 1}, density: "fire.NVDB#density", emission: "fire.nvdb#temperature", radiance: {4, 1.5, 0.3})`,
+		`subsurface()`,
+		`subsurface(reflectance: {0.9, 0.6, 0.5}, radius: {0.4, 0.15, 0.07}, roughness: 0.3, intIOR: 1.4, anisotropy: 0.8)`,
+		`mix(subsurface(radius: {1, 1, 1}), roughDielectric(roughness: "spec.png"), 0.8)`,
 	}
 
 	for index, expr := range validExpr {
@@ -42,6 +60,31 @@ func TestSemanticParseErrors(t *testing.T) {
 		`roughConductor(specularity: {.3,.3,.3}, intIOR: 1.2, extIOR: "foo", roughness: 1)`,
 		`dielectric(transmittance: {1.3,.3,.3})`,
 		`mix(diffuse(), conductor(), 0.2, 1.0)`,
+		`emissive(temperature: 0)`,
+		`emissive(radiance: {1,1,1}, temperature: 6500)`,
+		`diffuse(temperature: 6500)`,
+		`mixCurvature(diffuse(), conductor(), 0)`,
+		`mixOcclusion(diffuse(), conductor(), 0)`,
+		`mixOcclusion(diffuse(), conductor(), -1)`,
+		`principled(baseColor: {1.2, 0.5, 0.5})`,
+		`principled(metallic: 1.5)`,
+		`principled(clearcoat: -0.5)`,
+		`principled(transmittance: {1, 1, 1})`,
+		`diffuse(metallic: 1)`,
+		`alphaCutout(diffuse(), "opacity.png", 0)`,
+		`alphaCutout(diffuse(), "opacity.png", 1.5)`,
+		`medium(dielectric(), absorption: {-0.5, 0.1, 0.1})`,
+		`medium(dielectric(), absorption: {0, 0, 0}, scattering: {0, 0, 0})`,
+		`medium(dielectric(), scattering: {1, 1, 1}, anisotropy: 1)`,
+		`medium(anisotropy: 0.5)`,
+		`medium(density: "smoke.nvdb")`,
+		`medium(absorption: {1, 1, 1}, radiance: {1, 1, 1})`,
+		`medium(absorption: {1, 1, 1}, emission: "fire.nvdb", radiance: {-1, 1, 1})`,
+		`subsurface(radius: {0.5, 0, 0.5})`,
+		`subsurface(reflectance: "skin.png")`,
+		`subsurface(anisotropy: -1)`,
+		`subsurface(specularity: {1, 1, 1})`,
+		`diffuse(radius: {1, 1, 1})`,
 	}
 
 	for index, expr := range invalidExpr {
@@ -57,3 +100,80 @@ func TestSemanticParseErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestAlphaCutoutOnlyAtRoot(t *testing.T) {
+	expr, err := ParseExpression(`alphaCutout(diffuse(), "opacity.png")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, isCutout := expr.(AlphaCutoutNode)
+	if !isCutout {
+		t.Fatalf("expected parsed expression to be an AlphaCutoutNode; got %T", expr)
+	}
+	if node.Cutoff != DefaultAlphaCutoff {
+		t.Fatalf("expected default cutoff %f; got %f", DefaultAlphaCutoff, node.Cutoff)
+	}
+
+	invalidExpr := []string{
+		`mix(alphaCutout(diffuse(), "opacity.png"), diffuse(), 0.5)`,
+		`normalMap(alphaCutout(diffuse(), "opacity.png"), "normal.png")`,
+		`alphaCutout(alphaCutout(diffuse(), "opacity.png"), "opacity.png")`,
+	}
+	for index, expr := range invalidExpr {
+		if _, err := ParseExpression(expr); err == nil {
+			t.Errorf("[expr %d] expected a parse error for nested alpha cutout in %q", index, expr)
+		}
+	}
+}
+
+func TestMediumOnlyAtRoot(t *testing.T) {
+	expr, err := ParseExpression(`medium(dielectric(), absorption: {0.5, 0.1, 0.1}, anisotropy: 0.3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, isMedium := expr.(MediumNode)
+	if !isMedium {
+		t.Fatalf("expected parsed expression to be a MediumNode; got %T", expr)
+	}
+	if _, isBxdf := node.Expression.(BxdfNode); !isBxdf {
+		t.Fatalf("expected medium surface expression to be a BxdfNode; got %T", node.Expression)
+	}
+	if len(node.Parameters) != 2 {
+		t.Fatalf("expected medium to have 2 parameters; got %d", len(node.Parameters))
+	}
+
+	invalidExpr := []string{
+		`mix(medium(dielectric(), absorption: {1, 1, 1}), diffuse(), 0.5)`,
+		`alphaCutout(medium(dielectric(), absorption: {1, 1, 1}), "opacity.png")`,
+		`medium(medium(absorption: {1, 1, 1}), absorption: {1, 1, 1})`,
+		`medium(dielectric(), reflectance: {0.5, 0.5, 0.5})`,
+		`medium(dielectric())`,
+		`medium(absorption: {1, 1, 1}, density: "smoke.png")`,
+		`medium(absorption: {1, 1, 1}, density: {1, 1, 1})`,
+		`diffuse(density: "smoke.nvdb")`,
+	}
+	for index, expr := range invalidExpr {
+		if _, err := ParseExpression(expr); err == nil {
+			t.Errorf("[expr %d] expected a parse error for %q", index, expr)
+		}
+	}
+}
+
+func TestVolumeNodeSplit(t *testing.T) {
+	specs := []struct {
+		node          VolumeNode
+		expPath, grid string
+	}{
+		{"smoke.nvdb", "smoke.nvdb", ""},
+		{"fire.nvdb#temperature", "fire.nvdb", "temperature"},
+		{"takes#2/fire.NVDB", "takes#2/fire.NVDB", ""},
+		{"takes#2/fire.nvdb#density", "takes#2/fire.nvdb", "density"},
+	}
+
+	for index, spec := range specs {
+		path, grid := spec.node.Split()
+		if path != spec.expPath || grid != spec.grid {
+			t.Errorf("[spec %d] expected %q to split into (%q, %q); got (%q, %q)", index, spec.node, spec.expPath, spec.grid, path, grid)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package material
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/achilleasa/polaris/types"
 )
@@ -16,13 +17,27 @@ const (
 	ParamExtIOR        = "extIOR"
 	ParamScale         = "scale"
 	ParamRoughness     = "roughness"
+	ParamTemperature   = "temperature"
+	ParamBaseColor     = "baseColor"
+	ParamMetallic      = "metallic"
+	ParamSpecular      = "specular"
+	ParamSheen         = "sheen"
+	ParamClearcoat     = "clearcoat"
+	ParamTransmission  = "transmission"
+	ParamAbsorption    = "absorption"
+	ParamScattering    = "scattering"
+	ParamAnisotropy    = "anisotropy"
+	ParamRadius        = "radius"
+	ParamDensity       = "density"
+	ParamEmission      = "emission"
 )
 
 var (
 	bxdfAllowedParameters = map[BxdfType]map[string]struct{}{
 		BxdfEmissive: {
-			ParamRadiance: struct{}{},
-			ParamScale:    struct{}{},
+			ParamRadiance:    struct{}{},
+			ParamScale:       struct{}{},
+			ParamTemperature: struct{}{},
 		},
 		BxdfDiffuse: {
 			ParamReflectance: struct{}{},
@@ -51,6 +66,34 @@ var (
 			ParamExtIOR:        struct{}{},
 			ParamRoughness:     struct{}{},
 		},
+		BxdfPrincipled: {
+			ParamBaseColor:    struct{}{},
+			ParamMetallic:     struct{}{},
+			ParamRoughness:    struct{}{},
+			ParamSpecular:     struct{}{},
+			ParamSheen:        struct{}{},
+			ParamClearcoat:    struct{}{},
+			ParamTransmission: struct{}{},
+			ParamIntIOR:       struct{}{},
+			ParamExtIOR:       struct{}{},
+		},
+		BxdfSubsurface: {
+			ParamReflectance: struct{}{},
+			ParamRadius:      struct{}{},
+			ParamRoughness:   struct{}{},
+			ParamAnisotropy:  struct{}{},
+			ParamIntIOR:      struct{}{},
+			ParamExtIOR:      struct{}{},
+		},
+	}
+
+	mediumAllowedParameters = map[string]struct{}{
+		ParamAbsorption: struct{}{},
+		ParamScattering: struct{}{},
+		ParamAnisotropy: struct{}{},
+		ParamDensity:    struct{}{},
+		ParamEmission:   struct{}{},
+		ParamRadiance:   struct{}{},
 	}
 )
 
@@ -68,6 +111,10 @@ type MaterialRefNode string
 
 type TextureNode string
 
+// A volume grid reference in "file.nvdb#grid" format. The grid name is
+// optional; if omitted, the first float grid of the file is used.
+type VolumeNode string
+
 type BxdfParamNode struct {
 	Name  string
 	Value ExprNode
@@ -80,6 +127,16 @@ type MixNode struct {
 	Weight      float32
 }
 
+type MixCurvatureNode struct {
+	Expressions [2]ExprNode
+	Scale       float32
+}
+
+type MixOcclusionNode struct {
+	Expressions [2]ExprNode
+	Radius      float32
+}
+
 type BumpMapNode struct {
 	Expression ExprNode
 	Texture    TextureNode
@@ -95,6 +152,28 @@ type NormalMapNode struct {
 	Texture    TextureNode
 }
 
+// Treats the surface as transparent wherever the opacity texture value is
+// below the cutoff. Alpha cutouts are only allowed at the root of a material
+// expression.
+type AlphaCutoutNode struct {
+	Expression ExprNode
+	Texture    TextureNode
+	Cutoff     float32
+}
+
+// Defines a participating medium that fills the interior of the meshes using
+// the material. The surface of the meshes is shaded using the optional
+// expression; media without an expression can only be used as the scene
+// medium. Media are only allowed at the root of a material expression.
+//
+// Media are homogeneous unless they define a density volume grid that scales
+// the absorption and scattering coefficients or an emission volume grid that
+// is scaled by the radiance parameter.
+type MediumNode struct {
+	Expression ExprNode
+	Parameters BxdfParameterList
+}
+
 type DisperseNode struct {
 	Expression ExprNode
 	IntIOR     Vec3Node
@@ -135,6 +214,22 @@ func (n TextureNode) Validate() error {
 	return nil
 }
 
+func (n VolumeNode) Validate() error {
+	if path, _ := n.Split(); path == "" {
+		return errors.New("no volume path specified")
+	}
+	return nil
+}
+
+// Split the volume reference into the file path and the grid name.
+func (n VolumeNode) Split() (path, grid string) {
+	ref := string(n)
+	if index := strings.LastIndexByte(ref, '#'); index != -1 && strings.HasSuffix(strings.ToLower(ref[:index]), ".nvdb") {
+		return ref[:index], ref[index+1:]
+	}
+	return ref, ""
+}
+
 func (n BxdfParamNode) Validate() error {
 	// Ensure energy conservation
 	switch n.Name {
@@ -142,7 +237,7 @@ func (n BxdfParamNode) Validate() error {
 		if v, isVec := n.Value.(Vec3Node); isVec && (v[0] >= 1.0 || v[1] >= 1.0 || v[2] >= 1.0) {
 			return fmt.Errorf("energy conservation violation for Parameter %q; ensure that all vector components are < 1.0", n.Name)
 		}
-	case ParamSpecularity, ParamTransmittance:
+	case ParamSpecularity, ParamTransmittance, ParamBaseColor:
 		if v, isVec := n.Value.(Vec3Node); isVec && (v[0] > 1.0 || v[1] > 1.0 || v[2] > 1.0) {
 			return fmt.Errorf("energy conservation violation for Parameter %q; ensure that all vector components are <= 1.0", n.Name)
 		}
@@ -150,6 +245,26 @@ func (n BxdfParamNode) Validate() error {
 		if v, isFloat := n.Value.(FloatNode); isFloat && v > 1.0 {
 			return fmt.Errorf("values for Parameter %q must be in the [0, 1] range", n.Name)
 		}
+	case ParamMetallic, ParamSpecular, ParamSheen, ParamClearcoat, ParamTransmission:
+		if v, isFloat := n.Value.(FloatNode); isFloat && (v < 0.0 || v > 1.0) {
+			return fmt.Errorf("values for Parameter %q must be in the [0, 1] range", n.Name)
+		}
+	case ParamAbsorption, ParamScattering:
+		if v, isVec := n.Value.(Vec3Node); isVec && (v[0] < 0.0 || v[1] < 0.0 || v[2] < 0.0) {
+			return fmt.Errorf("values for Parameter %q must be >= 0", n.Name)
+		}
+	case ParamRadius:
+		if v, isVec := n.Value.(Vec3Node); isVec && (v[0] <= 0.0 || v[1] <= 0.0 || v[2] <= 0.0) {
+			return fmt.Errorf("values for Parameter %q must be > 0", n.Name)
+		}
+	case ParamAnisotropy:
+		if v, isFloat := n.Value.(FloatNode); isFloat && (v <= -1.0 || v >= 1.0) {
+			return fmt.Errorf("values for Parameter %q must be in the (-1, 1) range", n.Name)
+		}
+	case ParamTemperature:
+		if v, isFloat := n.Value.(FloatNode); isFloat && v <= 0.0 {
+			return fmt.Errorf("values for Parameter %q must be > 0", n.Name)
+		}
 	case ParamIntIOR, ParamExtIOR:
 		if v, isMat := n.Value.(MaterialNameNode); isMat {
 			_, err := IOR(v)
@@ -188,6 +303,65 @@ func (n NormalMapNode) Validate() error {
 	return nil
 }
 
+func (n AlphaCutoutNode) Validate() error {
+	if n.Expression == nil {
+		return fmt.Errorf("missing expression argument for %q", "alphaCutout")
+	}
+	err := n.Expression.Validate()
+	if err != nil {
+		return fmt.Errorf("alphaCutout: %v", err)
+	}
+	err = n.Texture.Validate()
+	if err != nil {
+		return fmt.Errorf("AlphaCutout: %v", err)
+	}
+	if n.Cutoff <= 0 || n.Cutoff > 1.0 {
+		return fmt.Errorf("AlphaCutout: cutoff must be in the (0, 1] range")
+	}
+	return nil
+}
+
+func (n MediumNode) Validate() error {
+	if n.Expression != nil {
+		if err := n.Expression.Validate(); err != nil {
+			return fmt.Errorf("medium: %v", err)
+		}
+	}
+
+	var extinction float32
+	var hasEmission, hasRadiance bool
+	for _, param := range n.Parameters {
+		if _, isAllowed := mediumAllowedParameters[param.Name]; !isAllowed {
+			return fmt.Errorf("medium does not support Parameter %q", param.Name)
+		}
+		if err := param.Validate(); err != nil {
+			return err
+		}
+
+		switch param.Name {
+		case ParamAbsorption, ParamScattering:
+			if v, isVec := param.Value.(Vec3Node); isVec {
+				extinction += types.Vec3(v).MaxComponent()
+			}
+		case ParamEmission:
+			hasEmission = true
+		case ParamRadiance:
+			hasRadiance = true
+			if v, isVec := param.Value.(Vec3Node); isVec && (v[0] < 0.0 || v[1] < 0.0 || v[2] < 0.0) {
+				return fmt.Errorf("values for Parameter %q must be >= 0", param.Name)
+			}
+		}
+	}
+
+	if extinction == 0.0 {
+		return fmt.Errorf("Medium: at least one of the absorption and scattering parameters must contain a non-zero value")
+	}
+	if hasRadiance && !hasEmission {
+		return fmt.Errorf("Medium: the radiance parameter requires an emission volume grid")
+	}
+	return nil
+}
+
 func (n DisperseNode) Validate() error {
 	if n.Expression == nil {
 		return fmt.Errorf("missing expression argument for %q", "Disperse")
@@ -236,6 +410,44 @@ func (n MixNode) Validate() error {
 	return nil
 }
 
+func (n MixCurvatureNode) Validate() error {
+	var err error
+	for argIndex, arg := range n.Expressions {
+		if arg == nil {
+			return fmt.Errorf("missing expression argument %d for %q", argIndex, "mixCurvature")
+		}
+		err = arg.Validate()
+		if err != nil {
+			return fmt.Errorf("mixCurvature argument %d: %v", argIndex, err)
+		}
+	}
+
+	if n.Scale == 0 {
+		return fmt.Errorf("MixCurvature: curvature scale must be non-zero")
+	}
+
+	return nil
+}
+
+func (n MixOcclusionNode) Validate() error {
+	var err error
+	for argIndex, arg := range n.Expressions {
+		if arg == nil {
+			return fmt.Errorf("missing expression argument %d for %q", argIndex, "mixOcclusion")
+		}
+		err = arg.Validate()
+		if err != nil {
+			return fmt.Errorf("mixOcclusion argument %d: %v", argIndex, err)
+		}
+	}
+
+	if n.Radius <= 0 {
+		return fmt.Errorf("MixOcclusion: occlusion radius must be > 0")
+	}
+
+	return nil
+}
+
 func (n BxdfNode) Validate() error {
 	if n.Type == bxdfInvalid {
 		return fmt.Errorf("invalid BXDF type")
@@ -243,10 +455,12 @@ func (n BxdfNode) Validate() error {
 
 	// Validate list of allowed Parameter names
 	var err error
+	seen := make(map[string]struct{}, 0)
 	for _, Param := range n.Parameters {
 		if _, isAllowed := bxdfAllowedParameters[n.Type][Param.Name]; !isAllowed {
 			return fmt.Errorf("bxdf type %q does not support Parameter %q", n.Type, Param.Name)
 		}
+		seen[Param.Name] = struct{}{}
 
 		// Validate Parameter
 		if err = Param.Validate(); err != nil {
@@ -254,5 +468,22 @@ func (n BxdfNode) Validate() error {
 		}
 	}
 
+	// A blackbody temperature defines the emitted color
+	_, hasRadiance := seen[ParamRadiance]
+	_, hasTemperature := seen[ParamTemperature]
+	if hasRadiance && hasTemperature {
+		return fmt.Errorf("Parameters %q and %q cannot be used together", ParamRadiance, ParamTemperature)
+	}
+
+	// Subsurface parameters are converted to the coefficients of the
+	// medium below the surface so they cannot vary across it
+	if n.Type == BxdfSubsurface {
+		for _, Param := range n.Parameters {
+			if _, isTex := Param.Value.(TextureNode); isTex && Param.Name == ParamReflectance {
+				return fmt.Errorf("bxdf type %q does not support textures for Parameter %q", n.Type, Param.Name)
+			}
+		}
+	}
+
 	return nil
 }
@@ -12,6 +12,10 @@ const (
 	OpBumpMap
 	OpNormalMap
 	OpDisperse
+	OpMixCurvature
+	OpMixOcclusion
+	OpAlphaCutout
+	OpMedium
 	//
 	lastOpEntry
 )
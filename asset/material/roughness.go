@@ -0,0 +1,74 @@
+package material
+
+import (
+	"fmt"
+	"math"
+)
+
+// RoughnessConvention describes how the roughness values and textures of a
+// material are encoded. The renderer expects roughness to be expressed as the
+// alpha parameter of the GGX microfacet distribution; other conventions are
+// remapped to alpha when the scene is compiled.
+type RoughnessConvention uint32
+
+const (
+	// Use the convention selected by the importer or the compiler options.
+	// If none is selected, roughness values are treated as alpha.
+	RoughnessAuto RoughnessConvention = iota
+
+	// Roughness values are GGX alpha values and are used as-is.
+	RoughnessAlpha
+
+	// Roughness values are perceptually linear and are squared to get
+	// alpha. This is the convention used by Blender, Substance, Unreal
+	// and glTF.
+	RoughnessPerceptual
+
+	// Values are glossiness values and are inverted (alpha = 1 - g).
+	RoughnessGlossiness
+
+	// Values are perceptually linear smoothness values as used by Unity
+	// (alpha = (1 - s)^2).
+	RoughnessSmoothness
+)
+
+// The names used by ParseRoughnessConvention and String.
+var roughnessConventionNames = []string{"auto", "alpha", "perceptual", "glossiness", "smoothness"}
+
+func (c RoughnessConvention) String() string {
+	if int(c) < len(roughnessConventionNames) {
+		return roughnessConventionNames[c]
+	}
+	return fmt.Sprintf("RoughnessConvention(%d)", uint32(c))
+}
+
+// Lookup a roughness convention by its name.
+func ParseRoughnessConvention(name string) (RoughnessConvention, error) {
+	for index, convName := range roughnessConventionNames {
+		if name == convName {
+			return RoughnessConvention(index), nil
+		}
+	}
+	return RoughnessAuto, fmt.Errorf("material: unknown roughness convention %q; supported values are auto, alpha, perceptual, glossiness and smoothness", name)
+}
+
+// Check if the convention requires roughness values to be remapped.
+func (c RoughnessConvention) NeedsRemap() bool {
+	return c != RoughnessAuto && c != RoughnessAlpha
+}
+
+// Convert a roughness value in the [0, 1] range encoded using this convention
+// to the GGX alpha value expected by the renderer. The result is clamped to
+// the [0, 1] range.
+func (c RoughnessConvention) ToAlpha(v float32) float32 {
+	v = float32(math.Max(0, math.Min(1, float64(v))))
+	switch c {
+	case RoughnessPerceptual:
+		return v * v
+	case RoughnessGlossiness:
+		return 1 - v
+	case RoughnessSmoothness:
+		return (1 - v) * (1 - v)
+	}
+	return v
+}
@@ -0,0 +1,48 @@
+package material
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRoughnessConventionToAlpha(t *testing.T) {
+	specs := []struct {
+		conv RoughnessConvention
+		in   float32
+		exp  float32
+	}{
+		{RoughnessAuto, 0.5, 0.5},
+		{RoughnessAlpha, 0.3, 0.3},
+		{RoughnessPerceptual, 0.5, 0.25},
+		{RoughnessPerceptual, 1, 1},
+		{RoughnessGlossiness, 0.8, 0.2},
+		{RoughnessGlossiness, 0, 1},
+		{RoughnessSmoothness, 0.5, 0.25},
+		{RoughnessSmoothness, 1, 0},
+		// Out of range values are clamped
+		{RoughnessPerceptual, 2, 1},
+		{RoughnessGlossiness, -1, 1},
+	}
+
+	for index, spec := range specs {
+		if got := spec.conv.ToAlpha(spec.in); math.Abs(float64(got-spec.exp)) > 1e-6 {
+			t.Errorf("[spec %d] expected %s value %f to map to alpha %f; got %f", index, spec.conv, spec.in, spec.exp, got)
+		}
+	}
+}
+
+func TestParseRoughnessConvention(t *testing.T) {
+	for _, conv := range []RoughnessConvention{RoughnessAuto, RoughnessAlpha, RoughnessPerceptual, RoughnessGlossiness, RoughnessSmoothness} {
+		got, err := ParseRoughnessConvention(conv.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != conv {
+			t.Errorf("expected %q to parse as %d; got %d", conv, conv, got)
+		}
+	}
+
+	if _, err := ParseRoughnessConvention("shiny"); err == nil {
+		t.Fatal("expected an error for an unknown convention")
+	}
+}
@@ -0,0 +1,28 @@
+package material
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Convert the multiple-scattering albedo and mean free path of a subsurface
+// material into the absorption and scattering coefficients of the medium
+// below its surface. The single-scattering albedo is obtained by inverting
+// the multiple-scattering albedo using the fit from "Practical and
+// Controllable Subsurface Scattering for Production Path Tracing"
+// (Chiang et al.) while the extinction coefficient is the reciprocal of the
+// mean free path.
+func SubsurfaceCoefficients(albedo, radius types.Vec3) (absorption, scattering types.Vec3) {
+	for channel := 0; channel < 3; channel++ {
+		a := float64(albedo[channel])
+		t := 4.09712 + 4.20863*a - math.Sqrt(9.59217+41.6808*a+17.7126*a*a)
+		singleScatteringAlbedo := float32(math.Max(0, 1-t*t))
+
+		extinction := 1 / radius[channel]
+		scattering[channel] = singleScatteringAlbedo * extinction
+		absorption[channel] = extinction - scattering[channel]
+	}
+
+	return absorption, scattering
+}
@@ -0,0 +1,35 @@
+package material
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestSubsurfaceCoefficients(t *testing.T) {
+	radius := types.XYZ(0.5, 1, 2)
+	absorption, scattering := SubsurfaceCoefficients(types.XYZ(0.8, 0.8, 0.8), radius)
+
+	for channel := 0; channel < 3; channel++ {
+		// The extinction coefficient is the reciprocal of the mean free path
+		if extinction := absorption[channel] + scattering[channel]; math.Abs(float64(extinction*radius[channel]-1)) > 1e-5 {
+			t.Errorf("[channel %d] expected extinction to be %f; got %f", channel, 1/radius[channel], extinction)
+		}
+
+		// Bright materials scatter light many times before absorbing it
+		if albedo := scattering[channel] * radius[channel]; math.Abs(float64(albedo-0.9906)) > 1e-3 {
+			t.Errorf("[channel %d] expected single-scattering albedo to be close to 0.9906; got %f", channel, albedo)
+		}
+	}
+
+	// The single-scattering albedo should increase along with the albedo
+	var lastScattering float32
+	for albedo := float32(0); albedo < 1; albedo += 0.1 {
+		absorption, scattering := SubsurfaceCoefficients(types.XYZ(albedo, albedo, albedo), types.XYZ(1, 1, 1))
+		if absorption[0] < 0 || scattering[0] < lastScattering {
+			t.Errorf("expected scattering to increase with albedo; got %f at albedo %f (previous %f)", scattering[0], albedo, lastScattering)
+		}
+		lastScattering = scattering[0]
+	}
+}
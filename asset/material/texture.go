@@ -5,4 +5,8 @@ import "regexp"
 var (
 	// supported image extensions regex
 	supportedImageRegex = regexp.MustCompile(`(?i)\.(?:jpg|jpeg|gif|png|tga|tiff|bmp|pnm|hdr|exr|webp)$`)
+
+	// supported volume grid extensions regex; the extension may be
+	// followed by a grid name
+	supportedVolumeRegex = regexp.MustCompile(`(?i)\.nvdb(?:#[^#]*)?$`)
 )
@@ -0,0 +1,74 @@
+package scene
+
+import (
+	"errors"
+	"image"
+	"math/rand"
+	"sort"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// The number of lens samples generated from a bokeh mask.
+const DefaultBokehSamples = 4096
+
+var (
+	ErrEmptyBokehMask = errors.New("scene: bokeh mask does not contain any non-black pixels")
+)
+
+// Generate lens sample positions distributed according to the luminance of a
+// bokeh mask image. The mask is centered inside the [-1, 1] range preserving
+// its aspect ratio. Samples are placed at the center of the selected mask
+// pixels; the returned jitter value specifies the max offset that should be
+// applied to each sample so that it covers the area of its mask pixel.
+// The generated sample set only depends on the mask contents.
+func BokehSamplesFromImage(im image.Image, count int) ([]types.Vec2, float32, error) {
+	bounds := im.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 || count <= 0 {
+		return nil, 0, ErrEmptyBokehMask
+	}
+
+	// Build a CDF over the pixel luminance values
+	cdf := make([]float64, w*h)
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, _ := im.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			sum += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 0xffff
+			cdf[y*w+x] = sum
+		}
+	}
+	if sum == 0 {
+		return nil, 0, ErrEmptyBokehMask
+	}
+
+	// Map pixels to the [-1, 1] range using the largest mask dimension
+	maxDim := w
+	if h > maxDim {
+		maxDim = h
+	}
+	pixelSize := 2.0 / float32(maxDim)
+	originX := -float32(w) * pixelSize * 0.5
+	originY := float32(h) * pixelSize * 0.5
+
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]types.Vec2, count)
+	for index := range samples {
+		// Select the first pixel whose CDF value exceeds the sample so
+		// that black pixels are never selected
+		u := rng.Float64() * sum
+		pixel := sort.Search(len(cdf), func(i int) bool { return cdf[i] > u })
+		if pixel >= len(cdf) {
+			pixel = len(cdf) - 1
+		}
+
+		x, y := pixel%w, pixel/w
+		samples[index] = types.Vec2{
+			originX + (float32(x)+0.5)*pixelSize,
+			originY - (float32(y)+0.5)*pixelSize,
+		}
+	}
+
+	return samples, pixelSize * 0.5, nil
+}
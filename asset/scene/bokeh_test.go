@@ -0,0 +1,82 @@
+package scene
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/achilleasa/polaris/stattest"
+)
+
+func TestBokehSamplesFromImage(t *testing.T) {
+	// Only the right half of the mask is lit
+	im := image.NewGray(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 2; x < 4; x++ {
+			im.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	samples, jitter, err := BokehSamplesFromImage(im, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 256 {
+		t.Fatalf("expected 256 samples; got %d", len(samples))
+	}
+	if jitter != 0.25 {
+		t.Fatalf("expected jitter to be half the mask pixel size (0.25); got %f", jitter)
+	}
+
+	for index, sample := range samples {
+		if sample[0] <= 0 || sample[0] >= 1 {
+			t.Fatalf("[sample %d] expected sample x to be in the lit (0, 1) range; got %f", index, sample[0])
+		}
+		// The 4x2 mask is centered vertically in the [-0.5, 0.5] range
+		if sample[1] <= -0.5 || sample[1] >= 0.5 {
+			t.Fatalf("[sample %d] expected sample y to be in the (-0.5, 0.5) range; got %f", index, sample[1])
+		}
+	}
+
+	// Sample sets only depend on the mask contents
+	other, _, _ := BokehSamplesFromImage(im, 256)
+	for index := range samples {
+		if samples[index] != other[index] {
+			t.Fatalf("[sample %d] expected sample sets to be identical", index)
+		}
+	}
+}
+
+func TestBokehSamplesFromEmptyImage(t *testing.T) {
+	_, _, err := BokehSamplesFromImage(image.NewGray(image.Rect(0, 0, 4, 4)), 16)
+	if err != ErrEmptyBokehMask {
+		t.Fatalf("expected to get ErrEmptyBokehMask; got %v", err)
+	}
+}
+
+func TestBokehSampleDistribution(t *testing.T) {
+	// A 4x1 gradient mask; samples should be distributed proportionally
+	// to the pixel luminance.
+	im := image.NewGray(image.Rect(0, 0, 4, 1))
+	for x := 0; x < 4; x++ {
+		im.SetGray(x, 0, color.Gray{Y: uint8(51 * (x + 1))})
+	}
+
+	samples, _, err := BokehSamplesFromImage(im, 20000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	observed := make([]int, 4)
+	for _, sample := range samples {
+		observed[int((sample[0]+1)*2)]++
+	}
+
+	res, err := stattest.ChiSquare(observed, []float64{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reject(0.001) {
+		t.Fatalf("expected samples to follow the mask luminance distribution; observed %v; %s", observed, res)
+	}
+}
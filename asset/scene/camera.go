@@ -1,11 +1,19 @@
 package scene
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 
 	"github.com/achilleasa/polaris/types"
 )
 
+var (
+	ErrUnknownCamera    = errors.New("scene: unknown camera")
+	ErrUnknownViewAngle = errors.New("scene: unknown view angle")
+)
+
 // Constants for the directions that cameras can move.
 type CameraDirection uint8
 
@@ -36,6 +44,9 @@ func (fr Frustrum) String() string {
 
 // The camera type controls the scene camera.
 type Camera struct {
+	// The camera name.
+	Name string
+
 	Position types.Vec3
 	LookAt   types.Vec3
 	Up       types.Vec3
@@ -51,6 +62,50 @@ type Camera struct {
 
 	// Adjust the frustrum so that Y is inverted
 	InvertY bool
+
+	// Extra frame area to render around each frame edge expressed as a
+	// percentage of the frame dimensions.
+	Overscan float32
+
+	// The width to height ratio of a pixel. A zero value is treated as 1
+	// (square pixels).
+	PixelAspect float32
+
+	// Depth of field settings. The aperture radius and focus distance are
+	// expressed in world units. Depth of field is disabled if either value
+	// is zero.
+	ApertureRadius float32
+	FocusDistance  float32
+
+	// The number of aperture blades. If set to 3 or more, lens samples
+	// are distributed inside a regular polygon rotated by ApertureRotation
+	// degrees, producing polygonal bokeh. Otherwise, a circular aperture
+	// is used.
+	ApertureBlades   uint32
+	ApertureRotation float32
+
+	// Lens sample positions in the [-1, 1] range generated from a bokeh
+	// mask image. If defined, they override the aperture blade settings.
+	BokehSamples []types.Vec2
+
+	// The max offset applied to each bokeh sample so that lens samples
+	// cover the area of the mask pixel they were generated from.
+	BokehJitter float32
+
+	// Lens shift expressed as a fraction of the frame width and height.
+	// Shifting moves the image plane without rotating the camera which
+	// keeps parallel lines parallel (e.g. when photographing buildings).
+	ShiftX float32
+	ShiftY float32
+
+	// Lens tilt in degrees around the camera right (TiltX) and up (TiltY)
+	// axes. Tilting rotates the plane in focus and only has a visible
+	// effect when depth of field is enabled.
+	TiltX float32
+	TiltY float32
+
+	// Clip-space scale factors for applying overscan; set by SetupFrame.
+	overscanScale [2]float32
 }
 
 func NewCamera(fov float32) *Camera {
@@ -64,12 +119,120 @@ func NewCamera(fov float32) *Camera {
 	}
 }
 
+// Select the active scene camera by name.
+func (sc *Scene) SelectCamera(name string) error {
+	for _, cam := range sc.cameraList() {
+		if cam.Name == name {
+			sc.Camera = cam
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %q; available cameras: %s", ErrUnknownCamera.Error(), name, strings.Join(sc.CameraNames(), ", "))
+}
+
+// Get the names of the cameras defined by the scene.
+func (sc *Scene) CameraNames() []string {
+	cameras := sc.cameraList()
+	names := make([]string, 0, len(cameras))
+	for _, cam := range cameras {
+		names = append(names, cam.Name)
+	}
+	return names
+}
+
+// Get the list of scene cameras. Scenes compiled before named cameras were
+// supported only define the active camera.
+func (sc *Scene) cameraList() []*Camera {
+	if len(sc.Cameras) == 0 && sc.Camera != nil {
+		return []*Camera{sc.Camera}
+	}
+	return sc.Cameras
+}
+
 // Setup camera projection matrix.
 func (c *Camera) SetupProjection(aspect float32) {
 	c.ProjMat = types.Perspective4(c.FOV, aspect, 1, 1000)
 	c.Update()
 }
 
+// Get the output frame dimensions for rendering a frame with the requested
+// dimensions after applying the camera overscan.
+func (c *Camera) FrameDims(frameW, frameH uint32) (uint32, uint32) {
+	if c.Overscan <= 0 {
+		return frameW, frameH
+	}
+
+	scale := float64(1.0 + c.Overscan/100.0)
+	return uint32(math.Floor(float64(frameW)*scale + 0.5)), uint32(math.Floor(float64(frameH)*scale + 0.5))
+}
+
+// Setup the camera projection for rendering a frame with the requested
+// dimensions taking into account the camera pixel aspect ratio and overscan.
+// This method returns back the output frame dimensions which include the
+// overscan area. The requested frame is always centered inside the output
+// frame.
+func (c *Camera) SetupFrame(frameW, frameH uint32) (uint32, uint32) {
+	outW, outH := c.FrameDims(frameW, frameH)
+	c.overscanScale = [2]float32{
+		float32(outW) / float32(frameW),
+		float32(outH) / float32(frameH),
+	}
+
+	pixelAspect := c.PixelAspect
+	if pixelAspect <= 0 {
+		pixelAspect = 1.0
+	}
+
+	c.SetupProjection(float32(frameW) * pixelAspect / float32(frameH))
+	return outW, outH
+}
+
+// Returns true if the camera simulates a finite aperture lens.
+func (c *Camera) HasDepthOfField() bool {
+	return c.ApertureRadius > 0 && c.FocusDistance > 0
+}
+
+// Get the orthonormal camera basis vectors pointing to the right, up and
+// forward directions.
+func (c *Camera) Basis() (right, up, forward types.Vec3) {
+	forward = c.LookAt.Sub(c.Position).Normalize()
+	right = forward.Cross(c.Up).Normalize()
+	up = right.Cross(forward)
+	return right, up, forward
+}
+
+// Get the direction of the pinhole ray passing through the point (x, y) of a
+// frame with the given dimensions. Pixel (px, py) spans the [px, px+1) x
+// [py, py+1) range so its center is located at (px + 0.5, py + 0.5). The
+// direction is interpolated from the frustrum corner rays in the same way as
+// the primary ray generator of the tracer.
+func (c *Camera) PixelRay(x, y float32, frameW, frameH uint32) types.Vec3 {
+	tx, ty := x/float32(frameW), y/float32(frameH)
+	left := c.Frustrum[0].Vec3().Mul(1 - ty).Add(c.Frustrum[2].Vec3().Mul(ty))
+	right := c.Frustrum[1].Vec3().Mul(1 - ty).Add(c.Frustrum[3].Vec3().Mul(ty))
+	return left.Mul(1 - tx).Add(right.Mul(tx)).Normalize()
+}
+
+// Get the normal of the plane in focus and its distance from the camera eye.
+// Without lens tilt, the plane in focus is perpendicular to the camera
+// forward vector and located FocusDistance units in front of the camera.
+func (c *Camera) FocusPlane() (normal types.Vec3, distance float32) {
+	right, up, forward := c.Basis()
+
+	normal = forward
+	if c.TiltX != 0 {
+		normal = types.QuatFromAxisAngle(right, c.TiltX*math.Pi/180.0).Rotate(normal)
+	}
+	if c.TiltY != 0 {
+		normal = types.QuatFromAxisAngle(up, c.TiltY*math.Pi/180.0).Rotate(normal)
+	}
+
+	// The plane passes through the point at FocusDistance along the
+	// camera forward vector.
+	return normal, c.FocusDistance * forward.Dot(normal)
+}
+
 // Move camera towards a specific direction using a particular offset.
 func (c *Camera) Move(dir CameraDirection, offset float32) {
 	var delta types.Vec3
@@ -122,20 +285,31 @@ func (c *Camera) updateFrustrum() {
 	var v types.Vec4
 	invProjViewMat := c.InvViewProjMat()
 
+	// Extend the clip space corners to cover the overscan area
+	var xRight float32 = 1.0
 	var yUp float32 = 1.0
+	if c.overscanScale[0] > 0 && c.overscanScale[1] > 0 {
+		xRight = c.overscanScale[0]
+		yUp = c.overscanScale[1]
+	}
+
+	// Apply lens shift by offsetting the clip space window. The clip
+	// space spans 2 units along each axis.
+	shiftX, shiftY := 2*c.ShiftX, 2*c.ShiftY
 	if c.InvertY {
-		yUp = -1.0
+		yUp = -yUp
+		shiftY = -shiftY
 	}
 
-	v = invProjViewMat.Mul4x1(types.XYZW(-1, yUp, -1, 1))
+	v = invProjViewMat.Mul4x1(types.XYZW(shiftX-xRight, shiftY+yUp, -1, 1))
 	c.Frustrum[0] = v.Mul(1.0 / v[3]).Vec3().Sub(c.Position).Vec4(0)
 
-	v = invProjViewMat.Mul4x1(types.XYZW(1, yUp, -1, 1))
+	v = invProjViewMat.Mul4x1(types.XYZW(shiftX+xRight, shiftY+yUp, -1, 1))
 	c.Frustrum[1] = v.Mul(1.0 / v[3]).Vec3().Sub(c.Position).Vec4(0)
 
-	v = invProjViewMat.Mul4x1(types.XYZW(-1, -yUp, -1, 1))
+	v = invProjViewMat.Mul4x1(types.XYZW(shiftX-xRight, shiftY-yUp, -1, 1))
 	c.Frustrum[2] = v.Mul(1.0 / v[3]).Vec3().Sub(c.Position).Vec4(0)
 
-	v = invProjViewMat.Mul4x1(types.XYZW(1, -yUp, -1, 1))
+	v = invProjViewMat.Mul4x1(types.XYZW(shiftX+xRight, shiftY-yUp, -1, 1))
 	c.Frustrum[3] = v.Mul(1.0 / v[3]).Vec3().Sub(c.Position).Vec4(0)
 }
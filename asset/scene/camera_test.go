@@ -0,0 +1,206 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestCameraFrameDims(t *testing.T) {
+	c := NewCamera(1.0)
+
+	w, h := c.FrameDims(1000, 500)
+	if w != 1000 || h != 500 {
+		t.Fatalf("expected frame dims without overscan to be 1000x500; got %dx%d", w, h)
+	}
+
+	c.Overscan = 10
+	w, h = c.FrameDims(1000, 500)
+	if w != 1100 || h != 550 {
+		t.Fatalf("expected frame dims with 10%% overscan to be 1100x550; got %dx%d", w, h)
+	}
+}
+
+func TestCameraSetupFrameOverscan(t *testing.T) {
+	base := NewCamera(1.0)
+	base.SetupFrame(1000, 500)
+
+	c := NewCamera(1.0)
+	c.Overscan = 10
+	w, h := c.SetupFrame(1000, 500)
+	if w != 1100 || h != 550 {
+		t.Fatalf("expected output frame dims to be 1100x550; got %dx%d", w, h)
+	}
+
+	// Frustrum corners should be pushed out by the overscan amount
+	for corner := range c.Frustrum {
+		expX := base.Frustrum[corner][0] * 1.1
+		expY := base.Frustrum[corner][1] * 1.1
+		if !approxEqual(c.Frustrum[corner][0], expX) || !approxEqual(c.Frustrum[corner][1], expY) {
+			t.Errorf("[corner %d] expected frustrum xy to be (%f, %f); got (%f, %f)", corner, expX, expY, c.Frustrum[corner][0], c.Frustrum[corner][1])
+		}
+		if !approxEqual(c.Frustrum[corner][2], base.Frustrum[corner][2]) {
+			t.Errorf("[corner %d] expected frustrum z to be %f; got %f", corner, base.Frustrum[corner][2], c.Frustrum[corner][2])
+		}
+	}
+}
+
+func TestCameraSetupFramePixelAspect(t *testing.T) {
+	c := NewCamera(1.0)
+	c.SetupFrame(100, 100)
+	if x, y := c.Frustrum[0][0], c.Frustrum[0][1]; !approxEqual(-x, y) {
+		t.Fatalf("expected square pixels to produce a square frustrum; got TL corner (%f, %f)", x, y)
+	}
+
+	// Each pixel is twice as wide as it is high
+	c.PixelAspect = 2.0
+	w, h := c.SetupFrame(100, 100)
+	if w != 100 || h != 100 {
+		t.Fatalf("expected pixel aspect to leave frame dims unchanged; got %dx%d", w, h)
+	}
+	if x, y := c.Frustrum[0][0], c.Frustrum[0][1]; !approxEqual(-x, 2*y) {
+		t.Fatalf("expected frustrum width to be twice its height; got TL corner (%f, %f)", x, y)
+	}
+}
+
+func approxEqual(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-4
+}
+
+func TestCameraBasis(t *testing.T) {
+	c := NewCamera(45)
+	c.Position = types.XYZ(0, 0, 5)
+	c.LookAt = types.XYZ(0, 0, 0)
+
+	right, up, forward := c.Basis()
+	specs := []struct {
+		name     string
+		got, exp types.Vec3
+	}{
+		{"right", right, types.XYZ(1, 0, 0)},
+		{"up", up, types.XYZ(0, 1, 0)},
+		{"forward", forward, types.XYZ(0, 0, -1)},
+	}
+
+	for _, spec := range specs {
+		for axis := 0; axis < 3; axis++ {
+			if !approxEqual(spec.got[axis], spec.exp[axis]) {
+				t.Errorf("expected %s vector to be %v; got %v", spec.name, spec.exp, spec.got)
+				break
+			}
+		}
+	}
+
+	if c.HasDepthOfField() {
+		t.Fatal("expected pinhole camera not to have depth of field")
+	}
+	c.ApertureRadius, c.FocusDistance = 0.1, 5
+	if !c.HasDepthOfField() {
+		t.Fatal("expected camera with a finite aperture to have depth of field")
+	}
+}
+
+func TestCameraShift(t *testing.T) {
+	base := NewCamera(45)
+	base.SetupFrame(100, 100)
+
+	c := NewCamera(45)
+	c.ShiftY = 0.5
+	c.SetupFrame(100, 100)
+
+	// A vertical shift by half the frame height moves the bottom frustrum
+	// edge to the center of the unshifted frame without rotating the camera
+	for _, corner := range []int{2, 3} {
+		expY := (base.Frustrum[corner-2][1] + base.Frustrum[corner][1]) * 0.5
+		if !approxEqual(c.Frustrum[corner][1], expY) {
+			t.Errorf("[corner %d] expected shifted frustrum y to be %f; got %f", corner, expY, c.Frustrum[corner][1])
+		}
+		if !approxEqual(c.Frustrum[corner][2], base.Frustrum[corner][2]) {
+			t.Errorf("[corner %d] expected shift not to change the frustrum z coordinate; got %f, expected %f", corner, c.Frustrum[corner][2], base.Frustrum[corner][2])
+		}
+	}
+}
+
+func TestCameraFocusPlane(t *testing.T) {
+	c := NewCamera(45)
+	c.FocusDistance = 10
+
+	normal, dist := c.FocusPlane()
+	if !approxEqual(normal[2], -1) || !approxEqual(dist, 10) {
+		t.Fatalf("expected untilted focus plane to face the camera at distance 10; got normal %v, distance %f", normal, dist)
+	}
+
+	// Tilting keeps the plane anchored at the focus point along the view axis
+	c.TiltX = 30
+	normal, dist = c.FocusPlane()
+	if !approxEqual(normal[1], 0.5) {
+		t.Fatalf("expected tilted focus plane normal y to be 0.5; got %v", normal)
+	}
+	focusPoint := types.XYZ(0, 0, -10)
+	if !approxEqual(focusPoint.Dot(normal), dist) {
+		t.Fatalf("expected focus point to lie on the tilted focus plane; got distance %f, expected %f", focusPoint.Dot(normal), dist)
+	}
+}
+
+func TestCameraPixelRay(t *testing.T) {
+	c := NewCamera(90)
+	c.Position = types.XYZ(0, 0, 5)
+	c.LookAt = types.XYZ(0, 0, 0)
+	c.Up = types.XYZ(0, 1, 0)
+	c.SetupFrame(100, 100)
+	c.Update()
+
+	specs := []struct {
+		x, y float32
+		exp  types.Vec3
+	}{
+		{50, 50, types.XYZ(0, 0, -1)},
+		{0, 0, c.Frustrum[0].Vec3().Normalize()},
+		{100, 50, c.Frustrum[1].Vec3().Add(c.Frustrum[3].Vec3()).Normalize()},
+		{50, 0, c.Frustrum[0].Vec3().Add(c.Frustrum[1].Vec3()).Normalize()},
+	}
+
+	for index, spec := range specs {
+		dir := c.PixelRay(spec.x, spec.y, 100, 100)
+		for axis := 0; axis < 3; axis++ {
+			if !approxEqual(dir[axis], spec.exp[axis]) {
+				t.Errorf("[spec %d] expected ray through (%f, %f) to be %v; got %v", index, spec.x, spec.y, spec.exp, dir)
+				break
+			}
+		}
+	}
+}
+
+func TestCameraSnapshotRestore(t *testing.T) {
+	c := NewCamera(1.0)
+	c.Position = types.XYZ(1, 2, 3)
+	c.LookAt = types.XYZ(0, 0, 0)
+	c.SetupFrame(200, 100)
+	c.FocusDistance = 4
+	snap := c.Snapshot()
+	expFrustrum := c.Frustrum
+
+	c.Position = types.XYZ(-5, 0, 0)
+	c.FocusDistance = 0
+	c.Yaw = 0.5
+	c.FOV = 0.5
+	c.SetupFrame(200, 100)
+
+	c.Restore(snap)
+	if c.Position != snap.Position || c.FocusDistance != 4 || c.FOV != 1.0 {
+		t.Fatalf("expected camera state to match the snapshot; got position %v, focus distance %f, fov %f", c.Position, c.FocusDistance, c.FOV)
+	}
+	if c.Yaw != 0 {
+		t.Fatalf("expected restore to reset the camera yaw; got %f", c.Yaw)
+	}
+
+	// The projection should keep the frame aspect ratio
+	for corner := range c.Frustrum {
+		for axis := 0; axis < 3; axis++ {
+			if !approxEqual(c.Frustrum[corner][axis], expFrustrum[corner][axis]) {
+				t.Fatalf("[corner %d] expected frustrum ray %v; got %v", corner, expFrustrum[corner], c.Frustrum[corner])
+			}
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package scene
+
+import (
+	"errors"
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrEmptyBvh = errors.New("scene: cannot compress an empty BVH")
+)
+
+const (
+	// The max number of children of a compressed BVH node.
+	CompressedBvhWidth = 8
+
+	// The max number of primitives referenced by a compressed BVH leaf.
+	// Larger leafs are split into multiple leafs with the same bounds.
+	MaxCompressedBvhLeafPrimitives = math.MaxUint8
+
+	// The range of the per-axis quantization grid exponents.
+	minCompressedBvhExponent = -126
+	maxCompressedBvhExponent = 127
+)
+
+// Compressed BVH nodes store up to 8 children whose bounding boxes are
+// quantized to 8-bit integers relative to a per-node grid. The grid starts at
+// Origin and its cell size along each axis is 2^Exponents[axis]. The bounding
+// box of child i along an axis is:
+//
+//	[Origin + QuantizedMin[axis][i] * 2^Exponents[axis], Origin + QuantizedMax[axis][i] * 2^Exponents[axis]]
+//
+// Quantized bounds are always conservative so they fully contain the original
+// child bounds. Only the first NumChildren children are valid and their
+// Children and Counts values depend on the child type:
+//
+//   - For non-leaf children, Children is >0 and points to the child node.
+//   - For top BVH leafs, Children is <= 0 and points to the mesh instance index
+//     while Counts is 0.
+//   - For bottom BVH leafs, Children is <= 0 and points to the first triangle
+//     primitive index while Counts is >0 and contains the count of leaf
+//     primitives.
+type CompressedBvhNode struct {
+	Origin      types.Vec3
+	NumChildren uint32
+
+	Children [CompressedBvhWidth]int32
+	Counts   [CompressedBvhWidth]uint8
+
+	QuantizedMin [3][CompressedBvhWidth]uint8
+	QuantizedMax [3][CompressedBvhWidth]uint8
+
+	Exponents [3]int8
+	_         [5]uint8
+}
+
+// Get the dequantized bounding box of a child node.
+func (n *CompressedBvhNode) ChildBBox(child int) [2]types.Vec3 {
+	var bbox [2]types.Vec3
+	for axis := 0; axis < 3; axis++ {
+		scale := float32(math.Ldexp(1, int(n.Exponents[axis])))
+		bbox[0][axis] = n.Origin[axis] + float32(n.QuantizedMin[axis][child])*scale
+		bbox[1][axis] = n.Origin[axis] + float32(n.QuantizedMax[axis][child])*scale
+	}
+	return bbox
+}
+
+// A compressed version of a two-level scene BVH. Each group of binary BVH
+// nodes is collapsed into a single 8-wide node with quantized child bounds
+// which reduces the memory bandwidth required for traversing the BVH at the
+// cost of slightly looser bounds.
+//
+// The compressed top-level BVH is stored at the beginning of the node list
+// and its root is always node 0. The compressed bottom-level BVH of each mesh
+// is stored after the top-level BVH.
+type CompressedBvh struct {
+	Nodes []CompressedBvhNode
+
+	// The root node of the compressed bottom-level BVH of each mesh
+	// instance.
+	MeshRoots []uint32
+
+	// The number of nodes used by the compressed top-level BVH.
+	NumTopLevelNodes int
+
+	// The binary BVH node that corresponds to each child of the
+	// compressed top-level nodes; used for refitting the top-level BVH.
+	topLevelChildren [][]uint32
+}
+
+// An item that is collapsed into a compressed node child; either a binary
+// BVH node or a range of primitives split from an oversized binary BVH leaf.
+type compressedBvhItem struct {
+	bbox [2]types.Vec3
+
+	// The binary node index or -1 for primitive ranges.
+	node int32
+
+	// The primitive range of binary BVH leafs and primitive range items.
+	firstPrim, numPrims uint32
+}
+
+// Collapses the nodes of a binary BVH into compressed BVH nodes.
+type bvhCompressor struct {
+	nodes []BvhNode
+	cb    *CompressedBvh
+}
+
+// Build a compressed version of the scene BVH. The compressed BVH is built
+// using a greedy top-down collapse: starting from the children of each binary
+// node, the child with the largest surface area is repeatedly replaced by its
+// own children until the compressed node has 8 children or only leafs remain.
+func CompressBvh(sc *Scene) (*CompressedBvh, error) {
+	if len(sc.BvhNodeList) == 0 || sc.NumTopLevelBvhNodes() == 0 {
+		return nil, ErrEmptyBvh
+	}
+
+	c := &bvhCompressor{
+		nodes: sc.BvhNodeList,
+		cb: &CompressedBvh{
+			MeshRoots: make([]uint32, len(sc.MeshInstanceList)),
+		},
+	}
+
+	c.compress(c.expand(c.item(0)), true)
+	c.cb.NumTopLevelNodes = len(c.cb.Nodes)
+
+	// Instances of the same mesh share the compressed mesh BVH
+	meshRoots := make(map[uint32]uint32)
+	for index, mi := range sc.MeshInstanceList {
+		root, exists := meshRoots[mi.BvhRoot]
+		if !exists {
+			root = c.compress(c.expand(c.item(mi.BvhRoot)), false)
+			meshRoots[mi.BvhRoot] = root
+		}
+		c.cb.MeshRoots[index] = root
+	}
+
+	return c.cb, nil
+}
+
+// Get the item for a binary BVH node.
+func (c *bvhCompressor) item(nodeIndex uint32) compressedBvhItem {
+	node := &c.nodes[nodeIndex]
+	item := compressedBvhItem{
+		bbox: [2]types.Vec3{node.Min, node.Max},
+		node: int32(nodeIndex),
+	}
+	if node.LData <= 0 {
+		item.firstPrim, item.numPrims = node.GetPrimitives()
+	}
+	return item
+}
+
+// Check whether an item is a non-leaf binary node.
+func (c *bvhCompressor) isInnerNode(item compressedBvhItem) bool {
+	return item.node != -1 && c.nodes[item.node].LData > 0
+}
+
+// Check whether an item can be split into multiple items.
+func (c *bvhCompressor) canExpand(item compressedBvhItem) bool {
+	return c.isInnerNode(item) || item.numPrims > MaxCompressedBvhLeafPrimitives
+}
+
+// Split an item into its child items. Items that cannot be split are
+// returned as is.
+func (c *bvhCompressor) expand(item compressedBvhItem) []compressedBvhItem {
+	switch {
+	case c.isInnerNode(item):
+		node := &c.nodes[item.node]
+		return []compressedBvhItem{c.item(uint32(node.LData)), c.item(uint32(node.RData))}
+	case item.numPrims > MaxCompressedBvhLeafPrimitives:
+		// Split oversized leafs into two halves with the same bounds
+		leftPrims := item.numPrims / 2
+		return []compressedBvhItem{
+			{bbox: item.bbox, node: -1, firstPrim: item.firstPrim, numPrims: leftPrims},
+			{bbox: item.bbox, node: -1, firstPrim: item.firstPrim + leftPrims, numPrims: item.numPrims - leftPrims},
+		}
+	}
+	return []compressedBvhItem{item}
+}
+
+// Collapse a list of items into a compressed node and return its index. Items
+// that do not fit in the node are recursively compressed into child nodes.
+func (c *bvhCompressor) compress(items []compressedBvhItem, topLevel bool) uint32 {
+	for len(items) < CompressedBvhWidth {
+		best := -1
+		var bestArea float32
+		for index, item := range items {
+			if !c.canExpand(item) {
+				continue
+			}
+			if area := bboxSurfaceArea(item.bbox); best == -1 || area > bestArea {
+				best, bestArea = index, area
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		expanded := c.expand(items[best])
+		items[best] = expanded[0]
+		items = append(items, expanded[1])
+	}
+
+	nodeIndex := uint32(len(c.cb.Nodes))
+	c.cb.Nodes = append(c.cb.Nodes, CompressedBvhNode{NumChildren: uint32(len(items))})
+
+	var children [CompressedBvhWidth]int32
+	var counts [CompressedBvhWidth]uint8
+	bboxes := make([][2]types.Vec3, len(items))
+	for index, item := range items {
+		bboxes[index] = item.bbox
+		switch {
+		case c.canExpand(item):
+			children[index] = int32(c.compress(c.expand(item), topLevel))
+		case topLevel:
+			// Top-level leafs reference mesh instances
+			children[index] = c.nodes[item.node].LData
+		default:
+			children[index] = -int32(item.firstPrim)
+			counts[index] = uint8(item.numPrims)
+		}
+	}
+
+	if topLevel {
+		binaryNodes := make([]uint32, len(items))
+		for index, item := range items {
+			binaryNodes[index] = uint32(item.node)
+		}
+		for len(c.cb.topLevelChildren) <= int(nodeIndex) {
+			c.cb.topLevelChildren = append(c.cb.topLevelChildren, nil)
+		}
+		c.cb.topLevelChildren[nodeIndex] = binaryNodes
+	}
+
+	node := &c.cb.Nodes[nodeIndex]
+	node.Children = children
+	node.Counts = counts
+	node.quantize(bboxes)
+	return nodeIndex
+}
+
+// Refit the compressed top-level BVH to the top-level nodes of an instance
+// update. Like the binary top-level BVH, the compressed BVH topology is
+// preserved so traversal performance degrades if instances move far from
+// their original location.
+func (cb *CompressedBvh) Refit(topLevelNodes []BvhNode) error {
+	for nodeIndex := 0; nodeIndex < cb.NumTopLevelNodes; nodeIndex++ {
+		binaryNodes := cb.topLevelChildren[nodeIndex]
+		bboxes := make([][2]types.Vec3, len(binaryNodes))
+		for index, binaryNode := range binaryNodes {
+			if int(binaryNode) >= len(topLevelNodes) {
+				return ErrInstanceMismatch
+			}
+			bboxes[index] = [2]types.Vec3{topLevelNodes[binaryNode].Min, topLevelNodes[binaryNode].Max}
+		}
+		cb.Nodes[nodeIndex].quantize(bboxes)
+	}
+	return nil
+}
+
+// Get a copy of the compressed BVH that can be refitted without affecting
+// the original.
+func (cb *CompressedBvh) Copy() *CompressedBvh {
+	dup := *cb
+	dup.Nodes = append([]CompressedBvhNode(nil), cb.Nodes...)
+	return &dup
+}
+
+// Select the quantization grid for a list of child bounding boxes and store
+// their quantized bounds.
+func (n *CompressedBvhNode) quantize(bboxes [][2]types.Vec3) {
+	if len(bboxes) == 0 {
+		return
+	}
+
+	union := bboxes[0]
+	for _, bbox := range bboxes[1:] {
+		union[0] = types.MinVec3(union[0], bbox[0])
+		union[1] = types.MaxVec3(union[1], bbox[1])
+	}
+	n.Origin = union[0]
+
+	for axis := 0; axis < 3; axis++ {
+		exp := minCompressedBvhExponent
+		if extent := float64(union[1][axis]) - float64(union[0][axis]); extent > 0 {
+			_, exp = math.Frexp(extent / math.MaxUint8)
+			if exp < minCompressedBvhExponent {
+				exp = minCompressedBvhExponent
+			}
+		}
+
+		// Dequantizing the bounds using float32 math may round the
+		// upper bounds inwards; use a coarser grid if that happens.
+		for ; exp < maxCompressedBvhExponent; exp++ {
+			if n.quantizeAxis(axis, exp, bboxes) {
+				break
+			}
+		}
+		if exp == maxCompressedBvhExponent {
+			n.quantizeAxis(axis, exp, bboxes)
+		}
+	}
+}
+
+// Quantize the child bounds along an axis using a grid with cell size 2^exp.
+// Returns false if the quantized bounds do not fully contain the child bounds.
+func (n *CompressedBvhNode) quantizeAxis(axis, exp int, bboxes [][2]types.Vec3) bool {
+	n.Exponents[axis] = int8(exp)
+	scale := float32(math.Ldexp(1, exp))
+	origin := n.Origin[axis]
+	for child, bbox := range bboxes {
+		qmin := math.Floor(float64(bbox[0][axis]-origin) / float64(scale))
+		qmax := math.Ceil(float64(bbox[1][axis]-origin) / float64(scale))
+		qmin = math.Max(0, math.Min(qmin, math.MaxUint8))
+		qmax = math.Max(0, math.Min(qmax, math.MaxUint8))
+
+		for qmin > 0 && origin+float32(qmin)*scale > bbox[0][axis] {
+			qmin--
+		}
+		for qmax < math.MaxUint8 && origin+float32(qmax)*scale < bbox[1][axis] {
+			qmax++
+		}
+		if origin+float32(qmax)*scale < bbox[1][axis] {
+			return false
+		}
+
+		n.QuantizedMin[axis][child] = uint8(qmin)
+		n.QuantizedMax[axis][child] = uint8(qmax)
+	}
+	return true
+}
+
+// Calculate the surface area of a bounding box.
+func bboxSurfaceArea(bbox [2]types.Vec3) float32 {
+	side := bbox[1].Sub(bbox[0])
+	return 2 * (side[0]*side[1] + side[0]*side[2] + side[1]*side[2])
+}
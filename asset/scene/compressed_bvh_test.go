@@ -0,0 +1,226 @@
+package scene
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Build a scene with two instances of a mesh whose BVH contains a leaf for
+// each one of the supplied primitive counts.
+func compressedBvhTestScene(leafPrims ...uint32) *Scene {
+	sc := instanceTestScene()
+
+	// Replace the single leaf mesh BVH with a left-leaning tree
+	sc.BvhNodeList = sc.BvhNodeList[:3]
+	meshRoot := uint32(len(sc.BvhNodeList))
+	var firstPrim uint32
+	for index, count := range leafPrims {
+		offset := float32(index) * 3
+		leaf := BvhNode{}
+		leaf.SetBBox([2]types.Vec3{{offset - 1, -1, -1}, {offset + 1, 1, 1}})
+		leaf.SetPrimitives(firstPrim, count)
+		firstPrim += count
+
+		if index == 0 {
+			sc.BvhNodeList = append(sc.BvhNodeList, leaf)
+			continue
+		}
+
+		// Move the tree built so far and join it with the leaf
+		prevRoot := sc.BvhNodeList[meshRoot]
+		sc.BvhNodeList = append(sc.BvhNodeList, prevRoot, leaf)
+		prevIndex := uint32(len(sc.BvhNodeList) - 2)
+		root := &sc.BvhNodeList[meshRoot]
+		root.SetBBox([2]types.Vec3{types.MinVec3(prevRoot.Min, leaf.Min), types.MaxVec3(prevRoot.Max, leaf.Max)})
+		root.SetChildNodes(prevIndex, prevIndex+1)
+	}
+
+	for index := range sc.MeshInstanceList {
+		sc.MeshInstanceList[index].BvhRoot = meshRoot
+	}
+	sc.MaterialIndex = make([]uint32, firstPrim)
+	sc.refitTopLevelBvh(0)
+	return sc
+}
+
+// Collect the primitive ranges of the leafs of a compressed sub-tree.
+func compressedBvhLeafs(t *testing.T, cb *CompressedBvh, nodeIndex uint32) [][2]uint32 {
+	node := &cb.Nodes[nodeIndex]
+	var leafs [][2]uint32
+	for child := 0; child < int(node.NumChildren); child++ {
+		if node.Children[child] > 0 {
+			leafs = append(leafs, compressedBvhLeafs(t, cb, uint32(node.Children[child]))...)
+			continue
+		}
+		if node.Counts[child] == 0 {
+			t.Fatalf("expected leaf %d of node %d to reference primitives", child, nodeIndex)
+		}
+		leafs = append(leafs, [2]uint32{uint32(-node.Children[child]), uint32(node.Counts[child])})
+	}
+	return leafs
+}
+
+// Check that a compressed child bbox contains a binary node bbox.
+func assertContainsBBox(t *testing.T, node *CompressedBvhNode, child int, bbox [2]types.Vec3) {
+	got := node.ChildBBox(child)
+	for axis := 0; axis < 3; axis++ {
+		if got[0][axis] > bbox[0][axis] || got[1][axis] < bbox[1][axis] {
+			t.Fatalf("expected quantized bbox %v of child %d to contain %v", got, child, bbox)
+		}
+	}
+}
+
+func TestCompressBvh(t *testing.T) {
+	sc := compressedBvhTestScene(2, 3, 1, 4)
+	cb, err := CompressBvh(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cb.NumTopLevelNodes != 1 {
+		t.Fatalf("expected compressed top-level BVH to contain 1 node; got %d", cb.NumTopLevelNodes)
+	}
+	root := &cb.Nodes[0]
+	if root.NumChildren != 2 {
+		t.Fatalf("expected compressed root to have 2 children; got %d", root.NumChildren)
+	}
+	for child := 0; child < 2; child++ {
+		if root.Children[child] != -int32(child) || root.Counts[child] != 0 {
+			t.Fatalf("expected child %d of compressed root to reference mesh instance %d; got %d (count %d)", child, child, root.Children[child], root.Counts[child])
+		}
+		binaryLeaf := sc.BvhNodeList[child+1]
+		assertContainsBBox(t, root, child, [2]types.Vec3{binaryLeaf.Min, binaryLeaf.Max})
+	}
+
+	// Both instances should share the compressed mesh BVH which should be
+	// collapsed into a single node.
+	if len(cb.MeshRoots) != 2 || cb.MeshRoots[0] != cb.MeshRoots[1] {
+		t.Fatalf("expected mesh instances to share the compressed mesh BVH; got roots %v", cb.MeshRoots)
+	}
+	if len(cb.Nodes) != 2 {
+		t.Fatalf("expected compressed BVH to contain 2 nodes; got %d", len(cb.Nodes))
+	}
+
+	// Map the first primitive of each leaf to the leaf X offset
+	leafOffsets := map[int32]float32{0: 0, 2: 3, 5: 6, 6: 9}
+	meshNode := &cb.Nodes[cb.MeshRoots[0]]
+	for child := 0; child < int(meshNode.NumChildren); child++ {
+		offset := leafOffsets[-meshNode.Children[child]]
+		assertContainsBBox(t, meshNode, child, [2]types.Vec3{{offset - 1, -1, -1}, {offset + 1, 1, 1}})
+	}
+
+	leafs := compressedBvhLeafs(t, cb, cb.MeshRoots[0])
+	sort.Slice(leafs, func(i, j int) bool { return leafs[i][0] < leafs[j][0] })
+	expLeafs := [][2]uint32{{0, 2}, {2, 3}, {5, 1}, {6, 4}}
+	if len(leafs) != len(expLeafs) {
+		t.Fatalf("expected %d compressed leafs; got %d", len(expLeafs), len(leafs))
+	}
+	for index, exp := range expLeafs {
+		if leafs[index] != exp {
+			t.Errorf("expected leaf %d to reference primitive range %v; got %v", index, exp, leafs[index])
+		}
+	}
+}
+
+func TestCompressBvhDeepTree(t *testing.T) {
+	var leafPrims []uint32
+	var totalPrims uint32
+	for index := 0; index < 20; index++ {
+		leafPrims = append(leafPrims, uint32(index+1))
+		totalPrims += uint32(index + 1)
+	}
+
+	sc := compressedBvhTestScene(leafPrims...)
+	cb, err := CompressBvh(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cb.Nodes) < 4 {
+		t.Fatalf("expected a BVH with 20 leafs to be compressed into at least 3 mesh nodes; got %d nodes", len(cb.Nodes))
+	}
+
+	var coveredPrims uint32
+	for _, leaf := range compressedBvhLeafs(t, cb, cb.MeshRoots[0]) {
+		coveredPrims += leaf[1]
+	}
+	if coveredPrims != totalPrims {
+		t.Fatalf("expected compressed leafs to cover %d primitives; got %d", totalPrims, coveredPrims)
+	}
+}
+
+func TestCompressBvhSplitsLargeLeafs(t *testing.T) {
+	sc := compressedBvhTestScene(600)
+	cb, err := CompressBvh(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafs := compressedBvhLeafs(t, cb, cb.MeshRoots[0])
+	sort.Slice(leafs, func(i, j int) bool { return leafs[i][0] < leafs[j][0] })
+	var next uint32
+	for _, leaf := range leafs {
+		if leaf[0] != next {
+			t.Fatalf("expected split leafs to form a contiguous primitive range; got %v", leafs)
+		}
+		if leaf[1] > MaxCompressedBvhLeafPrimitives {
+			t.Fatalf("expected split leafs to contain at most %d primitives; got %d", MaxCompressedBvhLeafPrimitives, leaf[1])
+		}
+		next += leaf[1]
+	}
+	if next != 600 {
+		t.Fatalf("expected split leafs to cover 600 primitives; got %d", next)
+	}
+}
+
+func TestCompressedBvhRefit(t *testing.T) {
+	sc := compressedBvhTestScene(2, 3)
+	cb, err := CompressBvh(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refitted := cb.Copy()
+	if err = sc.SetInstanceTransform(1, types.Translate4(types.Vec3{0, 50, 0})); err != nil {
+		t.Fatal(err)
+	}
+	update := sc.InstanceUpdate()
+	if err = refitted.Refit(update.TopLevelBvhNodes); err != nil {
+		t.Fatal(err)
+	}
+
+	root := &refitted.Nodes[0]
+	for child := 0; child < int(root.NumChildren); child++ {
+		binaryLeaf := update.TopLevelBvhNodes[child+1]
+		assertContainsBBox(t, root, child, [2]types.Vec3{binaryLeaf.Min, binaryLeaf.Max})
+	}
+	if cb.Nodes[0] == refitted.Nodes[0] {
+		t.Fatal("expected refitting a copy not to modify the original compressed BVH")
+	}
+
+	if err = refitted.Refit(update.TopLevelBvhNodes[:1]); err != ErrInstanceMismatch {
+		t.Fatalf("expected to get ErrInstanceMismatch; got %v", err)
+	}
+}
+
+func TestCompressEmptyBvh(t *testing.T) {
+	if _, err := CompressBvh(&Scene{}); err != ErrEmptyBvh {
+		t.Fatalf("expected to get ErrEmptyBvh; got %v", err)
+	}
+}
+
+func TestQuantizeBBoxes(t *testing.T) {
+	bboxes := [][2]types.Vec3{
+		{{-1000.3, 0.1, 5}, {-999.9, 0.2, 5}},
+		{{0.001, -1e-3, 5}, {1234.5, 1e-3, 5}},
+		{{-0.5, 7e5, 5}, {1e-7, 7e5 + 0.25, 5}},
+	}
+
+	var node CompressedBvhNode
+	node.quantize(bboxes)
+	for child, bbox := range bboxes {
+		assertContainsBBox(t, &node, child, bbox)
+	}
+}
@@ -0,0 +1,460 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+	"github.com/olekukonko/tablewriter"
+)
+
+// The max number of per-item differences (e.g. changed material nodes)
+// reported for each scene asset list. Any remaining differences are
+// summarized by a single entry.
+const maxDiffDetails = 10
+
+// The type of scene asset affected by a difference.
+type DiffCategory string
+
+const (
+	DiffLayout     DiffCategory = "layout"
+	DiffGeometry   DiffCategory = "geometry"
+	DiffMaterials  DiffCategory = "materials"
+	DiffLights     DiffCategory = "lights"
+	DiffInstances  DiffCategory = "instances"
+	DiffTextures   DiffCategory = "textures"
+	DiffCamera     DiffCategory = "camera"
+	DiffBackground DiffCategory = "background"
+)
+
+// A single difference between two scenes.
+type DiffEntry struct {
+	Category DiffCategory `json:"category"`
+	Message  string       `json:"message"`
+}
+
+// The differences between two compiled scenes.
+type SceneDiff struct {
+	Entries []DiffEntry `json:"entries"`
+}
+
+// Check if the compared scenes are identical.
+func (d *SceneDiff) Empty() bool {
+	return len(d.Entries) == 0
+}
+
+// Build a tabular representation of the scene differences.
+func (d *SceneDiff) String() string {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Category", "Difference"})
+	for _, entry := range d.Entries {
+		table.Append([]string{string(entry.Category), entry.Message})
+	}
+	table.Render()
+	return buf.String()
+}
+
+// Write the scene differences as indented JSON.
+func (d *SceneDiff) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func (d *SceneDiff) add(category DiffCategory, format string, args ...interface{}) {
+	d.Entries = append(d.Entries, DiffEntry{Category: category, Message: fmt.Sprintf(format, args...)})
+}
+
+// Compare the items of two asset lists with the supplied function which
+// returns the differences for the item at a particular index. The number of
+// reported item differences is capped to maxDiffDetails.
+func (d *SceneDiff) diffList(category DiffCategory, itemName string, lenA, lenB int, diffFn func(index int) []string) {
+	if lenA != lenB {
+		d.add(category, "%s count changed from %d to %d (%+d)", itemName, lenA, lenB, lenB-lenA)
+	}
+
+	numCommon := lenA
+	if lenB < numCommon {
+		numCommon = lenB
+	}
+
+	numChanged := 0
+	for index := 0; index < numCommon; index++ {
+		changes := diffFn(index)
+		if len(changes) == 0 {
+			continue
+		}
+
+		numChanged++
+		if numChanged > maxDiffDetails {
+			continue
+		}
+		for _, change := range changes {
+			d.add(category, "%s %d: %s", itemName, index, change)
+		}
+	}
+
+	if numChanged > maxDiffDetails {
+		d.add(category, "... and %d more changed %s(s)", numChanged-maxDiffDetails, itemName)
+	}
+}
+
+// Compare two compiled scenes and report their differences. As the scene
+// compiler does not preserve the names of the original scene assets, list
+// entries (e.g. material nodes or mesh instances) are matched by their index.
+// Triangles are matched by their vertex positions so that geometry changes
+// are detected even if the BVH builder reorders the primitives.
+func Diff(a, b *Scene) *SceneDiff {
+	d := &SceneDiff{}
+
+	if a.LayoutVersion != b.LayoutVersion {
+		d.add(DiffLayout, "layout version changed from %d to %d", a.LayoutVersion, b.LayoutVersion)
+	}
+
+	diffGeometry(d, a, b)
+	diffMaterials(d, a, b)
+	diffLights(d, a, b)
+	diffInstances(d, a, b)
+	diffTextures(d, a, b)
+	diffVolumes(d, a, b)
+	diffCameras(d, a, b)
+	diffBackground(d, a, b)
+
+	return d
+}
+
+// The vertex positions of a triangle.
+type triangleKey [3]types.Vec4
+
+// The attributes of a triangle that are compared when it appears in both scenes.
+type triangleAttrs struct {
+	material uint32
+	normals  [3]types.Vec4
+	tangents [3]types.Vec4
+	uvs      [3]types.Vec2
+}
+
+// Get the number of triangles whose vertices are available.
+func (sc *Scene) numTriangles() int {
+	count := len(sc.MaterialIndex)
+	if len(sc.VertexList)/3 < count {
+		count = len(sc.VertexList) / 3
+	}
+	return count
+}
+
+func (sc *Scene) triangle(index int) (triangleKey, triangleAttrs) {
+	var key triangleKey
+	attrs := triangleAttrs{material: sc.MaterialIndex[index]}
+	copy(key[:], sc.VertexList[3*index:])
+	if 3*index+3 <= len(sc.NormalList) {
+		copy(attrs.normals[:], sc.NormalList[3*index:])
+	}
+	if 3*index+3 <= len(sc.TangentList) {
+		copy(attrs.tangents[:], sc.TangentList[3*index:])
+	}
+	if 3*index+3 <= len(sc.UvList) {
+		copy(attrs.uvs[:], sc.UvList[3*index:])
+	}
+	return key, attrs
+}
+
+func diffGeometry(d *SceneDiff, a, b *Scene) {
+	numA, numB := a.numTriangles(), b.numTriangles()
+	if numA != numB {
+		d.add(DiffGeometry, "triangle count changed from %d to %d (%+d)", numA, numB, numB-numA)
+	}
+
+	// Build a multiset of the triangles in a and match it against b
+	trianglesA := make(map[triangleKey][]triangleAttrs, numA)
+	for index := 0; index < numA; index++ {
+		key, attrs := a.triangle(index)
+		trianglesA[key] = append(trianglesA[key], attrs)
+	}
+
+	added, materialChanges, attrChanges := 0, 0, 0
+	for index := 0; index < numB; index++ {
+		key, attrsB := b.triangle(index)
+		matches := trianglesA[key]
+		if len(matches) == 0 {
+			added++
+			continue
+		}
+
+		attrsA := matches[len(matches)-1]
+		trianglesA[key] = matches[:len(matches)-1]
+		if attrsA.material != attrsB.material {
+			materialChanges++
+		}
+		if attrsA.normals != attrsB.normals || attrsA.tangents != attrsB.tangents || attrsA.uvs != attrsB.uvs {
+			attrChanges++
+		}
+	}
+
+	removed := 0
+	for _, matches := range trianglesA {
+		removed += len(matches)
+	}
+
+	if added > 0 || removed > 0 {
+		d.add(DiffGeometry, "%d triangle(s) added and %d triangle(s) removed", added, removed)
+	}
+	if materialChanges > 0 {
+		d.add(DiffGeometry, "%d triangle(s) use a different material node", materialChanges)
+	}
+	if attrChanges > 0 {
+		d.add(DiffGeometry, "%d triangle(s) have different normals, tangents or uvs", attrChanges)
+	}
+	if len(a.BvhNodeList) != len(b.BvhNodeList) {
+		d.add(DiffGeometry, "BVH node count changed from %d to %d", len(a.BvhNodeList), len(b.BvhNodeList))
+	}
+}
+
+// Get a printable name for a material node type.
+func materialNodeTypeName(nodeType int32) string {
+	switch {
+	case material.IsBxdfType(uint32(nodeType)):
+		return material.BxdfType(nodeType).String()
+	case nodeType == int32(material.OpMix):
+		return "mix"
+	case nodeType == int32(material.OpMixMap):
+		return "mixMap"
+	case nodeType == int32(material.OpBumpMap):
+		return "bumpMap"
+	case nodeType == int32(material.OpNormalMap):
+		return "normalMap"
+	case nodeType == int32(material.OpDisperse):
+		return "disperse"
+	case nodeType == int32(material.OpMixCurvature):
+		return "mixCurvature"
+	case nodeType == int32(material.OpMixOcclusion):
+		return "mixOcclusion"
+	case nodeType == int32(material.OpAlphaCutout):
+		return "alphaCutout"
+	case nodeType == int32(material.OpMedium):
+		return "medium"
+	}
+	return fmt.Sprintf("type %d", nodeType)
+}
+
+func diffMaterials(d *SceneDiff, a, b *Scene) {
+	d.diffList(DiffMaterials, "material node", len(a.MaterialNodeList), len(b.MaterialNodeList), func(index int) []string {
+		nodeA, nodeB := a.MaterialNodeList[index], b.MaterialNodeList[index]
+		if nodeA.Union1[0] != nodeB.Union1[0] {
+			return []string{fmt.Sprintf("type changed from %s to %s", materialNodeTypeName(nodeA.Union1[0]), materialNodeTypeName(nodeB.Union1[0]))}
+		}
+
+		var changed []string
+		if nodeA.Union1 != nodeB.Union1 || nodeA.Union5 != nodeB.Union5 {
+			changed = append(changed, "child nodes or textures")
+		}
+		if nodeA.Union2 != nodeB.Union2 || nodeA.Union3 != nodeB.Union3 {
+			changed = append(changed, "colors or weights")
+		}
+		if nodeA.Union4 != nodeB.Union4 {
+			changed = append(changed, "IORs, roughness or scale")
+		}
+
+		var changes []string
+		for _, what := range changed {
+			changes = append(changes, fmt.Sprintf("%s %s changed", materialNodeTypeName(nodeA.Union1[0]), what))
+		}
+		return changes
+	})
+}
+
+// Get the world-space position of an emissive primitive. For area lights
+// this is the centroid of the emissive triangle.
+func (sc *Scene) emissivePosition(emissive EmissivePrimitive) types.Vec3 {
+	var pos types.Vec3
+	if emissive.Type == AreaLight && int(emissive.PrimitiveIndex)*3+3 <= len(sc.VertexList) {
+		for vertex := 0; vertex < 3; vertex++ {
+			pos = pos.Add(sc.VertexList[3*int(emissive.PrimitiveIndex)+vertex].Vec3())
+		}
+		pos = pos.Mul(1.0 / 3.0)
+	}
+	return emissive.Transform.Mul4x1(pos.Vec4(1)).Vec3()
+}
+
+func diffLights(d *SceneDiff, a, b *Scene) {
+	d.diffList(DiffLights, "emissive", len(a.EmissivePrimitives), len(b.EmissivePrimitives), func(index int) []string {
+		emA, emB := a.EmissivePrimitives[index], b.EmissivePrimitives[index]
+		if emA.Type != emB.Type {
+			return []string{fmt.Sprintf("type changed from %d to %d", emA.Type, emB.Type)}
+		}
+
+		var changes []string
+		if emA.Type == AreaLight {
+			if posA, posB := a.emissivePosition(emA), b.emissivePosition(emB); posA != posB {
+				changes = append(changes, fmt.Sprintf("moved from %v to %v", posA, posB))
+			}
+			if emA.Area != emB.Area {
+				changes = append(changes, fmt.Sprintf("area changed from %g to %g", emA.Area, emB.Area))
+			}
+		}
+		if emA.MaterialNodeIndex != emB.MaterialNodeIndex {
+			changes = append(changes, fmt.Sprintf("material node changed from %d to %d", emA.MaterialNodeIndex, emB.MaterialNodeIndex))
+		}
+		return changes
+	})
+}
+
+func diffInstances(d *SceneDiff, a, b *Scene) {
+	d.diffList(DiffInstances, "mesh instance", len(a.MeshInstanceList), len(b.MeshInstanceList), func(index int) []string {
+		miA, miB := a.MeshInstanceList[index], b.MeshInstanceList[index]
+
+		var changes []string
+		if miA.MeshIndex != miB.MeshIndex {
+			changes = append(changes, fmt.Sprintf("mesh changed from %d to %d", miA.MeshIndex, miB.MeshIndex))
+		}
+		if miA.Transform != miB.Transform {
+			posA := miA.MeshToWorld().Mul4x1(types.Vec4{0, 0, 0, 1}).Vec3()
+			posB := miB.MeshToWorld().Mul4x1(types.Vec4{0, 0, 0, 1}).Vec3()
+			if posA != posB {
+				changes = append(changes, fmt.Sprintf("moved from %v to %v", posA, posB))
+			} else {
+				changes = append(changes, "rotation or scale changed")
+			}
+		}
+		if miA.Flags != miB.Flags {
+			changes = append(changes, fmt.Sprintf("flags changed from %d to %d", miA.Flags, miB.Flags))
+		}
+		if miA.RayBias != miB.RayBias {
+			changes = append(changes, fmt.Sprintf("ray bias changed from %g to %g", miA.RayBias, miB.RayBias))
+		}
+		return changes
+	})
+}
+
+// Get the data of the top mip level of a texture or nil if it is out of range.
+func (sc *Scene) textureData(meta TextureMetadata) []byte {
+	start := int(meta.DataOffset)
+	end := start + int(meta.Width*meta.Height)*meta.Format.TexelSize()
+	if end > len(sc.TextureData) {
+		return nil
+	}
+	return sc.TextureData[start:end]
+}
+
+func diffTextures(d *SceneDiff, a, b *Scene) {
+	d.diffList(DiffTextures, "texture", len(a.TextureMetadata), len(b.TextureMetadata), func(index int) []string {
+		metaA, metaB := a.TextureMetadata[index], b.TextureMetadata[index]
+		if metaA.Format != metaB.Format || metaA.Width != metaB.Width || metaA.Height != metaB.Height {
+			return []string{fmt.Sprintf(
+				"changed from %dx%d (format %d) to %dx%d (format %d)",
+				metaA.Width, metaA.Height, metaA.Format, metaB.Width, metaB.Height, metaB.Format,
+			)}
+		}
+
+		var changes []string
+		if !bytes.Equal(a.textureData(metaA), b.textureData(metaB)) {
+			changes = append(changes, "texel data changed")
+		}
+		if metaA.MipLevels != metaB.MipLevels {
+			changes = append(changes, fmt.Sprintf("mip levels changed from %d to %d", metaA.MipLevels, metaB.MipLevels))
+		}
+		return changes
+	})
+}
+
+func diffVolumes(d *SceneDiff, a, b *Scene) {
+	d.diffList(DiffMaterials, "volume grid", len(a.VolumeGrids), len(b.VolumeGrids), func(index int) []string {
+		gridA, gridB := a.VolumeGrids[index], b.VolumeGrids[index]
+		if gridA.Dims != gridB.Dims {
+			return []string{fmt.Sprintf("changed from %v to %v voxels", gridA.Dims, gridB.Dims)}
+		}
+
+		var changes []string
+		if !equalFloats(a.volumeData(gridA), b.volumeData(gridB)) {
+			changes = append(changes, "voxel data changed")
+		}
+		if gridA.Transform != gridB.Transform {
+			changes = append(changes, "transform changed")
+		}
+		if gridA.Scale != gridB.Scale {
+			changes = append(changes, fmt.Sprintf("scale changed from %v to %v", gridA.Scale, gridB.Scale))
+		}
+		return changes
+	})
+}
+
+// Get the voxel data of a volume grid or nil if it is out of range.
+func (sc *Scene) volumeData(grid VolumeGrid) []float32 {
+	start := int(grid.DataOffset)
+	end := start + int(grid.Dims[0]*grid.Dims[1]*grid.Dims[2])
+	if end > len(sc.VolumeData) {
+		return nil
+	}
+	return sc.VolumeData[start:end]
+}
+
+func equalFloats(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index] != b[index] {
+			return false
+		}
+	}
+	return true
+}
+
+func diffCameras(d *SceneDiff, a, b *Scene) {
+	if len(a.Cameras) != len(b.Cameras) {
+		d.add(DiffCamera, "camera count changed from %d to %d", len(a.Cameras), len(b.Cameras))
+	}
+
+	camA, camB := a.Camera, b.Camera
+	switch {
+	case camA == nil && camB == nil:
+		return
+	case camA == nil || camB == nil:
+		d.add(DiffCamera, "active camera added or removed")
+		return
+	case camA.Name != camB.Name:
+		d.add(DiffCamera, "active camera changed from %q to %q", camA.Name, camB.Name)
+	}
+
+	if camA.Position != camB.Position {
+		d.add(DiffCamera, "camera position changed from %v to %v", camA.Position, camB.Position)
+	}
+	if camA.LookAt != camB.LookAt || camA.Up != camB.Up {
+		d.add(DiffCamera, "camera target changed from %v to %v", camA.LookAt, camB.LookAt)
+	}
+	if camA.FOV != camB.FOV {
+		d.add(DiffCamera, "camera FOV changed from %g to %g", camA.FOV, camB.FOV)
+	}
+	if camA.ApertureRadius != camB.ApertureRadius || camA.FocusDistance != camB.FocusDistance {
+		d.add(
+			DiffCamera, "camera lens changed from aperture %g/focus %g to aperture %g/focus %g",
+			camA.ApertureRadius, camA.FocusDistance, camB.ApertureRadius, camB.FocusDistance,
+		)
+	}
+}
+
+func diffBackground(d *SceneDiff, a, b *Scene) {
+	if a.SceneDiffuseMatIndex != b.SceneDiffuseMatIndex {
+		d.add(DiffBackground, "diffuse material node changed from %d to %d", a.SceneDiffuseMatIndex, b.SceneDiffuseMatIndex)
+	}
+	if a.SceneEmissiveMatIndex != b.SceneEmissiveMatIndex {
+		d.add(DiffBackground, "emissive material node changed from %d to %d", a.SceneEmissiveMatIndex, b.SceneEmissiveMatIndex)
+	}
+	if a.SceneBackplateMatIndex != b.SceneBackplateMatIndex {
+		d.add(DiffBackground, "backplate material node changed from %d to %d", a.SceneBackplateMatIndex, b.SceneBackplateMatIndex)
+	}
+	if a.SceneMediumMatIndex != b.SceneMediumMatIndex {
+		d.add(DiffBackground, "medium material node changed from %d to %d", a.SceneMediumMatIndex, b.SceneMediumMatIndex)
+	}
+}
@@ -0,0 +1,148 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+func diffTestScene() *Scene {
+	sc := inspectTestScene()
+	sc.VertexList = append(sc.VertexList, types.Vec4{0, 2, 0, 0}, types.Vec4{1, 2, 0, 0}, types.Vec4{0, 3, 0, 0})
+	sc.MaterialIndex = append(sc.MaterialIndex, 1)
+
+	var diffuse, emissive MaterialNode
+	diffuse.Union1[0] = int32(material.BxdfDiffuse)
+	diffuse.Union2 = types.Vec4{0.8, 0.8, 0.8, 0}
+	emissive.Union1[0] = int32(material.BxdfEmissive)
+	sc.MaterialNodeList = []MaterialNode{diffuse, emissive}
+	sc.EmissivePrimitives = []EmissivePrimitive{
+		{Transform: types.Ident4(), PrimitiveIndex: 1, MaterialNodeIndex: 1, Area: 0.5},
+	}
+	sc.SceneDiffuseMatIndex = -1
+	sc.SceneEmissiveMatIndex = -1
+	sc.SceneBackplateMatIndex = -1
+	sc.SceneMediumMatIndex = -1
+	sc.Camera = &Camera{Name: "default", Position: types.Vec3{0, 0, 5}, FOV: 45}
+	sc.Cameras = []*Camera{sc.Camera}
+	return sc
+}
+
+func TestDiffIdenticalScenes(t *testing.T) {
+	d := Diff(diffTestScene(), diffTestScene())
+	if !d.Empty() {
+		t.Fatalf("expected identical scenes to have no differences; got:\n%s", d)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	specs := []struct {
+		modify func(sc *Scene)
+		exp    []DiffEntry
+	}{
+		{
+			func(sc *Scene) { sc.MaterialNodeList[0].Union2 = types.Vec4{1, 0, 0, 0} },
+			[]DiffEntry{{DiffMaterials, "material node 0: diffuse colors or weights changed"}},
+		},
+		{
+			func(sc *Scene) { sc.MaterialNodeList[0].Union1[0] = int32(material.BxdfConductor) },
+			[]DiffEntry{{DiffMaterials, "material node 0: type changed from diffuse to conductor"}},
+		},
+		{
+			func(sc *Scene) {
+				sc.VertexList = append(sc.VertexList, types.Vec4{5, 5, 5, 0}, types.Vec4{6, 5, 5, 0}, types.Vec4{5, 6, 5, 0})
+				sc.MaterialIndex = append(sc.MaterialIndex, 0)
+			},
+			[]DiffEntry{
+				{DiffGeometry, "triangle count changed from 2 to 3 (+1)"},
+				{DiffGeometry, "1 triangle(s) added and 0 triangle(s) removed"},
+			},
+		},
+		{
+			// Reordering triangles (e.g. by the BVH builder) is not a change
+			func(sc *Scene) {
+				sc.VertexList[0], sc.VertexList[1], sc.VertexList[2], sc.VertexList[3], sc.VertexList[4], sc.VertexList[5] = sc.VertexList[3], sc.VertexList[4], sc.VertexList[5], sc.VertexList[0], sc.VertexList[1], sc.VertexList[2]
+				sc.MaterialIndex[0], sc.MaterialIndex[1] = sc.MaterialIndex[1], sc.MaterialIndex[0]
+				sc.EmissivePrimitives[0].PrimitiveIndex = 0
+			},
+			nil,
+		},
+		{
+			func(sc *Scene) { sc.MaterialIndex[0] = 1 },
+			[]DiffEntry{{DiffGeometry, "1 triangle(s) use a different material node"}},
+		},
+		{
+			func(sc *Scene) { sc.EmissivePrimitives[0].Transform = types.Translate4(types.Vec3{0, 3, 0}) },
+			[]DiffEntry{{DiffLights, "emissive 0: moved from " + types.Vec3{1.0 / 3.0, 7.0 / 3.0, 0}.String() + " to " + types.Vec3{1.0 / 3.0, 16.0 / 3.0, 0}.String()}},
+		},
+		{
+			func(sc *Scene) { sc.MeshInstanceList[1].Transform = types.Translate4(types.Vec3{-4, 0, 0}) },
+			[]DiffEntry{{DiffInstances, "mesh instance 1: moved from " + types.Vec3{2, 0, 0}.String() + " to " + types.Vec3{4, 0, 0}.String()}},
+		},
+		{
+			func(sc *Scene) { sc.MeshInstanceList[0].Flags = CameraInvisible },
+			[]DiffEntry{{DiffInstances, "mesh instance 0: flags changed from 0 to 1"}},
+		},
+		{
+			func(sc *Scene) {
+				sc.Camera = &Camera{Name: "closeup", Position: types.Vec3{0, 0, 2}, FOV: 45}
+				sc.Cameras = append(sc.Cameras, sc.Camera)
+			},
+			[]DiffEntry{
+				{DiffCamera, "camera count changed from 1 to 2"},
+				{DiffCamera, "active camera changed from \"default\" to \"closeup\""},
+				{DiffCamera, "camera position changed from " + types.Vec3{0, 0, 5}.String() + " to " + types.Vec3{0, 0, 2}.String()},
+			},
+		},
+		{
+			func(sc *Scene) { sc.SceneBackplateMatIndex = 0 },
+			[]DiffEntry{{DiffBackground, "backplate material node changed from -1 to 0"}},
+		},
+		{
+			func(sc *Scene) { sc.SceneMediumMatIndex = 1 },
+			[]DiffEntry{{DiffBackground, "medium material node changed from -1 to 1"}},
+		},
+	}
+
+	for index, spec := range specs {
+		b := diffTestScene()
+		spec.modify(b)
+
+		d := Diff(diffTestScene(), b)
+		if !reflect.DeepEqual(d.Entries, spec.exp) {
+			t.Errorf("[spec %d] expected diff entries:\n%v\ngot:\n%v", index, spec.exp, d.Entries)
+		}
+	}
+}
+
+func TestDiffCapsListDetails(t *testing.T) {
+	a, b := diffTestScene(), diffTestScene()
+	for index := 0; index < maxDiffDetails+3; index++ {
+		a.TextureMetadata = append(a.TextureMetadata, TextureMetadata{Width: 1, Height: 1})
+		b.TextureMetadata = append(b.TextureMetadata, TextureMetadata{Width: 2, Height: 1})
+	}
+
+	d := Diff(a, b)
+	if len(d.Entries) != maxDiffDetails+1 {
+		t.Fatalf("expected %d diff entries; got %d", maxDiffDetails+1, len(d.Entries))
+	}
+	if exp, got := "... and 3 more changed texture(s)", d.Entries[maxDiffDetails].Message; got != exp {
+		t.Fatalf("expected last entry to be %q; got %q", exp, got)
+	}
+
+	var buf bytes.Buffer
+	if err := d.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded SceneDiff
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Entries, d.Entries) {
+		t.Fatalf("expected JSON output to round-trip; got %s", buf.String())
+	}
+}
@@ -0,0 +1,203 @@
+package scene
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A piecewise-constant 2D distribution for importance sampling the
+// equirectangular radiance texture of the scene environment light. Each cell
+// covers a block of texels and its value is the total luminance of its texels
+// weighted by the sine of the cell polar angle so that the distribution
+// accounts for the compression of the rows near the poles.
+//
+// The distribution is sampled by first selecting a row using the marginal
+// CDF and then selecting a column using the conditional CDF of that row.
+type EnvMapDistribution struct {
+	// The material node for the environment light radiance.
+	MaterialNodeIndex uint32
+
+	// The distribution dimensions.
+	Width  uint32
+	Height uint32
+
+	// The marginal CDF of the rows (Height+1 entries) followed by the
+	// conditional CDF of each row (Width+1 entries per row).
+	CDF []float32
+}
+
+// Build an importance sampling distribution for the scene environment light.
+// The distribution resolution matches the radiance texture but is reduced
+// by an integer factor if the texture is wider than maxWidth. This method
+// returns nil if the scene does not define an environment light with a
+// radiance texture or if the texture is black.
+func (sc *Scene) EnvMapDistribution(maxWidth uint32) *EnvMapDistribution {
+	matNodeIndex := -1
+	for _, emissive := range sc.EmissivePrimitives {
+		if emissive.Type == EnvironmentLight {
+			matNodeIndex = int(emissive.MaterialNodeIndex)
+			break
+		}
+	}
+	if matNodeIndex < 0 || matNodeIndex >= len(sc.MaterialNodeList) {
+		return nil
+	}
+
+	texIndex := sc.MaterialNodeList[matNodeIndex].Union1[3]
+	if texIndex < 0 || int(texIndex) >= len(sc.TextureMetadata) {
+		return nil
+	}
+
+	meta := sc.TextureMetadata[texIndex]
+	if meta.Width == 0 || meta.Height == 0 {
+		return nil
+	}
+	if int(meta.DataOffset)+int(meta.Width*meta.Height)*meta.Format.TexelSize() > len(sc.TextureData) {
+		return nil
+	}
+
+	// Each distribution cell covers a block of factor x factor texels
+	factor := uint32(1)
+	if maxWidth > 0 && meta.Width > maxWidth {
+		factor = (meta.Width + maxWidth - 1) / maxWidth
+	}
+	dist := &EnvMapDistribution{
+		MaterialNodeIndex: uint32(matNodeIndex),
+		Width:             (meta.Width + factor - 1) / factor,
+		Height:            (meta.Height + factor - 1) / factor,
+	}
+
+	values := make([]float64, dist.Width*dist.Height)
+	for ty := uint32(0); ty < meta.Height; ty++ {
+		for tx := uint32(0); tx < meta.Width; tx++ {
+			values[(ty/factor)*dist.Width+tx/factor] += texelLuminance(sc.TextureData, meta, tx, ty)
+		}
+	}
+
+	dist.CDF = make([]float32, dist.Height+1+dist.Height*(dist.Width+1))
+	rowSums := make([]float64, dist.Height)
+	var total float64
+	for y := uint32(0); y < dist.Height; y++ {
+		sinTheta := math.Sin(math.Pi * (float64(y) + 0.5) / float64(dist.Height))
+		row := values[y*dist.Width : (y+1)*dist.Width]
+		for x := range row {
+			row[x] *= sinTheta
+			rowSums[y] += row[x]
+		}
+		total += rowSums[y]
+
+		buildCDF(dist.CDF[dist.Height+1+y*(dist.Width+1):dist.Height+1+(y+1)*(dist.Width+1)], row, rowSums[y])
+	}
+
+	if total <= 0 || math.IsInf(total, 0) || math.IsNaN(total) {
+		return nil
+	}
+
+	buildCDF(dist.CDF[:dist.Height+1], rowSums, total)
+	return dist
+}
+
+// Sample the distribution using two uniform random numbers in the [0, 1)
+// range. It returns the sampled uv coordinates and their pdf with respect
+// to the uv area. This method mirrors the sampler used by the opencl kernels.
+func (d *EnvMapDistribution) Sample(r0, r1 float32) (uv types.Vec2, pdf float32) {
+	marginal := d.CDF[:d.Height+1]
+	y := findCDFInterval(marginal, r1)
+	conditional := d.conditionalCDF(y)
+	x := findCDFInterval(conditional, r0)
+
+	uv[0] = (float32(x) + cdfOffset(conditional, x, r0)) / float32(d.Width)
+	uv[1] = (float32(y) + cdfOffset(marginal, y, r1)) / float32(d.Height)
+	return uv, d.cellPdf(x, y)
+}
+
+// Get the pdf for sampling the given uv coordinates with respect to the uv area.
+func (d *EnvMapDistribution) Pdf(uv types.Vec2) float32 {
+	x := uint32(math.Min(math.Max(float64(uv[0])*float64(d.Width), 0), float64(d.Width-1)))
+	y := uint32(math.Min(math.Max(float64(uv[1])*float64(d.Height), 0), float64(d.Height-1)))
+	return d.cellPdf(x, y)
+}
+
+// Get the pdf of a distribution cell with respect to the uv area.
+func (d *EnvMapDistribution) cellPdf(x, y uint32) float32 {
+	conditional := d.conditionalCDF(y)
+	return (d.CDF[y+1] - d.CDF[y]) * (conditional[x+1] - conditional[x]) * float32(d.Width*d.Height)
+}
+
+// Get the conditional CDF for a distribution row.
+func (d *EnvMapDistribution) conditionalCDF(y uint32) []float32 {
+	offset := d.Height + 1 + y*(d.Width+1)
+	return d.CDF[offset : offset+d.Width+1]
+}
+
+// Populate a CDF with len(values)+1 entries. If all values are zero, a
+// uniform CDF is generated.
+func buildCDF(cdf []float32, values []float64, sum float64) {
+	var acc float64
+	for index, value := range values {
+		if sum > 0 {
+			acc += value / sum
+		} else {
+			acc += 1 / float64(len(values))
+		}
+		cdf[index+1] = float32(acc)
+	}
+	cdf[len(values)] = 1
+}
+
+// Find the index of the CDF interval that contains u using binary search.
+func findCDFInterval(cdf []float32, u float32) uint32 {
+	lo, hi := 0, len(cdf)-1
+	for lo+1 < hi {
+		mid := (lo + hi) >> 1
+		if cdf[mid] <= u {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return uint32(lo)
+}
+
+// Get the relative offset of u within a CDF interval.
+func cdfOffset(cdf []float32, index uint32, u float32) float32 {
+	width := cdf[index+1] - cdf[index]
+	if width <= 0 {
+		return 0.5
+	}
+	return (u - cdf[index]) / width
+}
+
+// Read the luminance of a texel from the top mip level of a texture.
+func texelLuminance(data []byte, meta TextureMetadata, x, y uint32) float64 {
+	offset := int(meta.DataOffset) + int(y*meta.Width+x)*meta.Format.TexelSize()
+	var r, g, b float64
+	switch meta.Format {
+	case texture.Luminance8, texture.SLuminance8:
+		r = float64(data[offset]) / 255.0
+		g, b = r, r
+	case texture.Luminance32F:
+		r = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+		g, b = r, r
+	case texture.Rgba8, texture.Srgba8:
+		r, g, b = float64(data[offset])/255.0, float64(data[offset+1])/255.0, float64(data[offset+2])/255.0
+	case texture.Rgba32F:
+		r = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+		g = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset+4:])))
+		b = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[offset+8:])))
+	}
+
+	if meta.Format.ColorSpace() == texture.ColorSpaceSRGB {
+		r, g, b = texture.SRGBToLinear(r), texture.SRGBToLinear(g), texture.SRGBToLinear(b)
+	}
+
+	// Negative or non-finite texels would break the CDF
+	lum := 0.2126*r + 0.7152*g + 0.0722*b
+	if lum < 0 || math.IsNaN(lum) || math.IsInf(lum, 0) {
+		return 0
+	}
+	return lum
+}
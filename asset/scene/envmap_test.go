@@ -0,0 +1,127 @@
+package scene
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/texure"
+)
+
+// Create a scene with an 8x4 RGBA32F environment map that is dimly lit
+// except for a bright texel.
+func envMapTestScene(sunX, sunY int) *Scene {
+	const w, h = 8, 4
+	data := make([]byte, w*h*16)
+	for index := 0; index < w*h; index++ {
+		value := float32(0.1)
+		if index == sunY*w+sunX {
+			value = 1000
+		}
+		for channel := 0; channel < 3; channel++ {
+			binary.LittleEndian.PutUint32(data[index*16+channel*4:], math.Float32bits(value))
+		}
+	}
+
+	var envMat MaterialNode
+	envMat.Union1[3] = 0
+	return &Scene{
+		MaterialNodeList:   []MaterialNode{{}, envMat},
+		EmissivePrimitives: []EmissivePrimitive{{MaterialNodeIndex: 1, Type: EnvironmentLight}},
+		TextureMetadata:    []TextureMetadata{{Format: texture.Rgba32F, Width: w, Height: h, MipLevels: 1}},
+		TextureData:        data,
+	}
+}
+
+func TestEnvMapDistribution(t *testing.T) {
+	sc := envMapTestScene(5, 1)
+	dist := sc.EnvMapDistribution(0)
+	if dist == nil {
+		t.Fatal("expected a distribution to be generated")
+	}
+
+	if dist.Width != 8 || dist.Height != 4 || dist.MaterialNodeIndex != 1 {
+		t.Fatalf("expected an 8x4 distribution for material node 1; got %dx%d for node %d", dist.Width, dist.Height, dist.MaterialNodeIndex)
+	}
+	if len(dist.CDF) != 5+4*9 {
+		t.Fatalf("expected CDF to contain %d entries; got %d", 5+4*9, len(dist.CDF))
+	}
+
+	// The cell pdfs should integrate to 1 over the uv area
+	var integral float32
+	for y := uint32(0); y < dist.Height; y++ {
+		for x := uint32(0); x < dist.Width; x++ {
+			integral += dist.cellPdf(x, y) / float32(dist.Width*dist.Height)
+		}
+	}
+	if math.Abs(float64(integral-1)) > 1e-4 {
+		t.Fatalf("expected pdf to integrate to 1; got %f", integral)
+	}
+
+	// Most samples should land on the bright texel
+	const numSamples = 64
+	sunSamples := 0
+	for i := 0; i < numSamples; i++ {
+		for j := 0; j < numSamples; j++ {
+			r0, r1 := (float32(i)+0.5)/numSamples, (float32(j)+0.5)/numSamples
+			uv, pdf := dist.Sample(r0, r1)
+			if uv[0] < 0 || uv[0] >= 1 || uv[1] < 0 || uv[1] >= 1 {
+				t.Fatalf("expected sample for (%f, %f) to be in the [0, 1) range; got %v", r0, r1, uv)
+			}
+			if exp := dist.Pdf(uv); math.Abs(float64(exp-pdf)) > 1e-3*float64(exp) {
+				t.Fatalf("expected sample pdf for %v to be %f; got %f", uv, exp, pdf)
+			}
+			if int(uv[0]*8) == 5 && int(uv[1]*4) == 1 {
+				sunSamples++
+			}
+		}
+	}
+
+	sunWeight := 1000 * math.Sin(1.5*math.Pi/4)
+	var totalWeight float64
+	for y := 0; y < 4; y++ {
+		totalWeight += 8 * 0.1 * math.Sin((float64(y)+0.5)*math.Pi/4)
+	}
+	totalWeight += sunWeight - 0.1*math.Sin(1.5*math.Pi/4)
+	expFraction := sunWeight / totalWeight
+	if gotFraction := float64(sunSamples) / (numSamples * numSamples); math.Abs(gotFraction-expFraction) > 0.01 {
+		t.Fatalf("expected %.3f of the samples to hit the bright texel; got %.3f", expFraction, gotFraction)
+	}
+}
+
+func TestEnvMapDistributionDownscale(t *testing.T) {
+	dist := envMapTestScene(5, 1).EnvMapDistribution(4)
+	if dist == nil {
+		t.Fatal("expected a distribution to be generated")
+	}
+
+	if dist.Width != 4 || dist.Height != 2 {
+		t.Fatalf("expected a 4x2 distribution; got %dx%d", dist.Width, dist.Height)
+	}
+
+	// The bright texel is mapped to cell (2, 0)
+	if pdf := dist.cellPdf(2, 0); pdf < 4 {
+		t.Fatalf("expected the pdf of the cell containing the bright texel to be at least 4; got %f", pdf)
+	}
+}
+
+func TestEnvMapDistributionWithoutTexture(t *testing.T) {
+	specs := []func(sc *Scene){
+		func(sc *Scene) { sc.EmissivePrimitives[0].Type = AreaLight },
+		func(sc *Scene) { sc.MaterialNodeList[1].Union1[3] = -1 },
+		func(sc *Scene) { sc.TextureData = sc.TextureData[:16] },
+		func(sc *Scene) {
+			for index := range sc.TextureData {
+				sc.TextureData[index] = 0
+			}
+		},
+	}
+
+	for index, spec := range specs {
+		sc := envMapTestScene(0, 0)
+		spec(sc)
+		if dist := sc.EnvMapDistribution(0); dist != nil {
+			t.Errorf("[spec %d] expected no distribution to be generated", index)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package scene
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Information about a BVH node. Exactly one of Children, MeshInstance or
+// FirstPrimitive is set depending on the node type.
+type BvhNodeInfo struct {
+	Index uint32     `json:"index"`
+	Depth int        `json:"depth"`
+	Min   types.Vec3 `json:"min"`
+	Max   types.Vec3 `json:"max"`
+
+	// The left and right child indices of inner nodes.
+	Children []uint32 `json:"children,omitempty"`
+
+	// The mesh instance index of top-level BVH leafs.
+	MeshInstance *uint32 `json:"meshInstance,omitempty"`
+
+	// The primitive range of mesh BVH leafs.
+	FirstPrimitive *uint32 `json:"firstPrimitive,omitempty"`
+	PrimitiveCount uint32  `json:"primitiveCount,omitempty"`
+}
+
+// Summary statistics for a BVH tree.
+type BvhTreeStats struct {
+	Nodes             int     `json:"nodes"`
+	Leaves            int     `json:"leaves"`
+	MaxDepth          int     `json:"maxDepth"`
+	MaxLeafPrimitives int     `json:"maxLeafPrimitives"`
+	AvgLeafPrimitives float32 `json:"avgLeafPrimitives"`
+
+	// The number of nodes with an empty, non-finite or inverted bounding
+	// box or with out of range references.
+	InvalidNodes int `json:"invalidNodes"`
+}
+
+// The nodes of the top-level scene BVH or a mesh BVH in breadth-first order.
+type BvhTreeInfo struct {
+	Name string `json:"name"`
+	Root uint32 `json:"root"`
+
+	// The mesh index and the mesh instances that use the tree. Not set for
+	// the top-level scene BVH.
+	Mesh      *uint32  `json:"mesh,omitempty"`
+	Instances []uint32 `json:"instances,omitempty"`
+
+	Stats BvhTreeStats  `json:"stats"`
+	Nodes []BvhNodeInfo `json:"nodes"`
+}
+
+// The result of inspecting the BVH trees of a scene.
+type BvhInspection struct {
+	Trees []BvhTreeInfo `json:"trees"`
+
+	// Problems detected while walking the trees.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// Write the inspection results as indented JSON.
+func (in *BvhInspection) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(in, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Walk the top-level scene BVH and the BVH of each mesh referenced by a mesh
+// instance and collect the node information. Broken references (e.g. child
+// indices that are out of range or point back to a visited node) are
+// reported as issues instead of being followed.
+func (sc *Scene) InspectBvh() *BvhInspection {
+	in := &BvhInspection{}
+	if len(sc.BvhNodeList) == 0 {
+		in.Issues = append(in.Issues, "scene does not define any BVH nodes")
+		return in
+	}
+
+	in.Trees = append(in.Trees, sc.inspectBvhTree(in, "scene", 0, true))
+
+	meshTrees := make(map[uint32]int)
+	for index, mi := range sc.MeshInstanceList {
+		treeIndex, exists := meshTrees[mi.MeshIndex]
+		if !exists {
+			if int(mi.BvhRoot) >= len(sc.BvhNodeList) {
+				in.Issues = append(in.Issues, fmt.Sprintf("mesh instance %d: BVH root %d out of range", index, mi.BvhRoot))
+				continue
+			}
+
+			meshIndex := mi.MeshIndex
+			tree := sc.inspectBvhTree(in, fmt.Sprintf("mesh %d", meshIndex), mi.BvhRoot, false)
+			tree.Mesh = &meshIndex
+			treeIndex = len(in.Trees)
+			meshTrees[meshIndex] = treeIndex
+			in.Trees = append(in.Trees, tree)
+		}
+
+		in.Trees[treeIndex].Instances = append(in.Trees[treeIndex].Instances, uint32(index))
+	}
+
+	return in
+}
+
+// Walk a BVH tree in breadth-first order.
+func (sc *Scene) inspectBvhTree(in *BvhInspection, name string, root uint32, topLevel bool) BvhTreeInfo {
+	tree := BvhTreeInfo{Name: name, Root: root}
+	numPrims := uint32(len(sc.MaterialIndex))
+	totalLeafPrims := 0
+
+	type workItem struct {
+		index uint32
+		depth int
+	}
+	visited := make(map[uint32]bool)
+	queue := []workItem{{root, 0}}
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+
+		node := sc.BvhNodeList[item.index]
+		info := BvhNodeInfo{Index: item.index, Depth: item.depth, Min: node.Min, Max: node.Max}
+		valid := validBBox(node.Min, node.Max)
+		if !valid {
+			in.Issues = append(in.Issues, fmt.Sprintf("%s BVH: node %d has an invalid bounding box %v - %v", name, item.index, node.Min, node.Max))
+		}
+
+		switch {
+		case node.LData > 0:
+			info.Children = []uint32{uint32(node.LData), uint32(node.RData)}
+			for _, child := range info.Children {
+				if int(child) >= len(sc.BvhNodeList) || visited[child] || child == root {
+					in.Issues = append(in.Issues, fmt.Sprintf("%s BVH: node %d references invalid or already visited child %d", name, item.index, child))
+					valid = false
+					continue
+				}
+				visited[child] = true
+				queue = append(queue, workItem{child, item.depth + 1})
+			}
+		case topLevel:
+			meshInstance := node.GetMeshIndex()
+			info.MeshInstance = &meshInstance
+			tree.Stats.Leaves++
+			if int(meshInstance) >= len(sc.MeshInstanceList) {
+				in.Issues = append(in.Issues, fmt.Sprintf("%s BVH: leaf %d references missing mesh instance %d", name, item.index, meshInstance))
+				valid = false
+			}
+		default:
+			first, count := node.GetPrimitives()
+			info.FirstPrimitive = &first
+			info.PrimitiveCount = count
+			tree.Stats.Leaves++
+			totalLeafPrims += int(count)
+			if int(count) > tree.Stats.MaxLeafPrimitives {
+				tree.Stats.MaxLeafPrimitives = int(count)
+			}
+			if count == 0 || first+count > numPrims || first+count < first {
+				in.Issues = append(in.Issues, fmt.Sprintf("%s BVH: leaf %d references invalid primitive range [%d, %d)", name, item.index, first, first+count))
+				valid = false
+			}
+		}
+
+		if !valid {
+			tree.Stats.InvalidNodes++
+		}
+		if item.depth > tree.Stats.MaxDepth {
+			tree.Stats.MaxDepth = item.depth
+		}
+		tree.Nodes = append(tree.Nodes, info)
+	}
+
+	tree.Stats.Nodes = len(tree.Nodes)
+	if !topLevel && tree.Stats.Leaves > 0 {
+		tree.Stats.AvgLeafPrimitives = float32(totalLeafPrims) / float32(tree.Stats.Leaves)
+	}
+	return tree
+}
+
+// Check that a bounding box is finite and not inverted.
+func validBBox(min, max types.Vec3) bool {
+	for axis := 0; axis < 3; axis++ {
+		if math.IsNaN(float64(min[axis])) || math.IsInf(float64(min[axis]), 0) ||
+			math.IsNaN(float64(max[axis])) || math.IsInf(float64(max[axis]), 0) ||
+			min[axis] > max[axis] {
+			return false
+		}
+	}
+	return true
+}
+
+// Options for exporting the scene geometry and BVH boxes as a wavefront OBJ.
+type OBJExportOptions struct {
+	// Skip the mesh instance triangles and only export the BVH boxes.
+	SkipGeometry bool
+
+	// Export the boxes of BVH nodes up to this depth. Set to a negative
+	// value to skip the boxes.
+	MaxBoxDepth int
+}
+
+// The vertex indices (relative to the first box corner) of the 12 box edges.
+var boxEdges = [12][2]int{
+	{0, 1}, {1, 3}, {3, 2}, {2, 0},
+	{4, 5}, {5, 7}, {7, 6}, {6, 4},
+	{0, 4}, {1, 5}, {2, 6}, {3, 7},
+}
+
+// Export the world-space triangles of each mesh instance and the boxes of the
+// inspected BVH nodes as a wavefront OBJ file. Each mesh instance is exported
+// as a separate object. Boxes are exported as line elements grouped by tree
+// and depth so they can be toggled independently in a viewer; mesh BVH boxes
+// are exported once for each instance that uses the mesh.
+func (sc *Scene) ExportOBJ(w io.Writer, in *BvhInspection, opts OBJExportOptions) error {
+	out := bufio.NewWriter(w)
+	numVertices := 0
+
+	fmt.Fprintln(out, "# polaris scene geometry and BVH export")
+	for _, tree := range in.Trees {
+		transforms := []types.Mat4{types.Ident4()}
+		names := []string{"scene"}
+		if tree.Mesh != nil {
+			transforms, names = transforms[:0], names[:0]
+			for _, instance := range tree.Instances {
+				transforms = append(transforms, sc.MeshInstanceList[instance].MeshToWorld())
+				names = append(names, fmt.Sprintf("instance_%d", instance))
+			}
+		}
+
+		for index, toWorld := range transforms {
+			if tree.Mesh != nil && !opts.SkipGeometry {
+				fmt.Fprintf(out, "o %s\n", names[index])
+				numVertices = sc.exportTriangles(out, tree, toWorld, numVertices)
+			}
+
+			lastDepth := -1
+			for _, node := range tree.Nodes {
+				if node.Depth > opts.MaxBoxDepth {
+					break
+				}
+				if node.Depth != lastDepth {
+					fmt.Fprintf(out, "g %s_bvh_depth_%d\n", names[index], node.Depth)
+					lastDepth = node.Depth
+				}
+				numVertices = exportBox(out, node.Min, node.Max, toWorld, numVertices)
+			}
+		}
+	}
+
+	return out.Flush()
+}
+
+// Export the triangles referenced by the leafs of a mesh BVH and return the
+// updated vertex count.
+func (sc *Scene) exportTriangles(out *bufio.Writer, tree BvhTreeInfo, toWorld types.Mat4, numVertices int) int {
+	for _, node := range tree.Nodes {
+		if node.FirstPrimitive == nil {
+			continue
+		}
+
+		first, count := int(*node.FirstPrimitive), int(node.PrimitiveCount)
+		if 3*(first+count) > len(sc.VertexList) {
+			continue
+		}
+		for prim := first; prim < first+count; prim++ {
+			for vertex := 0; vertex < 3; vertex++ {
+				v := toWorld.Mul4x1(sc.VertexList[3*prim+vertex].Vec3().Vec4(1))
+				fmt.Fprintf(out, "v %g %g %g\n", v[0], v[1], v[2])
+			}
+			fmt.Fprintf(out, "f %d %d %d\n", numVertices+1, numVertices+2, numVertices+3)
+			numVertices += 3
+		}
+	}
+	return numVertices
+}
+
+// Export the edges of a box and return the updated vertex count.
+func exportBox(out *bufio.Writer, min, max types.Vec3, toWorld types.Mat4, numVertices int) int {
+	for corner := 0; corner < 8; corner++ {
+		v := min
+		if corner&1 != 0 {
+			v[0] = max[0]
+		}
+		if corner&2 != 0 {
+			v[1] = max[1]
+		}
+		if corner&4 != 0 {
+			v[2] = max[2]
+		}
+
+		v = toWorld.Mul4x1(v.Vec4(1)).Vec3()
+		fmt.Fprintf(out, "v %g %g %g\n", v[0], v[1], v[2])
+	}
+
+	for _, edge := range boxEdges {
+		fmt.Fprintf(out, "l %d %d\n", numVertices+edge[0]+1, numVertices+edge[1]+1)
+	}
+	return numVertices + 8
+}
@@ -0,0 +1,174 @@
+package scene
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func inspectTestScene() *Scene {
+	nodes := make([]BvhNode, 4)
+	nodes[0].SetBBox([2]types.Vec3{{-3, 0, 0}, {3, 1, 0}})
+	nodes[0].SetChildNodes(1, 2)
+	nodes[1].SetBBox([2]types.Vec3{{-3, 0, 0}, {-1, 1, 0}})
+	nodes[1].SetMeshIndex(0)
+	nodes[2].SetBBox([2]types.Vec3{{1, 0, 0}, {3, 1, 0}})
+	nodes[2].SetMeshIndex(1)
+	nodes[3].SetBBox([2]types.Vec3{{0, 0, 0}, {1, 1, 0}})
+	nodes[3].SetPrimitives(0, 1)
+
+	return &Scene{
+		BvhNodeList: nodes,
+		MeshInstanceList: []MeshInstance{
+			{BvhRoot: 3, Transform: types.Translate4(types.Vec3{2, 0, 0})},
+			{BvhRoot: 3, Transform: types.Translate4(types.Vec3{-2, 0, 0})},
+		},
+		VertexList:    []types.Vec4{{0, 0, 0, 0}, {1, 0, 0, 0}, {0, 1, 0, 0}},
+		MaterialIndex: []uint32{0},
+	}
+}
+
+func TestInspectBvh(t *testing.T) {
+	in := inspectTestScene().InspectBvh()
+	if len(in.Issues) != 0 {
+		t.Fatalf("expected no issues; got %v", in.Issues)
+	}
+
+	if len(in.Trees) != 2 {
+		t.Fatalf("expected 2 trees; got %d", len(in.Trees))
+	}
+
+	top := in.Trees[0]
+	expStats := BvhTreeStats{Nodes: 3, Leaves: 2, MaxDepth: 1}
+	if top.Stats != expStats {
+		t.Fatalf("expected scene BVH stats to be %+v; got %+v", expStats, top.Stats)
+	}
+	if leaf := top.Nodes[2]; leaf.MeshInstance == nil || *leaf.MeshInstance != 1 {
+		t.Fatalf("expected node 2 to reference mesh instance 1; got %+v", leaf)
+	}
+
+	mesh := in.Trees[1]
+	if mesh.Mesh == nil || *mesh.Mesh != 0 || mesh.Root != 3 {
+		t.Fatalf("expected the second tree to be the BVH of mesh 0 rooted at node 3; got %+v", mesh)
+	}
+	if len(mesh.Instances) != 2 {
+		t.Fatalf("expected mesh BVH to be shared by 2 instances; got %v", mesh.Instances)
+	}
+	expStats = BvhTreeStats{Nodes: 1, Leaves: 1, MaxLeafPrimitives: 1, AvgLeafPrimitives: 1}
+	if mesh.Stats != expStats {
+		t.Fatalf("expected mesh BVH stats to be %+v; got %+v", expStats, mesh.Stats)
+	}
+
+	var buf bytes.Buffer
+	if err := in.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded BvhInspection
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Trees) != 2 || len(decoded.Trees[0].Nodes) != 3 {
+		t.Fatalf("expected JSON output to round-trip; got %s", buf.String())
+	}
+}
+
+func TestInspectBrokenBvh(t *testing.T) {
+	sc := inspectTestScene()
+	sc.BvhNodeList[0].SetChildNodes(1, 0)
+	sc.BvhNodeList[1].Min = types.Vec3{5, 0, 0}
+	sc.BvhNodeList[3].SetPrimitives(0, 2)
+	sc.MeshInstanceList = append(sc.MeshInstanceList, MeshInstance{MeshIndex: 1, BvhRoot: 10})
+
+	in := sc.InspectBvh()
+	expIssues := []string{
+		"scene BVH: node 0 references invalid or already visited child 0",
+		"scene BVH: node 1 has an invalid bounding box " + types.Vec3{5, 0, 0}.String() + " - " + types.Vec3{-1, 1, 0}.String(),
+		"mesh 0 BVH: leaf 3 references invalid primitive range [0, 2)",
+		"mesh instance 2: BVH root 10 out of range",
+	}
+	if len(in.Issues) != len(expIssues) {
+		t.Fatalf("expected %d issues; got %v", len(expIssues), in.Issues)
+	}
+	for index, exp := range expIssues {
+		if in.Issues[index] != exp {
+			t.Errorf("[issue %d] expected %q; got %q", index, exp, in.Issues[index])
+		}
+	}
+
+	if in.Trees[0].Stats.InvalidNodes != 2 || in.Trees[1].Stats.InvalidNodes != 1 {
+		t.Fatalf("expected the scene and mesh BVHs to report 2 and 1 invalid nodes; got %d and %d", in.Trees[0].Stats.InvalidNodes, in.Trees[1].Stats.InvalidNodes)
+	}
+}
+
+func TestExportOBJ(t *testing.T) {
+	sc := inspectTestScene()
+	in := sc.InspectBvh()
+
+	specs := []struct {
+		opts           OBJExportOptions
+		expVertices    int
+		expFaces       int
+		expLines       int
+		expObjects     []string
+		missingObjects []string
+	}{
+		{
+			opts:        OBJExportOptions{MaxBoxDepth: -1},
+			expVertices: 6,
+			expFaces:    2,
+			expObjects:  []string{"o instance_0", "o instance_1"},
+		},
+		{
+			opts:           OBJExportOptions{MaxBoxDepth: 0, SkipGeometry: true},
+			expVertices:    3 * 8,
+			expLines:       3 * 12,
+			expObjects:     []string{"g scene_bvh_depth_0", "g instance_0_bvh_depth_0", "g instance_1_bvh_depth_0"},
+			missingObjects: []string{"o instance_0", "g scene_bvh_depth_1"},
+		},
+		{
+			opts:        OBJExportOptions{MaxBoxDepth: 1},
+			expVertices: 6 + 5*8,
+			expFaces:    2,
+			expLines:    5 * 12,
+			expObjects:  []string{"g scene_bvh_depth_1"},
+		},
+	}
+
+	for index, spec := range specs {
+		var buf bytes.Buffer
+		if err := sc.ExportOBJ(&buf, in, spec.opts); err != nil {
+			t.Fatalf("[spec %d] unexpected error: %v", index, err)
+		}
+
+		counts := make(map[string]int)
+		lines := strings.Split(buf.String(), "\n")
+		for _, line := range lines {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				counts[fields[0]]++
+			}
+		}
+		if counts["v"] != spec.expVertices || counts["f"] != spec.expFaces || counts["l"] != spec.expLines {
+			t.Errorf("[spec %d] expected %d vertices, %d faces and %d lines; got %d, %d and %d", index, spec.expVertices, spec.expFaces, spec.expLines, counts["v"], counts["f"], counts["l"])
+		}
+		for _, obj := range spec.expObjects {
+			if !strings.Contains(buf.String(), obj+"\n") {
+				t.Errorf("[spec %d] expected output to contain %q", index, obj)
+			}
+		}
+		for _, obj := range spec.missingObjects {
+			if strings.Contains(buf.String(), obj+"\n") {
+				t.Errorf("[spec %d] expected output not to contain %q", index, obj)
+			}
+		}
+	}
+
+	// Instance 0 is translated by -2 in world space
+	var buf bytes.Buffer
+	sc.ExportOBJ(&buf, in, OBJExportOptions{MaxBoxDepth: -1})
+	if !strings.Contains(buf.String(), "v -2 0 0\nv -1 0 0\nv -2 1 0\nf 1 2 3\n") {
+		t.Fatalf("expected the triangle of instance 0 to be transformed to world space; got:\n%s", buf.String())
+	}
+}
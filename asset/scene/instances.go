@@ -0,0 +1,159 @@
+package scene
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrUnknownMeshInstance = errors.New("scene: unknown mesh instance")
+	ErrInstanceMismatch    = errors.New("scene: instance update does not match the scene layout")
+)
+
+// The parts of a compiled scene that change when mesh instances are moved.
+// When rendering animations, tracers keep the static scene data (geometry,
+// materials and textures) resident and only upload an InstanceUpdate for
+// each frame instead of the entire scene.
+type InstanceUpdate struct {
+	// The mesh instance list.
+	MeshInstanceList []MeshInstance
+
+	// The top-level BVH nodes. They are stored at the beginning of the
+	// scene BVH node list.
+	TopLevelBvhNodes []BvhNode
+
+	// The emissive primitive list. Emissive primitives store a copy of the
+	// transformation of the mesh instance that they belong to.
+	EmissivePrimitives []EmissivePrimitive
+}
+
+// Get the number of top-level BVH nodes. The scene compiler stores the
+// top-level BVH nodes before the BVH nodes of the scene meshes.
+func (sc *Scene) NumTopLevelBvhNodes() int {
+	numNodes := len(sc.BvhNodeList)
+	for _, mi := range sc.MeshInstanceList {
+		if int(mi.BvhRoot) < numNodes {
+			numNodes = int(mi.BvhRoot)
+		}
+	}
+	return numNodes
+}
+
+// Set the transformation that maps the mesh coordinates of a mesh instance to
+// world coordinates. The emissive primitives of the instance are updated to
+// use the new transformation and the top-level BVH is refitted to the new
+// instance bounds. The BVH topology is preserved so traversal performance
+// degrades if instances move far from their original location.
+func (sc *Scene) SetInstanceTransform(instance int, meshToWorld types.Mat4) error {
+	if instance < 0 || instance >= len(sc.MeshInstanceList) {
+		return fmt.Errorf("%s: %d", ErrUnknownMeshInstance.Error(), instance)
+	}
+
+	mi := &sc.MeshInstanceList[instance]
+	oldTransform := mi.Transform
+	mi.Transform = meshToWorld.Inv()
+
+	// Emissive primitives do not reference their mesh instance so they are
+	// matched by their transformation and primitive index.
+	firstPrim, lastPrim := sc.meshPrimitiveRange(mi.BvhRoot)
+	for index := range sc.EmissivePrimitives {
+		emissive := &sc.EmissivePrimitives[index]
+		if emissive.Type != AreaLight || emissive.Transform != oldTransform {
+			continue
+		}
+		if emissive.PrimitiveIndex < firstPrim || emissive.PrimitiveIndex >= lastPrim {
+			continue
+		}
+		emissive.Transform = mi.Transform
+	}
+
+	if sc.NumTopLevelBvhNodes() != 0 {
+		sc.refitTopLevelBvh(0)
+	}
+	return nil
+}
+
+// Get the range of primitive indices referenced by the leafs of a mesh BVH.
+func (sc *Scene) meshPrimitiveRange(root uint32) (first, last uint32) {
+	first, last = ^uint32(0), 0
+	stack := []uint32{root}
+	for len(stack) != 0 {
+		node := &sc.BvhNodeList[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if node.LData > 0 {
+			stack = append(stack, uint32(node.LData), uint32(node.RData))
+			continue
+		}
+
+		primIndex, count := node.GetPrimitives()
+		if primIndex < first {
+			first = primIndex
+		}
+		if primIndex+count > last {
+			last = primIndex + count
+		}
+	}
+
+	return first, last
+}
+
+// Recalculate the bounding boxes of a top-level BVH sub-tree and return the
+// bounding box of its root.
+func (sc *Scene) refitTopLevelBvh(nodeIndex uint32) [2]types.Vec3 {
+	node := &sc.BvhNodeList[nodeIndex]
+
+	var bbox [2]types.Vec3
+	if node.LData <= 0 {
+		bbox = sc.instanceBBox(&sc.MeshInstanceList[node.GetMeshIndex()])
+	} else {
+		left := sc.refitTopLevelBvh(uint32(node.LData))
+		right := sc.refitTopLevelBvh(uint32(node.RData))
+		bbox = [2]types.Vec3{types.MinVec3(left[0], right[0]), types.MaxVec3(left[1], right[1])}
+	}
+
+	node.SetBBox(bbox)
+	return bbox
+}
+
+// Create an instance update with a copy of the current mesh instance state.
+// As the update does not share any memory with the scene, it can be queued
+// while the scene instances are being updated for the next frame.
+func (sc *Scene) InstanceUpdate() *InstanceUpdate {
+	return &InstanceUpdate{
+		MeshInstanceList:   append([]MeshInstance(nil), sc.MeshInstanceList...),
+		TopLevelBvhNodes:   append([]BvhNode(nil), sc.BvhNodeList[:sc.NumTopLevelBvhNodes()]...),
+		EmissivePrimitives: append([]EmissivePrimitive(nil), sc.EmissivePrimitives...),
+	}
+}
+
+// Check that an instance update was created from a scene with the same layout.
+func (sc *Scene) CheckInstanceUpdate(update *InstanceUpdate) error {
+	if update == nil ||
+		len(update.MeshInstanceList) != len(sc.MeshInstanceList) ||
+		len(update.TopLevelBvhNodes) != sc.NumTopLevelBvhNodes() ||
+		len(update.EmissivePrimitives) != len(sc.EmissivePrimitives) {
+		return ErrInstanceMismatch
+	}
+	return nil
+}
+
+// Get a shallow copy of the scene with an instance update applied to it. The
+// copy references the instance data of the update and shares all other scene
+// data with the original scene which is not modified. This allows tracers that
+// share the same scene to apply updates independently.
+func (sc *Scene) WithInstanceUpdate(update *InstanceUpdate) (*Scene, error) {
+	if err := sc.CheckInstanceUpdate(update); err != nil {
+		return nil, err
+	}
+
+	updated := *sc
+	updated.MeshInstanceList = update.MeshInstanceList
+	updated.EmissivePrimitives = update.EmissivePrimitives
+	updated.BvhNodeList = make([]BvhNode, len(sc.BvhNodeList))
+	copy(updated.BvhNodeList, update.TopLevelBvhNodes)
+	copy(updated.BvhNodeList[len(update.TopLevelBvhNodes):], sc.BvhNodeList[len(update.TopLevelBvhNodes):])
+	return &updated, nil
+}
@@ -0,0 +1,107 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func instanceTestScene() *Scene {
+	unitBox := [2]types.Vec3{{-1, -1, -1}, {1, 1, 1}}
+	sc := &Scene{
+		BvhNodeList: make([]BvhNode, 4),
+		MeshInstanceList: []MeshInstance{
+			{BvhRoot: 3, Transform: types.Translate4(types.Vec3{2, 0, 0})},
+			{BvhRoot: 3, Transform: types.Translate4(types.Vec3{-2, 0, 0})},
+		},
+		EmissivePrimitives: []EmissivePrimitive{
+			{Type: AreaLight, PrimitiveIndex: 0, Transform: types.Translate4(types.Vec3{-2, 0, 0})},
+			{Type: EnvironmentLight, Transform: types.Ident4()},
+		},
+	}
+
+	// Top-level BVH with a leaf for each instance
+	sc.BvhNodeList[0].SetChildNodes(1, 2)
+	sc.BvhNodeList[1].SetMeshIndex(0)
+	sc.BvhNodeList[2].SetMeshIndex(1)
+
+	// Mesh BVH with a single leaf
+	sc.BvhNodeList[3].SetBBox(unitBox)
+	sc.BvhNodeList[3].SetPrimitives(0, 2)
+	sc.refitTopLevelBvh(0)
+	return sc
+}
+
+func TestSetInstanceTransform(t *testing.T) {
+	sc := instanceTestScene()
+	if got := sc.NumTopLevelBvhNodes(); got != 3 {
+		t.Fatalf("expected scene to have 3 top-level BVH nodes; got %d", got)
+	}
+
+	err := sc.SetInstanceTransform(1, types.Translate4(types.Vec3{0, 5, 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expTransform := types.Translate4(types.Vec3{0, -5, 0})
+	if sc.MeshInstanceList[1].Transform != expTransform {
+		t.Fatalf("expected instance transform to be updated; got %v", sc.MeshInstanceList[1].Transform)
+	}
+	if sc.EmissivePrimitives[0].Transform != expTransform {
+		t.Fatalf("expected emissive transform to be updated; got %v", sc.EmissivePrimitives[0].Transform)
+	}
+	if sc.EmissivePrimitives[1].Transform != types.Ident4() {
+		t.Fatalf("expected environment light transform to be unchanged; got %v", sc.EmissivePrimitives[1].Transform)
+	}
+
+	root := sc.BvhNodeList[0]
+	if !types.ApproxEqual(root.Min, types.Vec3{-3, -1, -1}, 1e-5) || !types.ApproxEqual(root.Max, types.Vec3{1, 6, 1}, 1e-5) {
+		t.Fatalf("expected root bbox to be [(-3, -1, -1), (1, 6, 1)]; got [%v, %v]", root.Min, root.Max)
+	}
+	if leaf := sc.BvhNodeList[2]; leaf.GetMeshIndex() != 1 || !types.ApproxEqual(leaf.Min, types.Vec3{-1, 4, -1}, 1e-5) {
+		t.Fatalf("expected leaf for instance 1 to be refitted; got %+v", leaf)
+	}
+
+	if err = sc.SetInstanceTransform(2, types.Ident4()); err == nil {
+		t.Fatal("expected an error when updating an unknown instance")
+	}
+}
+
+func TestWithInstanceUpdate(t *testing.T) {
+	sc := instanceTestScene()
+	other := instanceTestScene()
+
+	if err := sc.SetInstanceTransform(0, types.Translate4(types.Vec3{0, 0, 10})); err != nil {
+		t.Fatal(err)
+	}
+	update := sc.InstanceUpdate()
+
+	// Changes to the source scene should not affect the queued update
+	if err := sc.SetInstanceTransform(0, types.Ident4()); err != nil {
+		t.Fatal(err)
+	}
+
+	updated, err := other.WithInstanceUpdate(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := types.Translate4(types.Vec3{0, 0, -10}); updated.MeshInstanceList[0].Transform != exp {
+		t.Fatalf("expected instance transform to be %v; got %v", exp, updated.MeshInstanceList[0].Transform)
+	}
+	if got := updated.BvhNodeList[0].Max; !types.ApproxEqual(got, types.Vec3{3, 1, 11}, 1e-5) {
+		t.Fatalf("expected root bbox max to be (3, 1, 11); got %v", got)
+	}
+	if got := updated.BvhNodeList[3]; got != other.BvhNodeList[3] {
+		t.Fatalf("expected mesh BVH nodes to be preserved; got %+v", got)
+	}
+
+	// The original scene should not be modified
+	if exp := types.Translate4(types.Vec3{2, 0, 0}); other.MeshInstanceList[0].Transform != exp {
+		t.Fatalf("expected original instance transform to be %v; got %v", exp, other.MeshInstanceList[0].Transform)
+	}
+
+	update.MeshInstanceList = update.MeshInstanceList[:1]
+	if _, err = other.WithInstanceUpdate(update); err != ErrInstanceMismatch {
+		t.Fatalf("expected to get ErrInstanceMismatch; got %v", err)
+	}
+}
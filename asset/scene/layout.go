@@ -0,0 +1,47 @@
+package scene
+
+import "unsafe"
+
+// The version of the memory layout used by the structures that are shared
+// with the rendering backends. This value must be bumped whenever a field is
+// added, removed or re-ordered in any of the shared structures and must match
+// the LAYOUT_VERSION define in the opencl kernel sources.
+const LayoutVersion uint32 = 6
+
+// Expected sizes (in bytes) of the structures that are shared with the
+// rendering backends.
+const (
+	SizeofBvhNode           = 32
+	SizeofMeshInstance      = 80
+	SizeofMaterialNode      = 64
+	SizeofEmissivePrimitive = 80
+	SizeofTextureMetadata   = 20
+	SizeofCompressedBvhNode = 112
+	SizeofVolumeGrid        = 96
+)
+
+// Static assertions for the shared structure sizes. If any of the following
+// lines fails to compile then the structure layout has changed and both the
+// size constants and the LayoutVersion need to be updated.
+var (
+	_ [SizeofBvhNode - unsafe.Sizeof(BvhNode{})]struct{}
+	_ [unsafe.Sizeof(BvhNode{}) - SizeofBvhNode]struct{}
+
+	_ [SizeofMeshInstance - unsafe.Sizeof(MeshInstance{})]struct{}
+	_ [unsafe.Sizeof(MeshInstance{}) - SizeofMeshInstance]struct{}
+
+	_ [SizeofMaterialNode - unsafe.Sizeof(MaterialNode{})]struct{}
+	_ [unsafe.Sizeof(MaterialNode{}) - SizeofMaterialNode]struct{}
+
+	_ [SizeofEmissivePrimitive - unsafe.Sizeof(EmissivePrimitive{})]struct{}
+	_ [unsafe.Sizeof(EmissivePrimitive{}) - SizeofEmissivePrimitive]struct{}
+
+	_ [SizeofTextureMetadata - unsafe.Sizeof(TextureMetadata{})]struct{}
+	_ [unsafe.Sizeof(TextureMetadata{}) - SizeofTextureMetadata]struct{}
+
+	_ [SizeofCompressedBvhNode - unsafe.Sizeof(CompressedBvhNode{})]struct{}
+	_ [unsafe.Sizeof(CompressedBvhNode{}) - SizeofCompressedBvhNode]struct{}
+
+	_ [SizeofVolumeGrid - unsafe.Sizeof(VolumeGrid{})]struct{}
+	_ [unsafe.Sizeof(VolumeGrid{}) - SizeofVolumeGrid]struct{}
+)
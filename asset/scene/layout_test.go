@@ -0,0 +1,87 @@
+package scene
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestSharedStructOffsets(t *testing.T) {
+	var (
+		bvh  BvhNode
+		mi   MeshInstance
+		mat  MaterialNode
+		em   EmissivePrimitive
+		meta TextureMetadata
+		cbvh CompressedBvhNode
+		vol  VolumeGrid
+	)
+
+	specs := []struct {
+		name   string
+		got    uintptr
+		expect uintptr
+	}{
+		{"BvhNode.Min", unsafe.Offsetof(bvh.Min), 0},
+		{"BvhNode.LData", unsafe.Offsetof(bvh.LData), 12},
+		{"BvhNode.Max", unsafe.Offsetof(bvh.Max), 16},
+		{"BvhNode.RData", unsafe.Offsetof(bvh.RData), 28},
+		{"MeshInstance.MeshIndex", unsafe.Offsetof(mi.MeshIndex), 0},
+		{"MeshInstance.BvhRoot", unsafe.Offsetof(mi.BvhRoot), 4},
+		{"MeshInstance.Transform", unsafe.Offsetof(mi.Transform), 16},
+		{"MaterialNode.Union1", unsafe.Offsetof(mat.Union1), 0},
+		{"MaterialNode.Union2", unsafe.Offsetof(mat.Union2), 16},
+		{"MaterialNode.Union3", unsafe.Offsetof(mat.Union3), 32},
+		{"MaterialNode.Union4", unsafe.Offsetof(mat.Union4), 48},
+		{"MaterialNode.Union5", unsafe.Offsetof(mat.Union5), 60},
+		{"EmissivePrimitive.Transform", unsafe.Offsetof(em.Transform), 0},
+		{"EmissivePrimitive.Area", unsafe.Offsetof(em.Area), 64},
+		{"EmissivePrimitive.PrimitiveIndex", unsafe.Offsetof(em.PrimitiveIndex), 68},
+		{"EmissivePrimitive.MaterialNodeIndex", unsafe.Offsetof(em.MaterialNodeIndex), 72},
+		{"EmissivePrimitive.Type", unsafe.Offsetof(em.Type), 76},
+		{"TextureMetadata.Format", unsafe.Offsetof(meta.Format), 0},
+		{"TextureMetadata.Width", unsafe.Offsetof(meta.Width), 4},
+		{"TextureMetadata.Height", unsafe.Offsetof(meta.Height), 8},
+		{"TextureMetadata.DataOffset", unsafe.Offsetof(meta.DataOffset), 12},
+		{"TextureMetadata.MipLevels", unsafe.Offsetof(meta.MipLevels), 16},
+		{"CompressedBvhNode.Origin", unsafe.Offsetof(cbvh.Origin), 0},
+		{"CompressedBvhNode.NumChildren", unsafe.Offsetof(cbvh.NumChildren), 12},
+		{"CompressedBvhNode.Children", unsafe.Offsetof(cbvh.Children), 16},
+		{"CompressedBvhNode.Counts", unsafe.Offsetof(cbvh.Counts), 48},
+		{"CompressedBvhNode.QuantizedMin", unsafe.Offsetof(cbvh.QuantizedMin), 56},
+		{"CompressedBvhNode.QuantizedMax", unsafe.Offsetof(cbvh.QuantizedMax), 80},
+		{"CompressedBvhNode.Exponents", unsafe.Offsetof(cbvh.Exponents), 104},
+		{"VolumeGrid.Transform", unsafe.Offsetof(vol.Transform), 0},
+		{"VolumeGrid.Scale", unsafe.Offsetof(vol.Scale), 64},
+		{"VolumeGrid.MaxValue", unsafe.Offsetof(vol.MaxValue), 76},
+		{"VolumeGrid.Dims", unsafe.Offsetof(vol.Dims), 80},
+		{"VolumeGrid.DataOffset", unsafe.Offsetof(vol.DataOffset), 92},
+	}
+
+	for _, spec := range specs {
+		if spec.got != spec.expect {
+			t.Errorf("expected offset of %s to be %d; got %d", spec.name, spec.expect, spec.got)
+		}
+	}
+}
+
+func TestSharedStructSizes(t *testing.T) {
+	specs := []struct {
+		name   string
+		got    uintptr
+		expect uintptr
+	}{
+		{"BvhNode", unsafe.Sizeof(BvhNode{}), SizeofBvhNode},
+		{"MeshInstance", unsafe.Sizeof(MeshInstance{}), SizeofMeshInstance},
+		{"MaterialNode", unsafe.Sizeof(MaterialNode{}), SizeofMaterialNode},
+		{"EmissivePrimitive", unsafe.Sizeof(EmissivePrimitive{}), SizeofEmissivePrimitive},
+		{"TextureMetadata", unsafe.Sizeof(TextureMetadata{}), SizeofTextureMetadata},
+		{"CompressedBvhNode", unsafe.Sizeof(CompressedBvhNode{}), SizeofCompressedBvhNode},
+		{"VolumeGrid", unsafe.Sizeof(VolumeGrid{}), SizeofVolumeGrid},
+	}
+
+	for _, spec := range specs {
+		if spec.got != spec.expect {
+			t.Errorf("expected sizeof(%s) to be %d; got %d", spec.name, spec.expect, spec.got)
+		}
+	}
+}
@@ -83,25 +83,34 @@ type MaterialNode struct {
 	// Layout:
 	// [0] type
 	// [1] left child
-	// [2] right child or transmittance texture
-	// [3] bump map, reflectance, specularity or radiance texture
+	// [2] right child, transmittance or metallic texture
+	// [3] bump map, opacity, reflectance, specularity, radiance or base color texture
+	//
+	// Medium nodes store the surface expression as the left child and the
+	// indices of their density and emission volume grids in [2] and [3].
+	// Each of these fields is -1 if the medium does not define it.
 	Union1 [4]int32
 
 	// Layout:
 	// [0-3] reflectance or specularity or radiance
 	// [0-3] RGB intIORs for dispersion
-	// [0] mix weight
+	// [0-2] base color and [3] transmission weight for principled bxdfs
+	// [0] mix weight, curvature scale, occlusion radius or alpha cutoff
+	// [0-2] medium absorption coefficients
 	Union2 types.Vec4
 
 	// Layout:
 	// [0-3] transmittance
 	// [0-3] RGB extIORs for dispersion
+	// [0-3] metallic, specular, sheen and clearcoat weights for principled bxdfs
+	// [0-2] medium scattering coefficients
 	Union3 types.Vec4
 
 	// Layout:
 	// [0] internal IOR
 	// [1] external IOR
-	// [2] roughness or radiance scaler
+	// [2] roughness, radiance scaler, bump map parallax scale or medium phase
+	//     function anisotropy
 	Union4 types.Vec3
 
 	// Layout:
@@ -136,6 +145,29 @@ type EmissivePrimitive struct {
 	Type EmissivePrimitiveType
 }
 
+// Mesh instance flags.
+type MeshInstanceFlag uint32
+
+const (
+	// The mesh instance is skipped by primary ray intersection queries. It
+	// still contributes to the scene lighting and appears in reflections.
+	CameraInvisible MeshInstanceFlag = 1 << iota
+
+	// The mesh instance acts as a shadow catcher for camera rays. Instead
+	// of being shaded using its material, it displays the scene background
+	// darkened by any shadows cast onto it.
+	ShadowCatcher
+
+	// The shadow catcher also reflects the scene using a dielectric
+	// Fresnel term. Only used in combination with ShadowCatcher.
+	CatcherReflections
+
+	// The mesh uses alpha cutout materials. Intersection queries sample
+	// the opacity texture of the hit triangle material and ignore hits
+	// whose opacity is below the material cutoff.
+	AlphaCutout
+)
+
 // The MeshInstance structure allows us to apply a transformation matrix to
 // a scene mesh so that it can be positioned inside the scene.
 type MeshInstance struct {
@@ -145,12 +177,29 @@ type MeshInstance struct {
 	// instances of the same mesh.
 	BvhRoot uint32
 
-	padding [2]uint32
+	// The distance that secondary ray origins are offset from the surface
+	// of this instance. If zero, the default intersection epsilon is used.
+	RayBias float32
+
+	// Instance flags.
+	Flags MeshInstanceFlag
 
-	// A transformation matrix for positioning the mesh.
+	// A transformation matrix for positioning the mesh. See WorldToMesh.
 	Transform types.Mat4
 }
 
+// Get the transformation from world space to the mesh space of the instance.
+// Instance transforms map world coordinates to mesh coordinates so that
+// tracers can transform rays into mesh space without inverting the matrix.
+func (mi *MeshInstance) WorldToMesh() types.Mat4 {
+	return mi.Transform
+}
+
+// Get the transformation from the mesh space of the instance to world space.
+func (mi *MeshInstance) MeshToWorld() types.Mat4 {
+	return mi.Transform.Inv()
+}
+
 // The texture metadata. All texture data is stored as a contiguous memory block.
 type TextureMetadata struct {
 	// Texture format.
@@ -162,9 +211,69 @@ type TextureMetadata struct {
 
 	// Offset to the beginning of texture data
 	DataOffset uint32
+
+	// Number of mip levels. The levels are stored back to back starting
+	// at DataOffset; each level is aligned on a dword boundary.
+	MipLevels uint32
+}
+
+// A dense volume grid used by heterogeneous media. The voxel values of all
+// grids are stored as a contiguous float block with the x coordinate varying
+// the fastest. Voxel centers are located at integer grid coordinates and
+// points outside the grid bounds evaluate to zero.
+type VolumeGrid struct {
+	// The transformation from world space to the grid coordinate space.
+	Transform types.Mat4
+
+	// The color that scales the voxel values.
+	Scale types.Vec3
+
+	// The max voxel value; used as the majorant for tracking.
+	MaxValue float32
+
+	// Grid dimensions in voxels.
+	Dims [3]uint32
+
+	// Offset (in floats) to the beginning of the voxel data.
+	DataOffset uint32
+}
+
+// Get the offset to the data of a mip level and the level dimensions. The
+// dimensions of each level are half the dimensions of the previous level
+// but never less than one texel.
+func (meta *TextureMetadata) MipLevel(level uint32) (dataOffset, width, height uint32) {
+	dataOffset, width, height = meta.DataOffset, meta.Width, meta.Height
+	for ; level > 0; level-- {
+		dataOffset += mipLevelSize(meta.Format, width, height)
+		width, height = halveMipDimension(width), halveMipDimension(height)
+	}
+	return dataOffset, width, height
+}
+
+// Get the size in bytes of a mip level including its alignment padding.
+func (meta *TextureMetadata) MipLevelSize(level uint32) uint32 {
+	_, width, height := meta.MipLevel(level)
+	return mipLevelSize(meta.Format, width, height)
+}
+
+// Get the dword-aligned size of a mip level with the given dimensions.
+func mipLevelSize(format texture.Format, width, height uint32) uint32 {
+	return (width*height*uint32(format.TexelSize()) + 3) &^ 3
+}
+
+// Get the dimension of the next mip level.
+func halveMipDimension(dim uint32) uint32 {
+	if dim > 1 {
+		return dim >> 1
+	}
+	return 1
 }
 
 type Scene struct {
+	// The version of the shared structure layout used when the scene
+	// was compiled. See LayoutVersion.
+	LayoutVersion uint32
+
 	BvhNodeList        []BvhNode
 	MeshInstanceList   []MeshInstance
 	MaterialNodeList   []MaterialNode
@@ -174,19 +283,53 @@ type Scene struct {
 	TextureData     []byte
 	TextureMetadata []TextureMetadata
 
+	// Volume grid definitions for heterogeneous media and the associated
+	// voxel data.
+	VolumeGrids []VolumeGrid
+	VolumeData  []float32
+
 	// Primitives are stored as an array of structs.
 	VertexList    []types.Vec4
 	NormalList    []types.Vec4
 	UvList        []types.Vec2
 	MaterialIndex []uint32
 
+	// The per-vertex tangents used for orienting normal and bump maps.
+	// The w component stores the bitangent handedness. Zero tangents
+	// indicate that the renderer should use an arbitrary tangent frame.
+	// The list is empty for scenes compiled before tangents were
+	// supported; see VertexTangents.
+	TangentList []types.Vec4
+
 	// Indices to material nodes used for storing the scene global
 	// properties such as diffuse and emissive colors.
 	SceneDiffuseMatIndex  int32
 	SceneEmissiveMatIndex int32
 
-	// The scene camera.
+	// Index to the material node used as a backplate for camera ray
+	// misses or -1 if the scene does not define a backplate.
+	SceneBackplateMatIndex int32
+
+	// Index to the medium material node that fills the scene or -1 if the
+	// scene does not define a medium.
+	SceneMediumMatIndex int32
+
+	// The active scene camera.
 	Camera *Camera
+
+	// The list of cameras defined by the scene. The active camera always
+	// points to one of the list entries.
+	Cameras []*Camera
+}
+
+// Get the per-vertex tangents of the scene. If the scene does not define a
+// tangent for each vertex, a list of zero tangents is returned so that the
+// renderers fall back to an arbitrary tangent frame.
+func (sc *Scene) VertexTangents() []types.Vec4 {
+	if len(sc.TangentList) == len(sc.VertexList) {
+		return sc.TangentList
+	}
+	return make([]types.Vec4, len(sc.VertexList))
 }
 
 // Build a tabular representation of scene statistics.
@@ -196,9 +339,10 @@ func (sc *Scene) Stats() string {
 	table.SetAlignment(tablewriter.ALIGN_LEFT)
 	table.SetAutoFormatHeaders(false)
 	table.SetHeader([]string{"Asset Type", "Asset", "Size"})
-	table.Append([]string{"Geometry", "---", fmtSize(sc.VertexList, sc.NormalList, sc.UvList, sc.BvhNodeList)})
+	table.Append([]string{"Geometry", "---", fmtSize(sc.VertexList, sc.NormalList, sc.TangentList, sc.UvList, sc.BvhNodeList)})
 	table.Append([]string{"", "Vertices", fmtSize(sc.VertexList)})
 	table.Append([]string{"", "Normals", fmtSize(sc.NormalList)})
+	table.Append([]string{"", "Tangents", fmtSize(sc.TangentList)})
 	table.Append([]string{"", "UVs", fmtSize(sc.UvList)})
 	table.Append([]string{"", "BVH", fmtSize(sc.BvhNodeList)})
 	table.Append([]string{" ", " ", " "})
@@ -213,7 +357,11 @@ func (sc *Scene) Stats() string {
 	table.Append([]string{"Textures", "---", fmtSize(sc.TextureMetadata, sc.TextureData)})
 	table.Append([]string{"", "Metadata", fmtSize(sc.TextureMetadata)})
 	table.Append([]string{"", "Data", fmtSize(sc.TextureData)})
-	table.SetFooter([]string{"Total", " ", strings.TrimLeft(fmtSize(sc.VertexList, sc.NormalList, sc.UvList, sc.BvhNodeList, sc.MeshInstanceList, sc.EmissivePrimitives, sc.MaterialNodeList, sc.MaterialIndex, sc.TextureMetadata, sc.TextureData), " ")})
+	table.Append([]string{" ", " ", " "})
+	table.Append([]string{"Volumes", "---", fmtSize(sc.VolumeGrids, sc.VolumeData)})
+	table.Append([]string{"", "Grids", fmtSize(sc.VolumeGrids)})
+	table.Append([]string{"", "Data", fmtSize(sc.VolumeData)})
+	table.SetFooter([]string{"Total", " ", strings.TrimLeft(fmtSize(sc.VertexList, sc.NormalList, sc.TangentList, sc.UvList, sc.BvhNodeList, sc.MeshInstanceList, sc.EmissivePrimitives, sc.MaterialNodeList, sc.MaterialIndex, sc.TextureMetadata, sc.TextureData, sc.VolumeGrids, sc.VolumeData), " ")})
 
 	table.Render()
 	return buf.String()
@@ -222,7 +370,12 @@ func (sc *Scene) Stats() string {
 // Sum the total space used by a set of slices and return back a formatted
 // value with the appropriate byte/kb/mb unit.
 func fmtSize(items ...interface{}) string {
-	var totalBytes float32 = 0.0
+	return formatBytes(sizeOf(items...))
+}
+
+// Sum the total space in bytes used by a set of slices.
+func sizeOf(items ...interface{}) int {
+	totalBytes := 0
 	for _, item := range items {
 		t := reflect.TypeOf(item)
 		v := reflect.ValueOf(item)
@@ -230,11 +383,16 @@ func fmtSize(items ...interface{}) string {
 			continue
 		}
 
-		totalBytes += float32(int(t.Elem().Size()) * v.Len())
+		totalBytes += int(t.Elem().Size()) * v.Len()
 	}
+	return totalBytes
+}
 
+// Format a byte count using the appropriate byte/kb/mb unit.
+func formatBytes(count int) string {
+	totalBytes := float32(count)
 	if totalBytes < 1e3 {
-		return fmt.Sprintf("%3d bytes", int(totalBytes))
+		return fmt.Sprintf("%3d bytes", count)
 	} else if totalBytes < 1e6 {
 		return fmt.Sprintf("%3.1f kb", totalBytes/1e3)
 	}
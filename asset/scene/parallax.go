@@ -0,0 +1,28 @@
+package scene
+
+import "github.com/achilleasa/polaris/asset/material"
+
+// Enable a parallax mapping preview for all bump map material nodes. When
+// enabled, the tracer offsets the surface uv coordinates using the bump map
+// height values so that displacement intent can be previewed without
+// subdividing the scene geometry. The scale value controls the max height
+// offset in uv space; a zero value disables the preview. This method returns
+// the number of material nodes that were updated.
+func (sc *Scene) SetParallaxScale(scale float32) int {
+	if scale < 0 {
+		scale = 0
+	}
+
+	count := 0
+	for index := range sc.MaterialNodeList {
+		node := &sc.MaterialNodeList[index]
+		if node.Union1[0] != int32(material.OpBumpMap) {
+			continue
+		}
+
+		node.Union4[2] = scale
+		count++
+	}
+
+	return count
+}
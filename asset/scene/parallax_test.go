@@ -0,0 +1,34 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+)
+
+func TestSetParallaxScale(t *testing.T) {
+	sc := &Scene{
+		MaterialNodeList: []MaterialNode{
+			{Union1: [4]int32{int32(material.OpBumpMap), 1, 0, 0}},
+			{Union1: [4]int32{int32(material.BxdfEmissive), 0, 0, 0}},
+			{Union1: [4]int32{int32(material.OpBumpMap), 1, 0, 0}},
+		},
+	}
+	sc.MaterialNodeList[1].Union4[2] = 10
+
+	if count := sc.SetParallaxScale(0.05); count != 2 {
+		t.Fatalf("expected 2 bump map nodes to be updated; got %d", count)
+	}
+
+	expScales := []float32{0.05, 10, 0.05}
+	for index, exp := range expScales {
+		if got := sc.MaterialNodeList[index].Union4[2]; got != exp {
+			t.Errorf("[node %d] expected Union4[2] to be %f; got %f", index, exp, got)
+		}
+	}
+
+	sc.SetParallaxScale(-1)
+	if got := sc.MaterialNodeList[0].Union4[2]; got != 0 {
+		t.Errorf("expected negative scale to disable parallax preview; got %f", got)
+	}
+}
@@ -0,0 +1,198 @@
+//go:build go1.18
+// +build go1.18
+
+package reader
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/volume"
+	"github.com/achilleasa/polaris/log"
+)
+
+// Limits used by the fuzz targets. Includes are disabled so that fuzzed
+// inputs cannot reach the filesystem or the network.
+var fuzzLimits = Limits{
+	MaxResourceSize: 1 << 20,
+	MaxElements:     1 << 14,
+	MaxIncludeDepth: 0,
+}
+
+// Silence reader logs; fuzz workers would otherwise spend most of their
+// time writing log output.
+func init() {
+	log.SetSink(ioutil.Discard)
+}
+
+const fuzzObjSeed = `
+# a quad with a custom material
+camera_fov 45
+camera_eye 0 0 10
+camera_look 0 0 0
+camera_up 0 1 0
+g quad
+v -1 -1 0
+v 1 -1 0
+v 1 1 0
+v -1 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+vn 0 0 1
+f 1/1/1 2/2/1 3/3/1 4/4/1
+f -4 -3 -2
+instance quad 0 0 0 0 45 0 1 1 1
+`
+
+const fuzzMtlSeed = `
+newmtl base
+Kd 0.5 0.5 0.5
+Ks 0.1 0.1 0.1
+Ni 1.5
+map_Kd diffuse.png
+newmtl light
+include base
+Ke 1 1 1
+KeScaler 10
+newmtl expr
+mat_expr mix(diffuse(reflectance: {0.9, 0.9, 0.9}), conductor(specularity: {1, 1, 1}), 0.5)
+`
+
+func FuzzWavefrontReader(f *testing.F) {
+	f.Add([]byte(fuzzObjSeed))
+	f.Add([]byte("f 1 2 3\n"))
+	f.Add([]byte("call other.obj\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := newWavefrontReader(Options{Limits: fuzzLimits}, nil)
+		err := r.parse(asset.NewResourceFromStream("fuzz.obj", bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		if len(r.rawScene.MeshInstances) == 0 {
+			r.createDefaultMeshInstances()
+		}
+		r.processMaterials()
+
+		if len(r.vertexList) > fuzzLimits.MaxElements || r.numPrimitives > fuzzLimits.MaxElements {
+			t.Fatalf("reader exceeded element limits: %d vertices, %d primitives", len(r.vertexList), r.numPrimitives)
+		}
+	})
+}
+
+func FuzzWavefrontMaterials(f *testing.F) {
+	f.Add([]byte(fuzzMtlSeed))
+	f.Add([]byte("newmtl a\nmap_Kd\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := newWavefrontReader(Options{Limits: fuzzLimits}, nil)
+		err := r.parseMaterials(asset.NewResourceFromStream("fuzz.mtl", bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		for _, mat := range r.materials {
+			mat.GetExpression()
+		}
+	})
+}
+
+func FuzzZipSceneReader(f *testing.F) {
+	f.Add(compiledSceneSeed(f))
+	f.Add([]byte("PK\x03\x04"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sc, err := newZipSceneReader(Options{Limits: fuzzLimits}, nil).Read(asset.NewResourceFromStream("fuzz.zip", bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		if sc.Camera == nil {
+			t.Fatal("expected reader to reject scenes without a camera")
+		}
+	})
+}
+
+func FuzzNanoVDB(f *testing.F) {
+	f.Add([]byte("NanoVDB2\x00\x00\x00\x04\x01\x00\x00\x00"))
+	f.Add([]byte("NanoVDB0"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		grids, err := volume.ReadNanoVDB(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		for _, grid := range grids {
+			dense, _, err := grid.Dense(fuzzLimits.MaxElements)
+			if err != nil {
+				continue
+			}
+			if len(dense.Data) > fuzzLimits.MaxElements {
+				t.Fatalf("dense grid exceeded the voxel budget: %d voxels", len(dense.Data))
+			}
+		}
+	})
+}
+
+// Generate a minimal compiled scene archive.
+func compiledSceneSeed(f *testing.F) []byte {
+	sc := &scene.Scene{
+		LayoutVersion:          scene.LayoutVersion,
+		BvhNodeList:            []scene.BvhNode{{}},
+		MaterialNodeList:       []scene.MaterialNode{{}},
+		SceneDiffuseMatIndex:   -1,
+		SceneEmissiveMatIndex:  -1,
+		SceneBackplateMatIndex: -1,
+		SceneMediumMatIndex:    -1,
+		Camera:                 scene.NewCamera(45),
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(dataFile)
+	if err != nil {
+		f.Fatal(err)
+	}
+	err = gob.NewEncoder(w).Encode(sc)
+	if err != nil {
+		f.Fatal(err)
+	}
+	err = zw.Close()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func FuzzGltfReader(f *testing.F) {
+	f.Add([]byte(`{"asset": {"version": "2.0"}}`))
+	f.Add([]byte(`{"asset": {"version": "2.0"}, "nodes": [{"mesh": 0}], "meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}], "accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}], "bufferViews": [{"buffer": 0, "byteLength": 36}], "buffers": [{"byteLength": 36, "uri": "data:application/octet-stream;base64,AAAAAAAAAAAAAAAAAACAPwAAAAAAAAAAAAAAAAAAgD8AAAAA"}]}`))
+	f.Add([]byte("glTF\x02\x00\x00\x00\x14\x00\x00\x00\x00\x00\x00\x00JSON"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := newGltfReader(Options{Limits: fuzzLimits}, nil)
+		r.sceneRes = asset.NewResourceFromStream("fuzz.gltf", bytes.NewReader(data))
+		err := r.parse()
+		if r.tmpDir != "" {
+			os.RemoveAll(r.tmpDir)
+		}
+		if err != nil {
+			return
+		}
+
+		if r.numPrimitives > fuzzLimits.MaxElements {
+			t.Fatalf("reader exceeded element limits: %d primitives", r.numPrimitives)
+		}
+	})
+}
@@ -0,0 +1,1512 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Binary glTF (.glb) container constants.
+const (
+	glbMagic         uint32 = 0x46546C67 // "glTF"
+	glbChunkJSON     uint32 = 0x4E4F534A // "JSON"
+	glbChunkBIN      uint32 = 0x004E4942 // "BIN\0"
+	glbHeaderSize           = 12
+	glbChunkHeadSize        = 8
+)
+
+// glTF accessor component types.
+const (
+	gltfByte          = 5120
+	gltfUnsignedByte  = 5121
+	gltfShort         = 5122
+	gltfUnsignedShort = 5123
+	gltfUnsignedInt   = 5125
+	gltfFloat         = 5126
+)
+
+// glTF primitive modes that describe triangles.
+const (
+	gltfTriangles     = 4
+	gltfTriangleStrip = 5
+	gltfTriangleFan   = 6
+)
+
+const (
+	// The radius of the emissive spheres that approximate point and spot
+	// lights. glTF scenes use meters so this corresponds to a small bulb.
+	gltfPointLightRadius float32 = 0.05
+
+	// The angular diameter (in degrees) of the emissive quads that
+	// approximate directional lights; roughly twice the size of the sun.
+	gltfDirectionalLightAngle = 1.0
+
+	// The IOR of materials that do not use the KHR_materials_ior
+	// extension.
+	gltfDefaultIOR float32 = 1.5
+)
+
+// The glTF extensions that the importer understands. Files that require
+// any other extension cannot be loaded.
+var gltfSupportedExtensions = map[string]struct{}{
+	"KHR_lights_punctual":             struct{}{},
+	"KHR_materials_clearcoat":         struct{}{},
+	"KHR_materials_emissive_strength": struct{}{},
+	"KHR_materials_ior":               struct{}{},
+	"KHR_materials_sheen":             struct{}{},
+	"KHR_materials_specular":          struct{}{},
+	"KHR_materials_transmission":      struct{}{},
+}
+
+// The subset of the glTF 2.0 document that is used by the importer.
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	ExtensionsRequired []string `json:"extensionsRequired"`
+
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+
+	Nodes       []gltfNode       `json:"nodes"`
+	Meshes      []gltfMesh       `json:"meshes"`
+	Materials   []gltfMaterial   `json:"materials"`
+	Textures    []gltfTexture    `json:"textures"`
+	Images      []gltfImage      `json:"images"`
+	Cameras     []gltfCamera     `json:"cameras"`
+	Accessors   []gltfAccessor   `json:"accessors"`
+	BufferViews []gltfBufferView `json:"bufferViews"`
+	Buffers     []gltfBuffer     `json:"buffers"`
+
+	Extensions struct {
+		LightsPunctual struct {
+			Lights []gltfLight `json:"lights"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type gltfNode struct {
+	Name        string    `json:"name"`
+	Children    []int     `json:"children"`
+	Mesh        *int      `json:"mesh"`
+	Camera      *int      `json:"camera"`
+	Matrix      []float32 `json:"matrix"`
+	Translation []float32 `json:"translation"`
+	Rotation    []float32 `json:"rotation"`
+	Scale       []float32 `json:"scale"`
+
+	Extensions struct {
+		LightsPunctual *struct {
+			Light int `json:"light"`
+		} `json:"KHR_lights_punctual"`
+	} `json:"extensions"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+type gltfMaterial struct {
+	Name                 string `json:"name"`
+	PbrMetallicRoughness struct {
+		BaseColorFactor          []float32        `json:"baseColorFactor"`
+		BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor           *float32         `json:"metallicFactor"`
+		RoughnessFactor          *float32         `json:"roughnessFactor"`
+		MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+	} `json:"pbrMetallicRoughness"`
+	NormalTexture    *gltfTextureInfo           `json:"normalTexture"`
+	OcclusionTexture *gltfTextureInfo           `json:"occlusionTexture"`
+	EmissiveTexture  *gltfTextureInfo           `json:"emissiveTexture"`
+	EmissiveFactor   []float32                  `json:"emissiveFactor"`
+	AlphaMode        string                     `json:"alphaMode"`
+	AlphaCutoff      *float32                   `json:"alphaCutoff"`
+	Extensions       map[string]json.RawMessage `json:"extensions"`
+}
+
+// The material extensions that map to principled bxdf parameters.
+type gltfPrincipledExtensions struct {
+	IOR *struct {
+		IOR *float32 `json:"ior"`
+	} `json:"KHR_materials_ior"`
+	Specular *struct {
+		SpecularFactor       *float32         `json:"specularFactor"`
+		SpecularTexture      *gltfTextureInfo `json:"specularTexture"`
+		SpecularColorFactor  []float32        `json:"specularColorFactor"`
+		SpecularColorTexture *gltfTextureInfo `json:"specularColorTexture"`
+	} `json:"KHR_materials_specular"`
+	Sheen *struct {
+		SheenColorFactor      []float32        `json:"sheenColorFactor"`
+		SheenColorTexture     *gltfTextureInfo `json:"sheenColorTexture"`
+		SheenRoughnessFactor  *float32         `json:"sheenRoughnessFactor"`
+		SheenRoughnessTexture *gltfTextureInfo `json:"sheenRoughnessTexture"`
+	} `json:"KHR_materials_sheen"`
+	Clearcoat *struct {
+		ClearcoatFactor           *float32         `json:"clearcoatFactor"`
+		ClearcoatTexture          *gltfTextureInfo `json:"clearcoatTexture"`
+		ClearcoatRoughnessFactor  *float32         `json:"clearcoatRoughnessFactor"`
+		ClearcoatRoughnessTexture *gltfTextureInfo `json:"clearcoatRoughnessTexture"`
+		ClearcoatNormalTexture    *gltfTextureInfo `json:"clearcoatNormalTexture"`
+	} `json:"KHR_materials_clearcoat"`
+	Transmission *struct {
+		TransmissionFactor  *float32         `json:"transmissionFactor"`
+		TransmissionTexture *gltfTextureInfo `json:"transmissionTexture"`
+	} `json:"KHR_materials_transmission"`
+}
+
+type gltfTextureInfo struct {
+	Index    int      `json:"index"`
+	TexCoord int      `json:"texCoord"`
+	Scale    *float32 `json:"scale"`
+}
+
+type gltfTexture struct {
+	Source *int `json:"source"`
+}
+
+type gltfImage struct {
+	URI        string `json:"uri"`
+	MimeType   string `json:"mimeType"`
+	BufferView *int   `json:"bufferView"`
+}
+
+type gltfCamera struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Perspective *struct {
+		Yfov float32 `json:"yfov"`
+	} `json:"perspective"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltfBufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfLight struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Color     []float32 `json:"color"`
+	Intensity *float32  `json:"intensity"`
+}
+
+// The decoded elements of an accessor.
+type gltfAccessorData struct {
+	data          []byte
+	stride        int
+	count         int
+	components    int
+	componentType int
+	normalized    bool
+}
+
+// Get component c of element i as a float. Normalized integer components
+// are mapped to the [0, 1] or [-1, 1] range.
+func (a *gltfAccessorData) Float(i, c int) float32 {
+	offset := i*a.stride + c*gltfComponentSize(a.componentType)
+	var v, norm float32
+	switch a.componentType {
+	case gltfByte:
+		v, norm = float32(int8(a.data[offset])), 127
+	case gltfUnsignedByte:
+		v, norm = float32(a.data[offset]), 255
+	case gltfShort:
+		v, norm = float32(int16(binary.LittleEndian.Uint16(a.data[offset:]))), 32767
+	case gltfUnsignedShort:
+		v, norm = float32(binary.LittleEndian.Uint16(a.data[offset:])), 65535
+	case gltfUnsignedInt:
+		v, norm = float32(binary.LittleEndian.Uint32(a.data[offset:])), 4294967295
+	default:
+		return math.Float32frombits(binary.LittleEndian.Uint32(a.data[offset:]))
+	}
+
+	if a.normalized {
+		return float32(math.Max(float64(v/norm), -1))
+	}
+	return v
+}
+
+// Get element i of a scalar integer accessor.
+func (a *gltfAccessorData) Uint(i int) uint32 {
+	offset := i * a.stride
+	switch a.componentType {
+	case gltfUnsignedByte:
+		return uint32(a.data[offset])
+	case gltfUnsignedShort:
+		return uint32(binary.LittleEndian.Uint16(a.data[offset:]))
+	default:
+		return binary.LittleEndian.Uint32(a.data[offset:])
+	}
+}
+
+// A light that is converted to emissive geometry once the mesh instances
+// (and thus the scene bounds) are known.
+type gltfPendingLight struct {
+	light     *gltfLight
+	name      string
+	transform types.Mat4
+}
+
+// The key for looking up images that have been prepared for use by the
+// scene compiler. The channel is -1 for images that are used as-is.
+type gltfImageKey struct {
+	image   int
+	channel int
+	factor  float32
+}
+
+type gltfSceneReader struct {
+	logger log.Logger
+
+	// Limits for protecting against malformed input files.
+	limits Limits
+
+	// Collects progress and non-fatal issues; may be nil.
+	report *compiler.Report
+
+	// Compiler settings; see Options.
+	textureBudget       int
+	textureColorSpace   texture.ColorSpace
+	roughnessConvention material.RoughnessConvention
+	normalFix           compiler.ShadingNormalFix
+	maxNormalAngle      float32
+	missingMaterial     compiler.MissingMaterialPolicy
+
+	// The parsed document and its resource.
+	sceneRes *asset.Resource
+	doc      gltfDocument
+
+	// The binary chunk of .glb files and the loaded buffer contents.
+	glbBin  []byte
+	buffers map[int][]byte
+
+	// Maps glTF meshes and materials to raw scene indices. Meshes that
+	// contain no triangles map to -1.
+	meshIndices map[int]int
+	matIndices  map[int]int
+
+	// Image paths indexed by image, channel and scale factor.
+	imagePaths map[gltfImageKey]string
+
+	// A temp folder for images that are embedded into the document or
+	// need to be converted; it is removed once the scene is compiled.
+	tmpDir string
+
+	// Unique names for generated materials and cameras.
+	usedNames map[string]struct{}
+
+	lights        []gltfPendingLight
+	rawScene      *input.Scene
+	numPrimitives int
+	hasCamera     bool
+}
+
+// Create a new glTF scene reader.
+func newGltfReader(opts Options, report *compiler.Report) *gltfSceneReader {
+	return &gltfSceneReader{
+		logger:              log.New("glTF scene reader"),
+		limits:              opts.Limits,
+		report:              report,
+		textureBudget:       opts.TextureBudget,
+		textureColorSpace:   opts.TextureColorSpace,
+		roughnessConvention: opts.RoughnessConvention,
+		normalFix:           opts.ShadingNormalFix,
+		maxNormalAngle:      opts.MaxShadingNormalAngle,
+		missingMaterial:     opts.MissingMaterialPolicy,
+		buffers:             make(map[int][]byte),
+		meshIndices:         make(map[int]int),
+		matIndices:          make(map[int]int),
+		imagePaths:          make(map[gltfImageKey]string),
+		usedNames:           make(map[string]struct{}),
+		rawScene:            input.NewScene(),
+	}
+}
+
+// Read scene definition.
+func (r *gltfSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, error) {
+	r.logger.Noticef(`parsing glTF scene from "%s"`, sceneRes.Path())
+	start := time.Now()
+
+	defer func() {
+		if r.tmpDir != "" {
+			os.RemoveAll(r.tmpDir)
+		}
+	}()
+
+	r.sceneRes = sceneRes
+	if err := r.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %s", sceneRes.Path(), err.Error())
+	}
+
+	r.report.Progress(compiler.SectionParse, len(r.doc.Nodes), len(r.doc.Nodes))
+	r.logger.Noticef("parsed scene in %d ms", time.Since(start).Nanoseconds()/1e6)
+
+	// Compile scene into an optimized, gpu-friendly format
+	return compiler.CompileWithOptions(
+		r.rawScene,
+		compiler.Options{
+			Report:                r.report,
+			TextureBudget:         r.textureBudget,
+			TextureColorSpace:     r.textureColorSpace,
+			RoughnessConvention:   r.roughnessConvention,
+			ShadingNormalFix:      r.normalFix,
+			MaxShadingNormalAngle: r.maxNormalAngle,
+			MissingMaterialPolicy: r.missingMaterial,
+		},
+	)
+}
+
+// Parse the glTF document and populate the raw scene.
+func (r *gltfSceneReader) parse() error {
+	data, err := ioutil.ReadAll(r.limits.limitReader(r.sceneRes))
+	if err != nil {
+		return err
+	}
+
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == glbMagic {
+		if data, err = r.parseGlb(data); err != nil {
+			return err
+		}
+	}
+
+	if err = json.Unmarshal(data, &r.doc); err != nil {
+		return fmt.Errorf("invalid glTF document: %s", err.Error())
+	}
+	if !strings.HasPrefix(r.doc.Asset.Version, "2.") {
+		return fmt.Errorf("unsupported glTF version %q; only version 2.x is supported", r.doc.Asset.Version)
+	}
+	for _, ext := range r.doc.ExtensionsRequired {
+		if _, supported := gltfSupportedExtensions[ext]; !supported {
+			return fmt.Errorf("unsupported required glTF extension %q", ext)
+		}
+	}
+
+	// Select the root nodes of the default scene. If the document does not
+	// define any scenes, all nodes without a parent are used instead.
+	var roots []int
+	switch {
+	case r.doc.Scene != nil && *r.doc.Scene >= 0 && *r.doc.Scene < len(r.doc.Scenes):
+		roots = r.doc.Scenes[*r.doc.Scene].Nodes
+	case len(r.doc.Scenes) > 0:
+		roots = r.doc.Scenes[0].Nodes
+	default:
+		isChild := make([]bool, len(r.doc.Nodes))
+		for _, node := range r.doc.Nodes {
+			for _, child := range node.Children {
+				if child >= 0 && child < len(isChild) {
+					isChild[child] = true
+				}
+			}
+		}
+		for nodeIndex := range r.doc.Nodes {
+			if !isChild[nodeIndex] {
+				roots = append(roots, nodeIndex)
+			}
+		}
+	}
+
+	for _, nodeIndex := range roots {
+		if err = r.parseNode(nodeIndex, types.Ident4(), 0); err != nil {
+			return err
+		}
+	}
+
+	return r.createLights()
+}
+
+// Extract the JSON and binary chunks from a binary glTF container.
+func (r *gltfSceneReader) parseGlb(data []byte) ([]byte, error) {
+	if len(data) < glbHeaderSize {
+		return nil, fmt.Errorf("truncated glb header")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, fmt.Errorf("unsupported glb container version %d", version)
+	}
+
+	var jsonChunk []byte
+	for offset := glbHeaderSize; offset+glbChunkHeadSize <= len(data); {
+		chunkLen := int(binary.LittleEndian.Uint32(data[offset:]))
+		chunkType := binary.LittleEndian.Uint32(data[offset+4:])
+		offset += glbChunkHeadSize
+		if chunkLen < 0 || chunkLen > len(data)-offset {
+			return nil, fmt.Errorf("truncated glb chunk")
+		}
+
+		chunk := data[offset : offset+chunkLen]
+		switch {
+		case chunkType == glbChunkJSON && jsonChunk == nil:
+			jsonChunk = chunk
+		case chunkType == glbChunkBIN && r.glbBin == nil:
+			r.glbBin = chunk
+		}
+		offset += chunkLen
+	}
+
+	if jsonChunk == nil {
+		return nil, fmt.Errorf("glb container does not contain a JSON chunk")
+	}
+	return jsonChunk, nil
+}
+
+// Process a node and its children. The parent argument contains the
+// transformation from the parent node space to world space.
+func (r *gltfSceneReader) parseNode(nodeIndex int, parent types.Mat4, depth int) error {
+	if nodeIndex < 0 || nodeIndex >= len(r.doc.Nodes) {
+		return fmt.Errorf("reference to undefined node %d", nodeIndex)
+	}
+	if depth > len(r.doc.Nodes) {
+		return fmt.Errorf("node hierarchy contains a cycle")
+	}
+
+	node := &r.doc.Nodes[nodeIndex]
+	transform := parent.Mul4(gltfNodeTransform(node))
+
+	if node.Mesh != nil {
+		meshIndex, err := r.mesh(*node.Mesh)
+		if err != nil {
+			return err
+		}
+		if meshIndex != -1 {
+			r.addMeshInstance(meshIndex, transform)
+		}
+	}
+
+	if node.Camera != nil {
+		if err := r.addCamera(*node.Camera, transform); err != nil {
+			return err
+		}
+	}
+
+	if ext := node.Extensions.LightsPunctual; ext != nil {
+		lights := r.doc.Extensions.LightsPunctual.Lights
+		if ext.Light < 0 || ext.Light >= len(lights) {
+			return fmt.Errorf("node %d references undefined light %d", nodeIndex, ext.Light)
+		}
+
+		r.lights = append(r.lights, gltfPendingLight{
+			light:     &lights[ext.Light],
+			name:      gltfName(lights[ext.Light].Name, "light", ext.Light),
+			transform: transform,
+		})
+	}
+
+	for _, child := range node.Children {
+		if err := r.parseNode(child, transform, depth+1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the local transformation matrix for a node. Nodes either define a
+// column-major matrix or a translation, rotation and scale (M = T * R * S).
+func gltfNodeTransform(node *gltfNode) types.Mat4 {
+	if len(node.Matrix) == 16 {
+		var m types.Mat4
+		copy(m[:], node.Matrix)
+		return m
+	}
+
+	m := types.Ident4()
+	if len(node.Translation) == 3 {
+		m = types.Translate4(types.Vec3{node.Translation[0], node.Translation[1], node.Translation[2]})
+	}
+	if len(node.Rotation) == 4 {
+		rot := types.Quat{
+			V: types.Vec3{node.Rotation[0], node.Rotation[1], node.Rotation[2]},
+			W: node.Rotation[3],
+		}
+		m = m.Mul4(rot.Normalize().Mat4())
+	}
+	if len(node.Scale) == 3 {
+		m = m.Mul4(types.Scale4(types.Vec3{node.Scale[0], node.Scale[1], node.Scale[2]}))
+	}
+	return m
+}
+
+// Add a mesh instance with the given local to world transformation.
+func (r *gltfSceneReader) addMeshInstance(meshIndex int, transform types.Mat4) {
+	// Transform the mesh bbox corners and calculate the instance AABB
+	meshBBox := r.rawScene.Meshes[meshIndex].BBox()
+	instBBox := [2]types.Vec3{
+		{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32},
+		{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32},
+	}
+	for corner := 0; corner < 8; corner++ {
+		p := types.Vec3{meshBBox[corner&1][0], meshBBox[(corner>>1)&1][1], meshBBox[corner>>2][2]}
+		p = transform.Mul4x1(p.Vec4(1)).Vec3()
+		instBBox[0] = types.MinVec3(instBBox[0], p)
+		instBBox[1] = types.MaxVec3(instBBox[1], p)
+	}
+
+	inst := &input.MeshInstance{
+		MeshIndex: uint32(meshIndex),
+		Transform: transform,
+	}
+	inst.SetBBox(instBBox)
+	inst.SetCenter(instBBox[0].Add(instBBox[1]).Mul(0.5))
+	r.rawScene.MeshInstances = append(r.rawScene.MeshInstances, inst)
+}
+
+// Add a camera positioned using the given local to world transformation.
+// glTF cameras look down their local -Z axis. The first camera becomes the
+// active scene camera.
+func (r *gltfSceneReader) addCamera(cameraIndex int, transform types.Mat4) error {
+	if cameraIndex < 0 || cameraIndex >= len(r.doc.Cameras) {
+		return fmt.Errorf("reference to undefined camera %d", cameraIndex)
+	}
+
+	gc := &r.doc.Cameras[cameraIndex]
+	name := gltfName(gc.Name, "camera", cameraIndex)
+	if gc.Type != "perspective" || gc.Perspective == nil {
+		return r.warn("skipping %s camera %q; only perspective cameras are supported", gc.Type, name)
+	}
+
+	cam := input.NewCamera(r.uniqueName(name))
+	cam.FOV = gc.Perspective.Yfov * 180.0 / math.Pi
+	cam.Eye = transform.Mul4x1(types.Vec4{0, 0, 0, 1}).Vec3()
+	cam.Look = cam.Eye.Add(transform.Mul4x1(types.Vec4{0, 0, -1, 0}).Vec3().Normalize())
+	cam.Up = transform.Mul4x1(types.Vec4{0, 1, 0, 0}).Vec3().Normalize()
+
+	if !r.hasCamera {
+		r.rawScene.Camera = cam
+		r.rawScene.Cameras = []*input.Camera{cam}
+		r.hasCamera = true
+	} else {
+		r.rawScene.Cameras = append(r.rawScene.Cameras, cam)
+	}
+	return nil
+}
+
+// Get the raw scene index for a glTF mesh, converting it if required. Returns
+// -1 if the mesh does not contain any triangles.
+func (r *gltfSceneReader) mesh(gltfIndex int) (int, error) {
+	if meshIndex, exists := r.meshIndices[gltfIndex]; exists {
+		return meshIndex, nil
+	}
+	if gltfIndex < 0 || gltfIndex >= len(r.doc.Meshes) {
+		return -1, fmt.Errorf("reference to undefined mesh %d", gltfIndex)
+	}
+
+	gm := &r.doc.Meshes[gltfIndex]
+	mesh := input.NewMesh(gltfName(gm.Name, "mesh", gltfIndex))
+	for primIndex := range gm.Primitives {
+		primList, err := r.parsePrimitive(&gm.Primitives[primIndex])
+		if err != nil {
+			return -1, fmt.Errorf("mesh %q: primitive %d: %s", mesh.Name, primIndex, err.Error())
+		}
+		mesh.Primitives = append(mesh.Primitives, primList...)
+	}
+
+	meshIndex := -1
+	if len(mesh.Primitives) == 0 {
+		r.logger.Warningf(`dropping mesh "%s" as it contains no triangles`, mesh.Name)
+	} else {
+		r.rawScene.Meshes = append(r.rawScene.Meshes, mesh)
+		meshIndex = len(r.rawScene.Meshes) - 1
+	}
+	r.meshIndices[gltfIndex] = meshIndex
+	return meshIndex, nil
+}
+
+// Convert a glTF mesh primitive into a list of triangles.
+func (r *gltfSceneReader) parsePrimitive(gp *gltfPrimitive) ([]*input.Primitive, error) {
+	mode := gltfTriangles
+	if gp.Mode != nil {
+		mode = *gp.Mode
+	}
+	if mode != gltfTriangles && mode != gltfTriangleStrip && mode != gltfTriangleFan {
+		return nil, r.warn("skipping primitive with unsupported mode %d; only triangles are supported", mode)
+	}
+
+	posIndex, hasPositions := gp.Attributes["POSITION"]
+	if !hasPositions {
+		return nil, r.warn("skipping primitive without a POSITION attribute")
+	}
+	positions, err := r.readAccessor(posIndex, "VEC3")
+	if err != nil {
+		return nil, err
+	}
+	if err = r.limits.checkElements("vertices", positions.count); err != nil {
+		return nil, err
+	}
+
+	var normals, tangents, uvs *gltfAccessorData
+	if index, exists := gp.Attributes["NORMAL"]; exists {
+		if normals, err = r.readAccessor(index, "VEC3"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tangents are only meaningful when paired with the vertex normals;
+	// if they are missing the compiler generates them from the uvs.
+	if index, exists := gp.Attributes["TANGENT"]; exists && normals != nil {
+		if tangents, err = r.readAccessor(index, "VEC4"); err != nil {
+			return nil, err
+		}
+	}
+	if index, exists := gp.Attributes["TEXCOORD_0"]; exists {
+		if uvs, err = r.readAccessor(index, "VEC2"); err != nil {
+			return nil, err
+		}
+	}
+	if (normals != nil && normals.count < positions.count) || (tangents != nil && tangents.count < positions.count) || (uvs != nil && uvs.count < positions.count) {
+		return nil, fmt.Errorf("vertex attributes contain fewer elements than the POSITION attribute")
+	}
+
+	// Assemble the vertex indices
+	var indices []uint32
+	if gp.Indices != nil {
+		indexData, err := r.readAccessor(*gp.Indices, "SCALAR")
+		if err != nil {
+			return nil, err
+		}
+		indices = make([]uint32, indexData.count)
+		for i := range indices {
+			indices[i] = indexData.Uint(i)
+			if int(indices[i]) >= positions.count {
+				return nil, fmt.Errorf("vertex index %d is out of range", indices[i])
+			}
+		}
+	} else {
+		indices = make([]uint32, positions.count)
+		for i := range indices {
+			indices[i] = uint32(i)
+		}
+	}
+
+	var triangles [][3]uint32
+	switch mode {
+	case gltfTriangles:
+		for i := 0; i+2 < len(indices); i += 3 {
+			triangles = append(triangles, [3]uint32{indices[i], indices[i+1], indices[i+2]})
+		}
+	case gltfTriangleStrip:
+		for i := 0; i+2 < len(indices); i++ {
+			if i%2 == 0 {
+				triangles = append(triangles, [3]uint32{indices[i], indices[i+1], indices[i+2]})
+			} else {
+				triangles = append(triangles, [3]uint32{indices[i+1], indices[i], indices[i+2]})
+			}
+		}
+	case gltfTriangleFan:
+		for i := 1; i+1 < len(indices); i++ {
+			triangles = append(triangles, [3]uint32{indices[0], indices[i], indices[i+1]})
+		}
+	}
+
+	r.numPrimitives += len(triangles)
+	if err = r.limits.checkElements("primitives", r.numPrimitives); err != nil {
+		return nil, err
+	}
+
+	matIndex, err := r.material(gp.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	primitives := make([]*input.Primitive, 0, len(triangles))
+	for _, tri := range triangles {
+		prim := &input.Primitive{MaterialIndex: matIndex}
+		for v, index := range tri {
+			prim.Vertices[v] = types.Vec3{positions.Float(int(index), 0), positions.Float(int(index), 1), positions.Float(int(index), 2)}
+			if normals != nil {
+				prim.Normals[v] = types.Vec3{normals.Float(int(index), 0), normals.Float(int(index), 1), normals.Float(int(index), 2)}
+			}
+			if tangents != nil {
+				prim.Tangents[v] = types.Vec4{tangents.Float(int(index), 0), tangents.Float(int(index), 1), tangents.Float(int(index), 2), tangents.Float(int(index), 3)}
+			}
+			if uvs != nil {
+				prim.UVs[v] = types.Vec2{uvs.Float(int(index), 0), uvs.Float(int(index), 1)}
+			}
+		}
+
+		// If no normals are available generate them from the vertices
+		if normals == nil {
+			faceNormal := prim.Vertices[1].Sub(prim.Vertices[0]).Cross(prim.Vertices[2].Sub(prim.Vertices[0])).Normalize()
+			prim.Normals = [3]types.Vec3{faceNormal, faceNormal, faceNormal}
+		}
+
+		prim.SetBBox(
+			[2]types.Vec3{
+				types.MinVec3(prim.Vertices[0], types.MinVec3(prim.Vertices[1], prim.Vertices[2])),
+				types.MaxVec3(prim.Vertices[0], types.MaxVec3(prim.Vertices[1], prim.Vertices[2])),
+			},
+		)
+		prim.SetCenter(prim.Vertices[0].Add(prim.Vertices[1]).Add(prim.Vertices[2]).Mul(1.0 / 3.0))
+		primitives = append(primitives, prim)
+	}
+
+	return primitives, nil
+}
+
+// Get the raw scene index for a glTF material, converting it if required.
+// Primitives without a material use the glTF default material.
+func (r *gltfSceneReader) material(gltfIndex *int) (int, error) {
+	key := -1
+	if gltfIndex != nil {
+		key = *gltfIndex
+	}
+	if matIndex, exists := r.matIndices[key]; exists {
+		return matIndex, nil
+	}
+
+	var gm *gltfMaterial
+	var name string
+	if key == -1 {
+		gm = &gltfMaterial{}
+		name = r.uniqueName("gltf_default")
+	} else if key >= 0 && key < len(r.doc.Materials) {
+		gm = &r.doc.Materials[key]
+		name = r.uniqueName(gltfName(gm.Name, "material", key))
+	} else {
+		return -1, fmt.Errorf("reference to undefined material %d", key)
+	}
+
+	expr, err := r.materialExpression(gm, name)
+	if err != nil {
+		return -1, fmt.Errorf("material %q: %s", name, err.Error())
+	}
+
+	// glTF roughness values are perceptual unless the importer options
+	// explicitly select a different convention.
+	conv := r.roughnessConvention
+	if conv == material.RoughnessAuto {
+		conv = material.RoughnessPerceptual
+	}
+
+	r.rawScene.Materials = append(r.rawScene.Materials, &input.Material{
+		Name:                name,
+		Expression:          expr,
+		AssetRelPath:        r.sceneRes,
+		RoughnessConvention: conv,
+		Used:                true,
+	})
+	r.matIndices[key] = len(r.rawScene.Materials) - 1
+	return r.matIndices[key], nil
+}
+
+// Generate a material expression for a glTF metallic-roughness material.
+// The metallic-roughness model maps directly to the parameters of the
+// principled bxdf; the factors of the KHR_materials_* extensions that extend
+// the model are mapped to the matching principled parameters. Properties that
+// cannot be represented are recorded to the conversion report.
+func (r *gltfSceneReader) materialExpression(gm *gltfMaterial, name string) (string, error) {
+	pbr := &gm.PbrMetallicRoughness
+
+	// Emissive materials
+	emissive := types.Vec3{}
+	if len(gm.EmissiveFactor) == 3 {
+		emissive = types.Vec3{gm.EmissiveFactor[0], gm.EmissiveFactor[1], gm.EmissiveFactor[2]}
+	}
+	if emissive.MaxComponent() > 0 {
+		return r.emissiveExpression(gm, name, emissive)
+	}
+
+	baseColor := types.Vec3{1, 1, 1}
+	if len(pbr.BaseColorFactor) >= 3 {
+		baseColor = types.Vec3{pbr.BaseColorFactor[0], pbr.BaseColorFactor[1], pbr.BaseColorFactor[2]}
+	}
+	metallic, roughness := float32(1), float32(1)
+	if pbr.MetallicFactor != nil {
+		metallic = *pbr.MetallicFactor
+	}
+	if pbr.RoughnessFactor != nil {
+		roughness = *pbr.RoughnessFactor
+	}
+	metallic = clampf(metallic, 0, 1)
+	roughness = clampf(roughness, 0, 1)
+
+	baseColorParam := gltfFormatVec3(clampVec3(baseColor, 1.0))
+	if pbr.BaseColorTexture != nil {
+		texPath, err := r.texturePath(pbr.BaseColorTexture, name, "baseColorTexture", -1, 1)
+		if err != nil {
+			return "", err
+		}
+		baseColorParam = strconv.Quote(texPath)
+		if baseColor != (types.Vec3{1, 1, 1}) {
+			r.report.Convert(name, "baseColorFactor", compiler.ConversionDropped, "the factor is not applied to the baseColorTexture")
+		}
+	}
+
+	// The roughness and metallic values are stored in the G and B
+	// channels of the metallic-roughness texture. Each one is extracted to
+	// a separate texture and scaled by its factor.
+	roughnessParam := gltfFormatFloat(roughness)
+	metallicParam := gltfFormatFloat(metallic)
+	if pbr.MetallicRoughnessTexture != nil {
+		roughTex, err := r.texturePath(pbr.MetallicRoughnessTexture, name, "metallicRoughnessTexture", 1, roughness)
+		if err != nil {
+			return "", err
+		}
+		metallicTex, err := r.texturePath(pbr.MetallicRoughnessTexture, name, "metallicRoughnessTexture", 2, metallic)
+		if err != nil {
+			return "", err
+		}
+		roughnessParam, metallicParam = strconv.Quote(roughTex), strconv.Quote(metallicTex)
+	}
+
+	params := []string{
+		fmt.Sprintf("%s: %s", material.ParamBaseColor, baseColorParam),
+		fmt.Sprintf("%s: %s", material.ParamMetallic, metallicParam),
+		fmt.Sprintf("%s: %s", material.ParamRoughness, roughnessParam),
+	}
+	extParams, err := r.principledExtensionParams(gm, name)
+	if err != nil {
+		return "", err
+	}
+	expr := fmt.Sprintf("%s(%s)", material.BxdfPrincipled, strings.Join(append(params, extParams...), ", "))
+
+	if gm.NormalTexture != nil {
+		texPath, err := r.texturePath(gm.NormalTexture, name, "normalTexture", -1, 1)
+		if err != nil {
+			return "", err
+		}
+		expr = fmt.Sprintf("normalMap(%s, %q)", expr, texPath)
+		if gm.NormalTexture.Scale != nil && *gm.NormalTexture.Scale != 1 {
+			r.report.Convert(name, "normalTexture.scale", compiler.ConversionDropped, "normal maps are always applied at full strength")
+		}
+	}
+
+	// Masked materials use the alpha channel of the base color texture
+	// scaled by the base color alpha factor as an opacity texture
+	if gm.AlphaMode == "MASK" {
+		cutoff := material.DefaultAlphaCutoff
+		if gm.AlphaCutoff != nil {
+			cutoff = *gm.AlphaCutoff
+		}
+		switch {
+		case pbr.BaseColorTexture == nil:
+			r.report.Convert(name, "alphaMode", compiler.ConversionDropped, "alpha masks require a baseColorTexture")
+		case cutoff > 0:
+			alpha := float32(1)
+			if len(pbr.BaseColorFactor) == 4 {
+				alpha = pbr.BaseColorFactor[3]
+			}
+			texPath, err := r.texturePath(pbr.BaseColorTexture, name, "baseColorTexture", 3, alpha)
+			if err != nil {
+				return "", err
+			}
+			expr = fmt.Sprintf("alphaCutout(%s, %q, %s)", expr, texPath, gltfFormatFloat(clampf(cutoff, 0, 1)))
+		}
+	}
+
+	r.reportUnsupported(gm, name)
+	return expr, nil
+}
+
+// Map the factors of the material extensions that extend the
+// metallic-roughness model to principled bxdf parameters. The textures
+// supplied by the extensions are not supported and are recorded to the
+// conversion report.
+func (r *gltfSceneReader) principledExtensionParams(gm *gltfMaterial, name string) ([]string, error) {
+	var ext gltfPrincipledExtensions
+	if len(gm.Extensions) != 0 {
+		raw, err := json.Marshal(gm.Extensions)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(raw, &ext); err != nil {
+			return nil, err
+		}
+	}
+
+	var params []string
+	dropTexture := func(ext string, info *gltfTextureInfo) {
+		if info != nil {
+			r.report.Convert(name, ext, compiler.ConversionDropped, "extension textures are not supported; only the factors are used")
+		}
+	}
+
+	// The dielectric specular reflectance (F0) depends on the IOR and
+	// the specular factors. The default glTF IOR of 1.5 corresponds to
+	// the default principled specular value.
+	if ext.IOR != nil || ext.Specular != nil {
+		ior := gltfDefaultIOR
+		if ext.IOR != nil && ext.IOR.IOR != nil {
+			ior = *ext.IOR.IOR
+			params = append(params, fmt.Sprintf("%s: %s", material.ParamIntIOR, gltfFormatFloat(ior)))
+		}
+		f0 := (ior - 1) / (ior + 1)
+		f0 *= f0
+		if ext.Specular != nil {
+			if ext.Specular.SpecularFactor != nil {
+				f0 *= *ext.Specular.SpecularFactor
+			}
+			if c := ext.Specular.SpecularColorFactor; len(c) == 3 && (c[0] != 1 || c[1] != 1 || c[2] != 1) {
+				f0 *= (c[0] + c[1] + c[2]) / 3
+				r.report.Convert(name, "KHR_materials_specular", compiler.ConversionApproximated, "the specular color is replaced by its average intensity")
+			}
+			dropTexture("KHR_materials_specular", ext.Specular.SpecularTexture)
+			dropTexture("KHR_materials_specular", ext.Specular.SpecularColorTexture)
+		}
+
+		// The principled dielectric F0 is 0.08 * specular
+		params = append(params, fmt.Sprintf("%s: %s", material.ParamSpecular, gltfFormatFloat(clampf(f0/0.08, 0, 1))))
+	}
+
+	if ext.Sheen != nil {
+		if c := ext.Sheen.SheenColorFactor; len(c) == 3 {
+			if sheen := clampf(types.Vec3{c[0], c[1], c[2]}.MaxComponent(), 0, 1); sheen > 0 {
+				params = append(params, fmt.Sprintf("%s: %s", material.ParamSheen, gltfFormatFloat(sheen)))
+				r.report.Convert(name, "KHR_materials_sheen", compiler.ConversionApproximated, "the sheen color is approximated by its largest component tinted by the base color")
+			}
+		}
+		if ext.Sheen.SheenRoughnessFactor != nil && *ext.Sheen.SheenRoughnessFactor != 0 {
+			r.report.Convert(name, "KHR_materials_sheen", compiler.ConversionDropped, "the sheen roughness is not supported")
+		}
+		dropTexture("KHR_materials_sheen", ext.Sheen.SheenColorTexture)
+		dropTexture("KHR_materials_sheen", ext.Sheen.SheenRoughnessTexture)
+	}
+
+	if ext.Clearcoat != nil {
+		if ext.Clearcoat.ClearcoatFactor != nil && *ext.Clearcoat.ClearcoatFactor > 0 {
+			params = append(params, fmt.Sprintf("%s: %s", material.ParamClearcoat, gltfFormatFloat(clampf(*ext.Clearcoat.ClearcoatFactor, 0, 1))))
+		}
+		if ext.Clearcoat.ClearcoatRoughnessFactor != nil && *ext.Clearcoat.ClearcoatRoughnessFactor != 0 {
+			r.report.Convert(name, "KHR_materials_clearcoat", compiler.ConversionApproximated, "the clearcoat layer always uses a low fixed roughness")
+		}
+		dropTexture("KHR_materials_clearcoat", ext.Clearcoat.ClearcoatTexture)
+		dropTexture("KHR_materials_clearcoat", ext.Clearcoat.ClearcoatRoughnessTexture)
+		dropTexture("KHR_materials_clearcoat", ext.Clearcoat.ClearcoatNormalTexture)
+	}
+
+	if ext.Transmission != nil {
+		if ext.Transmission.TransmissionFactor != nil && *ext.Transmission.TransmissionFactor > 0 {
+			params = append(params, fmt.Sprintf("%s: %s", material.ParamTransmission, gltfFormatFloat(clampf(*ext.Transmission.TransmissionFactor, 0, 1))))
+		}
+		dropTexture("KHR_materials_transmission", ext.Transmission.TransmissionTexture)
+	}
+
+	return params, nil
+}
+
+// Generate an emissive material expression. The base material of emissive
+// surfaces is not used.
+func (r *gltfSceneReader) emissiveExpression(gm *gltfMaterial, name string, emissive types.Vec3) (string, error) {
+	scale := float32(1)
+	if raw, exists := gm.Extensions["KHR_materials_emissive_strength"]; exists {
+		var ext struct {
+			EmissiveStrength *float32 `json:"emissiveStrength"`
+		}
+		if err := json.Unmarshal(raw, &ext); err != nil {
+			return "", err
+		}
+		if ext.EmissiveStrength != nil {
+			scale = *ext.EmissiveStrength
+		}
+	}
+
+	radiance := gltfFormatVec3(emissive)
+	if gm.EmissiveTexture != nil {
+		texPath, err := r.texturePath(gm.EmissiveTexture, name, "emissiveTexture", -1, 1)
+		if err != nil {
+			return "", err
+		}
+		radiance = strconv.Quote(texPath)
+
+		// Textures can only be scaled uniformly
+		scale *= emissive.MaxComponent()
+		if emissive[0] != emissive[1] || emissive[0] != emissive[2] {
+			r.report.Convert(name, "emissiveFactor", compiler.ConversionApproximated, "the emissiveTexture is scaled by the largest emissiveFactor component")
+		}
+	}
+
+	r.report.Convert(name, "pbrMetallicRoughness", compiler.ConversionDropped, "emissive surfaces do not reflect light")
+	if gm.AlphaMode == "MASK" {
+		r.report.Convert(name, "alphaMode", compiler.ConversionDropped, "alpha masks are not supported by emissive materials")
+	}
+	for ext := range gm.Extensions {
+		if _, supported := gltfSupportedExtensions[ext]; supported && strings.HasPrefix(ext, "KHR_materials_") && ext != "KHR_materials_emissive_strength" {
+			r.report.Convert(name, ext, compiler.ConversionDropped, "emissive surfaces do not reflect light")
+		}
+	}
+	r.reportUnsupported(gm, name)
+	return fmt.Sprintf("%s(%s: %s, %s: %s)", material.BxdfEmissive, material.ParamRadiance, radiance, material.ParamScale, gltfFormatFloat(scale)), nil
+}
+
+// Record the material properties that are not supported by polaris.
+func (r *gltfSceneReader) reportUnsupported(gm *gltfMaterial, name string) {
+	if gm.OcclusionTexture != nil {
+		r.report.Convert(name, "occlusionTexture", compiler.ConversionDropped, "occlusion is computed by the path tracer")
+	}
+	if gm.AlphaMode == "BLEND" {
+		r.report.Convert(name, "alphaMode", compiler.ConversionDropped, "partial transparency is not supported")
+	}
+	for ext := range gm.Extensions {
+		if _, supported := gltfSupportedExtensions[ext]; !supported {
+			r.report.Convert(name, ext, compiler.ConversionDropped, "unsupported glTF extension")
+		}
+	}
+}
+
+// Get a path that the scene compiler can use for loading the image referenced
+// by a texture. If channel is not -1, the specified channel is extracted into
+// a grayscale image and multiplied by factor.
+func (r *gltfSceneReader) texturePath(info *gltfTextureInfo, matName, prop string, channel int, factor float32) (string, error) {
+	if info.Index < 0 || info.Index >= len(r.doc.Textures) || r.doc.Textures[info.Index].Source == nil {
+		return "", fmt.Errorf("%s references undefined texture %d", prop, info.Index)
+	}
+	imageIndex := *r.doc.Textures[info.Index].Source
+	if imageIndex < 0 || imageIndex >= len(r.doc.Images) {
+		return "", fmt.Errorf("texture %d references undefined image %d", info.Index, imageIndex)
+	}
+	if info.TexCoord != 0 {
+		r.report.Convert(matName, prop, compiler.ConversionApproximated, "the texture is mapped using TEXCOORD_0 instead of TEXCOORD_%d", info.TexCoord)
+	}
+
+	key := gltfImageKey{imageIndex, channel, factor}
+	if path, exists := r.imagePaths[key]; exists {
+		return path, nil
+	}
+
+	img := &r.doc.Images[imageIndex]
+	var path string
+	var err error
+	switch {
+	case channel == -1 && img.BufferView == nil && !strings.HasPrefix(img.URI, "data:"):
+		// External images are loaded by the compiler relative to the document
+		path, err = url.PathUnescape(img.URI)
+	default:
+		var data []byte
+		if data, err = r.imageData(img); err != nil {
+			return "", fmt.Errorf("image %d: %s", imageIndex, err.Error())
+		}
+
+		if channel == -1 {
+			ext := ".png"
+			if img.MimeType == "image/jpeg" {
+				ext = ".jpg"
+			}
+			path, err = r.writeTempFile(fmt.Sprintf("image%d%s", imageIndex, ext), data)
+		} else {
+			path, err = r.extractChannel(data, fmt.Sprintf("image%d-%d-%s.png", imageIndex, channel, gltfFormatFloat(factor)), channel, factor)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("image %d: %s", imageIndex, err.Error())
+	}
+
+	r.imagePaths[key] = path
+	return path, nil
+}
+
+// Load the encoded contents of an image.
+func (r *gltfSceneReader) imageData(img *gltfImage) ([]byte, error) {
+	if img.BufferView != nil {
+		return r.bufferView(*img.BufferView)
+	}
+	return r.loadURI(img.URI)
+}
+
+// Decode an image and write the selected channel, multiplied by factor, to a
+// grayscale PNG image in the temp folder.
+func (r *gltfSceneReader) extractChannel(data []byte, name string, channel int, factor float32) (string, error) {
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(src.At(x, y)).(color.NRGBA)
+			v := [4]uint8{c.R, c.G, c.B, c.A}[channel]
+			dst.SetGray(x, y, color.Gray{Y: uint8(math.Min(255, math.Floor(float64(v)*float64(factor)+0.5)))})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, dst); err != nil {
+		return "", err
+	}
+	return r.writeTempFile(name, buf.Bytes())
+}
+
+// Write a file to the reader temp folder, creating the folder if required,
+// and return back its absolute path.
+func (r *gltfSceneReader) writeTempFile(name string, data []byte) (string, error) {
+	if r.tmpDir == "" {
+		var err error
+		if r.tmpDir, err = ioutil.TempDir("", "polaris-gltf"); err != nil {
+			return "", err
+		}
+	}
+
+	path := filepath.Join(r.tmpDir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Read an accessor and verify that its elements have the expected type.
+func (r *gltfSceneReader) readAccessor(index int, expType string) (*gltfAccessorData, error) {
+	if index < 0 || index >= len(r.doc.Accessors) {
+		return nil, fmt.Errorf("reference to undefined accessor %d", index)
+	}
+
+	acc := &r.doc.Accessors[index]
+	if acc.Type != expType {
+		return nil, fmt.Errorf("accessor %d: expected element type %s; got %s", index, expType, acc.Type)
+	}
+	if len(acc.Sparse) != 0 {
+		return nil, fmt.Errorf("accessor %d: sparse accessors are not supported", index)
+	}
+	if acc.BufferView == nil {
+		return nil, fmt.Errorf("accessor %d: accessors without a buffer view are not supported", index)
+	}
+
+	compSize := gltfComponentSize(acc.ComponentType)
+	if compSize == 0 {
+		return nil, fmt.Errorf("accessor %d: unsupported component type %d", index, acc.ComponentType)
+	}
+	if expType == "SCALAR" && acc.ComponentType != gltfUnsignedByte && acc.ComponentType != gltfUnsignedShort && acc.ComponentType != gltfUnsignedInt {
+		return nil, fmt.Errorf("accessor %d: unsupported index component type %d", index, acc.ComponentType)
+	}
+
+	viewData, err := r.bufferView(*acc.BufferView)
+	if err != nil {
+		return nil, fmt.Errorf("accessor %d: %s", index, err.Error())
+	}
+
+	data := &gltfAccessorData{
+		count:         acc.Count,
+		components:    map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4}[expType],
+		componentType: acc.ComponentType,
+		normalized:    acc.Normalized,
+	}
+	elemSize := data.components * compSize
+	data.stride = r.doc.BufferViews[*acc.BufferView].ByteStride
+	if data.stride == 0 {
+		data.stride = elemSize
+	}
+
+	if acc.Count < 0 || acc.ByteOffset < 0 || data.stride < elemSize || acc.ByteOffset > len(viewData) {
+		return nil, fmt.Errorf("accessor %d: invalid layout", index)
+	}
+	data.data = viewData[acc.ByteOffset:]
+	if acc.Count > 0 && (acc.Count-1) > (len(data.data)-elemSize)/data.stride {
+		return nil, fmt.Errorf("accessor %d: elements exceed the buffer view bounds", index)
+	}
+	return data, nil
+}
+
+// Get the contents of a buffer view.
+func (r *gltfSceneReader) bufferView(index int) ([]byte, error) {
+	if index < 0 || index >= len(r.doc.BufferViews) {
+		return nil, fmt.Errorf("reference to undefined buffer view %d", index)
+	}
+
+	view := &r.doc.BufferViews[index]
+	buf, err := r.buffer(view.Buffer)
+	if err != nil {
+		return nil, err
+	}
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset > len(buf) || view.ByteLength > len(buf)-view.ByteOffset {
+		return nil, fmt.Errorf("buffer view %d exceeds the bounds of buffer %d", index, view.Buffer)
+	}
+	return buf[view.ByteOffset : view.ByteOffset+view.ByteLength], nil
+}
+
+// Get the contents of a buffer, loading it if required. Buffers without a
+// URI refer to the binary chunk of .glb files.
+func (r *gltfSceneReader) buffer(index int) ([]byte, error) {
+	if data, loaded := r.buffers[index]; loaded {
+		return data, nil
+	}
+	if index < 0 || index >= len(r.doc.Buffers) {
+		return nil, fmt.Errorf("reference to undefined buffer %d", index)
+	}
+
+	var data []byte
+	var err error
+	if uri := r.doc.Buffers[index].URI; uri == "" {
+		if index != 0 || r.glbBin == nil {
+			return nil, fmt.Errorf("buffer %d does not define a URI", index)
+		}
+		data = r.glbBin
+	} else if data, err = r.loadURI(uri); err != nil {
+		return nil, fmt.Errorf("buffer %d: %s", index, err.Error())
+	}
+
+	if len(data) < r.doc.Buffers[index].ByteLength {
+		return nil, fmt.Errorf("buffer %d contains %d bytes; expected %d", index, len(data), r.doc.Buffers[index].ByteLength)
+	}
+	r.buffers[index] = data
+	return data, nil
+}
+
+// Load the contents of a base64 data URI or a resource relative to the
+// document.
+func (r *gltfSceneReader) loadURI(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		sep := strings.Index(uri, ",")
+		if sep == -1 || !strings.HasSuffix(uri[:sep], ";base64") {
+			return nil, fmt.Errorf("only base64 data URIs are supported")
+		}
+		return base64.StdEncoding.DecodeString(uri[sep+1:])
+	}
+
+	// External files are treated as includes
+	if r.limits.MaxIncludeDepth <= 0 {
+		return nil, ErrIncludeDepthExceeded
+	}
+
+	path, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+	res, err := asset.NewResource(path, r.sceneRes)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+	return ioutil.ReadAll(r.limits.limitReader(res))
+}
+
+// Convert the punctual lights into emissive geometry. Point and spot lights
+// are approximated by small emissive octahedra while directional lights are
+// approximated by distant emissive quads that face the scene.
+func (r *gltfSceneReader) createLights() error {
+	if len(r.lights) == 0 {
+		return nil
+	}
+
+	// Calculate the scene bounds
+	bbox := [2]types.Vec3{{-1, -1, -1}, {1, 1, 1}}
+	if len(r.rawScene.MeshInstances) != 0 {
+		bbox = r.rawScene.MeshInstances[0].BBox()
+		for _, mi := range r.rawScene.MeshInstances[1:] {
+			miBBox := mi.BBox()
+			bbox[0] = types.MinVec3(bbox[0], miBBox[0])
+			bbox[1] = types.MaxVec3(bbox[1], miBBox[1])
+		}
+	}
+	sceneCenter := bbox[0].Add(bbox[1]).Mul(0.5)
+	sceneRadius := float32(math.Max(float64(bbox[1].Sub(bbox[0]).Len()*0.5), 1))
+
+	for _, pl := range r.lights {
+		lightColor := types.Vec3{1, 1, 1}
+		if len(pl.light.Color) == 3 {
+			lightColor = types.Vec3{pl.light.Color[0], pl.light.Color[1], pl.light.Color[2]}
+		}
+		intensity := float32(1)
+		if pl.light.Intensity != nil {
+			intensity = *pl.light.Intensity
+		}
+
+		matName := r.uniqueName("light_" + pl.name)
+		var vertices [][3]types.Vec3
+		var scale float32
+		switch pl.light.Type {
+		case "point", "spot":
+			// The intensity (in candela) of a sphere with radius r and
+			// radiance L is L * pi * r^2
+			center := pl.transform.Mul4x1(types.Vec4{0, 0, 0, 1}).Vec3()
+			vertices = gltfOctahedron(center, gltfPointLightRadius)
+			scale = intensity / (math.Pi * gltfPointLightRadius * gltfPointLightRadius)
+			if pl.light.Type == "spot" {
+				r.report.Convert(matName, "spot", compiler.ConversionApproximated, "spot lights are rendered as point lights")
+			}
+			r.report.Convert(matName, pl.light.Type, compiler.ConversionApproximated, "rendered as an emissive sphere with radius %s", gltfFormatFloat(gltfPointLightRadius))
+		case "directional":
+			// The illuminance (in lux) of a distant emitter with radiance L
+			// that subtends a solid angle w is L * w
+			dir := pl.transform.Mul4x1(types.Vec4{0, 0, -1, 0}).Vec3().Normalize()
+			dist := 10 * sceneRadius
+			halfSize := dist * float32(math.Tan(gltfDirectionalLightAngle*math.Pi/360.0))
+			vertices = gltfQuad(sceneCenter.Sub(dir.Mul(dist)), dir, halfSize)
+			scale = intensity * dist * dist / (4 * halfSize * halfSize)
+			r.report.Convert(matName, pl.light.Type, compiler.ConversionApproximated, "rendered as a distant emissive quad")
+		default:
+			if err := r.warn("skipping light %q with unsupported type %q", pl.name, pl.light.Type); err != nil {
+				return err
+			}
+			continue
+		}
+
+		r.rawScene.Materials = append(r.rawScene.Materials, &input.Material{
+			Name:         matName,
+			Expression:   fmt.Sprintf("%s(%s: %s, %s: %s)", material.BxdfEmissive, material.ParamRadiance, gltfFormatVec3(lightColor), material.ParamScale, gltfFormatFloat(scale)),
+			AssetRelPath: r.sceneRes,
+			Used:         true,
+		})
+
+		mesh := input.NewMesh(matName)
+		for _, tri := range vertices {
+			normal := tri[1].Sub(tri[0]).Cross(tri[2].Sub(tri[0])).Normalize()
+			prim := &input.Primitive{
+				Vertices:      tri,
+				Normals:       [3]types.Vec3{normal, normal, normal},
+				MaterialIndex: len(r.rawScene.Materials) - 1,
+			}
+			prim.SetBBox([2]types.Vec3{
+				types.MinVec3(tri[0], types.MinVec3(tri[1], tri[2])),
+				types.MaxVec3(tri[0], types.MaxVec3(tri[1], tri[2])),
+			})
+			prim.SetCenter(tri[0].Add(tri[1]).Add(tri[2]).Mul(1.0 / 3.0))
+			mesh.Primitives = append(mesh.Primitives, prim)
+		}
+		r.rawScene.Meshes = append(r.rawScene.Meshes, mesh)
+		r.addMeshInstance(len(r.rawScene.Meshes)-1, types.Ident4())
+	}
+
+	return nil
+}
+
+// Generate the triangles of an octahedron with outward facing normals.
+func gltfOctahedron(center types.Vec3, radius float32) [][3]types.Vec3 {
+	axes := [3]types.Vec3{{radius, 0, 0}, {0, radius, 0}, {0, 0, radius}}
+	var tris [][3]types.Vec3
+	for _, sx := range []float32{-1, 1} {
+		for _, sy := range []float32{-1, 1} {
+			for _, sz := range []float32{-1, 1} {
+				x := center.Add(axes[0].Mul(sx))
+				y := center.Add(axes[1].Mul(sy))
+				z := center.Add(axes[2].Mul(sz))
+				if sx*sy*sz > 0 {
+					tris = append(tris, [3]types.Vec3{x, y, z})
+				} else {
+					tris = append(tris, [3]types.Vec3{x, z, y})
+				}
+			}
+		}
+	}
+	return tris
+}
+
+// Generate the triangles of a square centered at the given point whose
+// normal points towards dir.
+func gltfQuad(center, dir types.Vec3, halfSize float32) [][3]types.Vec3 {
+	up := types.Vec3{0, 1, 0}
+	if math.Abs(float64(dir.Dot(up))) > 0.99 {
+		up = types.Vec3{1, 0, 0}
+	}
+	u := up.Cross(dir).Normalize().Mul(halfSize)
+	v := dir.Cross(u).Normalize().Mul(halfSize)
+
+	c0 := center.Sub(u).Sub(v)
+	c1 := center.Add(u).Sub(v)
+	c2 := center.Add(u).Add(v)
+	c3 := center.Sub(u).Add(v)
+	return [][3]types.Vec3{{c0, c1, c2}, {c0, c2, c3}}
+}
+
+// Log a non-fatal issue and record it to the loading report. If the report
+// operates in strict mode the issue is returned back as an error.
+func (r *gltfSceneReader) warn(msgFormat string, args ...interface{}) error {
+	msg := fmt.Sprintf(msgFormat, args...)
+	r.logger.Warning(msg)
+	return r.report.Warn(compiler.SectionParse, "[%s] %s", r.sceneRes.Path(), msg)
+}
+
+// Ensure that a generated material or camera name is unique by appending a
+// numeric suffix to it if required.
+func (r *gltfSceneReader) uniqueName(name string) string {
+	unique := name
+	for suffix := 2; ; suffix++ {
+		if _, used := r.usedNames[unique]; !used {
+			break
+		}
+		unique = fmt.Sprintf("%s_%d", name, suffix)
+	}
+	r.usedNames[unique] = struct{}{}
+	return unique
+}
+
+// Get the name of a glTF object or generate one from its index.
+func gltfName(name, kind string, index int) string {
+	if name != "" {
+		return name
+	}
+	return fmt.Sprintf("%s%d", kind, index)
+}
+
+// Get the size in bytes of an accessor component type.
+func gltfComponentSize(componentType int) int {
+	switch componentType {
+	case gltfByte, gltfUnsignedByte:
+		return 1
+	case gltfShort, gltfUnsignedShort:
+		return 2
+	case gltfUnsignedInt, gltfFloat:
+		return 4
+	}
+	return 0
+}
+
+// Format a float for use in a material expression.
+func gltfFormatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
+
+// Format a vector for use in a material expression.
+func gltfFormatVec3(v types.Vec3) string {
+	return fmt.Sprintf("{%s, %s, %s}", gltfFormatFloat(v[0]), gltfFormatFloat(v[1]), gltfFormatFloat(v[2]))
+}
+
+// Clamp a value to the [lo, hi] range.
+func clampf(v, lo, hi float32) float32 {
+	return float32(math.Max(float64(lo), math.Min(float64(v), float64(hi))))
+}
+
+// Clamp the vector components to the [0, max] range.
+func clampVec3(v types.Vec3, max float32) types.Vec3 {
+	for index := range v {
+		v[index] = float32(math.Max(0, math.Min(float64(v[index]), float64(max))))
+	}
+	return v
+}
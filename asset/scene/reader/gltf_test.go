@@ -0,0 +1,551 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Build the binary buffer for a unit quad in the XY plane. The buffer
+// contains positions, normals, uvs and unsigned short indices.
+func gltfQuadBuffer() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []float32{-1, -1, 0, 1, -1, 0, 1, 1, 0, -1, 1, 0})
+	binary.Write(&buf, binary.LittleEndian, []float32{0, 0, 1, 0, 0, 1, 0, 0, 1, 0, 0, 1})
+	binary.Write(&buf, binary.LittleEndian, []float32{0, 1, 1, 1, 1, 0, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, 2, 0, 2, 3})
+	return buf.Bytes()
+}
+
+// Build a glTF document with a quad mesh, a camera and a point light. If
+// bufferURI is empty the buffer refers to the binary chunk of a glb file.
+func gltfTestDocument(bufferURI string) map[string]interface{} {
+	buffer := map[string]interface{}{"byteLength": len(gltfQuadBuffer())}
+	if bufferURI != "" {
+		buffer["uri"] = bufferURI
+	}
+
+	return map[string]interface{}{
+		"asset": map[string]interface{}{"version": "2.0"},
+		"scene": 0,
+		"scenes": []interface{}{
+			map[string]interface{}{"nodes": []int{0, 2}},
+		},
+		"nodes": []interface{}{
+			map[string]interface{}{"name": "root", "translation": []float32{0, 1, 0}, "children": []int{1, 3}},
+			map[string]interface{}{"mesh": 0, "scale": []float32{2, 2, 2}},
+			map[string]interface{}{"camera": 0, "translation": []float32{0, 0, 10}},
+			map[string]interface{}{
+				"translation": []float32{0, 5, 0},
+				"extensions": map[string]interface{}{
+					"KHR_lights_punctual": map[string]interface{}{"light": 0},
+				},
+			},
+		},
+		"meshes": []interface{}{
+			map[string]interface{}{
+				"name": "quad",
+				"primitives": []interface{}{
+					map[string]interface{}{
+						"attributes": map[string]int{"POSITION": 0, "NORMAL": 1, "TEXCOORD_0": 2},
+						"indices":    3,
+						"material":   0,
+					},
+				},
+			},
+		},
+		"materials": []interface{}{
+			map[string]interface{}{
+				"name": "gold",
+				"pbrMetallicRoughness": map[string]interface{}{
+					"baseColorFactor": []float32{1, 0.75, 0.25, 1},
+					"roughnessFactor": 0.5,
+				},
+			},
+		},
+		"cameras": []interface{}{
+			map[string]interface{}{
+				"type":        "perspective",
+				"perspective": map[string]interface{}{"yfov": math.Pi / 4, "znear": 0.1},
+			},
+		},
+		"extensions": map[string]interface{}{
+			"KHR_lights_punctual": map[string]interface{}{
+				"lights": []interface{}{
+					map[string]interface{}{"name": "bulb", "type": "point", "intensity": 2},
+				},
+			},
+		},
+		"accessors": []interface{}{
+			map[string]interface{}{"bufferView": 0, "componentType": gltfFloat, "count": 4, "type": "VEC3"},
+			map[string]interface{}{"bufferView": 1, "componentType": gltfFloat, "count": 4, "type": "VEC3"},
+			map[string]interface{}{"bufferView": 2, "componentType": gltfFloat, "count": 4, "type": "VEC2"},
+			map[string]interface{}{"bufferView": 3, "componentType": gltfUnsignedShort, "count": 6, "type": "SCALAR"},
+		},
+		"bufferViews": []interface{}{
+			map[string]interface{}{"buffer": 0, "byteOffset": 0, "byteLength": 48},
+			map[string]interface{}{"buffer": 0, "byteOffset": 48, "byteLength": 48},
+			map[string]interface{}{"buffer": 0, "byteOffset": 96, "byteLength": 32},
+			map[string]interface{}{"buffer": 0, "byteOffset": 128, "byteLength": 12},
+		},
+		"buffers": []interface{}{buffer},
+	}
+}
+
+func gltfDataURI(mimeType string, data []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func parseGltfDocument(data []byte) (*gltfSceneReader, error) {
+	r := newGltfReader(DefaultOptions, compiler.NewReport(nil, false))
+	r.sceneRes = asset.NewResourceFromStream("test.gltf", bytes.NewReader(data))
+	err := r.parse()
+	return r, err
+}
+
+func marshalGltf(t *testing.T, doc map[string]interface{}) []byte {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkGltfTestScene(t *testing.T, r *gltfSceneReader) {
+	sc := r.rawScene
+
+	// Quad mesh and the point light octahedron
+	if len(sc.Meshes) != 2 || len(sc.MeshInstances) != 2 {
+		t.Fatalf("expected 2 meshes and 2 instances; got %d and %d", len(sc.Meshes), len(sc.MeshInstances))
+	}
+	if len(sc.Meshes[0].Primitives) != 2 {
+		t.Fatalf("expected quad mesh to contain 2 triangles; got %d", len(sc.Meshes[0].Primitives))
+	}
+	prim := sc.Meshes[0].Primitives[1]
+	expVerts := [3]types.Vec3{{-1, -1, 0}, {1, 1, 0}, {-1, 1, 0}}
+	if prim.Vertices != expVerts {
+		t.Fatalf("expected second triangle vertices to be %v; got %v", expVerts, prim.Vertices)
+	}
+	if exp := [3]types.Vec2{{0, 1}, {1, 0}, {0, 0}}; prim.UVs != exp {
+		t.Fatalf("expected second triangle uvs to be %v; got %v", exp, prim.UVs)
+	}
+
+	// The instance transform combines the parent translation and the child scale
+	inst := sc.MeshInstances[0]
+	if p := inst.Transform.Mul4x1(types.Vec4{1, 1, 0, 1}).Vec3(); p != (types.Vec3{2, 3, 0}) {
+		t.Fatalf("expected instance transform to map (1, 1, 0) to (2, 3, 0); got %v", p)
+	}
+	if bbox := inst.BBox(); bbox[0] != (types.Vec3{-2, -1, 0}) || bbox[1] != (types.Vec3{2, 3, 0}) {
+		t.Fatalf("expected instance bbox to be [(-2, -1, 0), (2, 3, 0)]; got %v", bbox)
+	}
+
+	// Camera
+	if len(sc.Cameras) != 1 || sc.Camera != sc.Cameras[0] {
+		t.Fatalf("expected the glTF camera to replace the default camera; got %d cameras", len(sc.Cameras))
+	}
+	cam := sc.Camera
+	if cam.Name != "camera0" || math.Abs(float64(cam.FOV-45)) > 1e-4 {
+		t.Fatalf("expected camera0 with a 45 degree fov; got %q with fov %f", cam.Name, cam.FOV)
+	}
+	if cam.Eye != (types.Vec3{0, 0, 10}) || cam.Look != (types.Vec3{0, 0, 9}) || cam.Up != (types.Vec3{0, 1, 0}) {
+		t.Fatalf("expected camera to look down the -Z axis from (0, 0, 10); got eye %v, look %v, up %v", cam.Eye, cam.Look, cam.Up)
+	}
+
+	// Materials
+	if len(sc.Materials) != 2 {
+		t.Fatalf("expected 2 materials; got %d", len(sc.Materials))
+	}
+	mat := sc.Materials[0]
+	if exp := "principled(baseColor: {1, 0.75, 0.25}, metallic: 1, roughness: 0.5)"; mat.Name != "gold" || mat.Expression != exp {
+		t.Fatalf("expected material %q with expression %q; got %q with expression %q", "gold", exp, mat.Name, mat.Expression)
+	}
+	if mat.RoughnessConvention != material.RoughnessPerceptual {
+		t.Fatalf("expected glTF materials to use the perceptual roughness convention; got %s", mat.RoughnessConvention)
+	}
+	if _, err := material.ParseExpression(mat.Expression); err != nil {
+		t.Fatal(err)
+	}
+
+	// Light
+	light := sc.Materials[1]
+	expLight := "emissive(radiance: {1, 1, 1}, scale: " + gltfFormatFloat(float32(2)/(math.Pi*gltfPointLightRadius*gltfPointLightRadius)) + ")"
+	if light.Name != "light_bulb" || light.Expression != expLight {
+		t.Fatalf("expected light material %q with expression %q; got %q with expression %q", "light_bulb", expLight, light.Name, light.Expression)
+	}
+	lightBBox := sc.MeshInstances[1].BBox()
+	if center := lightBBox[0].Add(lightBBox[1]).Mul(0.5); center.Sub(types.Vec3{0, 6, 0}).Len() > 1e-5 {
+		t.Fatalf("expected light to be centered at (0, 6, 0); got %v", center)
+	}
+	for _, prim := range sc.Meshes[1].Primitives {
+		outward := prim.Center().Sub(types.Vec3{0, 6, 0})
+		if prim.Normals[0].Dot(outward) <= 0 {
+			t.Fatalf("expected light triangle normals to point outwards; got %v", prim.Normals[0])
+		}
+	}
+}
+
+func TestGltfReader(t *testing.T) {
+	doc := gltfTestDocument(gltfDataURI("application/octet-stream", gltfQuadBuffer()))
+	r, err := parseGltfDocument(marshalGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGltfTestScene(t, r)
+}
+
+func TestGlbReader(t *testing.T) {
+	jsonChunk := marshalGltf(t, gltfTestDocument(""))
+	for len(jsonChunk)%4 != 0 {
+		jsonChunk = append(jsonChunk, ' ')
+	}
+	binChunk := gltfQuadBuffer()
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, []uint32{glbMagic, 2, uint32(glbHeaderSize + 2*glbChunkHeadSize + len(jsonChunk) + len(binChunk))})
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(jsonChunk)), glbChunkJSON})
+	buf.Write(jsonChunk)
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(len(binChunk)), glbChunkBIN})
+	buf.Write(binChunk)
+
+	r, err := parseGltfDocument(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGltfTestScene(t, r)
+}
+
+func TestGltfTangents(t *testing.T) {
+	// Append a tangent accessor pointing along -X with a flipped bitangent
+	var buf bytes.Buffer
+	buf.Write(gltfQuadBuffer())
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	tangentOffset := buf.Len()
+	for i := 0; i < 4; i++ {
+		binary.Write(&buf, binary.LittleEndian, []float32{-1, 0, 0, -1})
+	}
+
+	doc := gltfTestDocument(gltfDataURI("application/octet-stream", buf.Bytes()))
+	doc["buffers"].([]interface{})[0].(map[string]interface{})["byteLength"] = buf.Len()
+	doc["bufferViews"] = append(doc["bufferViews"].([]interface{}), map[string]interface{}{"buffer": 0, "byteOffset": tangentOffset, "byteLength": 64})
+	doc["accessors"] = append(doc["accessors"].([]interface{}), map[string]interface{}{"bufferView": 4, "componentType": gltfFloat, "count": 4, "type": "VEC4"})
+	prim := doc["meshes"].([]interface{})[0].(map[string]interface{})["primitives"].([]interface{})[0].(map[string]interface{})
+	prim["attributes"].(map[string]int)["TANGENT"] = 4
+
+	r, err := parseGltfDocument(marshalGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := types.Vec4{-1, 0, 0, -1}
+	for index, prim := range r.rawScene.Meshes[0].Primitives {
+		for v, tangent := range prim.Tangents {
+			if tangent != exp {
+				t.Fatalf("[prim %d] expected vertex %d tangent to be %v; got %v", index, v, exp, tangent)
+			}
+		}
+	}
+}
+
+func TestGltfMaterials(t *testing.T) {
+	// A 2x1 metallic-roughness texture
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{0, 255, 0, 255})
+	img.Set(1, 0, color.NRGBA{0, 128, 255, 255})
+	var imgBuf bytes.Buffer
+	if err := png.Encode(&imgBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := gltfTestDocument(gltfDataURI("application/octet-stream", gltfQuadBuffer()))
+	doc["images"] = []interface{}{
+		map[string]interface{}{"uri": gltfDataURI("image/png", imgBuf.Bytes())},
+		map[string]interface{}{"uri": "textures/base%20color.png"},
+	}
+	doc["textures"] = []interface{}{
+		map[string]interface{}{"source": 0},
+		map[string]interface{}{"source": 1},
+	}
+	doc["materials"] = []interface{}{
+		map[string]interface{}{
+			"pbrMetallicRoughness": map[string]interface{}{
+				"baseColorTexture":         map[string]interface{}{"index": 1},
+				"metallicRoughnessTexture": map[string]interface{}{"index": 0},
+				"roughnessFactor":          0.5,
+			},
+			"alphaMode": "BLEND",
+		},
+	}
+
+	r, err := parseGltfDocument(marshalGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(r.tmpDir)
+
+	expr := r.rawScene.Materials[0].Expression
+	if _, err = material.ParseExpression(expr); err != nil {
+		t.Fatalf("expected generated expression to be valid; got %v\n%s", err, expr)
+	}
+	if !strings.HasPrefix(expr, "principled(baseColor: \"textures/base color.png\", metallic: \"") {
+		t.Fatalf("expected a principled bxdf using the base color and metallic textures; got %s", expr)
+	}
+
+	// Check the extracted roughness (G * 0.5) and metallic (B) textures
+	paths := regexp.MustCompile(`"([^"]+-[12]-[0-9.]+\.png)"`).FindAllStringSubmatch(expr, -1)
+	expValues := map[string][2]uint8{"-1-0.5.png": {128, 64}, "-2-1.png": {0, 255}}
+	found := 0
+	for _, match := range paths {
+		for suffix, exp := range expValues {
+			if !strings.HasSuffix(match[1], suffix) {
+				continue
+			}
+			found++
+
+			f, err := os.Open(match[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			gray, err := png.Decode(f)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for x, expV := range exp {
+				if v := gray.(*image.Gray).GrayAt(x, 0).Y; v != expV {
+					t.Errorf("[%s] expected texel %d to be %d; got %d", filepath.Base(match[1]), x, expV, v)
+				}
+			}
+		}
+	}
+	if found < 2 {
+		t.Fatalf("expected expression to reference the extracted roughness and metallic textures; got %s", expr)
+	}
+
+	if len(r.report.Conversions) == 0 {
+		t.Fatal("expected the dropped alphaMode to be reported")
+	}
+}
+
+func TestGltfAlphaMask(t *testing.T) {
+	// A 2x1 base color texture with a transparent and an opaque texel
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.NRGBA{0, 255, 0, 0})
+	img.Set(1, 0, color.NRGBA{0, 255, 0, 255})
+	var imgBuf bytes.Buffer
+	if err := png.Encode(&imgBuf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := gltfTestDocument(gltfDataURI("application/octet-stream", gltfQuadBuffer()))
+	doc["images"] = []interface{}{
+		map[string]interface{}{"uri": gltfDataURI("image/png", imgBuf.Bytes())},
+	}
+	doc["textures"] = []interface{}{
+		map[string]interface{}{"source": 0},
+	}
+	doc["materials"] = []interface{}{
+		map[string]interface{}{
+			"pbrMetallicRoughness": map[string]interface{}{
+				"baseColorFactor":  []float32{1, 1, 1, 0.5},
+				"baseColorTexture": map[string]interface{}{"index": 0},
+			},
+			"alphaMode":   "MASK",
+			"alphaCutoff": 0.25,
+		},
+	}
+
+	r, err := parseGltfDocument(marshalGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(r.tmpDir)
+
+	expr := r.rawScene.Materials[0].Expression
+	pe, err := material.ParseExpression(expr)
+	if err != nil {
+		t.Fatalf("expected generated expression to be valid; got %v\n%s", err, expr)
+	}
+	cutout, isCutout := pe.(material.AlphaCutoutNode)
+	if !isCutout {
+		t.Fatalf("expected an alpha cutout expression; got %s", expr)
+	}
+	if cutout.Cutoff != 0.25 {
+		t.Fatalf("expected alpha cutoff to be 0.25; got %v", cutout.Cutoff)
+	}
+
+	// Check the extracted opacity texture (A * 0.5)
+	f, err := os.Open(string(cutout.Texture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, expV := range []uint8{0, 128} {
+		if v := gray.(*image.Gray).GrayAt(x, 0).Y; v != expV {
+			t.Errorf("expected opacity texel %d to be %d; got %d", x, expV, v)
+		}
+	}
+
+	for _, conv := range r.report.Conversions {
+		if conv.Property == "alphaMode" {
+			t.Fatalf("expected the alpha mask to be converted; got %v", conv)
+		}
+	}
+}
+
+func TestGltfMaterialExtensions(t *testing.T) {
+	doc := gltfTestDocument(gltfDataURI("application/octet-stream", gltfQuadBuffer()))
+	doc["materials"] = []interface{}{
+		map[string]interface{}{
+			"name": "coated",
+			"pbrMetallicRoughness": map[string]interface{}{
+				"metallicFactor": 0,
+			},
+			"extensions": map[string]interface{}{
+				"KHR_materials_ior":          map[string]interface{}{"ior": 2},
+				"KHR_materials_specular":     map[string]interface{}{"specularFactor": 0},
+				"KHR_materials_sheen":        map[string]interface{}{"sheenColorFactor": []float32{0.2, 0.4, 0.1}},
+				"KHR_materials_clearcoat":    map[string]interface{}{"clearcoatFactor": 1, "clearcoatRoughnessFactor": 0.2},
+				"KHR_materials_transmission": map[string]interface{}{"transmissionFactor": 0.5, "transmissionTexture": map[string]interface{}{"index": 0}},
+			},
+		},
+	}
+
+	r, err := parseGltfDocument(marshalGltf(t, doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(r.tmpDir)
+
+	expr := r.rawScene.Materials[0].Expression
+	exp := "principled(baseColor: {1, 1, 1}, metallic: 0, roughness: 1, intIOR: 2, specular: 0, sheen: 0.4, clearcoat: 1, transmission: 0.5)"
+	if expr != exp {
+		t.Fatalf("expected expression %q; got %q", exp, expr)
+	}
+	pe, err := material.ParseExpression(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pe.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expConversions := []compiler.MaterialConversion{
+		{Material: "coated", Property: "KHR_materials_sheen", Action: compiler.ConversionApproximated},
+		{Material: "coated", Property: "KHR_materials_clearcoat", Action: compiler.ConversionApproximated},
+		{Material: "coated", Property: "KHR_materials_transmission", Action: compiler.ConversionDropped},
+	}
+	var conversions []compiler.MaterialConversion
+	for _, conv := range r.report.Conversions {
+		if conv.Material == "coated" {
+			conversions = append(conversions, conv)
+		}
+	}
+	if len(conversions) != len(expConversions) {
+		t.Fatalf("expected %d conversions; got %d: %v", len(expConversions), len(conversions), conversions)
+	}
+	for index, exp := range expConversions {
+		got := conversions[index]
+		if got.Material != exp.Material || got.Property != exp.Property || got.Action != exp.Action {
+			t.Errorf("[conversion %d] expected %s %s to be %s; got %v", index, exp.Material, exp.Property, exp.Action, got)
+		}
+	}
+}
+
+func TestGltfReaderErrors(t *testing.T) {
+	specs := []struct {
+		modify func(doc map[string]interface{})
+		expErr string
+	}{
+		{
+			func(doc map[string]interface{}) { doc["asset"] = map[string]interface{}{"version": "1.0"} },
+			"unsupported glTF version",
+		},
+		{
+			func(doc map[string]interface{}) { doc["extensionsRequired"] = []string{"KHR_draco_mesh_compression"} },
+			`unsupported required glTF extension "KHR_draco_mesh_compression"`,
+		},
+		{
+			func(doc map[string]interface{}) {
+				doc["bufferViews"].([]interface{})[3].(map[string]interface{})["byteOffset"] = 1000
+			},
+			"exceeds the bounds of buffer 0",
+		},
+		{
+			func(doc map[string]interface{}) {
+				doc["accessors"].([]interface{})[0].(map[string]interface{})["count"] = 5
+			},
+			"elements exceed the buffer view bounds",
+		},
+		{
+			func(doc map[string]interface{}) {
+				doc["nodes"].([]interface{})[1].(map[string]interface{})["children"] = []int{0}
+			},
+			"node hierarchy contains a cycle",
+		},
+	}
+
+	for index, spec := range specs {
+		doc := gltfTestDocument(gltfDataURI("application/octet-stream", gltfQuadBuffer()))
+		spec.modify(doc)
+
+		_, err := parseGltfDocument(marshalGltf(t, doc))
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", index, spec.expErr, err)
+		}
+	}
+}
+
+func TestReadGltfScene(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Store the buffer next to the document
+	err = ioutil.WriteFile(filepath.Join(dir, "quad.bin"), gltfQuadBuffer(), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sceneFile := filepath.Join(dir, "scene.gltf")
+	err = ioutil.WriteFile(sceneFile, marshalGltf(t, gltfTestDocument("quad.bin")), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, _, err := ReadSceneWithOptions(sceneFile, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := 2 + 8; len(sc.MaterialIndex) != exp {
+		t.Fatalf("expected compiled scene to contain %d triangles; got %d", exp, len(sc.MaterialIndex))
+	}
+	if len(sc.EmissivePrimitives) != 8 {
+		t.Fatalf("expected the point light to generate 8 emissive primitives; got %d", len(sc.EmissivePrimitives))
+	}
+	if sc.Camera == nil || sc.Camera.Name != "camera0" {
+		t.Fatalf("expected the active camera to be camera0; got %v", sc.Camera)
+	}
+}
@@ -0,0 +1,83 @@
+package reader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrResourceTooLarge     = errors.New("scene reader: resource exceeds the maximum allowed size")
+	ErrTooManyElements      = errors.New("scene reader: scene exceeds the maximum allowed number of elements")
+	ErrIncludeDepthExceeded = errors.New("scene reader: maximum include depth exceeded")
+)
+
+// Limits protect the scene readers against malformed or malicious input
+// files. A zero value for any of the fields disables the respective check
+// with the exception of MaxIncludeDepth where a zero value prevents the
+// reader from including any other file.
+type Limits struct {
+	// The max number of bytes that can be read from a single resource.
+	// For compressed scenes this applies to the decompressed data.
+	MaxResourceSize int64
+
+	// The max number of parsed vertices, normals, uv coordinates and
+	// primitives (each one is checked separately).
+	MaxElements int
+
+	// The max nesting level for files that include other files.
+	MaxIncludeDepth int
+}
+
+// The limits used by ReadScene.
+var DefaultLimits = Limits{
+	MaxResourceSize: 2 << 30,
+	MaxElements:     64 << 20,
+	MaxIncludeDepth: 16,
+}
+
+// Check that the number of parsed elements of a particular type does not
+// exceed the configured limit.
+func (l Limits) checkElements(kind string, count int) error {
+	if l.MaxElements > 0 && count > l.MaxElements {
+		return fmt.Errorf("%s: %d %s (limit %d)", ErrTooManyElements.Error(), count, kind, l.MaxElements)
+	}
+	return nil
+}
+
+// Wrap a reader so that it fails with ErrResourceTooLarge when more than
+// MaxResourceSize bytes are read from it.
+func (l Limits) limitReader(r io.Reader) io.Reader {
+	if l.MaxResourceSize <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, remaining: l.MaxResourceSize}
+}
+
+// A reader that returns an error instead of silently truncating the input
+// once its limit is reached.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+// Implements io.Reader.
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		// Probe for more data so that inputs exactly matching the limit
+		// can still be read.
+		var probe [1]byte
+		n, err := lr.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrResourceTooLarge
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	return n, err
+}
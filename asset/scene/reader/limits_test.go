@@ -0,0 +1,64 @@
+package reader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset"
+)
+
+func TestLimitReader(t *testing.T) {
+	limits := Limits{MaxResourceSize: 4}
+
+	data, err := ioutil.ReadAll(limits.limitReader(bytes.NewReader([]byte("1234"))))
+	if err != nil {
+		t.Fatalf("expected input matching the limit to be read; got %v", err)
+	}
+	if string(data) != "1234" {
+		t.Fatalf("expected to read 1234; got %q", string(data))
+	}
+
+	_, err = ioutil.ReadAll(limits.limitReader(bytes.NewReader([]byte("12345"))))
+	if err != ErrResourceTooLarge {
+		t.Fatalf("expected to get ErrResourceTooLarge; got %v", err)
+	}
+}
+
+func TestWavefrontReaderLimits(t *testing.T) {
+	specs := []struct {
+		limits Limits
+		input  string
+		expErr string
+	}{
+		{
+			Limits{MaxElements: 2},
+			"v 0 0 0\nv 1 0 0\nv 0 1 0\n",
+			ErrTooManyElements.Error(),
+		},
+		{
+			Limits{MaxElements: 1},
+			"v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\nf 1 2 3\n",
+			ErrTooManyElements.Error(),
+		},
+		{
+			Limits{MaxResourceSize: 16},
+			"v 0 0 0\nv 1 0 0\nv 0 1 0\n",
+			ErrResourceTooLarge.Error(),
+		},
+		{
+			Limits{},
+			"call other.obj\n",
+			ErrIncludeDepthExceeded.Error(),
+		},
+	}
+
+	for specIndex, spec := range specs {
+		r := newWavefrontReader(Options{Limits: spec.limits}, nil)
+		err := r.parse(asset.NewResourceFromStream("test.obj", strings.NewReader(spec.input)))
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"strings"
 
 	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/material"
 	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
 )
 
 // The Reader interface is implemented by all scene readers.
@@ -14,22 +17,96 @@ type Reader interface {
 	Read(*asset.Resource) (*scene.Scene, error)
 }
 
-// Read scene from file.
+// Options for customizing the scene loading process.
+type Options struct {
+	// Limits for protecting against malformed input files. Applications
+	// that load untrusted scene files should use limits that match their
+	// memory budget.
+	Limits Limits
+
+	// An optional callback for receiving progress updates.
+	Progress compiler.ProgressFunc
+
+	// If set, loading fails on the first non-fatal issue (e.g. a missing
+	// texture or an undefined material) instead of recording a warning.
+	Strict bool
+
+	// The max number of bytes that can be used by texture data. Textures
+	// are downscaled until they fit the budget and each downscaled texture
+	// is reported as a warning. A zero value disables the budget.
+	TextureBudget int
+
+	// The color space for textures that do not specify one using the
+	// -colorspace map option. By default, the color space is selected
+	// based on the texture usage and format; see compiler.Options.
+	TextureColorSpace texture.ColorSpace
+
+	// The convention used by the roughness values and textures of
+	// materials that do not specify one (e.g. via the roughness_convention
+	// MTL command). Use this to match the exporter that produced the
+	// scene; see material.RoughnessConvention.
+	RoughnessConvention material.RoughnessConvention
+
+	// Controls how shading normals that disagree with the geometric normal
+	// of their primitive are handled; see compiler.Options.
+	ShadingNormalFix      compiler.ShadingNormalFix
+	MaxShadingNormalAngle float32
+
+	// Controls how faces without a material (e.g. wavefront faces defined
+	// before any usemtl command) are handled; see compiler.Options.
+	MissingMaterialPolicy compiler.MissingMaterialPolicy
+}
+
+// The options used by ReadScene.
+var DefaultOptions = Options{
+	Limits: DefaultLimits,
+}
+
+// Read scene from file using the default options. Any non-fatal issues
+// encountered while loading the scene are logged.
 func ReadScene(filename string) (*scene.Scene, error) {
+	sc, _, err := ReadSceneWithOptions(filename, DefaultOptions)
+	return sc, err
+}
+
+// Read scene from file using the supplied options. Returns the loaded scene
+// and a list of non-fatal issues that were encountered while loading it.
+func ReadSceneWithOptions(filename string, opts Options) (*scene.Scene, []compiler.Warning, error) {
+	sc, report, err := ReadSceneWithReport(filename, opts)
+	if report == nil {
+		return sc, nil, err
+	}
+	return sc, report.Warnings, err
+}
+
+// Read scene from file using the supplied options. Returns the loaded scene
+// and a report with the non-fatal issues that were encountered while loading
+// it and the source material properties that were approximated or dropped
+// by the importer.
+func ReadSceneWithReport(filename string, opts Options) (*scene.Scene, *compiler.Report, error) {
 	res, err := asset.NewResource(filename, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer res.Close()
 
+	report := compiler.NewReport(opts.Progress, opts.Strict)
+
 	// Select reader based on file extension
 	var reader Reader
 	if strings.HasSuffix(filename, ".obj") {
-		reader = newWavefrontReader()
+		reader = newWavefrontReader(opts, report)
+	} else if strings.HasSuffix(filename, ".gltf") || strings.HasSuffix(filename, ".glb") {
+		reader = newGltfReader(opts, report)
 	} else if strings.HasSuffix(filename, ".zip") {
-		reader = newZipSceneReader()
+		reader = newZipSceneReader(opts, report)
 	} else {
-		return nil, fmt.Errorf("readScene: unsupported file format")
+		return nil, nil, fmt.Errorf("readScene: unsupported file format")
+	}
+
+	sc, err := reader.Read(res)
+	if err != nil {
+		return nil, report, err
 	}
-	return reader.Read(res)
+	return sc, report, nil
 }
@@ -0,0 +1,741 @@
+package reader
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/types"
+)
+
+const partialScene = `
+mtllib missing.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+g tri
+usemtl undefined
+f 1 2 3
+f 1 2 9
+instance missing 0 0 0 0 0 0 1 1 1
+`
+
+func writeTempScene(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "polaris-reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sceneFile := filepath.Join(dir, "scene.obj")
+	err = ioutil.WriteFile(sceneFile, []byte(contents), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return sceneFile, func() { os.RemoveAll(dir) }
+}
+
+func TestReadSceneWithWarnings(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, partialScene)
+	defer cleanup()
+
+	progress := make(map[string]int)
+	opts := DefaultOptions
+	opts.Progress = func(section string, done, total int) {
+		progress[section]++
+	}
+
+	sc, warnings, err := ReadSceneWithOptions(sceneFile, opts)
+	if err != nil {
+		t.Fatalf("expected scene to load with warnings; got error %v", err)
+	}
+
+	if len(sc.MaterialIndex) != 1 {
+		t.Fatalf("expected scene to contain 1 primitive; got %d", len(sc.MaterialIndex))
+	}
+
+	expWarnings := []string{
+		"skipping mtllib",
+		`undefined material with name "undefined"`,
+		"skipping face",
+		`skipping instance: unknown mesh with name "missing"`,
+	}
+	if len(warnings) != len(expWarnings) {
+		t.Fatalf("expected %d warnings; got %d: %v", len(expWarnings), len(warnings), warnings)
+	}
+	for index, exp := range expWarnings {
+		if warnings[index].Section != compiler.SectionParse || !strings.Contains(warnings[index].Message, exp) {
+			t.Errorf("expected warning %d to contain %q; got %v", index, exp, warnings[index])
+		}
+	}
+
+	for _, section := range []string{compiler.SectionParse, compiler.SectionMaterials, compiler.SectionGeometry} {
+		if progress[section] == 0 {
+			t.Errorf("expected to receive progress updates for section %q", section)
+		}
+	}
+}
+
+func TestReadSceneStrict(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, partialScene)
+	defer cleanup()
+
+	opts := DefaultOptions
+	opts.Strict = true
+
+	_, _, err := ReadSceneWithOptions(sceneFile, opts)
+	if err == nil || !strings.Contains(err.Error(), "skipping mtllib") {
+		t.Fatalf("expected strict mode to fail on the first issue; got %v", err)
+	}
+}
+
+func TestReadSceneWithMultipleCameras(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+camera front
+camera_eye 0 0 10
+camera_fov 60
+camera top
+camera_eye 0 10 0
+camera_up 0 0 -1
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+`)
+	defer cleanup()
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(sc.CameraNames(), ","); names != "front,top" {
+		t.Fatalf("expected scene cameras to be front,top; got %s", names)
+	}
+
+	if sc.Camera.Name != "front" || sc.Camera.FOV != 60 || sc.Camera.Position[2] != 10 {
+		t.Fatalf("expected active camera to be front with fov 60; got %q with fov %f", sc.Camera.Name, sc.Camera.FOV)
+	}
+
+	err = sc.SelectCamera("top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Camera.Name != "top" || sc.Camera.Position[1] != 10 || sc.Camera.Up[2] != -1 {
+		t.Fatalf("expected active camera to be top; got %q at %v", sc.Camera.Name, sc.Camera.Position)
+	}
+
+	err = sc.SelectCamera("side")
+	if err == nil || !strings.Contains(err.Error(), "available cameras: front, top") {
+		t.Fatalf("expected an unknown camera error; got %v", err)
+	}
+}
+
+func TestReadSceneWithDuplicateCameras(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+camera front
+camera front
+`)
+	defer cleanup()
+
+	_, err := ReadScene(sceneFile)
+	if err == nil || !strings.Contains(err.Error(), `camera "front" is already defined`) {
+		t.Fatalf("expected a duplicate camera error; got %v", err)
+	}
+}
+
+func TestReadSceneWithDepthOfField(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+camera_eye 0 0 10
+camera_aperture 0.1
+camera_focus_distance 8
+camera_aperture_blades 6 30
+camera_bokeh_mask heart.png
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+`)
+	defer cleanup()
+
+	// Create a bokeh mask with a single lit pixel next to the scene file
+	im := image.NewGray(image.Rect(0, 0, 3, 3))
+	im.SetGray(1, 1, color.Gray{Y: 255})
+	f, err := os.Create(filepath.Join(filepath.Dir(sceneFile), "heart.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, im)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cam := sc.Camera
+	if cam.ApertureRadius != 0.1 || cam.FocusDistance != 8 || cam.ApertureBlades != 6 || cam.ApertureRotation != 30 {
+		t.Fatalf("unexpected depth of field settings: radius %f, focus distance %f, blades %d, rotation %f", cam.ApertureRadius, cam.FocusDistance, cam.ApertureBlades, cam.ApertureRotation)
+	}
+
+	if len(cam.BokehSamples) == 0 {
+		t.Fatal("expected bokeh samples to be generated from the mask")
+	}
+	for index, sample := range cam.BokehSamples {
+		if sample[0] != 0 || sample[1] != 0 {
+			t.Fatalf("[sample %d] expected sample to be placed at the center of the lit pixel; got %v", index, sample)
+		}
+	}
+}
+
+func TestReadSceneWithLensShiftAndTilt(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+camera_shift 0.1 0.25
+camera_tilt 15 -5
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+`)
+	defer cleanup()
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cam := sc.Camera
+	if cam.ShiftX != 0.1 || cam.ShiftY != 0.25 || cam.TiltX != 15 || cam.TiltY != -5 {
+		t.Fatalf("unexpected lens settings: shift (%f, %f), tilt (%f, %f)", cam.ShiftX, cam.ShiftY, cam.TiltX, cam.TiltY)
+	}
+
+	badSceneFile, badCleanup := writeTempScene(t, "camera_tilt 90 0\n")
+	defer badCleanup()
+
+	_, err = ReadScene(badSceneFile)
+	if err == nil {
+		t.Fatal("expected an error for an out of range tilt angle")
+	}
+}
+
+func TestReadSceneWithObjectOverrides(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+o floor
+f 1 2 3
+o decal
+ray_bias 0.001
+invisible_to_camera
+f 1 2 3
+`)
+	defer cleanup()
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expBias := []float32{0, 0.001}
+	if len(sc.MeshInstanceList) != len(expBias) {
+		t.Fatalf("expected %d mesh instances; got %d", len(expBias), len(sc.MeshInstanceList))
+	}
+	expFlags := []scene.MeshInstanceFlag{0, scene.CameraInvisible}
+	for index, mi := range sc.MeshInstanceList {
+		if mi.RayBias != expBias[index] {
+			t.Errorf("[instance %d] expected ray bias to be %f; got %f", index, expBias[index], mi.RayBias)
+		}
+		if mi.Flags != expFlags[index] {
+			t.Errorf("[instance %d] expected flags to be %d; got %d", index, expFlags[index], mi.Flags)
+		}
+	}
+
+	badSceneFile, badCleanup := writeTempScene(t, "ray_bias 0.001\n")
+	defer badCleanup()
+
+	_, err = ReadScene(badSceneFile)
+	if err == nil {
+		t.Fatal("expected an error for a ray bias directive without an object")
+	}
+}
+
+func TestReadSceneWithBackplate(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl scene_diffuse_material
+Kd 0 0 0.5
+
+newmtl scene_backplate_material
+Kd 0.9 0 0
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sc.SceneBackplateMatIndex == -1 {
+		t.Fatal("expected backplate material to be defined")
+	}
+	if sc.SceneBackplateMatIndex == sc.SceneDiffuseMatIndex {
+		t.Fatal("expected backplate and scene diffuse materials to use different material nodes")
+	}
+	if exp, got := (types.Vec3{0.9, 0, 0}), sc.MaterialNodeList[sc.SceneBackplateMatIndex].Union2.Vec3(); got != exp {
+		t.Fatalf("expected backplate reflectance to be %v; got %v", exp, got)
+	}
+}
+
+func TestReadSceneWithMissingMaterials(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+f 1 2 3
+usemtl red
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl red
+Kd 0.9 0 0
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions
+	opts.MissingMaterialPolicy = compiler.UseDebugMaterial
+	sc, _, err := ReadSceneWithOptions(sceneFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pink, red int
+	for _, matNodeIndex := range sc.MaterialIndex {
+		switch sc.MaterialNodeList[matNodeIndex].Union2.Vec3() {
+		case types.Vec3{1, 0, 1}:
+			pink++
+		case types.Vec3{0.9, 0, 0}:
+			red++
+		}
+	}
+	if pink != 1 || red != 1 {
+		t.Fatalf("expected 1 primitive with the debug material and 1 with the red material; got %d and %d", pink, red)
+	}
+
+	opts.MissingMaterialPolicy = compiler.FailOnMissingMaterial
+	_, _, err = ReadSceneWithOptions(sceneFile, opts)
+	if err == nil {
+		t.Fatal("expected an error for a face without a material")
+	}
+}
+
+func TestReadSceneWithGroundPlane(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+ground_plane reflective
+v -1 2 -1
+v 1 2 -1
+v 0 4 1
+f 1 2 3
+`)
+	defer cleanup()
+
+	sc, err := ReadScene(sceneFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sc.MeshInstanceList) != 2 {
+		t.Fatalf("expected a mesh instance to be generated for the ground plane; got %d instances", len(sc.MeshInstanceList))
+	}
+
+	if exp, got := scene.ShadowCatcher|scene.CatcherReflections, sc.MeshInstanceList[1].Flags; got != exp {
+		t.Fatalf("expected ground plane instance flags to be %d; got %d", exp, got)
+	}
+
+	// The plane should be aligned to the bottom of the scene bbox
+	for index, v := range sc.VertexList {
+		if index < 3 {
+			continue
+		}
+		if v[1] != 2 {
+			t.Fatalf("[vertex %d] expected ground plane vertex y coordinate to be 2; got %f", index, v[1])
+		}
+	}
+
+	badSceneFile, badCleanup := writeTempScene(t, "ground_plane shiny\n")
+	defer badCleanup()
+
+	_, err = ReadScene(badSceneFile)
+	if err == nil {
+		t.Fatal("expected an error for an invalid ground_plane argument")
+	}
+}
+
+func TestReadSceneWithMaterialConversions(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+usemtl chrome
+f 1 2 3
+usemtl glass
+f 1 2 3
+usemtl custom
+f 1 2 3
+usemtl chalk
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl chrome
+Kd 0.5 0.5 0.5
+Ks 0.9 0.9 0.9
+Ns 100
+illum 3
+Ns 200
+
+newmtl glass
+Ks 0.9 0.9 0.9
+Tf 0.9 0.9 0.9
+Ni 1.5
+d 0.5
+
+newmtl custom
+Kd 0.5 0.5 0.5
+mat_expr diffuse(reflectance: {0.1, 0.1, 0.1})
+
+newmtl chalk
+include chrome
+Ks 0 0 0
+Ni 1.3
+
+newmtl unused
+Ns 10
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Conversions should not abort loading in strict mode
+	opts := DefaultOptions
+	opts.Strict = true
+	_, report, err := ReadSceneWithReport(sceneFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expConversions := []compiler.MaterialConversion{
+		{Material: "chrome", Property: "Ns", Action: compiler.ConversionDropped},
+		{Material: "chrome", Property: "illum", Action: compiler.ConversionDropped},
+		{Material: "chrome", Property: "Ks", Action: compiler.ConversionApproximated},
+		{Material: "chrome", Property: "Kd", Action: compiler.ConversionDropped},
+		{Material: "glass", Property: "d", Action: compiler.ConversionDropped},
+		{Material: "custom", Property: "Kd", Action: compiler.ConversionDropped},
+		{Material: "chalk", Property: "Ns", Action: compiler.ConversionDropped},
+		{Material: "chalk", Property: "illum", Action: compiler.ConversionDropped},
+		{Material: "chalk", Property: "Ni", Action: compiler.ConversionDropped},
+	}
+	if len(report.Conversions) != len(expConversions) {
+		t.Fatalf("expected %d conversions; got %d: %v", len(expConversions), len(report.Conversions), report.Conversions)
+	}
+	for index, exp := range expConversions {
+		got := report.Conversions[index]
+		if got.Material != exp.Material || got.Property != exp.Property || got.Action != exp.Action || got.Details == "" {
+			t.Errorf("[conversion %d] expected %s %s to be %s; got %v", index, exp.Material, exp.Property, exp.Action, got)
+		}
+	}
+}
+
+func TestReadSceneWithPBRMaterials(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+usemtl painted
+f 1 2 3
+usemtl lamp
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl painted
+Kd 0.8 0.1 0.1
+Ks 0.5 0.5 0.5
+Tf 0.9 0.9 0.9
+Ni 1.45
+Pr 0.4
+Pm 0
+Pc 1
+Pcr 0.1
+
+newmtl lamp
+Ke 1 1 1
+Pr 0.5
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions
+	opts.Strict = true
+	_, report, err := ReadSceneWithReport(sceneFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expConversions := []compiler.MaterialConversion{
+		{Material: "painted", Property: "Pcr", Action: compiler.ConversionDropped},
+		{Material: "painted", Property: "Tf", Action: compiler.ConversionDropped},
+		{Material: "painted", Property: "Ks", Action: compiler.ConversionDropped},
+		{Material: "lamp", Property: "Pr", Action: compiler.ConversionDropped},
+	}
+	if len(report.Conversions) != len(expConversions) {
+		t.Fatalf("expected %d conversions; got %d: %v", len(expConversions), len(report.Conversions), report.Conversions)
+	}
+	for index, exp := range expConversions {
+		got := report.Conversions[index]
+		if got.Material != exp.Material || got.Property != exp.Property || got.Action != exp.Action || got.Details == "" {
+			t.Errorf("[conversion %d] expected %s %s to be %s; got %v", index, exp.Material, exp.Property, exp.Action, got)
+		}
+	}
+
+	wf := &wavefrontMaterial{Kd: types.Vec3{0.5, 0.5, 0.5}, Pm: 1, PmTex: "metal.png", Pr: 0.25, PBR: true}
+	if exp := `principled(baseColor: {0.500000, 0.500000, 0.500000}, metallic: "metal.png", roughness: 0.25)`; wf.GetExpression() != exp {
+		t.Fatalf("expected expression %q; got %q", exp, wf.GetExpression())
+	}
+	if conv := wf.roughnessConvention(material.RoughnessAuto); conv != material.RoughnessPerceptual {
+		t.Fatalf("expected PBR materials to use the perceptual roughness convention; got %s", conv)
+	}
+	if conv := wf.roughnessConvention(material.RoughnessAlpha); conv != material.RoughnessAuto {
+		t.Fatalf("expected the reader roughness convention to take precedence; got %s", conv)
+	}
+}
+
+func TestReadSceneWithDisagreeingShadingNormals(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+v 0 0 0
+v 1 0 0
+v 0 1 0
+vn 0 0 1
+vn 0 0 -1
+vn 1 0 1
+g tri
+f 1//1 2//1 3//2
+g ok
+f 1//1 2//3 3//1
+`)
+	defer cleanup()
+
+	_, warnings, err := ReadSceneWithOptions(sceneFile, DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) != 1 || warnings[0].Section != compiler.SectionGeometry || !strings.Contains(warnings[0].Message, `1/1 primitives in mesh "tri"`) {
+		t.Fatalf("expected to get a shading normal warning for mesh tri; got %v", warnings)
+	}
+
+	// Clamp normals
+	opts := DefaultOptions
+	opts.ShadingNormalFix = compiler.ClampShadingNormals
+	opts.MaxShadingNormalAngle = 60
+	sc, warnings, err := ReadSceneWithOptions(sceneFile, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected clamped normals not to generate warnings; got %v", warnings)
+	}
+
+	cosMax := float32(math.Cos(60 * math.Pi / 180))
+	for index, n := range sc.NormalList {
+		if cosAngle := n.Vec3().Dot(types.Vec3{0, 0, 1}); cosAngle < cosMax-1e-4 {
+			t.Errorf("[normal %d] expected normal %v to be within 60 degrees of the geometric normal", index, n)
+		}
+	}
+	if exp := (types.Vec3{0, 0, 1}); sc.NormalList[0].Vec3() != exp {
+		t.Errorf("expected normals within the max angle to remain unmodified; got %v", sc.NormalList[0])
+	}
+}
+
+func TestReadSceneWithPolygonsAndTextureOptions(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0.5 2 0
+v 0 1 0
+vt 0 0
+vt 1 1
+o pentagon
+usemtl wood
+f 1/1 2/1 3/2 4/2 5/2
+o tri
+v 3 0 0
+v 4 0 0
+v 3 1 0
+f -3 -2 -1
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl wood
+map_Kd -s 2 2 -o 0.5 -colorspace linear wood grain.png
+map_bump -bm 0.5 "wood grain.png"
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sceneRes, err := asset.NewResource(sceneFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sceneRes.Close()
+
+	report := compiler.NewReport(nil, true)
+	r := newWavefrontReader(DefaultOptions, report)
+	if err = r.parse(sceneRes); err != nil {
+		t.Fatal(err)
+	}
+	r.processMaterials()
+
+	meshes := r.rawScene.Meshes
+	if len(meshes) != 2 || meshes[0].Name != "pentagon" || meshes[1].Name != "tri" {
+		t.Fatalf("expected the pentagon and tri meshes to be defined; got %d meshes", len(meshes))
+	}
+	if len(meshes[0].Primitives) != 3 {
+		t.Fatalf("expected the pentagon to be split into 3 triangles; got %d", len(meshes[0].Primitives))
+	}
+	if exp := [3]types.Vec3{{0, 0, 0}, {0.5, 2, 0}, {0, 1, 0}}; meshes[0].Primitives[2].Vertices != exp {
+		t.Fatalf("expected the last pentagon triangle to be %v; got %v", exp, meshes[0].Primitives[2].Vertices)
+	}
+	if exp := [3]types.Vec3{{3, 0, 0}, {4, 0, 0}, {3, 1, 0}}; meshes[1].Primitives[0].Vertices != exp {
+		t.Fatalf("expected negative indices to select the last 3 vertices %v; got %v", exp, meshes[1].Primitives[0].Vertices)
+	}
+
+	mat := r.materials[r.matNameToIndex["wood"]]
+	if mat.KdTex != "wood grain.png" || mat.BumpTex != "wood grain.png" {
+		t.Fatalf("expected texture paths with spaces to be parsed; got %q and %q", mat.KdTex, mat.BumpTex)
+	}
+	if cs := mat.TexColorSpaces["wood grain.png"]; cs != texture.ColorSpaceLinear {
+		t.Fatalf("expected the -colorspace option to be applied; got %v", cs)
+	}
+
+	expConversions := []string{"map_Kd -s", "map_Kd -o", "map_bump -bm"}
+	if len(report.Conversions) != len(expConversions) {
+		t.Fatalf("expected %d conversions; got %d: %v", len(expConversions), len(report.Conversions), report.Conversions)
+	}
+	for index, exp := range expConversions {
+		got := report.Conversions[index]
+		if got.Material != "wood" || got.Property != exp || got.Action != compiler.ConversionDropped || got.Details == "unsupported MTL property" {
+			t.Errorf("[conversion %d] expected texture option %q to be dropped; got %v", index, exp, got)
+		}
+	}
+}
+
+func TestReadSceneWithOpacityMaps(t *testing.T) {
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+usemtl leaf
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
+newmtl leaf
+Kd 0.2 0.5 0.1
+map_normal leaf_normal.png
+map_d leaf alpha.png
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sceneRes, err := asset.NewResource(sceneFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sceneRes.Close()
+
+	report := compiler.NewReport(nil, true)
+	r := newWavefrontReader(DefaultOptions, report)
+	if err = r.parse(sceneRes); err != nil {
+		t.Fatal(err)
+	}
+	r.processMaterials()
+
+	// The alpha cutout must wrap the normal map modifier
+	expr := r.materials[r.matNameToIndex["leaf"]].GetExpression()
+	if !strings.HasPrefix(expr, "alphaCutout(normalMap(diffuse(") || !strings.HasSuffix(expr, `, "leaf alpha.png")`) {
+		t.Fatalf("expected an alpha cutout expression using the opacity map; got %s", expr)
+	}
+	pe, err := material.ParseExpression(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pe.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Conversions) != 0 {
+		t.Fatalf("expected map_d to be converted; got %v", report.Conversions)
+	}
+}
+
+func TestParseTextureMapErrors(t *testing.T) {
+	specs := []struct {
+		line   string
+		expErr string
+	}{
+		{"map_Kd -s", `expected 1 argument(s) for option "-s"`},
+		{"map_Kd -bm 1", "missing texture path"},
+		{"map_Kd -colorspace", `expected 1 argument(s) for option "-colorspace"`},
+		{"map_Kd -colorspace rec709 foo.png", "rec709"},
+	}
+
+	for index, spec := range specs {
+		_, err := (&wavefrontMaterial{}).parseTextureMap(strings.Fields(spec.line))
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", index, spec.expErr, err)
+		}
+	}
+}
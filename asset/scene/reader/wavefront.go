@@ -13,10 +13,14 @@ import (
 	"github.com/achilleasa/polaris/asset/compiler/input"
 	"github.com/achilleasa/polaris/asset/material"
 	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
 	"github.com/achilleasa/polaris/log"
 	"github.com/achilleasa/polaris/types"
 )
 
+// The number of parsed lines between progress updates.
+const progressLineInterval = 10000
+
 type wavefrontMaterial struct {
 	Name string
 
@@ -36,17 +40,44 @@ type wavefrontMaterial struct {
 	// Index of refraction.
 	Ni float32
 
+	// PBR extension roughness, metallic, sheen and clearcoat values.
+	Pr float32
+	Pm float32
+	Ps float32
+	Pc float32
+
 	// Textures for modulating above parameters.
 	KdTex     string
 	KsTex     string
 	KeTex     string
 	TfTex     string
+	PrTex     string
+	PmTex     string
 	BumpTex   string
 	NormalTex string
 
+	// Opacity texture; texels with an opacity below the default alpha
+	// cutoff are treated as transparent.
+	OpacityTex string
+
+	// True if the material defines any PBR extension property; such
+	// materials are mapped to a principled bxdf.
+	PBR bool
+
+	// Texture color spaces specified via the -colorspace map option.
+	TexColorSpaces map[string]texture.ColorSpace
+
+	// The convention used by roughness values and textures in the
+	// material expression; set via roughness_convention.
+	RoughnessConvention material.RoughnessConvention
+
 	// Layered material expression.
 	MaterialExpression string
 
+	// MTL properties that are not supported by polaris and were ignored
+	// while parsing the material.
+	Unsupported []string
+
 	// Relative path for textures.
 	AssetRelPath *asset.Resource
 
@@ -66,6 +97,36 @@ func (wf *wavefrontMaterial) GetExpression() string {
 	var bxdf material.BxdfType
 	var exprArgs = make([]string, 0)
 	switch {
+	case wf.PBR && !isEmissive:
+		bxdf = material.BxdfPrincipled
+
+		if wf.KdTex != "" {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %q", material.ParamBaseColor, wf.KdTex))
+		} else if wf.Kd.MaxComponent() > 0.0 {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamBaseColor, wf.Kd))
+		}
+
+		if wf.PmTex != "" {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %q", material.ParamMetallic, wf.PmTex))
+		} else {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamMetallic, wf.Pm))
+		}
+
+		if wf.PrTex != "" {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %q", material.ParamRoughness, wf.PrTex))
+		} else {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamRoughness, wf.Pr))
+		}
+
+		if wf.Ps != 0.0 {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamSheen, wf.Ps))
+		}
+		if wf.Pc != 0.0 {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamClearcoat, wf.Pc))
+		}
+		if wf.Ni != 0.0 {
+			exprArgs = append(exprArgs, fmt.Sprintf("%s: %v", material.ParamIntIOR, wf.Ni))
+		}
 	case isSpecularReflection && wf.Ni == 0.0:
 		bxdf = material.BxdfConductor
 
@@ -121,9 +182,256 @@ func (wf *wavefrontMaterial) GetExpression() string {
 		materialExpr = fmt.Sprintf("bumpMap(%s, %q)", materialExpr, wf.BumpTex)
 	}
 
+	// Alpha cutouts must wrap the entire expression
+	if wf.OpacityTex != "" {
+		materialExpr = fmt.Sprintf("alphaCutout(%s, %q)", materialExpr, wf.OpacityTex)
+	}
+
 	return materialExpr
 }
 
+// The options of MTL texture map statements. Options are followed by at least
+// minArgs and at most maxArgs arguments; optional arguments must be numeric.
+type mtlTextureOption struct {
+	minArgs, maxArgs int
+	details          string
+}
+
+var mtlTextureOptions = map[string]mtlTextureOption{
+	"-colorspace": {1, 1, ""},
+	"-blendu":     {1, 1, "texture blending is not supported"},
+	"-blendv":     {1, 1, "texture blending is not supported"},
+	"-boost":      {1, 1, "mip-map sharpness cannot be adjusted"},
+	"-cc":         {1, 1, "color correction is not supported"},
+	"-clamp":      {1, 1, "textures always repeat"},
+	"-mm":         {1, 2, "texture value ranges cannot be remapped"},
+	"-o":          {1, 3, "texture coordinate offsets are not supported"},
+	"-s":          {1, 3, "texture coordinate scaling is not supported"},
+	"-t":          {1, 3, "texture turbulence is not supported"},
+	"-texres":     {1, 1, "texture resolution is selected by the texture budget"},
+	"-bm":         {1, 1, "bump maps are always applied at full strength"},
+	"-imfchan":    {1, 1, "scalar textures always use the first channel"},
+	"-type":       {1, 1, "reflection maps are not supported"},
+}
+
+// Parse the texture path of a map statement. The path may contain spaces and
+// may be preceded by any of the standard MTL texture options; these options
+// are recorded as unsupported properties. Polaris also supports a
+// "-colorspace srgb|linear" option which overrides the color space that the
+// scene compiler selects based on the texture usage.
+func (wf *wavefrontMaterial) parseTextureMap(lineTokens []string) (string, error) {
+	var colorSpace string
+	tokIdx := 1
+	for ; tokIdx < len(lineTokens); tokIdx++ {
+		opt, isOption := mtlTextureOptions[lineTokens[tokIdx]]
+		if !isOption {
+			break
+		}
+
+		optName := lineTokens[tokIdx]
+		if tokIdx+opt.minArgs >= len(lineTokens) {
+			return "", fmt.Errorf(`unsupported syntax for "%s"; expected %d argument(s) for option "%s" followed by a texture path`, lineTokens[0], opt.minArgs, optName)
+		}
+		if optName == "-colorspace" {
+			colorSpace = lineTokens[tokIdx+1]
+		} else {
+			wf.addUnsupported(lineTokens[0] + " " + optName)
+		}
+		tokIdx += opt.minArgs
+
+		// Consume optional numeric arguments but always leave a token for the path
+		for optArgs := opt.minArgs; optArgs < opt.maxArgs && tokIdx+2 < len(lineTokens); optArgs++ {
+			if _, err := strconv.ParseFloat(lineTokens[tokIdx+1], 32); err != nil {
+				break
+			}
+			tokIdx++
+		}
+	}
+
+	if tokIdx >= len(lineTokens) {
+		return "", fmt.Errorf(`unsupported syntax for "%s"; missing texture path`, lineTokens[0])
+	}
+	texPath := strings.Trim(strings.Join(lineTokens[tokIdx:], " "), `"`)
+
+	if colorSpace != "" {
+		cs, err := texture.ParseColorSpace(colorSpace)
+		if err != nil {
+			return "", err
+		}
+
+		if wf.TexColorSpaces == nil {
+			wf.TexColorSpaces = make(map[string]texture.ColorSpace)
+		}
+		wf.TexColorSpaces[texPath] = cs
+	}
+	return texPath, nil
+}
+
+// Descriptions for common MTL properties that polaris does not support.
+var unsupportedMtlProperties = map[string]string{
+	"Ka":     "ambient color is not used by the path tracer",
+	"map_Ka": "ambient color is not used by the path tracer",
+	"Ns":     "specular exponents are not supported; use Pr or a mat_expr with a rough bxdf for rough surfaces",
+	"map_Ns": "specular exponents are not supported",
+	"d":      "partial transparency is not supported; use map_d for alpha cutouts",
+	"Tr":     "partial transparency is not supported; use map_d for alpha cutouts",
+	"illum":  "the bxdf is selected based on the Kd, Ks, Ke, Tf and Ni properties",
+	"disp":   "displacement maps are not supported",
+	"bump":   "use map_bump to specify bump maps",
+	"map_Ps": "PBR sheen maps are not supported",
+	"map_Pc": "PBR clearcoat maps are not supported",
+	"Pcr":    "the PBR clearcoat layer uses a fixed roughness",
+	"aniso":  "anisotropic reflections are not supported",
+	"anisor": "anisotropic reflections are not supported",
+}
+
+// Record a property that is not supported by polaris.
+func (wf *wavefrontMaterial) addUnsupported(property string) {
+	for _, prop := range wf.Unsupported {
+		if prop == property {
+			return
+		}
+	}
+	wf.Unsupported = append(wf.Unsupported, property)
+}
+
+// Report the properties of this material that are either ignored or
+// approximated by the expression returned by GetExpression.
+func (wf *wavefrontMaterial) reportConversions(report *compiler.Report) {
+	for _, prop := range wf.Unsupported {
+		details, known := unsupportedMtlProperties[prop]
+		if optTokens := strings.Fields(prop); !known && len(optTokens) == 2 {
+			// Texture map options are recorded as "map_xx -option"
+			var opt mtlTextureOption
+			opt, known = mtlTextureOptions[optTokens[1]]
+			details = opt.details
+		}
+		if !known {
+			details = "unsupported MTL property"
+		}
+		report.Convert(wf.Name, prop, compiler.ConversionDropped, "%s", details)
+	}
+
+	if wf.MaterialExpression != "" {
+		for _, prop := range wf.definedProperties() {
+			report.Convert(wf.Name, prop, compiler.ConversionDropped, "overridden by mat_expr")
+		}
+		return
+	}
+
+	isSpecularReflection := wf.Ks.MaxComponent() > 0.0 || wf.KsTex != ""
+	isEmissive := wf.Ke.MaxComponent() > 0.0 || wf.KeTex != ""
+	hasKd := wf.Kd.MaxComponent() > 0.0 || wf.KdTex != ""
+	hasTf := wf.Tf.MaxComponent() > 0.0 || wf.TfTex != ""
+
+	var bxdf material.BxdfType
+	switch {
+	case wf.PBR && !isEmissive:
+		bxdf = material.BxdfPrincipled
+	case isSpecularReflection && wf.Ni == 0.0:
+		bxdf = material.BxdfConductor
+		report.Convert(wf.Name, "Ks", compiler.ConversionApproximated, "rendered as a smooth conductor as Ni is not defined")
+	case isSpecularReflection && wf.Ni != 0.0:
+		bxdf = material.BxdfDielectric
+	case isEmissive:
+		bxdf = material.BxdfEmissive
+	default:
+		bxdf = material.BxdfDiffuse
+	}
+
+	if hasKd && bxdf != material.BxdfDiffuse && bxdf != material.BxdfPrincipled {
+		report.Convert(wf.Name, "Kd", compiler.ConversionDropped, "the diffuse color is not used by %s materials", bxdf)
+	}
+	if hasTf && bxdf == material.BxdfPrincipled {
+		report.Convert(wf.Name, "Tf", compiler.ConversionDropped, "transmission filters are not supported by PBR materials")
+	} else if hasTf && bxdf != material.BxdfDielectric {
+		report.Convert(wf.Name, "Tf", compiler.ConversionDropped, "transmission requires both Ks and Ni to be defined")
+	}
+	if isSpecularReflection && bxdf == material.BxdfPrincipled {
+		report.Convert(wf.Name, "Ks", compiler.ConversionDropped, "the specular reflectance of PBR materials is derived from Ni and Pm")
+	}
+	if wf.PBR && bxdf != material.BxdfPrincipled {
+		for _, prop := range wf.definedProperties() {
+			if strings.HasPrefix(prop, "P") || strings.HasPrefix(prop, "map_P") {
+				report.Convert(wf.Name, prop, compiler.ConversionDropped, "PBR properties are not combined with %s materials", bxdf)
+			}
+		}
+	}
+	if wf.Ni != 0.0 && bxdf != material.BxdfDielectric && bxdf != material.BxdfPrincipled {
+		report.Convert(wf.Name, "Ni", compiler.ConversionDropped, "the index of refraction is only used by dielectric materials which require Ks")
+	}
+	if isEmissive && bxdf != material.BxdfEmissive {
+		report.Convert(wf.Name, "Ke", compiler.ConversionDropped, "emission is not combined with %s materials", bxdf)
+	}
+
+	// Textures replace the constant values instead of modulating them
+	for _, prop := range []struct {
+		name  string
+		value types.Vec3
+		tex   string
+		used  bool
+	}{
+		{"Kd", wf.Kd, wf.KdTex, bxdf == material.BxdfDiffuse || bxdf == material.BxdfPrincipled},
+		{"Ks", wf.Ks, wf.KsTex, bxdf == material.BxdfConductor || bxdf == material.BxdfDielectric},
+		{"Ke", wf.Ke, wf.KeTex, bxdf == material.BxdfEmissive},
+		{"Tf", wf.Tf, wf.TfTex, bxdf == material.BxdfDielectric},
+	} {
+		if prop.used && prop.value.MaxComponent() > 0.0 && prop.tex != "" {
+			report.Convert(wf.Name, prop.name, compiler.ConversionApproximated, "the color is replaced by map_%s instead of modulating it", prop.name)
+		}
+	}
+
+	if wf.NormalTex != "" && wf.BumpTex != "" {
+		report.Convert(wf.Name, "map_bump", compiler.ConversionDropped, "map_normal takes precedence over map_bump")
+	}
+}
+
+// Get the roughness convention for the material expression. Like glTF, the
+// PBR extension uses perceptual roughness so unless the material or the
+// reader options select a convention, PBR materials use the perceptual one.
+func (wf *wavefrontMaterial) roughnessConvention(readerConv material.RoughnessConvention) material.RoughnessConvention {
+	if wf.RoughnessConvention != material.RoughnessAuto || readerConv != material.RoughnessAuto {
+		return wf.RoughnessConvention
+	}
+	if wf.PBR && wf.MaterialExpression == "" {
+		return material.RoughnessPerceptual
+	}
+	return material.RoughnessAuto
+}
+
+// Get the names of the MTL properties that are defined by this material.
+func (wf *wavefrontMaterial) definedProperties() []string {
+	var props []string
+	for _, prop := range []struct {
+		name    string
+		defined bool
+	}{
+		{"Kd", wf.Kd.MaxComponent() > 0.0},
+		{"Ks", wf.Ks.MaxComponent() > 0.0},
+		{"Ke", wf.Ke.MaxComponent() > 0.0},
+		{"Tf", wf.Tf.MaxComponent() > 0.0},
+		{"Ni", wf.Ni != 0.0},
+		{"map_Kd", wf.KdTex != ""},
+		{"map_Ks", wf.KsTex != ""},
+		{"map_Ke", wf.KeTex != ""},
+		{"map_Tf", wf.TfTex != ""},
+		{"Pr", wf.Pr != 0.0},
+		{"Pm", wf.Pm != 0.0},
+		{"Ps", wf.Ps != 0.0},
+		{"Pc", wf.Pc != 0.0},
+		{"map_Pr", wf.PrTex != ""},
+		{"map_Pm", wf.PmTex != ""},
+		{"map_bump", wf.BumpTex != ""},
+		{"map_normal", wf.NormalTex != ""},
+		{"map_d", wf.OpacityTex != ""},
+	} {
+		if prop.defined {
+			props = append(props, prop.name)
+		}
+	}
+	return props
+}
+
 type wavefrontSceneReader struct {
 	logger log.Logger
 
@@ -144,21 +452,82 @@ type wavefrontSceneReader struct {
 	normalList []types.Vec3
 	uvList     []types.Vec2
 
+	// The number of parsed primitives.
+	numPrimitives int
+
 	// An error stack that provides additional error information when
 	// scene files include other files (models, mat libs e.t.c)
 	errStack []string
+
+	// Limits for protecting against malformed input files.
+	limits Limits
+
+	// The current include nesting level.
+	includeDepth int
+
+	// The total number of parsed lines.
+	parsedLines int
+
+	// Collects progress and non-fatal issues; may be nil.
+	report *compiler.Report
+
+	// The max number of bytes for texture data; see Options.
+	textureBudget int
+
+	// The color space for textures without a -colorspace option; see Options.
+	textureColorSpace texture.ColorSpace
+
+	// The roughness convention for materials without a
+	// roughness_convention command; see Options.
+	roughnessConvention material.RoughnessConvention
+
+	// Settings for handling shading normals; see Options.
+	normalFix      compiler.ShadingNormalFix
+	maxNormalAngle float32
+
+	// The policy for faces without a material; see Options.
+	missingMaterialPolicy compiler.MissingMaterialPolicy
+
+	// The camera modified by camera_* commands. It is nil until the
+	// default camera is configured or a named camera is defined.
+	curCamera *input.Camera
+
+	// Ground plane settings; nil if the scene does not request a ground plane.
+	groundPlane *groundPlaneSettings
+}
+
+// Settings for the auto-generated shadow catcher ground plane.
+type groundPlaneSettings struct {
+	material   *wavefrontMaterial
+	reflective bool
+}
+
+// An error returned when a mesh instance references an undefined mesh.
+type unknownMeshError string
+
+// Implements error.
+func (e unknownMeshError) Error() string {
+	return fmt.Sprintf(`unknown mesh with name "%s"`, string(e))
 }
 
 // Create a new text scene reader.
-func newWavefrontReader() *wavefrontSceneReader {
+func newWavefrontReader(opts Options, report *compiler.Report) *wavefrontSceneReader {
 	return &wavefrontSceneReader{
-		logger:         log.New("wavefront scene reader"),
-		rawScene:       input.NewScene(),
-		matNameToIndex: make(map[string]int, 0),
-		vertexList:     make([]types.Vec3, 0),
-		normalList:     make([]types.Vec3, 0),
-		uvList:         make([]types.Vec2, 0),
-		errStack:       make([]string, 0),
+		logger:                log.New("wavefront scene reader"),
+		limits:                opts.Limits,
+		textureBudget:         opts.TextureBudget,
+		textureColorSpace:     opts.TextureColorSpace,
+		roughnessConvention:   opts.RoughnessConvention,
+		normalFix:             opts.ShadingNormalFix,
+		maxNormalAngle:        opts.MaxShadingNormalAngle,
+		missingMaterialPolicy: opts.MissingMaterialPolicy,
+		report:                report,
+		rawScene:              input.NewScene(),
+		matNameToIndex:        make(map[string]int, 0),
+		vertexList:            make([]types.Vec3, 0),
+		normalList:            make([]types.Vec3, 0),
+		uvList:                make([]types.Vec2, 0),
+		errStack:              make([]string, 0),
 	}
 }
 
@@ -178,13 +547,33 @@ func (r *wavefrontSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, err
 		r.createDefaultMeshInstances()
 	}
 
+	// The ground plane is aligned to the bounding box of all mesh instances
+	if r.groundPlane != nil {
+		matIndex := r.matNameToIndex[r.groundPlane.material.Name]
+		if r.rawScene.AddGroundPlane(matIndex, r.groundPlane.reflective) == nil {
+			r.logger.Warning("skipping ground plane as the scene contains no geometry")
+		}
+	}
+
 	// Prune unused materials
 	r.processMaterials()
 
+	r.report.Progress(compiler.SectionParse, r.parsedLines, r.parsedLines)
 	r.logger.Noticef("parsed scene in %d ms", time.Since(start).Nanoseconds()/1e6)
 
 	// Compile scene into an optimized, gpu-friendly format
-	return compiler.Compile(r.rawScene)
+	return compiler.CompileWithOptions(
+		r.rawScene,
+		compiler.Options{
+			Report:                r.report,
+			TextureBudget:         r.textureBudget,
+			TextureColorSpace:     r.textureColorSpace,
+			RoughnessConvention:   r.roughnessConvention,
+			ShadingNormalFix:      r.normalFix,
+			MaxShadingNormalAngle: r.maxNormalAngle,
+			MissingMaterialPolicy: r.missingMaterialPolicy,
+		},
+	)
 }
 
 // Generate scene materials for material entries that are in use and update the
@@ -195,8 +584,14 @@ func (r *wavefrontSceneReader) processMaterials() {
 	pruned := 0
 	for wfIndex, wfMat := range r.materials {
 		// Whitelist scene materials
-		if wfMat.Name == compiler.SceneDiffuseMaterialName || wfMat.Name == compiler.SceneEmissiveMaterialName {
+		switch wfMat.Name {
+		case compiler.SceneDiffuseMaterialName, compiler.SceneEmissiveMaterialName, compiler.SceneBackplateMaterialName, compiler.SceneDefaultMaterialName, compiler.SceneMediumMaterialName:
 			wfMat.Used = true
+		case "":
+			// Faces without a material are assigned a material by the
+			// compiler according to its missing material policy
+			wfMaterialToSceneMaterial[wfIndex] = input.NoMaterial
+			continue
 		}
 
 		// Prune unused materials
@@ -217,12 +612,15 @@ func (r *wavefrontSceneReader) processMaterials() {
 		r.rawScene.Materials = append(
 			r.rawScene.Materials,
 			&input.Material{
-				Name:         wfMat.Name,
-				Expression:   wfMat.GetExpression(),
-				AssetRelPath: wfMat.AssetRelPath,
-				Used:         true,
+				Name:                wfMat.Name,
+				Expression:          wfMat.GetExpression(),
+				AssetRelPath:        wfMat.AssetRelPath,
+				TextureColorSpaces:  wfMat.TexColorSpaces,
+				RoughnessConvention: wfMat.roughnessConvention(r.roughnessConvention),
+				Used:                true,
 			},
 		)
+		wfMat.reportConversions(r.report)
 
 		wfMaterialToSceneMaterial[wfIndex] = len(r.rawScene.Materials) - 1
 	}
@@ -277,6 +675,26 @@ func (r *wavefrontSceneReader) emitError(file string, line int, msgFormat string
 	return fmt.Errorf(errMsg)
 }
 
+// Log a non-fatal issue and record it to the loading report. If the report
+// operates in strict mode the issue is returned back as an error.
+func (r *wavefrontSceneReader) warn(file string, line int, msgFormat string, args ...interface{}) error {
+	msg := fmt.Sprintf(msgFormat, args...)
+	r.logger.Warningf("[%s: %d] %s", file, line, msg)
+
+	if err := r.report.Warn(compiler.SectionParse, "[%s: %d] %s", file, line, msg); err != nil {
+		return r.emitError(file, line, "%s", msg)
+	}
+	return nil
+}
+
+// Count a parsed line and periodically report parsing progress.
+func (r *wavefrontSceneReader) countLine() {
+	r.parsedLines++
+	if r.parsedLines%progressLineInterval == 0 {
+		r.report.Progress(compiler.SectionParse, r.parsedLines, 0)
+	}
+}
+
 // Push a frame to the error stack.
 func (r *wavefrontSceneReader) pushFrame(msg string) {
 	r.errStack = append([]string{msg}, r.errStack...)
@@ -316,9 +734,10 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 	relUvOffset := len(r.uvList)
 	relNormalOffset := len(r.normalList)
 
-	scanner := bufio.NewScanner(res)
+	scanner := bufio.NewScanner(r.limits.limitReader(res))
 	for scanner.Scan() {
 		lineNum++
+		r.countLine()
 		lineTokens := strings.Fields(scanner.Text())
 		if len(lineTokens) == 0 || strings.HasPrefix(lineTokens[0], "#") {
 			continue
@@ -330,20 +749,30 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 				return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
 			}
 
+			if r.includeDepth >= r.limits.MaxIncludeDepth {
+				return r.emitError(res.Path(), lineNum, "%s", ErrIncludeDepthExceeded.Error())
+			}
+
 			r.pushFrame(fmt.Sprintf("referenced from %s:%d [%s]", res.Path(), lineNum, lineTokens[0]))
 
 			incRes, err := asset.NewResource(lineTokens[1], res)
 			if err != nil {
-				return r.emitError(res.Path(), lineNum, err.Error())
+				r.popFrame()
+				if err = r.warn(res.Path(), lineNum, "skipping %s: %s", lineTokens[0], err.Error()); err != nil {
+					return err
+				}
+				continue
 			}
 			defer incRes.Close()
 
+			r.includeDepth++
 			switch lineTokens[0] {
 			case "call":
 				err = r.parse(incRes)
 			case "mtllib":
 				err = r.parseMaterials(incRes)
 			}
+			r.includeDepth--
 
 			if err != nil {
 				return err
@@ -358,7 +787,11 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 			matName := lineTokens[1]
 			matIndex, exists := r.matNameToIndex[matName]
 			if !exists {
-				return r.emitError(res.Path(), lineNum, `undefined material with name "%s"`, matName)
+				if err = r.warn(res.Path(), lineNum, `undefined material with name "%s"; using default material`, matName); err != nil {
+					return err
+				}
+				r.defaultMaterial()
+				continue
 			}
 
 			// Activate material
@@ -369,18 +802,27 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 			r.vertexList = append(r.vertexList, v)
+			if err = r.limits.checkElements("vertices", len(r.vertexList)); err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
 		case "vn":
 			v, err := parseVec3(lineTokens)
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 			r.normalList = append(r.normalList, v)
+			if err = r.limits.checkElements("normals", len(r.normalList)); err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
 		case "vt":
 			v, err := parseVec2(lineTokens)
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 			r.uvList = append(r.uvList, v)
+			if err = r.limits.checkElements("uv coordinates", len(r.uvList)); err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
 		case "g", "o":
 			if len(lineTokens) < 2 {
 				return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument for object name; got %d`, lineTokens[0], len(lineTokens)-1)
@@ -391,6 +833,13 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 		case "f":
 			primList, err := r.parseFace(lineTokens, relVertexOffset, relUvOffset, relNormalOffset)
 			if err != nil {
+				if err = r.warn(res.Path(), lineNum, "skipping face: %s", err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			r.numPrimitives += len(primList)
+			if err = r.limits.checkElements("primitives", r.numPrimitives); err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 
@@ -403,35 +852,162 @@ func (r *wavefrontSceneReader) parse(res *asset.Resource) error {
 			meshIndex := len(r.rawScene.Meshes) - 1
 			r.rawScene.Meshes[meshIndex].MarkBBoxDirty()
 			r.rawScene.Meshes[meshIndex].Primitives = append(r.rawScene.Meshes[meshIndex].Primitives, primList...)
+		case "ray_bias":
+			if len(r.rawScene.Meshes) == 0 {
+				return r.emitError(res.Path(), lineNum, `"ray_bias" must follow an object definition`)
+			}
+			bias, err := parseFloat32(lineTokens)
+			if err == nil && !(bias >= 0) {
+				err = fmt.Errorf("ray bias must be >= 0")
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+			r.rawScene.Meshes[len(r.rawScene.Meshes)-1].RayBias = bias
+		case "ground_plane", "shadow_catcher":
+			reflective, err := parseCatcherArgs(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+
+			if lineTokens[0] == "ground_plane" {
+				// Use the active material for the plane and flag it as
+				// being in use so we don't prune it later.
+				if r.curMaterial == nil {
+					r.defaultMaterial()
+				}
+				r.curMaterial.Used = true
+				r.groundPlane = &groundPlaneSettings{
+					material:   r.curMaterial,
+					reflective: reflective,
+				}
+				continue
+			}
+
+			if len(r.rawScene.Meshes) == 0 {
+				return r.emitError(res.Path(), lineNum, `"shadow_catcher" must follow an object definition`)
+			}
+			r.rawScene.Meshes[len(r.rawScene.Meshes)-1].ShadowCatcher = true
+			r.rawScene.Meshes[len(r.rawScene.Meshes)-1].CatcherReflections = reflective
+		case "invisible_to_camera":
+			if len(r.rawScene.Meshes) == 0 {
+				return r.emitError(res.Path(), lineNum, `"invisible_to_camera" must follow an object definition`)
+			}
+			r.rawScene.Meshes[len(r.rawScene.Meshes)-1].CameraInvisible = true
+		case "camera":
+			if len(lineTokens) != 2 {
+				return r.emitError(res.Path(), lineNum, `unsupported syntax for "camera"; expected 1 argument; got %d`, len(lineTokens)-1)
+			}
+			err = r.defineCamera(lineTokens[1])
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
 		case "camera_fov":
-			r.rawScene.Camera.FOV, err = parseFloat32(lineTokens)
+			r.camera().FOV, err = parseFloat32(lineTokens)
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 		case "camera_eye":
-			r.rawScene.Camera.Eye, err = parseVec3(lineTokens)
+			r.camera().Eye, err = parseVec3(lineTokens)
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 		case "camera_look":
-			r.rawScene.Camera.Look, err = parseVec3(lineTokens)
+			r.camera().Look, err = parseVec3(lineTokens)
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 		case "camera_up":
-			r.rawScene.Camera.Up, err = parseVec3(lineTokens)
+			r.camera().Up, err = parseVec3(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_overscan":
+			r.camera().Overscan, err = parseFloat32(lineTokens)
+			if err == nil && !(r.camera().Overscan >= 0) {
+				err = fmt.Errorf("camera overscan must be >= 0")
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_pixel_aspect":
+			r.camera().PixelAspect, err = parseFloat32(lineTokens)
+			if err == nil && !(r.camera().PixelAspect > 0) {
+				err = fmt.Errorf("camera pixel aspect ratio must be > 0")
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_aperture":
+			r.camera().ApertureRadius, err = parseFloat32(lineTokens)
+			if err == nil && !(r.camera().ApertureRadius >= 0) {
+				err = fmt.Errorf("camera aperture radius must be >= 0")
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_focus_distance":
+			r.camera().FocusDistance, err = parseFloat32(lineTokens)
+			if err == nil && !(r.camera().FocusDistance > 0) {
+				err = fmt.Errorf("camera focus distance must be > 0")
+			}
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+		case "camera_aperture_blades":
+			if len(lineTokens) != 2 && len(lineTokens) != 3 {
+				return r.emitError(res.Path(), lineNum, `unsupported syntax for "camera_aperture_blades"; expected 1 or 2 arguments; got %d`, len(lineTokens)-1)
+			}
+			blades, err := strconv.ParseUint(lineTokens[1], 10, 32)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+			r.camera().ApertureBlades = uint32(blades)
+			if len(lineTokens) == 3 {
+				r.camera().ApertureRotation, err = parseFloat32(lineTokens[1:])
+				if err != nil {
+					return r.emitError(res.Path(), lineNum, err.Error())
+				}
+			}
+		case "camera_shift":
+			shift, err := parseVec2(lineTokens)
+			if err != nil {
+				return r.emitError(res.Path(), lineNum, err.Error())
+			}
+			r.camera().ShiftX, r.camera().ShiftY = shift[0], shift[1]
+		case "camera_tilt":
+			tilt, err := parseVec2(lineTokens)
+			if err == nil && (math.Abs(float64(tilt[0])) >= 90 || math.Abs(float64(tilt[1])) >= 90) {
+				err = fmt.Errorf("camera tilt angles must be in the (-90, 90) range")
+			}
 			if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
+			r.camera().TiltX, r.camera().TiltY = tilt[0], tilt[1]
+		case "camera_bokeh_mask":
+			if len(lineTokens) != 2 {
+				return r.emitError(res.Path(), lineNum, `unsupported syntax for "camera_bokeh_mask"; expected 1 argument; got %d`, len(lineTokens)-1)
+			}
+			r.camera().BokehMask = lineTokens[1]
+			r.camera().AssetRelPath = res
 		case "instance":
 			instance, err := r.parseMeshInstance(lineTokens)
-			if err != nil {
+			if _, isUnknownMesh := err.(unknownMeshError); isUnknownMesh {
+				if err = r.warn(res.Path(), lineNum, "skipping instance: %s", err.Error()); err != nil {
+					return err
+				}
+				continue
+			} else if err != nil {
 				return r.emitError(res.Path(), lineNum, err.Error())
 			}
 			r.rawScene.MeshInstances = append(r.rawScene.MeshInstances, instance)
 		}
 	}
 
+	if err = scanner.Err(); err != nil {
+		return r.emitError(res.Path(), lineNum, err.Error())
+	}
+
 	r.verifyLastParsedMesh()
 	return nil
 }
@@ -467,7 +1043,7 @@ func (r *wavefrontSceneReader) parseMeshInstance(lineTokens []string) (*input.Me
 	}
 
 	if meshIndex == -1 {
-		return nil, fmt.Errorf(`unknown mesh with name "%s"`, meshName)
+		return nil, unknownMeshError(meshName)
 	}
 
 	var translation, rotation, scale types.Vec3
@@ -537,16 +1113,17 @@ func (r *wavefrontSceneReader) parseMeshInstance(lineTokens []string) (*input.Me
 // Indices start from 1 and may be negative to indicate
 // an offset off the end of the vertex/uv list.
 //
-// This method only works with triangular/quad faces and will return an error if a
-// face with more than 4 vertices is encountered.
+// Faces with more than 3 vertices are split into a fan of triangles so
+// non-convex polygons should be triangulated by the exporter.
 func (r *wavefrontSceneReader) parseFace(lineTokens []string, relVertexOffset, relUvOffset, relNormalOffset int) ([]*input.Primitive, error) {
-	if len(lineTokens) < 4 || len(lineTokens) > 5 {
-		return nil, fmt.Errorf(`unsupported syntax for "f"; expected 3 arguments for triangular face or 4 arguments for a quad face; got %d. Select the triangulation option in your exporter`, len(lineTokens)-1)
+	if len(lineTokens) < 4 {
+		return nil, fmt.Errorf(`unsupported syntax for "f"; expected at least 3 arguments; got %d`, len(lineTokens)-1)
 	}
 
-	var vertices [4]types.Vec3
-	var normals [4]types.Vec3
-	var uv [4]types.Vec2
+	numVertices := len(lineTokens) - 1
+	vertices := make([]types.Vec3, numVertices)
+	normals := make([]types.Vec3, numVertices)
+	uv := make([]types.Vec2, numVertices)
 	var vOffset int
 	var err error
 	expIndices := 0
@@ -604,17 +1181,17 @@ func (r *wavefrontSceneReader) parseFace(lineTokens []string, relVertexOffset, r
 		e01 := vertices[1].Sub(vertices[0])
 		e02 := vertices[2].Sub(vertices[0])
 		faceNormal := e01.Cross(e02).Normalize()
-		normals[0] = faceNormal
-		normals[1] = faceNormal
-		normals[2] = faceNormal
-		normals[3] = faceNormal
+		for index := range normals {
+			normals[index] = faceNormal
+		}
 	}
 
-	// Assemble vertices into one or two primitives depending on whether we are parsing a triangular or a quad face
-	primitives := make([]*input.Primitive, 0)
-	indiceList := [][3]int{{0, 1, 2}}
-	if len(lineTokens) == 5 {
-		indiceList = append(indiceList, [3]int{0, 2, 3})
+	// Split the face into a triangle fan. This works for triangles, quads
+	// and the convex polygons exported by most modeling tools.
+	primitives := make([]*input.Primitive, 0, numVertices-2)
+	indiceList := make([][3]int, 0, numVertices-2)
+	for index := 1; index < numVertices-1; index++ {
+		indiceList = append(indiceList, [3]int{0, index, index + 1})
 	}
 
 	var triVerts [3]types.Vec3
@@ -654,13 +1231,14 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 
 	r.logger.Infof(`parsing material library "%s"`, res.Path())
 
-	scanner := bufio.NewScanner(res)
+	scanner := bufio.NewScanner(r.limits.limitReader(res))
 
 	var curMaterial *wavefrontMaterial = nil
 	var matName string = ""
 
 	for scanner.Scan() {
 		lineNum++
+		r.countLine()
 		lineTokens := strings.Fields(scanner.Text())
 		if len(lineTokens) == 0 || strings.HasPrefix(lineTokens[0], "#") {
 			continue
@@ -697,12 +1275,23 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 
 				baseMaterialIndex, exists := r.matNameToIndex[lineTokens[1]]
 				if !exists {
-					return r.emitError(res.Path(), lineNum, `could not include unknown material "%s"`, lineTokens[1])
+					if err = r.warn(res.Path(), lineNum, `could not include unknown material "%s"; skipping`, lineTokens[1]); err != nil {
+						return err
+					}
+					continue
 				}
 
 				// Overwrite material but keep the original name
 				*curMaterial = *r.materials[baseMaterialIndex]
 				curMaterial.Name = matName
+				curMaterial.Unsupported = append([]string(nil), curMaterial.Unsupported...)
+				if curMaterial.TexColorSpaces != nil {
+					colorSpaces := make(map[string]texture.ColorSpace, len(curMaterial.TexColorSpaces))
+					for path, cs := range curMaterial.TexColorSpaces {
+						colorSpaces[path] = cs
+					}
+					curMaterial.TexColorSpaces = colorSpaces
+				}
 			case "Kd", "Ks", "Ke", "Tf":
 
 				var target *types.Vec3
@@ -720,7 +1309,26 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 				*target, err = parseVec3(lineTokens)
 			case "Ni":
 				curMaterial.Ni, err = parseFloat32(lineTokens)
-			case "map_Kd", "map_Ks", "map_Ke", "map_Tf", "map_bump", "map_normal":
+			case "Pr", "Pm", "Ps", "Pc":
+				var target *float32
+				switch lineTokens[0] {
+				case "Pr":
+					target = &curMaterial.Pr
+				case "Pm":
+					target = &curMaterial.Pm
+				case "Ps":
+					target = &curMaterial.Ps
+				case "Pc":
+					target = &curMaterial.Pc
+				}
+
+				*target, err = parseFloat32(lineTokens)
+				curMaterial.PBR = true
+			case "map_Kd", "map_Ks", "map_Ke", "map_Tf", "map_Pr", "map_Pm", "map_bump", "map_normal", "map_d":
+				if len(lineTokens) < 2 {
+					return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
+				}
+
 				var target *string
 				switch lineTokens[0] {
 				case "map_Kd":
@@ -731,13 +1339,21 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 					target = &curMaterial.KeTex
 				case "map_Tf":
 					target = &curMaterial.TfTex
+				case "map_Pr":
+					target = &curMaterial.PrTex
+					curMaterial.PBR = true
+				case "map_Pm":
+					target = &curMaterial.PmTex
+					curMaterial.PBR = true
 				case "map_bump":
 					target = &curMaterial.BumpTex
 				case "map_normal":
 					target = &curMaterial.NormalTex
+				case "map_d":
+					target = &curMaterial.OpacityTex
 				}
 
-				*target = lineTokens[1]
+				*target, err = curMaterial.parseTextureMap(lineTokens)
 			case "mat_expr":
 				if len(lineTokens) < 2 {
 					return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
@@ -748,6 +1364,13 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 					return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
 				}
 				curMaterial.KeScaler, err = parseFloat32(lineTokens)
+			case "roughness_convention":
+				if len(lineTokens) != 2 {
+					return r.emitError(res.Path(), lineNum, `unsupported syntax for "%s"; expected 1 argument; got %d`, lineTokens[0], len(lineTokens)-1)
+				}
+				curMaterial.RoughnessConvention, err = material.ParseRoughnessConvention(lineTokens[1])
+			default:
+				curMaterial.addUnsupported(lineTokens[0])
 			}
 
 			// Report any errors
@@ -757,6 +1380,10 @@ func (r *wavefrontSceneReader) parseMaterials(res *asset.Resource) error {
 		}
 	}
 
+	if err = scanner.Err(); err != nil {
+		return r.emitError(res.Path(), lineNum, err.Error())
+	}
+
 	return nil
 }
 
@@ -795,6 +1422,47 @@ func parseFloat32(lineTokens []string) (float32, error) {
 	return float32(val), nil
 }
 
+// Parse the arguments of the ground_plane and shadow_catcher commands. Both
+// commands accept an optional "reflective" argument.
+func parseCatcherArgs(lineTokens []string) (reflective bool, err error) {
+	switch {
+	case len(lineTokens) == 1:
+		return false, nil
+	case len(lineTokens) == 2 && lineTokens[1] == "reflective":
+		return true, nil
+	}
+	return false, fmt.Errorf(`unsupported syntax for "%s"; expected an optional "reflective" argument`, lineTokens[0])
+}
+
+// Get the camera that is configured by the camera_* commands.
+func (r *wavefrontSceneReader) camera() *input.Camera {
+	if r.curCamera == nil {
+		r.curCamera = r.rawScene.Camera
+	}
+	return r.curCamera
+}
+
+// Define a named camera and select it as the target for any following
+// camera_* commands. The first defined camera replaces the default scene
+// camera if the latter has not been configured.
+func (r *wavefrontSceneReader) defineCamera(name string) error {
+	cam := input.NewCamera(name)
+	if r.curCamera == nil {
+		r.rawScene.Camera = cam
+		r.rawScene.Cameras = []*input.Camera{cam}
+		r.curCamera = cam
+		return nil
+	}
+
+	if r.rawScene.FindCamera(name) != nil {
+		return fmt.Errorf("camera %q is already defined", name)
+	}
+
+	r.rawScene.Cameras = append(r.rawScene.Cameras, cam)
+	r.curCamera = cam
+	return nil
+}
+
 // Parse a Vec3 row.
 func parseVec3(lineTokens []string) (types.Vec3, error) {
 	if len(lineTokens) < 4 {
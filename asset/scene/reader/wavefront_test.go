@@ -1,14 +1,14 @@
 package reader
 
 import (
-	"image"
-	"image/png"
-	"net/http"
-	"net/http/httptest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/achilleasa/polaris/asset"
 	"github.com/achilleasa/polaris/types"
 )
 
@@ -96,7 +96,7 @@ func TestSelectFaceCoordinate(t *testing.T) {
 	}
 
 	for idx, s := range specs {
-		v, err := selectFaceCoordIndex(s.in, s.listLen)
+		v, err := selectFaceCoordIndex(s.in, s.listLen, 0)
 		if s.expError != "" && (err == nil || err.Error() != s.expError) {
 			t.Fatalf("[spec %d] expected error %s; got %v", idx, s.expError, err)
 		} else if v != s.out {
@@ -123,14 +123,14 @@ f 1/1/1 2/2/2 -1/-1/-1
 `
 
 	res := mockResource(payload)
-	r := newWavefrontReader()
+	r := newWavefrontReader(DefaultOptions, nil)
 	r.Read(res)
 
 	expMeshInstances := 1
-	if len(r.sceneGraph.MeshInstances) != expMeshInstances {
-		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.sceneGraph.MeshInstances))
+	if len(r.rawScene.MeshInstances) != expMeshInstances {
+		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.rawScene.MeshInstances))
 	}
-	inst0 := r.sceneGraph.MeshInstances[0]
+	inst0 := r.rawScene.MeshInstances[0]
 	if inst0.MeshIndex != 0 {
 		t.Fatalf("expected mesh instance to point to mesh at index 0; got %d", inst0.MeshIndex)
 	}
@@ -178,12 +178,12 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 `
 
 	res := mockResource(payload)
-	r := newWavefrontReader()
+	r := newWavefrontReader(DefaultOptions, nil)
 	r.Read(res)
 
 	expMeshInstances := 3
-	if len(r.sceneGraph.MeshInstances) != expMeshInstances {
-		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.sceneGraph.MeshInstances))
+	if len(r.rawScene.MeshInstances) != expMeshInstances {
+		t.Fatalf("expected %d mesh instances to be generated; got %d", expMeshInstances, len(r.rawScene.MeshInstances))
 	}
 
 	type spec struct {
@@ -198,7 +198,7 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 		{2, types.Vec3{0, 1, 0}, types.Vec3{0, 0, 20}},
 	}
 	for idx, s := range specs {
-		inst := r.sceneGraph.MeshInstances[s.instance]
+		inst := r.rawScene.MeshInstances[s.instance]
 		out := inst.Transform.Mul4x1(s.in.Vec4(1.0)).Vec3()
 		if !types.ApproxEqual(out, s.expOut, 1e-3) {
 			t.Fatalf("[spec %d] expected transformed point with instance %d matrix to be %v; got %v", idx, s.instance, s.expOut, out)
@@ -209,7 +209,7 @@ instance testObj 	0 1 0	90 0 0	10 10 10
 		[2]types.Vec3{types.Vec3{1, 0, 1}, types.Vec3{2, 1, 1}},
 	}
 	for meshIndex, expBBox := range expBBoxes {
-		bbox := r.sceneGraph.MeshInstances[meshIndex].BBox()
+		bbox := r.rawScene.MeshInstances[meshIndex].BBox()
 		if !types.ApproxEqual(bbox[0], expBBox[0], 1e-3) {
 			t.Fatalf("[mesh inst. %d] expected bbox min to be %v; got %v", meshIndex, expBBox[0], bbox[0])
 		}
@@ -237,18 +237,18 @@ f 1/1/1 2/2/2 -1/-1/-1
 `
 
 	res := mockResource(payload)
-	r := newWavefrontReader()
+	r := newWavefrontReader(DefaultOptions, nil)
 	err := r.parse(res)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	expMeshes := 1
-	if len(r.sceneGraph.Meshes) != expMeshes {
-		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.sceneGraph.Meshes))
+	if len(r.rawScene.Meshes) != expMeshes {
+		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.rawScene.Meshes))
 	}
 
-	mesh0 := r.sceneGraph.Meshes[0]
+	mesh0 := r.rawScene.Meshes[0]
 	expName := "testObj"
 	if mesh0.Name != expName {
 		t.Fatalf("expected mesh[0] name to be '%s'; got %s", expName, mesh0.Name)
@@ -260,8 +260,8 @@ f 1/1/1 2/2/2 -1/-1/-1
 	}
 
 	expMaterials := 1
-	if len(r.sceneGraph.Materials) != expMaterials {
-		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.sceneGraph.Materials))
+	if len(r.materials) != expMaterials {
+		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.materials))
 	}
 
 	expPoints := []types.Vec3{
@@ -325,18 +325,18 @@ f 1 2 3 4
 `
 
 	res := mockResource(payload)
-	r := newWavefrontReader()
+	r := newWavefrontReader(DefaultOptions, nil)
 	err := r.parse(res)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	expMeshes := 1
-	if len(r.sceneGraph.Meshes) != expMeshes {
-		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.sceneGraph.Meshes))
+	if len(r.rawScene.Meshes) != expMeshes {
+		t.Fatalf("expected %d meshes to be parsed; got %d", expMeshes, len(r.rawScene.Meshes))
 	}
 
-	mesh0 := r.sceneGraph.Meshes[0]
+	mesh0 := r.rawScene.Meshes[0]
 	expName := "testObj"
 	if mesh0.Name != expName {
 		t.Fatalf("expected mesh[0] name to be '%s'; got %s", expName, mesh0.Name)
@@ -348,8 +348,8 @@ f 1 2 3 4
 	}
 
 	expMaterials := 1
-	if len(r.sceneGraph.Materials) != expMaterials {
-		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.sceneGraph.Materials))
+	if len(r.materials) != expMaterials {
+		t.Fatalf("expected scene to contain %d material(s); got %d", expMaterials, len(r.materials))
 	}
 
 	expPoints := []types.Vec3{
@@ -412,7 +412,7 @@ f 1 2 3 4
 func TestMaterialLoaderMissingNewMaterialCommand(t *testing.T) {
 	payload := `Kd 1.0 1.0 1.0`
 	res := mockResource(payload)
-	err := newWavefrontReader().parseMaterials(res)
+	err := newWavefrontReader(DefaultOptions, nil).parseMaterials(res)
 
 	expError := `[embedded: 1] error: got "Kd" without a "newmtl"`
 	if err == nil || err.Error() != expError {
@@ -425,7 +425,7 @@ func TestMaterialLoaderInvalidVec3Param(t *testing.T) {
 	newmtl foo
 	Kd 1.0`
 	res := mockResource(payload)
-	err := newWavefrontReader().parseMaterials(res)
+	err := newWavefrontReader(DefaultOptions, nil).parseMaterials(res)
 
 	expError := `[embedded: 3] error: unsupported syntax for "Kd"; expected 3 arguments; got 1`
 	if err == nil || err.Error() != expError {
@@ -438,7 +438,7 @@ func TestMaterialLoaderInvalidScalarParam(t *testing.T) {
 	newmtl foo
 	Ni`
 	res := mockResource(payload)
-	err := newWavefrontReader().parseMaterials(res)
+	err := newWavefrontReader(DefaultOptions, nil).parseMaterials(res)
 
 	expError := `[embedded: 3] error: unsupported syntax for "Ni"; expected 1 argument; got 0`
 	if err == nil || err.Error() != expError {
@@ -456,18 +456,18 @@ func TestMaterialLoaderSuccess(t *testing.T) {
 	Ni 2.5
 	Nr 0`
 	res := mockResource(payload)
-	r := newWavefrontReader()
+	r := newWavefrontReader(DefaultOptions, nil)
 	err := r.parseMaterials(res)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	matLen := len(r.sceneGraph.Materials)
+	matLen := len(r.materials)
 	if matLen != 1 {
 		t.Fatalf("expected to parse 1 material; got %d", matLen)
 	}
 
-	mat := r.sceneGraph.Materials[0]
+	mat := r.materials[0]
 	if mat.Name != "foo" {
 		t.Fatalf("expected material name to be 'foo'; got %s", mat.Name)
 	}
@@ -488,89 +488,79 @@ func TestMaterialLoaderSuccess(t *testing.T) {
 	if mat.Ni != expScalar {
 		t.Fatalf("expected Ni to be %f; got %f", expScalar, mat.Ni)
 	}
-	expScalar = 0
-	if mat.Nr != expScalar {
-		t.Fatalf("expected Nr to be %f; got %f", expScalar, mat.Nr)
+	expUnsupported := []string{"Nr"}
+	if !reflect.DeepEqual(mat.Unsupported, expUnsupported) {
+		t.Fatalf("expected unsupported properties to be %v; got %v", expUnsupported, mat.Unsupported)
 	}
-
 }
 
 func TestMaterialLoaderWithTextures(t *testing.T) {
-	serverFn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		png.Encode(w, image.NewRGBA64(image.Rect(0, 0, 1, 1)))
-	})
-	server := httptest.NewServer(serverFn)
-	defer server.Close()
-
 	payload := `
 newmtl foo
-map_Kd SERVER/kd.png
-map_Ks SERVER/ks.png
-map_Ke SERVER/ke.png
-map_bump SERVER/bump.png
-map_Ni SERVER/ni.png
-map_Nr SERVER/nr.png
+map_Kd kd.png
+map_Ks ks.png
+map_Ke ke.png
+map_bump bump.png
+map_normal normal.png
+map_d d.png
 `
-	res := mockResource(strings.Replace(payload, "SERVER", server.URL, -1))
-	r := newWavefrontReader()
+	res := mockResource(payload)
+	r := newWavefrontReader(DefaultOptions, nil)
 	err := r.parseMaterials(res)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(r.sceneGraph.Materials) != 1 {
-		t.Fatalf("expected to parse 1 material; got %d", len(r.sceneGraph.Materials))
+	if len(r.materials) != 1 {
+		t.Fatalf("expected to parse 1 material; got %d", len(r.materials))
 	}
 
-	expTexCount := 6
-	if len(r.sceneGraph.Textures) != expTexCount {
-		t.Fatalf("expected to load %d textures; got %d", expTexCount, len(r.sceneGraph.Textures))
-	}
-
-	texIndices := 0
-	mat := r.sceneGraph.Materials[0]
-	if mat.KdTex != -1 {
-		texIndices++
-	}
-	if mat.KsTex != -1 {
-		texIndices++
+	mat := r.materials[0]
+	specs := map[string]string{
+		"kd.png":     mat.KdTex,
+		"ks.png":     mat.KsTex,
+		"ke.png":     mat.KeTex,
+		"bump.png":   mat.BumpTex,
+		"normal.png": mat.NormalTex,
+		"d.png":      mat.OpacityTex,
 	}
-	if mat.KeTex != -1 {
-		texIndices++
-	}
-	if mat.NiTex != -1 {
-		texIndices++
-	}
-	if mat.NrTex != -1 {
-		texIndices++
-	}
-	if mat.NormalTex != -1 {
-		texIndices++
-	}
-	if texIndices != expTexCount {
-		t.Fatalf("expected %d texture indices to be assigned to mat0; got %d", expTexCount, texIndices)
+	for exp, texPath := range specs {
+		if texPath != exp {
+			t.Fatalf("expected texture path to be %q; got %q", exp, texPath)
+		}
 	}
 }
 
 func TestMaterialLoaderWithMissingTextures(t *testing.T) {
-	payload := `
+	sceneFile, cleanup := writeTempScene(t, `
+mtllib scene.mtl
+v 0 0 0
+v 1 0 0
+v 0 1 0
+usemtl foo
+f 1 2 3
+`)
+	defer cleanup()
+
+	err := ioutil.WriteFile(filepath.Join(filepath.Dir(sceneFile), "scene.mtl"), []byte(`
 newmtl foo
 map_Kd invalid.png
-`
-	res := mockResource(payload)
-	r := newWavefrontReader()
-	err := r.parseMaterials(res)
+`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc, warnings, err := ReadSceneWithOptions(sceneFile, DefaultOptions)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(r.sceneGraph.Textures) != 0 {
-		t.Fatalf("expected texture list to be empty; got %d items", len(r.sceneGraph.Textures))
+	if len(sc.TextureMetadata) != 0 {
+		t.Fatalf("expected texture list to be empty; got %d items", len(sc.TextureMetadata))
 	}
 
-	mat := r.sceneGraph.Materials[0]
-	if mat.KdTex != -1 {
-		t.Fatalf("expected kdTex to be -1 for missing texture; got %d", mat.KdTex)
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Message, `skipping missing texture "invalid.png"`) {
+		t.Fatalf("expected a warning about the missing texture; got %v", warnings)
 	}
 }
 
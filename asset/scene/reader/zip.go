@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/achilleasa/polaris/asset"
+	"github.com/achilleasa/polaris/asset/compiler"
 	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/log"
 )
@@ -19,12 +20,20 @@ const (
 
 type zipSceneReader struct {
 	logger log.Logger
+
+	// Limits for protecting against malformed input files.
+	limits Limits
+
+	// Collects progress and non-fatal issues; may be nil.
+	report *compiler.Report
 }
 
 // Create a new zip scene writer
-func newZipSceneReader() *zipSceneReader {
+func newZipSceneReader(opts Options, report *compiler.Report) *zipSceneReader {
 	return &zipSceneReader{
 		logger: log.New("zip reader"),
+		limits: opts.Limits,
+		report: report,
 	}
 }
 
@@ -36,7 +45,7 @@ func (p *zipSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, error) {
 	// zip package requires a reader implementing ReaderAt. To work around
 	// this requirement we read the entire zip file into memory and create
 	// a reader from the bytes package that implements ReaderAt
-	data, err := ioutil.ReadAll(sceneRes)
+	data, err := ioutil.ReadAll(p.limits.limitReader(sceneRes))
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +55,9 @@ func (p *zipSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, error) {
 	}
 
 	sc := &scene.Scene{}
-	for _, f := range zr.File {
+	for fIndex, f := range zr.File {
+		p.report.Progress(compiler.SectionParse, fIndex, len(zr.File))
+
 		switch f.Name {
 		case dataFile:
 		default:
@@ -58,7 +69,7 @@ func (p *zipSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, error) {
 		if err != nil {
 			return nil, err
 		}
-		decoder := gob.NewDecoder(rc)
+		decoder := gob.NewDecoder(p.limits.limitReader(rc))
 		err = decoder.Decode(&sc)
 		rc.Close()
 		if err != nil {
@@ -66,6 +77,16 @@ func (p *zipSceneReader) Read(sceneRes *asset.Resource) (*scene.Scene, error) {
 		}
 	}
 
+	if sc.LayoutVersion != scene.LayoutVersion {
+		return nil, fmt.Errorf("zipSceneReader: scene was compiled using data layout version %d; expected version %d. Please recompile the scene", sc.LayoutVersion, scene.LayoutVersion)
+	}
+
+	p.report.Progress(compiler.SectionParse, len(zr.File), len(zr.File))
+
+	if sc.Camera == nil {
+		return nil, fmt.Errorf("zipSceneReader: scene does not define a camera")
+	}
+
 	p.logger.Noticef("loaded scene in %d ms", time.Since(start).Nanoseconds()/1000000)
 	return sc, nil
 }
@@ -0,0 +1,65 @@
+package scene
+
+import "github.com/achilleasa/polaris/types"
+
+// A CameraSnapshot captures the user-adjustable camera state so that it can
+// be persisted and restored later.
+type CameraSnapshot struct {
+	Position types.Vec3 `json:"position"`
+	LookAt   types.Vec3 `json:"look_at"`
+	Up       types.Vec3 `json:"up"`
+	FOV      float32    `json:"fov"`
+
+	ApertureRadius float32 `json:"aperture_radius,omitempty"`
+	FocusDistance  float32 `json:"focus_distance,omitempty"`
+
+	ShiftX float32 `json:"shift_x,omitempty"`
+	ShiftY float32 `json:"shift_y,omitempty"`
+	TiltX  float32 `json:"tilt_x,omitempty"`
+	TiltY  float32 `json:"tilt_y,omitempty"`
+}
+
+// Capture the current camera state.
+func (c *Camera) Snapshot() CameraSnapshot {
+	return CameraSnapshot{
+		Position:       c.Position,
+		LookAt:         c.LookAt,
+		Up:             c.Up,
+		FOV:            c.FOV,
+		ApertureRadius: c.ApertureRadius,
+		FocusDistance:  c.FocusDistance,
+		ShiftX:         c.ShiftX,
+		ShiftY:         c.ShiftY,
+		TiltX:          c.TiltX,
+		TiltY:          c.TiltY,
+	}
+}
+
+// Restore a camera state captured by Snapshot. If the snapshot FOV differs
+// from the camera FOV, the projection matrix is rebuilt using the aspect ratio
+// of the current projection.
+func (c *Camera) Restore(s CameraSnapshot) {
+	c.Position = s.Position
+	c.LookAt = s.LookAt
+	c.Up = s.Up
+	c.ApertureRadius = s.ApertureRadius
+	c.FocusDistance = s.FocusDistance
+	c.ShiftX, c.ShiftY = s.ShiftX, s.ShiftY
+	c.TiltX, c.TiltY = s.TiltX, s.TiltY
+
+	// Snapshots store the final orientation so any pending pitch/yaw
+	// deltas must not be re-applied.
+	c.Pitch, c.Yaw = 0, 0
+
+	if s.FOV != c.FOV {
+		c.FOV = s.FOV
+		aspect := float32(1.0)
+		if c.ProjMat[0] != 0 {
+			aspect = c.ProjMat[5] / c.ProjMat[0]
+		}
+		c.SetupProjection(aspect)
+		return
+	}
+
+	c.Update()
+}
@@ -0,0 +1,170 @@
+package testscenes
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Geometry of the scenes with analytic solutions.
+var (
+	// The sphere used by the sky sphere scene. The sphere floats above a
+	// black ground plane at y = 0.
+	SkySphereCenter         = types.XYZ(0, 1.5, 0)
+	SkySphereRadius float32 = 1
+
+	// The area light used by the area lit floor scene. The light is a
+	// square parallel to the floor (y = 0) facing downwards.
+	AreaLightCenter           = types.XYZ(0, 2, 0)
+	AreaLightHalfSize float32 = 1
+
+	// The box used by the medium scenes. The box is centered at the
+	// origin and its boundary does not refract light.
+	MediumBoxHalfSize float32 = 1
+)
+
+// The half size of the ground planes used by the analytic scenes. The planes
+// are large enough to be treated as infinite.
+const groundHalfSize = 1000
+
+// Get the expected radiance for the sphere of the white furnace scene. As the
+// sphere is convex, each path scatters exactly once before escaping to the
+// uniform background.
+func FurnaceSphereRadiance(albedo float32) float32 {
+	return albedo * FurnaceBackground
+}
+
+// Generate a scene with a diffuse sphere with the given albedo floating above
+// a black ground plane under a uniform sky with the given radiance. The sky
+// radiance must be in the [0, 1) range.
+func SkySphere(albedo, skyRadiance float32) *input.Scene {
+	b := newBuilder()
+	b.material(compiler.SceneDiffuseMaterialName, fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(skyRadiance, skyRadiance, skyRadiance)))
+	mat := b.material("sphere", fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(albedo, albedo, albedo)))
+	ground := b.material("ground", "diffuse(reflectance: {0, 0, 0})")
+
+	b.sphere(b.mesh("sphere"), mat, SkySphereCenter, SkySphereRadius, sphereSegments, sphereRings)
+	b.quad(b.mesh("ground"), ground, groundQuad(), types.XYZ(0, 1, 0))
+
+	b.camera(types.XYZ(0, 2, 5), SkySphereCenter, 45)
+	return b.build()
+}
+
+// Get the expected radiance for a point on the sphere of the sky sphere scene
+// with the given surface normal. As the sphere is convex and the ground is
+// black and infinite, the sphere receives light from the part of the sky that
+// lies above both the horizon and the tangent plane at the point. The
+// irradiance from a uniform sky on a plane whose normal forms an angle theta
+// with the up vector is pi * L * (1 + cos(theta)) / 2.
+func SkySphereRadiance(albedo, skyRadiance float32, normal types.Vec3) float32 {
+	cosTheta := normal.Normalize()[1]
+	return albedo * skyRadiance * 0.5 * (1 + cosTheta)
+}
+
+// Generate a scene with a diffuse floor with the given albedo lit by a square
+// area light with the given radiance. The scene does not define a background
+// so the floor only receives light from the area light.
+func AreaLitFloor(albedo, lightRadiance float32) *input.Scene {
+	b := newBuilder()
+	floor := b.material("floor", fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(albedo, albedo, albedo)))
+	light := b.material("light", fmt.Sprintf("emissive(radiance: %v)", types.XYZ(lightRadiance, lightRadiance, lightRadiance)))
+
+	b.quad(b.mesh("floor"), floor, groundQuad(), types.XYZ(0, 1, 0))
+
+	c, h := AreaLightCenter, AreaLightHalfSize
+	b.quad(b.mesh("light"), light, [4]types.Vec3{
+		{c[0] - h, c[1], c[2] - h}, {c[0] + h, c[1], c[2] - h},
+		{c[0] + h, c[1], c[2] + h}, {c[0] - h, c[1], c[2] + h},
+	}, types.XYZ(0, -1, 0))
+
+	b.camera(types.XYZ(0, 1, 4), types.XYZ(0, 0, 0), 45)
+	return b.build()
+}
+
+// Get the expected radiance for a point on the floor of the area lit floor
+// scene. The floor radiance equals albedo * L * F where F is the form factor
+// between a differential floor area at the point and the area light.
+func AreaLitFloorRadiance(albedo, lightRadiance float32, point types.Vec3) float32 {
+	c, h := AreaLightCenter, AreaLightHalfSize
+	dist := float64(c[1] - point[1])
+	x0, x1 := float64(c[0]-h-point[0]), float64(c[0]+h-point[0])
+	z0, z1 := float64(c[2]-h-point[2]), float64(c[2]+h-point[2])
+
+	// Decompose the light into rectangles with a corner above the point
+	f := cornerFormFactor(x1, z1, dist) - cornerFormFactor(x0, z1, dist) - cornerFormFactor(x1, z0, dist) + cornerFormFactor(x0, z0, dist)
+	return albedo * lightRadiance * float32(f)
+}
+
+// Calculate the form factor between a differential area and a parallel
+// rectangle at distance dist with one corner directly above the differential
+// area and the opposite corner at offset (x, z). The result is signed based
+// on the quadrant of (x, z) so that arbitrary rectangles can be handled by
+// adding and subtracting corner rectangles.
+func cornerFormFactor(x, z, dist float64) float64 {
+	sign := math.Copysign(1, x) * math.Copysign(1, z)
+	a, b := math.Abs(x)/dist, math.Abs(z)/dist
+	sa, sb := math.Sqrt(1+a*a), math.Sqrt(1+b*b)
+	return sign * (a/sa*math.Atan(b/sa) + b/sb*math.Atan(a/sb)) / (2 * math.Pi)
+}
+
+// Generate a scene with a box filled with a purely absorbing medium with the
+// given absorption coefficient surrounded by a uniform background with
+// radiance equal to FurnaceBackground. The box boundary is an index-matched
+// dielectric so rays pass through it without changing direction.
+func AbsorbingBox(absorption float32) *input.Scene {
+	return mediumBox(fmt.Sprintf("absorption: %v", types.XYZ(absorption, absorption, absorption)))
+}
+
+// Get the expected radiance for a camera ray of the absorbing box scene. The
+// background radiance is attenuated by the Beer-Lambert law along the part of
+// the ray that lies inside the box.
+func AbsorbingBoxRadiance(absorption float32, origin, dir types.Vec3) float32 {
+	tMin, tMax := math.Inf(-1), math.Inf(1)
+	for axis := 0; axis < 3; axis++ {
+		if dir[axis] == 0 {
+			if math.Abs(float64(origin[axis])) > float64(MediumBoxHalfSize) {
+				return FurnaceBackground
+			}
+			continue
+		}
+		t0 := float64((-MediumBoxHalfSize - origin[axis]) / dir[axis])
+		t1 := float64((MediumBoxHalfSize - origin[axis]) / dir[axis])
+		tMin, tMax = math.Max(tMin, math.Min(t0, t1)), math.Min(tMax, math.Max(t0, t1))
+	}
+
+	dist := math.Max(0, tMax-math.Max(0, tMin))
+	return FurnaceBackground * float32(math.Exp(-float64(absorption)*dist))
+}
+
+// Generate a scene with a box filled with a non-absorbing medium with the
+// given scattering coefficient and phase function anisotropy surrounded by a
+// uniform background with radiance equal to FurnaceBackground. As the medium
+// does not absorb any light, a correct integrator renders the box with
+// radiance equal to the background radiance.
+func ScatteringBox(scattering, anisotropy float32) *input.Scene {
+	return mediumBox(fmt.Sprintf("scattering: %v, anisotropy: %v", types.XYZ(scattering, scattering, scattering), anisotropy))
+}
+
+// Generate a scene with a medium box using the supplied medium parameters.
+func mediumBox(mediumParams string) *input.Scene {
+	b := newBuilder()
+	b.material(compiler.SceneDiffuseMaterialName, fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(FurnaceBackground, FurnaceBackground, FurnaceBackground)))
+	mat := b.material("box", fmt.Sprintf("medium(dielectric(intIOR: 1, extIOR: 1), %s)", mediumParams))
+
+	h := MediumBoxHalfSize
+	b.box(b.mesh("box"), mat, types.XYZ(0, 0, 0), types.XYZ(h, h, h), 0)
+
+	b.camera(types.XYZ(0, 0, 4), types.XYZ(0, 0, 0), 45)
+	return b.build()
+}
+
+// Get the vertices of a ground plane quad at y = 0.
+func groundQuad() [4]types.Vec3 {
+	return [4]types.Vec3{
+		{-groundHalfSize, 0, -groundHalfSize}, {groundHalfSize, 0, -groundHalfSize},
+		{groundHalfSize, 0, groundHalfSize}, {-groundHalfSize, 0, groundHalfSize},
+	}
+}
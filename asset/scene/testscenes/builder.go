@@ -0,0 +1,166 @@
+package testscenes
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A builder assembles raw scenes from simple geometric shapes.
+type builder struct {
+	sc *input.Scene
+
+	// A map of material names to their index in the scene material list.
+	matNameToIndex map[string]int
+}
+
+// Create a new scene builder.
+func newBuilder() *builder {
+	return &builder{
+		sc:             input.NewScene(),
+		matNameToIndex: make(map[string]int),
+	}
+}
+
+// Define a material using a material expression and return its index. If a
+// material with the same name already exists, its index is returned instead.
+func (b *builder) material(name, expr string) int {
+	if index, exists := b.matNameToIndex[name]; exists {
+		return index
+	}
+
+	b.sc.Materials = append(b.sc.Materials, &input.Material{
+		Name:       name,
+		Expression: expr,
+		Used:       true,
+	})
+	b.matNameToIndex[name] = len(b.sc.Materials) - 1
+	return len(b.sc.Materials) - 1
+}
+
+// Create a new named mesh.
+func (b *builder) mesh(name string) *input.Mesh {
+	mesh := input.NewMesh(name)
+	b.sc.Meshes = append(b.sc.Meshes, mesh)
+	return mesh
+}
+
+// Set the scene camera.
+func (b *builder) camera(eye, look types.Vec3, fov float32) {
+	b.sc.Camera.Eye = eye
+	b.sc.Camera.Look = look
+	b.sc.Camera.FOV = fov
+}
+
+// Append a triangle with per-vertex normals to a mesh.
+func (b *builder) triangle(mesh *input.Mesh, matIndex int, verts [3]types.Vec3, normals [3]types.Vec3, uvs [3]types.Vec2) {
+	prim := &input.Primitive{
+		Vertices:      verts,
+		Normals:       normals,
+		UVs:           uvs,
+		MaterialIndex: matIndex,
+	}
+	prim.SetBBox(
+		[2]types.Vec3{
+			types.MinVec3(verts[0], types.MinVec3(verts[1], verts[2])),
+			types.MaxVec3(verts[0], types.MaxVec3(verts[1], verts[2])),
+		},
+	)
+	prim.SetCenter(verts[0].Add(verts[1]).Add(verts[2]).Mul(1.0 / 3.0))
+
+	mesh.Primitives = append(mesh.Primitives, prim)
+	mesh.MarkBBoxDirty()
+}
+
+// Append a planar quad to a mesh. The quad vertices must be specified in
+// either clockwise or counter-clockwise order; their winding is adjusted so
+// that the quad normal points towards the facing direction.
+func (b *builder) quad(mesh *input.Mesh, matIndex int, verts [4]types.Vec3, facing types.Vec3) {
+	normal := verts[1].Sub(verts[0]).Cross(verts[2].Sub(verts[0])).Normalize()
+	if normal.Dot(facing) < 0 {
+		verts[1], verts[3] = verts[3], verts[1]
+		normal = normal.Mul(-1)
+	}
+
+	normals := [3]types.Vec3{normal, normal, normal}
+	b.triangle(mesh, matIndex, [3]types.Vec3{verts[0], verts[1], verts[2]}, normals, [3]types.Vec2{{0, 0}, {1, 0}, {1, 1}})
+	b.triangle(mesh, matIndex, [3]types.Vec3{verts[0], verts[2], verts[3]}, normals, [3]types.Vec2{{0, 0}, {1, 1}, {0, 1}})
+}
+
+// Append a box to a mesh. The box is rotated around the Y axis by the given
+// angle (in radians) before being translated to its center.
+func (b *builder) box(mesh *input.Mesh, matIndex int, center, halfExtents types.Vec3, angleY float32) {
+	sin, cos := float32(math.Sin(float64(angleY))), float32(math.Cos(float64(angleY)))
+	rotate := func(v types.Vec3) types.Vec3 {
+		return types.XYZ(cos*v[0]+sin*v[2], v[1], -sin*v[0]+cos*v[2])
+	}
+
+	// Generate two faces for each axis
+	for axis := 0; axis < 3; axis++ {
+		u, v := (axis+1)%3, (axis+2)%3
+		for _, sign := range []float32{-1, 1} {
+			var verts [4]types.Vec3
+			for index, uv := range [4][2]float32{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+				var corner types.Vec3
+				corner[axis] = sign * halfExtents[axis]
+				corner[u] = uv[0] * halfExtents[u]
+				corner[v] = uv[1] * halfExtents[v]
+				verts[index] = center.Add(rotate(corner))
+			}
+
+			var facing types.Vec3
+			facing[axis] = sign
+			b.quad(mesh, matIndex, verts, rotate(facing))
+		}
+	}
+}
+
+// Append a UV sphere with smooth normals to a mesh.
+func (b *builder) sphere(mesh *input.Mesh, matIndex int, center types.Vec3, radius float32, segments, rings int) {
+	point := func(segment, ring int) (types.Vec3, types.Vec3, types.Vec2) {
+		theta := math.Pi * float64(ring) / float64(rings)
+		phi := 2.0 * math.Pi * float64(segment) / float64(segments)
+		normal := types.XYZ(
+			float32(math.Sin(theta)*math.Cos(phi)),
+			float32(math.Cos(theta)),
+			float32(math.Sin(theta)*math.Sin(phi)),
+		)
+		uv := types.Vec2{float32(segment) / float32(segments), float32(ring) / float32(rings)}
+		return center.Add(normal.Mul(radius)), normal, uv
+	}
+
+	for ring := 0; ring < rings; ring++ {
+		for segment := 0; segment < segments; segment++ {
+			v0, n0, uv0 := point(segment, ring)
+			v1, n1, uv1 := point(segment, ring+1)
+			v2, n2, uv2 := point(segment+1, ring+1)
+			v3, n3, uv3 := point(segment+1, ring)
+
+			// Skip the degenerate triangles at the poles
+			if ring != 0 {
+				b.triangle(mesh, matIndex, [3]types.Vec3{v0, v2, v3}, [3]types.Vec3{n0, n2, n3}, [3]types.Vec2{uv0, uv2, uv3})
+			}
+			if ring != rings-1 {
+				b.triangle(mesh, matIndex, [3]types.Vec3{v0, v1, v2}, [3]types.Vec3{n0, n1, n2}, [3]types.Vec2{uv0, uv1, uv2})
+			}
+		}
+	}
+}
+
+// Generate a mesh instance with an identity transformation for each defined
+// mesh and return the assembled scene.
+func (b *builder) build() *input.Scene {
+	for meshIndex, mesh := range b.sc.Meshes {
+		bbox := mesh.BBox()
+		inst := &input.MeshInstance{
+			MeshIndex: uint32(meshIndex),
+			Transform: types.Ident4(),
+		}
+		inst.SetBBox(bbox)
+		inst.SetCenter(bbox[0].Add(bbox[1]).Mul(0.5))
+		b.sc.MeshInstances = append(b.sc.MeshInstances, inst)
+	}
+
+	return b.sc
+}
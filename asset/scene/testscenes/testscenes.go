@@ -0,0 +1,147 @@
+// Package testscenes generates canonical test scenes programmatically so that
+// tests, benchmarks and examples do not depend on external asset files.
+//
+// Each generator returns a raw scene that can be compiled into an optimized
+// scene using Compile or the asset/compiler package.
+package testscenes
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	// The background radiance used by the furnace scene. Background
+	// materials use diffuse reflectance values which must be < 1.
+	FurnaceBackground float32 = 0.999
+
+	// The default material expression used by the material ball scene.
+	DefaultBallMaterial = "diffuse(reflectance: {0.8, 0.8, 0.8})"
+
+	// Tessellation used for generated spheres.
+	sphereSegments = 48
+	sphereRings    = 24
+)
+
+// Compile a generated scene into an optimized scene.
+func Compile(sc *input.Scene) (*scene.Scene, error) {
+	return compiler.Compile(sc)
+}
+
+// Generate a Cornell box scene. The box spans the [-1, 1] range on all axes
+// and is lit by an area light attached to its ceiling. The front side of the
+// box faces the camera and is left open.
+func CornellBox() *input.Scene {
+	b := newBuilder()
+	white := b.material("white", "diffuse(reflectance: {0.73, 0.73, 0.73})")
+	red := b.material("red", "diffuse(reflectance: {0.65, 0.05, 0.05})")
+	green := b.material("green", "diffuse(reflectance: {0.12, 0.45, 0.15})")
+	light := b.material("light", "emissive(radiance: {17, 12, 4})")
+
+	walls := b.mesh("walls")
+	b.quad(walls, white, [4]types.Vec3{{-1, -1, -1}, {1, -1, -1}, {1, -1, 1}, {-1, -1, 1}}, types.XYZ(0, 1, 0))
+	b.quad(walls, white, [4]types.Vec3{{-1, 1, -1}, {1, 1, -1}, {1, 1, 1}, {-1, 1, 1}}, types.XYZ(0, -1, 0))
+	b.quad(walls, white, [4]types.Vec3{{-1, -1, -1}, {1, -1, -1}, {1, 1, -1}, {-1, 1, -1}}, types.XYZ(0, 0, 1))
+	b.quad(walls, red, [4]types.Vec3{{-1, -1, -1}, {-1, -1, 1}, {-1, 1, 1}, {-1, 1, -1}}, types.XYZ(1, 0, 0))
+	b.quad(walls, green, [4]types.Vec3{{1, -1, -1}, {1, -1, 1}, {1, 1, 1}, {1, 1, -1}}, types.XYZ(-1, 0, 0))
+
+	lightMesh := b.mesh("light")
+	b.quad(lightMesh, light, [4]types.Vec3{{-0.25, 0.99, -0.25}, {0.25, 0.99, -0.25}, {0.25, 0.99, 0.25}, {-0.25, 0.99, 0.25}}, types.XYZ(0, -1, 0))
+
+	b.box(b.mesh("tall box"), white, types.XYZ(-0.35, -0.4, -0.3), types.XYZ(0.3, 0.6, 0.3), 0.3)
+	b.box(b.mesh("short box"), white, types.XYZ(0.35, -0.7, 0.3), types.XYZ(0.3, 0.3, 0.3), -0.3)
+
+	b.camera(types.XYZ(0, 0, 3.4), types.XYZ(0, 0, 0), 45)
+	return b.build()
+}
+
+// Generate a white furnace scene: a diffuse sphere with the given albedo
+// surrounded by a uniform background with radiance equal to FurnaceBackground.
+// The albedo must be in the [0, 1) range. A correct integrator renders the
+// sphere with radiance equal to the background radiance when the albedo
+// approaches 1, making the sphere indistinguishable from the background.
+func FurnaceSphere(albedo float32) *input.Scene {
+	b := newBuilder()
+	b.material(compiler.SceneDiffuseMaterialName, fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(FurnaceBackground, FurnaceBackground, FurnaceBackground)))
+	mat := b.material("sphere", fmt.Sprintf("diffuse(reflectance: %v)", types.XYZ(albedo, albedo, albedo)))
+
+	b.sphere(b.mesh("sphere"), mat, types.XYZ(0, 0, 0), 1, sphereSegments, sphereRings)
+
+	b.camera(types.XYZ(0, 0, 4), types.XYZ(0, 0, 0), 45)
+	return b.build()
+}
+
+// Generate a material preview scene consisting of a sphere using the given
+// material expression placed on top of a checkered floor and lit by an area
+// light. If expr is empty, DefaultBallMaterial is used.
+func MaterialBall(expr string) *input.Scene {
+	if expr == "" {
+		expr = DefaultBallMaterial
+	}
+
+	b := newBuilder()
+	ball := b.material("ball", expr)
+	light := b.material("light", "emissive(radiance: {10, 10, 10})")
+	dark := b.material("floor dark", "diffuse(reflectance: {0.2, 0.2, 0.2})")
+	bright := b.material("floor bright", "diffuse(reflectance: {0.7, 0.7, 0.7})")
+
+	// Generate a checkered floor using 1x1 tiles
+	floor := b.mesh("floor")
+	for z := -4; z < 4; z++ {
+		for x := -4; x < 4; x++ {
+			mat := dark
+			if (x+z)&1 == 0 {
+				mat = bright
+			}
+
+			fx, fz := float32(x), float32(z)
+			b.quad(floor, mat, [4]types.Vec3{{fx, 0, fz}, {fx + 1, 0, fz}, {fx + 1, 0, fz + 1}, {fx, 0, fz + 1}}, types.XYZ(0, 1, 0))
+		}
+	}
+
+	b.sphere(b.mesh("ball"), ball, types.XYZ(0, 1, 0), 1, sphereSegments, sphereRings)
+	b.quad(b.mesh("light"), light, [4]types.Vec3{{-1, 4, -1}, {1, 4, -1}, {1, 4, 1}, {-1, 4, 1}}, types.XYZ(0, -1, 0))
+
+	b.camera(types.XYZ(0, 2, 5), types.XYZ(0, 0.8, 0), 45)
+	return b.build()
+}
+
+// Generate a scene with a ground plane lit by numLights small area lights
+// arranged in a grid above it. Each light is assigned a different color so
+// that the contribution of individual lights can be told apart.
+func ManyLights(numLights int) *input.Scene {
+	if numLights < 1 {
+		numLights = 1
+	}
+
+	b := newBuilder()
+	floorMat := b.material("floor", "diffuse(reflectance: {0.7, 0.7, 0.7})")
+	b.quad(b.mesh("floor"), floorMat, [4]types.Vec3{{-5, 0, -5}, {5, 0, -5}, {5, 0, 5}, {-5, 0, 5}}, types.XYZ(0, 1, 0))
+
+	gridSize := int(math.Ceil(math.Sqrt(float64(numLights))))
+	spacing := 8.0 / float32(gridSize)
+	lights := b.mesh("lights")
+	for index := 0; index < numLights; index++ {
+		// Pick a hue for each light and convert it to RGB
+		hue := float64(index) / float64(numLights)
+		color := types.XYZ(
+			float32(0.5+0.5*math.Cos(2*math.Pi*hue)),
+			float32(0.5+0.5*math.Cos(2*math.Pi*(hue-1.0/3.0))),
+			float32(0.5+0.5*math.Cos(2*math.Pi*(hue-2.0/3.0))),
+		).Mul(20)
+		mat := b.material(fmt.Sprintf("light %d", index), fmt.Sprintf("emissive(radiance: %v)", color))
+
+		cx := -4 + spacing*(float32(index%gridSize)+0.5)
+		cz := -4 + spacing*(float32(index/gridSize)+0.5)
+		h := spacing * 0.1
+		b.quad(lights, mat, [4]types.Vec3{{cx - h, 2, cz - h}, {cx + h, 2, cz - h}, {cx + h, 2, cz + h}, {cx - h, 2, cz + h}}, types.XYZ(0, -1, 0))
+	}
+
+	b.camera(types.XYZ(0, 6, 9), types.XYZ(0, 0, 0), 45)
+	return b.build()
+}
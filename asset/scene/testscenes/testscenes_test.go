@@ -0,0 +1,451 @@
+package testscenes
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestCompileScenes(t *testing.T) {
+	specs := []struct {
+		name         string
+		scene        *input.Scene
+		expEmissives int
+	}{
+		{"cornell box", CornellBox(), 2},
+		{"furnace sphere", FurnaceSphere(0.8), 0},
+		{"material ball", MaterialBall(""), 2},
+		{"many lights", ManyLights(10), 20},
+		{"sky sphere", SkySphere(0.5, 0.8), 0},
+		{"area lit floor", AreaLitFloor(0.5, 4), 2},
+		{"absorbing box", AbsorbingBox(0.5), 0},
+		{"scattering box", ScatteringBox(1, 0.3), 0},
+	}
+
+	for _, spec := range specs {
+		if len(spec.scene.MeshInstances) != len(spec.scene.Meshes) {
+			t.Errorf("[%s] expected %d mesh instances; got %d", spec.name, len(spec.scene.Meshes), len(spec.scene.MeshInstances))
+		}
+
+		sc, err := Compile(spec.scene)
+		if err != nil {
+			t.Errorf("[%s] compilation failed: %v", spec.name, err)
+			continue
+		}
+
+		if got := len(sc.EmissivePrimitives); got != spec.expEmissives {
+			t.Errorf("[%s] expected %d emissive primitives; got %d", spec.name, spec.expEmissives, got)
+		}
+
+		if sc.Camera == nil {
+			t.Errorf("[%s] expected compiled scene to define a camera", spec.name)
+		}
+	}
+}
+
+func TestFurnaceSceneBackground(t *testing.T) {
+	sc, err := Compile(FurnaceSphere(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sc.SceneDiffuseMatIndex == -1 {
+		t.Fatal("expected furnace scene to define a background material")
+	}
+}
+
+func TestQuadWinding(t *testing.T) {
+	b := newBuilder()
+	mesh := b.mesh("quad")
+	facing := types.XYZ(0, -1, 0)
+
+	// Vertices are specified in counter-clockwise order when viewed from
+	// above so the builder needs to flip them.
+	b.quad(mesh, 0, [4]types.Vec3{{0, 0, 0}, {0, 0, 1}, {1, 0, 1}, {1, 0, 0}}, facing)
+
+	for index, prim := range mesh.Primitives {
+		faceNormal := prim.Vertices[1].Sub(prim.Vertices[0]).Cross(prim.Vertices[2].Sub(prim.Vertices[0]))
+		if faceNormal.Dot(facing) <= 0 {
+			t.Errorf("[prim %d] expected winding to produce a face normal pointing towards %v; got %v", index, facing, faceNormal)
+		}
+		for vIndex, normal := range prim.Normals {
+			if normal != facing {
+				t.Errorf("[prim %d] expected vertex %d normal to be %v; got %v", index, vIndex, facing, normal)
+			}
+		}
+	}
+}
+
+func TestGeneratedTangents(t *testing.T) {
+	b := newBuilder()
+	mat := b.material("diffuse", "diffuse(reflectance: {0.5, 0.5, 0.5})")
+	up := types.XYZ(0, 0, 1)
+	normals := [3]types.Vec3{up, up, up}
+
+	// Each triangle is placed in its own mesh at a different z offset
+	specs := []struct {
+		uvs [3]types.Vec2
+		exp types.Vec4
+	}{
+		{[3]types.Vec2{{0, 0}, {1, 0}, {0, 1}}, types.XYZW(1, 0, 0, 1)},
+		// Mirrored v coordinate
+		{[3]types.Vec2{{0, 0}, {1, 0}, {0, -1}}, types.XYZW(1, 0, 0, -1)},
+		// Rotated uv coords
+		{[3]types.Vec2{{0, 0}, {0, 1}, {-1, 0}}, types.XYZW(0, -1, 0, 1)},
+		// Degenerate uv coords
+		{[3]types.Vec2{{0, 0}, {0, 0}, {0, 0}}, types.Vec4{}},
+	}
+	for index, spec := range specs {
+		z := float32(index)
+		b.triangle(b.mesh(fmt.Sprintf("tri%d", index)), mat, [3]types.Vec3{{0, 0, z}, {1, 0, z}, {0, 1, z}}, normals, spec.uvs)
+	}
+	b.camera(types.XYZ(0, 0, 10), types.XYZ(0, 0, 0), 45)
+
+	sc, err := Compile(b.build())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.TangentList) != len(sc.VertexList) {
+		t.Fatalf("expected %d tangents; got %d", len(sc.VertexList), len(sc.TangentList))
+	}
+
+	for vertex, tangent := range sc.TangentList {
+		index := int(sc.VertexList[vertex][2])
+		if exp := specs[index].exp; tangent.Sub(exp).Len() > 1e-5 {
+			t.Errorf("[tri %d] expected tangent %v; got %v", index, exp, tangent)
+		}
+	}
+}
+
+func TestMissingMaterialPolicies(t *testing.T) {
+	// Build a scene with a triangle that uses a material (z = 0), a
+	// triangle without a material (z = 1) and a triangle that references
+	// an undefined material (z = 2).
+	buildScene := func(withSceneDefault bool) *input.Scene {
+		b := newBuilder()
+		mat := b.material("diffuse", "diffuse(reflectance: {0.5, 0.5, 0.5})")
+		if withSceneDefault {
+			b.material(compiler.SceneDefaultMaterialName, "diffuse(reflectance: {0.1, 0.2, 0.3})")
+		}
+		up := types.XYZ(0, 0, 1)
+		for index, matIndex := range []int{mat, input.NoMaterial, 42} {
+			z := float32(index)
+			b.triangle(b.mesh(fmt.Sprintf("tri%d", index)), matIndex, [3]types.Vec3{{0, 0, z}, {1, 0, z}, {0, 1, z}}, [3]types.Vec3{up, up, up}, [3]types.Vec2{})
+		}
+		b.camera(types.XYZ(0, 0, 10), types.XYZ(0, 0, 0), 45)
+		return b.build()
+	}
+
+	specs := []struct {
+		name             string
+		policy           compiler.MissingMaterialPolicy
+		withSceneDefault bool
+		exp              types.Vec4
+	}{
+		{"default", compiler.UseSceneDefaultMaterial, false, types.XYZW(0.7, 0.7, 0.7, 0)},
+		{"scene default", compiler.UseSceneDefaultMaterial, true, types.XYZW(0.1, 0.2, 0.3, 0)},
+		{"debug", compiler.UseDebugMaterial, true, types.XYZW(1, 0, 1, 0)},
+	}
+	for _, spec := range specs {
+		sc, err := compiler.CompileWithOptions(buildScene(spec.withSceneDefault), compiler.Options{MissingMaterialPolicy: spec.policy})
+		if err != nil {
+			t.Errorf("[%s] compilation failed: %v", spec.name, err)
+			continue
+		}
+
+		for prim, matNodeIndex := range sc.MaterialIndex {
+			exp := spec.exp
+			if sc.VertexList[prim*3][2] == 0 {
+				exp = types.XYZW(0.5, 0.5, 0.5, 0)
+			}
+			if got := sc.MaterialNodeList[matNodeIndex].Union2; got.Sub(exp).Len() > 1e-5 {
+				t.Errorf("[%s] expected primitive %d to use a material with reflectance %v; got %v", spec.name, prim, exp, got)
+			}
+		}
+		if len(sc.EmissivePrimitives) != 0 {
+			t.Errorf("[%s] expected no emissive primitives; got %d", spec.name, len(sc.EmissivePrimitives))
+		}
+	}
+
+	_, err := compiler.CompileWithOptions(buildScene(true), compiler.Options{MissingMaterialPolicy: compiler.FailOnMissingMaterial})
+	if err == nil || !strings.Contains(err.Error(), compiler.ErrMissingMaterial.Error()) {
+		t.Fatalf("expected compilation to fail with %q; got %v", compiler.ErrMissingMaterial, err)
+	}
+}
+
+func TestParseMissingMaterialPolicy(t *testing.T) {
+	for _, policy := range []compiler.MissingMaterialPolicy{compiler.UseSceneDefaultMaterial, compiler.UseDebugMaterial, compiler.FailOnMissingMaterial} {
+		got, err := compiler.ParseMissingMaterialPolicy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf("expected to parse %q as %d; got %d, %v", policy.String(), policy, got, err)
+		}
+	}
+	if _, err := compiler.ParseMissingMaterialPolicy("pink"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestAlphaCutoutMaterials(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "polaris-cutout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opacityPath := filepath.Join(tmpDir, "opacity.png")
+	f, err := os.Create(opacityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewGray(image.Rect(0, 0, 2, 2)))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leaf mesh uses a cutout material; the cutout of the material
+	// referenced by the branch mesh and the cutout with a missing opacity
+	// texture used by the ghost mesh are dropped.
+	b := newBuilder()
+	matIndices := []int{
+		b.material("leaf", fmt.Sprintf(`alphaCutout(diffuse(), %q, 0.3)`, opacityPath)),
+		b.material("branch", `mix("leaf", diffuse(), 0.5)`),
+		b.material("ghost", `alphaCutout(diffuse(), "missing.png")`),
+	}
+	up := types.XYZ(0, 0, 1)
+	for index, matIndex := range matIndices {
+		z := float32(index)
+		b.triangle(b.mesh(fmt.Sprintf("tri%d", index)), matIndex, [3]types.Vec3{{0, 0, z}, {1, 0, z}, {0, 1, z}}, [3]types.Vec3{up, up, up}, [3]types.Vec2{})
+	}
+	b.camera(types.XYZ(0, 0, 10), types.XYZ(0, 0, 0), 45)
+
+	report := compiler.NewReport(nil, false)
+	sc, err := compiler.CompileWithOptions(b.build(), compiler.Options{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mi := range sc.MeshInstanceList {
+		expFlag := mi.MeshIndex == 0
+		if gotFlag := mi.Flags&scene.AlphaCutout != 0; gotFlag != expFlag {
+			t.Errorf("[mesh %d] expected alpha cutout flag to be %t; got %t", mi.MeshIndex, expFlag, gotFlag)
+		}
+	}
+
+	var numCutouts int
+	for _, node := range sc.MaterialNodeList {
+		if node.Union1[0] != int32(material.OpAlphaCutout) {
+			continue
+		}
+		numCutouts++
+		if node.Union2[0] != 0.3 || node.Union1[3] == -1 {
+			t.Errorf("expected cutout node to use cutoff 0.3 and an opacity texture; got %f and %d", node.Union2[0], node.Union1[3])
+		}
+	}
+	// Referenced materials are regenerated so the leaf cutout node appears
+	// twice; only the node at the root of the leaf material is used.
+	if numCutouts != 2 {
+		t.Errorf("expected 2 alpha cutout nodes; got %d", numCutouts)
+	}
+	if len(report.Warnings) != 2 {
+		t.Errorf("expected 2 warnings for the dropped cutouts; got %v", report.Warnings)
+	}
+}
+
+func TestMediumMaterials(t *testing.T) {
+	// The glass mesh uses a medium material; the medium of the material
+	// referenced by the mix mesh is dropped and the fog mesh uses a medium
+	// without a surface which is replaced by the default material.
+	buildScene := func(sceneMediumExpr string) *input.Scene {
+		b := newBuilder()
+		matIndices := []int{
+			b.material("glass", "medium(dielectric(), absorption: {0.5, 0.2, 0.1}, anisotropy: 0.4)"),
+			b.material("mix", `mix("glass", diffuse(), 0.5)`),
+			b.material("fog", "medium(scattering: {0.1, 0.1, 0.1})"),
+		}
+		if sceneMediumExpr != "" {
+			b.material(compiler.SceneMediumMaterialName, sceneMediumExpr)
+		}
+		up := types.XYZ(0, 0, 1)
+		for index, matIndex := range matIndices {
+			z := float32(index)
+			b.triangle(b.mesh(fmt.Sprintf("tri%d", index)), matIndex, [3]types.Vec3{{0, 0, z}, {1, 0, z}, {0, 1, z}}, [3]types.Vec3{up, up, up}, [3]types.Vec2{})
+		}
+		b.camera(types.XYZ(0, 0, 10), types.XYZ(0, 0, 0), 45)
+		return b.build()
+	}
+
+	specs := []struct {
+		sceneMediumExpr string
+		expSceneMedium  bool
+		expWarnings     int
+	}{
+		{"", false, 2},
+		{"medium(scattering: {0.01, 0.02, 0.03})", true, 2},
+		{"diffuse()", false, 3},
+	}
+	for index, spec := range specs {
+		report := compiler.NewReport(nil, false)
+		sc, err := compiler.CompileWithOptions(buildScene(spec.sceneMediumExpr), compiler.Options{Report: report})
+		if err != nil {
+			t.Errorf("[spec %d] compilation failed: %v", index, err)
+			continue
+		}
+
+		for prim, matNodeIndex := range sc.MaterialIndex {
+			node := sc.MaterialNodeList[matNodeIndex]
+			isGlass := sc.VertexList[prim*3][2] == 0
+			if gotMedium := node.Union1[0] == int32(material.OpMedium); gotMedium != isGlass {
+				t.Errorf("[spec %d] expected primitive %d to use a medium material: %t; got op %d", index, prim, isGlass, node.Union1[0])
+			} else if isGlass && (node.Union2.Vec3() != types.XYZ(0.5, 0.2, 0.1) || node.Union4[2] != 0.4) {
+				t.Errorf("[spec %d] expected glass medium to use absorption {0.5, 0.2, 0.1} and anisotropy 0.4; got %v and %f", index, node.Union2.Vec3(), node.Union4[2])
+			}
+		}
+
+		if gotSceneMedium := sc.SceneMediumMatIndex != -1; gotSceneMedium != spec.expSceneMedium {
+			t.Errorf("[spec %d] expected scene medium to be defined: %t; got index %d", index, spec.expSceneMedium, sc.SceneMediumMatIndex)
+		} else if gotSceneMedium {
+			node := sc.MaterialNodeList[sc.SceneMediumMatIndex]
+			if node.Union1[0] != int32(material.OpMedium) || node.Union3.Vec3() != types.XYZ(0.01, 0.02, 0.03) {
+				t.Errorf("[spec %d] expected scene medium node with scattering {0.01, 0.02, 0.03}; got op %d with scattering %v", index, node.Union1[0], node.Union3.Vec3())
+			}
+		}
+		if len(report.Warnings) != spec.expWarnings {
+			t.Errorf("[spec %d] expected %d warnings; got %v", index, spec.expWarnings, report.Warnings)
+		}
+	}
+}
+
+func TestSubsurfaceMaterials(t *testing.T) {
+	// The skin and mix meshes are wrapped in a medium derived from the
+	// skin parameters while the wax mesh keeps the medium that it defines.
+	b := newBuilder()
+	matIndices := []int{
+		b.material("skin", "subsurface(reflectance: {0.8, 0.5, 0.3}, radius: {0.5, 0.25, 0.1}, anisotropy: 0.3)"),
+		b.material("mix", `mix("skin", diffuse(), 0.5)`),
+		b.material("wax", "medium(subsurface(), absorption: {1, 1, 1})"),
+	}
+	up := types.XYZ(0, 0, 1)
+	for index, matIndex := range matIndices {
+		z := float32(index)
+		b.triangle(b.mesh(fmt.Sprintf("tri%d", index)), matIndex, [3]types.Vec3{{0, 0, z}, {1, 0, z}, {0, 1, z}}, [3]types.Vec3{up, up, up}, [3]types.Vec2{})
+	}
+	b.camera(types.XYZ(0, 0, 10), types.XYZ(0, 0, 0), 45)
+
+	report := compiler.NewReport(nil, false)
+	sc, err := compiler.CompileWithOptions(b.build(), compiler.Options{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("expected no warnings; got %v", report.Warnings)
+	}
+
+	expAbsorption, expScattering := material.SubsurfaceCoefficients(types.XYZ(0.8, 0.5, 0.3), types.XYZ(0.5, 0.25, 0.1))
+	for prim, matNodeIndex := range sc.MaterialIndex {
+		node := sc.MaterialNodeList[matNodeIndex]
+		index := int(sc.VertexList[prim*3][2])
+		if node.Union1[0] != int32(material.OpMedium) {
+			t.Errorf("[mat %d] expected material root to be a medium; got op %d", index, node.Union1[0])
+			continue
+		}
+
+		if index == 2 {
+			if node.Union2.Vec3() != types.XYZ(1, 1, 1) || node.Union3.Vec3() != (types.Vec3{}) {
+				t.Errorf("[mat %d] expected the medium defined by the material to be used; got absorption %v and scattering %v", index, node.Union2.Vec3(), node.Union3.Vec3())
+			}
+			continue
+		}
+		if node.Union2.Vec3() != expAbsorption || node.Union3.Vec3() != expScattering || node.Union4[2] != 0.3 {
+			t.Errorf("[mat %d] expected medium with absorption %v, scattering %v and anisotropy 0.3; got %v, %v and %f", index, expAbsorption, expScattering, node.Union2.Vec3(), node.Union3.Vec3(), node.Union4[2])
+		}
+	}
+}
+
+func TestAbsorbingBoxRadiance(t *testing.T) {
+	eye := types.XYZ(0, 0, 4)
+	specs := []struct {
+		dir types.Vec3
+		exp float32
+	}{
+		// Through the box center
+		{types.XYZ(0, 0, -1), FurnaceBackground * float32(math.Exp(-1))},
+		// Exiting through the box edge at (1, 0, -1)
+		{types.XYZ(1, 0, -5).Normalize(), FurnaceBackground * float32(math.Exp(-0.2*math.Sqrt(26)))},
+		// Missing the box
+		{types.XYZ(0, 1, -1).Normalize(), FurnaceBackground},
+	}
+
+	for index, spec := range specs {
+		if got := AbsorbingBoxRadiance(0.5, eye, spec.dir); math.Abs(float64(got-spec.exp)) > 1e-5 {
+			t.Errorf("[spec %d] expected radiance along %v to be %f; got %f", index, spec.dir, spec.exp, got)
+		}
+	}
+}
+
+func BenchmarkCompileCornellBox(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_, err := Compile(CornellBox())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSkySphereRadiance(t *testing.T) {
+	specs := []struct {
+		normal types.Vec3
+		exp    float32
+	}{
+		{types.XYZ(0, 1, 0), 0.4},
+		{types.XYZ(1, 0, 0), 0.2},
+		{types.XYZ(0, -1, 0), 0},
+	}
+
+	for index, spec := range specs {
+		if got := SkySphereRadiance(0.5, 0.8, spec.normal); math.Abs(float64(got-spec.exp)) > 1e-6 {
+			t.Errorf("[spec %d] expected radiance for normal %v to be %f; got %f", index, spec.normal, spec.exp, got)
+		}
+	}
+}
+
+func TestAreaLitFloorRadiance(t *testing.T) {
+	// Compare the analytic solution against a numerical integration of the
+	// form factor over the light surface.
+	rng := rand.New(rand.NewSource(1))
+	c, h := AreaLightCenter, AreaLightHalfSize
+	for _, point := range []types.Vec3{{0, 0, 0}, {0.5, 0, -0.25}, {3, 0, 1}} {
+		const numSamples = 200000
+		var sum float64
+		for sample := 0; sample < numSamples; sample++ {
+			lightPoint := types.XYZ(
+				c[0]+h*(2*rng.Float32()-1),
+				c[1],
+				c[2]+h*(2*rng.Float32()-1),
+			)
+			d := lightPoint.Sub(point)
+			distSq := float64(d.Dot(d))
+			cosTheta := float64(d[1]) / math.Sqrt(distSq)
+			sum += cosTheta * cosTheta / (math.Pi * distSq)
+		}
+		formFactor := sum / numSamples * float64(4*h*h)
+
+		got := AreaLitFloorRadiance(0.5, 4, point)
+		if exp := 0.5 * 4 * formFactor; math.Abs(float64(got)-exp) > 1e-2*exp {
+			t.Errorf("expected radiance at %v to be %f; got %f", point, exp, got)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package scene
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+	"github.com/olekukonko/tablewriter"
+)
+
+var (
+	ErrInvalidScene = errors.New("scene: invalid scene data")
+)
+
+// Summary statistics for the contents of a compiled scene.
+type Summary struct {
+	MeshInstances int
+	Meshes        int
+
+	// The number of triangles stored in the scene and the number of
+	// triangles after expanding the mesh instances.
+	Triangles          int
+	InstancedTriangles int
+
+	// The number of material nodes and the number of distinct materials
+	// referenced by the scene triangles.
+	MaterialNodes int
+	Materials     int
+
+	AreaLights        int
+	EnvironmentLights int
+
+	Textures     int
+	TextureBytes int
+
+	Volumes     int
+	VolumeBytes int
+
+	Cameras int
+
+	// The scene bounding box.
+	BBox [2]types.Vec3
+
+	// The number of BVH nodes and the max depth of the top-level BVH plus
+	// the deepest mesh BVH. The latter defines the traversal stack depth
+	// required for intersecting the scene.
+	BvhNodes    int
+	MaxBvhDepth int
+
+	// The number of bytes required for uploading the scene data to a
+	// device. This does not include the frame-dependent buffers allocated
+	// by the tracers.
+	DeviceMemory int
+
+	// Problems detected while validating the scene.
+	Issues []string
+}
+
+// Collect summary statistics for the scene and validate its contents.
+func (sc *Scene) Summary() *Summary {
+	s := &Summary{
+		MeshInstances: len(sc.MeshInstanceList),
+		Triangles:     len(sc.MaterialIndex),
+		MaterialNodes: len(sc.MaterialNodeList),
+		Textures:      len(sc.TextureMetadata),
+		TextureBytes:  len(sc.TextureData),
+		Volumes:       len(sc.VolumeGrids),
+		VolumeBytes:   sizeOf(sc.VolumeData),
+		Cameras:       len(sc.Cameras),
+		BvhNodes:      len(sc.BvhNodeList),
+		DeviceMemory: sizeOf(
+			sc.VertexList, sc.NormalList, sc.TangentList, sc.UvList, sc.BvhNodeList,
+			sc.MeshInstanceList, sc.EmissivePrimitives, sc.MaterialNodeList,
+			sc.MaterialIndex, sc.TextureMetadata, sc.TextureData,
+			sc.VolumeGrids, sc.VolumeData,
+		),
+	}
+
+	s.Issues = append(s.Issues, sc.validatePrimitives()...)
+	s.Issues = append(s.Issues, sc.validateEmissives(s)...)
+	s.Issues = append(s.Issues, sc.validateTextures()...)
+	s.Issues = append(s.Issues, sc.validateVolumes()...)
+
+	// The BVH inspection walks each mesh tree once so we can also use it
+	// to count the instanced triangles without following broken links.
+	in := sc.InspectBvh()
+	s.Issues = append(s.Issues, in.Issues...)
+	sceneDepth, meshDepth := 0, 0
+	for index, tree := range in.Trees {
+		if index == 0 {
+			sceneDepth = tree.Stats.MaxDepth
+			if len(tree.Nodes) != 0 {
+				s.BBox = [2]types.Vec3{tree.Nodes[0].Min, tree.Nodes[0].Max}
+			}
+			continue
+		}
+
+		s.Meshes++
+		meshTriangles := 0
+		for _, node := range tree.Nodes {
+			meshTriangles += int(node.PrimitiveCount)
+		}
+		s.InstancedTriangles += meshTriangles * len(tree.Instances)
+		if tree.Stats.MaxDepth > meshDepth {
+			meshDepth = tree.Stats.MaxDepth
+		}
+	}
+	if len(in.Trees) != 0 {
+		s.MaxBvhDepth = sceneDepth + meshDepth + 1
+	}
+
+	materials := make(map[uint32]bool)
+	for _, matIndex := range sc.MaterialIndex {
+		materials[matIndex] = true
+	}
+	s.Materials = len(materials)
+
+	return s
+}
+
+// Validate the scene contents and return an error describing the first
+// detected issue.
+func (sc *Scene) Validate() error {
+	issues := sc.Summary().Issues
+	switch len(issues) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s: %s", ErrInvalidScene.Error(), issues[0])
+	}
+	return fmt.Errorf("%s: %s (and %d more issue(s))", ErrInvalidScene.Error(), issues[0], len(issues)-1)
+}
+
+// Check that the per-primitive lists are consistent and that the primitive
+// material indices are in range.
+func (sc *Scene) validatePrimitives() []string {
+	var issues []string
+	if len(sc.MeshInstanceList) == 0 {
+		issues = append(issues, "scene does not define any mesh instances")
+	}
+
+	numVertices := 3 * len(sc.MaterialIndex)
+	if len(sc.VertexList) != numVertices {
+		issues = append(issues, fmt.Sprintf("expected %d vertices for %d triangles; got %d", numVertices, len(sc.MaterialIndex), len(sc.VertexList)))
+	}
+	if len(sc.NormalList) != numVertices {
+		issues = append(issues, fmt.Sprintf("expected %d normals for %d triangles; got %d", numVertices, len(sc.MaterialIndex), len(sc.NormalList)))
+	}
+	if len(sc.UvList) != numVertices {
+		issues = append(issues, fmt.Sprintf("expected %d uvs for %d triangles; got %d", numVertices, len(sc.MaterialIndex), len(sc.UvList)))
+	}
+	if len(sc.TangentList) != 0 && len(sc.TangentList) != numVertices {
+		issues = append(issues, fmt.Sprintf("expected %d tangents for %d triangles; got %d", numVertices, len(sc.MaterialIndex), len(sc.TangentList)))
+	}
+
+	numNodes := uint32(len(sc.MaterialNodeList))
+	for prim, matIndex := range sc.MaterialIndex {
+		if matIndex >= numNodes {
+			issues = append(issues, fmt.Sprintf("triangle %d references missing material node %d", prim, matIndex))
+			break
+		}
+	}
+
+	for _, global := range []struct {
+		name  string
+		index int32
+	}{
+		{"diffuse", sc.SceneDiffuseMatIndex},
+		{"emissive", sc.SceneEmissiveMatIndex},
+		{"backplate", sc.SceneBackplateMatIndex},
+		{"medium", sc.SceneMediumMatIndex},
+	} {
+		if global.index >= int32(numNodes) {
+			issues = append(issues, fmt.Sprintf("scene %s material references missing material node %d", global.name, global.index))
+		}
+	}
+
+	return issues
+}
+
+// Count the scene emissives and check that they reference valid primitives
+// and material nodes.
+func (sc *Scene) validateEmissives(s *Summary) []string {
+	var issues []string
+	numPrims, numNodes := uint32(len(sc.MaterialIndex)), uint32(len(sc.MaterialNodeList))
+	for index, emissive := range sc.EmissivePrimitives {
+		switch emissive.Type {
+		case AreaLight:
+			s.AreaLights++
+			if emissive.PrimitiveIndex >= numPrims {
+				issues = append(issues, fmt.Sprintf("emissive %d references missing triangle %d", index, emissive.PrimitiveIndex))
+			}
+		case EnvironmentLight:
+			s.EnvironmentLights++
+		default:
+			issues = append(issues, fmt.Sprintf("emissive %d has unknown type %d", index, emissive.Type))
+		}
+
+		if emissive.MaterialNodeIndex >= numNodes {
+			issues = append(issues, fmt.Sprintf("emissive %d references missing material node %d", index, emissive.MaterialNodeIndex))
+		}
+	}
+	return issues
+}
+
+// Check that the texture data offsets are in range.
+func (sc *Scene) validateTextures() []string {
+	var issues []string
+	for index, meta := range sc.TextureMetadata {
+		if meta.Width == 0 || meta.Height == 0 {
+			issues = append(issues, fmt.Sprintf("texture %d has invalid dimensions %dx%d", index, meta.Width, meta.Height))
+		}
+		if int(meta.DataOffset) >= len(sc.TextureData) {
+			issues = append(issues, fmt.Sprintf("texture %d data offset %d out of range", index, meta.DataOffset))
+		}
+	}
+	return issues
+}
+
+// Check that the volume grid data ranges fit in the volume data and that the
+// media reference existing grids.
+func (sc *Scene) validateVolumes() []string {
+	var issues []string
+	for index, grid := range sc.VolumeGrids {
+		numVoxels := uint64(grid.Dims[0]) * uint64(grid.Dims[1]) * uint64(grid.Dims[2])
+		if numVoxels == 0 {
+			issues = append(issues, fmt.Sprintf("volume grid %d has invalid dimensions %dx%dx%d", index, grid.Dims[0], grid.Dims[1], grid.Dims[2]))
+		} else if uint64(grid.DataOffset)+numVoxels > uint64(len(sc.VolumeData)) {
+			issues = append(issues, fmt.Sprintf("volume grid %d data range [%d, %d) out of range", index, grid.DataOffset, uint64(grid.DataOffset)+numVoxels))
+		}
+	}
+
+	numGrids := int32(len(sc.VolumeGrids))
+	for index, node := range sc.MaterialNodeList {
+		if node.Union1[0] != int32(material.OpMedium) {
+			continue
+		}
+		for _, gridIndex := range node.Union1[2:] {
+			if gridIndex >= numGrids {
+				issues = append(issues, fmt.Sprintf("medium material node %d references missing volume grid %d", index, gridIndex))
+			}
+		}
+	}
+	return issues
+}
+
+// Build a tabular representation of the summary statistics.
+func (s *Summary) String() string {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Statistic", "Value"})
+	table.Append([]string{"Mesh instances", strconv.Itoa(s.MeshInstances)})
+	table.Append([]string{"Meshes", strconv.Itoa(s.Meshes)})
+	table.Append([]string{"Triangles", strconv.Itoa(s.Triangles)})
+	table.Append([]string{"Instanced triangles", strconv.Itoa(s.InstancedTriangles)})
+	table.Append([]string{"Materials", strconv.Itoa(s.Materials)})
+	table.Append([]string{"Material nodes", strconv.Itoa(s.MaterialNodes)})
+	table.Append([]string{"Area lights", strconv.Itoa(s.AreaLights)})
+	table.Append([]string{"Environment lights", strconv.Itoa(s.EnvironmentLights)})
+	table.Append([]string{"Textures", fmt.Sprintf("%d (%s)", s.Textures, formatBytes(s.TextureBytes))})
+	table.Append([]string{"Volumes", fmt.Sprintf("%d (%s)", s.Volumes, formatBytes(s.VolumeBytes))})
+	table.Append([]string{"Cameras", strconv.Itoa(s.Cameras)})
+	table.Append([]string{"BVH nodes", strconv.Itoa(s.BvhNodes)})
+	table.Append([]string{"Max BVH depth", strconv.Itoa(s.MaxBvhDepth)})
+	table.Append([]string{"Bounding box", fmt.Sprintf("%v - %v", s.BBox[0], s.BBox[1])})
+	table.SetFooter([]string{"Device memory", formatBytes(s.DeviceMemory)})
+
+	table.Render()
+	return buf.String()
+}
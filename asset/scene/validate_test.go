@@ -0,0 +1,96 @@
+package scene
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+func validateTestScene() *Scene {
+	sc := inspectTestScene()
+	sc.NormalList = make([]types.Vec4, 3)
+	sc.UvList = make([]types.Vec2, 3)
+	sc.MaterialNodeList = make([]MaterialNode, 2)
+	sc.SceneDiffuseMatIndex, sc.SceneEmissiveMatIndex, sc.SceneBackplateMatIndex = -1, -1, -1
+	sc.SceneMediumMatIndex = -1
+	sc.EmissivePrimitives = []EmissivePrimitive{
+		{Type: AreaLight, PrimitiveIndex: 0, MaterialNodeIndex: 1},
+		{Type: EnvironmentLight, MaterialNodeIndex: 0},
+	}
+	return sc
+}
+
+func TestSceneSummary(t *testing.T) {
+	s := validateTestScene().Summary()
+	if len(s.Issues) != 0 {
+		t.Fatalf("expected no issues; got %v", s.Issues)
+	}
+
+	exp := Summary{
+		MeshInstances:      2,
+		Meshes:             1,
+		Triangles:          1,
+		InstancedTriangles: 2,
+		MaterialNodes:      2,
+		Materials:          1,
+		AreaLights:         1,
+		EnvironmentLights:  1,
+		BBox:               [2]types.Vec3{{-3, 0, 0}, {3, 1, 0}},
+		BvhNodes:           4,
+		MaxBvhDepth:        2,
+	}
+	exp.DeviceMemory = s.DeviceMemory
+	if s.DeviceMemory == 0 {
+		t.Fatal("expected device memory estimate to be non-zero")
+	}
+	if !reflect.DeepEqual(*s, exp) {
+		t.Fatalf("expected summary to be %+v; got %+v", exp, *s)
+	}
+}
+
+func TestSceneValidate(t *testing.T) {
+	sc := validateTestScene()
+	if err := sc.Validate(); err != nil {
+		t.Fatalf("expected scene to be valid; got %v", err)
+	}
+
+	sc.MaterialIndex[0] = 5
+	sc.EmissivePrimitives[0].PrimitiveIndex = 3
+	sc.UvList = nil
+	issues := sc.Summary().Issues
+	if len(issues) != 3 {
+		t.Fatalf("expected 3 issues; got %v", issues)
+	}
+
+	err := sc.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidScene.Error()) {
+		t.Fatalf("expected an ErrInvalidScene error; got %v", err)
+	}
+	if !strings.Contains(err.Error(), "and 2 more issue(s)") {
+		t.Fatalf("expected error to mention the remaining issues; got %v", err)
+	}
+}
+
+func TestSceneValidateVolumes(t *testing.T) {
+	sc := validateTestScene()
+	sc.MaterialNodeList = append(sc.MaterialNodeList, MaterialNode{Union1: [4]int32{int32(material.OpMedium), -1, 0, -1}})
+	sc.VolumeGrids = []VolumeGrid{{Dims: [3]uint32{2, 2, 2}}}
+	sc.VolumeData = make([]float32, 8)
+	if err := sc.Validate(); err != nil {
+		t.Fatalf("expected scene to be valid; got %v", err)
+	}
+
+	sc.VolumeGrids[0].DataOffset = 1
+	sc.MaterialNodeList[2].Union1[3] = 1
+	issues := sc.Summary().Issues
+	expIssues := []string{
+		"volume grid 0 data range [1, 9) out of range",
+		"medium material node 2 references missing volume grid 1",
+	}
+	if !reflect.DeepEqual(issues, expIssues) {
+		t.Fatalf("expected issues to be %v; got %v", expIssues, issues)
+	}
+}
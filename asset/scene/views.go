@@ -0,0 +1,147 @@
+package scene
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// The FOV used by view cameras if the scene does not define a camera.
+const defaultViewFOV float32 = 45
+
+// A ViewAngle selects one of the preset directions for looking at the scene
+// geometry.
+type ViewAngle uint8
+
+// The supported view angles.
+const (
+	FrontView ViewAngle = iota
+	SideView
+	TopView
+	PerspectiveView
+)
+
+// The view angles used when no explicit view list is specified.
+var DefaultViewAngles = []ViewAngle{FrontView, SideView, TopView, PerspectiveView}
+
+// Implements Stringer.
+func (v ViewAngle) String() string {
+	switch v {
+	case FrontView:
+		return "front"
+	case SideView:
+		return "side"
+	case TopView:
+		return "top"
+	case PerspectiveView:
+		return "perspective"
+	}
+	return fmt.Sprintf("ViewAngle(%d)", uint8(v))
+}
+
+// Parse a view angle name.
+func ParseViewAngle(name string) (ViewAngle, error) {
+	for _, view := range DefaultViewAngles {
+		if strings.EqualFold(name, view.String()) {
+			return view, nil
+		}
+	}
+
+	return FrontView, fmt.Errorf("%s %q; supported views are front, side, top and perspective", ErrUnknownViewAngle.Error(), name)
+}
+
+// Get the world-space bounding box of all mesh instances whose flags do not
+// match skipFlags. The returned box is empty (min > max) if no instances
+// are included.
+func (sc *Scene) GeometryBBox(skipFlags MeshInstanceFlag) [2]types.Vec3 {
+	maxF := float32(math.MaxFloat32)
+	bbox := [2]types.Vec3{{maxF, maxF, maxF}, {-maxF, -maxF, -maxF}}
+
+	for _, mi := range sc.MeshInstanceList {
+		if mi.Flags&skipFlags != 0 || int(mi.BvhRoot) >= len(sc.BvhNodeList) {
+			continue
+		}
+
+		instBBox := sc.instanceBBox(&mi)
+		bbox[0] = types.MinVec3(bbox[0], instBBox[0])
+		bbox[1] = types.MaxVec3(bbox[1], instBBox[1])
+	}
+
+	return bbox
+}
+
+// Get the world-space bounding box of a mesh instance by transforming the
+// corners of its mesh BVH root bounding box.
+func (sc *Scene) instanceBBox(mi *MeshInstance) [2]types.Vec3 {
+	maxF := float32(math.MaxFloat32)
+	bbox := [2]types.Vec3{{maxF, maxF, maxF}, {-maxF, -maxF, -maxF}}
+
+	toWorld := mi.MeshToWorld()
+	root := sc.BvhNodeList[mi.BvhRoot]
+	for corner := 0; corner < 8; corner++ {
+		v := root.Min
+		if corner&1 != 0 {
+			v[0] = root.Max[0]
+		}
+		if corner&2 != 0 {
+			v[1] = root.Max[1]
+		}
+		if corner&4 != 0 {
+			v[2] = root.Max[2]
+		}
+
+		v = toWorld.Mul4x1(v.Vec4(1)).Vec3()
+		bbox[0] = types.MinVec3(bbox[0], v)
+		bbox[1] = types.MaxVec3(bbox[1], v)
+	}
+
+	return bbox
+}
+
+// Create a camera that looks at the scene geometry from the requested view
+// angle. The camera is positioned so that the bounding sphere of the scene
+// geometry fits inside its field of view. Shadow catchers (e.g. ground
+// planes) are excluded when framing the geometry. The FOV of the active scene
+// camera is used if one is defined.
+func (sc *Scene) ViewCamera(view ViewAngle) *Camera {
+	fov := defaultViewFOV
+	if sc.Camera != nil && sc.Camera.FOV > 0 {
+		fov = sc.Camera.FOV
+	}
+
+	center, radius := types.Vec3{}, float32(1)
+	bbox := sc.GeometryBBox(ShadowCatcher)
+	if bbox[0][0] <= bbox[1][0] {
+		center = bbox[0].Add(bbox[1]).Mul(0.5)
+		if r := bbox[1].Sub(bbox[0]).Len() * 0.5; r > 0 {
+			radius = r
+		}
+	}
+
+	var dir, up types.Vec3
+	switch view {
+	case SideView:
+		dir, up = types.Vec3{1, 0, 0}, types.Vec3{0, 1, 0}
+	case TopView:
+		dir, up = types.Vec3{0, 1, 0}, types.Vec3{0, 0, -1}
+	case PerspectiveView:
+		dir, up = types.Vec3{1, 0.75, 1}.Normalize(), types.Vec3{0, 1, 0}
+	default:
+		dir, up = types.Vec3{0, 0, 1}, types.Vec3{0, 1, 0}
+	}
+
+	// Move the camera back until the bounding sphere fits inside the
+	// vertical field of view. The distance is calculated using the same
+	// half-angle tangent as the projection matrix built by SetupProjection.
+	halfTan := math.Abs(math.Tan(float64(fov) / 2))
+	dist := radius * float32(math.Sqrt(1+1/(halfTan*halfTan)))
+
+	cam := NewCamera(fov)
+	cam.Name = view.String()
+	cam.Position = center.Add(dir.Mul(dist))
+	cam.LookAt = center
+	cam.Up = up
+	return cam
+}
@@ -0,0 +1,101 @@
+package scene
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+func viewTestScene() *Scene {
+	unitBox := BvhNode{Min: types.Vec3{-1, -1, -1}, Max: types.Vec3{1, 1, 1}}
+	return &Scene{
+		BvhNodeList: []BvhNode{unitBox},
+		MeshInstanceList: []MeshInstance{
+			// Boxes centered at x=2 and x=6 and a ground plane scaled by 100 along x and z
+			{Transform: types.Translate4(types.Vec3{-2, 0, 0})},
+			{Transform: types.Translate4(types.Vec3{-6, 0, 0})},
+			{Transform: types.Scale4(types.Vec3{0.01, 1, 0.01}), Flags: ShadowCatcher},
+		},
+	}
+}
+
+func TestGeometryBBox(t *testing.T) {
+	sc := viewTestScene()
+
+	bbox := sc.GeometryBBox(ShadowCatcher)
+	if !types.ApproxEqual(bbox[0], types.Vec3{1, -1, -1}, 1e-5) || !types.ApproxEqual(bbox[1], types.Vec3{7, 1, 1}, 1e-5) {
+		t.Fatalf("expected bbox to be [(1, -1, -1), (7, 1, 1)]; got %v", bbox)
+	}
+
+	bbox = sc.GeometryBBox(0)
+	if !types.ApproxEqual(bbox[0], types.Vec3{-100, -1, -100}, 1e-3) || !types.ApproxEqual(bbox[1], types.Vec3{100, 1, 100}, 1e-3) {
+		t.Fatalf("expected bbox to include the shadow catcher; got %v", bbox)
+	}
+
+	bbox = (&Scene{}).GeometryBBox(0)
+	if bbox[0][0] <= bbox[1][0] {
+		t.Fatalf("expected bbox of an empty scene to be empty; got %v", bbox)
+	}
+}
+
+func TestViewCamera(t *testing.T) {
+	sc := viewTestScene()
+	center := types.Vec3{4, 0, 0}
+	radius := types.Vec3{3, 1, 1}.Len()
+
+	specs := []struct {
+		view ViewAngle
+		dir  types.Vec3
+	}{
+		{FrontView, types.Vec3{0, 0, 1}},
+		{SideView, types.Vec3{1, 0, 0}},
+		{TopView, types.Vec3{0, 1, 0}},
+		{PerspectiveView, types.Vec3{1, 0.75, 1}.Normalize()},
+	}
+
+	for _, spec := range specs {
+		cam := sc.ViewCamera(spec.view)
+		if cam.Name != spec.view.String() {
+			t.Errorf("[%s] expected camera name to be %q; got %q", spec.view, spec.view.String(), cam.Name)
+		}
+		if !types.ApproxEqual(cam.LookAt, center, 1e-5) {
+			t.Errorf("[%s] expected camera to look at %v; got %v", spec.view, center, cam.LookAt)
+		}
+		if got := cam.Position.Sub(center).Normalize(); !types.ApproxEqual(got, spec.dir, 1e-5) {
+			t.Errorf("[%s] expected camera direction to be %v; got %v", spec.view, spec.dir, got)
+		}
+
+		// The bounding sphere should fit inside the vertical FOV
+		halfTan := math.Abs(math.Tan(float64(cam.FOV) / 2))
+		dist := float64(cam.Position.Sub(center).Len())
+		if got := math.Asin(float64(radius) / dist); got > math.Atan(halfTan)+1e-5 {
+			t.Errorf("[%s] expected bounding sphere to fit inside the camera FOV", spec.view)
+		}
+
+		// Ensure that the camera basis is well defined
+		cam.SetupProjection(1)
+		if _, up, _ := cam.Basis(); math.IsNaN(float64(up[0])) || up.Len() < 0.99 {
+			t.Errorf("[%s] expected camera basis to be valid; got up vector %v", spec.view, up)
+		}
+	}
+
+	// The FOV of the active scene camera should be used
+	sc.Camera = NewCamera(1.0)
+	if cam := sc.ViewCamera(FrontView); cam.FOV != 1.0 {
+		t.Fatalf("expected view camera to use the active camera FOV; got %f", cam.FOV)
+	}
+}
+
+func TestParseViewAngle(t *testing.T) {
+	for _, view := range DefaultViewAngles {
+		got, err := ParseViewAngle(view.String())
+		if err != nil || got != view {
+			t.Errorf("expected to parse %q as %d; got %d, %v", view.String(), view, got, err)
+		}
+	}
+
+	if _, err := ParseViewAngle("isometric"); err == nil {
+		t.Fatal("expected to get an error for an unknown view angle")
+	}
+}
@@ -0,0 +1,114 @@
+package scene
+
+import "github.com/achilleasa/polaris/asset/material"
+
+// The number of hemisphere rays used by the tracers for estimating the local
+// occlusion at each vertex.
+const LocalOcclusionSamples = 64
+
+// The inputs for estimating the local occlusion used by mixOcclusion material
+// nodes. The occlusion is calculated once for each vertex of the triangles
+// that need it by tracing rays against the BVH of the mesh containing the
+// triangle, so only geometry belonging to the same mesh occludes the vertex.
+type LocalOcclusionTargets struct {
+	// The root node of the mesh BVH containing each triangle.
+	BvhRoots []uint32
+
+	// The max distance of occlusion rays for each triangle. Triangles
+	// whose material does not contain any mixOcclusion nodes have a zero
+	// radius and are skipped.
+	Radius []float32
+}
+
+// Collect the local occlusion inputs for the scene triangles. If the scene
+// materials do not contain any mixOcclusion nodes, this method returns nil.
+func (sc *Scene) LocalOcclusionTargets() *LocalOcclusionTargets {
+	numTriangles := len(sc.MaterialIndex)
+	targets := &LocalOcclusionTargets{
+		BvhRoots: make([]uint32, numTriangles),
+		Radius:   make([]float32, numTriangles),
+	}
+
+	radiusCache := make(map[uint32]float32)
+	hasTargets := false
+	for triIndex, matIndex := range sc.MaterialIndex {
+		radius, cached := radiusCache[matIndex]
+		if !cached {
+			radius = sc.occlusionRadius(int32(matIndex), 0)
+			radiusCache[matIndex] = radius
+		}
+		targets.Radius[triIndex] = radius
+		hasTargets = hasTargets || radius > 0
+	}
+	if !hasTargets {
+		return nil
+	}
+
+	// Meshes may be shared by multiple instances; visit each mesh BVH once
+	visited := make(map[uint32]bool)
+	for _, instance := range sc.MeshInstanceList {
+		if visited[instance.BvhRoot] {
+			continue
+		}
+		visited[instance.BvhRoot] = true
+		sc.setMeshBvhRoot(targets.BvhRoots, instance.BvhRoot)
+	}
+
+	return targets
+}
+
+// Get the max radius of the mixOcclusion nodes in a material tree.
+func (sc *Scene) occlusionRadius(nodeIndex int32, depth int) float32 {
+	// Guard against invalid indices and cycles in malformed material trees
+	if nodeIndex < 0 || int(nodeIndex) >= len(sc.MaterialNodeList) || depth > len(sc.MaterialNodeList) {
+		return 0
+	}
+
+	node := &sc.MaterialNodeList[nodeIndex]
+	nodeType := uint32(node.Union1[0])
+	if !material.IsOpType(nodeType) {
+		return 0
+	}
+
+	var radius float32
+	if nodeType == uint32(material.OpMixOcclusion) {
+		radius = node.Union2[0]
+	}
+
+	children := node.Union1[1:2]
+	switch material.OpType(nodeType) {
+	case material.OpMix, material.OpMixMap, material.OpMixCurvature, material.OpMixOcclusion:
+		children = node.Union1[1:3]
+	}
+	for _, child := range children {
+		if childRadius := sc.occlusionRadius(child, depth+1); childRadius > radius {
+			radius = childRadius
+		}
+	}
+
+	return radius
+}
+
+// Set the BVH root for all triangles referenced by the leafs of a mesh BVH.
+func (sc *Scene) setMeshBvhRoot(roots []uint32, bvhRoot uint32) {
+	// Guard against invalid indices and cycles in malformed trees
+	stack := []uint32{bvhRoot}
+	for visits := 0; len(stack) != 0 && visits <= len(sc.BvhNodeList); visits++ {
+		nodeIndex := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if int(nodeIndex) >= len(sc.BvhNodeList) {
+			continue
+		}
+		node := &sc.BvhNodeList[nodeIndex]
+
+		if node.LData > 0 {
+			stack = append(stack, uint32(node.LData), uint32(node.RData))
+			continue
+		}
+
+		first, count := node.GetPrimitives()
+		for triIndex := first; triIndex < first+count && int(triIndex) < len(roots); triIndex++ {
+			roots[triIndex] = bvhRoot
+		}
+	}
+}
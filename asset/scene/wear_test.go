@@ -0,0 +1,32 @@
+package scene
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestLocalOcclusionTargets(t *testing.T) {
+	sc := inspectTestScene()
+	sc.MaterialNodeList = []MaterialNode{
+		{Union1: [4]int32{int32(material.BxdfDiffuse)}},
+		{Union1: [4]int32{int32(material.OpMixOcclusion), 0, 2}, Union2: types.Vec4{0.25}},
+		{Union1: [4]int32{int32(material.OpBumpMap), 3}},
+		{Union1: [4]int32{int32(material.OpMixOcclusion), 0, 0}, Union2: types.Vec4{0.5}},
+	}
+
+	if targets := sc.LocalOcclusionTargets(); targets != nil {
+		t.Fatalf("expected no targets for a scene without mixOcclusion nodes; got %+v", targets)
+	}
+
+	sc.MaterialIndex[0] = 1
+	exp := &LocalOcclusionTargets{
+		BvhRoots: []uint32{3},
+		Radius:   []float32{0.5},
+	}
+	if targets := sc.LocalOcclusionTargets(); !reflect.DeepEqual(targets, exp) {
+		t.Fatalf("expected targets to be %+v; got %+v", exp, targets)
+	}
+}
@@ -0,0 +1,278 @@
+package texture
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/achilleasa/polaris/asset"
+)
+
+var (
+	ErrBudgetExceeded = errors.New("texture cache: textures do not fit in the memory budget even after downscaling")
+)
+
+// Information about a texture that was downscaled to fit the memory budget.
+type Downscale struct {
+	// The paths of all resources that share the downscaled texture.
+	Paths []string
+
+	// The original texture dimensions.
+	FromW, FromH uint32
+
+	// The downscaled texture dimensions.
+	ToW, ToH uint32
+}
+
+// A Cache loads textures on demand and ensures that each texture is only
+// loaded once. Textures with identical contents are shared even if they
+// are loaded from different resources. The cache can also downscale the
+// loaded textures so that they fit a memory budget.
+type Cache struct {
+	// The max number of bytes that may be used by cached texture data. A
+	// zero value disables the budget.
+	budget int
+
+	// Loaded textures indexed by resource path and content hash.
+	byPath map[string]*Texture
+	byHash map[[sha1.Size]byte]*Texture
+
+	// The list of resource paths that share each unique texture.
+	paths map[*Texture][]string
+
+	// The unique textures in load order.
+	textures []*Texture
+
+	// The texture loader; overridden by tests.
+	loadFn func(*asset.Resource) (*Texture, error)
+}
+
+// Create a new texture cache with the given memory budget in bytes. A zero
+// budget disables downscaling.
+func NewCache(budget int) *Cache {
+	return &Cache{
+		budget: budget,
+		byPath: make(map[string]*Texture, 0),
+		byHash: make(map[[sha1.Size]byte]*Texture, 0),
+		paths:  make(map[*Texture][]string, 0),
+		loadFn: New,
+	}
+}
+
+// Load a texture from a resource. If the resource has already been loaded
+// or another resource with identical contents has been loaded then the
+// cached texture instance is returned instead.
+func (c *Cache) Load(res *asset.Resource) (*Texture, error) {
+	if tex, exists := c.byPath[res.Path()]; exists {
+		return tex, nil
+	}
+
+	tex, err := c.loadFn(res)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := tex.hash()
+	if shared, exists := c.byHash[hash]; exists {
+		tex = shared
+	} else {
+		c.byHash[hash] = tex
+		c.textures = append(c.textures, tex)
+	}
+
+	c.byPath[res.Path()] = tex
+	c.paths[tex] = append(c.paths[tex], res.Path())
+	return tex, nil
+}
+
+// Get the number of unique textures in the cache.
+func (c *Cache) Len() int {
+	return len(c.textures)
+}
+
+// Get the total size in bytes of the unique cached textures.
+func (c *Cache) Size() int {
+	size := 0
+	for _, tex := range c.textures {
+		size += len(tex.Data)
+	}
+	return size
+}
+
+// Downscale the cached textures until their total size fits the memory budget.
+// The largest texture is always downscaled first. This method returns back a
+// list of the downscaled textures. If the textures cannot fit the budget even
+// after downscaling them to a single texel, FitBudget returns the list of
+// downscaled textures and ErrBudgetExceeded.
+func (c *Cache) FitBudget() ([]Downscale, error) {
+	if c.budget <= 0 {
+		return nil, nil
+	}
+
+	origDims := make(map[*Texture][2]uint32, 0)
+	size := c.Size()
+	for size > c.budget {
+		// Select largest texture that can still be downscaled
+		var largest *Texture
+		for _, tex := range c.textures {
+			if tex.Width == 1 && tex.Height == 1 {
+				continue
+			}
+			if largest == nil || len(tex.Data) > len(largest.Data) {
+				largest = tex
+			}
+		}
+
+		if largest == nil {
+			break
+		}
+
+		if _, exists := origDims[largest]; !exists {
+			origDims[largest] = [2]uint32{largest.Width, largest.Height}
+		}
+
+		size -= len(largest.Data)
+		largest.downscale()
+		size += len(largest.Data)
+	}
+
+	// Build report in texture load order
+	report := make([]Downscale, 0, len(origDims))
+	for _, tex := range c.textures {
+		dims, exists := origDims[tex]
+		if !exists {
+			continue
+		}
+
+		paths := append([]string{}, c.paths[tex]...)
+		sort.Strings(paths)
+		report = append(report, Downscale{
+			Paths: paths,
+			FromW: dims[0],
+			FromH: dims[1],
+			ToW:   tex.Width,
+			ToH:   tex.Height,
+		})
+	}
+
+	if size > c.budget {
+		return report, ErrBudgetExceeded
+	}
+	return report, nil
+}
+
+// Calculate a hash of the texture format, dimensions and contents.
+func (t *Texture) hash() [sha1.Size]byte {
+	h := sha1.New()
+	binary.Write(h, binary.LittleEndian, [3]uint32{uint32(t.Format), t.Width, t.Height})
+	h.Write(t.Data)
+
+	var out [sha1.Size]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// Get the number of channels for the texture format.
+func (t *Texture) channels() int {
+	switch t.Format {
+	case Luminance8, Luminance32F, SLuminance8:
+		return 1
+	default:
+		return 4
+	}
+}
+
+// Halve the texture dimensions using a box filter.
+func (t *Texture) downscale() {
+	dstW := t.Width / 2
+	if dstW == 0 {
+		dstW = 1
+	}
+	dstH := t.Height / 2
+	if dstH == 0 {
+		dstH = 1
+	}
+
+	channels := t.channels()
+	srcW, srcH := int(t.Width), int(t.Height)
+
+	// Average the source texels covered by each destination texel. The
+	// color channels of sRGB textures are averaged in linear space.
+	var sample func(offset int) float64
+	store := make([]float64, int(dstW*dstH)*channels)
+	switch t.Format {
+	case Luminance8, Rgba8:
+		sample = func(offset int) float64 { return float64(t.Data[offset]) }
+	case SLuminance8, Srgba8:
+		sample = func(offset int) float64 {
+			if isAlphaChannel(offset, channels) {
+				return float64(t.Data[offset])
+			}
+			return 255 * SRGBToLinear(float64(t.Data[offset])/255)
+		}
+	default:
+		// Float data uses the host byte order (see New)
+		sample = func(offset int) float64 {
+			return float64(*(*float32)(unsafe.Pointer(&t.Data[offset<<2])))
+		}
+	}
+
+	for y := 0; y < int(dstH); y++ {
+		y0 := y * srcH / int(dstH)
+		y1 := (y + 1) * srcH / int(dstH)
+		for x := 0; x < int(dstW); x++ {
+			x0 := x * srcW / int(dstW)
+			x1 := (x + 1) * srcW / int(dstW)
+			count := float64((y1 - y0) * (x1 - x0))
+
+			dstOffset := (y*int(dstW) + x) * channels
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					srcOffset := (sy*srcW + sx) * channels
+					for c := 0; c < channels; c++ {
+						store[dstOffset+c] += sample(srcOffset + c)
+					}
+				}
+			}
+			for c := 0; c < channels; c++ {
+				store[dstOffset+c] /= count
+			}
+		}
+	}
+
+	switch t.Format {
+	case Luminance8, Rgba8:
+		data := make([]byte, len(store))
+		for index, v := range store {
+			data[index] = byte(math.Min(255, math.Floor(v+0.5)))
+		}
+		t.Data = data
+	case SLuminance8, Srgba8:
+		data := make([]byte, len(store))
+		for index, v := range store {
+			if !isAlphaChannel(index, channels) {
+				v = 255 * LinearToSRGB(v/255)
+			}
+			data[index] = byte(math.Min(255, math.Floor(v+0.5)))
+		}
+		t.Data = data
+	default:
+		data := make([]byte, len(store)*4)
+		for index, v := range store {
+			*(*float32)(unsafe.Pointer(&data[index<<2])) = float32(v)
+		}
+		t.Data = data
+	}
+
+	t.Width = dstW
+	t.Height = dstH
+}
+
+// Check if the value at the given offset of an interleaved texel buffer
+// belongs to the alpha channel.
+func isAlphaChannel(offset, channels int) bool {
+	return channels == 4 && offset%4 == 3
+}
@@ -0,0 +1,133 @@
+package texture
+
+import (
+	"bytes"
+	"testing"
+	"unsafe"
+
+	"github.com/achilleasa/polaris/asset"
+)
+
+func mockCache(budget int, textures map[string]*Texture) (*Cache, *int) {
+	loads := 0
+	cache := NewCache(budget)
+	cache.loadFn = func(res *asset.Resource) (*Texture, error) {
+		loads++
+		src := textures[res.Path()]
+		return &Texture{
+			Format: src.Format,
+			Width:  src.Width,
+			Height: src.Height,
+			Data:   append([]byte{}, src.Data...),
+		}, nil
+	}
+	return cache, &loads
+}
+
+func mockRes(path string) *asset.Resource {
+	return asset.NewResourceFromStream(path, bytes.NewReader(nil))
+}
+
+func TestCacheSharing(t *testing.T) {
+	solid := &Texture{Format: Rgba8, Width: 1, Height: 1, Data: []byte{1, 2, 3, 4}}
+	cache, loads := mockCache(0, map[string]*Texture{
+		"a.png": solid,
+		"b.png": solid,
+		"c.png": {Format: Rgba8, Width: 1, Height: 1, Data: []byte{4, 3, 2, 1}},
+	})
+
+	a, _ := cache.Load(mockRes("a.png"))
+	a2, _ := cache.Load(mockRes("a.png"))
+	b, _ := cache.Load(mockRes("b.png"))
+	c, _ := cache.Load(mockRes("c.png"))
+
+	if *loads != 3 {
+		t.Fatalf("expected each resource to be loaded once; got %d loads", *loads)
+	}
+	if a != a2 || a != b {
+		t.Fatal("expected textures with identical contents to be shared")
+	}
+	if a == c {
+		t.Fatal("expected textures with different contents not to be shared")
+	}
+	if cache.Len() != 2 || cache.Size() != 8 {
+		t.Fatalf("expected cache to contain 2 textures (8 bytes); got %d textures (%d bytes)", cache.Len(), cache.Size())
+	}
+}
+
+func TestCacheFitBudget(t *testing.T) {
+	cache, _ := mockCache(40, map[string]*Texture{
+		"large.png": {Format: Rgba8, Width: 4, Height: 4, Data: bytes.Repeat([]byte{10, 20, 30, 255}, 16)},
+		"small.png": {Format: Luminance8, Width: 2, Height: 2, Data: []byte{0, 100, 200, 100}},
+	})
+
+	large, _ := cache.Load(mockRes("large.png"))
+	small, _ := cache.Load(mockRes("small.png"))
+
+	report, err := cache.FitBudget()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report) != 1 {
+		t.Fatalf("expected 1 downscaled texture; got %d", len(report))
+	}
+	if report[0].Paths[0] != "large.png" || report[0].FromW != 4 || report[0].ToW != 2 || report[0].ToH != 2 {
+		t.Fatalf("unexpected downscale report: %#+v", report[0])
+	}
+
+	if large.Width != 2 || large.Height != 2 || len(large.Data) != 16 {
+		t.Fatalf("expected large texture to be downscaled to 2x2; got %dx%d (%d bytes)", large.Width, large.Height, len(large.Data))
+	}
+	if !bytes.Equal(large.Data[:4], []byte{10, 20, 30, 255}) {
+		t.Fatalf("expected box filter to preserve solid color; got %v", large.Data[:4])
+	}
+	if small.Width != 2 {
+		t.Fatal("expected small texture to be left untouched")
+	}
+
+	// Budget that cannot be met
+	cache.budget = 4
+	_, err = cache.FitBudget()
+	if err != ErrBudgetExceeded {
+		t.Fatalf("expected to get ErrBudgetExceeded; got %v", err)
+	}
+}
+
+func TestDownscaleFloat(t *testing.T) {
+	tex := &Texture{Format: Luminance32F, Width: 2, Height: 1, Data: make([]byte, 8)}
+	floats := []float32{1.0, 3.0}
+	for index, v := range floats {
+		copy(tex.Data[index*4:], float32Bytes(v))
+	}
+
+	tex.downscale()
+	if tex.Width != 1 || tex.Height != 1 || len(tex.Data) != 4 {
+		t.Fatalf("expected texture to be downscaled to 1x1; got %dx%d", tex.Width, tex.Height)
+	}
+	if !bytes.Equal(tex.Data, float32Bytes(2.0)) {
+		t.Fatalf("expected downscaled value to be 2.0; got %v", tex.Data)
+	}
+}
+
+func float32Bytes(v float32) []byte {
+	out := []float32{v}
+	return (*[4]byte)(unsafe.Pointer(&out[0]))[:]
+}
+
+func TestRemap(t *testing.T) {
+	tex := &Texture{Format: Rgba8, Width: 1, Height: 1, Data: []byte{0, 51, 255, 51}}
+	out := tex.Remap(func(v float64) float64 { return 1 - v })
+	if exp := []byte{255, 204, 0, 51}; !bytes.Equal(out.Data, exp) {
+		t.Fatalf("expected remapped data to be %v; got %v", exp, out.Data)
+	}
+	if exp := []byte{0, 51, 255, 51}; !bytes.Equal(tex.Data, exp) {
+		t.Fatalf("expected source texture to be left unchanged; got %v", tex.Data)
+	}
+
+	floatTex := &Texture{Format: Luminance32F, Width: 1, Height: 1, Data: float32Bytes(0.5)}
+	out = floatTex.Remap(func(v float64) float64 { return v * v })
+	if !bytes.Equal(out.Data, float32Bytes(0.25)) {
+		t.Fatalf("expected remapped float value to be 0.25; got %v", out.Data)
+	}
+}
@@ -0,0 +1,24 @@
+package texture
+
+// Build the mip chain for the texture. The returned slice contains the
+// texture itself followed by copies with successively halved dimensions down
+// to a single texel. Each level is generated from the previous one using a
+// box filter; the texture itself is not modified. The levels of sRGB
+// textures are filtered in linear space.
+func (t *Texture) MipChain() []*Texture {
+	levels := []*Texture{t}
+	for level := t; level.Width > 1 || level.Height > 1; {
+		next := &Texture{
+			Format: level.Format,
+			Width:  level.Width,
+			Height: level.Height,
+			Data:   level.Data,
+		}
+		next.downscale()
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels
+}
@@ -0,0 +1,66 @@
+package texture
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMipChain(t *testing.T) {
+	tex := &Texture{
+		Format: Luminance8,
+		Width:  4,
+		Height: 2,
+		Data: []byte{
+			0, 2, 10, 20,
+			4, 6, 30, 40,
+		},
+	}
+	orig := append([]byte{}, tex.Data...)
+
+	levels := tex.MipChain()
+	expDims := [][2]uint32{{4, 2}, {2, 1}, {1, 1}}
+	if len(levels) != len(expDims) {
+		t.Fatalf("expected mip chain to contain %d levels; got %d", len(expDims), len(levels))
+	}
+	for index, level := range levels {
+		if level.Width != expDims[index][0] || level.Height != expDims[index][1] {
+			t.Errorf("[level %d] expected dims to be %dx%d; got %dx%d", index, expDims[index][0], expDims[index][1], level.Width, level.Height)
+		}
+	}
+
+	if levels[0] != tex || !bytes.Equal(tex.Data, orig) {
+		t.Fatal("expected the first mip level to be the unmodified texture")
+	}
+	if exp := []byte{3, 25}; !bytes.Equal(levels[1].Data, exp) {
+		t.Errorf("expected level 1 data to be %v; got %v", exp, levels[1].Data)
+	}
+	if exp := []byte{14}; !bytes.Equal(levels[2].Data, exp) {
+		t.Errorf("expected level 2 data to be %v; got %v", exp, levels[2].Data)
+	}
+}
+
+func TestMipChainSingleTexel(t *testing.T) {
+	tex := &Texture{Format: Rgba8, Width: 1, Height: 1, Data: []byte{1, 2, 3, 4}}
+	if levels := tex.MipChain(); len(levels) != 1 || levels[0] != tex {
+		t.Fatalf("expected a single mip level for a 1x1 texture; got %d", len(levels))
+	}
+}
+
+func TestMipChainSRGB(t *testing.T) {
+	tex := &Texture{
+		Format: Srgba8,
+		Width:  2,
+		Height: 1,
+		Data:   []byte{0, 0, 0, 255, 255, 255, 255, 0},
+	}
+
+	// Color channels are averaged in linear space (0.5 linear maps to
+	// 188 in sRGB) whereas alpha is averaged as-is
+	levels := tex.MipChain()
+	if exp := []byte{188, 188, 188, 128}; len(levels) != 2 || !bytes.Equal(levels[1].Data, exp) {
+		t.Fatalf("expected level 1 data to be %v; got %v", exp, levels[1].Data)
+	}
+	if levels[1].Format != Srgba8 {
+		t.Fatalf("expected level 1 to retain the sRGB format; got %d", levels[1].Format)
+	}
+}
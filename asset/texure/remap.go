@@ -0,0 +1,53 @@
+package texture
+
+import (
+	"math"
+	"unsafe"
+)
+
+// Create a copy of the texture with fn applied to the value of each color
+// channel; alpha channels are copied as-is. The values passed to fn are
+// linear and normalized to the [0, 1] range for 8-bit formats; the values of
+// sRGB formats are decoded before calling fn and re-encoded afterwards. The
+// texture itself is not modified.
+func (t *Texture) Remap(fn func(v float64) float64) *Texture {
+	out := &Texture{
+		Format: t.Format,
+		Width:  t.Width,
+		Height: t.Height,
+		Data:   make([]byte, len(t.Data)),
+	}
+
+	channels := t.channels()
+	switch t.Format {
+	case Luminance8, Rgba8, SLuminance8, Srgba8:
+		isSRGB := t.Format.ColorSpace() == ColorSpaceSRGB
+		for offset, b := range t.Data {
+			if isAlphaChannel(offset, channels) {
+				out.Data[offset] = b
+				continue
+			}
+
+			v := float64(b) / 255
+			if isSRGB {
+				v = SRGBToLinear(v)
+			}
+			v = math.Max(0, math.Min(1, fn(v)))
+			if isSRGB {
+				v = LinearToSRGB(v)
+			}
+			out.Data[offset] = byte(math.Floor(v*255 + 0.5))
+		}
+	default:
+		// Float data uses the host byte order (see New)
+		for index := 0; index < len(t.Data)>>2; index++ {
+			v := *(*float32)(unsafe.Pointer(&t.Data[index<<2]))
+			if !isAlphaChannel(index, channels) {
+				v = float32(fn(float64(v)))
+			}
+			*(*float32)(unsafe.Pointer(&out.Data[index<<2])) = v
+		}
+	}
+
+	return out
+}
@@ -1,5 +1,10 @@
 package texture
 
+import (
+	"fmt"
+	"math"
+)
+
 type Format uint32
 
 const (
@@ -7,4 +12,100 @@ const (
 	Luminance32F
 	Rgba8
 	Rgba32F
+
+	// 8-bit formats whose color channels are sRGB-encoded. The renderer
+	// converts the texels to linear values when sampling them. The alpha
+	// channel of Srgba8 textures is always linear.
+	SLuminance8
+	Srgba8
+)
+
+// ColorSpace describes how the texel values of a texture are encoded.
+type ColorSpace uint32
+
+const (
+	// Select the color space based on the texture usage and format.
+	ColorSpaceAuto ColorSpace = iota
+
+	// Texel values are linear; used for data textures (e.g. normal and
+	// roughness maps) and HDR images.
+	ColorSpaceLinear
+
+	// Texel values are sRGB-encoded; used for 8-bit color textures.
+	ColorSpaceSRGB
 )
+
+func (cs ColorSpace) String() string {
+	switch cs {
+	case ColorSpaceLinear:
+		return "linear"
+	case ColorSpaceSRGB:
+		return "srgb"
+	}
+	return "auto"
+}
+
+// Lookup a color space by its name.
+func ParseColorSpace(name string) (ColorSpace, error) {
+	switch name {
+	case "auto":
+		return ColorSpaceAuto, nil
+	case "linear":
+		return ColorSpaceLinear, nil
+	case "srgb", "sRGB":
+		return ColorSpaceSRGB, nil
+	}
+	return ColorSpaceAuto, fmt.Errorf("texture: unknown color space %q; supported values are auto, linear and srgb", name)
+}
+
+// Get the color space of the texel values stored using this format.
+func (f Format) ColorSpace() ColorSpace {
+	switch f {
+	case SLuminance8, Srgba8:
+		return ColorSpaceSRGB
+	}
+	return ColorSpaceLinear
+}
+
+// Get the format for storing the same texel data using the given color space.
+// Float formats always store linear values and are returned unchanged.
+func (f Format) WithColorSpace(cs ColorSpace) Format {
+	switch {
+	case cs == ColorSpaceSRGB && f == Luminance8:
+		return SLuminance8
+	case cs == ColorSpaceSRGB && f == Rgba8:
+		return Srgba8
+	case cs == ColorSpaceLinear && f == SLuminance8:
+		return Luminance8
+	case cs == ColorSpaceLinear && f == Srgba8:
+		return Rgba8
+	}
+	return f
+}
+
+// Get the size in bytes of a texel stored using this format.
+func (f Format) TexelSize() int {
+	switch f {
+	case Luminance8, SLuminance8:
+		return 1
+	case Rgba32F:
+		return 16
+	}
+	return 4
+}
+
+// Convert an sRGB-encoded value in the [0, 1] range to a linear value.
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// Convert a linear value in the [0, 1] range to an sRGB-encoded value.
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1.0/2.4) - 0.055
+}
@@ -3,6 +3,7 @@ package texture
 import (
 	"image"
 	"image/png"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -118,3 +119,39 @@ func mockImage(img image.Image) (res *asset.Resource, err error) {
 
 	return asset.NewResource(imgFile, nil)
 }
+
+func TestFormatColorSpace(t *testing.T) {
+	specs := []struct {
+		format Format
+		cs     ColorSpace
+		exp    Format
+	}{
+		{Rgba8, ColorSpaceSRGB, Srgba8},
+		{Luminance8, ColorSpaceSRGB, SLuminance8},
+		{Srgba8, ColorSpaceLinear, Rgba8},
+		{SLuminance8, ColorSpaceLinear, Luminance8},
+		{Rgba32F, ColorSpaceSRGB, Rgba32F},
+		{Luminance32F, ColorSpaceSRGB, Luminance32F},
+		{Rgba8, ColorSpaceAuto, Rgba8},
+	}
+
+	for index, spec := range specs {
+		if got := spec.format.WithColorSpace(spec.cs); got != spec.exp {
+			t.Errorf("[spec %d] expected format %d with color space %s to be %d; got %d", index, spec.format, spec.cs, spec.exp, got)
+		}
+	}
+
+	if Srgba8.ColorSpace() != ColorSpaceSRGB || Rgba32F.ColorSpace() != ColorSpaceLinear {
+		t.Fatal("expected 8-bit sRGB formats to report the sRGB color space and float formats the linear color space")
+	}
+
+	for _, v := range []float64{0, 0.001, 0.2, 0.5, 1} {
+		if got := SRGBToLinear(LinearToSRGB(v)); math.Abs(got-v) > 1e-9 {
+			t.Errorf("expected sRGB conversion of %f to round-trip; got %f", v, got)
+		}
+	}
+
+	if _, err := ParseColorSpace("rec709"); err == nil {
+		t.Fatal("expected an error for an unknown color space")
+	}
+}
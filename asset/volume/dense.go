@@ -0,0 +1,134 @@
+package volume
+
+import (
+	"fmt"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// A dense voxel grid. Voxel centers are located at integer grid coordinates
+// and the voxels are stored in x, y, z order with x varying the fastest.
+type Dense struct {
+	// The grid dimensions in voxels.
+	Dims [3]uint32
+
+	// The voxel values.
+	Data []float32
+
+	// The transformation from world space to the grid coordinate space.
+	WorldToGrid types.Mat4
+}
+
+// Get the value of the voxel at the given grid coordinates.
+func (d *Dense) At(x, y, z int) float32 {
+	return d.Data[(z*int(d.Dims[1])+y)*int(d.Dims[0])+x]
+}
+
+// Convert the active region of the grid into a dense voxel grid that contains
+// at most maxVoxels voxels. If the active region does not fit, the grid is
+// downsampled by the smallest power of 2 factor that satisfies the budget by
+// averaging the voxels that map to each dense voxel. Voxels that are not
+// stored in the tree are set to the grid background value.
+//
+// Dense returns the converted grid and the downsampling factor.
+func (g *Grid) Dense(maxVoxels int) (*Dense, int, error) {
+	var extent [3]int64
+	for axis := range extent {
+		extent[axis] = int64(g.Max[axis]) - int64(g.Min[axis]) + 1
+	}
+
+	factor := int64(1)
+	var dims [3]int64
+	for {
+		// Stop multiplying once the running product exceeds the budget
+		// so that the voxel count of huge grids cannot overflow.
+		numVoxels, fits := int64(1), true
+		for axis := range dims {
+			dims[axis] = (extent[axis] + factor - 1) / factor
+			fits = fits && numVoxels <= int64(maxVoxels)/dims[axis]
+			if fits {
+				numVoxels *= dims[axis]
+			}
+		}
+		if fits {
+			break
+		}
+		if dims[0] == 1 && dims[1] == 1 && dims[2] == 1 {
+			return nil, 0, fmt.Errorf("volume: grid %q cannot fit in a budget of %d voxels", g.Name, maxVoxels)
+		}
+		factor <<= 1
+	}
+
+	dense := &Dense{
+		Dims: [3]uint32{uint32(dims[0]), uint32(dims[1]), uint32(dims[2])},
+		Data: make([]float32, dims[0]*dims[1]*dims[2]),
+	}
+	for index := range dense.Data {
+		dense.Data[index] = g.Background
+	}
+
+	// Each source voxel contributes its difference from the background
+	// weighted by the number of voxels that map to a dense voxel.
+	weight := float32(1.0 / (float64(factor) * float64(factor) * float64(factor)))
+	addTile := func(origin [3]int32, dim int32, value float32) {
+		if value == g.Background {
+			return
+		}
+
+		// Visit the dense voxels that the tile overlaps instead of the
+		// tile voxels so that huge tiles do not dominate the conversion.
+		var from, to [3]int64
+		for axis := range from {
+			from[axis] = maxInt64(int64(origin[axis]), int64(g.Min[axis]))
+			to[axis] = minInt64(int64(origin[axis])+int64(dim)-1, int64(g.Max[axis]))
+			if from[axis] > to[axis] {
+				return
+			}
+		}
+		overlap := func(axis int, cell int64) float32 {
+			cellMin := int64(g.Min[axis]) + cell*factor
+			return float32(minInt64(to[axis], cellMin+factor-1) - maxInt64(from[axis], cellMin) + 1)
+		}
+		contrib := (value - g.Background) * weight
+		for z := (from[2] - int64(g.Min[2])) / factor; z <= (to[2]-int64(g.Min[2]))/factor; z++ {
+			zWeight := contrib * overlap(2, z)
+			for y := (from[1] - int64(g.Min[1])) / factor; y <= (to[1]-int64(g.Min[1]))/factor; y++ {
+				yWeight := zWeight * overlap(1, y)
+				for x := (from[0] - int64(g.Min[0])) / factor; x <= (to[0]-int64(g.Min[0]))/factor; x++ {
+					dense.Data[(z*dims[1]+y)*dims[0]+x] += yWeight * overlap(0, x)
+				}
+			}
+		}
+	}
+
+	g.visitTiles(2, upperNodeLayout, addTile)
+	g.visitTiles(1, lowerNodeLayout, addTile)
+	g.visitLeafVoxels(func(coord [3]int32, value float32) {
+		addTile(coord, 1, value)
+	})
+
+	// Map index space coordinates to dense grid coordinates so that the
+	// center of each dense voxel is located at integer coordinates.
+	center := 0.5 * float32(factor-1)
+	dense.WorldToGrid = types.Scale4(types.XYZ(
+		1.0/float32(factor), 1.0/float32(factor), 1.0/float32(factor),
+	)).Mul4(types.Translate4(types.XYZ(
+		-(float32(g.Min[0]) + center), -(float32(g.Min[1]) + center), -(float32(g.Min[2]) + center),
+	))).Mul4(g.WorldToIndex)
+
+	return dense, int(factor), nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
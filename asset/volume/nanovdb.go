@@ -0,0 +1,308 @@
+package volume
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+var (
+	ErrInvalidFile        = errors.New("volume: invalid NanoVDB file")
+	ErrUnsupportedVersion = errors.New("volume: unsupported NanoVDB version")
+	ErrUnsupportedCodec   = errors.New("volume: compressed NanoVDB grids are not supported")
+	ErrUnsupportedGrid    = errors.New("volume: unsupported NanoVDB grid type")
+	ErrEmptyGrid          = errors.New("volume: grid does not contain any active voxels")
+)
+
+// NanoVDB magic numbers ("NanoVDB0", "NanoVDB1" and "NanoVDB2" in little
+// endian byte order). Files written by older library versions use the legacy
+// magic number for both the file header and the grids.
+const (
+	nanoVDBMagicLegacy uint64 = 0x304244566f6e614e
+	nanoVDBMagicGrid   uint64 = 0x314244566f6e614e
+	nanoVDBMagicFile   uint64 = 0x324244566f6e614e
+
+	// The supported major version of the NanoVDB data layout.
+	nanoVDBMajorVersion = 32
+
+	nanoVDBCodecNone  = 0
+	nanoVDBGridFloat  = 1
+	nanoVDBMaxNameLen = 256
+)
+
+// Sizes and field offsets of the NanoVDB structures used by float grids.
+// All nodes of a tree level are stored back to back so the reader visits
+// them linearly instead of traversing the tree.
+const (
+	sizeofFileHeader   = 16
+	sizeofFileMetaData = 176
+
+	gridOffsetGridSize  = 32
+	gridOffsetName      = 40
+	gridOffsetInvMatD   = 296 + 160
+	gridOffsetVecD      = 296 + 232
+	gridOffsetGridType  = 636
+	sizeofGridData      = 672
+	treeOffsetNodeCount = 32
+	sizeofTreeData      = 64
+
+	rootOffsetBackground = 28
+
+	leafOffsetValues = 96
+	sizeofLeafNode   = 2144
+
+	internalOffsetValueMask = 32
+)
+
+// The layout of the internal NanoVDB tree nodes that store float values.
+type internalNodeLayout struct {
+	// The log2 of the node dimension.
+	log2Dim uint32
+
+	// The dimension of a child node (or tile) in voxels.
+	childDim int32
+
+	// The offset to the tile table and the node size.
+	tableOffset int
+	size        int
+}
+
+var (
+	lowerNodeLayout = internalNodeLayout{log2Dim: 4, childDim: 8, tableOffset: 1088, size: 33856}
+	upperNodeLayout = internalNodeLayout{log2Dim: 5, childDim: 128, tableOffset: 8256, size: 270400}
+)
+
+// The offset to the child mask of an internal node.
+func (l internalNodeLayout) childMaskOffset() int {
+	return internalOffsetValueMask + (1<<(3*l.log2Dim))/8
+}
+
+// A float grid loaded from a NanoVDB file. The grid keeps the sparse tree
+// data of the file; use Dense to convert it into a dense voxel grid.
+type Grid struct {
+	// The grid name.
+	Name string
+
+	// The value of the voxels that are not stored in the tree.
+	Background float32
+
+	// The index space bounding box of the active voxels (inclusive).
+	Min, Max [3]int32
+
+	// The transformation from world space to the grid index space. Voxel
+	// centers are located at integer index space coordinates.
+	WorldToIndex types.Mat4
+
+	// The grid buffer and the offset to its tree.
+	data       []byte
+	treeOffset int
+}
+
+// Read all float grids from a NanoVDB file. Grids with a different value type
+// are skipped. Only uncompressed files are supported.
+func ReadNanoVDB(r io.Reader) ([]*Grid, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var grids []*Grid
+	var numGrids int
+	for offset := 0; offset < len(data); {
+		// Each segment consists of a header, the metadata and name of
+		// each grid and the grid buffers.
+		if len(data)-offset < sizeofFileHeader {
+			return nil, fmt.Errorf("%s: truncated file header", ErrInvalidFile.Error())
+		}
+		header := data[offset:]
+		if magic := binary.LittleEndian.Uint64(header); magic != nanoVDBMagicFile && magic != nanoVDBMagicLegacy {
+			return nil, fmt.Errorf("%s: bad magic number 0x%x", ErrInvalidFile.Error(), magic)
+		}
+		if major := binary.LittleEndian.Uint32(header[8:]) >> 21; major != nanoVDBMajorVersion {
+			return nil, fmt.Errorf("%s: file uses version %d; expected version %d", ErrUnsupportedVersion.Error(), major, nanoVDBMajorVersion)
+		}
+		if codec := binary.LittleEndian.Uint16(header[14:]); codec != nanoVDBCodecNone {
+			return nil, ErrUnsupportedCodec
+		}
+		segmentGrids := int(binary.LittleEndian.Uint16(header[12:]))
+		offset += sizeofFileHeader
+
+		gridSizes := make([]int, segmentGrids)
+		for index := range gridSizes {
+			if len(data)-offset < sizeofFileMetaData {
+				return nil, fmt.Errorf("%s: truncated grid metadata", ErrInvalidFile.Error())
+			}
+			meta := data[offset:]
+			gridSize := binary.LittleEndian.Uint64(meta)
+			nameSize := int(binary.LittleEndian.Uint32(meta[136:]))
+			if gridSize > uint64(len(data)) || nameSize > len(data)-offset-sizeofFileMetaData {
+				return nil, fmt.Errorf("%s: truncated grid metadata", ErrInvalidFile.Error())
+			}
+			gridSizes[index] = int(gridSize)
+			offset += sizeofFileMetaData + nameSize
+		}
+
+		for _, gridSize := range gridSizes {
+			if len(data)-offset < gridSize {
+				return nil, fmt.Errorf("%s: truncated grid data", ErrInvalidFile.Error())
+			}
+			grid, err := parseGrid(data[offset : offset+gridSize])
+			if err != nil && err != ErrUnsupportedGrid {
+				return nil, err
+			}
+			if grid != nil {
+				grids = append(grids, grid)
+			}
+			offset += gridSize
+			numGrids++
+		}
+	}
+
+	if len(grids) == 0 {
+		if numGrids != 0 {
+			return nil, fmt.Errorf("%s: the file does not contain any float grids", ErrUnsupportedGrid.Error())
+		}
+		return nil, fmt.Errorf("%s: the file does not contain any grids", ErrInvalidFile.Error())
+	}
+	return grids, nil
+}
+
+// Parse a grid buffer. Returns ErrUnsupportedGrid if the buffer does not
+// contain a float grid.
+func parseGrid(data []byte) (*Grid, error) {
+	if len(data) < sizeofGridData+sizeofTreeData {
+		return nil, fmt.Errorf("%s: truncated grid", ErrInvalidFile.Error())
+	}
+	if magic := binary.LittleEndian.Uint64(data); magic != nanoVDBMagicGrid && magic != nanoVDBMagicLegacy {
+		return nil, fmt.Errorf("%s: bad grid magic number 0x%x", ErrInvalidFile.Error(), magic)
+	}
+	if gridSize := binary.LittleEndian.Uint64(data[gridOffsetGridSize:]); gridSize != uint64(len(data)) {
+		return nil, fmt.Errorf("%s: grid size %d does not match the metadata size %d", ErrInvalidFile.Error(), gridSize, len(data))
+	}
+	if gridType := binary.LittleEndian.Uint32(data[gridOffsetGridType:]); gridType != nanoVDBGridFloat {
+		return nil, ErrUnsupportedGrid
+	}
+
+	grid := &Grid{
+		Name:       strings.TrimRight(string(data[gridOffsetName:gridOffsetName+nanoVDBMaxNameLen]), "\x00"),
+		data:       data,
+		treeOffset: sizeofGridData,
+	}
+	grid.WorldToIndex = worldToIndex(data)
+
+	// Validate the node ranges so that lookups do not need to check them
+	for level, nodeSize := range []int{sizeofLeafNode, lowerNodeLayout.size, upperNodeLayout.size} {
+		start, count := grid.nodeRange(level)
+		if count != 0 && (start < grid.treeOffset || uint64(start)+uint64(count)*uint64(nodeSize) > uint64(len(data))) {
+			return nil, fmt.Errorf("%s: grid %q: tree nodes exceed the grid buffer", ErrInvalidFile.Error(), grid.Name)
+		}
+	}
+
+	rootOffset := grid.treeOffset + int(binary.LittleEndian.Uint64(data[grid.treeOffset+24:]))
+	if rootOffset < grid.treeOffset || rootOffset > len(data)-rootOffsetBackground-4 {
+		return nil, fmt.Errorf("%s: grid %q: root node exceeds the grid buffer", ErrInvalidFile.Error(), grid.Name)
+	}
+	for axis := 0; axis < 3; axis++ {
+		grid.Min[axis] = int32(binary.LittleEndian.Uint32(data[rootOffset+4*axis:]))
+		grid.Max[axis] = int32(binary.LittleEndian.Uint32(data[rootOffset+12+4*axis:]))
+		if grid.Min[axis] > grid.Max[axis] {
+			return nil, fmt.Errorf("%s: grid %q", ErrEmptyGrid.Error(), grid.Name)
+		}
+	}
+	grid.Background = math.Float32frombits(binary.LittleEndian.Uint32(data[rootOffset+rootOffsetBackground:]))
+
+	return grid, nil
+}
+
+// Build the world to index transformation from the inverse affine map of a
+// grid. The NanoVDB map stores a row-major 3x3 matrix and the translation that
+// is subtracted from world space points before applying the matrix.
+func worldToIndex(data []byte) types.Mat4 {
+	var invMat [9]float64
+	var vec [3]float64
+	for index := range invMat {
+		invMat[index] = math.Float64frombits(binary.LittleEndian.Uint64(data[gridOffsetInvMatD+8*index:]))
+	}
+	for index := range vec {
+		vec[index] = math.Float64frombits(binary.LittleEndian.Uint64(data[gridOffsetVecD+8*index:]))
+	}
+
+	// types.Mat4 uses column-major order
+	m := types.Ident4()
+	for row := 0; row < 3; row++ {
+		var translation float64
+		for col := 0; col < 3; col++ {
+			m[col*4+row] = float32(invMat[row*3+col])
+			translation -= invMat[row*3+col] * vec[col]
+		}
+		m[12+row] = float32(translation)
+	}
+	return m
+}
+
+// Get the byte offset to the first node of a tree level (0: leaf, 1: lower
+// internal and 2: upper internal nodes) and the number of nodes in the level.
+func (g *Grid) nodeRange(level int) (start, count int) {
+	nodeOffset := binary.LittleEndian.Uint64(g.data[g.treeOffset+8*level:])
+	count = int(binary.LittleEndian.Uint32(g.data[g.treeOffset+treeOffsetNodeCount+4*level:]))
+	return g.treeOffset + int(nodeOffset), count
+}
+
+// Visit the tiles of the internal nodes in a tree level and invoke fn with
+// the origin and dimension of each tile and its value. Tiles with child nodes
+// are skipped.
+func (g *Grid) visitTiles(level int, layout internalNodeLayout, fn func(origin [3]int32, dim int32, value float32)) {
+	start, count := g.nodeRange(level)
+	nodeDim := layout.childDim << layout.log2Dim
+	numTiles := 1 << (3 * layout.log2Dim)
+	for nodeIndex := 0; nodeIndex < count; nodeIndex++ {
+		node := g.data[start+nodeIndex*layout.size:]
+		var nodeOrigin [3]int32
+		for axis := range nodeOrigin {
+			nodeOrigin[axis] = int32(binary.LittleEndian.Uint32(node[4*axis:])) &^ (nodeDim - 1)
+		}
+
+		childMask := node[layout.childMaskOffset():]
+		dimMask := (1 << layout.log2Dim) - 1
+		for tile := 0; tile < numTiles; tile++ {
+			if childMask[tile>>3]&(1<<uint(tile&7)) != 0 {
+				continue
+			}
+
+			value := math.Float32frombits(binary.LittleEndian.Uint32(node[layout.tableOffset+8*tile:]))
+			fn([3]int32{
+				nodeOrigin[0] + int32(tile>>(2*layout.log2Dim))*layout.childDim,
+				nodeOrigin[1] + int32((tile>>layout.log2Dim)&dimMask)*layout.childDim,
+				nodeOrigin[2] + int32(tile&dimMask)*layout.childDim,
+			}, layout.childDim, value)
+		}
+	}
+}
+
+// Visit the voxels of all leaf nodes and invoke fn with the index space
+// coordinates and the value of each voxel.
+func (g *Grid) visitLeafVoxels(fn func(coord [3]int32, value float32)) {
+	start, count := g.nodeRange(0)
+	for nodeIndex := 0; nodeIndex < count; nodeIndex++ {
+		node := g.data[start+nodeIndex*sizeofLeafNode:]
+		var origin [3]int32
+		for axis := range origin {
+			origin[axis] = int32(binary.LittleEndian.Uint32(node[4*axis:])) &^ 7
+		}
+
+		for voxel := 0; voxel < 512; voxel++ {
+			value := math.Float32frombits(binary.LittleEndian.Uint32(node[leafOffsetValues+4*voxel:]))
+			fn([3]int32{
+				origin[0] + int32(voxel>>6),
+				origin[1] + int32((voxel>>3)&7),
+				origin[2] + int32(voxel&7),
+			}, value)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package volume
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Build a NanoVDB file with a single float grid whose active voxels span
+// [0, 15] x [0, 7] x [0, 7]. The grid contains a leaf node at the origin with
+// voxel (1, 2, 3) set to 5 and a lower node tile with value 3 that covers the
+// voxels [8, 15] x [0, 7] x [0, 7].
+func mockNanoVDB(name string, codec uint16) []byte {
+	rootOffset := sizeofTreeData
+	upperOffset := rootOffset + 64
+	lowerOffset := upperOffset + upperNodeLayout.size
+	leafOffset := lowerOffset + lowerNodeLayout.size
+	gridSize := sizeofGridData + leafOffset + sizeofLeafNode
+
+	grid := make([]byte, gridSize)
+	le := binary.LittleEndian
+	le.PutUint64(grid, nanoVDBMagicGrid)
+	le.PutUint64(grid[gridOffsetGridSize:], uint64(gridSize))
+	copy(grid[gridOffsetName:], name)
+	le.PutUint32(grid[gridOffsetGridType:], nanoVDBGridFloat)
+
+	// index = 2 * (world - (1, 0, 0))
+	for axis := 0; axis < 3; axis++ {
+		le.PutUint64(grid[gridOffsetInvMatD+8*(axis*3+axis):], math.Float64bits(2))
+	}
+	le.PutUint64(grid[gridOffsetVecD:], math.Float64bits(1))
+
+	tree := grid[sizeofGridData:]
+	for level, offset := range []int{leafOffset, lowerOffset, upperOffset, rootOffset} {
+		le.PutUint64(tree[8*level:], uint64(offset))
+	}
+	for level := 0; level < 3; level++ {
+		le.PutUint32(tree[treeOffsetNodeCount+4*level:], 1)
+	}
+
+	root := tree[rootOffset:]
+	for axis, max := range []uint32{15, 7, 7} {
+		le.PutUint32(root[12+4*axis:], max)
+	}
+
+	upper := tree[upperOffset:]
+	upper[upperNodeLayout.childMaskOffset()] = 1
+
+	lower := tree[lowerOffset:]
+	lower[lowerNodeLayout.childMaskOffset()] = 1
+	le.PutUint32(lower[lowerNodeLayout.tableOffset+8*(1<<8):], math.Float32bits(3))
+
+	leaf := tree[leafOffset:]
+	le.PutUint32(leaf[leafOffsetValues+4*(1<<6|2<<3|3):], math.Float32bits(5))
+
+	var buf bytes.Buffer
+	header := make([]byte, sizeofFileHeader+sizeofFileMetaData)
+	le.PutUint64(header, nanoVDBMagicFile)
+	le.PutUint32(header[8:], nanoVDBMajorVersion<<21)
+	le.PutUint16(header[12:], 1)
+	le.PutUint16(header[14:], codec)
+	le.PutUint64(header[sizeofFileHeader:], uint64(gridSize))
+	le.PutUint32(header[sizeofFileHeader+136:], uint32(len(name)+1))
+	buf.Write(header)
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	buf.Write(grid)
+	return buf.Bytes()
+}
+
+func TestReadNanoVDB(t *testing.T) {
+	grids, err := ReadNanoVDB(bytes.NewReader(mockNanoVDB("density", nanoVDBCodecNone)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grids) != 1 {
+		t.Fatalf("expected 1 grid; got %d", len(grids))
+	}
+
+	grid := grids[0]
+	if grid.Name != "density" {
+		t.Fatalf("expected grid name to be %q; got %q", "density", grid.Name)
+	}
+	if grid.Min != [3]int32{0, 0, 0} || grid.Max != [3]int32{15, 7, 7} {
+		t.Fatalf("expected grid bbox to be [0 0 0]-[15 7 7]; got %v-%v", grid.Min, grid.Max)
+	}
+
+	expIndex := types.XYZW(1, 2, 4, 1)
+	if index := grid.WorldToIndex.Mul4x1(types.XYZW(1.5, 1, 2, 1)); index != expIndex {
+		t.Fatalf("expected world to index transform to map (1.5, 1, 2) to %v; got %v", expIndex, index)
+	}
+}
+
+func TestReadNanoVDBErrors(t *testing.T) {
+	data := mockNanoVDB("density", nanoVDBCodecNone)
+
+	specs := []struct {
+		data   []byte
+		expErr string
+	}{
+		{nil, ErrInvalidFile.Error()},
+		{append([]byte("NotAVDB!"), data[8:]...), ErrInvalidFile.Error()},
+		{data[:len(data)-1], "truncated grid data"},
+		{mockNanoVDB("density", 1), ErrUnsupportedCodec.Error()},
+	}
+
+	for specIndex, spec := range specs {
+		_, err := ReadNanoVDB(bytes.NewReader(spec.data))
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestDense(t *testing.T) {
+	grids, err := ReadNanoVDB(bytes.NewReader(mockNanoVDB("density", nanoVDBCodecNone)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dense, factor, err := grids[0].Dense(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if factor != 1 || dense.Dims != [3]uint32{16, 8, 8} {
+		t.Fatalf("expected a 16x8x8 grid without downsampling; got %v (factor %d)", dense.Dims, factor)
+	}
+	for _, spec := range []struct {
+		x, y, z  int
+		expValue float32
+	}{
+		{0, 0, 0, 0},
+		{1, 2, 3, 5},
+		{7, 7, 7, 0},
+		{8, 0, 0, 3},
+		{15, 7, 7, 3},
+	} {
+		if value := dense.At(spec.x, spec.y, spec.z); value != spec.expValue {
+			t.Errorf("expected voxel (%d, %d, %d) to be %f; got %f", spec.x, spec.y, spec.z, spec.expValue, value)
+		}
+	}
+
+	// Downsample to fit 16*8*8/8 voxels
+	dense, factor, err = grids[0].Dense(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if factor != 2 || dense.Dims != [3]uint32{8, 4, 4} {
+		t.Fatalf("expected a 8x4x4 grid downsampled by 2; got %v (factor %d)", dense.Dims, factor)
+	}
+	if value := dense.At(0, 1, 1); value != 5.0/8.0 {
+		t.Fatalf("expected voxel (0, 1, 1) to be the average of its source voxels; got %f", value)
+	}
+	if value := dense.At(4, 0, 0); value != 3 {
+		t.Fatalf("expected voxel (4, 0, 0) to be 3; got %f", value)
+	}
+
+	// World space (2.5, 0.25, 0.25) maps to index space (3, 0.5, 0.5)
+	expCoord := types.XYZW(1.25, 0, 0, 1)
+	if coord := dense.WorldToGrid.Mul4x1(types.XYZW(2.5, 0.25, 0.25, 1)); coord != expCoord {
+		t.Fatalf("expected world to grid transform to map (2.5, 0.25, 0.25) to %v; got %v", expCoord, coord)
+	}
+
+	if _, _, err = grids[0].Dense(0); err == nil {
+		t.Fatal("expected an error when the budget cannot fit a single voxel")
+	}
+}
+
+func TestDenseHugeGrid(t *testing.T) {
+	grids, err := ReadNanoVDB(bytes.NewReader(mockNanoVDB("density", nanoVDBCodecNone)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An extent of 2^32 voxels per axis overflows the voxel count of the
+	// grid bounding box.
+	grid := grids[0]
+	grid.Min = [3]int32{math.MinInt32, math.MinInt32, math.MinInt32}
+	grid.Max = [3]int32{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+
+	dense, factor, err := grid.Dense(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numVoxels := len(dense.Data); factor != 1<<26 || numVoxels != 64*64*64 {
+		t.Fatalf("expected a 64x64x64 grid downsampled by 2^26; got %v (factor %d)", dense.Dims, factor)
+	}
+}
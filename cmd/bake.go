@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"errors"
+	"image"
+	"image/png"
+	"os"
+
+	"github.com/achilleasa/polaris/asset/bake"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/asset/scene/writer"
+	"github.com/urfave/cli"
+)
+
+// Bake ambient occlusion and/or curvature maps for a scene mesh instance.
+func BakeMaps(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() != 1 {
+		return errors.New("missing scene file argument")
+	}
+
+	aoFile, curvatureFile := ctx.String("ao"), ctx.String("curvature")
+	if aoFile == "" && curvatureFile == "" {
+		return errors.New("at least one of the ao and curvature output files must be specified")
+	}
+
+	sc, err := reader.ReadScene(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	opts := bake.Options{
+		Width:             uint32(ctx.Int("size")),
+		Height:            uint32(ctx.Int("size")),
+		Samples:           ctx.Int("samples"),
+		MaxDistance:       float32(ctx.Float64("max-distance")),
+		CageOffset:        float32(ctx.Float64("cage-offset")),
+		SelfOcclusionOnly: ctx.Bool("self-only"),
+		Dilation:          ctx.Int("dilation"),
+	}
+
+	instance := ctx.Int("instance")
+	hasUVs, err := bake.HasUVs(sc, instance)
+	if err != nil {
+		return err
+	}
+	if !hasUVs || ctx.Bool("unwrap") {
+		opts.ChartPadding = ctx.Int("chart-padding")
+		numCharts, err := bake.GenerateLightmapUVs(sc, instance, opts)
+		if err != nil {
+			return err
+		}
+		logger.Noticef("generated %d lightmap uv chart(s) for mesh instance %d", numCharts, instance)
+
+		if unwrapFile := ctx.String("unwrap-out"); unwrapFile != "" {
+			err = writer.WriteScene(sc, unwrapFile)
+			if err != nil {
+				return err
+			}
+			logger.Noticef("wrote scene with generated uvs to %q", unwrapFile)
+		}
+	}
+
+	for _, out := range []struct {
+		file string
+		name string
+		bake func(*scene.Scene, int, bake.Options) (*image.Gray, error)
+	}{
+		{aoFile, "ambient occlusion", bake.AmbientOcclusion},
+		{curvatureFile, "curvature", bake.Curvature},
+	} {
+		if out.file == "" {
+			continue
+		}
+
+		logger.Noticef("baking %s map for mesh instance %d", out.name, instance)
+		im, err := out.bake(sc, instance, opts)
+		if err != nil {
+			return err
+		}
+
+		err = writeBakedMap(out.file, im)
+		if err != nil {
+			return err
+		}
+		logger.Noticef("wrote %s map to %q", out.name, out.file)
+	}
+
+	return nil
+}
+
+// Save a baked map or debug image as a PNG image.
+func writeBakedMap(imgFile string, im image.Image) error {
+	f, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, im)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"image/png"
+	"os"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/urfave/cli"
+)
+
+// Render a contact sheet with multiple views of a scene.
+func RenderContactSheet(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() != 1 {
+		return errors.New("missing scene file argument")
+	}
+
+	opts := renderer.ContactSheetOptions{
+		TileW:              uint32(ctx.Int("width")),
+		TileH:              uint32(ctx.Int("height")),
+		Columns:            ctx.Int("columns"),
+		Labels:             ctx.Bool("labels"),
+		SamplesPerPixel:    uint32(ctx.Int("spp")),
+		NumBounces:         uint32(ctx.Int("num-bounces")),
+		Exposure:           float32(ctx.Float64("exposure")),
+		BlackListedDevices: ctx.StringSlice("blacklist"),
+		ForcePrimaryDevice: ctx.String("force-primary"),
+		ShareDevices:       ctx.Bool("share"),
+	}
+
+	for _, name := range ctx.StringSlice("view") {
+		view, err := scene.ParseViewAngle(name)
+		if err != nil {
+			return err
+		}
+		opts.Views = append(opts.Views, view)
+	}
+
+	sc, err := reader.ReadScene(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	im, err := renderer.RenderContactSheet(sc, opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(ctx.String("out"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = png.Encode(f, im)
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("wrote contact sheet to %q", ctx.String("out"))
+	return nil
+}
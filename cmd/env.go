@@ -0,0 +1,20 @@
+package cmd
+
+// Environment variables that provide the values of the corresponding command
+// line flags. They allow containerized and render farm deployments to
+// configure polaris without changing the command line of each job; flags that
+// are specified on the command line take precedence.
+const (
+	// A comma-separated list of values for the blacklist flag. Opencl
+	// devices whose names contain any of the values are not used.
+	DeviceBlacklistEnvVar = "POLARIS_BLACKLIST"
+
+	// The value of the force-primary flag.
+	ForcePrimaryEnvVar = "POLARIS_FORCE_PRIMARY"
+
+	// The value of the debug-dir flag.
+	DebugDirEnvVar = "POLARIS_DEBUG_DIR"
+
+	// The value of the log-level flag.
+	LogLevelEnvVar = "POLARIS_LOG_LEVEL"
+)
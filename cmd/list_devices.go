@@ -28,7 +28,11 @@ func ListDevices(ctx *cli.Context) error {
 
 	for _, platformInfo := range clPlatforms {
 		for _, dev := range platformInfo.Devices {
-			table.Append([]string{dev.Name, dev.Type.String(), fmt.Sprintf("%d GFlops", dev.Speed), platformInfo.Name, platformInfo.Version})
+			devType := dev.Type.String()
+			if dev.IsSoftware() {
+				devType = fmt.Sprintf("%s (%s)", devType, dev.Impl.String())
+			}
+			table.Append([]string{dev.Name, devType, fmt.Sprintf("%d GFlops", dev.Speed), platformInfo.Name, platformInfo.Version})
 		}
 	}
 	table.Render()
@@ -7,12 +7,34 @@ import (
 
 var logger = log.New("polaris")
 
+// Set the logger verbosity. The log-level flag (or the POLARIS_LOG_LEVEL env
+// var) selects the verbosity unless the v or vv flags are specified.
 func setupLogging(ctx *cli.Context) {
-	if ctx.GlobalBool("v") {
-		log.SetLevel(log.Info)
+	level, err := selectLogLevel(ctx.GlobalString("log-level"), ctx.GlobalBool("v"), ctx.GlobalBool("vv"))
+	if err != nil {
+		logger.Warningf("ignoring log-level setting: %v", err)
 	}
 
-	if ctx.GlobalBool("vv") {
-		log.SetLevel(log.Debug)
+	log.SetLevel(level)
+}
+
+// Select the logger verbosity given the log-level setting and the values of
+// the v and vv flags. The flags take precedence over the log-level setting.
+// If the log-level setting is invalid, the selected level ignores it and the
+// parse error is returned.
+func selectLogLevel(levelName string, verbose, veryVerbose bool) (log.Level, error) {
+	level := log.Notice
+	var err error
+	if levelName != "" {
+		level, err = log.ParseLevel(levelName)
 	}
+
+	switch {
+	case veryVerbose:
+		level = log.Debug
+	case verbose:
+		level = log.Info
+	}
+
+	return level, err
 }
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/achilleasa/polaris/log"
+)
+
+func TestSelectLogLevel(t *testing.T) {
+	specs := []struct {
+		levelName   string
+		verbose     bool
+		veryVerbose bool
+		expLevel    log.Level
+		expErr      bool
+	}{
+		{"", false, false, log.Notice, false},
+		{"warning", false, false, log.Warning, false},
+		// The v and vv flags take precedence over the log-level setting
+		{"error", true, false, log.Info, false},
+		{"error", false, true, log.Debug, false},
+		{"info", true, true, log.Debug, false},
+		{"", true, false, log.Info, false},
+		// Invalid settings are ignored
+		{"loud", false, false, log.Notice, true},
+		{"loud", true, false, log.Info, true},
+	}
+
+	for specIndex, spec := range specs {
+		level, err := selectLogLevel(spec.levelName, spec.verbose, spec.veryVerbose)
+		if spec.expErr != (err != nil) || (err != nil && !errors.Is(err, log.ErrUnknownLevel)) {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+		}
+		if level != spec.expLevel {
+			t.Errorf("[spec %d] expected level %d; got %d", specIndex, spec.expLevel, level)
+		}
+	}
+}
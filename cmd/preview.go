@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"errors"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/urfave/cli"
+)
+
+// Render a preview of a material expression on the built-in material ball scene.
+func RenderMaterialPreview(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() == 0 {
+		return errors.New("missing material expression argument")
+	}
+
+	opts := renderer.PreviewOptions{
+		FrameW:             uint32(ctx.Int("width")),
+		FrameH:             uint32(ctx.Int("height")),
+		SamplesPerPixel:    uint32(ctx.Int("spp")),
+		NumBounces:         uint32(ctx.Int("num-bounces")),
+		BlackListedDevices: ctx.StringSlice("blacklist"),
+		ForcePrimaryDevice: ctx.String("force-primary"),
+		ShareDevices:       ctx.Bool("share"),
+	}
+
+	im, err := renderer.RenderMaterialPreview(strings.Join(ctx.Args(), " "), opts)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(ctx.String("out"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = png.Encode(f, im)
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("wrote material preview to %q", ctx.String("out"))
+	return nil
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/control"
+	"github.com/olekukonko/tablewriter"
+	"github.com/urfave/cli"
+)
+
+// Add one or more render jobs to a persistent job queue.
+func QueueJobs(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() == 0 {
+		return errors.New("missing scene file argument")
+	}
+
+	store, err := control.NewBoltStore(ctx.String("db"))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for index, sceneFile := range ctx.Args() {
+		info, err := control.EnqueueJob(store, &control.SubmitArgs{
+			SceneFile:   sceneFile,
+			Output:      jobOutput(ctx.String("out"), index, ctx.NArg()),
+			Width:       uint32(ctx.Int("width")),
+			Height:      uint32(ctx.Int("height")),
+			Spp:         uint32(ctx.Int("spp")),
+			NumBounces:  uint32(ctx.Int("num-bounces")),
+			RRBounces:   uint32(ctx.Int("rr-bounces")),
+			Exposure:    float32(ctx.Float64("exposure")),
+			Camera:      ctx.String("camera"),
+			SppSchedule: ctx.String("spp-schedule"),
+		})
+		if err != nil {
+			return err
+		}
+
+		logger.Noticef("queued job %d for scene %q", info.Id, sceneFile)
+	}
+
+	return nil
+}
+
+// Get the output filename for a queued job. When queuing multiple jobs, the
+// base name of each output file is prefixed with the job index.
+func jobOutput(output string, index, numJobs int) string {
+	if numJobs < 2 {
+		return output
+	}
+	return filepath.Join(filepath.Dir(output), fmt.Sprintf("%03d-%s", index, filepath.Base(output)))
+}
+
+// List the contents of a persistent job queue.
+func ListJobs(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	store, err := control.NewBoltStore(ctx.String("db"))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	jobs, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAutoFormatHeaders(false)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Id", "Scene", "Output", "State", "Samples", "Submitted", "Render time", "Error"})
+	for _, info := range jobs {
+		table.Append([]string{
+			fmt.Sprintf("%d", info.Id),
+			info.SceneFile,
+			info.Output,
+			string(info.State),
+			fmt.Sprintf("%d/%d", info.Samples, info.Options.SamplesPerPixel),
+			info.SubmittedAt.Format(time.RFC3339),
+			fmt.Sprintf("%s", info.RenderTime),
+			info.Error,
+		})
+	}
+	table.Render()
+
+	logger.Noticef("job queue contains %d job(s)\n%s", len(jobs), buf.String())
+	return nil
+}
+
+// Process all pending jobs in a persistent job queue and exit.
+func RunJobs(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	store, err := control.NewBoltStore(ctx.String("db"))
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	srv, err := control.NewServer(reader.ReadScene, jobRendererFactory(ctx), store)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	srv.WaitForIdle()
+	return nil
+}
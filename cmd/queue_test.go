@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJobOutput(t *testing.T) {
+	specs := []struct {
+		output  string
+		index   int
+		numJobs int
+		exp     string
+	}{
+		{"frame.png", 0, 1, "frame.png"},
+		{"renders/frame.png", 0, 1, "renders/frame.png"},
+		{"frame.png", 2, 3, "002-frame.png"},
+		{"renders/frame.png", 1, 3, filepath.Join("renders", "001-frame.png")},
+		{"/tmp/renders/frame.png", 12, 20, filepath.Join("/tmp/renders", "012-frame.png")},
+	}
+
+	for specIndex, spec := range specs {
+		if got := jobOutput(spec.output, spec.index, spec.numJobs); got != spec.exp {
+			t.Errorf("[spec %d] expected output to be %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
@@ -2,13 +2,31 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/asset/scene/reader"
 	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/shm"
 	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/network"
 	"github.com/achilleasa/polaris/tracer/opencl"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli"
@@ -32,11 +50,39 @@ func RenderFrame(ctx *cli.Context) error {
 		FrameH:          uint32(ctx.Int("height")),
 		SamplesPerPixel: uint32(ctx.Int("spp")),
 		Exposure:        float32(ctx.Float64("exposure")),
+		Seed:            ctx.Int64("seed"),
 		NumBounces:      uint32(ctx.Int("num-bounces")),
 		MinBouncesForRR: uint32(ctx.Int("rr-bounces")),
 		//
 		BlackListedDevices: ctx.StringSlice("blacklist"),
 		ForcePrimaryDevice: ctx.String("force-primary"),
+		ShareDevices:       ctx.Bool("share"),
+		TraversalStackSize: uint32(ctx.Int("bvh-stack-size")),
+		CompressedBvh:      ctx.Bool("compressed-bvh"),
+		RayBudget:          uint64(ctx.Int64("ray-budget")),
+		TimeBudget:         time.Duration(ctx.Float64("time-budget") * float64(time.Millisecond)),
+	}
+
+	var err error
+	opts.Schedule, err = renderer.ParseSampleSchedule(ctx.String("spp-schedule"))
+	if err != nil {
+		return err
+	}
+
+	opts.DeviceBuildOptions, err = deviceBuildOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	preset, err := selectedPreset(ctx)
+	if err != nil {
+		return err
+	}
+	opts = applyPreset(ctx, preset, opts)
+
+	opts.LightGroupScales, err = lightGroupScales(ctx)
+	if err != nil {
+		return err
 	}
 
 	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
@@ -44,6 +90,11 @@ func RenderFrame(ctx *cli.Context) error {
 		opts.MinBouncesForRR = opts.NumBounces + 1
 	}
 
+	scheduler, err := blockScheduler(ctx.String("scheduler"))
+	if err != nil {
+		return err
+	}
+
 	// Load scene
 	if ctx.NArg() != 1 {
 		return errors.New("missing scene file argument")
@@ -54,21 +105,80 @@ func RenderFrame(ctx *cli.Context) error {
 		return err
 	}
 
-	// Update projection matrix
-	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
+	err = selectCamera(ctx, sc)
+	if err != nil {
+		return err
+	}
+
+	opts, err = applyBookmark(ctx, sc, opts)
+	if err != nil {
+		return err
+	}
+
+	// Update projection matrix and adjust the frame dims to include any
+	// overscan area
+	opts.FrameW, opts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+
+	if workers := ctx.StringSlice("remote"); len(workers) != 0 {
+		if ctx.Bool("cpu") {
+			return errors.New("the cpu and remote flags cannot be combined; use a cpu worker instead")
+		}
+		return renderFrameOnWorkers(ctx, sc, workers, scheduler, preset, opts)
+	} else if ctx.Bool("cpu") {
+		return renderFrameOnCPU(ctx, sc, scheduler, preset, opts)
+	}
 
 	// Setup tracing pipeline
-	pipeline := opencl.DefaultPipeline(opencl.NoDebug)
-	pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveFrameBuffer(ctx.String("out")))
+	pipelineOpts, err := pipelineOptions(ctx, preset)
+	if err != nil {
+		return err
+	}
+	pipeline := opencl.DefaultPipeline(pipelineOpts...)
+	pipeline.CompensatedAccumulation = ctx.Bool("compensated-sum")
+	pipeline.TextureStreamingBudget = uint64(ctx.Int("texture-streaming")) << 20
+	if debugDir := ctx.String("debug-dir"); debugDir != "" {
+		pipeline.DebugSink = &opencl.FileDebugSink{Dir: debugDir}
+	}
+	if rayFile := ctx.String("camera-rays"); rayFile != "" {
+		rays, err := readCameraRays(rayFile, opts.FrameW, opts.FrameH)
+		if err != nil {
+			return err
+		}
+		pipeline.PrimaryRayGenerator = opencl.RayBufferCamera(rays)
+	}
+	imgOpts, err := imageOptions(ctx, preset)
+	if err != nil {
+		return err
+	}
+	pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveFrameBufferWithOptions(ctx.String("out"), imgOpts))
+	if ctx.String("aov-samples") != "" || ctx.String("aov-error") != "" {
+		pipeline.CollectSampleStats = true
+		pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveSampleStats(ctx.String("aov-samples"), ctx.String("aov-error"), pipelineOpts...))
+	}
+	pipeline.LightPathExpressions, err = lightPathExpressions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pipeline.LightPathExpressions) != 0 {
+		pipeline.PostProcess = append(pipeline.PostProcess, opencl.SaveLightPathPasses(ctx.String("lpe-out")))
+	}
+	pipeline.LightGroups = ctx.Bool("light-groups") || len(opts.LightGroupScales) != 0
+	seg, err := setupFrameSegment(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	if seg != nil {
+		defer seg.Close()
+	}
 
 	// Create renderer
-	r, err := renderer.NewDefault(sc, tracer.NaiveScheduler(), pipeline, opts)
+	r, err := renderer.NewDefault(sc, scheduler, pipeline, opts)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
-	err = r.Render()
+	err = renderSamples(r, opts)
 	if err != nil {
 		return err
 	}
@@ -79,22 +189,676 @@ func RenderFrame(ctx *cli.Context) error {
 	return err
 }
 
+// Render a still frame using the pure-Go cpu tracer instead of the opencl
+// devices. The cpu tracer implements the default opencl pipeline so flags that
+// enable other pipeline features are rejected.
+func renderFrameOnCPU(ctx *cli.Context, sc *scene.Scene, scheduler tracer.BlockScheduler, preset *renderer.Preset, opts renderer.Options) error {
+	err := checkDefaultPipelineFlags(ctx, "the cpu tracer")
+	if err != nil {
+		return err
+	}
+	if preset != nil {
+		logger.Noticef("the cpu tracer ignores the pixel filter, sample clamp, first-hit cache, shadow and debug settings of the %q preset", preset.Name)
+	}
+
+	imgOpts, err := imageOptions(ctx, preset)
+	if err != nil {
+		return err
+	}
+
+	imgFile := ctx.String("out")
+	tracerOpts := []cpu.TracerOption{
+		cpu.WithPostProcess(func(tr tracer.Tracer, blockReq *tracer.BlockRequest) error {
+			return opencl.WriteFrame(tr, blockReq, imgFile, imgOpts)
+		}),
+	}
+	if numWorkers := ctx.Int("cpu-workers"); numWorkers > 0 {
+		tracerOpts = append(tracerOpts, cpu.WithWorkers(numWorkers))
+	}
+	if opts.Seed != 0 {
+		tracerOpts = append(tracerOpts, cpu.WithSeed(opts.Seed))
+	}
+	if ctx.Bool("compensated-sum") {
+		tracerOpts = append(tracerOpts, cpu.WithCompensatedAccumulation())
+	}
+	if opts.CompressedBvh {
+		tracerOpts = append(tracerOpts, cpu.WithCompressedBvh())
+	}
+
+	tr, err := cpu.NewTracer("cpu", tracerOpts...)
+	if err != nil {
+		return err
+	}
+
+	r, err := renderer.NewWithTracers(sc, scheduler, []tracer.Tracer{tr}, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = renderSamples(r, opts)
+	if err != nil {
+		return err
+	}
+
+	displayFrameStats(r.Stats())
+	return nil
+}
+
+// Render a still frame using the tracers exposed by a list of remote workers.
+// Workers render using the default opencl pipeline so flags that enable other
+// pipeline features are rejected.
+func renderFrameOnWorkers(ctx *cli.Context, sc *scene.Scene, workers []string, scheduler tracer.BlockScheduler, preset *renderer.Preset, opts renderer.Options) error {
+	err := checkDefaultPipelineFlags(ctx, "remote workers")
+	if err != nil {
+		return err
+	}
+	if ctx.Bool("compensated-sum") {
+		return errors.New("remote workers do not support the compensated-sum flag")
+	}
+	if preset != nil {
+		logger.Noticef("remote workers ignore the pixel filter, sample clamp, first-hit cache, shadow and debug settings of the %q preset", preset.Name)
+	}
+
+	imgOpts, err := imageOptions(ctx, preset)
+	if err != nil {
+		return err
+	}
+
+	imgFile := ctx.String("out")
+	saveFrame := network.WithPostProcess(func(tr tracer.Tracer, blockReq *tracer.BlockRequest) error {
+		return opencl.WriteFrame(tr, blockReq, imgFile, imgOpts)
+	})
+
+	var tracers []tracer.Tracer
+	for _, addr := range workers {
+		remoteTracers, err := network.Dial(addr, saveFrame)
+		if err != nil {
+			for _, tr := range tracers {
+				tr.Close()
+			}
+			return fmt.Errorf("could not connect to worker %q: %v", addr, err)
+		}
+		tracers = append(tracers, remoteTracers...)
+	}
+
+	r, err := renderer.NewWithTracers(sc, scheduler, tracers, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = renderSamples(r, opts)
+	if err != nil {
+		return err
+	}
+
+	displayFrameStats(r.Stats())
+	return nil
+}
+
+// Check that the command line flags do not enable opencl pipeline features
+// that are not supported when rendering with the default pipeline settings
+// (e.g. by the cpu tracer or remote workers).
+func checkDefaultPipelineFlags(ctx *cli.Context, tracerName string) error {
+	var unsupported []string
+	for _, name := range []string{"camera-rays", "aov-samples", "aov-error", "shm"} {
+		if ctx.String(name) != "" {
+			unsupported = append(unsupported, name)
+		}
+	}
+	for _, name := range []string{"lpe", "light-scale", "cl-define", "cl-option"} {
+		if len(ctx.StringSlice(name)) != 0 {
+			unsupported = append(unsupported, name)
+		}
+	}
+	for _, name := range []string{"first-hit-cache", "ao-preview", "direct-only", "light-groups"} {
+		if ctx.Bool(name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	for _, name := range []string{"light-path-length", "bvh-stack-size", "texture-streaming"} {
+		if ctx.Int(name) != 0 {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if ctx.Float64("clamp-direct") != 0 || ctx.Float64("clamp-indirect") != 0 {
+		unsupported = append(unsupported, "clamp-direct/clamp-indirect")
+	}
+	if filter := ctx.String("pixel-filter"); filter != "" && filter != opencl.TentFilter.String() {
+		unsupported = append(unsupported, "pixel-filter")
+	}
+	if correction := ctx.String("normal-correction"); correction != "" && correction != opencl.NoNormalCorrection.String() {
+		unsupported = append(unsupported, "normal-correction")
+	}
+	if filter := ctx.String("texture-filter"); filter != "" && filter != opencl.RayDifferentialTextureFilter.String() {
+		unsupported = append(unsupported, "texture-filter")
+	}
+	if sampler := ctx.String("sampler"); sampler != "" && sampler != opencl.SobolSampler.String() {
+		unsupported = append(unsupported, "sampler")
+	}
+	if shadows := ctx.String("shadows"); shadows != "" && shadows != opencl.TracedShadows.String() {
+		unsupported = append(unsupported, "shadows")
+	}
+	if palette := ctx.String("debug-palette"); palette != "" && palette != opencl.GrayDebugPalette.String() {
+		unsupported = append(unsupported, "debug-palette")
+	}
+
+	if len(unsupported) != 0 {
+		return fmt.Errorf("the following flags are not supported by %s: %s", tracerName, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Create the block scheduler for the algorithm specified via the scheduler
+// flag.
+func blockScheduler(schedulerType string) (tracer.BlockScheduler, error) {
+	var scheduler tracer.BlockScheduler
+	switch schedulerType {
+	case "naive":
+		scheduler = tracer.NaiveScheduler()
+	case "perfect":
+		scheduler = tracer.PerfectScheduler()
+	case "dynamic":
+		scheduler = tracer.DynamicScheduler()
+	default:
+		return nil, fmt.Errorf("invalid scheduler algorithm %q; supported algorithms: naive, perfect, dynamic", schedulerType)
+	}
+	logger.Noticef("using %q block scheduler", schedulerType)
+	return scheduler, nil
+}
+
+// Render all requested samples in a single pass or, if a sample schedule is
+// specified, using multiple accumulation passes. Rendering is aborted if the
+// process receives an interrupt signal.
+func renderSamples(r renderer.Renderer, opts renderer.Options) error {
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if !opts.Schedule.Enabled() || opts.SamplesPerPixel == 0 {
+		return interruptedError(ctx, r.RenderContext(ctx))
+	}
+
+	for r.AccumulatedSamples() < opts.SamplesPerPixel {
+		err := r.AccumulateContext(ctx)
+		if err != nil {
+			return interruptedError(ctx, err)
+		}
+		logger.Infof("collected %d/%d samples per pixel", r.AccumulatedSamples(), opts.SamplesPerPixel)
+	}
+
+	return nil
+}
+
+// Get a context that is cancelled when the process receives an interrupt or
+// termination signal. Only the first signal is intercepted so sending a second
+// one terminates the process if rendering cannot be aborted in time. The
+// returned function stops listening for signals.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigChan:
+			signal.Stop(sigChan)
+			logger.Warningf("interrupted; aborting render after the running pipeline stages complete")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigChan)
+		cancel()
+	}
+}
+
+// Report render errors caused by a cancelled context as interruptions.
+func interruptedError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return renderer.ErrInterrupted
+	}
+	return err
+}
+
+// Read the primary rays for each frame pixel from a binary file. The file
+// stores 8 little-endian float32 values for each pixel in row-major order: the
+// ray origin, the ray cone spread angle and the ray direction followed by a
+// padding value.
+func readCameraRays(rayFile string, frameW, frameH uint32) ([]opencl.CameraRay, error) {
+	data, err := ioutil.ReadFile(rayFile)
+	if err != nil {
+		return nil, err
+	}
+
+	rays := make([]opencl.CameraRay, frameW*frameH)
+	if len(data) != len(rays)*32 {
+		return nil, fmt.Errorf("camera ray file %q should contain %d rays for a %dx%d frame; got %d bytes", rayFile, len(rays), frameW, frameH, len(data))
+	}
+
+	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, rays)
+	if err != nil {
+		return nil, err
+	}
+	return rays, nil
+}
+
+// Parse the light path expressions specified via the lpe flag. Each
+// expression is specified as name=expression.
+func lightPathExpressions(ctx *cli.Context) ([]*opencl.LightPathExpression, error) {
+	var exprs []*opencl.LightPathExpression
+	for _, spec := range ctx.StringSlice("lpe") {
+		tokens := strings.SplitN(spec, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid light path expression %q; expected name=expression", spec)
+		}
+
+		expr, err := opencl.ParseLightPathExpression(tokens[0], tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+
+	if len(exprs) > opencl.MaxLightPathExpressions {
+		return nil, opencl.ErrTooManyLightPathExpressions
+	}
+	return exprs, nil
+}
+
+// Parse the light group intensity multipliers specified via the light-scale
+// flag. Each multiplier is specified as group=scale.
+func lightGroupScales(ctx *cli.Context) ([]float32, error) {
+	var scales []float32
+	for _, spec := range ctx.StringSlice("light-scale") {
+		tokens := strings.SplitN(spec, "=", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid light group scale %q; expected group=scale", spec)
+		}
+
+		group, err := strconv.Atoi(tokens[0])
+		if err != nil || group < 0 || group >= opencl.MaxLightGroups {
+			return nil, fmt.Errorf("invalid light group %q; expected a value in the [0, %d] range", tokens[0], opencl.MaxLightGroups-1)
+		}
+		scale, err := strconv.ParseFloat(tokens[1], 32)
+		if err != nil || scale < 0 {
+			return nil, fmt.Errorf("invalid light group scale %q; expected a non-negative value", tokens[1])
+		}
+
+		for len(scales) <= group {
+			scales = append(scales, 1.0)
+		}
+		scales[group] = float32(scale)
+	}
+	return scales, nil
+}
+
+// Lookup the preset selected via the preset flag. Returns nil if no preset
+// is selected.
+func selectedPreset(ctx *cli.Context) (*renderer.Preset, error) {
+	name := ctx.String("preset")
+	if name == "" {
+		return nil, nil
+	}
+
+	preset, err := renderer.LookupPreset(name)
+	if err != nil {
+		return nil, err
+	}
+	logger.Noticef("using %q preset: %s", preset.Name, preset.Description)
+	return &preset, nil
+}
+
+// Apply the sampling and integrator settings of a preset to the renderer
+// options. Settings that are explicitly specified via command line flags take
+// precedence over the preset values.
+func applyPreset(ctx *cli.Context, preset *renderer.Preset, opts renderer.Options) renderer.Options {
+	if preset == nil {
+		return opts
+	}
+
+	presetOpts := preset.Apply(opts)
+	if !ctx.IsSet("spp") {
+		opts.SamplesPerPixel = presetOpts.SamplesPerPixel
+	}
+	if !ctx.IsSet("spp-schedule") {
+		opts.Schedule = presetOpts.Schedule
+	}
+	if !ctx.IsSet("num-bounces") {
+		opts.NumBounces = presetOpts.NumBounces
+	}
+	if !ctx.IsSet("rr-bounces") {
+		opts.MinBouncesForRR = preset.MinBouncesForRR
+	}
+	return opts
+}
+
+// Build the list of pipeline options from the command line flags. If a preset
+// is selected, its settings are used for any flags that are not explicitly
+// specified.
+func pipelineOptions(ctx *cli.Context, preset *renderer.Preset) ([]opencl.PipelineOption, error) {
+	filter, err := opencl.ParsePixelFilter(ctx.String("pixel-filter"))
+	if err != nil {
+		return nil, err
+	}
+
+	normalCorrection, err := opencl.ParseNormalCorrection(ctx.String("normal-correction"))
+	if err != nil {
+		return nil, err
+	}
+
+	textureFilter, err := opencl.ParseTextureFilter(ctx.String("texture-filter"))
+	if err != nil {
+		return nil, err
+	}
+
+	debugPalette, err := opencl.ParseDebugPalette(ctx.String("debug-palette"))
+	if err != nil {
+		return nil, err
+	}
+
+	rayOrder, err := opencl.ParseRayOrder(ctx.String("ray-order"))
+	if err != nil {
+		return nil, err
+	}
+
+	sampler, err := opencl.ParseSampler(ctx.String("sampler"))
+	if err != nil {
+		return nil, err
+	}
+
+	shadowMode, err := opencl.ParseShadowMode(ctx.String("shadows"))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []opencl.PipelineOption{
+		opencl.WithNormalCorrection(normalCorrection),
+		opencl.WithTextureFilter(textureFilter),
+		opencl.WithRayOrder(rayOrder),
+		opencl.WithSampler(sampler),
+	}
+	if preset != nil {
+		opts = append(opts, preset.PipelineOptions()...)
+	}
+	if preset == nil || ctx.IsSet("debug-palette") {
+		opts = append(opts, opencl.WithDebugPalette(debugPalette))
+	}
+	if preset == nil || ctx.IsSet("pixel-filter") {
+		opts = append(opts, opencl.WithPixelFilter(filter))
+	}
+	if preset == nil || ctx.IsSet("shadows") {
+		opts = append(opts, opencl.WithShadowMode(shadowMode))
+	}
+	if preset == nil || ctx.IsSet("clamp-direct") || ctx.IsSet("clamp-indirect") {
+		clamp := opencl.SampleClamp{
+			Direct:   float32(ctx.Float64("clamp-direct")),
+			Indirect: float32(ctx.Float64("clamp-indirect")),
+		}
+		if preset != nil && !ctx.IsSet("clamp-direct") {
+			clamp.Direct = preset.SampleClamp.Direct
+		}
+		if preset != nil && !ctx.IsSet("clamp-indirect") {
+			clamp.Indirect = preset.SampleClamp.Indirect
+		}
+		opts = append(opts, opencl.WithSampleClamp(clamp))
+	}
+	if ctx.Bool("first-hit-cache") {
+		opts = append(opts, opencl.WithFirstHitCache())
+	}
+	if lightPathLength := ctx.Int("light-path-length"); lightPathLength < 0 {
+		return nil, fmt.Errorf("invalid light path length %d; the length must not be negative", lightPathLength)
+	} else if lightPathLength > 0 {
+		opts = append(opts, opencl.WithLightPathLength(uint32(lightPathLength)))
+	}
+	if ctx.Bool("ao-preview") {
+		opts = append(opts, opencl.WithAmbientOcclusion(float32(ctx.Float64("ao-distance"))))
+	}
+	if ctx.Bool("direct-only") {
+		opts = append(opts, opencl.WithDirectLighting())
+	}
+
+	return opts, nil
+}
+
+// Build the per-device kernel build options from the cl-define and cl-option
+// command line flags. Each flag value may be prefixed by "DEVICE:" to limit
+// it to devices whose names contain DEVICE.
+func deviceBuildOptions(ctx *cli.Context) ([]renderer.DeviceBuildOptions, error) {
+	var list []renderer.DeviceBuildOptions
+	entryFor := func(deviceName string) *renderer.DeviceBuildOptions {
+		for index := range list {
+			if list[index].Device == deviceName {
+				return &list[index]
+			}
+		}
+		list = append(list, renderer.DeviceBuildOptions{Device: deviceName})
+		return &list[len(list)-1]
+	}
+
+	for _, spec := range ctx.StringSlice("cl-define") {
+		deviceName, define := splitDeviceSpec(spec)
+		name, value := define, ""
+		if eqIndex := strings.Index(define, "="); eqIndex != -1 {
+			name, value = define[:eqIndex], define[eqIndex+1:]
+		}
+
+		entry := entryFor(deviceName)
+		if entry.Defines == nil {
+			entry.Defines = make(map[string]string)
+		}
+		entry.Defines[name] = value
+	}
+
+	for _, spec := range ctx.StringSlice("cl-option") {
+		deviceName, flag := splitDeviceSpec(spec)
+		entry := entryFor(deviceName)
+		entry.Flags = append(entry.Flags, flag)
+	}
+
+	for _, entry := range list {
+		if err := entry.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Device-specific defines should override the ones for all devices
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Device == "" && list[j].Device != ""
+	})
+	return list, nil
+}
+
+// Split a "[DEVICE:]VALUE" flag value into its device and value parts. The
+// device part is only recognized if it appears before any "=" character so
+// that define values may contain colons.
+func splitDeviceSpec(spec string) (deviceName, value string) {
+	colonIndex := strings.Index(spec, ":")
+	if colonIndex == -1 {
+		return "", spec
+	}
+	if eqIndex := strings.Index(spec, "="); eqIndex != -1 && eqIndex < colonIndex {
+		return "", spec
+	}
+	return spec[:colonIndex], spec[colonIndex+1:]
+}
+
+// Build the options for saving the rendered frame from the command line flags.
+// The output format is selected based on the extension of the output file.
+// If a preset is selected and the bit depth is not explicitly specified, the
+// preset bit depth is used for integer formats that support 16-bit channels.
+func imageOptions(ctx *cli.Context, preset *renderer.Preset) (opencl.ImageOptions, error) {
+	format, err := opencl.ImageFormatFromFilename(ctx.String("out"))
+	if err != nil {
+		return opencl.ImageOptions{}, err
+	}
+
+	if bitDepth := ctx.Int("bit-depth"); bitDepth != 8 && bitDepth != 16 {
+		return opencl.ImageOptions{}, fmt.Errorf("unsupported bit depth %d; supported values are 8 and 16", bitDepth)
+	}
+
+	opts := opencl.ImageOptions{
+		Format:  format,
+		Depth16: ctx.Int("bit-depth") == 16,
+		Float:   ctx.Bool("tiff-float"),
+		DPI:     float32(ctx.Float64("dpi")),
+	}
+	if format == opencl.JPEGFormat {
+		opts.JPEGQuality = ctx.Int("jpeg-quality")
+	}
+	if preset != nil && !ctx.IsSet("bit-depth") {
+		opts.Depth16 = preset.Depth16 && !opts.Float && format != opencl.JPEGFormat && format != opencl.EXRFormat
+	}
+
+	opts.Overlay, err = frameOverlay(ctx)
+	if err != nil {
+		return opencl.ImageOptions{}, err
+	}
+
+	if err = opts.Validate(); err != nil {
+		return opencl.ImageOptions{}, fmt.Errorf("%s: check the output options for the %s format", err.Error(), format)
+	}
+
+	return opts, nil
+}
+
+// Build the burn-in overlay for the rendered frame from the command line flags.
+// Returns nil if neither burn-in text nor a watermark image are specified.
+func frameOverlay(ctx *cli.Context) (*opencl.Overlay, error) {
+	if ctx.String("burn-in") == "" && ctx.String("watermark") == "" {
+		return nil, nil
+	}
+
+	sceneFile := filepath.Base(ctx.Args().First())
+	overlay := &opencl.Overlay{
+		Text:             strings.Replace(ctx.String("burn-in"), `\n`, "\n", -1),
+		SceneName:        strings.TrimSuffix(sceneFile, filepath.Ext(sceneFile)),
+		Frame:            ctx.Int("frame-number"),
+		WatermarkOpacity: float32(ctx.Float64("watermark-opacity")),
+	}
+
+	var err error
+	overlay.TextPosition, err = opencl.ParseOverlayPosition(ctx.String("burn-in-position"))
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.String("watermark") == "" {
+		return overlay, nil
+	}
+
+	if overlay.WatermarkOpacity < 0 || overlay.WatermarkOpacity > 1 {
+		return nil, errors.New("watermark opacity must be in the [0, 1] range")
+	}
+
+	overlay.WatermarkPosition, err = opencl.ParseOverlayPosition(ctx.String("watermark-position"))
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(ctx.String("watermark"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	overlay.Watermark, _, err = image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode watermark image %q: %s", ctx.String("watermark"), err.Error())
+	}
+
+	return overlay, nil
+}
+
+// Select the active scene camera if the camera option is specified.
+func selectCamera(ctx *cli.Context, sc *scene.Scene) error {
+	name := ctx.String("camera")
+	if name == "" {
+		return nil
+	}
+
+	err := sc.SelectCamera(name)
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("using scene camera %q", name)
+	return nil
+}
+
+// Get the bookmark file for the scene. Unless overridden by the bookmarks
+// option, bookmarks are stored in a sidecar file next to the scene file.
+func bookmarkFile(ctx *cli.Context) string {
+	if bmFile := ctx.String("bookmarks"); bmFile != "" {
+		return bmFile
+	}
+	return renderer.BookmarkFile(ctx.Args().First())
+}
+
+// Restore the camera and render settings from a saved bookmark if the
+// bookmark option is specified.
+func applyBookmark(ctx *cli.Context, sc *scene.Scene, opts renderer.Options) (renderer.Options, error) {
+	name := ctx.String("bookmark")
+	if name == "" {
+		return opts, nil
+	}
+
+	bookmarks, err := renderer.LoadBookmarks(bookmarkFile(ctx))
+	if err != nil {
+		return opts, err
+	}
+
+	bm, err := bookmarks.Get(name)
+	if err != nil {
+		return opts, err
+	}
+
+	logger.Noticef("restoring bookmark %q from %q", name, bookmarks.Path())
+	return bm.Apply(sc.Camera, opts), nil
+}
+
+// Create a shared memory frame segment if the shm option is specified and
+// append a frame publishing stage to the pipeline.
+func setupFrameSegment(ctx *cli.Context, pipeline *opencl.Pipeline, opts renderer.Options) (*shm.Segment, error) {
+	segFile := ctx.String("shm")
+	if segFile == "" {
+		return nil, nil
+	}
+
+	seg, err := shm.Create(segFile, opts.FrameW, opts.FrameH)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Noticef("publishing frames to %q", segFile)
+	pipeline.PostProcess = append(pipeline.PostProcess, opencl.PublishFrameBuffer(seg))
+	return seg, nil
+}
+
 func displayFrameStats(stats renderer.FrameStats) {
 	var buf bytes.Buffer
 	table := tablewriter.NewWriter(&buf)
 	table.SetAutoFormatHeaders(false)
 	table.SetAutoWrapText(false)
-	table.SetHeader([]string{"Device", "Primary", "Block height", "% of frame", "Render time"})
+	table.SetHeader([]string{"Device", "Primary", "Block height", "% of frame", "Thermals", "Stack overflows", "Render time"})
 	for _, stat := range stats.Tracers {
+		thermals := stat.Thermals.String()
+		if stat.Throttled {
+			thermals += " (throttled)"
+		}
 		table.Append([]string{
 			stat.Id,
 			fmt.Sprintf("%t", stat.IsPrimary),
 			fmt.Sprintf("%d", stat.BlockH),
 			fmt.Sprintf("%02.1f %%", stat.FramePercent),
+			thermals,
+			fmt.Sprintf("%d", stat.StackOverflows),
 			fmt.Sprintf("%s", stat.RenderTime),
 		})
 	}
-	table.SetFooter([]string{"", "", "", "TOTAL", fmt.Sprintf("%s", stats.RenderTime)})
+	table.SetFooter([]string{"", "", "", "", "", "TOTAL", fmt.Sprintf("%s", stats.RenderTime)})
 
 	table.Render()
 	logger.Noticef("frame statistics\n%s", buf.String())
@@ -110,11 +874,42 @@ func RenderInteractive(ctx *cli.Context) error {
 		FrameH:          uint32(ctx.Int("height")),
 		SamplesPerPixel: uint32(ctx.Int("spp")),
 		Exposure:        float32(ctx.Float64("exposure")),
+		Seed:            ctx.Int64("seed"),
 		NumBounces:      uint32(ctx.Int("num-bounces")),
 		MinBouncesForRR: uint32(ctx.Int("rr-bounces")),
+		HistoryFrames:   ctx.Int("history"),
+		HistoryFile:     ctx.String("history-file"),
+		ResizableWindow: ctx.Bool("resizable"),
 		//
 		BlackListedDevices: ctx.StringSlice("blacklist"),
 		ForcePrimaryDevice: ctx.String("force-primary"),
+		ShareDevices:       ctx.Bool("share"),
+		TraversalStackSize: uint32(ctx.Int("bvh-stack-size")),
+		CompressedBvh:      ctx.Bool("compressed-bvh"),
+		RayBudget:          uint64(ctx.Int64("ray-budget")),
+		TimeBudget:         time.Duration(ctx.Float64("time-budget") * float64(time.Millisecond)),
+	}
+
+	var err error
+	opts.Schedule, err = renderer.ParseSampleSchedule(ctx.String("spp-schedule"))
+	if err != nil {
+		return err
+	}
+
+	opts.DeviceBuildOptions, err = deviceBuildOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	preset, err := selectedPreset(ctx)
+	if err != nil {
+		return err
+	}
+	opts = applyPreset(ctx, preset, opts)
+
+	opts.LightGroupScales, err = lightGroupScales(ctx)
+	if err != nil {
+		return err
 	}
 
 	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
@@ -123,17 +918,10 @@ func RenderInteractive(ctx *cli.Context) error {
 	}
 
 	// Setup block scheduler
-	schedulerType := ctx.String("scheduler")
-	var scheduler tracer.BlockScheduler
-	switch schedulerType {
-	case "naive":
-		scheduler = tracer.NaiveScheduler()
-	case "perfect":
-		scheduler = tracer.PerfectScheduler()
-	default:
-		return fmt.Errorf("invalid scheduler algorithm %q; supported algorithms: naive, perfect", schedulerType)
+	scheduler, err := blockScheduler(ctx.String("scheduler"))
+	if err != nil {
+		return err
 	}
-	logger.Noticef("using %q block scheduler", schedulerType)
 
 	// Load scene
 	if ctx.NArg() != 1 {
@@ -145,13 +933,46 @@ func RenderInteractive(ctx *cli.Context) error {
 		return err
 	}
 
+	err = selectCamera(ctx, sc)
+	if err != nil {
+		return err
+	}
+
+	opts, err = applyBookmark(ctx, sc, opts)
+	if err != nil {
+		return err
+	}
+
+	if parallaxScale := float32(ctx.Float64("parallax-preview")); parallaxScale > 0 {
+		count := sc.SetParallaxScale(parallaxScale)
+		logger.Noticef("enabled parallax preview for %d bump map material nodes (scale: %.3f)", count, parallaxScale)
+	}
+
 	// Due to the way that gl.TexSubImage2D works we need to
 	// generate a mirrored image of the frame buffer.
 	sc.Camera.InvertY = true
-	sc.Camera.SetupProjection(float32(opts.FrameW) / float32(opts.FrameH))
+	opts.FrameW, opts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+	opts.BookmarkFile = bookmarkFile(ctx)
 
 	// Setup tracing pipeline
-	pipeline := opencl.DefaultPipeline(opencl.NoDebug)
+	pipelineOpts, err := pipelineOptions(ctx, preset)
+	if err != nil {
+		return err
+	}
+	pipeline := opencl.DefaultPipeline(pipelineOpts...)
+	pipeline.CompensatedAccumulation = ctx.Bool("compensated-sum")
+	pipeline.TextureStreamingBudget = uint64(ctx.Int("texture-streaming")) << 20
+	pipeline.LightGroups = ctx.Bool("light-groups") || len(opts.LightGroupScales) != 0
+	if debugDir := ctx.String("debug-dir"); debugDir != "" {
+		pipeline.DebugSink = &opencl.FileDebugSink{Dir: debugDir}
+	}
+	seg, err := setupFrameSegment(ctx, pipeline, opts)
+	if err != nil {
+		return err
+	}
+	if seg != nil {
+		defer seg.Close()
+	}
 
 	// Create renderer
 	r, err := renderer.NewInteractive(sc, scheduler, pipeline, opts)
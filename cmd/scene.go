@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/asset/scene/reader"
 	"github.com/achilleasa/polaris/asset/scene/writer"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/tracer/opencl"
 	"github.com/urfave/cli"
 )
 
@@ -13,52 +24,242 @@ import (
 func CompileScene(ctx *cli.Context) error {
 	setupLogging(ctx)
 
+	colorSpace, err := texture.ParseColorSpace(ctx.String("texture-colorspace"))
+	if err != nil {
+		return err
+	}
+	roughness, err := material.ParseRoughnessConvention(ctx.String("roughness-convention"))
+	if err != nil {
+		return err
+	}
+	missingMaterial, err := compiler.ParseMissingMaterialPolicy(ctx.String("missing-material"))
+	if err != nil {
+		return err
+	}
+
 	for idx := 0; idx < ctx.NArg(); idx++ {
 		sceneFile := ctx.Args().Get(idx)
-		if !strings.HasSuffix(sceneFile, ".obj") {
+		ext := filepath.Ext(sceneFile)
+		if ext != ".obj" && ext != ".gltf" && ext != ".glb" {
 			logger.Warning("skipping unsupported file %s", sceneFile)
 			continue
 		}
+		basePath := strings.TrimSuffix(sceneFile, ext)
 
 		logger.Noticef("parsing and compiling scene: %s", sceneFile)
-		sc, err := reader.ReadScene(sceneFile)
+		opts := reader.DefaultOptions
+		opts.TextureBudget = ctx.Int("texture-budget") << 20
+		opts.TextureColorSpace = colorSpace
+		opts.RoughnessConvention = roughness
+		opts.Strict = ctx.Bool("strict")
+		opts.MaxShadingNormalAngle = float32(ctx.Float64("max-normal-angle"))
+		opts.MissingMaterialPolicy = missingMaterial
+		if ctx.Bool("fix-normals") {
+			opts.ShadingNormalFix = compiler.ClampShadingNormals
+		}
+		sc, report, err := reader.ReadSceneWithReport(sceneFile, opts)
 		if err != nil {
 			return err
 		}
 
+		if len(report.Warnings) > 0 {
+			logger.Warningf("scene compiled with %d warning(s):", len(report.Warnings))
+			for _, w := range report.Warnings {
+				logger.Warning(w.String())
+			}
+		}
+
+		if len(report.Conversions) > 0 {
+			logger.Noticef("approximated or dropped %d material properties:\n%s", len(report.Conversions), report.ConversionTable())
+		}
+
 		// Display compiled scene info
 		logger.Noticef("scene information:\n%s", sc.Stats())
 
-		zipFile := strings.Replace(sceneFile, ".obj", ".zip", -1)
+		zipFile := basePath + ".zip"
 		err = writer.WriteScene(sc, zipFile)
 		if err != nil {
 			return err
 		}
+
+		if ctx.Bool("conversion-report") {
+			err = writeConversionReport(basePath+"-conversions.json", report.Conversions)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-// Display compiled scene info.
+// Scenes with textures exceeding this size should be recompiled with a
+// texture budget.
+const largeTextureDataSize = 512 << 20
+
+// Load a scene and display its statistics together with any validation
+// issues and suggested render settings.
 func ShowSceneInfo(ctx *cli.Context) error {
 	setupLogging(ctx)
 
 	if ctx.NArg() != 1 {
-		return errors.New("missing compiled scene zip file")
+		return errors.New("missing scene file argument")
+	}
+
+	sc, err := reader.ReadScene(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	summary := sc.Summary()
+	logger.Noticef("scene information:\n%s", sc.Stats())
+	logger.Noticef("scene statistics:\n%s", summary.String())
+
+	if len(summary.Issues) > 0 {
+		logger.Warningf("detected %d scene issue(s):", len(summary.Issues))
+		for _, issue := range summary.Issues {
+			logger.Warning(issue)
+		}
+	}
+
+	for _, suggestion := range suggestSettings(summary) {
+		logger.Noticef("suggestion: %s", suggestion)
+	}
+
+	return nil
+}
+
+// Suggest render and compile settings based on the scene statistics.
+func suggestSettings(s *scene.Summary) []string {
+	var suggestions []string
+	switch {
+	case s.AreaLights == 0 && s.EnvironmentLights == 0:
+		suggestions = append(suggestions, "the scene does not define any light sources; add an emissive material or an environment light")
+	case s.AreaLights > 0 && s.EnvironmentLights == 0:
+		suggestions = append(suggestions, fmt.Sprintf("the scene is only lit by area lights; use --light-path-length %d to enable bidirectional path tracing", opencl.DefaultLightPathLength))
+	}
+
+	// Scenes whose BVH depth exceeds the traversal stack size render with
+	// missing geometry.
+	if s.MaxBvhDepth > opencl.DefaultTraversalStackSize {
+		suggestions = append(suggestions, fmt.Sprintf("the BVH depth (%d) exceeds the default traversal stack size (%d); render using --bvh-stack-size %d", s.MaxBvhDepth, opencl.DefaultTraversalStackSize, s.MaxBvhDepth+1))
+	}
+
+	if s.TextureBytes > largeTextureDataSize {
+		suggestions = append(suggestions, fmt.Sprintf("the scene textures use %d mb; recompile the scene using --texture-budget or render using --texture-streaming to reduce device memory usage", s.TextureBytes>>20))
+	}
+
+	return suggestions
+}
+
+// Export the scene geometry and BVH for inspection in an external viewer.
+func InspectScene(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() != 1 {
+		return errors.New("missing scene file argument")
 	}
 
-	sceneFile := ctx.Args().First()
-	if !strings.HasSuffix(sceneFile, ".zip") {
-		return errors.New("only compiled scene files with a .zip extension are supported")
+	objFile, jsonFile := ctx.String("obj"), ctx.String("json")
+	if objFile == "" && jsonFile == "" {
+		return errors.New("at least one of the obj and json output files must be specified")
 	}
 
-	sc, err := reader.ReadScene(sceneFile)
+	sc, err := reader.ReadScene(ctx.Args().First())
 	if err != nil {
 		return err
 	}
 
-	// Display compiled scene info
-	logger.Noticef("scene information:\n%s", sc.Stats())
+	in := sc.InspectBvh()
+	for _, tree := range in.Trees {
+		logger.Infof(
+			"%s BVH: %d nodes, %d leafs, max depth %d, max/avg primitives per leaf %d/%.1f",
+			tree.Name, tree.Stats.Nodes, tree.Stats.Leaves, tree.Stats.MaxDepth, tree.Stats.MaxLeafPrimitives, tree.Stats.AvgLeafPrimitives,
+		)
+	}
+	if len(in.Issues) > 0 {
+		logger.Warningf("detected %d BVH issue(s):", len(in.Issues))
+		for _, issue := range in.Issues {
+			logger.Warning(issue)
+		}
+	}
+
+	if jsonFile != "" {
+		err = writeInspectionFile(jsonFile, in.WriteJSON)
+		if err != nil {
+			return err
+		}
+	}
+
+	if objFile != "" {
+		opts := scene.OBJExportOptions{
+			SkipGeometry: ctx.Bool("no-geometry"),
+			MaxBoxDepth:  ctx.Int("max-depth"),
+		}
+		err = writeInspectionFile(objFile, func(f io.Writer) error {
+			return sc.ExportOBJ(f, in, opts)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Report the differences between two compiled scenes.
+func DiffScenes(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() != 2 {
+		return errors.New("diff expects two scene file arguments")
+	}
+
+	var scenes [2]*scene.Scene
+	for index := range scenes {
+		sc, err := reader.ReadScene(ctx.Args().Get(index))
+		if err != nil {
+			return err
+		}
+		scenes[index] = sc
+	}
+
+	d := scene.Diff(scenes[0], scenes[1])
+	if d.Empty() {
+		logger.Notice("scenes are identical")
+	} else {
+		logger.Noticef("found %d difference(s):\n%s", len(d.Entries), d)
+	}
+
+	if jsonFile := ctx.String("json"); jsonFile != "" {
+		return writeInspectionFile(jsonFile, d.WriteJSON)
+	}
 
 	return nil
 }
+
+// Create a file and populate it using the supplied write function.
+func writeInspectionFile(file string, writeFn func(io.Writer) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("writing scene inspection data to %q", file)
+	err = writeFn(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Write the list of material conversions to a JSON file.
+func writeConversionReport(reportFile string, conversions []compiler.MaterialConversion) error {
+	data, err := json.MarshalIndent(conversions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("writing material conversion report to %q", reportFile)
+	return ioutil.WriteFile(reportFile, data, 0644)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+)
+
+// Render a scene using multiple seeds and report per-pixel variance statistics.
+func RenderSeedSweep(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if ctx.NArg() != 1 {
+		return errors.New("missing scene file argument")
+	}
+
+	filter, err := opencl.ParsePixelFilter(ctx.String("pixel-filter"))
+	if err != nil {
+		return err
+	}
+	palette, err := opencl.ParseDebugPalette(ctx.String("debug-palette"))
+	if err != nil {
+		return err
+	}
+
+	opts := renderer.SeedSweepOptions{
+		FrameW:             uint32(ctx.Int("width")),
+		FrameH:             uint32(ctx.Int("height")),
+		SamplesPerPixel:    uint32(ctx.Int("spp")),
+		NumBounces:         uint32(ctx.Int("num-bounces")),
+		MinBouncesForRR:    uint32(ctx.Int("rr-bounces")),
+		NumSeeds:           ctx.Int("seeds"),
+		FirstSeed:          ctx.Int64("first-seed"),
+		Pipeline:           opencl.DefaultPipeline(opencl.WithPixelFilter(filter)),
+		BlackListedDevices: ctx.StringSlice("blacklist"),
+		ForcePrimaryDevice: ctx.String("force-primary"),
+		ShareDevices:       ctx.Bool("share"),
+	}
+
+	sc, err := reader.ReadScene(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+
+	logger.Noticef("rendering %d seeds at %d spp", opts.NumSeeds, opts.SamplesPerPixel)
+	sweep, err := renderer.RenderSeedSweep(sc, opts)
+	if err != nil {
+		return err
+	}
+
+	summary := sweep.Summary()
+	if ctx.Bool("json") {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		logger.Noticef("variance statistics:\n%s", summary.String())
+	}
+
+	heatmaps := []struct {
+		file   string
+		values []float32
+	}{
+		{ctx.String("variance-out"), sweep.Variance},
+		{ctx.String("relative-variance-out"), sweep.RelativeVariance()},
+	}
+	for _, heatmap := range heatmaps {
+		if heatmap.file == "" {
+			continue
+		}
+
+		im := opencl.DebugHeatmap(heatmap.values, int(sweep.FrameW), int(sweep.FrameH), opencl.LogDebugMapping, palette)
+		err = writeBakedMap(heatmap.file, im)
+		if err != nil {
+			return err
+		}
+		logger.Noticef("wrote variance heatmap to %q", heatmap.file)
+	}
+
+	return nil
+}
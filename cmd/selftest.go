@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+)
+
+const (
+	// This env var is set when the selftest command re-executes itself under oclgrind.
+	selftestOclgrindEnvVar = "POLARIS_SELFTEST_OCLGRIND"
+)
+
+var (
+	// The oclgrind options used for running the selftest. Data race
+	// detection is not enabled as it slows down kernel execution by
+	// several orders of magnitude.
+	oclgrindArgs = []string{"--check-api", "--uninitialized"}
+
+	// Line prefixes used by oclgrind when reporting errors.
+	oclgrindErrorPrefixes = []string{
+		"Invalid read",
+		"Invalid write",
+		"Uninitialized value",
+		"Oclgrind - ",
+		"OCLGRIND FATAL ERROR",
+	}
+)
+
+// Render a small built-in scene to verify that the rendering kernels work
+// as expected. If oclgrind is available, the command re-executes itself under
+// oclgrind and reports any invalid memory accesses or uninitialized reads
+// detected while the kernels execute.
+func SelfTest(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	if os.Getenv(selftestOclgrindEnvVar) != "" || ctx.Bool("no-oclgrind") {
+		return runSelfTest(ctx)
+	}
+
+	oclgrindPath, err := exec.LookPath(ctx.String("oclgrind"))
+	if err != nil {
+		logger.Warningf("could not find oclgrind (%s); running selftest without kernel memory checks", err.Error())
+		return runSelfTest(ctx)
+	}
+
+	return runSelfTestUnderOclgrind(oclgrindPath)
+}
+
+// Re-execute the selftest command under oclgrind and scan its output for errors.
+func runSelfTestUnderOclgrind(oclgrindPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("selftest: could not detect path to polaris binary: %s", err.Error())
+	}
+
+	logger.Noticef("running selftest under %q", oclgrindPath)
+
+	var errOutput bytes.Buffer
+	args := make([]string, 0, len(oclgrindArgs)+len(os.Args))
+	args = append(args, oclgrindArgs...)
+	args = append(args, exe)
+	args = append(args, os.Args[1:]...)
+	cmd := exec.Command(oclgrindPath, args...)
+	cmd.Env = append(os.Environ(), selftestOclgrindEnvVar+"=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, &errOutput)
+	runErr := cmd.Run()
+
+	numErrors, kernels := parseOclgrindOutput(&errOutput)
+	if numErrors != 0 {
+		return fmt.Errorf("selftest: oclgrind reported %d error(s) in kernel(s): %s", numErrors, strings.Join(kernels, ", "))
+	}
+	if runErr != nil {
+		return fmt.Errorf("selftest: %s", runErr.Error())
+	}
+
+	logger.Notice("selftest completed; oclgrind did not report any errors")
+	return nil
+}
+
+// Scan oclgrind output and return the number of reported errors and the
+// names of the kernels that triggered them.
+func parseOclgrindOutput(r io.Reader) (int, []string) {
+	var numErrors int
+	var inError bool
+	kernelSet := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			inError = false
+			continue
+		}
+
+		for _, prefix := range oclgrindErrorPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				numErrors++
+				inError = true
+				break
+			}
+		}
+
+		if inError && strings.HasPrefix(line, "Kernel:") {
+			kernelSet[strings.TrimSpace(strings.TrimPrefix(line, "Kernel:"))] = struct{}{}
+		}
+	}
+
+	kernels := make([]string, 0, len(kernelSet))
+	for kernel := range kernelSet {
+		kernels = append(kernels, kernel)
+	}
+	sort.Strings(kernels)
+
+	return numErrors, kernels
+}
+
+// Render the built-in selftest scene using the available devices.
+func runSelfTest(ctx *cli.Context) error {
+	sc, err := testscenes.Compile(testscenes.CornellBox())
+	if err != nil {
+		return err
+	}
+
+	opts := renderer.Options{
+		FrameW:          uint32(ctx.Int("width")),
+		FrameH:          uint32(ctx.Int("height")),
+		SamplesPerPixel: uint32(ctx.Int("spp")),
+		Exposure:        1.0,
+		NumBounces:      uint32(ctx.Int("num-bounces")),
+	}
+	opts.MinBouncesForRR = opts.NumBounces + 1
+	opts.FrameW, opts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+
+	// Also collect sample statistics so that the respective kernels are
+	// exercised by the selftest.
+	pipeline := opencl.DefaultPipeline()
+	pipeline.CollectSampleStats = true
+
+	r, err := renderer.NewDefault(sc, tracer.NaiveScheduler(), pipeline, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = r.Render()
+	if err != nil {
+		return fmt.Errorf("selftest: %s", err.Error())
+	}
+
+	displayFrameStats(r.Stats())
+	return nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/reader"
+	"github.com/achilleasa/polaris/control"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/urfave/cli"
+)
+
+// Run a control server that renders jobs submitted via JSON-RPC.
+func Serve(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	var store control.JobStore
+	if dbFile := ctx.String("db"); dbFile != "" {
+		boltStore, err := control.NewBoltStore(dbFile)
+		if err != nil {
+			return err
+		}
+		defer boltStore.Close()
+		store = boltStore
+	}
+
+	srv, err := control.NewServer(reader.ReadScene, jobRendererFactory(ctx), store)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+	srv.SetRootDirs(ctx.String("scene-dir"), ctx.String("output-dir"))
+
+	return srv.ListenAndServe(ctx.String("listen"))
+}
+
+// Create a renderer factory for processing jobs using the device selection
+// options specified by the user.
+func jobRendererFactory(ctx *cli.Context) control.RendererFactory {
+	blackList := ctx.StringSlice("blacklist")
+	forcePrimary := ctx.String("force-primary")
+	shareDevices := ctx.Bool("share")
+
+	return func(sc *scene.Scene, opts renderer.Options) (renderer.Renderer, error) {
+		opts.BlackListedDevices = blackList
+		opts.ForcePrimaryDevice = forcePrimary
+		opts.ShareDevices = shareDevices
+		return renderer.NewDefault(sc, tracer.NaiveScheduler(), opencl.DefaultPipeline(), opts)
+	}
+}
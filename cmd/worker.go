@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+	"github.com/achilleasa/polaris/tracer/network"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/urfave/cli"
+)
+
+// Run a render worker that exposes the local tracers to remote renderers.
+func Worker(ctx *cli.Context) error {
+	setupLogging(ctx)
+
+	var tracers []tracer.Tracer
+	if ctx.Bool("cpu") {
+		var tracerOpts []cpu.TracerOption
+		if numWorkers := ctx.Int("cpu-workers"); numWorkers > 0 {
+			tracerOpts = append(tracerOpts, cpu.WithWorkers(numWorkers))
+		}
+		tr, err := cpu.NewTracer("cpu", tracerOpts...)
+		if err != nil {
+			return err
+		}
+		tracers = append(tracers, tr)
+	} else {
+		var locks []*device.Lock
+		var err error
+		tracers, locks, err = workerDeviceTracers(ctx)
+		defer func() {
+			for _, lock := range locks {
+				lock.Release()
+			}
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tr := range tracers {
+		logger.Noticef("exposing tracer %q", tr.Id())
+	}
+
+	srv, err := network.NewServer(tracers)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	return srv.ListenAndServe(ctx.String("listen"))
+}
+
+// Create an opencl tracer for each local device that is not blacklisted. The
+// tracers use the default rendering pipeline. Unless the share option is
+// specified, devices that are in use by other polaris processes are skipped.
+func workerDeviceTracers(ctx *cli.Context) ([]tracer.Tracer, []*device.Lock, error) {
+	platforms, err := device.GetPlatformInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var locks []*device.Lock
+	selectedDevices := make([]*device.Device, 0)
+	for _, platformInfo := range platforms {
+		for _, dev := range platformInfo.Devices {
+			if isBlacklisted(dev.Name, ctx.StringSlice("blacklist")) {
+				continue
+			}
+
+			if !ctx.Bool("share") {
+				lock, err := dev.TryLock()
+				if device.IsLocked(err) {
+					logger.Warningf("skipping device %q: %v; use the share option to render on it anyway", dev.Name, err)
+					continue
+				} else if err == nil {
+					locks = append(locks, lock)
+				}
+			}
+
+			selectedDevices = append(selectedDevices, dev)
+		}
+	}
+
+	if len(selectedDevices) == 0 {
+		return nil, locks, fmt.Errorf("no opencl devices available")
+	}
+
+	sharedCtx, err := device.NewSharedContext(selectedDevices)
+	if err != nil {
+		return nil, locks, err
+	}
+
+	tracers := make([]tracer.Tracer, 0, len(selectedDevices))
+	for index, dev := range selectedDevices {
+		tr, err := opencl.NewTracer(
+			fmt.Sprintf("%s (%d)", dev.Name, index),
+			opencl.WithDevice(dev),
+			opencl.WithSharedContext(sharedCtx),
+			opencl.WithPipeline(opencl.DefaultPipeline()),
+		)
+		if err != nil {
+			return nil, locks, err
+		}
+		tracers = append(tracers, tr)
+	}
+
+	return tracers, locks, nil
+}
+
+// Check whether a device name contains any of the blacklist entries.
+func isBlacklisted(name string, blackList []string) bool {
+	for _, text := range blackList {
+		if text != "" && strings.Contains(name, text) {
+			return true
+		}
+	}
+	return false
+}
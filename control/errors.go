@@ -0,0 +1,15 @@
+package control
+
+import "errors"
+
+var (
+	ErrNoSuchJob       = errors.New("control server: no such job")
+	ErrJobFinished     = errors.New("control server: job has already finished")
+	ErrJobNotRendering = errors.New("control server: job is not rendering")
+	ErrMissingScene    = errors.New("control server: missing scene file")
+	ErrInvalidFrame    = errors.New("control server: invalid frame dimensions")
+	ErrInvalidPath     = errors.New("control server: paths must be relative to the server root directories")
+	ErrForbiddenOrigin = errors.New("control server: cross-origin requests are not allowed")
+	ErrServerClosed    = errors.New("control server: server closed")
+	ErrStoreLocked     = errors.New("control server: job store is locked by another process")
+)
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"sync"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+type EventType string
+
+// Supported event types.
+const (
+	JobQueued    EventType = "job.queued"
+	JobStarted   EventType = "job.started"
+	JobProgress  EventType = "job.progress"
+	JobCompleted EventType = "job.completed"
+	JobFailed    EventType = "job.failed"
+	JobCancelled EventType = "job.cancelled"
+
+	// Emitted when the set of throttled devices rendering a job changes.
+	DeviceThrottled EventType = "device.throttled"
+)
+
+// The number of events that can be buffered for each subscriber before
+// new events start getting dropped.
+const subscriberQueueSize = 64
+
+// An event emitted by the control server.
+type Event struct {
+	Type EventType `json:"type"`
+	Job  JobInfo   `json:"job"`
+
+	// The devices that appear to be throttled; only populated for
+	// DeviceThrottled events.
+	Devices []DeviceThermals `json:"devices,omitempty"`
+}
+
+// The thermal readings for a throttled device.
+type DeviceThermals struct {
+	Device string `json:"device"`
+	tracer.Thermals
+}
+
+// Dispatches events to a set of subscribers.
+type eventBroadcaster struct {
+	sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func newEventBroadcaster() *eventBroadcaster {
+	return &eventBroadcaster{
+		subscribers: make(map[chan Event]struct{}, 0),
+	}
+}
+
+// Register a new subscriber.
+func (b *eventBroadcaster) Subscribe() chan Event {
+	b.Lock()
+	defer b.Unlock()
+
+	ch := make(chan Event, subscriberQueueSize)
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Remove a subscriber and close its channel.
+func (b *eventBroadcaster) Unsubscribe(ch chan Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, exists := b.subscribers[ch]; exists {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Send event to all subscribers. Events are dropped for subscribers that
+// cannot keep up with the event rate.
+func (b *eventBroadcaster) Publish(evt Event) {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- evt:
+		default:
+		}
+	}
+}
+
+// Close all subscriber channels.
+func (b *eventBroadcaster) Close() {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+	}
+	b.subscribers = make(map[chan Event]struct{}, 0)
+}
@@ -0,0 +1,73 @@
+package control
+
+import (
+	"context"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/renderer"
+)
+
+type JobState string
+
+// Supported job states.
+const (
+	Queued    JobState = "queued"
+	Rendering JobState = "rendering"
+	Completed JobState = "completed"
+	Failed    JobState = "failed"
+	Cancelled JobState = "cancelled"
+)
+
+// A snapshot of a render job's state.
+type JobInfo struct {
+	Id        uint64   `json:"id"`
+	SceneFile string   `json:"scene"`
+	Output    string   `json:"output,omitempty"`
+	State     JobState `json:"state"`
+	Error     string   `json:"error,omitempty"`
+	Camera    string   `json:"camera,omitempty"`
+
+	// Render settings.
+	Options renderer.Options `json:"options"`
+
+	// The number of samples per pixel collected so far and the
+	// percentage of the requested samples that they represent.
+	Samples  uint32  `json:"samples"`
+	Progress float32 `json:"progress"`
+
+	// Job timings.
+	SubmittedAt time.Time     `json:"submittedAt"`
+	StartedAt   time.Time     `json:"startedAt,omitempty"`
+	FinishedAt  time.Time     `json:"finishedAt,omitempty"`
+	RenderTime  time.Duration `json:"renderTime"`
+}
+
+// A render job.
+type job struct {
+	info JobInfo
+
+	// Set when a client requests the job to be cancelled.
+	cancelled bool
+
+	// Aborts the pass that is being rendered; only valid while the job
+	// is rendering.
+	abort context.CancelFunc
+
+	// Pending updates that are applied before rendering the next pass.
+	pendingOpts   *renderer.Options
+	pendingCamera *CameraArgs
+
+	// The scene camera; only valid while the job is rendering.
+	camera *scene.Camera
+}
+
+// Check whether the job has reached a final state.
+func (j *job) done() bool {
+	switch j.info.State {
+	case Completed, Failed, Cancelled:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,816 @@
+package control
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/renderer"
+	"golang.org/x/net/websocket"
+)
+
+// Load a scene from a file.
+type SceneLoader func(filename string) (*scene.Scene, error)
+
+// Create a renderer for a scene using the supplied options.
+type RendererFactory func(sc *scene.Scene, opts renderer.Options) (renderer.Renderer, error)
+
+// The max width and height of submitted frames.
+const MaxFrameDim = 16384
+
+// A control server that exposes render job submission, parameter changes,
+// camera updates and progress events over JSON-RPC. Clients can issue RPC
+// calls either via HTTP POST requests or via a websocket connection to the
+// /rpc endpoint. Progress events are streamed as JSON objects to clients
+// connected to the /events websocket endpoint.
+//
+// Jobs are processed sequentially. If the server is backed by a JobStore,
+// job state changes are persisted so that any queued or interrupted jobs
+// are resumed when the server restarts.
+//
+// Scene and output paths of submitted jobs are resolved relative to the
+// server root directories; absolute paths and paths that reference a parent
+// directory are rejected.
+type Server struct {
+	logger log.Logger
+
+	sync.Mutex
+
+	loadScene   SceneLoader
+	newRenderer RendererFactory
+
+	// Root directories for scene and output files.
+	sceneDir  string
+	outputDir string
+
+	// An optional store for persisting job state.
+	store JobStore
+
+	rpcServer *rpc.Server
+	events    *eventBroadcaster
+	mux       *http.ServeMux
+
+	// Job list and queue of pending jobs.
+	jobs      map[uint64]*job
+	queue     []*job
+	nextJobId uint64
+
+	// Worker sync primitives.
+	busy       bool
+	idleCond   *sync.Cond
+	wakeupChan chan struct{}
+	closeChan  chan struct{}
+	workerDone sync.WaitGroup
+}
+
+// Create a new control server. The store argument is optional; if it is nil
+// jobs are only tracked in memory.
+func NewServer(loader SceneLoader, factory RendererFactory, store JobStore) (*Server, error) {
+	s := &Server{
+		logger:      log.New("control server"),
+		loadScene:   loader,
+		newRenderer: factory,
+		sceneDir:    ".",
+		outputDir:   ".",
+		store:       store,
+		rpcServer:   rpc.NewServer(),
+		events:      newEventBroadcaster(),
+		mux:         http.NewServeMux(),
+		jobs:        make(map[uint64]*job, 0),
+		queue:       make([]*job, 0),
+		nextJobId:   1,
+		wakeupChan:  make(chan struct{}, 1),
+		closeChan:   make(chan struct{}, 0),
+	}
+	s.idleCond = sync.NewCond(&s.Mutex)
+
+	err := s.rpcServer.RegisterName("Polaris", &Service{srv: s})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.restoreJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mux.HandleFunc("/rpc", s.serveRPC)
+	s.mux.Handle("/events", websocket.Server{Handler: s.serveEvents, Handshake: checkOrigin})
+
+	s.workerDone.Add(1)
+	go s.worker()
+
+	return s, nil
+}
+
+// Get the http handler for the server endpoints.
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Set the root directories for resolving the scene and output paths of
+// submitted jobs. Both directories default to the current working directory.
+func (s *Server) SetRootDirs(sceneDir, outputDir string) {
+	s.Lock()
+	s.sceneDir = sceneDir
+	s.outputDir = outputDir
+	s.Unlock()
+}
+
+// Listen for incoming connections on addr and serve requests.
+func (s *Server) ListenAndServe(addr string) error {
+	s.logger.Noticef("listening for connections on %s", addr)
+	return http.ListenAndServe(addr, s.mux)
+}
+
+// Shutdown the server. Any rendering job is cancelled.
+func (s *Server) Close() {
+	s.Lock()
+	if s.closed() {
+		s.Unlock()
+		return
+	}
+	close(s.closeChan)
+	for _, j := range s.jobs {
+		if !j.done() {
+			j.cancelled = true
+			if j.abort != nil {
+				j.abort()
+			}
+		}
+	}
+	s.idleCond.Broadcast()
+	s.Unlock()
+
+	s.workerDone.Wait()
+	s.events.Close()
+}
+
+// Submit a new job.
+func (s *Server) Submit(args *SubmitArgs) (JobInfo, error) {
+	err := validateSubmitArgs(args)
+	if err != nil {
+		return JobInfo{}, err
+	}
+
+	s.Lock()
+	if s.closed() {
+		s.Unlock()
+		return JobInfo{}, ErrServerClosed
+	}
+
+	resolved := *args
+	resolved.SceneFile, err = resolvePath(s.sceneDir, args.SceneFile)
+	if err == nil && args.Output != "" {
+		resolved.Output, err = resolvePath(s.outputDir, args.Output)
+	}
+	if err != nil {
+		s.Unlock()
+		return JobInfo{}, err
+	}
+	args = &resolved
+
+	jobId, err := s.allocJobId()
+	if err != nil {
+		s.Unlock()
+		return JobInfo{}, err
+	}
+
+	j := &job{info: newJobInfo(jobId, args)}
+	s.jobs[j.info.Id] = j
+	s.queue = append(s.queue, j)
+	s.persist(j)
+	info := j.info
+	s.Unlock()
+
+	s.logger.Infof("queued job %d for scene %q", info.Id, info.SceneFile)
+	s.events.Publish(Event{Type: JobQueued, Job: info})
+
+	select {
+	case s.wakeupChan <- struct{}{}:
+	default:
+	}
+
+	return info, nil
+}
+
+// Add a job to a job store without processing it. The job will be
+// processed the next time a server is started using the same store.
+func EnqueueJob(store JobStore, args *SubmitArgs) (JobInfo, error) {
+	err := validateSubmitArgs(args)
+	if err != nil {
+		return JobInfo{}, err
+	}
+
+	jobId, err := store.NextId()
+	if err != nil {
+		return JobInfo{}, err
+	}
+
+	info := newJobInfo(jobId, args)
+	return info, store.Save(info)
+}
+
+// Block until all queued jobs have been processed or the server is closed.
+func (s *Server) WaitForIdle() {
+	s.Lock()
+	defer s.Unlock()
+
+	for {
+		if s.closed() || (!s.busy && len(s.queue) == 0) {
+			return
+		}
+		s.idleCond.Wait()
+	}
+}
+
+// Check whether the server has been closed.
+func (s *Server) closed() bool {
+	select {
+	case <-s.closeChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// Get job status.
+func (s *Server) Status(jobId uint64) (JobInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	j, exists := s.jobs[jobId]
+	if !exists {
+		return JobInfo{}, ErrNoSuchJob
+	}
+
+	return j.info, nil
+}
+
+// List all jobs ordered by their id.
+func (s *Server) List() []JobInfo {
+	s.Lock()
+	defer s.Unlock()
+
+	list := make([]JobInfo, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, j.info)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].Id < list[k].Id })
+
+	return list
+}
+
+// Cancel a job.
+func (s *Server) Cancel(jobId uint64) (JobInfo, error) {
+	s.Lock()
+	j, exists := s.jobs[jobId]
+	if !exists {
+		s.Unlock()
+		return JobInfo{}, ErrNoSuchJob
+	}
+	if j.done() {
+		s.Unlock()
+		return j.info, ErrJobFinished
+	}
+
+	j.cancelled = true
+	if j.abort != nil {
+		j.abort()
+	}
+
+	// Queued jobs can be cancelled immediately
+	var info JobInfo
+	if j.info.State == Queued {
+		j.info.State = Cancelled
+		j.info.FinishedAt = time.Now()
+		for index, queued := range s.queue {
+			if queued == j {
+				s.queue = append(s.queue[:index], s.queue[index+1:]...)
+				break
+			}
+		}
+		s.persist(j)
+		info = j.info
+		s.Unlock()
+		s.events.Publish(Event{Type: JobCancelled, Job: info})
+		return info, nil
+	}
+
+	info = j.info
+	s.Unlock()
+	return info, nil
+}
+
+// Change job render parameters.
+func (s *Server) SetParams(args *ParamArgs) (JobInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	j, exists := s.jobs[args.Id]
+	if !exists {
+		return JobInfo{}, ErrNoSuchJob
+	}
+	if j.done() {
+		return j.info, ErrJobFinished
+	}
+
+	opts := j.info.Options
+	if j.pendingOpts != nil {
+		opts = *j.pendingOpts
+	}
+	if args.Spp != nil {
+		opts.SamplesPerPixel = *args.Spp
+	}
+	if args.NumBounces != nil {
+		opts.NumBounces = *args.NumBounces
+	}
+	if args.RRBounces != nil {
+		opts.MinBouncesForRR = *args.RRBounces
+	}
+	if args.Exposure != nil {
+		opts.Exposure = *args.Exposure
+	}
+	normalizeOptions(&opts)
+
+	if j.info.State == Queued {
+		j.info.Options = opts
+		s.persist(j)
+	} else {
+		j.pendingOpts = &opts
+	}
+
+	return j.info, nil
+}
+
+// Update the camera for a rendering job.
+func (s *Server) SetCamera(args *CameraArgs) (JobInfo, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	j, exists := s.jobs[args.Id]
+	if !exists {
+		return JobInfo{}, ErrNoSuchJob
+	}
+	if j.info.State != Rendering {
+		return j.info, ErrJobNotRendering
+	}
+
+	camArgs := *args
+	j.pendingCamera = &camArgs
+	return j.info, nil
+}
+
+// Load jobs from the attached store and queue any jobs that were pending or
+// rendering when the server was last stopped.
+func (s *Server) restoreJobs() error {
+	if s.store == nil {
+		return nil
+	}
+
+	list, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range list {
+		j := &job{info: info}
+		s.jobs[info.Id] = j
+		if j.done() {
+			continue
+		}
+
+		// Interrupted jobs are restarted from scratch
+		j.info.State = Queued
+		j.info.Samples = 0
+		j.info.Progress = 0
+		s.queue = append(s.queue, j)
+	}
+
+	if len(s.queue) != 0 {
+		s.logger.Noticef("resuming %d pending job(s)", len(s.queue))
+		s.busy = true
+	}
+
+	return nil
+}
+
+// Allocate a new job id. This method is meant to be called while holding s.Lock().
+func (s *Server) allocJobId() (uint64, error) {
+	if s.store != nil {
+		return s.store.NextId()
+	}
+
+	jobId := s.nextJobId
+	s.nextJobId++
+	return jobId, nil
+}
+
+// Persist job state to the attached store. This method is meant to be
+// called while holding s.Lock().
+func (s *Server) persist(j *job) {
+	if s.store == nil {
+		return
+	}
+
+	if err := s.store.Save(j.info); err != nil {
+		s.logger.Warningf("could not persist state for job %d: %v", j.info.Id, err)
+	}
+}
+
+// Serve RPC requests either via a websocket connection or via HTTP POST.
+// POST requests must use the application/json content type so that browsers
+// cannot issue them cross-site without a CORS preflight.
+func (s *Server) serveRPC(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Upgrade") == "websocket" {
+		websocket.Server{
+			Handler: func(ws *websocket.Conn) {
+				s.rpcServer.ServeCodec(jsonrpc.NewServerCodec(ws))
+			},
+			Handshake: checkOrigin,
+		}.ServeHTTP(w, req)
+		return
+	}
+
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := s.rpcServer.ServeRequest(jsonrpc.NewServerCodec(&httpConn{in: req.Body, out: w}))
+	if err != nil {
+		s.logger.Warningf("error serving rpc request: %v", err)
+	}
+}
+
+// Reject websocket connections initiated by pages served from a different
+// host than the server.
+func checkOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if originURL.Host != req.Host {
+		return ErrForbiddenOrigin
+	}
+	config.Origin = originURL
+	return nil
+}
+
+// Stream events to a websocket client.
+func (s *Server) serveEvents(ws *websocket.Conn) {
+	defer ws.Close()
+
+	events := s.events.Subscribe()
+	defer s.events.Unsubscribe(events)
+
+	for evt := range events {
+		if err := websocket.JSON.Send(ws, evt); err != nil {
+			return
+		}
+	}
+}
+
+// Process queued jobs.
+func (s *Server) worker() {
+	defer s.workerDone.Done()
+
+	for {
+		s.Lock()
+		var j *job
+		if len(s.queue) > 0 {
+			j = s.queue[0]
+			s.queue = s.queue[1:]
+		}
+		s.busy = j != nil
+		if !s.busy {
+			s.idleCond.Broadcast()
+		}
+		s.Unlock()
+
+		if j != nil {
+			s.runJob(j)
+			continue
+		}
+
+		select {
+		case <-s.closeChan:
+			return
+		case <-s.wakeupChan:
+		}
+	}
+}
+
+// Render a job and update its state.
+func (s *Server) runJob(j *job) {
+	s.Lock()
+	if j.cancelled {
+		s.Unlock()
+		return
+	}
+	j.info.State = Rendering
+	j.info.StartedAt = time.Now()
+	s.persist(j)
+	info := j.info
+	s.Unlock()
+
+	s.logger.Noticef("rendering job %d", info.Id)
+	s.events.Publish(Event{Type: JobStarted, Job: info})
+
+	err := s.renderJob(j)
+
+	s.Lock()
+	j.info.FinishedAt = time.Now()
+	j.info.RenderTime = j.info.FinishedAt.Sub(j.info.StartedAt)
+	j.camera = nil
+	evtType := JobCompleted
+	switch {
+	case err != nil:
+		j.info.State = Failed
+		j.info.Error = err.Error()
+		evtType = JobFailed
+	case j.cancelled && s.closed():
+		// Jobs interrupted by a server shutdown are resumed on restart
+		j.info.State = Queued
+		j.info.Samples = 0
+		j.info.Progress = 0
+		s.persist(j)
+		s.Unlock()
+		return
+	case j.cancelled:
+		j.info.State = Cancelled
+		evtType = JobCancelled
+	default:
+		j.info.State = Completed
+	}
+	s.persist(j)
+	info = j.info
+	s.Unlock()
+
+	if err != nil {
+		s.logger.Errorf("job %d failed: %v", info.Id, err)
+	} else {
+		s.logger.Noticef("job %d %s in %d ms", info.Id, info.State, info.RenderTime.Nanoseconds()/1e6)
+	}
+	s.events.Publish(Event{Type: evtType, Job: info})
+}
+
+// Render the job using a series of progressive passes emitting a progress
+// event after each pass.
+func (s *Server) renderJob(j *job) error {
+	s.Lock()
+	opts := j.info.Options
+	s.Unlock()
+
+	sc, err := s.loadScene(j.info.SceneFile)
+	if err != nil {
+		return err
+	}
+	if j.info.Camera != "" {
+		err = sc.SelectCamera(j.info.Camera)
+		if err != nil {
+			return err
+		}
+	}
+	opts.FrameW, opts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+
+	r, err := s.newRenderer(sc, progressiveOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// Cancelling the job aborts the pass that is being rendered
+	ctx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	s.Lock()
+	j.camera = sc.Camera
+	j.abort = abort
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		j.abort = nil
+		s.Unlock()
+	}()
+
+	var lastThrottled []DeviceThermals
+	for {
+		// Apply pending updates
+		s.Lock()
+		if j.cancelled {
+			s.Unlock()
+			return nil
+		}
+		if j.pendingOpts != nil {
+			// Preserve the frame dims that include the camera overscan
+			frameW, frameH := opts.FrameW, opts.FrameH
+			opts = *j.pendingOpts
+			j.info.Options = opts
+			j.pendingOpts = nil
+			opts.FrameW, opts.FrameH = frameW, frameH
+			r.UpdateOptions(progressiveOptions(opts))
+		}
+		if j.pendingCamera != nil {
+			applyCameraArgs(j.camera, j.pendingCamera, j.info.Options)
+			j.pendingCamera = nil
+			r.UpdateCamera(j.camera)
+		}
+		targetSamples := opts.SamplesPerPixel
+		s.Unlock()
+
+		if r.AccumulatedSamples() >= targetSamples {
+			break
+		}
+
+		err = r.AccumulateContext(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				// The job was cancelled while rendering
+				return nil
+			}
+			return err
+		}
+
+		s.Lock()
+		j.info.Samples = r.AccumulatedSamples()
+		j.info.Progress = 100.0 * float32(j.info.Samples) / float32(targetSamples)
+		info := j.info
+		s.Unlock()
+		s.events.Publish(Event{Type: JobProgress, Job: info})
+
+		// Notify subscribers when devices start or stop throttling
+		throttled := throttledDevices(r.Stats())
+		if !sameDevices(throttled, lastThrottled) {
+			s.events.Publish(Event{Type: DeviceThrottled, Job: info, Devices: throttled})
+			lastThrottled = throttled
+		}
+	}
+
+	if j.info.Output == "" {
+		return nil
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, int(opts.FrameW), int(opts.FrameH)))
+	err = r.ReadFrame(im)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(j.info.Output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, im)
+}
+
+// Get the thermal readings for the throttled devices listed in the frame stats.
+func throttledDevices(stats renderer.FrameStats) []DeviceThermals {
+	var list []DeviceThermals
+	for _, stat := range stats.ThrottledTracers() {
+		list = append(list, DeviceThermals{Device: stat.Id, Thermals: stat.Thermals})
+	}
+	return list
+}
+
+// Check whether two throttled device lists refer to the same devices.
+func sameDevices(a, b []DeviceThermals) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if a[index].Device != b[index].Device {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate job submission arguments.
+func validateSubmitArgs(args *SubmitArgs) error {
+	if args.SceneFile == "" {
+		return ErrMissingScene
+	}
+	if args.Width == 0 || args.Height == 0 || args.Width > MaxFrameDim || args.Height > MaxFrameDim {
+		return ErrInvalidFrame
+	}
+	if _, err := renderer.ParseSampleSchedule(args.SppSchedule); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Resolve a relative path under a root directory. Absolute paths and paths
+// that reference a parent directory are rejected.
+func resolvePath(rootDir, path string) (string, error) {
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" {
+		return "", ErrInvalidPath
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+
+	return filepath.Join(rootDir, path), nil
+}
+
+// Create the initial state for a new job.
+func newJobInfo(jobId uint64, args *SubmitArgs) JobInfo {
+	info := JobInfo{
+		Id:        jobId,
+		SceneFile: args.SceneFile,
+		Output:    args.Output,
+		Camera:    args.Camera,
+		State:     Queued,
+		Options: renderer.Options{
+			FrameW:          args.Width,
+			FrameH:          args.Height,
+			SamplesPerPixel: args.Spp,
+			NumBounces:      args.NumBounces,
+			MinBouncesForRR: args.RRBounces,
+			Exposure:        args.Exposure,
+		},
+		SubmittedAt: time.Now(),
+	}
+	// The schedule has already been validated by validateSubmitArgs
+	info.Options.Schedule, _ = renderer.ParseSampleSchedule(args.SppSchedule)
+	normalizeOptions(&info.Options)
+
+	return info
+}
+
+// Apply default values to render options.
+func normalizeOptions(opts *renderer.Options) {
+	if opts.SamplesPerPixel == 0 {
+		opts.SamplesPerPixel = 1
+	}
+	if opts.Exposure == 0 {
+		opts.Exposure = 1.0
+	}
+	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
+		opts.MinBouncesForRR = opts.NumBounces + 1
+	}
+}
+
+// Jobs are rendered using one sample per pass so that progress can be
+// reported and updates can be applied between passes. If the job specifies
+// a sample schedule, the schedule controls the samples for each pass instead.
+func progressiveOptions(opts renderer.Options) renderer.Options {
+	if !opts.Schedule.Enabled() {
+		opts.SamplesPerPixel = 1
+	}
+	return opts
+}
+
+// Update the camera using the supplied args.
+func applyCameraArgs(camera *scene.Camera, args *CameraArgs, opts renderer.Options) {
+	camera.Position = args.Eye
+	camera.LookAt = args.Look
+	if args.Up.Len() != 0 {
+		camera.Up = args.Up
+	}
+	if args.FOV > 0 {
+		camera.FOV = args.FOV
+	}
+	camera.Pitch, camera.Yaw = 0, 0
+	camera.SetupFrame(opts.FrameW, opts.FrameH)
+}
+
+// Adapts an HTTP request/response pair to the io.ReadWriteCloser interface
+// expected by the jsonrpc codec.
+type httpConn struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (c *httpConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *httpConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *httpConn) Close() error                { return nil }
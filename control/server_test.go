@@ -0,0 +1,299 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/renderer"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestSubmitJobOverHTTP(t *testing.T) {
+	mr := &mockRenderer{}
+	srv := newTestServer(t, mr)
+	defer srv.Close()
+
+	httpSrv := httptest.NewServer(srv.Handler())
+	defer httpSrv.Close()
+
+	var info JobInfo
+	callRPC(t, httpSrv.URL, "Polaris.Submit", SubmitArgs{SceneFile: "scene.zip", Width: 4, Height: 4, Spp: 3}, &info)
+	if info.Id != 1 {
+		t.Fatalf("expected job id to be 1; got %d", info.Id)
+	}
+
+	info = waitForJob(t, srv, info.Id)
+	if info.State != Completed {
+		t.Fatalf("expected job state to be %q; got %q (error: %s)", Completed, info.State, info.Error)
+	}
+	if info.Samples != 3 || info.Progress != 100.0 {
+		t.Fatalf("expected job to collect 3 samples (100%%); got %d (%f%%)", info.Samples, info.Progress)
+	}
+	if mr.passes != 3 {
+		t.Fatalf("expected renderer to run 3 passes; got %d", mr.passes)
+	}
+
+	var list []JobInfo
+	callRPC(t, httpSrv.URL, "Polaris.List", ListArgs{}, &list)
+	if len(list) != 1 || list[0].Id != 1 {
+		t.Fatalf("unexpected job list: %v", list)
+	}
+}
+
+func TestSubmitValidation(t *testing.T) {
+	srv := newTestServer(t, &mockRenderer{})
+	defer srv.Close()
+
+	_, err := srv.Submit(&SubmitArgs{Width: 1, Height: 1})
+	if err != ErrMissingScene {
+		t.Fatalf("expected to get ErrMissingScene; got %v", err)
+	}
+
+	_, err = srv.Submit(&SubmitArgs{SceneFile: "scene.zip"})
+	if err != ErrInvalidFrame {
+		t.Fatalf("expected to get ErrInvalidFrame; got %v", err)
+	}
+
+	_, err = srv.Submit(&SubmitArgs{SceneFile: "scene.zip", Width: 1, Height: 1, SppSchedule: "0:2"})
+	if err == nil || !strings.HasPrefix(err.Error(), renderer.ErrInvalidSchedule.Error()) {
+		t.Fatalf("expected to get ErrInvalidSchedule; got %v", err)
+	}
+
+	_, err = srv.Status(42)
+	if err != ErrNoSuchJob {
+		t.Fatalf("expected to get ErrNoSuchJob; got %v", err)
+	}
+}
+
+func TestSubmitRejectsUnsafeArgs(t *testing.T) {
+	srv := newTestServer(t, &mockRenderer{})
+	defer srv.Close()
+	srv.SetRootDirs("scenes", "renders")
+
+	specs := []struct {
+		args   SubmitArgs
+		expErr error
+	}{
+		{SubmitArgs{SceneFile: "../secret.zip", Width: 1, Height: 1}, ErrInvalidPath},
+		{SubmitArgs{SceneFile: "a/../../secret.zip", Width: 1, Height: 1}, ErrInvalidPath},
+		{SubmitArgs{SceneFile: "/etc/passwd", Width: 1, Height: 1}, ErrInvalidPath},
+		{SubmitArgs{SceneFile: "scene.zip", Output: "../../.bashrc", Width: 1, Height: 1}, ErrInvalidPath},
+		{SubmitArgs{SceneFile: "scene.zip", Output: "/tmp/out.png", Width: 1, Height: 1}, ErrInvalidPath},
+		{SubmitArgs{SceneFile: "scene.zip", Width: MaxFrameDim + 1, Height: 1}, ErrInvalidFrame},
+		{SubmitArgs{SceneFile: "scene.zip", Width: 1, Height: 0xFFFFFFFF}, ErrInvalidFrame},
+	}
+
+	for specIndex, spec := range specs {
+		_, err := srv.Submit(&spec.args)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+	if list := srv.List(); len(list) != 0 {
+		t.Fatalf("expected rejected jobs not to be queued; got %v", list)
+	}
+
+	info, err := srv.Submit(&SubmitArgs{SceneFile: "sub/scene.zip", Output: "frame.png", Width: MaxFrameDim, Height: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := filepath.Join("scenes", "sub", "scene.zip"); info.SceneFile != exp {
+		t.Errorf("expected scene file to be %q; got %q", exp, info.SceneFile)
+	}
+	if exp := filepath.Join("renders", "frame.png"); info.Output != exp {
+		t.Errorf("expected output to be %q; got %q", exp, info.Output)
+	}
+}
+
+func TestRPCRequiresJSONContentType(t *testing.T) {
+	srv := newTestServer(t, &mockRenderer{})
+	defer srv.Close()
+
+	httpSrv := httptest.NewServer(srv.Handler())
+	defer httpSrv.Close()
+
+	payload := `{"id": 1, "method": "Polaris.Submit", "params": [{"scene": "scene.zip", "width": 1, "height": 1}]}`
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		res, err := http.Post(httpSrv.URL+"/rpc", contentType, strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("[content type %q] expected status %d; got %d", contentType, http.StatusUnsupportedMediaType, res.StatusCode)
+		}
+	}
+	if list := srv.List(); len(list) != 0 {
+		t.Fatalf("expected rejected requests not to queue jobs; got %v", list)
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	srv := newTestServer(t, &mockRenderer{})
+	defer srv.Close()
+
+	events := srv.events.Subscribe()
+	_, err := srv.Submit(&SubmitArgs{SceneFile: "scene.zip", Width: 1, Height: 1, Spp: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expTypes := []EventType{JobQueued, JobStarted, JobProgress, JobProgress, JobCompleted}
+	for index, expType := range expTypes {
+		select {
+		case evt := <-events:
+			if evt.Type != expType {
+				t.Fatalf("[event %d] expected event type %q; got %q", index, expType, evt.Type)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[event %d] timeout waiting for event", index)
+		}
+	}
+}
+
+func TestDeviceThrottledEvents(t *testing.T) {
+	mr := &mockRenderer{
+		stats: renderer.FrameStats{
+			Tracers: []renderer.TracerStat{
+				{Id: "cpu"},
+				{Id: "gpu", Throttled: true, Thermals: tracer.Thermals{Temperature: 95}},
+			},
+		},
+	}
+	srv := newTestServer(t, mr)
+	defer srv.Close()
+
+	events := srv.events.Subscribe()
+	_, err := srv.Submit(&SubmitArgs{SceneFile: "scene.zip", Width: 1, Height: 1, Spp: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The throttled event should only be emitted when the set of
+	// throttled devices changes
+	expTypes := []EventType{JobQueued, JobStarted, JobProgress, DeviceThrottled, JobProgress, JobCompleted}
+	for index, expType := range expTypes {
+		select {
+		case evt := <-events:
+			if evt.Type != expType {
+				t.Fatalf("[event %d] expected event type %q; got %q", index, expType, evt.Type)
+			}
+			if evt.Type == DeviceThrottled && (len(evt.Devices) != 1 || evt.Devices[0].Device != "gpu" || evt.Devices[0].Temperature != 95) {
+				t.Fatalf("[event %d] expected gpu to be reported as throttled; got %+v", index, evt.Devices)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[event %d] timeout waiting for event", index)
+		}
+	}
+}
+
+func mockSceneLoader(_ string) (*scene.Scene, error) {
+	return &scene.Scene{Camera: scene.NewCamera(45)}, nil
+}
+
+func mockRendererFactory(mr *mockRenderer) RendererFactory {
+	return func(_ *scene.Scene, opts renderer.Options) (renderer.Renderer, error) {
+		mr.opts = opts
+		mr.accumulated = 0
+		return mr, nil
+	}
+}
+
+func newTestServer(t *testing.T, mr *mockRenderer) *Server {
+	srv, err := NewServer(mockSceneLoader, mockRendererFactory(mr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func callRPC(t *testing.T, url, method string, args interface{}, reply interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":     1,
+		"method": method,
+		"params": []interface{}{args},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(url+"/rpc", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var rpcRes struct {
+		Result json.RawMessage `json:"result"`
+		Error  interface{}     `json:"error"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&rpcRes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpcRes.Error != nil {
+		t.Fatalf("rpc call %s failed: %v", method, rpcRes.Error)
+	}
+
+	err = json.Unmarshal(rpcRes.Result, reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForJob(t *testing.T, srv *Server, jobId uint64) JobInfo {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, err := srv.Status(jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.State != Queued && info.State != Rendering {
+			return info
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("timeout waiting for job %d to complete", jobId)
+	return JobInfo{}
+}
+
+type mockRenderer struct {
+	opts        renderer.Options
+	passes      int
+	accumulated uint32
+	stats       renderer.FrameStats
+}
+
+func (mr *mockRenderer) Render() error                  { return nil }
+func (mr *mockRenderer) Close()                         {}
+func (mr *mockRenderer) Stats() renderer.FrameStats     { return mr.stats }
+func (mr *mockRenderer) ReadRadiance(_ []float32) error { return nil }
+func (mr *mockRenderer) ReadFrame(_ interface{}) error  { return nil }
+func (mr *mockRenderer) AccumulatedSamples() uint32     { return mr.accumulated }
+func (mr *mockRenderer) UpdateCamera(_ *scene.Camera)   { mr.accumulated = 0 }
+func (mr *mockRenderer) UpdateOptions(opts renderer.Options) {
+	mr.opts = opts
+	mr.accumulated = 0
+}
+func (mr *mockRenderer) UpdateInstances(_ *scene.InstanceUpdate) { mr.accumulated = 0 }
+func (mr *mockRenderer) ProbePixel(x, y uint32) (tracer.PixelProbe, error) {
+	return tracer.PixelProbe{X: x, Y: y}, nil
+}
+func (mr *mockRenderer) Accumulate() error {
+	mr.passes++
+	mr.accumulated += mr.opts.SamplesPerPixel
+	return nil
+}
+func (mr *mockRenderer) RenderContext(_ context.Context) error { return nil }
+func (mr *mockRenderer) AccumulateContext(_ context.Context) error {
+	return mr.Accumulate()
+}
@@ -0,0 +1,123 @@
+package control
+
+import "github.com/achilleasa/polaris/types"
+
+// Arguments for submitting a new render job.
+type SubmitArgs struct {
+	SceneFile string `json:"scene"`
+	Output    string `json:"output"`
+
+	Width      uint32  `json:"width"`
+	Height     uint32  `json:"height"`
+	Spp        uint32  `json:"spp"`
+	NumBounces uint32  `json:"numBounces"`
+	RRBounces  uint32  `json:"rrBounces"`
+	Exposure   float32 `json:"exposure"`
+
+	// An optional sample schedule (see renderer.ParseSampleSchedule).
+	SppSchedule string `json:"sppSchedule,omitempty"`
+
+	// The name of the scene camera to render from. If empty, the scene's
+	// default camera is used.
+	Camera string `json:"camera,omitempty"`
+}
+
+// Arguments for operations that target a specific job.
+type JobArgs struct {
+	Id uint64 `json:"id"`
+}
+
+// Arguments for listing jobs.
+type ListArgs struct{}
+
+// Arguments for changing the render parameters of a job. Only non-nil
+// values are applied.
+type ParamArgs struct {
+	Id uint64 `json:"id"`
+
+	Spp        *uint32  `json:"spp"`
+	NumBounces *uint32  `json:"numBounces"`
+	RRBounces  *uint32  `json:"rrBounces"`
+	Exposure   *float32 `json:"exposure"`
+}
+
+// Arguments for updating the camera of a job.
+type CameraArgs struct {
+	Id uint64 `json:"id"`
+
+	Eye  types.Vec3 `json:"eye"`
+	Look types.Vec3 `json:"look"`
+	Up   types.Vec3 `json:"up"`
+	FOV  float32    `json:"fov"`
+}
+
+// The RPC service exposed by the control server. It is registered
+// under the "Polaris" name; e.g. clients can submit a job by invoking
+// the "Polaris.Submit" method.
+type Service struct {
+	srv *Server
+}
+
+// Submit a new render job.
+func (s *Service) Submit(args *SubmitArgs, reply *JobInfo) error {
+	info, err := s.srv.Submit(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = info
+	return nil
+}
+
+// Get job status.
+func (s *Service) Status(args *JobArgs, reply *JobInfo) error {
+	info, err := s.srv.Status(args.Id)
+	if err != nil {
+		return err
+	}
+
+	*reply = info
+	return nil
+}
+
+// List all jobs.
+func (s *Service) List(_ *ListArgs, reply *[]JobInfo) error {
+	*reply = s.srv.List()
+	return nil
+}
+
+// Cancel a queued or rendering job.
+func (s *Service) Cancel(args *JobArgs, reply *JobInfo) error {
+	info, err := s.srv.Cancel(args.Id)
+	if err != nil {
+		return err
+	}
+
+	*reply = info
+	return nil
+}
+
+// Change the render parameters of a job. If the job is currently rendering,
+// the changes are applied before the next pass and the accumulated samples
+// are reset.
+func (s *Service) SetParams(args *ParamArgs, reply *JobInfo) error {
+	info, err := s.srv.SetParams(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = info
+	return nil
+}
+
+// Update the camera of a rendering job. The changes are applied before
+// the next pass and the accumulated samples are reset.
+func (s *Service) SetCamera(args *CameraArgs, reply *JobInfo) error {
+	info, err := s.srv.SetCamera(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = info
+	return nil
+}
@@ -0,0 +1,108 @@
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// The JobStore interface is implemented by objects that persist job state
+// so that job queues survive restarts.
+type JobStore interface {
+	// Allocate a new unique job id.
+	NextId() (uint64, error)
+
+	// Create or update a job entry.
+	Save(JobInfo) error
+
+	// Load all stored jobs ordered by their id.
+	Load() ([]JobInfo, error)
+
+	// Close the store.
+	Close() error
+}
+
+var jobBucket = []byte("jobs")
+
+// A JobStore implementation backed by a BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// Open (or create) a bolt-backed job store.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil, ErrStoreLocked
+		}
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Allocate a new unique job id.
+func (s *BoltStore) NextId() (uint64, error) {
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = tx.Bucket(jobBucket).NextSequence()
+		return err
+	})
+
+	return id, err
+}
+
+// Create or update a job entry.
+func (s *BoltStore) Save(info JobInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobBucket).Put(jobKey(info.Id), data)
+	})
+}
+
+// Load all stored jobs ordered by their id.
+func (s *BoltStore) Load() ([]JobInfo, error) {
+	list := make([]JobInfo, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobBucket).ForEach(func(_, data []byte) error {
+			var info JobInfo
+			err := json.Unmarshal(data, &info)
+			if err != nil {
+				return err
+			}
+			list = append(list, info)
+			return nil
+		})
+	})
+
+	return list, err
+}
+
+// Close the store.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// Encode job id as a big-endian key so that bolt iterates jobs in id order.
+func jobKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
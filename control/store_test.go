@@ -0,0 +1,82 @@
+package control
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobsSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbFile := filepath.Join(dir, "jobs.db")
+
+	// Queue jobs without running a server
+	store, err := NewBoltStore(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := 0; index < 2; index++ {
+		_, err = EnqueueJob(store, &SubmitArgs{SceneFile: "scene.zip", Width: 2, Height: 2, Spp: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// Start a server and process the queued jobs
+	store, err = NewBoltStore(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := &mockRenderer{}
+	srv, err := NewServer(mockSceneLoader, mockRendererFactory(mr), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.WaitForIdle()
+
+	list := srv.List()
+	if len(list) != 2 {
+		t.Fatalf("expected server to restore 2 jobs; got %d", len(list))
+	}
+	for _, info := range list {
+		if info.State != Completed {
+			t.Fatalf("expected job %d to be completed; got %q", info.Id, info.State)
+		}
+	}
+	srv.Close()
+	store.Close()
+
+	// Verify that the results were persisted
+	store, err = NewBoltStore(dbFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	list, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected store to contain 2 jobs; got %d", len(list))
+	}
+	for _, info := range list {
+		if info.State != Completed || info.Samples != 2 || info.FinishedAt.IsZero() {
+			t.Fatalf("expected completed job entry with timings; got %+v", info)
+		}
+	}
+
+	nextId, err := store.NextId()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nextId != 3 {
+		t.Fatalf("expected next job id to be 3; got %d", nextId)
+	}
+}
@@ -1,8 +1,11 @@
 package log
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/op/go-logging"
 )
@@ -18,6 +21,10 @@ const (
 	Error
 )
 
+var (
+	ErrUnknownLevel = errors.New("log: unknown level")
+)
+
 // The logger format
 var format = logging.MustStringFormatter(
 	`%{color}[%{time:15:04:05.000}] [%{module}] [%{level}]%{color:reset} %{message}`,
@@ -78,6 +85,17 @@ func SetLevel(level Level) {
 	leveledBackend.SetLevel(loggerLevel, "")
 }
 
+// Parse a level name (debug, info, notice, warning or error).
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range []string{"debug", "info", "notice", "warning", "error"} {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+
+	return Notice, fmt.Errorf("%w %q; supported levels are debug, info, notice, warning and error", ErrUnknownLevel, name)
+}
+
 func init() {
 	SetSink(os.Stdout)
 	SetLevel(Notice)
@@ -0,0 +1,35 @@
+package log
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	specs := []struct {
+		name     string
+		expLevel Level
+		expErr   error
+	}{
+		{"debug", Debug, nil},
+		{"info", Info, nil},
+		{"notice", Notice, nil},
+		{"warning", Warning, nil},
+		{"error", Error, nil},
+		{"DEBUG", Debug, nil},
+		{"Warning", Warning, nil},
+		{"warn", Notice, ErrUnknownLevel},
+		{"", Notice, ErrUnknownLevel},
+		{"verbose", Notice, ErrUnknownLevel},
+	}
+
+	for specIndex, spec := range specs {
+		level, err := ParseLevel(spec.name)
+		if !errors.Is(err, spec.expErr) {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+		if level != spec.expLevel {
+			t.Errorf("[spec %d] expected level %d; got %d", specIndex, spec.expLevel, level)
+		}
+	}
+}
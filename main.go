@@ -10,11 +10,66 @@ import (
 
 var (
 	sceneCompileHelp = `
-Parse a scene definition from a wavefront obj file, build a BVH tree to optimize
-ray intersection tests and package scene assets in a GPU-friendly format.
+Parse a scene definition from a wavefront obj or a glTF 2.0 (gltf/glb) file, build
+a BVH tree to optimize ray intersection tests and package scene assets in a GPU-friendly format.
 
 The optimized scene data is then written to a zip archive which can be supplied
 as an argument to the render commands.
+`
+
+	serveHelp = `
+Start a control server that exposes render job submission, parameter changes,
+camera updates and progress events over JSON-RPC.
+
+RPC calls can be issued via HTTP POST requests or via a websocket connection to
+the /rpc endpoint. Progress events are streamed to websocket clients connected
+to the /events endpoint.
+
+The server does not authenticate clients and only listens on the loopback
+interface by default. Scene and output paths of submitted jobs must be relative
+to the scene-dir and output-dir directories.
+`
+
+	workerHelp = `
+Start a render worker that exposes the local opencl devices (or the built-in
+cpu tracer) to remote renderers over TCP. Renderers connect to one or more
+workers via the remote option of the render frame command and distribute the
+frame rows between the local tracers of all workers.
+
+A worker should only be used by a single renderer at a time.
+`
+)
+
+var (
+	selftestHelp = `
+Render a built-in Cornell box scene using the opencl kernels. If oclgrind is
+available, the command re-executes itself under oclgrind which emulates an
+opencl device and reports out-of-bounds memory accesses and uninitialized
+reads performed by the kernels. The command fails if oclgrind reports any
+errors.
+`
+
+	materialPreviewHelp = `
+Render a material expression on a sphere placed on top of a checkered floor and
+lit by a fixed area light. The scene, lighting and random seed never change so
+previews of different materials can be compared side by side. Texture paths in
+the material expression are resolved relative to the current directory.
+`
+
+	contactSheetHelp = `
+Render a scene from a list of preset view angles (front, side, top and
+perspective) and arrange the views into a single contact sheet image. The view
+cameras are positioned so that the scene geometry fits inside each view; shadow
+catchers such as ground planes are ignored when framing the geometry.
+`
+
+	seedSweepHelp = `
+Render a scene multiple times using different random seeds at a low sample
+count and report statistics for the per-pixel variance of the rendered
+luminance. Comparing the variance of sweeps rendered with the same sample
+count quantifies the effect of sampler and integrator changes. Heatmaps of
+the per-pixel variance and relative variance (variance / mean^2) can also be
+saved.
 `
 )
 
@@ -37,6 +92,12 @@ func main() {
 			Name:  "vv",
 			Usage: "enable even more verbose logging",
 		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Value:  "",
+			Usage:  "logging verbosity (debug, info, notice, warning or error); the v and vv flags take precedence",
+			EnvVar: cmd.LogLevelEnvVar,
+		},
 	}
 	app.Commands = []cli.Command{
 		{
@@ -46,15 +107,160 @@ func main() {
 					Name:        "compile",
 					Usage:       "compile text scene representation into a binary compressed format",
 					Description: sceneCompileHelp,
-					ArgsUsage:   "scene_file1.obj scene_file2.obj ...",
-					Action:      cmd.CompileScene,
+					ArgsUsage:   "scene_file1.obj scene_file2.glb ...",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "texture-budget",
+							Value: 0,
+							Usage: "max texture memory in MB; textures are downscaled to fit. Set to 0 to disable",
+						},
+						cli.StringFlag{
+							Name:  "texture-colorspace",
+							Value: "auto",
+							Usage: "color space (auto, srgb or linear) for textures without a -colorspace map option. The auto mode treats 8-bit color textures as sRGB and data textures as linear",
+						},
+						cli.StringFlag{
+							Name:  "roughness-convention",
+							Value: "auto",
+							Usage: "roughness convention (auto, alpha, perceptual, glossiness or smoothness) for materials without a roughness_convention MTL command. Roughness values are treated as GGX alpha values in auto mode",
+						},
+						cli.BoolFlag{
+							Name:  "strict",
+							Usage: "fail on missing textures and other non-fatal scene issues",
+						},
+						cli.BoolFlag{
+							Name:  "conversion-report",
+							Usage: "write a JSON report with the material properties that were approximated or dropped next to each compiled scene",
+						},
+						cli.BoolFlag{
+							Name:  "fix-normals",
+							Usage: "clamp shading normals that deviate from the geometric normal by more than max-normal-angle instead of reporting them",
+						},
+						cli.Float64Flag{
+							Name:  "max-normal-angle",
+							Value: 80,
+							Usage: "max allowed angle (in degrees) between shading and geometric normals",
+						},
+						cli.StringFlag{
+							Name:  "missing-material",
+							Value: "default",
+							Usage: "policy (default, debug or error) for faces without a material. The debug policy assigns a pink material to them",
+						},
+					},
+					Action: cmd.CompileScene,
 				},
 				{
 					Name:      "info",
-					Usage:     "print the size of the various compiled scene assets",
-					ArgsUsage: "scene_file.zip",
+					Usage:     "print scene statistics, validation issues and suggested render settings",
+					ArgsUsage: "scene_file",
 					Action:    cmd.ShowSceneInfo,
 				},
+				{
+					Name:      "inspect",
+					Usage:     "export the scene geometry and BVH for inspection in an external viewer",
+					ArgsUsage: "scene_file",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "obj",
+							Value: "",
+							Usage: "export the scene geometry and BVH node boxes to this wavefront OBJ file",
+						},
+						cli.StringFlag{
+							Name:  "json",
+							Value: "",
+							Usage: "export the BVH nodes and statistics to this JSON file",
+						},
+						cli.IntFlag{
+							Name:  "max-depth",
+							Value: 4,
+							Usage: "max depth of the BVH node boxes exported to the OBJ file; set to -1 to skip the boxes",
+						},
+						cli.BoolFlag{
+							Name:  "no-geometry",
+							Usage: "only export the BVH node boxes to the OBJ file",
+						},
+					},
+					Action: cmd.InspectScene,
+				},
+				{
+					Name:      "diff",
+					Usage:     "report the differences (materials, geometry, lights, instances, textures and cameras) between two compiled scenes",
+					ArgsUsage: "old_scene_file new_scene_file",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "json",
+							Value: "",
+							Usage: "also write the differences to this JSON file",
+						},
+					},
+					Action: cmd.DiffScenes,
+				},
+				{
+					Name:      "bake",
+					Usage:     "bake ambient occlusion and curvature maps for a mesh instance",
+					ArgsUsage: "scene_file",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "instance",
+							Value: 0,
+							Usage: "index of the mesh instance to bake",
+						},
+						cli.StringFlag{
+							Name:  "ao",
+							Value: "",
+							Usage: "save an ambient occlusion map to this PNG file",
+						},
+						cli.StringFlag{
+							Name:  "curvature",
+							Value: "",
+							Usage: "save a curvature map to this PNG file",
+						},
+						cli.IntFlag{
+							Name:  "size",
+							Value: 1024,
+							Usage: "width and height of the baked maps",
+						},
+						cli.IntFlag{
+							Name:  "samples",
+							Value: 64,
+							Usage: "number of ambient occlusion rays per texel",
+						},
+						cli.Float64Flag{
+							Name:  "max-distance",
+							Value: 0,
+							Usage: "max distance for ambient occlusion rays; set to 0 to use 25% of the mesh bounding box diagonal",
+						},
+						cli.Float64Flag{
+							Name:  "cage-offset",
+							Value: 0,
+							Usage: "push ambient occlusion ray origins along the surface normal by this distance; set to 0 to use a small fraction of the mesh size",
+						},
+						cli.BoolFlag{
+							Name:  "self-only",
+							Usage: "only consider occlusion by the baked mesh instance",
+						},
+						cli.IntFlag{
+							Name:  "dilation",
+							Value: 4,
+							Usage: "number of texels to extend the baked texels across uv seams",
+						},
+						cli.BoolFlag{
+							Name:  "unwrap",
+							Usage: "generate lightmap uvs for the baked mesh even if it defines texture coordinates",
+						},
+						cli.IntFlag{
+							Name:  "chart-padding",
+							Value: 4,
+							Usage: "number of texels between generated lightmap uv charts",
+						},
+						cli.StringFlag{
+							Name:  "unwrap-out",
+							Value: "",
+							Usage: "save the scene with the generated lightmap uvs to this zip file",
+						},
+					},
+					Action: cmd.BakeMaps,
+				},
 			},
 		},
 		{
@@ -62,6 +268,223 @@ func main() {
 			Usage:  "list available opencl devices",
 			Action: cmd.ListDevices,
 		},
+		{
+			Name:        "selftest",
+			Usage:       "render a small built-in scene to check the rendering kernels for memory errors",
+			Description: selftestHelp,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "width",
+					Value: 32,
+					Usage: "frame width",
+				},
+				cli.IntFlag{
+					Name:  "height",
+					Value: 32,
+					Usage: "frame height",
+				},
+				cli.IntFlag{
+					Name:  "spp",
+					Value: 2,
+					Usage: "samples per pixel",
+				},
+				cli.IntFlag{
+					Name:  "num-bounces, nb",
+					Value: 3,
+					Usage: "number of indirect ray bounces",
+				},
+				cli.StringFlag{
+					Name:  "oclgrind",
+					Value: "oclgrind",
+					Usage: "name or path of the oclgrind binary",
+				},
+				cli.BoolFlag{
+					Name:  "no-oclgrind",
+					Usage: "run the selftest on the available devices without using oclgrind",
+				},
+			},
+			Action: cmd.SelfTest,
+		},
+		{
+			Name:        "serve",
+			Usage:       "run a control server for submitting render jobs via JSON-RPC",
+			Description: serveHelp,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: "127.0.0.1:8080",
+					Usage: "address to listen for incoming connections",
+				},
+				cli.StringFlag{
+					Name:  "scene-dir",
+					Value: ".",
+					Usage: "root directory for the scene files of submitted jobs",
+				},
+				cli.StringFlag{
+					Name:  "output-dir",
+					Value: ".",
+					Usage: "root directory for the output files of submitted jobs",
+				},
+				cli.StringFlag{
+					Name:  "db",
+					Value: "",
+					Usage: "persist jobs to a job queue file so they survive restarts",
+				},
+				cli.StringSliceFlag{
+					Name:   "blacklist, b",
+					Value:  &cli.StringSlice{},
+					Usage:  "blacklist opencl device whose names contain this value",
+					EnvVar: cmd.DeviceBlacklistEnvVar,
+				},
+				cli.StringFlag{
+					Name:   "force-primary",
+					Value:  "",
+					Usage:  "force a particular device name as the primary device",
+					EnvVar: cmd.ForcePrimaryEnvVar,
+				},
+				cli.BoolFlag{
+					Name:  "share",
+					Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+				},
+			},
+			Action: cmd.Serve,
+		},
+		{
+			Name:        "worker",
+			Usage:       "run a render worker for distributed rendering",
+			Description: workerHelp,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: ":7777",
+					Usage: "address to listen for incoming connections",
+				},
+				cli.StringSliceFlag{
+					Name:   "blacklist, b",
+					Value:  &cli.StringSlice{},
+					Usage:  "blacklist opencl device whose names contain this value",
+					EnvVar: cmd.DeviceBlacklistEnvVar,
+				},
+				cli.BoolFlag{
+					Name:  "share",
+					Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+				},
+				cli.BoolFlag{
+					Name:  "cpu",
+					Usage: "expose the built-in cpu tracer instead of the opencl devices",
+				},
+				cli.IntFlag{
+					Name:  "cpu-workers",
+					Value: 0,
+					Usage: "number of goroutines used by the cpu tracer; set to 0 to use one per available CPU",
+				},
+			},
+			Action: cmd.Worker,
+		},
+		{
+			Name:  "queue",
+			Usage: "manage a persistent queue of batch render jobs",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "add one or more render jobs to the queue",
+					ArgsUsage: "scene_file1.zip scene_file2.obj ...",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "db",
+							Value: "polaris-jobs.db",
+							Usage: "job queue file",
+						},
+						cli.IntFlag{
+							Name:  "width",
+							Value: 1024,
+							Usage: "frame width",
+						},
+						cli.IntFlag{
+							Name:  "height",
+							Value: 1024,
+							Usage: "frame height",
+						},
+						cli.IntFlag{
+							Name:  "spp",
+							Value: 16,
+							Usage: "samples per pixel",
+						},
+						cli.StringFlag{
+							Name:  "spp-schedule",
+							Value: "",
+							Usage: "sample schedule with format initial[:factor[:max]] (e.g. 1:2:64); collects the requested spp using passes of increasing size",
+						},
+						cli.IntFlag{
+							Name:  "num-bounces, nb",
+							Value: 5,
+							Usage: "number of indirect ray bounces",
+						},
+						cli.IntFlag{
+							Name:  "rr-bounces, nr",
+							Value: 3,
+							Usage: "number of indirect ray bounces before applying RR (disabled if 0 or >= than num-bounces)",
+						},
+						cli.Float64Flag{
+							Name:  "exposure",
+							Value: 1.2,
+							Usage: "camera exposure for tone-mapping",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Value: "frame.png",
+							Usage: "image filename for the rendered frame; prefixed with the job index when queuing multiple scenes",
+						},
+						cli.StringFlag{
+							Name:  "camera",
+							Value: "",
+							Usage: "name of the scene camera to render from; defaults to the first camera defined by the scene",
+						},
+					},
+					Action: cmd.QueueJobs,
+				},
+				{
+					Name:  "list",
+					Usage: "list queued jobs and their results",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "db",
+							Value: "polaris-jobs.db",
+							Usage: "job queue file",
+						},
+					},
+					Action: cmd.ListJobs,
+				},
+				{
+					Name:  "run",
+					Usage: "render all pending jobs sequentially and exit",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "db",
+							Value: "polaris-jobs.db",
+							Usage: "job queue file",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+					},
+					Action: cmd.RunJobs,
+				},
+			},
+		},
 		{
 			Name:   "render",
 			Usage:  "render scene",
@@ -83,11 +506,21 @@ func main() {
 							Value: 1024,
 							Usage: "frame height",
 						},
+						cli.StringFlag{
+							Name:  "preset",
+							Value: "",
+							Usage: "render using the settings of a preset (preview, production or debug); explicitly specified flags override the preset settings",
+						},
 						cli.IntFlag{
 							Name:  "spp",
 							Value: 16,
 							Usage: "samples per pixel",
 						},
+						cli.StringFlag{
+							Name:  "spp-schedule",
+							Value: "",
+							Usage: "sample schedule with format initial[:factor[:max]] (e.g. 1:2:64); collects the requested spp using passes of increasing size",
+						},
 						cli.IntFlag{
 							Name:  "num-bounces, nb",
 							Value: 5,
@@ -103,20 +536,260 @@ func main() {
 							Value: 1.2,
 							Usage: "camera exposure for tone-mapping",
 						},
+						cli.BoolFlag{
+							Name:  "light-groups",
+							Usage: "accumulate a separate pass for the scene background and each emissive material so that their intensities can be changed without tracing the frame again",
+						},
 						cli.StringSliceFlag{
-							Name:  "blacklist, b",
+							Name:  "light-scale",
 							Value: &cli.StringSlice{},
-							Usage: "blacklist opencl device whose names contain this value",
+							Usage: "scale the intensity of a light group; specified as group=scale (e.g. 1=0.5) where group 0 is the scene background and groups 1+ the emissive materials in definition order; implies light-groups",
 						},
 						cli.StringFlag{
-							Name:  "force-primary",
-							Value: "",
-							Usage: "force a particular device name as the primary device",
+							Name:  "pixel-filter",
+							Value: "tent",
+							Usage: "filter for distributing samples within each pixel (tent, box, gaussian or point)",
+						},
+						cli.StringFlag{
+							Name:  "ray-order",
+							Value: "morton",
+							Usage: "order for assigning primary rays to pixels (morton, tiled or scanline)",
+						},
+						cli.StringFlag{
+							Name:  "sampler",
+							Value: "sobol",
+							Usage: "sampler for generating the samples of camera paths (sobol, halton, random or blue-noise)",
+						},
+						cli.StringFlag{
+							Name:  "shadows",
+							Value: "traced",
+							Usage: "visibility test for sampled lights (traced or cone); cone shadows approximate soft area light shadows for fast previews",
+						},
+						cli.StringFlag{
+							Name:  "normal-correction",
+							Value: "none",
+							Usage: "correction for shading normals that disagree with the geometric normal (none, clamp or flip)",
+						},
+						cli.StringFlag{
+							Name:  "texture-filter",
+							Value: "ray-differentials",
+							Usage: "mip level selection for texture lookups (ray-differentials or top-mip)",
+						},
+						cli.StringFlag{
+							Name:  "debug-palette",
+							Value: "gray",
+							Usage: "palette for heatmap debug images and sample statistics images (gray, viridis or magma)",
+						},
+						cli.StringFlag{
+							Name:   "debug-dir",
+							Value:  "",
+							Usage:  "output directory for the debug images generated by the debug preset; defaults to the current directory",
+							EnvVar: cmd.DebugDirEnvVar,
+						},
+						cli.Float64Flag{
+							Name:  "clamp-direct",
+							Value: 0,
+							Usage: "clamp direct lighting samples to this value; set to 0 to disable clamping",
+						},
+						cli.Float64Flag{
+							Name:  "clamp-indirect",
+							Value: 0,
+							Usage: "clamp indirect lighting samples to this value; set to 0 to disable clamping",
+						},
+						cli.IntFlag{
+							Name:  "light-path-length",
+							Value: 0,
+							Usage: "trace light subpaths with up to this many vertices and connect them to the camera paths (bidirectional path tracing); set to 0 to disable",
+						},
+						cli.BoolFlag{
+							Name:  "ao-preview",
+							Usage: "render a fast ambient occlusion preview instead of path tracing the scene",
+						},
+						cli.Float64Flag{
+							Name:  "ao-distance",
+							Value: 0,
+							Usage: "max distance of ambient occlusion rays; set to 0 for unlimited rays",
+						},
+						cli.BoolFlag{
+							Name:  "direct-only",
+							Usage: "only render direct lighting without tracing indirect bounces",
+						},
+						cli.Int64Flag{
+							Name:  "seed",
+							Value: 0,
+							Usage: "seed for the random number generators; a non-zero value makes renders reproducible",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+						cli.StringSliceFlag{
+							Name:  "cl-define",
+							Value: &cli.StringSlice{},
+							Usage: "pass a define to the opencl kernel compiler using the format [DEVICE:]NAME[=VALUE]; the define only applies to devices whose names contain DEVICE",
+						},
+						cli.StringSliceFlag{
+							Name:  "cl-option",
+							Value: &cli.StringSlice{},
+							Usage: "pass an option (e.g. -cl-fast-relaxed-math) to the opencl kernel compiler using the format [DEVICE:]OPTION; the option only applies to devices whose names contain DEVICE",
+						},
+						cli.IntFlag{
+							Name:  "bvh-stack-size",
+							Value: 0,
+							Usage: "size of the BVH traversal stack used by the opencl kernels; increase it for scenes with very deep BVH trees (set to 0 to use the default size)",
+						},
+						cli.BoolFlag{
+							Name:  "compressed-bvh",
+							Usage: "traverse a compressed BVH with 8-wide nodes and quantized bounds to reduce memory bandwidth",
+						},
+						cli.BoolFlag{
+							Name:  "cpu",
+							Usage: "render using the built-in cpu tracer instead of the opencl devices",
+						},
+						cli.IntFlag{
+							Name:  "cpu-workers",
+							Value: 0,
+							Usage: "number of goroutines used by the cpu tracer; set to 0 to use one per available CPU",
+						},
+						cli.StringSliceFlag{
+							Name:  "remote",
+							Value: &cli.StringSlice{},
+							Usage: "render using the tracers of the worker listening at this address (host:port) instead of the local devices",
+						},
+						cli.StringFlag{
+							Name:  "scheduler",
+							Value: "dynamic",
+							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, dynamic",
+						},
+						cli.Int64Flag{
+							Name:  "ray-budget",
+							Value: 0,
+							Usage: "max number of rays traced by each rendering pass; set to 0 to disable",
+						},
+						cli.Float64Flag{
+							Name:  "time-budget",
+							Value: 0,
+							Usage: "max time in milliseconds spent by each rendering pass; set to 0 to disable",
+						},
+						cli.BoolFlag{
+							Name:  "compensated-sum",
+							Usage: "accumulate samples using compensated (Kahan) summation to avoid precision loss at very high sample counts",
+						},
+						cli.IntFlag{
+							Name:  "texture-streaming",
+							Value: 0,
+							Usage: "stream textures based on their visibility and keep at most this many MB of texture data on each opencl device; set to 0 to upload all textures",
 						},
 						cli.StringFlag{
 							Name:  "out, o",
 							Value: "frame.png",
-							Usage: "image filename for the rendered frame",
+							Usage: "image filename for the rendered frame; the .png, .jpg and .tiff extensions select the output format",
+						},
+						cli.IntFlag{
+							Name:  "bit-depth",
+							Value: 8,
+							Usage: "bits per channel (8 or 16) for PNG and TIFF frames",
+						},
+						cli.BoolFlag{
+							Name:  "tiff-float",
+							Usage: "save the linear radiance as a TIFF with 32-bit float channels",
+						},
+						cli.IntFlag{
+							Name:  "jpeg-quality",
+							Value: 90,
+							Usage: "the encoding quality (1-100) for JPEG frames",
+						},
+						cli.Float64Flag{
+							Name:  "dpi",
+							Value: 0,
+							Usage: "embed the print resolution in dots per inch into the saved frame",
+						},
+						cli.StringFlag{
+							Name:  "burn-in",
+							Value: "",
+							Usage: "burn text into the saved frame; supports the {scene}, {frame}, {spp} and {date} placeholders",
+						},
+						cli.StringFlag{
+							Name:  "burn-in-position",
+							Value: "bottom-left",
+							Usage: "the frame corner for the burned-in text (bottom-left, bottom-right, top-left or top-right)",
+						},
+						cli.IntFlag{
+							Name:  "frame-number",
+							Value: 1,
+							Usage: "the frame number for the {frame} burn-in placeholder",
+						},
+						cli.StringFlag{
+							Name:  "watermark",
+							Value: "",
+							Usage: "a PNG or JPEG image to blend over the saved frame",
+						},
+						cli.StringFlag{
+							Name:  "watermark-position",
+							Value: "bottom-right",
+							Usage: "the frame corner for the watermark image (bottom-left, bottom-right, top-left or top-right)",
+						},
+						cli.Float64Flag{
+							Name:  "watermark-opacity",
+							Value: 0.5,
+							Usage: "the opacity of the watermark image in the [0, 1] range",
+						},
+						cli.StringFlag{
+							Name:  "aov-samples",
+							Value: "",
+							Usage: "save an image with the number of samples collected for each pixel",
+						},
+						cli.StringFlag{
+							Name:  "aov-error",
+							Value: "",
+							Usage: "save an image with the estimated relative error of each pixel",
+						},
+						cli.StringFlag{
+							Name:  "camera-rays",
+							Value: "",
+							Usage: "generate primary rays using a binary file with the origin and direction of each pixel ray instead of the scene camera",
+						},
+						cli.StringSliceFlag{
+							Name:  "lpe",
+							Value: &cli.StringSlice{},
+							Usage: "render a custom output pass for the light paths matching an expression; specified as name=expression (e.g. diffuse=CDL)",
+						},
+						cli.StringFlag{
+							Name:  "lpe-out",
+							Value: "pass-{pass}.tiff",
+							Usage: "image file pattern for saving light path passes; {pass} is replaced by the pass name",
+						},
+						cli.StringFlag{
+							Name:  "camera",
+							Value: "",
+							Usage: "name of the scene camera to render from; defaults to the first camera defined by the scene",
+						},
+						cli.StringFlag{
+							Name:  "bookmark",
+							Value: "",
+							Usage: "restore the camera and render settings from the bookmark with this name",
+						},
+						cli.StringFlag{
+							Name:  "bookmarks",
+							Value: "",
+							Usage: "bookmark file to use; defaults to a sidecar file next to the scene file (e.g. scene.bookmarks.json)",
+						},
+						cli.StringFlag{
+							Name:  "shm",
+							Value: "",
+							Usage: "publish rendered frames to a memory-mapped file (e.g. /dev/shm/polaris)",
 						},
 					},
 					Action: cmd.RenderFrame,
@@ -136,11 +809,21 @@ func main() {
 							Value: 1024,
 							Usage: "frame height",
 						},
+						cli.StringFlag{
+							Name:  "preset",
+							Value: "",
+							Usage: "render using the settings of a preset (preview, production or debug); explicitly specified flags override the preset settings",
+						},
 						cli.IntFlag{
 							Name:  "spp",
 							Value: 0,
 							Usage: "samples per pixel; setting to 0 enables progressive rendering",
 						},
+						cli.StringFlag{
+							Name:  "spp-schedule",
+							Value: "",
+							Usage: "sample schedule with format initial[:factor[:max]] (e.g. 1:2:64); collects the requested spp using passes of increasing size",
+						},
 						cli.IntFlag{
 							Name:  "num-bounces, nb",
 							Value: 5,
@@ -156,24 +839,397 @@ func main() {
 							Value: 1.2,
 							Usage: "camera exposure for tone-mapping",
 						},
+						cli.BoolFlag{
+							Name:  "light-groups",
+							Usage: "accumulate a separate pass for the scene background and each emissive material so that their intensities can be changed without tracing the frame again",
+						},
 						cli.StringSliceFlag{
-							Name:  "blacklist, b",
+							Name:  "light-scale",
 							Value: &cli.StringSlice{},
-							Usage: "blacklist opencl device whose names contain this value",
+							Usage: "scale the intensity of a light group; specified as group=scale (e.g. 1=0.5) where group 0 is the scene background and groups 1+ the emissive materials in definition order; implies light-groups",
 						},
 						cli.StringFlag{
-							Name:  "force-primary",
-							Value: "",
-							Usage: "force a particular device name as the primary device",
+							Name:  "pixel-filter",
+							Value: "tent",
+							Usage: "filter for distributing samples within each pixel (tent, box, gaussian or point)",
+						},
+						cli.StringFlag{
+							Name:  "ray-order",
+							Value: "morton",
+							Usage: "order for assigning primary rays to pixels (morton, tiled or scanline)",
+						},
+						cli.StringFlag{
+							Name:  "sampler",
+							Value: "sobol",
+							Usage: "sampler for generating the samples of camera paths (sobol, halton, random or blue-noise)",
+						},
+						cli.StringFlag{
+							Name:  "shadows",
+							Value: "traced",
+							Usage: "visibility test for sampled lights (traced or cone); cone shadows approximate soft area light shadows for fast previews",
+						},
+						cli.StringFlag{
+							Name:  "normal-correction",
+							Value: "none",
+							Usage: "correction for shading normals that disagree with the geometric normal (none, clamp or flip)",
+						},
+						cli.StringFlag{
+							Name:  "texture-filter",
+							Value: "ray-differentials",
+							Usage: "mip level selection for texture lookups (ray-differentials or top-mip)",
+						},
+						cli.StringFlag{
+							Name:  "debug-palette",
+							Value: "gray",
+							Usage: "palette for heatmap debug images and sample statistics images (gray, viridis or magma)",
+						},
+						cli.StringFlag{
+							Name:   "debug-dir",
+							Value:  "",
+							Usage:  "output directory for the debug images generated by the debug preset; defaults to the current directory",
+							EnvVar: cmd.DebugDirEnvVar,
+						},
+						cli.Float64Flag{
+							Name:  "clamp-direct",
+							Value: 0,
+							Usage: "clamp direct lighting samples to this value; set to 0 to disable clamping",
+						},
+						cli.Float64Flag{
+							Name:  "clamp-indirect",
+							Value: 0,
+							Usage: "clamp indirect lighting samples to this value; set to 0 to disable clamping",
+						},
+						cli.IntFlag{
+							Name:  "light-path-length",
+							Value: 0,
+							Usage: "trace light subpaths with up to this many vertices and connect them to the camera paths (bidirectional path tracing); set to 0 to disable",
+						},
+						cli.BoolFlag{
+							Name:  "ao-preview",
+							Usage: "render a fast ambient occlusion preview instead of path tracing the scene",
+						},
+						cli.Float64Flag{
+							Name:  "ao-distance",
+							Value: 0,
+							Usage: "max distance of ambient occlusion rays; set to 0 for unlimited rays",
+						},
+						cli.BoolFlag{
+							Name:  "direct-only",
+							Usage: "only render direct lighting without tracing indirect bounces",
+						},
+						cli.Int64Flag{
+							Name:  "seed",
+							Value: 0,
+							Usage: "seed for the random number generators; a non-zero value makes renders reproducible",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+						cli.StringSliceFlag{
+							Name:  "cl-define",
+							Value: &cli.StringSlice{},
+							Usage: "pass a define to the opencl kernel compiler using the format [DEVICE:]NAME[=VALUE]; the define only applies to devices whose names contain DEVICE",
+						},
+						cli.StringSliceFlag{
+							Name:  "cl-option",
+							Value: &cli.StringSlice{},
+							Usage: "pass an option (e.g. -cl-fast-relaxed-math) to the opencl kernel compiler using the format [DEVICE:]OPTION; the option only applies to devices whose names contain DEVICE",
+						},
+						cli.IntFlag{
+							Name:  "bvh-stack-size",
+							Value: 0,
+							Usage: "size of the BVH traversal stack used by the opencl kernels; increase it for scenes with very deep BVH trees (set to 0 to use the default size)",
+						},
+						cli.BoolFlag{
+							Name:  "compressed-bvh",
+							Usage: "traverse a compressed BVH with 8-wide nodes and quantized bounds to reduce memory bandwidth",
+						},
+						cli.Int64Flag{
+							Name:  "ray-budget",
+							Value: 0,
+							Usage: "max number of rays traced by each rendering pass; set to 0 to disable",
+						},
+						cli.Float64Flag{
+							Name:  "time-budget",
+							Value: 0,
+							Usage: "max time in milliseconds spent by each rendering pass; set to 0 to disable",
+						},
+						cli.BoolFlag{
+							Name:  "compensated-sum",
+							Usage: "accumulate samples using compensated (Kahan) summation to avoid precision loss at very high sample counts",
+						},
+						cli.IntFlag{
+							Name:  "texture-streaming",
+							Value: 0,
+							Usage: "stream textures based on their visibility and keep at most this many MB of texture data on each opencl device; set to 0 to upload all textures",
 						},
 						cli.StringFlag{
 							Name:  "scheduler",
 							Value: "perfect",
-							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect",
+							Usage: "select a particular block scheduling algorithm; supported algorithms: naive, perfect, dynamic",
+						},
+						cli.StringFlag{
+							Name:  "camera",
+							Value: "",
+							Usage: "name of the scene camera to render from; defaults to the first camera defined by the scene",
+						},
+						cli.StringFlag{
+							Name:  "bookmark",
+							Value: "",
+							Usage: "restore the camera and render settings from the bookmark with this name",
+						},
+						cli.StringFlag{
+							Name:  "bookmarks",
+							Value: "",
+							Usage: "bookmark file to use; defaults to a sidecar file next to the scene file (e.g. scene.bookmarks.json)",
+						},
+						cli.StringFlag{
+							Name:  "shm",
+							Value: "",
+							Usage: "publish rendered frames to a memory-mapped file (e.g. /dev/shm/polaris)",
+						},
+						cli.Float64Flag{
+							Name:  "parallax-preview",
+							Value: 0,
+							Usage: "preview bump maps as height maps using parallax mapping with the given uv scale (e.g. 0.05); 0 disables the preview",
+						},
+						cli.BoolFlag{
+							Name:  "first-hit-cache",
+							Usage: "resolve primary visibility once per camera change and start paths from the cached first hit; disables anti-aliasing and depth of field",
+						},
+						cli.IntFlag{
+							Name:  "history",
+							Value: 8,
+							Usage: "number of frames to keep for flip-book review; set to 0 to disable the frame history",
+						},
+						cli.StringFlag{
+							Name:  "history-file",
+							Value: "",
+							Usage: "keep the frame history in a memory-mapped file instead of host memory",
+						},
+						cli.BoolFlag{
+							Name:  "resizable",
+							Usage: "allow resizing the window; the frame keeps its dimensions and is scaled to fit the window",
 						},
 					},
 					Action: cmd.RenderInteractive,
 				},
+				{
+					Name:        "material",
+					Usage:       "render material preview",
+					Description: materialPreviewHelp,
+					ArgsUsage:   "material_expression",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "width",
+							Value: 128,
+							Usage: "preview width",
+						},
+						cli.IntFlag{
+							Name:  "height",
+							Value: 128,
+							Usage: "preview height",
+						},
+						cli.IntFlag{
+							Name:  "spp",
+							Value: 64,
+							Usage: "samples per pixel",
+						},
+						cli.IntFlag{
+							Name:  "num-bounces, nb",
+							Value: 5,
+							Usage: "number of indirect ray bounces",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Value: "material.png",
+							Usage: "image filename for the rendered preview",
+						},
+					},
+					Action: cmd.RenderMaterialPreview,
+				},
+				{
+					Name:        "contact-sheet",
+					Usage:       "render a scene from multiple view angles into a single image",
+					Description: contactSheetHelp,
+					ArgsUsage:   "scene_file",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "width",
+							Value: 256,
+							Usage: "view tile width",
+						},
+						cli.IntFlag{
+							Name:  "height",
+							Value: 256,
+							Usage: "view tile height",
+						},
+						cli.StringSliceFlag{
+							Name:  "view",
+							Value: &cli.StringSlice{},
+							Usage: "a view angle to render (front, side, top or perspective); may be specified multiple times. Defaults to all views",
+						},
+						cli.IntFlag{
+							Name:  "columns",
+							Value: 0,
+							Usage: "number of tile columns; 0 arranges the tiles in a square grid",
+						},
+						cli.BoolFlag{
+							Name:  "labels",
+							Usage: "label each tile with the name of its view angle",
+						},
+						cli.IntFlag{
+							Name:  "spp",
+							Value: 64,
+							Usage: "samples per pixel",
+						},
+						cli.IntFlag{
+							Name:  "num-bounces, nb",
+							Value: 5,
+							Usage: "number of indirect ray bounces",
+						},
+						cli.Float64Flag{
+							Name:  "exposure",
+							Value: 1.0,
+							Usage: "camera exposure for tone-mapping",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Value: "contact-sheet.png",
+							Usage: "image filename for the rendered contact sheet",
+						},
+					},
+					Action: cmd.RenderContactSheet,
+				},
+				{
+					Name:        "seed-sweep",
+					Usage:       "measure the per-pixel variance of renders using different seeds",
+					Description: seedSweepHelp,
+					ArgsUsage:   "scene_file",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "width",
+							Value: 256,
+							Usage: "frame width",
+						},
+						cli.IntFlag{
+							Name:  "height",
+							Value: 256,
+							Usage: "frame height",
+						},
+						cli.IntFlag{
+							Name:  "spp",
+							Value: 4,
+							Usage: "samples per pixel for each seed",
+						},
+						cli.IntFlag{
+							Name:  "num-bounces, nb",
+							Value: 5,
+							Usage: "number of indirect ray bounces",
+						},
+						cli.IntFlag{
+							Name:  "rr-bounces, nr",
+							Value: 0,
+							Usage: "min bounces before applying russian roulette for path elimination; 0 disables russian roulette",
+						},
+						cli.IntFlag{
+							Name:  "seeds",
+							Value: 16,
+							Usage: "number of seeds to render",
+						},
+						cli.Int64Flag{
+							Name:  "first-seed",
+							Value: 1,
+							Usage: "the first seed; consecutive seeds are used for the remaining renders",
+						},
+						cli.StringFlag{
+							Name:  "pixel-filter",
+							Value: "tent",
+							Usage: "filter for distributing samples within each pixel (tent, box, gaussian or point)",
+						},
+						cli.StringFlag{
+							Name:  "variance-out",
+							Value: "",
+							Usage: "save a heatmap of the per-pixel variance to this file",
+						},
+						cli.StringFlag{
+							Name:  "relative-variance-out",
+							Value: "",
+							Usage: "save a heatmap of the per-pixel relative variance to this file",
+						},
+						cli.StringFlag{
+							Name:  "debug-palette",
+							Value: "viridis",
+							Usage: "color palette for the heatmaps (gray, viridis or magma)",
+						},
+						cli.BoolFlag{
+							Name:  "json",
+							Usage: "print the variance statistics as json",
+						},
+						cli.StringSliceFlag{
+							Name:   "blacklist, b",
+							Value:  &cli.StringSlice{},
+							Usage:  "blacklist opencl device whose names contain this value",
+							EnvVar: cmd.DeviceBlacklistEnvVar,
+						},
+						cli.StringFlag{
+							Name:   "force-primary",
+							Value:  "",
+							Usage:  "force a particular device name as the primary device",
+							EnvVar: cmd.ForcePrimaryEnvVar,
+						},
+						cli.BoolFlag{
+							Name:  "share",
+							Usage: "render on devices that are in use by other polaris processes instead of skipping them",
+						},
+					},
+					Action: cmd.RenderSeedSweep,
+				},
 			},
 		},
 	}
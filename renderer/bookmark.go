@@ -0,0 +1,179 @@
+package renderer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+)
+
+// The version of the bookmark file layout.
+const bookmarkFileVersion = 1
+
+// A Bookmark is a named snapshot of the render state that allows users to
+// jump back to a previously saved view.
+type Bookmark struct {
+	Name    string               `json:"name"`
+	Created time.Time            `json:"created"`
+	Camera  scene.CameraSnapshot `json:"camera"`
+
+	// Exposure for tonemapping.
+	Exposure float32 `json:"exposure"`
+
+	// Optional render setting overrides. Zero values keep the current
+	// settings when the bookmark is applied.
+	NumBounces      uint32 `json:"num_bounces,omitempty"`
+	MinBouncesForRR uint32 `json:"rr_bounces,omitempty"`
+	SamplesPerPixel uint32 `json:"spp,omitempty"`
+}
+
+// Create a bookmark for the current camera state and render options.
+func NewBookmark(name string, camera *scene.Camera, opts Options) Bookmark {
+	return Bookmark{
+		Name:            name,
+		Created:         time.Now().UTC(),
+		Camera:          camera.Snapshot(),
+		Exposure:        opts.Exposure,
+		NumBounces:      opts.NumBounces,
+		MinBouncesForRR: opts.MinBouncesForRR,
+		SamplesPerPixel: opts.SamplesPerPixel,
+	}
+}
+
+// Restore the bookmarked camera state and return a copy of opts with the
+// bookmarked settings applied.
+func (b Bookmark) Apply(camera *scene.Camera, opts Options) Options {
+	camera.Restore(b.Camera)
+
+	if b.Exposure > 0 {
+		opts.Exposure = b.Exposure
+	}
+	if b.NumBounces != 0 {
+		opts.NumBounces = b.NumBounces
+	}
+	if b.MinBouncesForRR != 0 {
+		opts.MinBouncesForRR = b.MinBouncesForRR
+	}
+	if b.SamplesPerPixel != 0 {
+		opts.SamplesPerPixel = b.SamplesPerPixel
+	}
+	return opts
+}
+
+// A set of bookmarks persisted to a JSON file.
+type Bookmarks struct {
+	path string
+	list []Bookmark
+}
+
+type bookmarkFile struct {
+	Version   int        `json:"version"`
+	Bookmarks []Bookmark `json:"bookmarks"`
+}
+
+// Get the path of the bookmark sidecar file for a scene file. The sidecar is
+// stored next to the scene file and shares its base name.
+func BookmarkFile(sceneFile string) string {
+	return strings.TrimSuffix(sceneFile, filepath.Ext(sceneFile)) + ".bookmarks.json"
+}
+
+// Load the bookmarks stored in a JSON file. If the file does not exist, an
+// empty bookmark set is returned which is written to the file when saved.
+func LoadBookmarks(path string) (*Bookmarks, error) {
+	set := &Bookmarks{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return set, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var file bookmarkFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", ErrInvalidBookmarkFile.Error(), err.Error())
+	}
+	if file.Version != bookmarkFileVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", ErrInvalidBookmarkFile.Error(), file.Version)
+	}
+
+	set.list = file.Bookmarks
+	return set, nil
+}
+
+// Get the path of the file backing the bookmark set.
+func (b *Bookmarks) Path() string {
+	return b.path
+}
+
+// Get the sorted list of bookmark names.
+func (b *Bookmarks) Names() []string {
+	names := make([]string, 0, len(b.list))
+	for _, bm := range b.list {
+		names = append(names, bm.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup a bookmark by name.
+func (b *Bookmarks) Get(name string) (Bookmark, error) {
+	for _, bm := range b.list {
+		if bm.Name == name {
+			return bm, nil
+		}
+	}
+
+	return Bookmark{}, fmt.Errorf("%s %q; available bookmarks: %s", ErrUnknownBookmark.Error(), name, strings.Join(b.Names(), ", "))
+}
+
+// Add a bookmark to the set replacing any existing bookmark with the same name.
+func (b *Bookmarks) Set(bm Bookmark) {
+	for index := range b.list {
+		if b.list[index].Name == bm.Name {
+			b.list[index] = bm
+			return
+		}
+	}
+	b.list = append(b.list, bm)
+}
+
+// Remove a bookmark by name.
+func (b *Bookmarks) Remove(name string) error {
+	for index, bm := range b.list {
+		if bm.Name == name {
+			b.list = append(b.list[:index], b.list[index+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%s %q", ErrUnknownBookmark.Error(), name)
+}
+
+// Write the bookmarks to the backing file. The bookmarks are written to a
+// temporary file which then replaces the backing file so that an interrupted
+// write does not corrupt previously saved bookmarks.
+func (b *Bookmarks) Save() error {
+	data, err := json.MarshalIndent(bookmarkFile{
+		Version:   bookmarkFileVersion,
+		Bookmarks: b.list,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile := b.path + ".tmp"
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile, b.path)
+}
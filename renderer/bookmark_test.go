@@ -0,0 +1,106 @@
+package renderer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestBookmarkFile(t *testing.T) {
+	specs := []struct {
+		in, exp string
+	}{
+		{"scenes/sponza.zip", "scenes/sponza.bookmarks.json"},
+		{"sponza.obj", "sponza.bookmarks.json"},
+		{"sponza", "sponza.bookmarks.json"},
+	}
+
+	for index, spec := range specs {
+		if got := BookmarkFile(spec.in); got != spec.exp {
+			t.Errorf("[spec %d] expected bookmark file for %q to be %q; got %q", index, spec.in, spec.exp, got)
+		}
+	}
+}
+
+func TestBookmarkApply(t *testing.T) {
+	cam := scene.NewCamera(1.0)
+	cam.Position = types.XYZ(1, 2, 3)
+	cam.SetupFrame(100, 100)
+	bm := NewBookmark("view", cam, Options{Exposure: 2, NumBounces: 7})
+
+	cam.Position = types.XYZ(0, 0, 10)
+	opts := bm.Apply(cam, Options{Exposure: 1, NumBounces: 3, MinBouncesForRR: 2})
+
+	if cam.Position != types.XYZ(1, 2, 3) {
+		t.Fatalf("expected camera position to be restored; got %v", cam.Position)
+	}
+	exp := Options{Exposure: 2, NumBounces: 7, MinBouncesForRR: 2}
+	if !reflect.DeepEqual(opts, exp) {
+		t.Fatalf("expected options %+v; got %+v", exp, opts)
+	}
+}
+
+func TestBookmarksPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bmFile := filepath.Join(dir, "scene.bookmarks.json")
+	set, err := LoadBookmarks(bmFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Names()) != 0 {
+		t.Fatalf("expected missing bookmark file to yield an empty set; got %v", set.Names())
+	}
+
+	cam := scene.NewCamera(1.0)
+	set.Set(NewBookmark("b", cam, Options{Exposure: 1}))
+	set.Set(NewBookmark("a", cam, Options{Exposure: 1}))
+	set.Set(NewBookmark("b", cam, Options{Exposure: 3}))
+	err = set.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err = LoadBookmarks(bmFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := set.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("expected bookmarks [a b]; got %v", names)
+	}
+	bm, err := set.Get("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bm.Exposure != 3 {
+		t.Fatalf("expected bookmark to be replaced; got exposure %f", bm.Exposure)
+	}
+
+	err = set.Remove("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = set.Get("a"); err == nil {
+		t.Fatal("expected to get an error for a removed bookmark")
+	}
+	if err = set.Remove("a"); err == nil {
+		t.Fatal("expected to get an error when removing an unknown bookmark")
+	}
+
+	err = ioutil.WriteFile(bmFile, []byte(`{"version": 99}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadBookmarks(bmFile); err == nil {
+		t.Fatal("expected to get an error when loading an unsupported bookmark file version")
+	}
+}
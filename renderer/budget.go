@@ -0,0 +1,52 @@
+package renderer
+
+import "time"
+
+// Get an upper bound for the number of rays traced for each sample. Each path
+// traces a primary or indirect ray and a shadow ray for every bounce.
+func raysPerSample(opts Options) uint64 {
+	return 2 * uint64(opts.NumBounces+1)
+}
+
+// Check whether the options define a ray or time budget.
+func (opts Options) hasBudget() bool {
+	return opts.RayBudget != 0 || opts.TimeBudget != 0
+}
+
+// Cap the number of samples per pixel collected by a pass so that the pass
+// fits within the configured ray and time budgets. The time budget is enforced
+// using the time per sample measured for the previous pass. Passes always
+// collect at least one sample per pixel.
+func (r *defaultRenderer) budgetBatchSize(spp uint32) uint32 {
+	maxSpp := uint64(spp)
+
+	if r.options.RayBudget != 0 {
+		raysPerPass := uint64(r.options.FrameW) * uint64(r.options.FrameH) * raysPerSample(r.options)
+		if raysPerPass != 0 && r.options.RayBudget/raysPerPass < maxSpp {
+			maxSpp = r.options.RayBudget / raysPerPass
+		}
+	}
+
+	if r.options.TimeBudget != 0 && r.sampleTime > 0 {
+		if timeSpp := uint64(r.options.TimeBudget / r.sampleTime); timeSpp < maxSpp {
+			maxSpp = timeSpp
+		}
+	}
+
+	if maxSpp == 0 {
+		if !r.budgetWarned {
+			r.logger.Warning("the configured budget does not fit a single sample per pixel; collecting 1 sample per pass")
+			r.budgetWarned = true
+		}
+		return 1
+	}
+
+	return uint32(maxSpp)
+}
+
+// Record the time per sample for a rendered pass.
+func (r *defaultRenderer) recordSampleTime(renderTime time.Duration, spp uint32) {
+	if spp != 0 {
+		r.sampleTime = renderTime / time.Duration(spp)
+	}
+}
@@ -0,0 +1,57 @@
+package renderer
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/log"
+)
+
+func TestRayBudgetBatchSize(t *testing.T) {
+	// Each sample of a 10x10 frame with 4 bounces traces up to 1000 rays
+	r := &defaultRenderer{
+		logger:  log.New("renderer"),
+		options: Options{FrameW: 10, FrameH: 10, NumBounces: 4, SamplesPerPixel: 10, RayBudget: 3500},
+	}
+
+	var got []uint32
+	for r.accumulatedSamples < r.options.SamplesPerPixel {
+		spp := r.nextBatchSize()
+		got = append(got, spp)
+		r.accumulatedSamples += spp
+		r.accumulatedPasses++
+	}
+
+	exp := []uint32{3, 3, 3, 1}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected batches %v; got %v", exp, got)
+	}
+
+	// Passes should collect at least one sample
+	r.options.RayBudget = 10
+	r.accumulatedSamples = 0
+	if spp := r.nextBatchSize(); spp != 1 {
+		t.Fatalf("expected batch size to be 1 when the budget does not fit a single sample; got %d", spp)
+	}
+	if !r.budgetWarned {
+		t.Fatal("expected a warning to be logged when the budget does not fit a single sample")
+	}
+}
+
+func TestTimeBudgetBatchSize(t *testing.T) {
+	r := &defaultRenderer{
+		logger:  log.New("renderer"),
+		options: Options{SamplesPerPixel: 64, TimeBudget: 50 * time.Millisecond},
+	}
+
+	// Without a time measurement the budget cannot be enforced
+	if spp := r.nextBatchSize(); spp != 64 {
+		t.Fatalf("expected batch size to be 64; got %d", spp)
+	}
+
+	r.recordSampleTime(80*time.Millisecond, 8)
+	if spp := r.nextBatchSize(); spp != 5 {
+		t.Fatalf("expected batch size to be 5; got %d", spp)
+	}
+}
@@ -0,0 +1,136 @@
+package renderer
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+)
+
+const (
+	// Defaults for contact sheet renders.
+	defaultContactSheetTileSize uint32 = 256
+	defaultContactSheetSamples  uint32 = 64
+)
+
+// Options for rendering contact sheets.
+type ContactSheetOptions struct {
+	// The view angles to render. If not specified, the scene is rendered
+	// using the scene.DefaultViewAngles list.
+	Views []scene.ViewAngle
+
+	// The dims of each view tile. If not specified, 256x256 tiles are rendered.
+	TileW uint32
+	TileH uint32
+
+	// The number of tile columns. If zero, tiles are arranged in a grid
+	// that is as close to a square as possible.
+	Columns int
+
+	// Label each tile with the name of its view angle.
+	Labels bool
+
+	// Number of samples per pixel for each view. Defaults to 64.
+	SamplesPerPixel uint32
+
+	// Number of indirect bounces. Defaults to 5.
+	NumBounces uint32
+
+	// The exposure value for tone-mapping the views. Defaults to 1.
+	Exposure float32
+
+	// Device selection.
+	BlackListedDevices []string
+	ForcePrimaryDevice string
+	ShareDevices       bool
+}
+
+// Render the scene from a list of preset view angles and arrange the rendered
+// views into a single contact sheet image. The view cameras are positioned so
+// that the scene geometry fits inside each view; the scene cameras are not
+// modified. All views are rendered using the same renderer instance and the
+// same random seed.
+func RenderContactSheet(sc *scene.Scene, opts ContactSheetOptions) (*image.RGBA, error) {
+	if len(opts.Views) == 0 {
+		opts.Views = scene.DefaultViewAngles
+	}
+	if opts.TileW == 0 {
+		opts.TileW = defaultContactSheetTileSize
+	}
+	if opts.TileH == 0 {
+		opts.TileH = defaultContactSheetTileSize
+	}
+	if opts.Columns <= 0 {
+		opts.Columns = int(math.Ceil(math.Sqrt(float64(len(opts.Views)))))
+	}
+	if opts.SamplesPerPixel == 0 {
+		opts.SamplesPerPixel = defaultContactSheetSamples
+	}
+	if opts.NumBounces == 0 {
+		opts.NumBounces = defaultPreviewNumBounces
+	}
+	if opts.Exposure == 0 {
+		opts.Exposure = 1.0
+	}
+
+	cameras := make([]*scene.Camera, len(opts.Views))
+	for index, view := range opts.Views {
+		cameras[index] = sc.ViewCamera(view)
+		cameras[index].SetupFrame(opts.TileW, opts.TileH)
+	}
+
+	renderOpts := Options{
+		FrameW:             opts.TileW,
+		FrameH:             opts.TileH,
+		NumBounces:         opts.NumBounces,
+		MinBouncesForRR:    opts.NumBounces + 1,
+		SamplesPerPixel:    opts.SamplesPerPixel,
+		Exposure:           opts.Exposure,
+		Seed:               previewSeed,
+		BlackListedDevices: opts.BlackListedDevices,
+		ForcePrimaryDevice: opts.ForcePrimaryDevice,
+		ShareDevices:       opts.ShareDevices,
+	}
+
+	// The renderer uploads the active scene camera when it is created
+	activeCamera := sc.Camera
+	sc.Camera = cameras[0]
+	r, err := NewDefault(sc, tracer.NaiveScheduler(), opencl.DefaultPipeline(), renderOpts)
+	sc.Camera = activeCamera
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	rows := (len(opts.Views) + opts.Columns - 1) / opts.Columns
+	sheet := image.NewRGBA(image.Rect(0, 0, opts.Columns*int(opts.TileW), rows*int(opts.TileH)))
+	tile := image.NewRGBA(image.Rect(0, 0, int(opts.TileW), int(opts.TileH)))
+	for index, view := range opts.Views {
+		if index > 0 {
+			r.UpdateCamera(cameras[index])
+		}
+
+		err = r.Render()
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.ReadFrame(tile)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Labels {
+			label := &opencl.Overlay{Text: view.String(), TextPosition: opencl.TopLeft}
+			label.Apply(tile, &tracer.BlockRequest{})
+		}
+
+		origin := image.Pt((index%opts.Columns)*int(opts.TileW), (index/opts.Columns)*int(opts.TileH))
+		draw.Draw(sheet, tile.Bounds().Add(origin), tile, image.Point{}, draw.Src)
+	}
+
+	return sheet, nil
+}
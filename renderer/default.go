@@ -1,6 +1,7 @@
 package renderer
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -14,6 +15,16 @@ import (
 	"github.com/achilleasa/polaris/tracer/opencl/device"
 )
 
+// The interval between polls of the device thermal readings.
+const thermalPollInterval = 5 * time.Second
+
+// A block request queued for a tracer worker together with the context of
+// the frame that it belongs to.
+type blockJob struct {
+	ctx      context.Context
+	blockReq tracer.BlockRequest
+}
+
 type defaultRenderer struct {
 	logger log.Logger
 
@@ -25,7 +36,7 @@ type defaultRenderer struct {
 
 	// The list of registered tracers.
 	tracers         []tracer.Tracer
-	jobChans        []chan tracer.BlockRequest
+	jobChans        []chan blockJob
 	jobCompleteChan chan error
 
 	// The selected primary tracer.
@@ -39,14 +50,32 @@ type defaultRenderer struct {
 
 	// Renderer statistics.
 	stats FrameStats
+
+	// The block request used for post-processing the last rendered frame.
+	lastFrameReq *tracer.BlockRequest
+
+	// The number of samples and passes collected via calls to Accumulate.
+	accumulatedSamples uint32
+	accumulatedPasses  uint32
+
+	// The time when device thermals were last polled.
+	lastThermalPoll time.Time
+
+	// Exclusive locks for the devices used by the tracers.
+	deviceLocks []*device.Lock
+
+	// The time per sample measured for the last pass; used for enforcing
+	// the time budget.
+	sampleTime   time.Duration
+	budgetWarned bool
 }
 
 // Create a new default renderer using the specified block scheduler and tracing pipeline.
 func NewDefault(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *opencl.Pipeline, opts Options) (Renderer, error) {
 	if sc == nil {
-		return nil, ErrSceneNotDefined
+		return nil, tracer.WrapError(tracer.ErrSceneInvalid, ErrSceneNotDefined)
 	} else if sc.Camera == nil {
-		return nil, ErrCameraNotDefined
+		return nil, tracer.WrapError(tracer.ErrSceneInvalid, ErrCameraNotDefined)
 	}
 
 	r := &defaultRenderer{
@@ -57,9 +86,60 @@ func NewDefault(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *open
 
 	err := r.initTracers(pipeline)
 	if err != nil {
+		r.releaseDeviceLocks()
 		return nil, err
 	}
-	r.jobChans = make([]chan tracer.BlockRequest, len(r.tracers))
+
+	r.startWorkers(sc)
+	return r, nil
+}
+
+// Create a new default renderer that distributes blocks to a user-supplied
+// list of tracers. This allows rendering with tracers that are not backed by
+// opencl devices (e.g. the cpu tracer). Tracers that fail to initialize are
+// skipped. The options that control opencl device selection are ignored.
+func NewWithTracers(sc *scene.Scene, scheduler tracer.BlockScheduler, tracers []tracer.Tracer, opts Options) (Renderer, error) {
+	if sc == nil {
+		return nil, tracer.WrapError(tracer.ErrSceneInvalid, ErrSceneNotDefined)
+	} else if sc.Camera == nil {
+		return nil, tracer.WrapError(tracer.ErrSceneInvalid, ErrCameraNotDefined)
+	}
+
+	r := &defaultRenderer{
+		logger:    log.New("renderer"),
+		scheduler: scheduler,
+		options:   opts,
+		tracers:   make([]tracer.Tracer, 0, len(tracers)),
+		primary:   -1,
+	}
+
+	for _, tr := range tracers {
+		err := tr.Init()
+		if err != nil {
+			r.logger.Warningf("could not init tracer %q: %v", tr.Id(), err)
+			continue
+		}
+
+		r.logger.Noticef("using tracer %q", tr.Id())
+		r.tracers = append(r.tracers, tr)
+		r.stats.Tracers = append(r.stats.Tracers, TracerStat{
+			Id: tr.Id(),
+		})
+	}
+
+	if len(r.tracers) == 0 {
+		return nil, ErrNoTracers
+	}
+
+	r.selectPrimary()
+	r.startWorkers(sc)
+	return r, nil
+}
+
+// Upload the scene to the registered tracers and start a job worker for each
+// one of them.
+func (r *defaultRenderer) startWorkers(sc *scene.Scene) {
+	r.jobChans = make([]chan blockJob, len(r.tracers))
 	r.jobCompleteChan = make(chan error, 0)
 
 	// Start workers
@@ -67,19 +147,17 @@ func NewDefault(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *open
 	r.workerCloseGroup.Add(len(r.tracers))
 	for trIndex := 0; trIndex < len(r.tracers); trIndex++ {
 		// Queue state changes
-		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{opts.FrameW, opts.FrameH})
+		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{r.options.FrameW, r.options.FrameH})
 		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.SceneData, sc)
 		r.tracers[trIndex].UpdateState(tracer.Synchronous, tracer.CameraData, sc.Camera)
 
 		// Start worker
-		r.jobChans[trIndex] = make(chan tracer.BlockRequest, 0)
+		r.jobChans[trIndex] = make(chan blockJob, 0)
 		go r.jobWorker(trIndex)
 	}
 
 	// wait for all workers to start
 	r.workerInitGroup.Wait()
-
-	return r, nil
 }
 
 // Get last frame stats.
@@ -94,25 +172,232 @@ func (r *defaultRenderer) Close() {
 	}
 
 	r.workerCloseGroup.Wait()
+	r.releaseDeviceLocks()
+}
+
+// Read the normalized linear radiance of the last rendered frame.
+func (r *defaultRenderer) ReadRadiance(out []float32) error {
+	if r.lastFrameReq == nil {
+		return ErrNoFrameRendered
+	}
+
+	_, err := r.tracers[r.primary].ReadRadiance(r.lastFrameReq, out)
+	return err
+}
+
+// Read the last rendered frame into dst.
+func (r *defaultRenderer) ReadFrame(dst interface{}) error {
+	if r.lastFrameReq == nil {
+		return ErrNoFrameRendered
+	}
+
+	_, err := r.tracers[r.primary].ReadFrame(r.lastFrameReq, dst)
+	return err
+}
+
+// Read the values of a single pixel of the last rendered frame.
+func (r *defaultRenderer) ProbePixel(x, y uint32) (tracer.PixelProbe, error) {
+	if r.lastFrameReq == nil {
+		return tracer.PixelProbe{}, ErrNoFrameRendered
+	}
+
+	prober, ok := r.tracers[r.primary].(tracer.PixelProber)
+	if !ok {
+		return tracer.PixelProbe{}, ErrProbeUnsupported
+	}
+
+	return prober.ProbePixel(r.lastFrameReq, x, y)
 }
 
-// Render next frame.
+// Render next frame. If a budget is configured, the frame samples are
+// collected using multiple passes that fit the budget.
 func (r *defaultRenderer) Render() error {
-	return r.renderFrame(0)
+	return r.RenderContext(context.Background())
+}
+
+// Render next frame. If ctx is cancelled while rendering, the block requests
+// that are being traced are aborted and the context error is returned.
+func (r *defaultRenderer) RenderContext(ctx context.Context) error {
+	if !r.options.hasBudget() || r.options.SamplesPerPixel == 0 {
+		return r.renderFrame(ctx, 0, r.options.SamplesPerPixel)
+	}
+
+	var accumulated uint32
+	for accumulated < r.options.SamplesPerPixel {
+		spp := r.budgetBatchSize(r.options.SamplesPerPixel - accumulated)
+		err := r.renderFrame(ctx, accumulated, spp)
+		if err != nil {
+			return err
+		}
+		accumulated += spp
+	}
+	return nil
+}
+
+// Render next frame accumulating its samples with the ones collected by
+// previous frames.
+func (r *defaultRenderer) Accumulate() error {
+	return r.AccumulateContext(context.Background())
+}
+
+// Render next frame accumulating its samples with the ones collected by
+// previous frames. If ctx is cancelled while rendering, the block requests
+// that are being traced are aborted, the accumulated samples are discarded
+// and the context error is returned.
+func (r *defaultRenderer) AccumulateContext(ctx context.Context) error {
+	spp := r.nextBatchSize()
+	err := r.renderFrame(ctx, r.accumulatedSamples, spp)
+	if err != nil {
+		return err
+	}
+
+	r.accumulatedSamples += spp
+	r.accumulatedPasses++
+	return nil
+}
+
+// Get the number of samples to be collected by the next call to Accumulate.
+func (r *defaultRenderer) nextBatchSize() uint32 {
+	var spp uint32
+	switch {
+	case r.options.Schedule.Enabled():
+		spp = r.options.Schedule.Batch(r.accumulatedPasses)
+	case r.options.SamplesPerPixel == 0:
+		// In progressive mode each frame collects a single sample
+		return 1
+	default:
+		spp = r.options.SamplesPerPixel
+	}
+
+	if r.options.hasBudget() {
+		spp = r.budgetBatchSize(spp)
+	}
+
+	// Avoid overshooting the target number of samples
+	target := r.options.SamplesPerPixel
+	if target != 0 && r.accumulatedSamples < target && r.accumulatedSamples+spp > target {
+		spp = target - r.accumulatedSamples
+	}
+	return spp
+}
+
+// Get the number of samples per pixel accumulated so far.
+func (r *defaultRenderer) AccumulatedSamples() uint32 {
+	return r.accumulatedSamples
+}
+
+// Queue a camera update for all tracers and reset the accumulated samples.
+func (r *defaultRenderer) UpdateCamera(camera *scene.Camera) {
+	for _, tr := range r.tracers {
+		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, camera)
+	}
+
+	r.accumulatedSamples = 0
+	r.accumulatedPasses = 0
+}
+
+// Queue a mesh instance update for all tracers and reset the accumulated samples.
+func (r *defaultRenderer) UpdateInstances(update *scene.InstanceUpdate) {
+	for _, tr := range r.tracers {
+		tr.UpdateState(tracer.Asynchronous, tracer.InstanceData, update)
+	}
+
+	r.accumulatedSamples = 0
+	r.accumulatedPasses = 0
+}
+
+// Update render options and reset the accumulated samples. If the frame
+// dimensions change, a buffer resize is queued for all tracers. Changes that
+// only affect the exposure or the light group intensities do not reset the
+// accumulated samples; instead, the post-processing stages are re-applied to
+// the last rendered frame.
+func (r *defaultRenderer) UpdateOptions(opts Options) {
+	if r.lastFrameReq != nil && postProcessOnlyChange(r.options, opts) {
+		err := r.updatePostProcess(opts)
+		if err == nil {
+			r.options = opts
+			return
+		}
+		r.logger.Warningf("could not re-apply post-processing stages after exposure or light intensity change: %s; resetting accumulated samples", err.Error())
+	}
+
+	if opts.FrameW != r.options.FrameW || opts.FrameH != r.options.FrameH {
+		for _, tr := range r.tracers {
+			tr.UpdateState(tracer.Asynchronous, tracer.FrameDimensions, [2]uint32{opts.FrameW, opts.FrameH})
+		}
+		r.lastFrameReq = nil
+	}
+
+	r.options = opts
+	r.accumulatedSamples = 0
+	r.accumulatedPasses = 0
+}
+
+// Re-run the post-processing stages for the last rendered frame using the
+// exposure and light group intensities of opts. The tracers accumulate each
+// light group to a separate pass so neither setting affects the accumulated
+// radiance and the frame does not need to be traced again.
+func (r *defaultRenderer) updatePostProcess(opts Options) error {
+	blockReq := *r.lastFrameReq
+	blockReq.Exposure = opts.Exposure
+	blockReq.LightGroupScales = opts.LightGroupScales
+
+	_, err := r.tracers[r.primary].SyncFramebuffer(&blockReq)
+	if err != nil {
+		return err
+	}
+
+	r.lastFrameReq = &blockReq
+	return nil
+}
+
+// Returns true if the only differences between the two option sets are the
+// exposure value and the light group intensities.
+func postProcessOnlyChange(cur, next Options) bool {
+	return (cur.Exposure != next.Exposure || !sameLightGroupScales(cur.LightGroupScales, next.LightGroupScales)) &&
+		cur.FrameW == next.FrameW &&
+		cur.FrameH == next.FrameH &&
+		cur.NumBounces == next.NumBounces &&
+		cur.MinBouncesForRR == next.MinBouncesForRR &&
+		cur.SamplesPerPixel == next.SamplesPerPixel &&
+		cur.Schedule == next.Schedule &&
+		cur.Seed == next.Seed
+}
+
+// Returns true if two lists of light group multipliers select the same
+// intensity for each light group.
+func sameLightGroupScales(a, b []float32) bool {
+	for group := 0; group < len(a) || group < len(b); group++ {
+		if lightGroupScale(a, group) != lightGroupScale(b, group) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the intensity multiplier for a light group. Groups without a multiplier
+// use 1.
+func lightGroupScale(scales []float32, group int) float32 {
+	if group < len(scales) {
+		return scales[group]
+	}
+	return 1.0
 }
 
 // The actual frame implementation. This is intentionally split so it can be
 // used by the opengl renderer.
-func (r *defaultRenderer) renderFrame(accumulatedSamples uint32) error {
+func (r *defaultRenderer) renderFrame(ctx context.Context, accumulatedSamples, samplesPerPixel uint32) error {
 	var blockReq = tracer.BlockRequest{
 		FrameW:             r.options.FrameW,
 		FrameH:             r.options.FrameH,
 		BlockW:             r.options.FrameW,
-		SamplesPerPixel:    r.options.SamplesPerPixel,
+		SamplesPerPixel:    samplesPerPixel,
 		Exposure:           r.options.Exposure,
+		LightGroupScales:   r.options.LightGroupScales,
 		NumBounces:         r.options.NumBounces,
 		MinBouncesForRR:    r.options.MinBouncesForRR,
 		AccumulatedSamples: accumulatedSamples,
+		FirstSample:        accumulatedSamples,
 		Seed:               rand.Uint32(),
 	}
 
@@ -127,7 +412,7 @@ func (r *defaultRenderer) renderFrame(accumulatedSamples uint32) error {
 	r.blockAssignments = r.scheduler.Schedule(r.tracers, blockReq.FrameH)
 	for trIndex, blockH := range r.blockAssignments {
 		blockReq.BlockH = blockH
-		r.jobChans[trIndex] <- blockReq
+		r.jobChans[trIndex] <- blockJob{ctx: ctx, blockReq: blockReq}
 
 		r.stats.Tracers[trIndex].BlockH = blockH
 		r.stats.Tracers[trIndex].FramePercent = 100.0 * float32(blockH) / float32(blockReq.FrameH)
@@ -140,36 +425,89 @@ func (r *defaultRenderer) renderFrame(accumulatedSamples uint32) error {
 		tot += bh
 	}
 
-	// Wait for all tracers to finish
-	pending := len(r.tracers)
-	for pending != 0 {
-		err, ok := <-r.jobCompleteChan
+	// Wait for all tracers to finish. All workers must report back before
+	// returning so that they are ready to accept the next frame's blocks.
+	var err error
+	for pending := len(r.tracers); pending != 0; pending-- {
+		jobErr, ok := <-r.jobCompleteChan
 		if !ok {
-			err = ErrInterrupted
+			jobErr = ErrInterrupted
 		}
 
-		if err != nil {
-			return err
+		if err == nil {
+			err = jobErr
 		}
+	}
 
-		pending--
+	if err != nil {
+		// Blocks traced before the frame was cancelled have already
+		// been merged into the frame accumulator so its contents
+		// cannot be used for accumulating further samples.
+		if ctx.Err() != nil {
+			r.accumulatedSamples = 0
+			r.accumulatedPasses = 0
+			r.lastFrameReq = nil
+		}
+		return err
 	}
 
 	// Run post-process filters on the primary tracer
 	blockReq.BlockY = 0
 	blockReq.BlockH = blockReq.FrameH
-	r.tracers[r.primary].SyncFramebuffer(&blockReq)
+	_, err = r.tracers[r.primary].SyncFramebuffer(&blockReq)
+	if err != nil {
+		return err
+	}
+	r.lastFrameReq = &blockReq
 
 	r.stats.RenderTime = time.Since(start)
+	r.recordSampleTime(r.stats.RenderTime, samplesPerPixel)
 
 	// Collect stats
 	for trIndex, tr := range r.tracers {
-		r.stats.Tracers[trIndex].RenderTime = tr.Stats().RenderTime
+		trStats := tr.Stats()
+		stat := &r.stats.Tracers[trIndex]
+		if trStats.StackOverflows != 0 && stat.StackOverflows == 0 {
+			r.logger.Warningf("device %q: %d rays overflowed the BVH traversal stack and may be missing geometry; increase the traversal stack size", stat.Id, trStats.StackOverflows)
+		}
+		stat.RenderTime = trStats.RenderTime
+		stat.StackOverflows = trStats.StackOverflows
+	}
+	if time.Since(r.lastThermalPoll) >= thermalPollInterval {
+		r.pollThermals()
 	}
 
 	return nil
 }
 
+// Refresh the thermal readings for tracers that can report them and log a
+// warning when a device starts or stops throttling. Devices that throttle
+// during long renders slow down the render without any other visible cause.
+func (r *defaultRenderer) pollThermals() {
+	r.lastThermalPoll = time.Now()
+	for trIndex, tr := range r.tracers {
+		reporter, ok := tr.(tracer.ThermalReporter)
+		if !ok {
+			continue
+		}
+
+		thermals, err := reporter.Thermals()
+		if err != nil {
+			continue
+		}
+
+		stat := &r.stats.Tracers[trIndex]
+		throttled := thermals.Throttled()
+		switch {
+		case throttled && !stat.Throttled:
+			r.logger.Warningf("device %q appears to be throttled (%s); render times will increase until it cools down", stat.Id, thermals)
+		case !throttled && stat.Throttled:
+			r.logger.Noticef("device %q is no longer throttled (%s)", stat.Id, thermals)
+		}
+		stat.Thermals, stat.Throttled = thermals, throttled
+	}
+}
+
 // A tracing job processor.
 func (r *defaultRenderer) jobWorker(trIndex int) {
 	r.workerInitGroup.Done()
@@ -180,12 +518,13 @@ func (r *defaultRenderer) jobWorker(trIndex int) {
 
 	for {
 		select {
-		case blockReq, ok := <-r.jobChans[trIndex]:
+		case job, ok := <-r.jobChans[trIndex]:
 			if !ok {
 				return
 			}
 
-			_, err := r.tracers[trIndex].Trace(&blockReq)
+			blockReq := job.blockReq
+			_, err := tracer.TraceContext(job.ctx, r.tracers[trIndex], &blockReq)
 			if err == nil {
 				// Merge trace accumulator output for this pass with primary tracer's frame accumulator
 				_, err = r.tracers[r.primary].MergeOutput(r.tracers[trIndex], &blockReq)
@@ -223,6 +562,18 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 		}
 	}
 
+	selectedDevices = filterSimulatedDevices(r.logger, selectedDevices)
+	if len(selectedDevices) == 0 {
+		return ErrNoTracers
+	}
+
+	if !r.options.ShareDevices {
+		selectedDevices = r.lockDevices(selectedDevices)
+		if len(selectedDevices) == 0 {
+			return ErrDevicesInUse
+		}
+	}
+
 	// Create shared context for seleected devices
 	sharedCtx, err := device.NewSharedContext(selectedDevices)
 	if err != nil {
@@ -236,11 +587,28 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 
 	for _, device := range selectedDevices {
 		// Create and initialize tracer
+		tracerOpts := []opencl.TracerOption{
+			opencl.WithDevice(device),
+			opencl.WithSharedContext(sharedCtx),
+			opencl.WithPipeline(pipeline),
+		}
+		if r.options.Seed != 0 {
+			tracerOpts = append(tracerOpts, opencl.WithSeed(r.options.Seed+int64(len(r.tracers))))
+		}
+		if r.options.TraversalStackSize != 0 {
+			tracerOpts = append(tracerOpts, opencl.WithTraversalStackSize(r.options.TraversalStackSize))
+		}
+		if r.options.CompressedBvh {
+			tracerOpts = append(tracerOpts, opencl.WithCompressedBvh())
+		}
+		if buildOpts := buildOptionsFor(device.Name, r.options.DeviceBuildOptions); buildOpts.String() != "" {
+			r.logger.Infof("building kernels for device %q using options: %s", device.Name, buildOpts.String())
+			tracerOpts = append(tracerOpts, opencl.WithBuildOptions(buildOpts))
+		}
+
 		tr, err := opencl.NewTracer(
 			fmt.Sprintf("%s (%d)", device.Name, len(r.tracers)),
-			device,
-			sharedCtx,
-			pipeline,
+			tracerOpts...,
 		)
 		if err == nil {
 			err = tr.Init()
@@ -269,7 +637,14 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 		return ErrNoTracers
 	}
 
-	// If no primary tracer selected, pick the GPU with max estimated speed
+	r.selectPrimary()
+	return nil
+}
+
+// Select the primary tracer unless one has already been forced. The GPU with
+// the max estimated speed is preferred; if no GPU is available the first
+// tracer is selected.
+func (r *defaultRenderer) selectPrimary() {
 	if r.primary == -1 {
 		var bestSpeed uint32 = 0
 		for trIndex, tr := range r.tracers {
@@ -287,6 +662,72 @@ func (r *defaultRenderer) initTracers(pipeline *opencl.Pipeline) error {
 
 	r.stats.Tracers[r.primary].IsPrimary = true
 	r.logger.Noticef("selected %q as primary device", r.tracers[r.primary].Id())
+}
 
-	return nil
+// Acquire exclusive locks for the selected devices so that other polaris
+// processes do not render on them at the same time. Devices locked by other
+// processes are skipped. If a lock cannot be acquired for any other reason,
+// the device is used without a lock.
+func (r *defaultRenderer) lockDevices(devices []*device.Device) []*device.Device {
+	unlocked := make([]*device.Device, 0, len(devices))
+	for _, dev := range devices {
+		lock, err := dev.TryLock()
+		if device.IsLocked(err) {
+			r.logger.Warningf("skipping device %q: %v; use the share option to render on it anyway", dev.Name, err)
+			continue
+		} else if err != nil {
+			r.logger.Warningf("could not lock device %q: %v", dev.Name, err)
+		} else {
+			r.deviceLocks = append(r.deviceLocks, lock)
+		}
+
+		unlocked = append(unlocked, dev)
+	}
+
+	return unlocked
+}
+
+// Release any acquired device locks.
+func (r *defaultRenderer) releaseDeviceLocks() {
+	for _, lock := range r.deviceLocks {
+		lock.Release()
+	}
+	r.deviceLocks = nil
+}
+
+// Device simulators such as oclgrind are orders of magnitude slower than
+// real devices and are only meant for debugging kernels. This function drops
+// any simulated devices from the list unless they are the only devices
+// available. It also reports whether rendering falls back to software devices
+// which is typically the case when running inside containers without GPU access.
+func filterSimulatedDevices(logger log.Logger, devices []*device.Device) []*device.Device {
+	hasRealDevices := false
+	hasHardwareDevices := false
+	for _, dev := range devices {
+		if !dev.IsSimulator() {
+			hasRealDevices = true
+		}
+		if !dev.IsSoftware() {
+			hasHardwareDevices = true
+		}
+	}
+
+	if !hasHardwareDevices && len(devices) != 0 {
+		logger.Notice("no hardware opencl devices available; using software devices")
+	}
+
+	if !hasRealDevices {
+		return devices
+	}
+
+	filtered := make([]*device.Device, 0, len(devices))
+	for _, dev := range devices {
+		if dev.IsSimulator() {
+			logger.Infof("skipping simulated device %q", dev.Name)
+			continue
+		}
+		filtered = append(filtered, dev)
+	}
+
+	return filtered
 }
@@ -0,0 +1,291 @@
+package renderer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+func TestExposureOnlyUpdateKeepsAccumulatedSamples(t *testing.T) {
+	tr := &mockTracer{}
+	opts := Options{FrameW: 4, FrameH: 4, SamplesPerPixel: 16, Exposure: 1.0}
+	r := &defaultRenderer{
+		logger:             log.New("renderer"),
+		options:            opts,
+		tracers:            []tracer.Tracer{tr},
+		lastFrameReq:       &tracer.BlockRequest{FrameW: 4, FrameH: 4, Exposure: 1.0},
+		accumulatedSamples: 16,
+		accumulatedPasses:  1,
+	}
+
+	opts.Exposure = 2.0
+	r.UpdateOptions(opts)
+
+	if r.accumulatedSamples != 16 {
+		t.Fatalf("expected exposure update to preserve the accumulated samples; got %d", r.accumulatedSamples)
+	}
+	if len(tr.syncReqs) != 1 || tr.syncReqs[0].Exposure != 2.0 {
+		t.Fatalf("expected post-processing stages to run once with the new exposure; got %v", tr.syncReqs)
+	}
+	if r.lastFrameReq.Exposure != 2.0 {
+		t.Fatalf("expected last frame request exposure to be updated; got %f", r.lastFrameReq.Exposure)
+	}
+
+	// Other option changes reset the accumulated samples
+	opts.NumBounces = 8
+	r.UpdateOptions(opts)
+	if r.accumulatedSamples != 0 {
+		t.Fatalf("expected option update to reset the accumulated samples; got %d", r.accumulatedSamples)
+	}
+	if len(tr.syncReqs) != 1 {
+		t.Fatalf("expected post-processing stages not to run; got %d calls", len(tr.syncReqs))
+	}
+
+	// Failed exposure updates fall back to resetting the accumulated samples
+	r.accumulatedSamples = 16
+	tr.syncErr = errors.New("sync failed")
+	opts.Exposure = 4.0
+	r.UpdateOptions(opts)
+	if r.accumulatedSamples != 0 {
+		t.Fatalf("expected failed exposure update to reset the accumulated samples; got %d", r.accumulatedSamples)
+	}
+	if r.options.Exposure != 4.0 {
+		t.Fatalf("expected exposure to be updated; got %f", r.options.Exposure)
+	}
+}
+
+func TestLightGroupScaleOnlyUpdateKeepsAccumulatedSamples(t *testing.T) {
+	tr := &mockTracer{}
+	opts := Options{FrameW: 4, FrameH: 4, SamplesPerPixel: 16, Exposure: 1.0}
+	r := &defaultRenderer{
+		logger:             log.New("renderer"),
+		options:            opts,
+		tracers:            []tracer.Tracer{tr},
+		lastFrameReq:       &tracer.BlockRequest{FrameW: 4, FrameH: 4, Exposure: 1.0},
+		accumulatedSamples: 16,
+		accumulatedPasses:  1,
+	}
+
+	opts.LightGroupScales = []float32{1, 0.5}
+	r.UpdateOptions(opts)
+	if r.accumulatedSamples != 16 {
+		t.Fatalf("expected light group intensity update to preserve the accumulated samples; got %d", r.accumulatedSamples)
+	}
+	if len(tr.syncReqs) != 1 || len(tr.syncReqs[0].LightGroupScales) != 2 || tr.syncReqs[0].LightGroupScales[1] != 0.5 {
+		t.Fatalf("expected post-processing stages to run once with the new light group scales; got %v", tr.syncReqs)
+	}
+	if len(r.lastFrameReq.LightGroupScales) != 2 || r.lastFrameReq.LightGroupScales[1] != 0.5 {
+		t.Fatalf("expected last frame request light group scales to be updated; got %v", r.lastFrameReq.LightGroupScales)
+	}
+}
+
+func TestNewWithTracers(t *testing.T) {
+	sc := &scene.Scene{Camera: scene.NewCamera(45)}
+	opts := Options{FrameW: 4, FrameH: 4, SamplesPerPixel: 1}
+
+	failing := &mockTracer{initErr: errors.New("init failed")}
+	tr := &mockTracer{}
+	r, err := NewWithTracers(sc, tracer.NaiveScheduler(), []tracer.Tracer{failing, tr}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stats := r.Stats()
+	if len(stats.Tracers) != 1 || !stats.Tracers[0].IsPrimary {
+		t.Fatalf("expected tracers that fail to initialize to be skipped; got %+v", stats.Tracers)
+	}
+
+	if err = r.Render(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.syncReqs) != 1 {
+		t.Fatalf("expected the primary tracer framebuffer to be synced once; got %d", len(tr.syncReqs))
+	}
+
+	_, err = NewWithTracers(sc, tracer.NaiveScheduler(), []tracer.Tracer{failing}, opts)
+	if err != ErrNoTracers {
+		t.Fatalf("expected to get ErrNoTracers; got %v", err)
+	}
+}
+
+func TestAccumulateContextCancellation(t *testing.T) {
+	sc := &scene.Scene{Camera: scene.NewCamera(45)}
+	opts := Options{FrameW: 4, FrameH: 4, SamplesPerPixel: 4}
+
+	tracers := []tracer.Tracer{&mockTracer{}, &mockTracer{}}
+	r, err := NewWithTracers(sc, tracer.NaiveScheduler(), tracers, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if err = r.Accumulate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.AccumulatedSamples(); got != 4 {
+		t.Fatalf("expected 4 accumulated samples; got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = r.AccumulateContext(ctx); err != context.Canceled {
+		t.Fatalf("expected to get context.Canceled; got %v", err)
+	}
+	if got := r.AccumulatedSamples(); got != 0 {
+		t.Fatalf("expected cancelled frame to discard the accumulated samples; got %d", got)
+	}
+	if _, err = r.ProbePixel(0, 0); err != ErrNoFrameRendered {
+		t.Fatalf("expected cancelled frame to invalidate the last rendered frame; got %v", err)
+	}
+
+	// The tracer workers should be ready to process the next frame
+	if err = r.Accumulate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPollThermals(t *testing.T) {
+	tr := &mockThermalTracer{thermals: tracer.Thermals{Temperature: 95}}
+	r := &defaultRenderer{
+		logger:  log.New("renderer"),
+		tracers: []tracer.Tracer{&mockTracer{}, tr},
+		stats: FrameStats{
+			Tracers: []TracerStat{{Id: "mock"}, {Id: "thermal"}},
+		},
+	}
+
+	r.pollThermals()
+	throttled := r.Stats().ThrottledTracers()
+	if len(throttled) != 1 || throttled[0].Id != "thermal" || throttled[0].Thermals != tr.thermals {
+		t.Fatalf("expected thermal tracer to be reported as throttled; got %+v", throttled)
+	}
+	if r.lastThermalPoll.IsZero() {
+		t.Fatal("expected poll time to be recorded")
+	}
+
+	tr.thermals.Temperature = 60
+	r.pollThermals()
+	if throttled = r.Stats().ThrottledTracers(); len(throttled) != 0 {
+		t.Fatalf("expected no throttled tracers after the device cools down; got %+v", throttled)
+	}
+}
+
+func TestLockDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origLockDir := device.LockDir
+	device.LockDir = dir
+	defer func() { device.LockDir = origLockDir }()
+
+	devices := []*device.Device{{Name: "gpu"}, {Name: "cpu"}}
+	held, err := devices[0].TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	r := &defaultRenderer{logger: log.New("renderer")}
+	unlocked := r.lockDevices(devices)
+	if runtime.GOOS == "windows" {
+		t.Skip("file locking is not supported on this platform")
+	}
+	if len(unlocked) != 1 || unlocked[0].Name != "cpu" {
+		t.Fatalf("expected devices locked by other processes to be skipped; got %v", unlocked)
+	}
+	if len(r.deviceLocks) != 1 {
+		t.Fatalf("expected renderer to hold 1 device lock; got %d", len(r.deviceLocks))
+	}
+
+	r.releaseDeviceLocks()
+	if len(r.deviceLocks) != 0 {
+		t.Fatalf("expected device locks to be released; got %d", len(r.deviceLocks))
+	}
+}
+
+func TestProbePixel(t *testing.T) {
+	r := &defaultRenderer{
+		logger:  log.New("renderer"),
+		tracers: []tracer.Tracer{&mockTracer{}},
+	}
+
+	if _, err := r.ProbePixel(0, 0); err != ErrNoFrameRendered {
+		t.Fatalf("expected to get ErrNoFrameRendered; got %v", err)
+	}
+
+	r.lastFrameReq = &tracer.BlockRequest{FrameW: 4, FrameH: 4, SamplesPerPixel: 8}
+	if _, err := r.ProbePixel(0, 0); err != ErrProbeUnsupported {
+		t.Fatalf("expected to get ErrProbeUnsupported; got %v", err)
+	}
+
+	r.tracers[0] = &mockProbeTracer{}
+	probe, err := r.ProbePixel(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if probe.X != 1 || probe.Y != 2 || probe.Samples != 8 {
+		t.Fatalf("expected probe for pixel (1, 2) with 8 samples; got %+v", probe)
+	}
+}
+
+type mockThermalTracer struct {
+	mockTracer
+	thermals tracer.Thermals
+}
+
+func (tr *mockThermalTracer) Thermals() (tracer.Thermals, error) { return tr.thermals, nil }
+
+type mockProbeTracer struct {
+	mockTracer
+}
+
+func (tr *mockProbeTracer) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	probe, _, err := tracer.NewPixelProbe(blockReq, x, y)
+	return probe, err
+}
+
+type mockTracer struct {
+	syncReqs []tracer.BlockRequest
+	syncErr  error
+	initErr  error
+}
+
+func (tr *mockTracer) Id() string                                        { return "mock" }
+func (tr *mockTracer) Flags() tracer.Flag                                { return tracer.Local }
+func (tr *mockTracer) Speed() uint32                                     { return 1 }
+func (tr *mockTracer) Init() error                                       { return tr.initErr }
+func (tr *mockTracer) Close()                                            {}
+func (tr *mockTracer) Stats() *tracer.Stats                              { return &tracer.Stats{} }
+func (tr *mockTracer) Trace(*tracer.BlockRequest) (time.Duration, error) { return 0, nil }
+func (tr *mockTracer) UpdateState(tracer.UpdateMode, tracer.ChangeType, interface{}) (time.Duration, error) {
+	return 0, nil
+}
+func (tr *mockTracer) MergeOutput(tracer.Tracer, *tracer.BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+func (tr *mockTracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	if tr.syncErr != nil {
+		return 0, tr.syncErr
+	}
+	tr.syncReqs = append(tr.syncReqs, *blockReq)
+	return 0, nil
+}
+func (tr *mockTracer) ReadRadiance(*tracer.BlockRequest, []float32) (time.Duration, error) {
+	return 0, nil
+}
+func (tr *mockTracer) ReadFrame(*tracer.BlockRequest, interface{}) (time.Duration, error) {
+	return 0, nil
+}
@@ -4,7 +4,14 @@ import "errors"
 
 var (
 	ErrNoTracers        = errors.New("renderer: no tracers attached")
+	ErrDevicesInUse     = errors.New("renderer: all selected devices are in use by other polaris processes; use the share option to render on them anyway")
 	ErrSceneNotDefined  = errors.New("renderer: no scene defined")
 	ErrCameraNotDefined = errors.New("renderer: no camera defined")
 	ErrInterrupted      = errors.New("renderer: interrupted while rendering")
+	ErrNoFrameRendered  = errors.New("renderer: no frame rendered yet")
+	ErrHeadless         = errors.New("renderer: interactive rendering is not supported by headless builds")
+	ErrProbeUnsupported = errors.New("renderer: the primary tracer does not support pixel probes")
+
+	ErrUnknownBookmark     = errors.New("renderer: unknown bookmark")
+	ErrInvalidBookmarkFile = errors.New("renderer: invalid bookmark file")
 )
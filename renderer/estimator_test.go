@@ -0,0 +1,177 @@
+package renderer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/compiler/input"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+	"github.com/achilleasa/polaris/stattest"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The settings used for the estimator tests.
+const (
+	estimatorFrameSize    = 32
+	estimatorNumSeeds     = 16
+	estimatorSignificance = 0.001
+)
+
+// A pixel whose estimates are compared to an analytic solution.
+type analyticPixel struct {
+	x, y int
+	exp  float32
+}
+
+// Render the white furnace scene using different seeds and check that the
+// estimates for the pixels covered by the sphere converge to the analytic
+// solution.
+func TestFurnaceEstimatorBias(t *testing.T) {
+	const albedo = 0.5
+	sc := compileEstimatorScene(t, testscenes.FurnaceSphere(albedo))
+
+	// Use the pixels around the frame center which are always covered by
+	// the sphere.
+	var pixels []analyticPixel
+	for y := estimatorFrameSize/2 - 2; y < estimatorFrameSize/2+2; y++ {
+		for x := estimatorFrameSize/2 - 2; x < estimatorFrameSize/2+2; x++ {
+			pixels = append(pixels, analyticPixel{x, y, testscenes.FurnaceSphereRadiance(albedo)})
+		}
+	}
+
+	checkEstimatorBias(t, sc, pixels)
+}
+
+// Render a diffuse sphere under a uniform sky and check that the estimates
+// for the pixels covered by the sphere converge to the analytic solution.
+func TestSkySphereEstimatorBias(t *testing.T) {
+	const albedo, skyRadiance = 0.8, 0.9
+	sc := compileEstimatorScene(t, testscenes.SkySphere(albedo, skyRadiance))
+
+	pixels := selectAnalyticPixels(sc, func(origin, dir types.Vec3) (float32, bool) {
+		// Intersect the pixel ray with the analytic sphere and skip
+		// grazing hits as the tessellated sphere silhouette does not
+		// match the analytic one.
+		oc := origin.Sub(testscenes.SkySphereCenter)
+		b := oc.Dot(dir)
+		disc := b*b - oc.Dot(oc) + testscenes.SkySphereRadius*testscenes.SkySphereRadius
+		if disc <= 0 {
+			return 0, false
+		}
+		hit := origin.Add(dir.Mul(-b - float32(math.Sqrt(float64(disc)))))
+		normal := hit.Sub(testscenes.SkySphereCenter).Normalize()
+		if -normal.Dot(dir) < 0.5 {
+			return 0, false
+		}
+		return testscenes.SkySphereRadiance(albedo, skyRadiance, normal), true
+	})
+
+	checkEstimatorBias(t, sc, pixels)
+}
+
+// Render a diffuse floor lit by an area light and check that the estimates
+// for the pixels covered by the floor converge to the analytic solution.
+func TestAreaLitFloorEstimatorBias(t *testing.T) {
+	const albedo, lightRadiance = 0.7, 4
+	sc := compileEstimatorScene(t, testscenes.AreaLitFloor(albedo, lightRadiance))
+
+	pixels := selectAnalyticPixels(sc, func(origin, dir types.Vec3) (float32, bool) {
+		// Skip rays that are almost parallel to the floor as the floor
+		// radiance varies quickly across their footprint.
+		if dir[1] > -0.1 {
+			return 0, false
+		}
+		hit := origin.Add(dir.Mul(-origin[1] / dir[1]))
+		return testscenes.AreaLitFloorRadiance(albedo, lightRadiance, hit), true
+	})
+
+	checkEstimatorBias(t, sc, pixels)
+}
+
+// Compile a scene for an estimator test.
+func compileEstimatorScene(t *testing.T, in *input.Scene) *scene.Scene {
+	if testing.Short() {
+		t.Skip("skipping estimator bias test in short mode")
+	}
+
+	sc, err := testscenes.Compile(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Camera.SetupFrame(estimatorFrameSize, estimatorFrameSize)
+	return sc
+}
+
+// Select every other pixel in each direction whose center ray is accepted by
+// the solution function and calculate the analytic solution for it.
+func selectAnalyticPixels(sc *scene.Scene, solution func(origin, dir types.Vec3) (float32, bool)) []analyticPixel {
+	var pixels []analyticPixel
+	for y := 0; y < estimatorFrameSize; y += 2 {
+		for x := 0; x < estimatorFrameSize; x += 2 {
+			dir := sc.Camera.PixelRay(float32(x)+0.5, float32(y)+0.5, estimatorFrameSize, estimatorFrameSize)
+			if exp, ok := solution(sc.Camera.Position, dir); ok {
+				pixels = append(pixels, analyticPixel{x, y, exp})
+			}
+		}
+	}
+	return pixels
+}
+
+// Render a scene using different seeds and run a t-test for each pixel
+// checking whether the estimates converge to the analytic solution. Pixel
+// rays are aimed at the pixel centers so that the analytic solution can be
+// evaluated for the same scene points.
+func checkEstimatorBias(t *testing.T, sc *scene.Scene, pixels []analyticPixel) {
+	if len(pixels) == 0 {
+		t.Fatal("expected at least one pixel with an analytic solution")
+	}
+
+	opts := Options{
+		FrameW:          estimatorFrameSize,
+		FrameH:          estimatorFrameSize,
+		SamplesPerPixel: 16,
+		Exposure:        1.0,
+		NumBounces:      4,
+		MinBouncesForRR: 5,
+	}
+	pipeline := opencl.DefaultPipeline(opencl.WithPixelFilter(opencl.PointFilter))
+
+	estimates := make([][]float64, len(pixels))
+	radiance := make([]float32, estimatorFrameSize*estimatorFrameSize*3)
+	for seed := 1; seed <= estimatorNumSeeds; seed++ {
+		opts.Seed = int64(seed)
+		r, err := NewDefault(sc, tracer.NaiveScheduler(), pipeline, opts)
+		if err == ErrNoTracers {
+			t.Skip("skipping estimator bias test; no opencl devices available")
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Render()
+		if err == nil {
+			err = r.ReadRadiance(radiance)
+		}
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for index, pixel := range pixels {
+			estimates[index] = append(estimates[index], float64(radiance[(pixel.y*estimatorFrameSize+pixel.x)*3+1]))
+		}
+	}
+
+	significance := stattest.SidakSignificance(estimatorSignificance, len(pixels))
+	for index, pixel := range pixels {
+		res, err := stattest.TTest(estimates[index], float64(pixel.exp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reject(significance) {
+			t.Errorf("expected pixel (%d, %d) estimates to converge to %f; got %v; %s", pixel.x, pixel.y, pixel.exp, estimates[index], res)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build headless
+// +build headless
+
+package renderer
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+)
+
+// Interactive rendering requires opengl which is not available in headless builds.
+func NewInteractive(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *opencl.Pipeline, opts Options) (Renderer, error) {
+	return nil, ErrHeadless
+}
@@ -1,13 +1,20 @@
+//go:build !headless
+// +build !headless
+
 package renderer
 
 import (
+	"context"
 	"fmt"
+	"image"
 	"math/rand"
 	"sync"
 
 	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/shm"
 	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/achilleasa/polaris/tracer/opencl/glinterop"
 	"github.com/achilleasa/polaris/types"
 	"github.com/go-gl/gl/v2.1/gl"
 	"github.com/go-gl/glfw/v3.1/glfw"
@@ -21,8 +28,21 @@ const (
 	// Camera movement speed
 	cameraMoveSpeed float32 = 0.05
 
+	// Exposure multiplier applied by the exposure adjustment keys
+	exposureStep float32 = 1.25
+
+	// Intensity multiplier applied by the light group adjustment keys
+	lightIntensityStep float32 = 1.25
+
 	// Height in pixels for stacked series widgets
 	stackedSeriesHeight uint32 = 20
+
+	// The min number of accumulated samples before a frame is added to the
+	// history. This prevents camera drags from flooding the history with
+	// noisy frames.
+	historyMinSamples uint32 = 16
+
+	windowTitle = "polaris"
 )
 
 const (
@@ -34,8 +54,6 @@ const (
 type interactiveGLRenderer struct {
 	*defaultRenderer
 
-	accumulatedSamples uint32
-
 	// opengl handles
 	window *glfw.Window
 	texFbo uint32
@@ -50,13 +68,26 @@ type interactiveGLRenderer struct {
 
 	// Display options
 	showUI                bool
+	stretchFrame          bool
 	blockAssignmentSeries *stackedSeries
+
+	// Frame history for flip-book review
+	history      *shm.History
+	historyFrame *image.RGBA
+	reviewFrame  []byte
+
+	// Bookmarks saved and restored by the number keys
+	bookmarks *Bookmarks
+
+	// The light group whose intensity is adjusted by the bracket keys or
+	// -1 if the pipeline does not accumulate light groups.
+	lightGroup int
 }
 
 // Create a new interactive opengl renderer using the specified block scheduler and tracing pipeline.
 func NewInteractive(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *opencl.Pipeline, opts Options) (Renderer, error) {
 	// Add an extra pipeline step to copy framebuffer data to an opengl texture
-	pipeline.PostProcess = append(pipeline.PostProcess, opencl.CopyFrameBufferToOpenGLTexture())
+	pipeline.PostProcess = append(pipeline.PostProcess, glinterop.CopyFrameBufferToOpenGLTexture())
 
 	base, err := NewDefault(sc, scheduler, pipeline, opts)
 	if err != nil {
@@ -66,6 +97,24 @@ func NewInteractive(sc *scene.Scene, scheduler tracer.BlockScheduler, pipeline *
 	r := &interactiveGLRenderer{
 		defaultRenderer: base.(*defaultRenderer),
 		camera:          sc.Camera,
+		lightGroup:      -1,
+	}
+	if pipeline.LightGroups {
+		r.lightGroup = 0
+	}
+
+	err = r.initHistory(opts)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	if opts.BookmarkFile != "" {
+		r.bookmarks, err = LoadBookmarks(opts.BookmarkFile)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
 	}
 
 	err = r.initGL(opts)
@@ -87,6 +136,9 @@ func (r *interactiveGLRenderer) Close() {
 	if r.window != nil {
 		r.window.SetShouldClose(true)
 	}
+	if r.history != nil {
+		r.history.Close()
+	}
 	if r != nil {
 		r.defaultRenderer.Close()
 	}
@@ -98,10 +150,14 @@ func (r *interactiveGLRenderer) initGL(opts Options) error {
 		return fmt.Errorf("failed to initialize glfw: %s", err.Error())
 	}
 
-	glfw.WindowHint(glfw.Resizable, glfw.False)
+	if opts.ResizableWindow {
+		glfw.WindowHint(glfw.Resizable, glfw.True)
+	} else {
+		glfw.WindowHint(glfw.Resizable, glfw.False)
+	}
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	r.window, err = glfw.CreateWindow(int(opts.FrameW), int(opts.FrameH), "polaris", nil, nil)
+	r.window, err = glfw.CreateWindow(int(opts.FrameW), int(opts.FrameH), windowTitle, nil, nil)
 	if err != nil {
 		return fmt.Errorf("could not create opengl window: %s", err.Error())
 	}
@@ -133,8 +189,37 @@ func (r *interactiveGLRenderer) initGL(opts Options) error {
 	return nil
 }
 
+// Allocate the frame history if enabled by the renderer options.
+func (r *interactiveGLRenderer) initHistory(opts Options) error {
+	if opts.HistoryFrames <= 0 {
+		return nil
+	}
+
+	var err error
+	if opts.HistoryFile != "" {
+		r.history, err = shm.CreateHistory(opts.HistoryFile, opts.HistoryFrames, opts.FrameW, opts.FrameH)
+	} else {
+		r.history, err = shm.NewHistory(opts.HistoryFrames, opts.FrameW, opts.FrameH)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.historyFrame = image.NewRGBA(image.Rect(0, 0, int(opts.FrameW), int(opts.FrameH)))
+	return nil
+}
+
 func (r *interactiveGLRenderer) Render() error {
+	return r.RenderContext(context.Background())
+}
+
+// Render frames until the window is closed or ctx is cancelled.
+func (r *interactiveGLRenderer) RenderContext(ctx context.Context) error {
 	for !r.window.ShouldClose() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		glfw.PollEvents()
 
 		// Render next frame
@@ -142,25 +227,37 @@ func (r *interactiveGLRenderer) Render() error {
 
 		// Render frame unless we have reached our target SPP
 		if r.options.SamplesPerPixel == 0 || (r.options.SamplesPerPixel != 0 && r.accumulatedSamples < r.defaultRenderer.options.SamplesPerPixel) {
-			err := r.renderFrame(r.accumulatedSamples)
-			if r.options.SamplesPerPixel == 0 {
-				r.accumulatedSamples++
-			} else {
-				r.accumulatedSamples += r.options.SamplesPerPixel
-			}
+			err := r.AccumulateContext(ctx)
 			if err != nil {
 				r.Unlock()
 				return err
 			}
 		}
 
-		// Copy texture data to framebuffer
-		gl.BindFramebuffer(gl.READ_FRAMEBUFFER, r.texFbo)
-		gl.BlitFramebuffer(0, 0, int32(r.options.FrameW), int32(r.options.FrameH), 0, 0, int32(r.options.FrameW), int32(r.options.FrameH), gl.COLOR_BUFFER_BIT, gl.LINEAR)
-		gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+		// The frame is always rendered using the camera aspect ratio
+		// and gets scaled to fit the window. Any window area that is
+		// not covered by the frame is cleared to black.
+		fbW, fbH := r.window.GetFramebufferSize()
+		view := frameViewport(r.options.FrameW, r.options.FrameH, fbW, fbH, !r.stretchFrame)
+		gl.Viewport(0, 0, int32(fbW), int32(fbH))
+		gl.Clear(gl.COLOR_BUFFER_BIT)
+
+		if r.reviewFrame != nil {
+			// Display the reviewed history frame. Like the texture
+			// data, history frames are already mirrored.
+			gl.WindowPos2i(view.X, view.Y)
+			gl.PixelZoom(float32(view.W)/float32(r.options.FrameW), float32(view.H)/float32(r.options.FrameH))
+			gl.DrawPixels(int32(r.options.FrameW), int32(r.options.FrameH), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(r.reviewFrame))
+		} else {
+			// Copy texture data to framebuffer
+			gl.BindFramebuffer(gl.READ_FRAMEBUFFER, r.texFbo)
+			gl.BlitFramebuffer(0, 0, int32(r.options.FrameW), int32(r.options.FrameH), view.X, view.Y, view.X+view.W, view.Y+view.H, gl.COLOR_BUFFER_BIT, gl.LINEAR)
+			gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
+		}
 
-		// Display tracer stats
+		// Display tracer stats. The UI is drawn using frame coordinates.
 		if r.showUI {
+			gl.Viewport(view.X, view.Y, view.W, view.H)
 			r.renderUI()
 		}
 
@@ -235,6 +332,44 @@ func (r *interactiveGLRenderer) onKeyEvent(w *glfw.Window, key glfw.Key, scancod
 			r.onBeforeShowUI()
 		}
 		return
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		r.adjustExposure(exposureStep)
+		return
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		r.adjustExposure(1.0 / exposureStep)
+		return
+	case glfw.KeyG:
+		r.selectNextLightGroup()
+		return
+	case glfw.KeyRightBracket:
+		r.adjustLightIntensity(lightIntensityStep)
+		return
+	case glfw.KeyLeftBracket:
+		r.adjustLightIntensity(1.0 / lightIntensityStep)
+		return
+	case glfw.KeyL:
+		r.stretchFrame = !r.stretchFrame
+		if r.stretchFrame {
+			r.logger.Notice("aspect lock disabled; stretching frame to fit the window")
+		} else {
+			r.logger.Notice("aspect lock enabled")
+		}
+		return
+	case glfw.KeyComma:
+		r.stepHistory(true)
+		return
+	case glfw.KeyPeriod:
+		r.stepHistory(false)
+		return
+	case glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5, glfw.Key6, glfw.Key7, glfw.Key8, glfw.Key9:
+		// Shift + number saves a bookmark; number restores it
+		name := fmt.Sprintf("%d", key-glfw.Key0)
+		if (mods & glfw.ModShift) == glfw.ModShift {
+			r.saveBookmark(name)
+		} else {
+			r.restoreBookmark(name)
+		}
+		return
 	default:
 		return
 
@@ -264,6 +399,7 @@ func (r *interactiveGLRenderer) onMouseEvent(w *glfw.Window, button glfw.MouseBu
 		buttonIndex := leftMouseButton
 		if button == glfw.MouseButtonRight {
 			buttonIndex = rightMouseButton
+			r.probeCursorPixel(xPos, yPos)
 		}
 
 		r.mousePressed[buttonIndex] = true
@@ -291,15 +427,175 @@ func (r *interactiveGLRenderer) onCursorPosEvent(w *glfw.Window, xPos, yPos floa
 	}
 }
 
+// Log the linear radiance of the frame pixel under the cursor.
+func (r *interactiveGLRenderer) probeCursorPixel(xPos, yPos float64) {
+	winW, winH := r.window.GetSize()
+	view := frameViewport(r.options.FrameW, r.options.FrameH, winW, winH, !r.stretchFrame)
+	x, row, inside := view.framePixel(xPos, yPos, winH, r.options.FrameW, r.options.FrameH)
+	if !inside {
+		return
+	}
+
+	// The frame is rendered upside down to match the opengl texture
+	// orientation so window rows need to be flipped.
+	y := r.options.FrameH - 1 - row
+	probe, err := r.ProbePixel(x, y)
+	if err != nil {
+		r.logger.Warningf("could not probe pixel (%d, %d): %v", x, y, err)
+		return
+	}
+
+	r.logger.Noticef(
+		"pixel (%d, %d): radiance (%.4f, %.4f, %.4f), luminance %.4f, %d spp",
+		x, y, probe.Radiance[0], probe.Radiance[1], probe.Radiance[2], probe.Luminance(), probe.Samples,
+	)
+}
+
 func (r *interactiveGLRenderer) updateCamera() {
 	r.Lock()
 	defer r.Unlock()
 
-	for _, tr := range r.tracers {
-		tr.UpdateState(tracer.Asynchronous, tracer.CameraData, r.camera)
+	r.captureHistory()
+	r.UpdateCamera(r.camera)
+}
+
+// Scale the exposure by the given factor. Exposure changes re-apply the
+// tone-mapping stages to the accumulated frame without resetting it.
+func (r *interactiveGLRenderer) adjustExposure(factor float32) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.captureHistory()
+	opts := r.options
+	opts.Exposure *= factor
+	r.UpdateOptions(opts)
+}
+
+// Select the next light group for adjusting its intensity.
+func (r *interactiveGLRenderer) selectNextLightGroup() {
+	if r.lightGroup == -1 {
+		return
+	}
+
+	r.lightGroup = (r.lightGroup + 1) % opencl.MaxLightGroups
+	r.showLightGroup()
+}
+
+// Scale the intensity of the selected light group by the given factor. Like
+// exposure changes, intensity changes re-apply the post-processing stages to
+// the accumulated frame without resetting it.
+func (r *interactiveGLRenderer) adjustLightIntensity(factor float32) {
+	if r.lightGroup == -1 {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.captureHistory()
+	// Copy the multipliers so the change can be detected by UpdateOptions
+	opts := r.options
+	numGroups := len(opts.LightGroupScales)
+	if numGroups <= r.lightGroup {
+		numGroups = r.lightGroup + 1
+	}
+	opts.LightGroupScales = make([]float32, numGroups)
+	for group := range opts.LightGroupScales {
+		opts.LightGroupScales[group] = lightGroupScale(r.options.LightGroupScales, group)
+	}
+	opts.LightGroupScales[r.lightGroup] *= factor
+	r.UpdateOptions(opts)
+	r.showLightGroup()
+}
+
+// Display the selected light group and its intensity in the window title.
+func (r *interactiveGLRenderer) showLightGroup() {
+	r.window.SetTitle(fmt.Sprintf("%s [light group %d: x%.2f]", windowTitle, r.lightGroup, lightGroupScale(r.options.LightGroupScales, r.lightGroup)))
+}
+
+// Save the current camera and render settings as a named bookmark and persist
+// the bookmark set.
+func (r *interactiveGLRenderer) saveBookmark(name string) {
+	if r.bookmarks == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.bookmarks.Set(NewBookmark(name, r.camera, r.options))
+	if r.bookmarks.Save() != nil {
+		r.window.SetTitle(fmt.Sprintf("%s [could not save bookmark %s]", windowTitle, name))
+		return
+	}
+	r.window.SetTitle(fmt.Sprintf("%s [saved bookmark %s]", windowTitle, name))
+}
+
+// Restore the camera and render settings from a named bookmark.
+func (r *interactiveGLRenderer) restoreBookmark(name string) {
+	if r.bookmarks == nil {
+		return
 	}
 
-	r.accumulatedSamples = 0
+	bm, err := r.bookmarks.Get(name)
+	if err != nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.captureHistory()
+	r.UpdateOptions(bm.Apply(r.camera, r.options))
+	r.UpdateCamera(r.camera)
+	r.window.SetTitle(fmt.Sprintf("%s [bookmark %s]", windowTitle, name))
+}
+
+// Return to the live frame and add the current frame to the history before
+// it gets replaced by a state change. Frames with too few samples are skipped.
+// Must be called while holding the renderer lock.
+func (r *interactiveGLRenderer) captureHistory() {
+	if r.history == nil {
+		return
+	}
+
+	r.history.Reset()
+	r.setReviewFrame(nil)
+	if r.accumulatedSamples < historyMinSamples || r.ReadFrame(r.historyFrame) != nil {
+		return
+	}
+	r.history.PushImage(r.historyFrame)
+}
+
+// Step back to an older history frame or forward towards the live frame.
+func (r *interactiveGLRenderer) stepHistory(back bool) {
+	if r.history == nil {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if back {
+		if frame, ok := r.history.Back(); ok {
+			r.setReviewFrame(frame)
+		}
+		return
+	}
+
+	frame, _ := r.history.Forward()
+	r.setReviewFrame(frame)
+}
+
+// Set the displayed history frame and update the window title to indicate
+// whether a history frame or the live frame is displayed.
+func (r *interactiveGLRenderer) setReviewFrame(frame []byte) {
+	r.reviewFrame = frame
+	if frame == nil {
+		r.window.SetTitle(windowTitle)
+		return
+	}
+	r.window.SetTitle(fmt.Sprintf("%s [history %d/%d]", windowTitle, r.history.Cursor()+1, r.history.Len()))
 }
 
 type stackedSeries struct {
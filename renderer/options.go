@@ -1,5 +1,12 @@
 package renderer
 
+import (
+	"strings"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
 type Options struct {
 	// Frame dims.
 	FrameW uint32
@@ -14,10 +21,91 @@ type Options struct {
 	// Number of samples.
 	SamplesPerPixel uint32
 
+	// An optional schedule for the number of samples collected by each
+	// call to Accumulate. If enabled, SamplesPerPixel specifies the total
+	// number of samples to collect.
+	Schedule SampleSchedule
+
+	// Optional budgets for the work performed by each rendered pass (a
+	// single call to Render or Accumulate; i.e. a displayed frame when
+	// rendering interactively). RayBudget caps the estimated number of
+	// traced rays while TimeBudget caps the render time based on the time
+	// per sample measured for the previous pass. The budgets limit the
+	// number of samples per pixel collected by each pass so that the
+	// renderer does not monopolize a device that is also used by other
+	// applications. Render splits its samples into multiple passes that
+	// fit the budget. Passes always collect at least one sample per pixel.
+	RayBudget  uint64
+	TimeBudget time.Duration
+
 	// Exposure for tonemapping.
 	Exposure float32
 
+	// The intensity multipliers for the light groups of tracers that
+	// accumulate a separate pass for each light group (see the
+	// LightGroups field of the opencl pipeline). Groups without a
+	// multiplier use 1.
+	LightGroupScales []float32
+
+	// If non-zero, seed the random number generators of the tracers with
+	// this value so that renders are reproducible.
+	Seed int64
+
+	// The number of tonemapped frames kept by the interactive renderer
+	// so users can step back and forth between them. If HistoryFile is
+	// set, the frames are stored in a memory-mapped file at that path.
+	HistoryFrames int
+	HistoryFile   string
+
+	// If set, the interactive renderer saves and restores bookmarks
+	// using the number keys and persists them to this file.
+	BookmarkFile string
+
+	// If set, the interactive renderer window can be resized. Frames are
+	// still rendered at FrameW x FrameH and scaled to fit the window while
+	// preserving the camera aspect ratio.
+	ResizableWindow bool
+
 	// Device selection.
 	BlackListedDevices []string
 	ForcePrimaryDevice string
+
+	// Additional kernel build options for the selected devices. Each entry
+	// applies to the devices whose name contains its Device field. The
+	// options of all matching entries are merged in order.
+	DeviceBuildOptions []DeviceBuildOptions
+
+	// The size of the BVH traversal stack used by the opencl kernels. If
+	// zero, the kernel default is used. A BVH_MAX_STACK_SIZE define in
+	// DeviceBuildOptions takes precedence over this value.
+	TraversalStackSize uint32
+
+	// If set, the opencl kernels traverse a compressed version of the
+	// scene BVH with 8-wide nodes and quantized child bounds.
+	CompressedBvh bool
+
+	// By default, the renderer acquires an exclusive lock for each
+	// selected device and skips devices locked by other polaris processes.
+	// If set, devices are used without acquiring any locks.
+	ShareDevices bool
+}
+
+// Kernel build options for a set of devices.
+type DeviceBuildOptions struct {
+	// The options apply to devices whose name contains this value. If
+	// empty, the options apply to all devices.
+	Device string
+
+	device.BuildOptions
+}
+
+// Merge the build options of the entries that match a device name.
+func buildOptionsFor(deviceName string, list []DeviceBuildOptions) device.BuildOptions {
+	var opts device.BuildOptions
+	for _, entry := range list {
+		if strings.Contains(deviceName, entry.Device) {
+			opts = opts.Merge(entry.BuildOptions)
+		}
+	}
+	return opts
 }
@@ -0,0 +1,30 @@
+package renderer
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+func TestBuildOptionsFor(t *testing.T) {
+	list := []DeviceBuildOptions{
+		{BuildOptions: device.BuildOptions{Flags: []string{"-cl-mad-enable"}}},
+		{Device: "GeForce", BuildOptions: device.BuildOptions{Defines: map[string]string{"BVH_MAX_STACK_SIZE": "64"}}},
+		{Device: "Radeon", BuildOptions: device.BuildOptions{Flags: []string{"-cl-fast-relaxed-math"}}},
+	}
+
+	specs := []struct {
+		name string
+		exp  string
+	}{
+		{"GeForce GTX 1080", "-D BVH_MAX_STACK_SIZE=64 -cl-mad-enable"},
+		{"AMD Radeon Pro 560", "-cl-mad-enable -cl-fast-relaxed-math"},
+		{"Intel(R) Core(TM) i7", "-cl-mad-enable"},
+	}
+
+	for index, spec := range specs {
+		if got := buildOptionsFor(spec.name, list).String(); got != spec.exp {
+			t.Errorf("[spec %d] expected build options for %q to be %q; got %q", index, spec.name, spec.exp, got)
+		}
+	}
+}
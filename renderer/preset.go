@@ -0,0 +1,154 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer/opencl"
+)
+
+var (
+	ErrUnknownPreset = errors.New("renderer: unknown preset")
+)
+
+// A Preset bundles the sampling, integrator and output settings that suit a
+// particular use case. Presets provide the defaults for a render; settings
+// that are explicitly specified by the user take precedence over the preset
+// values.
+type Preset struct {
+	Name        string
+	Description string
+
+	// Sampling settings.
+	SamplesPerPixel uint32
+	Schedule        SampleSchedule
+	PixelFilter     opencl.PixelFilter
+	FirstHitCache   bool
+
+	// Integrator settings. A zero MinBouncesForRR disables russian
+	// roulette.
+	NumBounces      uint32
+	MinBouncesForRR uint32
+	SampleClamp     opencl.SampleClamp
+	ShadowMode      opencl.ShadowMode
+
+	// Debug images generated for each rendered frame.
+	DebugFlags   opencl.DebugFlag
+	DebugMapping opencl.DebugMapping
+	DebugPalette opencl.DebugPalette
+
+	// Output settings. Depth16 is only applied to formats that support
+	// 16-bit channels.
+	Depth16 bool
+}
+
+// The presets supported by the renderer.
+var (
+	// Fast feedback while editing scenes or moving the camera. Renders
+	// progressively using a box filter, caches primary hits, approximates
+	// soft shadows using cone tracing and clamps indirect samples to hide
+	// the fireflies of low sample counts. When rendering still frames, a
+	// single sample per pixel is collected.
+	PreviewPreset = Preset{
+		Name:            "preview",
+		Description:     "fast progressive rendering for interactive scene editing",
+		SamplesPerPixel: 0,
+		Schedule:        SampleSchedule{Initial: 1, Factor: 2, Max: 8},
+		PixelFilter:     opencl.BoxFilter,
+		FirstHitCache:   true,
+		NumBounces:      3,
+		MinBouncesForRR: 2,
+		SampleClamp:     opencl.SampleClamp{Indirect: 10},
+		ShadowMode:      opencl.ConeShadows,
+	}
+
+	// Final frame quality. Collects a large number of samples through a
+	// growing schedule, traces deep paths and saves 16-bit images. The
+	// indirect clamp is kept high so that only extreme fireflies are
+	// removed.
+	ProductionPreset = Preset{
+		Name:            "production",
+		Description:     "high quality final frames",
+		SamplesPerPixel: 1024,
+		Schedule:        SampleSchedule{Initial: 16, Factor: 2, Max: 256},
+		PixelFilter:     opencl.GaussianFilter,
+		NumBounces:      8,
+		MinBouncesForRR: 4,
+		SampleClamp:     opencl.SampleClamp{Indirect: 100},
+		Depth16:         true,
+	}
+
+	// Kernel debugging. Traces a single deterministic sample per pixel
+	// through the pixel center and dumps the intermediate buffers of the
+	// pipeline as debug images. Debug values are log-mapped as
+	// throughput values span several orders of magnitude and depth
+	// heatmaps use the color-blind safe viridis palette.
+	DebugPreset = Preset{
+		Name:            "debug",
+		Description:     "single sample renders that dump the intermediate pipeline buffers",
+		SamplesPerPixel: 1,
+		PixelFilter:     opencl.PointFilter,
+		NumBounces:      2,
+		DebugFlags:      opencl.PrimaryRayIntersectionDepth | opencl.PrimaryRayIntersectionNormals | opencl.Throughput | opencl.Accumulator,
+		DebugMapping:    opencl.LogDebugMapping,
+		DebugPalette:    opencl.ViridisDebugPalette,
+	}
+)
+
+// Get the list of supported presets.
+func Presets() []Preset {
+	return []Preset{PreviewPreset, ProductionPreset, DebugPreset}
+}
+
+// Lookup a preset by name.
+func LookupPreset(name string) (Preset, error) {
+	var names []string
+	for _, preset := range Presets() {
+		if strings.EqualFold(name, preset.Name) {
+			return preset, nil
+		}
+		names = append(names, preset.Name)
+	}
+
+	return Preset{}, fmt.Errorf("%s %q; supported presets are %s", ErrUnknownPreset.Error(), name, strings.Join(names, ", "))
+}
+
+// Apply the preset sampling and integrator settings to a set of renderer
+// options. Russian roulette is disabled if the preset does not specify a
+// min number of bounces.
+func (p Preset) Apply(opts Options) Options {
+	opts.SamplesPerPixel = p.SamplesPerPixel
+	opts.Schedule = p.Schedule
+	opts.NumBounces = p.NumBounces
+	opts.MinBouncesForRR = p.MinBouncesForRR
+	if opts.MinBouncesForRR == 0 {
+		opts.MinBouncesForRR = opts.NumBounces + 1
+	}
+	return opts
+}
+
+// Get the pipeline options for the preset. Additional options may be
+// appended to override the preset settings.
+func (p Preset) PipelineOptions() []opencl.PipelineOption {
+	opts := []opencl.PipelineOption{
+		opencl.WithPixelFilter(p.PixelFilter),
+		opencl.WithSampleClamp(p.SampleClamp),
+		opencl.WithShadowMode(p.ShadowMode),
+		opencl.WithDebugMapping(p.DebugMapping),
+		opencl.WithDebugPalette(p.DebugPalette),
+	}
+	if p.FirstHitCache {
+		opts = append(opts, opencl.WithFirstHitCache())
+	}
+	if p.DebugFlags != opencl.NoDebug {
+		opts = append(opts, opencl.WithDebugFlags(p.DebugFlags))
+	}
+	return opts
+}
+
+// Create a rendering pipeline using the preset settings followed by any
+// additional options.
+func (p Preset) Pipeline(opts ...opencl.PipelineOption) *opencl.Pipeline {
+	return opencl.DefaultPipeline(append(p.PipelineOptions(), opts...)...)
+}
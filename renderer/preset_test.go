@@ -0,0 +1,58 @@
+package renderer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLookupPreset(t *testing.T) {
+	for _, name := range []string{"preview", "Production", "DEBUG"} {
+		preset, err := LookupPreset(name)
+		if err != nil {
+			t.Fatalf("error looking up preset %q: %v", name, err)
+		}
+		if !strings.EqualFold(preset.Name, name) {
+			t.Errorf("expected lookup of %q to return preset %q; got %q", name, strings.ToLower(name), preset.Name)
+		}
+	}
+
+	_, err := LookupPreset("draft")
+	if err == nil || !strings.HasPrefix(err.Error(), ErrUnknownPreset.Error()) {
+		t.Fatalf("expected to get ErrUnknownPreset; got %v", err)
+	}
+	if !strings.Contains(err.Error(), "preview, production, debug") {
+		t.Errorf("expected error to list the supported presets; got %v", err)
+	}
+}
+
+func TestPresetApply(t *testing.T) {
+	base := Options{FrameW: 640, FrameH: 480, Exposure: 1.5, SamplesPerPixel: 16, NumBounces: 5, MinBouncesForRR: 3}
+
+	opts := ProductionPreset.Apply(base)
+	if opts.FrameW != 640 || opts.FrameH != 480 || opts.Exposure != 1.5 {
+		t.Errorf("expected Apply to preserve the frame and exposure settings; got %+v", opts)
+	}
+	if opts.SamplesPerPixel != 1024 || opts.NumBounces != 8 || opts.MinBouncesForRR != 4 {
+		t.Errorf("expected Apply to use the production sampling settings; got %+v", opts)
+	}
+	if opts.Schedule != ProductionPreset.Schedule {
+		t.Errorf("expected schedule %+v; got %+v", ProductionPreset.Schedule, opts.Schedule)
+	}
+
+	// The debug preset does not use russian roulette
+	opts = DebugPreset.Apply(base)
+	if opts.MinBouncesForRR <= opts.NumBounces {
+		t.Errorf("expected Apply to disable russian roulette; got %d min bounces for %d bounces", opts.MinBouncesForRR, opts.NumBounces)
+	}
+	if opts.Schedule.Enabled() {
+		t.Errorf("expected the debug preset to disable the sample schedule")
+	}
+}
+
+func TestPresetPipeline(t *testing.T) {
+	for _, preset := range Presets() {
+		if preset.Pipeline() == nil {
+			t.Errorf("[preset %s] expected Pipeline to return a pipeline", preset.Name)
+		}
+	}
+}
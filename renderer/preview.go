@@ -0,0 +1,102 @@
+package renderer
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/achilleasa/polaris/asset/compiler"
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+)
+
+const (
+	// Defaults for material preview renders.
+	defaultPreviewSize       uint32 = 128
+	defaultPreviewSamples    uint32 = 64
+	defaultPreviewNumBounces uint32 = 5
+
+	// The seed used for material previews so that previews of the same
+	// material are identical.
+	previewSeed int64 = 1
+)
+
+// Options for rendering material previews.
+type PreviewOptions struct {
+	// Preview image dims. If not specified, a 128x128 image is rendered.
+	FrameW uint32
+	FrameH uint32
+
+	// Number of samples per pixel. Defaults to 64.
+	SamplesPerPixel uint32
+
+	// Number of indirect bounces. Defaults to 5.
+	NumBounces uint32
+
+	// Device selection.
+	BlackListedDevices []string
+	ForcePrimaryDevice string
+	ShareDevices       bool
+}
+
+// Render a material preview. The material expression is applied to a sphere
+// placed on top of a checkered floor and lit by a fixed area light. The
+// scene, lighting and random seed are always the same so previews of
+// different materials can be compared side by side. Texture paths used by the
+// material expression are resolved relative to the current working directory.
+// Invalid expressions and missing textures are reported as errors.
+func RenderMaterialPreview(expr string, opts PreviewOptions) (*image.RGBA, error) {
+	// Compile in strict mode so that invalid expressions and missing
+	// textures are reported instead of being replaced by default values
+	sc, err := compiler.CompileWithOptions(
+		testscenes.MaterialBall(expr),
+		compiler.Options{Report: compiler.NewReport(nil, true)},
+	)
+	if err != nil {
+		return nil, tracer.WrapError(tracer.ErrSceneInvalid, fmt.Errorf("renderer: invalid preview material: %s", err.Error()))
+	}
+
+	if opts.FrameW == 0 {
+		opts.FrameW = defaultPreviewSize
+	}
+	if opts.FrameH == 0 {
+		opts.FrameH = defaultPreviewSize
+	}
+	if opts.SamplesPerPixel == 0 {
+		opts.SamplesPerPixel = defaultPreviewSamples
+	}
+	if opts.NumBounces == 0 {
+		opts.NumBounces = defaultPreviewNumBounces
+	}
+
+	renderOpts := Options{
+		NumBounces:         opts.NumBounces,
+		MinBouncesForRR:    opts.NumBounces + 1,
+		SamplesPerPixel:    opts.SamplesPerPixel,
+		Exposure:           1.0,
+		Seed:               previewSeed,
+		BlackListedDevices: opts.BlackListedDevices,
+		ForcePrimaryDevice: opts.ForcePrimaryDevice,
+		ShareDevices:       opts.ShareDevices,
+	}
+	renderOpts.FrameW, renderOpts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+
+	r, err := NewDefault(sc, tracer.NaiveScheduler(), opencl.DefaultPipeline(), renderOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	err = r.Render()
+	if err != nil {
+		return nil, err
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, int(renderOpts.FrameW), int(renderOpts.FrameH)))
+	err = r.ReadFrame(im)
+	if err != nil {
+		return nil, err
+	}
+
+	return im, nil
+}
@@ -0,0 +1,18 @@
+package renderer
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestRenderMaterialPreviewInvalidExpression(t *testing.T) {
+	_, err := RenderMaterialPreview("not_a_material(", PreviewOptions{})
+	if err == nil {
+		t.Fatal("expected an error for an invalid material expression")
+	}
+
+	if kind := tracer.ErrorKind(err); kind != tracer.ErrSceneInvalid {
+		t.Fatalf("expected error kind to be %v; got %v", tracer.ErrSceneInvalid, kind)
+	}
+}
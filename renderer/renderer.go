@@ -1,12 +1,67 @@
 package renderer
 
+import (
+	"context"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+)
+
 type Renderer interface {
 	// Render frame.
 	Render() error
 
+	// Render frame. If ctx is cancelled while rendering, the block
+	// requests that are being traced are aborted and the context error is
+	// returned.
+	RenderContext(ctx context.Context) error
+
+	// Render a frame and accumulate its samples on top of the samples
+	// collected by previous calls to Accumulate. Camera and option updates
+	// (other than exposure and light group intensity changes) reset the
+	// accumulated samples.
+	Accumulate() error
+
+	// Like Accumulate but aborts rendering when ctx is cancelled. The
+	// samples accumulated so far are discarded if the frame is aborted.
+	AccumulateContext(ctx context.Context) error
+
+	// Get the number of samples per pixel accumulated so far.
+	AccumulatedSamples() uint32
+
+	// Update the camera used for rendering subsequent frames.
+	UpdateCamera(*scene.Camera)
+
+	// Update the mesh instance data used for rendering subsequent frames.
+	// Tracers keep the static scene data resident and only upload the
+	// instance data which makes this the preferred way to render animated
+	// sequences of the same scene.
+	UpdateInstances(*scene.InstanceUpdate)
+
+	// Update the options used for rendering subsequent frames. Changes
+	// that only affect the exposure or the light group intensities are
+	// applied to the last rendered frame without resetting the
+	// accumulated samples.
+	UpdateOptions(Options)
+
 	// Shutdown renderer and any attached tracer.
 	Close()
 
 	// Get render statistics.
 	Stats() FrameStats
+
+	// Read the linear radiance of the last rendered frame normalized by the
+	// number of accumulated samples. The output slice must be able to hold
+	// 3 float32 values (RGB) for each frame pixel.
+	ReadRadiance([]float32) error
+
+	// Read the last rendered frame into dst. Supported targets are
+	// *image.RGBA, *image.NRGBA and []float32 slices that receive 4 values
+	// (RGBA) in the [0, 1] range for each frame pixel.
+	ReadFrame(dst interface{}) error
+
+	// Read the linear radiance and any additional per-pixel outputs of the
+	// frame pixel at (x, y) of the last rendered frame without reading back
+	// the entire frame.
+	ProbePixel(x, y uint32) (tracer.PixelProbe, error)
 }
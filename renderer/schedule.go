@@ -0,0 +1,103 @@
+package renderer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidSchedule = errors.New("renderer: invalid sample schedule")
+)
+
+// A SampleSchedule controls the number of samples per pixel that are collected
+// by each accumulation pass. Starting with a small batch and growing it after
+// each pass allows the renderer to display a usable image immediately while
+// later passes amortize the kernel launch overhead over more samples.
+type SampleSchedule struct {
+	// The number of samples collected by the first pass. A zero value
+	// disables the schedule.
+	Initial uint32
+
+	// The batch size multiplier applied after each pass. Values less
+	// than 2 keep the batch size constant.
+	Factor uint32
+
+	// The max number of samples collected by a single pass. A zero value
+	// disables the limit.
+	Max uint32
+}
+
+// Parse a sample schedule definition with format "initial[:factor[:max]]".
+// For example "1:2:64" collects 1 sample in the first pass and doubles the
+// samples for each subsequent pass up to 64 samples per pass. An empty
+// definition returns a disabled schedule.
+func ParseSampleSchedule(def string) (SampleSchedule, error) {
+	var sched SampleSchedule
+	if def == "" {
+		return sched, nil
+	}
+
+	tokens := strings.Split(def, ":")
+	if len(tokens) > 3 {
+		return sched, fmt.Errorf("%s %q: expected format initial[:factor[:max]]", ErrInvalidSchedule.Error(), def)
+	}
+
+	fields := []*uint32{&sched.Initial, &sched.Factor, &sched.Max}
+	for index, token := range tokens {
+		val, err := strconv.ParseUint(strings.TrimSpace(token), 10, 32)
+		if err != nil {
+			return sched, fmt.Errorf("%s %q: %v", ErrInvalidSchedule.Error(), def, err)
+		}
+		*fields[index] = uint32(val)
+	}
+
+	if sched.Initial == 0 {
+		return sched, fmt.Errorf("%s %q: the initial number of samples must be > 0", ErrInvalidSchedule.Error(), def)
+	}
+	if sched.Max != 0 && sched.Max < sched.Initial {
+		return sched, fmt.Errorf("%s %q: the max number of samples must be >= the initial number of samples", ErrInvalidSchedule.Error(), def)
+	}
+
+	return sched, nil
+}
+
+// Check whether the schedule is enabled.
+func (s SampleSchedule) Enabled() bool {
+	return s.Initial != 0
+}
+
+// Get the number of samples to collect for the given pass (starting at 0).
+func (s SampleSchedule) Batch(pass uint32) uint32 {
+	batch := s.Initial
+	if batch == 0 {
+		batch = 1
+	}
+
+	if s.Factor < 2 {
+		return batch
+	}
+
+	for ; pass > 0; pass-- {
+		next := batch * s.Factor
+		if next/s.Factor != batch || (s.Max != 0 && next >= s.Max) {
+			// Clamp to max or to the largest value before overflowing
+			if s.Max != 0 {
+				return s.Max
+			}
+			return batch
+		}
+		batch = next
+	}
+
+	return batch
+}
+
+// Implements Stringer.
+func (s SampleSchedule) String() string {
+	if !s.Enabled() {
+		return "disabled"
+	}
+	return fmt.Sprintf("%d:%d:%d", s.Initial, s.Factor, s.Max)
+}
@@ -0,0 +1,95 @@
+package renderer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSampleSchedule(t *testing.T) {
+	specs := []struct {
+		def    string
+		exp    SampleSchedule
+		expErr bool
+	}{
+		{def: "", exp: SampleSchedule{}},
+		{def: "4", exp: SampleSchedule{Initial: 4}},
+		{def: "1:2", exp: SampleSchedule{Initial: 1, Factor: 2}},
+		{def: "1:2:64", exp: SampleSchedule{Initial: 1, Factor: 2, Max: 64}},
+		{def: "0:2", expErr: true},
+		{def: "8:2:4", expErr: true},
+		{def: "1:2:3:4", expErr: true},
+		{def: "1:x", expErr: true},
+		{def: "-1", expErr: true},
+	}
+
+	for index, spec := range specs {
+		sched, err := ParseSampleSchedule(spec.def)
+		if spec.expErr {
+			if err == nil {
+				t.Errorf("[spec %d] expected an error while parsing %q", index, spec.def)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error while parsing %q: %v", index, spec.def, err)
+			continue
+		}
+		if sched != spec.exp {
+			t.Errorf("[spec %d] expected schedule %v; got %v", index, spec.exp, sched)
+		}
+	}
+}
+
+func TestSampleScheduleBatch(t *testing.T) {
+	specs := []struct {
+		sched SampleSchedule
+		exp   []uint32
+	}{
+		{SampleSchedule{Initial: 4}, []uint32{4, 4, 4, 4}},
+		{SampleSchedule{Initial: 1, Factor: 2}, []uint32{1, 2, 4, 8, 16}},
+		{SampleSchedule{Initial: 1, Factor: 3, Max: 20}, []uint32{1, 3, 9, 20, 20}},
+		{SampleSchedule{Initial: 1 << 31, Factor: 2}, []uint32{1 << 31, 1 << 31}},
+	}
+
+	for index, spec := range specs {
+		got := make([]uint32, len(spec.exp))
+		for pass := range got {
+			got[pass] = spec.sched.Batch(uint32(pass))
+		}
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected batches %v; got %v", index, spec.exp, got)
+		}
+	}
+}
+
+func TestNextBatchSize(t *testing.T) {
+	r := &defaultRenderer{
+		options: Options{
+			SamplesPerPixel: 10,
+			Schedule:        SampleSchedule{Initial: 1, Factor: 2},
+		},
+	}
+
+	var got []uint32
+	for r.accumulatedSamples < r.options.SamplesPerPixel {
+		spp := r.nextBatchSize()
+		got = append(got, spp)
+		r.accumulatedSamples += spp
+		r.accumulatedPasses++
+	}
+
+	exp := []uint32{1, 2, 4, 3}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected batches %v; got %v", exp, got)
+	}
+
+	// Without a schedule each pass collects the requested samples
+	r = &defaultRenderer{options: Options{SamplesPerPixel: 10}}
+	if spp := r.nextBatchSize(); spp != 10 {
+		t.Fatalf("expected batch size to be 10; got %d", spp)
+	}
+	r.options.SamplesPerPixel = 0
+	if spp := r.nextBatchSize(); spp != 1 {
+		t.Fatalf("expected progressive batch size to be 1; got %d", spp)
+	}
+}
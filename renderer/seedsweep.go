@@ -0,0 +1,267 @@
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	// Defaults for seed sweeps.
+	defaultSeedSweepSize    uint32 = 256
+	defaultSeedSweepSamples uint32 = 4
+	defaultSeedSweepSeeds          = 16
+)
+
+// Options for rendering seed sweeps.
+type SeedSweepOptions struct {
+	// Frame dims. If not specified, a 256x256 frame is rendered.
+	FrameW uint32
+	FrameH uint32
+
+	// Number of samples per pixel for each seed. Defaults to 4.
+	SamplesPerPixel uint32
+
+	// Number of indirect bounces and the min bounces before applying
+	// russian roulette. Defaults to 5 bounces without russian roulette.
+	NumBounces      uint32
+	MinBouncesForRR uint32
+
+	// The number of rendered seeds. Defaults to 16. The frames are rendered
+	// using the seeds FirstSeed to FirstSeed + NumSeeds - 1; as a zero seed
+	// selects a random seed, FirstSeed defaults to 1.
+	NumSeeds  int
+	FirstSeed int64
+
+	// The pipeline used for rendering each seed. If not specified, the
+	// default pipeline is used.
+	Pipeline *opencl.Pipeline
+
+	// Device selection.
+	BlackListedDevices []string
+	ForcePrimaryDevice string
+	ShareDevices       bool
+}
+
+// The per-pixel statistics of the luminance of frames rendered using
+// different seeds.
+type SeedSweep struct {
+	FrameW, FrameH uint32
+	NumSeeds       int
+
+	// The mean and the unbiased sample variance of the luminance of each
+	// pixel across all seeds.
+	Mean     []float32
+	Variance []float32
+}
+
+// A summary of the per-pixel variance of a seed sweep.
+type SeedSweepSummary struct {
+	NumSeeds int `json:"num_seeds"`
+	Pixels   int `json:"pixels"`
+
+	// The mean luminance of all pixels.
+	MeanLuminance float64 `json:"mean_luminance"`
+
+	// Statistics of the per-pixel variance.
+	MeanVariance   float64 `json:"mean_variance"`
+	MedianVariance float64 `json:"median_variance"`
+	P99Variance    float64 `json:"p99_variance"`
+	MaxVariance    float64 `json:"max_variance"`
+
+	// The mean of the per-pixel relative variance (variance / mean^2)
+	// which is less sensitive to the brightness of the scene. Pixels with
+	// a zero mean are excluded.
+	MeanRelativeVariance float64 `json:"mean_relative_variance"`
+}
+
+// Render the scene using multiple seeds at a low sample count and calculate
+// the per-pixel mean and variance of the rendered luminance. Comparing the
+// variance of sweeps that use the same sample count is a simple way of
+// quantifying the effect of changes to the samplers and integrators. The
+// scene camera is set up for the sweep frame dims.
+func RenderSeedSweep(sc *scene.Scene, opts SeedSweepOptions) (*SeedSweep, error) {
+	if opts.FrameW == 0 {
+		opts.FrameW = defaultSeedSweepSize
+	}
+	if opts.FrameH == 0 {
+		opts.FrameH = defaultSeedSweepSize
+	}
+	if opts.SamplesPerPixel == 0 {
+		opts.SamplesPerPixel = defaultSeedSweepSamples
+	}
+	if opts.NumBounces == 0 {
+		opts.NumBounces = defaultPreviewNumBounces
+	}
+	if opts.MinBouncesForRR == 0 || opts.MinBouncesForRR >= opts.NumBounces {
+		opts.MinBouncesForRR = opts.NumBounces + 1
+	}
+	if opts.NumSeeds == 0 {
+		opts.NumSeeds = defaultSeedSweepSeeds
+	}
+	if opts.NumSeeds < 2 {
+		return nil, fmt.Errorf("renderer: a seed sweep requires at least 2 seeds; got %d", opts.NumSeeds)
+	}
+	if opts.FirstSeed == 0 {
+		opts.FirstSeed = 1
+	}
+	if opts.Pipeline == nil {
+		opts.Pipeline = opencl.DefaultPipeline()
+	}
+
+	renderOpts := Options{
+		NumBounces:         opts.NumBounces,
+		MinBouncesForRR:    opts.MinBouncesForRR,
+		SamplesPerPixel:    opts.SamplesPerPixel,
+		Exposure:           1.0,
+		BlackListedDevices: opts.BlackListedDevices,
+		ForcePrimaryDevice: opts.ForcePrimaryDevice,
+		ShareDevices:       opts.ShareDevices,
+	}
+	renderOpts.FrameW, renderOpts.FrameH = sc.Camera.SetupFrame(opts.FrameW, opts.FrameH)
+
+	sweep := newSeedSweep(renderOpts.FrameW, renderOpts.FrameH)
+	radiance := make([]float32, renderOpts.FrameW*renderOpts.FrameH*3)
+	for index := 0; index < opts.NumSeeds; index++ {
+		// The tracers are seeded when the renderer is created
+		renderOpts.Seed = opts.FirstSeed + int64(index)
+		r, err := NewDefault(sc, tracer.NaiveScheduler(), opts.Pipeline, renderOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.Render()
+		if err == nil {
+			err = r.ReadRadiance(radiance)
+		}
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		sweep.add(radiance)
+	}
+
+	sweep.finalize()
+	return sweep, nil
+}
+
+// Create an empty seed sweep for the given frame dims.
+func newSeedSweep(frameW, frameH uint32) *SeedSweep {
+	return &SeedSweep{
+		FrameW:   frameW,
+		FrameH:   frameH,
+		Mean:     make([]float32, frameW*frameH),
+		Variance: make([]float32, frameW*frameH),
+	}
+}
+
+// Update the per-pixel statistics with the RGB radiance of a rendered frame.
+// The statistics are updated using Welford's algorithm; until finalize is
+// called, Variance stores the sum of squared differences from the mean.
+func (s *SeedSweep) add(radiance []float32) {
+	s.NumSeeds++
+	n := float64(s.NumSeeds)
+	for pixel := range s.Mean {
+		lum := 0.2126*float64(radiance[pixel*3]) + 0.7152*float64(radiance[pixel*3+1]) + 0.0722*float64(radiance[pixel*3+2])
+		mean := float64(s.Mean[pixel])
+		delta := lum - mean
+		mean += delta / n
+		s.Mean[pixel] = float32(mean)
+		s.Variance[pixel] += float32(delta * (lum - mean))
+	}
+}
+
+// Convert the accumulated sums of squared differences into variances.
+func (s *SeedSweep) finalize() {
+	if s.NumSeeds < 2 {
+		return
+	}
+	for pixel := range s.Variance {
+		s.Variance[pixel] /= float32(s.NumSeeds - 1)
+	}
+}
+
+// Get the per-pixel relative variance (variance / mean^2). The relative
+// variance of pixels with a zero mean is set to zero.
+func (s *SeedSweep) RelativeVariance() []float32 {
+	relVar := make([]float32, len(s.Variance))
+	for pixel, variance := range s.Variance {
+		if mean := s.Mean[pixel]; mean > 0 {
+			relVar[pixel] = variance / (mean * mean)
+		}
+	}
+	return relVar
+}
+
+// Summarize the per-pixel variance of the sweep.
+func (s *SeedSweep) Summary() SeedSweepSummary {
+	summary := SeedSweepSummary{
+		NumSeeds: s.NumSeeds,
+		Pixels:   len(s.Variance),
+	}
+	if len(s.Variance) == 0 {
+		return summary
+	}
+
+	sorted := make([]float64, len(s.Variance))
+	var relVarSum float64
+	var relVarCount int
+	for pixel, variance := range s.Variance {
+		sorted[pixel] = float64(variance)
+		summary.MeanVariance += float64(variance)
+		summary.MeanLuminance += float64(s.Mean[pixel])
+		if mean := float64(s.Mean[pixel]); mean > 0 {
+			relVarSum += float64(variance) / (mean * mean)
+			relVarCount++
+		}
+	}
+	sort.Float64s(sorted)
+
+	summary.MeanVariance /= float64(len(sorted))
+	summary.MeanLuminance /= float64(len(sorted))
+	summary.MedianVariance = percentile(sorted, 0.5)
+	summary.P99Variance = percentile(sorted, 0.99)
+	summary.MaxVariance = sorted[len(sorted)-1]
+	if relVarCount > 0 {
+		summary.MeanRelativeVariance = relVarSum / float64(relVarCount)
+	}
+	return summary
+}
+
+// Build a tabular representation of the summary.
+func (s SeedSweepSummary) String() string {
+	var buf bytes.Buffer
+	table := tablewriter.NewWriter(&buf)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeader([]string{"Statistic", "Value"})
+	table.Append([]string{"Seeds", strconv.Itoa(s.NumSeeds)})
+	table.Append([]string{"Pixels", strconv.Itoa(s.Pixels)})
+	table.Append([]string{"Mean luminance", fmt.Sprintf("%g", s.MeanLuminance)})
+	table.Append([]string{"Mean variance", fmt.Sprintf("%g", s.MeanVariance)})
+	table.Append([]string{"Median variance", fmt.Sprintf("%g", s.MedianVariance)})
+	table.Append([]string{"99th percentile variance", fmt.Sprintf("%g", s.P99Variance)})
+	table.Append([]string{"Max variance", fmt.Sprintf("%g", s.MaxVariance)})
+	table.Append([]string{"Mean relative variance", fmt.Sprintf("%g", s.MeanRelativeVariance)})
+
+	table.Render()
+	return buf.String()
+}
+
+// Get the value at percentile p (in the [0, 1] range) of a sorted list using
+// the nearest rank method.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,70 @@
+package renderer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+)
+
+func TestSeedSweepStatistics(t *testing.T) {
+	s := newSeedSweep(2, 1)
+
+	// The first pixel is constant; the second pixel alternates between 1
+	// and 3 (variance 4/3 for the unbiased estimator of 4 samples).
+	for _, v := range []float32{1, 3, 1, 3} {
+		s.add([]float32{2, 2, 2, v, v, v})
+	}
+	s.finalize()
+
+	if s.NumSeeds != 4 {
+		t.Fatalf("expected 4 seeds; got %d", s.NumSeeds)
+	}
+	expMean := []float32{2, 2}
+	expVar := []float32{0, 4.0 / 3.0}
+	for pixel := range expMean {
+		if math.Abs(float64(s.Mean[pixel]-expMean[pixel])) > 1e-5 {
+			t.Errorf("[pixel %d] expected mean %f; got %f", pixel, expMean[pixel], s.Mean[pixel])
+		}
+		if math.Abs(float64(s.Variance[pixel]-expVar[pixel])) > 1e-5 {
+			t.Errorf("[pixel %d] expected variance %f; got %f", pixel, expVar[pixel], s.Variance[pixel])
+		}
+	}
+
+	if relVar := s.RelativeVariance(); math.Abs(float64(relVar[1])-1.0/3.0) > 1e-5 {
+		t.Errorf("expected relative variance of second pixel to be %f; got %f", 1.0/3.0, relVar[1])
+	}
+
+	summary := s.Summary()
+	if summary.Pixels != 2 || summary.NumSeeds != 4 {
+		t.Fatalf("expected summary for 2 pixels and 4 seeds; got %d and %d", summary.Pixels, summary.NumSeeds)
+	}
+	specs := []struct {
+		name     string
+		got, exp float64
+	}{
+		{"mean luminance", summary.MeanLuminance, 2},
+		{"mean variance", summary.MeanVariance, 2.0 / 3.0},
+		{"median variance", summary.MedianVariance, 0},
+		{"p99 variance", summary.P99Variance, 4.0 / 3.0},
+		{"max variance", summary.MaxVariance, 4.0 / 3.0},
+		{"mean relative variance", summary.MeanRelativeVariance, 1.0 / 6.0},
+	}
+	for _, spec := range specs {
+		if math.Abs(spec.got-spec.exp) > 1e-5 {
+			t.Errorf("expected %s to be %f; got %f", spec.name, spec.exp, spec.got)
+		}
+	}
+}
+
+func TestRenderSeedSweepRequiresMultipleSeeds(t *testing.T) {
+	sc, err := testscenes.Compile(testscenes.FurnaceSphere(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RenderSeedSweep(sc, SeedSweepOptions{NumSeeds: 1})
+	if err == nil {
+		t.Fatal("expected an error when rendering a single seed")
+	}
+}
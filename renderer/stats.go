@@ -1,6 +1,10 @@
 package renderer
 
-import "time"
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+)
 
 type TracerStat struct {
 	// The tracer id.
@@ -15,6 +19,17 @@ type TracerStat struct {
 
 	// Render time for assigned block
 	RenderTime time.Duration
+
+	// The number of rays whose BVH traversal overflowed the traversal
+	// stack while rendering the assigned block.
+	StackOverflows uint32
+
+	// The last thermal and clock readings for the tracer device and
+	// whether they indicate that the device is being throttled. The
+	// readings are refreshed periodically for tracers that implement
+	// tracer.ThermalReporter.
+	Thermals  tracer.Thermals
+	Throttled bool
 }
 
 type FrameStats struct {
@@ -24,3 +39,14 @@ type FrameStats struct {
 	// Total render time for entire frame.
 	RenderTime time.Duration
 }
+
+// Get the stats for tracers whose devices appear to be throttled.
+func (s FrameStats) ThrottledTracers() []TracerStat {
+	var list []TracerStat
+	for _, stat := range s.Tracers {
+		if stat.Throttled {
+			list = append(list, stat)
+		}
+	}
+	return list
+}
@@ -0,0 +1,54 @@
+package renderer
+
+// A rectangle in window coordinates. The origin is located at the
+// bottom-left corner of the window to match the opengl conventions.
+type viewRect struct {
+	X, Y, W, H int32
+}
+
+// Calculate the window area where a frame with the given dimensions is
+// displayed. If keepAspect is set, the frame is uniformly scaled to fit the
+// window and centered in it so that the unused window area forms bars
+// (letterboxing or pillarboxing). Otherwise, the frame is stretched to cover
+// the entire window.
+func frameViewport(frameW, frameH uint32, winW, winH int, keepAspect bool) viewRect {
+	if !keepAspect || frameW == 0 || frameH == 0 {
+		return viewRect{W: int32(winW), H: int32(winH)}
+	}
+
+	// Compare the aspect ratios using integer math to avoid rounding
+	// errors when the window matches the frame aspect.
+	w, h := winW, winH
+	if uint64(winW)*uint64(frameH) > uint64(winH)*uint64(frameW) {
+		w = int(uint64(winH) * uint64(frameW) / uint64(frameH))
+	} else {
+		h = int(uint64(winW) * uint64(frameH) / uint64(frameW))
+	}
+
+	return viewRect{
+		X: int32((winW - w) / 2),
+		Y: int32((winH - h) / 2),
+		W: int32(w),
+		H: int32(h),
+	}
+}
+
+// Map a cursor position, specified relative to the top-left corner of a
+// window with height winH, to the coordinates of a frame pixel that is
+// displayed inside the view rectangle. The returned row is counted from the
+// top of the displayed frame. The last return value is false if the cursor is
+// located outside the view rectangle.
+func (v viewRect) framePixel(xPos, yPos float64, winH int, frameW, frameH uint32) (uint32, uint32, bool) {
+	if v.W <= 0 || v.H <= 0 {
+		return 0, 0, false
+	}
+
+	top := float64(int32(winH) - v.Y - v.H)
+	fx := (xPos - float64(v.X)) * float64(frameW) / float64(v.W)
+	fy := (yPos - top) * float64(frameH) / float64(v.H)
+	if fx < 0 || fy < 0 || fx >= float64(frameW) || fy >= float64(frameH) {
+		return 0, 0, false
+	}
+
+	return uint32(fx), uint32(fy), true
+}
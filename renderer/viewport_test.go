@@ -0,0 +1,59 @@
+package renderer
+
+import "testing"
+
+func TestFrameViewport(t *testing.T) {
+	specs := []struct {
+		frameW, frameH uint32
+		winW, winH     int
+		keepAspect     bool
+		exp            viewRect
+	}{
+		// Matching aspect
+		{512, 256, 1024, 512, true, viewRect{0, 0, 1024, 512}},
+		// Wider window; bars on the left and right
+		{512, 512, 1000, 500, true, viewRect{250, 0, 500, 500}},
+		// Taller window; bars on the top and bottom
+		{640, 360, 640, 640, true, viewRect{0, 140, 640, 360}},
+		// Aspect lock disabled
+		{640, 360, 640, 640, false, viewRect{0, 0, 640, 640}},
+	}
+
+	for index, spec := range specs {
+		got := frameViewport(spec.frameW, spec.frameH, spec.winW, spec.winH, spec.keepAspect)
+		if got != spec.exp {
+			t.Errorf("[spec %d] expected viewport %+v; got %+v", index, spec.exp, got)
+		}
+	}
+}
+
+func TestViewRectFramePixel(t *testing.T) {
+	// A 640x360 frame displayed with bars above and below it
+	v := frameViewport(640, 360, 640, 640, true)
+
+	specs := []struct {
+		xPos, yPos float64
+		expX, expY uint32
+		expOk      bool
+	}{
+		{0, 140, 0, 0, true},
+		{639.5, 499.5, 639, 359, true},
+		{320, 320, 320, 180, true},
+		// Cursor over the top and bottom bars
+		{320, 100, 0, 0, false},
+		{320, 500, 0, 0, false},
+	}
+
+	for index, spec := range specs {
+		x, y, ok := v.framePixel(spec.xPos, spec.yPos, 640, 640, 360)
+		if ok != spec.expOk || x != spec.expX || y != spec.expY {
+			t.Errorf("[spec %d] expected pixel (%d, %d, %t); got (%d, %d, %t)", index, spec.expX, spec.expY, spec.expOk, x, y, ok)
+		}
+	}
+
+	// Scaled frame
+	v = frameViewport(320, 180, 1280, 720, true)
+	if x, y, ok := v.framePixel(640, 360, 720, 320, 180); !ok || x != 160 || y != 90 {
+		t.Errorf("expected window center to map to pixel (160, 90); got (%d, %d, %t)", x, y, ok)
+	}
+}
@@ -0,0 +1,259 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+)
+
+// History layout constants. When backed by a file, the history starts with a
+// fixed-size header (all values are stored in host byte order):
+//
+//	offset  size  description
+//	0       4     magic value ("PLRH")
+//	4       4     layout version
+//	8       4     frame width in pixels
+//	12      4     frame height in pixels
+//	16      4     capacity in frames
+//	20      4     number of stored frames
+//	24      4     slot index where the next frame will be stored
+//	28      4     reserved
+//	32      ...   frame slots (RGBA8 pixels, tightly packed rows)
+//
+// The newest frame is stored in the slot preceding the next slot index.
+const (
+	HistoryMagic      = "PLRH"
+	HistoryVersion    = 1
+	HistoryHeaderSize = 32
+
+	historyCountOffset = 20
+	historyHeadOffset  = 24
+)
+
+var (
+	ErrInvalidCapacity = errors.New("shm: history capacity must be greater than zero")
+	ErrHistoryClosed   = errors.New("shm: history is closed")
+	ErrNoSuchFrame     = errors.New("shm: no such frame in history")
+)
+
+// A ring buffer that keeps the last N frames so that users can step back and
+// forth between them. The frames are either kept in host memory or in a
+// memory-mapped file that external tools can inspect.
+type History struct {
+	file *os.File
+	data []byte
+
+	frameW   uint32
+	frameH   uint32
+	capacity int
+
+	count int
+	head  int
+
+	// The offset of the reviewed frame from the newest frame or -1 when
+	// the live frame is displayed.
+	cursor int
+}
+
+// Get the total size in bytes of a history file for the given capacity and
+// frame dimensions.
+func HistorySize(capacity int, frameW, frameH uint32) int {
+	return HistoryHeaderSize + capacity*int(frameW*frameH*4)
+}
+
+// Create a history that keeps the last capacity frames in host memory.
+func NewHistory(capacity int, frameW, frameH uint32) (*History, error) {
+	if err := validateHistory(capacity, frameW, frameH); err != nil {
+		return nil, err
+	}
+
+	return newHistory(nil, make([]byte, HistorySize(capacity, frameW, frameH)), capacity, frameW, frameH), nil
+}
+
+// Create (or truncate) a history file at the given path and map it into
+// memory. Memory-mapped histories are only supported on platforms that support
+// memory-mapped segments.
+func CreateHistory(path string, capacity int, frameW, frameH uint32) (*History, error) {
+	if err := validateHistory(capacity, frameW, frameH); err != nil {
+		return nil, err
+	}
+
+	f, data, err := mapFile(path, HistorySize(capacity, frameW, frameH))
+	if err != nil {
+		return nil, err
+	}
+
+	return newHistory(f, data, capacity, frameW, frameH), nil
+}
+
+func validateHistory(capacity int, frameW, frameH uint32) error {
+	if capacity <= 0 {
+		return ErrInvalidCapacity
+	}
+	if frameW == 0 || frameH == 0 {
+		return ErrInvalidDimensions
+	}
+	return nil
+}
+
+func newHistory(f *os.File, data []byte, capacity int, frameW, frameH uint32) *History {
+	// Write header
+	copy(data[0:4], HistoryMagic)
+	byteOrder().PutUint32(data[4:8], HistoryVersion)
+	byteOrder().PutUint32(data[8:12], frameW)
+	byteOrder().PutUint32(data[12:16], frameH)
+	byteOrder().PutUint32(data[16:20], uint32(capacity))
+
+	return &History{
+		file:     f,
+		data:     data,
+		frameW:   frameW,
+		frameH:   frameH,
+		capacity: capacity,
+		cursor:   -1,
+	}
+}
+
+// Release the history buffers. If the history is backed by a file, the file
+// is unmapped and closed but not removed.
+func (h *History) Close() error {
+	if h.data == nil {
+		return nil
+	}
+
+	var err error
+	if h.file != nil {
+		err = unmapFile(h.file, h.data)
+	}
+	h.data = nil
+	return err
+}
+
+// Get the frame width.
+func (h *History) FrameW() uint32 {
+	return h.frameW
+}
+
+// Get the frame height.
+func (h *History) FrameH() uint32 {
+	return h.frameH
+}
+
+// Get the max number of frames that can be stored in the history.
+func (h *History) Capacity() int {
+	return h.capacity
+}
+
+// Get the number of stored frames.
+func (h *History) Len() int {
+	return h.count
+}
+
+// Append a copy of a frame with tightly packed RGBA8 pixels to the history
+// evicting the oldest frame if the history is full. Pushing a frame also
+// returns the history to the live frame.
+func (h *History) Push(frame []byte) error {
+	if h.data == nil {
+		return ErrHistoryClosed
+	}
+	if len(frame) != int(h.frameW*h.frameH*4) {
+		return ErrDimensionMismatch
+	}
+
+	copy(h.slot(h.head), frame)
+	h.head = (h.head + 1) % h.capacity
+	if h.count < h.capacity {
+		h.count++
+	}
+	h.cursor = -1
+
+	byteOrder().PutUint32(h.data[historyCountOffset:], uint32(h.count))
+	byteOrder().PutUint32(h.data[historyHeadOffset:], uint32(h.head))
+	return nil
+}
+
+// Append a copy of an image to the history. The image dimensions must match
+// the history frame dimensions.
+func (h *History) PushImage(img *image.RGBA) error {
+	if h.data == nil {
+		return ErrHistoryClosed
+	}
+	w, ht := img.Rect.Dx(), img.Rect.Dy()
+	if w != int(h.frameW) || ht != int(h.frameH) {
+		return ErrDimensionMismatch
+	}
+
+	rowLen := w * 4
+	if img.Stride == rowLen {
+		return h.Push(img.Pix[:rowLen*ht])
+	}
+
+	frame := make([]byte, rowLen*ht)
+	for y := 0; y < ht; y++ {
+		copy(frame[y*rowLen:(y+1)*rowLen], img.Pix[y*img.Stride:])
+	}
+	return h.Push(frame)
+}
+
+// Get the frame at the given offset from the newest frame (0 = newest). The
+// returned slice references the history storage and is only valid until the
+// next call to Push.
+func (h *History) Frame(offset int) ([]byte, error) {
+	if h.data == nil {
+		return nil, ErrHistoryClosed
+	}
+	if offset < 0 || offset >= h.count {
+		return nil, fmt.Errorf("%s: offset %d; history contains %d frames", ErrNoSuchFrame.Error(), offset, h.count)
+	}
+
+	return h.slot((h.head - 1 - offset + h.capacity) % h.capacity), nil
+}
+
+// Step back to the previous frame in the history. Returns the frame to be
+// displayed or false if there are no older frames.
+func (h *History) Back() ([]byte, bool) {
+	if h.cursor+1 >= h.count {
+		return nil, false
+	}
+
+	h.cursor++
+	frame, err := h.Frame(h.cursor)
+	return frame, err == nil
+}
+
+// Step forward to the next frame in the history. Returns the frame to be
+// displayed or false if the history returned to the live frame.
+func (h *History) Forward() ([]byte, bool) {
+	if h.cursor <= 0 {
+		h.cursor = -1
+		return nil, false
+	}
+
+	h.cursor--
+	frame, err := h.Frame(h.cursor)
+	return frame, err == nil
+}
+
+// Get the offset of the reviewed frame from the newest frame or -1 if the live
+// frame is displayed.
+func (h *History) Cursor() int {
+	return h.cursor
+}
+
+// Check whether a history frame is being reviewed.
+func (h *History) Reviewing() bool {
+	return h.cursor >= 0
+}
+
+// Return to the live frame.
+func (h *History) Reset() {
+	h.cursor = -1
+}
+
+// Get the pixels for a frame slot.
+func (h *History) slot(index int) []byte {
+	frameSize := int(h.frameW * h.frameH * 4)
+	offset := HistoryHeaderSize + index*frameSize
+	return h.data[offset : offset+frameSize]
+}
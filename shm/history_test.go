@@ -0,0 +1,185 @@
+package shm
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryRing(t *testing.T) {
+	h, err := NewHistory(3, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for i := byte(1); i <= 4; i++ {
+		err = h.Push([]byte{i, i, i, 255})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if h.Len() != 3 {
+		t.Fatalf("expected history to contain 3 frames; got %d", h.Len())
+	}
+
+	// The oldest frame should have been evicted
+	for offset, exp := range []byte{4, 3, 2} {
+		frame, err := h.Frame(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame[0] != exp {
+			t.Errorf("expected frame at offset %d to be %d; got %d", offset, exp, frame[0])
+		}
+	}
+
+	_, err = h.Frame(3)
+	if err == nil {
+		t.Fatal("expected to get an error when requesting an evicted frame")
+	}
+
+	err = h.Push([]byte{1, 2})
+	if err != ErrDimensionMismatch {
+		t.Fatalf("expected to get ErrDimensionMismatch; got %v", err)
+	}
+}
+
+func TestHistoryStepping(t *testing.T) {
+	h, err := NewHistory(4, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if _, ok := h.Back(); ok {
+		t.Fatal("expected Back() to fail for an empty history")
+	}
+
+	for i := byte(1); i <= 2; i++ {
+		h.Push([]byte{i, i, i, 255})
+	}
+
+	specs := []struct {
+		back   bool
+		ok     bool
+		exp    byte
+		cursor int
+	}{
+		{true, true, 2, 0},
+		{true, true, 1, 1},
+		{true, false, 0, 1},
+		{false, true, 2, 0},
+		{false, false, 0, -1},
+		{false, false, 0, -1},
+	}
+
+	for specIndex, spec := range specs {
+		var frame []byte
+		var ok bool
+		if spec.back {
+			frame, ok = h.Back()
+		} else {
+			frame, ok = h.Forward()
+		}
+
+		if ok != spec.ok {
+			t.Errorf("[spec %d] expected ok to be %t; got %t", specIndex, spec.ok, ok)
+			continue
+		}
+		if ok && frame[0] != spec.exp {
+			t.Errorf("[spec %d] expected frame %d; got %d", specIndex, spec.exp, frame[0])
+		}
+		if h.Cursor() != spec.cursor {
+			t.Errorf("[spec %d] expected cursor to be %d; got %d", specIndex, spec.cursor, h.Cursor())
+		}
+	}
+
+	// Pushing a frame should return to the live frame
+	h.Back()
+	h.Push([]byte{3, 3, 3, 255})
+	if h.Reviewing() {
+		t.Fatal("expected Push() to return to the live frame")
+	}
+}
+
+func TestHistoryPushImage(t *testing.T) {
+	h, err := NewHistory(1, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// Use a sub-image so that the stride differs from the row length
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2)).SubImage(image.Rect(1, 0, 3, 2)).(*image.RGBA)
+	img.Pix[0] = 10
+	img.Pix[img.Stride] = 20
+
+	err = h.PushImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame, _ := h.Frame(0)
+	if frame[0] != 10 || frame[8] != 20 {
+		t.Fatalf("expected rows to be tightly packed; got %v", frame)
+	}
+
+	err = h.PushImage(image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	if err != ErrDimensionMismatch {
+		t.Fatalf("expected to get ErrDimensionMismatch; got %v", err)
+	}
+}
+
+func TestMappedHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-shm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	histFile := filepath.Join(dir, "history")
+	h, err := CreateHistory(histFile, 2, 1, 1)
+	if err == ErrUnsupported {
+		t.Skip("memory-mapped histories are not supported on this platform")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := byte(1); i <= 3; i++ {
+		err = h.Push([]byte{i, i, i, 255})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = h.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(histFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != HistorySize(2, 1, 1) {
+		t.Fatalf("expected history file size to be %d; got %d", HistorySize(2, 1, 1), len(data))
+	}
+	if string(data[0:4]) != HistoryMagic {
+		t.Fatalf("expected magic %q; got %q", HistoryMagic, data[0:4])
+	}
+	if count := byteOrder().Uint32(data[historyCountOffset:]); count != 2 {
+		t.Fatalf("expected header frame count to be 2; got %d", count)
+	}
+	if head := byteOrder().Uint32(data[historyHeadOffset:]); head != 1 {
+		t.Fatalf("expected header head index to be 1; got %d", head)
+	}
+
+	// Slot 0 should contain the newest frame and slot 1 the previous one
+	if data[HistoryHeaderSize] != 3 || data[HistoryHeaderSize+4] != 2 {
+		t.Fatalf("unexpected frame slot contents: %v", data[HistoryHeaderSize:])
+	}
+}
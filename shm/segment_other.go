@@ -0,0 +1,43 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package shm
+
+import "os"
+
+// A memory-mapped frame segment.
+type Segment struct {
+	path string
+	data []byte
+
+	frameW uint32
+	frameH uint32
+
+	writing bool
+}
+
+// Create a segment file. Memory-mapped segments are not supported on this platform.
+func Create(path string, frameW, frameH uint32) (*Segment, error) {
+	return nil, ErrUnsupported
+}
+
+// Close the segment.
+func (s *Segment) Close() error {
+	return nil
+}
+
+func (s *Segment) bumpSequence() {
+}
+
+func (s *Segment) loadSequence() uint64 {
+	return 0
+}
+
+// Memory-mapped files are not supported on this platform.
+func mapFile(path string, size int) (*os.File, []byte, error) {
+	return nil, nil, ErrUnsupported
+}
+
+func unmapFile(f *os.File, data []byte) error {
+	return nil
+}
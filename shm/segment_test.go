@@ -0,0 +1,70 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package shm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSegmentPublish(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-shm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	segFile := filepath.Join(dir, "frame")
+	seg, err := Create(segFile, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frame := []byte{1, 2, 3, 255, 4, 5, 6, 255}
+	err = seg.Publish(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seq := seg.Sequence(); seq != 2 {
+		t.Fatalf("expected sequence counter to be 2; got %d", seq)
+	}
+
+	err = seg.Publish(frame[:4])
+	if err != ErrDimensionMismatch {
+		t.Fatalf("expected to get ErrDimensionMismatch; got %v", err)
+	}
+
+	err = seg.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify file contents
+	data, err := ioutil.ReadFile(segFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != Size(2, 1) {
+		t.Fatalf("expected segment size to be %d; got %d", Size(2, 1), len(data))
+	}
+	if string(data[0:4]) != Magic {
+		t.Fatalf("expected segment to start with magic %q; got %q", Magic, string(data[0:4]))
+	}
+	order := byteOrder()
+	if w, h := order.Uint32(data[8:12]), order.Uint32(data[12:16]); w != 2 || h != 1 {
+		t.Fatalf("expected frame dims to be 2x1; got %dx%d", w, h)
+	}
+	if seq := order.Uint64(data[sequenceOffset:]); seq != 2 {
+		t.Fatalf("expected stored sequence counter to be 2; got %d", seq)
+	}
+	for index, v := range frame {
+		if data[HeaderSize+index] != v {
+			t.Fatalf("pixel data mismatch at offset %d; expected %d; got %d", index, v, data[HeaderSize+index])
+		}
+	}
+}
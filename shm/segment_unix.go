@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package shm
+
+import (
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// A memory-mapped frame segment.
+type Segment struct {
+	path string
+	file *os.File
+	data []byte
+
+	frameW uint32
+	frameH uint32
+
+	// True while a frame update is in progress.
+	writing bool
+}
+
+// Create (or truncate) a segment file at the given path and map it into memory.
+func Create(path string, frameW, frameH uint32) (*Segment, error) {
+	if frameW == 0 || frameH == 0 {
+		return nil, ErrInvalidDimensions
+	}
+
+	f, data, err := mapFile(path, Size(frameW, frameH))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write header
+	copy(data[0:4], Magic)
+	byteOrder().PutUint32(data[4:8], Version)
+	byteOrder().PutUint32(data[8:12], frameW)
+	byteOrder().PutUint32(data[12:16], frameH)
+	byteOrder().PutUint32(data[16:20], FormatRGBA8)
+
+	return &Segment{
+		path:   path,
+		file:   f,
+		data:   data,
+		frameW: frameW,
+		frameH: frameH,
+	}, nil
+}
+
+// Unmap the segment and close its backing file. The file itself is not removed
+// so that readers can still access the last published frame.
+func (s *Segment) Close() error {
+	if s.data == nil {
+		return nil
+	}
+
+	err := unmapFile(s.file, s.data)
+	s.data = nil
+	return err
+}
+
+// Create (or truncate) a file with the given size and map it into memory.
+func mapFile(path string, size int) (*os.File, []byte, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = f.Truncate(int64(size))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, data, nil
+}
+
+// Unmap a file mapped by mapFile and close it.
+func unmapFile(f *os.File, data []byte) error {
+	err := syscall.Munmap(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *Segment) bumpSequence() {
+	atomic.AddUint64((*uint64)(unsafe.Pointer(&s.data[sequenceOffset])), 1)
+}
+
+func (s *Segment) loadSequence() uint64 {
+	return atomic.LoadUint64((*uint64)(unsafe.Pointer(&s.data[sequenceOffset])))
+}
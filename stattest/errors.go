@@ -0,0 +1,9 @@
+package stattest
+
+import "errors"
+
+var (
+	ErrNotEnoughSamples = errors.New("stattest: not enough samples")
+	ErrBinCountMismatch = errors.New("stattest: observed and expected bin counts do not match")
+	ErrInvalidExpected  = errors.New("stattest: expected frequencies must be non-negative and sum to a positive value")
+)
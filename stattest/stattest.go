@@ -0,0 +1,321 @@
+// Package stattest implements statistical hypothesis tests for verifying that
+// samplers and estimators generate outputs with the expected distribution.
+//
+// The tests are meant to be used by unit tests to catch subtle regressions
+// (e.g. a biased sample warping function or an estimator that converges to the
+// wrong value) that cannot be detected by comparing individual samples.
+package stattest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Bins whose expected frequency is lower than this value are pooled together
+// as the chi-square approximation is inaccurate for small frequencies.
+const minExpectedFrequency = 5.0
+
+// Parameters for evaluating the special functions.
+const (
+	maxIterations = 1000
+	epsilon       = 1e-14
+	tiny          = 1e-300
+)
+
+// The result of a hypothesis test.
+type Result struct {
+	// The value of the test statistic.
+	Statistic float64
+
+	// The degrees of freedom of the statistic distribution.
+	DOF float64
+
+	// The probability of observing a statistic at least as extreme as
+	// Statistic if the null hypothesis holds.
+	PValue float64
+}
+
+// Check whether the null hypothesis should be rejected at the given
+// significance level.
+func (r Result) Reject(significance float64) bool {
+	return r.PValue < significance
+}
+
+// Implements Stringer.
+func (r Result) String() string {
+	return fmt.Sprintf("statistic: %g, dof: %g, p-value: %g", r.Statistic, r.DOF, r.PValue)
+}
+
+// Adjust the significance level for a single test so that the probability of
+// rejecting at least one of numTests independent tests when all null
+// hypotheses hold equals significance (Sidak correction).
+func SidakSignificance(significance float64, numTests int) float64 {
+	if numTests <= 1 {
+		return significance
+	}
+	return 1 - math.Pow(1-significance, 1/float64(numTests))
+}
+
+// Run Pearson's chi-square goodness-of-fit test comparing the observed bin
+// counts with the expected bin frequencies. The expected frequencies are
+// scaled so that they sum to the total observed count, so they may be
+// specified either as counts or as probabilities. Bins with low expected
+// frequencies are pooled together before calculating the statistic.
+func ChiSquare(observed []int, expected []float64) (Result, error) {
+	if len(observed) != len(expected) {
+		return Result{}, fmt.Errorf("%s: %d observed and %d expected bins", ErrBinCountMismatch.Error(), len(observed), len(expected))
+	}
+
+	var numSamples int
+	var expectedSum float64
+	for index, count := range observed {
+		if expected[index] < 0 {
+			return Result{}, ErrInvalidExpected
+		}
+		numSamples += count
+		expectedSum += expected[index]
+	}
+	if expectedSum <= 0 {
+		return Result{}, ErrInvalidExpected
+	}
+	if numSamples == 0 {
+		return Result{}, ErrNotEnoughSamples
+	}
+
+	// Visit bins in order of increasing expected frequency and pool
+	// adjacent bins until the pooled frequency is large enough.
+	order := make([]int, len(observed))
+	for index := range order {
+		order[index] = index
+	}
+	sort.SliceStable(order, func(i, j int) bool { return expected[order[i]] < expected[order[j]] })
+
+	scale := float64(numSamples) / expectedSum
+	var stat, pooledExp, pooledObs float64
+	var numBins int
+	for _, index := range order {
+		exp, obs := expected[index]*scale, float64(observed[index])
+		if exp == 0 {
+			if obs > 0 {
+				// Samples in bins that should never be selected
+				// indicate a broken sampler.
+				return Result{Statistic: math.Inf(1), PValue: 0}, nil
+			}
+			continue
+		}
+
+		pooledExp += exp
+		pooledObs += obs
+		if pooledExp < minExpectedFrequency {
+			continue
+		}
+
+		stat += (pooledObs - pooledExp) * (pooledObs - pooledExp) / pooledExp
+		pooledExp, pooledObs = 0, 0
+		numBins++
+	}
+
+	// As bins are visited in increasing frequency order, any remaining
+	// pooled frequencies only exist if all bins have low frequencies.
+	if pooledExp > 0 {
+		stat += (pooledObs - pooledExp) * (pooledObs - pooledExp) / pooledExp
+		numBins++
+	}
+
+	if numBins < 2 {
+		return Result{}, fmt.Errorf("%s: need at least 2 bins with an expected frequency >= %g", ErrNotEnoughSamples.Error(), minExpectedFrequency)
+	}
+
+	dof := float64(numBins - 1)
+	return Result{
+		Statistic: stat,
+		DOF:       dof,
+		PValue:    ChiSquareSurvival(stat, dof),
+	}, nil
+}
+
+// Run a chi-square test checking whether the observed bin counts are
+// uniformly distributed.
+func ChiSquareUniform(observed []int) (Result, error) {
+	expected := make([]float64, len(observed))
+	for index := range expected {
+		expected[index] = 1
+	}
+	return ChiSquare(observed, expected)
+}
+
+// Run a two-sided one-sample Student's t-test checking whether the mean of the
+// samples equals the specified mean. The samples are assumed to be independent
+// estimates (e.g. the same pixel rendered using different seeds) drawn from an
+// approximately normal distribution.
+func TTest(samples []float64, mean float64) (Result, error) {
+	if len(samples) < 2 {
+		return Result{}, ErrNotEnoughSamples
+	}
+
+	n := float64(len(samples))
+	var sum float64
+	for _, s := range samples {
+		sum += s
+	}
+	sampleMean := sum / n
+
+	var sqSum float64
+	for _, s := range samples {
+		sqSum += (s - sampleMean) * (s - sampleMean)
+	}
+	stdErr := math.Sqrt(sqSum / (n - 1) / n)
+
+	dof := n - 1
+	if stdErr == 0 {
+		// All samples are identical; the test degenerates to an
+		// exact comparison.
+		if sampleMean == mean {
+			return Result{Statistic: 0, DOF: dof, PValue: 1}, nil
+		}
+		return Result{Statistic: math.Inf(1), DOF: dof, PValue: 0}, nil
+	}
+
+	t := (sampleMean - mean) / stdErr
+	return Result{
+		Statistic: t,
+		DOF:       dof,
+		PValue:    StudentTTwoSided(t, dof),
+	}, nil
+}
+
+// Calculate the probability that a chi-square distributed variable with dof
+// degrees of freedom is greater than x.
+func ChiSquareSurvival(x, dof float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	return 1 - regularizedGammaP(dof/2, x/2)
+}
+
+// Calculate the probability that the absolute value of a Student's t
+// distributed variable with dof degrees of freedom is greater than |t|.
+func StudentTTwoSided(t, dof float64) float64 {
+	if math.IsInf(t, 0) {
+		return 0
+	}
+	return regularizedBeta(dof/(dof+t*t), dof/2, 0.5)
+}
+
+// Evaluate the regularized lower incomplete gamma function P(a, x).
+func regularizedGammaP(a, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+
+	lgammaA, _ := math.Lgamma(a)
+	logPrefix := a*math.Log(x) - x - lgammaA
+
+	// Use the series expansion for small x and the continued fraction
+	// expansion of Q(a, x) for large x.
+	if x < a+1 {
+		term := 1 / a
+		sum := term
+		for n := 1; n < maxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return math.Min(1, sum*math.Exp(logPrefix))
+	}
+
+	// Modified Lentz's method
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIterations; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return math.Max(0, 1-math.Exp(logPrefix)*h)
+}
+
+// Evaluate the regularized incomplete beta function I_x(a, b).
+func regularizedBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	} else if x >= 1 {
+		return 1
+	}
+
+	lgammaAB, _ := math.Lgamma(a + b)
+	lgammaA, _ := math.Lgamma(a)
+	lgammaB, _ := math.Lgamma(b)
+	prefix := math.Exp(lgammaAB - lgammaA - lgammaB + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges rapidly for x < (a+1)/(a+b+2);
+	// use the symmetry relation for larger values.
+	if x < (a+1)/(a+b+2) {
+		return prefix * betaContinuedFraction(x, a, b) / a
+	}
+	return 1 - prefix*betaContinuedFraction(1-x, b, a)/b
+}
+
+// Evaluate the continued fraction expansion of the incomplete beta function
+// using the modified Lentz's method.
+func betaContinuedFraction(x, a, b float64) float64 {
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m < maxIterations; m++ {
+		fm := float64(m)
+
+		// Even step
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+
+		// Odd step
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + num*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + num/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
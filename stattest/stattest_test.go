@@ -0,0 +1,133 @@
+package stattest
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestChiSquareSurvival(t *testing.T) {
+	specs := []struct {
+		x, dof float64
+		exp    float64
+	}{
+		{3.841459, 1, 0.05},
+		{18.307038, 10, 0.05},
+		{2.705543, 1, 0.1},
+		{124.342113, 100, 0.05},
+		{0, 5, 1},
+	}
+
+	for index, spec := range specs {
+		if p := ChiSquareSurvival(spec.x, spec.dof); math.Abs(p-spec.exp) > 1e-5 {
+			t.Errorf("[spec %d] expected survival(%g, %g) to be %g; got %g", index, spec.x, spec.dof, spec.exp, p)
+		}
+	}
+}
+
+func TestStudentTTwoSided(t *testing.T) {
+	specs := []struct {
+		t, dof float64
+		exp    float64
+	}{
+		{2.228139, 10, 0.05},
+		{-2.228139, 10, 0.05},
+		{12.706205, 1, 0.05},
+		{1.959964, 1e6, 0.05},
+		{0, 3, 1},
+	}
+
+	for index, spec := range specs {
+		if p := StudentTTwoSided(spec.t, spec.dof); math.Abs(p-spec.exp) > 1e-5 {
+			t.Errorf("[spec %d] expected p-value(%g, %g) to be %g; got %g", index, spec.t, spec.dof, spec.exp, p)
+		}
+	}
+}
+
+func TestChiSquare(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	observed := make([]int, 32)
+	for i := 0; i < 100000; i++ {
+		observed[rng.Intn(len(observed))]++
+	}
+
+	res, err := ChiSquareUniform(observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DOF != 31 || res.Reject(0.001) {
+		t.Fatalf("expected uniform samples to pass the test; got %s", res)
+	}
+
+	// Skew the distribution by 2%
+	for i := 0; i < 2000; i++ {
+		observed[0]++
+	}
+	if res, _ = ChiSquareUniform(observed); !res.Reject(0.001) {
+		t.Fatalf("expected skewed samples to fail the test; got %s", res)
+	}
+
+	// Samples in bins with zero probability always fail the test
+	res, err = ChiSquare([]int{10, 10, 1}, []float64{0.5, 0.5, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Reject(0.001) {
+		t.Fatalf("expected samples in zero-probability bins to fail the test; got %s", res)
+	}
+
+	// Low frequency bins should be pooled
+	res, err = ChiSquare([]int{1, 0, 2, 1, 1, 50, 45}, []float64{1, 1, 1, 1, 1, 50, 45})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DOF != 2 {
+		t.Fatalf("expected low frequency bins to be pooled into a single bin; got %s", res)
+	}
+
+	if _, err = ChiSquare([]int{1, 2}, []float64{1}); err == nil {
+		t.Fatal("expected to get an error for mismatched bin counts")
+	}
+	if _, err = ChiSquareUniform([]int{0, 0}); err != ErrNotEnoughSamples {
+		t.Fatalf("expected to get ErrNotEnoughSamples; got %v", err)
+	}
+}
+
+func TestTTest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, 200)
+	for index := range samples {
+		samples[index] = 0.5 + 0.1*rng.NormFloat64()
+	}
+
+	res, err := TTest(samples, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.DOF != 199 || res.Reject(0.001) {
+		t.Fatalf("expected unbiased samples to pass the test; got %s", res)
+	}
+
+	if res, _ = TTest(samples, 0.55); !res.Reject(0.001) {
+		t.Fatalf("expected biased samples to fail the test; got %s", res)
+	}
+
+	if res, _ = TTest([]float64{1, 1, 1}, 1); res.PValue != 1 {
+		t.Fatalf("expected identical samples matching the mean to pass the test; got %s", res)
+	}
+
+	if _, err = TTest(samples[:1], 0.5); err != ErrNotEnoughSamples {
+		t.Fatalf("expected to get ErrNotEnoughSamples; got %v", err)
+	}
+}
+
+func TestSidakSignificance(t *testing.T) {
+	if s := SidakSignificance(0.01, 1); s != 0.01 {
+		t.Fatalf("expected significance for a single test to be unchanged; got %g", s)
+	}
+
+	s := SidakSignificance(0.01, 10)
+	if total := 1 - math.Pow(1-s, 10); math.Abs(total-0.01) > 1e-12 {
+		t.Fatalf("expected combined significance to be 0.01; got %g", total)
+	}
+}
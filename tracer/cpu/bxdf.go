@@ -0,0 +1,329 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The bxdf evaluators mirror the ones implemented by the opencl kernels (see
+// CL/bxdf). All ray directions point away from the surface.
+
+// Sample the bxdf and generate a bounce ray with a pdf that approximates the
+// bxdf.
+func (sd *sceneData) bxdfSample(s *surface, b *bxdf, sample types.Vec2, inRayDir types.Vec3) (value, outRayDir types.Vec3, pdf float32) {
+	switch b.kind {
+	case material.BxdfDiffuse:
+		outRayDir = cosWeightedHemisphereSample(s.normal, sample)
+		kd := sd.sample3f(s.uv, s.texLod, b.color, b.colorTex)
+		return kd.Mul(1 / math.Pi), outRayDir, s.normal.Dot(outRayDir) / math.Pi
+	case material.BxdfConductor:
+		iDotN := inRayDir.Dot(s.normal)
+		outRayDir = s.normal.Mul(2 * iDotN).Sub(inRayDir)
+		return sd.conductorValue(s, b, iDotN), outRayDir, 1
+	case material.BxdfDielectric:
+		return sd.dielectricSample(s, b, sample, inRayDir)
+	case material.BxdfRoughtConductor:
+		roughness := sd.roughness(s, b)
+		h := ggxSample(roughness, s.normal, sample)
+		outRayDir = h.Mul(2 * inRayDir.Dot(h)).Sub(inRayDir)
+		pdf = ggxReflectionPdf(roughness, outRayDir, s.normal, h)
+		return sd.roughConductorEval(s, b, inRayDir, outRayDir), outRayDir, pdf
+	case material.BxdfRoughDielectric:
+		return sd.roughDielectricSample(s, b, sample, inRayDir)
+	case material.BxdfPrincipled:
+		return sd.principledSample(s, b, sample, inRayDir)
+	case material.BxdfSubsurface:
+		return sd.subsurfaceSample(s, b, sample, inRayDir)
+	}
+
+	return types.Vec3{}, types.Vec3{}, 0
+}
+
+// Get the pdf for generating outRayDir when sampling the bxdf.
+func (sd *sceneData) bxdfPdf(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) float32 {
+	switch b.kind {
+	case material.BxdfDiffuse:
+		return s.normal.Dot(outRayDir) / math.Pi
+	case material.BxdfConductor:
+		if matchesReflection(s, inRayDir, outRayDir) {
+			return 1
+		}
+	case material.BxdfRoughtConductor:
+		return ggxReflectionPdf(sd.roughness(s, b), outRayDir, s.normal, inRayDir.Add(outRayDir).Normalize())
+	case material.BxdfRoughDielectric:
+		return sd.roughDielectricPdf(s, b, inRayDir, outRayDir)
+	case material.BxdfPrincipled:
+		p := sd.newPrincipledParams(s, b, inRayDir)
+		return p.pdf(inRayDir, outRayDir)
+	case material.BxdfSubsurface:
+		return sd.subsurfacePdf(s, b, inRayDir, outRayDir)
+	}
+
+	// The pdf of ideal dielectrics is always 0
+	return 0
+}
+
+// Evaluate the bxdf for a pair of in and out rays.
+func (sd *sceneData) bxdfEval(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	switch b.kind {
+	case material.BxdfDiffuse:
+		return sd.sample3f(s.uv, s.texLod, b.color, b.colorTex).Mul(1 / math.Pi)
+	case material.BxdfConductor:
+		if matchesReflection(s, inRayDir, outRayDir) {
+			return sd.conductorValue(s, b, inRayDir.Dot(s.normal))
+		}
+	case material.BxdfRoughtConductor:
+		return sd.roughConductorEval(s, b, inRayDir, outRayDir)
+	case material.BxdfRoughDielectric:
+		return sd.roughDielectricEval(s, b, inRayDir, outRayDir)
+	case material.BxdfPrincipled:
+		p := sd.newPrincipledParams(s, b, inRayDir)
+		return p.eval(inRayDir, outRayDir)
+	case material.BxdfSubsurface:
+		return sd.subsurfaceEval(s, b, inRayDir, outRayDir)
+	}
+
+	// Ideal dielectrics always evaluate to 0
+	return types.Vec3{}
+}
+
+// Check whether outRayDir matches the mirror reflection of inRayDir using
+// the same error margin as the conductor kernels.
+func matchesReflection(s *surface, inRayDir, outRayDir types.Vec3) bool {
+	expOutDir := s.normal.Mul(2 * inRayDir.Dot(s.normal)).Sub(inRayDir)
+	expDot := expOutDir.Dot(outRayDir)
+	return expDot >= 0 && expDot <= 0.001
+}
+
+// Get the value of an ideal conductor for the mirror reflection of the
+// incoming ray.
+//
+// BXDF = ks * fresnel / cosI
+func (sd *sceneData) conductorValue(s *surface, b *bxdf, iDotN float32) types.Vec3 {
+	if iDotN == 0 {
+		return types.Vec3{}
+	}
+
+	// Calculate fresnel unless no IOR is specified
+	var f float32 = 1
+	if b.intIOR != 0 {
+		f = fresnelForDielectric(b.extIOR, b.intIOR, iDotN)
+	}
+	ks := sd.sample3f(s.uv, s.texLod, b.color, b.colorTex)
+	return ks.Mul(f / iDotN)
+}
+
+// Sample an ideal dielectric. Based on the fresnel value, either the
+// reflected or the refracted ray is selected.
+//
+// BXDF = 1 / cos(theta)
+func (sd *sceneData) dielectricSample(s *surface, b *bxdf, sample types.Vec2, inRayDir types.Vec3) (value, outRayDir types.Vec3, pdf float32) {
+	iDotN := inRayDir.Dot(s.normal)
+	etaI, etaT := b.extIOR, b.intIOR
+
+	// If hitting from the inside we need to swap the eta
+	if iDotN < 0 {
+		etaI, etaT = etaT, etaI
+	}
+	eta := etaI / etaT
+
+	f := fresnelForDielectric(etaI, etaT, iDotN)
+	cosTSq := 1 + eta*(iDotN*iDotN-1)
+
+	// Total internal reflection always selects the reflected ray
+	var kVal types.Vec3
+	if cosTSq <= 0 || sample[0] <= f {
+		outRayDir = s.normal.Mul(-signf(iDotN) * 2 * iDotN).Sub(inRayDir)
+		kVal = sd.sample3f(s.uv, s.texLod, b.color, b.colorTex)
+		pdf = f
+		if cosTSq <= 0 {
+			pdf = 1
+		}
+	} else {
+		outRayDir = s.normal.Mul(eta*iDotN - signf(iDotN)*sqrtf(cosTSq)).Sub(inRayDir.Mul(eta))
+		kVal = sd.sample3f(s.uv, s.texLod, b.transmittance, b.transmittanceTex).Mul(eta * eta)
+		pdf = 1 - f
+	}
+
+	if iDotN == 0 {
+		return types.Vec3{}, outRayDir, pdf
+	}
+	return kVal.Mul(pdf / absf(iDotN)), outRayDir, pdf
+}
+
+// Get the GGX alpha value for a rough surface using Disney's remapping:
+// a = roughness^2
+func (sd *sceneData) roughness(s *surface, b *bxdf) float32 {
+	roughness := clampf(sd.sample1f(s.uv, s.texLod, b.roughness, b.roughnessTex), minRoughness, 1)
+	return roughness * roughness
+}
+
+// Evaluate a rough conductor.
+func (sd *sceneData) roughConductorEval(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	roughness := sd.roughness(s, b)
+	iDotN := inRayDir.Dot(s.normal)
+	oDotN := outRayDir.Dot(s.normal)
+
+	// Calculate fresnel unless no IOR is specified
+	var f float32 = 1
+	if b.intIOR != 0 {
+		f = fresnelForDielectric(b.extIOR, b.intIOR, iDotN)
+	}
+
+	h := inRayDir.Add(outRayDir).Normalize()
+	d := ggxD(roughness, s.normal, h)
+	g := ggxG(roughness, inRayDir, outRayDir, s.normal, h)
+
+	denom := 4 * iDotN * oDotN
+	if denom <= 0 {
+		return types.Vec3{}
+	}
+	ks := sd.sample3f(s.uv, s.texLod, b.color, b.colorTex)
+	return ks.Mul(f * d * g / denom)
+}
+
+// The min probability for selecting either the reflection or the refraction
+// lobe of a rough dielectric (see CL/bxdf/rough_dielectric.cl).
+const roughDielectricMinLobePdf float32 = 0.05
+
+// Get the GGX alpha value of a rough dielectric, the surface normal flipped so
+// it faces the incoming ray and the IORs on the incoming and transmitted side.
+func (sd *sceneData) roughDielectricInit(s *surface, b *bxdf, inRayDir types.Vec3) (roughness float32, normal types.Vec3, etaI, etaT float32) {
+	roughness = sd.roughness(s, b)
+	normal, etaI, etaT = s.normal, b.extIOR, b.intIOR
+
+	// If hitting from the inside we need to flip the normal and swap the eta
+	if inRayDir.Dot(s.normal) < 0 {
+		normal, etaI, etaT = s.normal.Mul(-1), b.intIOR, b.extIOR
+	}
+	return roughness, normal, etaI, etaT
+}
+
+// Sample the reflection or the refraction lobe of a rough dielectric. The
+// returned pdf includes the probability for selecting the lobe.
+func (sd *sceneData) roughDielectricSample(s *surface, b *bxdf, sample types.Vec2, inRayDir types.Vec3) (value, outRayDir types.Vec3, pdf float32) {
+	roughness, normal, etaI, etaT := sd.roughDielectricInit(s, b, inRayDir)
+
+	// Select a lobe using sample[0] and remap it back to [0, 1) so it can
+	// be reused for sampling the GGX distribution.
+	reflectionPdf := roughDielectricReflectionPdf(etaI, etaT, inRayDir.Dot(normal))
+	sampleReflection := sample[0] < reflectionPdf
+	if sampleReflection {
+		sample[0] /= reflectionPdf
+	} else {
+		const oneMinusEps = 1 - 1.1920929e-7
+		sample[0] = clampf((sample[0]-reflectionPdf)/(1-reflectionPdf), 0, oneMinusEps)
+	}
+
+	h := ggxSample(roughness, normal, sample)
+	iDotH := inRayDir.Dot(h)
+
+	if sampleReflection {
+		// Reflect I over h to get O; rays reflected below the surface
+		// are rejected.
+		outRayDir = h.Mul(2 * iDotH).Sub(inRayDir)
+		if outRayDir.Dot(normal) <= 0 {
+			return types.Vec3{}, outRayDir, 0
+		}
+	} else {
+		// Refract I over h to get O; rays undergoing total internal
+		// reflection are rejected.
+		eta := etaI / etaT
+		cosTSq := 1 + eta*eta*(iDotH*iDotH-1)
+		if iDotH <= 0 || cosTSq <= 0 {
+			return types.Vec3{}, outRayDir, 0
+		}
+		outRayDir = h.Mul(eta*iDotH - sqrtf(cosTSq)).Sub(inRayDir.Mul(eta))
+	}
+
+	return sd.roughDielectricEval(s, b, inRayDir, outRayDir), outRayDir, sd.roughDielectricPdf(s, b, inRayDir, outRayDir)
+}
+
+// Get the pdf for sampling outRayDir from a rough dielectric.
+func (sd *sceneData) roughDielectricPdf(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) float32 {
+	roughness, normal, etaI, etaT := sd.roughDielectricInit(s, b, inRayDir)
+	reflectionPdf := roughDielectricReflectionPdf(etaI, etaT, inRayDir.Dot(normal))
+
+	// This is a reflected ray
+	if outRayDir.Dot(normal) > 0 {
+		return reflectionPdf * ggxReflectionPdf(roughness, outRayDir, normal, inRayDir.Add(outRayDir).Normalize())
+	}
+
+	h := refractionHalfVector(etaI, etaT, inRayDir, outRayDir, normal)
+	return (1 - reflectionPdf) * ggxRefractionPdf(roughness, etaI, etaT, inRayDir, outRayDir, normal, h)
+}
+
+// Evaluate a rough dielectric.
+func (sd *sceneData) roughDielectricEval(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	roughness, normal, etaI, etaT := sd.roughDielectricInit(s, b, inRayDir)
+	iDotN := inRayDir.Dot(normal)
+	oDotN := outRayDir.Dot(normal)
+	if iDotN <= 0 {
+		return types.Vec3{}
+	}
+
+	// This is a reflected ray (equation 20)
+	if oDotN > 0 {
+		h := inRayDir.Add(outRayDir).Normalize()
+		f := roughDielectricFresnel(etaI, etaT, inRayDir.Dot(h))
+		d := ggxD(roughness, normal, h)
+		g := ggxG(roughness, inRayDir, outRayDir, normal, h)
+		ks := sd.sample3f(s.uv, s.texLod, b.color, b.colorTex)
+		return ks.Mul(f * d * g / (4 * iDotN * oDotN))
+	}
+
+	// Evaluate the transmission term (equation 21 of
+	// https://www.cs.cornell.edu/~srm/publications/EGSR07-btdf.pdf)
+	h := refractionHalfVector(etaI, etaT, inRayDir, outRayDir, normal)
+	iDotH := absf(inRayDir.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+	f := roughDielectricFresnel(etaI, etaT, iDotH)
+
+	sum := etaI*iDotH + etaT*oDotH
+	focusTermDenom := iDotN * oDotN * sum * sum
+	if focusTermDenom == 0 {
+		return types.Vec3{}
+	}
+	focusTerm := absf(etaT * etaT * iDotH * oDotH / focusTermDenom)
+
+	d := ggxD(roughness, normal, h)
+	g := ggxG(roughness, inRayDir, outRayDir, normal, h)
+	tf := sd.sample3f(s.uv, s.texLod, b.transmittance, b.transmittanceTex)
+	return tf.Mul((1 - f) * d * g * focusTerm)
+}
+
+// Calculate the fresnel reflectance for a microfacet of a rough dielectric.
+// Microfacets that cause total internal reflection reflect all light.
+func roughDielectricFresnel(etaI, etaT, iDotH float32) float32 {
+	eta := etaI / etaT
+	if 1+eta*eta*(iDotH*iDotH-1) <= 0 {
+		return 1
+	}
+	return fresnelForDielectric(etaI, etaT, iDotH)
+}
+
+// Get the probability for sampling the reflection lobe of a rough dielectric.
+func roughDielectricReflectionPdf(etaI, etaT, iDotN float32) float32 {
+	return clampf(roughDielectricFresnel(etaI, etaT, iDotN), roughDielectricMinLobePdf, 1-roughDielectricMinLobePdf)
+}
+
+// Calculate the halfway transmission vector (equation 16) and flip it so that
+// it points to the same side as the normal.
+func refractionHalfVector(etaI, etaT float32, inRayDir, outRayDir, normal types.Vec3) types.Vec3 {
+	h := inRayDir.Mul(etaI).Add(outRayDir.Mul(etaT)).Mul(-1).Normalize()
+	if h.Dot(normal) < 0 {
+		return h.Mul(-1)
+	}
+	return h
+}
+
+// Calculate fresnel given the eta and cosTheta using Schlick's approximation.
+func fresnelForDielectric(etaI, etaT, iDotN float32) float32 {
+	eta := etaI / etaT
+	r0 := ((1 - eta) * (1 - eta)) / ((1 + eta) * (1 + eta))
+	c := 1 - absf(iDotN)
+	c2 := c * c
+	return r0 + (1-r0)*c2*c2*c
+}
@@ -0,0 +1,143 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The camera attributes used for generating primary rays. A copy is made when
+// the camera is uploaded so that later changes to the scene camera do not
+// affect blocks that are being traced.
+type cameraState struct {
+	position types.Vec3
+	frustrum scene.Frustrum
+
+	// The camera right and up vectors scaled by the aperture radius.
+	lensRight types.Vec3
+	lensUp    types.Vec3
+
+	// The normal of the plane in focus and its distance from the camera
+	// eye. A zero distance disables depth of field.
+	focusNormal   types.Vec3
+	focusDistance float32
+
+	// Aperture shape.
+	apertureBlades   uint32
+	apertureRotation float32
+	bokehSamples     []types.Vec2
+	bokehJitter      float32
+}
+
+// Capture the state of a scene camera.
+func newCameraState(camera *scene.Camera) *cameraState {
+	cs := &cameraState{
+		position: camera.Position,
+		frustrum: camera.Frustrum,
+	}
+
+	if camera.HasDepthOfField() {
+		right, up, _ := camera.Basis()
+		cs.lensRight = right.Mul(camera.ApertureRadius)
+		cs.lensUp = up.Mul(camera.ApertureRadius)
+		cs.focusNormal, cs.focusDistance = camera.FocusPlane()
+		cs.apertureBlades = camera.ApertureBlades
+		cs.apertureRotation = camera.ApertureRotation * math.Pi / 180.0
+		cs.bokehSamples = append([]types.Vec2(nil), camera.BokehSamples...)
+		cs.bokehJitter = camera.BokehJitter
+	}
+
+	return cs
+}
+
+// Get the pinhole ray direction for a point in texture space.
+func (cs *cameraState) rayDir(tx, ty float32) types.Vec3 {
+	left := cs.frustrum[0].Vec3().Mul(1 - ty).Add(cs.frustrum[2].Vec3().Mul(ty))
+	right := cs.frustrum[1].Vec3().Mul(1 - ty).Add(cs.frustrum[3].Vec3().Mul(ty))
+	return left.Mul(1 - tx).Add(right.Mul(tx)).Normalize()
+}
+
+// Generate the primary ray for a frame pixel and return it together with the
+// spread angle of its ray cone. Pixel samples are distributed using a tent
+// filter like the default primary ray generator of the opencl tracer.
+func (cs *cameraState) primaryRay(x, y, frameW, frameH uint32, rng *pathRng) (r ray, coneSpread float32) {
+	sample := rng.sample2f()
+	offset := types.Vec2{tentFilterOffset(sample[0]), tentFilterOffset(sample[1])}
+
+	texelW, texelH := 1/float32(frameW), 1/float32(frameH)
+	tx := (float32(x) + offset[0]) * texelW
+	ty := (float32(y) + offset[1]) * texelH
+	dir := cs.rayDir(tx, ty)
+
+	// Approximate the angle between the rays of vertically adjacent
+	// pixels; it is used as the spread angle of the ray cone for
+	// selecting texture mip levels.
+	coneSpread = cs.rayDir(tx, ty+texelH).Sub(dir).Len()
+
+	origin := cs.position
+	if cs.focusDistance > 0 {
+		// Find where the pinhole ray intersects the focus plane and aim
+		// a ray from a sampled lens position towards it.
+		focusPoint := cs.position.Add(dir.Mul(cs.focusDistance / maxf(dir.Dot(cs.focusNormal), 1e-4)))
+		lensSample := cs.sampleAperture(rng.sample2f())
+		origin = cs.position.Add(cs.lensRight.Mul(lensSample[0])).Add(cs.lensUp.Mul(lensSample[1]))
+		dir = focusPoint.Sub(origin).Normalize()
+	}
+
+	return ray{origin: origin, dir: dir, maxDist: maxFloat}, coneSpread
+}
+
+// Map a uniform sample to a tent-distributed offset in the [-0.5, 1.5] range
+// relative to the top corner of a pixel.
+func tentFilterOffset(s float32) float32 {
+	if s < 0.5 {
+		return sqrtf(2*s) - 0.5
+	}
+	return 1.5 - sqrtf(2-2*s)
+}
+
+// Sample a point on the unit aperture. If bokeh samples are available, one of
+// them is selected and jittered to cover its mask pixel. Otherwise, the point
+// is uniformly sampled inside a regular polygon with apertureBlades sides or,
+// if less than 3 blades are specified, inside the unit disk.
+func (cs *cameraState) sampleAperture(sample types.Vec2) types.Vec2 {
+	if numSamples := uint32(len(cs.bokehSamples)); numSamples > 0 {
+		// Use the fractional part of the scaled sample for jittering
+		scaled := sample[0] * float32(numSamples)
+		index := minUint32(uint32(scaled), numSamples-1)
+		jitterX, jitterY := (scaled-float32(index))*2-1, sample[1]*2-1
+		return types.Vec2{
+			cs.bokehSamples[index][0] + jitterX*cs.bokehJitter,
+			cs.bokehSamples[index][1] + jitterY*cs.bokehJitter,
+		}
+	}
+
+	if cs.apertureBlades >= 3 {
+		// Select a blade triangle and uniformly sample it
+		scaled := sample[0] * float32(cs.apertureBlades)
+		blade := minUint32(uint32(scaled), cs.apertureBlades-1)
+		u := sqrtf(scaled - float32(blade))
+		bladeAngle := 2 * math.Pi / float32(cs.apertureBlades)
+		a0 := cs.apertureRotation + float32(blade)*bladeAngle
+		a1 := a0 + bladeAngle
+		return types.Vec2{
+			u * ((1-sample[1])*cosf(a0) + sample[1]*cosf(a1)),
+			u * ((1-sample[1])*sinf(a0) + sample[1]*sinf(a1)),
+		}
+	}
+
+	// Map the sample to the unit disk using the concentric mapping
+	ox, oy := 2*sample[0]-1, 2*sample[1]-1
+	if ox == 0 && oy == 0 {
+		return types.Vec2{}
+	}
+
+	var r, theta float32
+	if absf(ox) > absf(oy) {
+		r, theta = ox, math.Pi/4*(oy/ox)
+	} else {
+		r, theta = oy, math.Pi/2-math.Pi/4*(ox/oy)
+	}
+	return types.Vec2{r * cosf(theta), r * sinf(theta)}
+}
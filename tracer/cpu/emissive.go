@@ -0,0 +1,172 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Get the radiance of an emissive material node at the given uv coordinates.
+func (sd *sceneData) emissiveRadiance(node *scene.MaterialNode, uv types.Vec2, lod float32) types.Vec3 {
+	return sd.sample3f(uv, lod, node.Union2.Vec3(), node.Union1[3]).Mul(node.Union4[2])
+}
+
+// Generate a ray from the surface towards a random point on an emissive and
+// return the emitted radiance, the ray direction, the solid angle pdf for
+// selecting the ray and the distance to the emissive.
+func (sd *sceneData) emissiveSample(s *surface, e *scene.EmissivePrimitive, sample types.Vec2) (value, outRayDir types.Vec3, pdf, dist float32) {
+	switch e.Type {
+	case scene.AreaLight:
+		return sd.areaLightSample(s, e, sample)
+	case scene.EnvironmentLight:
+		return sd.envLightSample(s, e, sample)
+	}
+	return types.Vec3{}, types.Vec3{}, 0, 0
+}
+
+// Get the solid angle pdf for hitting an emissive with a ray leaving the
+// surface towards outRayDir.
+func (sd *sceneData) emissivePdf(s *surface, e *scene.EmissivePrimitive, outRayDir types.Vec3) float32 {
+	switch e.Type {
+	case scene.AreaLight:
+		return sd.areaLightPdf(s, e, outRayDir)
+	case scene.EnvironmentLight:
+		return sd.envLightPdf(s, outRayDir)
+	}
+	return 0
+}
+
+// Select a random point on an area light with pdf = 1/area.
+func (sd *sceneData) areaLightSample(s *surface, e *scene.EmissivePrimitive, sample types.Vec2) (value, outRayDir types.Vec3, pdf, dist float32) {
+	r1sqrt := sqrtf(sample[0])
+	ru := (1 - sample[1]) * r1sqrt
+	rv := sample[1] * r1sqrt
+	w := 1 - ru - rv
+
+	offset := e.PrimitiveIndex * 3
+	vertices := sd.VertexList[offset : offset+3]
+	normals := sd.NormalList[offset : offset+3]
+	uvs := sd.UvList[offset : offset+3]
+
+	emissivePoint := transformPoint(&e.Transform, vertices[0].Vec3().Mul(w).Add(vertices[1].Vec3().Mul(ru)).Add(vertices[2].Vec3().Mul(rv)))
+
+	// Unlike the kernel version, the normal is transformed as a direction
+	emissiveNormal := transformDir(&e.Transform, normals[0].Vec3().Mul(w).Add(normals[1].Vec3().Mul(ru)).Add(normals[2].Vec3().Mul(rv))).Normalize()
+	emissiveUV := types.Vec2{
+		uvs[0][0]*w + uvs[1][0]*ru + uvs[2][0]*rv,
+		uvs[0][1]*w + uvs[1][1]*ru + uvs[2][1]*rv,
+	}
+
+	emissiveRay := emissivePoint.Sub(s.point)
+	distSq := emissiveRay.Dot(emissiveRay)
+	outRayDir = emissiveRay.Normalize()
+	dist = sqrtf(distSq)
+
+	nDotOutRay := -emissiveNormal.Dot(outRayDir)
+	if nDotOutRay <= 0 || e.Area <= 0 {
+		return types.Vec3{}, outRayDir, 0, dist
+	}
+
+	// Convert from area to solid angle measure: w = cos(theta) / dist^2
+	node := sd.materialNode(int32(e.MaterialNodeIndex))
+	if node == nil {
+		return types.Vec3{}, outRayDir, 0, dist
+	}
+	return sd.emissiveRadiance(node, emissiveUV, texLodTopMip).Mul(nDotOutRay / distSq), outRayDir, 1 / e.Area, dist
+}
+
+// Get the world-space centroid of an area light. Returns false if e is nil or
+// not an area light.
+func (sd *sceneData) areaLightCentroid(e *scene.EmissivePrimitive) (types.Vec3, bool) {
+	if e == nil || e.Type != scene.AreaLight {
+		return types.Vec3{}, false
+	}
+	offset := e.PrimitiveIndex * 3
+	centroid := sd.VertexList[offset].Vec3().Add(sd.VertexList[offset+1].Vec3()).Add(sd.VertexList[offset+2].Vec3()).Mul(1.0 / 3.0)
+	return transformPoint(&e.Transform, centroid), true
+}
+
+// Intersect a ray with an area light and convert the uniform area pdf into
+// the solid angle measure.
+func (sd *sceneData) areaLightPdf(s *surface, e *scene.EmissivePrimitive, outRayDir types.Vec3) float32 {
+	offset := e.PrimitiveIndex * 3
+	v0 := sd.VertexList[offset].Vec3()
+	edge01 := transformDir(&e.Transform, sd.VertexList[offset+1].Vec3().Sub(v0))
+	edge02 := transformDir(&e.Transform, sd.VertexList[offset+2].Vec3().Sub(v0))
+	v0 = transformPoint(&e.Transform, v0)
+
+	pVec := outRayDir.Cross(edge02)
+	det := edge01.Dot(pVec)
+	if absf(det) < intersectionEpsilon {
+		return 0
+	}
+	invDet := 1.0 / det
+
+	tVec := s.point.Sub(v0)
+	u := tVec.Dot(pVec) * invDet
+	if u < 0 || u > 1 {
+		return 0
+	}
+
+	qVec := tVec.Cross(edge01)
+	v := outRayDir.Dot(qVec) * invDet
+	if v < 0 || u+v > 1 {
+		return 0
+	}
+
+	t := edge02.Dot(qVec) * invDet
+	if t < intersectionEpsilon {
+		return 0
+	}
+
+	emissiveNormal := edge01.Cross(edge02).Normalize()
+	denom := e.Area * absf(emissiveNormal.Dot(outRayDir))
+	if denom <= 0 {
+		return 0
+	}
+	return t * t / denom
+}
+
+// Sample the environment light. If the scene env map provides an importance
+// sampling distribution, directions are selected proportionally to the env
+// map luminance. Otherwise, a cosine-weighted direction is generated.
+func (sd *sceneData) envLightSample(s *surface, e *scene.EmissivePrimitive, sample types.Vec2) (value, outRayDir types.Vec3, pdf, dist float32) {
+	var uv types.Vec2
+	if sd.envMap != nil {
+		// Convert the uv pdf into a solid angle pdf: the lat/long
+		// mapping maps the uv area to the sphere with jacobian
+		// 2 * pi^2 * sin(theta)
+		var uvPdf float32
+		uv, uvPdf = sd.envMap.Sample(sample[0], sample[1])
+		outRayDir = latLongUVToRay(uv)
+		if sinTheta := sinf(uv[1] * math.Pi); sinTheta > 0 {
+			pdf = uvPdf / (2 * math.Pi * math.Pi * sinTheta)
+		}
+	} else {
+		outRayDir = cosWeightedHemisphereSample(s.normal, sample)
+		pdf = maxf(0, s.normal.Dot(outRayDir)) / math.Pi
+		uv = rayToLatLongUV(outRayDir)
+	}
+
+	node := sd.materialNode(int32(e.MaterialNodeIndex))
+	if node == nil {
+		return types.Vec3{}, outRayDir, 0, maxFloat
+	}
+	return sd.emissiveRadiance(node, uv, texLodTopMip), outRayDir, pdf, maxFloat
+}
+
+// Get the solid angle pdf for sampling the given direction using
+// envLightSample.
+func (sd *sceneData) envLightPdf(s *surface, outRayDir types.Vec3) float32 {
+	if sd.envMap != nil {
+		uv := rayToLatLongUV(outRayDir)
+		if sinTheta := sinf(uv[1] * math.Pi); sinTheta > 0 {
+			return sd.envMap.Pdf(uv) / (2 * math.Pi * math.Pi * sinTheta)
+		}
+		return 0
+	}
+
+	// We use the same formula as for lambert shading: cos(theta) / PI
+	return maxf(0, s.normal.Dot(outRayDir)/math.Pi)
+}
@@ -0,0 +1,15 @@
+package cpu
+
+import "errors"
+
+var (
+	ErrInvalidOption         = errors.New("cpu tracer: invalid tracer option")
+	ErrUnsupportedChangeType = errors.New("cpu tracer: unsupported change type")
+	ErrInvalidChangeData     = errors.New("cpu tracer: invalid data type for change")
+	ErrNoSceneData           = errors.New("cpu tracer: no scene data uploaded")
+	ErrNoCameraData          = errors.New("cpu tracer: no camera data uploaded")
+	ErrEmptyScene            = errors.New("cpu tracer: scene does not contain any geometry")
+	ErrInvalidBlock          = errors.New("cpu tracer: block request does not fit inside the frame")
+	ErrUnsupportedMerge      = errors.New("cpu tracer: cannot merge output from a different tracer type")
+	ErrBufferTooSmall        = errors.New("cpu tracer: output buffer too small")
+)
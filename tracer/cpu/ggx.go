@@ -0,0 +1,104 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// See https://www.cs.cornell.edu/~srm/publications/EGSR07-btdf.pdf
+// for GGX distribution formulas
+
+// G1(v, m) = 2 / 1 + sqrt( 1 + a^2 * tanv^2 )  (formula 34)
+func ggxG1(roughness float32, v, n, m types.Vec3) float32 {
+	nDotV := n.Dot(v)
+	mDotV := m.Dot(v)
+	if nDotV*mDotV <= 0 {
+		return 0
+	}
+	nDotVSq := nDotV * nDotV
+
+	// Calc tanV^2
+	var tanSq float32
+	if nDotVSq > 0 {
+		tanSq = (1 - nDotVSq) / nDotVSq
+	}
+
+	aSq := roughness * roughness
+	return 2 / (1 + sqrtf(1+aSq*tanSq))
+}
+
+// Use smith approximation for G:
+// G(l, v, h) = G1(l,h) * G1(v,h)
+func ggxG(roughness float32, inRayDir, outRayDir, n, m types.Vec3) float32 {
+	return ggxG1(roughness, inRayDir, n, m) * ggxG1(roughness, outRayDir, n, m)
+}
+
+// D(m) = a^2 / PI * cosT^4 * (a^2 + tanT^2)^2  (formula 33)
+func ggxD(roughness float32, n, m types.Vec3) float32 {
+	nDotM := n.Dot(m)
+	if nDotM <= 0 {
+		return 0
+	}
+	nDotMSq := nDotM * nDotM
+
+	// Calc tanT^2
+	tanSq := (1 - nDotMSq) / nDotMSq
+
+	aSq := roughness * roughness
+	denom := math.Pi * nDotMSq * nDotMSq * (aSq + tanSq) * (aSq + tanSq)
+	if denom <= 0 {
+		return 0
+	}
+	return aSq / denom
+}
+
+// Sample the GGX distribution to generate a microfacet normal.
+func ggxSample(roughness float32, n types.Vec3, sample types.Vec2) types.Vec3 {
+	u, v := tangentVectors(n)
+
+	// According to equations (35, 36) for sampling GGX:
+	// theta = atan( a * sqrt(sample.x / 1 - sample.x) )
+	// phi = 2 * pi * sample.y
+	theta := float32(math.Atan(float64(roughness * sqrtf(sample[0]/(1-sample[0])))))
+	if theta < 0 {
+		theta += 2 * math.Pi
+	}
+
+	cosTheta := cosf(theta)
+	sinTheta := sqrtf(1 - cosTheta*cosTheta)
+
+	cosPhi := cosf(2 * math.Pi * sample[1])
+	sinPhi := sinf(2 * math.Pi * sample[1])
+
+	// Project and rotate to get the halfway vector
+	return u.Mul(sinTheta * cosPhi).Add(v.Mul(sinTheta * sinPhi)).Add(n.Mul(cosTheta)).Normalize()
+}
+
+// pdf = D * hDotN / 4 * oDotH
+// the nominator comes from equation 24 and the denominator comes form the
+// half-dir Jacobian (equation 14)
+func ggxReflectionPdf(roughness float32, outRayDir, n, h types.Vec3) float32 {
+	nDotH := absf(n.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+
+	denom := 4 * oDotH
+	if denom == 0 {
+		return 0
+	}
+	return ggxD(roughness, n, h) * nDotH / denom
+}
+
+// pdf = D * hDotN * focusTerm where
+// focusTerm = etaT * etaT * oDotH / (etaI * iDotH + etaT * oDotH)^2
+func ggxRefractionPdf(roughness, etaI, etaT float32, inRayDir, outRayDir, n, h types.Vec3) float32 {
+	iDotH := absf(inRayDir.Dot(h))
+	oDotH := absf(outRayDir.Dot(h))
+	hDotN := absf(h.Dot(n))
+
+	denom := (etaI*iDotH + etaT*oDotH) * (etaI*iDotH + etaT*oDotH)
+	if denom <= 0 {
+		return 0
+	}
+	return ggxD(roughness, n, h) * hDotN * oDotH * etaT * etaT / denom
+}
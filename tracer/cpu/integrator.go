@@ -0,0 +1,365 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+const (
+	// Fresnel reflectance at normal incidence used by reflective shadow
+	// catchers.
+	shadowCatcherF0 float32 = 0.04
+
+	// The angle (in radians) that is added to the ray cone spread when a
+	// path scatters off a non-specular surface.
+	rayConeScatterSpread float32 = 0.25
+)
+
+// A path tracer that implements the same integrator as the default opencl
+// pipeline (MonteCarloIntegrator) one path at a time. Each tracer worker owns
+// a pathTracer instance so its scratch buffers do not need to be guarded.
+//
+// Paths traveling through participating media sample a free-flight distance
+// before shading the next surface; the distance sampling is combined with
+// equi-angular sampling towards the area lights. Heterogeneous media are
+// sampled using delta tracking and their transmittance is estimated using
+// ratio tracking. Media interactions use next event estimation with area
+// lights and importance sample the medium phase function.
+//
+// The following opencl pipeline features are not supported: sample clamping,
+// shading normal correction, light path expressions and sample statistics.
+type pathTracer struct {
+	sd  *sceneData
+	cam *cameraState
+
+	// Scratch space for BVH traversals.
+	stack []uint32
+}
+
+func newPathTracer(sd *sceneData, cam *cameraState) *pathTracer {
+	return &pathTracer{
+		sd:    sd,
+		cam:   cam,
+		stack: make([]uint32, 0, bvhStackSize),
+	}
+}
+
+// Trace a path through the frame pixel (x, y) and return the radiance
+// arriving at the camera.
+func (pt *pathTracer) tracePath(x, y uint32, seed uint32, blockReq *tracer.BlockRequest) types.Vec3 {
+	sd := pt.sd
+	pixelIndex := y*blockReq.FrameW + x
+	rng := newPathRng(seed, pixelIndex)
+
+	r, coneSpread := pt.cam.primaryRay(x, y, blockReq.FrameW, blockReq.FrameH, &rng)
+	var coneWidth float32
+	var pathFlags uint32
+	var radiance types.Vec3
+	throughput := types.Vec3{1, 1, 1}
+	skipFlags := scene.CameraInvisible
+	mediumIndex := sd.SceneMediumMatIndex
+
+	var hit intersection
+	for bounce := uint32(0); bounce < blockReq.NumBounces; bounce++ {
+		hitFound := sd.intersect(&r, skipFlags, &pt.stack, &hit)
+
+		// Attenuate the path while it travels through a medium and
+		// check whether it scatters before reaching the next surface.
+		if m, inMedium := sd.medium(mediumIndex); inMedium {
+			tMax := maxFloat
+			if hitFound {
+				tMax = hit.t
+			}
+
+			// Combine free-flight sampling with equi-angular sampling
+			// towards a randomly selected area light. Heterogeneous
+			// media are sampled using delta tracking instead.
+			var t float32
+			var weight types.Vec3
+			var mediumScatter bool
+			if m.heterogeneous() {
+				var emitted types.Vec3
+				t, weight, emitted, mediumScatter = m.trackDistance(r.origin, r.dir, tMax, &rng)
+				radiance = radiance.Add(mulVec3(throughput, emitted))
+			} else {
+				distSample := rng.sample2f()
+				if lightPos, isAreaLight := sd.areaLightCentroid(pt.selectEmissive(rng.float())); isAreaLight {
+					t, weight, mediumScatter = m.sampleDistanceEquiAngular(r.origin, r.dir, tMax, lightPos, distSample)
+				} else {
+					t, weight, mediumScatter = m.sampleDistance(tMax, distSample)
+				}
+			}
+			throughput = mulVec3(throughput, weight)
+			if mediumScatter {
+				point := r.origin.Add(r.dir.Mul(t))
+				coneWidth += coneSpread * t
+				skipFlags = 0
+
+				sample0 := rng.sample2f()
+				sample1 := rng.sample2f()
+				sample2 := rng.sample2f()
+				if bounce >= blockReq.MinBouncesForRR {
+					rrProbability := maxf(minf(0.5, luminance(throughput)), 0.01)
+					if rrProbability < sample2[0] {
+						break
+					}
+					throughput = throughput.Mul(1 / rrProbability)
+				}
+
+				phaseDir, phasePdf := m.samplePhase(r.dir, sample0)
+				emissiveSample, phaseWeight := pt.sampleMediumEmissive(&m, point, r.dir, phaseDir, phasePdf, sample1, &rng)
+				radiance = radiance.Add(mulVec3(throughput, emissiveSample))
+
+				// The phase function value and the pdf of its
+				// samples cancel out.
+				throughput = throughput.Mul(phaseWeight)
+				if maxComponent(throughput) <= 0 {
+					break
+				}
+				coneSpread += rayConeScatterSpread
+				r = ray{origin: point, dir: phaseDir, maxDist: maxFloat}
+				continue
+			}
+		}
+
+		if !hitFound {
+			if bounce == 0 {
+				return mulVec3(throughput, pt.backgroundSample(r.dir, x, y, blockReq))
+			}
+
+			// The path throughput already includes the MIS weight for
+			// the environment light samples.
+			return radiance.Add(mulVec3(throughput, pt.envSample(r.dir)))
+		}
+		skipFlags = 0
+
+		s := sd.surfaceAt(&r, &hit)
+
+		// Grow the path ray cone to the intersection point and use it
+		// to select the texture mip levels for this surface.
+		coneWidth += coneSpread * hit.t
+		sd.setTextureLod(&s, &hit, r.dir, coneWidth)
+
+		sample0 := rng.sample2f()
+		sample1 := rng.sample2f()
+		sample2 := rng.sample2f()
+
+		instance := &sd.MeshInstanceList[hit.meshInstance]
+		rayBias := intersectionEpsilon
+		if instance.RayBias > 0 {
+			rayBias = instance.RayBias
+		}
+
+		// All BxDF formulas use in/out rays that are going outwards from
+		// the surface.
+		inRayDir := r.dir.Mul(-1)
+		b, tint := sd.selectBxdf(&s, inRayDir, &pathFlags, &rng)
+		inRayDotNormal := inRayDir.Dot(s.normal)
+
+		var emissiveSample, emissiveOutRayDir types.Vec3
+		var distToEmissive float32
+		castShadowRay, scatter := false, false
+		var outRay ray
+
+		switch {
+		case bounce == 0 && instance.Flags&scene.ShadowCatcher != 0:
+			// Shadow catchers replace the surface color with the
+			// background behind them darkened by any shadows.
+			background := pt.backgroundSample(r.dir, x, y, blockReq)
+			var fresnel float32
+			if instance.Flags&scene.CatcherReflections != 0 {
+				c := 1 - maxf(0, inRayDotNormal)
+				fresnel = shadowCatcherF0 + (1-shadowCatcherF0)*c*c*c*c*c
+			}
+			matte := mulVec3(throughput, background).Mul(1 - fresnel)
+
+			// If we cannot get a valid emissive sample then the
+			// catcher is considered to be unoccluded.
+			var emissivePdf float32
+			if emissive := pt.selectEmissive(sample1[0]); emissive != nil {
+				emissiveSample, emissiveOutRayDir, emissivePdf, distToEmissive = sd.emissiveSample(&s, emissive, sample1)
+			}
+			if maxComponent(emissiveSample) > 0 && emissivePdf > 0 && s.normal.Dot(emissiveOutRayDir) > 0 {
+				emissiveSample = matte
+				castShadowRay = true
+			} else {
+				radiance = radiance.Add(matte)
+			}
+
+			if fresnel > 0 {
+				outRay = ray{
+					origin:  s.point.Add(s.normal.Mul(rayBias)),
+					dir:     s.normal.Mul(2 * inRayDotNormal).Sub(inRayDir),
+					maxDist: maxFloat,
+				}
+				throughput = throughput.Mul(fresnel)
+				scatter = true
+			}
+		case b.kind == material.BxdfEmissive:
+			// Accumulate implicit light and terminate the path if the
+			// incoming ray is facing the emissive.
+			if inRayDotNormal > 0 {
+				ke := sd.sample3f(s.uv, s.texLod, b.color, b.colorTex).Mul(b.roughness)
+				radiance = radiance.Add(mulVec3(throughput, ke))
+			}
+		default:
+			// Implement RR to terminate paths with no significant
+			// contribution while boosting surviving paths by the same
+			// probability.
+			rejectSample := b.kind == 0
+			if bounce >= blockReq.MinBouncesForRR {
+				rrProbability := maxf(minf(0.5, luminance(throughput)), 0.01)
+				if rrProbability < sample2[0] {
+					rejectSample = true
+				} else {
+					throughput = throughput.Mul(1 / rrProbability)
+				}
+			}
+			if rejectSample {
+				break
+			}
+
+			bxdfSample, bxdfOutRayDir, bxdfPdf := sd.bxdfSample(&s, &b, sample0, inRayDir)
+
+			// Displace the ray origins along the normal to avoid self
+			// intersections. Refracted rays start inside the surface
+			// while emissive rays always start outside of it.
+			outRay = ray{
+				origin:  s.point.Add(s.normal.Mul(signf(s.normal.Dot(bxdfOutRayDir)) * rayBias)),
+				dir:     bxdfOutRayDir,
+				maxDist: maxFloat,
+			}
+
+			var emissivePdf float32
+			bxdfWeight := float32(1)
+			emissive := pt.selectEmissive(sample1[0])
+			if emissive != nil {
+				emissiveSample, emissiveOutRayDir, emissivePdf, distToEmissive = sd.emissiveSample(&s, emissive, sample1)
+
+				// MIS: calculate sampling weights for the emissive and
+				// bxdf samples using the power heuristic.
+				emissiveWeight := powerHeuristic(emissivePdf, sd.bxdfPdf(&s, &b, inRayDir, emissiveOutRayDir))
+				bxdfWeight = powerHeuristic(bxdfPdf, sd.emissivePdf(&s, emissive, bxdfOutRayDir))
+
+				nDotEmissiveOutRay := maxf(0, s.normal.Dot(emissiveOutRayDir))
+				if maxComponent(emissiveSample) > 0 && emissivePdf > 0 && nDotEmissiveOutRay > 0 {
+					bxdfEmissiveSample := sd.bxdfEval(&s, &b, inRayDir, emissiveOutRayDir)
+					emissiveSample = mulVec3(mulVec3(emissiveSample, bxdfEmissiveSample), throughput).Mul(
+						emissiveWeight * nDotEmissiveOutRay * float32(len(sd.EmissivePrimitives)) / emissivePdf,
+					)
+
+					// Shadow rays travel through the medium on the
+					// outer side of the surface.
+					shadowMedium := mediumIndex
+					if inRayDotNormal < 0 {
+						shadowMedium = sd.SceneMediumMatIndex
+					}
+					if m, inMedium := sd.medium(shadowMedium); inMedium {
+						emissiveSample = mulVec3(emissiveSample, m.shadowTransmittance(s.point, emissiveOutRayDir, distToEmissive, &rng))
+					}
+					castShadowRay = maxComponent(emissiveSample) > 0
+				}
+			}
+
+			// Disable bxdfWeight for singular surfaces. Rays scattered
+			// by other surfaces widen the path ray cone.
+			if b.singular() {
+				bxdfWeight = 1
+			} else {
+				coneSpread += rayConeScatterSpread
+			}
+
+			// Use the abs value of the dot product as it will be
+			// negative for rays entering refractive surfaces.
+			t := mulVec3(bxdfSample, tint).Mul(bxdfWeight * absf(s.normal.Dot(bxdfOutRayDir)))
+			if maxComponent(t) > 0 && bxdfPdf > 0 {
+				throughput = mulVec3(throughput, t).Mul(1 / bxdfPdf)
+				scatter = true
+			}
+
+			// Update the path medium for rays that are transmitted
+			// through the surface.
+			if outRayDotNormal := s.normal.Dot(bxdfOutRayDir); outRayDotNormal*inRayDotNormal < 0 {
+				mediumIndex = sd.transmittedMedium(s.matNodeIndex, outRayDotNormal < 0)
+			}
+		}
+
+		if castShadowRay {
+			shadowRay := ray{
+				origin:  s.point.Add(s.normal.Mul(rayBias)),
+				dir:     emissiveOutRayDir,
+				maxDist: distToEmissive - intersectionWithLightEpsilon,
+			}
+			if !sd.occluded(&shadowRay, &pt.stack) {
+				radiance = radiance.Add(emissiveSample)
+			}
+		}
+
+		if !scatter {
+			break
+		}
+		r = outRay
+	}
+
+	return radiance
+}
+
+// Select a random emissive with uniform probability or return nil if the
+// scene has no emissives.
+func (pt *pathTracer) selectEmissive(sample float32) *scene.EmissivePrimitive {
+	numEmissives := len(pt.sd.EmissivePrimitives)
+	if numEmissives == 0 {
+		return nil
+	}
+
+	index := int(sample * float32(numEmissives))
+	if index >= numEmissives {
+		index = numEmissives - 1
+	} else if index < 0 {
+		index = 0
+	}
+	return &pt.sd.EmissivePrimitives[index]
+}
+
+// Sample the scene background as seen by a camera ray. If a backplate is
+// defined, it is mapped to the frame using the pixel coordinates. Otherwise,
+// the scene env map or the scene bg color is sampled using the ray direction.
+func (pt *pathTracer) backgroundSample(rayDir types.Vec3, x, y uint32, blockReq *tracer.BlockRequest) types.Vec3 {
+	if node := pt.sd.materialNode(pt.sd.SceneBackplateMatIndex); node != nil {
+		uv := types.Vec2{
+			(float32(x) + 0.5) / float32(blockReq.FrameW),
+			(float32(y) + 0.5) / float32(blockReq.FrameH),
+		}
+		return pt.sd.sample3f(uv, texLodTopMip, node.Union2.Vec3(), node.Union1[3])
+	}
+
+	return pt.envSample(rayDir)
+}
+
+// Sample the scene environment along a ray direction. If the scene defines an
+// environment map, its scaled radiance is returned. Otherwise, the scene bg
+// color (or lat/long skybox) is sampled.
+func (pt *pathTracer) envSample(rayDir types.Vec3) types.Vec3 {
+	uv := rayToLatLongUV(rayDir)
+	if node := pt.sd.materialNode(pt.sd.envMatNodeIndex); node != nil {
+		return pt.sd.emissiveRadiance(node, uv, texLodTopMip)
+	} else if node := pt.sd.materialNode(pt.sd.SceneDiffuseMatIndex); node != nil {
+		return pt.sd.sample3f(uv, texLodTopMip, node.Union2.Vec3(), node.Union1[3])
+	}
+
+	return types.Vec3{}
+}
+
+func powerHeuristic(a, b float32) float32 {
+	denom := a*a + b*b
+	if denom == 0 {
+		return 0
+	}
+	return (a * a) / denom
+}
+
+func maxComponent(v types.Vec3) float32 {
+	return maxf(v[0], maxf(v[1], v[2]))
+}
@@ -0,0 +1,305 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The initial capacity of the BVH traversal stack.
+const bvhStackSize = 64
+
+type ray struct {
+	origin types.Vec3
+	dir    types.Vec3
+
+	// The max allowed distance for intersections.
+	maxDist float32
+}
+
+type intersection struct {
+	// The barycentric coordinates of the hit.
+	w, u, v float32
+
+	// The distance from the ray origin to the hit.
+	t float32
+
+	// The intersected triangle and mesh instance.
+	triIndex     uint32
+	meshInstance uint32
+}
+
+// Find the closest intersection of a ray with the scene geometry skipping any
+// mesh instances that have one of the skipFlags set. It returns false if the
+// ray does not hit anything.
+func (sd *sceneData) intersect(r *ray, skipFlags scene.MeshInstanceFlag, stack *[]uint32, hit *intersection) bool {
+	hit.t = r.maxDist
+	return sd.traverse(r, skipFlags, false, stack, hit)
+}
+
+// Check whether a ray intersects any scene geometry.
+func (sd *sceneData) occluded(r *ray, stack *[]uint32) bool {
+	var hit intersection
+	hit.t = r.maxDist
+	return sd.traverse(r, 0, true, stack, &hit)
+}
+
+// Traverse the two-level scene BVH in the same way as the intersection
+// kernels. Top-level leafs point to mesh instances whose bottom-level BVH is
+// traversed using the ray transformed into mesh space. As the ray direction
+// is not normalized after the transformation, hit distances in mesh space
+// match the world space distances.
+func (sd *sceneData) traverse(r *ray, skipFlags scene.MeshInstanceFlag, anyHit bool, stack *[]uint32, hit *intersection) bool {
+	if sd.compressedBvh != nil {
+		return sd.traverseCompressed(r, skipFlags, anyHit, stack, hit)
+	}
+
+	nodes := sd.BvhNodeList
+	gotHit := false
+
+	origin, dir := r.origin, r.dir
+	invDir := invVec3(dir)
+	var meshInstance uint32
+	meshStackStart := -1
+
+	*stack = (*stack)[:0]
+	node := &nodes[0]
+	for {
+		wantLeft, wantRight := false, false
+		var left, right *scene.BvhNode
+		if node.LData <= 0 {
+			if node.RData == 0 {
+				// Top-level leaf; enter the bottom-level BVH of the
+				// mesh instance unless it is skipped.
+				meshInstance = uint32(-node.LData)
+				instance := &sd.MeshInstanceList[meshInstance]
+				if instance.Flags&skipFlags == 0 {
+					meshStackStart = len(*stack)
+					*stack = append(*stack, instance.BvhRoot)
+					origin = transformPoint(&instance.Transform, r.origin)
+					dir = transformDir(&instance.Transform, r.dir)
+					invDir = invVec3(dir)
+				}
+			} else {
+				first, count := node.GetPrimitives()
+				for triIndex := first; triIndex < first+count; triIndex++ {
+					t, u, v, ok := sd.intersectTriangle(triIndex, origin, dir)
+					if !ok || t >= hit.t {
+						continue
+					}
+
+					// Ignore hits on transparent texels of alpha cutout materials
+					if sd.MeshInstanceList[meshInstance].Flags&scene.AlphaCutout != 0 && sd.transparentAt(triIndex, u, v) {
+						continue
+					}
+
+					gotHit = true
+					if anyHit {
+						return true
+					}
+					hit.w, hit.u, hit.v, hit.t = 1-(u+v), u, v, t
+					hit.triIndex = triIndex
+					hit.meshInstance = meshInstance
+				}
+			}
+		} else {
+			left, right = &nodes[node.LData], &nodes[node.RData]
+			wantLeft = intersectBox(left, origin, invDir, hit.t)
+			wantRight = intersectBox(right, origin, invDir, hit.t)
+		}
+
+		switch {
+		case wantLeft && wantRight:
+			*stack = append(*stack, uint32(node.RData))
+			node = left
+			continue
+		case wantLeft:
+			node = left
+			continue
+		case wantRight:
+			node = right
+			continue
+		}
+
+		// If we exited from a bottom-level BVH restore the world space ray
+		if len(*stack) == meshStackStart {
+			origin, dir, invDir = r.origin, r.dir, invVec3(r.dir)
+			meshStackStart = -1
+		}
+
+		if len(*stack) == 0 {
+			return gotHit
+		}
+		node = &nodes[(*stack)[len(*stack)-1]]
+		*stack = (*stack)[:len(*stack)-1]
+	}
+}
+
+// Traverse the compressed scene BVH in the same way as the intersection
+// kernels built with BVH_COMPRESSED. The stack stores the Children and Counts
+// values of each pushed node child as two consecutive entries. Hit children
+// are pushed in order of decreasing distance so the closest child is visited
+// first.
+func (sd *sceneData) traverseCompressed(r *ray, skipFlags scene.MeshInstanceFlag, anyHit bool, stack *[]uint32, hit *intersection) bool {
+	nodes := sd.compressedBvh.Nodes
+	gotHit := false
+
+	origin, dir := r.origin, r.dir
+	invDir := invVec3(dir)
+	var meshInstance uint32
+	meshStackStart := -1
+
+	var hits [scene.CompressedBvhWidth]struct {
+		child, count uint32
+		dist         float32
+	}
+
+	*stack = (*stack)[:0]
+	nodeIndex := 0
+	for nodeIndex >= 0 {
+		node := &nodes[nodeIndex]
+		numHits := 0
+		for child := 0; child < int(node.NumChildren); child++ {
+			bbox := node.ChildBBox(child)
+			dist, ok := intersectBBox(bbox, origin, invDir, hit.t)
+			if !ok {
+				continue
+			}
+
+			pos := numHits
+			for ; pos > 0 && hits[pos-1].dist < dist; pos-- {
+				hits[pos] = hits[pos-1]
+			}
+			hits[pos].child, hits[pos].count, hits[pos].dist = uint32(node.Children[child]), uint32(node.Counts[child]), dist
+			numHits++
+		}
+		for index := 0; index < numHits; index++ {
+			*stack = append(*stack, hits[index].child, hits[index].count)
+		}
+
+		// Pop stack entries until we find the next node to visit
+		nodeIndex = -1
+		for nodeIndex < 0 && len(*stack) > 0 {
+			// If we exited from a bottom-level BVH restore the world space ray
+			if len(*stack) == meshStackStart {
+				origin, dir, invDir = r.origin, r.dir, invVec3(r.dir)
+				meshStackStart = -1
+			}
+
+			children, count := int32((*stack)[len(*stack)-2]), (*stack)[len(*stack)-1]
+			*stack = (*stack)[:len(*stack)-2]
+			switch {
+			case children > 0:
+				nodeIndex = int(children)
+			case count == 0:
+				// Top-level leaf; enter the bottom-level BVH of the
+				// mesh instance unless it is skipped.
+				instance := &sd.MeshInstanceList[-children]
+				if instance.Flags&skipFlags != 0 {
+					continue
+				}
+				meshInstance = uint32(-children)
+				meshStackStart = len(*stack)
+				nodeIndex = int(sd.compressedBvh.MeshRoots[meshInstance])
+				origin = transformPoint(&instance.Transform, r.origin)
+				dir = transformDir(&instance.Transform, r.dir)
+				invDir = invVec3(dir)
+			default:
+				first := uint32(-children)
+				for triIndex := first; triIndex < first+count; triIndex++ {
+					t, u, v, ok := sd.intersectTriangle(triIndex, origin, dir)
+					if !ok || t >= hit.t {
+						continue
+					}
+
+					// Ignore hits on transparent texels of alpha cutout materials
+					if sd.MeshInstanceList[meshInstance].Flags&scene.AlphaCutout != 0 && sd.transparentAt(triIndex, u, v) {
+						continue
+					}
+
+					gotHit = true
+					if anyHit {
+						return true
+					}
+					hit.w, hit.u, hit.v, hit.t = 1-(u+v), u, v, t
+					hit.triIndex = triIndex
+					hit.meshInstance = meshInstance
+				}
+			}
+		}
+	}
+
+	return gotHit
+}
+
+// Intersect a ray with a triangle using the Moller-Trumbore algorithm.
+func (sd *sceneData) intersectTriangle(triIndex uint32, origin, dir types.Vec3) (t, u, v float32, ok bool) {
+	offset := triIndex * 3
+	v0 := sd.VertexList[offset].Vec3()
+	edge01 := sd.VertexList[offset+1].Vec3().Sub(v0)
+	edge02 := sd.VertexList[offset+2].Vec3().Sub(v0)
+
+	pVec := dir.Cross(edge02)
+	det := edge01.Dot(pVec)
+	if absf(det) < intersectionEpsilon {
+		return 0, 0, 0, false
+	}
+	invDet := 1.0 / det
+
+	// Calculate barycentric coords
+	tVec := origin.Sub(v0)
+	u = tVec.Dot(pVec) * invDet
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+
+	qVec := tVec.Cross(edge01)
+	v = dir.Dot(qVec) * invDet
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+
+	t = edge02.Dot(qVec) * invDet
+	return t, u, v, t > intersectionEpsilon
+}
+
+// Check whether a hit lands on a transparent texel of an alpha cutout
+// material. The u and v arguments are the barycentric coordinates of the hit
+// for the second and third triangle vertex.
+func (sd *sceneData) transparentAt(triIndex uint32, u, v float32) bool {
+	node := sd.materialNode(int32(sd.MaterialIndex[triIndex]))
+	if node == nil || material.OpType(node.Union1[0]) != material.OpAlphaCutout {
+		return false
+	}
+
+	offset := triIndex * 3
+	uvs := sd.UvList[offset : offset+3]
+	w := 1 - (u + v)
+	uv := types.Vec2{uvs[0][0]*w + uvs[1][0]*u + uvs[2][0]*v, uvs[0][1]*w + uvs[1][1]*u + uvs[2][1]*v}
+	return sd.sampleOpacity(uv, node.Union1[3]) < node.Union2[0]
+}
+
+// Check whether a ray intersects the bounding box of a BVH node closer than
+// maxDist.
+func intersectBox(node *scene.BvhNode, origin, invDir types.Vec3, maxDist float32) bool {
+	_, ok := intersectBBox([2]types.Vec3{node.Min, node.Max}, origin, invDir, maxDist)
+	return ok
+}
+
+// Check whether a ray intersects a bounding box closer than maxDist and return
+// the distance to the box entry point.
+func intersectBBox(bbox [2]types.Vec3, origin, invDir types.Vec3, maxDist float32) (float32, bool) {
+	var minmax, maxmin float32 = maxFloat, -maxFloat
+	for axis := 0; axis < 3; axis++ {
+		tmin := (bbox[0][axis] - origin[axis]) * invDir[axis]
+		tmax := (bbox[1][axis] - origin[axis]) * invDir[axis]
+		minmax = minf(minmax, maxf(tmin, tmax))
+		maxmin = maxf(maxmin, minf(tmin, tmax))
+	}
+
+	return maxmin, minmax >= 0 && maxmin <= minmax && maxmin < maxDist
+}
+
+func invVec3(v types.Vec3) types.Vec3 {
+	return types.Vec3{1.0 / v[0], 1.0 / v[1], 1.0 / v[2]}
+}
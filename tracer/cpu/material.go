@@ -0,0 +1,206 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Path flags for tracking the color channel selected by disperse nodes.
+const (
+	pathFlagDisperseR uint32 = 1 << iota
+	pathFlagDisperseG
+	pathFlagDisperseB
+)
+
+// Number of height layers used by the parallax preview.
+const parallaxSteps = 8
+
+// A leaf material node selected for shading a surface. The node fields are
+// decoded from the union fields of scene.MaterialNode.
+type bxdf struct {
+	kind material.BxdfType
+
+	// Reflectance, specularity, radiance or base color and the associated
+	// texture.
+	color    types.Vec3
+	colorTex int32
+
+	// Transmittance and the associated texture. Principled bxdfs use the
+	// texture for the metallic weight.
+	transmittance    types.Vec3
+	transmittanceTex int32
+
+	// The metallic, specular, sheen and clearcoat weights and the
+	// transmission weight of principled bxdfs.
+	principledWeights types.Vec4
+	transmission      float32
+
+	intIOR float32
+	extIOR float32
+
+	// Roughness or radiance scaler.
+	roughness    float32
+	roughnessTex int32
+}
+
+// Decode a leaf material node.
+func newBxdf(node *scene.MaterialNode) bxdf {
+	return bxdf{
+		kind:              material.BxdfType(node.Union1[0]),
+		color:             node.Union2.Vec3(),
+		colorTex:          node.Union1[3],
+		transmittance:     node.Union3.Vec3(),
+		transmittanceTex:  node.Union1[2],
+		principledWeights: node.Union3,
+		transmission:      node.Union2[3],
+		intIOR:            node.Union4[0],
+		extIOR:            node.Union4[1],
+		roughness:         node.Union4[2],
+		roughnessTex:      node.Union5[0],
+	}
+}
+
+// Returns true if the bxdf describes an ideal mirror or dielectric.
+func (b *bxdf) singular() bool {
+	return b.kind == material.BxdfConductor || b.kind == material.BxdfDielectric
+}
+
+// Traverse the layered material tree for a surface and select a leaf node.
+// Bump and normal map nodes update the surface normal while disperse nodes
+// select a color channel for the path and return it as a tint. If the tree
+// is invalid, the returned bxdf has a zero kind.
+func (sd *sceneData) selectBxdf(s *surface, inRayDir types.Vec3, pathFlags *uint32, rng *pathRng) (b bxdf, tint types.Vec3) {
+	tint = types.Vec3{1, 1, 1}
+	var forceIntIOR, forceExtIOR float32
+
+	node := sd.materialNode(s.matNodeIndex)
+	for depth := 0; node != nil && material.IsOpType(uint32(node.Union1[0])); depth++ {
+		// Guard against cycles in malformed material trees
+		if depth > len(sd.MaterialNodeList) {
+			return bxdf{}, tint
+		}
+
+		switch material.OpType(node.Union1[0]) {
+		case material.OpMix:
+			// Depending on the sample, follow left or right
+			sample := rng.sample2f()
+			if sample[0] < node.Union2[0] {
+				node = sd.materialNode(node.Union1[1])
+			} else {
+				node = sd.materialNode(node.Union1[2])
+			}
+		case material.OpMixMap:
+			// Sample weight from texture
+			sample := rng.sample2f()
+			if sample[0] < sd.sampleTexture1f(s.uv, s.texLod, node.Union1[3]) {
+				node = sd.materialNode(node.Union1[1])
+			} else {
+				node = sd.materialNode(node.Union1[2])
+			}
+		case material.OpMixCurvature:
+			// Use the scaled surface curvature as the weight
+			sample := rng.sample2f()
+			if sample[0] < clampf(s.curvature*node.Union2[0], 0, 1) {
+				node = sd.materialNode(node.Union1[1])
+			} else {
+				node = sd.materialNode(node.Union1[2])
+			}
+		case material.OpMixOcclusion:
+			// Use the baked local occlusion as the weight
+			sample := rng.sample2f()
+			if sample[0] < s.occlusion {
+				node = sd.materialNode(node.Union1[1])
+			} else {
+				node = sd.materialNode(node.Union1[2])
+			}
+		case material.OpBumpMap:
+			if parallaxScale := node.Union4[2]; parallaxScale > 0 {
+				s.uv = sd.parallaxUV(s.normal, s.tangent, s.uv, inRayDir, parallaxScale, node.Union1[3])
+			}
+			u, v := tangentFrame(s.normal, s.tangent)
+			sample := sd.sampleBumpMap(s.uv, node.Union1[3]).Mul(2).Sub(types.Vec3{1, 1, 1})
+			s.normal = u.Mul(sample[0]).Add(v.Mul(sample[1])).Add(s.normal.Mul(sample[2])).Normalize()
+			node = sd.materialNode(node.Union1[1])
+		case material.OpAlphaCutout:
+			// Transparent texels are skipped by the intersection tests
+			node = sd.materialNode(node.Union1[1])
+		case material.OpMedium:
+			// The medium is handled by the integrator
+			node = sd.materialNode(node.Union1[1])
+		case material.OpNormalMap:
+			// R, G components encode the range [-1, 1] into a value
+			// [0, 255]; B encodes the range [0, 1] into [128, 255]
+			u, v := tangentFrame(s.normal, s.tangent)
+			sample := sd.sampleTexture(s.uv, texLodTopMip, node.Union1[3]).Mul(2).Sub(types.Vec3{1, 1, 1})
+			s.normal = u.Mul(sample[0]).Add(v.Mul(sample[1])).Add(s.normal.Mul(0.5 * sample[2])).Normalize()
+			node = sd.materialNode(node.Union1[1])
+		case material.OpDisperse:
+			// Select a channel the first time that the path hits a
+			// dispersive material and reuse it when exiting the
+			// material.
+			if *pathFlags&(pathFlagDisperseR|pathFlagDisperseG|pathFlagDisperseB) == 0 {
+				sample := rng.sample2f()
+				switch {
+				case sample[0] < 0.333:
+					*pathFlags |= pathFlagDisperseR
+				case sample[0] < 0.666:
+					*pathFlags |= pathFlagDisperseG
+				default:
+					*pathFlags |= pathFlagDisperseB
+				}
+			}
+
+			channel := 2
+			if *pathFlags&pathFlagDisperseR != 0 {
+				channel = 0
+			} else if *pathFlags&pathFlagDisperseG != 0 {
+				channel = 1
+			}
+			tint = types.Vec3{}
+			tint[channel] = 1
+			forceIntIOR, forceExtIOR = node.Union2[channel], node.Union3[channel]
+			node = sd.materialNode(node.Union1[1])
+		default:
+			node = nil
+		}
+	}
+
+	if node == nil {
+		return bxdf{}, tint
+	}
+
+	// Apply dispersion IORs
+	b = newBxdf(node)
+	b.intIOR = maxf(b.intIOR, forceIntIOR)
+	b.extIOR = maxf(b.extIOR, forceExtIOR)
+	return b, tint
+}
+
+// Offset the surface uv coordinates using a bump map as a height map. This
+// implements the same parallax occlusion mapping variant as the opencl
+// kernels.
+func (sd *sceneData) parallaxUV(normal types.Vec3, tangent types.Vec4, uv types.Vec2, inRayDir types.Vec3, scale float32, texIndex int32) types.Vec2 {
+	u, v := tangentFrame(normal, tangent)
+
+	// Express view vector in tangent space
+	viewDir := inRayDir.Mul(-1)
+	tsView := types.Vec3{viewDir.Dot(u), viewDir.Dot(v), viewDir.Dot(normal)}
+	if tsView[2] <= 0 {
+		return uv
+	}
+
+	layerStep := float32(1.0) / parallaxSteps
+	scaler := scale * layerStep / maxf(tsView[2], 0.05)
+	uvStep := types.Vec2{tsView[0] * scaler, tsView[1] * scaler}
+
+	var layerDepth float32
+	depth := 1 - sd.sampleTexture1f(uv, texLodTopMip, texIndex)
+	for step := 0; step < parallaxSteps && layerDepth < depth; step++ {
+		uv = uv.Sub(uvStep)
+		layerDepth += layerStep
+		depth = 1 - sd.sampleTexture1f(uv, texLodTopMip, texIndex)
+	}
+
+	return uv
+}
@@ -0,0 +1,173 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Constants shared with the opencl kernels (see CL/constants.cl).
+const (
+	intersectionEpsilon          float32 = 0.00001
+	intersectionWithLightEpsilon         = intersectionEpsilon * 1e3
+	minRoughness                 float32 = 0.1
+	maxFloat                     float32 = math.MaxFloat32
+
+	// A texture LOD that always selects the top mip level.
+	texLodTopMip = -math.MaxFloat32
+
+	// The min squared length of a projected tangent that can be used for
+	// building a tangent frame (see CL/util/surface.cl).
+	minTangentLenSq float32 = 1e-12
+)
+
+func absf(v float32) float32 {
+	return float32(math.Abs(float64(v)))
+}
+
+func sqrtf(v float32) float32 {
+	return float32(math.Sqrt(float64(v)))
+}
+
+func sinf(v float32) float32 {
+	return float32(math.Sin(float64(v)))
+}
+
+func cosf(v float32) float32 {
+	return float32(math.Cos(float64(v)))
+}
+
+func log2f(v float32) float32 {
+	return float32(math.Log2(float64(v)))
+}
+
+// Get the min of two values. Like fmin in opencl, NaN arguments are ignored.
+func minf(a, b float32) float32 {
+	if a < b || b != b {
+		return a
+	}
+	return b
+}
+
+// Get the max of two values. Like fmax in opencl, NaN arguments are ignored.
+func maxf(a, b float32) float32 {
+	if a > b || b != b {
+		return a
+	}
+	return b
+}
+
+func clampf(v, lo, hi float32) float32 {
+	return minf(maxf(v, lo), hi)
+}
+
+func signf(v float32) float32 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
+
+// Component-wise multiplication of two vectors.
+func mulVec3(a, b types.Vec3) types.Vec3 {
+	return types.Vec3{a[0] * b[0], a[1] * b[1], a[2] * b[2]}
+}
+
+// Convert a linear RGB value to luminance.
+func luminance(v types.Vec3) float32 {
+	return 0.2126*v[0] + 0.7152*v[1] + 0.0722*v[2]
+}
+
+// Transform a point with a 4x4 matrix.
+func transformPoint(m *types.Mat4, p types.Vec3) types.Vec3 {
+	return types.Vec3{
+		m[0]*p[0] + m[4]*p[1] + m[8]*p[2] + m[12],
+		m[1]*p[0] + m[5]*p[1] + m[9]*p[2] + m[13],
+		m[2]*p[0] + m[6]*p[1] + m[10]*p[2] + m[14],
+	}
+}
+
+// Transform a direction with the upper 3x3 part of a 4x4 matrix.
+func transformDir(m *types.Mat4, d types.Vec3) types.Vec3 {
+	return types.Vec3{
+		m[0]*d[0] + m[4]*d[1] + m[8]*d[2],
+		m[1]*d[0] + m[5]*d[1] + m[9]*d[2],
+		m[2]*d[0] + m[6]*d[1] + m[10]*d[2],
+	}
+}
+
+// Transform a normal with the transpose of the upper 3x3 part of a 4x4
+// matrix. Normals are converted from mesh to world space by passing the
+// world to mesh transformation of a mesh instance.
+func transformNormal(m *types.Mat4, n types.Vec3) types.Vec3 {
+	return types.Vec3{
+		m[0]*n[0] + m[1]*n[1] + m[2]*n[2],
+		m[4]*n[0] + m[5]*n[1] + m[6]*n[2],
+		m[8]*n[0] + m[9]*n[1] + m[10]*n[2],
+	}
+}
+
+// Generate the tangent and bi-tangent vectors for a normal in the same way
+// as the TANGENT_VECTORS kernel macro.
+func tangentVectors(n types.Vec3) (u, v types.Vec3) {
+	axis := types.Vec3{0, 0, 1}
+	if absf(n[2]) >= .999 {
+		axis = types.Vec3{1, 0, 0}
+	}
+	u = axis.Cross(n).Normalize()
+	v = n.Cross(u)
+	return u, v
+}
+
+// Build an orthonormal tangent frame around a normal in the same way as the
+// surfaceTangentFrame kernel function. If the tangent is zero, the frame
+// generated by tangentVectors is returned instead.
+func tangentFrame(n types.Vec3, tangent types.Vec4) (u, v types.Vec3) {
+	t := tangent.Vec3()
+	t = t.Sub(n.Mul(n.Dot(t)))
+	tLenSq := t.Dot(t)
+	if tLenSq < minTangentLenSq {
+		return tangentVectors(n)
+	}
+
+	u = t.Mul(1 / sqrtf(tLenSq))
+	v = n.Cross(u)
+	if tangent[3] < 0 {
+		v = v.Mul(-1)
+	}
+	return u, v
+}
+
+// Convert a direction vector into lat/long uv coordinates.
+func rayToLatLongUV(dir types.Vec3) types.Vec2 {
+	at2 := float32(math.Atan2(float64(dir[0]), float64(dir[2])))
+	if at2 < 0 {
+		at2 += 2 * math.Pi
+	}
+	return types.Vec2{
+		at2 / (2 * math.Pi),
+		float32(math.Acos(float64(clampf(dir[1]/dir.Len(), -1, 1)))) / math.Pi,
+	}
+}
+
+// Convert lat/long uv coordinates into a unit direction vector. This is the
+// inverse of rayToLatLongUV.
+func latLongUVToRay(uv types.Vec2) types.Vec3 {
+	phi := uv[0] * 2 * math.Pi
+	theta := uv[1] * math.Pi
+	sinTheta := sinf(theta)
+	return types.Vec3{sinTheta * sinf(phi), cosf(theta), sinTheta * cosf(phi)}
+}
+
+// Sample a hemisphere direction using a cosine weighted distribution.
+//
+// PDF = cos(theta) / pi
+func cosWeightedHemisphereSample(n types.Vec3, sample types.Vec2) types.Vec3 {
+	rd := sqrtf(sample[0])
+	phi := 2 * math.Pi * sample[1]
+	u, v := tangentVectors(n)
+	return u.Mul(rd * cosf(phi)).Add(v.Mul(rd * sinf(phi))).Add(n.Mul(sqrtf(1 - sample[0]))).Normalize()
+}
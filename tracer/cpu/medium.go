@@ -0,0 +1,286 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A participating medium decoded from a medium material node. The
+// coefficients of media with a density grid are scaled by the grid values.
+type medium struct {
+	sigmaA types.Vec3
+	sigmaS types.Vec3
+	sigmaT types.Vec3
+
+	// The anisotropy of the Henyey-Greenstein phase function.
+	g float32
+
+	// The density and emission grids or nil if the medium does not
+	// define them.
+	density  *volumeGrid
+	emission *volumeGrid
+}
+
+// Decode the medium material node with the given index. Returns false if the
+// index does not point to a medium node.
+func (sd *sceneData) medium(index int32) (medium, bool) {
+	node := sd.materialNode(index)
+	if node == nil || material.OpType(node.Union1[0]) != material.OpMedium {
+		return medium{}, false
+	}
+
+	m := medium{
+		sigmaA: node.Union2.Vec3(),
+		sigmaS: node.Union3.Vec3(),
+		g:      node.Union4[2],
+
+		density:  sd.volumeGrid(node.Union1[2]),
+		emission: sd.volumeGrid(node.Union1[3]),
+	}
+	m.sigmaT = m.sigmaA.Add(m.sigmaS)
+	return m, true
+}
+
+// Get the index of the medium that a path enters when it is transmitted
+// through a surface with the given root material node. Paths entering a
+// surface use the interior medium of its material (or no medium if the
+// material does not define one) while paths leaving a surface return to the
+// scene medium. Nested media are not supported.
+func (sd *sceneData) transmittedMedium(matNodeIndex int32, entering bool) int32 {
+	if !entering {
+		return sd.SceneMediumMatIndex
+	}
+	if _, isMedium := sd.medium(matNodeIndex); isMedium {
+		return matNodeIndex
+	}
+	return -1
+}
+
+// Get the transmittance along a ray segment of the given length. Only valid
+// for media without a density grid; see shadowTransmittance.
+func (m *medium) transmittance(dist float32) types.Vec3 {
+	return types.Vec3{
+		expf(-m.sigmaT[0] * dist),
+		expf(-m.sigmaT[1] * dist),
+		expf(-m.sigmaT[2] * dist),
+	}
+}
+
+// Sample a free-flight distance along a ray segment of length tMax. The
+// distance is sampled using the extinction coefficient of a uniformly selected
+// channel and the returned weight is divided by the pdf of the combined
+// single-channel strategies. If scatter is true, a scattering event occurs at
+// distance t and the weight includes the scattering coefficients. Otherwise,
+// the ray reaches the end of the segment and the weight is the attenuation of
+// the segment. Media that do not scatter light are handled by attenuating the
+// ray without sampling a distance.
+func (m *medium) sampleDistance(tMax float32, sample types.Vec2) (t float32, weight types.Vec3, scatter bool) {
+	if maxComponent(m.sigmaS) == 0 {
+		return tMax, m.transmittance(tMax), false
+	}
+
+	t, scatter = m.freeFlightDistance(tMax, sample)
+	pdf := m.freeFlightPdf(t, scatter)
+	if pdf <= 0 {
+		return t, types.Vec3{}, scatter
+	}
+	return t, m.eventWeight(t, scatter).Mul(1 / pdf), scatter
+}
+
+// Sample a free-flight distance along a ray segment of length tMax by
+// combining the strategy used by sampleDistance with equi-angular sampling
+// towards lightPos. One of the two strategies is selected uniformly and the
+// returned weight is divided by the pdf of the combined strategies (balance
+// heuristic). Equi-angular samples always scatter inside the segment so that
+// single scattering near lights converges quickly. If the light lies on the
+// ray line, only the free-flight strategy is used.
+func (m *medium) sampleDistanceEquiAngular(origin, dir types.Vec3, tMax float32, lightPos types.Vec3, sample types.Vec2) (t float32, weight types.Vec3, scatter bool) {
+	if maxComponent(m.sigmaS) == 0 {
+		return tMax, m.transmittance(tMax), false
+	}
+
+	eaT, eaPdf := equiAngularSample(origin, dir, tMax, lightPos, sample[1])
+	if eaPdf <= 0 {
+		return m.sampleDistance(tMax, sample)
+	}
+
+	// Select a strategy and rescale the sample used for selecting it
+	if sample[0] < 0.5 {
+		t, scatter = m.freeFlightDistance(tMax, types.Vec2{2 * sample[0], sample[1]})
+	} else {
+		t, scatter = eaT, true
+	}
+
+	pdf := 0.5 * m.freeFlightPdf(t, scatter)
+	if scatter {
+		pdf += 0.5 * equiAngularPdf(origin, dir, tMax, lightPos, t)
+	}
+	if pdf <= 0 {
+		return t, types.Vec3{}, scatter
+	}
+	return t, m.eventWeight(t, scatter).Mul(1 / pdf), scatter
+}
+
+// Sample a free-flight distance using the extinction coefficient of the
+// channel selected by the first sample dimension. The distance is clamped to
+// tMax if the ray reaches the end of the segment.
+func (m *medium) freeFlightDistance(tMax float32, sample types.Vec2) (t float32, scatter bool) {
+	channel := int(sample[0] * 3)
+	if channel > 2 {
+		channel = 2
+	}
+	t = maxFloat
+	if m.sigmaT[channel] > 0 {
+		t = -float32(math.Log(float64(1-sample[1]))) / m.sigmaT[channel]
+	}
+
+	if t < tMax {
+		return t, true
+	}
+	return tMax, false
+}
+
+// Get the pdf of freeFlightDistance for a scattering event at distance t or
+// the probability of reaching the end of the segment at t.
+func (m *medium) freeFlightPdf(t float32, scatter bool) float32 {
+	density := m.transmittance(t)
+	if scatter {
+		density = mulVec3(m.sigmaT, density)
+	}
+	return (density[0] + density[1] + density[2]) / 3
+}
+
+// Get the unweighted contribution of a scattering event at distance t or of
+// reaching the end of the segment at t.
+func (m *medium) eventWeight(t float32, scatter bool) types.Vec3 {
+	if scatter {
+		return mulVec3(m.transmittance(t), m.sigmaS)
+	}
+	return m.transmittance(t)
+}
+
+// Sample a distance along a ray segment [0, tMax] proportionally to the
+// inverse squared distance to a point light and return the distance and its
+// pdf. The returned pdf is zero if the light lies on the ray line.
+//
+// See "Importance Sampling Techniques for Path Tracing in Participating Media"
+// (Kulla & Fajardo, EGSR 2012).
+func equiAngularSample(origin, dir types.Vec3, tMax float32, lightPos types.Vec3, sample float32) (t, pdf float32) {
+	delta, d, thetaA, thetaB := equiAngularRange(origin, dir, tMax, lightPos)
+	if d == 0 || thetaB <= thetaA {
+		return 0, 0
+	}
+
+	h := d * math.Tan(thetaA+(thetaB-thetaA)*float64(sample))
+	t = clampf(float32(delta+h), 0, tMax)
+	return t, equiAngularPdf(origin, dir, tMax, lightPos, t)
+}
+
+// Get the pdf for selecting distance t along the ray segment [0, tMax] using
+// equi-angular sampling.
+//
+// pdf = D / ((thetaB - thetaA) * (D^2 + h^2))
+func equiAngularPdf(origin, dir types.Vec3, tMax float32, lightPos types.Vec3, t float32) float32 {
+	delta, d, thetaA, thetaB := equiAngularRange(origin, dir, tMax, lightPos)
+	if d == 0 || thetaB <= thetaA || t < 0 || t > tMax {
+		return 0
+	}
+
+	h := float64(t) - delta
+	return float32(d / ((thetaB - thetaA) * (d*d + h*h)))
+}
+
+// Project a light position onto a ray and get the distance of the projected
+// point along the ray, the distance of the light to the ray and the angles
+// subtended by the segment endpoints.
+func equiAngularRange(origin, dir types.Vec3, tMax float32, lightPos types.Vec3) (delta, d, thetaA, thetaB float64) {
+	toLight := lightPos.Sub(origin)
+	delta = float64(toLight.Dot(dir))
+	d = float64(toLight.Sub(dir.Mul(float32(delta))).Len())
+	return delta, d, math.Atan2(-delta, d), math.Atan2(float64(tMax)-delta, d)
+}
+
+// Evaluate the Henyey-Greenstein phase function for a ray propagating along
+// rayDir that is scattered towards outRayDir.
+func (m *medium) phase(rayDir, outRayDir types.Vec3) float32 {
+	return henyeyGreenstein(rayDir.Dot(outRayDir), m.g)
+}
+
+// Sample the Henyey-Greenstein phase function for a ray propagating along
+// rayDir. As the sampled directions are distributed according to the phase
+// function, the returned pdf also equals the phase function value.
+func (m *medium) samplePhase(rayDir types.Vec3, sample types.Vec2) (outRayDir types.Vec3, pdf float32) {
+	var cosTheta float32
+	if absf(m.g) < 1e-3 {
+		cosTheta = 1 - 2*sample[0]
+	} else {
+		sqrTerm := (1 - m.g*m.g) / (1 - m.g + 2*m.g*sample[0])
+		cosTheta = (1 + m.g*m.g - sqrTerm*sqrTerm) / (2 * m.g)
+	}
+	cosTheta = clampf(cosTheta, -1, 1)
+	sinTheta := sqrtf(maxf(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * sample[1]
+
+	u, v := tangentVectors(rayDir)
+	outRayDir = u.Mul(sinTheta * cosf(phi)).Add(v.Mul(sinTheta * sinf(phi))).Add(rayDir.Mul(cosTheta)).Normalize()
+	return outRayDir, henyeyGreenstein(cosTheta, m.g)
+}
+
+// The Henyey-Greenstein phase function where cosTheta is the cosine of the
+// angle between the propagation and the scattered directions.
+//
+// p = (1 - g^2) / (4 * pi * (1 + g^2 - 2 * g * cosTheta)^(3/2))
+func henyeyGreenstein(cosTheta, g float32) float32 {
+	denom := 1 + g*g - 2*g*cosTheta
+	if denom <= 0 {
+		return 0
+	}
+	return (1 - g*g) / (4 * math.Pi * denom * sqrtf(denom))
+}
+
+// Sample direct lighting for a scattering event inside a medium. As rays that
+// leave a medium through a surface are occluded by it and rays traveling
+// through the scene medium never reach the environment, only area lights are
+// sampled. The returned radiance includes the phase function, the medium
+// attenuation and the MIS weight for the light sample; the MIS weight for the
+// phase sample towards phaseDir is also returned.
+func (pt *pathTracer) sampleMediumEmissive(m *medium, point, rayDir, phaseDir types.Vec3, phasePdf float32, sample types.Vec2, rng *pathRng) (radiance types.Vec3, phaseWeight float32) {
+	sd := pt.sd
+	emissive := pt.selectEmissive(sample[0])
+	if emissive == nil || emissive.Type != scene.AreaLight {
+		return types.Vec3{}, 1
+	}
+
+	s := surface{point: point}
+	emissiveSample, emissiveOutRayDir, emissivePdf, distToEmissive := sd.emissiveSample(&s, emissive, sample)
+	phaseWeight = powerHeuristic(phasePdf, sd.emissivePdf(&s, emissive, phaseDir))
+	if maxComponent(emissiveSample) <= 0 || emissivePdf <= 0 {
+		return types.Vec3{}, phaseWeight
+	}
+
+	emissivePhase := m.phase(rayDir, emissiveOutRayDir)
+	emissiveWeight := powerHeuristic(emissivePdf, emissivePhase)
+	radiance = mulVec3(emissiveSample, m.shadowTransmittance(point, emissiveOutRayDir, distToEmissive, rng)).Mul(
+		emissiveWeight * emissivePhase * float32(len(sd.EmissivePrimitives)) / emissivePdf,
+	)
+	if maxComponent(radiance) <= 0 {
+		return types.Vec3{}, phaseWeight
+	}
+
+	shadowRay := ray{
+		origin:  point,
+		dir:     emissiveOutRayDir,
+		maxDist: distToEmissive - intersectionWithLightEpsilon,
+	}
+	if sd.occluded(&shadowRay, &pt.stack) {
+		return types.Vec3{}, phaseWeight
+	}
+	return radiance, phaseWeight
+}
+
+func expf(v float32) float32 {
+	return float32(math.Exp(float64(v)))
+}
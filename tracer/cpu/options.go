@@ -0,0 +1,72 @@
+package cpu
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// A PostProcessFunc is invoked by SyncFramebuffer after the frame accumulator
+// has been tone-mapped into the output frame buffer. Post-process functions
+// typically read the frame via ReadFrame or ReadRadiance and save it.
+type PostProcessFunc func(tr tracer.Tracer, blockReq *tracer.BlockRequest) error
+
+// A TracerOption configures a tracer created via NewTracer.
+type TracerOption func(tr *Tracer) error
+
+// Set the number of goroutines that trace the rows of each block request in
+// parallel. If not specified, one worker per available CPU is used.
+func WithWorkers(numWorkers int) TracerOption {
+	return func(tr *Tracer) error {
+		if numWorkers < 1 {
+			return fmt.Errorf("%s: the number of workers must be at least 1", ErrInvalidOption.Error())
+		}
+		tr.numWorkers = numWorkers
+		return nil
+	}
+}
+
+// Seed the tracer's random number generator. Tracers using the same seed
+// render the same image for the same sequence of block requests, regardless
+// of the number of workers. If not specified, the tracer uses the global
+// random number generator.
+func WithSeed(seed int64) TracerOption {
+	return func(tr *Tracer) error {
+		tr.rng = rand.New(rand.NewSource(seed))
+		return nil
+	}
+}
+
+// Merge the samples of each block request into the frame accumulator using
+// compensated (Kahan) summation. This prevents the precision loss of the
+// single-precision frame accumulator when collecting very high sample counts
+// (100k+ spp) at the cost of an additional frame-sized buffer.
+func WithCompensatedAccumulation() TracerOption {
+	return func(tr *Tracer) error {
+		tr.compensatedAccumulation = true
+		return nil
+	}
+}
+
+// Traverse a compressed version of the scene BVH that collapses groups of
+// binary nodes into 8-wide nodes with quantized child bounds. It matches the
+// traversal used by the opencl tracer when its WithCompressedBvh option is
+// specified.
+func WithCompressedBvh() TracerOption {
+	return func(tr *Tracer) error {
+		tr.compressBvh = true
+		return nil
+	}
+}
+
+// Append a function to the list of functions invoked by SyncFramebuffer.
+func WithPostProcess(fn PostProcessFunc) TracerOption {
+	return func(tr *Tracer) error {
+		if fn == nil {
+			return fmt.Errorf("%s: nil post-process function", ErrInvalidOption.Error())
+		}
+		tr.postProcess = append(tr.postProcess, fn)
+		return nil
+	}
+}
@@ -0,0 +1,224 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Constants shared with the opencl principled bxdf (see CL/bxdf/principled.cl).
+const (
+	principledClearcoatAlpha float32 = 0.05
+	principledClearcoatF0    float32 = 0.04
+	principledSheenTint      float32 = 0.5
+)
+
+// The evaluated parameters of a principled bxdf at a surface point.
+type principledParams struct {
+	baseColor    types.Vec3
+	metallic     float32
+	specular     float32
+	sheen        float32
+	clearcoat    float32
+	transmission float32
+
+	// Perceptual roughness and GGX alpha.
+	roughness float32
+	alpha     float32
+
+	// Surface normal flipped so it faces the incoming ray.
+	normal types.Vec3
+
+	// IORs on the incoming and transmitted side of the surface.
+	etaI, etaT float32
+
+	// Probabilities for sampling the diffuse, specular, clearcoat and
+	// transmission lobes.
+	lobePdf [4]float32
+}
+
+// Evaluate the textures and lobe weights of a principled bxdf.
+func (sd *sceneData) newPrincipledParams(s *surface, b *bxdf, inRayDir types.Vec3) principledParams {
+	p := principledParams{
+		baseColor:    sd.sample3f(s.uv, s.texLod, b.color, b.colorTex),
+		metallic:     clampf(sd.sample1f(s.uv, s.texLod, b.principledWeights[0], b.transmittanceTex), 0, 1),
+		specular:     b.principledWeights[1],
+		sheen:        b.principledWeights[2],
+		clearcoat:    b.principledWeights[3],
+		transmission: b.transmission,
+		roughness:    clampf(sd.sample1f(s.uv, s.texLod, b.roughness, b.roughnessTex), minRoughness, 1),
+		normal:       s.normal,
+		etaI:         b.extIOR,
+		etaT:         b.intIOR,
+	}
+	p.alpha = p.roughness * p.roughness
+
+	// If hitting from the inside we need to flip the normal and swap the eta
+	if inRayDir.Dot(s.normal) < 0 {
+		p.normal = s.normal.Mul(-1)
+		p.etaI, p.etaT = p.etaT, p.etaI
+	}
+
+	dielectricWeight := 1 - p.metallic
+	p.lobePdf = [4]float32{
+		dielectricWeight * (1 - p.transmission),
+		1,
+		0.25 * p.clearcoat,
+		dielectricWeight * p.transmission,
+	}
+	sum := p.lobePdf[0] + p.lobePdf[1] + p.lobePdf[2] + p.lobePdf[3]
+	for i := range p.lobePdf {
+		p.lobePdf[i] /= sum
+	}
+
+	return p
+}
+
+// Sample one of the principled lobes. The returned value and pdf account for
+// all lobes that could have generated the outgoing ray.
+func (sd *sceneData) principledSample(s *surface, b *bxdf, sample types.Vec2, inRayDir types.Vec3) (value, outRayDir types.Vec3, pdf float32) {
+	p := sd.newPrincipledParams(s, b, inRayDir)
+
+	// Select a lobe using sample[0] and remap it back to [0, 1) so it can
+	// be reused for sampling the lobe.
+	lobePdf := p.lobePdf
+	const oneMinusEps = 1 - 1.1920929e-7
+	switch {
+	case sample[0] < lobePdf[0]:
+		sample[0] /= lobePdf[0]
+		outRayDir = cosWeightedHemisphereSample(p.normal, sample)
+	case sample[0] < lobePdf[0]+lobePdf[1]:
+		sample[0] = (sample[0] - lobePdf[0]) / lobePdf[1]
+		h := ggxSample(p.alpha, p.normal, sample)
+		outRayDir = h.Mul(2 * inRayDir.Dot(h)).Sub(inRayDir)
+	case sample[0] < lobePdf[0]+lobePdf[1]+lobePdf[2] || lobePdf[3] <= 0:
+		if lobePdf[2] > 0 {
+			sample[0] = clampf((sample[0]-lobePdf[0]-lobePdf[1])/lobePdf[2], 0, oneMinusEps)
+		} else {
+			sample[0] = 0
+		}
+		h := ggxSample(principledClearcoatAlpha, p.normal, sample)
+		outRayDir = h.Mul(2 * inRayDir.Dot(h)).Sub(inRayDir)
+	default:
+		sample[0] = clampf((sample[0]-lobePdf[0]-lobePdf[1]-lobePdf[2])/lobePdf[3], 0, oneMinusEps)
+		h := ggxSample(p.alpha, p.normal, sample)
+
+		// Refract I over h to get O; rays undergoing total internal
+		// reflection are rejected.
+		eta := p.etaI / p.etaT
+		iDotH := inRayDir.Dot(h)
+		cosTSq := 1 + eta*eta*(iDotH*iDotH-1)
+		if cosTSq <= 0 {
+			return types.Vec3{}, outRayDir, 0
+		}
+		outRayDir = h.Mul(eta*iDotH - sqrtf(cosTSq)).Sub(inRayDir.Mul(eta))
+	}
+
+	return p.eval(inRayDir, outRayDir), outRayDir, p.pdf(inRayDir, outRayDir)
+}
+
+// Calculate the pdf of the lobe mixture for generating outRayDir.
+func (p *principledParams) pdf(inRayDir, outRayDir types.Vec3) float32 {
+	oDotN := outRayDir.Dot(p.normal)
+
+	// This is a reflected ray
+	if oDotN > 0 {
+		h := inRayDir.Add(outRayDir).Normalize()
+		return p.lobePdf[0]*oDotN/math.Pi +
+			p.lobePdf[1]*ggxReflectionPdf(p.alpha, outRayDir, p.normal, h) +
+			p.lobePdf[2]*ggxReflectionPdf(principledClearcoatAlpha, outRayDir, p.normal, h)
+	}
+
+	if p.lobePdf[3] <= 0 {
+		return 0
+	}
+
+	h := refractionHalfVector(p.etaI, p.etaT, inRayDir, outRayDir, p.normal)
+	return p.lobePdf[3] * ggxRefractionPdf(p.alpha, p.etaI, p.etaT, inRayDir, outRayDir, p.normal, h)
+}
+
+// Evaluate the sum of the principled lobes for outRayDir.
+func (p *principledParams) eval(inRayDir, outRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(p.normal)
+	oDotN := outRayDir.Dot(p.normal)
+	if iDotN <= 0 {
+		return types.Vec3{}
+	}
+
+	dielectricWeight := 1 - p.metallic
+
+	// Refracted ray; evaluate the transmission lobe (equation 21)
+	if oDotN < 0 {
+		transmissionWeight := dielectricWeight * p.transmission
+		if transmissionWeight <= 0 {
+			return types.Vec3{}
+		}
+
+		h := refractionHalfVector(p.etaI, p.etaT, inRayDir, outRayDir, p.normal)
+		iDotH := absf(inRayDir.Dot(h))
+		oDotH := absf(outRayDir.Dot(h))
+
+		sum := p.etaI*iDotH + p.etaT*oDotH
+		focusTermDenom := iDotN * oDotN * sum * sum
+		if focusTermDenom == 0 {
+			return types.Vec3{}
+		}
+		focusTerm := absf(p.etaT * p.etaT * iDotH * oDotH / focusTermDenom)
+
+		f := fresnelForDielectric(p.etaI, p.etaT, iDotH)
+		d := ggxD(p.alpha, p.normal, h)
+		g := ggxG(p.alpha, inRayDir, outRayDir, p.normal, h)
+		return p.baseColor.Mul(transmissionWeight * (1 - f) * d * g * focusTerm)
+	}
+
+	h := inRayDir.Add(outRayDir).Normalize()
+	oDotH := outRayDir.Dot(h)
+	fh := schlickWeight(oDotH)
+
+	// Burley diffuse with a retro-reflection term that depends on roughness
+	fd90 := 0.5 + 2*p.roughness*oDotH*oDotH
+	fdI := 1 + (fd90-1)*schlickWeight(iDotN)
+	fdO := 1 + (fd90-1)*schlickWeight(oDotN)
+	value := p.baseColor.Mul(fdI * fdO / math.Pi)
+
+	// Grazing angle sheen optionally tinted by the base color hue
+	if p.sheen > 0 {
+		tint := types.Vec3{1, 1, 1}
+		if lum := luminance(p.baseColor); lum > 0 {
+			tint = p.baseColor.Mul(1 / lum)
+		}
+		sheenColor := types.Vec3{1, 1, 1}.Mul(1 - principledSheenTint).Add(tint.Mul(principledSheenTint))
+		value = value.Add(sheenColor.Mul(p.sheen * fh))
+	}
+	value = value.Mul(dielectricWeight * (1 - p.transmission))
+
+	denom := 4 * iDotN * oDotN
+	if denom <= 0 {
+		return value
+	}
+
+	// GGX specular; metals tint the reflection with the base color
+	dielectricF0 := 0.08 * p.specular
+	f0 := types.Vec3{dielectricF0, dielectricF0, dielectricF0}.Mul(1 - p.metallic).Add(p.baseColor.Mul(p.metallic))
+	f := f0.Add(types.Vec3{1, 1, 1}.Sub(f0).Mul(fh))
+	d := ggxD(p.alpha, p.normal, h)
+	g := ggxG(p.alpha, inRayDir, outRayDir, p.normal, h)
+	value = value.Add(f.Mul(d * g / denom))
+
+	// Clearcoat layer
+	if p.clearcoat > 0 {
+		fc := principledClearcoatF0 + (1-principledClearcoatF0)*fh
+		d = ggxD(principledClearcoatAlpha, p.normal, h)
+		g = ggxG(principledClearcoatAlpha, inRayDir, outRayDir, p.normal, h)
+		value = value.Add(types.Vec3{1, 1, 1}.Mul(0.25 * p.clearcoat * fc * d * g / denom))
+	}
+
+	return value
+}
+
+// Schlick's fresnel weight: (1 - cosTheta)^5
+func schlickWeight(cosTheta float32) float32 {
+	c := clampf(1-cosTheta, 0, 1)
+	c2 := c * c
+	return c2 * c2 * c
+}
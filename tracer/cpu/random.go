@@ -0,0 +1,40 @@
+package cpu
+
+import "github.com/achilleasa/polaris/types"
+
+// A xorshift64* generator for the random samples of a single path. Each
+// path gets its own generator seeded from the sample seed and the pixel index
+// so that rendered frames do not depend on the way that rows are distributed
+// to the tracer workers.
+type pathRng struct {
+	state uint64
+}
+
+// Create a generator for the path traced through a pixel. The seed is mixed
+// using the splitmix64 finalizer so that neighboring pixels get uncorrelated
+// sequences.
+func newPathRng(seed, pixelIndex uint32) pathRng {
+	z := (uint64(seed)<<32 | uint64(pixelIndex)) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+
+	// The xorshift state must never be zero
+	if z == 0 {
+		z = 0x9e3779b97f4a7c15
+	}
+	return pathRng{state: z}
+}
+
+// Generate a random number in the [0, 1) range.
+func (r *pathRng) float() float32 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	return float32((r.state*0x2545f4914f6cdd1d)>>40) / (1 << 24)
+}
+
+// Generate 2 random numbers in the [0, 1) range.
+func (r *pathRng) sample2f() types.Vec2 {
+	return types.Vec2{r.float(), r.float()}
+}
@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The max width of the importance sampling distribution for the scene env map.
+// It matches the distribution used by the opencl tracer.
+const maxEnvMapDistributionWidth = 2048
+
+// The scene data used by the tracer workers. The data is never modified
+// while tracing so it can be shared by all workers.
+type sceneData struct {
+	*scene.Scene
+
+	// The mesh to world space transformation of each mesh instance. The
+	// instance transformations stored in the scene convert from world to
+	// mesh space.
+	meshToWorld []types.Mat4
+
+	// The importance sampling distribution for the scene environment
+	// light or nil if the scene does not define an env map.
+	envMap *scene.EnvMapDistribution
+
+	// The material node index of the env map or -1 if the scene does not
+	// define an environment light with a radiance texture.
+	envMatNodeIndex int32
+
+	// The per-vertex local occlusion used by mixOcclusion material nodes
+	// or nil if the scene materials do not contain any such nodes.
+	vertexOcclusion []float32
+
+	// The per-vertex tangents; zero tangents select an arbitrary tangent
+	// frame for normal and bump maps.
+	vertexTangents []types.Vec4
+
+	// If not nil, the compressed BVH is traversed instead of the scene BVH.
+	compressedBvh *scene.CompressedBvh
+}
+
+// Prepare a scene for rendering. If compressBvh is set, a compressed version of
+// the scene BVH is built and used for traversal.
+func newSceneData(sc *scene.Scene, compressBvh bool) (*sceneData, error) {
+	if len(sc.BvhNodeList) == 0 || len(sc.VertexList) == 0 {
+		return nil, ErrEmptyScene
+	}
+
+	sd := &sceneData{
+		Scene:           sc,
+		meshToWorld:     make([]types.Mat4, len(sc.MeshInstanceList)),
+		envMap:          sc.EnvMapDistribution(maxEnvMapDistributionWidth),
+		envMatNodeIndex: -1,
+		vertexTangents:  sc.VertexTangents(),
+	}
+	for index, instance := range sc.MeshInstanceList {
+		sd.meshToWorld[index] = instance.MeshToWorld()
+	}
+	if sd.envMap != nil {
+		sd.envMatNodeIndex = int32(sd.envMap.MaterialNodeIndex)
+	}
+	sd.vertexOcclusion = sd.bakeLocalOcclusion(sc.LocalOcclusionTargets())
+
+	if compressBvh {
+		var err error
+		if sd.compressedBvh, err = scene.CompressBvh(sc); err != nil {
+			return nil, err
+		}
+	}
+
+	return sd, nil
+}
+
+// Get a copy of the scene data that uses an updated version of the scene with
+// the same layout. Only the instance transformations and the compressed
+// top-level BVH are recalculated.
+func (sd *sceneData) withScene(sc *scene.Scene) (*sceneData, error) {
+	updated := *sd
+	updated.Scene = sc
+	updated.meshToWorld = make([]types.Mat4, len(sc.MeshInstanceList))
+	for index, instance := range sc.MeshInstanceList {
+		updated.meshToWorld[index] = instance.MeshToWorld()
+	}
+	if sd.compressedBvh != nil {
+		updated.compressedBvh = sd.compressedBvh.Copy()
+		if err := updated.compressedBvh.Refit(sc.BvhNodeList[:sc.NumTopLevelBvhNodes()]); err != nil {
+			return nil, err
+		}
+	}
+	return &updated, nil
+}
+
+// Get the material node with the given index or nil if the index is invalid.
+func (sd *sceneData) materialNode(index int32) *scene.MaterialNode {
+	if index < 0 || int(index) >= len(sd.MaterialNodeList) {
+		return nil
+	}
+	return &sd.MaterialNodeList[index]
+}
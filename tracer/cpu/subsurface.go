@@ -0,0 +1,90 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// Constants shared with the opencl subsurface bxdf (see CL/bxdf/subsurface.cl).
+const (
+	subsurfaceMinSpecularPdf float32 = 0.05
+	subsurfaceMaxSpecularPdf float32 = 0.95
+)
+
+// The subsurface bxdf models the boundary of the scattering medium that the
+// compiler attaches to materials using it. Rays hitting the boundary from the
+// outside are either reflected by a rough dielectric coating or diffusely
+// transmitted into the medium where they perform a random walk. Rays that
+// reach the boundary from the inside are diffusely transmitted out of the
+// medium.
+
+// Get the probability for sampling the specular lobe. It follows the fresnel
+// term but is clamped so both lobes are sampled at any incident angle.
+func subsurfaceSpecularPdf(b *bxdf, iDotN float32) float32 {
+	return clampf(fresnelForDielectric(b.extIOR, b.intIOR, iDotN), subsurfaceMinSpecularPdf, subsurfaceMaxSpecularPdf)
+}
+
+// Sample the subsurface bxdf.
+func (sd *sceneData) subsurfaceSample(s *surface, b *bxdf, sample types.Vec2, inRayDir types.Vec3) (value, outRayDir types.Vec3, pdf float32) {
+	iDotN := inRayDir.Dot(s.normal)
+
+	switch specularPdf := subsurfaceSpecularPdf(b, iDotN); {
+	case iDotN <= 0:
+		// Exit the medium
+		outRayDir = cosWeightedHemisphereSample(s.normal, sample)
+	case sample[0] < specularPdf:
+		sample[0] /= specularPdf
+		h := ggxSample(sd.roughness(s, b), s.normal, sample)
+		outRayDir = h.Mul(2 * inRayDir.Dot(h)).Sub(inRayDir)
+	default:
+		// Enter the medium
+		sample[0] = (sample[0] - specularPdf) / (1 - specularPdf)
+		outRayDir = cosWeightedHemisphereSample(s.normal.Mul(-1), sample)
+	}
+
+	return sd.subsurfaceEval(s, b, inRayDir, outRayDir), outRayDir, sd.subsurfacePdf(s, b, inRayDir, outRayDir)
+}
+
+// Get the pdf for generating outRayDir when sampling the subsurface bxdf.
+func (sd *sceneData) subsurfacePdf(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) float32 {
+	iDotN := inRayDir.Dot(s.normal)
+	oDotN := outRayDir.Dot(s.normal)
+
+	switch specularPdf := subsurfaceSpecularPdf(b, iDotN); {
+	case iDotN <= 0:
+		return maxf(0, oDotN) / math.Pi
+	case oDotN > 0:
+		h := inRayDir.Add(outRayDir).Normalize()
+		return specularPdf * ggxReflectionPdf(sd.roughness(s, b), outRayDir, s.normal, h)
+	default:
+		return (1 - specularPdf) * -oDotN / math.Pi
+	}
+}
+
+// Evaluate the subsurface bxdf.
+//
+// BXDF = F * D * G / (4 * cosI * cosO) for reflected rays
+// BXDF = (1 - F) / PI for rays entering the medium
+// BXDF = 1 / PI for rays exiting the medium
+func (sd *sceneData) subsurfaceEval(s *surface, b *bxdf, inRayDir, outRayDir types.Vec3) types.Vec3 {
+	iDotN := inRayDir.Dot(s.normal)
+	oDotN := outRayDir.Dot(s.normal)
+
+	var value float32
+	switch {
+	case iDotN <= 0:
+		if oDotN > 0 {
+			value = 1 / math.Pi
+		}
+	case oDotN > 0:
+		roughness := sd.roughness(s, b)
+		h := inRayDir.Add(outRayDir).Normalize()
+		f := fresnelForDielectric(b.extIOR, b.intIOR, iDotN)
+		value = f * ggxD(roughness, s.normal, h) * ggxG(roughness, inRayDir, outRayDir, s.normal, h) / (4 * iDotN * oDotN)
+	default:
+		value = (1 - fresnelForDielectric(b.extIOR, b.intIOR, iDotN)) / math.Pi
+	}
+
+	return types.Vec3{value, value, value}
+}
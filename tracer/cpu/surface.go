@@ -0,0 +1,112 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/types"
+)
+
+// The shading attributes of a ray intersection. Unlike the opencl kernels
+// which shade intersections using mesh space coordinates, all attributes are
+// expressed in world space.
+type surface struct {
+	// Intersection point.
+	point types.Vec3
+
+	// Interpolated (shading) normal at the intersection point.
+	normal types.Vec3
+
+	// Geometric triangle normal oriented towards the same side as the
+	// vertex normals.
+	geomNormal types.Vec3
+
+	// Interpolated vertex tangent and bitangent handedness (w). A zero
+	// tangent indicates that the mesh does not define tangents.
+	tangent types.Vec4
+
+	// Interpolated uv coordinates.
+	uv types.Vec2
+
+	// The texture LOD used for sampling the surface material textures.
+	texLod float32
+
+	// The root node of the surface material.
+	matNodeIndex int32
+
+	// The approximate mesh space curvature and the local occlusion at the
+	// intersection point; used by curvature and occlusion mix nodes.
+	curvature float32
+	occlusion float32
+}
+
+// Initialize the surface attributes for an intersection.
+func (sd *sceneData) surfaceAt(r *ray, hit *intersection) surface {
+	offset := hit.triIndex * 3
+	vertices := sd.VertexList[offset : offset+3]
+	normals := sd.NormalList[offset : offset+3]
+	uvs := sd.UvList[offset : offset+3]
+	worldToMesh := &sd.MeshInstanceList[hit.meshInstance].Transform
+
+	n := normals[0].Vec3().Mul(hit.w).Add(normals[1].Vec3().Mul(hit.u)).Add(normals[2].Vec3().Mul(hit.v))
+	s := surface{
+		point:        r.origin.Add(r.dir.Mul(hit.t)),
+		normal:       transformNormal(worldToMesh, n).Normalize(),
+		uv:           types.Vec2{uvs[0][0]*hit.w + uvs[1][0]*hit.u + uvs[2][0]*hit.v, uvs[0][1]*hit.w + uvs[1][1]*hit.u + uvs[2][1]*hit.v},
+		texLod:       texLodTopMip,
+		matNodeIndex: int32(sd.MaterialIndex[hit.triIndex]),
+	}
+
+	// Orient the geometric normal using the vertex normals as the triangle
+	// winding order of imported meshes is not always consistent
+	edge01 := vertices[1].Vec3().Sub(vertices[0].Vec3())
+	edge02 := vertices[2].Vec3().Sub(vertices[0].Vec3())
+	geomNormal := edge01.Cross(edge02)
+	if geomNormal.Dot(normals[0].Vec3().Add(normals[1].Vec3()).Add(normals[2].Vec3())) < 0 {
+		geomNormal = geomNormal.Mul(-1)
+	}
+	s.geomNormal = transformNormal(worldToMesh, geomNormal).Normalize()
+	sd.setWearInputs(&s, hit)
+	sd.setTangent(&s, hit, n)
+
+	return s
+}
+
+// Calculate the texture LOD for a ray cone with the given width at the
+// intersection point. The LOD is the log2 of the cone footprint in uv space;
+// it combines the ratio of the triangle uv area to its world space area with
+// the cone width projected on the triangle plane.
+func (sd *sceneData) setTextureLod(s *surface, hit *intersection, inRayDir types.Vec3, coneWidth float32) {
+	offset := hit.triIndex * 3
+	meshToWorld := &sd.meshToWorld[hit.meshInstance]
+	v0 := transformPoint(meshToWorld, sd.VertexList[offset].Vec3())
+	v1 := transformPoint(meshToWorld, sd.VertexList[offset+1].Vec3())
+	v2 := transformPoint(meshToWorld, sd.VertexList[offset+2].Vec3())
+	area := v1.Sub(v0).Cross(v2.Sub(v0)).Len()
+
+	uvEdge1 := sd.UvList[offset+1].Sub(sd.UvList[offset])
+	uvEdge2 := sd.UvList[offset+2].Sub(sd.UvList[offset])
+	uvArea := absf(uvEdge1[0]*uvEdge2[1] - uvEdge1[1]*uvEdge2[0])
+
+	s.texLod = 0.5*log2f(uvArea/area) + log2f(coneWidth/absf(inRayDir.Dot(s.geomNormal)))
+}
+
+// Interpolate the vertex tangents at the intersection point and convert them
+// to world space. As the handedness of the tangent frame flips for mirroring
+// instance transformations, it is recalculated using the transformed
+// bitangent. The mesh space normal at the intersection is passed in n.
+func (sd *sceneData) setTangent(s *surface, hit *intersection, n types.Vec3) {
+	offset := hit.triIndex * 3
+	tangents := sd.vertexTangents[offset : offset+3]
+	t := tangents[0].Vec3().Mul(hit.w).Add(tangents[1].Vec3().Mul(hit.u)).Add(tangents[2].Vec3().Mul(hit.v))
+	if t.Dot(t) == 0 {
+		return
+	}
+
+	meshToWorld := &sd.meshToWorld[hit.meshInstance]
+	bitangent := transformDir(meshToWorld, n.Cross(t).Mul(tangents[0][3]))
+	worldTangent := transformDir(meshToWorld, t)
+
+	var handedness float32 = 1
+	if s.normal.Cross(worldTangent).Dot(bitangent) < 0 {
+		handedness = -1
+	}
+	s.tangent = worldTangent.Vec4(handedness)
+}
@@ -0,0 +1,199 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Select the mip level for sampling a texture. The lod argument is the log2
+// of the ray footprint in uv space; it is converted into a texel footprint
+// using the texture dimensions. The returned level is fractional so that
+// lookups can blend the two nearest levels.
+func selectMipLevel(meta *scene.TextureMetadata, lod float32) float32 {
+	maxLevel := meta.MipLevels
+	if maxLevel > 0 {
+		maxLevel--
+	}
+
+	// NaN footprints (e.g. for triangles without uv coords) select the
+	// top level
+	return minf(maxf(lod+0.5*log2f(float32(meta.Width)*float32(meta.Height)), 0), float32(maxLevel))
+}
+
+// Read a texel and convert it to linear RGB values in the [0, 1] range.
+// Luminance texels are replicated to all channels.
+func (sd *sceneData) texel(format texture.Format, dataOffset, index uint32) types.Vec3 {
+	data := sd.TextureData
+	offset := dataOffset + index*uint32(format.TexelSize())
+	switch format {
+	case texture.Rgba8:
+		return types.Vec3{float32(data[offset]) / 255, float32(data[offset+1]) / 255, float32(data[offset+2]) / 255}
+	case texture.Srgba8:
+		return types.Vec3{
+			srgbTexel(data[offset]),
+			srgbTexel(data[offset+1]),
+			srgbTexel(data[offset+2]),
+		}
+	case texture.Rgba32F:
+		return types.Vec3{readFloat32(data[offset:]), readFloat32(data[offset+4:]), readFloat32(data[offset+8:])}
+	case texture.Luminance8:
+		v := float32(data[offset]) / 255
+		return types.Vec3{v, v, v}
+	case texture.SLuminance8:
+		v := srgbTexel(data[offset])
+		return types.Vec3{v, v, v}
+	case texture.Luminance32F:
+		v := readFloat32(data[offset:])
+		return types.Vec3{v, v, v}
+	}
+	return types.Vec3{}
+}
+
+// Convert an sRGB-encoded 8-bit texel channel to a linear value.
+func srgbTexel(v byte) float32 {
+	return float32(texture.SRGBToLinear(float64(v) / 255))
+}
+
+// Sample a texture at the given uv coordinates. The lod argument selects the
+// mip level to be sampled; fractional levels are sampled by blending the
+// bilinearly filtered texels of the two nearest levels (trilinear filtering).
+// Texture coordinates outside the [0, 1] range wrap around.
+func (sd *sceneData) sampleTexture(uv types.Vec2, lod float32, texIndex int32) types.Vec3 {
+	if texIndex < 0 || int(texIndex) >= len(sd.TextureMetadata) {
+		return types.Vec3{}
+	}
+	meta := &sd.TextureMetadata[texIndex]
+	level := selectMipLevel(meta, lod)
+	level0 := uint32(level)
+
+	sample := sd.bilinearSample(meta, level0, uv)
+
+	// The level is clamped to the last level so a non-zero blend weight
+	// implies that the next level exists
+	if blend := level - float32(level0); blend > 0 {
+		sample = mixVec3(sample, sd.bilinearSample(meta, level0+1, uv), blend)
+	}
+	return sample
+}
+
+// Sample a mip level at the given uv coordinates using bilinear filtering.
+func (sd *sceneData) bilinearSample(meta *scene.TextureMetadata, level uint32, uv types.Vec2) types.Vec3 {
+	dataOffset, width, height := meta.MipLevel(level)
+
+	tx, ty, bx, by, coeffX, coeffY := bilinearTaps(uv, width, height)
+	tl := sd.texel(meta.Format, dataOffset, ty*width+tx)
+	tr := sd.texel(meta.Format, dataOffset, ty*width+bx)
+	bl := sd.texel(meta.Format, dataOffset, by*width+tx)
+	br := sd.texel(meta.Format, dataOffset, by*width+bx)
+
+	return mixVec3(mixVec3(tl, bl, coeffY), mixVec3(tr, br, coeffY), coeffX)
+}
+
+// Sample a single-channel texture. For multi-channel textures only the red
+// channel is read.
+func (sd *sceneData) sampleTexture1f(uv types.Vec2, lod float32, texIndex int32) float32 {
+	return sd.sampleTexture(uv, lod, texIndex)[0]
+}
+
+// Sample a bump map and return the perturbed tangent space normal encoded in
+// the [0, 1] range. Bump maps are always sampled at the top mip level.
+func (sd *sceneData) sampleBumpMap(uv types.Vec2, texIndex int32) types.Vec3 {
+	if texIndex < 0 || int(texIndex) >= len(sd.TextureMetadata) {
+		return types.Vec3{0.5, 0.5, 1}
+	}
+	meta := &sd.TextureMetadata[texIndex]
+	width := meta.Width
+
+	// We need 3 samples to recreate the normal:
+	// s0(tx, ty), s1(tx+1, ty), s2(tx, ty+1)
+	tx, ty, bx, by, _, _ := bilinearTaps(uv, meta.Width, meta.Height)
+	s0 := sd.texel(meta.Format, meta.DataOffset, ty*width+tx)[0]
+	s1 := sd.texel(meta.Format, meta.DataOffset, ty*width+bx)[0]
+	s2 := sd.texel(meta.Format, meta.DataOffset, by*width+tx)[0]
+
+	n := types.Vec3{s1 - s0, s2 - s0, 1}.Normalize()
+	return types.Vec3{0.5, 0.5, 0.5}.Add(n.Mul(0.5))
+}
+
+// Sample the opacity of a texture using bilinear filtering. The alpha channel
+// is used for RGBA textures and the texel value for luminance textures.
+// Opacity is always sampled from the top mip level.
+func (sd *sceneData) sampleOpacity(uv types.Vec2, texIndex int32) float32 {
+	if texIndex < 0 || int(texIndex) >= len(sd.TextureMetadata) {
+		return 1
+	}
+	meta := &sd.TextureMetadata[texIndex]
+	width := meta.Width
+
+	tx, ty, bx, by, coeffX, coeffY := bilinearTaps(uv, meta.Width, meta.Height)
+	tl := sd.opacityTexel(meta, ty*width+tx)
+	tr := sd.opacityTexel(meta, ty*width+bx)
+	bl := sd.opacityTexel(meta, by*width+tx)
+	br := sd.opacityTexel(meta, by*width+bx)
+
+	left := tl + (bl-tl)*coeffY
+	right := tr + (br-tr)*coeffY
+	return left + (right-left)*coeffX
+}
+
+// Read the opacity of a top mip level texel. The alpha channel is always
+// linear.
+func (sd *sceneData) opacityTexel(meta *scene.TextureMetadata, index uint32) float32 {
+	data := sd.TextureData
+	switch meta.Format {
+	case texture.Rgba8, texture.Srgba8:
+		return float32(data[meta.DataOffset+index*4+3]) / 255
+	case texture.Rgba32F:
+		return readFloat32(data[meta.DataOffset+index*16+12:])
+	}
+	return sd.texel(meta.Format, meta.DataOffset, index)[0]
+}
+
+// Get the texel coordinates and interpolation weights for bilinear filtering.
+func bilinearTaps(uv types.Vec2, width, height uint32) (tx, ty, bx, by uint32, coeffX, coeffY float32) {
+	// Keep the fractional part of uv and scale to [0, dims) range
+	su := (uv[0] - float32(math.Floor(float64(uv[0])))) * float32(width)
+	sv := (uv[1] - float32(math.Floor(float64(uv[1])))) * float32(height)
+
+	tx = minUint32(uint32(maxf(su, 0)), width-1)
+	ty = minUint32(uint32(maxf(sv, 0)), height-1)
+	bx = minUint32(tx+1, width-1)
+	by = minUint32(ty+1, height-1)
+	return tx, ty, bx, by, su - float32(tx), sv - float32(ty)
+}
+
+// Sample a material property. If texIndex is -1, the default value is returned.
+func (sd *sceneData) sample3f(uv types.Vec2, lod float32, defaultValue types.Vec3, texIndex int32) types.Vec3 {
+	if texIndex == -1 {
+		return defaultValue
+	}
+	return sd.sampleTexture(uv, lod, texIndex)
+}
+
+// Sample a scalar material property. If texIndex is -1, the default value is
+// returned.
+func (sd *sceneData) sample1f(uv types.Vec2, lod float32, defaultValue float32, texIndex int32) float32 {
+	if texIndex == -1 {
+		return defaultValue
+	}
+	return sd.sampleTexture1f(uv, lod, texIndex)
+}
+
+func mixVec3(a, b types.Vec3, t float32) types.Vec3 {
+	return a.Add(b.Sub(a).Mul(t))
+}
+
+func readFloat32(data []byte) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(data))
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,482 @@
+// Package cpu provides a pure-Go implementation of the tracer.Tracer
+// interface. It renders frames using the same integrator as the default opencl
+// pipeline so it can be used on machines without opencl drivers and as a
+// reference for validating the output of the opencl kernels.
+package cpu
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+type Tracer struct {
+	sync.Mutex
+
+	// The tracer id.
+	id string
+
+	// The number of goroutines used for tracing each block request.
+	numWorkers int
+
+	// An optional random number generator for generating sample seeds.
+	// If nil, the global generator is used.
+	rng *rand.Rand
+
+	// If set, block requests are merged into the frame accumulator
+	// using Kahan summation.
+	compensatedAccumulation bool
+
+	// If set, the tracer traverses a compressed version of the scene BVH.
+	compressBvh bool
+
+	// Functions invoked by SyncFramebuffer.
+	postProcess []PostProcessFunc
+
+	// A buffer for asynchronous updates. Updates are grouped by type and
+	// latest updates always overwrite the previous ones.
+	changeBuffer map[tracer.ChangeType]interface{}
+
+	// Statistics for last rendered frame.
+	stats *tracer.Stats
+
+	// The uploaded scene and camera data.
+	sceneData *sceneData
+	camera    *cameraState
+
+	// Frame dimensions.
+	frameW, frameH uint32
+
+	// The trace accumulator stores the radiance (3 float32 values per
+	// pixel) collected by the last block request while the frame
+	// accumulator stores the radiance merged from all tracers.
+	traceAcc []float32
+	frameAcc []float32
+
+	// The running compensation for the frame accumulator. It is only
+	// allocated if compensated accumulation is enabled.
+	frameComp []float32
+
+	// The tone-mapped output frame.
+	frame *image.RGBA
+}
+
+// Create a new cpu tracer.
+func NewTracer(id string, opts ...TracerOption) (tracer.Tracer, error) {
+	tr := &Tracer{
+		id:           id,
+		numWorkers:   runtime.NumCPU(),
+		changeBuffer: make(map[tracer.ChangeType]interface{}, 0),
+		stats:        &tracer.Stats{},
+	}
+
+	for _, opt := range opts {
+		err := opt(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tr, nil
+}
+
+// Generate a random seed for the traced samples.
+func (tr *Tracer) randUint32() uint32 {
+	if tr.rng != nil {
+		return tr.rng.Uint32()
+	}
+	return rand.Uint32()
+}
+
+// Get tracer id.
+func (tr *Tracer) Id() string {
+	return tr.id
+}
+
+// Get tracer flags.
+func (tr *Tracer) Flags() tracer.Flag {
+	return tracer.Local | tracer.CpuDevice
+}
+
+// Get the computation speed estimate. As there is no meaningful GFlops
+// estimate for the host CPU, the number of workers is used instead.
+func (tr *Tracer) Speed() uint32 {
+	return uint32(tr.numWorkers)
+}
+
+// Initialize tracer.
+func (tr *Tracer) Init() error {
+	return nil
+}
+
+// Shutdown and cleanup tracer.
+func (tr *Tracer) Close() {
+	tr.Lock()
+	defer tr.Unlock()
+
+	tr.sceneData = nil
+	tr.camera = nil
+	tr.traceAcc = nil
+	tr.frameAcc = nil
+	tr.frameComp = nil
+	tr.frame = nil
+}
+
+// Retrieve last frame statistics.
+func (tr *Tracer) Stats() *tracer.Stats {
+	return tr.stats
+}
+
+// Update tracer state.
+func (tr *Tracer) UpdateState(mode tracer.UpdateMode, changeType tracer.ChangeType, data interface{}) (time.Duration, error) {
+	tr.changeBuffer[changeType] = data
+
+	if mode == tracer.Synchronous {
+		return tr.commitChanges()
+	}
+
+	return time.Duration(0), nil
+}
+
+// Commit queued state changes.
+func (tr *Tracer) commitChanges() (time.Duration, error) {
+	if len(tr.changeBuffer) == 0 {
+		return 0, nil
+	}
+
+	var err error
+	start := time.Now()
+	for changeType, data := range tr.changeBuffer {
+		switch changeType {
+		case tracer.FrameDimensions:
+			dims, isDims := data.([2]uint32)
+			if !isDims {
+				err = ErrInvalidChangeData
+				break
+			}
+			tr.resize(dims[0], dims[1])
+		case tracer.SceneData:
+			sc, isScene := data.(*scene.Scene)
+			if !isScene || sc == nil {
+				err = tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+				break
+			}
+			var sd *sceneData
+			sd, err = newSceneData(sc, tr.compressBvh)
+			if err != nil {
+				err = tracer.WrapError(tracer.ErrSceneInvalid, err)
+				break
+			}
+			tr.sceneData = sd
+		case tracer.CameraData:
+			camera, isCamera := data.(*scene.Camera)
+			if !isCamera || camera == nil {
+				err = ErrInvalidChangeData
+				break
+			}
+			tr.camera = newCameraState(camera)
+		case tracer.InstanceData:
+			// Instance updates are applied after any pending scene upload
+			continue
+		default:
+			err = fmt.Errorf("%s %d", ErrUnsupportedChangeType.Error(), changeType)
+		}
+
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	if data, hasUpdate := tr.changeBuffer[tracer.InstanceData]; hasUpdate {
+		err = tr.applyInstanceData(data)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	tr.changeBuffer = make(map[tracer.ChangeType]interface{}, 0)
+	return time.Since(start), nil
+}
+
+// Apply the mesh instance data for the next frame. The scene is shared with
+// other tracers so the update is applied to a copy of it.
+func (tr *Tracer) applyInstanceData(data interface{}) error {
+	update, isUpdate := data.(*scene.InstanceUpdate)
+	if !isUpdate || update == nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+	}
+	if tr.sceneData == nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+	}
+
+	sc, err := tr.sceneData.WithInstanceUpdate(update)
+	if err != nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, err)
+	}
+
+	sd, err := tr.sceneData.withScene(sc)
+	if err != nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, err)
+	}
+	tr.sceneData = sd
+	return nil
+}
+
+// Allocate the accumulation and frame buffers for the given frame dimensions.
+func (tr *Tracer) resize(frameW, frameH uint32) {
+	if tr.frameW == frameW && tr.frameH == frameH && tr.frame != nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	numPixels := int(frameW * frameH)
+	tr.frameW, tr.frameH = frameW, frameH
+	tr.traceAcc = make([]float32, numPixels*3)
+	tr.frameAcc = make([]float32, numPixels*3)
+	if tr.compensatedAccumulation {
+		tr.frameComp = make([]float32, numPixels*3)
+	}
+	tr.frame = image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+}
+
+// Process block request. The block rows are distributed to a pool of
+// goroutines; each pixel sample is traced using a random number sequence that
+// only depends on the sample seed and the pixel index.
+func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return tr.TraceContext(context.Background(), blockReq)
+}
+
+// Process block request. The context is checked before tracing each block
+// row; if it gets cancelled, the remaining rows are skipped and the context
+// error is returned. Implements tracer.ContextTracer.
+func (tr *Tracer) TraceContext(ctx context.Context, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+
+	_, err := tr.commitChanges()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if tr.sceneData == nil {
+		return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+	}
+	if tr.camera == nil {
+		return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrNoCameraData)
+	}
+
+	if blockReq.FrameW != tr.frameW || blockReq.FrameH != tr.frameH {
+		tr.resize(blockReq.FrameW, blockReq.FrameH)
+	}
+	if blockReq.BlockH > blockReq.FrameH || blockReq.BlockY > blockReq.FrameH-blockReq.BlockH {
+		return time.Since(start), ErrInvalidBlock
+	}
+
+	// Clear the trace accumulator rows for this block
+	rowOffset := blockReq.BlockY * blockReq.FrameW * 3
+	rowCount := blockReq.BlockH * blockReq.FrameW * 3
+	traceAcc := tr.traceAcc[rowOffset : rowOffset+rowCount]
+	for index := range traceAcc {
+		traceAcc[index] = 0
+	}
+
+	// Note: blockReq.Seed is updated for each sample in the same way as the
+	// opencl tracer does
+	seeds := make([]uint32, blockReq.SamplesPerPixel)
+	for sample := range seeds {
+		blockReq.Seed = tr.randUint32()
+		seeds[sample] = blockReq.Seed
+	}
+
+	rows := make(chan uint32, blockReq.BlockH)
+	for row := blockReq.BlockY; row < blockReq.BlockY+blockReq.BlockH; row++ {
+		rows <- row
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	wg.Add(tr.numWorkers)
+	for worker := 0; worker < tr.numWorkers; worker++ {
+		go func() {
+			defer wg.Done()
+			pt := newPathTracer(tr.sceneData, tr.camera)
+			for y := range rows {
+				if ctx.Err() != nil {
+					continue
+				}
+				for x := uint32(0); x < blockReq.FrameW; x++ {
+					// Sum the pixel samples in double precision
+					var sum [3]float64
+					for _, seed := range seeds {
+						radiance := pt.tracePath(x, y, seed, blockReq)
+						sum[0] += float64(radiance[0])
+						sum[1] += float64(radiance[1])
+						sum[2] += float64(radiance[2])
+					}
+					offset := (y*blockReq.FrameW + x) * 3
+					tr.traceAcc[offset] = float32(sum[0])
+					tr.traceAcc[offset+1] = float32(sum[1])
+					tr.traceAcc[offset+2] = float32(sum[2])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err = ctx.Err(); err != nil {
+		return time.Since(start), err
+	}
+
+	tr.stats.BlockW = blockReq.BlockW
+	tr.stats.BlockH = blockReq.BlockH
+	tr.stats.RenderTime = time.Since(start)
+	return tr.stats.RenderTime, nil
+}
+
+// Merge the trace accumulator rows for a block request from another tracer
+// into this tracer's frame accumulator. If the request does not include any
+// previously accumulated samples, the frame accumulator rows are overwritten.
+// If compensated accumulation is enabled, rows are added using Kahan
+// summation.
+func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	src, isCpuTracer := other.(*Tracer)
+	if !isCpuTracer {
+		return 0, ErrUnsupportedMerge
+	}
+
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	if blockReq.BlockH > blockReq.FrameH || blockReq.BlockY > blockReq.FrameH-blockReq.BlockH {
+		return time.Since(start), ErrInvalidBlock
+	}
+	rowOffset := blockReq.BlockY * blockReq.FrameW * 3
+	rowCount := blockReq.BlockH * blockReq.FrameW * 3
+	if int(rowOffset+rowCount) > len(tr.frameAcc) || int(rowOffset+rowCount) > len(src.traceAcc) {
+		return time.Since(start), ErrInvalidBlock
+	}
+
+	dst := tr.frameAcc[rowOffset : rowOffset+rowCount]
+	if !tr.compensatedAccumulation {
+		if blockReq.AccumulatedSamples == 0 {
+			copy(dst, src.traceAcc[rowOffset:rowOffset+rowCount])
+		} else {
+			for index, v := range src.traceAcc[rowOffset : rowOffset+rowCount] {
+				dst[index] += v
+			}
+		}
+		return time.Since(start), nil
+	}
+
+	// Add the block samples using Kahan summation
+	comp := tr.frameComp[rowOffset : rowOffset+rowCount]
+	if blockReq.AccumulatedSamples == 0 {
+		for index := range dst {
+			dst[index], comp[index] = 0, 0
+		}
+	}
+	for index, v := range src.traceAcc[rowOffset : rowOffset+rowCount] {
+		y := v - comp[index]
+		sum := dst[index] + y
+		comp[index] = (sum - dst[index]) - y
+		dst[index] = sum
+	}
+
+	return time.Since(start), nil
+}
+
+// Tone-map the frame accumulator into the output frame buffer and run the
+// registered post-process functions.
+func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+
+	if tr.sceneData == nil {
+		return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+	}
+
+	radiance := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+	_, err := tr.ReadRadiance(blockReq, radiance)
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	tr.Lock()
+	err = tracer.Tonemap(tr.frame, radiance, blockReq.FrameW, blockReq.FrameH, tracer.DefaultPostStages(blockReq.Exposure)...)
+	tr.Unlock()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	for _, fn := range tr.postProcess {
+		err = fn(tr, blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the linear radiance stored in the frame accumulator normalized by the
+// total number of accumulated samples.
+func (tr *Tracer) ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	numValues := int(blockReq.FrameW * blockReq.FrameH * 3)
+	if len(out) < numValues || len(tr.frameAcc) < numValues {
+		return time.Since(start), ErrBufferTooSmall
+	}
+
+	weight := blockReq.SampleWeight()
+	for index, v := range tr.frameAcc[:numValues] {
+		out[index] = v * weight
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the linear radiance of a single frame pixel from the frame
+// accumulator. Implements tracer.PixelProber.
+func (tr *Tracer) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	probe, pixel, err := tracer.NewPixelProbe(blockReq, x, y)
+	if err != nil {
+		return probe, err
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	offset := pixel * 3
+	if offset+3 > len(tr.frameAcc) {
+		return probe, ErrBufferTooSmall
+	}
+
+	weight := blockReq.SampleWeight()
+	probe.Radiance = [3]float32{tr.frameAcc[offset] * weight, tr.frameAcc[offset+1] * weight, tr.frameAcc[offset+2] * weight}
+	return probe, nil
+}
+
+// Read the output frame buffer into a user-provided target. See
+// tracer.CopyFrame for the list of supported target types.
+func (tr *Tracer) ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error) {
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	if tr.frame == nil {
+		return time.Since(start), ErrBufferTooSmall
+	}
+
+	return time.Since(start), tracer.CopyFrame(dst, tr.frame.Pix, blockReq.FrameW, blockReq.FrameH)
+}
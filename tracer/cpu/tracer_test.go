@@ -0,0 +1,1008 @@
+package cpu
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+	"github.com/achilleasa/polaris/asset/texure"
+	"github.com/achilleasa/polaris/stattest"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Create a scene with a diffuse quad centered at the origin and facing the
+// camera. The scene is lit by a uniform white background.
+func testScene(albedo float32) *scene.Scene {
+	cam := scene.NewCamera(45)
+	cam.Position = types.XYZ(0, 0, 3)
+	cam.LookAt = types.XYZ(0, 0, 0)
+
+	n := types.XYZW(0, 0, 1, 0)
+	sc := &scene.Scene{
+		BvhNodeList: []scene.BvhNode{
+			// Top-level leaf pointing to mesh instance 0
+			{Min: types.XYZ(-1, -1, 0), Max: types.XYZ(1, 1, 0)},
+			// Bottom-level leaf with both quad triangles
+			{Min: types.XYZ(-1, -1, 0), Max: types.XYZ(1, 1, 0)},
+		},
+		MeshInstanceList: []scene.MeshInstance{
+			{BvhRoot: 1, Transform: types.Ident4()},
+		},
+		MaterialNodeList: []scene.MaterialNode{
+			{
+				Union1: [4]int32{int32(material.BxdfDiffuse), -1, -1, -1},
+				Union2: types.XYZW(albedo, albedo, albedo, 0),
+				Union5: [1]int32{-1},
+			},
+			{
+				Union1: [4]int32{int32(material.BxdfDiffuse), -1, -1, -1},
+				Union2: types.XYZW(1, 1, 1, 0),
+				Union5: [1]int32{-1},
+			},
+		},
+		VertexList: []types.Vec4{
+			types.XYZW(-1, -1, 0, 1), types.XYZW(1, -1, 0, 1), types.XYZW(1, 1, 0, 1),
+			types.XYZW(-1, -1, 0, 1), types.XYZW(1, 1, 0, 1), types.XYZW(-1, 1, 0, 1),
+		},
+		NormalList:             []types.Vec4{n, n, n, n, n, n},
+		UvList:                 []types.Vec2{{0, 0}, {1, 0}, {1, 1}, {0, 0}, {1, 1}, {0, 1}},
+		MaterialIndex:          []uint32{0, 0},
+		SceneDiffuseMatIndex:   1,
+		SceneEmissiveMatIndex:  -1,
+		SceneBackplateMatIndex: -1,
+		SceneMediumMatIndex:    -1,
+		Camera:                 cam,
+	}
+	sc.BvhNodeList[1].SetPrimitives(0, 2)
+	return sc
+}
+
+func newTestTracer(t *testing.T, sc *scene.Scene, frameW, frameH uint32, opts ...TracerOption) tracer.Tracer {
+	sc.Camera.SetupFrame(frameW, frameH)
+
+	tr, err := NewTracer("cpu", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tr.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{frameW, frameH}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.SceneData, sc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.CameraData, sc.Camera); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func renderTestFrame(t *testing.T, tr tracer.Tracer, blockReq *tracer.BlockRequest) []float32 {
+	if _, err := tr.Trace(blockReq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.MergeOutput(tr, blockReq); err != nil {
+		t.Fatal(err)
+	}
+
+	radiance := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+	if _, err := tr.ReadRadiance(blockReq, radiance); err != nil {
+		t.Fatal(err)
+	}
+	return radiance
+}
+
+func TestTraceDiffuseQuad(t *testing.T) {
+	var frameW, frameH uint32 = 32, 32
+	tr := newTestTracer(t, testScene(0.5), frameW, frameH, WithSeed(1), WithWorkers(3))
+	defer tr.Close()
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          frameW,
+		FrameH:          frameH,
+		BlockW:          frameW,
+		BlockH:          frameH,
+		SamplesPerPixel: 4,
+		NumBounces:      2,
+		MinBouncesForRR: 3,
+		Exposure:        1,
+	}
+	radiance := renderTestFrame(t, tr, blockReq)
+
+	// A lambertian surface with albedo 0.5 lit by a uniform white
+	// background reflects half of the incoming radiance. The center pixel
+	// always hits the quad while the corner pixels always miss it.
+	center := (frameH/2*frameW + frameW/2) * 3
+	if got := radiance[center]; math.Abs(float64(got)-0.5) > 1e-3 {
+		t.Errorf("expected center pixel radiance to be 0.5; got %f", got)
+	}
+	if got := radiance[0]; math.Abs(float64(got)-1.0) > 1e-3 {
+		t.Errorf("expected corner pixel radiance to match the background; got %f", got)
+	}
+
+	// Accumulate another pass
+	blockReq.AccumulatedSamples = blockReq.SamplesPerPixel
+	radiance = renderTestFrame(t, tr, blockReq)
+	if got := radiance[center]; math.Abs(float64(got)-0.5) > 1e-3 {
+		t.Errorf("expected accumulated center pixel radiance to be 0.5; got %f", got)
+	}
+
+	// Resetting the accumulated samples overwrites the frame accumulator
+	blockReq.AccumulatedSamples = 0
+	radiance = renderTestFrame(t, tr, blockReq)
+	if got := radiance[center]; math.Abs(float64(got)-0.5) > 1e-3 {
+		t.Errorf("expected frame accumulator to be reset; got center pixel radiance %f", got)
+	}
+}
+
+func TestProbePixel(t *testing.T) {
+	var frameW, frameH uint32 = 16, 16
+	tr := newTestTracer(t, testScene(0.5), frameW, frameH, WithSeed(1), WithWorkers(2))
+	defer tr.Close()
+
+	blockReq := &tracer.BlockRequest{
+		FrameW:          frameW,
+		FrameH:          frameH,
+		BlockW:          frameW,
+		BlockH:          frameH,
+		SamplesPerPixel: 2,
+		NumBounces:      2,
+		MinBouncesForRR: 3,
+	}
+	radiance := renderTestFrame(t, tr, blockReq)
+
+	prober := tr.(tracer.PixelProber)
+	x, y := frameW/2, frameH/2-1
+	probe, err := prober.ProbePixel(blockReq, x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := (y*frameW + x) * 3
+	expRadiance := [3]float32{radiance[offset], radiance[offset+1], radiance[offset+2]}
+	if probe.Radiance != expRadiance {
+		t.Fatalf("expected probed radiance to be %v; got %v", expRadiance, probe.Radiance)
+	}
+	if probe.X != x || probe.Y != y || probe.Samples != 2 {
+		t.Fatalf("expected probe for pixel (%d, %d) with 2 samples; got %+v", x, y, probe)
+	}
+	if probe.RelativeError >= 0 {
+		t.Fatalf("expected relative error to be unavailable; got %f", probe.RelativeError)
+	}
+
+	if _, err = prober.ProbePixel(blockReq, frameW, 0); err != tracer.ErrPixelOutOfBounds {
+		t.Fatalf("expected to get ErrPixelOutOfBounds; got %v", err)
+	}
+}
+
+func TestTraceIsDeterministic(t *testing.T) {
+	var frameW, frameH uint32 = 16, 8
+	blockReq := tracer.BlockRequest{
+		FrameW:          frameW,
+		FrameH:          frameH,
+		BlockW:          frameW,
+		BlockH:          frameH,
+		SamplesPerPixel: 2,
+		NumBounces:      4,
+		MinBouncesForRR: 1,
+	}
+
+	var frames [2][]float32
+	for index, numWorkers := range []int{1, 4} {
+		tr := newTestTracer(t, testScene(0.8), frameW, frameH, WithSeed(42), WithWorkers(numWorkers))
+		req := blockReq
+		frames[index] = renderTestFrame(t, tr, &req)
+		tr.Close()
+	}
+
+	for index := range frames[0] {
+		if frames[0][index] != frames[1][index] {
+			t.Fatalf("expected tracers with the same seed to render the same frame; radiance at index %d is %f and %f", index, frames[0][index], frames[1][index])
+		}
+	}
+}
+
+func TestTraceBlocks(t *testing.T) {
+	var frameW, frameH uint32 = 8, 8
+	primary := newTestTracer(t, testScene(0.5), frameW, frameH, WithSeed(1))
+	secondary := newTestTracer(t, testScene(0.5), frameW, frameH, WithSeed(2))
+
+	blockReq := tracer.BlockRequest{
+		FrameW:          frameW,
+		FrameH:          frameH,
+		BlockW:          frameW,
+		BlockH:          frameH / 2,
+		SamplesPerPixel: 1,
+		NumBounces:      2,
+		MinBouncesForRR: 3,
+	}
+	for index, tr := range []tracer.Tracer{primary, secondary} {
+		req := blockReq
+		req.BlockY = uint32(index) * blockReq.BlockH
+		if _, err := tr.Trace(&req); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := primary.MergeOutput(tr, &req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	radiance := make([]float32, frameW*frameH*3)
+	if _, err := primary.ReadRadiance(&blockReq, radiance); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bottom corner is only traced by the secondary tracer
+	if got := radiance[len(radiance)-3]; math.Abs(float64(got)-1.0) > 1e-3 {
+		t.Fatalf("expected merged bottom corner pixel radiance to match the background; got %f", got)
+	}
+
+	blockReq.BlockY = frameH
+	if _, err := primary.Trace(&blockReq); err != ErrInvalidBlock {
+		t.Fatalf("expected to get ErrInvalidBlock; got %v", err)
+	}
+
+	// BlockY+BlockH must not wrap around
+	blockReq.BlockY, blockReq.BlockH = 0xFFFFFFF8, 0x10
+	if _, err := primary.Trace(&blockReq); err != ErrInvalidBlock {
+		t.Fatalf("expected to get ErrInvalidBlock; got %v", err)
+	}
+	if _, err := primary.MergeOutput(secondary, &blockReq); err != ErrInvalidBlock {
+		t.Fatalf("expected to get ErrInvalidBlock; got %v", err)
+	}
+}
+
+func TestTraceWithoutScene(t *testing.T) {
+	tr, err := NewTracer("cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tr.Trace(&tracer.BlockRequest{FrameW: 1, FrameH: 1, BlockW: 1, BlockH: 1, SamplesPerPixel: 1})
+	if tracer.ErrorKind(err) != tracer.ErrSceneInvalid {
+		t.Fatalf("expected to get a scene error; got %v", err)
+	}
+
+	if _, err = NewTracer("cpu", WithWorkers(0)); err == nil {
+		t.Fatal("expected to get an error when specifying 0 workers")
+	}
+}
+
+func TestTraceContextCancellation(t *testing.T) {
+	var frameW, frameH uint32 = 8, 8
+	tr := newTestTracer(t, testScene(0.5), frameW, frameH, WithWorkers(2))
+	defer tr.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	blockReq := &tracer.BlockRequest{FrameW: frameW, FrameH: frameH, BlockW: frameW, BlockH: frameH, SamplesPerPixel: 1, NumBounces: 1}
+	if _, err := tracer.TraceContext(ctx, tr, blockReq); err != context.Canceled {
+		t.Fatalf("expected to get context.Canceled; got %v", err)
+	}
+
+	if _, err := tracer.TraceContext(context.Background(), tr, blockReq); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceInstanceUpdate(t *testing.T) {
+	var frameW, frameH uint32 = 16, 16
+	sc := testScene(0.5)
+	tr := newTestTracer(t, sc, frameW, frameH, WithSeed(1), WithWorkers(2))
+	defer tr.Close()
+
+	// Move the quad out of the camera view
+	if err := sc.SetInstanceTransform(0, types.Translate4(types.Vec3{10, 0, 0})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.UpdateState(tracer.Asynchronous, tracer.InstanceData, sc.InstanceUpdate()); err != nil {
+		t.Fatal(err)
+	}
+
+	blockReq := &tracer.BlockRequest{FrameW: frameW, FrameH: frameH, BlockW: frameW, BlockH: frameH, SamplesPerPixel: 1, NumBounces: 2, MinBouncesForRR: 3}
+	radiance := renderTestFrame(t, tr, blockReq)
+	center := (frameH/2*frameW + frameW/2) * 3
+	if got := radiance[center]; math.Abs(float64(got)-1.0) > 1e-3 {
+		t.Fatalf("expected center pixel radiance to match the background; got %f", got)
+	}
+
+	// Updates for a scene with a different layout should be rejected
+	update := sc.InstanceUpdate()
+	update.MeshInstanceList = nil
+	if _, err := tr.UpdateState(tracer.Synchronous, tracer.InstanceData, update); tracer.ErrorKind(err) != tracer.ErrSceneInvalid {
+		t.Fatalf("expected to get a scene error; got %v", err)
+	}
+}
+
+func TestMergeOutputCompensated(t *testing.T) {
+	blockReq := tracer.BlockRequest{FrameW: 1, FrameH: 1, BlockW: 1, BlockH: 1}
+
+	var merged [2]float32
+	for index, opts := range [][]TracerOption{nil, {WithCompensatedAccumulation()}} {
+		trIface, err := NewTracer("cpu", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := trIface.(*Tracer)
+		tr.resize(1, 1)
+		tr.traceAcc[0] = 0.1
+
+		// Merge a million passes with a bright sample
+		const numPasses = 1000000
+		for pass := 0; pass < numPasses; pass++ {
+			blockReq.AccumulatedSamples = uint32(pass)
+			if _, err = tr.MergeOutput(tr, &blockReq); err != nil {
+				t.Fatal(err)
+			}
+		}
+		merged[index] = tr.frameAcc[0]
+	}
+
+	if math.Abs(float64(merged[0])-100000) < 100 {
+		t.Fatalf("expected the uncompensated sum to drift; got %f", merged[0])
+	}
+	if math.Abs(float64(merged[1])-100000) > 0.01 {
+		t.Fatalf("expected the compensated sum to be 100000; got %f", merged[1])
+	}
+}
+
+func TestPrincipledBxdfConsistency(t *testing.T) {
+	sd := &sceneData{}
+	s := &surface{normal: types.XYZ(0, 0, 1), texLod: texLodTopMip}
+	inRayDir := types.XYZ(0.3, 0, 1).Normalize()
+
+	specs := []scene.MaterialNode{
+		// Opaque dielectric with sheen and clearcoat
+		{
+			Union1: [4]int32{int32(material.BxdfPrincipled), -1, -1, -1},
+			Union2: types.XYZW(0.8, 0.5, 0.2, 0),
+			Union3: types.XYZW(0, 0.5, 0.5, 1),
+			Union4: types.XYZ(1.5, 1, 0.5),
+			Union5: [1]int32{-1},
+		},
+		// Rough glass
+		{
+			Union1: [4]int32{int32(material.BxdfPrincipled), -1, -1, -1},
+			Union2: types.XYZW(1, 1, 1, 1),
+			Union3: types.XYZW(0, 0.5, 0, 0),
+			Union4: types.XYZ(1.5, 1, 0.3),
+			Union5: [1]int32{-1},
+		},
+	}
+
+	for specIndex, node := range specs {
+		b := newBxdf(&node)
+		rng := newPathRng(0, uint32(specIndex))
+
+		var reflected float32
+		const numSamples = 20000
+		for i := 0; i < numSamples; i++ {
+			value, outRayDir, pdf := sd.bxdfSample(s, &b, rng.sample2f(), inRayDir)
+			if pdf <= 0 {
+				continue
+			}
+
+			if expPdf := sd.bxdfPdf(s, &b, inRayDir, outRayDir); math.Abs(float64(expPdf-pdf)) > 1e-3*float64(pdf) {
+				t.Fatalf("[spec %d] expected sample pdf %f to match bxdfPdf %f", specIndex, pdf, expPdf)
+			}
+			if expValue := sd.bxdfEval(s, &b, inRayDir, outRayDir); !types.ApproxEqual(value, expValue, 1e-4) {
+				t.Fatalf("[spec %d] expected sample value %v to match bxdfEval %v", specIndex, value, expValue)
+			}
+			reflected += luminance(value) * absf(outRayDir.Dot(s.normal)) / pdf
+		}
+
+		// The bxdf should not create energy
+		if albedo := reflected / numSamples; albedo <= 0 || albedo > 1.05 {
+			t.Errorf("[spec %d] expected the estimated albedo to be in the (0, 1.05] range; got %f", specIndex, albedo)
+		}
+	}
+}
+
+func TestRoughDielectricBxdfConsistency(t *testing.T) {
+	sd := &sceneData{}
+	s := &surface{normal: types.XYZ(0, 0, 1), texLod: texLodTopMip}
+	glass := scene.MaterialNode{
+		Union1: [4]int32{int32(material.BxdfRoughDielectric), -1, -1, -1},
+		Union2: types.XYZW(1, 1, 1, 0),
+		Union3: types.XYZW(1, 1, 1, 0),
+		Union4: types.XYZ(1.5, 1, 0.3),
+		Union5: [1]int32{-1},
+	}
+
+	specs := []types.Vec3{
+		// Entering the glass
+		types.XYZ(0.3, 0, 1).Normalize(),
+		// Exiting the glass
+		types.XYZ(0.3, 0, -1).Normalize(),
+		// Exiting the glass beyond the critical angle
+		types.XYZ(1, 0, -0.3).Normalize(),
+	}
+
+	b := newBxdf(&glass)
+	for specIndex, inRayDir := range specs {
+		rng := newPathRng(0, uint32(specIndex))
+
+		var scattered float32
+		var numRefracted int
+		const numSamples = 20000
+		for i := 0; i < numSamples; i++ {
+			value, outRayDir, pdf := sd.bxdfSample(s, &b, rng.sample2f(), inRayDir)
+			if pdf <= 0 {
+				continue
+			}
+
+			if expPdf := sd.bxdfPdf(s, &b, inRayDir, outRayDir); math.Abs(float64(expPdf-pdf)) > 1e-3*float64(pdf) {
+				t.Fatalf("[spec %d] expected sample pdf %f to match bxdfPdf %f", specIndex, pdf, expPdf)
+			}
+			if expValue := sd.bxdfEval(s, &b, inRayDir, outRayDir); !types.ApproxEqual(value, expValue, 1e-4) {
+				t.Fatalf("[spec %d] expected sample value %v to match bxdfEval %v", specIndex, value, expValue)
+			}
+			if inRayDir.Dot(s.normal)*outRayDir.Dot(s.normal) < 0 {
+				numRefracted++
+			}
+			scattered += luminance(value) * absf(outRayDir.Dot(s.normal)) / pdf
+		}
+
+		if numRefracted == 0 {
+			t.Errorf("[spec %d] expected some of the samples to be refracted", specIndex)
+		}
+
+		// The bxdf should neither create nor lose a significant amount of energy
+		if albedo := scattered / numSamples; albedo < 0.8 || albedo > 1.05 {
+			t.Errorf("[spec %d] expected the estimated albedo to be in the [0.8, 1.05] range; got %f", specIndex, albedo)
+		}
+	}
+}
+
+func TestSubsurfaceBxdfConsistency(t *testing.T) {
+	sd := &sceneData{}
+	s := &surface{normal: types.XYZ(0, 0, 1), texLod: texLodTopMip}
+	skin := scene.MaterialNode{
+		Union1: [4]int32{int32(material.BxdfSubsurface), -1, -1, -1},
+		Union2: types.XYZW(0.8, 0.5, 0.3, 0),
+		Union3: types.XYZW(0.5, 0.25, 0.1, 0),
+		Union4: types.XYZ(1.4, 1, 0.3),
+		Union5: [1]int32{-1},
+	}
+
+	specs := []struct {
+		inRayDir        types.Vec3
+		expAllRefracted bool
+	}{
+		// Reflected by the coating or entering the medium
+		{types.XYZ(0.3, 0, 1).Normalize(), false},
+		// Rays hitting the boundary from the inside always exit the medium
+		{types.XYZ(0.3, 0, -1).Normalize(), true},
+	}
+
+	b := newBxdf(&skin)
+	for specIndex, spec := range specs {
+		rng := newPathRng(0, uint32(specIndex))
+
+		var scattered float32
+		var numRefracted int
+		const numSamples = 20000
+		for i := 0; i < numSamples; i++ {
+			value, outRayDir, pdf := sd.bxdfSample(s, &b, rng.sample2f(), spec.inRayDir)
+			if pdf <= 0 {
+				continue
+			}
+
+			if expPdf := sd.bxdfPdf(s, &b, spec.inRayDir, outRayDir); math.Abs(float64(expPdf-pdf)) > 1e-3*float64(pdf) {
+				t.Fatalf("[spec %d] expected sample pdf %f to match bxdfPdf %f", specIndex, pdf, expPdf)
+			}
+			if expValue := sd.bxdfEval(s, &b, spec.inRayDir, outRayDir); !types.ApproxEqual(value, expValue, 1e-4) {
+				t.Fatalf("[spec %d] expected sample value %v to match bxdfEval %v", specIndex, value, expValue)
+			}
+			if spec.inRayDir.Dot(s.normal)*outRayDir.Dot(s.normal) < 0 {
+				numRefracted++
+			}
+			scattered += luminance(value) * absf(outRayDir.Dot(s.normal)) / pdf
+		}
+
+		if numRefracted == 0 {
+			t.Errorf("[spec %d] expected some of the samples to be refracted", specIndex)
+		} else if spec.expAllRefracted && numRefracted != numSamples {
+			t.Errorf("[spec %d] expected all samples to be refracted; got %d out of %d", specIndex, numRefracted, numSamples)
+		}
+
+		// The bxdf should neither create nor lose a significant amount of energy
+		if albedo := scattered / numSamples; albedo < 0.9 || albedo > 1.05 {
+			t.Errorf("[spec %d] expected the estimated albedo to be in the [0.9, 1.05] range; got %f", specIndex, albedo)
+		}
+	}
+}
+
+func TestTangentFrame(t *testing.T) {
+	n := types.XYZ(0, 0, 1)
+	arbitraryU, arbitraryV := tangentVectors(n)
+	specs := []struct {
+		tangent    types.Vec4
+		expU, expV types.Vec3
+	}{
+		{types.XYZW(1, 0, 0, 1), types.XYZ(1, 0, 0), types.XYZ(0, 1, 0)},
+		{types.XYZW(1, 0, 0, -1), types.XYZ(1, 0, 0), types.XYZ(0, -1, 0)},
+		// Tangents are projected to the plane of the normal
+		{types.XYZW(0, 2, 2, 1), types.XYZ(0, 1, 0), types.XYZ(-1, 0, 0)},
+		// Zero and parallel tangents select an arbitrary frame
+		{types.Vec4{}, arbitraryU, arbitraryV},
+		{types.XYZW(0, 0, 1, 1), arbitraryU, arbitraryV},
+	}
+
+	for index, spec := range specs {
+		u, v := tangentFrame(n, spec.tangent)
+		if !types.ApproxEqual(u, spec.expU, 1e-5) || !types.ApproxEqual(v, spec.expV, 1e-5) {
+			t.Errorf("[spec %d] expected frame (%v, %v); got (%v, %v)", index, spec.expU, spec.expV, u, v)
+		}
+	}
+}
+
+func TestTrilinearTextureSampling(t *testing.T) {
+	// A 2x2 black texture whose 1x1 mip level is white
+	sd := &sceneData{Scene: &scene.Scene{
+		TextureMetadata: []scene.TextureMetadata{
+			{Format: texture.Luminance8, Width: 2, Height: 2, MipLevels: 2},
+		},
+		TextureData: []byte{0, 0, 0, 0, 255, 0, 0, 0},
+	}}
+
+	// The footprint of a texel at the top level is log2(1/2)
+	specs := []struct {
+		lod float32
+		exp float32
+	}{
+		{texLodTopMip, 0},
+		{-1, 0},
+		{-0.75, 0.25},
+		{-0.5, 0.5},
+		{0, 1},
+		{4, 1},
+	}
+	for _, spec := range specs {
+		got := sd.sampleTexture1f(types.Vec2{0.25, 0.25}, spec.lod, 0)
+		if math.Abs(float64(got-spec.exp)) > 1e-5 {
+			t.Errorf("[lod %f] expected sample to be %f; got %f", spec.lod, spec.exp, got)
+		}
+	}
+}
+
+func TestAlphaCutoutIntersection(t *testing.T) {
+	// Cut out the left side of the quad using a 2x1 texture whose left
+	// texel is transparent
+	sc := testScene(0.5)
+	sc.MaterialNodeList = append(sc.MaterialNodeList, scene.MaterialNode{
+		Union1: [4]int32{int32(material.OpAlphaCutout), 0, -1, 0},
+		Union2: types.XYZW(0.5, 0, 0, 0),
+		Union5: [1]int32{-1},
+	})
+	sc.MaterialIndex = []uint32{2, 2}
+	sc.TextureMetadata = []scene.TextureMetadata{
+		{Format: texture.Srgba8, Width: 2, Height: 1, MipLevels: 1},
+	}
+	sc.TextureData = []byte{255, 255, 255, 0, 255, 255, 255, 255}
+	sd := &sceneData{Scene: sc}
+
+	specs := []struct {
+		x      float32
+		flags  scene.MeshInstanceFlag
+		expHit bool
+	}{
+		{-0.8, scene.AlphaCutout, false},
+		{0.5, scene.AlphaCutout, true},
+		// Without the mesh flag the opacity texture is not sampled
+		{-0.8, 0, true},
+	}
+
+	stack := make([]uint32, 0, bvhStackSize)
+	for index, spec := range specs {
+		sc.MeshInstanceList[0].Flags = spec.flags
+		r := &ray{origin: types.XYZ(spec.x, 0, 3), dir: types.XYZ(0, 0, -1), maxDist: maxFloat}
+
+		var hit intersection
+		if got := sd.intersect(r, 0, &stack, &hit); got != spec.expHit {
+			t.Errorf("[spec %d] expected intersect to return %t; got %t", index, spec.expHit, got)
+		}
+		if got := sd.occluded(r, &stack); got != spec.expHit {
+			t.Errorf("[spec %d] expected occluded to return %t; got %t", index, spec.expHit, got)
+		}
+	}
+}
+
+// Create a scene with three instances of a mesh with randomly placed triangles.
+func randomTriangleScene(t *testing.T, rng *rand.Rand, numTriangles int) *scene.Scene {
+	sc := &scene.Scene{
+		BvhNodeList: make([]scene.BvhNode, 5),
+		MeshInstanceList: []scene.MeshInstance{
+			{BvhRoot: 5}, {BvhRoot: 5}, {BvhRoot: 5},
+		},
+		MaterialIndex: make([]uint32, numTriangles),
+	}
+
+	// Triangles are sorted along the X axis so splitting the triangle
+	// list in halves yields a reasonable mesh BVH.
+	for tri := 0; tri < numTriangles; tri++ {
+		center := types.XYZ(-1+2*float32(tri)/float32(numTriangles), 0, 0)
+		for vertex := 0; vertex < 3; vertex++ {
+			offset := types.XYZ(rng.Float32()*0.4-0.2, rng.Float32()*2-1, rng.Float32()*2-1)
+			sc.VertexList = append(sc.VertexList, center.Add(offset).Vec4(1))
+		}
+	}
+
+	var buildMeshBvh func(first, count uint32) uint32
+	buildMeshBvh = func(first, count uint32) uint32 {
+		nodeIndex := uint32(len(sc.BvhNodeList))
+		sc.BvhNodeList = append(sc.BvhNodeList, scene.BvhNode{})
+		if count <= 2 {
+			bbox := [2]types.Vec3{sc.VertexList[first*3].Vec3(), sc.VertexList[first*3].Vec3()}
+			for _, v := range sc.VertexList[first*3 : (first+count)*3] {
+				bbox[0], bbox[1] = types.MinVec3(bbox[0], v.Vec3()), types.MaxVec3(bbox[1], v.Vec3())
+			}
+			sc.BvhNodeList[nodeIndex].SetBBox(bbox)
+			sc.BvhNodeList[nodeIndex].SetPrimitives(first, count)
+			return nodeIndex
+		}
+
+		left := buildMeshBvh(first, count/2)
+		right := buildMeshBvh(first+count/2, count-count/2)
+		node := &sc.BvhNodeList[nodeIndex]
+		node.SetBBox([2]types.Vec3{
+			types.MinVec3(sc.BvhNodeList[left].Min, sc.BvhNodeList[right].Min),
+			types.MaxVec3(sc.BvhNodeList[left].Max, sc.BvhNodeList[right].Max),
+		})
+		node.SetChildNodes(left, right)
+		return nodeIndex
+	}
+	buildMeshBvh(0, uint32(numTriangles))
+
+	// Top-level BVH: root -> (instance 0, (instance 1, instance 2))
+	sc.BvhNodeList[0].SetChildNodes(1, 2)
+	sc.BvhNodeList[1].SetMeshIndex(0)
+	sc.BvhNodeList[2].SetChildNodes(3, 4)
+	sc.BvhNodeList[3].SetMeshIndex(1)
+	sc.BvhNodeList[4].SetMeshIndex(2)
+	for instance, offset := range []types.Vec3{{0, 0, 0}, {0, 3, 0}, {1, -3, 1}} {
+		if err := sc.SetInstanceTransform(instance, types.Translate4(offset).Mul4(types.Scale4(types.Vec3{1, 1, 1.5}))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sc
+}
+
+func TestCompressedBvhTraversal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sc := randomTriangleScene(t, rng, 200)
+
+	binary, err := newSceneData(sc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := newSceneData(sc, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compareTraversals := func(binary, compressed *sceneData) {
+		stack := make([]uint32, 0, bvhStackSize)
+		var numHits int
+		for index := 0; index < 2000; index++ {
+			target := types.XYZ(rng.Float32()*4-2, rng.Float32()*8-4, rng.Float32()*4-2)
+			origin := types.XYZ(rng.Float32()*16-8, rng.Float32()*16-8, rng.Float32()*16-8)
+			r := &ray{origin: origin, dir: target.Sub(origin).Normalize(), maxDist: maxFloat}
+
+			var expHit, gotHit intersection
+			expOk := binary.intersect(r, 0, &stack, &expHit)
+			gotOk := compressed.intersect(r, 0, &stack, &gotHit)
+			if expOk != gotOk || expHit != gotHit {
+				t.Fatalf("[ray %d] expected compressed traversal to return %t, %+v; got %t, %+v", index, expOk, expHit, gotOk, gotHit)
+			}
+			if got := compressed.occluded(r, &stack); got != expOk {
+				t.Fatalf("[ray %d] expected compressed occlusion test to return %t; got %t", index, expOk, got)
+			}
+			if expOk {
+				numHits++
+			}
+		}
+		if numHits == 0 {
+			t.Fatal("expected some of the rays to hit the scene geometry")
+		}
+	}
+	compareTraversals(binary, compressed)
+
+	// The compressed top-level BVH should be refitted after an instance update
+	if err = sc.SetInstanceTransform(2, types.Translate4(types.Vec3{-1, 0, -2})); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := sc.WithInstanceUpdate(sc.InstanceUpdate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary, err = binary.withScene(updated); err != nil {
+		t.Fatal(err)
+	}
+	if compressed, err = compressed.withScene(updated); err != nil {
+		t.Fatal(err)
+	}
+	compareTraversals(binary, compressed)
+}
+
+func TestHenyeyGreensteinSampling(t *testing.T) {
+	rayDir := types.XYZ(0.2, -1, 0.4).Normalize()
+	for specIndex, g := range []float32{0, 0.6, -0.8} {
+		m := medium{g: g}
+		rng := newPathRng(0, uint32(specIndex))
+
+		var meanCos float32
+		const numSamples = 20000
+		for i := 0; i < numSamples; i++ {
+			outRayDir, pdf := m.samplePhase(rayDir, rng.sample2f())
+			if expPdf := m.phase(rayDir, outRayDir); math.Abs(float64(expPdf-pdf)) > 1e-3*float64(pdf) {
+				t.Fatalf("[g %f] expected sample pdf %f to match the phase function value %f", g, pdf, expPdf)
+			}
+			meanCos += rayDir.Dot(outRayDir)
+		}
+
+		// The mean cosine of the scattered directions equals g
+		if got := meanCos / numSamples; math.Abs(float64(got-g)) > 0.02 {
+			t.Errorf("[g %f] expected the mean scattering cosine to be %f; got %f", g, g, got)
+		}
+	}
+}
+
+func TestEquiAngularSampling(t *testing.T) {
+	origin := types.XYZ(0, 0, 0)
+	rayDir := types.XYZ(1, 0, 0)
+	const tMax, numBins, numSamples = 4, 32, 50000
+
+	specs := []types.Vec3{
+		types.XYZ(2, 1, 0),    // light beside the segment
+		types.XYZ(-1, 0.5, 0), // light behind the ray origin
+		types.XYZ(6, 0, 0.25), // light past the segment end
+		types.XYZ(1, 0.01, 0), // light close to the ray
+	}
+	significance := stattest.SidakSignificance(0.001, len(specs))
+	for specIndex, lightPos := range specs {
+		// The pdf must integrate to 1 over the segment
+		expected := make([]float64, numBins)
+		var total float64
+		const steps = 200
+		for bin := range expected {
+			for step := 0; step < steps; step++ {
+				dist := (float32(bin) + (float32(step)+0.5)/steps) * tMax / numBins
+				expected[bin] += float64(equiAngularPdf(origin, rayDir, tMax, lightPos, dist)) * tMax / (numBins * steps)
+			}
+			total += expected[bin]
+		}
+		if math.Abs(total-1) > 1e-2 {
+			t.Errorf("[spec %d] expected the equi-angular pdf to integrate to 1; got %f", specIndex, total)
+		}
+
+		// The sampled distances must follow the pdf
+		rng := newPathRng(0, uint32(specIndex))
+		observed := make([]int, numBins)
+		for i := 0; i < numSamples; i++ {
+			dist, pdf := equiAngularSample(origin, rayDir, tMax, lightPos, rng.float())
+			if expPdf := equiAngularPdf(origin, rayDir, tMax, lightPos, dist); math.Abs(float64(expPdf-pdf)) > 1e-3*float64(pdf) {
+				t.Fatalf("[spec %d] expected sample pdf %f to match the evaluated pdf %f", specIndex, pdf, expPdf)
+			}
+			bin := int(dist / tMax * numBins)
+			if bin >= numBins {
+				bin = numBins - 1
+			}
+			observed[bin]++
+		}
+		res, err := stattest.ChiSquare(observed, expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reject(significance) {
+			t.Errorf("[spec %d] expected sampled distances to follow the equi-angular pdf; %s", specIndex, res)
+		}
+	}
+
+	// Combining the strategies must not bias the estimate of the scattered
+	// and transmitted energy along the segment.
+	m := medium{
+		sigmaA: types.XYZ(0.2, 0.2, 0.2),
+		sigmaS: types.XYZ(0.3, 0.3, 0.3),
+		sigmaT: types.XYZ(0.5, 0.5, 0.5),
+	}
+	tr := math.Exp(-0.5 * tMax)
+	expMean := 0.3/0.5*(1-tr) + tr
+	rng := newPathRng(1, 0)
+	estimates := make([]float64, numSamples)
+	for i := range estimates {
+		_, weight, _ := m.sampleDistanceEquiAngular(origin, rayDir, tMax, specs[0], rng.sample2f())
+		estimates[i] = float64(weight[0])
+	}
+	res, err := stattest.TTest(estimates, expMean)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Reject(0.001) {
+		t.Errorf("expected the mean of the combined distance sampling weights to be %f; %s", expMean, res)
+	}
+}
+
+func TestTraceMedia(t *testing.T) {
+	var frameW, frameH uint32 = 16, 16
+	blockReq := &tracer.BlockRequest{
+		FrameW:          frameW,
+		FrameH:          frameH,
+		BlockW:          frameW,
+		BlockH:          frameH,
+		SamplesPerPixel: 16,
+		NumBounces:      64,
+		MinBouncesForRR: 64,
+		Exposure:        1,
+	}
+	center := (frameH/2*frameW + frameW/2) * 3
+
+	// The background seen through an absorbing box is attenuated by the
+	// Beer-Lambert law.
+	const absorption = 0.5
+	sc, err := testscenes.Compile(testscenes.AbsorbingBox(absorption))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newTestTracer(t, sc, frameW, frameH, WithSeed(1))
+	radiance := renderTestFrame(t, tr, blockReq)
+	tr.Close()
+
+	dir := sc.Camera.PixelRay(float32(frameW/2)+0.5, float32(frameH/2)+0.5, frameW, frameH)
+	exp := testscenes.AbsorbingBoxRadiance(absorption, sc.Camera.Position, dir)
+	if got := radiance[center]; math.Abs(float64(got-exp)) > 1e-2 {
+		t.Errorf("expected center pixel radiance of the absorbing box to be %f; got %f", exp, got)
+	}
+
+	// A non-absorbing box is invisible in a furnace as every path that
+	// enters it eventually escapes to the background.
+	sc, err = testscenes.Compile(testscenes.ScatteringBox(0.5, 0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr = newTestTracer(t, sc, frameW, frameH, WithSeed(1))
+	radiance = renderTestFrame(t, tr, blockReq)
+	tr.Close()
+
+	if got := radiance[center]; math.Abs(float64(got-testscenes.FurnaceBackground)) > 1e-3 {
+		t.Errorf("expected center pixel radiance of the scattering box to be %f; got %f", testscenes.FurnaceBackground, got)
+	}
+
+	// A constant density grid scales the absorption of the box. As delta
+	// tracking either absorbs a path or lets it through, more samples are
+	// needed.
+	sc, err = testscenes.Compile(testscenes.AbsorbingBox(absorption))
+	if err != nil {
+		t.Fatal(err)
+	}
+	constantDensityGrid(sc, 0.5)
+	tr = newTestTracer(t, sc, frameW, frameH, WithSeed(1))
+	blockReq.SamplesPerPixel = 4096
+	radiance = renderTestFrame(t, tr, blockReq)
+	tr.Close()
+
+	exp = testscenes.AbsorbingBoxRadiance(0.5*absorption, sc.Camera.Position, dir)
+	if got := radiance[center]; math.Abs(float64(got-exp)) > 3e-2 {
+		t.Errorf("expected center pixel radiance of the heterogeneous absorbing box to be %f; got %f", exp, got)
+	}
+}
+
+// Attach a density grid with a constant value inside the medium box of the
+// compiled medium scenes to the box medium.
+func constantDensityGrid(sc *scene.Scene, density float32) {
+	h := testscenes.MediumBoxHalfSize
+	sc.VolumeGrids = []scene.VolumeGrid{{
+		Transform: types.Scale4(types.XYZ(0.5/h, 0.5/h, 0.5/h)).Mul4(types.Translate4(types.XYZ(h, h, h))),
+		MaxValue:  density,
+		Dims:      [3]uint32{2, 2, 2},
+	}}
+	sc.VolumeData = make([]float32, 8)
+	for index := range sc.VolumeData {
+		sc.VolumeData[index] = density
+	}
+	for index, node := range sc.MaterialNodeList {
+		if material.OpType(node.Union1[0]) == material.OpMedium {
+			sc.MaterialNodeList[index].Union1[2] = 0
+		}
+	}
+}
+
+func TestHeterogeneousMediumTracking(t *testing.T) {
+	const numSamples = 4096
+	origin, rayDir := types.XYZ(0, 0, -1), types.XYZ(0, 0, 1)
+
+	// A grid with a constant value of 0.5 in the [-1, 1] box and a loose
+	// majorant so that tracking also samples null collisions.
+	grid := &volumeGrid{
+		VolumeGrid: &scene.VolumeGrid{
+			Transform: types.Scale4(types.XYZ(0.5, 0.5, 0.5)).Mul4(types.Translate4(types.XYZ(1, 1, 1))),
+			Scale:     types.XYZ(2, 2, 2),
+			MaxValue:  1,
+			Dims:      [3]uint32{2, 2, 2},
+		},
+		data: []float32{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5},
+	}
+	if got := grid.value(types.XYZ(0.3, -0.2, 0.9)); math.Abs(float64(got-0.5)) > 1e-6 {
+		t.Fatalf("expected grid value inside the box to be 0.5; got %f", got)
+	}
+	if got := grid.value(types.XYZ(0, 0, 4)); got != 0 {
+		t.Fatalf("expected grid value outside the grid bounds to be 0; got %f", got)
+	}
+
+	m := medium{
+		sigmaA:   types.XYZ(0.4, 0.4, 0.4),
+		sigmaS:   types.XYZ(0.6, 0.6, 0.6),
+		sigmaT:   types.XYZ(1, 1, 1),
+		density:  grid,
+		emission: grid,
+	}
+	const dist = 2
+	sigmaT := 0.5 * float64(m.sigmaT[0])
+	tr := math.Exp(-sigmaT * dist)
+
+	specs := []struct {
+		name    string
+		expMean float64
+		sample  func(rng *pathRng) float32
+	}{
+		{
+			"ratio tracking transmittance", tr,
+			func(rng *pathRng) float32 {
+				return m.shadowTransmittance(origin, rayDir, dist, rng)[0]
+			},
+		},
+		{
+			"delta tracking transmitted weight", tr,
+			func(rng *pathRng) float32 {
+				_, weight, _, scatter := m.trackDistance(origin, rayDir, dist, rng)
+				if scatter {
+					return 0
+				}
+				return weight[0]
+			},
+		},
+		{
+			"delta tracking scattered weight", 0.6 / 1.0 * (1 - tr),
+			func(rng *pathRng) float32 {
+				_, weight, _, scatter := m.trackDistance(origin, rayDir, dist, rng)
+				if !scatter {
+					return 0
+				}
+				return weight[0]
+			},
+		},
+		{
+			// The emitted radiance per unit length is 2 * 0.5
+			"delta tracking emission", 1.0 / sigmaT * (1 - tr),
+			func(rng *pathRng) float32 {
+				_, _, emitted, _ := m.trackDistance(origin, rayDir, dist, rng)
+				return emitted[0]
+			},
+		},
+	}
+
+	significance := stattest.SidakSignificance(0.001, len(specs))
+	for _, spec := range specs {
+		rng := newPathRng(1, 0)
+		estimates := make([]float64, numSamples)
+		for i := range estimates {
+			estimates[i] = float64(spec.sample(&rng))
+		}
+		res, err := stattest.TTest(estimates, spec.expMean)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reject(significance) {
+			t.Errorf("expected the mean of the %s estimates to be %f; %s", spec.name, spec.expMean, res)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package cpu
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The max number of tentative collisions sampled by delta and ratio tracking
+// along a single ray segment. Segments that need more steps are truncated.
+// It matches the MEDIUM_MAX_TRACKING_STEPS define of the opencl kernels.
+const maxTrackingSteps = 256
+
+// A dense volume grid of a heterogeneous medium.
+type volumeGrid struct {
+	*scene.VolumeGrid
+
+	// The voxel data of the grid.
+	data []float32
+}
+
+// Get the volume grid with the given index or nil if the index is out of
+// range.
+func (sd *sceneData) volumeGrid(index int32) *volumeGrid {
+	if index < 0 || int(index) >= len(sd.VolumeGrids) {
+		return nil
+	}
+
+	grid := &sd.VolumeGrids[index]
+	numVoxels := grid.Dims[0] * grid.Dims[1] * grid.Dims[2]
+	return &volumeGrid{
+		VolumeGrid: grid,
+		data:       sd.VolumeData[grid.DataOffset : grid.DataOffset+numVoxels],
+	}
+}
+
+// Get the trilinearly interpolated grid value at a world space point. Points
+// outside the grid evaluate to zero.
+func (g *volumeGrid) value(point types.Vec3) float32 {
+	p := transformPoint(&g.Transform, point)
+
+	var base [3]int
+	var frac [3]float32
+	for axis := range base {
+		floor := math.Floor(float64(p[axis]))
+		base[axis] = int(floor)
+		frac[axis] = p[axis] - float32(floor)
+	}
+
+	var value float32
+	for corner := 0; corner < 8; corner++ {
+		weight := float32(1)
+		var coord [3]int
+		for axis := range coord {
+			if corner&(1<<uint(axis)) != 0 {
+				coord[axis] = base[axis] + 1
+				weight *= frac[axis]
+			} else {
+				coord[axis] = base[axis]
+				weight *= 1 - frac[axis]
+			}
+		}
+		if weight > 0 {
+			value += weight * g.voxel(coord)
+		}
+	}
+	return value
+}
+
+// Get the value of a voxel or zero if the coordinates are out of range.
+func (g *volumeGrid) voxel(coord [3]int) float32 {
+	for axis, c := range coord {
+		if c < 0 || c >= int(g.Dims[axis]) {
+			return 0
+		}
+	}
+	return g.data[(coord[2]*int(g.Dims[1])+coord[1])*int(g.Dims[0])+coord[0]]
+}
+
+// Clip the ray segment [0, tMax] against the region where the grid values may
+// be non-zero. As the grid transformation is affine, distances along the ray
+// are the same in world and grid space.
+func (g *volumeGrid) clip(origin, dir types.Vec3, tMax float32) (t0, t1 float32, overlaps bool) {
+	o := transformPoint(&g.Transform, origin)
+	d := transformDir(&g.Transform, dir)
+
+	t0, t1 = 0, tMax
+	for axis := 0; axis < 3; axis++ {
+		lo, hi := float32(-1), float32(g.Dims[axis])
+		if d[axis] == 0 {
+			if o[axis] <= lo || o[axis] >= hi {
+				return 0, 0, false
+			}
+			continue
+		}
+
+		ta, tb := (lo-o[axis])/d[axis], (hi-o[axis])/d[axis]
+		if ta > tb {
+			ta, tb = tb, ta
+		}
+		t0, t1 = maxf(t0, ta), minf(t1, tb)
+		if t0 >= t1 {
+			return 0, 0, false
+		}
+	}
+	return t0, t1, true
+}
+
+// Check whether the medium defines a density or an emission grid. The
+// interactions with heterogeneous media are sampled using delta tracking.
+func (m *medium) heterogeneous() bool {
+	return m.density != nil || m.emission != nil
+}
+
+// Get the max extinction coefficient of the medium that is used as the
+// majorant for delta and ratio tracking.
+func (m *medium) majorant() float32 {
+	if m.density == nil {
+		return maxComponent(m.sigmaT)
+	}
+	return m.density.MaxValue * maxComponent(m.sigmaT)
+}
+
+// Get the density of the medium at a world space point.
+func (m *medium) densityAt(point types.Vec3) float32 {
+	if m.density == nil {
+		return 1
+	}
+	return m.density.value(point)
+}
+
+// Get the part of the ray segment [0, tMax] that needs to be tracked. Media
+// without a density grid are tracked along the entire segment.
+func (m *medium) trackingRange(origin, dir types.Vec3, tMax float32, withEmission bool) (t0, t1 float32, overlaps bool) {
+	if m.density == nil {
+		return 0, tMax, true
+	}
+
+	t0, t1, overlaps = m.density.clip(origin, dir, tMax)
+	if withEmission && m.emission != nil {
+		e0, e1, emissionOverlaps := m.emission.clip(origin, dir, tMax)
+		switch {
+		case emissionOverlaps && overlaps:
+			t0, t1 = minf(t0, e0), maxf(t1, e1)
+		case emissionOverlaps:
+			t0, t1, overlaps = e0, e1, true
+		}
+	}
+	return t0, t1, overlaps
+}
+
+// Sample a collision along the ray segment [0, tMax] using delta tracking.
+// Each tentative collision is classified as a real collision with probability
+// equal to the average extinction over the majorant and the path weight is
+// updated so that the estimator remains unbiased for all channels. Real
+// collisions always scatter and their weight includes the scattering albedo.
+//
+// The light emitted by the medium is collected at each tentative collision
+// and returned as emitted. The emission grid values (scaled by the grid scale)
+// define the emitted radiance per unit length.
+func (m *medium) trackDistance(origin, dir types.Vec3, tMax float32, rng *pathRng) (t float32, weight, emitted types.Vec3, scatter bool) {
+	weight = types.Vec3{1, 1, 1}
+	mu := m.majorant()
+	t0, t1, overlaps := m.trackingRange(origin, dir, tMax, true)
+	if !overlaps || mu <= 0 {
+		return tMax, weight, emitted, false
+	}
+
+	t = t0
+	for step := 0; step < maxTrackingSteps; step++ {
+		t -= float32(math.Log(float64(1-rng.float()))) / mu
+		if t >= t1 {
+			break
+		}
+
+		point := origin.Add(dir.Mul(t))
+		if m.emission != nil {
+			emitted = emitted.Add(mulVec3(weight, m.emission.Scale).Mul(m.emission.value(point) / mu))
+		}
+
+		sigmaT := m.sigmaT.Mul(m.densityAt(point))
+		avgSigmaT := (sigmaT[0] + sigmaT[1] + sigmaT[2]) / 3
+		if rng.float()*mu < avgSigmaT {
+			weight = mulVec3(weight, m.sigmaS.Mul(m.densityAt(point)/avgSigmaT))
+			return t, weight, emitted, true
+		}
+
+		sigmaN := types.Vec3{mu - sigmaT[0], mu - sigmaT[1], mu - sigmaT[2]}
+		weight = mulVec3(weight, sigmaN.Mul(3/(sigmaN[0]+sigmaN[1]+sigmaN[2])))
+	}
+	return tMax, weight, emitted, false
+}
+
+// Estimate the transmittance along a ray segment of the given length. The
+// transmittance of heterogeneous media is estimated using ratio tracking.
+func (m *medium) shadowTransmittance(origin, dir types.Vec3, dist float32, rng *pathRng) types.Vec3 {
+	if m.density == nil {
+		return m.transmittance(dist)
+	}
+
+	tr := types.Vec3{1, 1, 1}
+	mu := m.majorant()
+	t0, t1, overlaps := m.trackingRange(origin, dir, dist, false)
+	if !overlaps || mu <= 0 {
+		return tr
+	}
+
+	t := t0
+	for step := 0; step < maxTrackingSteps; step++ {
+		t -= float32(math.Log(float64(1-rng.float()))) / mu
+		if t >= t1 {
+			break
+		}
+
+		sigmaT := m.sigmaT.Mul(m.density.value(origin.Add(dir.Mul(t))))
+		tr = mulVec3(tr, types.Vec3{1 - sigmaT[0]/mu, 1 - sigmaT[1]/mu, 1 - sigmaT[2]/mu})
+		if maxComponent(tr) <= 0 {
+			return types.Vec3{}
+		}
+	}
+	return tr
+}
@@ -0,0 +1,109 @@
+package cpu
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The origin offset (relative to the occlusion radius) of local occlusion rays.
+// It matches the offset used by the opencl bakeLocalOcclusion kernel.
+const localOcclusionRayOffset = 1e-3
+
+// Estimate the local occlusion for each vertex of the triangles in targets.
+// Like the opencl tracer, the occlusion is the fraction of cosine-weighted
+// rays that hit geometry of the same mesh within the radius of the triangle.
+// Rays are traced in mesh space so the result does not depend on the mesh
+// instances. If targets is nil, this function returns nil.
+func (sd *sceneData) bakeLocalOcclusion(targets *scene.LocalOcclusionTargets) []float32 {
+	if targets == nil {
+		return nil
+	}
+
+	occlusion := make([]float32, len(sd.VertexList))
+	stack := make([]uint32, 0, bvhStackSize)
+	for vertex := range occlusion {
+		radius := targets.Radius[vertex/3]
+		if radius <= 0 {
+			continue
+		}
+
+		normal := sd.NormalList[vertex].Vec3().Normalize()
+		origin := sd.VertexList[vertex].Vec3().Add(normal.Mul(maxf(radius*localOcclusionRayOffset, intersectionEpsilon)))
+		bvhRoot := targets.BvhRoots[vertex/3]
+
+		rng := newPathRng(0, uint32(vertex))
+		numOccluded := 0
+		for sample := 0; sample < scene.LocalOcclusionSamples; sample++ {
+			dir := cosWeightedHemisphereSample(normal, rng.sample2f())
+			if sd.meshOccluded(bvhRoot, origin, dir, radius, &stack) {
+				numOccluded++
+			}
+		}
+		occlusion[vertex] = float32(numOccluded) / scene.LocalOcclusionSamples
+	}
+
+	return occlusion
+}
+
+// Check whether a mesh space ray hits any triangle of the mesh BVH starting at
+// bvhRoot closer than maxDist.
+func (sd *sceneData) meshOccluded(bvhRoot uint32, origin, dir types.Vec3, maxDist float32, stack *[]uint32) bool {
+	nodes := sd.BvhNodeList
+	invDir := invVec3(dir)
+
+	*stack = append((*stack)[:0], bvhRoot)
+	for len(*stack) != 0 {
+		node := &nodes[(*stack)[len(*stack)-1]]
+		*stack = (*stack)[:len(*stack)-1]
+		if !intersectBox(node, origin, invDir, maxDist) {
+			continue
+		}
+
+		if node.LData > 0 {
+			*stack = append(*stack, uint32(node.LData), uint32(node.RData))
+			continue
+		}
+
+		first, count := node.GetPrimitives()
+		for triIndex := first; triIndex < first+count; triIndex++ {
+			if t, _, _, ok := sd.intersectTriangle(triIndex, origin, dir); ok && t < maxDist {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Populate the curvature and local occlusion of a surface. The curvature is
+// estimated in mesh space using the rate of change of the vertex normals along
+// the triangle edges in the same way as the opencl kernels.
+func (sd *sceneData) setWearInputs(s *surface, hit *intersection) {
+	offset := hit.triIndex * 3
+	var p, n [3]types.Vec3
+	for i := 0; i < 3; i++ {
+		p[i] = sd.VertexList[offset+uint32(i)].Vec3()
+		n[i] = sd.NormalList[offset+uint32(i)].Vec3().Normalize()
+	}
+
+	k01 := edgeCurvature(p[0], n[0], p[1], n[1])
+	k02 := edgeCurvature(p[0], n[0], p[2], n[2])
+	k12 := edgeCurvature(p[1], n[1], p[2], n[2])
+	s.curvature = 0.5 * (hit.w*(k01+k02) + hit.u*(k01+k12) + hit.v*(k02+k12))
+
+	if sd.vertexOcclusion != nil {
+		occlusion := sd.vertexOcclusion[offset : offset+3]
+		s.occlusion = hit.w*occlusion[0] + hit.u*occlusion[1] + hit.v*occlusion[2]
+	}
+}
+
+// Estimate the normal curvature along the edge p0 -> p1. The result is
+// positive for convex and negative for concave edges.
+func edgeCurvature(p0, n0, p1, n1 types.Vec3) float32 {
+	edge := p1.Sub(p0)
+	edgeLenSq := edge.Dot(edge)
+	if edgeLenSq == 0 {
+		return 0
+	}
+	return n1.Sub(n0).Dot(edge) / edgeLenSq
+}
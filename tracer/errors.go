@@ -0,0 +1,81 @@
+package tracer
+
+import "errors"
+
+var (
+	ErrUnsupportedFrameTarget = errors.New("tracer: unsupported frame target type")
+	ErrFrameTargetTooSmall    = errors.New("tracer: frame target is too small to fit the frame")
+	ErrFrameSourceTooSmall    = errors.New("tracer: frame source does not contain enough pixel data")
+	ErrPixelOutOfBounds       = errors.New("tracer: probed pixel is outside the frame")
+)
+
+// Error kinds reported by tracers. Errors returned by the Tracer API and the
+// tracing pipeline may be wrapped in an *Error whose Kind is set to one of
+// these values so that callers can distinguish between device failures and
+// problems with the rendered scene.
+var (
+	// The device stopped responding or was reset by the driver. Any
+	// state uploaded to the device is lost and the tracer must be
+	// recreated.
+	ErrDeviceLost = errors.New("tracer: device lost")
+
+	// The device could not allocate the memory required for rendering.
+	// Rendering a smaller frame or scene may succeed.
+	ErrOutOfMemory = errors.New("tracer: out of device memory")
+
+	// The tracer kernels could not be compiled or loaded for the device.
+	ErrKernelBuild = errors.New("tracer: could not build kernels")
+
+	// The scene data is missing or cannot be rendered.
+	ErrSceneInvalid = errors.New("tracer: invalid scene")
+)
+
+// An Error annotates an error returned by a tracer with its kind.
+type Error struct {
+	// One of ErrDeviceLost, ErrOutOfMemory, ErrKernelBuild or ErrSceneInvalid.
+	Kind error
+
+	// The underlying error.
+	Err error
+}
+
+// Implements error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Get the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Check whether target matches the error kind. Allows errors.Is to match
+// wrapped errors against the error kind values.
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Wrap err into an Error with the specified kind. If err is nil, WrapError
+// returns nil. Errors that have already been assigned a kind are returned
+// unchanged.
+func WrapError(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, isTyped := err.(*Error); isTyped {
+		return err
+	}
+
+	return &Error{Kind: kind, Err: err}
+}
+
+// Get the kind of an error returned by a tracer or nil if the error has not
+// been assigned a kind.
+func ErrorKind(err error) error {
+	if typedErr, isTyped := err.(*Error); isTyped {
+		return typedErr.Kind
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package tracer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWrapError(t *testing.T) {
+	if WrapError(ErrDeviceLost, nil) != nil {
+		t.Fatal("expected wrapping a nil error to return nil")
+	}
+
+	srcErr := errors.New("kernel did not complete successfully")
+	err := WrapError(ErrDeviceLost, srcErr)
+	if err.Error() != srcErr.Error() {
+		t.Fatalf("expected wrapped error message to be %q; got %q", srcErr.Error(), err.Error())
+	}
+
+	if kind := ErrorKind(err); kind != ErrDeviceLost {
+		t.Fatalf("expected error kind to be %v; got %v", ErrDeviceLost, kind)
+	}
+
+	if !errors.Is(err, ErrDeviceLost) {
+		t.Fatal("expected errors.Is to match the error kind")
+	}
+
+	if !errors.Is(err, srcErr) {
+		t.Fatal("expected errors.Is to match the wrapped error")
+	}
+
+	if errors.Is(err, ErrOutOfMemory) {
+		t.Fatal("expected errors.Is not to match a different error kind")
+	}
+
+	// Errors with an assigned kind should not be re-wrapped
+	if rewrapped := WrapError(ErrSceneInvalid, err); ErrorKind(rewrapped) != ErrDeviceLost {
+		t.Fatalf("expected re-wrapped error to retain its original kind; got %v", ErrorKind(rewrapped))
+	}
+
+	if kind := ErrorKind(srcErr); kind != nil {
+		t.Fatalf("expected untyped error kind to be nil; got %v", kind)
+	}
+}
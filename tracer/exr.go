@@ -0,0 +1,127 @@
+package tracer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+// The OpenEXR magic number and the version field for single-part scanline files.
+const (
+	exrMagic   uint32 = 20000630
+	exrVersion uint32 = 2
+)
+
+// The OpenEXR pixel type for 32-bit float channels.
+const exrPixelTypeFloat int32 = 2
+
+// Save a RGB float framebuffer as an uncompressed OpenEXR image. The
+// radiance slice contains 3 linear values for each frame pixel in row-major
+// order; the values are written as-is (no tone-mapping or gamma correction)
+// so the image retains the full dynamic range of the render.
+func SaveEXR(imgFile string, radiance []float32, frameW, frameH uint32) error {
+	f, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+
+	err = WriteEXR(f, radiance, frameW, frameH)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Encode a RGB float framebuffer as an uncompressed single-part scanline
+// OpenEXR image with 32-bit float channels. See SaveEXR.
+func WriteEXR(w io.Writer, radiance []float32, frameW, frameH uint32) error {
+	numPixels := int(frameW * frameH)
+	if frameW == 0 || frameH == 0 || len(radiance) < numPixels*3 {
+		return ErrFrameSourceTooSmall
+	}
+
+	// Channels are stored in alphabetical order
+	channels := []struct {
+		name   string
+		offset int
+	}{{"B", 2}, {"G", 1}, {"R", 0}}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, [2]uint32{exrMagic, exrVersion})
+
+	var chlist bytes.Buffer
+	for _, ch := range channels {
+		chlist.WriteString(ch.name)
+		chlist.WriteByte(0)
+		binary.Write(&chlist, binary.LittleEndian, exrPixelTypeFloat)
+		chlist.Write([]byte{0, 0, 0, 0}) // pLinear and reserved bytes
+		binary.Write(&chlist, binary.LittleEndian, [2]int32{1, 1})
+	}
+	chlist.WriteByte(0)
+
+	window := [4]int32{0, 0, int32(frameW) - 1, int32(frameH) - 1}
+	writeEXRAttribute(&header, "channels", "chlist", chlist.Bytes())
+	writeEXRAttribute(&header, "compression", "compression", []byte{0})
+	writeEXRAttribute(&header, "dataWindow", "box2i", window)
+	writeEXRAttribute(&header, "displayWindow", "box2i", window)
+	writeEXRAttribute(&header, "lineOrder", "lineOrder", []byte{0})
+	writeEXRAttribute(&header, "pixelAspectRatio", "float", float32(1))
+	writeEXRAttribute(&header, "screenWindowCenter", "v2f", [2]float32{0, 0})
+	writeEXRAttribute(&header, "screenWindowWidth", "float", float32(1))
+	header.WriteByte(0)
+
+	// Uncompressed files store one scanline per chunk; each chunk is
+	// prefixed by its y coordinate and data size.
+	lineDataLen := int(frameW) * len(channels) * 4
+	chunkOffset := uint64(header.Len()) + 8*uint64(frameH)
+	for y := uint32(0); y < frameH; y++ {
+		binary.Write(&header, binary.LittleEndian, chunkOffset)
+		chunkOffset += uint64(8 + lineDataLen)
+	}
+
+	out := bufio.NewWriter(w)
+	out.Write(header.Bytes())
+
+	line := make([]byte, 8+lineDataLen)
+	binary.LittleEndian.PutUint32(line[4:], uint32(lineDataLen))
+	for y := 0; y < int(frameH); y++ {
+		binary.LittleEndian.PutUint32(line, uint32(y))
+		offset := 8
+		for _, ch := range channels {
+			rowStart := y * int(frameW) * 3
+			for x := 0; x < int(frameW); x++ {
+				binary.LittleEndian.PutUint32(line[offset:], math.Float32bits(radiance[rowStart+x*3+ch.offset]))
+				offset += 4
+			}
+		}
+
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
+
+// Append an OpenEXR header attribute. The value is encoded in little-endian
+// byte order.
+func writeEXRAttribute(buf *bytes.Buffer, name, attrType string, value interface{}) {
+	var data []byte
+	if raw, isRaw := value.([]byte); isRaw {
+		data = raw
+	} else {
+		var valueBuf bytes.Buffer
+		binary.Write(&valueBuf, binary.LittleEndian, value)
+		data = valueBuf.Bytes()
+	}
+
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	buf.WriteString(attrType)
+	buf.WriteByte(0)
+	binary.Write(buf, binary.LittleEndian, int32(len(data)))
+	buf.Write(data)
+}
@@ -0,0 +1,92 @@
+package tracer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestWriteEXR(t *testing.T) {
+	const frameW, frameH = 3, 2
+	radiance := make([]float32, frameW*frameH*3)
+	for index := range radiance {
+		radiance[index] = float32(index) * 10.5
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEXR(&buf, radiance, frameW, frameH); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if magic := binary.LittleEndian.Uint32(data); magic != exrMagic {
+		t.Fatalf("expected magic number to be %d; got %d", exrMagic, magic)
+	}
+
+	// Parse the header attributes
+	attrs := make(map[string][]byte)
+	offset := 8
+	readString := func() string {
+		end := bytes.IndexByte(data[offset:], 0)
+		s := string(data[offset : offset+end])
+		offset += end + 1
+		return s
+	}
+	for data[offset] != 0 {
+		name := readString()
+		readString()
+		size := int(binary.LittleEndian.Uint32(data[offset:]))
+		attrs[name] = data[offset+4 : offset+4+size]
+		offset += 4 + size
+	}
+	offset++
+
+	for _, name := range []string{"channels", "compression", "dataWindow", "displayWindow", "lineOrder", "pixelAspectRatio", "screenWindowCenter", "screenWindowWidth"} {
+		if _, exists := attrs[name]; !exists {
+			t.Errorf("expected header to define the required %q attribute", name)
+		}
+	}
+	if exp := "B\x00\x02\x00\x00\x00"; !bytes.HasPrefix(attrs["channels"], []byte(exp)) {
+		t.Fatalf("expected the first channel to be a float B channel; got %v", attrs["channels"])
+	}
+	var window [4]int32
+	binary.Read(bytes.NewReader(attrs["dataWindow"]), binary.LittleEndian, &window)
+	if exp := [4]int32{0, 0, frameW - 1, frameH - 1}; window != exp {
+		t.Fatalf("expected data window to be %v; got %v", exp, window)
+	}
+
+	// Check the pixel values of each scanline
+	for y := 0; y < frameH; y++ {
+		chunk := int(binary.LittleEndian.Uint64(data[offset+y*8:]))
+		if lineY := int(binary.LittleEndian.Uint32(data[chunk:])); lineY != y {
+			t.Fatalf("expected chunk %d to contain scanline %d; got %d", y, y, lineY)
+		}
+		if size := binary.LittleEndian.Uint32(data[chunk+4:]); size != frameW*3*4 {
+			t.Fatalf("expected scanline data size to be %d; got %d", frameW*3*4, size)
+		}
+
+		for channel, rgbIndex := range []int{2, 1, 0} {
+			for x := 0; x < frameW; x++ {
+				got := math.Float32frombits(binary.LittleEndian.Uint32(data[chunk+8+(channel*frameW+x)*4:]))
+				if exp := radiance[(y*frameW+x)*3+rgbIndex]; got != exp {
+					t.Errorf("[y %d, x %d, channel %d] expected value %f; got %f", y, x, channel, exp, got)
+				}
+			}
+		}
+	}
+
+	if last := int(binary.LittleEndian.Uint64(data[offset+(frameH-1)*8:])) + 8 + frameW*3*4; last != len(data) {
+		t.Fatalf("expected the last scanline to end at the end of the file (%d); got %d", len(data), last)
+	}
+}
+
+func TestWriteEXRErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEXR(&buf, make([]float32, 5), 2, 1); err != ErrFrameSourceTooSmall {
+		t.Fatalf("expected ErrFrameSourceTooSmall; got %v", err)
+	}
+	if err := WriteEXR(&buf, nil, 0, 0); err != ErrFrameSourceTooSmall {
+		t.Fatalf("expected ErrFrameSourceTooSmall for an empty frame; got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package tracer
+
+import "image"
+
+// Check that dst is a supported frame target with enough room for a frame
+// with the given dimensions. If dst exposes a contiguous block of RGBA8 pixels
+// that can be directly written to by a tracer, this function returns a slice
+// to that block. Otherwise, it returns nil and the caller should use CopyFrame
+// to fill the target.
+func FrameTarget(dst interface{}, frameW, frameH uint32) ([]byte, error) {
+	var pix []byte
+	var stride int
+	var rect image.Rectangle
+	var offset int
+
+	switch t := dst.(type) {
+	case *image.RGBA:
+		pix, stride, rect = t.Pix, t.Stride, t.Rect
+		offset = t.PixOffset(rect.Min.X, rect.Min.Y)
+	case *image.NRGBA:
+		pix, stride, rect = t.Pix, t.Stride, t.Rect
+		offset = t.PixOffset(rect.Min.X, rect.Min.Y)
+	case []float32:
+		if len(t) < int(frameW*frameH*4) {
+			return nil, ErrFrameTargetTooSmall
+		}
+		return nil, nil
+	default:
+		return nil, ErrUnsupportedFrameTarget
+	}
+
+	if rect.Dx() < int(frameW) || rect.Dy() < int(frameH) {
+		return nil, ErrFrameTargetTooSmall
+	}
+
+	// Rows must be tightly packed for the target to be directly writable
+	rowLen := int(frameW) * 4
+	if stride != rowLen || len(pix)-offset < rowLen*int(frameH) {
+		return nil, nil
+	}
+
+	return pix[offset : offset+rowLen*int(frameH)], nil
+}
+
+// Copy a tightly packed RGBA8 frame into dst. The dst argument may be an
+// *image.RGBA, an *image.NRGBA or a []float32 slice that receives 4 values
+// (RGBA) in the [0, 1] range for each frame pixel. Image targets may use any
+// row stride; the frame is copied into the top-left corner of the image bounds.
+func CopyFrame(dst interface{}, src []byte, frameW, frameH uint32) error {
+	_, err := FrameTarget(dst, frameW, frameH)
+	if err != nil {
+		return err
+	}
+
+	rowLen := int(frameW) * 4
+	if len(src) < rowLen*int(frameH) {
+		return ErrFrameSourceTooSmall
+	}
+
+	switch t := dst.(type) {
+	case *image.RGBA:
+		copyFrameRows(t.Pix[t.PixOffset(t.Rect.Min.X, t.Rect.Min.Y):], t.Stride, src, rowLen, int(frameH))
+	case *image.NRGBA:
+		// The tracer always emits opaque pixels so no alpha conversion is required
+		copyFrameRows(t.Pix[t.PixOffset(t.Rect.Min.X, t.Rect.Min.Y):], t.Stride, src, rowLen, int(frameH))
+	case []float32:
+		const scaler float32 = 1.0 / 255.0
+		numValues := rowLen * int(frameH)
+		for index := 0; index < numValues; index++ {
+			t[index] = float32(src[index]) * scaler
+		}
+	}
+
+	return nil
+}
+
+// Copy frame rows into a target with a possibly different row stride.
+func copyFrameRows(dst []byte, dstStride int, src []byte, rowLen, rows int) {
+	for row := 0; row < rows; row++ {
+		copy(dst[row*dstStride:row*dstStride+rowLen], src[row*rowLen:(row+1)*rowLen])
+	}
+}
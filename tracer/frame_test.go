@@ -0,0 +1,81 @@
+package tracer
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFrameTarget(t *testing.T) {
+	var frameW, frameH uint32 = 4, 2
+
+	pix, err := FrameTarget(image.NewRGBA(image.Rect(0, 0, 4, 2)), frameW, frameH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pix) != 32 {
+		t.Fatalf("expected tightly packed image to be directly writable; got %d bytes", len(pix))
+	}
+
+	// Wider images need a stride-aware copy
+	pix, err = FrameTarget(image.NewNRGBA(image.Rect(0, 0, 8, 2)), frameW, frameH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pix != nil {
+		t.Fatal("expected image with larger stride not to be directly writable")
+	}
+
+	_, err = FrameTarget(image.NewRGBA(image.Rect(0, 0, 2, 2)), frameW, frameH)
+	if err != ErrFrameTargetTooSmall {
+		t.Fatalf("expected to get ErrFrameTargetTooSmall; got %v", err)
+	}
+
+	_, err = FrameTarget(make([]float32, 4), frameW, frameH)
+	if err != ErrFrameTargetTooSmall {
+		t.Fatalf("expected to get ErrFrameTargetTooSmall; got %v", err)
+	}
+
+	_, err = FrameTarget(image.NewGray(image.Rect(0, 0, 4, 2)), frameW, frameH)
+	if err != ErrUnsupportedFrameTarget {
+		t.Fatalf("expected to get ErrUnsupportedFrameTarget; got %v", err)
+	}
+}
+
+func TestCopyFrame(t *testing.T) {
+	var frameW, frameH uint32 = 2, 2
+	src := []byte{
+		1, 2, 3, 255, 4, 5, 6, 255,
+		7, 8, 9, 255, 10, 11, 12, 255,
+	}
+
+	// Copy into a sub-image with a larger stride
+	im := image.NewRGBA(image.Rect(0, 0, 4, 4)).SubImage(image.Rect(1, 1, 3, 3)).(*image.RGBA)
+	err := CopyFrame(im, src, frameW, frameH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			c := im.RGBAAt(1+x, 1+y)
+			srcOffset := (y*2 + x) * 4
+			if c.R != src[srcOffset] || c.G != src[srcOffset+1] || c.B != src[srcOffset+2] || c.A != src[srcOffset+3] {
+				t.Fatalf("pixel mismatch at (%d, %d); got %v", x, y, c)
+			}
+		}
+	}
+
+	out := make([]float32, 16)
+	err = CopyFrame(out, src, frameW, frameH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out[3] != 1.0 || out[0] != 1.0/255.0 {
+		t.Fatalf("unexpected float conversion output: %v", out[:4])
+	}
+
+	err = CopyFrame(out, src[:4], frameW, frameH)
+	if err != ErrFrameSourceTooSmall {
+		t.Fatalf("expected to get ErrFrameSourceTooSmall; got %v", err)
+	}
+}
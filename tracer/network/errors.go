@@ -0,0 +1,16 @@
+package network
+
+import "errors"
+
+var (
+	ErrInvalidOption         = errors.New("network tracer: invalid tracer option")
+	ErrNoTracers             = errors.New("network tracer: no tracers to serve")
+	ErrInvalidTracer         = errors.New("network tracer: invalid remote tracer index")
+	ErrUnsupportedChangeType = errors.New("network tracer: unsupported change type")
+	ErrInvalidChangeData     = errors.New("network tracer: invalid data type for change")
+	ErrInvalidBlock          = errors.New("network tracer: block request does not fit inside the frame")
+	ErrFrameMismatch         = errors.New("network tracer: block request frame dimensions do not match the tracer frame dimensions")
+	ErrUnsupportedMerge      = errors.New("network tracer: cannot merge output from a different tracer type")
+	ErrBufferTooSmall        = errors.New("network tracer: output buffer too small")
+	ErrServerClosed          = errors.New("network tracer: server closed")
+)
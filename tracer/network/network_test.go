@@ -0,0 +1,273 @@
+package network
+
+import (
+	"math"
+	"net"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/scene/testscenes"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/cpu"
+)
+
+const (
+	testFrameW uint32 = 16
+	testFrameH uint32 = 16
+)
+
+// Create a pair of seeded cpu tracers.
+func cpuTracers(t *testing.T) []tracer.Tracer {
+	tracers := make([]tracer.Tracer, 2)
+	for index := range tracers {
+		tr, err := cpu.NewTracer("cpu", cpu.WithWorkers(2), cpu.WithSeed(int64(index+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracers[index] = tr
+	}
+	return tracers
+}
+
+// Upload the scene to a list of tracers, split the frame between them and
+// merge their output into the first tracer. Returns the merged radiance.
+func renderFrame(t *testing.T, sc *scene.Scene, tracers []tracer.Tracer) []float32 {
+	for _, tr := range tracers {
+		if err := tr.Init(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{testFrameW, testFrameH}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tr.UpdateState(tracer.Synchronous, tracer.SceneData, sc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tr.UpdateState(tracer.Asynchronous, tracer.CameraData, sc.Camera); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blockReq := tracer.BlockRequest{
+		FrameW:          testFrameW,
+		FrameH:          testFrameH,
+		BlockW:          testFrameW,
+		BlockH:          testFrameH / uint32(len(tracers)),
+		SamplesPerPixel: 2,
+		NumBounces:      3,
+		MinBouncesForRR: 4,
+	}
+	for pass := uint32(0); pass < 2; pass++ {
+		blockReq.AccumulatedSamples = pass * blockReq.SamplesPerPixel
+		for index, tr := range tracers {
+			req := blockReq
+			req.BlockY = uint32(index) * blockReq.BlockH
+			if _, err := tr.Trace(&req); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tracers[0].MergeOutput(tr, &req); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	blockReq.BlockH = testFrameH
+	radiance := make([]float32, testFrameW*testFrameH*3)
+	if _, err := tracers[0].ReadRadiance(&blockReq, radiance); err != nil {
+		t.Fatal(err)
+	}
+	return radiance
+}
+
+func TestRemoteTracersMatchLocalTracers(t *testing.T) {
+	sc, err := testscenes.Compile(testscenes.FurnaceSphere(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Camera.SetupFrame(testFrameW, testFrameH)
+
+	srv, err := NewServer(cpuTracers(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	remoteTracers, err := Dial(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, tr := range remoteTracers {
+			tr.Close()
+		}
+	}()
+
+	if len(remoteTracers) != 2 {
+		t.Fatalf("expected worker to expose 2 tracers; got %d", len(remoteTracers))
+	}
+	if flags := remoteTracers[0].Flags(); flags&tracer.Remote == 0 || flags&tracer.Local != 0 {
+		t.Fatalf("expected remote tracer flags to include Remote and exclude Local; got %d", flags)
+	}
+
+	expected := renderFrame(t, sc, cpuTracers(t))
+	got := renderFrame(t, sc, remoteTracers)
+	for index := range expected {
+		if math.Abs(float64(got[index]-expected[index])) > 1e-5*math.Max(1, float64(expected[index])) {
+			t.Fatalf("expected remote radiance at index %d to be %f; got %f", index, expected[index], got[index])
+		}
+	}
+
+	// Merging output from a different tracer type is not supported
+	blockReq := tracer.BlockRequest{FrameW: testFrameW, FrameH: testFrameH, BlockW: testFrameW, BlockH: 1}
+	if _, err = remoteTracers[0].MergeOutput(cpuTracers(t)[0], &blockReq); err != ErrUnsupportedMerge {
+		t.Fatalf("expected to get ErrUnsupportedMerge; got %v", err)
+	}
+}
+
+func TestServerRejectsInvalidBlocks(t *testing.T) {
+	srv, err := NewServer(cpuTracers(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	ws := &workerService{srv: srv}
+	var ok bool
+	err = ws.UpdateState(UpdateStateArgs{Tracer: 0, Change: tracer.FrameDimensions, FrameDims: [2]uint32{testFrameW, testFrameH}}, &ok)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		req    tracer.BlockRequest
+		expErr error
+	}{
+		// BlockY+BlockH wraps around to 8
+		{tracer.BlockRequest{FrameW: testFrameW, FrameH: testFrameH, BlockW: testFrameW, BlockY: 0xFFFFFFF8, BlockH: 0x10}, ErrInvalidBlock},
+		{tracer.BlockRequest{FrameW: testFrameW, FrameH: testFrameH, BlockW: testFrameW, BlockY: 1, BlockH: testFrameH}, ErrInvalidBlock},
+		{tracer.BlockRequest{FrameW: testFrameW, FrameH: 0xFFFFFFFF, BlockW: testFrameW, BlockH: 1}, ErrFrameMismatch},
+		{tracer.BlockRequest{FrameW: 0x10000, FrameH: testFrameH, BlockW: 0x10000, BlockH: 1}, ErrFrameMismatch},
+	}
+
+	for specIndex, spec := range specs {
+		var reply TraceReply
+		if err = ws.Trace(TraceArgs{Tracer: 0, Request: spec.req}, &reply); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// Tracers without frame dimensions reject all blocks
+	var reply TraceReply
+	req := tracer.BlockRequest{FrameW: testFrameW, FrameH: testFrameH, BlockW: testFrameW, BlockH: 1}
+	if err = ws.Trace(TraceArgs{Tracer: 1, Request: req}, &reply); err != ErrFrameMismatch {
+		t.Fatalf("expected to get ErrFrameMismatch; got %v", err)
+	}
+}
+
+func TestServerWithoutTracers(t *testing.T) {
+	if _, err := NewServer(nil); err != ErrNoTracers {
+		t.Fatalf("expected to get ErrNoTracers; got %v", err)
+	}
+}
+
+func TestRowGroups(t *testing.T) {
+	sc, err := testscenes.Compile(testscenes.FurnaceSphere(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.Camera.SetupFrame(testFrameW, testFrameH)
+
+	srv, err := NewServer(cpuTracers(t)[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	if _, err = Dial(l.Addr().String(), WithRowGroups(0, nil)); err == nil {
+		t.Fatal("expected an error when using empty row groups")
+	}
+
+	var groups []tracer.BlockRequest
+	var received []float32
+	remoteTracers, err := Dial(l.Addr().String(), WithRowGroups(3, func(tr tracer.Tracer, groupReq *tracer.BlockRequest, radiance []float32) error {
+		groups = append(groups, *groupReq)
+		received = append(received, radiance...)
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := remoteTracers[0]
+	defer tr.Close()
+
+	if err = tr.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.FrameDimensions, [2]uint32{testFrameW, testFrameH}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.SceneData, sc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tr.UpdateState(tracer.Synchronous, tracer.CameraData, sc.Camera); err != nil {
+		t.Fatal(err)
+	}
+
+	// Trace the bottom 8 rows; the last group only contains 2 rows
+	blockReq := tracer.BlockRequest{
+		FrameW:          testFrameW,
+		FrameH:          testFrameH,
+		BlockW:          testFrameW,
+		BlockY:          8,
+		BlockH:          8,
+		SamplesPerPixel: 1,
+		NumBounces:      3,
+		MinBouncesForRR: 4,
+	}
+	if _, err = tr.Trace(&blockReq); err != nil {
+		t.Fatal(err)
+	}
+
+	expY := []uint32{8, 11, 14}
+	expH := []uint32{3, 3, 2}
+	if len(groups) != len(expY) {
+		t.Fatalf("expected %d row groups; got %d", len(expY), len(groups))
+	}
+	for index, groupReq := range groups {
+		if groupReq.BlockY != expY[index] || groupReq.BlockH != expH[index] {
+			t.Errorf("[group %d] expected rows %d-%d; got %d-%d", index, expY[index], expY[index]+expH[index], groupReq.BlockY, groupReq.BlockY+groupReq.BlockH)
+		}
+	}
+	if stats := tr.Stats(); stats.BlockH != blockReq.BlockH {
+		t.Errorf("expected stats to report the full block height %d; got %d", blockReq.BlockH, stats.BlockH)
+	}
+
+	// The received rows should match the merged block radiance
+	if _, err = tr.MergeOutput(tr, &blockReq); err != nil {
+		t.Fatal(err)
+	}
+	radiance := make([]float32, testFrameW*testFrameH*3)
+	if _, err = tr.ReadRadiance(&blockReq, radiance); err != nil {
+		t.Fatal(err)
+	}
+	blockRadiance := radiance[blockReq.BlockY*testFrameW*3:]
+	if len(received) != len(blockRadiance) {
+		t.Fatalf("expected to receive %d radiance values; got %d", len(blockRadiance), len(received))
+	}
+	for index, v := range blockRadiance {
+		if math.Abs(float64(received[index]-v)) > 1e-5 {
+			t.Fatalf("expected received radiance at index %d to be %f; got %f", index, v, received[index])
+		}
+	}
+}
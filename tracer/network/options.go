@@ -0,0 +1,65 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// A PostProcessFunc is invoked by SyncFramebuffer after the frame accumulator
+// has been tone-mapped into the output frame buffer. Post-process functions
+// typically read the frame via ReadFrame or ReadRadiance and save it.
+type PostProcessFunc func(tr tracer.Tracer, blockReq *tracer.BlockRequest) error
+
+// A RowGroupFunc is invoked by Trace each time the rows of a row group are
+// received from the worker. The group request describes the received rows
+// while radiance contains the sum of the radiance samples of each group pixel
+// (3 float32 values per pixel). The radiance slice is only valid for the
+// duration of the call. Returning an error aborts the trace.
+type RowGroupFunc func(tr tracer.Tracer, groupReq *tracer.BlockRequest, radiance []float32) error
+
+// A TracerOption configures a tracer created via Dial.
+type TracerOption func(tr *Tracer) error
+
+// Append a function to the list of functions invoked by SyncFramebuffer.
+func WithPostProcess(fn PostProcessFunc) TracerOption {
+	return func(tr *Tracer) error {
+		if fn == nil {
+			return fmt.Errorf("%s: nil post-process function", ErrInvalidOption.Error())
+		}
+		tr.postProcess = append(tr.postProcess, fn)
+		return nil
+	}
+}
+
+// Set the timeout for connecting to the worker. If not specified, a 10
+// second timeout is used.
+func WithDialTimeout(timeout time.Duration) TracerOption {
+	return func(tr *Tracer) error {
+		if timeout <= 0 {
+			return fmt.Errorf("%s: the dial timeout must be positive", ErrInvalidOption.Error())
+		}
+		tr.dialTimeout = timeout
+		return nil
+	}
+}
+
+// Split each block request into groups of rowsPerGroup rows which are traced
+// by the worker top to bottom and invoke fn as soon as the rows of each group
+// are received. This allows remote viewers to display the frame while it is
+// being rendered instead of waiting for each block to complete. Smaller
+// groups paint in more smoothly but require more round trips to the worker.
+func WithRowGroups(rowsPerGroup uint32, fn RowGroupFunc) TracerOption {
+	return func(tr *Tracer) error {
+		if rowsPerGroup == 0 {
+			return fmt.Errorf("%s: row groups must contain at least one row", ErrInvalidOption.Error())
+		}
+		if fn == nil {
+			return fmt.Errorf("%s: nil row group function", ErrInvalidOption.Error())
+		}
+		tr.rowsPerGroup = rowsPerGroup
+		tr.rowGroupFn = fn
+		return nil
+	}
+}
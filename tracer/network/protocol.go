@@ -0,0 +1,60 @@
+package network
+
+import (
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// The name of the RPC service exposed by worker servers.
+const serviceName = "Worker"
+
+// Describes a tracer exposed by a worker.
+type TracerInfo struct {
+	// The tracer id reported by the worker.
+	Id string
+
+	// The tracer flags reported by the worker.
+	Flags tracer.Flag
+
+	// The speed estimate reported by the worker.
+	Speed uint32
+}
+
+// The reply to a Tracers call.
+type TracersReply struct {
+	Tracers []TracerInfo
+}
+
+// Arguments for calls that only target a remote tracer.
+type TracerArgs struct {
+	// The index of the remote tracer.
+	Tracer int
+}
+
+// Arguments for an UpdateState call. Only the field that matches the change
+// type is populated. Changes are always applied synchronously by the worker.
+type UpdateStateArgs struct {
+	Tracer int
+	Change tracer.ChangeType
+
+	FrameDims [2]uint32
+	Scene     *scene.Scene
+	Camera    *scene.Camera
+	Instances *scene.InstanceUpdate
+}
+
+// Arguments for a Trace call.
+type TraceArgs struct {
+	Tracer  int
+	Request tracer.BlockRequest
+}
+
+// The reply to a Trace call.
+type TraceReply struct {
+	// The remote tracer statistics.
+	Stats tracer.Stats
+
+	// The sum of the radiance samples collected for each pixel of the
+	// block rows (3 float32 values per pixel).
+	Radiance []float32
+}
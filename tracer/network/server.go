@@ -0,0 +1,261 @@
+package network
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/achilleasa/polaris/log"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// A worker server that exposes a list of local tracers to remote renderers
+// over TCP. Remote renderers drive the tracers via the network Tracer which
+// forwards state updates and block requests to the server and receives the
+// traced block radiance.
+//
+// The served tracers keep a single rendering state, so a worker should only
+// be used by one renderer at a time.
+type Server struct {
+	logger log.Logger
+
+	sync.Mutex
+
+	rpcServer *rpc.Server
+	listener  net.Listener
+	closed    bool
+
+	// The served tracers. Calls to each tracer are serialized using the
+	// matching lock.
+	tracers []tracer.Tracer
+	locks   []sync.Mutex
+
+	// The frame dimensions set for each tracer via UpdateState.
+	frameDims [][2]uint32
+
+	// Scratch buffers for reading back the radiance of each tracer.
+	radiance [][]float32
+}
+
+// Create a new worker server for a list of local tracers. The server
+// initializes and closes the tracers when requested by remote renderers.
+func NewServer(tracers []tracer.Tracer) (*Server, error) {
+	if len(tracers) == 0 {
+		return nil, ErrNoTracers
+	}
+
+	s := &Server{
+		logger:    log.New("worker server"),
+		rpcServer: rpc.NewServer(),
+		tracers:   tracers,
+		locks:     make([]sync.Mutex, len(tracers)),
+		frameDims: make([][2]uint32, len(tracers)),
+		radiance:  make([][]float32, len(tracers)),
+	}
+
+	err := s.rpcServer.RegisterName(serviceName, &workerService{srv: s})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Listen for incoming connections on addr and serve requests.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Noticef("listening for connections on %s", l.Addr())
+	return s.Serve(l)
+}
+
+// Accept connections from a listener and serve requests. Each connection is
+// served by a separate goroutine. Serve blocks until the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.logger.Noticef("accepted connection from %s", conn.RemoteAddr())
+		go s.rpcServer.ServeConn(conn)
+	}
+}
+
+// Stop accepting connections and close the served tracers.
+func (s *Server) Close() {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return
+	}
+	s.closed = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.Unlock()
+
+	for index, tr := range s.tracers {
+		s.locks[index].Lock()
+		tr.Close()
+		s.locks[index].Unlock()
+	}
+}
+
+// Lookup a served tracer and lock it. The caller must invoke the returned
+// function to release the lock.
+func (s *Server) lockTracer(index int) (tracer.Tracer, func(), error) {
+	if index < 0 || index >= len(s.tracers) {
+		return nil, nil, ErrInvalidTracer
+	}
+
+	s.locks[index].Lock()
+	return s.tracers[index], s.locks[index].Unlock, nil
+}
+
+// Trace a block using a served tracer and return the sum of the radiance
+// samples for the block rows. The tracer output is merged into its own frame
+// accumulator as the first pass of the frame so the block radiance can be
+// read back using the tracer.Tracer interface.
+func (s *Server) trace(index int, blockReq tracer.BlockRequest, reply *TraceReply) error {
+	tr, unlock, err := s.lockTracer(index)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Only accept blocks for the frame dimensions that were set via
+	// UpdateState so clients cannot force arbitrary buffer allocations.
+	if blockReq.FrameW != s.frameDims[index][0] || blockReq.FrameH != s.frameDims[index][1] {
+		return ErrFrameMismatch
+	}
+	if blockReq.BlockH > blockReq.FrameH || blockReq.BlockY > blockReq.FrameH-blockReq.BlockH {
+		return ErrInvalidBlock
+	}
+
+	blockReq.AccumulatedSamples = 0
+	_, err = tr.Trace(&blockReq)
+	if err != nil {
+		return err
+	}
+	reply.Stats = *tr.Stats()
+
+	_, err = tr.MergeOutput(tr, &blockReq)
+	if err != nil {
+		return err
+	}
+
+	numValues := int(blockReq.FrameW) * int(blockReq.FrameH) * 3
+	if len(s.radiance[index]) != numValues {
+		s.radiance[index] = make([]float32, numValues)
+	}
+	_, err = tr.ReadRadiance(&blockReq, s.radiance[index])
+	if err != nil {
+		return err
+	}
+
+	// Convert the normalized radiance back to sums
+	rowOffset := int(blockReq.BlockY) * int(blockReq.FrameW) * 3
+	rowCount := int(blockReq.BlockH) * int(blockReq.FrameW) * 3
+	reply.Radiance = make([]float32, rowCount)
+	scaler := float32(blockReq.SamplesPerPixel)
+	for i, v := range s.radiance[index][rowOffset : rowOffset+rowCount] {
+		reply.Radiance[i] = v * scaler
+	}
+
+	return nil
+}
+
+// The RPC service exposed by the worker server.
+type workerService struct {
+	srv *Server
+}
+
+// List the served tracers.
+func (ws *workerService) Tracers(args int, reply *TracersReply) error {
+	reply.Tracers = make([]TracerInfo, len(ws.srv.tracers))
+	for index, tr := range ws.srv.tracers {
+		reply.Tracers[index] = TracerInfo{
+			Id:    tr.Id(),
+			Flags: tr.Flags(),
+			Speed: tr.Speed(),
+		}
+	}
+	return nil
+}
+
+// Initialize a served tracer.
+func (ws *workerService) Init(args TracerArgs, reply *bool) error {
+	tr, unlock, err := ws.srv.lockTracer(args.Tracer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return tr.Init()
+}
+
+// Shutdown and cleanup a served tracer.
+func (ws *workerService) Close(args TracerArgs, reply *bool) error {
+	tr, unlock, err := ws.srv.lockTracer(args.Tracer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tr.Close()
+	return nil
+}
+
+// Apply a state change to a served tracer.
+func (ws *workerService) UpdateState(args UpdateStateArgs, reply *bool) error {
+	tr, unlock, err := ws.srv.lockTracer(args.Tracer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var data interface{}
+	switch args.Change {
+	case tracer.FrameDimensions:
+		data = args.FrameDims
+	case tracer.SceneData:
+		data = args.Scene
+	case tracer.CameraData:
+		data = args.Camera
+	case tracer.InstanceData:
+		data = args.Instances
+	default:
+		return ErrUnsupportedChangeType
+	}
+
+	_, err = tr.UpdateState(tracer.Synchronous, args.Change, data)
+	if err == nil && args.Change == tracer.FrameDimensions {
+		ws.srv.frameDims[args.Tracer] = args.FrameDims
+	}
+	return err
+}
+
+// Trace a block using a served tracer.
+func (ws *workerService) Trace(args TraceArgs, reply *TraceReply) error {
+	return ws.srv.trace(args.Tracer, args.Request, reply)
+}
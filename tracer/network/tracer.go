@@ -0,0 +1,450 @@
+// Package network implements distributed rendering across multiple machines.
+// Workers expose their local tracers via a Server while renderers use the
+// network Tracer to forward block requests to remote tracers and merge the
+// traced block radiance into a host-side frame accumulator.
+package network
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// The default timeout for connecting to workers.
+const defaultDialTimeout = 10 * time.Second
+
+// A tracer that forwards block requests to a tracer exposed by a remote
+// worker. Each network tracer maintains its own connection to the worker.
+//
+// The traced radiance is transferred back to the host so network tracers
+// can only merge output from other network tracers. When rendering with
+// network tracers, all tracers used by the renderer must be network tracers.
+type Tracer struct {
+	sync.Mutex
+
+	// The worker address and the index of the remote tracer.
+	addr   string
+	remote int
+	info   TracerInfo
+
+	dialTimeout time.Duration
+	client      *rpc.Client
+
+	// Functions invoked by SyncFramebuffer.
+	postProcess []PostProcessFunc
+
+	// If set, block requests are traced in groups of rowsPerGroup rows
+	// and rowGroupFn is invoked after receiving each group.
+	rowsPerGroup uint32
+	rowGroupFn   RowGroupFunc
+
+	// A buffer for asynchronous updates. Updates are grouped by type and
+	// latest updates always overwrite the previous ones.
+	changeBuffer map[tracer.ChangeType]interface{}
+
+	// Statistics for last rendered frame.
+	stats *tracer.Stats
+
+	// Frame dimensions.
+	frameW, frameH uint32
+
+	// The trace accumulator stores the radiance (3 float32 values per
+	// pixel) received for the last block request while the frame
+	// accumulator stores the radiance merged from all tracers.
+	traceAcc []float32
+	frameAcc []float32
+
+	// The tone-mapped output frame.
+	frame *image.RGBA
+}
+
+// Connect to a worker and create a network tracer for each one of the
+// tracers that it exposes.
+func Dial(addr string, opts ...TracerOption) ([]tracer.Tracer, error) {
+	probe, err := newTracer(addr, 0, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply TracersReply
+	err = probe.client.Call(serviceName+".Tracers", 0, &reply)
+	probe.client.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	tracers := make([]tracer.Tracer, 0, len(reply.Tracers))
+	for index, info := range reply.Tracers {
+		tr, err := newTracer(addr, index, opts)
+		if err != nil {
+			for _, tr := range tracers {
+				tr.(*Tracer).client.Close()
+			}
+			return nil, err
+		}
+		tr.info = info
+		tracers = append(tracers, tr)
+	}
+
+	return tracers, nil
+}
+
+// Create a network tracer for a remote tracer and connect to its worker.
+func newTracer(addr string, remote int, opts []TracerOption) (*Tracer, error) {
+	tr := &Tracer{
+		addr:         addr,
+		remote:       remote,
+		dialTimeout:  defaultDialTimeout,
+		changeBuffer: make(map[tracer.ChangeType]interface{}, 0),
+		stats:        &tracer.Stats{},
+	}
+
+	for _, opt := range opts {
+		err := opt(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, tr.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	tr.client = rpc.NewClient(conn)
+
+	return tr, nil
+}
+
+// Get tracer id.
+func (tr *Tracer) Id() string {
+	return fmt.Sprintf("%s@%s", tr.info.Id, tr.addr)
+}
+
+// Get tracer flags.
+func (tr *Tracer) Flags() tracer.Flag {
+	return (tr.info.Flags &^ tracer.Local) | tracer.Remote
+}
+
+// Get the computation speed estimate reported by the remote tracer.
+func (tr *Tracer) Speed() uint32 {
+	return tr.info.Speed
+}
+
+// Initialize the remote tracer.
+func (tr *Tracer) Init() error {
+	return tr.client.Call(serviceName+".Init", TracerArgs{Tracer: tr.remote}, new(bool))
+}
+
+// Shutdown and cleanup the remote tracer and close the connection to the
+// worker.
+func (tr *Tracer) Close() {
+	tr.Lock()
+	defer tr.Unlock()
+
+	if tr.client != nil {
+		tr.client.Call(serviceName+".Close", TracerArgs{Tracer: tr.remote}, new(bool))
+		tr.client.Close()
+		tr.client = nil
+	}
+
+	tr.traceAcc = nil
+	tr.frameAcc = nil
+	tr.frame = nil
+}
+
+// Retrieve last frame statistics.
+func (tr *Tracer) Stats() *tracer.Stats {
+	return tr.stats
+}
+
+// Update tracer state. Asynchronous updates are sent to the worker before
+// the next block request.
+func (tr *Tracer) UpdateState(mode tracer.UpdateMode, changeType tracer.ChangeType, data interface{}) (time.Duration, error) {
+	tr.changeBuffer[changeType] = data
+
+	if mode == tracer.Synchronous {
+		return tr.commitChanges()
+	}
+
+	return time.Duration(0), nil
+}
+
+// Send queued state changes to the worker.
+func (tr *Tracer) commitChanges() (time.Duration, error) {
+	if len(tr.changeBuffer) == 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	for changeType, data := range tr.changeBuffer {
+		args := UpdateStateArgs{Tracer: tr.remote, Change: changeType}
+		switch changeType {
+		case tracer.FrameDimensions:
+			dims, isDims := data.([2]uint32)
+			if !isDims {
+				return time.Since(start), ErrInvalidChangeData
+			}
+			args.FrameDims = dims
+			tr.resize(dims[0], dims[1])
+		case tracer.SceneData:
+			sc, isScene := data.(*scene.Scene)
+			if !isScene || sc == nil {
+				return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+			}
+			args.Scene = sc
+		case tracer.CameraData:
+			camera, isCamera := data.(*scene.Camera)
+			if !isCamera || camera == nil {
+				return time.Since(start), ErrInvalidChangeData
+			}
+			args.Camera = camera
+		case tracer.InstanceData:
+			// Instance updates are sent after any pending scene upload
+			continue
+		default:
+			return time.Since(start), ErrUnsupportedChangeType
+		}
+
+		err := tr.client.Call(serviceName+".UpdateState", args, new(bool))
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	if data, hasUpdate := tr.changeBuffer[tracer.InstanceData]; hasUpdate {
+		update, isUpdate := data.(*scene.InstanceUpdate)
+		if !isUpdate || update == nil {
+			return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+		}
+
+		args := UpdateStateArgs{Tracer: tr.remote, Change: tracer.InstanceData, Instances: update}
+		err := tr.client.Call(serviceName+".UpdateState", args, new(bool))
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	// Clear change buffer
+	tr.changeBuffer = make(map[tracer.ChangeType]interface{}, 0)
+	tr.stats.UpdateTime = time.Since(start)
+	return tr.stats.UpdateTime, nil
+}
+
+// Allocate the accumulation and frame buffers for the given frame dimensions.
+func (tr *Tracer) resize(frameW, frameH uint32) {
+	if tr.frameW == frameW && tr.frameH == frameH && tr.frame != nil {
+		return
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	numPixels := int(frameW * frameH)
+	tr.frameW, tr.frameH = frameW, frameH
+	tr.traceAcc = make([]float32, numPixels*3)
+	tr.frameAcc = make([]float32, numPixels*3)
+	tr.frame = image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+}
+
+// Forward a block request to the remote tracer and store the returned block
+// radiance in the trace accumulator. The reported render time includes the
+// network transfer time so that block schedulers can account for it.
+func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return tr.TraceContext(context.Background(), blockReq)
+}
+
+// Forward a block request to the remote tracer. If ctx is cancelled before
+// the worker replies, TraceContext returns the context error without waiting
+// for the reply; the worker still completes the request but its output is
+// discarded. If row groups are enabled, the block rows are requested one
+// group at a time and the rows of the groups received before the
+// cancellation remain in the trace accumulator. Implements
+// tracer.ContextTracer.
+func (tr *Tracer) TraceContext(ctx context.Context, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+
+	_, err := tr.commitChanges()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if blockReq.FrameW != tr.frameW || blockReq.FrameH != tr.frameH {
+		tr.resize(blockReq.FrameW, blockReq.FrameH)
+	}
+	if blockReq.BlockH > blockReq.FrameH || blockReq.BlockY > blockReq.FrameH-blockReq.BlockH {
+		return time.Since(start), ErrInvalidBlock
+	}
+
+	tr.stats.StackOverflows = 0
+	groupReq := *blockReq
+	for blockEnd := blockReq.BlockY + blockReq.BlockH; groupReq.BlockY < blockEnd; groupReq.BlockY += groupReq.BlockH {
+		groupReq.BlockH = blockEnd - groupReq.BlockY
+		if tr.rowsPerGroup != 0 && groupReq.BlockH > tr.rowsPerGroup {
+			groupReq.BlockH = tr.rowsPerGroup
+		}
+
+		err = tr.traceRows(ctx, &groupReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	tr.stats.BlockW = blockReq.BlockW
+	tr.stats.BlockH = blockReq.BlockH
+	tr.stats.RenderTime = time.Since(start)
+	return tr.stats.RenderTime, nil
+}
+
+// Forward the request for a group of block rows to the remote tracer, store
+// the returned radiance in the trace accumulator and invoke the row group
+// function if one is set.
+func (tr *Tracer) traceRows(ctx context.Context, groupReq *tracer.BlockRequest) error {
+	var reply TraceReply
+	call := tr.client.Go(serviceName+".Trace", TraceArgs{Tracer: tr.remote, Request: *groupReq}, &reply, nil)
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			return call.Error
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	rowOffset := groupReq.BlockY * groupReq.FrameW * 3
+	rowCount := groupReq.BlockH * groupReq.FrameW * 3
+	if uint32(len(reply.Radiance)) != rowCount {
+		return ErrInvalidBlock
+	}
+	copy(tr.traceAcc[rowOffset:rowOffset+rowCount], reply.Radiance)
+	tr.stats.StackOverflows += reply.Stats.StackOverflows
+
+	if tr.rowGroupFn == nil {
+		return nil
+	}
+	return tr.rowGroupFn(tr, groupReq, reply.Radiance)
+}
+
+// Merge the trace accumulator rows for a block request from another network
+// tracer into this tracer's frame accumulator. If the request does not include
+// any previously accumulated samples, the frame accumulator rows are
+// overwritten.
+func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	src, isNetworkTracer := other.(*Tracer)
+	if !isNetworkTracer {
+		return 0, ErrUnsupportedMerge
+	}
+
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	if blockReq.BlockH > blockReq.FrameH || blockReq.BlockY > blockReq.FrameH-blockReq.BlockH {
+		return time.Since(start), ErrInvalidBlock
+	}
+	rowOffset := blockReq.BlockY * blockReq.FrameW * 3
+	rowCount := blockReq.BlockH * blockReq.FrameW * 3
+	if int(rowOffset+rowCount) > len(tr.frameAcc) || int(rowOffset+rowCount) > len(src.traceAcc) {
+		return time.Since(start), ErrInvalidBlock
+	}
+
+	dst := tr.frameAcc[rowOffset : rowOffset+rowCount]
+	if blockReq.AccumulatedSamples == 0 {
+		copy(dst, src.traceAcc[rowOffset:rowOffset+rowCount])
+	} else {
+		for index, v := range src.traceAcc[rowOffset : rowOffset+rowCount] {
+			dst[index] += v
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Tone-map the frame accumulator into the output frame buffer and run the
+// registered post-process functions.
+func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+
+	radiance := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+	_, err := tr.ReadRadiance(blockReq, radiance)
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	tr.Lock()
+	err = tracer.Tonemap(tr.frame, radiance, blockReq.FrameW, blockReq.FrameH, tracer.DefaultPostStages(blockReq.Exposure)...)
+	tr.Unlock()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	for _, fn := range tr.postProcess {
+		err = fn(tr, blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the linear radiance stored in the frame accumulator normalized by the
+// total number of accumulated samples.
+func (tr *Tracer) ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	numValues := int(blockReq.FrameW * blockReq.FrameH * 3)
+	if len(out) < numValues || len(tr.frameAcc) < numValues {
+		return time.Since(start), ErrBufferTooSmall
+	}
+
+	weight := blockReq.SampleWeight()
+	for index, v := range tr.frameAcc[:numValues] {
+		out[index] = v * weight
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the linear radiance of a single frame pixel from the frame
+// accumulator. Implements tracer.PixelProber.
+func (tr *Tracer) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	probe, pixel, err := tracer.NewPixelProbe(blockReq, x, y)
+	if err != nil {
+		return probe, err
+	}
+
+	tr.Lock()
+	defer tr.Unlock()
+
+	offset := pixel * 3
+	if offset+3 > len(tr.frameAcc) {
+		return probe, ErrBufferTooSmall
+	}
+
+	weight := blockReq.SampleWeight()
+	probe.Radiance = [3]float32{tr.frameAcc[offset] * weight, tr.frameAcc[offset+1] * weight, tr.frameAcc[offset+2] * weight}
+	return probe, nil
+}
+
+// Read the output frame buffer into a user-provided target. See
+// tracer.CopyFrame for the list of supported target types.
+func (tr *Tracer) ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error) {
+	start := time.Now()
+	tr.Lock()
+	defer tr.Unlock()
+
+	if tr.frame == nil {
+		return time.Since(start), ErrBufferTooSmall
+	}
+
+	return time.Since(start), tracer.CopyFrame(dst, tr.frame.Pix, blockReq.FrameW, blockReq.FrameH)
+}
@@ -6,6 +6,8 @@
 #include "dielectric.cl"
 #include "rough_conductor.cl"
 #include "rough_dielectric.cl"
+#include "principled.cl"
+#include "subsurface.cl"
 
 #ifndef BXDF_INVALID
 	#define BXDF_INVALID 0
@@ -16,6 +18,8 @@
 #define BXDF_TYPE_ROUGHT_CONDUCTOR 1 << 4
 #define BXDF_TYPE_DIELECTRIC       1 << 5
 #define BXDF_TYPE_ROUGH_DIELECTRIC 1 << 6
+#define BXDF_TYPE_PRINCIPLED       1 << 7
+#define BXDF_TYPE_SUBSURFACE       1 << 8
 
 #define BXDF_IS_EMISSIVE(t) (t == BXDF_TYPE_EMISSIVE)
 #define BXDF_IS_SINGULAR(t) ((t & (BXDF_TYPE_CONDUCTOR | BXDF_TYPE_DIELECTRIC)) != 0)
@@ -47,6 +51,10 @@ float3 bxdfGetSample(
 			return roughConductorSample(surface, matNode, texMeta, texData, randSample, inRayDir, outRayDir, pdf);
 		case BXDF_TYPE_ROUGH_DIELECTRIC:
 			return roughDielectricSample(surface, matNode, texMeta, texData, randSample, inRayDir, outRayDir, pdf);
+		case BXDF_TYPE_PRINCIPLED:
+			return principledSample(surface, matNode, texMeta, texData, randSample, inRayDir, outRayDir, pdf);
+		case BXDF_TYPE_SUBSURFACE:
+			return subsurfaceSample(surface, matNode, texMeta, texData, randSample, inRayDir, outRayDir, pdf);
 	}
 
 	return (float3)(0.0f, 0.0f, 0.0f);
@@ -73,6 +81,10 @@ float bxdfGetPdf(
 			return roughConductorPdf(surface, matNode, texMeta, texData, inRayDir, outRayDir);
 		case BXDF_TYPE_ROUGH_DIELECTRIC:
 			return roughDielectricPdf(surface, matNode, texMeta, texData, inRayDir, outRayDir);
+		case BXDF_TYPE_PRINCIPLED:
+			return principledPdf(surface, matNode, texMeta, texData, inRayDir, outRayDir);
+		case BXDF_TYPE_SUBSURFACE:
+			return subsurfacePdf(surface, matNode, texMeta, texData, inRayDir, outRayDir);
 	}
 
 	return 0.0f;
@@ -99,6 +111,10 @@ float3 bxdfEval(
 			return roughConductorEval(surface, matNode, texMeta, texData, inRayDir, outRayDir);
 		case BXDF_TYPE_ROUGH_DIELECTRIC:
 			return roughDielectricEval(surface, matNode, texMeta, texData, inRayDir, outRayDir);
+		case BXDF_TYPE_PRINCIPLED:
+			return principledEval(surface, matNode, texMeta, texData, inRayDir, outRayDir);
+		case BXDF_TYPE_SUBSURFACE:
+			return subsurfaceEval(surface, matNode, texMeta, texData, inRayDir, outRayDir);
 	}
 
 	return (float3)(0.0f, 0.0f, 0.0f);
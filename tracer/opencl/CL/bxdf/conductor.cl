@@ -24,7 +24,7 @@ float3 conductorSample(Surface *surface, MaterialNode *matNode, __global Texture
 		? fresnelForDielectric(matNode->extIOR, matNode->intIOR, iDotN)
 		: 1.0f;
 
-	float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+	float3 ks = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
 	return iDotN != 0.0f ? f * ks / iDotN : 0.0f;
 }
 
@@ -57,7 +57,7 @@ float3 conductorEval(Surface *surface, MaterialNode *matNode, __global TextureMe
 		? fresnelForDielectric(matNode->extIOR, matNode->intIOR, iDotN)
 		: 1.0f;
 
-	float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+	float3 ks = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
 	return iDotN != 0.0f ? f * ks / iDotN : 0.0f;
 }
 #endif
@@ -34,11 +34,11 @@ float3 dielecticSample(Surface *surface, MaterialNode *matNode, __global Texture
 	// always pick the reflection ray
 	if( cosTSq <= 0.0f || randSample.x <= f ){
 		*outRayDir = -sign(iDotN) * 2.0f * iDotN * surface->normal - inRayDir;
-		kVal = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+		kVal = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
 		*pdf = cosTSq <= 0.0f ? 1.0f : f;
 	} else {
 		*outRayDir = (eta * iDotN - sign(iDotN)*sqrt(cosTSq))*surface->normal - eta * inRayDir;
-		kVal = eta * eta * matGetSample3f(surface->uv, matNode->transmittance, matNode->transmittanceTex, texMeta, texData);
+		kVal = eta * eta * matGetSample3f(surface->uv, surface->texLod, matNode->transmittance, matNode->transmittanceTex, texMeta, texData);
 		*pdf = 1.0f - f;
 	}
 	
@@ -14,7 +14,7 @@ float3 diffuseSample(Surface *surface, MaterialNode *matNode, __global TextureMe
 	
 	*pdf = dot(surface->normal, *rayOutDir) * C_1_PI;
 
-	float3 kd = matGetSample3f(surface->uv, matNode->reflectance, matNode->reflectanceTex, texMeta, texData);
+	float3 kd = matGetSample3f(surface->uv, surface->texLod, matNode->reflectance, matNode->reflectanceTex, texMeta, texData);
 	
 	return kd * C_1_PI;
 }
@@ -27,7 +27,7 @@ float diffusePdf(Surface *surface, MaterialNode *matNode, float3 rayOutDir){
 
 // Evaluate BXDF for lambert surface given a pre-calculated bounce ray.
 float3 diffuseEval(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 rayOutDir){
-	float3 kd = matGetSample3f(surface->uv, matNode->reflectance, matNode->reflectanceTex, texMeta, texData);
+	float3 kd = matGetSample3f(surface->uv, surface->texLod, matNode->reflectance, matNode->reflectanceTex, texMeta, texData);
 	return kd * C_1_PI;
 }
 #endif
@@ -0,0 +1,241 @@
+#ifndef BXDF_PRINCIPLED_CL
+#define BXDF_PRINCIPLED_CL
+
+// GGX roughness (alpha) of the clearcoat layer
+#define PRINCIPLED_CLEARCOAT_ALPHA 0.05f
+
+// Normal incidence fresnel reflectance of the clearcoat layer (IOR = 1.5)
+#define PRINCIPLED_CLEARCOAT_F0 0.04f
+
+// Amount of base color tint applied to the sheen lobe
+#define PRINCIPLED_SHEEN_TINT 0.5f
+
+// The evaluated parameters of a principled material at a surface point.
+typedef struct {
+	float3 baseColor;
+	float metallic;
+	float specular;
+	float sheen;
+	float clearcoat;
+	float transmission;
+
+	// Perceptual roughness and GGX alpha
+	float roughness;
+	float alpha;
+
+	// Surface normal flipped so it faces the incoming ray
+	float3 normal;
+
+	// IORs on the incoming and transmitted side of the surface
+	float etaI;
+	float etaT;
+
+	// Probabilities for sampling the diffuse, specular, clearcoat and
+	// transmission lobes.
+	float4 lobePdf;
+} PrincipledParams;
+
+void principledInit(PrincipledParams *params, Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir);
+float3 principledSample( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf);
+float principledPdf( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
+float3 principledEval( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
+float _principledGetPdf(PrincipledParams *params, float3 inRayDir, float3 outRayDir);
+float3 _principledEval(PrincipledParams *params, float3 inRayDir, float3 outRayDir);
+float3 _principledRefractionHalfVector(PrincipledParams *params, float3 inRayDir, float3 outRayDir);
+float _principledSchlickWeight(float cosTheta);
+
+// Evaluate the textures and lobe weights of a principled material.
+void principledInit(PrincipledParams *params, Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir){
+	params->baseColor = matGetSample3f(surface->uv, surface->texLod, matNode->principledBase.xyz, matNode->baseColorTex, texMeta, texData);
+	params->metallic = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->principledWeights.x, matNode->metallicTex, texMeta, texData), 0.0f, 1.0f);
+	params->specular = matNode->principledWeights.y;
+	params->sheen = matNode->principledWeights.z;
+	params->clearcoat = matNode->principledWeights.w;
+	params->transmission = matNode->principledBase.w;
+
+	// Use Disney's remapping: a = roughness^2
+	params->roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+	params->alpha = params->roughness * params->roughness;
+
+	// If hitting from the inside we need to flip the normal and swap the eta
+	params->normal = surface->normal;
+	params->etaI = matNode->extIOR;
+	params->etaT = matNode->intIOR;
+	if( dot(inRayDir, surface->normal) < 0.0f ){
+		params->normal = -surface->normal;
+		params->etaI = matNode->intIOR;
+		params->etaT = matNode->extIOR;
+	}
+
+	float dielectricWeight = 1.0f - params->metallic;
+	params->lobePdf = (float4)(
+		dielectricWeight * (1.0f - params->transmission),
+		1.0f,
+		0.25f * params->clearcoat,
+		dielectricWeight * params->transmission
+	);
+	params->lobePdf /= params->lobePdf.x + params->lobePdf.y + params->lobePdf.z + params->lobePdf.w;
+}
+
+// Sample one of the principled lobes. The returned value and pdf account for
+// all lobes that could have generated the outgoing ray.
+float3 principledSample( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf){
+	PrincipledParams params;
+	principledInit(&params, surface, matNode, texMeta, texData, inRayDir);
+
+	// Select a lobe using randSample.x and remap it back to [0, 1) so it
+	// can be reused for sampling the lobe.
+	float4 lobePdf = params.lobePdf;
+	float3 h;
+	if( randSample.x < lobePdf.x ){
+		randSample.x /= lobePdf.x;
+		*outRayDir = cosWeightedHemisphereGetSample(params.normal, randSample);
+	} else if( randSample.x < lobePdf.x + lobePdf.y ){
+		randSample.x = (randSample.x - lobePdf.x) / lobePdf.y;
+		h = ggxGetSample(params.alpha, inRayDir, params.normal, randSample);
+		*outRayDir = 2.0f * dot(inRayDir, h) * h - inRayDir;
+	} else if( randSample.x < lobePdf.x + lobePdf.y + lobePdf.z || lobePdf.w <= 0.0f ){
+		randSample.x = lobePdf.z > 0.0f ? clamp((randSample.x - lobePdf.x - lobePdf.y) / lobePdf.z, 0.0f, 1.0f - FLT_EPSILON) : 0.0f;
+		h = ggxGetSample(PRINCIPLED_CLEARCOAT_ALPHA, inRayDir, params.normal, randSample);
+		*outRayDir = 2.0f * dot(inRayDir, h) * h - inRayDir;
+	} else {
+		randSample.x = clamp((randSample.x - lobePdf.x - lobePdf.y - lobePdf.z) / lobePdf.w, 0.0f, 1.0f - FLT_EPSILON);
+		h = ggxGetSample(params.alpha, inRayDir, params.normal, randSample);
+
+		// Refract I over h to get O; rays undergoing total internal
+		// reflection are rejected.
+		float eta = params.etaI / params.etaT;
+		float iDotH = dot(inRayDir, h);
+		float cosTSq = 1.0f + eta * eta * (iDotH * iDotH - 1.0f);
+		if( cosTSq <= 0.0f ){
+			*pdf = 0.0f;
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+		*outRayDir = (eta * iDotH - sqrt(cosTSq)) * h - eta * inRayDir;
+	}
+
+	*pdf = _principledGetPdf(&params, inRayDir, *outRayDir);
+	return _principledEval(&params, inRayDir, *outRayDir);
+}
+
+// Get PDF given an outbound ray
+float principledPdf( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
+	PrincipledParams params;
+	principledInit(&params, surface, matNode, texMeta, texData, inRayDir);
+	return _principledGetPdf(&params, inRayDir, outRayDir);
+}
+
+// Evaluate principled BXDF for the selected outgoing ray.
+float3 principledEval( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
+	PrincipledParams params;
+	principledInit(&params, surface, matNode, texMeta, texData, inRayDir);
+	return _principledEval(&params, inRayDir, outRayDir);
+}
+
+// Calculate the pdf of the lobe mixture for generating outRayDir.
+float _principledGetPdf(PrincipledParams *params, float3 inRayDir, float3 outRayDir){
+	float oDotN = dot(outRayDir, params->normal);
+
+	// This is a reflected ray
+	if( oDotN > 0.0f ){
+		float3 h = normalize(inRayDir + outRayDir);
+		return params->lobePdf.x * oDotN * C_1_PI +
+			params->lobePdf.y * ggxGetReflectionPdf(params->alpha, inRayDir, outRayDir, params->normal, h) +
+			params->lobePdf.z * ggxGetReflectionPdf(PRINCIPLED_CLEARCOAT_ALPHA, inRayDir, outRayDir, params->normal, h);
+	}
+
+	if( params->lobePdf.w <= 0.0f ){
+		return 0.0f;
+	}
+
+	float3 h = _principledRefractionHalfVector(params, inRayDir, outRayDir);
+	return params->lobePdf.w * ggxGetRefractionPdf(params->alpha, params->etaI, params->etaT, inRayDir, outRayDir, params->normal, h);
+}
+
+// Evaluate the sum of the principled lobes for outRayDir.
+float3 _principledEval(PrincipledParams *params, float3 inRayDir, float3 outRayDir){
+	float iDotN = dot(inRayDir, params->normal);
+	float oDotN = dot(outRayDir, params->normal);
+	if( iDotN <= 0.0f ){
+		return (float3)(0.0f, 0.0f, 0.0f);
+	}
+
+	float dielectricWeight = 1.0f - params->metallic;
+
+	// Refracted ray; evaluate the transmission lobe (equation 21)
+	if( oDotN < 0.0f ){
+		float transmissionWeight = dielectricWeight * params->transmission;
+		if( transmissionWeight <= 0.0f ){
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+
+		float3 h = _principledRefractionHalfVector(params, inRayDir, outRayDir);
+		float iDotH = fabs(dot(inRayDir, h));
+		float oDotH = fabs(dot(outRayDir, h));
+
+		float focusTermDenom = iDotN * oDotN * (params->etaI * iDotH + params->etaT * oDotH) * (params->etaI * iDotH + params->etaT * oDotH);
+		if( focusTermDenom == 0.0f ){
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+		float focusTerm = fabs(params->etaT * params->etaT * iDotH * oDotH / focusTermDenom);
+
+		float f = fresnelForDielectric(params->etaI, params->etaT, iDotH);
+		float d = ggxGetD(params->alpha, params->normal, h);
+		float g = ggxGetG(params->alpha, inRayDir, outRayDir, params->normal, h);
+		return params->baseColor * transmissionWeight * (1.0f - f) * d * g * focusTerm;
+	}
+
+	float3 h = normalize(inRayDir + outRayDir);
+	float oDotH = dot(outRayDir, h);
+	float fh = _principledSchlickWeight(oDotH);
+
+	// Burley diffuse with a retro-reflection term that depends on roughness
+	float fd90 = 0.5f + 2.0f * params->roughness * oDotH * oDotH;
+	float fdI = 1.0f + (fd90 - 1.0f) * _principledSchlickWeight(iDotN);
+	float fdO = 1.0f + (fd90 - 1.0f) * _principledSchlickWeight(oDotN);
+	float3 value = params->baseColor * fdI * fdO * C_1_PI;
+
+	// Grazing angle sheen optionally tinted by the base color hue
+	if( params->sheen > 0.0f ){
+		float lum = 0.2126f * params->baseColor.x + 0.7152f * params->baseColor.y + 0.0722f * params->baseColor.z;
+		float3 tint = lum > 0.0f ? params->baseColor / lum : (float3)(1.0f, 1.0f, 1.0f);
+		value += params->sheen * mix((float3)(1.0f, 1.0f, 1.0f), tint, PRINCIPLED_SHEEN_TINT) * fh;
+	}
+	value *= dielectricWeight * (1.0f - params->transmission);
+
+	// GGX specular; metals tint the reflection with the base color
+	float3 f0 = mix((float3)(0.08f * params->specular), params->baseColor, params->metallic);
+	float3 f = f0 + ((float3)(1.0f, 1.0f, 1.0f) - f0) * fh;
+	float d = ggxGetD(params->alpha, params->normal, h);
+	float g = ggxGetG(params->alpha, inRayDir, outRayDir, params->normal, h);
+	float denom = 4.0f * iDotN * oDotN;
+	if( denom > 0.0f ){
+		value += f * d * g / denom;
+	}
+
+	// Clearcoat layer
+	if( params->clearcoat > 0.0f && denom > 0.0f ){
+		float fc = PRINCIPLED_CLEARCOAT_F0 + (1.0f - PRINCIPLED_CLEARCOAT_F0) * fh;
+		d = ggxGetD(PRINCIPLED_CLEARCOAT_ALPHA, params->normal, h);
+		g = ggxGetG(PRINCIPLED_CLEARCOAT_ALPHA, inRayDir, outRayDir, params->normal, h);
+		value += 0.25f * params->clearcoat * fc * d * g / denom;
+	}
+
+	return value;
+}
+
+// Calculate the halfway transmission vector (equation 16) and flip it so that
+// it points to the same side as the normal.
+float3 _principledRefractionHalfVector(PrincipledParams *params, float3 inRayDir, float3 outRayDir){
+	float3 h = normalize(-(params->etaI * inRayDir + params->etaT * outRayDir));
+	return dot(h, params->normal) < 0.0f ? -h : h;
+}
+
+// Schlick's fresnel weight: (1 - cosTheta)^5
+float _principledSchlickWeight(float cosTheta){
+	float c = clamp(1.0f - cosTheta, 0.0f, 1.0f);
+	float c2 = c * c;
+	return c2 * c2 * c;
+}
+
+#endif
@@ -8,10 +8,10 @@ float3 roughConductorEval( Surface *surface, MaterialNode *matNode, __global Tex
 // Sample microfacet surface
 float3 roughConductorSample( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf){
 	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+	float roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
 	roughness *= roughness;
 
-	float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+	float3 ks = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
 
 	// Sample GGX distribution to get halfway vector
 	float3 h = ggxGetSample(roughness, inRayDir, surface->normal, randSample);
@@ -42,7 +42,7 @@ float3 roughConductorSample( Surface *surface, MaterialNode *matNode, __global T
 // Get PDF given an outbound ray
 float roughConductorPdf( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
 	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+	float roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
 	roughness *= roughness;
 
 	float3 h = normalize(inRayDir + outRayDir);
@@ -53,10 +53,10 @@ float roughConductorPdf( Surface *surface, MaterialNode *matNode, __global Textu
 // Evaluate microfacet BXDF for the selected outgoing ray.
 float3 roughConductorEval( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
 	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+	float roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
 	roughness *= roughness;
 
-	float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+	float3 ks = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
 
 	float iDotN = dot(inRayDir, surface->normal);
 	float oDotN = dot(outRayDir, surface->normal);
@@ -1,153 +1,110 @@
 #ifndef BXDF_ROUGH_DIELECTRIC_CL
 #define BXDF_ROUGH_DIELECTRIC_CL
 
+// The min probability for selecting either the reflection or the refraction
+// lobe. Lobes are selected using the fresnel value for the surface normal
+// which may differ from the fresnel value of the sampled microfacet (e.g.
+// close to the total internal reflection angle), so neither lobe may be
+// skipped.
+#define ROUGH_DIELECTRIC_MIN_LOBE_PDF 0.05f
+
 float3 roughDielectricSample( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf);
 float roughDielectricPdf( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
 float3 roughDielectricEval( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
+float _roughDielectricInit( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 *normal, float *etaI, float *etaT);
+float _roughDielectricFresnel(float etaI, float etaT, float iDotH);
+float _roughDielectricReflectionPdf(float etaI, float etaT, float iDotN);
+float3 _roughDielectricRefractionHalfVector(float etaI, float etaT, float3 inRayDir, float3 outRayDir, float3 normal);
 
-// Sample microfacet surface
+// Sample the reflection or the refraction lobe of a GGX microfacet surface.
+// The returned pdf includes the probability for selecting the lobe.
 float3 roughDielectricSample( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf){
-	float iDotN = dot(inRayDir, surface->normal);
-	
-	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
-	roughness *= roughness;
-
-	// If hitting from the inside we need to swap the eta 
-	float etaI = matNode->extIOR;
-	float etaT = matNode->intIOR;
-	if( iDotN < 0.0f ){
-		float tmp = etaI;
-		etaI = etaT;
-		etaT = tmp;
-	}
+	float3 normal;
+	float etaI, etaT;
+	float roughness = _roughDielectricInit(surface, matNode, texMeta, texData, inRayDir, &normal, &etaI, &etaT);
+
+	// Select a lobe using randSample.x and remap it back to [0, 1) so it
+	// can be reused for sampling the GGX distribution.
+	float reflectionPdf = _roughDielectricReflectionPdf(etaI, etaT, dot(inRayDir, normal));
+	int sampleReflection = randSample.x < reflectionPdf;
+	randSample.x = sampleReflection
+		? randSample.x / reflectionPdf
+		: clamp((randSample.x - reflectionPdf) / (1.0f - reflectionPdf), 0.0f, 1.0f - FLT_EPSILON);
 
-	float eta = etaI / etaT;
-	
 	// Sample GGX distribution to get halfway vector
-	float3 h = ggxGetSample(roughness, inRayDir, surface->normal, randSample);
-
-	// Calculate fresnel 
-	float f = fresnelForDielectric(etaI, etaT, iDotN);
-	
-	float cosTSq = 1.0f + eta * (iDotN * iDotN - 1.0f);
-
-	// Based on the fresnel value randomly sample the reflection ray.
-	// In the case where the ray undergoes total internal reflection we 
-	// always pick the reflection ray
-	if( cosTSq <= 0.0f|| randSample.x <= f ){
-		// Reflect I over h to get O
-		*outRayDir = 2.0f * dot(inRayDir, h) * h - inRayDir;
-		
-		float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
-	
-		// Recalculate halfway vector (equation 13)
-		float iDotN = dot(inRayDir, surface->normal);
-		float oDotN = dot(*outRayDir, surface->normal);
-		h = normalize(inRayDir + *outRayDir);
-		*pdf = cosTSq <= 0.0f ? 1.0f : ggxGetReflectionPdf(roughness, inRayDir, *outRayDir, surface->normal, h);
-		
-		// Calculate d and g for GGX
-		float d = ggxGetD(roughness, surface->normal, h);
-		float g = ggxGetG(roughness, inRayDir, *outRayDir, surface->normal, h);
-
-		float denom = 4.0f * iDotN * oDotN;
-		return denom > 0.0f ?  ks * f * d * g / denom : 0.0f;
+	float3 h = ggxGetSample(roughness, inRayDir, normal, randSample);
+	float iDotH = dot(inRayDir, h);
+
+	if( sampleReflection ){
+		// Reflect I over h to get O; rays reflected below the surface
+		// are rejected.
+		*outRayDir = 2.0f * iDotH * h - inRayDir;
+		if( dot(*outRayDir, normal) <= 0.0f ){
+			*pdf = 0.0f;
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+	} else {
+		// Refract I over h to get O; rays undergoing total internal
+		// reflection are rejected.
+		float eta = etaI / etaT;
+		float cosTSq = 1.0f + eta * eta * (iDotH * iDotH - 1.0f);
+		if( iDotH <= 0.0f || cosTSq <= 0.0f ){
+			*pdf = 0.0f;
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+		*outRayDir = (eta * iDotH - sqrt(cosTSq)) * h - eta * inRayDir;
 	}
 
-	// Refract I over h to get O
-	*outRayDir = (eta * iDotN - sign(iDotN)*sqrt(cosTSq))*h - eta * inRayDir;
-
-	// Recalculate halfway transmission vector (equation 16)
-	h = normalize(-(etaI * inRayDir + etaT * *outRayDir));
-	*pdf = ggxGetRefractionPdf(roughness, etaI, etaT, inRayDir, *outRayDir, surface->normal, h);
-
-	float iDotH = fabs(dot(inRayDir, h));
-	float oDotH = fabs(dot(*outRayDir, h));
-	float oDotN = dot(*outRayDir, surface->normal);
-
-	// Calc focus term (see equation 21)
-	float focusTermDenom = iDotN * oDotN * (etaI * iDotH + etaT * oDotH) * (etaI * iDotH + etaT * oDotH);
-	if( focusTermDenom == 0.0f ){
-		return (float3)(0.0f, 0.0f, 0.0f);
-	}
-	float focusTerm = fabs(etaT * etaT * iDotH * oDotH / focusTermDenom);
-	
-	// Calculate d and g for GGX
-	float d = ggxGetD(roughness, surface->normal, h);
-	float g = ggxGetG(roughness, inRayDir, *outRayDir, surface->normal, h);
-
-	// Eval sample (equation 21)
-	float3 tf = matGetSample3f(surface->uv, matNode->transmittance, matNode->transmittanceTex, texMeta, texData);
-	return tf * (1.0f - f) * d * g * focusTerm;
+	*pdf = roughDielectricPdf(surface, matNode, texMeta, texData, inRayDir, *outRayDir);
+	return roughDielectricEval(surface, matNode, texMeta, texData, inRayDir, *outRayDir);
 }
 
 // Get PDF given an outbound ray
 float roughDielectricPdf( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
-	float iDotN = dot(inRayDir, surface->normal);
-	
-	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
-	roughness *= roughness;
+	float3 normal;
+	float etaI, etaT;
+	float roughness = _roughDielectricInit(surface, matNode, texMeta, texData, inRayDir, &normal, &etaI, &etaT);
+	float reflectionPdf = _roughDielectricReflectionPdf(etaI, etaT, dot(inRayDir, normal));
 
 	// This is a reflected ray
-	if( iDotN > 0.0f ){
+	if( dot(outRayDir, normal) > 0.0f ){
 		float3 h = normalize(inRayDir + outRayDir);
-		return ggxGetReflectionPdf(roughness, inRayDir, outRayDir, surface->normal, h);	
-	}
-	
-	// If hitting from the inside we need to swap the eta 
-	float etaI = matNode->extIOR;
-	float etaT = matNode->intIOR;
-	if( iDotN < 0.0f ){
-		float tmp = etaI;
-		etaI = etaT;
-		etaT = tmp;
+		return reflectionPdf * ggxGetReflectionPdf(roughness, inRayDir, outRayDir, normal, h);
 	}
 
-	float3 h = normalize(-(etaI * inRayDir + etaT * outRayDir));
-	return ggxGetRefractionPdf(roughness, etaI, etaT, inRayDir, outRayDir, surface->normal, h);
+	float3 h = _roughDielectricRefractionHalfVector(etaI, etaT, inRayDir, outRayDir, normal);
+	return (1.0f - reflectionPdf) * ggxGetRefractionPdf(roughness, etaI, etaT, inRayDir, outRayDir, normal, h);
 }
 
 // Evaluate microfacet BXDF for the selected outgoing ray.
 float3 roughDielectricEval( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
-	float iDotN = dot(inRayDir, surface->normal);
-	float oDotN = dot(outRayDir, surface->normal);
-	
-	// Use Disney's remapping: a = roughness^2
-	float roughness = clamp(matGetSample1f(surface->uv, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
-	roughness *= roughness;
-
-	// If hitting from the inside we need to swap the eta 
-	float etaI = matNode->extIOR;
-	float etaT = matNode->intIOR;
-	if( iDotN < 0.0f ){
-		float tmp = etaI;
-		etaI = etaT;
-		etaT = tmp;
-	}
+	float3 normal;
+	float etaI, etaT;
+	float roughness = _roughDielectricInit(surface, matNode, texMeta, texData, inRayDir, &normal, &etaI, &etaT);
 
-	// Calculate fresnel 
-	float f = fresnelForDielectric(etaI, etaT, iDotN);
+	float iDotN = dot(inRayDir, normal);
+	float oDotN = dot(outRayDir, normal);
+	if( iDotN <= 0.0f ){
+		return (float3)(0.0f, 0.0f, 0.0f);
+	}
 
-	// This is a reflected ray
-	if(iDotN > 0.0f) {
-		float3 ks = matGetSample3f(surface->uv, matNode->specularity, matNode->specularityTex, texMeta, texData);
+	// This is a reflected ray (equation 20)
+	if( oDotN > 0.0f ){
 		float3 h = normalize(inRayDir + outRayDir);
+		float f = _roughDielectricFresnel(etaI, etaT, dot(inRayDir, h));
 
 		// Calculate d and g for GGX
-		float d = ggxGetD(roughness, surface->normal, h);
-		float g = ggxGetG(roughness, inRayDir, outRayDir, surface->normal, h);
+		float d = ggxGetD(roughness, normal, h);
+		float g = ggxGetG(roughness, inRayDir, outRayDir, normal, h);
 
-		// Eval sample (equation 20)
-		float denom = 4.0f * iDotN * oDotN;
-		return denom > 0.0f ?  ks * f * d * g / denom : 0.0f;
+		float3 ks = matGetSample3f(surface->uv, surface->texLod, matNode->specularity, matNode->specularityTex, texMeta, texData);
+		return ks * f * d * g / (4.0f * iDotN * oDotN);
 	}
 
-	float3 h = normalize(-(etaI * inRayDir + etaT * outRayDir));
-
+	float3 h = _roughDielectricRefractionHalfVector(etaI, etaT, inRayDir, outRayDir, normal);
 	float iDotH = fabs(dot(inRayDir, h));
 	float oDotH = fabs(dot(outRayDir, h));
+	float f = _roughDielectricFresnel(etaI, etaT, iDotH);
 
 	// Calc focus term (see equation 21)
 	float focusTermDenom = iDotN * oDotN * (etaI * iDotH + etaT * oDotH) * (etaI * iDotH + etaT * oDotH);
@@ -155,14 +112,55 @@ float3 roughDielectricEval( Surface *surface, MaterialNode *matNode, __global Te
 		return (float3)(0.0f, 0.0f, 0.0f);
 	}
 	float focusTerm = fabs(etaT * etaT * iDotH * oDotH / focusTermDenom);
-	
+
 	// Calculate d and g for GGX
-	float d = ggxGetD(roughness, surface->normal, h);
-	float g = ggxGetG(roughness, inRayDir, outRayDir, surface->normal, h);
+	float d = ggxGetD(roughness, normal, h);
+	float g = ggxGetG(roughness, inRayDir, outRayDir, normal, h);
 
 	// Eval sample (equation 21)
-	float3 tf = matGetSample3f(surface->uv, matNode->transmittance, matNode->transmittanceTex, texMeta, texData);
+	float3 tf = matGetSample3f(surface->uv, surface->texLod, matNode->transmittance, matNode->transmittanceTex, texMeta, texData);
 	return tf * (1.0f - f) * d * g * focusTerm;
 }
 
+// Get the GGX roughness for the surface, the surface normal flipped so it
+// faces the incoming ray and the IORs on the incoming and transmitted side.
+float _roughDielectricInit( Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 *normal, float *etaI, float *etaT){
+	// Use Disney's remapping: a = roughness^2
+	float roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+
+	// If hitting from the inside we need to flip the normal and swap the eta
+	*normal = surface->normal;
+	*etaI = matNode->extIOR;
+	*etaT = matNode->intIOR;
+	if( dot(inRayDir, surface->normal) < 0.0f ){
+		*normal = -surface->normal;
+		*etaI = matNode->intIOR;
+		*etaT = matNode->extIOR;
+	}
+
+	return roughness * roughness;
+}
+
+// Calculate the fresnel reflectance for a microfacet. Microfacets that cause
+// total internal reflection reflect all light.
+float _roughDielectricFresnel(float etaI, float etaT, float iDotH){
+	float eta = etaI / etaT;
+	if( 1.0f + eta * eta * (iDotH * iDotH - 1.0f) <= 0.0f ){
+		return 1.0f;
+	}
+	return fresnelForDielectric(etaI, etaT, iDotH);
+}
+
+// Get the probability for sampling the reflection lobe.
+float _roughDielectricReflectionPdf(float etaI, float etaT, float iDotN){
+	return clamp(_roughDielectricFresnel(etaI, etaT, iDotN), ROUGH_DIELECTRIC_MIN_LOBE_PDF, 1.0f - ROUGH_DIELECTRIC_MIN_LOBE_PDF);
+}
+
+// Calculate the halfway transmission vector (equation 16) and flip it so that
+// it points to the same side as the normal.
+float3 _roughDielectricRefractionHalfVector(float etaI, float etaT, float3 inRayDir, float3 outRayDir, float3 normal){
+	float3 h = normalize(-(etaI * inRayDir + etaT * outRayDir));
+	return dot(h, normal) < 0.0f ? -h : h;
+}
+
 #endif
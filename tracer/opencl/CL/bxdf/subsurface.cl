@@ -0,0 +1,94 @@
+#ifndef BXDF_SUBSURFACE_CL
+#define BXDF_SUBSURFACE_CL
+
+// The subsurface bxdf models the boundary of the scattering medium that the 
+// compiler attaches to materials using it. Rays hitting the surface from the 
+// outside are either reflected by a rough dielectric coating or diffusely 
+// transmitted into the medium where they perform a random walk. Rays that 
+// reach the boundary from the inside are diffusely transmitted out of the 
+// medium.
+
+// Bounds for the probability of sampling the specular lobe; they ensure that
+// both lobes are sampled at any incident angle.
+#define SUBSURFACE_MIN_SPECULAR_PDF 0.05f
+#define SUBSURFACE_MAX_SPECULAR_PDF 0.95f
+
+float _subsurfaceGetRoughness(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData);
+float3 subsurfaceSample(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf);
+float subsurfacePdf(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
+float3 subsurfaceEval(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir);
+
+// Get the GGX alpha for the specular lobe.
+float _subsurfaceGetRoughness(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData){
+	// Use Disney's remapping: a = roughness^2
+	float roughness = clamp(matGetSample1f(surface->uv, surface->texLod, matNode->roughness, matNode->roughnessTex, texMeta, texData), MIN_ROUGHNESS, 1.0f);
+	return roughness * roughness;
+}
+
+// Sample the subsurface bxdf.
+float3 subsurfaceSample(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 inRayDir, float3 *outRayDir, float *pdf){
+	float iDotN = dot(inRayDir, surface->normal);
+	float specularPdf = clamp(fresnelForDielectric(matNode->extIOR, matNode->intIOR, iDotN), SUBSURFACE_MIN_SPECULAR_PDF, SUBSURFACE_MAX_SPECULAR_PDF);
+
+	if( iDotN <= 0.0f ){
+		// Exit the medium
+		*outRayDir = cosWeightedHemisphereGetSample(surface->normal, randSample);
+	} else if( randSample.x < specularPdf ){
+		randSample.x /= specularPdf;
+		float3 h = ggxGetSample(_subsurfaceGetRoughness(surface, matNode, texMeta, texData), inRayDir, surface->normal, randSample);
+		*outRayDir = 2.0f * dot(inRayDir, h) * h - inRayDir;
+	} else {
+		// Enter the medium
+		randSample.x = (randSample.x - specularPdf) / (1.0f - specularPdf);
+		*outRayDir = cosWeightedHemisphereGetSample(-surface->normal, randSample);
+	}
+
+	*pdf = subsurfacePdf(surface, matNode, texMeta, texData, inRayDir, *outRayDir);
+	return subsurfaceEval(surface, matNode, texMeta, texData, inRayDir, *outRayDir);
+}
+
+// Get PDF given an outbound ray
+float subsurfacePdf(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
+	float iDotN = dot(inRayDir, surface->normal);
+	float oDotN = dot(outRayDir, surface->normal);
+
+	if( iDotN <= 0.0f ){
+		return max(0.0f, oDotN) * C_1_PI;
+	}
+
+	float specularPdf = clamp(fresnelForDielectric(matNode->extIOR, matNode->intIOR, iDotN), SUBSURFACE_MIN_SPECULAR_PDF, SUBSURFACE_MAX_SPECULAR_PDF);
+	if( oDotN > 0.0f ){
+		float3 h = normalize(inRayDir + outRayDir);
+		return specularPdf * ggxGetReflectionPdf(_subsurfaceGetRoughness(surface, matNode, texMeta, texData), inRayDir, outRayDir, surface->normal, h);
+	}
+
+	return (1.0f - specularPdf) * -oDotN * C_1_PI;
+}
+
+// Evaluate the subsurface bxdf for the selected outgoing ray.
+//
+// BXDF = F * D * G / (4 * cosI * cosO) for reflected rays
+// BXDF = (1 - F) / PI for rays entering the medium
+// BXDF = 1 / PI for rays exiting the medium
+float3 subsurfaceEval(Surface *surface, MaterialNode *matNode, __global TextureMetadata *texMeta, __global uchar *texData, float3 inRayDir, float3 outRayDir){
+	float iDotN = dot(inRayDir, surface->normal);
+	float oDotN = dot(outRayDir, surface->normal);
+
+	if( iDotN <= 0.0f ){
+		return (float3)(oDotN > 0.0f ? C_1_PI : 0.0f);
+	}
+
+	float f = fresnelForDielectric(matNode->extIOR, matNode->intIOR, iDotN);
+	if( oDotN <= 0.0f ){
+		return (float3)((1.0f - f) * C_1_PI);
+	}
+
+	float roughness = _subsurfaceGetRoughness(surface, matNode, texMeta, texData);
+	float3 h = normalize(inRayDir + outRayDir);
+	float d = ggxGetD(roughness, surface->normal, h);
+	float g = ggxGetG(roughness, inRayDir, outRayDir, surface->normal, h);
+
+	return (float3)(f * d * g / (4.0f * iDotN * oDotN));
+}
+
+#endif
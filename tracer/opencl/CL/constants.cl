@@ -23,6 +23,9 @@
 #define INTERSECTION_EPSILON 0.00001f
 #define INTERSECTION_WITH_LIGHT_EPSILON (INTERSECTION_EPSILON * 1e3f)
 
+// Texture LOD that always selects the top mip level
+#define TEX_LOD_TOP_MIP -FLT_MAX
+
 // GGX distribution explodes if roughness is set to 0 (microfacet bxdf)
 #define MIN_ROUGHNESS 0.1f
 
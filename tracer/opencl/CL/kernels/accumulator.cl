@@ -2,20 +2,58 @@
 #define ACCUMULATOR_KERNEL_CL
 
 // Clear accumulation buffer
-__kernel void clearAccumulator(
-		__global float3 *accumulator
-		){
+__kernel void clearAccumulator(CLEAR_ACCUMULATOR_ARGS){
 	accumulator[get_global_id(0)] = (float3)(0.0f, 0.0f, 0.0f);
 }
 
 
 // Aggregate trace accumulator to the primary tracer's frame accumulator 
-__kernel void aggregateAccumulator(
-		__global float3 *srcAccumulator,
-		__global float3 *dstAccumulator
-		){
+__kernel void aggregateAccumulator(AGGREGATE_ACCUMULATOR_ARGS){
 	int globalId = get_global_id(0);
 	dstAccumulator[globalId] += srcAccumulator[globalId];
 }
 
+// Aggregate trace accumulator to the primary tracer's frame accumulator using
+// Kahan summation. The compensation buffer stores the negated low-order part
+// of the previous additions which is folded into the next added value.
+__kernel void aggregateAccumulatorCompensated(AGGREGATE_ACCUMULATOR_COMPENSATED_ARGS){
+	int globalId = get_global_id(0);
+
+	float3 sum = dstAccumulator[globalId];
+	float3 value = srcAccumulator[globalId] - compensation[globalId];
+	float3 newSum = sum + value;
+	compensation[globalId] = (newSum - sum) - value;
+	dstAccumulator[globalId] = newSum;
+}
+
+// Rescale the light group passes of each pixel using the intensity multiplier
+// of each group. As the accumulator already includes the contribution of all
+// light groups, the passes are added using their multiplier minus one.
+__kernel void mixLightGroups(MIX_LIGHT_GROUPS_ARGS){
+	int globalId = get_global_id(0);
+
+	float3 sum = accumulator[globalId];
+	for(uint group = 0; group < numLightGroups; group++){
+		sum += (lightGroupScales[group] - 1.0f) * lpeAccumulator[(firstLightGroupPass + group) * numPixels + globalId];
+	}
+
+	// Clamp values that become negative due to the limited precision of
+	// the accumulators when dimming light groups.
+	outAccumulator[globalId] = fmax(sum, (float3)(0.0f, 0.0f, 0.0f));
+}
+
+// Update the per-pixel sample statistics using the contribution of the last
+// traced sample. Statistics are stored as (luminance sum, squared luminance
+// sum, sample count).
+__kernel void accumulateSampleStats(ACCUMULATE_SAMPLE_STATS_ARGS){
+	int globalId = get_global_id(0);
+
+	float3 total = traceAccumulator[globalId];
+	float3 sample = total - sampleSnapshot[globalId];
+	sampleSnapshot[globalId] = total;
+
+	float lum = dot(sample, (float3)(0.2126f, 0.7152f, 0.0722f));
+	sampleStats[globalId] += (float3)(lum, lum * lum, 1.0f);
+}
+
 #endif
@@ -0,0 +1,69 @@
+#ifndef AO_INTEGRATOR_KERNEL_CL
+#define AO_INTEGRATOR_KERNEL_CL
+
+// Shade intersections using ambient occlusion. For each hit, an occlusion ray
+// is emitted along a cosine-weighted direction in the hemisphere facing the
+// incoming ray. As the cos term cancels out with the pdf, each non-occluded
+// ray contributes a white sample which is added to the accumulator by the 
+// accumulateEmissiveSamples kernel. Rays that miss the scene are treated as
+// fully unoccluded.
+__kernel void shadeAmbientOcclusion(SHADE_AMBIENT_OCCLUSION_ARGS){
+
+	// Local counters used to perform atomics inside this WG
+	volatile __local int wgNumOcclusionRays;
+	int wgOcclusionRayIndex = -1;
+
+	int localId = get_local_id(0);
+	int globalId = get_global_id(0);
+
+	// The first thread in this WG should initialize the local counters
+	if(localId == 0){
+		wgNumOcclusionRays = 0;
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	Surface surface;
+	uint rayPathIndex;
+	float3 outRayOrigin, outRayDir;
+
+	if(globalId < *numRays){
+		float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+		if( hitFlags[globalId] ){
+			Sampler sampler;
+			samplerInit(&sampler, samplerType, sampleIndex, samplerSeed, paths[rayPathIndex].pixelIndex, frameW, blueNoise, SAMPLER_BOUNCE_DIMENSION(0) + 1, 1, (uint2)(randSeed, globalId));
+			float2 sample0 = samplerGetSample2f(&sampler);
+
+			surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+			surfaceFixShadingNormal(&surface, inRayDir, shadingNormalFix);
+
+			// Shade both sides of the surface
+			float3 normal = dot(surface.normal, inRayDir) < 0.0f ? -surface.normal : surface.normal;
+
+			MeshInstance meshInstance = meshInstances[intersections[globalId].meshInstance];
+			float rayBias = meshInstance.rayBias > 0.0f ? meshInstance.rayBias : INTERSECTION_EPSILON;
+
+			outRayOrigin = DISPLACE_BY_BIAS(surface.point, normal, rayBias);
+			outRayDir = cosWeightedHemisphereGetSample(normal, sample0);
+			wgOcclusionRayIndex = atomic_inc(&wgNumOcclusionRays);
+		} else {
+			accumulator[paths[rayPathIndex].pixelIndex] += (float3)(1.0f, 1.0f, 1.0f);
+		}
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+	if(localId == 0 && wgNumOcclusionRays > 0){
+		wgNumOcclusionRays = atomic_add(numOcclusionRays, wgNumOcclusionRays);
+	}
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	// Emit occlusion ray and sample
+	if( wgOcclusionRayIndex != -1 ){
+		wgOcclusionRayIndex += wgNumOcclusionRays;
+		emissiveSamples[wgOcclusionRayIndex] = (float3)(1.0f, 1.0f, 1.0f);
+		emissiveSampleLpeMasks[wgOcclusionRayIndex] = 0;
+		rayNew(occlusionRays + wgOcclusionRayIndex, outRayOrigin, outRayDir, maxDistance, rayPathIndex);
+	}
+}
+
+#endif
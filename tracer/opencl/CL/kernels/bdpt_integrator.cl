@@ -0,0 +1,300 @@
+#ifndef BDPT_INTEGRATOR_KERNEL_CL
+#define BDPT_INTEGRATOR_KERNEL_CL
+
+// The bidirectional integrator traces a light subpath for each eye path. The
+// light subpaths start at a random point on an area light and their vertices
+// are stored in the light vertex buffer (numLightVertices per path). At each
+// bounce, the eye path vertices are connected to the light subpath vertices
+// with a connection ray; the connection samples of non-occluded rays are added
+// to the accumulator using the accumulateEmissiveSamples kernel. Samples
+// generated by shadeHits and the connection samples are weighted using
+// bdptStrategyWeight.
+
+// Select a random area light for each light subpath and emit a ray leaving a
+// random point on its surface. Ray directions are generated using cosine
+// weighted sampling so the cos term of the emitted radiance cancels out with
+// the pdf. The light subpath vertices from the previous sample are cleared and
+// the light group of the selected light is recorded for the connection samples.
+__kernel void generateLightRays(GENERATE_LIGHT_RAYS_ARGS){
+
+	// Local counters used to perform atomics inside this WG
+	volatile __local int wgNumRays;
+	int wgRayIndex = -1;
+
+	int localId = get_local_id(0);
+	int globalId = get_global_id(0);
+
+	// The first thread in this WG should initialize the local counters
+	if(localId == 0){
+		wgNumRays = 0;
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	float3 emissivePoint, emissiveNormal, outRayDir;
+
+	if(globalId < numPaths){
+		for(uint depth = 0; depth < numLightVertices; depth++){
+			lightVertices[globalId * numLightVertices + depth].throughput = (float3)(0.0f, 0.0f, 0.0f);
+		}
+		lightPathGroupMasks[globalId] = 0;
+
+		if(numEmissives > 0){
+			// Init PRNG and generate required samples
+			uint2 rndState = (uint2)(randSeed, globalId);
+			float2 sample0 = randomGetSample2f(&rndState);
+			float2 sample1 = randomGetSample2f(&rndState);
+			float2 sample2 = randomGetSample2f(&rndState);
+
+			// Environment lights do not start light subpaths
+			float selectionPdf;
+			__global Emissive *emissive = emissives + emissiveSelect(numEmissives, sample0.x, &selectionPdf);
+			if( emissive->type == EMISSIVE_TYPE_AREA_LIGHT ){
+				float3 radiance = areaLightGetEmission(emissive, vertices, normals, uv, materialNodes, texMeta, texData, sample1, &emissivePoint, &emissiveNormal);
+				outRayDir = cosWeightedHemisphereGetSample(emissiveNormal, sample2);
+
+				// throughput = Le * cos / (selectionPdf * (1 / area) * (cos / PI))
+				float3 throughput = radiance * C_PI * emissive->area / selectionPdf;
+				if( MAX_VEC3_COMPONENT(throughput) > 0.0f ){
+					pathNew(paths + globalId, globalId, 0.0f);
+					pathSetThroughput(paths + globalId, throughput);
+					lightPathGroupMasks[globalId] = LIGHT_GROUP_NODE_MASK(lightGroupMasks, emissive->matNodeIndex);
+					wgRayIndex = atomic_inc(&wgNumRays);
+				}
+			}
+		}
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+	if(localId == 0 && wgNumRays > 0){
+		wgNumRays = atomic_add(numRays, wgNumRays);
+	}
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	// Emit light ray
+	if( wgRayIndex != -1 ){
+		wgRayIndex += wgNumRays;
+		rayNew(rays + wgRayIndex, DISPLACE_BY_BIAS(emissivePoint, emissiveNormal, INTERSECTION_EPSILON), outRayDir, FLT_MAX, globalId);
+	}
+}
+
+// Store the light subpath vertex for each light ray hit and scatter the light
+// subpath by sampling the surface BXDF. Light subpaths are terminated when
+// they hit an emissive surface or when they reach numLightVertices vertices.
+__kernel void shadeLightHits(SHADE_LIGHT_HITS_ARGS){
+
+	// Local counters used to perform atomics inside this WG
+	volatile __local int wgNumRays;
+	int wgRayIndex = -1;
+
+	int localId = get_local_id(0);
+	int globalId = get_global_id(0);
+
+	// The first thread in this WG should initialize the local counters
+	if(localId == 0){
+		wgNumRays = 0;
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	Surface surface;
+	uint rayPathIndex;
+	float3 outRayOrigin, outRayDir;
+
+	if(globalId < *numRays && hitFlags[globalId]){
+		// Init PRNG and generate required samples
+		Sampler sampler;
+		samplerInitRandom(&sampler, (uint2)(randSeed, globalId));
+		float2 sample0 = samplerGetSample2f(&sampler);
+		float2 sample1 = samplerGetSample2f(&sampler);
+
+		float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+		float3 throughput = paths[rayPathIndex].throughput;
+
+		surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+		surfaceSetWearInputs(&surface, intersections + globalId, vertices, normals, vertexOcclusion);
+		surfaceSetTangent(&surface, intersections + globalId, tangents);
+		surfaceFixShadingNormal(&surface, inRayDir, shadingNormalFix);
+
+		MeshInstance meshInstance = meshInstances[intersections[globalId].meshInstance];
+		float rayBias = meshInstance.rayBias > 0.0f ? meshInstance.rayBias : INTERSECTION_EPSILON;
+
+		MaterialNode materialNode;
+		float3 bxdfTint = (float3)(1.0f, 1.0f, 1.0f);
+		uint matNodeIndex = matSelectNode(paths + rayPathIndex, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &sampler, texMeta, texData);
+
+		if( !BXDF_IS_EMISSIVE(materialNode.type) && materialNode.type != BXDF_INVALID ){
+			if( BXDF_IS_SINGULAR(materialNode.type) ){
+				pathSetSingularVertex(paths + rayPathIndex, depth);
+			}
+
+			__global LightVertex *vertex = lightVertices + rayPathIndex * numLightVertices + depth;
+			vertex->point = surface.point;
+			vertex->normal = surface.normal;
+			vertex->geomNormal = surface.geomNormal;
+			vertex->inRayDir = inRayDir;
+			vertex->throughput = throughput;
+			vertex->uv = surface.uv;
+			vertex->matNodeIndex = matNodeIndex;
+			vertex->singularMask = pathGetSingularMask(paths + rayPathIndex);
+
+			// Use RR to terminate light subpaths with no significant contribution
+			bool rejectSample = depth + 1 >= numLightVertices;
+			if( !rejectSample && depth >= minBouncesForRR ){
+				float rrProbability = max(
+						min(0.5f, 0.2126f * throughput.x + 0.7152f * throughput.y + 0.0722f * throughput.z),
+						0.01f
+						);
+				if (rrProbability < sample1.x){
+					rejectSample = true;
+				} else {
+					throughput /= rrProbability;
+				}
+			}
+
+			if( !rejectSample ){
+				float bxdfPdf;
+				float3 bxdfSample = bxdfGetSample(&surface, &materialNode, texMeta, texData, sample0, inRayDir, &outRayDir, &bxdfPdf);
+				float3 bxdfThroughput = bxdfSample * bxdfTint * fabs(dot(surface.normal, outRayDir));
+				if( MAX_VEC3_COMPONENT(bxdfThroughput) > 0.0f && bxdfPdf > 0.0f ){
+					pathSetThroughput(paths + rayPathIndex, throughput * bxdfThroughput / bxdfPdf);
+					float displaceDir = sign(dot(surface.normal, outRayDir));
+					outRayOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal * displaceDir, rayBias);
+					wgRayIndex = atomic_inc(&wgNumRays);
+				}
+			}
+		}
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+	if(localId == 0 && wgNumRays > 0){
+		wgNumRays = atomic_add(numOutRays, wgNumRays);
+	}
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	// Emit ray for the next light subpath vertex
+	if( wgRayIndex != -1 ){
+		wgRayIndex += wgNumRays;
+		rayNew(outRays + wgRayIndex, outRayOrigin, outRayDir, FLT_MAX, rayPathIndex);
+	}
+}
+
+// Connect the eye subpath vertex for each ray hit to the light subpath vertex
+// at lightDepth. If the connection carries light, a connection ray and sample
+// are emitted. Connection samples are not recorded by light path expressions
+// but they are added to the light group pass of the light that started the
+// light subpath.
+__kernel void connectLightVertex(CONNECT_LIGHT_VERTEX_ARGS){
+
+	// Local counters used to perform atomics inside this WG
+	volatile __local int wgNumConnectionRays;
+	int wgConnectionRayIndex = -1;
+
+	int localId = get_local_id(0);
+	int globalId = get_global_id(0);
+
+	// The first thread in this WG should initialize the local counters
+	if(localId == 0){
+		wgNumConnectionRays = 0;
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	Surface surface;
+	uint rayPathIndex;
+	float3 connectionOrigin, connectionDir, connectionSample;
+	float connectionDist;
+
+	// Medium interactions cannot be connected to light subpaths
+	if(globalId < *numRays && hitFlags[globalId] && hitFlags[globalId] != HIT_FLAG_MEDIUM){
+		float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+		LightVertex lightVertex = lightVertices[rayPathIndex * numLightVertices + lightDepth];
+		MeshInstance meshInstance = meshInstances[intersections[globalId].meshInstance];
+
+		// Shadow catchers and singular light vertices cannot be connected
+		bool canConnect = MAX_VEC3_COMPONENT(lightVertex.throughput) > 0.0f &&
+			(lightDepth >= PATH_MAX_SINGULAR_VERTICES || (lightVertex.singularMask & (1u << lightDepth)) == 0) &&
+			!(bounce == 0 && (meshInstance.flags & MESH_FLAG_SHADOW_CATCHER) != 0);
+
+		if( canConnect ){
+			Sampler sampler;
+			samplerInitRandom(&sampler, (uint2)(randSeed, globalId));
+
+			surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+			surfaceSetWearInputs(&surface, intersections + globalId, vertices, normals, vertexOcclusion);
+			surfaceSetTangent(&surface, intersections + globalId, tangents);
+			surfaceFixShadingNormal(&surface, inRayDir, shadingNormalFix);
+			if( textureFilter == TEXTURE_FILTER_RAY_DIFFERENTIALS ){
+				float coneWidth = paths[rayPathIndex].coneWidth + paths[rayPathIndex].coneSpread * intersections[globalId].wuvt.w;
+				surfaceSetTextureLod(&surface, intersections + globalId, vertices, uv, inRayDir, coneWidth);
+			}
+
+			float rayBias = meshInstance.rayBias > 0.0f ? meshInstance.rayBias : INTERSECTION_EPSILON;
+
+			MaterialNode materialNode;
+			float3 bxdfTint = (float3)(1.0f, 1.0f, 1.0f);
+			matSelectNode(paths + rayPathIndex, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &sampler, texMeta, texData);
+
+			if( !BXDF_IS_EMISSIVE(materialNode.type) && !BXDF_IS_SINGULAR(materialNode.type) && materialNode.type != BXDF_INVALID ){
+				float3 connection = lightVertex.point - surface.point;
+				float distSq = dot(connection, connection);
+				connectionDist = native_sqrt(distSq);
+				connectionDir = connection / connectionDist;
+
+				Surface lightSurface;
+				lightSurface.point = lightVertex.point;
+				lightSurface.normal = lightVertex.normal;
+				lightSurface.geomNormal = lightVertex.geomNormal;
+				lightSurface.uv = lightVertex.uv;
+				lightSurface.texLod = TEX_LOD_TOP_MIP;
+				lightSurface.matNodeIndex = lightVertex.matNodeIndex;
+				MaterialNode lightMaterialNode = materialNodes[lightVertex.matNodeIndex];
+
+				float3 eyeBxdf = bxdfEval(&surface, &materialNode, texMeta, texData, inRayDir, connectionDir);
+				float3 lightBxdf = bxdfEval(&lightSurface, &lightMaterialNode, texMeta, texData, lightVertex.inRayDir, -connectionDir);
+				float geometryTerm = fabs(dot(surface.normal, connectionDir) * dot(lightSurface.normal, connectionDir)) / distSq;
+
+				// Assemble the singular vertex mask for the full path. The eye
+				// subpath contributes vertices x1..x(bounce+1) and light subpath
+				// vertex d is located at x(numEdges-1-d).
+				uint numEdges = bounce + lightDepth + 3;
+				uint singularMask = pathGetSingularMask(paths + rayPathIndex);
+				if( bounce < PATH_MAX_SINGULAR_VERTICES ){
+					singularMask &= (1u << bounce) - 1;
+				}
+				for(uint depth = 0; depth < lightDepth; depth++){
+					uint bit = numEdges - 2 - depth;
+					if( bit < PATH_MAX_SINGULAR_VERTICES && (lightVertex.singularMask & (1u << depth)) != 0 ){
+						singularMask |= 1u << bit;
+					}
+				}
+
+				connectionSample = paths[rayPathIndex].throughput * eyeBxdf * geometryTerm * lightBxdf * lightVertex.throughput;
+				connectionSample *= bdptStrategyWeight(numEdges, singularMask, numBounces, numLightVertices);
+				connectionSample = clampSample(connectionSample, numEdges - 1, 0.0f, clampIndirect);
+
+				if( MAX_VEC3_COMPONENT(connectionSample) > 0.0f && distSq > 0.0f ){
+					float displaceDir = sign(dot(surface.normal, connectionDir));
+					connectionOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal * displaceDir, rayBias);
+					wgConnectionRayIndex = atomic_inc(&wgNumConnectionRays);
+				}
+			}
+		}
+	}
+
+	barrier(CLK_LOCAL_MEM_FENCE);
+	if(localId == 0 && wgNumConnectionRays > 0){
+		wgNumConnectionRays = atomic_add(numConnectionRays, wgNumConnectionRays);
+	}
+	barrier(CLK_LOCAL_MEM_FENCE);
+
+	// Emit connection ray and sample
+	if( wgConnectionRayIndex != -1 ){
+		wgConnectionRayIndex += wgNumConnectionRays;
+		connectionSamples[wgConnectionRayIndex] = connectionSample;
+		connectionSampleLpeMasks[wgConnectionRayIndex] = lightPathGroupMasks[rayPathIndex];
+		rayNew(connectionRays + wgConnectionRayIndex, connectionOrigin, connectionDir, connectionDist - INTERSECTION_WITH_LIGHT_EPSILON, rayPathIndex);
+	}
+}
+
+#endif
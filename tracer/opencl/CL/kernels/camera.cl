@@ -1,23 +1,54 @@
 #ifndef CAMERA_KERNEL_CL
 #define CAMERA_KERNEL_CL
 
-// Generate primary rays.
-__kernel void generatePrimaryRays(
-		__global Ray *rays, 
-		__global int *numRays,
-		__global Path *paths,
-		const float4 frustrumTL,
-		const float4 frustrumTR,
-		const float4 frustrumBL,
-		const float4 frustrumBR,
-		const float3 eyePos,
-		const float2 texelDims,
-		const uint blockY,
-		const uint blockH,
-		const uint frameW,
-		const uint frameH,
-		const uint randSeed
-		){
+float2 cameraSampleAperture(float2 sample, const uint apertureBlades, const float apertureRotation, __global float2 *bokehSamples, const uint numBokehSamples, const float bokehJitter);
+
+// Sample a point on the unit aperture. If bokeh samples are available, one of
+// them is selected and jittered to cover its mask pixel. Otherwise, the
+// point is uniformly sampled inside a regular polygon with apertureBlades
+// sides or, if less than 3 blades are specified, inside the unit disk.
+float2 cameraSampleAperture(float2 sample, const uint apertureBlades, const float apertureRotation, __global float2 *bokehSamples, const uint numBokehSamples, const float bokehJitter){
+	if(numBokehSamples > 0){
+		// Use the fractional part of the scaled sample for jittering
+		float scaled = sample.x * numBokehSamples;
+		uint index = min((uint)scaled, numBokehSamples - 1);
+		float2 jitter = (float2)(scaled - (float)index, sample.y) * 2.0f - 1.0f;
+		return bokehSamples[index] + jitter * bokehJitter;
+	}
+
+	if(apertureBlades >= 3){
+		// Select a blade triangle and uniformly sample it
+		float scaled = sample.x * apertureBlades;
+		uint blade = min((uint)scaled, apertureBlades - 1);
+		float u = native_sqrt(scaled - (float)blade);
+		float bladeAngle = C_TWO_TIMES_PI / (float)apertureBlades;
+		float a0 = apertureRotation + blade * bladeAngle;
+		float a1 = a0 + bladeAngle;
+		float2 v0 = (float2)(native_cos(a0), native_sin(a0));
+		float2 v1 = (float2)(native_cos(a1), native_sin(a1));
+		return u * ((1.0f - sample.y) * v0 + sample.y * v1);
+	}
+
+	// Map the sample to the unit disk using the concentric mapping
+	float2 offset = 2.0f * sample - 1.0f;
+	if(offset.x == 0.0f && offset.y == 0.0f){
+		return (float2)(0.0f, 0.0f);
+	}
+
+	float r, theta;
+	if(fabs(offset.x) > fabs(offset.y)){
+		r = offset.x;
+		theta = C_PI_4 * (offset.y / offset.x);
+	} else {
+		r = offset.y;
+		theta = C_PI_2 - C_PI_4 * (offset.x / offset.y);
+	}
+	return r * (float2)(native_cos(theta), native_sin(theta));
+}
+
+// Generate primary rays. The ray at each index samples the block pixel that is
+// stored at the same index of the rayPixels buffer.
+__kernel void generatePrimaryRays(GENERATE_PRIMARY_RAYS_ARGS){
 
 	uint2 globalId;
 	globalId.x = get_global_id(0);
@@ -29,19 +60,41 @@ __kernel void generatePrimaryRays(
 
 	if( globalId.x < frameW && globalId.y < blockH ){
 		uint index = (globalId.y * frameW) + globalId.x;
-		uint pixelIndex = ((globalId.y + blockY) * frameW) + globalId.x;
-
-		// Apply stratified sampling using a tent filter. This will wrap our
-		// random numbers in the [-1, 1] range. X and Y point to the top corner
-		// of the current texel so we need to add a bit of offset to get the coords
-		// into the [-0.5, 1.5] range.
-		uint2 rndState = globalId + randSeed;
-		float2 sample0 = randomGetSample2f(&rndState);
-		float2 offset = (float2)(
-				sample0.x < 0.5f ? native_sqrt(2.0f * sample0.x) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.x),
-				sample0.y < 0.5f ? native_sqrt(2.0f * sample0.y) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.y)
-		);
-		float2 texel = ((float2)(globalId.x, globalId.y + blockY) + offset) * texelDims;
+		uint blockPixel = rayPixels[index];
+		uint pixelIndex = (blockY * frameW) + blockPixel;
+
+		// Seed the generator using the pixel coordinates so the primary
+		// ray samples of each pixel do not depend on the ray order
+		uint2 pixel = (uint2)(blockPixel % frameW, blockPixel / frameW);
+		Sampler sampler;
+		samplerInit(&sampler, samplerType, sampleIndex, samplerSeed, pixelIndex, frameW, blueNoise, 0, SAMPLER_CAMERA_DIMENSIONS, pixel + randSeed);
+		float2 sample0 = samplerGetSample2f(&sampler);
+		float2 offset;
+		if(pixelFilter == PIXEL_FILTER_POINT){
+			// Sample the texel center
+			offset = (float2)(0.5f, 0.5f);
+		} else if(pixelFilter == PIXEL_FILTER_BOX){
+			// Sample uniformly inside the texel. X and Y point to the
+			// top corner of the current texel.
+			offset = sample0;
+		} else if(pixelFilter == PIXEL_FILTER_GAUSSIAN){
+			// Use the Box-Muller transform to generate normally distributed
+			// offsets (sigma = 0.5) around the texel center and truncate
+			// them to the [-1.5, 1.5] range.
+			float r = 0.5f * native_sqrt(-2.0f * native_log(max(sample0.x, 1e-6f)));
+			float phi = C_TWO_TIMES_PI * sample0.y;
+			offset = 0.5f + clamp((float2)(r * native_cos(phi), r * native_sin(phi)), -1.5f, 1.5f);
+		} else {
+			// Apply stratified sampling using a tent filter. This will wrap our
+			// random numbers in the [-1, 1] range. X and Y point to the top corner
+			// of the current texel so we need to add a bit of offset to get the coords
+			// into the [-0.5, 1.5] range.
+			offset = (float2)(
+					sample0.x < 0.5f ? native_sqrt(2.0f * sample0.x) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.x),
+					sample0.y < 0.5f ? native_sqrt(2.0f * sample0.y) - 0.5f : 1.5f - native_sqrt(2.0f - 2.0f * sample0.y)
+			);
+		}
+		float2 texel = ((float2)(pixel.x, pixel.y + blockY) + offset) * texelDims;
 
 		// Get ray direction using trilinear interpolation
 		float4 dir = normalize(
@@ -52,8 +105,54 @@ __kernel void generatePrimaryRays(
 			)
 		);
 
-		rayNew(rays + index,  eyePos, dir.xyz, FLT_MAX, index);
-		pathNew(paths + index, pixelIndex);
+		// Approximate the angle between the rays of vertically adjacent 
+		// pixels; it is used as the spread angle of the ray cone for 
+		// selecting texture mip levels.
+		float4 nextDir = normalize(
+			mix(
+				mix(frustrumTL, frustrumBL, texel.y + texelDims.y),
+				mix(frustrumTR, frustrumBR, texel.y + texelDims.y),
+				texel.x
+			)
+		);
+		float coneSpread = fast_length(nextDir.xyz - dir.xyz);
+
+		float3 origin = eyePos;
+		if(focusDistance > 0.0f){
+			// Find where the pinhole ray intersects the focus plane and
+			// aim a ray from a sampled lens position towards it. The
+			// focus plane may be tilted with respect to the image plane.
+			float3 focusPoint = eyePos + dir.xyz * (focusDistance / fmax(dot(dir.xyz, focusNormal), 1e-4f));
+			float2 lensSample = cameraSampleAperture(samplerGetSample2f(&sampler), apertureBlades, apertureRotation, bokehSamples, numBokehSamples, bokehJitter);
+			origin = eyePos + lensSample.x * lensRight + lensSample.y * lensUp;
+			dir.xyz = normalize(focusPoint - origin);
+		}
+
+		rayNew(rays + index, origin, dir.xyz, FLT_MAX, index);
+		pathNew(paths + index, pixelIndex, coneSpread);
+	}
+}
+
+// Generate primary rays using a list of host-supplied camera rays. Each camera
+// ray is stored as a pair of float4 values; the first stores the ray origin and
+// the ray cone spread angle in its W coordinate while the second stores the
+// ray direction. The ray for block pixel i is read from index rayOffset + i and
+// the ray at each index samples the block pixel that is stored at the same
+// index of the rayPixels buffer.
+__kernel void generateCustomRays(GENERATE_CUSTOM_RAYS_ARGS){
+
+	uint index = get_global_id(0);
+	if(index == 0){
+		*numRays = frameW * blockH;
+	}
+
+	if( index < frameW * blockH ){
+		uint blockPixel = rayPixels[index];
+		float4 origin = cameraRays[2 * (rayOffset + blockPixel)];
+		float4 dir = cameraRays[2 * (rayOffset + blockPixel) + 1];
+
+		rayNew(rays + index, origin.xyz, normalize(dir.xyz), FLT_MAX, index);
+		pathNew(paths + index, blockY * frameW + blockPixel, origin.w);
 	}
 }
 
@@ -0,0 +1,232 @@
+#ifndef CONE_SHADOWS_KERNEL_CL
+#define CONE_SHADOWS_KERNEL_CL
+
+// Rays whose visibility drops below this threshold are treated as fully
+// occluded.
+#define CONE_MIN_VISIBILITY 0.01f
+
+float coneIntersectBox(float3 origin, float3 invDir, float tMax, float3 minExtent, float3 maxExtent);
+
+// Approximate the visibility of area lights by tracing a cone from the origin
+// of each occlusion ray towards the centroid of the sampled light. The cone
+// apex lies at the ray origin and its radius grows linearly to the radius of
+// the disk that approximates the light. Each triangle that intersects the cone
+// occludes the fraction of the cone cross-section that it covers at the
+// intersection distance and the coverage of overlapping triangles is combined
+// multiplicatively. This produces smooth penumbras with a single occlusion
+// ray per sample at the cost of overestimating the occlusion of meshes that
+// partially cover the cone with several triangles.
+//
+// Rays with a zero cone radius (e.g. environment light samples) are tested
+// against the triangles along the ray like rayIntersectionTest does. The
+// kernel always traverses the binary BVH.
+__kernel void coneOcclusionTest(CONE_OCCLUSION_TEST_ARGS){
+
+	int globalId = get_global_id(0);
+	if(globalId >= *numRays){
+		return;
+	}
+
+	int stackIndex;
+	int meshBvhStackStartIndex;
+	uint nodeStack[BVH_MAX_STACK_SIZE];
+	int stackOverflow = 0;
+	BvhNode curNode;
+	BvhNode childNodes[2];
+	int meshInstanceId;
+	MeshInstance meshInstance;
+
+	// triangle intersection vars
+	float3 v0, edge01, edge02;
+	int triStartIndex, numTriangles;
+
+	// Fetch ray and aim it at the light centroid
+	Ray	ray = rays[globalId];
+	float4 cone = occlusionCones[globalId];
+	if( cone.w > 0.0f ){
+		float3 toLight = cone.xyz - ray.origin.xyz;
+		float distToLight = length(toLight);
+		if( distToLight > INTERSECTION_WITH_LIGHT_EPSILON ){
+			ray.dir.xyz = toLight / distToLight;
+			ray.origin.w = distToLight - INTERSECTION_WITH_LIGHT_EPSILON;
+		} else {
+			cone.w = 0.0f;
+		}
+	}
+	float3 origRayOrigin = ray.origin.xyz;
+	float3 origRayDir = ray.dir.xyz;
+
+	// The cone radius per unit of distance along the ray. Mesh space
+	// distances are scaled by the length of the transformed ray direction.
+	float radiusScale = cone.w > 0.0f ? cone.w / ray.origin.w : 0.0f;
+	float meshScale = 1.0f;
+
+	// Setup stack
+	stackIndex = 0;
+	meshBvhStackStartIndex = -1;
+	curNode = bvhNodes[0];
+
+	int wantLeft;
+	int wantRight;
+	float visibility = 1.0f;
+
+	while(stackIndex > -1){
+		if(BVH_IS_LEAF(curNode)){
+			numTriangles = BVH_TRIANGLE_COUNT(curNode);
+
+			// If this is a top BVH leaf we need to load the mesh instance
+			// and transform all rays using its matrix.
+			if( numTriangles == 0 ){
+				meshInstanceId = BVH_MESH_INSTANCE_ID(curNode);
+				meshInstance = meshInstances[meshInstanceId];
+
+				if( stackIndex < BVH_MAX_STACK_SIZE ){
+					// Push bottom BVH root to the stack and keep a record
+					// of the current stack so that we know when we exit the
+					// bottom BVH
+					meshBvhStackStartIndex = stackIndex;
+					nodeStack[stackIndex++] = meshInstance.bvhRoot;
+
+					// Transform rays without translating ray direction vector
+					ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
+					ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+					meshScale = length(ray.dir.xyz);
+				} else {
+					stackOverflow = 1;
+				}
+			} else {
+				// Find where the cone axis crosses the plane of each
+				// triangle using the Moller-Trumbore algorithm
+				triStartIndex = BVH_TRIANGLE_INDEX(curNode);
+				for(int vIndex = triStartIndex * 3; vIndex < (triStartIndex + numTriangles)*3;vIndex+=3){
+					v0 = vertexList[vIndex].xyz;
+					edge01 = vertexList[vIndex+1].xyz - v0;
+					edge02 = vertexList[vIndex+2].xyz - v0;
+
+					float3 pVec = cross(ray.dir.xyz, edge02);
+					float det = dot(edge01, pVec);
+
+					if (fabs(det) < INTERSECTION_EPSILON){
+						continue;
+					}
+
+					float invDet = native_recip(det);
+					float3 tVec = ray.origin.xyz - v0;
+					float3 qVec = cross(tVec, edge01);
+					float t = dot(edge02, qVec) * invDet;
+					if (t <= INTERSECTION_EPSILON || t >= ray.origin.w){
+						continue;
+					}
+
+					// Calculate the signed distance from the plane hit
+					// point to the closest triangle edge using the
+					// barycentric coords; it is positive for points
+					// inside the triangle.
+					float u = dot(tVec, pVec) * invDet;
+					float v = dot(ray.dir.xyz, qVec) * invDet;
+					float doubleArea = length(cross(edge01, edge02));
+					float edgeDist = min(
+							min((1.0f - u - v) * doubleArea / length(edge02 - edge01), u * doubleArea / length(edge02)),
+							v * doubleArea / length(edge01)
+							);
+
+					// The triangle covers the part of the cone cross-section
+					// that lies inside its closest edge but no more than
+					// the ratio of their areas.
+					float radius = radiusScale * meshScale * t;
+					float coverage;
+					if( radius > 0.0f ){
+						coverage = clamp(0.5f + 0.5f * edgeDist / radius, 0.0f, 1.0f);
+						coverage = min(coverage, 0.5f * doubleArea / (C_PI * radius * radius));
+					} else {
+						coverage = edgeDist >= 0.0f ? 1.0f : 0.0f;
+					}
+					if( coverage <= 0.0f ){
+						continue;
+					}
+
+					// Ignore triangles whose closest point to the cone axis
+					// maps to a transparent texel of an alpha cutout material
+					if( (meshInstance.flags & MESH_FLAG_ALPHA_CUTOUT) != 0 ){
+						float cu = clamp(u, 0.0f, 1.0f);
+						float cv = clamp(v, 0.0f, 1.0f - cu);
+						if( intersectIsTransparent(vIndex / 3, cu, cv, materialIndices, materialNodes, uv, texMeta, texData) ){
+							continue;
+						}
+					}
+
+					visibility *= 1.0f - coverage;
+					if( visibility < CONE_MIN_VISIBILITY ){
+						visibility = 0.0f;
+						stackIndex = -1;
+						break;
+					}
+				}
+			}
+
+			wantLeft = 0;
+			wantRight = 0;
+		} else {
+			// Read children and check for intersections with their
+			// bboxes expanded by the cone radius at the light
+			childNodes[0] = bvhNodes[BVH_LEFT_CHILD(curNode)];
+			childNodes[1] = bvhNodes[BVH_RIGHT_CHILD(curNode)];
+
+			float3 invDir = native_recip(ray.dir.xyz);
+			float3 expand = (float3)(cone.w * meshScale);
+			float lHitDist = coneIntersectBox(ray.origin.xyz, invDir, ray.origin.w, childNodes[0].minExtent.xyz - expand, childNodes[0].maxExtent.xyz + expand);
+			float rHitDist = coneIntersectBox(ray.origin.xyz, invDir, ray.origin.w, childNodes[1].minExtent.xyz - expand, childNodes[1].maxExtent.xyz + expand);
+
+			wantLeft = lHitDist < FLT_MAX ? 1 : 0;
+			wantRight = rHitDist < FLT_MAX ? 1 : 0;
+		}
+
+		if( wantLeft && wantRight ){
+			// If the stack is full the second child is dropped
+			if( stackIndex < BVH_MAX_STACK_SIZE ){
+				nodeStack[stackIndex++] = BVH_RIGHT_CHILD(curNode);
+			} else {
+				stackOverflow = 1;
+			}
+			curNode = childNodes[0];
+		} else if(wantLeft || wantRight){
+			curNode = wantLeft ? childNodes[0] : childNodes[1];
+		} else {
+			if(stackIndex == meshBvhStackStartIndex){
+				// If we exited from a bottom bvh tree we need to restore our ray
+				ray.origin.xyz = origRayOrigin;
+				ray.dir.xyz = origRayDir;
+				meshScale = 1.0f;
+				meshBvhStackStartIndex = -1;
+			}
+
+			// Pop the next node off the stack
+			if( --stackIndex >= 0 ){
+				curNode = bvhNodes[nodeStack[stackIndex]];
+			}
+		}
+	}
+
+	if( stackOverflow ){
+		atomic_inc(stackOverflows);
+	}
+
+	// Scale the emissive sample by the light visibility and flag fully
+	// occluded rays so accumulateEmissiveSamples skips them
+	emissiveSamples[globalId] *= visibility;
+	hitFlag[globalId] = visibility == 0.0f;
+}
+
+// Get the distance to the entry point of a ray/bbox intersection or FLT_MAX
+// if the ray misses the bbox or enters it past tMax.
+float coneIntersectBox(float3 origin, float3 invDir, float tMax, float3 minExtent, float3 maxExtent){
+	float3 tmin = (minExtent - origin) * invDir;
+	float3 tmax = (maxExtent - origin) * invDir;
+	float3 rmin = fmin(tmin, tmax);
+	float3 rmax = fmax(tmin, tmax);
+	float minmax = fmin( fmin(rmax.x, rmax.y), rmax.z);
+	float maxmin = fmax( fmax(rmin.x, rmin.y), rmin.z);
+	return minmax < 0 || maxmin > minmax ? FLT_MAX : (maxmin >= tMax ? FLT_MAX : maxmin);
+}
+
+#endif
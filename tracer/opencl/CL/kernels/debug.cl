@@ -1,33 +1,20 @@
 #ifndef DEBUG_KERNELS_CL
 #define DEBUG_KERNELS_CL
 
-#define DEBUG_TONEMAP_EXPOSURE 1.0f
-
-float3 debugToneMapAndGammaCorrect(float3 sample);
-
-float3 debugToneMapAndGammaCorrect(float3 sample){
-	sample *= DEBUG_TONEMAP_EXPOSURE;
-
-	float3 mapped = sample / (sample + 1.0f);
-	return clamp(pow(mapped, 1.0f / 2.2f), 0.0f, 1.0f) * 255.0f;
-}
-
 // Clear debug buffer
-__kernel void debugClearBuffer(
-		__global uchar4 *output
-		){
+__kernel void debugClearBuffer(DEBUG_CLEAR_BUFFER_ARGS){
 	output[get_global_id(0)] = (uchar4)(0,0,0,255);
 }
 
-// Generate a depth map for primary ray intersections
-__kernel void debugRayIntersectionDepth(
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global Intersection *intersections,
-		const float maxDepth,
-		__global uchar4 *output
-		){
+// Clear debug value buffer. The host maps the raw values to colors when the
+// debug image is written so pixels without data are marked by a zero w.
+__kernel void debugClearValues(DEBUG_CLEAR_VALUES_ARGS){
+	output[get_global_id(0)] = (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+}
+
+// Render the hit distance of primary ray intersections. The host maps the
+// distances to colors; pixels whose rays miss the scene are left empty.
+__kernel void debugRayIntersectionDepth(DEBUG_RAY_INTERSECTION_DEPTH_ARGS){
 
 	int globalId = get_global_id(0);
 	if(globalId >= *numRays){
@@ -39,32 +26,14 @@ __kernel void debugRayIntersectionDepth(
 
 	// No hit
 	if(!hitFlags[globalId] || hitDist == FLT_MAX) {
-		output[pixelIndex] = (uchar4)(0, 0, 0, 255);
 		return;
 	}
 
-	uchar sd = uchar(255.0f * (1.0f - hitDist / (maxDepth + 1.0f)));
-	output[pixelIndex] = (uchar4)(sd, sd, sd, 255);
+	output[pixelIndex] = (float4)(hitDist, hitDist, hitDist, 1.0f);
 }
 
 // Render surface normals for primary ray hits.
-__kernel void debugRayIntersectionNormals(
-		__global Ray *rays,
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global Intersection *intersections,
-		__global float4 *vertices,
-		__global float4 *normals,
-		__global float2 *uv,
-		__global uint *materialIndices,
-		__global MaterialNode *materialNodes,
-		// texture data
-		__global TextureMetadata *texMeta,
-		__global uchar *texData,
-		// output
-		__global uchar4 *output
-		){
+__kernel void debugRayIntersectionNormals(DEBUG_RAY_INTERSECTION_NORMALS_ARGS){
 
 	int globalId = get_global_id(0);
 	if(globalId >= *numRays){
@@ -82,13 +51,16 @@ __kernel void debugRayIntersectionNormals(
 
 	Surface surface;
 	surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+	surfaceSetWearInputs(&surface, intersections + globalId, vertices, normals, vertexOcclusion);
+	surfaceSetTangent(&surface, intersections + globalId, tangents);
 
 	float3 inRayDir = -rays[globalId].dir.xyz;
 
 	MaterialNode materialNode;
-	uint2 rndState = (uint2)(globalId, globalId);
+	Sampler sampler;
+	samplerInitRandom(&sampler, (uint2)(globalId, globalId));
 	float3 bxdfTint;
-	matSelectNode(paths + globalId, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &rndState, texMeta, texData);
+	matSelectNode(paths + globalId, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &sampler, texMeta, texData);
 
 	// convert normal from [-1, 1] -> [0, 255]
 	float3 val = (surface.normal + 1.0f) * 255.0f * 0.5f;
@@ -96,16 +68,7 @@ __kernel void debugRayIntersectionNormals(
 }
 
 // Render emissive samples with optional masking for occluded/not-occluded rays.
-__kernel void debugEmissiveSamples(
-		__global Ray *rays,
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global float3 *emissiveSamples,
-		const uint maskOccluded,
-		const uint maskNotOccluded,
-		__global uchar4 *output
-		){
+__kernel void debugEmissiveSamples(DEBUG_EMISSIVE_SAMPLES_ARGS){
 
 	int globalId = get_global_id(0);
 	if(globalId >= *numRays){
@@ -117,42 +80,27 @@ __kernel void debugEmissiveSamples(
 
 	// Masked output
 	if((maskOccluded && hitFlags[globalId]) || (maskNotOccluded && !hitFlags[globalId])) {
-		output[pixelIndex] = (uchar4)(0, 0, 0, 255);
 		return;
 	} 
 
-	// gamma correct and clamp
-	float3 val = debugToneMapAndGammaCorrect(emissiveSamples[globalId]);
-	output[pixelIndex] = (uchar4)((uchar)val.x, (uchar)val.y, (uchar)val.z, 255);
+	output[pixelIndex] = (float4)(emissiveSamples[globalId], 1.0f);
 }
 
 // Visualize throughput
-__kernel void debugThroughput(
-		__global Path *paths,
-		__global uchar4 *output
-		){
+__kernel void debugThroughput(DEBUG_THROUGHPUT_ARGS){
 
 	int globalId = get_global_id(0);
 	uint pixelIndex = paths[globalId].pixelIndex;
 
-	// gamma correct and clamp
-	float3 val = debugToneMapAndGammaCorrect(paths[globalId].throughput);
-	output[pixelIndex] = (uchar4)((uchar)val.x, (uchar)val.y, (uchar)val.z, 255);
+	output[pixelIndex] = (float4)(paths[globalId].throughput, 1.0f);
 }
 
 // Render accumulator contents
-__kernel void debugAccumulator(
-		const float sampleWeight,
-		__global Path *paths,
-		__global float3 *accumulator,
-		__global uchar4 *output
-		){
+__kernel void debugAccumulator(DEBUG_ACCUMULATOR_ARGS){
 
 	int globalId = get_global_id(0);
-	
-	// gamma correct and clamp
-	float3 val = debugToneMapAndGammaCorrect(accumulator[globalId] * sampleWeight);
-	output[globalId] = (uchar4)((uchar)val.x, (uchar)val.y, (uchar)val.z, 255);
+
+	output[globalId] = (float4)(accumulator[globalId] * sampleWeight, 1.0f);
 }
 
 #endif
@@ -2,13 +2,7 @@
 #define HDR_KERNEL_CL
 
 // Simple Reinhard tone-mapping
-__kernel void tonemapSimpleReinhard(
-	__global float3 *accumulator,
-	__global Path *paths,
-	__global uchar4 *frameBuffer,
-	const float sampleWeight,
-	const float exposure
-		){
+__kernel void tonemapSimpleReinhard(TONEMAP_SIMPLE_REINHARD_ARGS){
 
 			int globalId = get_global_id(0);
 
@@ -1,7 +1,13 @@
 #ifndef INTERSECT_KERNEL_CL
 #define INTERSECT_KERNEL_CL
 
-#define BVH_MAX_STACK_SIZE 32
+// The size of the BVH traversal stack. It can be overridden via the kernel
+// build options for scenes with very deep BVH trees. Rays whose traversal
+// runs out of stack space skip the nodes that could not be pushed and are
+// counted in the stackOverflows kernel argument.
+#ifndef BVH_MAX_STACK_SIZE
+	#define BVH_MAX_STACK_SIZE 32
+#endif
 
 #define BVH_IS_LEAF(node) (node.leftChild.w <= 0)
 #define BVH_LEFT_CHILD(node) (node.leftChild.w)
@@ -19,27 +25,39 @@
 #define RAY_VISIT_BOTH_NODES 3
 
 void printIntersection(Intersection *intersection);
+int intersectIsTransparent(uint triIndex, float u, float v, __global uint *materialIndices, __global MaterialNode *materialNodes, __global float2 *uv, __global TextureMetadata *texMeta, __global uchar *texData);
+
+#ifdef BVH_COMPRESSED
+int intersectCompressedBvh(Ray ray, Intersection *intersection, int anyHit, uint skipInstanceFlags, int *stackOverflow, __global CompressedBvhNode *nodes, __global uint *roots, __global MeshInstance *meshInstances, __global float4 *vertexList, __global uint *materialIndices, __global MaterialNode *materialNodes, __global float2 *uv, __global TextureMetadata *texMeta, __global uchar *texData);
+#endif
 
 // Test for ray intersections with scene geometry and set an ouput flag to indicate
 // intersections. This method does not calculate any intersection details so its
 // cheaper to use for general intersection queries (e.g light occlusion)
-__kernel void rayIntersectionTest(
-		__global Ray* rays,
-		__global const int *numRays,
-		__global BvhNode* bvhNodes,
-		__global MeshInstance* meshInstances,
-		__global float4* vertexList,
-		__global int* hitFlag
-		){
+__kernel void rayIntersectionTest(RAY_INTERSECTION_TEST_ARGS){
 
 	int globalId = get_global_id(0);
 	if(globalId >= *numRays){
 		return;
 	}
 
+#ifdef BVH_COMPRESSED
+	{
+		// Traverse the compressed BVH instead of the binary BVH
+		int compressedStackOverflow = 0;
+		Intersection compressedIntersection;
+		hitFlag[globalId] = intersectCompressedBvh(rays[globalId], &compressedIntersection, 1, 0, &compressedStackOverflow, compressedBvhNodes, compressedBvhRoots, meshInstances, vertexList, materialIndices, materialNodes, uv, texMeta, texData);
+		if( compressedStackOverflow ){
+			atomic_inc(stackOverflows);
+		}
+		return;
+	}
+#endif
+
 	int stackIndex;
 	int meshBvhStackStartIndex;
 	uint nodeStack[BVH_MAX_STACK_SIZE];
+	int stackOverflow = 0;
 	BvhNode curNode;
 	BvhNode childNodes[2];
 	int meshInstanceId;
@@ -77,15 +95,19 @@ __kernel void rayIntersectionTest(
 				meshInstanceId = BVH_MESH_INSTANCE_ID(curNode);
 				meshInstance = meshInstances[meshInstanceId];
 
-				// Push bottom BVH root to the stack and keep a record
-				// of the current stack so that we know when we exit the 
-				// bottom BVH
-				meshBvhStackStartIndex = stackIndex;
-				nodeStack[stackIndex++] = meshInstance.bvhRoot;
+				if( stackIndex < BVH_MAX_STACK_SIZE ){
+					// Push bottom BVH root to the stack and keep a record
+					// of the current stack so that we know when we exit the 
+					// bottom BVH
+					meshBvhStackStartIndex = stackIndex;
+					nodeStack[stackIndex++] = meshInstance.bvhRoot;
 
-				// Transform rays without translating ray direction vector
-				ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
-				ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+					// Transform rays without translating ray direction vector
+					ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
+					ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+				} else {
+					stackOverflow = 1;
+				}
 			} else {
 				// Intersect with all triangles using the Moller-Trumbore algorithm
 				triStartIndex = BVH_TRIANGLE_INDEX(curNode);
@@ -118,6 +140,11 @@ __kernel void rayIntersectionTest(
 
 					float t = dot(edge02, qVec) * invDet;
 					if (t > INTERSECTION_EPSILON && t < ray.origin.w){
+						// Ignore hits on transparent texels of alpha cutout materials
+						if( (meshInstance.flags & MESH_FLAG_ALPHA_CUTOUT) != 0 && intersectIsTransparent(vIndex / 3, u, v, materialIndices, materialNodes, uv, texMeta, texData) ){
+							continue;
+						}
+
 						gotHit = 1;
 						stackIndex = -1;
 						break;
@@ -156,7 +183,12 @@ __kernel void rayIntersectionTest(
 		} 
 
 		if( wantLeft && wantRight ){
-			nodeStack[stackIndex++] = wantLeft ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+			// If the stack is full the second child is dropped
+			if( stackIndex < BVH_MAX_STACK_SIZE ){
+				nodeStack[stackIndex++] = wantLeft ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+			} else {
+				stackOverflow = 1;
+			}
 			curNode = wantLeft ? childNodes[0] : childNodes[1];
 		} else if(wantLeft || wantRight){
 			curNode = wantLeft ? childNodes[0] : childNodes[1];
@@ -175,30 +207,41 @@ __kernel void rayIntersectionTest(
 		}
 	}
 	
+	if( stackOverflow ){
+		atomic_inc(stackOverflows);
+	}
+
 	// Update hit flag
 	hitFlag[globalId] = gotHit;
 }
 
 // Test for ray intersections with scene geometry. Sets an ouput flag to indicate
 // intersections and also emits intersection data for any found intersections.
-__kernel void rayIntersectionQuery(
-		__global Ray* rays,
-		__global const int *numRays,
-		__global BvhNode* bvhNodes,
-		__global MeshInstance* meshInstances,
-		__global float4* vertexList,
-		__global int* hitFlag,
-		__global Intersection* intersections
-		){
+__kernel void rayIntersectionQuery(RAY_INTERSECTION_QUERY_ARGS){
 
 	int globalId = get_global_id(0);
 	if(globalId >= *numRays){
 		return;
 	}
 
+#ifdef BVH_COMPRESSED
+	{
+		// Traverse the compressed BVH instead of the binary BVH
+		int compressedStackOverflow = 0;
+		Intersection compressedIntersection;
+		hitFlag[globalId] = intersectCompressedBvh(rays[globalId], &compressedIntersection, 0, skipInstanceFlags, &compressedStackOverflow, compressedBvhNodes, compressedBvhRoots, meshInstances, vertexList, materialIndices, materialNodes, uv, texMeta, texData);
+		intersections[globalId] = compressedIntersection;
+		if( compressedStackOverflow ){
+			atomic_inc(stackOverflows);
+		}
+		return;
+	}
+#endif
+
 	int stackIndex;
 	int meshBvhStackStartIndex;
 	uint nodeStack[BVH_MAX_STACK_SIZE];
+	int stackOverflow = 0;
 	BvhNode curNode;
 	BvhNode childNodes[2];
 	int meshInstanceId;
@@ -238,15 +281,23 @@ __kernel void rayIntersectionQuery(
 				meshInstanceId = BVH_MESH_INSTANCE_ID(curNode);
 				meshInstance = meshInstances[meshInstanceId];
 
-				// Push bottom BVH root to the stack and keep a record
-				// of the current stack so that we know when we exit the 
-				// bottom BVH
-				meshBvhStackStartIndex = stackIndex;
-				nodeStack[stackIndex++] = meshInstance.bvhRoot;
+				// Skipped instances are treated as leafs with no intersections
+				int enterMesh = (meshInstance.flags & skipInstanceFlags) == 0;
+				if( enterMesh && stackIndex == BVH_MAX_STACK_SIZE ){
+					enterMesh = 0;
+					stackOverflow = 1;
+				}
+				if( enterMesh ){
+					// Push bottom BVH root to the stack and keep a record
+					// of the current stack so that we know when we exit the 
+					// bottom BVH
+					meshBvhStackStartIndex = stackIndex;
+					nodeStack[stackIndex++] = meshInstance.bvhRoot;
 
-				// Transform rays without translating ray direction vector
-				ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
-				ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+					// Transform rays without translating ray direction vector
+					ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
+					ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+				}
 			} else {
 				// Intersect with all triangles using the Moller-Trumbore algorithm
 				triStartIndex = BVH_TRIANGLE_INDEX(curNode);
@@ -279,6 +330,11 @@ __kernel void rayIntersectionQuery(
 
 					float t = dot(edge02, qVec) * invDet;
 					if (t > INTERSECTION_EPSILON && t < intersection.wuvt.w){
+						// Ignore hits on transparent texels of alpha cutout materials
+						if( (meshInstance.flags & MESH_FLAG_ALPHA_CUTOUT) != 0 && intersectIsTransparent(vIndex / 3, u, v, materialIndices, materialNodes, uv, texMeta, texData) ){
+							continue;
+						}
+
 						intersection.wuvt = (float4)(
 								1.0f - (u+v),
 								u,
@@ -322,7 +378,12 @@ __kernel void rayIntersectionQuery(
 		}
 
 		if( wantLeft && wantRight ){
-			nodeStack[stackIndex++] = wantLeft ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+			// If the stack is full the second child is dropped
+			if( stackIndex < BVH_MAX_STACK_SIZE ){
+				nodeStack[stackIndex++] = wantLeft ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+			} else {
+				stackOverflow = 1;
+			}
 			curNode = wantLeft ? childNodes[0] : childNodes[1];
 		} else if(wantLeft || wantRight){
 			curNode = wantLeft ? childNodes[0] : childNodes[1];
@@ -340,6 +401,10 @@ __kernel void rayIntersectionQuery(
 			}
 		}
 	}
+
+	if( stackOverflow ){
+		atomic_inc(stackOverflows);
+	}
 			
 	// Update hit flag
 	hitFlag[globalId] = intersection.wuvt.w < ray.origin.w ? 1 : 0;
@@ -350,26 +415,36 @@ __kernel void rayIntersectionQuery(
 // indicate intersections and also emits intersection data for any found intersections.
 // This kernel operates on a bundle of RAY_PACKET_SIZE rays in parallel. Stack
 // operations are handled by the first thread in the local thread group.
-__kernel void rayPacketIntersectionQuery(
-		__global Ray* rays,
-		__global const int *numRays,
-		__global BvhNode* bvhNodes,
-		__global MeshInstance* meshInstances,
-		__global float4* vertexList,
-		__global int* hitFlag,
-		__global Intersection* intersections
-		){
+__kernel void rayPacketIntersectionQuery(RAY_PACKET_INTERSECTION_QUERY_ARGS){
 
 	int globalId = get_global_id(0);
 	if (globalId >= *numRays){
 		return;
 	}
+
+#ifdef BVH_COMPRESSED
+	{
+		// Traverse the compressed BVH instead of the binary BVH. As
+		// the compressed nodes are already 8-wide, each ray is traversed
+		// independently.
+		int compressedStackOverflow = 0;
+		Intersection compressedIntersection;
+		hitFlag[globalId] = intersectCompressedBvh(rays[globalId], &compressedIntersection, 0, skipInstanceFlags, &compressedStackOverflow, compressedBvhNodes, compressedBvhRoots, meshInstances, vertexList, materialIndices, materialNodes, uv, texMeta, texData);
+		intersections[globalId] = compressedIntersection;
+		if( compressedStackOverflow ){
+			atomic_inc(stackOverflows);
+		}
+		return;
+	}
+#endif
 	int localId = get_local_id(0);
 
 	// Shared data for all threads
 	__local int stackIndex;
 	__local int meshBvhStackStartIndex;
 	__local uint nodeStack[BVH_MAX_STACK_SIZE];
+	__local int stackOverflow;
+	__local int enterMesh;
 	__local BvhNode curNode;
 	__local  BvhNode childNodes[2];
 	__local int scratchMemory[RAY_PACKET_SIZE];
@@ -399,6 +474,7 @@ __kernel void rayPacketIntersectionQuery(
 	// Thread 0 manages the stack; set initial values
 	if(localId == 0){
 		stackIndex = 0;
+		stackOverflow = 0;
 		meshBvhStackStartIndex = -1;
 		curNode = bvhNodes[0];
 	}
@@ -418,16 +494,26 @@ __kernel void rayPacketIntersectionQuery(
 
 					// Push bottom BVH root to the stack and keep a record
 					// of the current stack so that we know when we exit the 
-					// bottom BVH
-					meshBvhStackStartIndex = stackIndex;
-					nodeStack[stackIndex++] = meshInstance.bvhRoot;
+					// bottom BVH. Skipped instances are treated as leafs with
+					// no intersections.
+					enterMesh = (meshInstance.flags & skipInstanceFlags) == 0;
+					if( enterMesh && stackIndex == BVH_MAX_STACK_SIZE ){
+						enterMesh = 0;
+						stackOverflow = 1;
+					}
+					if( enterMesh ){
+						meshBvhStackStartIndex = stackIndex;
+						nodeStack[stackIndex++] = meshInstance.bvhRoot;
+					}
 				}
 
 				barrier(CLK_LOCAL_MEM_FENCE);
 
 				// Transform rays without translating ray direction vector
-				ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
-				ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+				if( enterMesh ){
+					ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
+					ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+				}
 			} else {
 				// Intersect with all triangles using the Moller-Trumbore algorithm
 				triStartIndex = BVH_TRIANGLE_INDEX(curNode);
@@ -458,7 +544,9 @@ __kernel void rayPacketIntersectionQuery(
 								v >= 0.0f && 
 								u+v <= 1.0f && 
 								t > INTERSECTION_EPSILON && 
-								t < intersection.wuvt.w){
+								t < intersection.wuvt.w &&
+								// Ignore hits on transparent texels of alpha cutout materials
+								!((meshInstance.flags & MESH_FLAG_ALPHA_CUTOUT) != 0 && intersectIsTransparent(vIndex / 3, u, v, materialIndices, materialNodes, uv, texMeta, texData))){
 							intersection.wuvt = (float4)(
 									1.0f - (u+v),
 									u,
@@ -545,8 +633,13 @@ __kernel void rayPacketIntersectionQuery(
 		// Thread 0 handles all stack operations
 		if( localId == 0 ){
 			if( packetWantsLeft && packetWantsRight ){
-				// scratchMemory[0] sign indicates which node should we visit first
-				nodeStack[stackIndex++] = scratchMemory[0] < 1 ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+				// scratchMemory[0] sign indicates which node should we visit first.
+				// If the stack is full the second child is dropped.
+				if( stackIndex < BVH_MAX_STACK_SIZE ){
+					nodeStack[stackIndex++] = scratchMemory[0] < 1 ? BVH_RIGHT_CHILD(curNode) : BVH_LEFT_CHILD(curNode);
+				} else {
+					stackOverflow = 1;
+				}
 				curNode = scratchMemory[0] < 1  ? childNodes[0] : childNodes[1];
 			} else if( packetWantsLeft || packetWantsRight ){
 				curNode = packetWantsLeft ? childNodes[0] : childNodes[1];
@@ -568,12 +661,193 @@ __kernel void rayPacketIntersectionQuery(
 		// Sync before next iteration
 		barrier(CLK_LOCAL_MEM_FENCE);
 	}
+
+	if( stackOverflow ){
+		atomic_inc(stackOverflows);
+	}
 			
 	// Update hit flag
 	hitFlag[globalId] = intersection.wuvt.w < ray.origin.w ? 1 : 0;
 	intersections[globalId] = intersection;
 }
 
+// Check whether a ray hits a transparent texel of an alpha cutout material.
+// The u and v arguments are the barycentric coordinates of the hit for the
+// second and third triangle vertex.
+int intersectIsTransparent(uint triIndex, float u, float v, __global uint *materialIndices, __global MaterialNode *materialNodes, __global float2 *uv, __global TextureMetadata *texMeta, __global uchar *texData){
+	__global MaterialNode *node = materialNodes + materialIndices[triIndex];
+	if( node->type != MAT_OP_ALPHA_CUTOUT ){
+		return 0;
+	}
+
+	uint offset = triIndex * 3;
+	float2 hitUV = (1.0f - (u+v)) * uv[offset] + u * uv[offset+1] + v * uv[offset+2];
+	return texGetOpacitySample1f(hitUV, node->opacityTex, texMeta, texData) < node->alphaCutoff;
+}
+
+#ifdef BVH_COMPRESSED
+// Traverse the compressed BVH for a single ray. If anyHit is set, the traversal
+// stops at the first intersection. Otherwise, the closest intersection is
+// written to the intersection argument. Mesh instances with any of the
+// skipInstanceFlags set are ignored. Returns 1 if the ray intersects any
+// geometry closer than its max distance.
+int intersectCompressedBvh(Ray ray, Intersection *intersection, int anyHit, uint skipInstanceFlags, int *stackOverflow, __global CompressedBvhNode *nodes, __global uint *roots, __global MeshInstance *meshInstances, __global float4 *vertexList, __global uint *materialIndices, __global MaterialNode *materialNodes, __global float2 *uv, __global TextureMetadata *texMeta, __global uchar *texData){
+	// Each stack entry stores the children and counts values of a node child
+	int2 nodeStack[BVH_MAX_STACK_SIZE];
+	int stackIndex = 0;
+	int meshBvhStackStartIndex = -1;
+	int meshInstanceId = 0;
+	MeshInstance meshInstance;
+	meshInstance.flags = 0;
+
+	float3 origRayOrigin = ray.origin.xyz;
+	float3 origRayDir = ray.dir.xyz;
+	float3 invDir = native_recip(ray.dir.xyz);
+
+	// Set initial intersection to the ray max dist
+	intersection->wuvt.w = ray.origin.w;
+	int gotHit = 0;
+
+	// Child hits sorted by descending distance
+	int2 hits[8];
+	float hitDist[8];
+
+	// The root of the top-level BVH is always node 0
+	int nodeIndex = 0;
+	while(nodeIndex >= 0){
+		__global CompressedBvhNode *node = nodes + nodeIndex;
+		float3 scale = (float3)(
+				ldexp(1.0f, (int)node->exponents[0]),
+				ldexp(1.0f, (int)node->exponents[1]),
+				ldexp(1.0f, (int)node->exponents[2])
+		);
+
+		int numHits = 0;
+		for(uint child = 0; child < node->numChildren.w; child++){
+			float3 bmin = node->origin.xyz + convert_float3((uchar3)(node->qmin[0][child], node->qmin[1][child], node->qmin[2][child])) * scale;
+			float3 bmax = node->origin.xyz + convert_float3((uchar3)(node->qmax[0][child], node->qmax[1][child], node->qmax[2][child])) * scale;
+
+			float3 tmin = (bmin - ray.origin.xyz) * invDir;
+			float3 tmax = (bmax - ray.origin.xyz) * invDir;
+			float3 rmin = fmin(tmin, tmax);
+			float3 rmax = fmax(tmin, tmax);
+			float minmax = fmin( fmin(rmax.x, rmax.y), rmax.z);
+			float maxmin = fmax( fmax(rmin.x, rmin.y), rmin.z);
+			if( minmax < 0 || maxmin > minmax || maxmin >= intersection->wuvt.w ){
+				continue;
+			}
+
+			int pos = numHits++;
+			for(; pos > 0 && hitDist[pos-1] < maxmin; pos--){
+				hits[pos] = hits[pos-1];
+				hitDist[pos] = hitDist[pos-1];
+			}
+			hits[pos] = (int2)(node->children[child], node->counts[child]);
+			hitDist[pos] = maxmin;
+		}
+
+		// Push hit children so that the closest child is popped first. If
+		// the stack is full the farthest children are dropped.
+		int firstHit = max(0, numHits - (BVH_MAX_STACK_SIZE - stackIndex));
+		if( firstHit > 0 ){
+			*stackOverflow = 1;
+		}
+		for(int hit = firstHit; hit < numHits; hit++){
+			nodeStack[stackIndex++] = hits[hit];
+		}
+
+		// Pop stack entries until we find the next node to visit
+		nodeIndex = -1;
+		while(nodeIndex < 0 && stackIndex > 0 && !(anyHit && gotHit)){
+			if(stackIndex == meshBvhStackStartIndex){
+				// If we exited from a bottom bvh tree we need to restore our ray
+				ray.origin.xyz = origRayOrigin;
+				ray.dir.xyz = origRayDir;
+				invDir = native_recip(ray.dir.xyz);
+				meshBvhStackStartIndex = -1;
+			}
+
+			int2 entry = nodeStack[--stackIndex];
+			if( entry.x > 0 ){
+				nodeIndex = entry.x;
+			} else if( entry.y == 0 ){
+				// If this is a top BVH leaf we need to load the mesh instance
+				// and transform the ray using its matrix. Skipped instances
+				// are treated as leafs with no intersections.
+				meshInstance = meshInstances[-entry.x];
+				if( (meshInstance.flags & skipInstanceFlags) != 0 ){
+					continue;
+				}
+				meshInstanceId = -entry.x;
+				meshBvhStackStartIndex = stackIndex;
+				nodeIndex = roots[meshInstanceId];
+
+				// Transform rays without translating ray direction vector
+				ray.origin.xyz = mul4x1(ray.origin.xyz, meshInstance.transformMat0, meshInstance.transformMat1, meshInstance.transformMat2, meshInstance.transformMat3);
+				ray.dir.xyz = mul3x1(ray.dir.xyz, meshInstance.transformMat0.xyz, meshInstance.transformMat1.xyz, meshInstance.transformMat2.xyz);
+				invDir = native_recip(ray.dir.xyz);
+			} else {
+				// Intersect with all triangles using the Moller-Trumbore algorithm
+				for(int vIndex = -entry.x * 3; vIndex < (entry.y - entry.x)*3; vIndex+=3){
+					float3 v0 = vertexList[vIndex].xyz;
+					float3 edge01 = vertexList[vIndex+1].xyz - v0;
+					float3 edge02 = vertexList[vIndex+2].xyz - v0;
+
+					float3 pVec = cross(ray.dir.xyz, edge02);
+					float det = dot(edge01, pVec);
+
+					if (fabs(det) < INTERSECTION_EPSILON){
+						continue;
+					}
+
+					float invDet = native_recip(det);
+
+					// Calculate barycentric coords
+					float3 tVec = ray.origin.xyz - v0;
+					float u = dot(tVec, pVec) * invDet;
+					if( u < 0.0f || u > 1.0f ){
+						continue;
+					}
+
+					float3 qVec = cross(tVec, edge01);
+					float v = dot(ray.dir.xyz, qVec) * invDet;
+					if( v < 0.0f || u+v > 1.0f ){
+						continue;
+					}
+
+					float t = dot(edge02, qVec) * invDet;
+					if (t > INTERSECTION_EPSILON && t < intersection->wuvt.w){
+						// Ignore hits on transparent texels of alpha cutout materials
+						if( (meshInstance.flags & MESH_FLAG_ALPHA_CUTOUT) != 0 && intersectIsTransparent(vIndex / 3, u, v, materialIndices, materialNodes, uv, texMeta, texData) ){
+							continue;
+						}
+
+						intersection->wuvt = (float4)(
+								1.0f - (u+v),
+								u,
+								v,
+								t
+						);
+						intersection->triIndex = vIndex / 3;
+						intersection->meshInstance = meshInstanceId;
+						gotHit = 1;
+						if( anyHit ){
+							break;
+						}
+					}
+				}
+			}
+		}
+
+		if( anyHit && gotHit ){
+			break;
+		}
+	}
+
+	return gotHit;
+}
+#endif
+
 void printIntersection(Intersection *inter){
 	printf("[tid: %03d] intersection (barycentric: %2.2v3hlf, t: %f, meshInstance: %d, triIndex: %d)\n", 
 			get_global_id(0),
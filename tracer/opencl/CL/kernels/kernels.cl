@@ -4,8 +4,15 @@
 #include "camera.cl"
 #include "hdr.cl"
 #include "intersect.cl"
+#include "cone_shadows.cl"
+#include "texture_feedback.cl"
 #include "pt_integrator.cl"
+#include "medium.cl"
+#include "bdpt_integrator.cl"
+#include "ao_integrator.cl"
+#include "wear.cl"
 #include "accumulator.cl"
 #include "debug.cl"
+#include "layout.cl"
 
 #endif
@@ -0,0 +1,23 @@
+#ifndef LAYOUT_KERNELS_CL
+#define LAYOUT_KERNELS_CL
+
+// Report the layout version, the sizes of all structures that are shared
+// with the host and the stage ABI version. The host compares these values 
+// against its own before uploading any data to the device.
+__kernel void getLayoutInfo(GET_LAYOUT_INFO_ARGS){
+	output[0] = LAYOUT_VERSION;
+	output[1] = sizeof(Ray);
+	output[2] = sizeof(Path);
+	output[3] = sizeof(Intersection);
+	output[4] = sizeof(BvhNode);
+	output[5] = sizeof(MeshInstance);
+	output[6] = sizeof(MaterialNode);
+	output[7] = sizeof(Emissive);
+	output[8] = sizeof(TextureMetadata);
+	output[9] = sizeof(LightVertex);
+	output[10] = sizeof(CompressedBvhNode);
+	output[11] = sizeof(VolumeGrid);
+	output[12] = STAGE_ABI_VERSION;
+}
+
+#endif
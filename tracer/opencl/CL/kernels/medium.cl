@@ -0,0 +1,78 @@
+#ifndef MEDIUM_KERNEL_CL
+#define MEDIUM_KERNEL_CL
+
+// For each ray that travels through a medium, sample a free-flight distance
+// and update the path throughput. Rays that scatter before reaching the next
+// surface (or before escaping the scene) are flagged with HIT_FLAG_MEDIUM and 
+// the distance to the scattering event is stored in their intersection so that
+// shadeHits can shade the medium interaction instead of the surface. The
+// distance sampling is combined with equi-angular sampling towards the area
+// lights so that light shafts converge quickly. Heterogeneous media are
+// sampled using delta tracking and the light emitted by their emission grid
+// is added to the accumulator. Camera rays start inside the scene medium; the
+// medium of all other rays is tracked by shadeHits.
+__kernel void sampleMediumInteractions(SAMPLE_MEDIUM_INTERACTIONS_ARGS){
+
+	int globalId = get_global_id(0);
+	if( globalId >= *numRays ){
+		return;
+	}
+
+	uint rayPathIndex = rayGetPathIndex(rays + globalId);
+	int mediumIndex = bounce == 0 ? sceneMediumMatNodeIndex : pathMedia[rayPathIndex];
+	if( !mediumIsValid(mediumIndex, materialNodes) ){
+		return;
+	}
+
+	Sampler sampler;
+	samplerInit(&sampler, samplerType, sampleIndex, samplerSeed, paths[rayPathIndex].pixelIndex, frameW, blueNoise, SAMPLER_BOUNCE_DIMENSION(bounce), 1, (uint2)(randSeed, globalId));
+	float tMax = hitFlags[globalId] ? intersections[globalId].wuvt.w : FLT_MAX;
+	float3 rayOrigin = rays[globalId].origin.xyz;
+	float3 weight;
+	bool scatter;
+	float t;
+
+	__global MaterialNode *medium = materialNodes + mediumIndex;
+	if( mediumIsHeterogeneous(medium) ){
+		float3 emitted;
+		t = mediumGetTrackedDistanceSample(medium, volumeGrids, volumeData, rayOrigin, rays[globalId].dir.xyz, tMax, &sampler.rndState, &weight, &emitted, &scatter);
+
+		// The emitted light reaches the camera after bounce scattering
+		// events and is recorded as a light event by light path expressions.
+		float3 radiance = paths[rayPathIndex].throughput * emitted;
+		if( MAX_VEC3_COMPONENT(radiance) > 0.0f ){
+			uint pixelIndex = paths[rayPathIndex].pixelIndex;
+			uint lpeAcceptMask;
+			radiance = clampSample(radiance, bounce, clampDirect, clampIndirect);
+			accumulator[rayPathIndex] += radiance;
+
+			lpeStep(bounce == 0 ? LPE_INITIAL_STATES : lpeStates[rayPathIndex], LPE_EVENT_LIGHT, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+			lpeAccumulate(radiance, lpeAcceptMask | LIGHT_GROUP_NODE_MASK(lightGroupMasks, mediumIndex), pixelIndex, frameW * frameH, lpeAccumulator);
+		}
+	} else {
+		float2 distSample = samplerGetSample2f(&sampler);
+
+		// Combine free-flight sampling with equi-angular sampling towards a
+		// randomly selected area light. The light selection sample is drawn
+		// past the medium sampler dimension so it comes from the random
+		// number generator.
+		float4 lightCone = (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+		if( numEmissives > 0 ){
+			float selectionPdf;
+			uint emissiveIndex = emissiveSelect((int)numEmissives, samplerGetSample2f(&sampler).x, &selectionPdf);
+			lightCone = emissiveGetShadowCone(emissives + emissiveIndex, vertices, rayOrigin, (float3)(0.0f, 0.0f, 0.0f));
+		}
+
+		t = lightCone.w > 0.0f
+			? mediumGetEquiAngularDistanceSample(medium, rayOrigin, rays[globalId].dir.xyz, tMax, lightCone.xyz, distSample, &weight, &scatter)
+			: mediumGetDistanceSample(medium, tMax, distSample, &weight, &scatter);
+	}
+
+	pathSetThroughput(paths + rayPathIndex, paths[rayPathIndex].throughput * weight);
+	if( scatter ){
+		hitFlags[globalId] = HIT_FLAG_MEDIUM;
+		intersections[globalId].wuvt.w = t;
+	}
+}
+
+#endif
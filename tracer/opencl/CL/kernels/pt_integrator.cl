@@ -3,48 +3,87 @@
 
 #define MAX_VEC3_COMPONENT(v) (max(v.x,max(v.y,v.z)))
 #define MIN_VEC3_COMPONENT(v) (min(v.x,min(v.y,v.z)))
-#define DISPLACE_BY_EPSILON(v,n) (v + n * INTERSECTION_EPSILON)
+#define DISPLACE_BY_BIAS(v,n,bias) (v + n * bias)
 
 #define BALANCE_HEURISTIC(a,b) a/(a+b)
 #define POWER_HEURISTIC(a,b) (a*a)/(a*a+b*b)
 
+// Fresnel reflectance at normal incidence used by reflective shadow catchers
+#define SHADOW_CATCHER_F0 0.04f
+
+// The angle (in radians) that is added to the ray cone spread when a path 
+// scatters off a non-specular surface. Textures seen through diffuse or glossy
+// bounces are blurred by the scattering lobe so a coarse estimate is enough
+// for selecting lower-resolution mip levels.
+#define RAY_CONE_SCATTER_SPREAD 0.25f
+
+float3 sceneBackgroundSample(float3 rayDir, uint pixelIndex, int sceneDiffuseMatNodeIndex, int sceneBackplateMatNodeIndex, int sceneEnvMatNodeIndex, uint frameW, uint frameH, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData);
+float3 sceneEnvSample(float3 rayDir, int sceneDiffuseMatNodeIndex, int sceneEnvMatNodeIndex, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData);
+float3 clampSample(float3 sample, uint numScatterEvents, float clampDirect, float clampIndirect);
+
+// Sample the scene background as seen by a camera ray. If a backplate is 
+// defined, it is mapped to the frame using the pixel coordinates. Otherwise, 
+// the scene env map or the scene bg color is sampled using the ray direction.
+float3 sceneBackgroundSample(float3 rayDir, uint pixelIndex, int sceneDiffuseMatNodeIndex, int sceneBackplateMatNodeIndex, int sceneEnvMatNodeIndex, uint frameW, uint frameH, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData){
+	if( sceneBackplateMatNodeIndex >= 0 ){
+		MaterialNode matNode = materialNodes[sceneBackplateMatNodeIndex];
+		float2 uv = (float2)(
+				((float)(pixelIndex % frameW) + 0.5f) / (float)frameW,
+				((float)(pixelIndex / frameW) + 0.5f) / (float)frameH
+		);
+		return matGetSample3f(uv, TEX_LOD_TOP_MIP, matNode.reflectance, matNode.reflectanceTex, texMeta, texData);
+	}
+
+	return sceneEnvSample(rayDir, sceneDiffuseMatNodeIndex, sceneEnvMatNodeIndex, materialNodes, texMeta, texData);
+}
+
+// Sample the scene environment along a ray direction. If the scene defines 
+// an environment map, its scaled radiance is returned. Otherwise, the scene
+// bg color (or lat/long skybox) is sampled.
+float3 sceneEnvSample(float3 rayDir, int sceneDiffuseMatNodeIndex, int sceneEnvMatNodeIndex, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData){
+	float2 uv = rayToLatLongUV(rayDir);
+	if( sceneEnvMatNodeIndex >= 0 ){
+		MaterialNode matNode = materialNodes[sceneEnvMatNodeIndex];
+		return matNode.scale * matGetSample3f(uv, TEX_LOD_TOP_MIP, matNode.radiance, matNode.radianceTex, texMeta, texData);
+	} else if( sceneDiffuseMatNodeIndex >= 0 ){
+		MaterialNode matNode = materialNodes[sceneDiffuseMatNodeIndex];
+		return matGetSample3f(uv, TEX_LOD_TOP_MIP, matNode.reflectance, matNode.reflectanceTex, texMeta, texData);
+	}
+
+	return (float3)(0.0f, 0.0f, 0.0f);
+}
+
+// Clamp the max component of a light sample to suppress fireflies. Samples are
+// classified by the number of scattering events between the camera and the 
+// light: samples with a single event (direct lighting) are clamped to 
+// clampDirect and samples with more events (indirect lighting) to clampIndirect.
+// Lights and backgrounds that are directly visible are never clamped. A clamp
+// value of 0 disables clamping for the corresponding class.
+float3 clampSample(float3 sample, uint numScatterEvents, float clampDirect, float clampIndirect){
+	if( numScatterEvents == 0 ){
+		return sample;
+	}
+
+	float limit = numScatterEvents == 1 ? clampDirect : clampIndirect;
+	float maxComponent = MAX_VEC3_COMPONENT(sample);
+	if( limit > 0.0f && maxComponent > limit ){
+		return sample * (limit / maxComponent);
+	}
+	return sample;
+}
+
 // For each intersection, calculate an outgoing indirect ray based on the 
 // surface PDF and also perform direct light sampling emitting occlusion
 // rays and light samples. 
 //
 // If a ray hits an emissive surface, we update the accumulator with emissive
 // output multiplied by the current throughput and kill the ray.
-__kernel void shadeHits(
-		__global Ray *rays,
-		global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global Intersection *intersections,
-		// scene data
-		__global float4 *vertices,
-		__global float4 *normals,
-		__global float2 *uv,
-		__global uint *materialIndices,
-		__global MaterialNode *materialNodes,
-		__global Emissive *emissives,
-		const uint numEmissives,
-		// texture data
-		__global TextureMetadata *texMeta,
-		__global uchar *texData,
-		// state
-		const uint bounce,
-		const uint minBouncesForRR,
-		const uint randSeed,
-		// occlusion rays and samples
-		__global Ray *occlusionRays,
-		volatile __global int *numOcclusionRays,
-		__global float3 *emissiveSamples,
-		// indirect rays
-		__global Ray *indirectRays,
-		volatile __global int *numIndirectRays,
-		// output accumulator
-		__global float3 *accumulator
-		){
+//
+// Rays flagged by sampleMediumInteractions scatter inside the path medium. For
+// these rays, direct light sampling is restricted to area lights and the 
+// outgoing ray is generated by sampling the medium phase function. The path
+// medium is updated whenever a ray is transmitted through a surface.
+__kernel void shadeHits(SHADE_HITS_ARGS){
 
 	// Local counters used to perform atomics inside this WG
 	volatile __local int wgNumOcclusionRays;
@@ -71,48 +110,56 @@ __kernel void shadeHits(
 	float3 bxdfOutRayDir, bxdfSample, bxdfEmissiveSample, emissiveOutRayDir, emissiveSample;
 	float bxdfPdf, bxdfEmissivePdf, emissivePdf, emissiveBxdfPdf, emissiveSelectionPdf;
 	float emissiveWeight, bxdfWeight, distToEmissive;
+	float4 occlusionCone = (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+	float coneWidth, outConeSpread;
+	uint lpeScatterStates, lpeAcceptMask, lpeEmissiveMask = 0;
+	uint lpeNumPixels = frameW * frameH;
+	uint2 envDims = (uint2)(envDistributionW, envDistributionH);
+	int pathMedium, nextPathMedium = -1;
 
 	if(globalId < *numRays){
 		if( hitFlags[globalId] ){
 			bxdfPdf = 1.0f;
 			bxdfWeight = 1.0f;
 
-			// Init PRNG and generate required samples
-			uint2 rndState = (uint2)(randSeed, globalId);
-			float2 sample0 = randomGetSample2f(&rndState);
-			float2 sample1 = randomGetSample2f(&rndState);
-			float2 sample2 = randomGetSample2f(&rndState);
-
 			// Load incoming ray direction and invert it so it points away
 			// from the surface. All BxDF formulas use in/out rays that 
 			// are going outwards from the surface.
 			float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
 			curPathThroughput = paths[rayPathIndex].throughput;
-
-			// Fill surface data and calculate cos(n, inRay)
-			surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
-
-			// Select material
-			MaterialNode materialNode;
-			matSelectNode(paths + rayPathIndex, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &rndState, texMeta, texData);
-
-			float inRayDotNormal = dot(inRayDir, surface.normal);
-
-			// Check if we hit an emissive node. If so, we need to accumulate implicit
-			// light and terminate the path.
-			if( BXDF_IS_EMISSIVE(materialNode.type) ){
-				// Make sure that the incoming ray is facing the emissive.
-				if( inRayDotNormal > 0.0f ){
-					accumulator[rayPathIndex] += curPathThroughput * materialNode.scale * matGetSample3f(surface.uv, materialNode.radiance, materialNode.radianceTex, texMeta, texData);
-				}
-			} else {
-				// Implement RR to terminate paths with no significant contribution
-				// killing paths with a probability less than sample2.x while also
-				// boosting surving paths by the same probablility.
-				bool rejectSample = materialNode.type == BXDF_INVALID;
+			uint pixelIndex = paths[rayPathIndex].pixelIndex;
+
+			// Init sampler and generate required samples. The first 
+			// dimension of each bounce is used by sampleMediumInteractions.
+			Sampler sampler;
+			samplerInit(&sampler, samplerType, sampleIndex, samplerSeed, pixelIndex, frameW, blueNoise, SAMPLER_BOUNCE_DIMENSION(bounce) + 1, SAMPLER_BOUNCE_DIMENSIONS - 1, (uint2)(randSeed, globalId));
+			float2 sample0 = samplerGetSample2f(&sampler);
+			float2 sample1 = samplerGetSample2f(&sampler);
+			float2 sample2 = samplerGetSample2f(&sampler);
+			uint lpePathStates = bounce == 0 ? LPE_INITIAL_STATES : lpeStates[rayPathIndex];
+			pathMedium = bounce == 0 ? sceneMediumMatNodeIndex : pathMedia[rayPathIndex];
+			nextPathMedium = pathMedium;
+
+			if( hitFlags[globalId] == HIT_FLAG_MEDIUM ){
+				// The scattering event lies inside the path medium at 
+				// the distance stored in the intersection
+				__global MaterialNode *medium = materialNodes + pathMedium;
+				float distToEvent = intersections[globalId].wuvt.w;
+				surface.point = rays[globalId].origin.xyz - inRayDir * distToEvent;
+				outBxdfRayOrigin = surface.point;
+				outEmissiveRayOrigin = surface.point;
+				coneWidth = paths[rayPathIndex].coneWidth + paths[rayPathIndex].coneSpread * distToEvent;
+				outConeSpread = paths[rayPathIndex].coneSpread + RAY_CONE_SCATTER_SPREAD;
+
+				// Medium interactions cannot be connected to light subpaths
+				// and are recorded as diffuse events by light path expressions.
+				pathSetSingularVertex(paths + rayPathIndex, bounce);
+				lpeScatterStates = lpeStep(lpePathStates, LPE_EVENT_DIFFUSE, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+				lpeStep(lpeScatterStates, LPE_EVENT_LIGHT, numLpeExpressions, lpeTransitions, &lpeEmissiveMask);
+
+				bool rejectSample = false;
 				if(bounce >= minBouncesForRR) {
 					float rrProbability = max(
-							// convert throughput to luminance
 							min(0.5f, 0.2126f * curPathThroughput.x + 0.7152f * curPathThroughput.y + 0.0722f * curPathThroughput.z),
 							0.01f
 							);
@@ -124,59 +171,235 @@ __kernel void shadeHits(
 				}
 
 				if( !rejectSample ){
-					// Get BXDF sample and generate outgoing ray based on surface BXDF
-					bxdfSample = bxdfGetSample(&surface, &materialNode, texMeta, texData, sample0, inRayDir, &bxdfOutRayDir, &bxdfPdf);
-
-					// To calculate the origin for occlusion/indirect rays we displace the 
-					// surface hit point by a small epsilon along the normal to ensure that 
-					// we don't register an intersection with the same surface.  If this 
-					// material is refractive and we are hitting it from the outside we 
-					// need to ensure that the outgoing ray starts inside the surface.
-					float displaceDir = sign(dot(surface.normal, bxdfOutRayDir));
-					outBxdfRayOrigin = DISPLACE_BY_EPSILON(surface.point, surface.normal * displaceDir);
-					// The emissive ray always starts away from the surface. This allows us to shade BTDFs
-					outEmissiveRayOrigin = DISPLACE_BY_EPSILON(surface.point, surface.normal);
-
-					// Select and sample emissive source
+					float3 rayDir = -inRayDir;
+					bxdfOutRayDir = mediumPhaseGetSample(medium->anisotropy, rayDir, sample0, &bxdfPdf);
+
+					// Rays leaving the medium through a surface are occluded
+					// by it and rays traveling through the scene medium 
+					// never reach the environment so only area lights are
+					// sampled.
 					int emissiveIndex = numEmissives > 0 ? emissiveSelect(numEmissives, sample1.x, &emissiveSelectionPdf) : -1;
-					if( emissiveIndex > -1 ){
-						emissiveSample = emissiveGetSample(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, sample1, &emissiveOutRayDir, &emissivePdf, &distToEmissive);
+					if( emissiveIndex > -1 && emissives[emissiveIndex].type == EMISSIVE_TYPE_AREA_LIGHT ){
+						emissiveSample = emissiveGetSample(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, envDistribution, envDims, sample1, &emissiveOutRayDir, &emissivePdf, &distToEmissive);
+						occlusionCone = emissiveGetShadowCone(emissives + emissiveIndex, vertices, outEmissiveRayOrigin, (float3)(0.0f, 0.0f, 0.0f));
+						lpeEmissiveMask |= LIGHT_GROUP_NODE_MASK(lightGroupMasks, emissives[emissiveIndex].matNodeIndex);
+
+						// MIS: calculate sampling weights for the emissive
+						// and phase function samples using the power heuristic.
+						float emissivePhase = mediumPhaseEval(medium->anisotropy, dot(rayDir, emissiveOutRayDir));
+						emissiveWeight = POWER_HEURISTIC(emissivePdf, emissivePhase);
+						emissiveBxdfPdf = emissiveGetPdf(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, envDistribution, envDims, bxdfOutRayDir);
+						bxdfWeight = POWER_HEURISTIC(bxdfPdf, emissiveBxdfPdf);
 
-						// MIS: we already have a PDF for generating emissiveOutRayDir.
-						// Calculate a PDF for the BXDF sampler generating the same ray 
-						// and generate sampling weights using the power heuristic.
-						bxdfEmissivePdf = bxdfGetPdf(&surface, &materialNode, texMeta, texData, inRayDir, emissiveOutRayDir);
-						emissiveWeight = POWER_HEURISTIC(emissivePdf, bxdfEmissivePdf);
+						if( MAX_VEC3_COMPONENT(emissiveSample) > 0.0f && emissivePdf > 0.0f ){
+							emissiveSample *= emissiveWeight * emissivePhase * curPathThroughput * mediumGetShadowTransmittance(medium, volumeGrids, volumeData, outEmissiveRayOrigin, emissiveOutRayDir, distToEmissive, &sampler.rndState) / (emissivePdf * emissiveSelectionPdf);
+							emissiveSample *= bdptStrategyWeight(bounce + 2, pathGetSingularMask(paths + rayPathIndex), numBounces, numLightVertices);
+							emissiveSample = clampSample(emissiveSample, bounce + 1, clampDirect, clampIndirect);
+							wgOcclusionRayIndex = MAX_VEC3_COMPONENT(emissiveSample) > 0.0f ? atomic_inc(&wgNumOcclusionRays) : -1;
+						}
+					}
 
-						// We use the same approach to calculate a weight for the BXDF sample by 
-						// calculating the PDF for the emissive sampler generating bxdfOutRayDir
-						emissiveBxdfPdf = emissiveGetPdf(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, bxdfOutRayDir);
-						bxdfWeight = POWER_HEURISTIC(bxdfPdf, emissiveBxdfPdf);
+					// The phase function value and the pdf of its samples
+					// cancel out.
+					float3 throughput = curPathThroughput * bxdfWeight;
+					if( MAX_VEC3_COMPONENT(throughput) > 0.0f && bxdfPdf > 0.0f ){
+						pathSetThroughput(paths + rayPathIndex, throughput);
+						wgIndirectRayIndex = atomic_inc(&wgNumIndirectRays);
 					}
+				}
+			} else {
+				// Fill surface data and calculate cos(n, inRay)
+				surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+				surfaceSetWearInputs(&surface, intersections + globalId, vertices, normals, vertexOcclusion);
+				surfaceSetTangent(&surface, intersections + globalId, tangents);
+				surfaceFixShadingNormal(&surface, inRayDir, shadingNormalFix);
+
+				// Grow the path ray cone to the intersection point and use it
+				// to select the texture mip levels for this surface.
+				coneWidth = paths[rayPathIndex].coneWidth + paths[rayPathIndex].coneSpread * intersections[globalId].wuvt.w;
+				outConeSpread = paths[rayPathIndex].coneSpread;
+				if( textureFilter == TEXTURE_FILTER_RAY_DIFFERENTIALS ){
+					surfaceSetTextureLod(&surface, intersections + globalId, vertices, uv, inRayDir, coneWidth);
+				}
 
-					// If we have a valid emissive sample allocate an occlusion ray.
-					float nDotEmissiveOutRay = max(0.0f, dot(surface.normal, emissiveOutRayDir));
-					if( MAX_VEC3_COMPONENT(emissiveSample) > 0.0f && emissivePdf > 0.0f && nDotEmissiveOutRay > 0.0f){
-						bxdfEmissiveSample = bxdfEval(&surface, &materialNode, texMeta, texData, inRayDir, emissiveOutRayDir);
-						emissiveSample *= emissiveWeight * bxdfEmissiveSample * curPathThroughput * nDotEmissiveOutRay / (emissivePdf * emissiveSelectionPdf);
-						wgOcclusionRayIndex = MAX_VEC3_COMPONENT(emissiveSample) > 0.0f ? atomic_inc(&wgNumOcclusionRays) : -1;
+				// Mesh instances may override the distance used for displacing
+				// secondary ray origins to work around self-intersection artifacts 
+				// on thin or coplanar geometry.
+				MeshInstance meshInstance = meshInstances[intersections[globalId].meshInstance];
+				float rayBias = meshInstance.rayBias > 0.0f ? meshInstance.rayBias : INTERSECTION_EPSILON;
+
+				// Select material
+				MaterialNode materialNode;
+				uint matNodeIndex = matSelectNode(paths + rayPathIndex, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &sampler, texMeta, texData);
+
+				float inRayDotNormal = dot(inRayDir, surface.normal);
+
+				// Shadow catchers hit by camera rays replace the surface color with
+				// the background behind them. The background is only darkened by 
+				// the fraction of occluded light samples so the catcher blends
+				// with the backplate or env map while still receiving shadows.
+				// Reflective catchers also emit a mirror ray weighted by the 
+				// Fresnel reflectance so reflections match the env map.
+				if( bounce == 0 && (meshInstance.flags & MESH_FLAG_SHADOW_CATCHER) != 0 ){
+					// Shadow catchers cannot be connected to light subpaths
+					pathSetSingularVertex(paths + rayPathIndex, bounce);
+
+					float3 background = sceneBackgroundSample(-inRayDir, paths[rayPathIndex].pixelIndex, sceneDiffuseMatNodeIndex, sceneBackplateMatNodeIndex, sceneEnvMatNodeIndex, frameW, frameH, materialNodes, texMeta, texData);
+					float fresnel = 0.0f;
+					if( (meshInstance.flags & MESH_FLAG_CATCHER_REFLECTIONS) != 0 ){
+						fresnel = SHADOW_CATCHER_F0 + (1.0f - SHADOW_CATCHER_F0) * pown(1.0f - max(0.0f, inRayDotNormal), 5);
 					}
+					float3 matte = curPathThroughput * background * (1.0f - fresnel);
+
+					// The matte is recorded as a background event and the
+					// reflection as a specular scattering event.
+					lpeStep(lpePathStates, LPE_EVENT_BACKGROUND, numLpeExpressions, lpeTransitions, &lpeEmissiveMask);
+					lpeEmissiveMask |= LIGHT_GROUP_BACKGROUND_MASK(lightGroupMasks);
+					lpeScatterStates = lpeStep(lpePathStates, LPE_EVENT_SPECULAR, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
 
-					// Disable bxdfWeight for singular surfaces (ideal mirror/dielectric)
-					if( BXDF_IS_SINGULAR(materialNode.type) ){
-						bxdfWeight = 1.0f;
+					// Select and sample emissive source; if we cannot get a valid
+					// sample then the catcher is considered to be unoccluded.
+					outEmissiveRayOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal, rayBias);
+					int emissiveIndex = numEmissives > 0 ? emissiveSelect(numEmissives, sample1.x, &emissiveSelectionPdf) : -1;
+					if( emissiveIndex > -1 ){
+						emissiveSample = emissiveGetSample(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, envDistribution, envDims, sample1, &emissiveOutRayDir, &emissivePdf, &distToEmissive);
+						occlusionCone = emissiveGetShadowCone(emissives + emissiveIndex, vertices, outEmissiveRayOrigin, surface.normal);
+					}
+
+					if( emissiveIndex > -1 && MAX_VEC3_COMPONENT(emissiveSample) > 0.0f && emissivePdf > 0.0f && dot(surface.normal, emissiveOutRayDir) > 0.0f ){
+						emissiveSample = matte;
+						wgOcclusionRayIndex = atomic_inc(&wgNumOcclusionRays);
+					} else {
+						accumulator[rayPathIndex] += matte;
+						lpeAccumulate(matte, lpeEmissiveMask, pixelIndex, lpeNumPixels, lpeAccumulator);
 					}
 
-					// If we got a valid bxdf sample update the path throughput
-					// Note: we are using the abs value of the dot product as 
-					// it will be negative for rays entering into refractive surfaces
-					float3 throughput = bxdfWeight * bxdfSample * bxdfTint * fabs(dot(surface.normal, bxdfOutRayDir));
-					if (MAX_VEC3_COMPONENT(throughput) > 0.0f && bxdfPdf > 0.0f){
-						pathSetThroughput(paths + rayPathIndex, curPathThroughput * throughput / bxdfPdf);
+					if( fresnel > 0.0f ){
+						bxdfOutRayDir = 2.0f * inRayDotNormal * surface.normal - inRayDir;
+						outBxdfRayOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal, rayBias);
+						pathSetThroughput(paths + rayPathIndex, curPathThroughput * fresnel);
 						wgIndirectRayIndex = atomic_inc(&wgNumIndirectRays);
-					} 
-				} // if(!rejectSample)
-			} // if(BXDF_IS_EMISSIVE)
+					}
+				} else if( BXDF_IS_EMISSIVE(materialNode.type) ){
+					// Check if we hit an emissive node. If so, we need to accumulate implicit
+					// light and terminate the path.
+					// Make sure that the incoming ray is facing the emissive.
+					// The ray that hit the emissive was scattered bounce times
+					// before reaching it so the path has bounce + 1 edges.
+					if( inRayDotNormal > 0.0f ){
+						float3 radiance = curPathThroughput * materialNode.scale * matGetSample3f(surface.uv, surface.texLod, materialNode.radiance, materialNode.radianceTex, texMeta, texData);
+						radiance *= bdptStrategyWeight(bounce + 1, pathGetSingularMask(paths + rayPathIndex), numBounces, numLightVertices);
+						radiance = clampSample(radiance, bounce, clampDirect, clampIndirect);
+						accumulator[rayPathIndex] += radiance;
+
+						lpeStep(lpePathStates, LPE_EVENT_LIGHT, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+						lpeAccumulate(radiance, lpeAcceptMask | LIGHT_GROUP_NODE_MASK(lightGroupMasks, matNodeIndex), pixelIndex, lpeNumPixels, lpeAccumulator);
+					}
+				} else {
+					// Implement RR to terminate paths with no significant contribution
+					// killing paths with a probability less than sample2.x while also
+					// boosting surving paths by the same probablility.
+					bool rejectSample = materialNode.type == BXDF_INVALID;
+					if(bounce >= minBouncesForRR) {
+						float rrProbability = max(
+								// convert throughput to luminance
+								min(0.5f, 0.2126f * curPathThroughput.x + 0.7152f * curPathThroughput.y + 0.0722f * curPathThroughput.z),
+								0.01f
+								);
+						if (rrProbability < sample2.x){
+							rejectSample = true;
+						} else {
+							curPathThroughput /= rrProbability;
+						}
+					}
+
+					if( !rejectSample ){
+						if( BXDF_IS_SINGULAR(materialNode.type) ){
+							pathSetSingularVertex(paths + rayPathIndex, bounce);
+						}
+
+						// Get BXDF sample and generate outgoing ray based on surface BXDF
+						bxdfSample = bxdfGetSample(&surface, &materialNode, texMeta, texData, sample0, inRayDir, &bxdfOutRayDir, &bxdfPdf);
+
+						// Record the scattering event for the path and calculate
+						// the expressions that accept the direct light sample.
+						lpeScatterStates = lpeStep(lpePathStates, LPE_BXDF_EVENT(materialNode.type), numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+						lpeStep(lpeScatterStates, LPE_EVENT_LIGHT, numLpeExpressions, lpeTransitions, &lpeEmissiveMask);
+
+						// To calculate the origin for occlusion/indirect rays we displace the 
+						// surface hit point by a small epsilon along the normal to ensure that 
+						// we don't register an intersection with the same surface.  If this 
+						// material is refractive and we are hitting it from the outside we 
+						// need to ensure that the outgoing ray starts inside the surface.
+						float displaceDir = sign(dot(surface.normal, bxdfOutRayDir));
+						outBxdfRayOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal * displaceDir, rayBias);
+						// The emissive ray always starts away from the surface. This allows us to shade BTDFs
+						outEmissiveRayOrigin = DISPLACE_BY_BIAS(surface.point, surface.normal, rayBias);
+
+						// Select and sample emissive source
+						int emissiveIndex = numEmissives > 0 ? emissiveSelect(numEmissives, sample1.x, &emissiveSelectionPdf) : -1;
+						if( emissiveIndex > -1 ){
+							emissiveSample = emissiveGetSample(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, envDistribution, envDims, sample1, &emissiveOutRayDir, &emissivePdf, &distToEmissive);
+							occlusionCone = emissiveGetShadowCone(emissives + emissiveIndex, vertices, outEmissiveRayOrigin, surface.normal);
+							lpeEmissiveMask |= LIGHT_GROUP_NODE_MASK(lightGroupMasks, emissives[emissiveIndex].matNodeIndex);
+
+							// MIS: we already have a PDF for generating emissiveOutRayDir.
+							// Calculate a PDF for the BXDF sampler generating the same ray 
+							// and generate sampling weights using the power heuristic.
+							bxdfEmissivePdf = bxdfGetPdf(&surface, &materialNode, texMeta, texData, inRayDir, emissiveOutRayDir);
+							emissiveWeight = POWER_HEURISTIC(emissivePdf, bxdfEmissivePdf);
+
+							// We use the same approach to calculate a weight for the BXDF sample by 
+							// calculating the PDF for the emissive sampler generating bxdfOutRayDir
+							emissiveBxdfPdf = emissiveGetPdf(&surface, emissives + emissiveIndex, vertices, normals, uv, materialNodes, texMeta, texData, envDistribution, envDims, bxdfOutRayDir);
+							bxdfWeight = POWER_HEURISTIC(bxdfPdf, emissiveBxdfPdf);
+						}
+
+						// If we have a valid emissive sample allocate an occlusion ray.
+						float nDotEmissiveOutRay = max(0.0f, dot(surface.normal, emissiveOutRayDir));
+						if( MAX_VEC3_COMPONENT(emissiveSample) > 0.0f && emissivePdf > 0.0f && nDotEmissiveOutRay > 0.0f){
+							bxdfEmissiveSample = bxdfEval(&surface, &materialNode, texMeta, texData, inRayDir, emissiveOutRayDir);
+							emissiveSample *= emissiveWeight * bxdfEmissiveSample * curPathThroughput * nDotEmissiveOutRay / (emissivePdf * emissiveSelectionPdf);
+
+							// Occlusion rays travel through the medium on the
+							// outer side of the surface
+							int shadowMedium = inRayDotNormal < 0.0f ? sceneMediumMatNodeIndex : pathMedium;
+							if( mediumIsValid(shadowMedium, materialNodes) ){
+								emissiveSample *= mediumGetShadowTransmittance(materialNodes + shadowMedium, volumeGrids, volumeData, outEmissiveRayOrigin, emissiveOutRayDir, distToEmissive, &sampler.rndState);
+							}
+							if( emissives[emissiveIndex].type == EMISSIVE_TYPE_AREA_LIGHT ){
+								emissiveSample *= bdptStrategyWeight(bounce + 2, pathGetSingularMask(paths + rayPathIndex), numBounces, numLightVertices);
+							}
+							emissiveSample = clampSample(emissiveSample, bounce + 1, clampDirect, clampIndirect);
+							wgOcclusionRayIndex = MAX_VEC3_COMPONENT(emissiveSample) > 0.0f ? atomic_inc(&wgNumOcclusionRays) : -1;
+						}
+
+						// Disable bxdfWeight for singular surfaces (ideal mirror/dielectric).
+						// Rays scattered by other surfaces widen the path ray cone.
+						if( BXDF_IS_SINGULAR(materialNode.type) ){
+							bxdfWeight = 1.0f;
+						} else {
+							outConeSpread += RAY_CONE_SCATTER_SPREAD;
+						}
+
+						// If we got a valid bxdf sample update the path throughput
+						// Note: we are using the abs value of the dot product as 
+						// it will be negative for rays entering into refractive surfaces
+						float3 throughput = bxdfWeight * bxdfSample * bxdfTint * fabs(dot(surface.normal, bxdfOutRayDir));
+						if (MAX_VEC3_COMPONENT(throughput) > 0.0f && bxdfPdf > 0.0f){
+							pathSetThroughput(paths + rayPathIndex, curPathThroughput * throughput / bxdfPdf);
+							wgIndirectRayIndex = atomic_inc(&wgNumIndirectRays);
+						} 
+
+						// Update the path medium for rays that are transmitted
+						// through the surface
+						float outRayDotNormal = dot(surface.normal, bxdfOutRayDir);
+						if( outRayDotNormal * inRayDotNormal < 0.0f ){
+							nextPathMedium = mediumGetTransmitted(surface.matNodeIndex, outRayDotNormal < 0.0f, sceneMediumMatNodeIndex, materialNodes);
+						}
+					} // if(!rejectSample)
+				} // if(BXDF_IS_EMISSIVE)
+			} // if(HIT_FLAG_MEDIUM)
 		} // if(hitFlags)
 	} // if(globalId < *numRays)
 
@@ -200,30 +423,23 @@ __kernel void shadeHits(
 	if( wgOcclusionRayIndex != -1 ){
 		wgOcclusionRayIndex += wgNumOcclusionRays;
 		emissiveSamples[wgOcclusionRayIndex] = emissiveSample;
+		emissiveSampleLpeMasks[wgOcclusionRayIndex] = lpeEmissiveMask;
+		occlusionCones[wgOcclusionRayIndex] = occlusionCone;
 		rayNew(occlusionRays + wgOcclusionRayIndex, outEmissiveRayOrigin, emissiveOutRayDir, distToEmissive - INTERSECTION_WITH_LIGHT_EPSILON, rayPathIndex);
 	}
 
 	// Emit indirect ray
 	if( wgIndirectRayIndex != -1 ){
 		wgIndirectRayIndex += wgNumIndirectRays;
+		pathSetCone(paths + rayPathIndex, coneWidth, outConeSpread);
+		lpeStates[rayPathIndex] = lpeScatterStates;
+		pathMedia[rayPathIndex] = nextPathMedium;
 		rayNew(indirectRays + wgIndirectRayIndex, outBxdfRayOrigin, bxdfOutRayDir, FLT_MAX, rayPathIndex);
 	}
 }
 
 // Shade primary ray misses by sampling the scene background.
-__kernel void shadePrimaryRayMisses(
-		__global Ray *rays,
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global MaterialNode *materialNodes,
-		const uint sceneDiffuseMatNodeIndex,
-		// Texture data
-		__global TextureMetadata *texMeta,
-		__global uchar *texData,
-		// Output
-		__global float3 *accumulator
-		){
+__kernel void shadePrimaryRayMisses(SHADE_PRIMARY_RAY_MISSES_ARGS){
 
 	int globalId = get_global_id(0);
 
@@ -232,29 +448,21 @@ __kernel void shadePrimaryRayMisses(
 		return;
 	}
 
-	// Just sample global env map or use scene bg color
-	MaterialNode matNode = materialNodes[sceneDiffuseMatNodeIndex];
 	uint rayPathIndex;
-	float2 uv = rayToLatLongUV(rayGetDirAndPathIndex(rays + globalId, &rayPathIndex));
+	float3 rayDir = rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+	uint pixelIndex = paths[rayPathIndex].pixelIndex;
 
-	float3 kd = matGetSample3f(uv, matNode.reflectance, matNode.reflectanceTex, texMeta, texData);
-	accumulator[paths[rayPathIndex].pixelIndex] += kd;
+	// The path throughput includes the attenuation of the scene medium
+	float3 background = paths[rayPathIndex].throughput * sceneBackgroundSample(rayDir, pixelIndex, sceneDiffuseMatNodeIndex, sceneBackplateMatNodeIndex, sceneEnvMatNodeIndex, frameW, frameH, materialNodes, texMeta, texData);
+	accumulator[pixelIndex] += background;
+
+	uint lpeAcceptMask;
+	lpeStep(LPE_INITIAL_STATES, LPE_EVENT_BACKGROUND, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+	lpeAccumulate(background, lpeAcceptMask | LIGHT_GROUP_BACKGROUND_MASK(lightGroupMasks), pixelIndex, frameW * frameH, lpeAccumulator);
 }
 
 // Shade indirect ray misses by sampling the scene background.
-__kernel void shadeIndirectRayMisses(
-		__global Ray *rays,
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global MaterialNode *materialNodes,
-		const uint sceneDiffuseMatNodeIndex,
-		// Texture data
-		__global TextureMetadata *texMeta,
-		__global uchar *texData,
-		// Output
-		__global float3 *accumulator
-		){
+__kernel void shadeIndirectRayMisses(SHADE_INDIRECT_RAY_MISSES_ARGS){
 
 	int globalId = get_global_id(0);
 
@@ -263,26 +471,67 @@ __kernel void shadeIndirectRayMisses(
 		return;
 	}
 
-	// Just sample global env map or use scene bg color
-	MaterialNode matNode = materialNodes[sceneDiffuseMatNodeIndex];
+	// Sample the scene env map or use the scene bg color
+	uint rayPathIndex;
+	float3 rayDir = rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+	float3 kd = sceneEnvSample(rayDir, sceneDiffuseMatNodeIndex, sceneEnvMatNodeIndex, materialNodes, texMeta, texData);
+
+	// As this is an indirect ray we need to multiply the path throughput with the env sample
+	// and accumulate that. The ray was scattered bounce times before escaping the scene. The
+	// path throughput already includes the MIS weight for the environment light samples 
+	// generated by shadeHits.
+	float3 sample = clampSample(paths[rayPathIndex].throughput * kd, bounce, clampDirect, clampIndirect);
+	accumulator[paths[rayPathIndex].pixelIndex] += sample;
+
+	// Rays escaping towards an env map reach a light source
+	uint lpeAcceptMask;
+	lpeStep(lpeStates[rayPathIndex], sceneEnvMatNodeIndex >= 0 ? LPE_EVENT_LIGHT : LPE_EVENT_BACKGROUND, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+	lpeAccumulate(sample, lpeAcceptMask | LIGHT_GROUP_BACKGROUND_MASK(lightGroupMasks), paths[rayPathIndex].pixelIndex, numPixels, lpeAccumulator);
+}
+
+// Accumulate the emitted radiance for rays that hit an emissive surface without
+// scattering the paths any further. This kernel is used by the direct lighting
+// integrator to collect the light reached by the bxdf samples of the last 
+// shaded bounce; the path throughput already includes their MIS weights.
+__kernel void shadeEmissiveHits(SHADE_EMISSIVE_HITS_ARGS){
+
+	int globalId = get_global_id(0);
+
+	// If this thread is inactive or we missed the scene then ignore
+	if( globalId >= *numRays || !hitFlags[globalId] ){
+		return;
+	}
+
+	Surface surface;
+	MaterialNode materialNode;
 	uint rayPathIndex;
-	float2 uv = rayToLatLongUV(rayGetDirAndPathIndex(rays + globalId, &rayPathIndex));
+	Sampler sampler;
+	samplerInitRandom(&sampler, (uint2)(randSeed, globalId));
+	float3 bxdfTint = (float3)(1.0f, 1.0f, 1.0f);
+
+	float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+	surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+	surfaceSetWearInputs(&surface, intersections + globalId, vertices, normals, vertexOcclusion);
+	surfaceSetTangent(&surface, intersections + globalId, tangents);
+	uint matNodeIndex = matSelectNode(paths + rayPathIndex, &surface, inRayDir, &materialNode, &bxdfTint, materialNodes, &sampler, texMeta, texData);
+
+	// Make sure that the incoming ray is facing the emissive
+	if( !BXDF_IS_EMISSIVE(materialNode.type) || dot(inRayDir, surface.normal) <= 0.0f ){
+		return;
+	}
+
+	float3 radiance = paths[rayPathIndex].throughput * materialNode.scale * matGetSample3f(surface.uv, surface.texLod, materialNode.radiance, materialNode.radianceTex, texMeta, texData);
+	radiance = clampSample(radiance, bounce, clampDirect, clampIndirect);
+	uint pixelIndex = paths[rayPathIndex].pixelIndex;
+	accumulator[pixelIndex] += radiance;
 
-	// As this is an indirect ray we need to multiply the path throughput with the diffuse sample
-	// and accumulate that.
-	float3 kd = matGetSample3f(uv, matNode.reflectance, matNode.reflectanceTex, texMeta, texData);
-	accumulator[paths[rayPathIndex].pixelIndex] += paths[rayPathIndex].throughput * kd;
+	uint lpeAcceptMask;
+	lpeStep(lpeStates[rayPathIndex], LPE_EVENT_LIGHT, numLpeExpressions, lpeTransitions, &lpeAcceptMask);
+	lpeAccumulate(radiance, lpeAcceptMask | LIGHT_GROUP_NODE_MASK(lightGroupMasks, matNodeIndex), pixelIndex, numPixels, lpeAccumulator);
 }
 
 // Accumulate emissive samples for emissive surfaces that are not occluded.
-__kernel void accumulateEmissiveSamples(
-		__global Ray *rays,
-		__global const int *numRays,
-		__global Path *paths,
-		__global uint *hitFlags,
-		__global float3 *emissiveSamples,
-		__global float3 *accumulator
-		){
+__kernel void accumulateEmissiveSamples(ACCUMULATE_EMISSIVE_SAMPLES_ARGS){
 
 	int globalId = get_global_id(0);
 
@@ -293,6 +542,7 @@ __kernel void accumulateEmissiveSamples(
 
 	uint pathIndex = rayGetPathIndex(rays + globalId);
 	accumulator[paths[pathIndex].pixelIndex] += emissiveSamples[globalId];
+	lpeAccumulate(emissiveSamples[globalId], emissiveSampleLpeMasks[globalId], paths[pathIndex].pixelIndex, numPixels, lpeAccumulator);
 }
 
 #endif
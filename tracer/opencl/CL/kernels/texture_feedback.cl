@@ -0,0 +1,36 @@
+#ifndef TEXTURE_FEEDBACK_KERNEL_CL
+#define TEXTURE_FEEDBACK_KERNEL_CL
+
+// The range of the recorded texture footprints
+#define TEXTURE_FEEDBACK_MIN_LOD -64.0f
+#define TEXTURE_FEEDBACK_MAX_LOD 64.0f
+
+// For each ray hit, record the texture footprint of the hit surface into the
+// feedback slot of its material. The footprint is the log2 of the ray cone
+// width in uv space; the host adds the log2 of each texture's dimensions to
+// it for selecting the finest mip level that any of the material textures
+// need. The footprints are rounded down so the host errs on the side of
+// finer levels. Surfaces without uv coords and texture filters that always
+// sample the top mip level request the full texture resolution.
+__kernel void recordTextureFeedback(RECORD_TEXTURE_FEEDBACK_ARGS){
+
+	int globalId = get_global_id(0);
+	if( globalId >= *numRays || !hitFlags[globalId] ){
+		return;
+	}
+
+	uint rayPathIndex;
+	float3 inRayDir = -rayGetDirAndPathIndex(rays + globalId, &rayPathIndex);
+
+	Surface surface;
+	surfaceInit(&surface, intersections + globalId, vertices, normals, uv, materialIndices);
+	if( textureFilter == TEXTURE_FILTER_RAY_DIFFERENTIALS ){
+		float coneWidth = paths[rayPathIndex].coneWidth + paths[rayPathIndex].coneSpread * intersections[globalId].wuvt.w;
+		surfaceSetTextureLod(&surface, intersections + globalId, vertices, uv, inRayDir, coneWidth);
+	}
+
+	float lod = isnan(surface.texLod) ? TEXTURE_FEEDBACK_MIN_LOD : clamp(floor(surface.texLod), TEXTURE_FEEDBACK_MIN_LOD, TEXTURE_FEEDBACK_MAX_LOD);
+	atomic_min(textureFeedback + surface.matNodeIndex, (int)lod);
+}
+
+#endif
@@ -0,0 +1,117 @@
+#ifndef WEAR_KERNEL_CL
+#define WEAR_KERNEL_CL
+
+// Offset (relative to the occlusion radius) for displacing the origin of local
+// occlusion rays along the vertex normal so they do not hit the triangles
+// sharing the vertex.
+#define LOCAL_OCCLUSION_RAY_OFFSET 1e-3f
+
+int meshOcclusionTest(float3 origin, float3 dir, float maxDist, uint bvhRoot, __global BvhNode *bvhNodes, __global float4 *vertices);
+
+// Estimate the local occlusion for each vertex of triangles with a non-zero
+// occlusion radius. Each vertex emits numSamples cosine-weighted rays in the
+// hemisphere around its normal and the occlusion is set to the fraction of
+// rays that hit geometry of the same mesh within the radius. As vertices and
+// mesh BVHs share the same (mesh) space, the result does not depend on the
+// instances referencing the mesh.
+__kernel void bakeLocalOcclusion(BAKE_LOCAL_OCCLUSION_ARGS){
+	uint globalId = get_global_id(0);
+	if(globalId >= numVertices){
+		return;
+	}
+
+	uint triIndex = globalId / 3;
+	float radius = triOcclusionRadius[triIndex];
+	if( radius <= 0.0f ){
+		vertexOcclusion[globalId] = 0.0f;
+		return;
+	}
+
+	float3 normal = normalize(normals[globalId].xyz);
+	float3 origin = vertices[globalId].xyz + normal * fmax(radius * LOCAL_OCCLUSION_RAY_OFFSET, INTERSECTION_EPSILON);
+	uint bvhRoot = triBvhRoots[triIndex];
+
+	uint2 rndState = (uint2)(randSeed, globalId);
+	uint numOccluded = 0;
+	for(uint sample = 0; sample < numSamples; sample++){
+		float3 dir = cosWeightedHemisphereGetSample(normal, randomGetSample2f(&rndState));
+		numOccluded += meshOcclusionTest(origin, dir, radius, bvhRoot, bvhNodes, vertices);
+	}
+
+	vertexOcclusion[globalId] = numSamples > 0 ? (float)numOccluded / (float)numSamples : 0.0f;
+}
+
+// Check whether a mesh-space ray hits any triangle of the mesh BVH starting at
+// bvhRoot within maxDist. Nodes that do not fit in the traversal stack are
+// skipped.
+int meshOcclusionTest(float3 origin, float3 dir, float maxDist, uint bvhRoot, __global BvhNode *bvhNodes, __global float4 *vertices){
+	uint nodeStack[BVH_MAX_STACK_SIZE];
+	int stackIndex = 0;
+	BvhNode curNode;
+
+	// Node bbox and triangle intersection vars
+	float3 invDir = native_recip(dir);
+	float3 tmin, tmax, rmin, rmax, v0, edge01, edge02;
+	float minmax, maxmin;
+	int triStartIndex, numTriangles;
+
+	nodeStack[stackIndex++] = bvhRoot;
+	while(stackIndex > 0){
+		curNode = bvhNodes[nodeStack[--stackIndex]];
+
+		tmin = (curNode.minExtent.xyz - origin) * invDir;
+		tmax = (curNode.maxExtent.xyz - origin) * invDir;
+		rmin = fmin(tmin, tmax);
+		rmax = fmax(tmin, tmax);
+		minmax = fmin( fmin(rmax.x, rmax.y), rmax.z);
+		maxmin = fmax( fmax(rmin.x, rmin.y), rmin.z);
+		if( minmax < 0 || maxmin > minmax || maxmin >= maxDist ){
+			continue;
+		}
+
+		if(!BVH_IS_LEAF(curNode)){
+			if( stackIndex < BVH_MAX_STACK_SIZE - 1 ){
+				nodeStack[stackIndex++] = BVH_LEFT_CHILD(curNode);
+				nodeStack[stackIndex++] = BVH_RIGHT_CHILD(curNode);
+			}
+			continue;
+		}
+
+		// Intersect with all triangles using the Moller-Trumbore algorithm
+		triStartIndex = BVH_TRIANGLE_INDEX(curNode);
+		numTriangles = BVH_TRIANGLE_COUNT(curNode);
+		for(int vIndex = triStartIndex * 3; vIndex < (triStartIndex + numTriangles)*3;vIndex+=3){
+			v0 = vertices[vIndex].xyz;
+			edge01 = vertices[vIndex+1].xyz - v0;
+			edge02 = vertices[vIndex+2].xyz - v0;
+
+			float3 pVec = cross(dir, edge02);
+			float det = dot(edge01, pVec);
+			if (fabs(det) < INTERSECTION_EPSILON){
+				continue;
+			}
+
+			float invDet = native_recip(det);
+			float3 tVec = origin - v0;
+			float u = dot(tVec, pVec) * invDet;
+			if( u < 0.0f || u > 1.0f ){
+				continue;
+			}
+
+			float3 qVec = cross(tVec, edge01);
+			float v = dot(dir, qVec) * invDet;
+			if( v < 0.0f || u+v > 1.0f ){
+				continue;
+			}
+
+			float t = dot(edge02, qVec) * invDet;
+			if (t > INTERSECTION_EPSILON && t < maxDist){
+				return 1;
+			}
+		}
+	}
+
+	return 0;
+}
+
+#endif
@@ -1,5 +1,6 @@
 #include "constants.cl"
 #include "types.cl"
+#include "abi.cl"
 #include "util/util.cl"
 #include "samplers/samplers.cl"
 #include "bxdf/bxdf.cl"
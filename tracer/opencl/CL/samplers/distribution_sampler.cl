@@ -67,7 +67,7 @@ float3 ggxGetSample(float roughness, float3 inRayDir, float3 n, float2 randSampl
 	float sinTheta = sqrt(1.0f - cosTheta * cosTheta );
 
 	float cosPhi = native_cos(C_TWO_TIMES_PI * randSample.y);
-	float sinPhi = sqrt(1.0f - cosPhi * cosPhi);
+	float sinPhi = native_sin(C_TWO_TIMES_PI * randSample.y);
 
     // Project and rotate to get the halfway vector
     return normalize(u * sinTheta * cosPhi + v * sinTheta * sinPhi + n * cosTheta);
@@ -4,44 +4,73 @@
 #define EMISSIVE_TYPE_AREA_LIGHT 0
 #define EMISSIVE_TYPE_ENVIRONMENT_LIGHT 1
 
-float3 environmentLightGetSample( Surface *surface, __global Emissive *emissive, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 *outRayDir, float *pdf, float *distToEmissive); 
-float environmentLightGetPdf( Surface *surface, __global Emissive *emissive, float3 outRayDir);
+float3 environmentLightGetSample( Surface *surface, __global Emissive *emissive, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, __global float *envDistribution, uint2 envDims, float2 randSample, float3 *outRayDir, float *pdf, float *distToEmissive); 
+float environmentLightGetPdf( Surface *surface, __global Emissive *emissive, __global float *envDistribution, uint2 envDims, float3 outRayDir);
 float3 areaLightGetSample( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 *outRayDir, float *pdf, float *distToEmissive);
+float3 areaLightGetEmission( __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 *emissivePoint, float3 *emissiveNormal);
 float areaLightGetPdf( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float3 outRayDir);
 
-float3 emissiveGetSample( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float2 randSample, float3 *outRayDir, float *pdf, float *distToEmissive);
-float emissiveGetPdf( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, float3 outRayDir);
+float3 emissiveGetSample( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, __global float *envDistribution, uint2 envDims, float2 randSample, float3 *outRayDir, float *pdf, float *distToEmissive);
+float emissiveGetPdf( Surface *surface, __global Emissive *emissive, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global MaterialNode *materialNodes, __global TextureMetadata *texMeta, __global uchar *texData, __global float *envDistribution, uint2 envDims, float3 outRayDir);
 uint emissiveSelect( const int numLights, float randSample, float *pdf);
+float4 emissiveGetShadowCone( __global Emissive *emissive, __global float4 *vertices, float3 origin, float3 normal);
 
+// Sample the environment light. If an importance sampling distribution is
+// available (envDims.x > 0), directions are selected proportionally to the 
+// env map luminance so small and bright regions (e.g. sun disks) are sampled 
+// frequently. Otherwise, a cosine-weighted direction is generated.
 float3 environmentLightGetSample(
 		Surface *surface,
 		__global Emissive *emissive,
 		__global MaterialNode *materialNodes,
 		__global TextureMetadata *texMeta,
 		__global uchar *texData,
+		__global float *envDistribution,
+		uint2 envDims,
 		float2 randSample,
 		float3 *outRayDir,
 		float *pdf,
 		float *distToEmissive
 		){
 
-	*outRayDir = cosWeightedHemisphereGetSample(surface->normal, randSample);
-	*pdf = max(0.0f, dot(surface->normal, *outRayDir)) * C_1_PI;
+	float2 uv;
 	*distToEmissive = FLT_MAX;
 
-	// Convert ray direction vector into spherical UV and use that to sample the env map
-	float2 uv = rayToLatLongUV(*outRayDir);
-	MaterialNode matNode = materialNodes[emissive->matNodeIndex];
+	if( envDims.x > 0 ){
+		// Convert the uv pdf into a solid angle pdf: the lat/long mapping 
+		// maps the uv area to the sphere with jacobian 2 * pi^2 * sin(theta)
+		float uvPdf;
+		uv = envMapGetSampleUV(envDistribution, envDims, randSample, &uvPdf);
+		*outRayDir = latLongUVToRay(uv);
+		float sinTheta = native_sin(uv.y * C_PI);
+		*pdf = sinTheta > 0.0f ? uvPdf / (2.0f * C_PI * C_PI * sinTheta) : 0.0f;
+	} else {
+		*outRayDir = cosWeightedHemisphereGetSample(surface->normal, randSample);
+		*pdf = max(0.0f, dot(surface->normal, *outRayDir)) * C_1_PI;
+
+		// Convert ray direction vector into spherical UV and use that to sample the env map
+		uv = rayToLatLongUV(*outRayDir);
+	}
 
-	return matNode.scale * matGetSample3f(uv, matNode.radiance, matNode.radianceTex, texMeta, texData) * C_1_PI;
+	MaterialNode matNode = materialNodes[emissive->matNodeIndex];
+	return matNode.scale * matGetSample3f(uv, TEX_LOD_TOP_MIP, matNode.radiance, matNode.radianceTex, texMeta, texData);
 }
 
+// Get the solid angle pdf for sampling the given direction using environmentLightGetSample.
 float environmentLightGetPdf(
 		Surface *surface,
 		__global Emissive *emissive,
+		__global float *envDistribution,
+		uint2 envDims,
 		float3 outRayDir
 		){
 
+	if( envDims.x > 0 ){
+		float2 uv = rayToLatLongUV(outRayDir);
+		float sinTheta = native_sin(uv.y * C_PI);
+		return sinTheta > 0.0f ? envMapGetUVPdf(envDistribution, envDims, uv) / (2.0f * C_PI * C_PI * sinTheta) : 0.0f;
+	}
+
 	// We use the same formula as for lambert shading: cos(theta) / PI
 	return max(0.0f, dot(surface->normal, outRayDir) * C_1_PI);
 }
@@ -104,7 +133,7 @@ float3 areaLightGetSample(
 
 		// convert from area to solid angle using formula (25) from total compedium:
 		// ω = cos(θy) / dist^2
-		float3 ke = matGetSample3f(emissiveUV, matNode.radiance, matNode.radianceTex, texMeta, texData);
+		float3 ke = matGetSample3f(emissiveUV, TEX_LOD_TOP_MIP, matNode.radiance, matNode.radianceTex, texMeta, texData);
 		return matNode.scale * ke * nDotOutRay / squaredDistToLight;
 	}
 
@@ -112,6 +141,50 @@ float3 areaLightGetSample(
 	return (float3)(0.0f, 0.0f, 0.0f);
 }
 
+// Select a random point on the emissive primitive with PDF=1/area and return
+// its emitted radiance together with the *world* point and normal coordinates.
+// This is used for starting light subpaths.
+float3 areaLightGetEmission(
+		__global Emissive *emissive,
+		__global float4 *vertices, 
+		__global float4 *normals,
+		__global float2 *uv,
+		__global MaterialNode *materialNodes,
+		__global TextureMetadata *texMeta,
+		__global uchar *texData,
+		float2 randSample,
+		float3 *emissivePoint,
+		float3 *emissiveNormal
+		){
+
+	float r1sqrt = native_sqrt(randSample.x);
+	float ru = (1.0f - randSample.y) * r1sqrt;
+	float rv = randSample.y * r1sqrt;
+	float3 wuv = (float3)(1.0f - ru - rv, ru, rv);
+	int offset = emissive->triIndex * 3;
+
+	float3 v0 = mul4x1(vertices[offset].xyz, emissive->transformMat0, emissive->transformMat1, emissive->transformMat2, emissive->transformMat3);
+	float3 v1 = mul4x1(vertices[offset+1].xyz, emissive->transformMat0, emissive->transformMat1, emissive->transformMat2, emissive->transformMat3);
+	float3 v2 = mul4x1(vertices[offset+2].xyz, emissive->transformMat0, emissive->transformMat1, emissive->transformMat2, emissive->transformMat3);
+	*emissivePoint = wuv.x * v0 + wuv.y * v1 + wuv.z * v2;
+
+	// Emissives only emit towards the side of their vertex normals. As the 
+	// transformation may contain a translation, the world normal is calculated
+	// from the transformed vertices and oriented using the mesh normals.
+	*emissiveNormal = normalize(cross(v1 - v0, v2 - v0));
+	float3 meshGeomNormal = cross((vertices[offset+1] - vertices[offset]).xyz, (vertices[offset+2] - vertices[offset]).xyz);
+	if( dot(meshGeomNormal, (normals[offset] + normals[offset+1] + normals[offset+2]).xyz) < 0.0f ){
+		*emissiveNormal = -*emissiveNormal;
+	}
+
+	float2 emissiveUV = wuv.x * uv[offset] + 
+		wuv.y * uv[offset+1] + 
+		wuv.z * uv[offset+2];
+
+	MaterialNode matNode = materialNodes[emissive->matNodeIndex];
+	return matNode.scale * matGetSample3f(emissiveUV, TEX_LOD_TOP_MIP, matNode.radiance, matNode.radianceTex, texMeta, texData);
+}
+
 // Given a pre-calculated bounce ray, calculate a PDF for hitting this 
 // emissive primitive.
 float areaLightGetPdf(
@@ -184,6 +257,8 @@ float3 emissiveGetSample(
 		__global MaterialNode *materialNodes,
 		__global TextureMetadata *texMeta,
 		__global uchar *texData,
+		__global float *envDistribution,
+		uint2 envDims,
 		float2 randSample,
 		float3 *outRayDir,
 		float *pdf,
@@ -194,7 +269,7 @@ float3 emissiveGetSample(
 		case EMISSIVE_TYPE_AREA_LIGHT:
 			return areaLightGetSample(surface, emissive, vertices, normals, uv, materialNodes, texMeta, texData, randSample, outRayDir, pdf, distToEmissive);
 		case EMISSIVE_TYPE_ENVIRONMENT_LIGHT:
-			return environmentLightGetSample(surface, emissive, materialNodes, texMeta, texData, randSample, outRayDir, pdf, distToEmissive);
+			return environmentLightGetSample(surface, emissive, materialNodes, texMeta, texData, envDistribution, envDims, randSample, outRayDir, pdf, distToEmissive);
 	}
 	return (float3)(0.0f, 0.0f, 0.0f);
 }
@@ -210,6 +285,8 @@ float emissiveGetPdf(
 		__global MaterialNode *materialNodes,
 		__global TextureMetadata *texMeta,
 		__global uchar *texData,
+		__global float *envDistribution,
+		uint2 envDims,
 		float3 outRayDir
 		){
 
@@ -217,12 +294,44 @@ float emissiveGetPdf(
 		case EMISSIVE_TYPE_AREA_LIGHT:
 			return areaLightGetPdf(surface, emissive, vertices, normals, uv, materialNodes, texMeta, texData, outRayDir);
 		case EMISSIVE_TYPE_ENVIRONMENT_LIGHT:
-			return environmentLightGetPdf(surface, emissive, outRayDir);
+			return environmentLightGetPdf(surface, emissive, envDistribution, envDims, outRayDir);
 	}
 
 	return 0.0f;
 }
 
+// Get the world-space centroid (xyz) and radius (w) of the disk that 
+// approximates an area light when tracing soft shadow cones from origin. The
+// disk has the same area as the emissive triangle. Environment lights and
+// area lights whose centroid lies below the surface with the given normal 
+// are not approximated and get a zero radius; a zero normal skips the check.
+float4 emissiveGetShadowCone(
+		__global Emissive *emissive,
+		__global float4 *vertices,
+		float3 origin,
+		float3 normal
+		){
+
+	if( emissive->type != EMISSIVE_TYPE_AREA_LIGHT ){
+		return (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+	}
+
+	int offset = emissive->triIndex * 3;
+	float3 centroid = mul4x1(
+			(vertices[offset] + vertices[offset+1] + vertices[offset+2]).xyz / 3.0f,
+			emissive->transformMat0,
+			emissive->transformMat1,
+			emissive->transformMat2,
+			emissive->transformMat3
+			);
+
+	if( dot(normal, centroid - origin) < 0.0f ){
+		return (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+	}
+
+	return (float4)(centroid, native_sqrt(emissive->area * C_1_PI));
+}
+
 // Select a random emissive surface from the set of emissive primitives
 uint emissiveSelect(
 		const int numLights,
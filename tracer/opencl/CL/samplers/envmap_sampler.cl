@@ -0,0 +1,76 @@
+#ifndef ENVMAP_SAMPLER_CL
+#define ENVMAP_SAMPLER_CL
+
+uint envCdfFindInterval(__global float *cdf, uint count, float u);
+float2 envMapGetSampleUV(__global float *envDistribution, uint2 envDims, float2 randSample, float *uvPdf);
+float envMapGetUVPdf(__global float *envDistribution, uint2 envDims, float2 uv);
+float3 latLongUVToRay(float2 uv);
+
+// The environment map distribution is a piecewise-constant 2D distribution
+// built on the host (see scene.EnvMapDistribution). The buffer contains the
+// marginal CDF of the rows (envDims.y + 1 entries) followed by the conditional 
+// CDF of each row (envDims.x + 1 entries per row).
+
+// Find the index of the CDF interval that contains u using binary search. The 
+// CDF contains count + 1 entries.
+uint envCdfFindInterval(__global float *cdf, uint count, float u){
+	uint lo = 0;
+	uint hi = count;
+	while( lo + 1 < hi ){
+		uint mid = (lo + hi) >> 1;
+		if( cdf[mid] <= u ){
+			lo = mid;
+		} else {
+			hi = mid;
+		}
+	}
+	return lo;
+}
+
+// Sample the environment map distribution and return the sampled uv 
+// coordinates. The pdf of the sample with respect to the uv area is stored
+// into uvPdf.
+float2 envMapGetSampleUV(__global float *envDistribution, uint2 envDims, float2 randSample, float *uvPdf){
+	__global float *marginal = envDistribution;
+	uint y = envCdfFindInterval(marginal, envDims.y, randSample.y);
+	float marginalPdf = marginal[y + 1] - marginal[y];
+
+	__global float *conditional = envDistribution + envDims.y + 1 + y * (envDims.x + 1);
+	uint x = envCdfFindInterval(conditional, envDims.x, randSample.x);
+	float conditionalPdf = conditional[x + 1] - conditional[x];
+
+	*uvPdf = marginalPdf * conditionalPdf * (float)(envDims.x * envDims.y);
+
+	// Offset the sample within the selected cell
+	float dv = marginalPdf > 0.0f ? (randSample.y - marginal[y]) / marginalPdf : 0.5f;
+	float du = conditionalPdf > 0.0f ? (randSample.x - conditional[x]) / conditionalPdf : 0.5f;
+	return (float2)(
+			((float)x + du) / (float)envDims.x,
+			((float)y + dv) / (float)envDims.y
+	);
+}
+
+// Get the pdf for sampling the given uv coordinates with respect to the uv area.
+float envMapGetUVPdf(__global float *envDistribution, uint2 envDims, float2 uv){
+	uint x = min((uint)(clamp(uv.x, 0.0f, 1.0f) * (float)envDims.x), envDims.x - 1);
+	uint y = min((uint)(clamp(uv.y, 0.0f, 1.0f) * (float)envDims.y), envDims.y - 1);
+
+	__global float *conditional = envDistribution + envDims.y + 1 + y * (envDims.x + 1);
+	return (envDistribution[y + 1] - envDistribution[y]) * (conditional[x + 1] - conditional[x]) * (float)(envDims.x * envDims.y);
+}
+
+// Convert lat/long uv coordinates into a unit direction vector. This is the 
+// inverse of rayToLatLongUV.
+float3 latLongUVToRay(float2 uv){
+	float phi = uv.x * C_TWO_TIMES_PI;
+	float theta = uv.y * C_PI;
+	float sinTheta = native_sin(theta);
+
+	return (float3)(
+			sinTheta * native_sin(phi),
+			native_cos(theta),
+			sinTheta * native_cos(phi)
+	);
+}
+
+#endif
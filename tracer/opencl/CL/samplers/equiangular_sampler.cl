@@ -0,0 +1,55 @@
+#ifndef EQUIANGULAR_SAMPLER_CL
+#define EQUIANGULAR_SAMPLER_CL
+
+float equiAngularGetSample(float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float randSample, float *pdf);
+float equiAngularGetPdf(float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float t);
+
+// Sample a distance along a ray segment [0, tMax] proportionally to the
+// inverse squared distance to a point light. This is used for single
+// scattering inside participating media where distance sampling based on
+// transmittance alone yields noisy light shafts.
+//
+// See "Importance Sampling Techniques for Path Tracing in Participating Media"
+// (Kulla & Fajardo, EGSR 2012).
+//
+// PDF = D / ((thetaB - thetaA) * (D^2 + h^2))
+float equiAngularGetSample(float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float randSample, float *pdf){
+	// Project light position onto the ray
+	float delta = dot(lightPos - rayOrigin, rayDir);
+
+	// Distance from light to the ray
+	float D = length(rayOrigin + delta * rayDir - lightPos);
+
+	// Angles subtended by the segment endpoints
+	float thetaA = atan2(0.0f - delta, D);
+	float thetaB = atan2(tMax - delta, D);
+	if( D == 0.0f || thetaB <= thetaA ){
+		*pdf = 0.0f;
+		return 0.0f;
+	}
+
+	// Uniformly sample an angle and map it back to a distance
+	float h = D * tan(mix(thetaA, thetaB, randSample));
+	*pdf = D / ((thetaB - thetaA) * (D * D + h * h));
+
+	return clamp(delta + h, 0.0f, tMax);
+}
+
+// Get the pdf for selecting distance t along the ray segment [0, tMax] using
+// equi-angular sampling. This is required for combining equi-angular and
+// transmittance-based distance sampling via MIS.
+float equiAngularGetPdf(float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float t){
+	float delta = dot(lightPos - rayOrigin, rayDir);
+	float D = length(rayOrigin + delta * rayDir - lightPos);
+
+	float thetaA = atan2(0.0f - delta, D);
+	float thetaB = atan2(tMax - delta, D);
+	if( D == 0.0f || thetaB <= thetaA || t < 0.0f || t > tMax ){
+		return 0.0f;
+	}
+
+	float h = t - delta;
+	return D / ((thetaB - thetaA) * (D * D + h * h));
+}
+
+#endif
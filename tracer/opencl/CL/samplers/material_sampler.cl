@@ -6,19 +6,28 @@
 #define MAT_OP_BUMP_MAP   10003
 #define MAT_OP_NORMAL_MAP 10004
 #define MAT_OP_DISPERSE   10005
+#define MAT_OP_MIX_CURVATURE 10006
+#define MAT_OP_MIX_OCCLUSION 10007
+#define MAT_OP_ALPHA_CUTOUT 10008
+#define MAT_OP_MEDIUM 10009
 #define MAT_NODE_IS_OP(node) (node->type >= MAT_OP_MIX)
+
+// Number of height layers used by the parallax preview
+#define MAT_PARALLAX_STEPS 8
 #ifndef BXDF_INVALID
 	#define BXDF_INVALID 0
 #endif
 
-void matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, MaterialNode *selectedMaterial, float3 *tint, __global MaterialNode* materialNodes, uint2 *rndState, __global TextureMetadata *texMeta, __global uchar *texData );
-float3 matGetSample3f(float2 uv, float3 defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
-float matGetSample1f(float2 uv, float defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
-float3 matGetBumpSample3f(float3 normal, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
-float3 matGetNormalSample3f(float3 normal, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
+uint matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, MaterialNode *selectedMaterial, float3 *tint, __global MaterialNode* materialNodes, Sampler *sampler, __global TextureMetadata *texMeta, __global uchar *texData );
+float3 matGetSample3f(float2 uv, float lod, float3 defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
+float matGetSample1f(float2 uv, float lod, float defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
+float3 matGetBumpSample3f(float3 normal, float4 tangent, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
+float3 matGetNormalSample3f(float3 normal, float4 tangent, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
+float2 matGetParallaxUV(float3 normal, float4 tangent, float2 uv, float3 inRayDir, float scale, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData);
 
-// Traverse the layered material tree for this surface and select a leaf node
-void matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, MaterialNode *selectedMaterial, float3 *tint, __global MaterialNode* materialNodes, uint2 *rndState, __global TextureMetadata *texMeta, __global uchar *texData ){
+// Traverse the layered material tree for this surface and select a leaf node.
+// The index of the selected leaf node is returned.
+uint matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, MaterialNode *selectedMaterial, float3 *tint, __global MaterialNode* materialNodes, Sampler *sampler, __global TextureMetadata *texMeta, __global uchar *texData ){
 	__global MaterialNode* node = materialNodes + surface->matNodeIndex;
 	float2 sample;
 	float2 forceIOR = (float2)(0.0f, 0.0f);
@@ -27,21 +36,49 @@ void matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, Mater
 		switch(node->type){
 			case MAT_OP_MIX: 
 				// Depending on the sample, follow left or right
-				sample = randomGetSample2f(rndState);
+				sample = samplerGetSample2f(sampler);
 				node = materialNodes + (sample.x < node->mixWeight ? node->leftChild : node->rightChild);
 				break;
 			case MAT_OP_MIX_MAP: 
 				// Sample weight from texture
-				sample = randomGetSample2f(rndState);
-				sample.y = texGetSample1f(surface->uv, node->mixWeightsTex, texMeta, texData);
+				sample = samplerGetSample2f(sampler);
+				sample.y = texGetSample1f(surface->uv, surface->texLod, node->mixWeightsTex, texMeta, texData);
+				node = materialNodes + (sample.x < sample.y ? node->leftChild : node->rightChild);
+				break;
+			case MAT_OP_MIX_CURVATURE:
+				// Use the scaled surface curvature as the weight; a negative
+				// scale selects the left child on concave surfaces
+				sample = samplerGetSample2f(sampler);
+				sample.y = clamp(surface->curvature * node->curvatureScale, 0.0f, 1.0f);
 				node = materialNodes + (sample.x < sample.y ? node->leftChild : node->rightChild);
 				break;
+			case MAT_OP_MIX_OCCLUSION:
+				// Use the baked local occlusion as the weight
+				sample = samplerGetSample2f(sampler);
+				node = materialNodes + (sample.x < surface->occlusion ? node->leftChild : node->rightChild);
+				break;
 			case MAT_OP_BUMP_MAP:
-				surface->normal = matGetBumpSample3f(surface->normal, surface->uv, node->bumpTex, texMeta, texData);
+				if( node->parallaxScale > 0.0f ){
+					surface->uv = matGetParallaxUV(surface->normal, surface->tangent, surface->uv, inRayDir, node->parallaxScale, node->bumpTex, texMeta, texData);
+				}
+				surface->normal = matGetBumpSample3f(surface->normal, surface->tangent, surface->uv, node->bumpTex, texMeta, texData);
+				node = materialNodes + node->leftChild;
+				break;
+			case MAT_OP_ALPHA_CUTOUT:
+				// Transparent texels are skipped by the intersection kernels
+				node = materialNodes + node->leftChild;
+				break;
+			case MAT_OP_MEDIUM:
+				// Media are handled by the integrator kernels; media
+				// without a surface cannot be shaded
+				if( (int)node->leftChild < 0 ){
+					selectedMaterial->type = BXDF_INVALID;
+					return (uint)(node - materialNodes);
+				}
 				node = materialNodes + node->leftChild;
 				break;
 			case MAT_OP_NORMAL_MAP:
-				surface->normal = matGetNormalSample3f(surface->normal, surface->uv, node->bumpTex, texMeta, texData);
+				surface->normal = matGetNormalSample3f(surface->normal, surface->tangent, surface->uv, node->bumpTex, texMeta, texData);
 				node = materialNodes + node->leftChild;
 				break;
 			case MAT_OP_DISPERSE:
@@ -60,7 +97,7 @@ void matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, Mater
 						*tint = (float3)(0.0f, 0.0f, 1.0f);
 						forceIOR = (float2)(node->intDispersionIORs.z, node->extDispersionIORs.z);
 				} else {
-					sample = randomGetSample2f(rndState);
+					sample = samplerGetSample2f(sampler);
 					if( sample.x < 0.333f ){
 						*tint = (float3)(1.0f, 0.0f, 0.0f);
 						forceIOR = (float2)(node->intDispersionIORs.x, node->extDispersionIORs.x);
@@ -85,48 +122,81 @@ void matSelectNode(__global Path *path, Surface *surface, float3 inRayDir, Mater
 	// Apply dispersion IORs
 	selectedMaterial->intIOR = max(selectedMaterial->intIOR, forceIOR.x);
 	selectedMaterial->extIOR = max(selectedMaterial->extIOR, forceIOR.y);
+
+	return (uint)(node - materialNodes);
 }
 
-// Sample texture using the supplied uv coordinates and return a float3 vector. 
-// If texIndex is -1 then fall-back to the supplied default value.
-float3 matGetSample3f(float2 uv, float3 defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
+// Sample texture using the supplied uv coordinates and LOD and return a float3 
+// vector. If texIndex is -1 then fall-back to the supplied default value.
+float3 matGetSample3f(float2 uv, float lod, float3 defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
 	if( texIndex == -1 ){
 		return defaultValue;
 	}
 
-	return texGetSample3f( uv, texIndex, texMeta, texData );
+	return texGetSample3f( uv, lod, texIndex, texMeta, texData );
 }
 
-// Sample texture using the supplied uv coordinates and return a float value.
-// If texIndex is -1 then fall-back to the supplied default value.
-float matGetSample1f(float2 uv, float defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
+// Sample texture using the supplied uv coordinates and LOD and return a float
+// value. If texIndex is -1 then fall-back to the supplied default value.
+float matGetSample1f(float2 uv, float lod, float defaultValue, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
 	if( texIndex == -1 ){
 		return defaultValue;
 	}
 
-	return texGetSample1f( uv, texIndex, texMeta, texData );
+	return texGetSample1f( uv, lod, texIndex, texMeta, texData );
 }
 
-// Apply normal map to intersection normal.
-float3 matGetNormalSample3f(float3 normal, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
+// Apply a tangent-space normal map to intersection normal. Like bump maps,
+// normal maps are always sampled at the top mip level.
+float3 matGetNormalSample3f(float3 normal, float4 tangent, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
 	// Generate tangent, bi-tangent vectors
 	float3 u,v;
-	TANGENT_VECTORS(normal, u, v);
+	surfaceTangentFrame(normal, tangent, &u, &v);
 
 	// Sample normal map and convert it into the [-1, 1] range. 
 	// R, G components encode the range [-1, 1] into a value [0, 255]
 	// B component encodes the range [0, 1] into [128, 255]
-	float3 sample = (texGetSample3f( uv, texIndex, texMeta, texData ) * 2.0f) - 1.0f;
+	float3 sample = (texGetSample3f( uv, TEX_LOD_TOP_MIP, texIndex, texMeta, texData ) * 2.0f) - 1.0f;
 	return normalize(u * sample.x + v * sample.y + 0.5f * normal * sample.z);
 }
 
 // Apply bump map to intersection normal.
-float3 matGetBumpSample3f(float3 normal, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
+float3 matGetBumpSample3f(float3 normal, float4 tangent, float2 uv, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
 	// Generate tangent, bi-tangent vectors
 	float3 u,v;
-	TANGENT_VECTORS(normal, u, v);
+	surfaceTangentFrame(normal, tangent, &u, &v);
 
 	float3 sample = (texGetBumpSample3f( uv, texIndex, texMeta, texData ) * 2.0f) - 1.0f;
 	return normalize(u * sample.x + v * sample.y + normal * sample.z);
 }
+
+// Offset the surface uv coordinates using the bump map as a height map. This
+// implements a cheap parallax occlusion mapping variant that marches the view
+// ray through a fixed number of height layers. It is used for previewing
+// displacement in interactive mode and does not modify the surface geometry.
+float2 matGetParallaxUV(float3 normal, float4 tangent, float2 uv, float3 inRayDir, float scale, int texIndex, __global TextureMetadata *texMeta, __global uchar* texData){
+	// Generate tangent, bi-tangent vectors
+	float3 u,v;
+	surfaceTangentFrame(normal, tangent, &u, &v);
+
+	// Express view vector in tangent space
+	float3 viewDir = -inRayDir;
+	float3 tsView = (float3)(dot(viewDir, u), dot(viewDir, v), dot(viewDir, normal));
+	if( tsView.z <= 0.0f ){
+		return uv;
+	}
+
+	float layerStep = 1.0f / (float)MAT_PARALLAX_STEPS;
+	float2 uvStep = (tsView.xy / max(tsView.z, 0.05f)) * scale * layerStep;
+
+	float layerDepth = 0.0f;
+	float depth = 1.0f - texGetSample1f(uv, TEX_LOD_TOP_MIP, texIndex, texMeta, texData);
+	for(int step = 0; step < MAT_PARALLAX_STEPS && layerDepth < depth; step++){
+		uv -= uvStep;
+		layerDepth += layerStep;
+		depth = 1.0f - texGetSample1f(uv, TEX_LOD_TOP_MIP, texIndex, texMeta, texData);
+	}
+
+	return uv;
+}
 #endif
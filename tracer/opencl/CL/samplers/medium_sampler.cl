@@ -0,0 +1,353 @@
+#ifndef MEDIUM_SAMPLER_CL
+#define MEDIUM_SAMPLER_CL
+
+// Below this anisotropy value the phase function is treated as isotropic
+#define MEDIUM_ISOTROPIC_EPSILON 1e-3f
+
+// The max number of tentative collisions sampled by delta and ratio tracking
+// along a single ray segment. Segments that need more steps are truncated.
+#define MEDIUM_MAX_TRACKING_STEPS 256
+
+bool mediumIsValid(int matNodeIndex, __global MaterialNode *materialNodes);
+int mediumGetTransmitted(int matNodeIndex, bool entering, int sceneMediumMatNodeIndex, __global MaterialNode *materialNodes);
+float3 mediumGetTransmittance(__global MaterialNode *medium, float dist);
+float mediumGetDistanceSample(__global MaterialNode *medium, float tMax, float2 randSample, float3 *weight, bool *scatter);
+float mediumGetEquiAngularDistanceSample(__global MaterialNode *medium, float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float2 randSample, float3 *weight, bool *scatter);
+float mediumGetFreeFlightDistance(float3 sigmaT, float tMax, float2 randSample, bool *scatter);
+float mediumGetFreeFlightPdf(float3 sigmaT, float t, bool scatter);
+float mediumPhaseEval(float g, float cosTheta);
+float3 mediumPhaseGetSample(float g, float3 rayDir, float2 randSample, float *pdf);
+bool mediumIsHeterogeneous(__global MaterialNode *medium);
+float mediumGetMajorant(__global MaterialNode *medium, __global VolumeGrid *volumeGrids);
+bool mediumGetTrackingRange(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, float3 rayOrigin, float3 rayDir, float tMax, bool withEmission, float2 *tRange);
+float mediumGetTrackedDistanceSample(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, __global float *volumeData, float3 rayOrigin, float3 rayDir, float tMax, uint2 *rndState, float3 *weight, float3 *emitted, bool *scatter);
+float3 mediumGetShadowTransmittance(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, __global float *volumeData, float3 rayOrigin, float3 rayDir, float dist, uint2 *rndState);
+float volumeGridGetValue(__global VolumeGrid *grid, __global float *volumeData, float3 point);
+float volumeGridGetVoxel(__global VolumeGrid *grid, __global float *volumeData, int3 coord);
+bool volumeGridClip(__global VolumeGrid *grid, float3 rayOrigin, float3 rayDir, float tMax, float2 *tRange);
+bool volumeGridClipAxis(float origin, float dir, float hi, float2 *tRange);
+
+// Check whether a material node index points to a medium node.
+bool mediumIsValid(int matNodeIndex, __global MaterialNode *materialNodes){
+	return matNodeIndex >= 0 && materialNodes[matNodeIndex].type == MAT_OP_MEDIUM;
+}
+
+// Get the medium that a path enters when it is transmitted through a surface
+// with the given root material node. Paths entering a surface use the interior
+// medium of its material (or no medium if the material does not define one)
+// while paths leaving a surface return to the scene medium. Nested media are
+// not supported.
+int mediumGetTransmitted(int matNodeIndex, bool entering, int sceneMediumMatNodeIndex, __global MaterialNode *materialNodes){
+	if( !entering ){
+		return sceneMediumMatNodeIndex;
+	}
+	return mediumIsValid(matNodeIndex, materialNodes) ? matNodeIndex : -1;
+}
+
+// Get the transmittance along a ray segment of the given length.
+float3 mediumGetTransmittance(__global MaterialNode *medium, float dist){
+	return exp(-(medium->absorption + medium->scattering) * dist);
+}
+
+// Sample a free-flight distance along a ray segment of length tMax. The
+// distance is sampled using the extinction coefficient of a uniformly selected
+// channel and the returned weight is divided by the pdf of the combined
+// single-channel strategies. If scatter is set, a scattering event occurs at
+// the returned distance and the weight includes the scattering coefficients.
+// Otherwise, the ray reaches the end of the segment and the weight is the
+// attenuation of the segment. Media that do not scatter light are handled by
+// attenuating the ray without sampling a distance.
+float mediumGetDistanceSample(__global MaterialNode *medium, float tMax, float2 randSample, float3 *weight, bool *scatter){
+	float3 sigmaS = medium->scattering;
+	float3 sigmaT = medium->absorption + sigmaS;
+
+	*scatter = false;
+	if( max(sigmaS.x, max(sigmaS.y, sigmaS.z)) <= 0.0f ){
+		*weight = exp(-sigmaT * tMax);
+		return tMax;
+	}
+
+	float t = mediumGetFreeFlightDistance(sigmaT, tMax, randSample, scatter);
+	float pdf = mediumGetFreeFlightPdf(sigmaT, t, *scatter);
+	if( pdf <= 0.0f ){
+		*weight = (float3)(0.0f, 0.0f, 0.0f);
+		return t;
+	}
+
+	float3 tr = exp(-sigmaT * t);
+	*weight = (*scatter ? tr * sigmaS : tr) / pdf;
+	return t;
+}
+
+// Sample a free-flight distance along a ray segment of length tMax by
+// combining the strategy used by mediumGetDistanceSample with equi-angular
+// sampling towards lightPos. One of the two strategies is selected uniformly
+// and the returned weight is divided by the pdf of the combined strategies
+// (balance heuristic). Equi-angular samples always scatter inside the segment
+// so that single scattering near lights converges quickly. If the light lies
+// on the ray line, only the free-flight strategy is used.
+float mediumGetEquiAngularDistanceSample(__global MaterialNode *medium, float3 rayOrigin, float3 rayDir, float tMax, float3 lightPos, float2 randSample, float3 *weight, bool *scatter){
+	float3 sigmaS = medium->scattering;
+	float3 sigmaT = medium->absorption + sigmaS;
+
+	*scatter = false;
+	if( max(sigmaS.x, max(sigmaS.y, sigmaS.z)) <= 0.0f ){
+		*weight = exp(-sigmaT * tMax);
+		return tMax;
+	}
+
+	float eaPdf;
+	float eaT = equiAngularGetSample(rayOrigin, rayDir, tMax, lightPos, randSample.y, &eaPdf);
+	if( eaPdf <= 0.0f ){
+		return mediumGetDistanceSample(medium, tMax, randSample, weight, scatter);
+	}
+
+	// Select a strategy and rescale the sample used for selecting it
+	float t;
+	if( randSample.x < 0.5f ){
+		t = mediumGetFreeFlightDistance(sigmaT, tMax, (float2)(2.0f * randSample.x, randSample.y), scatter);
+	} else {
+		t = eaT;
+		*scatter = true;
+	}
+
+	float pdf = 0.5f * mediumGetFreeFlightPdf(sigmaT, t, *scatter);
+	if( *scatter ){
+		pdf += 0.5f * equiAngularGetPdf(rayOrigin, rayDir, tMax, lightPos, t);
+	}
+	if( pdf <= 0.0f ){
+		*weight = (float3)(0.0f, 0.0f, 0.0f);
+		return t;
+	}
+
+	float3 tr = exp(-sigmaT * t);
+	*weight = (*scatter ? tr * sigmaS : tr) / pdf;
+	return t;
+}
+
+// Sample a free-flight distance using the extinction coefficient of the
+// channel selected by the first sample dimension. The distance is clamped to
+// tMax if the ray reaches the end of the segment.
+float mediumGetFreeFlightDistance(float3 sigmaT, float tMax, float2 randSample, bool *scatter){
+	float channelSigmaT = randSample.x < 1.0f / 3.0f ? sigmaT.x : (randSample.x < 2.0f / 3.0f ? sigmaT.y : sigmaT.z);
+	float t = channelSigmaT > 0.0f ? -log(1.0f - randSample.y) / channelSigmaT : FLT_MAX;
+
+	*scatter = t < tMax;
+	return *scatter ? t : tMax;
+}
+
+// Get the pdf of mediumGetFreeFlightDistance for a scattering event at 
+// distance t or the probability of reaching the end of the segment at t.
+float mediumGetFreeFlightPdf(float3 sigmaT, float t, bool scatter){
+	float3 tr = exp(-sigmaT * t);
+	float3 density = scatter ? sigmaT * tr : tr;
+	return (density.x + density.y + density.z) / 3.0f;
+}
+
+// Evaluate the Henyey-Greenstein phase function where cosTheta is the cosine
+// of the angle between the propagation and the scattered directions.
+//
+// p = (1 - g^2) / (4 * pi * (1 + g^2 - 2 * g * cosTheta)^(3/2))
+float mediumPhaseEval(float g, float cosTheta){
+	float denom = 1.0f + g * g - 2.0f * g * cosTheta;
+	if( denom <= 0.0f ){
+		return 0.0f;
+	}
+	return (1.0f - g * g) * C_1_PI / (4.0f * denom * sqrt(denom));
+}
+
+// Sample the Henyey-Greenstein phase function for a ray propagating along
+// rayDir. As the sampled directions are distributed according to the phase
+// function, the returned pdf also equals the phase function value.
+float3 mediumPhaseGetSample(float g, float3 rayDir, float2 randSample, float *pdf){
+	float cosTheta;
+	if( fabs(g) < MEDIUM_ISOTROPIC_EPSILON ){
+		cosTheta = 1.0f - 2.0f * randSample.x;
+	} else {
+		float sqrTerm = (1.0f - g * g) / (1.0f - g + 2.0f * g * randSample.x);
+		cosTheta = (1.0f + g * g - sqrTerm * sqrTerm) / (2.0f * g);
+	}
+	cosTheta = clamp(cosTheta, -1.0f, 1.0f);
+	float sinTheta = sqrt(max(0.0f, 1.0f - cosTheta * cosTheta));
+	float phi = C_TWO_TIMES_PI * randSample.y;
+
+	float3 u,v;
+	TANGENT_VECTORS(rayDir, u, v);
+
+	*pdf = mediumPhaseEval(g, cosTheta);
+	return normalize(u * sinTheta * cos(phi) + v * sinTheta * sin(phi) + rayDir * cosTheta);
+}
+
+// Check whether a medium defines a density or an emission grid. The
+// interactions with heterogeneous media are sampled using delta tracking.
+bool mediumIsHeterogeneous(__global MaterialNode *medium){
+	return medium->densityGrid >= 0 || medium->emissionGrid >= 0;
+}
+
+// Get the max extinction coefficient of a medium that is used as the majorant
+// for delta and ratio tracking.
+float mediumGetMajorant(__global MaterialNode *medium, __global VolumeGrid *volumeGrids){
+	float3 sigmaT = medium->absorption + medium->scattering;
+	float majorant = max(sigmaT.x, max(sigmaT.y, sigmaT.z));
+	return medium->densityGrid >= 0 ? majorant * volumeGrids[medium->densityGrid].scale.w : majorant;
+}
+
+// Get the part of the ray segment [0, tMax] that needs to be tracked. Media
+// without a density grid are tracked along the entire segment. Returns false
+// if the segment does not overlap any of the medium grids.
+bool mediumGetTrackingRange(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, float3 rayOrigin, float3 rayDir, float tMax, bool withEmission, float2 *tRange){
+	*tRange = (float2)(0.0f, tMax);
+	if( medium->densityGrid < 0 ){
+		return true;
+	}
+
+	bool overlaps = volumeGridClip(volumeGrids + medium->densityGrid, rayOrigin, rayDir, tMax, tRange);
+	float2 emissionRange;
+	if( withEmission && medium->emissionGrid >= 0 && volumeGridClip(volumeGrids + medium->emissionGrid, rayOrigin, rayDir, tMax, &emissionRange) ){
+		*tRange = overlaps ? (float2)(min(tRange->x, emissionRange.x), max(tRange->y, emissionRange.y)) : emissionRange;
+		overlaps = true;
+	}
+	return overlaps;
+}
+
+// Sample a collision along the ray segment [0, tMax] using delta tracking.
+// Each tentative collision is classified as a real collision with probability
+// equal to the average extinction over the majorant and the weight is updated
+// so that the estimator remains unbiased for all channels. If scatter is set,
+// a scattering event occurs at the returned distance and the weight includes
+// the scattering albedo. The tracking samples are drawn from the random number
+// generator as their count is not fixed.
+//
+// The light emitted by the medium is collected at each tentative collision
+// and returned in emitted. The emission grid values (scaled by the grid scale)
+// define the emitted radiance per unit length.
+float mediumGetTrackedDistanceSample(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, __global float *volumeData, float3 rayOrigin, float3 rayDir, float tMax, uint2 *rndState, float3 *weight, float3 *emitted, bool *scatter){
+	*weight = (float3)(1.0f, 1.0f, 1.0f);
+	*emitted = (float3)(0.0f, 0.0f, 0.0f);
+	*scatter = false;
+
+	float majorant = mediumGetMajorant(medium, volumeGrids);
+	float2 tRange;
+	if( majorant <= 0.0f || !mediumGetTrackingRange(medium, volumeGrids, rayOrigin, rayDir, tMax, true, &tRange) ){
+		return tMax;
+	}
+
+	__global VolumeGrid *densityGrid = medium->densityGrid >= 0 ? volumeGrids + medium->densityGrid : 0;
+	__global VolumeGrid *emissionGrid = medium->emissionGrid >= 0 ? volumeGrids + medium->emissionGrid : 0;
+	float3 sigmaS = medium->scattering;
+	float3 sigmaT = medium->absorption + sigmaS;
+
+	float t = tRange.x;
+	for(int step = 0; step < MEDIUM_MAX_TRACKING_STEPS; step++){
+		float2 randSample = randomGetSample2f(rndState);
+		t -= log(1.0f - randSample.x) / majorant;
+		if( t >= tRange.y ){
+			break;
+		}
+
+		float3 point = rayOrigin + rayDir * t;
+		if( emissionGrid ){
+			*emitted += *weight * emissionGrid->scale.xyz * (volumeGridGetValue(emissionGrid, volumeData, point) / majorant);
+		}
+
+		float density = densityGrid ? volumeGridGetValue(densityGrid, volumeData, point) : 1.0f;
+		float3 localSigmaT = sigmaT * density;
+		float avgSigmaT = (localSigmaT.x + localSigmaT.y + localSigmaT.z) / 3.0f;
+		if( randSample.y * majorant < avgSigmaT ){
+			*weight *= sigmaS * (density / avgSigmaT);
+			*scatter = true;
+			return t;
+		}
+
+		float3 sigmaN = majorant - localSigmaT;
+		*weight *= sigmaN * (3.0f / (sigmaN.x + sigmaN.y + sigmaN.z));
+	}
+
+	return tMax;
+}
+
+// Estimate the transmittance along a ray segment of the given length. The
+// transmittance of media with a density grid is estimated using ratio
+// tracking.
+float3 mediumGetShadowTransmittance(__global MaterialNode *medium, __global VolumeGrid *volumeGrids, __global float *volumeData, float3 rayOrigin, float3 rayDir, float dist, uint2 *rndState){
+	if( medium->densityGrid < 0 ){
+		return mediumGetTransmittance(medium, dist);
+	}
+
+	float3 tr = (float3)(1.0f, 1.0f, 1.0f);
+	float majorant = mediumGetMajorant(medium, volumeGrids);
+	float2 tRange;
+	if( majorant <= 0.0f || !mediumGetTrackingRange(medium, volumeGrids, rayOrigin, rayDir, dist, false, &tRange) ){
+		return tr;
+	}
+
+	__global VolumeGrid *densityGrid = volumeGrids + medium->densityGrid;
+	float3 sigmaT = medium->absorption + medium->scattering;
+	float t = tRange.x;
+	for(int step = 0; step < MEDIUM_MAX_TRACKING_STEPS; step++){
+		t -= log(1.0f - randomGetSample2f(rndState).x) / majorant;
+		if( t >= tRange.y ){
+			break;
+		}
+
+		tr *= 1.0f - sigmaT * (volumeGridGetValue(densityGrid, volumeData, rayOrigin + rayDir * t) / majorant);
+		if( max(tr.x, max(tr.y, tr.z)) <= 0.0f ){
+			return (float3)(0.0f, 0.0f, 0.0f);
+		}
+	}
+
+	return tr;
+}
+
+// Get the trilinearly interpolated grid value at a world space point. Voxel
+// centers are located at integer grid coordinates and points outside the
+// grid evaluate to zero.
+float volumeGridGetValue(__global VolumeGrid *grid, __global float *volumeData, float3 point){
+	float3 p = mul4x1(point, grid->transformMat0, grid->transformMat1, grid->transformMat2, grid->transformMat3);
+	float3 base = floor(p);
+	float3 f = p - base;
+	int3 c = convert_int3_sat(base);
+
+	float v00 = mix(volumeGridGetVoxel(grid, volumeData, c), volumeGridGetVoxel(grid, volumeData, c + (int3)(1, 0, 0)), f.x);
+	float v10 = mix(volumeGridGetVoxel(grid, volumeData, c + (int3)(0, 1, 0)), volumeGridGetVoxel(grid, volumeData, c + (int3)(1, 1, 0)), f.x);
+	float v01 = mix(volumeGridGetVoxel(grid, volumeData, c + (int3)(0, 0, 1)), volumeGridGetVoxel(grid, volumeData, c + (int3)(1, 0, 1)), f.x);
+	float v11 = mix(volumeGridGetVoxel(grid, volumeData, c + (int3)(0, 1, 1)), volumeGridGetVoxel(grid, volumeData, c + (int3)(1, 1, 1)), f.x);
+	return mix(mix(v00, v10, f.y), mix(v01, v11, f.y), f.z);
+}
+
+// Get the value of a voxel or zero if the coordinates are out of range.
+float volumeGridGetVoxel(__global VolumeGrid *grid, __global float *volumeData, int3 coord){
+	int3 dims = convert_int3(grid->dims.xyz);
+	if( any(coord < 0) || any(coord >= dims) ){
+		return 0.0f;
+	}
+	return volumeData[grid->dims.w + (coord.z * dims.y + coord.y) * dims.x + coord.x];
+}
+
+// Clip the ray segment [0, tMax] against the region where the grid values may
+// be non-zero. As the grid transformation is affine, distances along the ray
+// are the same in world and grid space.
+bool volumeGridClip(__global VolumeGrid *grid, float3 rayOrigin, float3 rayDir, float tMax, float2 *tRange){
+	float3 o = mul4x1(rayOrigin, grid->transformMat0, grid->transformMat1, grid->transformMat2, grid->transformMat3);
+	float3 d = mul3x1(rayDir, grid->transformMat0.xyz, grid->transformMat1.xyz, grid->transformMat2.xyz);
+	float3 hi = convert_float3(grid->dims.xyz);
+
+	*tRange = (float2)(0.0f, tMax);
+	return volumeGridClipAxis(o.x, d.x, hi.x, tRange) &&
+		volumeGridClipAxis(o.y, d.y, hi.y, tRange) &&
+		volumeGridClipAxis(o.z, d.z, hi.z, tRange);
+}
+
+// Clip a ray range against the [-1, hi] slab of a grid axis.
+bool volumeGridClipAxis(float origin, float dir, float hi, float2 *tRange){
+	if( dir == 0.0f ){
+		return origin > -1.0f && origin < hi;
+	}
+
+	float ta = (-1.0f - origin) / dir;
+	float tb = (hi - origin) / dir;
+	*tRange = (float2)(max(tRange->x, min(ta, tb)), min(tRange->y, max(ta, tb)));
+	return tRange->x < tRange->y;
+}
+
+#endif
@@ -0,0 +1,213 @@
+#ifndef PATH_SAMPLER_CL
+#define PATH_SAMPLER_CL
+
+// The path samplers generate the 2D samples consumed by the kernels that
+// trace camera paths. Each sample is drawn from a 2D dimension; dimensions
+// are assigned to path events using the layout below so that the samples of
+// each event follow the same low-discrepancy sequence across the samples of
+// a pixel:
+//
+// - camera rays use dimension 0 for the pixel offset and 1 for the lens.
+// - each bounce b uses SAMPLER_BOUNCE_DIMENSIONS dimensions starting at
+//   SAMPLER_CAMERA_DIMENSIONS + b * SAMPLER_BOUNCE_DIMENSIONS. The first
+//   one samples the medium free-flight distance and the remaining ones are
+//   consumed by the shading kernels.
+//
+// Samples drawn past the dimensions reserved for an event fall back to the
+// random number generator.
+#define SAMPLER_CAMERA_DIMENSIONS 2
+#define SAMPLER_BOUNCE_DIMENSIONS 8
+#define SAMPLER_BOUNCE_DIMENSION(bounce) (SAMPLER_CAMERA_DIMENSIONS + (bounce) * SAMPLER_BOUNCE_DIMENSIONS)
+
+// The blue-noise sampler uses the blue-noise mask for the camera and first
+// bounce dimensions and the Sobol sampler for the remaining dimensions.
+#define SAMPLER_BLUE_NOISE_DIMENSIONS SAMPLER_BOUNCE_DIMENSION(1)
+
+// The generator vector of the R2 sequence in 0.32 fixed-point format. It is
+// used by the blue-noise sampler for rotating the mask values between the
+// samples of each pixel.
+#define SAMPLER_R2_ALPHA (uint2)(0xc13fa9a9u, 0x91e10da6u)
+
+// The number of prime bases available to the Halton sampler. Each 2D
+// dimension uses a pair of bases.
+#define SAMPLER_HALTON_BASES 64
+
+// The largest float below 1
+#define SAMPLER_ONE_MINUS_EPSILON 0x1.fffffep-1f
+
+__constant uint samplerHaltonBases[SAMPLER_HALTON_BASES] = {
+	2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53,
+	59, 61, 67, 71, 73, 79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131,
+	137, 139, 149, 151, 157, 163, 167, 173, 179, 181, 191, 193, 197, 199, 211, 223,
+	227, 229, 233, 239, 241, 251, 257, 263, 269, 271, 277, 281, 283, 293, 307, 311
+};
+
+typedef struct {
+	// The random number generator state
+	uint2 rndState;
+
+	// The sampler type, the index of the traced pixel sample, the seed of
+	// the traced frame and the seed for decorrelating the samples of each
+	// pixel
+	uint type;
+	uint index;
+	uint seed;
+	uint pixelSeed;
+
+	// The blue-noise mask and the frame coordinates of the sampled pixel
+	__global float2 *blueNoise;
+	uint2 pixel;
+
+	// The next dimension to be sampled and the end of the dimension range
+	uint dimension;
+	uint endDimension;
+} Sampler;
+
+void samplerInit(Sampler *sampler, uint type, uint index, uint seed, uint pixelIndex, uint frameW, __global float2 *blueNoise, uint dimension, uint numDimensions, uint2 rndState);
+void samplerInitRandom(Sampler *sampler, uint2 rndState);
+float2 samplerGetSample2f(Sampler *sampler);
+uint samplerHash(uint x);
+uint samplerReverseBits(uint x);
+uint samplerNestedUniformScramble(uint x, uint seed);
+uint samplerSobol2(uint index);
+float samplerToFloat(uint x);
+float samplerRadicalInverse(uint base, uint index);
+
+// Initialize a sampler for drawing samples from numDimensions dimensions
+// starting at the given dimension. The random number generator state is used
+// by the random sampler and for dimensions outside the range. The frame width
+// and the blue-noise mask are only used by the blue-noise sampler.
+void samplerInit(Sampler *sampler, uint type, uint index, uint seed, uint pixelIndex, uint frameW, __global float2 *blueNoise, uint dimension, uint numDimensions, uint2 rndState){
+	sampler->rndState = rndState;
+	sampler->type = type;
+	sampler->index = index;
+	sampler->seed = seed;
+	sampler->pixelSeed = samplerHash(seed ^ samplerHash(pixelIndex));
+	sampler->blueNoise = blueNoise;
+	sampler->pixel = type == SAMPLER_BLUE_NOISE ? (uint2)(pixelIndex % frameW, pixelIndex / frameW) : (uint2)(0, 0);
+	sampler->dimension = dimension;
+	sampler->endDimension = dimension + numDimensions;
+}
+
+// Initialize a sampler that draws all samples from the random number
+// generator. It is used by the kernels that trace light paths.
+void samplerInitRandom(Sampler *sampler, uint2 rndState){
+	samplerInit(sampler, SAMPLER_RANDOM, 0, 0, 0, 0, 0, 0, 0, rndState);
+}
+
+// Draw a 2D sample in the [0, 1) range from the next sampler dimension.
+//
+// The Sobol sampler shuffles the sample index using a per-pixel and
+// per-dimension Owen scramble and uses the first two dimensions of an
+// Owen-scrambled Sobol sequence for each 2D dimension. The shuffled index
+// decorrelates the dimensions while the samples of each dimension remain
+// stratified as long as the pixel sample count is a power of two.
+//
+// The Halton sampler uses a pair of prime bases for each 2D dimension and
+// applies a per-pixel and per-dimension toroidal shift to the samples.
+//
+// The blue-noise sampler looks up the blue-noise mask using the pixel
+// coordinates and a per-dimension toroidal offset so the samples of each
+// dimension are distributed as blue noise across the screen. The mask values
+// are rotated by the R2 sequence for each pixel sample so that the samples of
+// each pixel remain well distributed.
+float2 samplerGetSample2f(Sampler *sampler){
+	uint dimension = sampler->dimension++;
+	if( sampler->type == SAMPLER_RANDOM || dimension >= sampler->endDimension ){
+		return randomGetSample2f(&sampler->rndState);
+	}
+
+	if( sampler->type == SAMPLER_BLUE_NOISE && dimension < SAMPLER_BLUE_NOISE_DIMENSIONS ){
+		uint offset = samplerHash(sampler->seed ^ samplerHash(dimension));
+		uint2 texel = (sampler->pixel + (uint2)(offset, offset >> 16)) & (BLUE_NOISE_SIZE - 1);
+		uint2 rotation = sampler->index * SAMPLER_R2_ALPHA;
+		float2 sample = sampler->blueNoise[texel.y * BLUE_NOISE_SIZE + texel.x] + (float2)(samplerToFloat(rotation.x), samplerToFloat(rotation.y));
+		return fmin(sample - floor(sample), SAMPLER_ONE_MINUS_EPSILON);
+	}
+
+	uint seed = samplerHash(sampler->pixelSeed ^ samplerHash(dimension));
+	if( sampler->type == SAMPLER_SOBOL || sampler->type == SAMPLER_BLUE_NOISE ){
+		uint index = samplerNestedUniformScramble(sampler->index, seed);
+		return (float2)(
+			samplerToFloat(samplerNestedUniformScramble(samplerReverseBits(index), samplerHash(seed ^ 0xa511e9b3u))),
+			samplerToFloat(samplerNestedUniformScramble(samplerSobol2(index), samplerHash(seed ^ 0x63d83595u)))
+		);
+	}
+
+	if( 2 * dimension + 1 >= SAMPLER_HALTON_BASES ){
+		return randomGetSample2f(&sampler->rndState);
+	}
+
+	float2 shift = (float2)(samplerToFloat(samplerHash(seed ^ 0xa511e9b3u)), samplerToFloat(samplerHash(seed ^ 0x63d83595u)));
+	float2 sample = (float2)(
+		samplerRadicalInverse(samplerHaltonBases[2 * dimension], sampler->index),
+		samplerRadicalInverse(samplerHaltonBases[2 * dimension + 1], sampler->index)
+	) + shift;
+	return fmin(sample - floor(sample), SAMPLER_ONE_MINUS_EPSILON);
+}
+
+// An integer hash with good avalanche properties (lowbias32).
+uint samplerHash(uint x){
+	x ^= x >> 16;
+	x *= 0x7feb352du;
+	x ^= x >> 15;
+	x *= 0x846ca68bu;
+	x ^= x >> 16;
+	return x;
+}
+
+// Reverse the bits of a 32-bit value. It generates the first dimension of the
+// Sobol sequence (the base-2 van der Corput sequence).
+uint samplerReverseBits(uint x){
+	x = ((x >> 1) & 0x55555555u) | ((x & 0x55555555u) << 1);
+	x = ((x >> 2) & 0x33333333u) | ((x & 0x33333333u) << 2);
+	x = ((x >> 4) & 0x0f0f0f0fu) | ((x & 0x0f0f0f0fu) << 4);
+	x = ((x >> 8) & 0x00ff00ffu) | ((x & 0x00ff00ffu) << 8);
+	return (x >> 16) | (x << 16);
+}
+
+// Apply a hash-based Owen scramble to the bits of a value in the [0, 1)
+// range encoded as a 32-bit fixed-point number. Bit reversing the value turns
+// the Laine-Karras permutation into a nested uniform scramble.
+uint samplerNestedUniformScramble(uint x, uint seed){
+	x = samplerReverseBits(x);
+	x += seed;
+	x ^= x * 0x6c50b47cu;
+	x ^= x * 0xb82f1e52u;
+	x ^= x * 0xc7afe638u;
+	x ^= x * 0x8d22f6e6u;
+	return samplerReverseBits(x);
+}
+
+// Generate the second dimension of the Sobol sequence. Its direction numbers
+// are generated by the primitive polynomial x + 1.
+uint samplerSobol2(uint index){
+	uint value = 0;
+	for(uint direction = 0x80000000u; index != 0; index >>= 1, direction ^= direction >> 1){
+		if( (index & 1) != 0 ){
+			value ^= direction;
+		}
+	}
+	return value;
+}
+
+// Convert a 32-bit fixed-point value to a float in the [0, 1) range.
+float samplerToFloat(uint x){
+	return (float)(x >> 8) * (1.0f / 16777216.0f);
+}
+
+// Mirror the digits of the index in the given base around the decimal point.
+float samplerRadicalInverse(uint base, uint index){
+	float invBase = 1.0f / (float)base;
+	float invBaseN = 1.0f;
+	ulong reversed = 0;
+	while( index != 0 ){
+		uint next = index / base;
+		reversed = reversed * base + (index - next * base);
+		invBaseN *= invBase;
+		index = next;
+	}
+	return fmin((float)reversed * invBaseN, SAMPLER_ONE_MINUS_EPSILON);
+}
+
+#endif
@@ -2,9 +2,13 @@
 #define SAMPLERS_CL
 
 #include "random_sampler.cl"
+#include "path_sampler.cl"
 #include "texture_sampler.cl"
 #include "material_sampler.cl"
 #include "distribution_sampler.cl"
+#include "envmap_sampler.cl"
 #include "emissive_sampler.cl"
+#include "equiangular_sampler.cl"
+#include "medium_sampler.cl"
 
 #endif
@@ -5,18 +5,130 @@
 #define TEX_FMT_LUMINANCE32F 1
 #define TEX_FMT_RGBA8 2
 #define TEX_FMT_RGBA32F 3
-
-float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
-float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
+#define TEX_FMT_SLUMINANCE8 4
+#define TEX_FMT_SRGBA8 5
+
+uint texGetTexelSize(uint format);
+float texSrgbToLinear(float v);
+float4 texSrgbaToLinear(float4 v);
+float texSelectMipLevel(float lod, int texIndex, __global TextureMetadata *metadata);
+uint texGetMipLevelOffset(uint level, int texIndex, __global TextureMetadata *metadata, uint2 *texDims);
+float3 texGetSample3f(float2 uv, float lod, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
+float texGetSample1f(float2 uv, float lod, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
+float3 _texGetBilinearSample3f(float2 uv, uint format, __global uchar* basePtr, uint2 texDims);
+float _texGetBilinearSample1f(float2 uv, uint format, __global uchar* basePtr, uint2 texDims);
 float3 texGetBumpSample3f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
+float texGetOpacitySample1f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data);
 
-// Sample texture at given uv coordinates returning back a float3 vector
-float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
-	uint2 texDims = (uint2)(
+// Get the size in bytes of a texel for the given texture format
+uint texGetTexelSize(uint format) {
+	switch(format){
+		case TEX_FMT_LUMINANCE8:
+		case TEX_FMT_SLUMINANCE8:
+			return 1;
+		case TEX_FMT_RGBA32F:
+			return 16;
+	}
+
+	return 4;
+}
+
+// Convert a sRGB-encoded value in the [0, 1] range to a linear value
+float texSrgbToLinear(float v) {
+	return v <= 0.04045f ? v * (1.0f / 12.92f) : pow((v + 0.055f) * (1.0f / 1.055f), 2.4f);
+}
+
+// Convert the color channels of a sRGB-encoded texel in the [0, 1] range to 
+// linear values. The alpha channel is always linear.
+float4 texSrgbaToLinear(float4 v) {
+	return (float4)(texSrgbToLinear(v.x), texSrgbToLinear(v.y), texSrgbToLinear(v.z), v.w);
+}
+
+// Select the mip level for sampling a texture. The lod argument is the log2
+// of the ray footprint in uv space (see surfaceSetTextureLod); it is converted
+// into a texel footprint using the texture dimensions. The returned level is
+// fractional so that lookups can blend the two nearest levels.
+float texSelectMipLevel(float lod, int texIndex, __global TextureMetadata *metadata) {
+	float width = (float)metadata[texIndex].width;
+	float height = (float)metadata[texIndex].height;
+	uint maxLevel = max(metadata[texIndex].mipLevels, (uint)1) - 1;
+
+	// fmin/fmax also map NaN footprints (e.g. for triangles without uv 
+	// coords) to the top level
+	return fmin(fmax(lod + 0.5f * log2(width * height), 0.0f), (float)maxLevel);
+}
+
+// Get the offset to the data of a mip level. The dimensions of the level are
+// stored into texDims.
+uint texGetMipLevelOffset(uint level, int texIndex, __global TextureMetadata *metadata, uint2 *texDims) {
+	uint2 dims = (uint2)(
 			metadata[texIndex].width,
 			metadata[texIndex].height
 	);
+	uint dataOffset = metadata[texIndex].dataOffset;
+
+	// Mip levels are stored after each other; each level is aligned on 
+	// a dword boundary.
+	uint texelSize = texGetTexelSize(metadata[texIndex].format);
+	for(; level > 0; level--){
+		dataOffset += (dims.x * dims.y * texelSize + 3) & ~3u;
+		dims = max(dims >> 1, (uint2)(1, 1));
+	}
+
+	*texDims = dims;
+	return dataOffset;
+}
+
+// Sample texture at given uv coordinates returning back a float3 vector. The
+// lod argument selects the mip level to be sampled; fractional levels are
+// sampled by blending the two nearest levels (trilinear filtering).
+float3 texGetSample3f(float2 uv, float lod, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
+	uint format = metadata[texIndex].format;
+	float level = texSelectMipLevel(lod, texIndex, metadata);
+	uint level0 = (uint)level;
+
+	uint2 texDims;
+	uint dataOffset = texGetMipLevelOffset(level0, texIndex, metadata, &texDims);
+	float3 sample = _texGetBilinearSample3f(uv, format, data + dataOffset, texDims);
+
+	// The level is clamped to the last level so a non-zero blend weight
+	// implies that the next level exists
+	float blend = level - (float)level0;
+	if( blend > 0.0f ){
+		dataOffset += (texDims.x * texDims.y * texGetTexelSize(format) + 3) & ~3u;
+		texDims = max(texDims >> 1, (uint2)(1, 1));
+		sample = mix(sample, _texGetBilinearSample3f(uv, format, data + dataOffset, texDims), blend);
+	}
+
+	return sample;
+}
+
+// Sample texture at given uv coordinates returning back a float. For multi-channel
+// textures we only read from the red channel. The lod argument selects the mip
+// level to be sampled; fractional levels are sampled by blending the two 
+// nearest levels (trilinear filtering).
+float texGetSample1f(float2 uv, float lod, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
+	uint format = metadata[texIndex].format;
+	float level = texSelectMipLevel(lod, texIndex, metadata);
+	uint level0 = (uint)level;
+
+	uint2 texDims;
+	uint dataOffset = texGetMipLevelOffset(level0, texIndex, metadata, &texDims);
+	float sample = _texGetBilinearSample1f(uv, format, data + dataOffset, texDims);
+
+	float blend = level - (float)level0;
+	if( blend > 0.0f ){
+		dataOffset += (texDims.x * texDims.y * texGetTexelSize(format) + 3) & ~3u;
+		texDims = max(texDims >> 1, (uint2)(1, 1));
+		sample = mix(sample, _texGetBilinearSample1f(uv, format, data + dataOffset, texDims), blend);
+	}
+
+	return sample;
+}
 
+// Sample a mip level at the given uv coordinates using bilinear filtering and
+// return back a float3 vector.
+float3 _texGetBilinearSample3f(float2 uv, uint format, __global uchar* basePtr, uint2 texDims) {
 	// Handle repeating textures by keeping the fractional part of uv and
 	// scale to [0, texDims) range
 	float2 scaledUV = uv - floor(uv);
@@ -33,9 +145,7 @@ float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadat
 	float coeffX = scaledUV.x - (float)tx;
 	float coeffY = scaledUV.y - (float)ty;
 
-	__global uchar* basePtr = data + metadata[texIndex].dataOffset;
-
-	switch(metadata[texIndex].format){
+	switch(format){
 		case TEX_FMT_RGBA8:
 		{
 			const __global uchar4* vecPtr = (__global const uchar4*)basePtr;
@@ -51,6 +161,22 @@ float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadat
 					coeffX
 			).xyz / 255.0f;
 		}
+		case TEX_FMT_SRGBA8:
+		{
+			// Texels are converted to linear values before filtering them
+			const __global uchar4* vecPtr = (__global const uchar4*)basePtr;
+
+			float4 rgbTL = texSrgbaToLinear(convert_float4(vecPtr[(ty * texDims.x) + tx]) / 255.0f);
+			float4 rgbTR = texSrgbaToLinear(convert_float4(vecPtr[(ty * texDims.x) + bx]) / 255.0f);
+			float4 rgbBL = texSrgbaToLinear(convert_float4(vecPtr[(by * texDims.x) + tx]) / 255.0f);
+			float4 rgbBR = texSrgbaToLinear(convert_float4(vecPtr[(by * texDims.x) + bx]) / 255.0f);
+
+			return mix(
+					mix(rgbTL, rgbBL, coeffY),
+					mix(rgbTR, rgbBR, coeffY),
+					coeffX
+			).xyz;
+		}
 		case TEX_FMT_RGBA32F:
 		{
 			const __global float4* vecPtr = (__global const float4*)basePtr;
@@ -80,6 +206,20 @@ float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadat
 			
 			return (float3)(r,r,r);
 		}
+		case TEX_FMT_SLUMINANCE8:
+		{
+			float rTL = texSrgbToLinear((float)basePtr[(ty * texDims.x) + tx] / 255.0f);
+			float rTR = texSrgbToLinear((float)basePtr[(ty * texDims.x) + bx] / 255.0f);
+			float rBL = texSrgbToLinear((float)basePtr[(by * texDims.x) + tx] / 255.0f);
+			float rBR = texSrgbToLinear((float)basePtr[(by * texDims.x) + bx] / 255.0f);
+			float r = mix(
+					mix(rTL, rBL, coeffY),
+					mix(rTR, rBR, coeffY),
+					coeffX
+			);
+			
+			return (float3)(r,r,r);
+		}
 		case TEX_FMT_LUMINANCE32F:
 		{
 			const __global float* floatPtr = (__global const float*)basePtr;
@@ -100,14 +240,11 @@ float3 texGetSample3f(float2 uv, int texIndex, __global TextureMetadata *metadat
 	return (float3)(0.0f, 0.0f, 0.0f);
 }
 
-// Sample texture at given uv coordinates returning back a float. For multi-channel
-// textures we only read from the red channel.
-float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
-	uint2 texDims = (uint2)(
-			metadata[texIndex].width,
-			metadata[texIndex].height
-	);
 
+// Sample a mip level at the given uv coordinates using bilinear filtering and
+// return back a float. For multi-channel textures we only read from the red 
+// channel.
+float _texGetBilinearSample1f(float2 uv, uint format, __global uchar* basePtr, uint2 texDims) {
 	// Handle repeating textures by keeping the fractional part of uv and
 	// scale to [0, texDims) range
 	float2 scaledUV = uv - floor(uv);
@@ -124,9 +261,7 @@ float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata
 	float coeffX = scaledUV.x - (float)tx;
 	float coeffY = scaledUV.y - (float)ty;
 
-	__global uchar* basePtr = data + metadata[texIndex].dataOffset;
-
-	switch(metadata[texIndex].format){
+	switch(format){
 		case TEX_FMT_RGBA8:
 		{
 			float rTL = (float)basePtr[(ty * texDims.x << 2) + (tx << 2)];
@@ -139,6 +274,18 @@ float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata
 					coeffX
 			) / 255.0f;
 		}
+		case TEX_FMT_SRGBA8:
+		{
+			float rTL = texSrgbToLinear((float)basePtr[(ty * texDims.x << 2) + (tx << 2)] / 255.0f);
+			float rTR = texSrgbToLinear((float)basePtr[(ty * texDims.x << 2) + (bx << 2)] / 255.0f);
+			float rBL = texSrgbToLinear((float)basePtr[(by * texDims.x << 2) + (tx << 2)] / 255.0f);
+			float rBR = texSrgbToLinear((float)basePtr[(by * texDims.x << 2) + (bx << 2)] / 255.0f);
+			return mix(
+					mix(rTL, rBL, coeffY),
+					mix(rTR, rBR, coeffY),
+					coeffX
+			);
+		}
 		case TEX_FMT_RGBA32F:
 		{
 			const __global float* floatPtr = (__global const float*)basePtr;
@@ -165,6 +312,18 @@ float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata
 					coeffX
 			) / 255.0f;
 		}
+		case TEX_FMT_SLUMINANCE8:
+		{
+			float rTL = texSrgbToLinear((float)basePtr[(ty * texDims.x) + tx] / 255.0f);
+			float rTR = texSrgbToLinear((float)basePtr[(ty * texDims.x) + bx] / 255.0f);
+			float rBL = texSrgbToLinear((float)basePtr[(by * texDims.x) + tx] / 255.0f);
+			float rBR = texSrgbToLinear((float)basePtr[(by * texDims.x) + bx] / 255.0f);
+			return mix(
+					mix(rTL, rBL, coeffY),
+					mix(rTR, rBR, coeffY),
+					coeffX
+			);
+		}
 		case TEX_FMT_LUMINANCE32F:
 		{
 			const __global float* floatPtr = (__global const float*)basePtr;
@@ -183,7 +342,9 @@ float texGetSample1f(float2 uv, int texIndex, __global TextureMetadata *metadata
 	return 0.0f;
 }
 
-// Sample bump map texture at given uv coordinates returning back a float3 vector
+// Sample bump map texture at given uv coordinates returning back a float3 vector.
+// Bump maps are always sampled at the top mip level as the lower levels smooth
+// out the height differences that encode the surface detail.
 float3 texGetBumpSample3f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
 	uint2 texDims = (uint2)(
 			metadata[texIndex].width,
@@ -218,6 +379,16 @@ float3 texGetBumpSample3f(float2 uv, int texIndex, __global TextureMetadata *met
 
 			return halfVec + 0.5f * normalize((float3)(s1 - s0, s2 - s0, 1.0f));
 		}
+		case TEX_FMT_SRGBA8:
+		{
+			const __global uchar4* vecPtr = (__global const uchar4*)basePtr;
+
+			float s0 = texSrgbToLinear((float)(vecPtr[(ty * texDims.x) + tx].x) / 255.0f);
+			float s1 = texSrgbToLinear((float)(vecPtr[(ty * texDims.x) + bx].x) / 255.0f);
+			float s2 = texSrgbToLinear((float)(vecPtr[(by * texDims.x) + tx].x) / 255.0f);
+
+			return halfVec + 0.5f * normalize((float3)(s1 - s0, s2 - s0, 1.0f));
+		}
 		case TEX_FMT_RGBA32F:
 		{
 			const __global float4* vecPtr = (__global const float4*)basePtr;
@@ -236,6 +407,14 @@ float3 texGetBumpSample3f(float2 uv, int texIndex, __global TextureMetadata *met
 
 			return halfVec + 0.5f * normalize((float3)(s1 - s0, s2 - s0, 1.0f));
 		}
+		case TEX_FMT_SLUMINANCE8:
+		{
+			float s0 = texSrgbToLinear((float)(basePtr[(ty * texDims.x) + tx]) / 255.0f);
+			float s1 = texSrgbToLinear((float)(basePtr[(ty * texDims.x) + bx]) / 255.0f);
+			float s2 = texSrgbToLinear((float)(basePtr[(by * texDims.x) + tx]) / 255.0f);
+
+			return halfVec + 0.5f * normalize((float3)(s1 - s0, s2 - s0, 1.0f));
+		}
 		case TEX_FMT_LUMINANCE32F:
 		{
 			const __global float* floatPtr = (__global const float*)basePtr;
@@ -250,4 +429,27 @@ float3 texGetBumpSample3f(float2 uv, int texIndex, __global TextureMetadata *met
 
 	return (float3)(0.0f, 0.0f, 0.0f);
 }
+
+// Sample the opacity of a texture at the given uv coordinates. The alpha
+// channel is used for RGBA textures and the texel value for luminance
+// textures. Opacity is always sampled from the top mip level as the
+// intersection kernels do not track ray footprints.
+float texGetOpacitySample1f(float2 uv, int texIndex, __global TextureMetadata *metadata, __global uchar* data) {
+	uint format = metadata[texIndex].format;
+	uint2 texDims;
+	uint dataOffset = texGetMipLevelOffset(0, texIndex, metadata, &texDims);
+
+	// Offset the data pointer so that the single-channel sampler reads
+	// the alpha channel. The alpha channel is always linear.
+	switch(format){
+		case TEX_FMT_RGBA8:
+		case TEX_FMT_SRGBA8:
+			return _texGetBilinearSample1f(uv, TEX_FMT_RGBA8, data + dataOffset + 3, texDims);
+		case TEX_FMT_RGBA32F:
+			return _texGetBilinearSample1f(uv, TEX_FMT_RGBA32F, data + dataOffset + 12, texDims);
+	}
+
+	return _texGetBilinearSample1f(uv, format, data + dataOffset, texDims);
+}
+
 #endif
@@ -1,6 +1,10 @@
 #ifndef TYPES_CL
 #define TYPES_CL
 
+// The version of the memory layout for structures shared with the host. This
+// must match the LayoutVersion constant defined in the scene package.
+#define LAYOUT_VERSION 6
+
 typedef struct {
 	// origin.w stores the max allowed distance for intersection queries.
 	float4 origin;
@@ -19,11 +23,37 @@ typedef struct {
 	// Path flags
 	uint flags;
 
-	// Padding; reseved for future use
-	uint _reserved1;
-	uint _reserved2;
+	// Ray cone used for selecting texture mip levels; the cone width at
+	// the ray origin and its spread angle in radians.
+	float coneWidth;
+	float coneSpread;
 } Path;
 
+// A light subpath vertex generated by the bidirectional integrator. Vertices
+// with zero throughput are not connected to eye subpaths.
+typedef struct {
+	float3 point;
+
+	// shading and geometric normals
+	float3 normal;
+	float3 geomNormal;
+
+	// direction towards the previous light subpath vertex
+	float3 inRayDir;
+
+	// the light carried to this vertex divided by the sampling pdfs
+	float3 throughput;
+
+	// texture uv coords
+	float2 uv;
+
+	// the selected leaf material node
+	uint matNodeIndex;
+
+	// bit i is set if the light subpath vertex at depth i is singular
+	uint singularMask;
+} LightVertex;
+
 typedef struct {
 	union {
 		float4 minExtent;
@@ -49,15 +79,48 @@ typedef struct {
 	};
 } BvhNode;
 
+// An 8-wide BVH node with child bounds quantized relative to a per-node grid.
+// The bounds of child i along each axis are:
+// [origin + qmin[axis][i] * 2^exponents[axis], origin + qmax[axis][i] * 2^exponents[axis]]
+typedef struct {
+	union {
+		float4 origin;
+
+		// The W coordinate contains the number of valid children
+		uint4 numChildren;
+	};
+
+	// Inner node children point to the child node index. Leaf children
+	// are <= 0 and point to the mesh instance index for top-level BVH
+	// leafs or to the first triangle index for bottom-level BVH leafs.
+	int children[8];
+
+	// The triangle count for bottom-level BVH leafs; 0 for other children.
+	uchar counts[8];
+
+	uchar qmin[3][8];
+	uchar qmax[3][8];
+	char exponents[3];
+	uchar _padding[5];
+} CompressedBvhNode;
+
+// Mesh instance flags
+#define MESH_FLAG_CAMERA_INVISIBLE 1 << 0
+#define MESH_FLAG_SHADOW_CATCHER 1 << 1
+#define MESH_FLAG_CATCHER_REFLECTIONS 1 << 2
+#define MESH_FLAG_ALPHA_CUTOUT 1 << 3
+
 typedef struct {
 	uint meshIndex;
 
 	// BVH root node index for mesh BVH
 	uint bvhRoot;
 
-	// padding
-	uint _reserved1;
-	uint _reserved2;
+	// offset for secondary ray origins; 0 selects INTERSECTION_EPSILON
+	float rayBias;
+
+	// instance flags
+	uint flags;
 
 	// inverted mesh transformation matrix for transforming rays to mesh space
 	float4 transformMat0;
@@ -86,14 +149,34 @@ typedef struct {
 	// intersection point
 	float3 point;
 
-	// normal at intersection point
+	// interpolated (shading) normal at intersection point
 	float3 normal;
 
+	// geometric triangle normal oriented towards the same side as the
+	// vertex normals
+	float3 geomNormal;
+
+	// interpolated vertex tangent (xyz) and bitangent handedness (w). A zero
+	// tangent indicates that the tangent frame must be derived from the
+	// shading normal
+	float4 tangent;
+
 	// texture uv coords at intersection point
 	float2 uv;
 
+	// log2 of the ray footprint in uv space; used for selecting texture
+	// mip levels
+	float texLod;
+
 	// material node index
 	uint matNodeIndex;
+
+	// Approximate mean curvature at intersection point (positive values
+	// for convex and negative values for concave surfaces) and the
+	// fraction of the hemisphere above it that is occluded by nearby
+	// geometry of the same mesh. Used by curvature/occlusion mix nodes.
+	float curvature;
+	float occlusion;
 } Surface;
 
 typedef struct {
@@ -105,8 +188,26 @@ typedef struct {
 
 	// start offset in texture data
 	uint dataOffset;
+
+	// number of mip levels stored after each other starting at dataOffset
+	uint mipLevels;
 } TextureMetadata;
 
+typedef struct {
+	// transformation matrix for transforming world space points to the
+	// grid coordinate space
+	float4 transformMat0;
+	float4 transformMat1;
+	float4 transformMat2;
+	float4 transformMat3;
+
+	// color that scales the voxel values (xyz) and the max voxel value (w)
+	float4 scale;
+
+	// grid dimensions in voxels (xyz) and start offset in volume data (w)
+	uint4 dims;
+} VolumeGrid;
+
 typedef struct {
 	// Node type
 	uint type;
@@ -117,6 +218,10 @@ typedef struct {
 		uint rightChild;
 
 		int transmittanceTex;
+		int metallicTex;
+
+		// Density volume grid for medium nodes
+		int densityGrid;
 	};
 
 	union {
@@ -124,9 +229,16 @@ typedef struct {
 		int bumpTex;
 		int mixWeightsTex;
 
+		// Texture for alpha cutout nodes
+		int opacityTex;
+
 		int reflectanceTex;
 		int specularityTex;
 		int radianceTex;
+		int baseColorTex;
+
+		// Emission volume grid for medium nodes
+		int emissionGrid;
 	};
 
 	union {
@@ -135,13 +247,35 @@ typedef struct {
 		float3 radiance;
 		float3 intDispersionIORs;
 
+		// principled bxdf base color (xyz) and transmission weight (w)
+		float4 principledBase;
+
 		// mix node
 		float mixWeight;
+
+		// curvature mix node
+		float curvatureScale;
+
+		// alpha cutout node
+		float alphaCutoff;
+
+		// medium node
+		float3 absorption;
 	};
 	
 	union {
 		float3 transmittance;
 		float3 extDispersionIORs;
+
+		// medium node
+		float3 scattering;
+
+		// subsurface bxdf mean free path (xyz) and phase function anisotropy (w);
+		// the compiler converts them into the coefficients of a medium node
+		float4 subsurfaceRadius;
+
+		// principled bxdf metallic, specular, sheen and clearcoat weights
+		float4 principledWeights;
 	};
 
 	union {
@@ -157,6 +291,12 @@ typedef struct {
 		float scale;
 
 		float roughness;
+
+		// Parallax preview scale for bump map nodes
+		float parallaxScale;
+
+		// Phase function anisotropy for medium nodes
+		float anisotropy;
 	};
 
 	union {
@@ -0,0 +1,41 @@
+#ifndef BDPT_CL
+#define BDPT_CL
+
+float bdptStrategyWeight(uint numEdges, uint singularMask, uint numBounces, uint numLightVertices);
+
+// Calculate the weight of a sample for a path with numEdges edges when the 
+// bidirectional integrator is enabled. The path x0...xk starts at the camera
+// (x0) and ends at an area light (xk); bit i of singularMask is set if 
+// vertex x(i+1) is singular.
+//
+// The integrator can generate the same path using the path tracing strategy
+// (light sampling or bxdf sampling towards the light) and by connecting eye
+// subpath vertex x(b+1) to light subpath vertex x(b+2). Connections are only
+// possible when both vertices are non-singular and the light subpath is long
+// enough to reach x(b+2). Each strategy receives the same weight (uniform 
+// weighting) so the weights of all strategies that can generate the path sum
+// to 1. If numLightVertices is 0, only the path tracing strategy is used.
+float bdptStrategyWeight(uint numEdges, uint singularMask, uint numBounces, uint numLightVertices){
+	if( numLightVertices == 0 || numEdges < 3 ){
+		return 1.0f;
+	}
+
+	// The path tracer samples the light from the last vertex if it is 
+	// non-singular; otherwise it has to scatter towards the light.
+	uint lastVertex = numEdges - 2;
+	bool lastSingular = lastVertex < PATH_MAX_SINGULAR_VERTICES && (singularMask & (1u << lastVertex)) != 0;
+	uint numStrategies = (lastSingular ? lastVertex + 1 : lastVertex) < numBounces ? 1 : 0;
+
+	// Connecting eye vertex x(b+1) to light vertex x(b+2) generates paths
+	// whose light subpath has numEdges - b - 3 scattering vertices.
+	for(uint b = 0; b < numBounces && b + 3 <= numEdges; b++){
+		uint l = numEdges - b - 3;
+		if( l < numLightVertices && (b + 1 >= PATH_MAX_SINGULAR_VERTICES || (singularMask & (3u << b)) == 0) ){
+			numStrategies++;
+		}
+	}
+
+	return numStrategies > 1 ? native_recip((float)numStrategies) : 1.0f;
+}
+
+#endif
@@ -0,0 +1,51 @@
+#ifndef LPE_CL
+#define LPE_CL
+
+// The light path events and the DFA limits are defined in abi.cl.
+
+// The packed DFA states of all expressions after the camera event. The state
+// of each expression is stored in a separate byte.
+#define LPE_INITIAL_STATES 0x01010101
+
+// Map a bxdf type to a scattering event. Subsurface materials scatter light
+// diffusely like the media below their surface.
+#define LPE_BXDF_EVENT(t) ((t) == BXDF_TYPE_DIFFUSE || (t) == BXDF_TYPE_SUBSURFACE ? LPE_EVENT_DIFFUSE : (BXDF_IS_SINGULAR(t) ? LPE_EVENT_SPECULAR : LPE_EVENT_GLOSSY))
+
+// Get the light group pass masks from the table built by the host. The table
+// stores the mask of the scene background followed by the mask of each
+// material node; nodes that do not emit light have an empty mask.
+#define LIGHT_GROUP_BACKGROUND_MASK(masks) ((masks)[0])
+#define LIGHT_GROUP_NODE_MASK(masks, nodeIndex) ((masks)[(nodeIndex) + 1])
+
+uint lpeStep(uint states, uint event, const uint numExpressions, __global uchar *transitions, uint *acceptMask);
+void lpeAccumulate(float3 sample, uint acceptMask, uint pixelIndex, const uint numPixels, __global float3 *accumulator);
+
+// Advance the DFA of each expression using the given event and return the 
+// packed next states. The bit for each expression that accepts the path 
+// after the transition is set in acceptMask.
+uint lpeStep(uint states, uint event, const uint numExpressions, __global uchar *transitions, uint *acceptMask){
+	uint nextStates = 0;
+	*acceptMask = 0;
+	for(uint expr = 0; expr < numExpressions; expr++){
+		uint state = (states >> (expr << 3)) & 0xff;
+		uint next = transitions[(expr * LPE_MAX_STATES + state) * LPE_NUM_EVENTS + event];
+		if( (next & LPE_ACCEPT_FLAG) != 0 ){
+			*acceptMask |= 1 << expr;
+		}
+		nextStates |= (next & ~LPE_ACCEPT_FLAG) << (expr << 3);
+	}
+	return nextStates;
+}
+
+// Add a sample to the accumulator of each pass in acceptMask. The pass
+// accumulators of the expressions are followed by the light group passes;
+// they are stored after each other and contain numPixels entries each.
+void lpeAccumulate(float3 sample, uint acceptMask, uint pixelIndex, const uint numPixels, __global float3 *accumulator){
+	for(uint expr = 0; acceptMask != 0; expr++, acceptMask >>= 1){
+		if( (acceptMask & 1) != 0 ){
+			accumulator[expr * numPixels + pixelIndex] += sample;
+		}
+	}
+}
+
+#endif
@@ -5,15 +5,26 @@
 #define PATH_FLAG_DISPERSE_G 1 << 1
 #define PATH_FLAG_DISPERSE_B 1 << 2
 
-void pathNew(__global Path *path, uint pixelIndex);
+// The upper path flag bits record which path vertices are singular (e.g. ideal
+// mirrors and dielectrics). Bit i of the mask is set for the vertex generated
+// at bounce i; vertices past PATH_MAX_SINGULAR_VERTICES are not tracked.
+#define PATH_SINGULAR_MASK_SHIFT 8
+#define PATH_MAX_SINGULAR_VERTICES 24
+
+void pathNew(__global Path *path, uint pixelIndex, float coneSpread);
 void pathMulThroughput(__global Path *path, float3 fragColor);
 void pathSetThroughput(__global Path *path, float3 throughput);
+void pathSetCone(__global Path *path, float coneWidth, float coneSpread);
+void pathSetSingularVertex(__global Path *path, uint bounce);
+uint pathGetSingularMask(__global Path *path);
 
-// Initialize path.
-inline void pathNew(__global Path *path, uint pixelIndex){
+// Initialize path. The path ray cone starts at the camera with zero width.
+inline void pathNew(__global Path *path, uint pixelIndex, float coneSpread){
 	path->throughput = (float3)(1.0f, 1.0f, 1.0f);
 	path->pixelIndex = pixelIndex;
 	path->flags = 0;
+	path->coneWidth = 0.0f;
+	path->coneSpread = coneSpread;
 }
 
 // Multiply a fragment color with the current path throughput.
@@ -26,4 +37,22 @@ void pathSetThroughput(__global Path *path, float3 throughput){
 	path->throughput = throughput;
 }
 
+// Set the ray cone for the next path segment.
+void pathSetCone(__global Path *path, float coneWidth, float coneSpread){
+	path->coneWidth = coneWidth;
+	path->coneSpread = coneSpread;
+}
+
+// Mark the path vertex generated at the given bounce as singular.
+void pathSetSingularVertex(__global Path *path, uint bounce){
+	if( bounce < PATH_MAX_SINGULAR_VERTICES ){
+		path->flags |= 1u << (bounce + PATH_SINGULAR_MASK_SHIFT);
+	}
+}
+
+// Get a mask where bit i is set if the vertex generated at bounce i is singular.
+uint pathGetSingularMask(__global Path *path){
+	return path->flags >> PATH_SINGULAR_MASK_SHIFT;
+}
+
 #endif
@@ -1,11 +1,24 @@
 #ifndef SURFACE_CL
 #define SURFACE_CL
 
+// The min cosine between a clamped shading normal and the incoming ray
+#define SHADING_NORMAL_CLAMP_EPSILON 0.01f
+
+// The min squared length of a projected tangent that can be used for
+// building a tangent frame
+#define SURFACE_MIN_TANGENT_LEN_SQ 1e-12f
+
 #define TANGENT_VECTORS(normal, u, v) \
 	u = normalize(cross((fabs(normal.z) < .999f ? (float3)(0.0f, 0.0f, 1.0f) : (float3)(1.0f, 0.0f, 0.0f)), normal)); \
 	v = cross(normal, u);
 
 void surfaceInit(Surface *surface, __global Intersection *intersection, __global float4 *vertices, __global float4 *normals, __global float2 *uv, __global uint *matIndices);
+void surfaceFixShadingNormal(Surface *surface, float3 inRayDir, const uint mode);
+void surfaceSetTextureLod(Surface *surface, __global Intersection *intersection, __global float4 *vertices, __global float2 *uv, float3 inRayDir, float coneWidth);
+void surfaceSetWearInputs(Surface *surface, __global Intersection *intersection, __global float4 *vertices, __global float4 *normals, __global float *vertexOcclusion);
+void surfaceSetTangent(Surface *surface, __global Intersection *intersection, __global float4 *tangents);
+void surfaceTangentFrame(float3 normal, float4 tangent, float3 *u, float3 *v);
+float surfaceEdgeCurvature(float3 p0, float3 n0, float3 p1, float3 n1);
 void printSurface(Surface *surface);
 
 // Initialize surface parameters
@@ -24,12 +37,147 @@ void surfaceInit(Surface *surface, __global Intersection *intersection, __global
 					   wuv.z * normals[offset+2]).xyz
 			);
 
+	// Orient the geometric normal using the vertex normals as the triangle
+	// winding order of imported meshes is not always consistent
+	surface->geomNormal = normalize(cross(
+				(vertices[offset+1] - vertices[offset]).xyz,
+				(vertices[offset+2] - vertices[offset]).xyz
+			));
+	if( dot(surface->geomNormal, (normals[offset] + normals[offset+1] + normals[offset+2]).xyz) < 0.0f ){
+		surface->geomNormal = -surface->geomNormal;
+	}
+
 	surface->uv = wuv.x * uv[offset] + 
 		          wuv.y * uv[offset+1] + 
 				  wuv.z * uv[offset+2];
 
+	// Sample the top texture mip level unless surfaceSetTextureLod is called
+	surface->texLod = TEX_LOD_TOP_MIP;
+
 	// Fetch material root node index
 	surface->matNodeIndex = matIndices[intersection->triIndex];
+
+	// Curvature and occlusion are only populated by surfaceSetWearInputs
+	surface->curvature = 0.0f;
+	surface->occlusion = 0.0f;
+
+	// The tangent is only populated by surfaceSetTangent
+	surface->tangent = (float4)(0.0f, 0.0f, 0.0f, 0.0f);
+}
+
+// Interpolate the vertex tangents at the intersection point. The bitangent
+// handedness is copied from the first vertex as it is constant across 
+// triangles that do not straddle a uv mirror seam.
+void surfaceSetTangent(Surface *surface, __global Intersection *intersection, __global float4 *tangents){
+	float3 wuv = intersection->wuvt.xyz;
+	int offset = intersection->triIndex * 3;
+
+	float3 tangent = wuv.x * tangents[offset].xyz + 
+		             wuv.y * tangents[offset+1].xyz + 
+					 wuv.z * tangents[offset+2].xyz;
+	surface->tangent = (float4)(tangent, tangents[offset].w);
+}
+
+// Build an orthonormal tangent frame around the normal. If a tangent is
+// available, the frame is aligned to the surface uv directions by projecting
+// the tangent to the plane of the (possibly perturbed) normal. Otherwise, an
+// arbitrary frame is generated.
+void surfaceTangentFrame(float3 normal, float4 tangent, float3 *u, float3 *v){
+	float3 t = tangent.xyz - normal * dot(normal, tangent.xyz);
+	float tLenSq = dot(t, t);
+	if( tLenSq < SURFACE_MIN_TANGENT_LEN_SQ ){
+		TANGENT_VECTORS(normal, *u, *v);
+		return;
+	}
+
+	*u = t * rsqrt(tLenSq);
+	*v = cross(normal, *u) * (tangent.w < 0.0f ? -1.0f : 1.0f);
+}
+
+// Populate the curvature and local occlusion inputs used by the curvature and
+// occlusion mix material nodes. The curvature is approximated by the rate of 
+// change of the vertex normals along the triangle edges; the per-vertex values
+// are the average of the two edges sharing each vertex and are interpolated
+// using the barycentric coords of the hit. The occlusion is interpolated from
+// the per-vertex values estimated by the bakeLocalOcclusion kernel.
+void surfaceSetWearInputs(Surface *surface, __global Intersection *intersection, __global float4 *vertices, __global float4 *normals, __global float *vertexOcclusion){
+	float3 wuv = intersection->wuvt.xyz;
+	int offset = intersection->triIndex * 3;
+
+	float3 p0 = vertices[offset].xyz;
+	float3 p1 = vertices[offset+1].xyz;
+	float3 p2 = vertices[offset+2].xyz;
+	float3 n0 = normalize(normals[offset].xyz);
+	float3 n1 = normalize(normals[offset+1].xyz);
+	float3 n2 = normalize(normals[offset+2].xyz);
+
+	float k01 = surfaceEdgeCurvature(p0, n0, p1, n1);
+	float k02 = surfaceEdgeCurvature(p0, n0, p2, n2);
+	float k12 = surfaceEdgeCurvature(p1, n1, p2, n2);
+
+	surface->curvature = 0.5f * (
+			wuv.x * (k01 + k02) + 
+			wuv.y * (k01 + k12) + 
+			wuv.z * (k02 + k12)
+		);
+
+	surface->occlusion = wuv.x * vertexOcclusion[offset] + 
+		                 wuv.y * vertexOcclusion[offset+1] + 
+						 wuv.z * vertexOcclusion[offset+2];
+}
+
+// Estimate the normal curvature along the edge p0 -> p1. The result is 
+// positive when the normals diverge (convex edge) and negative when they 
+// converge (concave edge).
+float surfaceEdgeCurvature(float3 p0, float3 n0, float3 p1, float3 n1){
+	float3 edge = p1 - p0;
+	float edgeLenSq = dot(edge, edge);
+	return edgeLenSq > 0.0f ? dot(n1 - n0, edge) / edgeLenSq : 0.0f;
+}
+
+// Calculate the texture LOD for a ray cone with the given width at the 
+// intersection point. The LOD is the log2 of the cone footprint in uv space;
+// it combines the ratio of the triangle uv area to its surface area with the
+// cone width projected on the triangle plane. The texture dimensions are 
+// applied when sampling each texture (see texSelectMipLevel).
+void surfaceSetTextureLod(Surface *surface, __global Intersection *intersection, __global float4 *vertices, __global float2 *uv, float3 inRayDir, float coneWidth){
+	int offset = intersection->triIndex * 3;
+
+	float area = length(cross(
+				(vertices[offset+1] - vertices[offset]).xyz,
+				(vertices[offset+2] - vertices[offset]).xyz
+			));
+	float2 uvEdge1 = uv[offset+1] - uv[offset];
+	float2 uvEdge2 = uv[offset+2] - uv[offset];
+	float uvArea = fabs(uvEdge1.x * uvEdge2.y - uvEdge1.y * uvEdge2.x);
+
+	surface->texLod = 0.5f * log2(uvArea / area) + log2(coneWidth / fabs(dot(inRayDir, surface->geomNormal)));
+}
+
+// Correct shading normals that disagree with the geometric normal. Such
+// normals cause black fringes on low-poly meshes as rays hitting the front 
+// side of a triangle end up below the hemisphere of the shading normal. The
+// inRayDir argument must point away from the surface.
+//
+// - SHADING_NORMAL_FIX_CLAMP bends the shading normal towards the incoming
+//   ray when the ray is on a different side of the shading and the geometric
+//   normals.
+// - SHADING_NORMAL_FIX_FLIP mirrors shading normals that point below the
+//   triangle plane to the side of the geometric normal.
+void surfaceFixShadingNormal(Surface *surface, float3 inRayDir, const uint mode){
+	if( mode == SHADING_NORMAL_FIX_CLAMP ){
+		float inRayDotGeomNormal = dot(inRayDir, surface->geomNormal);
+		float inRayDotNormal = dot(inRayDir, surface->normal);
+		if( inRayDotGeomNormal * inRayDotNormal < 0.0f ){
+			float target = SHADING_NORMAL_CLAMP_EPSILON * sign(inRayDotGeomNormal);
+			surface->normal = normalize(surface->normal + inRayDir * (target - inRayDotNormal));
+		}
+	} else if( mode == SHADING_NORMAL_FIX_FLIP ){
+		float normalDotGeomNormal = dot(surface->normal, surface->geomNormal);
+		if( normalDotGeomNormal < 0.0f ){
+			surface->normal = normalize(surface->normal - 2.0f * normalDotGeomNormal * surface->geomNormal);
+		}
+	}
 }
 
 void printSurface(Surface *surface){
@@ -6,5 +6,7 @@
 #include "transform.cl"
 #include "surface.cl"
 #include "fresnel.cl"
+#include "lpe.cl"
+#include "bdpt.cl"
 
 #endif
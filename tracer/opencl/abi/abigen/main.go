@@ -0,0 +1,48 @@
+// The abigen command generates the Go and opencl sources for the stage ABI
+// spec of the opencl tracer. It is invoked by go generate in the
+// tracer/opencl package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/achilleasa/polaris/tracer/opencl/abi"
+)
+
+func main() {
+	specFile := flag.String("spec", "stages.abi", "the stage ABI spec file")
+	goFile := flag.String("go", "kernel_abi_gen.go", "the output file for the generated Go source")
+	clFile := flag.String("cl", "CL/abi.cl", "the output file for the generated opencl header")
+	pkg := flag.String("pkg", "opencl", "the package name for the generated Go source")
+	flag.Parse()
+
+	err := generate(*specFile, *goFile, *clFile, *pkg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "abigen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(specFile, goFile, clFile, pkg string) error {
+	spec, err := abi.ParseFile(specFile)
+	if err != nil {
+		return err
+	}
+
+	source := filepath.Base(specFile)
+	goSrc, err := spec.GoSource(pkg, source)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(goFile, goSrc, 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(clFile, spec.CLHeader(source), 0644)
+}
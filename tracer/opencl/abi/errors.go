@@ -0,0 +1,7 @@
+package abi
+
+import "errors"
+
+var (
+	ErrInvalidSpec = errors.New("abi: invalid stage ABI spec")
+)
@@ -0,0 +1,170 @@
+package abi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+)
+
+// Get the name of the macro that expands to the parameter list of a kernel
+// (e.g. SHADE_HITS_ARGS for shadeHits).
+func MacroName(kernelName string) string {
+	var buf bytes.Buffer
+	for index, r := range kernelName {
+		if index > 0 && unicode.IsUpper(r) {
+			buf.WriteByte('_')
+		}
+		buf.WriteRune(unicode.ToUpper(r))
+	}
+	buf.WriteString("_ARGS")
+	return buf.String()
+}
+
+// Get the name of the argument struct field for a kernel argument.
+func FieldName(argName string) string {
+	return strings.ToUpper(argName[:1]) + argName[1:]
+}
+
+// Generate the Go source for the given package. The source argument is the
+// name of the spec file and is referenced by the generated header.
+func (s *Spec) GoSource(pkg, source string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// Code generated by abigen from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+
+	buf.WriteString("import (\n")
+	buf.WriteString("\t\"github.com/achilleasa/polaris/tracer/opencl/device\"\n")
+	if s.usesVectors() {
+		buf.WriteString("\t\"github.com/achilleasa/polaris/types\"\n")
+	}
+	buf.WriteString(")\n\n")
+
+	buf.WriteString("// The version of the stage ABI.\n")
+	fmt.Fprintf(&buf, "const stageABIVersion = %d\n\n", s.Version)
+
+	buf.WriteString("// The list of kernels that implement the tracer.\nconst (\n")
+	for index, kernel := range s.Kernels {
+		writeComment(&buf, "\t", "//", kernel.Doc)
+		if index == 0 {
+			fmt.Fprintf(&buf, "\t%s kernelType = iota\n", kernel.Name)
+		} else {
+			fmt.Fprintf(&buf, "\t%s\n", kernel.Name)
+		}
+	}
+	buf.WriteString("\t//\n\tnumKernels\n)\n\n")
+
+	buf.WriteString("// The kernel names as defined in the CL source files.\n")
+	buf.WriteString("var kernelNames = [numKernels]string{\n")
+	for _, kernel := range s.Kernels {
+		fmt.Fprintf(&buf, "\t%q,\n", kernel.Name)
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("// The argument names of each kernel in declaration order.\n")
+	buf.WriteString("var kernelArgNames = [numKernels][]string{\n")
+	for _, kernel := range s.Kernels {
+		names := make([]string, len(kernel.Args))
+		for index, arg := range kernel.Args {
+			names[index] = fmt.Sprintf("%q", arg.Name)
+		}
+		fmt.Fprintf(&buf, "\t{%s},\n", strings.Join(names, ", "))
+	}
+	buf.WriteString("}\n\n")
+
+	buf.WriteString("// The defines shared with the kernels and the host constants that must\n")
+	buf.WriteString("// match their values.\n")
+	buf.WriteString("var abiDefines = []abiDefine{\n")
+	for _, define := range s.Defines {
+		if define.HostConstant == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "\t{%q, %s, uint64(%s)},\n", define.Name, define.Literal, define.HostConstant)
+	}
+	buf.WriteString("}\n")
+
+	for _, kernel := range s.Kernels {
+		fmt.Fprintf(&buf, "\n// Arguments for the %s kernel.\n", kernel.Name)
+		fmt.Fprintf(&buf, "type %sArgs struct {\n", kernel.Name)
+		for _, arg := range kernel.Args {
+			writeComment(&buf, "\t", "//", arg.Doc)
+			fmt.Fprintf(&buf, "\t%s %s\n", FieldName(arg.Name), arg.Kind.GoType())
+		}
+		buf.WriteString("}\n\n")
+
+		fmt.Fprintf(&buf, "// Bind the arguments to the %s kernel.\n", kernel.Name)
+		fmt.Fprintf(&buf, "func (a %sArgs) bind(k argBinder) error {\n", kernel.Name)
+		fmt.Fprintf(&buf, "\treturn bindKernelArgs(k, %s,\n", kernel.Name)
+		for _, arg := range kernel.Args {
+			fmt.Fprintf(&buf, "\t\ta.%s,\n", FieldName(arg.Name))
+		}
+		buf.WriteString("\t)\n}\n")
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// Generate the opencl header. The source argument is the name of the spec
+// file and is referenced by the generated header.
+func (s *Spec) CLHeader(source string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// Code generated by abigen from %s. DO NOT EDIT.\n\n", source)
+	buf.WriteString("#ifndef ABI_CL\n#define ABI_CL\n\n")
+
+	buf.WriteString("// The version of the stage ABI.\n")
+	fmt.Fprintf(&buf, "#define STAGE_ABI_VERSION %d\n", s.Version)
+
+	for _, define := range s.Defines {
+		if len(define.Doc) != 0 {
+			buf.WriteByte('\n')
+			writeComment(&buf, "", "//", define.Doc)
+		}
+		fmt.Fprintf(&buf, "#define %s %s\n", define.Name, define.Literal)
+	}
+
+	for _, kernel := range s.Kernels {
+		buf.WriteByte('\n')
+		writeComment(&buf, "", "//", kernel.Doc)
+		fmt.Fprintf(&buf, "#define %s \\\n", MacroName(kernel.Name))
+		for index, arg := range kernel.Args {
+			// Line comments would swallow the line continuation
+			for _, line := range arg.Doc {
+				fmt.Fprintf(&buf, "\t\t/* %s */ \\\n", line)
+			}
+			if index < len(kernel.Args)-1 {
+				fmt.Fprintf(&buf, "\t\t%s, \\\n", arg.Decl)
+			} else {
+				fmt.Fprintf(&buf, "\t\t%s\n", arg.Decl)
+			}
+		}
+	}
+
+	buf.WriteString("\n#endif\n")
+	return buf.Bytes()
+}
+
+// Check whether any kernel uses vector arguments.
+func (s *Spec) usesVectors() bool {
+	for _, kernel := range s.Kernels {
+		for _, arg := range kernel.Args {
+			if arg.Kind >= Float2Arg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Write a list of comment lines using the given indentation and prefix.
+func writeComment(buf *bytes.Buffer, indent, prefix string, lines []string) {
+	for _, line := range lines {
+		if line == "" {
+			fmt.Fprintf(buf, "%s%s\n", indent, prefix)
+			continue
+		}
+		fmt.Fprintf(buf, "%s%s %s\n", indent, prefix, line)
+	}
+}
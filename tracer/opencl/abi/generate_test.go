@@ -0,0 +1,53 @@
+package abi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMacroName(t *testing.T) {
+	specs := []struct {
+		in, exp string
+	}{
+		{"shadeHits", "SHADE_HITS_ARGS"},
+		{"rayPacketIntersectionQuery", "RAY_PACKET_INTERSECTION_QUERY_ARGS"},
+		{"clear", "CLEAR_ARGS"},
+	}
+
+	for index, spec := range specs {
+		if got := MacroName(spec.in); got != spec.exp {
+			t.Errorf("[spec %d] expected macro name for %q to be %q; got %q", index, spec.in, spec.exp, got)
+		}
+	}
+}
+
+// Ensure that the generated sources in the opencl tracer package match the
+// stage ABI spec.
+func TestGeneratedSourcesUpToDate(t *testing.T) {
+	spec, err := ParseFile("../stages.abi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goSrc, err := spec.GoSource("opencl", "stages.abi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, gen := range []struct {
+		file string
+		src  []byte
+	}{
+		{"../kernel_abi_gen.go", goSrc},
+		{"../CL/abi.cl", spec.CLHeader("stages.abi")},
+	} {
+		existing, err := ioutil.ReadFile(gen.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(existing, gen.src) {
+			t.Errorf("%s does not match the stage ABI spec; run go generate in tracer/opencl", gen.file)
+		}
+	}
+}
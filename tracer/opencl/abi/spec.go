@@ -0,0 +1,279 @@
+// Package abi parses the stage ABI spec shared by the opencl tracer and its
+// kernels and generates the matching Go and opencl sources.
+package abi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The kind of a kernel argument.
+type ArgKind uint8
+
+// Supported argument kinds.
+const (
+	BufferArg ArgKind = iota
+	IntArg
+	UintArg
+	FloatArg
+	Float2Arg
+	Float3Arg
+	Float4Arg
+)
+
+// The argument kinds for each supported opencl scalar and vector type.
+var scalarKinds = map[string]ArgKind{
+	"int":    IntArg,
+	"uint":   UintArg,
+	"float":  FloatArg,
+	"float2": Float2Arg,
+	"float3": Float3Arg,
+	"float4": Float4Arg,
+}
+
+// Get the Go type that is used for binding arguments of this kind.
+func (k ArgKind) GoType() string {
+	switch k {
+	case BufferArg:
+		return "*device.Buffer"
+	case IntArg:
+		return "int32"
+	case UintArg:
+		return "uint32"
+	case FloatArg:
+		return "float32"
+	case Float2Arg:
+		return "types.Vec2"
+	case Float3Arg:
+		return "types.Vec3"
+	default:
+		return "types.Vec4"
+	}
+}
+
+// A kernel argument.
+type Arg struct {
+	// The argument name.
+	Name string
+
+	// The opencl parameter declaration (e.g. "__global Ray *rays").
+	Decl string
+
+	Kind ArgKind
+	Doc  []string
+}
+
+// An integer constant shared by the host and the kernels.
+type Define struct {
+	Name string
+
+	// The value as specified in the spec and its numeric value.
+	Literal string
+	Value   uint64
+
+	// An optional host constant that must match the define value.
+	HostConstant string
+
+	Doc []string
+}
+
+// A kernel and its arguments in declaration order.
+type Kernel struct {
+	Name string
+	Args []Arg
+	Doc  []string
+}
+
+// A parsed stage ABI spec.
+type Spec struct {
+	Version uint32
+	Defines []Define
+	Kernels []Kernel
+}
+
+// Parse a stage ABI spec file.
+func ParseFile(path string) (*Spec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse a stage ABI spec.
+func Parse(r io.Reader) (*Spec, error) {
+	spec := &Spec{}
+	var doc []string
+	var kernel *Kernel
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+		trimmed := strings.TrimSpace(line)
+		indented := trimmed != line
+
+		switch {
+		case trimmed == "":
+			doc = nil
+			continue
+		case strings.HasPrefix(trimmed, "#"):
+			doc = append(doc, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+			continue
+		case indented:
+			if kernel == nil {
+				return nil, specError(lineNum, "parameter declared outside of a kernel definition")
+			}
+			arg, err := parseArg(trimmed)
+			if err != nil {
+				return nil, specError(lineNum, err.Error())
+			}
+			for _, other := range kernel.Args {
+				if other.Name == arg.Name {
+					return nil, specError(lineNum, fmt.Sprintf("duplicate parameter %q for kernel %q", arg.Name, kernel.Name))
+				}
+			}
+			arg.Doc = doc
+			kernel.Args = append(kernel.Args, arg)
+			doc = nil
+			continue
+		}
+
+		if kernel != nil {
+			if len(kernel.Args) == 0 {
+				return nil, specError(lineNum, fmt.Sprintf("kernel %q does not declare any parameters", kernel.Name))
+			}
+			spec.Kernels = append(spec.Kernels, *kernel)
+			kernel = nil
+		}
+
+		tokens := strings.Fields(trimmed)
+		switch tokens[0] {
+		case "version":
+			if len(tokens) != 2 {
+				return nil, specError(lineNum, "expected: version N")
+			}
+			version, err := strconv.ParseUint(tokens[1], 10, 32)
+			if err != nil || version == 0 {
+				return nil, specError(lineNum, fmt.Sprintf("invalid version %q", tokens[1]))
+			}
+			spec.Version = uint32(version)
+		case "define":
+			if len(tokens) != 3 && len(tokens) != 4 {
+				return nil, specError(lineNum, "expected: define NAME VALUE [HOST_CONSTANT]")
+			}
+			if !isIdentifier(tokens[1]) || spec.define(tokens[1]) != nil {
+				return nil, specError(lineNum, fmt.Sprintf("invalid or duplicate define name %q", tokens[1]))
+			}
+			value, err := strconv.ParseUint(tokens[2], 0, 64)
+			if err != nil {
+				return nil, specError(lineNum, fmt.Sprintf("invalid value %q for define %q", tokens[2], tokens[1]))
+			}
+			define := Define{Name: tokens[1], Literal: tokens[2], Value: value, Doc: doc}
+			if len(tokens) == 4 {
+				if !isIdentifier(tokens[3]) {
+					return nil, specError(lineNum, fmt.Sprintf("invalid host constant %q", tokens[3]))
+				}
+				define.HostConstant = tokens[3]
+			}
+			spec.Defines = append(spec.Defines, define)
+		case "kernel":
+			if len(tokens) != 2 {
+				return nil, specError(lineNum, "expected: kernel name")
+			}
+			if !isIdentifier(tokens[1]) || spec.kernel(tokens[1]) != nil {
+				return nil, specError(lineNum, fmt.Sprintf("invalid or duplicate kernel name %q", tokens[1]))
+			}
+			kernel = &Kernel{Name: tokens[1], Doc: doc}
+		default:
+			return nil, specError(lineNum, fmt.Sprintf("unknown directive %q", tokens[0]))
+		}
+		doc = nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if kernel != nil {
+		if len(kernel.Args) == 0 {
+			return nil, fmt.Errorf("%s: kernel %q does not declare any parameters", ErrInvalidSpec.Error(), kernel.Name)
+		}
+		spec.Kernels = append(spec.Kernels, *kernel)
+	}
+
+	if spec.Version == 0 {
+		return nil, fmt.Errorf("%s: missing version", ErrInvalidSpec.Error())
+	}
+	if len(spec.Kernels) == 0 {
+		return nil, fmt.Errorf("%s: no kernels defined", ErrInvalidSpec.Error())
+	}
+
+	return spec, nil
+}
+
+// Parse an opencl parameter declaration.
+func parseArg(decl string) (Arg, error) {
+	tokens := strings.Fields(decl)
+	name := strings.TrimLeft(tokens[len(tokens)-1], "*")
+	if len(tokens) < 2 || !isIdentifier(name) {
+		return Arg{}, fmt.Errorf("invalid parameter declaration %q", decl)
+	}
+
+	arg := Arg{Name: name, Decl: strings.Join(tokens, " ")}
+	if strings.Contains(decl, "*") {
+		arg.Kind = BufferArg
+		return arg, nil
+	}
+
+	kind, supported := scalarKinds[tokens[len(tokens)-2]]
+	if !supported {
+		return Arg{}, fmt.Errorf("unsupported type for parameter %q; non-pointer parameters must use one of: int, uint, float, float2, float3, float4", name)
+	}
+	arg.Kind = kind
+	return arg, nil
+}
+
+// Lookup a define by name.
+func (s *Spec) define(name string) *Define {
+	for index := range s.Defines {
+		if s.Defines[index].Name == name {
+			return &s.Defines[index]
+		}
+	}
+	return nil
+}
+
+// Lookup a kernel by name.
+func (s *Spec) kernel(name string) *Kernel {
+	for index := range s.Kernels {
+		if s.Kernels[index].Name == name {
+			return &s.Kernels[index]
+		}
+	}
+	return nil
+}
+
+// Check whether name is a valid C and Go identifier.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for index, r := range name {
+		if r != '_' && !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && (index == 0 || !(r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Create a spec error for the given line.
+func specError(lineNum int, msg string) error {
+	return fmt.Errorf("%s: line %d: %s", ErrInvalidSpec.Error(), lineNum, msg)
+}
@@ -0,0 +1,89 @@
+package abi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := `
+# file comment
+
+version 2
+
+# Pixel filters.
+define PIXEL_FILTER_TENT 0 TentFilter
+define LPE_ACCEPT_FLAG 0x80
+
+# Clear a buffer.
+kernel clearBuffer
+	__global float3 *accumulator
+	# the block offset
+	const uint offset
+	const float2 texelDims
+`
+	spec, err := Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spec.Version != 2 {
+		t.Fatalf("expected version 2; got %d", spec.Version)
+	}
+
+	if len(spec.Defines) != 2 {
+		t.Fatalf("expected 2 defines; got %d", len(spec.Defines))
+	}
+	if d := spec.Defines[0]; d.Name != "PIXEL_FILTER_TENT" || d.HostConstant != "TentFilter" || len(d.Doc) != 1 {
+		t.Fatalf("unexpected define: %+v", d)
+	}
+	if d := spec.Defines[1]; d.Value != 0x80 || d.Literal != "0x80" || d.HostConstant != "" {
+		t.Fatalf("unexpected define: %+v", d)
+	}
+
+	if len(spec.Kernels) != 1 {
+		t.Fatalf("expected 1 kernel; got %d", len(spec.Kernels))
+	}
+	kernel := spec.Kernels[0]
+	if kernel.Name != "clearBuffer" || len(kernel.Doc) != 1 {
+		t.Fatalf("unexpected kernel: %+v", kernel)
+	}
+
+	expArgs := []Arg{
+		{Name: "accumulator", Decl: "__global float3 *accumulator", Kind: BufferArg},
+		{Name: "offset", Decl: "const uint offset", Kind: UintArg, Doc: []string{"the block offset"}},
+		{Name: "texelDims", Decl: "const float2 texelDims", Kind: Float2Arg},
+	}
+	for index, exp := range expArgs {
+		got := kernel.Args[index]
+		if got.Name != exp.Name || got.Decl != exp.Decl || got.Kind != exp.Kind || len(got.Doc) != len(exp.Doc) {
+			t.Errorf("expected arg %d to be %+v; got %+v", index, exp, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	specs := []struct {
+		src    string
+		expErr string
+	}{
+		{"kernel foo\n\t__global int *out\n", "missing version"},
+		{"version 1\n", "no kernels defined"},
+		{"version 0\n", "invalid version"},
+		{"version 1\nfoo bar\n", "line 2: unknown directive"},
+		{"version 1\n\t__global int *out\n", "outside of a kernel"},
+		{"version 1\nkernel foo\nkernel bar\n\tconst uint x\n", "does not declare any parameters"},
+		{"version 1\nkernel foo\n\tconst uint x\n\tconst float x\n", "duplicate parameter"},
+		{"version 1\nkernel foo\n\tconst uint x\nkernel foo\n\tconst uint x\n", "duplicate kernel"},
+		{"version 1\nkernel foo\n\tconst double x\n", "unsupported type"},
+		{"version 1\ndefine FOO bar\n", "invalid value"},
+		{"version 1\ndefine FOO 1\ndefine FOO 2\n", "duplicate define"},
+	}
+
+	for index, spec := range specs {
+		_, err := Parse(strings.NewReader(spec.src))
+		if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidSpec.Error()) || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", index, spec.expErr, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package opencl
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/achilleasa/polaris/types"
+)
+
+// The width and height of the blue-noise mask in texels. It must be a power
+// of two.
+const blueNoiseSize = 64
+
+// The standard deviation of the gaussian filter used for locating the
+// clusters and voids of the blue-noise dither patterns.
+const blueNoiseSigma = 1.5
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoise     []types.Vec2
+)
+
+// Get the blue-noise mask used by the blue-noise sampler. The mask contains
+// blueNoiseSize x blueNoiseSize texels in row-major order; the X and Y
+// coordinates of each texel are obtained from two independent dither arrays
+// and are uniformly distributed in the [0, 1) range. The mask is generated
+// the first time it is requested and is shared by all tracers.
+func blueNoiseMask() []types.Vec2 {
+	blueNoiseOnce.Do(func() {
+		rng := rand.New(rand.NewSource(1))
+		ranksX := voidAndCluster(blueNoiseSize, rng)
+		ranksY := voidAndCluster(blueNoiseSize, rng)
+
+		numTexels := float32(len(ranksX))
+		blueNoise = make([]types.Vec2, len(ranksX))
+		for texel := range blueNoise {
+			blueNoise[texel] = types.Vec2{
+				(float32(ranksX[texel]) + 0.5) / numTexels,
+				(float32(ranksY[texel]) + 0.5) / numTexels,
+			}
+		}
+	})
+	return blueNoise
+}
+
+// A binary pattern that tiles the plane and the energy of each of its pixels.
+// The energy of a pixel is the sum of the gaussian-weighted (toroidal)
+// distances to the set pixels of the pattern.
+type ditherPattern struct {
+	size   int
+	filter []float64
+	set    []bool
+	energy []float64
+}
+
+// Generate a size x size dither array using Ulichney's void-and-cluster
+// method. Each pixel is assigned a unique rank in the [0, size * size) range
+// so that thresholding the array at any rank yields a blue-noise pattern.
+func voidAndCluster(size int, rng *rand.Rand) []uint32 {
+	numPixels := size * size
+	p := &ditherPattern{
+		size:   size,
+		filter: make([]float64, numPixels),
+		set:    make([]bool, numPixels),
+		energy: make([]float64, numPixels),
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(minInt(x, size-x)), float64(minInt(y, size-y))
+			p.filter[y*size+x] = math.Exp(-(dx*dx + dy*dy) / (2 * blueNoiseSigma * blueNoiseSigma))
+		}
+	}
+
+	// Seed a tenth of the pixels at random and move the pixels of the
+	// tightest clusters to the largest voids until the pattern converges.
+	numSeeds := numPixels / 10
+	for _, pixel := range rng.Perm(numPixels)[:numSeeds] {
+		p.toggle(pixel)
+	}
+	for iteration := 0; iteration < numPixels; iteration++ {
+		cluster := p.tightestCluster()
+		p.toggle(cluster)
+		void := p.largestVoid()
+		p.toggle(void)
+		if void == cluster {
+			break
+		}
+	}
+	prototype := append([]bool(nil), p.set...)
+	prototypeEnergy := append([]float64(nil), p.energy...)
+
+	// Rank the seed pixels by removing the tightest clusters first
+	ranks := make([]uint32, numPixels)
+	for rank := numSeeds - 1; rank >= 0; rank-- {
+		cluster := p.tightestCluster()
+		p.toggle(cluster)
+		ranks[cluster] = uint32(rank)
+	}
+
+	// Rank the remaining pixels by filling the largest voids. Once more
+	// than half of the pixels are set, the largest void of the set pixels
+	// is also the tightest cluster of the unset pixels so the same
+	// criterion is used for all remaining ranks.
+	p.set, p.energy = prototype, prototypeEnergy
+	for rank := numSeeds; rank < numPixels; rank++ {
+		void := p.largestVoid()
+		p.toggle(void)
+		ranks[void] = uint32(rank)
+	}
+
+	return ranks
+}
+
+// Flip a pattern pixel and update the pixel energies.
+func (p *ditherPattern) toggle(pixel int) {
+	weight := 1.0
+	if p.set[pixel] {
+		weight = -1.0
+	}
+	p.set[pixel] = !p.set[pixel]
+
+	px, py := pixel%p.size, pixel/p.size
+	for y := 0; y < p.size; y++ {
+		row := ((y - py + p.size) % p.size) * p.size
+		for x := 0; x < p.size; x++ {
+			p.energy[y*p.size+x] += weight * p.filter[row+(x-px+p.size)%p.size]
+		}
+	}
+}
+
+// Get the set pixel with the highest energy.
+func (p *ditherPattern) tightestCluster() int {
+	best := -1
+	for pixel, energy := range p.energy {
+		if p.set[pixel] && (best == -1 || energy > p.energy[best]) {
+			best = pixel
+		}
+	}
+	return best
+}
+
+// Get the unset pixel with the lowest energy.
+func (p *ditherPattern) largestVoid() int {
+	best := -1
+	for pixel, energy := range p.energy {
+		if !p.set[pixel] && (best == -1 || energy < p.energy[best]) {
+			best = pixel
+		}
+	}
+	return best
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
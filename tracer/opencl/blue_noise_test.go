@@ -0,0 +1,59 @@
+package opencl
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBlueNoiseMask(t *testing.T) {
+	mask := blueNoiseMask()
+	numTexels := blueNoiseSize * blueNoiseSize
+	if len(mask) != numTexels {
+		t.Fatalf("expected mask to contain %d texels; got %d", numTexels, len(mask))
+	}
+
+	// Each channel must contain every rank exactly once
+	for channel := 0; channel < 2; channel++ {
+		seen := make([]bool, numTexels)
+		for texel, value := range mask {
+			rank := int(value[channel] * float32(numTexels))
+			if rank < 0 || rank >= numTexels || seen[rank] {
+				t.Fatalf("[channel %d] texel %d has invalid or duplicate value %f", channel, texel, value[channel])
+			}
+			seen[rank] = true
+		}
+	}
+
+	// Blue noise lacks low-frequency content so the values of each 8x8
+	// tile average to almost 0.5. The tile averages of white noise deviate
+	// from 0.5 by 0.029 on average.
+	const tileSize = 8
+	var deviation float64
+	var numTiles int
+	for tileY := 0; tileY < blueNoiseSize; tileY += tileSize {
+		for tileX := 0; tileX < blueNoiseSize; tileX += tileSize {
+			for channel := 0; channel < 2; channel++ {
+				var sum float64
+				for y := tileY; y < tileY+tileSize; y++ {
+					for x := tileX; x < tileX+tileSize; x++ {
+						sum += float64(mask[y*blueNoiseSize+x][channel])
+					}
+				}
+				deviation += math.Abs(sum/(tileSize*tileSize) - 0.5)
+				numTiles++
+			}
+		}
+	}
+	if deviation /= float64(numTiles); deviation > 0.01 {
+		t.Fatalf("expected tile averages to deviate from 0.5 by less than 0.01 on average; got %f", deviation)
+	}
+
+	// Channels are generated from independent dither arrays
+	var correlation float64
+	for _, value := range mask {
+		correlation += (float64(value[0]) - 0.5) * (float64(value[1]) - 0.5)
+	}
+	if correlation = 12 * correlation / float64(numTexels); math.Abs(correlation) > 0.1 {
+		t.Fatalf("expected mask channels to be uncorrelated; got correlation %f", correlation)
+	}
+}
@@ -3,19 +3,25 @@ package opencl
 import (
 	"reflect"
 
+	"github.com/achilleasa/gopencl/v1.2/cl"
 	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
-	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/types"
 )
 
 // Size of buffer elements in bytes.
 const (
 	sizeofRay               = 32
 	sizeofPath              = 32
+	sizeofLightVertex       = 96
 	sizeofHitFlag           = 4 // uint32
 	sizeofIntersection      = 32
 	sizeofEmissiveSample    = 16 // float3 but takes same space as float4
+	sizeofOcclusionCone     = 16 // float4
 	sizeofAccumulatorSample = 16 // float3
+	sizeofLpeState          = 4  // uint32
+	sizeofPathMedium        = 4  // int32
 )
 
 type bufferSet struct {
@@ -25,6 +31,11 @@ type bufferSet struct {
 	// Bvh node storage.
 	BvhNodes *device.Buffer
 
+	// Compressed BVH node storage and the compressed BVH root of each
+	// mesh instance.
+	CompressedBvhNodes *device.Buffer
+	CompressedBvhRoots *device.Buffer
+
 	// Mesh instances.
 	MeshInstances *device.Buffer
 
@@ -35,12 +46,24 @@ type bufferSet struct {
 	Textures        *device.Buffer
 	TextureMetadata *device.Buffer
 
+	// The density and emission grids of heterogeneous media and their
+	// voxel data.
+	VolumeGrids *device.Buffer
+	VolumeData  *device.Buffer
+
 	// Geometry
 	Vertices        *device.Buffer
 	Normals         *device.Buffer
+	Tangents        *device.Buffer
 	UV              *device.Buffer
 	MaterialIndices *device.Buffer
 
+	// The per-vertex local occlusion used by mixOcclusion material nodes
+	// and the per-triangle inputs for estimating it.
+	VertexOcclusion   *device.Buffer
+	OcclusionBvhRoots *device.Buffer
+	OcclusionRadius   *device.Buffer
+
 	// Emissive primitives
 	EmissivePrimitives *device.Buffer
 
@@ -52,6 +75,10 @@ type bufferSet struct {
 	HitFlags      *device.Buffer
 	Intersections *device.Buffer
 
+	// The material node index of the medium that each path travels
+	// through or -1 if the path is not inside a medium.
+	PathMedia *device.Buffer
+
 	// A buffer that stores trace samples for a single trace request. It is
 	// cleared before starting a new trace.
 	TraceAccumulator *device.Buffer
@@ -62,11 +89,102 @@ type bufferSet struct {
 	// is executed.
 	FrameAccumulator *device.Buffer
 
+	// The running compensation for the frame accumulator when the
+	// accumulator contents are aggregated using compensated summation.
+	// The buffer is only allocated when the pipeline enables compensated
+	// accumulation.
+	FrameCompensation *device.Buffer
+
+	// A buffer that stores the frame accumulator contents after they
+	// have been processed by host post-processing stages. The buffer is
+	// allocated when a host post-processing stage is first executed.
+	PostAccumulator *device.Buffer
+
+	// Per-pixel sample statistics stored as float3 values (luminance sum,
+	// squared luminance sum and sample count). The trace and frame buffers
+	// follow the same semantics as the trace and frame accumulators. The
+	// sample snapshot holds the trace accumulator contents after the last
+	// traced sample so that the contribution of each sample can be isolated.
+	// These buffers are only allocated when the pipeline collects sample
+	// statistics.
+	TraceSampleStats *device.Buffer
+	FrameSampleStats *device.Buffer
+	SampleSnapshot   *device.Buffer
+
+	// The compiled light path expression transition tables, the packed
+	// DFA states of each path and the expressions that accept each
+	// emissive sample. The trace and frame light path accumulators store
+	// one pass per expression and light group and follow the same
+	// semantics as the trace and frame accumulators.
+	LpeTransitions         *device.Buffer
+	LpeStates              *device.Buffer
+	EmissiveSampleLpeMasks *device.Buffer
+	TraceLpeAccumulator    *device.Buffer
+	FrameLpeAccumulator    *device.Buffer
+
+	// The light group pass mask of the scene background and each material
+	// node and the intensity multipliers for mixing the light group passes
+	// that the light path accumulators store after the expression passes.
+	LightGroupMasks  *device.Buffer
+	LightGroupScales *device.Buffer
+
+	// Host-supplied primary rays used by the custom camera stages.
+	CameraRays *device.Buffer
+
+	// The block-relative pixel index for each primary ray.
+	RayPixels *device.Buffer
+
+	// Lens samples generated from the camera bokeh mask.
+	BokehSamples *device.Buffer
+
+	// The blue-noise mask used by the blue-noise sampler.
+	BlueNoise *device.Buffer
+
+	// The importance sampling distribution for the scene environment light.
+	EnvMapDistribution *device.Buffer
+
+	// The smallest texture footprint recorded for each material node. The
+	// buffer is only allocated when texture streaming is enabled.
+	TextureFeedback *device.Buffer
+
+	// Copies of the hit flags and intersections for primary rays. These
+	// buffers are used by the first-hit cache and are only allocated when
+	// the cache is enabled.
+	PrimaryHitFlags      *device.Buffer
+	PrimaryIntersections *device.Buffer
+
+	// Light subpath rays, paths and vertices and the connection rays and
+	// samples used by the bidirectional integrator. The light vertex
+	// buffer stores a fixed number of vertices for each path. These
+	// buffers are only allocated when the bidirectional integrator is used.
+	LightRays            [2]*device.Buffer
+	LightRayCounters     [2]*device.Buffer
+	LightPaths           *device.Buffer
+	LightVertices        *device.Buffer
+	ConnectionRays       *device.Buffer
+	ConnectionRayCounter *device.Buffer
+	ConnectionHitFlags   *device.Buffer
+	ConnectionSamples    *device.Buffer
+	ConnectionLpeMasks   *device.Buffer
+	LightPathGroupMasks  *device.Buffer
+
 	EmissiveSamples *device.Buffer
 	DebugOutput     *device.Buffer
 
+	// The centroid and radius of the light sampled by each occlusion ray.
+	// They are used for tracing soft shadow cones.
+	OcclusionCones *device.Buffer
+
+	// Raw float4 values generated by the debug kernels for the throughput,
+	// accumulator and emissive sample images.
+	DebugValues *device.Buffer
+
 	// Counters
 	RayCounters [3]*device.Buffer
+
+	// The number of rays whose BVH traversal ran out of stack space since
+	// the counter was last cleared.
+	StackOverflows *device.Buffer
 }
 
 // Allocate new buffer set.
@@ -76,14 +194,22 @@ func newBufferSet(dev *device.Device) *bufferSet {
 		FrameBuffer: dev.Buffer("frameBuffer"),
 		// Scene data
 		BvhNodes:           dev.Buffer("bvhNodes"),
+		CompressedBvhNodes: dev.Buffer("compressedBvhNodes"),
+		CompressedBvhRoots: dev.Buffer("compressedBvhRoots"),
 		MeshInstances:      dev.Buffer("meshInstances"),
 		MaterialNodes:      dev.Buffer("materialNodes"),
 		Textures:           dev.Buffer("textures"),
 		TextureMetadata:    dev.Buffer("textureMetadata"),
+		VolumeGrids:        dev.Buffer("volumeGrids"),
+		VolumeData:         dev.Buffer("volumeData"),
 		Vertices:           dev.Buffer("vertices"),
 		Normals:            dev.Buffer("normals"),
+		Tangents:           dev.Buffer("tangents"),
 		UV:                 dev.Buffer("uv"),
 		MaterialIndices:    dev.Buffer("materialIndices"),
+		VertexOcclusion:    dev.Buffer("vertexOcclusion"),
+		OcclusionBvhRoots:  dev.Buffer("occlusionBvhRoots"),
+		OcclusionRadius:    dev.Buffer("occlusionRadius"),
 		EmissivePrimitives: dev.Buffer("emissivePrimitives"),
 		// Tracer data
 		Rays: [3]*device.Buffer{
@@ -91,18 +217,58 @@ func newBufferSet(dev *device.Device) *bufferSet {
 			dev.Buffer("rays1"),
 			dev.Buffer("rays2"),
 		},
-		Paths:            dev.Buffer("paths"),
-		HitFlags:         dev.Buffer("hitFlags"),
-		Intersections:    dev.Buffer("intersections"),
-		EmissiveSamples:  dev.Buffer("emissiveSamples"),
-		TraceAccumulator: dev.Buffer("traceAccumulator"),
-		FrameAccumulator: dev.Buffer("frameAccumulator"),
-		DebugOutput:      dev.Buffer("debugOutput"),
+		Paths:                  dev.Buffer("paths"),
+		HitFlags:               dev.Buffer("hitFlags"),
+		Intersections:          dev.Buffer("intersections"),
+		PathMedia:              dev.Buffer("pathMedia"),
+		EmissiveSamples:        dev.Buffer("emissiveSamples"),
+		OcclusionCones:         dev.Buffer("occlusionCones"),
+		TraceAccumulator:       dev.Buffer("traceAccumulator"),
+		FrameAccumulator:       dev.Buffer("frameAccumulator"),
+		FrameCompensation:      dev.Buffer("frameCompensation"),
+		PostAccumulator:        dev.Buffer("postAccumulator"),
+		TraceSampleStats:       dev.Buffer("traceSampleStats"),
+		FrameSampleStats:       dev.Buffer("frameSampleStats"),
+		SampleSnapshot:         dev.Buffer("sampleSnapshot"),
+		LpeTransitions:         dev.Buffer("lpeTransitions"),
+		LpeStates:              dev.Buffer("lpeStates"),
+		EmissiveSampleLpeMasks: dev.Buffer("emissiveSampleLpeMasks"),
+		TraceLpeAccumulator:    dev.Buffer("traceLpeAccumulator"),
+		FrameLpeAccumulator:    dev.Buffer("frameLpeAccumulator"),
+		LightGroupMasks:        dev.Buffer("lightGroupMasks"),
+		LightGroupScales:       dev.Buffer("lightGroupScales"),
+		CameraRays:             dev.Buffer("cameraRays"),
+		RayPixels:              dev.Buffer("rayPixels"),
+		BokehSamples:           dev.Buffer("bokehSamples"),
+		BlueNoise:              dev.Buffer("blueNoise"),
+		EnvMapDistribution:     dev.Buffer("envMapDistribution"),
+		TextureFeedback:        dev.Buffer("textureFeedback"),
+		PrimaryHitFlags:        dev.Buffer("primaryHitFlags"),
+		PrimaryIntersections:   dev.Buffer("primaryIntersections"),
+		LightRays: [2]*device.Buffer{
+			dev.Buffer("lightRays0"),
+			dev.Buffer("lightRays1"),
+		},
+		LightRayCounters: [2]*device.Buffer{
+			dev.Buffer("numLightRays0"),
+			dev.Buffer("numLightRays1"),
+		},
+		LightPaths:           dev.Buffer("lightPaths"),
+		LightVertices:        dev.Buffer("lightVertices"),
+		ConnectionRays:       dev.Buffer("connectionRays"),
+		ConnectionRayCounter: dev.Buffer("numConnectionRays"),
+		ConnectionHitFlags:   dev.Buffer("connectionHitFlags"),
+		ConnectionSamples:    dev.Buffer("connectionSamples"),
+		ConnectionLpeMasks:   dev.Buffer("connectionLpeMasks"),
+		LightPathGroupMasks:  dev.Buffer("lightPathGroupMasks"),
+		DebugOutput:          dev.Buffer("debugOutput"),
+		DebugValues:          dev.Buffer("debugValues"),
 		RayCounters: [3]*device.Buffer{
 			dev.Buffer("numRays0"),
 			dev.Buffer("numRays1"),
 			dev.Buffer("numRays2"),
 		},
+		StackOverflows: dev.Buffer("stackOverflows"),
 	}
 }
 
@@ -142,6 +308,10 @@ func (bs *bufferSet) Resize(frameW, frameH uint32) error {
 			return err
 		}
 	}
+	err = bs.StackOverflows.Allocate(4, cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
 	err = bs.Paths.Allocate(int(pixels*sizeofPath), cl.MEM_READ_WRITE)
 	if err != nil {
 		return err
@@ -166,23 +336,109 @@ func (bs *bufferSet) Resize(frameW, frameH uint32) error {
 	if err != nil {
 		return err
 	}
+	err = bs.OcclusionCones.Allocate(int(pixels*sizeofOcclusionCone), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.LpeStates.Allocate(int(pixels*sizeofLpeState), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.PathMedia.Allocate(int(pixels*sizeofPathMedium), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	err = bs.EmissiveSampleLpeMasks.Allocate(int(pixels*sizeofLpeState), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
 	err = bs.DebugOutput.Allocate(int(pixels*4), cl.MEM_READ_WRITE)
 	if err != nil {
 		return err
 	}
+	err = bs.DebugValues.Allocate(int(pixels*16), cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Resize the light path accumulators so they can hold the specified number of
+// passes (one per expression and light group). As opencl does not support
+// zero-sized buffers, a single placeholder sample is allocated if no passes
+// are defined.
+func (bs *bufferSet) ResizeLightPathAccumulators(frameW, frameH uint32, numPasses int) error {
+	size := int(frameW*frameH) * numPasses * sizeofAccumulatorSample
+	if size == 0 {
+		size = sizeofAccumulatorSample
+	}
+	for _, buf := range []*device.Buffer{bs.TraceLpeAccumulator, bs.FrameLpeAccumulator} {
+		err := buf.Allocate(size, cl.MEM_READ_WRITE)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resize the sample statistics buffers to the given frame dimensions.
+func (bs *bufferSet) ResizeSampleStats(frameW, frameH uint32) error {
+	pixels := int(frameW * frameH)
+	for _, buf := range []*device.Buffer{bs.TraceSampleStats, bs.FrameSampleStats, bs.SampleSnapshot} {
+		err := buf.Allocate(pixels*sizeofAccumulatorSample, cl.MEM_READ_WRITE)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resize the frame accumulator compensation buffer to the given frame dimensions.
+func (bs *bufferSet) ResizeFrameCompensation(frameW, frameH uint32) error {
+	return bs.FrameCompensation.Allocate(int(frameW*frameH)*sizeofAccumulatorSample, cl.MEM_READ_WRITE)
+}
+
+// Resize the bidirectional integrator buffers to the given frame dimensions
+// and the number of light subpath vertices stored for each path.
+func (bs *bufferSet) ResizeLightPaths(frameW, frameH uint32, numLightVertices int) error {
+	pixels := int(frameW * frameH)
+	sizes := map[*device.Buffer]int{
+		bs.LightRays[0]:         pixels * sizeofRay,
+		bs.LightRays[1]:         pixels * sizeofRay,
+		bs.LightRayCounters[0]:  4,
+		bs.LightRayCounters[1]:  4,
+		bs.LightPaths:           pixels * sizeofPath,
+		bs.LightVertices:        pixels * numLightVertices * sizeofLightVertex,
+		bs.ConnectionRays:       pixels * sizeofRay,
+		bs.ConnectionRayCounter: 4,
+		bs.ConnectionHitFlags:   pixels * sizeofHitFlag,
+		bs.ConnectionSamples:    pixels * sizeofEmissiveSample,
+		bs.ConnectionLpeMasks:   pixels * sizeofLpeState,
+		bs.LightPathGroupMasks:  pixels * sizeofLpeState,
+	}
+
+	for buf, size := range sizes {
+		err := buf.Allocate(size, cl.MEM_READ_WRITE)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Upload scene data to the device buffers.
+// Upload scene data to the device buffers. The scene textures are uploaded
+// separately via UploadTextures.
 func (bs *bufferSet) UploadSceneData(scene *scene.Scene) error {
 	var err error
 
+	if len(scene.BvhNodeList) == 0 || len(scene.MeshInstanceList) == 0 {
+		return tracer.WrapError(tracer.ErrSceneInvalid, ErrEmptyScene)
+	}
+
 	targets := map[*device.Buffer]interface{}{
 		bs.BvhNodes:           scene.BvhNodeList,
 		bs.MeshInstances:      scene.MeshInstanceList,
 		bs.MaterialNodes:      scene.MaterialNodeList,
-		bs.Textures:           scene.TextureData,
-		bs.TextureMetadata:    scene.TextureMetadata,
 		bs.Vertices:           scene.VertexList,
 		bs.Normals:            scene.NormalList,
 		bs.UV:                 scene.UvList,
@@ -199,3 +455,139 @@ func (bs *bufferSet) UploadSceneData(scene *scene.Scene) error {
 
 	return nil
 }
+
+// Overwrite the mesh instance, top-level BVH and emissive primitive data of a
+// previously uploaded scene. As an instance update does not change the scene
+// layout, the existing buffers are reused and the remaining scene data stays
+// resident on the device.
+func (bs *bufferSet) UploadInstanceData(update *scene.InstanceUpdate) error {
+	targets := map[*device.Buffer]interface{}{
+		bs.BvhNodes:           update.TopLevelBvhNodes,
+		bs.MeshInstances:      update.MeshInstanceList,
+		bs.EmissivePrimitives: update.EmissivePrimitives,
+	}
+
+	for buf, data := range targets {
+		err := buf.WriteData(data, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Upload texture data and the matching metadata. As the buffers use the host
+// memory for storage, the caller must keep a reference to the slices for as
+// long as the buffers are in use.
+func (bs *bufferSet) UploadTextures(data []byte, metadata []scene.TextureMetadata) error {
+	err := bs.Textures.AllocateAndWriteData(data, cl.MEM_READ_ONLY)
+	if err != nil {
+		return err
+	}
+	return bs.TextureMetadata.AllocateAndWriteData(metadata, cl.MEM_READ_ONLY)
+}
+
+// Upload the volume grids of the scene media and their voxel data. As opencl
+// does not support zero-sized buffers, a single placeholder grid and voxel are
+// uploaded if the scene does not define any volume grids.
+func (bs *bufferSet) UploadVolumes(grids []scene.VolumeGrid, data []float32) error {
+	if len(grids) == 0 {
+		grids, data = []scene.VolumeGrid{{}}, []float32{0}
+	}
+
+	err := bs.VolumeGrids.AllocateAndWriteData(grids, cl.MEM_READ_ONLY)
+	if err != nil {
+		return err
+	}
+	return bs.VolumeData.AllocateAndWriteData(data, cl.MEM_READ_ONLY)
+}
+
+// Upload the compressed scene BVH. As the buffers use the host memory for
+// storage, the caller must keep a reference to the compressed BVH for as long
+// as the buffers are in use. As opencl does not support zero-sized buffers, a
+// single placeholder node and root are uploaded if cb is nil.
+func (bs *bufferSet) UploadCompressedBvh(cb *scene.CompressedBvh) error {
+	nodes, roots := []scene.CompressedBvhNode{{}}, []uint32{0}
+	if cb != nil {
+		nodes, roots = cb.Nodes, cb.MeshRoots
+	}
+
+	err := bs.CompressedBvhNodes.AllocateAndWriteData(nodes, cl.MEM_READ_ONLY)
+	if err != nil {
+		return err
+	}
+	return bs.CompressedBvhRoots.AllocateAndWriteData(roots, cl.MEM_READ_ONLY)
+}
+
+// Overwrite the compressed top-level BVH nodes of a previously uploaded
+// compressed BVH.
+func (bs *bufferSet) UploadCompressedTopLevelBvh(cb *scene.CompressedBvh) error {
+	return bs.CompressedBvhNodes.WriteData(cb.Nodes[:cb.NumTopLevelNodes], 0)
+}
+
+// Upload the block-relative pixel index for each primary ray.
+func (bs *bufferSet) UploadRayPixels(pixels []uint32) error {
+	return bs.RayPixels.AllocateAndWriteData(pixels, cl.MEM_READ_ONLY)
+}
+
+// Upload the lens samples generated from a bokeh mask. As opencl does not
+// support zero-sized buffers, a single placeholder sample is uploaded if the
+// sample list is empty.
+func (bs *bufferSet) UploadBokehSamples(samples []types.Vec2) error {
+	if len(samples) == 0 {
+		samples = []types.Vec2{{0, 0}}
+	}
+	return bs.BokehSamples.AllocateAndWriteData(samples, cl.MEM_READ_ONLY)
+}
+
+// Upload the blue-noise mask used by the blue-noise sampler. As the buffer uses
+// the host memory for storage, the caller must keep a reference to the mask for
+// as long as the buffer is in use.
+func (bs *bufferSet) UploadBlueNoise(mask []types.Vec2) error {
+	return bs.BlueNoise.AllocateAndWriteData(mask, cl.MEM_READ_ONLY)
+}
+
+// Upload the importance sampling distribution for the scene environment light.
+// As opencl does not support zero-sized buffers, a single placeholder value is
+// uploaded if the distribution is empty.
+func (bs *bufferSet) UploadEnvMapDistribution(cdf []float32) error {
+	if len(cdf) == 0 {
+		cdf = []float32{0}
+	}
+	return bs.EnvMapDistribution.AllocateAndWriteData(cdf, cl.MEM_READ_ONLY)
+}
+
+// Upload the per-vertex local occlusion buffer and the inputs for estimating
+// it. As the buffers use the host memory for storage, the caller must keep a
+// reference to the occlusion slice and the targets for as long as the buffers
+// are in use. If targets is nil, only the occlusion buffer is uploaded.
+func (bs *bufferSet) UploadLocalOcclusion(occlusion []float32, targets *scene.LocalOcclusionTargets) error {
+	err := bs.VertexOcclusion.AllocateAndWriteData(occlusion, cl.MEM_READ_WRITE)
+	if err != nil || targets == nil {
+		return err
+	}
+
+	err = bs.OcclusionBvhRoots.AllocateAndWriteData(targets.BvhRoots, cl.MEM_READ_ONLY)
+	if err != nil {
+		return err
+	}
+	return bs.OcclusionRadius.AllocateAndWriteData(targets.Radius, cl.MEM_READ_ONLY)
+}
+
+// Upload the concatenated transition tables of the compiled light path
+// expressions. As the buffer uses the host memory for storage, the caller must
+// keep a reference to the table for as long as the buffer is in use.
+func (bs *bufferSet) UploadLightPathTransitions(transitions []uint8) error {
+	return bs.LpeTransitions.AllocateAndWriteData(transitions, cl.MEM_READ_ONLY)
+}
+
+// Resize the primary hit cache buffers to the given frame dimensions.
+func (bs *bufferSet) ResizePrimaryHits(frameW, frameH uint32) error {
+	pixels := int(frameW * frameH)
+	err := bs.PrimaryHitFlags.Allocate(pixels*sizeofHitFlag, cl.MEM_READ_WRITE)
+	if err != nil {
+		return err
+	}
+	return bs.PrimaryIntersections.Allocate(pixels*sizeofIntersection, cl.MEM_READ_WRITE)
+}
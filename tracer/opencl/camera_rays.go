@@ -0,0 +1,106 @@
+package opencl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The size of a CameraRay in bytes; it matches a pair of float4 values.
+const sizeofCameraRay = 32
+
+// A CameraRay describes the primary ray for a single frame pixel.
+type CameraRay struct {
+	Origin types.Vec3
+
+	// The spread angle (in radians) of the ray cone that is used for
+	// selecting texture mip levels. This is typically the angle between
+	// the rays of adjacent pixels. A zero spread always selects the top
+	// mip level.
+	Spread float32
+
+	// The ray direction; it does not need to be normalized.
+	Dir types.Vec3
+
+	_ float32
+}
+
+// A RayGeneratorFunc fills in the primary rays for the block described by
+// blockReq. The rays slice contains FrameW * BlockH entries; the ray for frame
+// pixel (x, y) is stored at index (y - BlockY) * FrameW + x. The function is
+// invoked each time the tracer generates primary rays (once for each sample
+// per pixel) and may use blockReq.Seed to generate different rays for each
+// sample. Functions must be safe for concurrent use as each tracer invokes
+// them independently.
+type RayGeneratorFunc func(blockReq *tracer.BlockRequest, rays []CameraRay) error
+
+// Use a list of pre-computed camera rays for the primary ray generation
+// stage. The list must contain a ray for each frame pixel stored in row-major
+// order. This stage allows users to implement camera models that are not
+// supported by the built-in camera (e.g. lens simulations or lightfield
+// rendering). The list is uploaded once; as the device may access its contents
+// directly, the caller must not modify it while the pipeline is in use. The
+// WithRayOrder option selects the order in which rays are assigned to the
+// block pixels.
+func RayBufferCamera(rays []CameraRay, opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		if len(rays) != int(blockReq.FrameW*blockReq.FrameH) {
+			return 0, fmt.Errorf("%s: expected %d rays; got %d", ErrCameraRayCount.Error(), blockReq.FrameW*blockReq.FrameH, len(rays))
+		}
+
+		err := tr.stageRes.UploadCameraRays(rays)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tr.stageRes.GenerateCustomRays(blockReq, blockReq.FrameW*blockReq.BlockY, settings.rayOrder)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Since(start), nil
+	}
+}
+
+// Use a Go callback for the primary ray generation stage. The callback is
+// invoked each time the tracer generates primary rays and the generated rays
+// are uploaded to the device. See RayGeneratorFunc for more details. The
+// WithRayOrder option selects the order in which rays are assigned to the
+// block pixels.
+func RayGeneratorCamera(fn RayGeneratorFunc, opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		rays := tr.stageRes.CameraRayScratch(int(blockReq.FrameW * blockReq.BlockH))
+		err := fn(blockReq, rays)
+		if err != nil {
+			return 0, err
+		}
+
+		err = tr.stageRes.WriteBlockCameraRays(rays)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tr.stageRes.GenerateCustomRays(blockReq, 0, settings.rayOrder)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Since(start), nil
+	}
+}
+
+// Returns true if both slices refer to the same list of camera rays.
+func sameCameraRays(a, b []CameraRay) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
@@ -0,0 +1,28 @@
+package opencl
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCameraRayLayout(t *testing.T) {
+	if size := unsafe.Sizeof(CameraRay{}); size != sizeofCameraRay {
+		t.Fatalf("expected sizeof(CameraRay) to be %d; got %d", sizeofCameraRay, size)
+	}
+	if offset := unsafe.Offsetof(CameraRay{}.Dir); offset != 16 {
+		t.Fatalf("expected the ray direction to be stored at offset 16; got %d", offset)
+	}
+}
+
+func TestSameCameraRays(t *testing.T) {
+	rays := make([]CameraRay, 4)
+	if !sameCameraRays(rays, rays) {
+		t.Fatal("expected a ray list to match itself")
+	}
+	if sameCameraRays(rays, make([]CameraRay, 4)) {
+		t.Fatal("expected ray lists with different backing arrays not to match")
+	}
+	if sameCameraRays(rays, rays[:2]) {
+		t.Fatal("expected ray lists with different lengths not to match")
+	}
+}
@@ -0,0 +1,299 @@
+package opencl
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// The percentile of the frame values that is mapped to white by the normalize
+// debug mapping.
+const debugNormalizePercentile = 0.99
+
+// The min value (relative to the max value) that is visualized by the log
+// debug mapping.
+const debugLogMinRatio = 1e-6
+
+// The height (in unscaled pixels) of the gradient bar in debug image legends.
+const debugLegendBarHeight = 8
+
+// A DebugSink receives the debug images generated by the pipeline stages when
+// debug flags are enabled.
+type DebugSink interface {
+	// Write a debug image. The name identifies the pipeline stage that
+	// generated the image and, for per-bounce images, the bounce number
+	// (e.g. "throughput-002").
+	WriteDebugImage(name string, im image.Image) error
+}
+
+// A DebugSink that writes debug images as PNG files. Each image is written to
+// a file named "debug-$name.png" inside the sink directory.
+type FileDebugSink struct {
+	// The output directory for the debug images. If empty, images are
+	// written to the current working directory.
+	Dir string
+}
+
+// Implements DebugSink.
+func (s *FileDebugSink) WriteDebugImage(name string, im image.Image) error {
+	if name == "" {
+		return fmt.Errorf("%s: missing debug image name", ErrInvalidDebugOutput.Error())
+	} else if im == nil {
+		return fmt.Errorf("%s: missing debug image data for %q", ErrInvalidDebugOutput.Error(), name)
+	}
+
+	if s.Dir != "" {
+		err := os.MkdirAll(s.Dir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writePNG(filepath.Join(s.Dir, "debug-"+name+".png"), im)
+}
+
+// Get the debug sink for the pipeline. If no sink is defined, debug images are
+// written to the current working directory.
+func (p *Pipeline) debugSink() DebugSink {
+	if p.DebugSink != nil {
+		return p.DebugSink
+	}
+
+	return &FileDebugSink{}
+}
+
+// Read back the debug buffer contents and send them to the pipeline debug
+// sink. If debugKernelError is not nil, it is returned without reading the
+// debug buffer.
+func dumpDebugBuffer(debugKernelError error, tr *Tracer, blockReq *tracer.BlockRequest, name string) error {
+	if debugKernelError != nil {
+		return debugKernelError
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, int(blockReq.FrameW), int(blockReq.FrameH)))
+	if len(im.Pix) == 0 {
+		return fmt.Errorf("%s: empty frame", ErrInvalidDebugOutput.Error())
+	}
+
+	err := tr.stageRes.ReadDebugOutput(blockReq, im.Pix)
+	if err != nil {
+		return err
+	}
+
+	return tr.pipeline.debugSink().WriteDebugImage(name, im)
+}
+
+// Read back the raw values generated by a debug kernel, map them to colors and
+// send the resulting image to the pipeline debug sink. If debugKernelError is
+// not nil, it is returned without reading the debug values.
+func dumpDebugValues(debugKernelError error, tr *Tracer, blockReq *tracer.BlockRequest, name string, mapping DebugMapping, palette DebugPalette) error {
+	if debugKernelError != nil {
+		return debugKernelError
+	}
+
+	frameW, frameH := int(blockReq.FrameW), int(blockReq.FrameH)
+	if frameW*frameH == 0 {
+		return fmt.Errorf("%s: empty frame", ErrInvalidDebugOutput.Error())
+	}
+
+	values := make([]float32, frameW*frameH*4)
+	err := tr.stageRes.ReadDebugValues(blockReq, values)
+	if err != nil {
+		return err
+	}
+
+	return tr.pipeline.debugSink().WriteDebugImage(name, mapDebugValues(values, frameW, frameH, mapping, palette))
+}
+
+// Map per-pixel scalar values to a heatmap image that uses the same mapping,
+// palette and legend as the debug images. This is useful for visualizing
+// statistics that are calculated on the host.
+func DebugHeatmap(values []float32, frameW, frameH int, mapping DebugMapping, palette DebugPalette) *image.RGBA {
+	rgba := make([]float32, len(values)*4)
+	for pixel, v := range values {
+		rgba[pixel*4], rgba[pixel*4+1], rgba[pixel*4+2], rgba[pixel*4+3] = v, v, v, 1
+	}
+	return mapDebugValues(rgba, frameW, frameH, mapping, palette)
+}
+
+// The range of the values visualized by a debug image.
+type debugValueRange struct {
+	min, max float64
+}
+
+// Map the raw RGBA values generated by a debug kernel to an RGBA image. Pixels
+// with a zero alpha component contain no data and are rendered black. With the
+// gray palette each channel is mapped separately; other palettes render a
+// heatmap of the max channel value. A legend with a gradient of the mapped
+// range is appended below the frame.
+func mapDebugValues(values []float32, frameW, frameH int, mapping DebugMapping, palette DebugPalette) *image.RGBA {
+	valRange := debugRange(values, mapping)
+
+	scale := frameH / overlayTextRefHeight
+	if scale < 1 {
+		scale = 1
+	}
+	minLabel, maxLabel := mapping.legendLabels(valRange)
+	labels := [3]*image.Alpha{
+		renderOverlayText(minLabel, scale),
+		renderOverlayText(mapping.String(), scale),
+		renderOverlayText(maxLabel, scale),
+	}
+	barH := debugLegendBarHeight * scale
+	legendH := barH + labels[0].Bounds().Dy()
+
+	im := image.NewRGBA(image.Rect(0, 0, frameW, frameH+legendH))
+	draw.Draw(im, im.Bounds(), image.Black, image.Point{}, draw.Src)
+
+	for pixel := 0; pixel < frameW*frameH; pixel++ {
+		if values[pixel*4+3] == 0 {
+			continue
+		}
+		if palette != GrayDebugPalette {
+			v := math.Max(math.Max(float64(values[pixel*4]), float64(values[pixel*4+1])), float64(values[pixel*4+2]))
+			im.SetRGBA(pixel%frameW, pixel/frameW, palette.color(mapping.toDisplay(v, valRange)))
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			im.Pix[pixel*4+c] = debugToByte(mapping.toDisplay(float64(values[pixel*4+c]), valRange))
+		}
+	}
+
+	// Draw the legend gradient and the range labels
+	gradientW := math.Max(float64(frameW-1), 1)
+	for x := 0; x < frameW; x++ {
+		c := palette.color(mapping.toDisplay(mapping.legendValue(float64(x)/gradientW, valRange), valRange))
+		draw.Draw(im, image.Rect(x, frameH, x+1, frameH+barH), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	labelY := frameH + barH
+	for index, label := range labels {
+		size := label.Bounds().Size()
+		x := [3]int{0, (frameW - size.X) / 2, frameW - size.X}[index]
+		draw.DrawMask(im, image.Rect(x, labelY, x+size.X, labelY+size.Y), image.White, image.Point{}, label, image.Point{}, draw.Over)
+	}
+
+	return im
+}
+
+// Calculate the range of the finite values of the pixels that contain data.
+// The max value of the normalize mapping is the configured percentile of the
+// max pixel component while the min value of the log mapping is the smallest
+// non-zero component.
+func debugRange(values []float32, mapping DebugMapping) debugValueRange {
+	var pixelMax []float64
+	minPositive := math.Inf(1)
+	for offset := 0; offset+3 < len(values); offset += 4 {
+		if values[offset+3] == 0 {
+			continue
+		}
+
+		maxC := 0.0
+		for _, v := range values[offset : offset+3] {
+			if fv := float64(v); !math.IsNaN(fv) && !math.IsInf(fv, 0) {
+				maxC = math.Max(maxC, fv)
+				if fv > 0 {
+					minPositive = math.Min(minPositive, fv)
+				}
+			}
+		}
+		pixelMax = append(pixelMax, maxC)
+	}
+
+	valRange := debugValueRange{max: 1}
+	if len(pixelMax) == 0 {
+		return valRange
+	}
+	sort.Float64s(pixelMax)
+
+	switch mapping {
+	case NormalizeDebugMapping:
+		valRange.max = pixelMax[int(debugNormalizePercentile*float64(len(pixelMax)-1))]
+		if valRange.max == 0 {
+			valRange.max = pixelMax[len(pixelMax)-1]
+		}
+	case LogDebugMapping:
+		valRange.max = pixelMax[len(pixelMax)-1]
+		valRange.min = math.Max(minPositive, valRange.max*debugLogMinRatio)
+	}
+	if valRange.max <= 0 {
+		valRange.max = 1
+	}
+	if valRange.min >= valRange.max {
+		valRange.min = valRange.max * debugLogMinRatio
+	}
+	return valRange
+}
+
+// Map a raw value to the [0, 1] display range.
+func (m DebugMapping) toDisplay(v float64, valRange debugValueRange) float64 {
+	if math.IsNaN(v) || v <= 0 {
+		return 0
+	} else if math.IsInf(v, 1) {
+		return 1
+	}
+
+	switch m {
+	case LogDebugMapping:
+		if v <= valRange.min {
+			return 0
+		}
+		return math.Min(math.Log10(v/valRange.min)/math.Log10(valRange.max/valRange.min), 1)
+	case TonemapDebugMapping:
+		return math.Pow(v/(v+1), 1.0/2.2)
+	}
+	return math.Pow(math.Min(v/valRange.max, 1), 1.0/2.2)
+}
+
+// Get the raw value that corresponds to position f (in the [0, 1] range) of
+// the legend gradient.
+func (m DebugMapping) legendValue(f float64, valRange debugValueRange) float64 {
+	switch m {
+	case LogDebugMapping:
+		return valRange.min * math.Pow(valRange.max/valRange.min, f)
+	case TonemapDebugMapping:
+		if f >= 1 {
+			return math.Inf(1)
+		}
+		return f / (1 - f)
+	}
+	return f * valRange.max
+}
+
+// Get the labels for the min and max values of the legend gradient.
+func (m DebugMapping) legendLabels(valRange debugValueRange) (string, string) {
+	switch m {
+	case LogDebugMapping:
+		return formatDebugValue(valRange.min), formatDebugValue(valRange.max)
+	case TonemapDebugMapping:
+		return "0", "inf"
+	}
+	return "0", formatDebugValue(valRange.max)
+}
+
+// Format a legend value using 3 significant digits.
+func formatDebugValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 3, 64)
+}
+
+// Convert a value in the [0, 1] range to a byte.
+func debugToByte(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(v, 1))*255 + 0.5)
+}
+
+// Read the value of a ray counter.
+func readCounter(dr *deviceResources, counterIndex uint32) (uint32, error) {
+	if int(counterIndex) >= len(dr.buffers.RayCounters) {
+		return 0, fmt.Errorf("%s: invalid ray counter index %d", ErrInvalidDebugOutput.Error(), counterIndex)
+	}
+
+	out := make([]uint32, 1)
+	err := dr.buffers.RayCounters[counterIndex].ReadData(0, 0, 4, out)
+	return out[0], err
+}
@@ -0,0 +1,161 @@
+package opencl
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileDebugSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &FileDebugSink{Dir: filepath.Join(dir, "out")}
+	err = sink.WriteDebugImage("throughput-001", image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "out", "debug-throughput-001.png"))
+	if err != nil {
+		t.Fatalf("expected debug image to be written; got %v", err)
+	}
+
+	err = sink.WriteDebugImage("", image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	if err == nil {
+		t.Fatal("expected an error when writing a debug image without a name")
+	}
+
+	err = sink.WriteDebugImage("fb", nil)
+	if err == nil {
+		t.Fatal("expected an error when writing a nil debug image")
+	}
+}
+
+func TestPipelineDefaultDebugSink(t *testing.T) {
+	p := &Pipeline{}
+	if _, isFileSink := p.debugSink().(*FileDebugSink); !isFileSink {
+		t.Fatalf("expected default debug sink to be a *FileDebugSink; got %T", p.debugSink())
+	}
+
+	sink := &FileDebugSink{Dir: "foo"}
+	p.DebugSink = sink
+	if p.debugSink() != sink {
+		t.Fatal("expected pipeline to use the supplied debug sink")
+	}
+}
+
+func TestMapDebugValues(t *testing.T) {
+	// A 4x1 frame with a pixel without data and a firefly
+	values := []float32{
+		0, 0, 0, 0,
+		0.5, 0.5, 0.5, 1,
+		1, 0.25, 0, 1,
+		1000, 1000, 1000, 1,
+	}
+
+	specs := []struct {
+		mapping  DebugMapping
+		expRange debugValueRange
+	}{
+		// The 99th percentile of the 3 pixels with data is the second brightest pixel
+		{NormalizeDebugMapping, debugValueRange{0, 1}},
+		{LogDebugMapping, debugValueRange{0.25, 1000}},
+		{TonemapDebugMapping, debugValueRange{0, 1}},
+	}
+
+	for index, spec := range specs {
+		if got := debugRange(values, spec.mapping); got != spec.expRange {
+			t.Errorf("[spec %d] expected %s range to be %v; got %v", index, spec.mapping, spec.expRange, got)
+			continue
+		}
+
+		im := mapDebugValues(values, 4, 1, spec.mapping, GrayDebugPalette)
+		if im.Bounds().Dx() != 4 || im.Bounds().Dy() <= 1 {
+			t.Errorf("[spec %d] expected a 4 pixel wide image with a legend; got %v", index, im.Bounds())
+			continue
+		}
+
+		if got := im.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("[spec %d] expected pixels without data to be black; got %v", index, got)
+		}
+		if got := im.RGBAAt(3, 0); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("[spec %d] expected the firefly to map to white; got %v", index, got)
+		}
+
+		// Values are mapped monotonically
+		px := im.RGBAAt(2, 0)
+		if px.R <= px.G || px.G < px.B {
+			t.Errorf("[spec %d] expected mapped channels to preserve the value order; got %v", index, px)
+		}
+	}
+
+	// The log mapping visualizes the values in the [0.25, 1000] range
+	im := mapDebugValues(values, 4, 1, LogDebugMapping, GrayDebugPalette)
+	if got := im.RGBAAt(2, 0); got.B != 0 || got.G != 0 || got.R == 0 {
+		t.Errorf("expected the min value to map to black and 1 to a dim color; got %v", got)
+	}
+
+	// The normalize mapping clips values above the percentile
+	im = mapDebugValues(values, 4, 1, NormalizeDebugMapping, GrayDebugPalette)
+	if got := im.RGBAAt(2, 0).R; got != 255 {
+		t.Errorf("expected the percentile value to map to white; got %d", got)
+	}
+}
+
+func TestMapDebugValuesWithoutData(t *testing.T) {
+	im := mapDebugValues(make([]float32, 8), 2, 1, LogDebugMapping, ViridisDebugPalette)
+	for x := 0; x < 2; x++ {
+		if got := im.RGBAAt(x, 0); got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("expected pixel %d to be black; got %v", x, got)
+		}
+	}
+}
+
+func TestMapDebugValuesWithPalette(t *testing.T) {
+	// A 3x1 heatmap with a pixel without data
+	values := []float32{
+		0, 0, 0, 0,
+		1, 1, 1, 1,
+		4, 4, 4, 1,
+	}
+
+	im := mapDebugValues(values, 3, 1, LogDebugMapping, ViridisDebugPalette)
+	if got := im.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("expected pixels without data to be black; got %v", got)
+	}
+	if got, exp := im.RGBAAt(2, 0), viridisPalette[len(viridisPalette)-1]; got != exp {
+		t.Errorf("expected the max value to map to the last palette color %v; got %v", exp, got)
+	}
+	if got, exp := im.RGBAAt(1, 0), viridisPalette[0]; got != exp {
+		t.Errorf("expected the min value to map to the first palette color %v; got %v", exp, got)
+	}
+
+	// The legend gradient starts and ends with the palette endpoints
+	if got, exp := im.RGBAAt(0, 1), ViridisDebugPalette.color(0); got != exp {
+		t.Errorf("expected the legend to start with %v; got %v", exp, got)
+	}
+	if got, exp := im.RGBAAt(2, 1), ViridisDebugPalette.color(1); got != exp {
+		t.Errorf("expected the legend to end with %v; got %v", exp, got)
+	}
+}
+
+func TestDebugHeatmap(t *testing.T) {
+	values := []float32{1, 4}
+	im := DebugHeatmap(values, 2, 1, LogDebugMapping, MagmaDebugPalette)
+	if got, exp := im.RGBAAt(0, 0), magmaPalette[0]; got != exp {
+		t.Errorf("expected the min value to map to the first palette color %v; got %v", exp, got)
+	}
+	if got, exp := im.RGBAAt(1, 0), magmaPalette[len(magmaPalette)-1]; got != exp {
+		t.Errorf("expected the max value to map to the last palette color %v; got %v", exp, got)
+	}
+	if im.Bounds().Dy() <= 1 {
+		t.Errorf("expected the heatmap to include a legend")
+	}
+}
@@ -29,21 +29,27 @@ func (b *Buffer) Size() int {
 
 // Allocate a buffer with the given size and flags.
 func (b *Buffer) Allocate(size int, flags cl.MemFlags) error {
-	var errPtr *int32
+	var errCode int32
 
 	// If the buffer is alreay allocated release it
 	b.Release()
 
+	// Opencl does not support zero-sized buffers
+	if size == 0 {
+		b.size = 0
+		return nil
+	}
+
 	b.bufHandle = cl.CreateBuffer(
 		*b.device.ctx,
 		flags,
 		cl.MemFlags(size),
 		nil,
-		errPtr,
+		&errCode,
 	)
 
-	if errPtr != nil && cl.ErrorCode(*errPtr) != cl.SUCCESS {
-		return fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, size, cl.ErrorCode(*errPtr))
+	if cl.ErrorCode(errCode) != cl.SUCCESS {
+		return wrapError(memoryErrorKind(cl.ErrorCode(errCode)), fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, size, errCode))
 	}
 
 	b.size = size
@@ -53,7 +59,7 @@ func (b *Buffer) Allocate(size int, flags cl.MemFlags) error {
 
 // Allocate a buffer with enough capacity to fit the given data.
 func (b *Buffer) AllocateToFitData(data interface{}, flags cl.MemFlags) error {
-	var errPtr *int32
+	var errCode int32
 
 	// If the buffer is alreay allocated release it
 	b.Release()
@@ -68,11 +74,11 @@ func (b *Buffer) AllocateToFitData(data interface{}, flags cl.MemFlags) error {
 		flags,
 		cl.MemFlags(dataLen),
 		nil,
-		errPtr,
+		&errCode,
 	)
 
-	if errPtr != nil && cl.ErrorCode(*errPtr) != cl.SUCCESS {
-		return fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, dataLen, cl.ErrorCode(*errPtr))
+	if cl.ErrorCode(errCode) != cl.SUCCESS {
+		return wrapError(memoryErrorKind(cl.ErrorCode(errCode)), fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, dataLen, errCode))
 	}
 
 	b.size = dataLen
@@ -85,7 +91,7 @@ func (b *Buffer) AllocateToFitData(data interface{}, flags cl.MemFlags) error {
 // undefined if a non-slice argument is passed or the argument does not use contiguous
 // memory.
 func (b *Buffer) AllocateAndWriteData(data interface{}, flags cl.MemFlags) error {
-	var errPtr *int32
+	var errCode int32
 
 	// If the buffer is alreay allocated release it
 	b.Release()
@@ -100,11 +106,11 @@ func (b *Buffer) AllocateAndWriteData(data interface{}, flags cl.MemFlags) error
 		flags|cl.MEM_USE_HOST_PTR,
 		cl.MemFlags(dataLen),
 		dataPtr,
-		errPtr,
+		&errCode,
 	)
 
-	if errPtr != nil && cl.ErrorCode(*errPtr) != cl.SUCCESS {
-		return fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, dataLen, cl.ErrorCode(*errPtr))
+	if cl.ErrorCode(errCode) != cl.SUCCESS {
+		return wrapError(memoryErrorKind(cl.ErrorCode(errCode)), fmt.Errorf("opencl device (%s): could not allocate buffer %s of size %d (errCode %d)", b.device.Name, b.name, dataLen, errCode))
 	}
 
 	b.size = dataLen
@@ -138,7 +144,7 @@ func (b *Buffer) WriteData(data interface{}, offset int) error {
 	)
 
 	if errCode != cl.SUCCESS {
-		return fmt.Errorf("opencl device(%s): error copying host data to device buffer %s (errCode %d)", b.device.Name, b.name, errCode)
+		return wrapError(memoryErrorKind(errCode), fmt.Errorf("opencl device(%s): error copying host data to device buffer %s (errCode %d)", b.device.Name, b.name, errCode))
 	}
 
 	return nil
@@ -151,11 +157,22 @@ func (b *Buffer) WriteData(data interface{}, offset int) error {
 // If size is <= 0 then ReadData will read the entire bufer. Both src and dst
 // offsets are specified in bytes.
 func (b *Buffer) ReadData(srcOffset, dstOffset, size int, hostBuffer interface{}) error {
+	if b.bufHandle == nil {
+		return fmt.Errorf("opencl device(%s): cannot read from unallocated buffer %s", b.device.Name, b.name)
+	} else if reflect.ValueOf(hostBuffer).Kind() != reflect.Slice {
+		return fmt.Errorf("opencl device(%s): host buffer for reading %s must be a slice; got %T", b.device.Name, b.name, hostBuffer)
+	}
+
 	if size <= 0 {
-		size = b.size
+		size = b.size - srcOffset
 	}
 
-	dataPtr, _ := getSliceData(hostBuffer)
+	dataPtr, dataLen := getSliceData(hostBuffer)
+	if srcOffset < 0 || srcOffset+size > b.size {
+		return fmt.Errorf("opencl device(%s): cannot read %d bytes at offset %d from buffer %s of size %d", b.device.Name, size, srcOffset, b.name, b.size)
+	} else if dstOffset < 0 || dstOffset+size > dataLen {
+		return fmt.Errorf("opencl device(%s): insufficient host buffer space (%d) for reading %d bytes from %s at offset %d", b.device.Name, dataLen, size, b.name, dstOffset)
+	}
 
 	errCode := cl.EnqueueReadBuffer(
 		b.device.cmdQueue,
@@ -170,7 +187,7 @@ func (b *Buffer) ReadData(srcOffset, dstOffset, size int, hostBuffer interface{}
 	)
 
 	if errCode != cl.SUCCESS {
-		return fmt.Errorf("opencl device(%s): error copying device data from %s to host buffer (errCode %d)", b.device.Name, b.name, errCode)
+		return wrapError(memoryErrorKind(errCode), fmt.Errorf("opencl device(%s): error copying device data from %s to host buffer (errCode %d)", b.device.Name, b.name, errCode))
 	}
 
 	return nil
@@ -178,16 +195,20 @@ func (b *Buffer) ReadData(srcOffset, dstOffset, size int, hostBuffer interface{}
 
 // Read all data from device buffer into a slice of the given type. This method
 // will allocate a new slice with enough capacity to fit the buffer data and
-// will panic if the buffer size is not a multiple of the slice element size.
+// will return an error if the buffer size is not a multiple of the slice
+// element size.
 func (b *Buffer) ReadDataIntoSlice(sliceType interface{}) (interface{}, error) {
 	reflType := reflect.TypeOf(sliceType)
 	sliceItemSize := int(reflType.Elem().Size())
-	if b.Size()%sliceItemSize != 0 {
-		panic(fmt.Sprintf("buffer: bufSize (%d) %% sliceItemSize(%d) != 0", b.Size(), sliceItemSize))
+	if sliceItemSize == 0 || b.Size()%sliceItemSize != 0 {
+		return nil, fmt.Errorf("opencl device(%s): size of buffer %s (%d) is not a multiple of the slice item size (%d)", b.device.Name, b.name, b.Size(), sliceItemSize)
 	}
 
 	numElements := b.Size() / sliceItemSize
 	slice := reflect.MakeSlice(reflType, numElements, numElements).Interface()
+	if numElements == 0 {
+		return slice, nil
+	}
 	return slice, b.ReadData(0, 0, b.Size(), slice)
 }
 
@@ -218,7 +239,7 @@ func (b *Buffer) CopyDataFrom(src *Buffer, srcOffset, dstOffset, size int) error
 	)
 
 	if errCode != cl.SUCCESS {
-		return fmt.Errorf("opencl device(%s): error copying device data from buffer %s to buffer %s (errCode %d)", b.device.Name, src.name, b.name, errCode)
+		return wrapError(memoryErrorKind(errCode), fmt.Errorf("opencl device(%s): error copying device data from buffer %s to buffer %s (errCode %d)", b.device.Name, src.name, b.name, errCode))
 	}
 	return nil
 }
@@ -282,7 +282,7 @@ func createCpuDevice() (*Device, error) {
 	}
 
 	dev := devList[0]
-	err = dev.Init("test.cl")
+	err = dev.Init("test.cl", nil)
 	if err != nil {
 		return nil, err
 	}
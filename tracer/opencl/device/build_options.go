@@ -0,0 +1,84 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidBuildOption = errors.New("opencl device: invalid build option")
+
+	defineNameRegex = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+)
+
+// Additional options for building the opencl program of a device.
+type BuildOptions struct {
+	// Preprocessor defines passed to the compiler. Defines with an empty
+	// value are passed as "-D NAME".
+	Defines map[string]string
+
+	// Compiler flags (e.g. -cl-fast-relaxed-math).
+	Flags []string
+}
+
+// Check that the define names are valid identifiers and that the flags are
+// single compiler options.
+func (o BuildOptions) Validate() error {
+	for name, value := range o.Defines {
+		if !defineNameRegex.MatchString(name) {
+			return fmt.Errorf("%s: invalid define name %q", ErrInvalidBuildOption.Error(), name)
+		}
+		if strings.ContainsAny(value, " \t\n\"'") {
+			return fmt.Errorf("%s: value %q of define %s must not contain whitespace or quotes", ErrInvalidBuildOption.Error(), value, name)
+		}
+	}
+
+	for _, flag := range o.Flags {
+		if !strings.HasPrefix(flag, "-") || strings.ContainsAny(flag, " \t\n\"'") {
+			return fmt.Errorf("%s: invalid compiler flag %q", ErrInvalidBuildOption.Error(), flag)
+		}
+	}
+	return nil
+}
+
+// Create a copy of the options with the defines and flags of other appended to
+// them. Defines in other override defines with the same name.
+func (o BuildOptions) Merge(other BuildOptions) BuildOptions {
+	merged := BuildOptions{
+		Flags: append(append([]string(nil), o.Flags...), other.Flags...),
+	}
+	if len(o.Defines)+len(other.Defines) != 0 {
+		merged.Defines = make(map[string]string, len(o.Defines)+len(other.Defines))
+		for name, value := range o.Defines {
+			merged.Defines[name] = value
+		}
+		for name, value := range other.Defines {
+			merged.Defines[name] = value
+		}
+	}
+	return merged
+}
+
+// Format the options as a compiler option string. Defines are sorted by name
+// so that the output is deterministic.
+func (o BuildOptions) String() string {
+	names := make([]string, 0, len(o.Defines))
+	for name := range o.Defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	opts := make([]string, 0, len(names)+len(o.Flags))
+	for _, name := range names {
+		if value := o.Defines[name]; value != "" {
+			opts = append(opts, fmt.Sprintf("-D %s=%s", name, value))
+		} else {
+			opts = append(opts, "-D "+name)
+		}
+	}
+	opts = append(opts, o.Flags...)
+	return strings.Join(opts, " ")
+}
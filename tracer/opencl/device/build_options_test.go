@@ -0,0 +1,57 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildOptions(t *testing.T) {
+	opts := BuildOptions{
+		Defines: map[string]string{"BVH_MAX_STACK_SIZE": "64", "DEBUG": ""},
+		Flags:   []string{"-cl-fast-relaxed-math"},
+	}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "-D BVH_MAX_STACK_SIZE=64 -D DEBUG -cl-fast-relaxed-math"
+	if got := opts.String(); got != exp {
+		t.Fatalf("expected options string to be %q; got %q", exp, got)
+	}
+
+	merged := opts.Merge(BuildOptions{
+		Defines: map[string]string{"BVH_MAX_STACK_SIZE": "48"},
+		Flags:   []string{"-cl-mad-enable"},
+	})
+	exp = "-D BVH_MAX_STACK_SIZE=48 -D DEBUG -cl-fast-relaxed-math -cl-mad-enable"
+	if got := merged.String(); got != exp {
+		t.Fatalf("expected merged options string to be %q; got %q", exp, got)
+	}
+	if opts.Defines["BVH_MAX_STACK_SIZE"] != "64" || len(opts.Flags) != 1 {
+		t.Fatalf("expected merge not to modify the original options; got %+v", opts)
+	}
+
+	if got := (BuildOptions{}).String(); got != "" {
+		t.Fatalf("expected empty options string; got %q", got)
+	}
+}
+
+func TestBuildOptionsValidate(t *testing.T) {
+	specs := []struct {
+		opts   BuildOptions
+		expErr string
+	}{
+		{BuildOptions{Defines: map[string]string{"1FOO": "1"}}, "invalid define name"},
+		{BuildOptions{Defines: map[string]string{"FOO BAR": ""}}, "invalid define name"},
+		{BuildOptions{Defines: map[string]string{"FOO": "1 -D BAR"}}, "must not contain whitespace"},
+		{BuildOptions{Flags: []string{"cl-fast-relaxed-math"}}, "invalid compiler flag"},
+		{BuildOptions{Flags: []string{"-cl-mad-enable -w"}}, "invalid compiler flag"},
+	}
+
+	for index, spec := range specs {
+		err := spec.opts.Validate()
+		if err == nil || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[spec %d] expected error containing %q; got %v", index, spec.expErr, err)
+		}
+	}
+}
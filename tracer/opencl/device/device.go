@@ -6,9 +6,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"unsafe"
 
 	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/tracer"
 )
 
 type DeviceType uint8
@@ -21,10 +23,50 @@ const (
 	AllDevices             = 0xFF
 )
 
+type Implementation uint8
+
+// Supported opencl implementations.
+const (
+	// A vendor-provided implementation.
+	NativeImpl Implementation = iota
+
+	// The pocl (portable computing language) software implementation.
+	PoclImpl
+
+	// The oclgrind device simulator.
+	OclgrindImpl
+)
+
 var (
 	indentRegex = regexp.MustCompile("(?m)^")
 )
 
+func (impl Implementation) String() string {
+	switch impl {
+	case NativeImpl:
+		return "native"
+	case PoclImpl:
+		return "pocl"
+	case OclgrindImpl:
+		return "oclgrind"
+	}
+	panic("opencl: unsupported implementation")
+}
+
+// Detect software opencl implementations by examining the platform and device names.
+func detectImplementation(platformName, platformVendor, deviceName string) Implementation {
+	switch {
+	case strings.Contains(platformName, "Oclgrind") || strings.Contains(deviceName, "Oclgrind"):
+		return OclgrindImpl
+	case strings.Contains(platformName, "Portable Computing Language") ||
+		strings.Contains(platformVendor, "pocl") ||
+		strings.HasPrefix(deviceName, "pthread") ||
+		strings.HasPrefix(deviceName, "cpu-"):
+		return PoclImpl
+	}
+	return NativeImpl
+}
+
 func (dt DeviceType) String() string {
 	switch dt {
 	case CpuDevice:
@@ -43,16 +85,37 @@ type Device struct {
 	Id   cl.DeviceId
 	Type DeviceType
 
+	// The opencl implementation that provides this device.
+	Impl Implementation
+
 	compUnits  uint32
 	clockSpeed uint32
 
 	// Speed estimate in GFlops.
 	Speed uint32
 
+	// The index of the device among devices with the same name; used
+	// for generating device lock keys.
+	ordinal int
+
 	// Opencl handles; allocated when device is initialized.
 	ctx      *cl.Context
 	cmdQueue cl.CommandQueue
 	program  cl.Program
+
+	// The source for thermal readings; selected on first use.
+	thermals thermalSource
+}
+
+// Check whether the device is emulated in software (e.g. pocl or oclgrind).
+func (d *Device) IsSoftware() bool {
+	return d.Impl != NativeImpl
+}
+
+// Check whether the device is a simulator intended for debugging kernels
+// rather than rendering (e.g. oclgrind).
+func (d *Device) IsSimulator() bool {
+	return d.Impl == OclgrindImpl
 }
 
 // A list of devices.
@@ -61,9 +124,10 @@ type DeviceList []Device
 // Implements Stringer.
 func (d Device) String() string {
 	return fmt.Sprintf(
-		"Name: %s\nType: %s\nSpecs: %d computation units, %d Mhz clock, %d GFlops approximate speed",
+		"Name: %s\nType: %s\nImplementation: %s\nSpecs: %d computation units, %d Mhz clock, %d GFlops approximate speed",
 		d.Name,
 		d.Type.String(),
+		d.Impl.String(),
 		d.compUnits,
 		d.clockSpeed,
 		d.Speed,
@@ -72,6 +136,12 @@ func (d Device) String() string {
 
 // Initialize device.
 func (d *Device) Init(programFile string, ctx *cl.Context) error {
+	return d.InitWithOptions(programFile, ctx, BuildOptions{})
+}
+
+// Initialize device and build its program using a set of additional build
+// options.
+func (d *Device) InitWithOptions(programFile string, ctx *cl.Context, opts BuildOptions) error {
 	var errCode cl.ErrorCode
 
 	// Already initialized
@@ -127,14 +197,24 @@ func (d *Device) Init(programFile string, ctx *cl.Context) error {
 	)
 	if errCode != cl.SUCCESS {
 		defer d.Close()
-		return fmt.Errorf("opencl device (%s): could not create program (error: %s; code %d)", d.Name, ErrorName(errCode), errCode)
+		return tracer.WrapError(tracer.ErrKernelBuild, fmt.Errorf("opencl device (%s): could not create program (error: %s; code %d)", d.Name, ErrorName(errCode), errCode))
+	}
+
+	if err = opts.Validate(); err != nil {
+		defer d.Close()
+		return tracer.WrapError(tracer.ErrKernelBuild, err)
+	}
+
+	buildOpts := fmt.Sprintf("-I %s", filepath.Dir(absProgramPath))
+	if extraOpts := opts.String(); extraOpts != "" {
+		buildOpts += " " + extraOpts
 	}
 
 	errCode = cl.BuildProgram(
 		d.program,
 		1,
 		&d.Id,
-		cl.Str(fmt.Sprintf("-I %s\x00", filepath.Dir(absProgramPath))),
+		cl.Str(buildOpts+"\x00"),
 		nil,
 		nil,
 	)
@@ -144,7 +224,7 @@ func (d *Device) Init(programFile string, ctx *cl.Context) error {
 
 		cl.GetProgramBuildInfo(d.program, d.Id, cl.PROGRAM_BUILD_LOG, uint64(len(data)), unsafe.Pointer(&data[0]), &dataLen)
 		defer d.Close()
-		return fmt.Errorf("opencl device (%s): could not build kernel (error: %s; code %d):\n%s", d.Name, ErrorName(errCode), errCode, string(data[0:dataLen-1]))
+		return tracer.WrapError(tracer.ErrKernelBuild, fmt.Errorf("opencl device (%s): could not build kernel using options %q (error: %s; code %d):\n%s", d.Name, buildOpts, ErrorName(errCode), errCode, string(data[0:dataLen-1])))
 	}
 
 	return nil
@@ -178,7 +258,7 @@ func (d *Device) Kernel(name string) (*Kernel, error) {
 	)
 
 	if errCode != cl.SUCCESS {
-		return nil, fmt.Errorf("opencl device (%s): could not load kernel %s (error: %s; code %d)", d.Name, name, ErrorName(errCode), errCode)
+		return nil, tracer.WrapError(tracer.ErrKernelBuild, fmt.Errorf("opencl device (%s): could not load kernel %s (error: %s; code %d)", d.Name, name, ErrorName(errCode), errCode))
 	}
 
 	return &Kernel{
@@ -200,7 +280,7 @@ func (d *Device) Buffer(name string) *Buffer {
 func (d *Device) WaitForKernels() error {
 	errCode := cl.Finish(d.cmdQueue)
 	if errCode != cl.SUCCESS {
-		return fmt.Errorf("opencl device (%s): wait for kernels failed (error: %s; code: %d)", d.Name, ErrorName(errCode), errCode)
+		return wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): wait for kernels failed (error: %s; code: %d)", d.Name, ErrorName(errCode), errCode))
 	}
 	return nil
 }
@@ -221,6 +301,17 @@ func (d *Device) detectSpeed() error {
 	return nil
 }
 
+// Check whether the device uses little-endian byte ordering.
+func (d *Device) IsLittleEndian() (bool, error) {
+	var littleEndian uint32
+	errCode := cl.GetDeviceInfo(d.Id, cl.DEVICE_ENDIAN_LITTLE, 4, unsafe.Pointer(&littleEndian), nil)
+	if errCode != cl.SUCCESS {
+		return false, fmt.Errorf("opencl device (%s): could not query ENDIAN_LITTLE (error: %s; code %d)", d.Name, ErrorName(errCode), errCode)
+	}
+
+	return littleEndian != 0, nil
+}
+
 // Return a textual description of an opencl error code.
 func ErrorName(errCode cl.ErrorCode) string {
 	switch errCode {
@@ -25,7 +25,7 @@ func TestDeviceInit(t *testing.T) {
 	}
 
 	dev := devList[0]
-	err = dev.Init("test.cl")
+	err = dev.Init("test.cl", nil)
 	if err != nil {
 		t.Fatalf("error initializing device '%s': %v", dev.Name, err)
 	}
@@ -59,5 +59,5 @@ func createCpuTestDevice() (*Device, error) {
 	if err != nil {
 		return nil, err
 	}
-	return devList[0], devList[0].Init("test.cl")
+	return devList[0], devList[0].Init("test.cl", nil)
 }
@@ -0,0 +1,41 @@
+package device
+
+import (
+	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// Map an opencl error code reported while allocating or transferring device
+// memory to a tracer error kind. Returns nil if the error code does not map
+// to a known kind.
+func memoryErrorKind(errCode cl.ErrorCode) error {
+	switch errCode {
+	case -4, -5, -6: // MEM_OBJECT_ALLOCATION_FAILURE, OUT_OF_RESOURCES, OUT_OF_HOST_MEMORY
+		return tracer.ErrOutOfMemory
+	case -2, -36: // DEVICE_NOT_AVAILABLE, INVALID_COMMAND_QUEUE
+		return tracer.ErrDeviceLost
+	}
+	return nil
+}
+
+// Map an opencl error code reported while executing a kernel to a tracer
+// error kind. Drivers typically report OUT_OF_RESOURCES when a kernel crashes
+// or the device gets reset by a watchdog so it is treated as device loss.
+// Returns nil if the error code does not map to a known kind.
+func execErrorKind(errCode cl.ErrorCode) error {
+	switch errCode {
+	case -4, -6: // MEM_OBJECT_ALLOCATION_FAILURE, OUT_OF_HOST_MEMORY
+		return tracer.ErrOutOfMemory
+	case -2, -5, -14, -36: // DEVICE_NOT_AVAILABLE, OUT_OF_RESOURCES, EXEC_STATUS_ERROR_FOR_EVENTS_IN_WAIT_LIST, INVALID_COMMAND_QUEUE
+		return tracer.ErrDeviceLost
+	}
+	return nil
+}
+
+// Wrap err with the given tracer error kind. If kind is nil, err is returned unchanged.
+func wrapError(kind, err error) error {
+	if kind == nil {
+		return err
+	}
+	return tracer.WrapError(kind, err)
+}
@@ -6,8 +6,8 @@ import (
 	"time"
 	"unsafe"
 
-	"github.com/achilleasa/polaris/types"
 	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/types"
 )
 
 // A wrapper around opencl kernelHandles.
@@ -117,13 +117,13 @@ func (k *Kernel) Exec1D(offset, globalWorkSize, localWorkSize int) (time.Duratio
 		nil,
 	)
 	if errCode != cl.SUCCESS {
-		return time.Duration(0), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+		return time.Duration(0), wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode))
 	}
 
 	// Wait for the kernelHandle to complete
 	errCode = cl.Finish(k.device.cmdQueue)
 	if errCode != cl.SUCCESS {
-		return time.Duration(0), fmt.Errorf("opencl device (%s): kernel %s did not complete successfully (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+		return time.Duration(0), wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): kernel %s did not complete successfully (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode))
 	}
 
 	return time.Since(tick), nil
@@ -164,7 +164,7 @@ func (k *Kernel) Exec1DNoWait(offset, globalWorkSize, localWorkSize int) (time.D
 		nil,
 	)
 	if errCode != cl.SUCCESS {
-		return time.Duration(0), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+		return time.Duration(0), wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code: %d)", k.device.Name, k.name, ErrorName(errCode), errCode))
 	}
 
 	return time.Since(tick), nil
@@ -203,13 +203,13 @@ func (k *Kernel) Exec2D(offsetX, offsetY, globalWorkSizeX, globalWorkSizeY, loca
 		nil,
 	)
 	if errCode != cl.SUCCESS {
-		return time.Duration(0), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+		return time.Duration(0), wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): unable to execute kernel %s (error: %s; code %d)", k.device.Name, k.name, ErrorName(errCode), errCode))
 	}
 
 	// Wait for the kernelHandle to complete
 	errCode = cl.Finish(k.device.cmdQueue)
 	if errCode != cl.SUCCESS {
-		return time.Duration(0), fmt.Errorf("opencl device (%s): kernel %s did not complete successfully (error: %s; code %d)", k.device.Name, k.name, ErrorName(errCode), errCode)
+		return time.Duration(0), wrapError(execErrorKind(errCode), fmt.Errorf("opencl device (%s): kernel %s did not complete successfully (error: %s; code %d)", k.device.Name, k.name, ErrorName(errCode), errCode))
 	}
 
 	return time.Since(tick), nil
@@ -0,0 +1,97 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrDeviceLocked = errors.New("opencl device: device is in use by another process")
+
+	// Returned by lockFile if the lock is held by another process.
+	errLockHeld = errors.New("opencl device: lock held by another process")
+
+	lockKeyRegex = regexp.MustCompile("[^a-zA-Z0-9]+")
+)
+
+// The directory where device lock files are created. Processes that need to
+// coordinate device usage must use the same lock directory.
+var LockDir = filepath.Join(os.TempDir(), "polaris-locks")
+
+// An exclusive advisory lock for a device. Locks are backed by lock files so
+// they are automatically released by the OS if the process holding them exits.
+type Lock struct {
+	file *os.File
+}
+
+// Get a key that identifies the device across processes. As identical devices
+// share the same name, the key also includes the device index among devices
+// with the same name.
+func (d *Device) LockKey() string {
+	name := strings.Trim(lockKeyRegex.ReplaceAllString(strings.ToLower(d.Name), "-"), "-")
+	return fmt.Sprintf("%s-%d", name, d.ordinal)
+}
+
+// Try to acquire an exclusive lock for the device without blocking. If the
+// device is locked by another process, an ErrDeviceLocked error is returned
+// which includes the pid of the process holding the lock. On platforms
+// without file locking support, the lock always succeeds.
+func (d *Device) TryLock() (*Lock, error) {
+	// Make the lock dir writable by all users so that processes from
+	// different users can share it.
+	if err := os.MkdirAll(LockDir, 0777); err != nil {
+		return nil, err
+	}
+	os.Chmod(LockDir, 0777|os.ModeSticky)
+
+	f, err := os.OpenFile(filepath.Join(LockDir, d.LockKey()+".lock"), os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(f)
+	if err == errLockHeld {
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return nil, fmt.Errorf("%s: %s (pid %d)", ErrDeviceLocked.Error(), d.Name, pid)
+		}
+		return nil, fmt.Errorf("%s: %s", ErrDeviceLocked.Error(), d.Name)
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Record our pid so other processes can report who holds the lock
+	if f.Truncate(0) == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{file: f}, nil
+}
+
+// Release the device lock. The lock file is not removed as another process
+// may be waiting to lock it.
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+// Check whether err was returned because a device is locked by another process.
+func IsLocked(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), ErrDeviceLocked.Error())
+}
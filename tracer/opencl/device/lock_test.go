@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package device
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDeviceLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	origLockDir := LockDir
+	LockDir = dir
+	defer func() { LockDir = origLockDir }()
+
+	dev := &Device{Name: "AMD Radeon (TM) Pro", ordinal: 1}
+	if key := dev.LockKey(); key != "amd-radeon-tm-pro-1" {
+		t.Fatalf("expected lock key to be %q; got %q", "amd-radeon-tm-pro-1", key)
+	}
+
+	lock, err := dev.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks are held per open file so a second lock attempt from
+	// the same process should fail
+	_, err = dev.TryLock()
+	if !IsLocked(err) {
+		t.Fatalf("expected to get ErrDeviceLocked; got %v", err)
+	}
+	if expErr := fmt.Sprintf("%s: %s (pid %d)", ErrDeviceLocked.Error(), dev.Name, os.Getpid()); err.Error() != expErr {
+		t.Fatalf("expected error %q; got %q", expErr, err.Error())
+	}
+
+	// Identical devices should be locked independently
+	other := &Device{Name: dev.Name}
+	otherLock, err := other.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherLock.Release()
+
+	err = lock.Release()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err = dev.TryLock()
+	if err != nil {
+		t.Fatalf("expected to lock device after the previous lock was released; got %v", err)
+	}
+	lock.Release()
+}
@@ -61,6 +61,7 @@ func GetPlatformInfo() ([]PlatformInfo, error) {
 	cl.GetPlatformIDs(uint32(len(pids)), &pids[0], &pidCount)
 
 	infoList := make([]PlatformInfo, int(pidCount))
+	nameCount := make(map[string]int)
 	for pIdx := 0; pIdx < int(pidCount); pIdx++ {
 		infoList[pIdx].Devices = make([]*Device, 0)
 
@@ -110,8 +111,12 @@ func GetPlatformInfo() ([]PlatformInfo, error) {
 			)
 		}
 
-		// Enumerate speed for all platform devices
+		// Detect software implementations and estimate speed for all platform devices
 		for _, dev := range infoList[pIdx].Devices {
+			dev.Impl = detectImplementation(infoList[pIdx].Name, infoList[pIdx].Vendor, dev.Name)
+			dev.ordinal = nameCount[dev.Name]
+			nameCount[dev.Name]++
+
 			err := dev.detectSpeed()
 			if err != nil {
 				return nil, err
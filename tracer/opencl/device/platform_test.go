@@ -0,0 +1,26 @@
+package device
+
+import "testing"
+
+func TestDetectImplementation(t *testing.T) {
+	type spec struct {
+		platformName   string
+		platformVendor string
+		deviceName     string
+		expImpl        Implementation
+	}
+	specs := []spec{
+		spec{"Apple", "Apple", "Iris Pro", NativeImpl},
+		spec{"NVIDIA CUDA", "NVIDIA Corporation", "GeForce GTX 1080", NativeImpl},
+		spec{"Portable Computing Language", "The pocl project", "pthread-Intel(R) Xeon(R) CPU", PoclImpl},
+		spec{"Portable Computing Language", "The pocl project", "cpu-haswell-Intel(R) Core(TM) i7", PoclImpl},
+		spec{"Oclgrind", "University of Bristol", "Oclgrind Simulator", OclgrindImpl},
+	}
+
+	for index, s := range specs {
+		impl := detectImplementation(s.platformName, s.platformVendor, s.deviceName)
+		if impl != s.expImpl {
+			t.Errorf("[spec %d] expected implementation %s; got %s", index, s.expImpl, impl)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package device
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// PCI vendor ids for GPUs that expose thermal readings via sysfs.
+const (
+	amdVendorId   = 0x1002
+	intelVendorId = 0x8086
+)
+
+// The root of the sysfs tree used for polling thermal readings.
+var sysfsRoot = "/sys"
+
+// The hwmon drivers that report CPU package temperatures.
+var cpuHwmonDrivers = map[string]bool{
+	"coretemp":    true,
+	"k10temp":     true,
+	"zenpower":    true,
+	"cpu_thermal": true,
+}
+
+// A function that polls thermal readings for a device.
+type thermalSource func() tracer.Thermals
+
+// Poll the device temperature and clock speed. OpenCL does not define a
+// portable way to query these values so they are read from sysfs where the
+// platform exposes them: CPU readings come from cpufreq and the CPU hwmon
+// driver while AMD and Intel GPU readings come from the DRM card that
+// matches the device vendor. Readings that are not available are left zeroed.
+func (d *Device) Thermals() (tracer.Thermals, error) {
+	if d.thermals == nil {
+		d.thermals = d.detectThermalSource(sysfsRoot)
+	}
+	return d.thermals(), nil
+}
+
+// Select the thermal source for the device.
+func (d *Device) detectThermalSource(root string) thermalSource {
+	noReadings := func() tracer.Thermals { return tracer.Thermals{} }
+
+	switch {
+	case d.IsSimulator():
+		return noReadings
+	case d.Type == CpuDevice:
+		return func() tracer.Thermals { return cpuThermals(root) }
+	case d.Type == GpuDevice:
+		var vendorId uint32
+		errCode := cl.GetDeviceInfo(d.Id, cl.DEVICE_VENDOR_ID, 4, unsafe.Pointer(&vendorId), nil)
+		if errCode != cl.SUCCESS {
+			return noReadings
+		}
+		cardDir := findDrmCard(root, vendorId)
+		if cardDir == "" {
+			return noReadings
+		}
+		return func() tracer.Thermals { return drmThermals(cardDir) }
+	}
+
+	return noReadings
+}
+
+// Read the average CPU clock from cpufreq and the package temperature from
+// the CPU hwmon driver or the x86 package thermal zone.
+func cpuThermals(root string) tracer.Thermals {
+	var t tracer.Thermals
+
+	cpuDirs, _ := filepath.Glob(filepath.Join(root, "devices/system/cpu/cpu[0-9]*/cpufreq"))
+	var clockSum, numClocks uint64
+	for _, dir := range cpuDirs {
+		if kHz, ok := readSysfsInt(filepath.Join(dir, "scaling_cur_freq")); ok {
+			clockSum += uint64(kHz)
+			numClocks++
+		}
+		if kHz, ok := readSysfsInt(filepath.Join(dir, "cpuinfo_max_freq")); ok && uint32(kHz/1000) > t.MaxClockMHz {
+			t.MaxClockMHz = uint32(kHz / 1000)
+		}
+	}
+	if numClocks > 0 {
+		t.ClockMHz = uint32(clockSum / numClocks / 1000)
+	}
+
+	hwmonDirs, _ := filepath.Glob(filepath.Join(root, "class/hwmon/hwmon*"))
+	for _, dir := range hwmonDirs {
+		if !cpuHwmonDrivers[readSysfsString(filepath.Join(dir, "name"))] {
+			continue
+		}
+		if milliC, ok := readSysfsInt(filepath.Join(dir, "temp1_input")); ok {
+			t.Temperature = float32(milliC) / 1000
+			return t
+		}
+	}
+
+	zoneDirs, _ := filepath.Glob(filepath.Join(root, "class/thermal/thermal_zone*"))
+	for _, dir := range zoneDirs {
+		if readSysfsString(filepath.Join(dir, "type")) != "x86_pkg_temp" {
+			continue
+		}
+		if milliC, ok := readSysfsInt(filepath.Join(dir, "temp")); ok {
+			t.Temperature = float32(milliC) / 1000
+			break
+		}
+	}
+
+	return t
+}
+
+// Find the sysfs DRM card directory for the first GPU with the given PCI
+// vendor id. Returns an empty string if no matching card is found.
+func findDrmCard(root string, vendorId uint32) string {
+	if vendorId != amdVendorId && vendorId != intelVendorId {
+		return ""
+	}
+
+	cardDirs, _ := filepath.Glob(filepath.Join(root, "class/drm/card*"))
+	for _, dir := range cardDirs {
+		// Skip connector entries (e.g. card0-HDMI-A-1)
+		if strings.Contains(filepath.Base(dir), "-") {
+			continue
+		}
+		vendor, err := strconv.ParseUint(strings.TrimPrefix(readSysfsString(filepath.Join(dir, "device/vendor")), "0x"), 16, 32)
+		if err == nil && uint32(vendor) == vendorId {
+			return dir
+		}
+	}
+
+	return ""
+}
+
+// Read the temperature and clock of a DRM card. AMD cards list their shader
+// clock levels in pp_dpm_sclk with the active level marked by an asterisk
+// while Intel cards report their actual and max clocks via the gt_* files.
+func drmThermals(cardDir string) tracer.Thermals {
+	var t tracer.Thermals
+
+	hwmonDirs, _ := filepath.Glob(filepath.Join(cardDir, "device/hwmon/hwmon*"))
+	for _, dir := range hwmonDirs {
+		if milliC, ok := readSysfsInt(filepath.Join(dir, "temp1_input")); ok {
+			t.Temperature = float32(milliC) / 1000
+			break
+		}
+	}
+
+	if levels := readSysfsString(filepath.Join(cardDir, "device/pp_dpm_sclk")); levels != "" {
+		// Each line has the format: "1: 1500Mhz *"
+		for _, line := range strings.Split(levels, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			mhz, err := strconv.ParseUint(strings.TrimSuffix(strings.ToLower(fields[1]), "mhz"), 10, 32)
+			if err != nil {
+				continue
+			}
+			if uint32(mhz) > t.MaxClockMHz {
+				t.MaxClockMHz = uint32(mhz)
+			}
+			if len(fields) > 2 && fields[2] == "*" {
+				t.ClockMHz = uint32(mhz)
+			}
+		}
+		return t
+	}
+
+	if mhz, ok := readSysfsInt(filepath.Join(cardDir, "gt_act_freq_mhz")); ok {
+		t.ClockMHz = uint32(mhz)
+	}
+	if mhz, ok := readSysfsInt(filepath.Join(cardDir, "gt_RP0_freq_mhz")); ok {
+		t.MaxClockMHz = uint32(mhz)
+	}
+
+	return t
+}
+
+// Read a sysfs attribute and strip trailing whitespace. Returns an empty
+// string if the attribute cannot be read.
+func readSysfsString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Read an integer sysfs attribute.
+func readSysfsInt(path string) (int64, bool) {
+	val, err := strconv.ParseInt(readSysfsString(path), 10, 64)
+	return val, err == nil
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestCpuThermals(t *testing.T) {
+	root := makeSysfsTree(t, map[string]string{
+		"devices/system/cpu/cpu0/cpufreq/scaling_cur_freq": "2000000\n",
+		"devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq": "4000000\n",
+		"devices/system/cpu/cpu1/cpufreq/scaling_cur_freq": "3000000\n",
+		"devices/system/cpu/cpu1/cpufreq/cpuinfo_max_freq": "4000000\n",
+		"class/hwmon/hwmon0/name":                          "nvme\n",
+		"class/hwmon/hwmon0/temp1_input":                   "40000\n",
+		"class/hwmon/hwmon1/name":                          "coretemp\n",
+		"class/hwmon/hwmon1/temp1_input":                   "95500\n",
+	})
+	defer os.RemoveAll(root)
+
+	exp := tracer.Thermals{Temperature: 95.5, ClockMHz: 2500, MaxClockMHz: 4000}
+	if got := cpuThermals(root); got != exp {
+		t.Fatalf("expected cpu thermals %+v; got %+v", exp, got)
+	}
+}
+
+func TestCpuThermalsFromThermalZone(t *testing.T) {
+	root := makeSysfsTree(t, map[string]string{
+		"class/thermal/thermal_zone0/type": "acpitz\n",
+		"class/thermal/thermal_zone0/temp": "30000\n",
+		"class/thermal/thermal_zone1/type": "x86_pkg_temp\n",
+		"class/thermal/thermal_zone1/temp": "70000\n",
+	})
+	defer os.RemoveAll(root)
+
+	exp := tracer.Thermals{Temperature: 70}
+	if got := cpuThermals(root); got != exp {
+		t.Fatalf("expected cpu thermals %+v; got %+v", exp, got)
+	}
+}
+
+func TestDrmThermals(t *testing.T) {
+	root := makeSysfsTree(t, map[string]string{
+		"class/drm/card0/device/vendor":                   "0x8086\n",
+		"class/drm/card0/gt_act_freq_mhz":                 "300\n",
+		"class/drm/card0/gt_RP0_freq_mhz":                 "1100\n",
+		"class/drm/card0-HDMI-A-1/device/vendor":          "0x1002\n",
+		"class/drm/card1/device/vendor":                   "0x1002\n",
+		"class/drm/card1/device/hwmon/hwmon3/temp1_input": "82000\n",
+		"class/drm/card1/device/pp_dpm_sclk":              "0: 300Mhz\n1: 1200Mhz *\n2: 1800Mhz\n",
+	})
+	defer os.RemoveAll(root)
+
+	specs := []struct {
+		vendorId uint32
+		exp      tracer.Thermals
+	}{
+		{intelVendorId, tracer.Thermals{ClockMHz: 300, MaxClockMHz: 1100}},
+		{amdVendorId, tracer.Thermals{Temperature: 82, ClockMHz: 1200, MaxClockMHz: 1800}},
+	}
+
+	for index, spec := range specs {
+		cardDir := findDrmCard(root, spec.vendorId)
+		if cardDir == "" {
+			t.Errorf("[spec %d] expected to find a drm card for vendor 0x%x", index, spec.vendorId)
+			continue
+		}
+		if got := drmThermals(cardDir); got != spec.exp {
+			t.Errorf("[spec %d] expected drm thermals %+v; got %+v", index, spec.exp, got)
+		}
+	}
+
+	if cardDir := findDrmCard(root, 0x10de); cardDir != "" {
+		t.Fatalf("expected no drm card for vendors that do not expose sysfs readings; got %q", cardDir)
+	}
+}
+
+// Create a temporary sysfs tree with the given attribute files.
+func makeSysfsTree(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "polaris-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range files {
+		fullPath := filepath.Join(root, path)
+		err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(fullPath, []byte(content), 0644)
+		}
+		if err != nil {
+			os.RemoveAll(root)
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
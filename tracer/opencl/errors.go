@@ -3,20 +3,35 @@ package opencl
 import "errors"
 
 var (
-	ErrContextCreationFailed  = errors.New("opencl tracer: could not create opencl context")
-	ErrCmdQueueCreationFailed = errors.New("opencl tracer: could not create opencl command queue")
-	ErrAlreadySetup           = errors.New("opencl tracer: tracer already set up")
-	ErrProgramCreationFailed  = errors.New("opencl tracer: program creation failed")
-	ErrProgramBuildFailed     = errors.New("opencl tracer: program compilation failed")
-	ErrKernelCreationFailed   = errors.New("opencl tracer: could not create compute kernel")
-	ErrGettingWorkgroupInfo   = errors.New("opencl tracer: could not get kernel work group info")
-	ErrAllocatingBuffer       = errors.New("opencl tracer: could not allocate device buffer")
-	ErrCopyingDataToHost      = errors.New("opencl tracer: could not copy device data to host buffer")
-	ErrCopyingDataToDevice    = errors.New("opencl tracer: could not copy host data to device buffer")
-	ErrSettingKernelArgument  = errors.New("opencl tracer: error setting kernel argument")
-	ErrKernelExecutionFailed  = errors.New("opencl tracer: kernel execution failed")
-	ErrUnsupportedChangeType  = errors.New("opencl tracer: unsupported change type")
-	ErrInvalidChangeData      = errors.New("opencl tracer: invalid data type for change")
-	ErrInvalidOption          = errors.New("opencl tracer: invalid tracer option")
-	ErrNoSceneData            = errors.New("opencl tracer: no scene data uploaded")
+	ErrContextCreationFailed       = errors.New("opencl tracer: could not create opencl context")
+	ErrCmdQueueCreationFailed      = errors.New("opencl tracer: could not create opencl command queue")
+	ErrAlreadySetup                = errors.New("opencl tracer: tracer already set up")
+	ErrProgramCreationFailed       = errors.New("opencl tracer: program creation failed")
+	ErrProgramBuildFailed          = errors.New("opencl tracer: program compilation failed")
+	ErrKernelCreationFailed        = errors.New("opencl tracer: could not create compute kernel")
+	ErrGettingWorkgroupInfo        = errors.New("opencl tracer: could not get kernel work group info")
+	ErrAllocatingBuffer            = errors.New("opencl tracer: could not allocate device buffer")
+	ErrCopyingDataToHost           = errors.New("opencl tracer: could not copy device data to host buffer")
+	ErrCopyingDataToDevice         = errors.New("opencl tracer: could not copy host data to device buffer")
+	ErrSettingKernelArgument       = errors.New("opencl tracer: error setting kernel argument")
+	ErrKernelExecutionFailed       = errors.New("opencl tracer: kernel execution failed")
+	ErrUnsupportedChangeType       = errors.New("opencl tracer: unsupported change type")
+	ErrInvalidChangeData           = errors.New("opencl tracer: invalid data type for change")
+	ErrInvalidOption               = errors.New("opencl tracer: invalid tracer option")
+	ErrNoSceneData                 = errors.New("opencl tracer: no scene data uploaded")
+	ErrEmptyScene                  = errors.New("opencl tracer: scene does not contain any geometry")
+	ErrNotInitialized              = errors.New("opencl tracer: tracer not initialized")
+	ErrBufferTooSmall              = errors.New("opencl tracer: output buffer too small")
+	ErrLayoutMismatch              = errors.New("opencl tracer: host and device data layouts do not match")
+	ErrInvalidDebugOutput          = errors.New("opencl tracer: invalid debug output")
+	ErrMissingFilename             = errors.New("opencl tracer: missing output filename")
+	ErrSampleStatsDisabled         = errors.New("opencl tracer: sample statistics are not collected by the pipeline")
+	ErrUnsupportedImageFormat      = errors.New("opencl tracer: unsupported output image format")
+	ErrUnsupportedImageOptions     = errors.New("opencl tracer: image options not supported by the output format")
+	ErrInvalidLightPathExpression  = errors.New("opencl tracer: invalid light path expression")
+	ErrTooManyLightPathExpressions = errors.New("opencl tracer: too many light path expressions")
+	ErrNoLightPathExpressions      = errors.New("opencl tracer: the pipeline does not define any light path expressions")
+	ErrCameraRayCount              = errors.New("opencl tracer: number of camera rays does not match the number of pixels")
+	ErrInvalidKernelArgs           = errors.New("opencl tracer: invalid kernel arguments")
+	ErrNoCameraAperture            = errors.New("opencl tracer: the thin-lens camera requires a camera with a non-zero aperture and focus distance")
 )
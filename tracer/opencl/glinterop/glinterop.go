@@ -0,0 +1,35 @@
+// Package glinterop provides pipeline stages for displaying the output of
+// the opencl tracer using opengl. It lives in a separate package so that the
+// opencl tracer can be compiled without any opengl headers or libraries.
+package glinterop
+
+import (
+	"image"
+	"time"
+	"unsafe"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl"
+	"github.com/go-gl/gl/v2.1/gl"
+)
+
+// Copy RGBA screen buffer to opengl texture. This function assumes that
+// the caller has enabled the appropriate 2D texture target.
+func CopyFrameBufferToOpenGLTexture() opencl.PipelineStage {
+	var fb *image.RGBA
+	return func(tr *opencl.Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		if fb == nil || fb.Rect.Dx() != int(blockReq.FrameW) || fb.Rect.Dy() != int(blockReq.FrameH) {
+			fb = image.NewRGBA(image.Rect(0, 0, int(blockReq.FrameW), int(blockReq.FrameH)))
+		}
+
+		_, err := tr.ReadFrame(blockReq, fb)
+		if err != nil {
+			return 0, err
+		}
+
+		gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(blockReq.FrameW), int32(blockReq.FrameH), gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&fb.Pix[0]))
+		return time.Since(start), nil
+	}
+}
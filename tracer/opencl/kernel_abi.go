@@ -0,0 +1,50 @@
+package opencl
+
+import (
+	"fmt"
+
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+//go:generate go run ./abi/abigen -spec stages.abi -go kernel_abi_gen.go -cl CL/abi.cl
+
+// The kernel types, the argument structs for each kernel and the defines
+// shared with the kernels are generated from the stage ABI spec in stages.abi.
+type kernelType uint8
+
+// Implements Stringer; map kernel type to the kernel name as defined in the CL source files.
+func (kt kernelType) String() string {
+	if kt >= numKernels {
+		panic(fmt.Sprintf("Unsupported kernel type: %d", kt))
+	}
+	return kernelNames[kt]
+}
+
+// The argBinder interface is implemented by device.Kernel.
+type argBinder interface {
+	SetArgs(args ...interface{}) error
+}
+
+// A define shared with the kernels and the value of the matching host constant.
+type abiDefine struct {
+	name      string
+	value     uint64
+	hostValue uint64
+}
+
+// Bind a list of arguments to a kernel. This function is invoked by the
+// generated argument structs and ensures that every buffer argument is set.
+func bindKernelArgs(k argBinder, kt kernelType, args ...interface{}) error {
+	names := kernelArgNames[kt]
+	if len(args) != len(names) {
+		return fmt.Errorf("%s: kernel %s expects %d arguments; got %d", ErrInvalidKernelArgs.Error(), kt, len(names), len(args))
+	}
+
+	for index, arg := range args {
+		if buf, isBuffer := arg.(*device.Buffer); isBuffer && buf == nil {
+			return fmt.Errorf("%s: missing buffer for argument %q of kernel %s", ErrInvalidKernelArgs.Error(), names[index], kt)
+		}
+	}
+
+	return k.SetArgs(args...)
+}
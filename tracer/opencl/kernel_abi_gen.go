@@ -0,0 +1,1451 @@
+// Code generated by abigen from stages.abi. DO NOT EDIT.
+
+package opencl
+
+import (
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The version of the stage ABI.
+const stageABIVersion = 22
+
+// The list of kernels that implement the tracer.
+const (
+	// Generate primary rays for a block using the built-in perspective camera.
+	generatePrimaryRays kernelType = iota
+	// Generate primary rays for a block using host-supplied camera rays.
+	generateCustomRays
+	// Check whether rays intersect any geometry.
+	rayIntersectionTest
+	// Find the closest intersection for each ray.
+	rayIntersectionQuery
+	// Find the closest intersection for each ray using packet traversal.
+	rayPacketIntersectionQuery
+	// Approximate the fraction of each area light that is visible from the origin
+	// of each occlusion ray by tracing a cone towards the light. The emissive
+	// samples of the rays are scaled by the visibility and fully occluded rays
+	// are flagged as hits.
+	coneOcclusionTest
+	// Sample a free-flight distance for rays traveling through a medium and flag
+	// the rays that scatter before reaching the next surface. Distances are also
+	// sampled towards the area lights using equi-angular sampling. Heterogeneous
+	// media are sampled using delta tracking and the light that they emit is added
+	// to the output accumulator.
+	sampleMediumInteractions
+	// Record the texture footprints of ray hits for texture streaming.
+	recordTextureFeedback
+	// Shade ray hits and generate occlusion and indirect rays.
+	shadeHits
+	// Shade camera rays that do not hit any geometry.
+	shadePrimaryRayMisses
+	// Shade indirect rays that do not hit any geometry.
+	shadeIndirectRayMisses
+	// Accumulate the emitted radiance of emissive surfaces hit by rays without
+	// scattering them any further.
+	shadeEmissiveHits
+	// Accumulate the emissive samples of paths with non-occluded occlusion rays.
+	accumulateEmissiveSamples
+	// Generate a ray leaving a random point on an area light for each light
+	// subpath and clear the light subpath vertices.
+	generateLightRays
+	// Store the light subpath vertices at the given depth and generate the rays
+	// for the next depth.
+	shadeLightHits
+	// Connect eye subpath vertices to a light subpath vertex and generate
+	// connection rays and samples. It must run before shadeHits updates the path
+	// throughput for the current bounce.
+	connectLightVertex
+	// Shade intersections using ambient occlusion. Hits emit an occlusion ray
+	// limited to maxDistance and misses add a white sample to the accumulator.
+	shadeAmbientOcclusion
+	// Estimate the local occlusion for the vertices of triangles whose material
+	// contains mixOcclusion nodes by tracing rays against the BVH of their mesh.
+	bakeLocalOcclusion
+	// Apply simple Reinhard tone-mapping.
+	tonemapSimpleReinhard
+	// Clear an accumulation buffer.
+	clearAccumulator
+	// Add the contents of an accumulator to another accumulator.
+	aggregateAccumulator
+	// Add the contents of an accumulator to another accumulator using compensated
+	// (Kahan) summation. The compensation buffer tracks the low-order bits lost by
+	// each addition to the destination accumulator.
+	aggregateAccumulatorCompensated
+	// Add the light group passes of the light path accumulator to an accumulator
+	// after scaling each pass by its intensity multiplier minus one and store the
+	// results in the output accumulator.
+	mixLightGroups
+	// Update the per-pixel sample statistics.
+	accumulateSampleStats
+	// Clear the debug buffer.
+	debugClearBuffer
+	// Clear the debug value buffer.
+	debugClearValues
+	// Render the hit distance of primary ray intersections.
+	debugRayIntersectionDepth
+	// Generate a normal map for primary ray intersections.
+	debugRayIntersectionNormals
+	// Render the emissive samples of occluded and/or non-occluded paths.
+	debugEmissiveSamples
+	// Render the path throughput.
+	debugThroughput
+	// Render the accumulator contents.
+	debugAccumulator
+	// Report the layout and ABI versions and the sizes of the shared structures.
+	getLayoutInfo
+	//
+	numKernels
+)
+
+// The kernel names as defined in the CL source files.
+var kernelNames = [numKernels]string{
+	"generatePrimaryRays",
+	"generateCustomRays",
+	"rayIntersectionTest",
+	"rayIntersectionQuery",
+	"rayPacketIntersectionQuery",
+	"coneOcclusionTest",
+	"sampleMediumInteractions",
+	"recordTextureFeedback",
+	"shadeHits",
+	"shadePrimaryRayMisses",
+	"shadeIndirectRayMisses",
+	"shadeEmissiveHits",
+	"accumulateEmissiveSamples",
+	"generateLightRays",
+	"shadeLightHits",
+	"connectLightVertex",
+	"shadeAmbientOcclusion",
+	"bakeLocalOcclusion",
+	"tonemapSimpleReinhard",
+	"clearAccumulator",
+	"aggregateAccumulator",
+	"aggregateAccumulatorCompensated",
+	"mixLightGroups",
+	"accumulateSampleStats",
+	"debugClearBuffer",
+	"debugClearValues",
+	"debugRayIntersectionDepth",
+	"debugRayIntersectionNormals",
+	"debugEmissiveSamples",
+	"debugThroughput",
+	"debugAccumulator",
+	"getLayoutInfo",
+}
+
+// The argument names of each kernel in declaration order.
+var kernelArgNames = [numKernels][]string{
+	{"rays", "numRays", "paths", "frustrumTL", "frustrumTR", "frustrumBL", "frustrumBR", "eyePos", "texelDims", "blockY", "blockH", "frameW", "frameH", "randSeed", "samplerType", "samplerSeed", "sampleIndex", "blueNoise", "pixelFilter", "lensRight", "lensUp", "focusNormal", "focusDistance", "apertureBlades", "apertureRotation", "bokehSamples", "numBokehSamples", "bokehJitter", "rayPixels"},
+	{"rays", "numRays", "paths", "cameraRays", "rayOffset", "blockY", "blockH", "frameW", "rayPixels"},
+	{"rays", "numRays", "bvhNodes", "meshInstances", "vertexList", "hitFlag", "stackOverflows", "materialIndices", "materialNodes", "uv", "texMeta", "texData", "compressedBvhNodes", "compressedBvhRoots"},
+	{"rays", "numRays", "bvhNodes", "meshInstances", "vertexList", "hitFlag", "intersections", "stackOverflows", "skipInstanceFlags", "materialIndices", "materialNodes", "uv", "texMeta", "texData", "compressedBvhNodes", "compressedBvhRoots"},
+	{"rays", "numRays", "bvhNodes", "meshInstances", "vertexList", "hitFlag", "intersections", "stackOverflows", "skipInstanceFlags", "materialIndices", "materialNodes", "uv", "texMeta", "texData", "compressedBvhNodes", "compressedBvhRoots"},
+	{"rays", "numRays", "bvhNodes", "meshInstances", "vertexList", "hitFlag", "stackOverflows", "occlusionCones", "emissiveSamples", "materialIndices", "materialNodes", "uv", "texMeta", "texData"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "materialNodes", "pathMedia", "sceneMediumMatNodeIndex", "vertices", "emissives", "numEmissives", "bounce", "randSeed", "samplerType", "samplerSeed", "sampleIndex", "frameW", "blueNoise", "volumeGrids", "volumeData", "frameH", "clampDirect", "clampIndirect", "accumulator", "numLpeExpressions", "lpeTransitions", "lpeStates", "lpeAccumulator", "lightGroupMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "vertices", "normals", "uv", "materialIndices", "textureFilter", "textureFeedback"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "meshInstances", "vertices", "normals", "tangents", "uv", "materialIndices", "vertexOcclusion", "materialNodes", "emissives", "numEmissives", "envDistribution", "envDistributionW", "envDistributionH", "sceneDiffuseMatNodeIndex", "sceneBackplateMatNodeIndex", "sceneEnvMatNodeIndex", "frameW", "frameH", "texMeta", "texData", "bounce", "minBouncesForRR", "randSeed", "samplerType", "samplerSeed", "sampleIndex", "blueNoise", "shadingNormalFix", "textureFilter", "clampDirect", "clampIndirect", "occlusionRays", "numOcclusionRays", "emissiveSamples", "occlusionCones", "indirectRays", "numIndirectRays", "accumulator", "numLpeExpressions", "lpeTransitions", "lpeStates", "emissiveSampleLpeMasks", "lpeAccumulator", "lightGroupMasks", "numBounces", "numLightVertices", "pathMedia", "sceneMediumMatNodeIndex", "volumeGrids", "volumeData"},
+	{"rays", "numRays", "paths", "hitFlags", "materialNodes", "sceneDiffuseMatNodeIndex", "sceneBackplateMatNodeIndex", "sceneEnvMatNodeIndex", "frameW", "frameH", "texMeta", "texData", "accumulator", "numLpeExpressions", "lpeTransitions", "lpeAccumulator", "lightGroupMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "materialNodes", "sceneDiffuseMatNodeIndex", "sceneEnvMatNodeIndex", "bounce", "clampDirect", "clampIndirect", "texMeta", "texData", "accumulator", "numLpeExpressions", "lpeTransitions", "lpeStates", "numPixels", "lpeAccumulator", "lightGroupMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "vertices", "normals", "tangents", "uv", "materialIndices", "vertexOcclusion", "materialNodes", "texMeta", "texData", "bounce", "randSeed", "clampDirect", "clampIndirect", "accumulator", "numLpeExpressions", "lpeTransitions", "lpeStates", "numPixels", "lpeAccumulator", "lightGroupMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "emissiveSamples", "accumulator", "emissiveSampleLpeMasks", "numPixels", "lpeAccumulator"},
+	{"rays", "numRays", "paths", "lightVertices", "numLightVertices", "numPaths", "vertices", "normals", "uv", "materialNodes", "emissives", "numEmissives", "texMeta", "texData", "randSeed", "lightGroupMasks", "lightPathGroupMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "meshInstances", "vertices", "normals", "tangents", "uv", "materialIndices", "vertexOcclusion", "materialNodes", "texMeta", "texData", "depth", "minBouncesForRR", "randSeed", "shadingNormalFix", "lightVertices", "numLightVertices", "outRays", "numOutRays"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "meshInstances", "vertices", "normals", "tangents", "uv", "materialIndices", "vertexOcclusion", "materialNodes", "texMeta", "texData", "bounce", "numBounces", "randSeed", "shadingNormalFix", "textureFilter", "clampIndirect", "lightVertices", "lightDepth", "numLightVertices", "lightPathGroupMasks", "connectionRays", "numConnectionRays", "connectionSamples", "connectionSampleLpeMasks"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "meshInstances", "vertices", "normals", "uv", "materialIndices", "randSeed", "samplerType", "samplerSeed", "sampleIndex", "frameW", "blueNoise", "shadingNormalFix", "maxDistance", "occlusionRays", "numOcclusionRays", "emissiveSamples", "emissiveSampleLpeMasks", "accumulator"},
+	{"vertices", "normals", "bvhNodes", "triBvhRoots", "triOcclusionRadius", "numVertices", "numSamples", "randSeed", "vertexOcclusion"},
+	{"accumulator", "paths", "frameBuffer", "sampleWeight", "exposure"},
+	{"accumulator"},
+	{"srcAccumulator", "dstAccumulator"},
+	{"srcAccumulator", "dstAccumulator", "compensation"},
+	{"accumulator", "lpeAccumulator", "lightGroupScales", "firstLightGroupPass", "numLightGroups", "numPixels", "outAccumulator"},
+	{"traceAccumulator", "sampleSnapshot", "sampleStats"},
+	{"output"},
+	{"output"},
+	{"numRays", "paths", "hitFlags", "intersections", "output"},
+	{"rays", "numRays", "paths", "hitFlags", "intersections", "vertices", "normals", "tangents", "uv", "materialIndices", "vertexOcclusion", "materialNodes", "texMeta", "texData", "output"},
+	{"rays", "numRays", "paths", "hitFlags", "emissiveSamples", "maskOccluded", "maskNotOccluded", "output"},
+	{"paths", "output"},
+	{"sampleWeight", "paths", "accumulator", "output"},
+	{"output"},
+}
+
+// The defines shared with the kernels and the host constants that must
+// match their values.
+var abiDefines = []abiDefine{
+	{"PIXEL_FILTER_TENT", 0, uint64(TentFilter)},
+	{"PIXEL_FILTER_BOX", 1, uint64(BoxFilter)},
+	{"PIXEL_FILTER_GAUSSIAN", 2, uint64(GaussianFilter)},
+	{"PIXEL_FILTER_POINT", 3, uint64(PointFilter)},
+	{"TEXTURE_FILTER_RAY_DIFFERENTIALS", 0, uint64(RayDifferentialTextureFilter)},
+	{"TEXTURE_FILTER_TOP_MIP", 1, uint64(TopMipTextureFilter)},
+	{"SAMPLER_SOBOL", 0, uint64(SobolSampler)},
+	{"SAMPLER_HALTON", 1, uint64(HaltonSampler)},
+	{"SAMPLER_RANDOM", 2, uint64(RandomSampler)},
+	{"SAMPLER_BLUE_NOISE", 3, uint64(BlueNoiseSampler)},
+	{"BLUE_NOISE_SIZE", 64, uint64(blueNoiseSize)},
+	{"SHADING_NORMAL_FIX_NONE", 0, uint64(NoNormalCorrection)},
+	{"SHADING_NORMAL_FIX_CLAMP", 1, uint64(ClampNormalCorrection)},
+	{"SHADING_NORMAL_FIX_FLIP", 2, uint64(FlipNormalCorrection)},
+	{"LPE_EVENT_DIFFUSE", 0, uint64(lpeEventDiffuse)},
+	{"LPE_EVENT_GLOSSY", 1, uint64(lpeEventGlossy)},
+	{"LPE_EVENT_SPECULAR", 2, uint64(lpeEventSpecular)},
+	{"LPE_EVENT_LIGHT", 3, uint64(lpeEventLight)},
+	{"LPE_EVENT_BACKGROUND", 4, uint64(lpeEventBackground)},
+	{"LPE_NUM_EVENTS", 5, uint64(lpeNumEvents)},
+	{"LPE_MAX_STATES", 128, uint64(lpeMaxStates)},
+	{"LPE_ACCEPT_FLAG", 0x80, uint64(lpeAcceptFlag)},
+	{"TEXTURE_FEEDBACK_NONE", 0x7fffffff, uint64(textureFeedbackNone)},
+}
+
+// Arguments for the generatePrimaryRays kernel.
+type generatePrimaryRaysArgs struct {
+	Rays       *device.Buffer
+	NumRays    *device.Buffer
+	Paths      *device.Buffer
+	FrustrumTL types.Vec4
+	FrustrumTR types.Vec4
+	FrustrumBL types.Vec4
+	FrustrumBR types.Vec4
+	EyePos     types.Vec3
+	TexelDims  types.Vec2
+	BlockY     uint32
+	BlockH     uint32
+	FrameW     uint32
+	FrameH     uint32
+	RandSeed   uint32
+	// the path sampler settings and the blue-noise mask
+	SamplerType      uint32
+	SamplerSeed      uint32
+	SampleIndex      uint32
+	BlueNoise        *device.Buffer
+	PixelFilter      uint32
+	LensRight        types.Vec3
+	LensUp           types.Vec3
+	FocusNormal      types.Vec3
+	FocusDistance    float32
+	ApertureBlades   uint32
+	ApertureRotation float32
+	BokehSamples     *device.Buffer
+	NumBokehSamples  uint32
+	BokehJitter      float32
+	// the block-relative pixel index for each ray
+	RayPixels *device.Buffer
+}
+
+// Bind the arguments to the generatePrimaryRays kernel.
+func (a generatePrimaryRaysArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, generatePrimaryRays,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.FrustrumTL,
+		a.FrustrumTR,
+		a.FrustrumBL,
+		a.FrustrumBR,
+		a.EyePos,
+		a.TexelDims,
+		a.BlockY,
+		a.BlockH,
+		a.FrameW,
+		a.FrameH,
+		a.RandSeed,
+		a.SamplerType,
+		a.SamplerSeed,
+		a.SampleIndex,
+		a.BlueNoise,
+		a.PixelFilter,
+		a.LensRight,
+		a.LensUp,
+		a.FocusNormal,
+		a.FocusDistance,
+		a.ApertureBlades,
+		a.ApertureRotation,
+		a.BokehSamples,
+		a.NumBokehSamples,
+		a.BokehJitter,
+		a.RayPixels,
+	)
+}
+
+// Arguments for the generateCustomRays kernel.
+type generateCustomRaysArgs struct {
+	Rays       *device.Buffer
+	NumRays    *device.Buffer
+	Paths      *device.Buffer
+	CameraRays *device.Buffer
+	RayOffset  uint32
+	BlockY     uint32
+	BlockH     uint32
+	FrameW     uint32
+	// the block-relative pixel index for each ray
+	RayPixels *device.Buffer
+}
+
+// Bind the arguments to the generateCustomRays kernel.
+func (a generateCustomRaysArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, generateCustomRays,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.CameraRays,
+		a.RayOffset,
+		a.BlockY,
+		a.BlockH,
+		a.FrameW,
+		a.RayPixels,
+	)
+}
+
+// Arguments for the rayIntersectionTest kernel.
+type rayIntersectionTestArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	BvhNodes      *device.Buffer
+	MeshInstances *device.Buffer
+	VertexList    *device.Buffer
+	HitFlag       *device.Buffer
+	// incremented for each ray whose traversal overflowed the BVH stack
+	StackOverflows *device.Buffer
+	// material and texture data for testing hits against alpha cutouts
+	MaterialIndices *device.Buffer
+	MaterialNodes   *device.Buffer
+	Uv              *device.Buffer
+	TexMeta         *device.Buffer
+	TexData         *device.Buffer
+	// compressed BVH nodes and the compressed BVH root of each mesh
+	// instance; only used if the kernels are built with BVH_COMPRESSED
+	CompressedBvhNodes *device.Buffer
+	CompressedBvhRoots *device.Buffer
+}
+
+// Bind the arguments to the rayIntersectionTest kernel.
+func (a rayIntersectionTestArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, rayIntersectionTest,
+		a.Rays,
+		a.NumRays,
+		a.BvhNodes,
+		a.MeshInstances,
+		a.VertexList,
+		a.HitFlag,
+		a.StackOverflows,
+		a.MaterialIndices,
+		a.MaterialNodes,
+		a.Uv,
+		a.TexMeta,
+		a.TexData,
+		a.CompressedBvhNodes,
+		a.CompressedBvhRoots,
+	)
+}
+
+// Arguments for the rayIntersectionQuery kernel.
+type rayIntersectionQueryArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	BvhNodes      *device.Buffer
+	MeshInstances *device.Buffer
+	VertexList    *device.Buffer
+	HitFlag       *device.Buffer
+	Intersections *device.Buffer
+	// incremented for each ray whose traversal overflowed the BVH stack
+	StackOverflows *device.Buffer
+	// mesh instances with any of these flags set are skipped
+	SkipInstanceFlags uint32
+	// material and texture data for testing hits against alpha cutouts
+	MaterialIndices *device.Buffer
+	MaterialNodes   *device.Buffer
+	Uv              *device.Buffer
+	TexMeta         *device.Buffer
+	TexData         *device.Buffer
+	// compressed BVH nodes and the compressed BVH root of each mesh
+	// instance; only used if the kernels are built with BVH_COMPRESSED
+	CompressedBvhNodes *device.Buffer
+	CompressedBvhRoots *device.Buffer
+}
+
+// Bind the arguments to the rayIntersectionQuery kernel.
+func (a rayIntersectionQueryArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, rayIntersectionQuery,
+		a.Rays,
+		a.NumRays,
+		a.BvhNodes,
+		a.MeshInstances,
+		a.VertexList,
+		a.HitFlag,
+		a.Intersections,
+		a.StackOverflows,
+		a.SkipInstanceFlags,
+		a.MaterialIndices,
+		a.MaterialNodes,
+		a.Uv,
+		a.TexMeta,
+		a.TexData,
+		a.CompressedBvhNodes,
+		a.CompressedBvhRoots,
+	)
+}
+
+// Arguments for the rayPacketIntersectionQuery kernel.
+type rayPacketIntersectionQueryArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	BvhNodes      *device.Buffer
+	MeshInstances *device.Buffer
+	VertexList    *device.Buffer
+	HitFlag       *device.Buffer
+	Intersections *device.Buffer
+	// incremented for each ray whose traversal overflowed the BVH stack
+	StackOverflows *device.Buffer
+	// mesh instances with any of these flags set are skipped
+	SkipInstanceFlags uint32
+	// material and texture data for testing hits against alpha cutouts
+	MaterialIndices *device.Buffer
+	MaterialNodes   *device.Buffer
+	Uv              *device.Buffer
+	TexMeta         *device.Buffer
+	TexData         *device.Buffer
+	// compressed BVH nodes and the compressed BVH root of each mesh
+	// instance; only used if the kernels are built with BVH_COMPRESSED
+	CompressedBvhNodes *device.Buffer
+	CompressedBvhRoots *device.Buffer
+}
+
+// Bind the arguments to the rayPacketIntersectionQuery kernel.
+func (a rayPacketIntersectionQueryArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, rayPacketIntersectionQuery,
+		a.Rays,
+		a.NumRays,
+		a.BvhNodes,
+		a.MeshInstances,
+		a.VertexList,
+		a.HitFlag,
+		a.Intersections,
+		a.StackOverflows,
+		a.SkipInstanceFlags,
+		a.MaterialIndices,
+		a.MaterialNodes,
+		a.Uv,
+		a.TexMeta,
+		a.TexData,
+		a.CompressedBvhNodes,
+		a.CompressedBvhRoots,
+	)
+}
+
+// Arguments for the coneOcclusionTest kernel.
+type coneOcclusionTestArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	BvhNodes      *device.Buffer
+	MeshInstances *device.Buffer
+	VertexList    *device.Buffer
+	HitFlag       *device.Buffer
+	// incremented for each ray whose traversal overflowed the BVH stack
+	StackOverflows *device.Buffer
+	// the light cone of each ray and the emissive samples to be scaled
+	OcclusionCones  *device.Buffer
+	EmissiveSamples *device.Buffer
+	// material and texture data for testing hits against alpha cutouts
+	MaterialIndices *device.Buffer
+	MaterialNodes   *device.Buffer
+	Uv              *device.Buffer
+	TexMeta         *device.Buffer
+	TexData         *device.Buffer
+}
+
+// Bind the arguments to the coneOcclusionTest kernel.
+func (a coneOcclusionTestArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, coneOcclusionTest,
+		a.Rays,
+		a.NumRays,
+		a.BvhNodes,
+		a.MeshInstances,
+		a.VertexList,
+		a.HitFlag,
+		a.StackOverflows,
+		a.OcclusionCones,
+		a.EmissiveSamples,
+		a.MaterialIndices,
+		a.MaterialNodes,
+		a.Uv,
+		a.TexMeta,
+		a.TexData,
+	)
+}
+
+// Arguments for the sampleMediumInteractions kernel.
+type sampleMediumInteractionsArgs struct {
+	Rays                    *device.Buffer
+	NumRays                 *device.Buffer
+	Paths                   *device.Buffer
+	HitFlags                *device.Buffer
+	Intersections           *device.Buffer
+	MaterialNodes           *device.Buffer
+	PathMedia               *device.Buffer
+	SceneMediumMatNodeIndex int32
+	// the area lights used for equi-angular distance sampling
+	Vertices     *device.Buffer
+	Emissives    *device.Buffer
+	NumEmissives uint32
+	Bounce       uint32
+	RandSeed     uint32
+	// the path sampler settings and the blue-noise mask
+	SamplerType uint32
+	SamplerSeed uint32
+	SampleIndex uint32
+	FrameW      uint32
+	BlueNoise   *device.Buffer
+	// the density and emission grids of heterogeneous media
+	VolumeGrids *device.Buffer
+	VolumeData  *device.Buffer
+	// output accumulator for the light emitted by heterogeneous media
+	FrameH        uint32
+	ClampDirect   float32
+	ClampIndirect float32
+	Accumulator   *device.Buffer
+	// light path expressions
+	NumLpeExpressions uint32
+	LpeTransitions    *device.Buffer
+	LpeStates         *device.Buffer
+	LpeAccumulator    *device.Buffer
+	LightGroupMasks   *device.Buffer
+}
+
+// Bind the arguments to the sampleMediumInteractions kernel.
+func (a sampleMediumInteractionsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, sampleMediumInteractions,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.MaterialNodes,
+		a.PathMedia,
+		a.SceneMediumMatNodeIndex,
+		a.Vertices,
+		a.Emissives,
+		a.NumEmissives,
+		a.Bounce,
+		a.RandSeed,
+		a.SamplerType,
+		a.SamplerSeed,
+		a.SampleIndex,
+		a.FrameW,
+		a.BlueNoise,
+		a.VolumeGrids,
+		a.VolumeData,
+		a.FrameH,
+		a.ClampDirect,
+		a.ClampIndirect,
+		a.Accumulator,
+		a.NumLpeExpressions,
+		a.LpeTransitions,
+		a.LpeStates,
+		a.LpeAccumulator,
+		a.LightGroupMasks,
+	)
+}
+
+// Arguments for the recordTextureFeedback kernel.
+type recordTextureFeedbackArgs struct {
+	Rays            *device.Buffer
+	NumRays         *device.Buffer
+	Paths           *device.Buffer
+	HitFlags        *device.Buffer
+	Intersections   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	TextureFilter   uint32
+	// the minimum footprint for each material root node
+	TextureFeedback *device.Buffer
+}
+
+// Bind the arguments to the recordTextureFeedback kernel.
+func (a recordTextureFeedbackArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, recordTextureFeedback,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.Vertices,
+		a.Normals,
+		a.Uv,
+		a.MaterialIndices,
+		a.TextureFilter,
+		a.TextureFeedback,
+	)
+}
+
+// Arguments for the shadeHits kernel.
+type shadeHitsArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// scene data
+	MeshInstances   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Tangents        *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	VertexOcclusion *device.Buffer
+	MaterialNodes   *device.Buffer
+	Emissives       *device.Buffer
+	NumEmissives    uint32
+	// importance sampling distribution for the environment light; a zero
+	// width selects cosine-weighted sampling
+	EnvDistribution  *device.Buffer
+	EnvDistributionW uint32
+	EnvDistributionH uint32
+	// scene background
+	SceneDiffuseMatNodeIndex   int32
+	SceneBackplateMatNodeIndex int32
+	SceneEnvMatNodeIndex       int32
+	FrameW                     uint32
+	FrameH                     uint32
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// state
+	Bounce          uint32
+	MinBouncesForRR uint32
+	RandSeed        uint32
+	// the path sampler settings and the blue-noise mask
+	SamplerType      uint32
+	SamplerSeed      uint32
+	SampleIndex      uint32
+	BlueNoise        *device.Buffer
+	ShadingNormalFix uint32
+	TextureFilter    uint32
+	ClampDirect      float32
+	ClampIndirect    float32
+	// occlusion rays and samples
+	OcclusionRays    *device.Buffer
+	NumOcclusionRays *device.Buffer
+	EmissiveSamples  *device.Buffer
+	// the centroid (xyz) and radius (w) of the light sampled by each
+	// occlusion ray; the radius is 0 for rays towards the environment
+	OcclusionCones *device.Buffer
+	// indirect rays
+	IndirectRays    *device.Buffer
+	NumIndirectRays *device.Buffer
+	// output accumulator
+	Accumulator *device.Buffer
+	// light path expressions
+	NumLpeExpressions      uint32
+	LpeTransitions         *device.Buffer
+	LpeStates              *device.Buffer
+	EmissiveSampleLpeMasks *device.Buffer
+	LpeAccumulator         *device.Buffer
+	// the light group pass mask of the scene background followed by the
+	// mask of each material node
+	LightGroupMasks *device.Buffer
+	// bidirectional path tracing; a zero light subpath length disables the
+	// weighting of light samples
+	NumBounces       uint32
+	NumLightVertices uint32
+	// participating media; the medium of each path and the medium that
+	// fills the scene or -1 if the scene does not define one
+	PathMedia               *device.Buffer
+	SceneMediumMatNodeIndex int32
+	// the density grids of heterogeneous media for estimating the
+	// transmittance of occlusion rays
+	VolumeGrids *device.Buffer
+	VolumeData  *device.Buffer
+}
+
+// Bind the arguments to the shadeHits kernel.
+func (a shadeHitsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadeHits,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.MeshInstances,
+		a.Vertices,
+		a.Normals,
+		a.Tangents,
+		a.Uv,
+		a.MaterialIndices,
+		a.VertexOcclusion,
+		a.MaterialNodes,
+		a.Emissives,
+		a.NumEmissives,
+		a.EnvDistribution,
+		a.EnvDistributionW,
+		a.EnvDistributionH,
+		a.SceneDiffuseMatNodeIndex,
+		a.SceneBackplateMatNodeIndex,
+		a.SceneEnvMatNodeIndex,
+		a.FrameW,
+		a.FrameH,
+		a.TexMeta,
+		a.TexData,
+		a.Bounce,
+		a.MinBouncesForRR,
+		a.RandSeed,
+		a.SamplerType,
+		a.SamplerSeed,
+		a.SampleIndex,
+		a.BlueNoise,
+		a.ShadingNormalFix,
+		a.TextureFilter,
+		a.ClampDirect,
+		a.ClampIndirect,
+		a.OcclusionRays,
+		a.NumOcclusionRays,
+		a.EmissiveSamples,
+		a.OcclusionCones,
+		a.IndirectRays,
+		a.NumIndirectRays,
+		a.Accumulator,
+		a.NumLpeExpressions,
+		a.LpeTransitions,
+		a.LpeStates,
+		a.EmissiveSampleLpeMasks,
+		a.LpeAccumulator,
+		a.LightGroupMasks,
+		a.NumBounces,
+		a.NumLightVertices,
+		a.PathMedia,
+		a.SceneMediumMatNodeIndex,
+		a.VolumeGrids,
+		a.VolumeData,
+	)
+}
+
+// Arguments for the shadePrimaryRayMisses kernel.
+type shadePrimaryRayMissesArgs struct {
+	Rays                       *device.Buffer
+	NumRays                    *device.Buffer
+	Paths                      *device.Buffer
+	HitFlags                   *device.Buffer
+	MaterialNodes              *device.Buffer
+	SceneDiffuseMatNodeIndex   int32
+	SceneBackplateMatNodeIndex int32
+	// the environment light material or -1 if the scene does not
+	// define an environment map
+	SceneEnvMatNodeIndex int32
+	FrameW               uint32
+	FrameH               uint32
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// output
+	Accumulator *device.Buffer
+	// light path expressions
+	NumLpeExpressions uint32
+	LpeTransitions    *device.Buffer
+	LpeAccumulator    *device.Buffer
+	LightGroupMasks   *device.Buffer
+}
+
+// Bind the arguments to the shadePrimaryRayMisses kernel.
+func (a shadePrimaryRayMissesArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadePrimaryRayMisses,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.MaterialNodes,
+		a.SceneDiffuseMatNodeIndex,
+		a.SceneBackplateMatNodeIndex,
+		a.SceneEnvMatNodeIndex,
+		a.FrameW,
+		a.FrameH,
+		a.TexMeta,
+		a.TexData,
+		a.Accumulator,
+		a.NumLpeExpressions,
+		a.LpeTransitions,
+		a.LpeAccumulator,
+		a.LightGroupMasks,
+	)
+}
+
+// Arguments for the shadeIndirectRayMisses kernel.
+type shadeIndirectRayMissesArgs struct {
+	Rays                     *device.Buffer
+	NumRays                  *device.Buffer
+	Paths                    *device.Buffer
+	HitFlags                 *device.Buffer
+	MaterialNodes            *device.Buffer
+	SceneDiffuseMatNodeIndex int32
+	SceneEnvMatNodeIndex     int32
+	Bounce                   uint32
+	ClampDirect              float32
+	ClampIndirect            float32
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// output
+	Accumulator *device.Buffer
+	// light path expressions
+	NumLpeExpressions uint32
+	LpeTransitions    *device.Buffer
+	LpeStates         *device.Buffer
+	NumPixels         uint32
+	LpeAccumulator    *device.Buffer
+	LightGroupMasks   *device.Buffer
+}
+
+// Bind the arguments to the shadeIndirectRayMisses kernel.
+func (a shadeIndirectRayMissesArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadeIndirectRayMisses,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.MaterialNodes,
+		a.SceneDiffuseMatNodeIndex,
+		a.SceneEnvMatNodeIndex,
+		a.Bounce,
+		a.ClampDirect,
+		a.ClampIndirect,
+		a.TexMeta,
+		a.TexData,
+		a.Accumulator,
+		a.NumLpeExpressions,
+		a.LpeTransitions,
+		a.LpeStates,
+		a.NumPixels,
+		a.LpeAccumulator,
+		a.LightGroupMasks,
+	)
+}
+
+// Arguments for the shadeEmissiveHits kernel.
+type shadeEmissiveHitsArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// scene data
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Tangents        *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	VertexOcclusion *device.Buffer
+	MaterialNodes   *device.Buffer
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// state
+	Bounce        uint32
+	RandSeed      uint32
+	ClampDirect   float32
+	ClampIndirect float32
+	// output accumulator
+	Accumulator *device.Buffer
+	// light path expressions
+	NumLpeExpressions uint32
+	LpeTransitions    *device.Buffer
+	LpeStates         *device.Buffer
+	NumPixels         uint32
+	LpeAccumulator    *device.Buffer
+	LightGroupMasks   *device.Buffer
+}
+
+// Bind the arguments to the shadeEmissiveHits kernel.
+func (a shadeEmissiveHitsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadeEmissiveHits,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.Vertices,
+		a.Normals,
+		a.Tangents,
+		a.Uv,
+		a.MaterialIndices,
+		a.VertexOcclusion,
+		a.MaterialNodes,
+		a.TexMeta,
+		a.TexData,
+		a.Bounce,
+		a.RandSeed,
+		a.ClampDirect,
+		a.ClampIndirect,
+		a.Accumulator,
+		a.NumLpeExpressions,
+		a.LpeTransitions,
+		a.LpeStates,
+		a.NumPixels,
+		a.LpeAccumulator,
+		a.LightGroupMasks,
+	)
+}
+
+// Arguments for the accumulateEmissiveSamples kernel.
+type accumulateEmissiveSamplesArgs struct {
+	Rays            *device.Buffer
+	NumRays         *device.Buffer
+	Paths           *device.Buffer
+	HitFlags        *device.Buffer
+	EmissiveSamples *device.Buffer
+	Accumulator     *device.Buffer
+	// light path expressions
+	EmissiveSampleLpeMasks *device.Buffer
+	NumPixels              uint32
+	LpeAccumulator         *device.Buffer
+}
+
+// Bind the arguments to the accumulateEmissiveSamples kernel.
+func (a accumulateEmissiveSamplesArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, accumulateEmissiveSamples,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.EmissiveSamples,
+		a.Accumulator,
+		a.EmissiveSampleLpeMasks,
+		a.NumPixels,
+		a.LpeAccumulator,
+	)
+}
+
+// Arguments for the generateLightRays kernel.
+type generateLightRaysArgs struct {
+	Rays             *device.Buffer
+	NumRays          *device.Buffer
+	Paths            *device.Buffer
+	LightVertices    *device.Buffer
+	NumLightVertices uint32
+	NumPaths         uint32
+	// scene data
+	Vertices      *device.Buffer
+	Normals       *device.Buffer
+	Uv            *device.Buffer
+	MaterialNodes *device.Buffer
+	Emissives     *device.Buffer
+	NumEmissives  uint32
+	// texture data
+	TexMeta  *device.Buffer
+	TexData  *device.Buffer
+	RandSeed uint32
+	// the light group pass mask of the area light that starts each light
+	// subpath
+	LightGroupMasks     *device.Buffer
+	LightPathGroupMasks *device.Buffer
+}
+
+// Bind the arguments to the generateLightRays kernel.
+func (a generateLightRaysArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, generateLightRays,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.LightVertices,
+		a.NumLightVertices,
+		a.NumPaths,
+		a.Vertices,
+		a.Normals,
+		a.Uv,
+		a.MaterialNodes,
+		a.Emissives,
+		a.NumEmissives,
+		a.TexMeta,
+		a.TexData,
+		a.RandSeed,
+		a.LightGroupMasks,
+		a.LightPathGroupMasks,
+	)
+}
+
+// Arguments for the shadeLightHits kernel.
+type shadeLightHitsArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// scene data
+	MeshInstances   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Tangents        *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	VertexOcclusion *device.Buffer
+	MaterialNodes   *device.Buffer
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// state
+	Depth            uint32
+	MinBouncesForRR  uint32
+	RandSeed         uint32
+	ShadingNormalFix uint32
+	// light subpath vertices
+	LightVertices    *device.Buffer
+	NumLightVertices uint32
+	// next depth rays
+	OutRays    *device.Buffer
+	NumOutRays *device.Buffer
+}
+
+// Bind the arguments to the shadeLightHits kernel.
+func (a shadeLightHitsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadeLightHits,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.MeshInstances,
+		a.Vertices,
+		a.Normals,
+		a.Tangents,
+		a.Uv,
+		a.MaterialIndices,
+		a.VertexOcclusion,
+		a.MaterialNodes,
+		a.TexMeta,
+		a.TexData,
+		a.Depth,
+		a.MinBouncesForRR,
+		a.RandSeed,
+		a.ShadingNormalFix,
+		a.LightVertices,
+		a.NumLightVertices,
+		a.OutRays,
+		a.NumOutRays,
+	)
+}
+
+// Arguments for the connectLightVertex kernel.
+type connectLightVertexArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// scene data
+	MeshInstances   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Tangents        *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	VertexOcclusion *device.Buffer
+	MaterialNodes   *device.Buffer
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// state
+	Bounce           uint32
+	NumBounces       uint32
+	RandSeed         uint32
+	ShadingNormalFix uint32
+	TextureFilter    uint32
+	ClampIndirect    float32
+	// light subpath vertices
+	LightVertices       *device.Buffer
+	LightDepth          uint32
+	NumLightVertices    uint32
+	LightPathGroupMasks *device.Buffer
+	// connection rays and samples
+	ConnectionRays           *device.Buffer
+	NumConnectionRays        *device.Buffer
+	ConnectionSamples        *device.Buffer
+	ConnectionSampleLpeMasks *device.Buffer
+}
+
+// Bind the arguments to the connectLightVertex kernel.
+func (a connectLightVertexArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, connectLightVertex,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.MeshInstances,
+		a.Vertices,
+		a.Normals,
+		a.Tangents,
+		a.Uv,
+		a.MaterialIndices,
+		a.VertexOcclusion,
+		a.MaterialNodes,
+		a.TexMeta,
+		a.TexData,
+		a.Bounce,
+		a.NumBounces,
+		a.RandSeed,
+		a.ShadingNormalFix,
+		a.TextureFilter,
+		a.ClampIndirect,
+		a.LightVertices,
+		a.LightDepth,
+		a.NumLightVertices,
+		a.LightPathGroupMasks,
+		a.ConnectionRays,
+		a.NumConnectionRays,
+		a.ConnectionSamples,
+		a.ConnectionSampleLpeMasks,
+	)
+}
+
+// Arguments for the shadeAmbientOcclusion kernel.
+type shadeAmbientOcclusionArgs struct {
+	Rays          *device.Buffer
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// scene data
+	MeshInstances   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	// state
+	RandSeed uint32
+	// the path sampler settings and the blue-noise mask
+	SamplerType      uint32
+	SamplerSeed      uint32
+	SampleIndex      uint32
+	FrameW           uint32
+	BlueNoise        *device.Buffer
+	ShadingNormalFix uint32
+	MaxDistance      float32
+	// occlusion rays and samples
+	OcclusionRays          *device.Buffer
+	NumOcclusionRays       *device.Buffer
+	EmissiveSamples        *device.Buffer
+	EmissiveSampleLpeMasks *device.Buffer
+	// output accumulator
+	Accumulator *device.Buffer
+}
+
+// Bind the arguments to the shadeAmbientOcclusion kernel.
+func (a shadeAmbientOcclusionArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, shadeAmbientOcclusion,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.MeshInstances,
+		a.Vertices,
+		a.Normals,
+		a.Uv,
+		a.MaterialIndices,
+		a.RandSeed,
+		a.SamplerType,
+		a.SamplerSeed,
+		a.SampleIndex,
+		a.FrameW,
+		a.BlueNoise,
+		a.ShadingNormalFix,
+		a.MaxDistance,
+		a.OcclusionRays,
+		a.NumOcclusionRays,
+		a.EmissiveSamples,
+		a.EmissiveSampleLpeMasks,
+		a.Accumulator,
+	)
+}
+
+// Arguments for the bakeLocalOcclusion kernel.
+type bakeLocalOcclusionArgs struct {
+	Vertices *device.Buffer
+	Normals  *device.Buffer
+	BvhNodes *device.Buffer
+	// the mesh BVH root and the occlusion ray length for each triangle;
+	// vertices of triangles with a zero radius are not occluded
+	TriBvhRoots        *device.Buffer
+	TriOcclusionRadius *device.Buffer
+	NumVertices        uint32
+	NumSamples         uint32
+	RandSeed           uint32
+	VertexOcclusion    *device.Buffer
+}
+
+// Bind the arguments to the bakeLocalOcclusion kernel.
+func (a bakeLocalOcclusionArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, bakeLocalOcclusion,
+		a.Vertices,
+		a.Normals,
+		a.BvhNodes,
+		a.TriBvhRoots,
+		a.TriOcclusionRadius,
+		a.NumVertices,
+		a.NumSamples,
+		a.RandSeed,
+		a.VertexOcclusion,
+	)
+}
+
+// Arguments for the tonemapSimpleReinhard kernel.
+type tonemapSimpleReinhardArgs struct {
+	Accumulator  *device.Buffer
+	Paths        *device.Buffer
+	FrameBuffer  *device.Buffer
+	SampleWeight float32
+	Exposure     float32
+}
+
+// Bind the arguments to the tonemapSimpleReinhard kernel.
+func (a tonemapSimpleReinhardArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, tonemapSimpleReinhard,
+		a.Accumulator,
+		a.Paths,
+		a.FrameBuffer,
+		a.SampleWeight,
+		a.Exposure,
+	)
+}
+
+// Arguments for the clearAccumulator kernel.
+type clearAccumulatorArgs struct {
+	Accumulator *device.Buffer
+}
+
+// Bind the arguments to the clearAccumulator kernel.
+func (a clearAccumulatorArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, clearAccumulator,
+		a.Accumulator,
+	)
+}
+
+// Arguments for the aggregateAccumulator kernel.
+type aggregateAccumulatorArgs struct {
+	SrcAccumulator *device.Buffer
+	DstAccumulator *device.Buffer
+}
+
+// Bind the arguments to the aggregateAccumulator kernel.
+func (a aggregateAccumulatorArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, aggregateAccumulator,
+		a.SrcAccumulator,
+		a.DstAccumulator,
+	)
+}
+
+// Arguments for the aggregateAccumulatorCompensated kernel.
+type aggregateAccumulatorCompensatedArgs struct {
+	SrcAccumulator *device.Buffer
+	DstAccumulator *device.Buffer
+	Compensation   *device.Buffer
+}
+
+// Bind the arguments to the aggregateAccumulatorCompensated kernel.
+func (a aggregateAccumulatorCompensatedArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, aggregateAccumulatorCompensated,
+		a.SrcAccumulator,
+		a.DstAccumulator,
+		a.Compensation,
+	)
+}
+
+// Arguments for the mixLightGroups kernel.
+type mixLightGroupsArgs struct {
+	Accumulator         *device.Buffer
+	LpeAccumulator      *device.Buffer
+	LightGroupScales    *device.Buffer
+	FirstLightGroupPass uint32
+	NumLightGroups      uint32
+	NumPixels           uint32
+	OutAccumulator      *device.Buffer
+}
+
+// Bind the arguments to the mixLightGroups kernel.
+func (a mixLightGroupsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, mixLightGroups,
+		a.Accumulator,
+		a.LpeAccumulator,
+		a.LightGroupScales,
+		a.FirstLightGroupPass,
+		a.NumLightGroups,
+		a.NumPixels,
+		a.OutAccumulator,
+	)
+}
+
+// Arguments for the accumulateSampleStats kernel.
+type accumulateSampleStatsArgs struct {
+	TraceAccumulator *device.Buffer
+	SampleSnapshot   *device.Buffer
+	SampleStats      *device.Buffer
+}
+
+// Bind the arguments to the accumulateSampleStats kernel.
+func (a accumulateSampleStatsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, accumulateSampleStats,
+		a.TraceAccumulator,
+		a.SampleSnapshot,
+		a.SampleStats,
+	)
+}
+
+// Arguments for the debugClearBuffer kernel.
+type debugClearBufferArgs struct {
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugClearBuffer kernel.
+func (a debugClearBufferArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugClearBuffer,
+		a.Output,
+	)
+}
+
+// Arguments for the debugClearValues kernel.
+type debugClearValuesArgs struct {
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugClearValues kernel.
+func (a debugClearValuesArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugClearValues,
+		a.Output,
+	)
+}
+
+// Arguments for the debugRayIntersectionDepth kernel.
+type debugRayIntersectionDepthArgs struct {
+	NumRays       *device.Buffer
+	Paths         *device.Buffer
+	HitFlags      *device.Buffer
+	Intersections *device.Buffer
+	// raw values; the w component is set to 1 for pixels with data
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugRayIntersectionDepth kernel.
+func (a debugRayIntersectionDepthArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugRayIntersectionDepth,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.Output,
+	)
+}
+
+// Arguments for the debugRayIntersectionNormals kernel.
+type debugRayIntersectionNormalsArgs struct {
+	Rays            *device.Buffer
+	NumRays         *device.Buffer
+	Paths           *device.Buffer
+	HitFlags        *device.Buffer
+	Intersections   *device.Buffer
+	Vertices        *device.Buffer
+	Normals         *device.Buffer
+	Tangents        *device.Buffer
+	Uv              *device.Buffer
+	MaterialIndices *device.Buffer
+	VertexOcclusion *device.Buffer
+	MaterialNodes   *device.Buffer
+	// texture data
+	TexMeta *device.Buffer
+	TexData *device.Buffer
+	// output
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugRayIntersectionNormals kernel.
+func (a debugRayIntersectionNormalsArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugRayIntersectionNormals,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.Intersections,
+		a.Vertices,
+		a.Normals,
+		a.Tangents,
+		a.Uv,
+		a.MaterialIndices,
+		a.VertexOcclusion,
+		a.MaterialNodes,
+		a.TexMeta,
+		a.TexData,
+		a.Output,
+	)
+}
+
+// Arguments for the debugEmissiveSamples kernel.
+type debugEmissiveSamplesArgs struct {
+	Rays            *device.Buffer
+	NumRays         *device.Buffer
+	Paths           *device.Buffer
+	HitFlags        *device.Buffer
+	EmissiveSamples *device.Buffer
+	MaskOccluded    uint32
+	MaskNotOccluded uint32
+	// raw values; the w component is set to 1 for pixels with data
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugEmissiveSamples kernel.
+func (a debugEmissiveSamplesArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugEmissiveSamples,
+		a.Rays,
+		a.NumRays,
+		a.Paths,
+		a.HitFlags,
+		a.EmissiveSamples,
+		a.MaskOccluded,
+		a.MaskNotOccluded,
+		a.Output,
+	)
+}
+
+// Arguments for the debugThroughput kernel.
+type debugThroughputArgs struct {
+	Paths *device.Buffer
+	// raw values; the w component is set to 1 for pixels with data
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugThroughput kernel.
+func (a debugThroughputArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugThroughput,
+		a.Paths,
+		a.Output,
+	)
+}
+
+// Arguments for the debugAccumulator kernel.
+type debugAccumulatorArgs struct {
+	SampleWeight float32
+	Paths        *device.Buffer
+	Accumulator  *device.Buffer
+	// raw values; the w component is set to 1 for pixels with data
+	Output *device.Buffer
+}
+
+// Bind the arguments to the debugAccumulator kernel.
+func (a debugAccumulatorArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, debugAccumulator,
+		a.SampleWeight,
+		a.Paths,
+		a.Accumulator,
+		a.Output,
+	)
+}
+
+// Arguments for the getLayoutInfo kernel.
+type getLayoutInfoArgs struct {
+	Output *device.Buffer
+}
+
+// Bind the arguments to the getLayoutInfo kernel.
+func (a getLayoutInfoArgs) bind(k argBinder) error {
+	return bindKernelArgs(k, getLayoutInfo,
+		a.Output,
+	)
+}
@@ -0,0 +1,79 @@
+package opencl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+type mockArgBinder struct {
+	args []interface{}
+}
+
+func (b *mockArgBinder) SetArgs(args ...interface{}) error {
+	b.args = args
+	return nil
+}
+
+func TestStageABIDefines(t *testing.T) {
+	for _, define := range abiDefines {
+		if define.value != define.hostValue {
+			t.Errorf("expected the host constant for %s to be %d; got %d", define.name, define.value, define.hostValue)
+		}
+	}
+}
+
+func TestKernelArgsBind(t *testing.T) {
+	buf := &device.Buffer{}
+	binder := &mockArgBinder{}
+	err := rayIntersectionQueryArgs{
+		Rays:               buf,
+		NumRays:            buf,
+		BvhNodes:           buf,
+		MeshInstances:      buf,
+		VertexList:         buf,
+		HitFlag:            buf,
+		Intersections:      buf,
+		StackOverflows:     buf,
+		SkipInstanceFlags:  3,
+		MaterialIndices:    buf,
+		MaterialNodes:      buf,
+		Uv:                 buf,
+		TexMeta:            buf,
+		TexData:            buf,
+		CompressedBvhNodes: buf,
+		CompressedBvhRoots: buf,
+	}.bind(binder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(binder.args) != len(kernelArgNames[rayIntersectionQuery]) {
+		t.Fatalf("expected %d bound args; got %d", len(kernelArgNames[rayIntersectionQuery]), len(binder.args))
+	}
+	if flags := binder.args[8]; flags != uint32(3) {
+		t.Fatalf("expected the ninth bound arg to be the skip flags; got %v", flags)
+	}
+
+	// Unset buffers should be detected before binding
+	binder.args = nil
+	err = aggregateAccumulatorArgs{SrcAccumulator: buf}.bind(binder)
+	if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidKernelArgs.Error()) || !strings.Contains(err.Error(), `"dstAccumulator"`) {
+		t.Fatalf("expected missing buffer error; got %v", err)
+	}
+	if binder.args != nil {
+		t.Fatal("expected no arguments to be bound when a buffer is missing")
+	}
+
+	err = bindKernelArgs(binder, clearAccumulator, buf, buf)
+	if err == nil || !strings.Contains(err.Error(), "expects 1 arguments") {
+		t.Fatalf("expected argument count error; got %v", err)
+	}
+}
+
+func TestKernelTypeString(t *testing.T) {
+	if got := shadeHits.String(); got != "shadeHits" {
+		t.Fatalf("expected kernel name to be shadeHits; got %s", got)
+	}
+}
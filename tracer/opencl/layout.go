@@ -0,0 +1,109 @@
+package opencl
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+// The size of a struct shared between the host and the opencl kernels.
+type structLayout struct {
+	name string
+	size uint32
+}
+
+// The list of structures shared with the opencl kernels in the order that
+// they are reported by the getLayoutInfo kernel.
+var sharedLayouts = []structLayout{
+	{"Ray", sizeofRay},
+	{"Path", sizeofPath},
+	{"Intersection", sizeofIntersection},
+	{"BvhNode", uint32(unsafe.Sizeof(scene.BvhNode{}))},
+	{"MeshInstance", uint32(unsafe.Sizeof(scene.MeshInstance{}))},
+	{"MaterialNode", uint32(unsafe.Sizeof(scene.MaterialNode{}))},
+	{"Emissive", uint32(unsafe.Sizeof(scene.EmissivePrimitive{}))},
+	{"TextureMetadata", uint32(unsafe.Sizeof(scene.TextureMetadata{}))},
+	{"LightVertex", sizeofLightVertex},
+	{"CompressedBvhNode", uint32(unsafe.Sizeof(scene.CompressedBvhNode{}))},
+	{"VolumeGrid", uint32(unsafe.Sizeof(scene.VolumeGrid{}))},
+}
+
+// The number of entries reported by the getLayoutInfo kernel: the layout
+// version, the size of each shared structure and the stage ABI version.
+var numLayoutEntries = len(sharedLayouts) + 2
+
+// Compare the layout information reported by the getLayoutInfo kernel with
+// the layouts expected by the host. The first element of the device info
+// contains the layout version and is followed by the size of each shared
+// structure. The last element contains the stage ABI version.
+func compareLayouts(devInfo []uint32) error {
+	if len(devInfo) != numLayoutEntries {
+		return fmt.Errorf("%s: expected %d layout entries; device reported %d", ErrLayoutMismatch.Error(), numLayoutEntries, len(devInfo))
+	}
+
+	if devInfo[0] != scene.LayoutVersion {
+		return fmt.Errorf("%s: host uses layout version %d; kernels use version %d", ErrLayoutMismatch.Error(), scene.LayoutVersion, devInfo[0])
+	}
+
+	for index, layout := range sharedLayouts {
+		if devSize := devInfo[index+1]; devSize != layout.size {
+			return fmt.Errorf("%s: sizeof(%s) is %d bytes on host and %d bytes on device", ErrLayoutMismatch.Error(), layout.name, layout.size, devSize)
+		}
+	}
+
+	if abiVersion := devInfo[len(devInfo)-1]; abiVersion != stageABIVersion {
+		return fmt.Errorf("%s: host uses stage ABI version %d; kernels use version %d", ErrLayoutMismatch.Error(), stageABIVersion, abiVersion)
+	}
+
+	return nil
+}
+
+// Check whether the host is using little-endian byte ordering.
+func hostIsLittleEndian() bool {
+	var probe uint16 = 1
+	return *(*byte)(unsafe.Pointer(&probe)) == 1
+}
+
+// Verify that the structure layouts used by the device kernels match the
+// layouts used by the host.
+func (dr *deviceResources) CheckLayouts(dev *device.Device) error {
+	devLittleEndian, err := dev.IsLittleEndian()
+	if err != nil {
+		return err
+	}
+	if devLittleEndian != hostIsLittleEndian() {
+		return fmt.Errorf("%s: device and host use different byte ordering", ErrLayoutMismatch.Error())
+	}
+
+	out := dev.Buffer("layoutInfo")
+	defer out.Release()
+
+	devInfo := make([]uint32, numLayoutEntries)
+	err = out.AllocateToFitData(devInfo, cl.MEM_WRITE_ONLY)
+	if err != nil {
+		return err
+	}
+
+	kernel := dr.kernels[getLayoutInfo]
+	err = getLayoutInfoArgs{
+		Output: out,
+	}.bind(kernel)
+	if err != nil {
+		return err
+	}
+
+	_, err = kernel.Exec1D(0, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	err = out.ReadData(0, 0, 0, devInfo)
+	if err != nil {
+		return err
+	}
+
+	return compareLayouts(devInfo)
+}
@@ -0,0 +1,53 @@
+package opencl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+)
+
+func expectedLayoutInfo() []uint32 {
+	info := []uint32{scene.LayoutVersion}
+	for _, layout := range sharedLayouts {
+		info = append(info, layout.size)
+	}
+	return append(info, stageABIVersion)
+}
+
+func TestCompareLayouts(t *testing.T) {
+	err := compareLayouts(expectedLayoutInfo())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Version mismatch
+	info := expectedLayoutInfo()
+	info[0]++
+	err = compareLayouts(info)
+	if err == nil || !strings.Contains(err.Error(), "layout version") {
+		t.Fatalf("expected version mismatch error; got %v", err)
+	}
+
+	// Size mismatch due to different padding
+	info = expectedLayoutInfo()
+	info[6] += 16
+	err = compareLayouts(info)
+	if err == nil || !strings.Contains(err.Error(), "sizeof(MaterialNode)") {
+		t.Fatalf("expected MaterialNode size mismatch error; got %v", err)
+	}
+
+	// Stage ABI version mismatch
+	info = expectedLayoutInfo()
+	info[len(info)-1]++
+	err = compareLayouts(info)
+	if err == nil || !strings.Contains(err.Error(), "stage ABI version") {
+		t.Fatalf("expected stage ABI version mismatch error; got %v", err)
+	}
+
+	// Truncated info
+	err = compareLayouts(info[:3])
+	if err == nil || !strings.HasPrefix(err.Error(), ErrLayoutMismatch.Error()) {
+		t.Fatalf("expected layout mismatch error; got %v", err)
+	}
+}
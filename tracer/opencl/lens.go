@@ -0,0 +1,57 @@
+package opencl
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+// Camera lens settings used by the primary ray generation kernel.
+type cameraLens struct {
+	// The camera right and up vectors scaled by the aperture radius.
+	right types.Vec3
+	up    types.Vec3
+
+	// The normal of the plane in focus and its distance from the camera
+	// eye. A zero distance disables depth of field and all primary rays
+	// originate from the camera eye.
+	focusNormal   types.Vec3
+	focusDistance float32
+
+	// Aperture shape.
+	blades   uint32
+	rotation float32
+
+	// Lens samples generated from a bokeh mask.
+	bokehSamples []types.Vec2
+	bokehJitter  float32
+}
+
+// Extract the lens settings from a scene camera.
+func newCameraLens(camera *scene.Camera) cameraLens {
+	if !camera.HasDepthOfField() {
+		return cameraLens{}
+	}
+
+	right, up, _ := camera.Basis()
+	focusNormal, focusDistance := camera.FocusPlane()
+	return cameraLens{
+		right:         right.Mul(camera.ApertureRadius),
+		up:            up.Mul(camera.ApertureRadius),
+		focusNormal:   focusNormal,
+		focusDistance: focusDistance,
+		blades:        camera.ApertureBlades,
+		rotation:      camera.ApertureRotation * math.Pi / 180.0,
+		bokehSamples:  camera.BokehSamples,
+		bokehJitter:   camera.BokehJitter,
+	}
+}
+
+// Returns true if both slices refer to the same set of bokeh samples.
+func sameBokehSamples(a, b []types.Vec2) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
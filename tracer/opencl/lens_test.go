@@ -0,0 +1,36 @@
+package opencl
+
+import (
+	"math"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/types"
+)
+
+func TestCameraLens(t *testing.T) {
+	cam := scene.NewCamera(45)
+	cam.Position = types.XYZ(0, 0, 5)
+	cam.LookAt = types.XYZ(0, 0, 0)
+
+	// Pinhole cameras disable depth of field
+	if lens := newCameraLens(cam); lens.focusDistance != 0 {
+		t.Fatalf("expected pinhole camera lens to disable depth of field; got focus distance %f", lens.focusDistance)
+	}
+
+	cam.ApertureRadius = 0.5
+	cam.FocusDistance = 4
+	cam.ApertureBlades = 6
+	cam.ApertureRotation = 90
+	lens := newCameraLens(cam)
+
+	if lens.focusDistance != 4 || lens.blades != 6 {
+		t.Fatalf("unexpected lens settings: %+v", lens)
+	}
+	if math.Abs(float64(lens.rotation)-math.Pi/2) > 1e-5 {
+		t.Fatalf("expected lens rotation to be converted to radians; got %f", lens.rotation)
+	}
+	if lens.right != types.XYZ(0.5, 0, 0) || lens.up != types.XYZ(0, 0.5, 0) || lens.focusNormal != types.XYZ(0, 0, -1) {
+		t.Fatalf("expected lens vectors to be scaled by the aperture radius; got right %v, up %v, focus normal %v", lens.right, lens.up, lens.focusNormal)
+	}
+}
@@ -0,0 +1,87 @@
+package opencl
+
+import (
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+)
+
+// The max number of light groups that can be accumulated by the tracer. The
+// kernels record the light groups of each sample in the same 32-bit pass mask
+// as the light path expressions that accept it.
+const MaxLightGroups = 8
+
+// Build the light group pass mask table for the material nodes of a scene.
+// Light group 0 collects the light reaching the camera from the scene
+// background and the environment light while each of the remaining groups
+// collects the light emitted by an emissive material node or by a medium with
+// an emission grid in the order that the nodes are defined. If the scene defines more emissive nodes than can fit
+// in MaxLightGroups, the remaining nodes share the last group. The light group
+// passes are stored after the passes of the numExpressions light path
+// expressions.
+//
+// The returned table contains the mask of the background followed by the mask
+// of each material node; nodes that do not emit light have an empty mask.
+func lightGroupMasks(sc *scene.Scene, numExpressions int) ([]uint32, int) {
+	masks := make([]uint32, len(sc.MaterialNodeList)+1)
+	groupMask := func(group int) uint32 {
+		return 1 << uint(numExpressions+group)
+	}
+
+	masks[0] = groupMask(0)
+	for _, nodeIndex := range []int32{sc.SceneDiffuseMatIndex, sc.SceneBackplateMatIndex, sc.SceneEmissiveMatIndex} {
+		if nodeIndex >= 0 && int(nodeIndex) < len(sc.MaterialNodeList) {
+			masks[nodeIndex+1] = masks[0]
+		}
+	}
+	for _, emissive := range sc.EmissivePrimitives {
+		if emissive.Type == scene.EnvironmentLight && int(emissive.MaterialNodeIndex) < len(sc.MaterialNodeList) {
+			masks[emissive.MaterialNodeIndex+1] = masks[0]
+		}
+	}
+
+	numGroups := 1
+	for nodeIndex, node := range sc.MaterialNodeList {
+		if masks[nodeIndex+1] != 0 || !emitsLight(node) {
+			continue
+		}
+
+		if numGroups < MaxLightGroups {
+			numGroups++
+		}
+		masks[nodeIndex+1] = groupMask(numGroups - 1)
+	}
+
+	return masks, numGroups
+}
+
+// Check whether a material node emits light.
+func emitsLight(node scene.MaterialNode) bool {
+	switch uint32(node.Union1[0]) {
+	case uint32(material.BxdfEmissive):
+		return true
+	case uint32(material.OpMedium):
+		return node.Union1[3] >= 0
+	}
+	return false
+}
+
+// Expand a list of light group intensity multipliers to one multiplier per
+// light group. Groups without a multiplier use 1 and extra multipliers are
+// ignored. If all multipliers are 1, nil is returned as the light group
+// passes do not need to be mixed.
+func lightGroupScales(scales []float32, numGroups int) []float32 {
+	out := make([]float32, numGroups)
+	mix := false
+	for group := range out {
+		out[group] = 1.0
+		if group < len(scales) {
+			out[group] = scales[group]
+		}
+		mix = mix || out[group] != 1.0
+	}
+
+	if !mix {
+		return nil
+	}
+	return out
+}
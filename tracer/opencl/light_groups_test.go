@@ -0,0 +1,74 @@
+package opencl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+)
+
+func TestLightGroupMasks(t *testing.T) {
+	var diffuse, emissive scene.MaterialNode
+	diffuse.Union1[0] = int32(material.BxdfDiffuse)
+	emissive.Union1[0] = int32(material.BxdfEmissive)
+
+	sc := &scene.Scene{
+		// 0: scene diffuse, 1: scene emissive, 2: diffuse, 3-4: emissives, 5: env light
+		MaterialNodeList:       []scene.MaterialNode{diffuse, emissive, diffuse, emissive, emissive, emissive},
+		SceneDiffuseMatIndex:   0,
+		SceneEmissiveMatIndex:  1,
+		SceneBackplateMatIndex: -1,
+		EmissivePrimitives: []scene.EmissivePrimitive{
+			{Type: scene.EnvironmentLight, MaterialNodeIndex: 5},
+		},
+	}
+
+	masks, numGroups := lightGroupMasks(sc, 2)
+	if numGroups != 3 {
+		t.Fatalf("expected 3 light groups; got %d", numGroups)
+	}
+	expMasks := []uint32{1 << 2, 1 << 2, 1 << 2, 0, 1 << 3, 1 << 4, 1 << 2}
+	if !reflect.DeepEqual(masks, expMasks) {
+		t.Fatalf("expected masks to be %v; got %v", expMasks, masks)
+	}
+
+	// Emissive nodes that do not fit share the last group
+	for index := 0; index < MaxLightGroups; index++ {
+		sc.MaterialNodeList = append(sc.MaterialNodeList, emissive)
+	}
+	masks, numGroups = lightGroupMasks(sc, 0)
+	if numGroups != MaxLightGroups {
+		t.Fatalf("expected %d light groups; got %d", MaxLightGroups, numGroups)
+	}
+	lastMask := uint32(1 << (MaxLightGroups - 1))
+	if last := masks[len(masks)-1]; last != lastMask || masks[len(masks)-2] != lastMask {
+		t.Fatalf("expected the extra emissive nodes to use mask %d; got %v", lastMask, masks)
+	}
+
+	// Media with an emission grid get their own group
+	var medium, emissiveMedium scene.MaterialNode
+	medium.Union1 = [4]int32{int32(material.OpMedium), -1, 0, -1}
+	emissiveMedium.Union1 = [4]int32{int32(material.OpMedium), -1, -1, 1}
+	sc.MaterialNodeList = []scene.MaterialNode{diffuse, emissive, medium, emissiveMedium}
+	masks, numGroups = lightGroupMasks(sc, 0)
+	expMasks = []uint32{1, 1, 1, 0, 1 << 1}
+	if numGroups != 2 || !reflect.DeepEqual(masks, expMasks) {
+		t.Fatalf("expected 2 light groups with masks %v; got %d groups with masks %v", expMasks, numGroups, masks)
+	}
+}
+
+func TestLightGroupScales(t *testing.T) {
+	if scales := lightGroupScales(nil, 3); scales != nil {
+		t.Fatalf("expected nil scales when no multipliers are specified; got %v", scales)
+	}
+	if scales := lightGroupScales([]float32{1, 1, 1, 1}, 2); scales != nil {
+		t.Fatalf("expected nil scales when all multipliers are 1; got %v", scales)
+	}
+
+	scales := lightGroupScales([]float32{1, 0.5, 1, 2}, 3)
+	expScales := []float32{1, 0.5, 1}
+	if !reflect.DeepEqual(scales, expScales) {
+		t.Fatalf("expected scales to be %v; got %v", expScales, scales)
+	}
+}
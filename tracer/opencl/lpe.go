@@ -0,0 +1,423 @@
+package opencl
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// Light path events. These values must match the LPE_EVENT_* defines in
+// stages.abi.
+const (
+	lpeEventDiffuse uint8 = iota
+	lpeEventGlossy
+	lpeEventSpecular
+	lpeEventLight
+	lpeEventBackground
+	lpeNumEvents
+)
+
+// The max number of light path expressions that can be evaluated by the
+// tracer. The kernels pack the DFA state of each expression into a byte of a
+// 32-bit per-path value.
+const MaxLightPathExpressions = 4
+
+const (
+	// The max number of DFA states for each expression. State 0 is the
+	// dead state and state 1 is the state after the camera event.
+	lpeMaxStates = 128
+
+	// The bit that is set in a DFA transition if the next state accepts
+	// the path.
+	lpeAcceptFlag = 0x80
+)
+
+// The characters used for each event in light path expressions.
+var lpeEventSymbols = [lpeNumEvents]byte{'D', 'G', 'S', 'L', 'B'}
+
+// A LightPathExpression selects the light paths that contribute to a custom
+// output pass. Expressions use a subset of the OSL light path expression
+// syntax. Each path is described by a sequence of events:
+//   - C: the camera; each expression must start with this event
+//   - D: diffuse scattering
+//   - G: glossy scattering (rough conductors and dielectrics)
+//   - S: specular scattering (smooth conductors and dielectrics)
+//   - L: an emissive surface
+//   - B: the scene background
+//
+// Events can be combined using the following operators:
+//   - '.' matches any event
+//   - '[DG]' matches any of the enclosed events and '[^S]' any other event
+//   - '*', '+' and '?' repeat the previous event or group
+//   - '|' matches either the expression on its left or its right
+//   - '(...)' groups events
+//
+// For example, "CD*L" selects paths that reach a light after any number of
+// diffuse bounces and "C[GS].*[LB]" selects all reflections and refractions.
+type LightPathExpression struct {
+	// The pass name.
+	Name string
+
+	// The expression source.
+	Expr string
+
+	// The compiled DFA transitions. The table contains lpeNumEvents
+	// entries for each of the lpeMaxStates states.
+	transitions []uint8
+}
+
+// Compile a light path expression for the output pass with the given name.
+func ParseLightPathExpression(name, expr string) (*LightPathExpression, error) {
+	p := &lpeParser{src: strings.Replace(expr, " ", "", -1)}
+	if !strings.HasPrefix(p.src, "C") {
+		return nil, fmt.Errorf("%s %q: expressions must start with the camera event (C)", ErrInvalidLightPathExpression.Error(), expr)
+	}
+	p.pos = 1
+
+	frag, err := p.parseAlt()
+	if err == nil && p.pos < len(p.src) {
+		err = p.errorf("unexpected %q", p.src[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %s", ErrInvalidLightPathExpression.Error(), expr, err.Error())
+	}
+
+	transitions, err := p.nfa.compile(frag)
+	if err != nil {
+		return nil, fmt.Errorf("%s %q: %s", ErrInvalidLightPathExpression.Error(), expr, err.Error())
+	}
+
+	return &LightPathExpression{
+		Name:        name,
+		Expr:        expr,
+		transitions: transitions,
+	}, nil
+}
+
+// Concatenate the transition tables of a list of expressions. As opencl does
+// not support zero-sized buffers, a single placeholder entry is returned if
+// the list is empty.
+func lightPathTransitions(exprs []*LightPathExpression) []uint8 {
+	if len(exprs) == 0 {
+		return []uint8{0}
+	}
+
+	transitions := make([]uint8, 0, len(exprs)*len(exprs[0].transitions))
+	for _, expr := range exprs {
+		transitions = append(transitions, expr.transitions...)
+	}
+	return transitions
+}
+
+// Generate the output file name for a light path pass by replacing the {pass}
+// placeholder in filePattern with the pass name. If the pattern does not
+// contain the placeholder, the pass name is inserted before the file extension.
+func lightPathPassFile(filePattern, name string) string {
+	if strings.Contains(filePattern, "{pass}") {
+		return strings.Replace(filePattern, "{pass}", name, -1)
+	}
+
+	ext := filepath.Ext(filePattern)
+	return strings.TrimSuffix(filePattern, ext) + "-" + name + ext
+}
+
+// Write the radiance of a light path pass to imgFile using the given format.
+func writeLightPathPass(imgFile string, format ImageFormat, radiance []float32, blockReq *tracer.BlockRequest) error {
+	frameW, frameH := blockReq.FrameW, blockReq.FrameH
+	switch format {
+	case TIFFFormat:
+		return writeTIFF(imgFile, tiffFloatData(radiance), frameW, frameH, 32, tiffSampleFormatFloat, 0)
+	case EXRFormat:
+		return tracer.SaveEXR(imgFile, radiance, frameW, frameH)
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+	err := tracer.Tonemap(im, radiance, frameW, frameH, tracer.DefaultPostStages(blockReq.Exposure)...)
+	if err != nil {
+		return err
+	}
+
+	if format == JPEGFormat {
+		return writeJPEG(imgFile, im, 0)
+	}
+	return writePNG(imgFile, im)
+}
+
+// Check whether the expression matches a path described as a sequence of
+// event characters (e.g. "CDDL").
+func (e *LightPathExpression) matches(path string) bool {
+	if !strings.HasPrefix(path, "C") {
+		return false
+	}
+
+	state, accepted := uint8(1), false
+	for index := 1; index < len(path); index++ {
+		event := strings.IndexByte(string(lpeEventSymbols[:]), path[index])
+		if event < 0 {
+			return false
+		}
+
+		next := e.transitions[int(state)*int(lpeNumEvents)+event]
+		state, accepted = next&^lpeAcceptFlag, next&lpeAcceptFlag != 0
+	}
+
+	return accepted
+}
+
+// A NFA state with an optional transition for a set of events and a list of
+// epsilon transitions.
+type lpeNfaState struct {
+	events uint8
+	next   int
+	eps    []int
+}
+
+// A NFA fragment with a single entry and a single exit state.
+type lpeFragment struct {
+	start, end int
+}
+
+// A NFA built using Thompson's construction.
+type lpeNfa struct {
+	states []lpeNfaState
+}
+
+// Add a new state to the NFA and return its index.
+func (n *lpeNfa) newState() int {
+	n.states = append(n.states, lpeNfaState{next: -1})
+	return len(n.states) - 1
+}
+
+// Add an epsilon transition between two states.
+func (n *lpeNfa) epsilon(from, to int) {
+	n.states[from].eps = append(n.states[from].eps, to)
+}
+
+// Get the epsilon closure of a set of states.
+func (n *lpeNfa) closure(set map[int]bool) map[int]bool {
+	stack := make([]int, 0, len(set))
+	for state := range set {
+		stack = append(stack, state)
+	}
+
+	for len(stack) > 0 {
+		state := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, next := range n.states[state].eps {
+			if !set[next] {
+				set[next] = true
+				stack = append(stack, next)
+			}
+		}
+	}
+
+	return set
+}
+
+// Convert the NFA for a fragment into a DFA using the subset construction
+// and return its transition table.
+func (n *lpeNfa) compile(frag lpeFragment) ([]uint8, error) {
+	setKey := func(set map[int]bool) string {
+		states := make([]int, 0, len(set))
+		for state := range set {
+			states = append(states, state)
+		}
+		sort.Ints(states)
+		return fmt.Sprint(states)
+	}
+
+	// State 0 is the dead state (empty set) and state 1 the start state
+	dfaStates := []map[int]bool{{}, n.closure(map[int]bool{frag.start: true})}
+	dfaIndex := map[string]int{setKey(dfaStates[0]): 0, setKey(dfaStates[1]): 1}
+
+	transitions := make([]uint8, lpeMaxStates*int(lpeNumEvents))
+	for index := 1; index < len(dfaStates); index++ {
+		for event := uint8(0); event < lpeNumEvents; event++ {
+			moved := make(map[int]bool)
+			for state := range dfaStates[index] {
+				if n.states[state].events&(1<<event) != 0 {
+					moved[n.states[state].next] = true
+				}
+			}
+			moved = n.closure(moved)
+
+			key := setKey(moved)
+			next, exists := dfaIndex[key]
+			if !exists {
+				if len(dfaStates) == lpeMaxStates {
+					return nil, fmt.Errorf("expression requires more than %d states", lpeMaxStates)
+				}
+				next = len(dfaStates)
+				dfaStates = append(dfaStates, moved)
+				dfaIndex[key] = next
+			}
+
+			entry := uint8(next)
+			if moved[frag.end] {
+				entry |= lpeAcceptFlag
+			}
+			transitions[index*int(lpeNumEvents)+int(event)] = entry
+		}
+	}
+
+	return transitions, nil
+}
+
+// A recursive descent parser that builds a NFA for a light path expression.
+type lpeParser struct {
+	src string
+	pos int
+	nfa lpeNfa
+}
+
+// Generate a parse error for the current position.
+func (p *lpeParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+// Parse a list of alternatives separated by '|'.
+func (p *lpeParser) parseAlt() (lpeFragment, error) {
+	frag, err := p.parseSeq()
+	if err != nil {
+		return frag, err
+	}
+
+	for p.pos < len(p.src) && p.src[p.pos] == '|' {
+		p.pos++
+		right, err := p.parseSeq()
+		if err != nil {
+			return frag, err
+		}
+
+		alt := lpeFragment{p.nfa.newState(), p.nfa.newState()}
+		p.nfa.epsilon(alt.start, frag.start)
+		p.nfa.epsilon(alt.start, right.start)
+		p.nfa.epsilon(frag.end, alt.end)
+		p.nfa.epsilon(right.end, alt.end)
+		frag = alt
+	}
+
+	return frag, nil
+}
+
+// Parse a sequence of repeated atoms.
+func (p *lpeParser) parseSeq() (lpeFragment, error) {
+	state := p.nfa.newState()
+	frag := lpeFragment{state, state}
+	for p.pos < len(p.src) && p.src[p.pos] != '|' && p.src[p.pos] != ')' {
+		next, err := p.parseRepeat()
+		if err != nil {
+			return frag, err
+		}
+
+		p.nfa.epsilon(frag.end, next.start)
+		frag.end = next.end
+	}
+
+	return frag, nil
+}
+
+// Parse an atom followed by any number of '*', '+' or '?' operators.
+func (p *lpeParser) parseRepeat() (lpeFragment, error) {
+	frag, err := p.parseAtom()
+	if err != nil {
+		return frag, err
+	}
+
+	for p.pos < len(p.src) {
+		op := p.src[p.pos]
+		if op != '*' && op != '+' && op != '?' {
+			break
+		}
+		p.pos++
+
+		rep := lpeFragment{p.nfa.newState(), p.nfa.newState()}
+		p.nfa.epsilon(rep.start, frag.start)
+		p.nfa.epsilon(frag.end, rep.end)
+		if op != '+' {
+			p.nfa.epsilon(rep.start, rep.end)
+		}
+		if op != '?' {
+			p.nfa.epsilon(frag.end, frag.start)
+		}
+		frag = rep
+	}
+
+	return frag, nil
+}
+
+// Parse an event, an event class or a group.
+func (p *lpeParser) parseAtom() (lpeFragment, error) {
+	if p.pos >= len(p.src) {
+		return lpeFragment{}, p.errorf("unexpected end of expression")
+	}
+
+	var events uint8
+	switch ch := p.src[p.pos]; ch {
+	case '(':
+		p.pos++
+		frag, err := p.parseAlt()
+		if err != nil {
+			return frag, err
+		}
+		if p.pos >= len(p.src) || p.src[p.pos] != ')' {
+			return frag, p.errorf("missing ')'")
+		}
+		p.pos++
+		return frag, nil
+	case '.':
+		p.pos++
+		events = 1<<lpeNumEvents - 1
+	case '[':
+		p.pos++
+		negate := p.pos < len(p.src) && p.src[p.pos] == '^'
+		if negate {
+			p.pos++
+		}
+		for p.pos < len(p.src) && p.src[p.pos] != ']' {
+			event, err := p.parseEvent()
+			if err != nil {
+				return lpeFragment{}, err
+			}
+			events |= event
+		}
+		if p.pos >= len(p.src) {
+			return lpeFragment{}, p.errorf("missing ']'")
+		}
+		p.pos++
+		if negate {
+			events = ^events & (1<<lpeNumEvents - 1)
+		}
+	default:
+		event, err := p.parseEvent()
+		if err != nil {
+			return lpeFragment{}, err
+		}
+		events = event
+	}
+
+	frag := lpeFragment{p.nfa.newState(), p.nfa.newState()}
+	p.nfa.states[frag.start].events = events
+	p.nfa.states[frag.start].next = frag.end
+	return frag, nil
+}
+
+// Parse an event character and return its event mask.
+func (p *lpeParser) parseEvent() (uint8, error) {
+	ch := p.src[p.pos]
+	for event, symbol := range lpeEventSymbols {
+		if ch == symbol {
+			p.pos++
+			return 1 << uint(event), nil
+		}
+	}
+
+	if ch == 'C' {
+		return 0, p.errorf("the camera event (C) may only appear at the start of the expression")
+	}
+	return 0, p.errorf("unknown event %q", ch)
+}
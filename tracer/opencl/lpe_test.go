@@ -0,0 +1,108 @@
+package opencl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLightPathExpressionMatching(t *testing.T) {
+	specs := []struct {
+		expr    string
+		match   []string
+		noMatch []string
+	}{
+		{
+			expr:    "CD*L",
+			match:   []string{"CL", "CDL", "CDDDL"},
+			noMatch: []string{"CB", "CSL", "CDSL", "CDD"},
+		},
+		{
+			expr:    "C[GS].*[LB]",
+			match:   []string{"CGL", "CSB", "CSDDL"},
+			noMatch: []string{"CL", "CDGL", "CS"},
+		},
+		{
+			expr:    "C[^D]+L",
+			match:   []string{"CSL", "CGSL", "CBL"},
+			noMatch: []string{"CL", "CDL", "CSDL"},
+		},
+		{
+			expr:    "C(DL|SB)",
+			match:   []string{"CDL", "CSB"},
+			noMatch: []string{"CDB", "CSL", "CL"},
+		},
+		{
+			expr:    "C D? L",
+			match:   []string{"CL", "CDL"},
+			noMatch: []string{"CDDL", "CGL"},
+		},
+		{
+			expr:    "C.*",
+			match:   []string{"CL", "CDGSB"},
+			noMatch: []string{"DL"},
+		},
+	}
+
+	for _, spec := range specs {
+		lpe, err := ParseLightPathExpression("pass", spec.expr)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %v", spec.expr, err)
+			continue
+		}
+
+		for _, path := range spec.match {
+			if !lpe.matches(path) {
+				t.Errorf("[%s] expected path %q to match", spec.expr, path)
+			}
+		}
+		for _, path := range spec.noMatch {
+			if lpe.matches(path) {
+				t.Errorf("[%s] expected path %q not to match", spec.expr, path)
+			}
+		}
+	}
+}
+
+func TestLightPathExpressionErrors(t *testing.T) {
+	specs := []struct {
+		expr   string
+		expErr string
+	}{
+		{"DL", "must start with the camera event"},
+		{"CDCL", "may only appear at the start"},
+		{"CXL", "unknown event 'X' at position 1"},
+		{"C(DL", "missing ')'"},
+		{"C[DL", "missing ']'"},
+		{"CDL)", "unexpected ')'"},
+		{"C*", "unknown event '*'"},
+	}
+
+	for _, spec := range specs {
+		_, err := ParseLightPathExpression("pass", spec.expr)
+		if err == nil {
+			t.Errorf("[%s] expected to get an error", spec.expr)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), ErrInvalidLightPathExpression.Error()) || !strings.Contains(err.Error(), spec.expErr) {
+			t.Errorf("[%s] expected error to contain %q; got %v", spec.expr, spec.expErr, err)
+		}
+	}
+}
+
+func TestLightPathPassFile(t *testing.T) {
+	specs := []struct {
+		pattern string
+		exp     string
+	}{
+		{"pass-{pass}.tiff", "pass-diffuse.tiff"},
+		{"out/{pass}/{pass}.png", "out/diffuse/diffuse.png"},
+		{"frame.png", "frame-diffuse.png"},
+		{"frame", "frame-diffuse"},
+	}
+
+	for _, spec := range specs {
+		if got := lightPathPassFile(spec.pattern, "diffuse"); got != spec.exp {
+			t.Errorf("[%s] expected %q; got %q", spec.pattern, spec.exp, got)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package opencl
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+	"github.com/achilleasa/polaris/types"
+)
+
+// A recorded stageResources method invocation.
+type mockCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// A mock stageResources implementation that records the invoked methods and
+// their arguments. Calls to methods with an entry in the errors map fail with
+// the mapped error.
+type mockResources struct {
+	calls  []mockCall
+	errors map[string]error
+
+	// The value returned by HasPrimaryHits.
+	primaryHits bool
+
+	// The values copied to the output slices of the read methods.
+	radiance    []float32
+	debugOutput []byte
+	debugValues []float32
+
+	cameraRayScratch []CameraRay
+}
+
+// Create a tracer that uses a mockResources instance for running pipeline
+// stages. The tracer device type selects the device-specific stage branches.
+func newMockTracer(devType device.DeviceType, pipeline *Pipeline) (*Tracer, *mockResources) {
+	if pipeline == nil {
+		pipeline = &Pipeline{}
+	}
+
+	res := &mockResources{errors: make(map[string]error)}
+	tr := &Tracer{
+		device:   &device.Device{Name: "mock", Type: devType},
+		stageRes: res,
+		pipeline: pipeline,
+		stats:    &tracer.Stats{},
+		rng:      rand.New(rand.NewSource(0)),
+		sceneData: &scene.Scene{
+			SceneDiffuseMatIndex:   -1,
+			SceneBackplateMatIndex: -1,
+			SceneMediumMatIndex:    -1,
+		},
+	}
+
+	return tr, res
+}
+
+// Record a method invocation and return the injected error for it.
+func (m *mockResources) record(method string, args ...interface{}) error {
+	m.calls = append(m.calls, mockCall{Method: method, Args: args})
+	return m.errors[method]
+}
+
+// Get the names of the invoked methods in invocation order.
+func (m *mockResources) methods() []string {
+	names := make([]string, len(m.calls))
+	for index, call := range m.calls {
+		names[index] = call.Method
+	}
+	return names
+}
+
+// Get the recorded invocations of a particular method.
+func (m *mockResources) callsTo(method string) []mockCall {
+	var calls []mockCall
+	for _, call := range m.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Check that the invoked methods match the expected list.
+func (m *mockResources) assertMethods(t *testing.T, exp ...string) {
+	if got := m.methods(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected method calls:\n%v\ngot:\n%v", exp, got)
+	}
+}
+
+func (m *mockResources) ClearFrameAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, m.record("ClearFrameAccumulator")
+}
+
+func (m *mockResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum [4]types.Vec4, lens cameraLens, pixelFilter PixelFilter, rayOrder RayOrder, sampler pathSampler) (time.Duration, error) {
+	return 0, m.record("GeneratePrimaryRays", cameraEyePos, lens, pixelFilter, rayOrder, sampler)
+}
+
+func (m *mockResources) UploadCameraRays(rays []CameraRay) error {
+	return m.record("UploadCameraRays", len(rays))
+}
+
+func (m *mockResources) WriteBlockCameraRays(rays []CameraRay) error {
+	return m.record("WriteBlockCameraRays", len(rays))
+}
+
+func (m *mockResources) GenerateCustomRays(blockReq *tracer.BlockRequest, rayOffset uint32, rayOrder RayOrder) (time.Duration, error) {
+	return 0, m.record("GenerateCustomRays", rayOffset, rayOrder)
+}
+
+func (m *mockResources) CameraRayScratch(numRays int) []CameraRay {
+	if len(m.cameraRayScratch) != numRays {
+		m.cameraRayScratch = make([]CameraRay, numRays)
+	}
+	return m.cameraRayScratch
+}
+
+func (m *mockResources) HasPrimaryHits(blockReq *tracer.BlockRequest) bool {
+	return m.primaryHits
+}
+
+func (m *mockResources) StorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	err := m.record("StorePrimaryHits")
+	if err == nil {
+		m.primaryHits = true
+	}
+	return 0, err
+}
+
+func (m *mockResources) RestorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, m.record("RestorePrimaryHits")
+}
+
+func (m *mockResources) RayIntersectionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("RayIntersectionTest", rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ConeOcclusionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ConeOcclusionTest", rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) RayIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error) {
+	return 0, m.record("RayIntersectionQuery", rayBufferIndex, skipInstanceFlags, numPixels)
+}
+
+func (m *mockResources) RayPacketIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error) {
+	return 0, m.record("RayPacketIntersectionQuery", rayBufferIndex, skipInstanceFlags, numPixels)
+}
+
+func (m *mockResources) RecordTextureFeedback(textureFilter TextureFilter, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("RecordTextureFeedback", textureFilter, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) SampleMediumInteractions(blockReq *tracer.BlockRequest, mediumMatNodeIndex int32, bounce, randSeed uint32, sampler pathSampler, numEmissives uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("SampleMediumInteractions", mediumMatNodeIndex, bounce, numEmissives, clamp, rayBufferIndex, numPixels, sampler)
+}
+
+func (m *mockResources) ShadeHits(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex, mediumMatNodeIndex int32, bounce, minBouncesForRR, randSeed uint32, sampler pathSampler, numEmissives uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, numLightVertices, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadeHits", bounce, minBouncesForRR, numEmissives, normalCorrection, textureFilter, clamp, numLightVertices, rayBufferIndex, numPixels, sampler)
+}
+
+func (m *mockResources) ShadePrimaryRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex int32, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadePrimaryRayMisses", diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ShadeIndirectRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, envMatNodeIndex int32, bounce uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadeIndirectRayMisses", diffuseMatNodeIndex, envMatNodeIndex, bounce, clamp, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ShadeEmissiveHits(blockReq *tracer.BlockRequest, bounce, randSeed uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadeEmissiveHits", bounce, clamp, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) AccumulateEmissiveSamples(blockReq *tracer.BlockRequest, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("AccumulateEmissiveSamples", rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) GenerateLightRays(blockReq *tracer.BlockRequest, randSeed, numEmissives, numLightVertices uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("GenerateLightRays", numEmissives, numLightVertices, numPixels)
+}
+
+func (m *mockResources) LightRayIntersectionQuery(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("LightRayIntersectionQuery", rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ShadeLightHits(depth, minBouncesForRR, randSeed, numLightVertices uint32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadeLightHits", depth, minBouncesForRR, numLightVertices, normalCorrection, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ConnectLightVertex(blockReq *tracer.BlockRequest, bounce, randSeed, lightDepth, numLightVertices uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ConnectLightVertex", bounce, lightDepth, numLightVertices, normalCorrection, textureFilter, clamp, rayBufferIndex, numPixels)
+}
+
+func (m *mockResources) ShadeAmbientOcclusion(blockReq *tracer.BlockRequest, randSeed uint32, sampler pathSampler, maxDistance float32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	return 0, m.record("ShadeAmbientOcclusion", maxDistance, normalCorrection, rayBufferIndex, numPixels, sampler)
+}
+
+func (m *mockResources) TonemapSimpleReinhard(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, m.record("TonemapSimpleReinhard")
+}
+
+func (m *mockResources) ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	copy(out, m.radiance)
+	return 0, m.record("ReadRadiance")
+}
+
+func (m *mockResources) WritePostRadiance(blockReq *tracer.BlockRequest, radiance []float32) (time.Duration, error) {
+	return 0, m.record("WritePostRadiance", append([]float32(nil), radiance...))
+}
+
+func (m *mockResources) ReadSampleStats(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	return 0, m.record("ReadSampleStats")
+}
+
+func (m *mockResources) ReadLightPathPass(blockReq *tracer.BlockRequest, pass int, out []float32) (time.Duration, error) {
+	return 0, m.record("ReadLightPathPass", pass)
+}
+
+func (m *mockResources) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	return tracer.PixelProbe{X: x, Y: y}, m.record("ProbePixel", x, y)
+}
+
+func (m *mockResources) ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error) {
+	return 0, m.record("ReadFrame")
+}
+
+func (m *mockResources) ReadDebugOutput(blockReq *tracer.BlockRequest, out []byte) error {
+	copy(out, m.debugOutput)
+	return m.record("ReadDebugOutput")
+}
+
+func (m *mockResources) ReadDebugValues(blockReq *tracer.BlockRequest, out []float32) error {
+	copy(out, m.debugValues)
+	return m.record("ReadDebugValues")
+}
+
+func (m *mockResources) DebugRayIntersectionDepth(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error) {
+	return 0, m.record("DebugRayIntersectionDepth", activeRayBuf)
+}
+
+func (m *mockResources) DebugRayIntersectionNormals(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error) {
+	return 0, m.record("DebugRayIntersectionNormals", activeRayBuf)
+}
+
+func (m *mockResources) DebugEmissiveSamples(blockReq *tracer.BlockRequest, maskOccluded, maskNotOccluded uint32) (time.Duration, error) {
+	return 0, m.record("DebugEmissiveSamples", maskOccluded, maskNotOccluded)
+}
+
+func (m *mockResources) DebugThroughput(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return 0, m.record("DebugThroughput")
+}
+
+func (m *mockResources) DebugAccumulator(blockReq *tracer.BlockRequest, tracedSamples uint32) (time.Duration, error) {
+	return 0, m.record("DebugAccumulator", tracedSamples)
+}
+
+// A DebugSink that collects the names of the written debug images.
+type mockDebugSink struct {
+	images []string
+	err    error
+}
+
+func (s *mockDebugSink) WriteDebugImage(name string, im image.Image) error {
+	if im == nil {
+		return fmt.Errorf("nil debug image %q", name)
+	}
+	s.images = append(s.images, name)
+	return s.err
+}
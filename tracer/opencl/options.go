@@ -0,0 +1,684 @@
+package opencl
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+// The filter used for distributing primary ray samples within each pixel.
+type PixelFilter uint32
+
+// Supported pixel filters.
+const (
+	// Distribute samples using a tent filter with a radius of 1 pixel.
+	TentFilter PixelFilter = iota
+
+	// Distribute samples uniformly inside each pixel.
+	BoxFilter
+
+	// Distribute samples using a gaussian filter (sigma = 0.5 pixels)
+	// truncated to a radius of 1.5 pixels.
+	GaussianFilter
+
+	// Sample the center of each pixel. This filter does not provide any
+	// anti-aliasing.
+	PointFilter
+)
+
+// Implements Stringer.
+func (f PixelFilter) String() string {
+	switch f {
+	case TentFilter:
+		return "tent"
+	case BoxFilter:
+		return "box"
+	case GaussianFilter:
+		return "gaussian"
+	case PointFilter:
+		return "point"
+	}
+	return fmt.Sprintf("PixelFilter(%d)", uint32(f))
+}
+
+// Parse a pixel filter name.
+func ParsePixelFilter(name string) (PixelFilter, error) {
+	for _, filter := range []PixelFilter{TentFilter, BoxFilter, GaussianFilter, PointFilter} {
+		if strings.EqualFold(name, filter.String()) {
+			return filter, nil
+		}
+	}
+
+	return TentFilter, fmt.Errorf("%s: unknown pixel filter %q; supported filters are tent, box, gaussian and point", ErrInvalidOption.Error(), name)
+}
+
+// Controls how the shading kernel corrects shading normals that disagree
+// with the geometric normal of the intersected triangle.
+type NormalCorrection uint32
+
+// Supported normal corrections.
+const (
+	// Use the interpolated shading normals as-is.
+	NoNormalCorrection NormalCorrection = iota
+
+	// Bend the shading normal towards the incoming ray when the ray hits
+	// the front side of a triangle but ends up below the hemisphere of the
+	// shading normal. This removes the black fringes that appear on the
+	// silhouettes of low-poly meshes with smooth normals.
+	ClampNormalCorrection
+
+	// Mirror shading normals that point below the triangle plane to the
+	// side of the geometric normal. This fixes meshes with flipped normals.
+	FlipNormalCorrection
+)
+
+// Implements Stringer.
+func (c NormalCorrection) String() string {
+	switch c {
+	case NoNormalCorrection:
+		return "none"
+	case ClampNormalCorrection:
+		return "clamp"
+	case FlipNormalCorrection:
+		return "flip"
+	}
+	return fmt.Sprintf("NormalCorrection(%d)", uint32(c))
+}
+
+// Parse a normal correction name.
+func ParseNormalCorrection(name string) (NormalCorrection, error) {
+	for _, correction := range []NormalCorrection{NoNormalCorrection, ClampNormalCorrection, FlipNormalCorrection} {
+		if strings.EqualFold(name, correction.String()) {
+			return correction, nil
+		}
+	}
+
+	return NoNormalCorrection, fmt.Errorf("%s: unknown normal correction %q; supported corrections are none, clamp and flip", ErrInvalidOption.Error(), name)
+}
+
+// Controls how the shading kernel selects the mip level for texture lookups.
+type TextureFilter uint32
+
+// Supported texture filters.
+const (
+	// Track the footprint of each path through the primary rays and
+	// bounces and sample the mip level that matches the footprint size at
+	// each intersection. This eliminates the shimmering of distant or
+	// minified textures.
+	RayDifferentialTextureFilter TextureFilter = iota
+
+	// Always sample the top mip level.
+	TopMipTextureFilter
+)
+
+// Implements Stringer.
+func (f TextureFilter) String() string {
+	switch f {
+	case RayDifferentialTextureFilter:
+		return "ray-differentials"
+	case TopMipTextureFilter:
+		return "top-mip"
+	}
+	return fmt.Sprintf("TextureFilter(%d)", uint32(f))
+}
+
+// Parse a texture filter name.
+func ParseTextureFilter(name string) (TextureFilter, error) {
+	for _, filter := range []TextureFilter{RayDifferentialTextureFilter, TopMipTextureFilter} {
+		if strings.EqualFold(name, filter.String()) {
+			return filter, nil
+		}
+	}
+
+	return RayDifferentialTextureFilter, fmt.Errorf("%s: unknown texture filter %q; supported filters are ray-differentials and top-mip", ErrInvalidOption.Error(), name)
+}
+
+// Controls the order in which primary rays are assigned to the pixels of a
+// block. Neighboring rays are processed by the same device work-groups and
+// intersected as packets so orderings that keep neighboring rays close on
+// screen improve the coherence of BVH traversal and texture fetches. Renders
+// using different orderings converge to the same image.
+type RayOrder uint32
+
+// Supported ray orders.
+const (
+	// Assign rays to pixels following a Z-order (Morton) curve.
+	MortonRayOrder RayOrder = iota
+
+	// Split the block into RayOrderTileSize x RayOrderTileSize tiles and
+	// assign rays to the tiles in row-major order and to the pixels of
+	// each tile in row-major order.
+	TiledRayOrder
+
+	// Assign rays to pixels in row-major order.
+	ScanlineRayOrder
+)
+
+// The tile size used by TiledRayOrder. Each tile contains as many pixels as
+// the rays in a packet of the packet intersection kernel.
+const RayOrderTileSize = 8
+
+// Implements Stringer.
+func (o RayOrder) String() string {
+	switch o {
+	case MortonRayOrder:
+		return "morton"
+	case TiledRayOrder:
+		return "tiled"
+	case ScanlineRayOrder:
+		return "scanline"
+	}
+	return fmt.Sprintf("RayOrder(%d)", uint32(o))
+}
+
+// Parse a ray order name.
+func ParseRayOrder(name string) (RayOrder, error) {
+	for _, order := range []RayOrder{MortonRayOrder, TiledRayOrder, ScanlineRayOrder} {
+		if strings.EqualFold(name, order.String()) {
+			return order, nil
+		}
+	}
+
+	return MortonRayOrder, fmt.Errorf("%s: unknown ray order %q; supported orders are morton, tiled and scanline", ErrInvalidOption.Error(), name)
+}
+
+// Selects how the kernels that trace camera paths generate the samples for
+// the pixel offsets, lens positions, free-flight distances, material layer
+// selection and scattering directions of each bounce. Each path event draws
+// its samples from a dedicated sampler dimension and the samples of each
+// dimension are decorrelated per pixel. Light subpaths and local occlusion
+// baking always use the tracer's random number generator.
+type Sampler uint32
+
+// Supported samplers.
+const (
+	// Use an Owen-scrambled Sobol sequence. The samples of each dimension
+	// are stratified across the samples of a pixel which converges faster
+	// than random sampling, especially for sample counts that are a power
+	// of two.
+	SobolSampler Sampler = iota
+
+	// Use a Halton sequence with a random per-pixel shift. Dimensions past
+	// the first 32 fall back to random sampling.
+	HaltonSampler
+
+	// Use the tracer's random number generator.
+	RandomSampler
+
+	// Use a tiled blue-noise mask for the pixel offsets, lens positions
+	// and first bounce samples and the Sobol sampler for the remaining
+	// samples. Low sample count frames show fine-grained noise without
+	// the clumps of random sampling which makes this sampler well suited
+	// to interactive rendering.
+	BlueNoiseSampler
+)
+
+// Implements Stringer.
+func (s Sampler) String() string {
+	switch s {
+	case SobolSampler:
+		return "sobol"
+	case HaltonSampler:
+		return "halton"
+	case RandomSampler:
+		return "random"
+	case BlueNoiseSampler:
+		return "blue-noise"
+	}
+	return fmt.Sprintf("Sampler(%d)", uint32(s))
+}
+
+// Parse a sampler name.
+func ParseSampler(name string) (Sampler, error) {
+	for _, sampler := range []Sampler{SobolSampler, HaltonSampler, RandomSampler, BlueNoiseSampler} {
+		if strings.EqualFold(name, sampler.String()) {
+			return sampler, nil
+		}
+	}
+
+	return SobolSampler, fmt.Errorf("%s: unknown sampler %q; supported samplers are sobol, halton, random and blue-noise", ErrInvalidOption.Error(), name)
+}
+
+// Selects how the path tracing integrators test the visibility of the lights
+// sampled at each path vertex.
+type ShadowMode uint32
+
+// Supported shadow modes.
+const (
+	// Trace an occlusion ray towards a random point on the sampled light.
+	// Soft shadows converge to the exact result as samples accumulate.
+	TracedShadows ShadowMode = iota
+
+	// Trace a cone from each path vertex towards the sampled area light
+	// and estimate the fraction of the light that is not covered by the
+	// triangles inside the cone. Penumbras are smooth after a single
+	// sample which makes this mode suitable for interactive previews but
+	// the visibility is approximate and overlapping occluders tend to
+	// darken shadows. Environment light samples are still traced.
+	ConeShadows
+)
+
+// Implements Stringer.
+func (m ShadowMode) String() string {
+	switch m {
+	case TracedShadows:
+		return "traced"
+	case ConeShadows:
+		return "cone"
+	}
+	return fmt.Sprintf("ShadowMode(%d)", uint32(m))
+}
+
+// Parse a shadow mode name.
+func ParseShadowMode(name string) (ShadowMode, error) {
+	for _, mode := range []ShadowMode{TracedShadows, ConeShadows} {
+		if strings.EqualFold(name, mode.String()) {
+			return mode, nil
+		}
+	}
+
+	return TracedShadows, fmt.Errorf("%s: unknown shadow mode %q; supported modes are traced and cone", ErrInvalidOption.Error(), name)
+}
+
+// Controls how the depth, throughput, accumulator and emissive sample debug
+// images map raw values to colors. The images include a legend with the range of
+// the mapped values.
+type DebugMapping uint32
+
+// Supported debug mappings.
+const (
+	// Scale values so the 99th percentile of the frame maps to white.
+	// Brighter values are clipped; this ignores isolated fireflies that
+	// would otherwise darken the rest of the image.
+	NormalizeDebugMapping DebugMapping = iota
+
+	// Map the log10 of the values between the smallest non-zero value
+	// and the max value of the frame. This mapping is useful for values
+	// that span several orders of magnitude such as path throughput.
+	LogDebugMapping
+
+	// Apply a fixed Reinhard tone-mapping operator. As the mapping does
+	// not depend on the frame contents, images generated at different
+	// bounces or samples can be compared directly.
+	TonemapDebugMapping
+)
+
+// Implements Stringer.
+func (m DebugMapping) String() string {
+	switch m {
+	case NormalizeDebugMapping:
+		return "normalize"
+	case LogDebugMapping:
+		return "log"
+	case TonemapDebugMapping:
+		return "tonemap"
+	}
+	return fmt.Sprintf("DebugMapping(%d)", uint32(m))
+}
+
+// Parse a debug mapping name.
+func ParseDebugMapping(name string) (DebugMapping, error) {
+	for _, mapping := range []DebugMapping{NormalizeDebugMapping, LogDebugMapping, TonemapDebugMapping} {
+		if strings.EqualFold(name, mapping.String()) {
+			return mapping, nil
+		}
+	}
+
+	return NormalizeDebugMapping, fmt.Errorf("%s: unknown debug mapping %q; supported mappings are normalize, log and tonemap", ErrInvalidOption.Error(), name)
+}
+
+// Selects the colors used by heatmap-style debug images such as the depth
+// debug image and the sample statistics images. Heatmaps visualize a single
+// value per pixel; images with per-channel values (e.g. throughput) are always
+// rendered using their own colors.
+type DebugPalette uint32
+
+// Supported debug palettes.
+const (
+	// Render values as gray levels.
+	GrayDebugPalette DebugPalette = iota
+
+	// A perceptually uniform palette ranging from dark blue to yellow that
+	// remains readable by color-blind users and when printed in grayscale.
+	ViridisDebugPalette
+
+	// A perceptually uniform palette ranging from black through purple and
+	// orange to pale yellow. Its dark low end suits mostly empty images.
+	MagmaDebugPalette
+)
+
+// Implements Stringer.
+func (p DebugPalette) String() string {
+	switch p {
+	case GrayDebugPalette:
+		return "gray"
+	case ViridisDebugPalette:
+		return "viridis"
+	case MagmaDebugPalette:
+		return "magma"
+	}
+	return fmt.Sprintf("DebugPalette(%d)", uint32(p))
+}
+
+// Parse a debug palette name.
+func ParseDebugPalette(name string) (DebugPalette, error) {
+	for _, palette := range []DebugPalette{GrayDebugPalette, ViridisDebugPalette, MagmaDebugPalette} {
+		if strings.EqualFold(name, palette.String()) {
+			return palette, nil
+		}
+	}
+
+	return GrayDebugPalette, fmt.Errorf("%s: unknown debug palette %q; supported palettes are gray, viridis and magma", ErrInvalidOption.Error(), name)
+}
+
+// The max value for the components of the light samples accumulated by the
+// integrator. Samples are classified by the number of scattering events
+// between the camera and the light source. Direct samples have a single
+// scattering event while indirect samples have two or more. Light sources and
+// backgrounds that are directly visible by the camera are never clamped. A
+// zero value disables clamping for the corresponding class.
+type SampleClamp struct {
+	Direct   float32
+	Indirect float32
+}
+
+// Light subpath lengths for the bidirectional integrator.
+const (
+	// The number of light subpath vertices used by BidirectionalIntegrator
+	// when the WithLightPathLength option is not specified.
+	DefaultLightPathLength = 3
+
+	// The max supported number of light subpath vertices.
+	MaxLightPathLength = 16
+)
+
+// BVH traversal stack sizes for the intersection kernels.
+const (
+	// The traversal stack size used by the kernels when the
+	// WithTraversalStackSize option is not specified.
+	DefaultTraversalStackSize = 32
+
+	// The max supported traversal stack size. The packet traversal kernel
+	// keeps its stack in local memory so very large stacks may not fit.
+	MaxTraversalStackSize = 256
+)
+
+// The settings used by the pipeline stage constructors.
+type pipelineSettings struct {
+	debugFlags       DebugFlag
+	debugMapping     DebugMapping
+	debugPalette     DebugPalette
+	pixelFilter      PixelFilter
+	rayOrder         RayOrder
+	sampler          Sampler
+	shadowMode       ShadowMode
+	firstHitCache    bool
+	normalCorrection NormalCorrection
+	textureFilter    TextureFilter
+	sampleClamp      SampleClamp
+	lightPathLength  uint32
+	ambientOcclusion bool
+	aoDistance       float32
+	directLighting   bool
+}
+
+// A PipelineOption configures the stages created by DefaultPipeline and the
+// individual pipeline stage constructors.
+type PipelineOption func(s *pipelineSettings)
+
+// Enable one or more debug flags.
+func WithDebugFlags(flags DebugFlag) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.debugFlags |= flags
+	}
+}
+
+// Select how the depth, throughput, accumulator and emissive sample debug
+// images map raw values to colors. If not specified, values are normalized.
+func WithDebugMapping(mapping DebugMapping) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.debugMapping = mapping
+	}
+}
+
+// Select the palette for heatmap-style debug images and the sample statistics
+// images. If not specified, heatmaps are rendered in grayscale.
+func WithDebugPalette(palette DebugPalette) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.debugPalette = palette
+	}
+}
+
+// Select the filter for distributing primary ray samples within each pixel.
+// If not specified, a tent filter is used.
+func WithPixelFilter(filter PixelFilter) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.pixelFilter = filter
+	}
+}
+
+// Select the order in which primary rays are assigned to the pixels of each
+// block. If not specified, rays are assigned using the Morton order.
+func WithRayOrder(order RayOrder) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.rayOrder = order
+	}
+}
+
+// Select the sampler for generating the samples of camera paths. If not
+// specified, an Owen-scrambled Sobol sequence is used.
+func WithSampler(sampler Sampler) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.sampler = sampler
+	}
+}
+
+// Select how the visibility of sampled lights is tested. If not specified,
+// occlusion rays are traced.
+func WithShadowMode(mode ShadowMode) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.shadowMode = mode
+	}
+}
+
+// Resolve primary ray visibility once and cache the first hit for each pixel
+// until the camera, scene or frame dimensions change. Subsequent samples skip
+// the primary ray intersection query and start path tracing from the cached
+// hit, which speeds up interactive rendering of heavy scenes. As all samples
+// share the same primary hit, this option forces the use of the point pixel
+// filter so edges are not anti-aliased and disables depth of field.
+func WithFirstHitCache() PipelineOption {
+	return func(s *pipelineSettings) {
+		s.firstHitCache = true
+	}
+}
+
+// Select how the shading kernel corrects shading normals that disagree with
+// the geometric normal of the intersected triangle. If not specified, shading
+// normals are used without any correction.
+func WithNormalCorrection(correction NormalCorrection) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.normalCorrection = correction
+	}
+}
+
+// Select how the shading kernel selects the mip level for texture lookups.
+// If not specified, mip levels are selected using ray differentials.
+func WithTextureFilter(filter TextureFilter) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.textureFilter = filter
+	}
+}
+
+// Clamp the light samples accumulated by the integrator to suppress fireflies.
+// Clamping introduces bias by removing energy from the image; clamping
+// indirect samples is usually enough to remove fireflies caused by caustics
+// and glossy inter-reflections without dimming the highlights caused by direct
+// lighting. Negative values are treated as zero. If not specified, samples are
+// not clamped.
+func WithSampleClamp(clamp SampleClamp) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.sampleClamp = SampleClamp{
+			Direct:   float32(math.Max(float64(clamp.Direct), 0)),
+			Indirect: float32(math.Max(float64(clamp.Indirect), 0)),
+		}
+	}
+}
+
+// Set the max number of vertices of the light subpaths traced by the
+// bidirectional integrator. Each vertex requires 96 bytes of device memory
+// per pixel. Values above MaxLightPathLength are clamped. If specified,
+// DefaultPipeline uses the bidirectional integrator; otherwise,
+// BidirectionalIntegrator traces light subpaths with DefaultLightPathLength
+// vertices.
+func WithLightPathLength(length uint32) PipelineOption {
+	return func(s *pipelineSettings) {
+		if length > MaxLightPathLength {
+			length = MaxLightPathLength
+		}
+		s.lightPathLength = length
+	}
+}
+
+// Use the ambient occlusion integrator in DefaultPipeline. Occlusion rays are
+// limited to maxDist; a non-positive value does not limit the ray length. This
+// option takes precedence over WithLightPathLength.
+func WithAmbientOcclusion(maxDist float32) PipelineOption {
+	return func(s *pipelineSettings) {
+		s.ambientOcclusion = true
+		s.aoDistance = maxDist
+	}
+}
+
+// Use the direct lighting integrator in DefaultPipeline. This option takes
+// precedence over WithLightPathLength.
+func WithDirectLighting() PipelineOption {
+	return func(s *pipelineSettings) {
+		s.directLighting = true
+	}
+}
+
+// Apply a list of pipeline options to the default pipeline settings.
+func applyPipelineOptions(opts []PipelineOption) pipelineSettings {
+	var settings pipelineSettings
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	return settings
+}
+
+// A TracerOption configures a tracer created via NewTracer.
+type TracerOption func(tr *Tracer) error
+
+// Use the specified device for tracing. This option is required.
+func WithDevice(dev *device.Device) TracerOption {
+	return func(tr *Tracer) error {
+		if dev == nil {
+			return fmt.Errorf("%s: nil device", ErrInvalidOption.Error())
+		}
+		tr.device = dev
+		return nil
+	}
+}
+
+// Use a shared opencl context. A shared context is required when rendering
+// using multiple devices.
+func WithSharedContext(ctx *cl.Context) TracerOption {
+	return func(tr *Tracer) error {
+		tr.ctx = ctx
+		return nil
+	}
+}
+
+// Build the kernels using a set of additional defines and compiler flags.
+// This allows tuning the kernels for a particular device (e.g. enabling
+// -cl-fast-relaxed-math or changing BVH_MAX_STACK_SIZE) without patching
+// the kernel sources. The defines shared with the host via the stage ABI
+// cannot be overridden. Multiple options are merged in order.
+func WithBuildOptions(opts device.BuildOptions) TracerOption {
+	return func(tr *Tracer) error {
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", ErrInvalidOption.Error(), err.Error())
+		}
+		for name := range opts.Defines {
+			if isABIDefine(name) {
+				return fmt.Errorf("%s: define %s is part of the stage ABI and cannot be overridden", ErrInvalidOption.Error(), name)
+			}
+		}
+		tr.buildOpts = tr.buildOpts.Merge(opts)
+		return nil
+	}
+}
+
+// Set the size of the BVH traversal stack used by the intersection kernels.
+// Rays whose traversal needs a deeper stack skip part of the BVH and may miss
+// geometry; the number of such rays is reported by the tracer stats. This is
+// equivalent to defining BVH_MAX_STACK_SIZE via WithBuildOptions.
+func WithTraversalStackSize(size uint32) TracerOption {
+	return func(tr *Tracer) error {
+		if size == 0 || size > MaxTraversalStackSize {
+			return fmt.Errorf("%s: traversal stack size must be between 1 and %d; got %d", ErrInvalidOption.Error(), MaxTraversalStackSize, size)
+		}
+		tr.buildOpts = tr.buildOpts.Merge(device.BuildOptions{
+			Defines: map[string]string{"BVH_MAX_STACK_SIZE": strconv.Itoa(int(size))},
+		})
+		return nil
+	}
+}
+
+// Traverse a compressed version of the scene BVH that collapses groups of
+// binary nodes into 8-wide nodes with quantized child bounds. The compressed
+// BVH uses less memory bandwidth at the cost of slightly looser bounds. It is
+// built when the scene is uploaded and the kernels are built with the
+// BVH_COMPRESSED define.
+func WithCompressedBvh() TracerOption {
+	return func(tr *Tracer) error {
+		tr.compressBvh = true
+		tr.buildOpts = tr.buildOpts.Merge(device.BuildOptions{
+			Defines: map[string]string{"BVH_COMPRESSED": "1"},
+		})
+		return nil
+	}
+}
+
+// Check whether a define name is shared between the host and the kernels.
+func isABIDefine(name string) bool {
+	if name == "STAGE_ABI_VERSION" || name == "LAYOUT_VERSION" {
+		return true
+	}
+	for _, define := range abiDefines {
+		if define.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Use the specified rendering pipeline. If not specified, the tracer uses
+// the pipeline returned by DefaultPipeline.
+func WithPipeline(pipeline *Pipeline) TracerOption {
+	return func(tr *Tracer) error {
+		if pipeline == nil {
+			return fmt.Errorf("%s: nil pipeline", ErrInvalidOption.Error())
+		}
+		tr.pipeline = pipeline
+		return nil
+	}
+}
+
+// Seed the tracer's random number generator. Tracers using the same seed
+// generate the same sequence of random seeds for the rendering kernels. If
+// not specified, the tracer uses the global random number generator. The
+// seed also scrambles the samples generated by the Sobol and Halton samplers.
+func WithSeed(seed int64) TracerOption {
+	return func(tr *Tracer) error {
+		tr.rng = rand.New(rand.NewSource(seed))
+		tr.samplerSeed = uint32(seed)
+		return nil
+	}
+}
@@ -0,0 +1,285 @@
+package opencl
+
+import (
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+func TestParsePixelFilter(t *testing.T) {
+	specs := []struct {
+		name   string
+		exp    PixelFilter
+		expErr bool
+	}{
+		{"tent", TentFilter, false},
+		{"Box", BoxFilter, false},
+		{"GAUSSIAN", GaussianFilter, false},
+		{"point", PointFilter, false},
+		{"mitchell", TentFilter, true},
+	}
+
+	for index, spec := range specs {
+		filter, err := ParsePixelFilter(spec.name)
+		if spec.expErr {
+			if err == nil {
+				t.Errorf("[spec %d] expected an error", index)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", index, err)
+			continue
+		}
+
+		if filter != spec.exp {
+			t.Errorf("[spec %d] expected filter %s; got %s", index, spec.exp, filter)
+		}
+	}
+}
+
+func TestPipelineOptions(t *testing.T) {
+	settings := applyPipelineOptions(nil)
+	if settings.debugFlags != NoDebug || settings.pixelFilter != TentFilter || settings.rayOrder != MortonRayOrder || settings.sampler != SobolSampler || settings.shadowMode != TracedShadows || settings.firstHitCache || settings.normalCorrection != NoNormalCorrection || settings.textureFilter != RayDifferentialTextureFilter || settings.sampleClamp != (SampleClamp{}) {
+		t.Fatalf("unexpected default pipeline settings: %+v", settings)
+	}
+
+	settings = applyPipelineOptions([]PipelineOption{
+		WithDebugFlags(Throughput),
+		WithDebugFlags(Accumulator),
+		WithPixelFilter(GaussianFilter),
+		WithRayOrder(TiledRayOrder),
+		WithSampler(HaltonSampler),
+		WithShadowMode(ConeShadows),
+		WithFirstHitCache(),
+		WithNormalCorrection(ClampNormalCorrection),
+		WithTextureFilter(TopMipTextureFilter),
+		WithSampleClamp(SampleClamp{Direct: -1, Indirect: 10}),
+		WithDebugMapping(LogDebugMapping),
+		WithDebugPalette(MagmaDebugPalette),
+	})
+
+	if expFlags := DebugFlag(Throughput | Accumulator); settings.debugFlags != expFlags {
+		t.Errorf("expected debug flags to be %d; got %d", expFlags, settings.debugFlags)
+	}
+	if settings.debugMapping != LogDebugMapping {
+		t.Errorf("expected debug mapping to be %s; got %s", LogDebugMapping, settings.debugMapping)
+	}
+	if settings.debugPalette != MagmaDebugPalette {
+		t.Errorf("expected debug palette to be %s; got %s", MagmaDebugPalette, settings.debugPalette)
+	}
+	if settings.pixelFilter != GaussianFilter {
+		t.Errorf("expected pixel filter to be %s; got %s", GaussianFilter, settings.pixelFilter)
+	}
+	if settings.rayOrder != TiledRayOrder {
+		t.Errorf("expected ray order to be %s; got %s", TiledRayOrder, settings.rayOrder)
+	}
+	if settings.sampler != HaltonSampler {
+		t.Errorf("expected sampler to be %s; got %s", HaltonSampler, settings.sampler)
+	}
+	if settings.shadowMode != ConeShadows {
+		t.Errorf("expected shadow mode to be %s; got %s", ConeShadows, settings.shadowMode)
+	}
+	if !settings.firstHitCache {
+		t.Error("expected first-hit cache to be enabled")
+	}
+	if settings.normalCorrection != ClampNormalCorrection {
+		t.Errorf("expected normal correction to be %s; got %s", ClampNormalCorrection, settings.normalCorrection)
+	}
+	if settings.textureFilter != TopMipTextureFilter {
+		t.Errorf("expected texture filter to be %s; got %s", TopMipTextureFilter, settings.textureFilter)
+	}
+	if expClamp := (SampleClamp{Direct: 0, Indirect: 10}); settings.sampleClamp != expClamp {
+		t.Errorf("expected sample clamp to be %+v; got %+v", expClamp, settings.sampleClamp)
+	}
+}
+
+func TestPrimaryHitCacheValidity(t *testing.T) {
+	dr := &deviceResources{}
+	blockReq := &tracer.BlockRequest{FrameW: 16, FrameH: 16, BlockY: 0, BlockH: 8}
+	if dr.HasPrimaryHits(blockReq) {
+		t.Fatal("expected empty cache not to contain any hits")
+	}
+
+	dr.primaryHitsBlock = *blockReq
+	dr.primaryHitsValid = true
+	if !dr.HasPrimaryHits(blockReq) {
+		t.Fatal("expected cache to contain hits for the cached block")
+	}
+
+	// Requests with a different seed or sample count reuse the cached hits
+	if !dr.HasPrimaryHits(&tracer.BlockRequest{FrameW: 16, FrameH: 16, BlockY: 0, BlockH: 8, Seed: 42, SamplesPerPixel: 4}) {
+		t.Fatal("expected cache to contain hits for a request with a different seed")
+	}
+
+	// Block reassignments invalidate the cached hits
+	if dr.HasPrimaryHits(&tracer.BlockRequest{FrameW: 16, FrameH: 16, BlockY: 8, BlockH: 8}) {
+		t.Fatal("expected cache not to contain hits for a different block")
+	}
+
+	dr.InvalidatePrimaryHits()
+	if dr.HasPrimaryHits(blockReq) {
+		t.Fatal("expected invalidated cache not to contain any hits")
+	}
+}
+
+func TestNewTracerOptions(t *testing.T) {
+	_, err := NewTracer("test")
+	if err == nil {
+		t.Fatal("expected an error when no device is specified")
+	}
+
+	_, err = NewTracer("test", WithDevice(nil))
+	if err == nil {
+		t.Fatal("expected an error when a nil device is specified")
+	}
+
+	dev := &device.Device{Name: "test device"}
+	tr, err := NewTracer("test", WithDevice(dev), WithSeed(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clTracer := tr.(*Tracer)
+	if clTracer.device != dev {
+		t.Fatal("expected tracer to use the supplied device")
+	}
+	if clTracer.pipeline == nil {
+		t.Fatal("expected tracer to use the default pipeline when no pipeline is specified")
+	}
+
+	// Tracers with the same seed should generate the same kernel seeds
+	other, _ := NewTracer("other", WithDevice(dev), WithSeed(42))
+	for i := 0; i < 10; i++ {
+		if a, b := clTracer.randUint32(), other.(*Tracer).randUint32(); a != b {
+			t.Fatalf("[iteration %d] expected seeded tracers to generate the same values; got %d and %d", i, a, b)
+		}
+	}
+	if clTracer.samplerSeed != 42 {
+		t.Fatalf("expected the seed to scramble the sampler sequences; got sampler seed %d", clTracer.samplerSeed)
+	}
+}
+
+func TestWithBuildOptions(t *testing.T) {
+	dev := &device.Device{Name: "test device"}
+	tr, err := NewTracer("test", WithDevice(dev),
+		WithBuildOptions(device.BuildOptions{Defines: map[string]string{"BVH_MAX_STACK_SIZE": "64"}}),
+		WithBuildOptions(device.BuildOptions{Flags: []string{"-cl-fast-relaxed-math"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "-D BVH_MAX_STACK_SIZE=64 -cl-fast-relaxed-math"
+	if got := tr.(*Tracer).buildOpts.String(); got != exp {
+		t.Fatalf("expected build options to be %q; got %q", exp, got)
+	}
+
+	invalid := []device.BuildOptions{
+		{Defines: map[string]string{"LPE_MAX_STATES": "16"}},
+		{Defines: map[string]string{"STAGE_ABI_VERSION": "1"}},
+		{Flags: []string{"fast-math"}},
+	}
+	for index, opts := range invalid {
+		if _, err = NewTracer("test", WithDevice(dev), WithBuildOptions(opts)); err == nil {
+			t.Errorf("[spec %d] expected an error for build options %+v", index, opts)
+		}
+	}
+}
+
+func TestWithTraversalStackSize(t *testing.T) {
+	dev := &device.Device{Name: "test device"}
+	tr, err := NewTracer("test", WithDevice(dev), WithTraversalStackSize(48))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := "-D BVH_MAX_STACK_SIZE=48"
+	if got := tr.(*Tracer).buildOpts.String(); got != exp {
+		t.Fatalf("expected build options to be %q; got %q", exp, got)
+	}
+
+	for _, size := range []uint32{0, MaxTraversalStackSize + 1} {
+		if _, err = NewTracer("test", WithDevice(dev), WithTraversalStackSize(size)); err == nil {
+			t.Errorf("expected an error for traversal stack size %d", size)
+		}
+	}
+}
+
+func TestParseNormalCorrection(t *testing.T) {
+	for _, correction := range []NormalCorrection{NoNormalCorrection, ClampNormalCorrection, FlipNormalCorrection} {
+		got, err := ParseNormalCorrection(correction.String())
+		if err != nil || got != correction {
+			t.Errorf("expected to parse %q as %d; got %d, %v", correction.String(), correction, got, err)
+		}
+	}
+
+	if _, err := ParseNormalCorrection("smooth"); err == nil {
+		t.Fatal("expected to get an error for an unknown normal correction")
+	}
+}
+
+func TestParseDebugMapping(t *testing.T) {
+	for _, mapping := range []DebugMapping{NormalizeDebugMapping, LogDebugMapping, TonemapDebugMapping} {
+		got, err := ParseDebugMapping(mapping.String())
+		if err != nil || got != mapping {
+			t.Errorf("expected to parse %q as %d; got %d, %v", mapping.String(), mapping, got, err)
+		}
+	}
+
+	if _, err := ParseDebugMapping("linear"); err == nil {
+		t.Fatal("expected to get an error for an unknown debug mapping")
+	}
+}
+
+func TestParseDebugPalette(t *testing.T) {
+	for _, palette := range []DebugPalette{GrayDebugPalette, ViridisDebugPalette, MagmaDebugPalette} {
+		got, err := ParseDebugPalette(palette.String())
+		if err != nil || got != palette {
+			t.Errorf("expected to parse %q as %d; got %d, %v", palette.String(), palette, got, err)
+		}
+	}
+
+	if _, err := ParseDebugPalette("jet"); err == nil {
+		t.Fatal("expected to get an error for an unknown debug palette")
+	}
+}
+
+func TestParseTextureFilter(t *testing.T) {
+	for _, filter := range []TextureFilter{RayDifferentialTextureFilter, TopMipTextureFilter} {
+		got, err := ParseTextureFilter(filter.String())
+		if err != nil || got != filter {
+			t.Errorf("expected to parse %q as %d; got %d, %v", filter.String(), filter, got, err)
+		}
+	}
+
+	if _, err := ParseTextureFilter("anisotropic"); err == nil {
+		t.Fatal("expected to get an error for an unknown texture filter")
+	}
+}
+
+func TestParseSampler(t *testing.T) {
+	for _, sampler := range []Sampler{SobolSampler, HaltonSampler, RandomSampler, BlueNoiseSampler} {
+		got, err := ParseSampler(sampler.String())
+		if err != nil || got != sampler {
+			t.Errorf("expected to parse %q as %d; got %d, %v", sampler.String(), sampler, got, err)
+		}
+	}
+
+	if _, err := ParseSampler("stratified"); err == nil {
+		t.Fatal("expected to get an error for an unknown sampler")
+	}
+}
+
+func TestParseShadowMode(t *testing.T) {
+	for _, mode := range []ShadowMode{TracedShadows, ConeShadows} {
+		got, err := ParseShadowMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf("expected to parse %q as %d; got %d, %v", mode.String(), mode, got, err)
+		}
+	}
+
+	if _, err := ParseShadowMode("pcss"); err == nil {
+		t.Fatal("expected to get an error for an unknown shadow mode")
+	}
+}
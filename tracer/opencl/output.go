@@ -0,0 +1,179 @@
+package opencl
+
+import (
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+// The file format for frames saved by the SaveFrameBufferWithOptions stage.
+type ImageFormat uint8
+
+// The supported output image formats.
+const (
+	PNGFormat ImageFormat = iota
+	JPEGFormat
+	TIFFFormat
+
+	// OpenEXR frames always store the linear radiance using 32-bit floats.
+	EXRFormat
+)
+
+// Implements Stringer.
+func (f ImageFormat) String() string {
+	switch f {
+	case JPEGFormat:
+		return "jpeg"
+	case TIFFFormat:
+		return "tiff"
+	case EXRFormat:
+		return "exr"
+	}
+	return "png"
+}
+
+// Select the output image format based on the extension of an image filename.
+// Filenames without an extension default to the PNG format.
+func ImageFormatFromFilename(imgFile string) (ImageFormat, error) {
+	switch strings.ToLower(filepath.Ext(imgFile)) {
+	case "", ".png":
+		return PNGFormat, nil
+	case ".jpg", ".jpeg":
+		return JPEGFormat, nil
+	case ".tif", ".tiff":
+		return TIFFFormat, nil
+	case ".exr":
+		return EXRFormat, nil
+	}
+	return PNGFormat, ErrUnsupportedImageFormat
+}
+
+// Options for frames saved by the SaveFrameBufferWithOptions stage.
+type ImageOptions struct {
+	// The output file format.
+	Format ImageFormat
+
+	// Save 16 bits per channel (PNG and TIFF only). The frame is tone-mapped
+	// on the host using the radiance buffer so that the additional precision
+	// is preserved; the tone-mapping matches the TonemapSimpleReinhard stage.
+	Depth16 bool
+
+	// Save the linear radiance buffer using 32-bit floats per channel (TIFF
+	// only). Float frames are not tone-mapped or gamma-corrected. EXR frames
+	// are always saved as float frames.
+	Float bool
+
+	// The JPEG encoding quality in the [1, 100] range. If zero, the default
+	// quality of the jpeg encoder is used.
+	JPEGQuality int
+
+	// If non-zero, embed the image resolution in dots per inch into the
+	// image metadata (PNG and TIFF only) so that print workflows use the
+	// correct physical size.
+	DPI float32
+
+	// If specified, burn text and/or a watermark image into the frame.
+	// Overlays are not supported for float frames.
+	Overlay *Overlay
+}
+
+// Check that the options are supported by the selected output format.
+func (opts ImageOptions) Validate() error {
+	switch {
+	case opts.Depth16 && opts.Float,
+		opts.Depth16 && opts.Format == JPEGFormat,
+		opts.Float && opts.Format != TIFFFormat && opts.Format != EXRFormat,
+		opts.Float && opts.Overlay != nil,
+		opts.Format == EXRFormat && (opts.Depth16 || opts.Overlay != nil || opts.DPI > 0),
+		opts.DPI < 0,
+		opts.DPI > 0 && opts.Format == JPEGFormat,
+		opts.JPEGQuality < 0 || opts.JPEGQuality > 100:
+		return ErrUnsupportedImageOptions
+	}
+	return nil
+}
+
+// Read the frame from a tracer and write it to imgFile using the supplied
+// options. The tracer does not need to be an opencl tracer so this function
+// can also be used for saving the output of other tracer implementations.
+func WriteFrame(tr tracer.Tracer, blockReq *tracer.BlockRequest, imgFile string, opts ImageOptions) error {
+	if imgFile == "" {
+		return ErrMissingFilename
+	}
+
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+
+	frameW, frameH := blockReq.FrameW, blockReq.FrameH
+
+	// Float and 16-bit frames are generated from the radiance buffer
+	var radiance []float32
+	if opts.Float || opts.Depth16 || opts.Format == EXRFormat {
+		radiance = make([]float32, frameW*frameH*3)
+		_, err = tr.ReadRadiance(blockReq, radiance)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Format == EXRFormat {
+		return tracer.SaveEXR(imgFile, radiance, frameW, frameH)
+	}
+	if opts.Float {
+		return writeTIFF(imgFile, tiffFloatData(radiance), frameW, frameH, 32, tiffSampleFormatFloat, opts.DPI)
+	}
+
+	var im draw.Image
+	if opts.Depth16 {
+		im16 := image.NewRGBA64(image.Rect(0, 0, int(frameW), int(frameH)))
+		err := tracer.Tonemap16(im16, radiance, frameW, frameH, tracer.DefaultPostStages(blockReq.Exposure)...)
+		if err != nil {
+			return err
+		}
+		im = im16
+	} else {
+		im8 := image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
+		_, err := tr.ReadFrame(blockReq, im8)
+		if err != nil {
+			return err
+		}
+		im = im8
+	}
+
+	if opts.Overlay != nil {
+		opts.Overlay.Apply(im, blockReq)
+	}
+
+	switch opts.Format {
+	case JPEGFormat:
+		return writeJPEG(imgFile, im, opts.JPEGQuality)
+	case TIFFFormat:
+		if opts.Depth16 {
+			return writeTIFF(imgFile, tiff16Data(im.(*image.RGBA64)), frameW, frameH, 16, tiffSampleFormatUint, opts.DPI)
+		}
+		return writeTIFF(imgFile, tiff8Data(im.(*image.RGBA)), frameW, frameH, 8, tiffSampleFormatUint, opts.DPI)
+	}
+	return writePNGWithDPI(imgFile, im, opts.DPI)
+}
+
+// Save im as a JPEG file using the specified quality.
+func writeJPEG(imgFile string, im image.Image, quality int) error {
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	f, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return jpeg.Encode(f, im, &jpeg.Options{Quality: quality})
+}
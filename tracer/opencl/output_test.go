@@ -0,0 +1,62 @@
+package opencl
+
+import "testing"
+
+func TestImageFormatFromFilename(t *testing.T) {
+	specs := []struct {
+		file   string
+		exp    ImageFormat
+		expErr error
+	}{
+		{"frame.png", PNGFormat, nil},
+		{"frame", PNGFormat, nil},
+		{"frame.JPG", JPEGFormat, nil},
+		{"frame.jpeg", JPEGFormat, nil},
+		{"out/frame.tiff", TIFFFormat, nil},
+		{"frame.tif", TIFFFormat, nil},
+		{"frame.EXR", EXRFormat, nil},
+		{"frame.bmp", PNGFormat, ErrUnsupportedImageFormat},
+	}
+
+	for index, spec := range specs {
+		format, err := ImageFormatFromFilename(spec.file)
+		if err != spec.expErr || format != spec.exp {
+			t.Errorf("[spec %d] expected format %s and error %v; got %s and %v", index, spec.exp, spec.expErr, format, err)
+		}
+	}
+}
+
+func TestImageOptionsValidation(t *testing.T) {
+	specs := []struct {
+		opts  ImageOptions
+		valid bool
+	}{
+		{ImageOptions{}, true},
+		{ImageOptions{Depth16: true, DPI: 300}, true},
+		{ImageOptions{Format: TIFFFormat, Float: true, DPI: 300}, true},
+		{ImageOptions{Format: TIFFFormat, Depth16: true}, true},
+		{ImageOptions{Format: JPEGFormat, JPEGQuality: 75}, true},
+		{ImageOptions{Float: true}, false},
+		{ImageOptions{Format: TIFFFormat, Float: true, Depth16: true}, false},
+		{ImageOptions{Format: JPEGFormat, Depth16: true}, false},
+		{ImageOptions{Format: JPEGFormat, DPI: 300}, false},
+		{ImageOptions{Format: JPEGFormat, JPEGQuality: 101}, false},
+		{ImageOptions{DPI: -1}, false},
+		{ImageOptions{Format: JPEGFormat, Overlay: &Overlay{Text: "{spp}"}}, true},
+		{ImageOptions{Format: TIFFFormat, Float: true, Overlay: &Overlay{Text: "{spp}"}}, false},
+		{ImageOptions{Format: EXRFormat}, true},
+		{ImageOptions{Format: EXRFormat, Float: true}, true},
+		{ImageOptions{Format: EXRFormat, Depth16: true}, false},
+		{ImageOptions{Format: EXRFormat, DPI: 300}, false},
+		{ImageOptions{Format: EXRFormat, Overlay: &Overlay{Text: "{spp}"}}, false},
+	}
+
+	for index, spec := range specs {
+		err := spec.opts.Validate()
+		if spec.valid && err != nil {
+			t.Errorf("[spec %d] expected options to be valid; got %v", index, err)
+		} else if !spec.valid && err != ErrUnsupportedImageOptions {
+			t.Errorf("[spec %d] expected to get ErrUnsupportedImageOptions; got %v", index, err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package opencl
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// The frame height (in pixels) at which burned-in text is drawn without any
+// scaling. Text on larger frames is scaled up by an integer factor so it
+// remains legible.
+const overlayTextRefHeight = 540
+
+// The margin (in unscaled pixels) between the overlay elements and the frame edges.
+const overlayMargin = 8
+
+// The frame corner where an overlay element is placed.
+type OverlayPosition uint8
+
+// The supported overlay positions.
+const (
+	BottomLeft OverlayPosition = iota
+	BottomRight
+	TopLeft
+	TopRight
+)
+
+// Implements Stringer.
+func (p OverlayPosition) String() string {
+	switch p {
+	case BottomLeft:
+		return "bottom-left"
+	case BottomRight:
+		return "bottom-right"
+	case TopLeft:
+		return "top-left"
+	case TopRight:
+		return "top-right"
+	}
+	return fmt.Sprintf("OverlayPosition(%d)", uint8(p))
+}
+
+// Parse an overlay position name.
+func ParseOverlayPosition(name string) (OverlayPosition, error) {
+	for _, pos := range []OverlayPosition{BottomLeft, BottomRight, TopLeft, TopRight} {
+		if strings.EqualFold(name, pos.String()) {
+			return pos, nil
+		}
+	}
+
+	return BottomLeft, fmt.Errorf("%s: unknown overlay position %q; supported positions are bottom-left, bottom-right, top-left and top-right", ErrInvalidOption.Error(), name)
+}
+
+// An Overlay describes text and an optional watermark image that are burned
+// into a saved frame (e.g. for dailies and client previews).
+type Overlay struct {
+	// The text to burn into the frame. The text may span multiple lines
+	// and may contain the following placeholders:
+	//  - {scene}: the value of the SceneName field
+	//  - {frame}: the value of the Frame field
+	//  - {spp}: the number of samples per pixel accumulated into the frame
+	//  - {date}: the current date in YYYY-MM-DD format
+	Text         string
+	TextPosition OverlayPosition
+
+	// The values for the {scene} and {frame} placeholders.
+	SceneName string
+	Frame     int
+
+	// An image to blend over the frame using the specified opacity. If
+	// the opacity is zero, the watermark is drawn fully opaque.
+	Watermark         image.Image
+	WatermarkPosition OverlayPosition
+	WatermarkOpacity  float32
+}
+
+// Expand the placeholders in the overlay text.
+func (o *Overlay) expandText(blockReq *tracer.BlockRequest, now time.Time) string {
+	return strings.NewReplacer(
+		"{scene}", o.SceneName,
+		"{frame}", strconv.Itoa(o.Frame),
+		"{spp}", strconv.Itoa(int(blockReq.TotalSamples())),
+		"{date}", now.Format("2006-01-02"),
+	).Replace(o.Text)
+}
+
+// Burn the overlay into dst. The block request supplies the value of the {spp}
+// placeholder.
+func (o *Overlay) Apply(dst draw.Image, blockReq *tracer.BlockRequest) {
+	bounds := dst.Bounds()
+	scale := bounds.Dy() / overlayTextRefHeight
+	if scale < 1 {
+		scale = 1
+	}
+
+	if o.Watermark != nil {
+		mask := image.Image(nil)
+		if o.WatermarkOpacity > 0 && o.WatermarkOpacity < 1 {
+			mask = image.NewUniform(color.Alpha{A: uint8(o.WatermarkOpacity*255 + 0.5)})
+		}
+
+		r := overlayRect(bounds, o.Watermark.Bounds().Size(), o.WatermarkPosition, overlayMargin*scale)
+		draw.DrawMask(dst, r, o.Watermark, o.Watermark.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+
+	if text := o.expandText(blockReq, time.Now()); text != "" {
+		textMask := renderOverlayText(text, scale)
+		r := overlayRect(bounds, textMask.Bounds().Size(), o.TextPosition, overlayMargin*scale)
+
+		// Darken the area behind the text so it remains legible on bright frames
+		draw.Draw(dst, r, image.NewUniform(color.NRGBA{A: 128}), image.Point{}, draw.Over)
+		draw.DrawMask(dst, r, image.White, image.Point{}, textMask, image.Point{}, draw.Over)
+	}
+}
+
+// Render text into an alpha mask using a fixed-size font. The mask includes
+// a padding area around the text and is scaled up by the given integer factor.
+func renderOverlayText(text string, scale int) *image.Alpha {
+	face := basicfont.Face7x13
+	lines := strings.Split(text, "\n")
+	lineH := face.Metrics().Height.Ceil()
+	padding := 2
+
+	var textW int
+	for _, line := range lines {
+		if w := font.MeasureString(face, line).Ceil(); w > textW {
+			textW = w
+		}
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, textW+2*padding, len(lines)*lineH+2*padding))
+	drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face}
+	for index, line := range lines {
+		drawer.Dot = fixed.P(padding, padding+index*lineH+face.Metrics().Ascent.Ceil())
+		drawer.DrawString(line)
+	}
+
+	if scale == 1 {
+		return mask
+	}
+
+	// Upscale using nearest-neighbor sampling to keep the glyphs sharp
+	size := mask.Bounds().Size()
+	scaled := image.NewAlpha(image.Rect(0, 0, size.X*scale, size.Y*scale))
+	for y := 0; y < size.Y*scale; y++ {
+		for x := 0; x < size.X*scale; x++ {
+			scaled.Pix[scaled.PixOffset(x, y)] = mask.Pix[mask.PixOffset(x/scale, y/scale)]
+		}
+	}
+	return scaled
+}
+
+// Get the rectangle for placing an overlay element with the given size at the
+// requested position of the frame bounds.
+func overlayRect(bounds image.Rectangle, size image.Point, pos OverlayPosition, margin int) image.Rectangle {
+	var min image.Point
+	switch pos {
+	case TopLeft:
+		min = image.Pt(bounds.Min.X+margin, bounds.Min.Y+margin)
+	case TopRight:
+		min = image.Pt(bounds.Max.X-margin-size.X, bounds.Min.Y+margin)
+	case BottomRight:
+		min = image.Pt(bounds.Max.X-margin-size.X, bounds.Max.Y-margin-size.Y)
+	default:
+		min = image.Pt(bounds.Min.X+margin, bounds.Max.Y-margin-size.Y)
+	}
+	return image.Rectangle{Min: min, Max: min.Add(size)}
+}
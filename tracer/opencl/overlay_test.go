@@ -0,0 +1,100 @@
+package opencl
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestOverlayTextExpansion(t *testing.T) {
+	o := &Overlay{
+		Text:      "{scene} #{frame}\n{spp} spp, {date}",
+		SceneName: "cornell",
+		Frame:     42,
+	}
+	blockReq := &tracer.BlockRequest{SamplesPerPixel: 16, AccumulatedSamples: 48}
+
+	exp := "cornell #42\n64 spp, 2017-03-05"
+	if got := o.expandText(blockReq, time.Date(2017, 3, 5, 12, 0, 0, 0, time.UTC)); got != exp {
+		t.Fatalf("expected expanded text to be %q; got %q", exp, got)
+	}
+}
+
+func TestOverlayApply(t *testing.T) {
+	frameW, frameH := 200, 100
+	im := image.NewRGBA(image.Rect(0, 0, frameW, frameH))
+	draw.Draw(im, im.Bounds(), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+
+	watermark := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(watermark, watermark.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	o := &Overlay{
+		Text:              "polaris",
+		TextPosition:      BottomLeft,
+		Watermark:         watermark,
+		WatermarkPosition: TopRight,
+		WatermarkOpacity:  0.5,
+	}
+	o.Apply(im, &tracer.BlockRequest{})
+
+	// The watermark should be blended at the top-right corner
+	c := im.RGBAAt(frameW-overlayMargin-5, overlayMargin+5)
+	if c.R < 120 || c.R > 135 || c.B < 120 || c.B > 135 {
+		t.Fatalf("expected watermark to be blended with 50%% opacity; got %v", c)
+	}
+
+	// The text should be drawn in white at the bottom-left corner
+	var numTextPixels int
+	for y := frameH / 2; y < frameH; y++ {
+		for x := 0; x < frameW/2; x++ {
+			if c := im.RGBAAt(x, y); c.R == 255 && c.G == 255 && c.B == 255 {
+				numTextPixels++
+			}
+		}
+	}
+	if numTextPixels == 0 {
+		t.Fatal("expected text to be drawn at the bottom-left corner")
+	}
+
+	// The remaining frame area should not be modified
+	for _, pt := range []image.Point{{0, 0}, {frameW / 2, frameH / 2}, {frameW - 1, frameH - 1}} {
+		if c := im.RGBAAt(pt.X, pt.Y); c != (color.RGBA{0, 0, 255, 255}) {
+			t.Errorf("expected pixel at %v to remain unmodified; got %v", pt, c)
+		}
+	}
+}
+
+func TestOverlayTextScaling(t *testing.T) {
+	mask := renderOverlayText("spp", 1)
+	scaled := renderOverlayText("spp", 3)
+
+	if exp := mask.Bounds().Size().Mul(3); scaled.Bounds().Size() != exp {
+		t.Fatalf("expected scaled mask size to be %v; got %v", exp, scaled.Bounds().Size())
+	}
+
+	size := mask.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if mask.AlphaAt(x, y) != scaled.AlphaAt(x*3+1, y*3+1) {
+				t.Fatalf("expected scaled mask pixel (%d, %d) to match the unscaled mask", x*3+1, y*3+1)
+			}
+		}
+	}
+}
+
+func TestParseOverlayPosition(t *testing.T) {
+	for _, pos := range []OverlayPosition{BottomLeft, BottomRight, TopLeft, TopRight} {
+		got, err := ParseOverlayPosition(pos.String())
+		if err != nil || got != pos {
+			t.Errorf("expected to parse %q as %d; got %d, %v", pos.String(), pos, got, err)
+		}
+	}
+
+	if _, err := ParseOverlayPosition("center"); err == nil {
+		t.Fatal("expected to get an error for an unknown overlay position")
+	}
+}
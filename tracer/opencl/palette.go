@@ -0,0 +1,86 @@
+package opencl
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Evenly spaced samples of the viridis and magma palettes. Colors between the
+// samples are linearly interpolated.
+var (
+	viridisPalette = []color.RGBA{
+		{0x44, 0x01, 0x54, 255},
+		{0x48, 0x28, 0x78, 255},
+		{0x3e, 0x4a, 0x89, 255},
+		{0x31, 0x68, 0x8e, 255},
+		{0x26, 0x82, 0x8e, 255},
+		{0x1f, 0x9e, 0x89, 255},
+		{0x35, 0xb7, 0x79, 255},
+		{0x6d, 0xcd, 0x59, 255},
+		{0xb4, 0xde, 0x2c, 255},
+		{0xfd, 0xe7, 0x25, 255},
+	}
+
+	magmaPalette = []color.RGBA{
+		{0x00, 0x00, 0x04, 255},
+		{0x18, 0x0f, 0x3e, 255},
+		{0x45, 0x10, 0x77, 255},
+		{0x72, 0x1f, 0x81, 255},
+		{0x9f, 0x2f, 0x7f, 255},
+		{0xcd, 0x40, 0x71, 255},
+		{0xf1, 0x60, 0x5d, 255},
+		{0xfd, 0x95, 0x67, 255},
+		{0xfe, 0xc9, 0x8d, 255},
+		{0xfc, 0xfd, 0xbf, 255},
+	}
+)
+
+// Map a value in the [0, 1] range to a palette color. Out of range values are
+// clamped.
+func (p DebugPalette) color(v float64) color.RGBA {
+	if !(v > 0) {
+		v = 0
+	} else if v > 1 {
+		v = 1
+	}
+
+	var samples []color.RGBA
+	switch p {
+	case ViridisDebugPalette:
+		samples = viridisPalette
+	case MagmaDebugPalette:
+		samples = magmaPalette
+	default:
+		g := debugToByte(v)
+		return color.RGBA{g, g, g, 255}
+	}
+
+	pos := v * float64(len(samples)-1)
+	index := int(pos)
+	if index >= len(samples)-1 {
+		return samples[len(samples)-1]
+	}
+	f := pos - float64(index)
+	from, to := samples[index], samples[index+1]
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Floor(float64(a)*(1-f) + float64(b)*f + 0.5))
+	}
+	return color.RGBA{lerp(from.R, to.R), lerp(from.G, to.G), lerp(from.B, to.B), 255}
+}
+
+// Map the gray levels of an image to palette colors. Grayscale images are
+// returned as-is when using the gray palette.
+func (p DebugPalette) colorize(im *image.Gray) image.Image {
+	if p == GrayDebugPalette {
+		return im
+	}
+
+	out := image.NewRGBA(im.Bounds())
+	for y := im.Rect.Min.Y; y < im.Rect.Max.Y; y++ {
+		for x := im.Rect.Min.X; x < im.Rect.Max.X; x++ {
+			out.SetRGBA(x, y, p.color(float64(im.GrayAt(x, y).Y)/255))
+		}
+	}
+	return out
+}
@@ -0,0 +1,77 @@
+package opencl
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestDebugPaletteColor(t *testing.T) {
+	specs := []struct {
+		palette DebugPalette
+		samples []color.RGBA
+	}{
+		{ViridisDebugPalette, viridisPalette},
+		{MagmaDebugPalette, magmaPalette},
+	}
+
+	for _, spec := range specs {
+		if got, exp := spec.palette.color(0), spec.samples[0]; got != exp {
+			t.Errorf("[%s] expected 0 to map to %v; got %v", spec.palette, exp, got)
+		}
+		if got, exp := spec.palette.color(1), spec.samples[len(spec.samples)-1]; got != exp {
+			t.Errorf("[%s] expected 1 to map to %v; got %v", spec.palette, exp, got)
+		}
+
+		// Out of range values are clamped
+		if got, exp := spec.palette.color(-1), spec.palette.color(0); got != exp {
+			t.Errorf("[%s] expected negative values to map to %v; got %v", spec.palette, exp, got)
+		}
+		if got, exp := spec.palette.color(2), spec.palette.color(1); got != exp {
+			t.Errorf("[%s] expected values above 1 to map to %v; got %v", spec.palette, exp, got)
+		}
+
+		// Colors between samples are interpolated
+		mid := spec.palette.color(0.5 / float64(len(spec.samples)-1))
+		from, to := spec.samples[0], spec.samples[1]
+		if got, exp := int(mid.G), (int(from.G)+int(to.G)+1)/2; got != exp {
+			t.Errorf("[%s] expected the green component between the first two samples to be %d; got %d", spec.palette, exp, got)
+		}
+
+		// Palettes increase monotonically in brightness
+		var prevLum float64 = -1
+		for i := 0; i <= 64; i++ {
+			c := spec.palette.color(float64(i) / 64)
+			lum := 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+			if lum < prevLum {
+				t.Errorf("[%s] expected brightness to increase monotonically; got %f after %f at %d/64", spec.palette, lum, prevLum, i)
+				break
+			}
+			prevLum = lum
+		}
+	}
+
+	if got := GrayDebugPalette.color(0.5); got != (color.RGBA{128, 128, 128, 255}) {
+		t.Errorf("expected the gray palette to map 0.5 to gray level 128; got %v", got)
+	}
+}
+
+func TestDebugPaletteColorize(t *testing.T) {
+	im := image.NewGray(image.Rect(0, 0, 2, 1))
+	im.Pix = []uint8{0, 255}
+
+	if got := GrayDebugPalette.colorize(im); got != image.Image(im) {
+		t.Fatal("expected the gray palette to return the grayscale image")
+	}
+
+	out, ok := MagmaDebugPalette.colorize(im).(*image.RGBA)
+	if !ok {
+		t.Fatalf("expected colorized image to be an *image.RGBA; got %T", MagmaDebugPalette.colorize(im))
+	}
+	if got, exp := out.RGBAAt(0, 0), magmaPalette[0]; got != exp {
+		t.Errorf("expected black pixel to map to %v; got %v", exp, got)
+	}
+	if got, exp := out.RGBAAt(1, 0), magmaPalette[len(magmaPalette)-1]; got != exp {
+		t.Errorf("expected white pixel to map to %v; got %v", exp, got)
+	}
+}
@@ -3,15 +3,13 @@ package opencl
 import (
 	"fmt"
 	"image"
-	"image/png"
-	"math/rand"
-	"os"
+	"math"
 	"time"
-	"unsafe"
 
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/shm"
 	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
-	"github.com/go-gl/gl/v2.1/gl"
 )
 
 // Debug flags.
@@ -32,6 +30,11 @@ const (
 // An alias for functions that can be used as part of the rendering pipeline.
 type PipelineStage func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error)
 
+// A HostPostFunc processes the HDR frame on the host. The radiance slice
+// contains the linear RGB values (3 float32 values per pixel) of the frame
+// normalized by the number of accumulated samples and may be modified in place.
+type HostPostFunc func(radiance []float32, frameW, frameH uint32) error
+
 // The list of pluggable of stages that are used to render the scene.
 type Pipeline struct {
 	// Reset the tracer state. This stage is executed whenever the camera
@@ -50,20 +53,72 @@ type Pipeline struct {
 	// A set of post-processing stages that are executed prior to
 	// rendering the final frame.
 	PostProcess []PipelineStage
+
+	// If set, the tracer collects per-pixel sample statistics that can
+	// be exported via the SaveSampleStats stage.
+	CollectSampleStats bool
+
+	// If set, the frame accumulator aggregates the samples traced by
+	// each pass using compensated (Kahan) summation. This prevents the
+	// precision loss of single-precision accumulators when collecting
+	// very high sample counts (100k+ spp) at the cost of an additional
+	// frame-sized buffer. The samples traced by a single pass are still
+	// summed in single precision so this option should be combined with
+	// a sample schedule or progressive rendering.
+	CompensatedAccumulation bool
+
+	// A list of light path expressions for generating custom output
+	// passes (e.g. direct diffuse lighting or caustics). Each pass
+	// accumulates the contribution of the light paths matching its
+	// expression and can be exported via the SaveLightPathPasses stage.
+	// Up to MaxLightPathExpressions expressions are supported.
+	LightPathExpressions []*LightPathExpression
+
+	// If set, the light reaching the camera from each light group of the
+	// scene is accumulated to a separate pass. Group 0 contains the scene
+	// background and the environment light and each of the remaining
+	// groups an emissive material. Before running the post-processing
+	// stages, the passes are rescaled by the LightGroupScales of the block
+	// request so the intensity of each group can be changed without
+	// tracing the frame again. Up to MaxLightGroups groups are supported.
+	LightGroups bool
+
+	// If non-zero, the scene textures are streamed based on visibility
+	// and at most this many bytes of texture data are kept on the device.
+	// The tracer records the mip levels sampled by the camera ray hits
+	// and only uploads the finer levels of textures that need them, which
+	// allows rendering scenes whose textures do not fit in device memory.
+	TextureStreamingBudget uint64
+
+	// The sink for debug images generated when debug flags are enabled.
+	// If not specified, debug images are written as PNG files to the
+	// current working directory.
+	DebugSink DebugSink
 }
 
-func DefaultPipeline(debugFlags DebugFlag) *Pipeline {
+// Create the default rendering pipeline. The pipeline stages can be
+// configured via a list of pipeline options.
+func DefaultPipeline(opts ...PipelineOption) *Pipeline {
+	settings := applyPipelineOptions(opts)
 	pipeline := &Pipeline{
 		Reset:               ClearAccumulator(),
-		PrimaryRayGenerator: PerspectiveCamera(),
-		Integrator:          MonteCarloIntegrator(debugFlags),
+		PrimaryRayGenerator: PerspectiveCamera(opts...),
+		Integrator:          MonteCarloIntegrator(opts...),
 		PostProcess: []PipelineStage{
 			TonemapSimpleReinhard(),
 		},
 	}
 
-	if debugFlags&FrameBuffer == FrameBuffer {
-		pipeline.PostProcess = append(pipeline.PostProcess, SaveFrameBuffer("debug-fb.png"))
+	if settings.ambientOcclusion {
+		pipeline.Integrator = AmbientOcclusion(settings.aoDistance, opts...)
+	} else if settings.directLighting {
+		pipeline.Integrator = DirectLighting(opts...)
+	} else if settings.lightPathLength > 0 {
+		pipeline.Integrator = BidirectionalIntegrator(opts...)
+	}
+
+	if settings.debugFlags&FrameBuffer == FrameBuffer {
+		pipeline.PostProcess = append(pipeline.PostProcess, DebugFrameBuffer())
 	}
 
 	return pipeline
@@ -72,128 +127,266 @@ func DefaultPipeline(debugFlags DebugFlag) *Pipeline {
 // Clear the frame accumulator buffer.
 func ClearAccumulator() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-		return tr.resources.ClearFrameAccumulator(blockReq)
+		return tr.stageRes.ClearFrameAccumulator(blockReq)
+	}
+}
+
+// Process the HDR frame using a Go callback. This stage allows prototyping
+// post-processing effects on the host before porting them to opencl kernels.
+// The stage reads the frame radiance, invokes fn and uploads the processed
+// radiance back to the device. Post-processing stages that follow this stage
+// (e.g. tone-mapping, saving float frames or another host stage) operate on
+// the processed radiance. The frame accumulator itself is not modified so
+// effects do not compound as more samples are accumulated.
+func HostPostProcess(fn HostPostFunc) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		radiance := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+		_, err := tr.ReadRadiance(blockReq, radiance)
+		if err != nil {
+			return 0, err
+		}
+
+		err = fn(radiance, blockReq.FrameW, blockReq.FrameH)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tr.stageRes.WritePostRadiance(blockReq, radiance)
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Since(start), nil
 	}
 }
 
-// Use a perspective camera for the primary ray generation stage.
-func PerspectiveCamera() PipelineStage {
+// Use a perspective camera for the primary ray generation stage. The
+// WithPixelFilter option selects the filter for distributing the primary
+// ray samples within each pixel and the WithRayOrder option selects the order
+// in which rays are assigned to the block pixels. If the camera defines a finite aperture,
+// the stage simulates depth of field by sampling the camera lens. If the
+// WithFirstHitCache option is specified, the point filter is always used
+// and depth of field is disabled.
+func PerspectiveCamera(opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	if settings.firstHitCache {
+		settings.pixelFilter = PointFilter
+	}
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-		return tr.resources.GeneratePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum)
+		lens := tr.cameraLens
+		if settings.firstHitCache {
+			lens.focusDistance = 0
+		}
+		return tr.stageRes.GeneratePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum, lens, settings.pixelFilter, settings.rayOrder, tr.currentPathSampler(blockReq, settings.sampler))
+	}
+}
+
+// Use a thin-lens camera for the primary ray generation stage. Unlike
+// PerspectiveCamera, which falls back to a pinhole camera, the stage always
+// simulates depth of field by jittering the primary ray origins across the
+// lens aperture and focusing them on the camera focus plane. The stage fails
+// if the camera does not define both an aperture radius and a focus distance
+// or if the WithFirstHitCache option is specified since cached primary hits
+// cannot capture lens blur.
+func ThinLensCamera(opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		if settings.firstHitCache {
+			return 0, fmt.Errorf("%s: the thin-lens camera cannot be combined with the first hit cache", ErrInvalidOption.Error())
+		}
+		if tr.cameraLens.focusDistance == 0 {
+			return 0, ErrNoCameraAperture
+		}
+		return tr.stageRes.GeneratePrimaryRays(blockReq, tr.cameraPosition, tr.cameraFrustrum, tr.cameraLens, settings.pixelFilter, settings.rayOrder, tr.currentPathSampler(blockReq, settings.sampler))
 	}
 }
 
 // Apply simple Reinhard tone-mapping.
 func TonemapSimpleReinhard() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
-		return tr.resources.TonemapSimpleReinhard(blockReq)
+		return tr.stageRes.TonemapSimpleReinhard(blockReq)
 	}
 }
 
-// Use a montecarlo pathtracer implementation.
-func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
+// Use a montecarlo pathtracer implementation. The WithDebugFlags option
+// enables the generation of debug images for the integrator stages, the
+// WithDebugMapping option selects how the debug images visualize depth,
+// throughput, accumulator and emissive sample values, the WithDebugPalette
+// option selects the colors of the depth debug image, the WithFirstHitCache
+// option enables caching of primary ray intersections and the WithShadowMode
+// option selects how the visibility of sampled lights is tested.
+func MonteCarloIntegrator(opts ...PipelineOption) PipelineStage {
+	return integrator(applyPipelineOptions(opts), 0)
+}
+
+// Use a bidirectional pathtracer implementation. Besides the estimates of the
+// montecarlo pathtracer, the integrator traces a light subpath from a random
+// area light for each pixel and connects each eye path vertex to the light
+// subpath vertices. This improves the rendering of scenes where lights are
+// mostly reached via indirect paths (e.g. rooms lit through a door) at the
+// cost of additional intersection tests. The WithLightPathLength option sets
+// the max number of light subpath vertices. All options supported by
+// MonteCarloIntegrator are also supported by this stage.
+//
+// The estimates are combined by assigning the same weight to all sampling
+// strategies that can generate a path. Environment lights do not start light
+// subpaths so their contribution is only estimated by the pathtracer. Light
+// subpath connections are not recorded by light path expressions.
+func BidirectionalIntegrator(opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	numLightVertices := settings.lightPathLength
+	if numLightVertices == 0 {
+		numLightVertices = DefaultLightPathLength
+	}
+	return integrator(settings, numLightVertices)
+}
+
+// Create an integrator stage. If numLightVertices is not zero, the stage
+// connects the eye paths to light subpaths with up to numLightVertices
+// vertices.
+func integrator(settings pipelineSettings, numLightVertices uint32) PipelineStage {
+	debugFlags := settings.debugFlags
+	debugMapping := settings.debugMapping
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 		var err error
 
 		start := time.Now()
+		if tr.sceneData == nil {
+			return 0, tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+		}
+
 		numPixels := int(blockReq.FrameW * blockReq.BlockH)
 		numEmissives := uint32(len(tr.sceneData.EmissivePrimitives))
 
+		// Light subpaths share the intersection buffers with the eye
+		// paths so they are traced before the primary ray intersections.
+		numConnections := numLightVertices
+		if numEmissives == 0 {
+			numConnections = 0
+		}
+		if numConnections > 0 {
+			err = traceLightSubpaths(tr, blockReq, settings, numEmissives, numConnections, numPixels)
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
 		var activeRayBuf uint32 = 0
 
 		// Intersect primary rays outside of the loop
-		// Use packet query intersector for GPUs as opencl forces CPU
-		// to use a local workgroup size equal to 1
-		if tr.device.Type == device.GpuDevice {
-			_, err = tr.resources.RayPacketIntersectionQuery(activeRayBuf, numPixels)
-		} else {
-			_, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
-		}
-		if err != nil {
+		if err = intersectPrimaryRays(tr, blockReq, settings, activeRayBuf, numPixels); err != nil {
 			return time.Since(start), err
 		}
 
 		if debugFlags&PrimaryRayIntersectionDepth == PrimaryRayIntersectionDepth {
-			_, err = tr.resources.DebugRayIntersectionDepth(blockReq, activeRayBuf)
-			err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, "debug-primary-intersection-depth.png")
+			_, err = tr.stageRes.DebugRayIntersectionDepth(blockReq, activeRayBuf)
+			err = dumpDebugValues(err, tr, blockReq, "primary-intersection-depth", debugMapping, settings.debugPalette)
 			if err != nil {
 				return time.Since(start), err
 			}
 		}
 		if debugFlags&PrimaryRayIntersectionNormals == PrimaryRayIntersectionNormals {
-			_, err = tr.resources.DebugRayIntersectionNormals(blockReq, activeRayBuf)
-			err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, "debug-primary-intersection-normals.png")
+			_, err = tr.stageRes.DebugRayIntersectionNormals(blockReq, activeRayBuf)
+			err = dumpDebugBuffer(err, tr, blockReq, "primary-intersection-normals")
 			if err != nil {
 				return time.Since(start), err
 			}
 		}
 
+		envMatIndex := tr.envMatNodeIndex()
+		sampler := tr.currentPathSampler(blockReq, settings.sampler)
+
 		var bounce uint32
 		for bounce = 0; bounce < blockReq.NumBounces; bounce++ {
-			// Shade misses
-			if tr.sceneData.SceneDiffuseMatIndex != -1 {
-				if bounce == 0 {
-					_, err = tr.resources.ShadePrimaryRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
-				} else {
-					_, err = tr.resources.ShadeIndirectRayMisses(uint32(tr.sceneData.SceneDiffuseMatIndex), activeRayBuf, numPixels)
+			if err = tr.interrupted(); err != nil {
+				return time.Since(start), err
+			}
+
+			// Paths traveling through participating media may scatter
+			// before reaching the next surface or escaping the scene.
+			if tr.hasMedia {
+				_, err = tr.stageRes.SampleMediumInteractions(blockReq, tr.sceneData.SceneMediumMatIndex, bounce, tr.randUint32(), sampler, numEmissives, settings.sampleClamp, activeRayBuf, numPixels)
+				if err != nil {
+					return time.Since(start), err
 				}
+			}
+
+			// Shade misses. Camera ray misses use the backplate if the
+			// scene defines one whereas all other misses sample the scene
+			// env map or background.
+			if bounce == 0 && (tr.sceneData.SceneDiffuseMatIndex != -1 || tr.sceneData.SceneBackplateMatIndex != -1 || envMatIndex != -1) {
+				_, err = tr.stageRes.ShadePrimaryRayMisses(blockReq, tr.sceneData.SceneDiffuseMatIndex, tr.sceneData.SceneBackplateMatIndex, envMatIndex, activeRayBuf, numPixels)
+			} else if bounce > 0 && (tr.sceneData.SceneDiffuseMatIndex != -1 || envMatIndex != -1) {
+				_, err = tr.stageRes.ShadeIndirectRayMisses(blockReq, tr.sceneData.SceneDiffuseMatIndex, envMatIndex, bounce, settings.sampleClamp, activeRayBuf, numPixels)
+			}
+			if err != nil {
+				return time.Since(start), err
+			}
+
+			// Connect hits to the light subpath vertices before
+			// shading updates the path throughput
+			var lightDepth uint32
+			for lightDepth = 0; lightDepth < numConnections; lightDepth++ {
+				_, err = tr.stageRes.ConnectLightVertex(blockReq, bounce, tr.randUint32(), lightDepth, numConnections, settings.normalCorrection, settings.textureFilter, settings.sampleClamp, activeRayBuf, numPixels)
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			// Shade hits
-			_, err = tr.resources.ShadeHits(bounce, blockReq.MinBouncesForRR, rand.Uint32(), numEmissives, activeRayBuf, numPixels)
+			_, err = tr.stageRes.ShadeHits(blockReq, tr.sceneData.SceneDiffuseMatIndex, tr.sceneData.SceneBackplateMatIndex, envMatIndex, tr.sceneData.SceneMediumMatIndex, bounce, blockReq.MinBouncesForRR, tr.randUint32(), sampler, numEmissives, settings.normalCorrection, settings.textureFilter, settings.sampleClamp, numConnections, activeRayBuf, numPixels)
 			if err != nil {
 				return time.Since(start), err
 			}
 
 			if debugFlags&Throughput == Throughput {
-				_, err = tr.resources.DebugThroughput(blockReq)
-				err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, fmt.Sprintf("debug-throughput-%03d.png", bounce))
+				_, err = tr.stageRes.DebugThroughput(blockReq)
+				err = dumpDebugValues(err, tr, blockReq, fmt.Sprintf("throughput-%03d", bounce), debugMapping, GrayDebugPalette)
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			// Process intersections for occlusion rays and accumulate emissive samples for non occluded paths
-			_, err := tr.resources.RayIntersectionTest(2, numPixels)
+			_, err = testOcclusionRays(tr, settings, numPixels)
 			if err != nil {
 				return time.Since(start), err
 			}
 
-			_, err = tr.resources.AccumulateEmissiveSamples(2, numPixels)
+			_, err = tr.stageRes.AccumulateEmissiveSamples(blockReq, 2, numPixels)
 			if err != nil {
 				return time.Since(start), err
 			}
 
 			if debugFlags&AllEmissiveSamples == AllEmissiveSamples {
-				_, err = tr.resources.DebugEmissiveSamples(blockReq, 0, 0)
-				err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, fmt.Sprintf("debug-emissive-all-%03d.png", bounce))
+				_, err = tr.stageRes.DebugEmissiveSamples(blockReq, 0, 0)
+				err = dumpDebugValues(err, tr, blockReq, fmt.Sprintf("emissive-all-%03d", bounce), debugMapping, GrayDebugPalette)
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			if debugFlags&VisibleEmissiveSamples == VisibleEmissiveSamples {
-				_, err = tr.resources.DebugEmissiveSamples(blockReq, 1, 0)
-				err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, fmt.Sprintf("debug-emissive-vis-%03d.png", bounce))
+				_, err = tr.stageRes.DebugEmissiveSamples(blockReq, 1, 0)
+				err = dumpDebugValues(err, tr, blockReq, fmt.Sprintf("emissive-vis-%03d", bounce), debugMapping, GrayDebugPalette)
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			if debugFlags&OccludedEmissiveSamples == OccludedEmissiveSamples {
-				_, err = tr.resources.DebugEmissiveSamples(blockReq, 0, 1)
-				err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, fmt.Sprintf("debug-emissive-occ-%03d.png", bounce))
+				_, err = tr.stageRes.DebugEmissiveSamples(blockReq, 0, 1)
+				err = dumpDebugValues(err, tr, blockReq, fmt.Sprintf("emissive-occ-%03d", bounce), debugMapping, GrayDebugPalette)
 				if err != nil {
 					return time.Since(start), err
 				}
 			}
 
 			if debugFlags&Accumulator == Accumulator {
-				_, err = tr.resources.DebugAccumulator(blockReq)
-				err = dumpDebugBuffer(err, tr.resources, blockReq.FrameW, blockReq.FrameH, fmt.Sprintf("debug-accumulator-%03d.png", bounce))
+				_, err = tr.stageRes.DebugAccumulator(blockReq, tr.tracedSamples)
+				err = dumpDebugValues(err, tr, blockReq, fmt.Sprintf("accumulator-%03d", bounce), debugMapping, GrayDebugPalette)
 				if err != nil {
 					return time.Since(start), err
 				}
@@ -202,7 +395,7 @@ func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 			// Process intersections for indirect rays
 			if bounce+1 < blockReq.NumBounces {
 				activeRayBuf = 1 - activeRayBuf
-				_, err = tr.resources.RayIntersectionQuery(activeRayBuf, numPixels)
+				_, err = tr.stageRes.RayIntersectionQuery(activeRayBuf, 0, numPixels)
 				if err != nil {
 					return time.Since(start), err
 				}
@@ -212,71 +405,319 @@ func MonteCarloIntegrator(debugFlags DebugFlag) PipelineStage {
 	}
 }
 
+// Calculate the primary ray intersections or restore them from the first hit
+// cache if the WithFirstHitCache option is specified. If texture streaming is
+// enabled, the texture footprints of the primary ray hits are also recorded.
+func intersectPrimaryRays(tr *Tracer, blockReq *tracer.BlockRequest, settings pipelineSettings, rayBufferIndex uint32, numPixels int) error {
+	var err error
+
+	// Use packet query intersector for GPUs as opencl forces CPU
+	// to use a local workgroup size equal to 1
+	if settings.firstHitCache && tr.stageRes.HasPrimaryHits(blockReq) {
+		_, err = tr.stageRes.RestorePrimaryHits(blockReq)
+	} else if tr.device.Type == device.GpuDevice {
+		_, err = tr.stageRes.RayPacketIntersectionQuery(rayBufferIndex, scene.CameraInvisible, numPixels)
+	} else {
+		_, err = tr.stageRes.RayIntersectionQuery(rayBufferIndex, scene.CameraInvisible, numPixels)
+	}
+	if err != nil {
+		return err
+	}
+
+	if settings.firstHitCache && !tr.stageRes.HasPrimaryHits(blockReq) {
+		_, err = tr.stageRes.StorePrimaryHits(blockReq)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tr.textures != nil {
+		_, err = tr.stageRes.RecordTextureFeedback(settings.textureFilter, rayBufferIndex, numPixels)
+	}
+	return err
+}
+
+// Test the occlusion rays emitted by the shading stage using the shadow mode
+// selected by the WithShadowMode option.
+func testOcclusionRays(tr *Tracer, settings pipelineSettings, numPixels int) (time.Duration, error) {
+	if settings.shadowMode == ConeShadows {
+		return tr.stageRes.ConeOcclusionTest(2, numPixels)
+	}
+	return tr.stageRes.RayIntersectionTest(2, numPixels)
+}
+
+// Trace the light subpaths for a block and store their vertices on the device.
+func traceLightSubpaths(tr *Tracer, blockReq *tracer.BlockRequest, settings pipelineSettings, numEmissives, numLightVertices uint32, numPixels int) error {
+	_, err := tr.stageRes.GenerateLightRays(blockReq, tr.randUint32(), numEmissives, numLightVertices, numPixels)
+	if err != nil {
+		return err
+	}
+
+	var lightRayBuf, depth uint32
+	for depth = 0; depth < numLightVertices; depth++ {
+		if err = tr.interrupted(); err != nil {
+			return err
+		}
+
+		_, err = tr.stageRes.LightRayIntersectionQuery(lightRayBuf, numPixels)
+		if err != nil {
+			return err
+		}
+
+		_, err = tr.stageRes.ShadeLightHits(depth, blockReq.MinBouncesForRR, tr.randUint32(), numLightVertices, settings.normalCorrection, lightRayBuf, numPixels)
+		if err != nil {
+			return err
+		}
+		lightRayBuf = 1 - lightRayBuf
+	}
+
+	return nil
+}
+
+// Use an integrator that only estimates the direct lighting of the surfaces
+// visible by the camera. Each primary hit is shaded using next event estimation
+// and a single bxdf sample which only contributes if it reaches a light or
+// the scene background; light that reaches a surface via other surfaces is
+// ignored. This is useful for iterating on the lighting setup and as a
+// baseline when debugging the path tracer. The block request bounce settings
+// are ignored. The WithNormalCorrection, WithTextureFilter, WithSampleClamp,
+// WithFirstHitCache and WithShadowMode options are supported by this stage.
+func DirectLighting(opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		if tr.sceneData == nil {
+			return 0, tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+		}
+
+		numPixels := int(blockReq.FrameW * blockReq.BlockH)
+		numEmissives := uint32(len(tr.sceneData.EmissivePrimitives))
+		envMatIndex := tr.envMatNodeIndex()
+		hasBackground := tr.sceneData.SceneDiffuseMatIndex != -1 || envMatIndex != -1
+
+		err := intersectPrimaryRays(tr, blockReq, settings, 0, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		if hasBackground || tr.sceneData.SceneBackplateMatIndex != -1 {
+			_, err = tr.stageRes.ShadePrimaryRayMisses(blockReq, tr.sceneData.SceneDiffuseMatIndex, tr.sceneData.SceneBackplateMatIndex, envMatIndex, 0, numPixels)
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
+		// Shade the primary hits without russian roulette. The bxdf
+		// samples are emitted into the second ray buffer.
+		_, err = tr.stageRes.ShadeHits(blockReq, tr.sceneData.SceneDiffuseMatIndex, tr.sceneData.SceneBackplateMatIndex, envMatIndex, -1, 0, 1, tr.randUint32(), tr.currentPathSampler(blockReq, settings.sampler), numEmissives, settings.normalCorrection, settings.textureFilter, settings.sampleClamp, 0, 0, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = testOcclusionRays(tr, settings, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = tr.stageRes.AccumulateEmissiveSamples(blockReq, 2, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		// Add the MIS-weighted contribution of bxdf samples that reach
+		// a light or the scene background.
+		_, err = tr.stageRes.RayIntersectionQuery(1, 0, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		if hasBackground {
+			_, err = tr.stageRes.ShadeIndirectRayMisses(blockReq, tr.sceneData.SceneDiffuseMatIndex, envMatIndex, 1, settings.sampleClamp, 1, numPixels)
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
+		_, err = tr.stageRes.ShadeEmissiveHits(blockReq, 1, tr.randUint32(), settings.sampleClamp, 1, numPixels)
+		return time.Since(start), err
+	}
+}
+
+// Use an ambient occlusion integrator for fast previews. The integrator
+// ignores the scene materials and lights and shades each primary hit by the
+// fraction of the hemisphere around the surface normal that is not occluded
+// by geometry closer than maxDist. Primary rays that miss the scene are
+// shaded white. If maxDist is not positive, occlusion rays are not limited.
+// The WithNormalCorrection and WithFirstHitCache options are supported by
+// this stage.
+func AmbientOcclusion(maxDist float32, opts ...PipelineOption) PipelineStage {
+	settings := applyPipelineOptions(opts)
+	if maxDist <= 0 {
+		maxDist = math.MaxFloat32
+	}
+
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		if tr.sceneData == nil {
+			return 0, tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+		}
+
+		numPixels := int(blockReq.FrameW * blockReq.BlockH)
+		err := intersectPrimaryRays(tr, blockReq, settings, 0, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = tr.stageRes.ShadeAmbientOcclusion(blockReq, tr.randUint32(), tr.currentPathSampler(blockReq, settings.sampler), maxDist, settings.normalCorrection, 0, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		// Accumulate the samples of non-occluded rays
+		_, err = tr.stageRes.RayIntersectionTest(2, numPixels)
+		if err != nil {
+			return time.Since(start), err
+		}
+
+		_, err = tr.stageRes.AccumulateEmissiveSamples(blockReq, 2, numPixels)
+		return time.Since(start), err
+	}
+}
+
 // Save a copy of the RGBA framebuffer.
 func SaveFrameBuffer(imgFile string) PipelineStage {
+	return SaveFrameBufferWithOptions(imgFile, ImageOptions{})
+}
+
+// Save a copy of the framebuffer using the supplied options for selecting
+// the image format, the bit depth and the embedded metadata.
+func SaveFrameBufferWithOptions(imgFile string, opts ImageOptions) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+		return time.Since(start), WriteFrame(tr, blockReq, imgFile, opts)
+	}
+}
+
+// Save the linear radiance of the frame (before tone-mapping) as an OpenEXR
+// image so that it can be graded or denoised by external tools.
+func SaveEXR(imgFile string) PipelineStage {
+	return SaveFrameBufferWithOptions(imgFile, ImageOptions{Format: EXRFormat})
+}
+
+// Send a copy of the RGBA framebuffer to the pipeline debug sink.
+func DebugFrameBuffer() PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 		start := time.Now()
 
-		f, err := os.Create(imgFile)
+		im := image.NewRGBA(image.Rect(0, 0, int(blockReq.FrameW), int(blockReq.FrameH)))
+		_, err := tr.ReadFrame(blockReq, im)
 		if err != nil {
 			return 0, err
 		}
-		defer f.Close()
 
-		im := image.NewRGBA(image.Rect(0, 0, int(blockReq.FrameW), int(blockReq.FrameH)))
-		err = tr.resources.buffers.FrameBuffer.ReadData(0, 0, tr.resources.buffers.FrameBuffer.Size(), im.Pix)
+		return time.Since(start), tr.pipeline.debugSink().WriteDebugImage("fb", im)
+	}
+}
+
+// Save the per-pixel sample statistics as PNG images. The sample count image is
+// normalized by the max per-pixel sample count while the error image encodes
+// the relative standard error of the pixel luminance estimate. Either file name
+// may be empty to skip the respective image. The images are grayscale unless
+// a different palette is selected via the WithDebugPalette option. This stage
+// requires the pipeline to collect sample statistics.
+func SaveSampleStats(countFile, errorFile string, opts ...PipelineOption) PipelineStage {
+	palette := applyPipelineOptions(opts).debugPalette
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
+
+		if !tr.pipeline.CollectSampleStats {
+			return 0, ErrSampleStatsDisabled
+		}
+
+		stats := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+		_, err := tr.ReadSampleStats(blockReq, stats)
 		if err != nil {
 			return 0, err
 		}
 
-		return time.Since(start), png.Encode(f, im)
+		for _, out := range []struct {
+			file string
+			img  func([]float32, uint32, uint32) *image.Gray
+		}{
+			{countFile, sampleCountImage},
+			{errorFile, sampleErrorImage},
+		} {
+			if out.file == "" {
+				continue
+			}
+
+			err = writePNG(out.file, palette.colorize(out.img(stats, blockReq.FrameW, blockReq.FrameH)))
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		return time.Since(start), nil
 	}
 }
 
-// Copy RGBA screen buffer to opengl texture. This function assumes that
-// the caller has enabled the appropriate 2D texture target.
-func CopyFrameBufferToOpenGLTexture() PipelineStage {
-	var fbBuf []byte
+// Save the output pass of each light path expression defined by the pipeline.
+// The file name for each pass is generated by replacing the {pass} placeholder
+// in filePattern with the pass name. The image format is selected using the
+// file extension; TIFF and EXR passes store the linear pass radiance as 32-bit
+// floats while PNG and JPEG passes are tone-mapped in the same way as the frame buffer.
+func SaveLightPathPasses(filePattern string) PipelineStage {
 	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
 		start := time.Now()
 
-		fbSizeInBytes := tr.resources.buffers.FrameBuffer.Size()
-		if fbBuf == nil || len(fbBuf) != fbSizeInBytes {
-			fbBuf = make([]byte, fbSizeInBytes)
+		if len(tr.pipeline.LightPathExpressions) == 0 {
+			return 0, ErrNoLightPathExpressions
 		}
 
-		err := tr.resources.buffers.FrameBuffer.ReadData(0, 0, fbSizeInBytes, fbBuf)
+		format, err := ImageFormatFromFilename(filePattern)
 		if err != nil {
 			return 0, err
 		}
 
-		gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(blockReq.FrameW), int32(blockReq.FrameH), gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&fbBuf[0]))
+		radiance := make([]float32, blockReq.FrameW*blockReq.FrameH*3)
+		for pass, expr := range tr.pipeline.LightPathExpressions {
+			_, err = tr.ReadLightPathPass(blockReq, pass, radiance)
+			if err != nil {
+				return 0, err
+			}
+
+			err = writeLightPathPass(lightPathPassFile(filePattern, expr.Name), format, radiance, blockReq)
+			if err != nil {
+				return 0, err
+			}
+		}
+
 		return time.Since(start), nil
 	}
 }
 
-// Dump debug buffer to png file.
-func dumpDebugBuffer(debugKernelError error, dr *deviceResources, frameW, frameH uint32, imgFile string) error {
-	if debugKernelError != nil {
-		return debugKernelError
-	}
-	f, err := os.Create(imgFile)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// Publish the RGBA framebuffer to a memory-mapped segment so that it can be
+// displayed by other processes. The segment dimensions must match the
+// frame dimensions.
+func PublishFrameBuffer(seg *shm.Segment) PipelineStage {
+	return func(tr *Tracer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+		start := time.Now()
 
-	im := image.NewRGBA(image.Rect(0, 0, int(frameW), int(frameH)))
-	err = dr.buffers.DebugOutput.ReadData(0, 0, dr.buffers.DebugOutput.Size(), im.Pix)
-	if err != nil {
-		return err
-	}
+		if seg.FrameW() != blockReq.FrameW || seg.FrameH() != blockReq.FrameH {
+			return 0, shm.ErrDimensionMismatch
+		}
 
-	return png.Encode(f, im)
-}
+		im, err := seg.BeginWrite()
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tr.ReadFrame(blockReq, im)
+		endErr := seg.EndWrite()
+		if err != nil {
+			return 0, err
+		}
 
-func readCounter(dr *deviceResources, counterIndex uint32) uint32 {
-	out := make([]uint32, 1)
-	dr.buffers.RayCounters[counterIndex].ReadData(0, 0, 4, out)
-	return out[0]
+		return time.Since(start), endErr
+	}
 }
@@ -0,0 +1,589 @@
+package opencl
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/tracer/opencl/device"
+)
+
+func testBlockRequest() *tracer.BlockRequest {
+	return &tracer.BlockRequest{
+		FrameW:          4,
+		FrameH:          2,
+		BlockW:          4,
+		BlockH:          2,
+		SamplesPerPixel: 1,
+		NumBounces:      2,
+		MinBouncesForRR: 1,
+	}
+}
+
+func TestPerspectiveCameraStage(t *testing.T) {
+	specs := []struct {
+		opts      []PipelineOption
+		expFilter PixelFilter
+		expFocus  float32
+		expOrder  RayOrder
+	}{
+		{nil, TentFilter, 5, MortonRayOrder},
+		{[]PipelineOption{WithPixelFilter(GaussianFilter), WithRayOrder(ScanlineRayOrder)}, GaussianFilter, 5, ScanlineRayOrder},
+		{[]PipelineOption{WithPixelFilter(GaussianFilter), WithFirstHitCache()}, PointFilter, 0, MortonRayOrder},
+	}
+
+	for index, spec := range specs {
+		tr, res := newMockTracer(device.CpuDevice, nil)
+		tr.cameraLens = cameraLens{focusDistance: 5}
+
+		_, err := PerspectiveCamera(spec.opts...)(tr, testBlockRequest())
+		if err != nil {
+			t.Fatalf("[spec %d] %v", index, err)
+		}
+
+		calls := res.callsTo("GeneratePrimaryRays")
+		if len(calls) != 1 {
+			t.Fatalf("[spec %d] expected GeneratePrimaryRays to be called once; got %d calls", index, len(calls))
+		}
+		if lens := calls[0].Args[1].(cameraLens); lens.focusDistance != spec.expFocus {
+			t.Errorf("[spec %d] expected lens focus distance to be %f; got %f", index, spec.expFocus, lens.focusDistance)
+		}
+		if filter := calls[0].Args[2].(PixelFilter); filter != spec.expFilter {
+			t.Errorf("[spec %d] expected pixel filter to be %s; got %s", index, spec.expFilter, filter)
+		}
+		if order := calls[0].Args[3].(RayOrder); order != spec.expOrder {
+			t.Errorf("[spec %d] expected ray order to be %s; got %s", index, spec.expOrder, order)
+		}
+	}
+}
+
+func TestThinLensCameraStage(t *testing.T) {
+	specs := []struct {
+		opts   []PipelineOption
+		lens   cameraLens
+		expErr string
+	}{
+		{[]PipelineOption{WithPixelFilter(BoxFilter)}, cameraLens{focusDistance: 5, blades: 6}, ""},
+		{nil, cameraLens{}, ErrNoCameraAperture.Error()},
+		{[]PipelineOption{WithFirstHitCache()}, cameraLens{focusDistance: 5}, ErrInvalidOption.Error()},
+	}
+
+	for index, spec := range specs {
+		tr, res := newMockTracer(device.CpuDevice, nil)
+		tr.cameraLens = spec.lens
+
+		_, err := ThinLensCamera(spec.opts...)(tr, testBlockRequest())
+		if spec.expErr != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), spec.expErr) {
+				t.Errorf("[spec %d] expected error %q; got %v", index, spec.expErr, err)
+			}
+			if calls := res.callsTo("GeneratePrimaryRays"); len(calls) != 0 {
+				t.Errorf("[spec %d] expected GeneratePrimaryRays not to be called; got %d calls", index, len(calls))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("[spec %d] %v", index, err)
+		}
+
+		calls := res.callsTo("GeneratePrimaryRays")
+		if len(calls) != 1 {
+			t.Fatalf("[spec %d] expected GeneratePrimaryRays to be called once; got %d calls", index, len(calls))
+		}
+		if lens := calls[0].Args[1].(cameraLens); lens.focusDistance != 5 || lens.blades != 6 {
+			t.Errorf("[spec %d] expected the camera lens to be passed through; got %+v", index, lens)
+		}
+		if filter := calls[0].Args[2].(PixelFilter); filter != BoxFilter {
+			t.Errorf("[spec %d] expected pixel filter to be %s; got %s", index, BoxFilter, filter)
+		}
+	}
+}
+
+func TestMonteCarloIntegratorStageSequence(t *testing.T) {
+	specs := []struct {
+		devType    device.DeviceType
+		diffuseMat int32
+		exp        []string
+	}{
+		{
+			device.CpuDevice, -1,
+			[]string{
+				"RayIntersectionQuery", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+				"RayIntersectionQuery", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+			},
+		},
+		{
+			device.GpuDevice, 3,
+			[]string{
+				"RayPacketIntersectionQuery", "ShadePrimaryRayMisses", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+				"RayIntersectionQuery", "ShadeIndirectRayMisses", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+			},
+		},
+	}
+
+	for index, spec := range specs {
+		tr, res := newMockTracer(spec.devType, nil)
+		tr.sceneData.SceneDiffuseMatIndex = spec.diffuseMat
+
+		_, err := MonteCarloIntegrator()(tr, testBlockRequest())
+		if err != nil {
+			t.Fatalf("[spec %d] %v", index, err)
+		}
+
+		if got := res.methods(); !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected method calls:\n%v\ngot:\n%v", index, spec.exp, got)
+		}
+	}
+}
+
+func TestConeShadows(t *testing.T) {
+	for _, integrator := range []PipelineOption{WithLightPathLength(0), WithDirectLighting()} {
+		tr, res := newMockTracer(device.CpuDevice, nil)
+		_, err := DefaultPipeline(integrator, WithShadowMode(ConeShadows)).Integrator(tr, testBlockRequest())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if calls := res.callsTo("RayIntersectionTest"); len(calls) != 0 {
+			t.Errorf("expected occlusion rays not to be traced; got %d RayIntersectionTest calls", len(calls))
+		}
+		for _, call := range res.callsTo("ConeOcclusionTest") {
+			if exp := []interface{}{uint32(2), 8}; !reflect.DeepEqual(call.Args, exp) {
+				t.Errorf("expected ConeOcclusionTest args to be %v; got %v", exp, call.Args)
+			}
+		}
+		if got, exp := len(res.callsTo("ConeOcclusionTest")), len(res.callsTo("AccumulateEmissiveSamples")); got == 0 || got != exp {
+			t.Errorf("expected a ConeOcclusionTest call before each of the %d AccumulateEmissiveSamples calls; got %d", exp, got)
+		}
+	}
+}
+
+func TestMonteCarloIntegratorStageEnvMap(t *testing.T) {
+	tr, res := newMockTracer(device.GpuDevice, nil)
+	tr.envMap = &scene.EnvMapDistribution{MaterialNodeIndex: 7, Width: 2, Height: 1}
+
+	_, err := MonteCarloIntegrator()(tr, testBlockRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Misses should sample the env map even if the scene does not define a
+	// background color
+	primary := res.callsTo("ShadePrimaryRayMisses")
+	if len(primary) != 1 || primary[0].Args[2] != int32(7) {
+		t.Fatalf("expected primary ray misses to be shaded using env material 7; got %v", primary)
+	}
+	indirect := res.callsTo("ShadeIndirectRayMisses")
+	if len(indirect) != 1 || indirect[0].Args[1] != int32(7) {
+		t.Fatalf("expected indirect ray misses to be shaded using env material 7; got %v", indirect)
+	}
+}
+
+func TestMonteCarloIntegratorStageMedia(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.hasMedia = true
+	tr.sceneData.SceneMediumMatIndex = 5
+	tr.sceneData.EmissivePrimitives = make([]scene.EmissivePrimitive, 3)
+
+	_, err := MonteCarloIntegrator()(tr, testBlockRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Medium interactions are sampled after each intersection query and
+	// before hits get shaded
+	exp := []string{
+		"RayIntersectionQuery", "SampleMediumInteractions", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+		"RayIntersectionQuery", "SampleMediumInteractions", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+	}
+	if got := res.methods(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected method calls:\n%v\ngot:\n%v", exp, got)
+	}
+
+	for bounce, call := range res.callsTo("SampleMediumInteractions") {
+		exp := []interface{}{int32(5), uint32(bounce), uint32(3), SampleClamp{}, uint32(bounce), 8, pathSampler{}}
+		if !reflect.DeepEqual(call.Args, exp) {
+			t.Errorf("expected SampleMediumInteractions args for bounce %d to be %v; got %v", bounce, exp, call.Args)
+		}
+	}
+}
+
+func TestPathSamplerArgs(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.samplerSeed = 7
+	tr.tracedSamples = 3
+	blockReq := testBlockRequest()
+	blockReq.FirstSample = 8
+
+	pipeline := DefaultPipeline(WithSampler(HaltonSampler))
+	if _, err := pipeline.PrimaryRayGenerator(tr, blockReq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pipeline.Integrator(tr, blockReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// The third traced sample of the request continues the sample
+	// sequence of each pixel after the first 8 samples
+	exp := pathSampler{sampler: HaltonSampler, seed: 7, index: 10}
+	for _, method := range []string{"GeneratePrimaryRays", "ShadeHits"} {
+		for _, call := range res.callsTo(method) {
+			if got := call.Args[len(call.Args)-1]; got != exp {
+				t.Errorf("expected %s sampler to be %+v; got %+v", method, exp, got)
+			}
+		}
+	}
+}
+
+func TestMonteCarloIntegratorStageArgs(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.sceneData.EmissivePrimitives = make([]scene.EmissivePrimitive, 3)
+
+	clamp := SampleClamp{Direct: 2, Indirect: 1}
+	_, err := MonteCarloIntegrator(WithSampleClamp(clamp), WithNormalCorrection(FlipNormalCorrection))(tr, testBlockRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Primary rays skip camera-invisible instances and each bounce swaps
+	// the active ray buffer
+	queries := res.callsTo("RayIntersectionQuery")
+	expQueries := [][]interface{}{
+		{uint32(0), scene.CameraInvisible, 8},
+		{uint32(1), scene.MeshInstanceFlag(0), 8},
+	}
+	for index, call := range queries {
+		if !reflect.DeepEqual(call.Args, expQueries[index]) {
+			t.Errorf("expected intersection query %d args to be %v; got %v", index, expQueries[index], call.Args)
+		}
+	}
+
+	shadeCalls := res.callsTo("ShadeHits")
+	for bounce, call := range shadeCalls {
+		exp := []interface{}{uint32(bounce), uint32(1), uint32(3), FlipNormalCorrection, RayDifferentialTextureFilter, clamp, uint32(0), uint32(bounce), 8, pathSampler{}}
+		if !reflect.DeepEqual(call.Args, exp) {
+			t.Errorf("expected ShadeHits args for bounce %d to be %v; got %v", bounce, exp, call.Args)
+		}
+	}
+
+	// Occlusion rays are always stored in the third ray buffer
+	for _, call := range res.callsTo("AccumulateEmissiveSamples") {
+		if call.Args[0] != uint32(2) {
+			t.Errorf("expected emissive samples to be accumulated from ray buffer 2; got %v", call.Args[0])
+		}
+	}
+}
+
+func TestBidirectionalIntegratorStage(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.sceneData.EmissivePrimitives = make([]scene.EmissivePrimitive, 3)
+	blockReq := testBlockRequest()
+	blockReq.NumBounces = 1
+
+	_, err := BidirectionalIntegrator(WithLightPathLength(2))(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Light subpaths are traced before the primary ray intersections and
+	// each eye path vertex is connected to all light subpath vertices
+	// before being shaded.
+	res.assertMethods(t,
+		"GenerateLightRays",
+		"LightRayIntersectionQuery", "ShadeLightHits",
+		"LightRayIntersectionQuery", "ShadeLightHits",
+		"RayIntersectionQuery", "ConnectLightVertex", "ConnectLightVertex",
+		"ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+	)
+
+	lightQueries := res.callsTo("LightRayIntersectionQuery")
+	for depth, call := range res.callsTo("ShadeLightHits") {
+		exp := []interface{}{uint32(depth), uint32(1), uint32(2), NoNormalCorrection, uint32(depth), 8}
+		if !reflect.DeepEqual(call.Args, exp) {
+			t.Errorf("expected ShadeLightHits args for depth %d to be %v; got %v", depth, exp, call.Args)
+		}
+		if lightQueries[depth].Args[0] != uint32(depth) {
+			t.Errorf("expected light ray query for depth %d to use ray buffer %d; got %v", depth, depth, lightQueries[depth].Args[0])
+		}
+	}
+	for lightDepth, call := range res.callsTo("ConnectLightVertex") {
+		if call.Args[1] != uint32(lightDepth) || call.Args[2] != uint32(2) {
+			t.Errorf("expected connection %d to use light vertex %d of 2; got %v", lightDepth, lightDepth, call.Args)
+		}
+	}
+	if call := res.callsTo("ShadeHits")[0]; call.Args[6] != uint32(2) {
+		t.Errorf("expected ShadeHits to weight samples for 2 light vertices; got %v", call.Args[6])
+	}
+
+	// Light subpaths are not traced for scenes without emissives
+	tr, res = newMockTracer(device.CpuDevice, nil)
+	_, err = BidirectionalIntegrator()(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.assertMethods(t, "RayIntersectionQuery", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples")
+
+	// Default and clamped light subpath lengths
+	specs := []struct {
+		opts []PipelineOption
+		exp  uint32
+	}{
+		{nil, DefaultLightPathLength},
+		{[]PipelineOption{WithLightPathLength(100)}, MaxLightPathLength},
+	}
+	for index, spec := range specs {
+		tr, res = newMockTracer(device.CpuDevice, nil)
+		tr.sceneData.EmissivePrimitives = make([]scene.EmissivePrimitive, 1)
+		_, err = BidirectionalIntegrator(spec.opts...)(tr, blockReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.callsTo("GenerateLightRays")[0].Args[1]; got != spec.exp {
+			t.Errorf("[spec %d] expected light subpaths with %d vertices; got %v", index, spec.exp, got)
+		}
+	}
+}
+
+func TestDirectLightingStage(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.sceneData.SceneDiffuseMatIndex = 0
+	tr.sceneData.EmissivePrimitives = make([]scene.EmissivePrimitive, 2)
+	blockReq := testBlockRequest()
+	blockReq.NumBounces = 5
+
+	clamp := SampleClamp{Direct: 4}
+	_, err := DefaultPipeline(WithDirectLighting(), WithSampleClamp(clamp), WithLightPathLength(2)).Integrator(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary hits are shaded once and the bxdf samples only collect
+	// emission regardless of the number of bounces.
+	res.assertMethods(t,
+		"RayIntersectionQuery", "ShadePrimaryRayMisses", "ShadeHits",
+		"RayIntersectionTest", "AccumulateEmissiveSamples",
+		"RayIntersectionQuery", "ShadeIndirectRayMisses", "ShadeEmissiveHits",
+	)
+
+	exp := []interface{}{uint32(0), uint32(1), uint32(2), NoNormalCorrection, RayDifferentialTextureFilter, clamp, uint32(0), uint32(0), 8, pathSampler{}}
+	if call := res.callsTo("ShadeHits")[0]; !reflect.DeepEqual(call.Args, exp) {
+		t.Errorf("expected ShadeHits args to be %v; got %v", exp, call.Args)
+	}
+	exp = []interface{}{uint32(1), clamp, uint32(1), 8}
+	if call := res.callsTo("ShadeEmissiveHits")[0]; !reflect.DeepEqual(call.Args, exp) {
+		t.Errorf("expected ShadeEmissiveHits args to be %v; got %v", exp, call.Args)
+	}
+	if query := res.callsTo("RayIntersectionQuery")[1]; query.Args[0] != uint32(1) {
+		t.Errorf("expected bxdf samples to be traced using ray buffer 1; got %v", query.Args)
+	}
+}
+
+func TestAmbientOcclusionStage(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	blockReq := testBlockRequest()
+
+	_, err := AmbientOcclusion(2.5, WithNormalCorrection(ClampNormalCorrection))(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.assertMethods(t, "RayIntersectionQuery", "ShadeAmbientOcclusion", "RayIntersectionTest", "AccumulateEmissiveSamples")
+
+	exp := []interface{}{float32(2.5), ClampNormalCorrection, uint32(0), 8, pathSampler{}}
+	if call := res.callsTo("ShadeAmbientOcclusion")[0]; !reflect.DeepEqual(call.Args, exp) {
+		t.Errorf("expected ShadeAmbientOcclusion args to be %v; got %v", exp, call.Args)
+	}
+
+	// Occlusion rays are not limited if no max distance is specified
+	tr, res = newMockTracer(device.CpuDevice, nil)
+	if _, err = DefaultPipeline(WithAmbientOcclusion(0), WithLightPathLength(2)).Integrator(tr, blockReq); err != nil {
+		t.Fatal(err)
+	}
+	if got := res.callsTo("ShadeAmbientOcclusion")[0].Args[0]; got != float32(math.MaxFloat32) {
+		t.Errorf("expected unlimited occlusion ray distance; got %v", got)
+	}
+}
+
+func TestMonteCarloIntegratorStageTextureStreaming(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	tr.textures = &textureResidency{}
+
+	_, err := MonteCarloIntegrator(WithTextureFilter(TopMipTextureFilter))(tr, testBlockRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Texture feedback is only recorded for primary ray hits
+	exp := []string{
+		"RayIntersectionQuery", "RecordTextureFeedback", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+		"RayIntersectionQuery", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples",
+	}
+	if got := res.methods(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected method calls:\n%v\ngot:\n%v", exp, got)
+	}
+
+	call := res.callsTo("RecordTextureFeedback")[0]
+	if expArgs := []interface{}{TopMipTextureFilter, uint32(0), 8}; !reflect.DeepEqual(call.Args, expArgs) {
+		t.Fatalf("expected RecordTextureFeedback args to be %v; got %v", expArgs, call.Args)
+	}
+}
+
+func TestMonteCarloIntegratorStageFirstHitCache(t *testing.T) {
+	tr, res := newMockTracer(device.GpuDevice, nil)
+	blockReq := testBlockRequest()
+	blockReq.NumBounces = 1
+	stage := MonteCarloIntegrator(WithFirstHitCache())
+
+	_, err := stage(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.assertMethods(t, "RayPacketIntersectionQuery", "StorePrimaryHits", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples")
+
+	res.calls = nil
+	_, err = stage(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.assertMethods(t, "RestorePrimaryHits", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples")
+}
+
+func TestMonteCarloIntegratorStageErrors(t *testing.T) {
+	tr, _ := newMockTracer(device.CpuDevice, nil)
+	tr.sceneData = nil
+
+	_, err := MonteCarloIntegrator()(tr, testBlockRequest())
+	if tracer.ErrorKind(err) != tracer.ErrSceneInvalid {
+		t.Fatalf("expected to get an ErrSceneInvalid error when no scene data is uploaded; got %v", err)
+	}
+
+	expErr := errors.New("kernel failed")
+	for _, method := range []string{"RayIntersectionQuery", "ShadeHits", "RayIntersectionTest", "AccumulateEmissiveSamples"} {
+		tr, res := newMockTracer(device.CpuDevice, nil)
+		res.errors[method] = expErr
+
+		_, err = MonteCarloIntegrator()(tr, testBlockRequest())
+		if err != expErr {
+			t.Errorf("expected %s error to be returned by the stage; got %v", method, err)
+			continue
+		}
+
+		methods := res.methods()
+		if last := methods[len(methods)-1]; last != method {
+			t.Errorf("expected stage to abort after the %s error; last call was %s", method, last)
+		}
+	}
+}
+
+func TestMonteCarloIntegratorStageCancellation(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tr.traceCtx = ctx
+
+	_, err := MonteCarloIntegrator()(tr, testBlockRequest())
+	if err != context.Canceled {
+		t.Fatalf("expected to get context.Canceled; got %v", err)
+	}
+	if calls := res.callsTo("ShadeHits"); len(calls) != 0 {
+		t.Fatalf("expected no bounces to be shaded after the context was cancelled; got %d ShadeHits calls", len(calls))
+	}
+}
+
+func TestMonteCarloIntegratorStageDebugFlags(t *testing.T) {
+	sink := &mockDebugSink{}
+	tr, res := newMockTracer(device.CpuDevice, &Pipeline{DebugSink: sink})
+
+	_, err := MonteCarloIntegrator(WithDebugFlags(PrimaryRayIntersectionDepth|Throughput))(tr, testBlockRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expImages := []string{"primary-intersection-depth", "throughput-000", "throughput-001"}
+	if !reflect.DeepEqual(sink.images, expImages) {
+		t.Fatalf("expected debug images %v; got %v", expImages, sink.images)
+	}
+	if calls := res.callsTo("DebugRayIntersectionNormals"); len(calls) != 0 {
+		t.Fatal("expected debug kernels for disabled flags not to be invoked")
+	}
+	if calls := res.callsTo("ReadDebugValues"); len(calls) != 3 {
+		t.Fatalf("expected the depth and throughput images to be generated from the debug values; got %d reads", len(calls))
+	}
+
+	// Debug kernel errors abort the stage without reading the debug buffer
+	sink = &mockDebugSink{}
+	tr, res = newMockTracer(device.CpuDevice, &Pipeline{DebugSink: sink})
+	expErr := errors.New("kernel failed")
+	res.errors["DebugThroughput"] = expErr
+
+	_, err = MonteCarloIntegrator(WithDebugFlags(PrimaryRayIntersectionDepth|Throughput))(tr, testBlockRequest())
+	if err != expErr {
+		t.Fatalf("expected debug kernel error to be returned by the stage; got %v", err)
+	}
+	if expImages = expImages[:1]; !reflect.DeepEqual(sink.images, expImages) {
+		t.Fatalf("expected debug images %v; got %v", expImages, sink.images)
+	}
+	if calls := res.callsTo("ReadDebugValues"); len(calls) != 1 {
+		t.Fatalf("expected the debug values to be read once; got %d reads", len(calls))
+	}
+}
+
+func TestHostPostProcessStage(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	blockReq := testBlockRequest()
+	blockReq.FrameW, blockReq.FrameH = 2, 1
+	res.radiance = []float32{1, 2, 3, 4, 5, 6}
+
+	stage := HostPostProcess(func(radiance []float32, frameW, frameH uint32) error {
+		for index := range radiance {
+			radiance[index] *= 2
+		}
+		return nil
+	})
+
+	_, err := stage(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.assertMethods(t, "ReadRadiance", "WritePostRadiance")
+	exp := []float32{2, 4, 6, 8, 10, 12}
+	if got := res.calls[1].Args[0]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected processed radiance %v to be uploaded; got %v", exp, got)
+	}
+
+	// Callback errors abort the stage before uploading the radiance
+	res.calls = nil
+	expErr := errors.New("callback failed")
+	_, err = HostPostProcess(func([]float32, uint32, uint32) error { return expErr })(tr, blockReq)
+	if err != expErr {
+		t.Fatalf("expected callback error to be returned by the stage; got %v", err)
+	}
+	res.assertMethods(t, "ReadRadiance")
+}
+
+func TestRayGeneratorCameraStage(t *testing.T) {
+	tr, res := newMockTracer(device.CpuDevice, nil)
+	blockReq := testBlockRequest()
+
+	var numRays int
+	_, err := RayGeneratorCamera(func(_ *tracer.BlockRequest, rays []CameraRay) error {
+		numRays = len(rays)
+		return nil
+	})(tr, blockReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if numRays != 8 {
+		t.Fatalf("expected callback to receive 8 rays; got %d", numRays)
+	}
+	res.assertMethods(t, "WriteBlockCameraRays", "GenerateCustomRays")
+	if offset := res.calls[1].Args[0]; offset != uint32(0) {
+		t.Fatalf("expected block rays to be read from offset 0; got %v", offset)
+	}
+}
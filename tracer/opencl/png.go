@@ -0,0 +1,50 @@
+package opencl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"math"
+)
+
+// The length of the PNG signature and the IHDR chunk that always follows it.
+const pngHeaderLen = 8 + 4 + 4 + 13 + 4
+
+// Save im as a PNG file optionally embedding the resolution metadata.
+func writePNGWithDPI(imgFile string, im image.Image, dpi float32) error {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, im)
+	if err != nil {
+		return err
+	}
+
+	data := buf.Bytes()
+	if dpi > 0 {
+		data = insertPNGResolution(data, dpi)
+	}
+
+	return ioutil.WriteFile(imgFile, data, 0644)
+}
+
+// Insert a pHYs chunk with the supplied resolution in dots per inch right
+// after the IHDR chunk of an encoded PNG image.
+func insertPNGResolution(data []byte, dpi float32) []byte {
+	// The pHYs chunk stores the resolution in pixels per meter
+	ppm := uint32(math.Floor(float64(dpi)/0.0254 + 0.5))
+
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // unit is the meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:pngHeaderLen]...)
+	out = append(out, chunk...)
+	return append(out, data[pngHeaderLen:]...)
+}
@@ -0,0 +1,58 @@
+package opencl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePNGWithDPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "polaris-png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgFile := filepath.Join(dir, "frame.png")
+	err = writePNGWithDPI(imgFile, image.NewRGBA64(image.Rect(0, 0, 4, 2)), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(imgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pHYs chunk should immediately follow the IHDR chunk
+	chunk := data[pngHeaderLen:]
+	if string(chunk[4:8]) != "pHYs" || binary.BigEndian.Uint32(chunk[0:]) != 9 {
+		t.Fatalf("expected a pHYs chunk after the IHDR chunk; got %q", chunk[4:8])
+	}
+	if ppm := binary.BigEndian.Uint32(chunk[8:]); ppm != 11811 || binary.BigEndian.Uint32(chunk[12:]) != ppm || chunk[16] != 1 {
+		t.Fatalf("expected resolution to be 11811 pixels per meter; got %d", ppm)
+	}
+	if crc := binary.BigEndian.Uint32(chunk[17:]); crc != crc32.ChecksumIEEE(chunk[4:17]) {
+		t.Fatalf("pHYs chunk CRC mismatch")
+	}
+
+	// The file should still be a valid 16-bit PNG
+	im, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch im.(type) {
+	case *image.RGBA64, *image.NRGBA64:
+	default:
+		t.Fatalf("expected decoded image to be a 16-bit image; got %T", im)
+	}
+	if im.Bounds().Dx() != 4 || im.Bounds().Dy() != 2 {
+		t.Fatalf("expected decoded image dims to be 4x2; got %v", im.Bounds())
+	}
+}
@@ -0,0 +1,41 @@
+package opencl
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/tracer"
+)
+
+func TestPackPostRadiance(t *testing.T) {
+	radiance := []float32{1, 2, 3, 0.5, 0.25, 0}
+
+	// 4 accumulated samples per pixel
+	blockReq := &tracer.BlockRequest{FrameW: 2, FrameH: 1, SamplesPerPixel: 4}
+	out := make([]float32, 8)
+	for index := range out {
+		out[index] = -1
+	}
+	packPostRadiance(out, radiance, blockReq.SampleWeight())
+
+	exp := []float32{4, 8, 12, 0, 2, 1, 0, 0}
+	if !reflect.DeepEqual(out, exp) {
+		t.Fatalf("expected packed samples to be %v; got %v", exp, out)
+	}
+
+	// The kernels normalize the packed samples back to the processed radiance
+	for pixel := 0; pixel < 2; pixel++ {
+		for ch := 0; ch < 3; ch++ {
+			if got := out[pixel*4+ch] * blockReq.SampleWeight(); got != radiance[pixel*3+ch] {
+				t.Errorf("[pixel %d] expected normalized channel %d to be %f; got %f", pixel, ch, radiance[pixel*3+ch], got)
+			}
+		}
+	}
+
+	// Frames without accumulated samples are uploaded as is
+	packPostRadiance(out, radiance, 0)
+	exp = []float32{1, 2, 3, 0, 0.5, 0.25, 0, 0}
+	if !reflect.DeepEqual(out, exp) {
+		t.Fatalf("expected packed samples to be %v; got %v", exp, out)
+	}
+}
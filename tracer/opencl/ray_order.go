@@ -0,0 +1,53 @@
+package opencl
+
+// Generate the block-relative pixel index for each primary ray of a block
+// with the given dimensions. The returned slice contains width * height
+// entries and the ray at index i samples pixel (p % width, p / width) of the
+// block where p is the value of entry i.
+func rayOrderPixels(order RayOrder, width, height uint32) []uint32 {
+	pixels := make([]uint32, 0, width*height)
+
+	switch order {
+	case MortonRayOrder:
+		side := uint32(1)
+		for side < width || side < height {
+			side <<= 1
+		}
+		pixels = appendMortonPixels(pixels, 0, 0, side, width, height)
+	case TiledRayOrder:
+		for tileY := uint32(0); tileY < height; tileY += RayOrderTileSize {
+			for tileX := uint32(0); tileX < width; tileX += RayOrderTileSize {
+				for y := tileY; y < tileY+RayOrderTileSize && y < height; y++ {
+					for x := tileX; x < tileX+RayOrderTileSize && x < width; x++ {
+						pixels = append(pixels, y*width+x)
+					}
+				}
+			}
+		}
+	default:
+		for pixel := uint32(0); pixel < width*height; pixel++ {
+			pixels = append(pixels, pixel)
+		}
+	}
+
+	return pixels
+}
+
+// Append the pixels of the square region with the given origin and
+// power-of-two side to the list in Morton order. Quadrants that lie outside
+// the block are skipped so blocks with arbitrary dimensions do not need to be
+// padded.
+func appendMortonPixels(pixels []uint32, x, y, side, width, height uint32) []uint32 {
+	if x >= width || y >= height {
+		return pixels
+	}
+	if side == 1 {
+		return append(pixels, y*width+x)
+	}
+
+	half := side >> 1
+	pixels = appendMortonPixels(pixels, x, y, half, width, height)
+	pixels = appendMortonPixels(pixels, x+half, y, half, width, height)
+	pixels = appendMortonPixels(pixels, x, y+half, half, width, height)
+	return appendMortonPixels(pixels, x+half, y+half, half, width, height)
+}
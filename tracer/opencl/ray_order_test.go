@@ -0,0 +1,78 @@
+package opencl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRayOrder(t *testing.T) {
+	specs := []struct {
+		name   string
+		exp    RayOrder
+		expErr bool
+	}{
+		{"morton", MortonRayOrder, false},
+		{"Tiled", TiledRayOrder, false},
+		{"SCANLINE", ScanlineRayOrder, false},
+		{"hilbert", MortonRayOrder, true},
+	}
+
+	for index, spec := range specs {
+		order, err := ParseRayOrder(spec.name)
+		if spec.expErr {
+			if err == nil {
+				t.Errorf("[spec %d] expected an error", index)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", index, err)
+			continue
+		}
+
+		if order != spec.exp {
+			t.Errorf("[spec %d] expected order %s; got %s", index, spec.exp, order)
+		}
+	}
+}
+
+func TestRayOrderPixels(t *testing.T) {
+	specs := []struct {
+		order RayOrder
+		w, h  uint32
+		exp   []uint32
+	}{
+		{ScanlineRayOrder, 3, 2, []uint32{0, 1, 2, 3, 4, 5}},
+		{MortonRayOrder, 4, 4, []uint32{0, 1, 4, 5, 2, 3, 6, 7, 8, 9, 12, 13, 10, 11, 14, 15}},
+		// Quadrants outside the block are skipped
+		{MortonRayOrder, 3, 2, []uint32{0, 1, 3, 4, 2, 5}},
+		// The first tile is 8 pixels wide and the second one is 2 pixels wide
+		{TiledRayOrder, 10, 2, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 10, 11, 12, 13, 14, 15, 16, 17, 8, 9, 18, 19}},
+		{MortonRayOrder, 13, 7, nil},
+		{TiledRayOrder, 13, 7, nil},
+		{MortonRayOrder, 1, 0, nil},
+	}
+
+	for index, spec := range specs {
+		pixels := rayOrderPixels(spec.order, spec.w, spec.h)
+		if spec.exp != nil && !reflect.DeepEqual(pixels, spec.exp) {
+			t.Errorf("[spec %d] expected %s pixels to be %v; got %v", index, spec.order, spec.exp, pixels)
+			continue
+		}
+
+		// Each block pixel must be assigned to exactly one ray
+		if len(pixels) != int(spec.w*spec.h) {
+			t.Errorf("[spec %d] expected %d pixels; got %d", index, spec.w*spec.h, len(pixels))
+			continue
+		}
+		seen := make([]bool, len(pixels))
+		for _, pixel := range pixels {
+			if int(pixel) >= len(seen) || seen[pixel] {
+				t.Errorf("[spec %d] pixel %d is out of range or assigned to multiple rays", index, pixel)
+				break
+			}
+			seen[pixel] = true
+		}
+	}
+}
@@ -2,9 +2,10 @@ package opencl
 
 import (
 	"fmt"
-	"math"
 	"time"
 
+	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/tracer"
 	"github.com/achilleasa/polaris/tracer/opencl/device"
 	"github.com/achilleasa/polaris/types"
@@ -12,6 +13,11 @@ import (
 
 const (
 	relativePathToMainKernel = "CL/main.cl"
+
+	// The max width of the environment light importance sampling
+	// distribution. Wider env maps are sampled using a distribution with
+	// a reduced resolution.
+	maxEnvMapDistributionWidth = 2048
 )
 
 var (
@@ -25,6 +31,72 @@ type deviceResources struct {
 
 	// The set of kernels.
 	kernels []*device.Kernel
+
+	// Scratch buffers for reading back the frame accumulator and the frame buffer.
+	radianceScratch []float32
+	frameScratch    []byte
+
+	// If set, the sample statistics buffers are allocated when resizing.
+	collectSampleStats bool
+
+	// If set, the frame accumulator compensation buffer is allocated when
+	// resizing and accumulators are aggregated using Kahan summation.
+	compensatedAccumulation bool
+
+	// The light path expressions evaluated by the kernels and their
+	// concatenated transition tables.
+	lightPathExpressions []*LightPathExpression
+	lpeTransitions       []uint8
+
+	// If set, the light reaching the camera from each light group of the
+	// scene is accumulated to a separate pass after the light path
+	// expression passes.
+	collectLightGroups bool
+
+	// The number of light groups of the uploaded scene, the light group
+	// pass mask table and the intensity multipliers used for mixing the
+	// light group passes; referenced here as the device buffers use them
+	// for storage.
+	numLightGroups   int
+	lightGroupMasks  []uint32
+	lightGroupScales []float32
+
+	// The frame dimensions of the last buffer resize.
+	frameDims [2]uint32
+
+	// Set when the post accumulator holds the output of a host
+	// post-processing stage for the current frame.
+	postProcessed bool
+
+	// The camera ray list that is currently uploaded to the camera ray
+	// buffer and a scratch buffer for rays produced by camera callbacks.
+	cameraRays       []CameraRay
+	cameraRayScratch []CameraRay
+
+	// The pixel index for each primary ray and the order and block
+	// dimensions used for generating them; referenced here as the device
+	// buffer uses them for storage.
+	rayPixels      []uint32
+	rayPixelsOrder RayOrder
+	rayPixelsDims  [2]uint32
+
+	// The block whose primary ray intersections are stored in the
+	// first-hit cache and a flag indicating whether the cache is valid.
+	primaryHitsBlock tracer.BlockRequest
+	primaryHitsValid bool
+
+	// The dimensions of the uploaded environment light distribution or
+	// zero if the env light uses cosine-weighted sampling.
+	envMapDims [2]uint32
+
+	// The per-vertex local occlusion and the inputs used for estimating
+	// it; referenced here as the device buffers use them for storage.
+	vertexOcclusion      []float32
+	localOcclusionInputs *scene.LocalOcclusionTargets
+
+	// The per-vertex tangents; referenced here as the device buffer uses
+	// them for storage.
+	vertexTangents []types.Vec4
 }
 
 // Using the supplied device as a target, load and compile all defined kernels.
@@ -55,9 +127,127 @@ func newDeviceResources(dev *device.Device) (*deviceResources, error) {
 	return dr, nil
 }
 
-// Resize buffers to fit frame size.
+// Upload the importance sampling distribution for the scene environment light.
+// If dist is nil, the kernels use cosine-weighted sampling for the env light.
+func (dr *deviceResources) UploadEnvMapDistribution(dist *scene.EnvMapDistribution) error {
+	var cdf []float32
+	dr.envMapDims = [2]uint32{}
+	if dist != nil {
+		cdf = dist.CDF
+		dr.envMapDims = [2]uint32{dist.Width, dist.Height}
+	}
+	return dr.buffers.UploadEnvMapDistribution(cdf)
+}
+
+// Upload the per-vertex tangents used for orienting normal and bump maps.
+func (dr *deviceResources) UploadVertexTangents(tangents []types.Vec4) error {
+	dr.vertexTangents = tangents
+	return dr.buffers.Tangents.AllocateAndWriteData(tangents, cl.MEM_READ_ONLY)
+}
+
+// Estimate the per-vertex local occlusion for the scene triangles whose
+// materials contain mixOcclusion nodes. If targets is nil, the occlusion of all
+// vertices is set to zero without running the bake kernel.
+func (dr *deviceResources) UpdateLocalOcclusion(targets *scene.LocalOcclusionTargets, numVertices int, randSeed uint32) (time.Duration, error) {
+	dr.vertexOcclusion = make([]float32, numVertices)
+	dr.localOcclusionInputs = targets
+	err := dr.buffers.UploadLocalOcclusion(dr.vertexOcclusion, targets)
+	if err != nil || targets == nil {
+		return 0, err
+	}
+
+	kernel := dr.kernels[bakeLocalOcclusion]
+	err = bakeLocalOcclusionArgs{
+		Vertices:           dr.buffers.Vertices,
+		Normals:            dr.buffers.Normals,
+		BvhNodes:           dr.buffers.BvhNodes,
+		TriBvhRoots:        dr.buffers.OcclusionBvhRoots,
+		TriOcclusionRadius: dr.buffers.OcclusionRadius,
+		NumVertices:        uint32(numVertices),
+		NumSamples:         scene.LocalOcclusionSamples,
+		RandSeed:           randSeed,
+		VertexOcclusion:    dr.buffers.VertexOcclusion,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numVertices, 0)
+}
+
+// Resize buffers to fit frame size. The blue-noise mask, which does not depend
+// on the frame size, is uploaded the first time that the buffers are resized.
 func (dr *deviceResources) ResizeBuffers(frameW, frameH uint32) error {
-	return dr.buffers.Resize(frameW, frameH)
+	dr.InvalidatePrimaryHits()
+	dr.ResetPostProcess()
+
+	err := dr.buffers.Resize(frameW, frameH)
+	if err != nil {
+		return err
+	}
+
+	if dr.buffers.BlueNoise.Size() == 0 {
+		err = dr.buffers.UploadBlueNoise(blueNoiseMask())
+		if err != nil {
+			return err
+		}
+	}
+
+	dr.frameDims = [2]uint32{frameW, frameH}
+	err = dr.buffers.ResizeLightPathAccumulators(frameW, frameH, dr.numLightPathPasses())
+	if err != nil {
+		return err
+	}
+
+	if dr.compensatedAccumulation {
+		err = dr.buffers.ResizeFrameCompensation(frameW, frameH)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !dr.collectSampleStats {
+		return nil
+	}
+
+	return dr.buffers.ResizeSampleStats(frameW, frameH)
+}
+
+// Set the light path expressions to be evaluated by the kernels and upload
+// their transition tables. The light path accumulators are resized the next
+// time that ResizeBuffers is invoked.
+func (dr *deviceResources) SetLightPathExpressions(exprs []*LightPathExpression) error {
+	if len(exprs) > MaxLightPathExpressions {
+		return ErrTooManyLightPathExpressions
+	}
+
+	dr.lightPathExpressions = exprs
+	dr.lpeTransitions = lightPathTransitions(exprs)
+	return dr.buffers.UploadLightPathTransitions(dr.lpeTransitions)
+}
+
+// Build and upload the light group pass masks for the material nodes of a
+// scene. If light groups are not collected, all masks are empty. As the number
+// of light groups depends on the scene, the light path accumulators are resized
+// if the frame dimensions are already known.
+func (dr *deviceResources) UploadLightGroups(sc *scene.Scene) error {
+	if dr.collectLightGroups {
+		dr.lightGroupMasks, dr.numLightGroups = lightGroupMasks(sc, len(dr.lightPathExpressions))
+	} else {
+		dr.lightGroupMasks, dr.numLightGroups = make([]uint32, len(sc.MaterialNodeList)+1), 0
+	}
+
+	err := dr.buffers.LightGroupMasks.AllocateAndWriteData(dr.lightGroupMasks, cl.MEM_READ_ONLY)
+	if err != nil || dr.frameDims[0] == 0 {
+		return err
+	}
+
+	return dr.buffers.ResizeLightPathAccumulators(dr.frameDims[0], dr.frameDims[1], dr.numLightPathPasses())
+}
+
+// Get the number of passes stored in the light path accumulators.
+func (dr *deviceResources) numLightPathPasses() int {
+	return len(dr.lightPathExpressions) + dr.numLightGroups
 }
 
 // Release all allocated resources.
@@ -77,45 +267,189 @@ func (dr *deviceResources) Close() {
 	}
 }
 
-// Clear the frame accumulator.
+// Clear the frame accumulator and the frame light path accumulator. If
+// compensated accumulation is enabled, the frame accumulator compensation is
+// also cleared.
 func (dr *deviceResources) ClearFrameAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	elapsed, err := dr.clearAccumulators(blockReq, dr.buffers.FrameAccumulator, dr.buffers.FrameLpeAccumulator)
+	if err != nil || !dr.compensatedAccumulation {
+		return elapsed, err
+	}
+
 	kernel := dr.kernels[clearAccumulator]
-	err := kernel.SetArgs(
-		dr.buffers.FrameAccumulator,
-	)
+	err = clearAccumulatorArgs{
+		Accumulator: dr.buffers.FrameCompensation,
+	}.bind(kernel)
 	if err != nil {
-		return 0, err
+		return elapsed, err
 	}
 
-	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+	compElapsed, err := kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+	return elapsed + compElapsed, err
 }
 
-// Clear the trace accumulator.
+// Clear the trace accumulator and the trace light path accumulator.
 func (dr *deviceResources) ClearTraceAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return dr.clearAccumulators(blockReq, dr.buffers.TraceAccumulator, dr.buffers.TraceLpeAccumulator)
+}
+
+// Clear an accumulator and, if any light path expressions or light groups are
+// defined, the matching light path accumulator.
+func (dr *deviceResources) clearAccumulators(blockReq *tracer.BlockRequest, accumulator, lpeAccumulator *device.Buffer) (time.Duration, error) {
 	kernel := dr.kernels[clearAccumulator]
-	err := kernel.SetArgs(
-		dr.buffers.TraceAccumulator,
-	)
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	err := clearAccumulatorArgs{
+		Accumulator: accumulator,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
 
-	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+	elapsed, err := kernel.Exec1D(0, numPixels, 0)
+	if err != nil || dr.numLightPathPasses() == 0 {
+		return elapsed, err
+	}
+
+	err = clearAccumulatorArgs{
+		Accumulator: lpeAccumulator,
+	}.bind(kernel)
+	if err != nil {
+		return elapsed, err
+	}
+
+	lpeElapsed, err := kernel.Exec1D(0, numPixels*dr.numLightPathPasses(), 0)
+	return elapsed + lpeElapsed, err
 }
 
 // Aggregate the trace accumulator contents from another tracer into
-// this tracer's frame accumulator.
+// this tracer's frame accumulator. If compensated accumulation is enabled,
+// the contents are added using Kahan summation.
 func (dr *deviceResources) AggregateAccumulator(srcAccumulator *device.Buffer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	var kernel *device.Kernel
+	var err error
+	if dr.compensatedAccumulation {
+		kernel = dr.kernels[aggregateAccumulatorCompensated]
+		err = aggregateAccumulatorCompensatedArgs{
+			SrcAccumulator: srcAccumulator,
+			DstAccumulator: dr.buffers.FrameAccumulator,
+			Compensation:   dr.buffers.FrameCompensation,
+		}.bind(kernel)
+	} else {
+		kernel = dr.kernels[aggregateAccumulator]
+		err = aggregateAccumulatorArgs{
+			SrcAccumulator: srcAccumulator,
+			DstAccumulator: dr.buffers.FrameAccumulator,
+		}.bind(kernel)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Add the contents of block specified by blockReq
+	return kernel.Exec1DNoWait(
+		int(blockReq.FrameW*blockReq.BlockY),
+		int(blockReq.BlockW*blockReq.BlockH),
+		0,
+	)
+}
+
+// Aggregate the trace light path accumulator contents from another tracer
+// into this tracer's frame light path accumulator.
+func (dr *deviceResources) AggregateLightPathAccumulator(srcAccumulator *device.Buffer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	var total time.Duration
 	kernel := dr.kernels[aggregateAccumulator]
-	err := kernel.SetArgs(
-		srcAccumulator,
-		dr.buffers.FrameAccumulator,
+	err := aggregateAccumulatorArgs{
+		SrcAccumulator: srcAccumulator,
+		DstAccumulator: dr.buffers.FrameLpeAccumulator,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	// Each pass stores a full frame so we need to add the block contents
+	// of each pass separately
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	for pass := 0; pass < dr.numLightPathPasses(); pass++ {
+		elapsed, err := kernel.Exec1DNoWait(
+			pass*numPixels+int(blockReq.FrameW*blockReq.BlockY),
+			int(blockReq.BlockW*blockReq.BlockH),
+			0,
+		)
+		total += elapsed
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// Clear the frame sample statistics.
+func (dr *deviceResources) ClearFrameSampleStats(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	kernel := dr.kernels[clearAccumulator]
+	err := clearAccumulatorArgs{
+		Accumulator: dr.buffers.FrameSampleStats,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+}
+
+// Clear the trace sample statistics and the sample snapshot.
+func (dr *deviceResources) ClearTraceSampleStats(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	var total time.Duration
+	kernel := dr.kernels[clearAccumulator]
+	for _, buf := range []*device.Buffer{dr.buffers.TraceSampleStats, dr.buffers.SampleSnapshot} {
+		err := clearAccumulatorArgs{
+			Accumulator: buf,
+		}.bind(kernel)
+		if err != nil {
+			return total, err
+		}
+
+		elapsed, err := kernel.Exec1D(0, int(blockReq.FrameW*blockReq.FrameH), 0)
+		total += elapsed
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// Update the trace sample statistics with the contribution of the last traced sample.
+func (dr *deviceResources) AccumulateSampleStats(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	kernel := dr.kernels[accumulateSampleStats]
+	err := accumulateSampleStatsArgs{
+		TraceAccumulator: dr.buffers.TraceAccumulator,
+		SampleSnapshot:   dr.buffers.SampleSnapshot,
+		SampleStats:      dr.buffers.TraceSampleStats,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(
+		int(blockReq.FrameW*blockReq.BlockY),
+		int(blockReq.FrameW*blockReq.BlockH),
+		0,
 	)
+}
+
+// Aggregate the trace sample statistics from another tracer into this
+// tracer's frame sample statistics.
+func (dr *deviceResources) AggregateSampleStats(srcStats *device.Buffer, blockReq *tracer.BlockRequest) (time.Duration, error) {
+	kernel := dr.kernels[aggregateAccumulator]
+	err := aggregateAccumulatorArgs{
+		SrcAccumulator: srcStats,
+		DstAccumulator: dr.buffers.FrameSampleStats,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
 
-	// Add the contents of block specified by blockReq
 	return kernel.Exec1DNoWait(
 		int(blockReq.FrameW*blockReq.BlockY),
 		int(blockReq.BlockW*blockReq.BlockH),
@@ -123,31 +457,102 @@ func (dr *deviceResources) AggregateAccumulator(srcAccumulator *device.Buffer, b
 	)
 }
 
-// Generate primary rays.
-func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum [4]types.Vec4) (time.Duration, error) {
+// Read the frame sample statistics. The output slice receives 3 float32
+// values per frame pixel: the luminance sum, the squared luminance sum and
+// the sample count.
+func (dr *deviceResources) ReadSampleStats(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	start := time.Now()
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	if len(out) < numPixels*3 {
+		return 0, ErrBufferTooSmall
+	}
+
+	// Statistics are stored as float3 values which occupy the same
+	// space as a float4 value.
+	if len(dr.radianceScratch) != numPixels*4 {
+		dr.radianceScratch = make([]float32, numPixels*4)
+	}
+	err := dr.buffers.FrameSampleStats.ReadData(0, 0, numPixels*sizeofAccumulatorSample, dr.radianceScratch)
+	if err != nil {
+		return 0, err
+	}
+
+	for pixel, rOffset, wOffset := 0, 0, 0; pixel < numPixels; pixel, rOffset, wOffset = pixel+1, rOffset+4, wOffset+3 {
+		copy(out[wOffset:wOffset+3], dr.radianceScratch[rOffset:rOffset+3])
+	}
+
+	return time.Since(start), nil
+}
+
+// Upload the pixel index for each primary ray of a block unless the same
+// ordering is already uploaded. As the first-hit cache stores the primary hits
+// of each ray, uploading a different ordering invalidates the cache.
+func (dr *deviceResources) uploadRayPixels(blockReq *tracer.BlockRequest, order RayOrder) error {
+	dims := [2]uint32{blockReq.FrameW, blockReq.BlockH}
+	if dr.rayPixels != nil && dr.rayPixelsOrder == order && dr.rayPixelsDims == dims {
+		return nil
+	}
+
+	pixels := rayOrderPixels(order, dims[0], dims[1])
+	err := dr.buffers.UploadRayPixels(pixels)
+	if err != nil {
+		return err
+	}
+
+	dr.rayPixels = pixels
+	dr.rayPixelsOrder = order
+	dr.rayPixelsDims = dims
+	dr.primaryHitsValid = false
+	return nil
+}
+
+// Generate primary rays. The rayOrder argument selects the order in which
+// rays are assigned to the block pixels while the sampler argument selects
+// how the pixel offsets and lens positions are sampled.
+func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum [4]types.Vec4, lens cameraLens, pixelFilter PixelFilter, rayOrder RayOrder, sampler pathSampler) (time.Duration, error) {
 	kernel := dr.kernels[generatePrimaryRays]
 
+	err := dr.uploadRayPixels(blockReq, rayOrder)
+	if err != nil {
+		return 0, err
+	}
+
 	texelDims := types.Vec2{
 		1.0 / float32(blockReq.FrameW),
 		1.0 / float32(blockReq.FrameH),
 	}
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[0],
-		dr.buffers.RayCounters[0],
-		dr.buffers.Paths,
-		cameraFrustrum[0],
-		cameraFrustrum[1],
-		cameraFrustrum[2],
-		cameraFrustrum[3],
-		cameraEyePos,
-		texelDims,
-		blockReq.BlockY,
-		blockReq.BlockH,
-		blockReq.FrameW,
-		blockReq.FrameH,
-		blockReq.Seed,
-	)
+	err = generatePrimaryRaysArgs{
+		Rays:             dr.buffers.Rays[0],
+		NumRays:          dr.buffers.RayCounters[0],
+		Paths:            dr.buffers.Paths,
+		FrustrumTL:       cameraFrustrum[0],
+		FrustrumTR:       cameraFrustrum[1],
+		FrustrumBL:       cameraFrustrum[2],
+		FrustrumBR:       cameraFrustrum[3],
+		EyePos:           cameraEyePos,
+		TexelDims:        texelDims,
+		BlockY:           blockReq.BlockY,
+		BlockH:           blockReq.BlockH,
+		FrameW:           blockReq.FrameW,
+		FrameH:           blockReq.FrameH,
+		RandSeed:         blockReq.Seed,
+		SamplerType:      uint32(sampler.sampler),
+		SamplerSeed:      sampler.seed,
+		SampleIndex:      sampler.index,
+		BlueNoise:        dr.buffers.BlueNoise,
+		PixelFilter:      uint32(pixelFilter),
+		LensRight:        lens.right,
+		LensUp:           lens.up,
+		FocusNormal:      lens.focusNormal,
+		FocusDistance:    lens.focusDistance,
+		ApertureBlades:   lens.blades,
+		ApertureRotation: lens.rotation,
+		BokehSamples:     dr.buffers.BokehSamples,
+		NumBokehSamples:  uint32(len(lens.bokehSamples)),
+		BokehJitter:      lens.bokehJitter,
+		RayPixels:        dr.buffers.RayPixels,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -155,6 +560,136 @@ func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, ca
 	return kernel.Exec2D(0, 0, int(blockReq.FrameW), int(blockReq.BlockH), 0, 0)
 }
 
+// Upload a list of camera rays for the entire frame unless the same list is
+// already uploaded. As the buffer uses the host memory for storage, the caller
+// must keep a reference to the list for as long as the buffer is in use.
+func (dr *deviceResources) UploadCameraRays(rays []CameraRay) error {
+	if sameCameraRays(rays, dr.cameraRays) && dr.buffers.CameraRays.Size() != 0 {
+		return nil
+	}
+
+	err := dr.buffers.CameraRays.AllocateAndWriteData(rays, cl.MEM_READ_ONLY)
+	if err != nil {
+		return err
+	}
+	dr.cameraRays = rays
+	return nil
+}
+
+// Write a list of camera rays for the current block to the start of the camera
+// ray buffer, growing the buffer if required.
+func (dr *deviceResources) WriteBlockCameraRays(rays []CameraRay) error {
+	size := len(rays) * sizeofCameraRay
+	if dr.buffers.CameraRays.Size() < size || dr.cameraRays != nil {
+		err := dr.buffers.CameraRays.Allocate(size, cl.MEM_READ_ONLY)
+		if err != nil {
+			return err
+		}
+		dr.cameraRays = nil
+	}
+
+	return dr.buffers.CameraRays.WriteData(rays, 0)
+}
+
+// Get a scratch buffer for numRays camera rays. The buffer is reused by
+// subsequent calls that request the same number of rays.
+func (dr *deviceResources) CameraRayScratch(numRays int) []CameraRay {
+	if len(dr.cameraRayScratch) != numRays {
+		dr.cameraRayScratch = make([]CameraRay, numRays)
+	}
+	return dr.cameraRayScratch
+}
+
+// Generate primary rays using the contents of the camera ray buffer. The ray
+// for each block pixel is read from the buffer starting at rayOffset. The
+// rayOrder argument selects the order in which rays are assigned to the block
+// pixels.
+func (dr *deviceResources) GenerateCustomRays(blockReq *tracer.BlockRequest, rayOffset uint32, rayOrder RayOrder) (time.Duration, error) {
+	kernel := dr.kernels[generateCustomRays]
+
+	err := dr.uploadRayPixels(blockReq, rayOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	err = generateCustomRaysArgs{
+		Rays:       dr.buffers.Rays[0],
+		NumRays:    dr.buffers.RayCounters[0],
+		Paths:      dr.buffers.Paths,
+		CameraRays: dr.buffers.CameraRays,
+		RayOffset:  rayOffset,
+		BlockY:     blockReq.BlockY,
+		BlockH:     blockReq.BlockH,
+		FrameW:     blockReq.FrameW,
+		RayPixels:  dr.buffers.RayPixels,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, int(blockReq.FrameW*blockReq.BlockH), 0)
+}
+
+// Invalidate the contents of the first-hit cache.
+func (dr *deviceResources) InvalidatePrimaryHits() {
+	dr.primaryHitsValid = false
+}
+
+// Check whether the first-hit cache contains the primary ray intersections
+// for the given block.
+func (dr *deviceResources) HasPrimaryHits(blockReq *tracer.BlockRequest) bool {
+	return dr.primaryHitsValid &&
+		dr.primaryHitsBlock.FrameW == blockReq.FrameW &&
+		dr.primaryHitsBlock.FrameH == blockReq.FrameH &&
+		dr.primaryHitsBlock.BlockY == blockReq.BlockY &&
+		dr.primaryHitsBlock.BlockH == blockReq.BlockH
+}
+
+// Copy the hit flags and intersections for the primary rays of a block into
+// the first-hit cache. The cache buffers are allocated on first use.
+func (dr *deviceResources) StorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+
+	if dr.buffers.PrimaryIntersections.Size() < numPixels*sizeofIntersection {
+		err := dr.buffers.ResizePrimaryHits(blockReq.FrameW, blockReq.FrameH)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := dr.buffers.PrimaryHitFlags.CopyDataFrom(dr.buffers.HitFlags, 0, 0, numPixels*sizeofHitFlag)
+	if err != nil {
+		return 0, err
+	}
+	err = dr.buffers.PrimaryIntersections.CopyDataFrom(dr.buffers.Intersections, 0, 0, numPixels*sizeofIntersection)
+	if err != nil {
+		return 0, err
+	}
+
+	dr.primaryHitsBlock = *blockReq
+	dr.primaryHitsValid = true
+	return time.Since(start), nil
+}
+
+// Restore the hit flags and intersections for the primary rays of a block
+// from the first-hit cache.
+func (dr *deviceResources) RestorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	start := time.Now()
+	numPixels := int(blockReq.FrameW * blockReq.BlockH)
+
+	err := dr.buffers.HitFlags.CopyDataFrom(dr.buffers.PrimaryHitFlags, 0, 0, numPixels*sizeofHitFlag)
+	if err != nil {
+		return 0, err
+	}
+	err = dr.buffers.Intersections.CopyDataFrom(dr.buffers.PrimaryIntersections, 0, 0, numPixels*sizeofIntersection)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
 // Test for ray intersection. This method will update the hit buffer to indicate
 // whether each ray intersects with the scene geometry or not. This method is
 // much faster than an intersection query as it terminates on the first found
@@ -162,14 +697,52 @@ func (dr *deviceResources) GeneratePrimaryRays(blockReq *tracer.BlockRequest, ca
 func (dr *deviceResources) RayIntersectionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[rayIntersectionTest]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.BvhNodes,
-		dr.buffers.MeshInstances,
-		dr.buffers.Vertices,
-		dr.buffers.HitFlags,
-	)
+	err := rayIntersectionTestArgs{
+		Rays:               dr.buffers.Rays[rayBufferIndex],
+		NumRays:            dr.buffers.RayCounters[rayBufferIndex],
+		BvhNodes:           dr.buffers.BvhNodes,
+		MeshInstances:      dr.buffers.MeshInstances,
+		VertexList:         dr.buffers.Vertices,
+		HitFlag:            dr.buffers.HitFlags,
+		StackOverflows:     dr.buffers.StackOverflows,
+		MaterialIndices:    dr.buffers.MaterialIndices,
+		MaterialNodes:      dr.buffers.MaterialNodes,
+		Uv:                 dr.buffers.UV,
+		TexMeta:            dr.buffers.TextureMetadata,
+		TexData:            dr.buffers.Textures,
+		CompressedBvhNodes: dr.buffers.CompressedBvhNodes,
+		CompressedBvhRoots: dr.buffers.CompressedBvhRoots,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Approximate the visibility of the area lights sampled by the occlusion rays
+// by tracing cones towards the lights. The emissive samples of the rays are
+// scaled by the visibility and fully occluded rays are flagged in the hit
+// buffer.
+func (dr *deviceResources) ConeOcclusionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[coneOcclusionTest]
+
+	err := coneOcclusionTestArgs{
+		Rays:            dr.buffers.Rays[rayBufferIndex],
+		NumRays:         dr.buffers.RayCounters[rayBufferIndex],
+		BvhNodes:        dr.buffers.BvhNodes,
+		MeshInstances:   dr.buffers.MeshInstances,
+		VertexList:      dr.buffers.Vertices,
+		HitFlag:         dr.buffers.HitFlags,
+		StackOverflows:  dr.buffers.StackOverflows,
+		OcclusionCones:  dr.buffers.OcclusionCones,
+		EmissiveSamples: dr.buffers.EmissiveSamples,
+		MaterialIndices: dr.buffers.MaterialIndices,
+		MaterialNodes:   dr.buffers.MaterialNodes,
+		Uv:              dr.buffers.UV,
+		TexMeta:         dr.buffers.TextureMetadata,
+		TexData:         dr.buffers.Textures,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -179,18 +752,28 @@ func (dr *deviceResources) RayIntersectionTest(rayBufferIndex uint32, numPixels
 
 // Calculate ray intersections and fill out the hit buffer and the intersection
 // buffer with intersection data for the closest ray/triangle intersection.
-func (dr *deviceResources) RayIntersectionQuery(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+// Mesh instances with any of the skipInstanceFlags set are ignored.
+func (dr *deviceResources) RayIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[rayIntersectionQuery]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.BvhNodes,
-		dr.buffers.MeshInstances,
-		dr.buffers.Vertices,
-		dr.buffers.HitFlags,
-		dr.buffers.Intersections,
-	)
+	err := rayIntersectionQueryArgs{
+		Rays:               dr.buffers.Rays[rayBufferIndex],
+		NumRays:            dr.buffers.RayCounters[rayBufferIndex],
+		BvhNodes:           dr.buffers.BvhNodes,
+		MeshInstances:      dr.buffers.MeshInstances,
+		VertexList:         dr.buffers.Vertices,
+		HitFlag:            dr.buffers.HitFlags,
+		Intersections:      dr.buffers.Intersections,
+		StackOverflows:     dr.buffers.StackOverflows,
+		SkipInstanceFlags:  uint32(skipInstanceFlags),
+		MaterialIndices:    dr.buffers.MaterialIndices,
+		MaterialNodes:      dr.buffers.MaterialNodes,
+		Uv:                 dr.buffers.UV,
+		TexMeta:            dr.buffers.TextureMetadata,
+		TexData:            dr.buffers.Textures,
+		CompressedBvhNodes: dr.buffers.CompressedBvhNodes,
+		CompressedBvhRoots: dr.buffers.CompressedBvhRoots,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -201,18 +784,27 @@ func (dr *deviceResources) RayIntersectionQuery(rayBufferIndex uint32, numPixels
 // Calculate ray intersections and fill out the hit buffer and the intersection
 // buffer with intersection data for the closest ray/triangle intersection.
 // This kernel works with ray packets and should only be used for primary rays.
-func (dr *deviceResources) RayPacketIntersectionQuery(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+func (dr *deviceResources) RayPacketIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[rayPacketIntersectionQuery]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.BvhNodes,
-		dr.buffers.MeshInstances,
-		dr.buffers.Vertices,
-		dr.buffers.HitFlags,
-		dr.buffers.Intersections,
-	)
+	err := rayPacketIntersectionQueryArgs{
+		Rays:               dr.buffers.Rays[rayBufferIndex],
+		NumRays:            dr.buffers.RayCounters[rayBufferIndex],
+		BvhNodes:           dr.buffers.BvhNodes,
+		MeshInstances:      dr.buffers.MeshInstances,
+		VertexList:         dr.buffers.Vertices,
+		HitFlag:            dr.buffers.HitFlags,
+		Intersections:      dr.buffers.Intersections,
+		StackOverflows:     dr.buffers.StackOverflows,
+		SkipInstanceFlags:  uint32(skipInstanceFlags),
+		MaterialIndices:    dr.buffers.MaterialIndices,
+		MaterialNodes:      dr.buffers.MaterialNodes,
+		Uv:                 dr.buffers.UV,
+		TexMeta:            dr.buffers.TextureMetadata,
+		TexData:            dr.buffers.Textures,
+		CompressedBvhNodes: dr.buffers.CompressedBvhNodes,
+		CompressedBvhRoots: dr.buffers.CompressedBvhRoots,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -220,10 +812,125 @@ func (dr *deviceResources) RayPacketIntersectionQuery(rayBufferIndex uint32, num
 	return kernel.Exec1D(0, numPixels, 32)
 }
 
+// Reset the counter of rays whose BVH traversal overflowed the traversal stack.
+func (dr *deviceResources) ClearStackOverflows() error {
+	return dr.buffers.StackOverflows.WriteData(counterResetPattern, 0)
+}
+
+// Read the number of rays whose BVH traversal overflowed the traversal stack
+// since the counter was last cleared. Such rays skip the BVH nodes that did
+// not fit in the stack and may therefore miss geometry.
+func (dr *deviceResources) ReadStackOverflows() (uint32, error) {
+	count := make([]uint32, 1)
+	err := dr.buffers.StackOverflows.ReadData(0, 0, 4, count)
+	return count[0], err
+}
+
+// Sample a free-flight distance for each ray that travels through a
+// participating medium and update the path throughputs. Rays that scatter
+// before reaching the next surface are flagged so that ShadeHits shades the
+// medium interaction instead. Camera rays (bounce 0) start inside the medium
+// with the supplied material node index which may be set to -1 if the scene
+// does not define a medium. The scattering distances are also sampled towards
+// the area lights using equi-angular sampling. Heterogeneous media are sampled
+// using delta tracking and the light they emit is added to the trace
+// accumulator after applying the sample clamp.
+func (dr *deviceResources) SampleMediumInteractions(blockReq *tracer.BlockRequest, mediumMatNodeIndex int32, bounce, randSeed uint32, sampler pathSampler, numEmissives uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[sampleMediumInteractions]
+
+	err := sampleMediumInteractionsArgs{
+		Rays:                    dr.buffers.Rays[rayBufferIndex],
+		NumRays:                 dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                   dr.buffers.Paths,
+		HitFlags:                dr.buffers.HitFlags,
+		Intersections:           dr.buffers.Intersections,
+		MaterialNodes:           dr.buffers.MaterialNodes,
+		PathMedia:               dr.buffers.PathMedia,
+		SceneMediumMatNodeIndex: mediumMatNodeIndex,
+		Vertices:                dr.buffers.Vertices,
+		Emissives:               dr.buffers.EmissivePrimitives,
+		NumEmissives:            numEmissives,
+		Bounce:                  bounce,
+		RandSeed:                randSeed,
+		SamplerType:             uint32(sampler.sampler),
+		SamplerSeed:             sampler.seed,
+		SampleIndex:             sampler.index,
+		FrameW:                  blockReq.FrameW,
+		BlueNoise:               dr.buffers.BlueNoise,
+		VolumeGrids:             dr.buffers.VolumeGrids,
+		VolumeData:              dr.buffers.VolumeData,
+		FrameH:                  blockReq.FrameH,
+		ClampDirect:             clamp.Direct,
+		ClampIndirect:           clamp.Indirect,
+		Accumulator:             dr.buffers.TraceAccumulator,
+		NumLpeExpressions:       uint32(len(dr.lightPathExpressions)),
+		LpeTransitions:          dr.buffers.LpeTransitions,
+		LpeStates:               dr.buffers.LpeStates,
+		LpeAccumulator:          dr.buffers.TraceLpeAccumulator,
+		LightGroupMasks:         dr.buffers.LightGroupMasks,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Record the texture footprint of each ray hit into the texture feedback
+// slot of the hit material. The feedback buffer must be allocated via
+// ResetTextureFeedback before invoking this stage.
+func (dr *deviceResources) RecordTextureFeedback(textureFilter TextureFilter, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[recordTextureFeedback]
+
+	err := recordTextureFeedbackArgs{
+		Rays:            dr.buffers.Rays[rayBufferIndex],
+		NumRays:         dr.buffers.RayCounters[rayBufferIndex],
+		Paths:           dr.buffers.Paths,
+		HitFlags:        dr.buffers.HitFlags,
+		Intersections:   dr.buffers.Intersections,
+		Vertices:        dr.buffers.Vertices,
+		Normals:         dr.buffers.Normals,
+		Uv:              dr.buffers.UV,
+		MaterialIndices: dr.buffers.MaterialIndices,
+		TextureFilter:   uint32(textureFilter),
+		TextureFeedback: dr.buffers.TextureFeedback,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Allocate a texture feedback slot for each material node and clear all
+// recorded footprints.
+func (dr *deviceResources) ResetTextureFeedback(numMaterialNodes int) error {
+	feedback := make([]int32, numMaterialNodes)
+	for index := range feedback {
+		feedback[index] = textureFeedbackNone
+	}
+
+	if dr.buffers.TextureFeedback.Size() != numMaterialNodes*4 {
+		err := dr.buffers.TextureFeedback.Allocate(numMaterialNodes*4, cl.MEM_READ_WRITE)
+		if err != nil {
+			return err
+		}
+	}
+	return dr.buffers.TextureFeedback.WriteData(feedback, 0)
+}
+
+// Read the texture footprints recorded for each material node.
+func (dr *deviceResources) ReadTextureFeedback(out []int32) error {
+	return dr.buffers.TextureFeedback.ReadData(0, 0, len(out)*4, out)
+}
+
 // Evaluate shading for intersections. For each intersection, this kernel may
 // generate an occlusion ray and a emissive sample as well as an indirect
-// ray to be used for future bounces.
-func (dr *deviceResources) ShadeHits(bounce, minBouncesForRR, randSeed, numEmissives, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+// ray to be used for future bounces. The scene background material indices
+// are used for shading shadow catchers and may be set to -1 if the scene
+// does not define them. The medium material index selects the medium that
+// fills the scene and may be set to -1 if the scene does not define one.
+func (dr *deviceResources) ShadeHits(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex, mediumMatNodeIndex int32, bounce, minBouncesForRR, randSeed uint32, sampler pathSampler, numEmissives uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, numLightVertices, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[shadeHits]
 
 	// Clear indirect ray counters
@@ -237,34 +944,68 @@ func (dr *deviceResources) ShadeHits(bounce, minBouncesForRR, randSeed, numEmiss
 		return 0, err
 	}
 
-	err = kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.Intersections,
-		dr.buffers.Vertices,
-		dr.buffers.Normals,
-		dr.buffers.UV,
-		dr.buffers.MaterialIndices,
-		dr.buffers.MaterialNodes,
-		dr.buffers.EmissivePrimitives,
-		numEmissives,
-		dr.buffers.TextureMetadata,
-		dr.buffers.Textures,
-		bounce,
-		minBouncesForRR,
-		randSeed,
-		// Occlusion rays and emissive samples
-		dr.buffers.Rays[2], // occlusion rays always go to last ray buf
+	err = shadeHitsArgs{
+		Rays:                       dr.buffers.Rays[rayBufferIndex],
+		NumRays:                    dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                      dr.buffers.Paths,
+		HitFlags:                   dr.buffers.HitFlags,
+		Intersections:              dr.buffers.Intersections,
+		MeshInstances:              dr.buffers.MeshInstances,
+		Vertices:                   dr.buffers.Vertices,
+		Normals:                    dr.buffers.Normals,
+		Tangents:                   dr.buffers.Tangents,
+		Uv:                         dr.buffers.UV,
+		MaterialIndices:            dr.buffers.MaterialIndices,
+		VertexOcclusion:            dr.buffers.VertexOcclusion,
+		MaterialNodes:              dr.buffers.MaterialNodes,
+		Emissives:                  dr.buffers.EmissivePrimitives,
+		NumEmissives:               numEmissives,
+		EnvDistribution:            dr.buffers.EnvMapDistribution,
+		EnvDistributionW:           dr.envMapDims[0],
+		EnvDistributionH:           dr.envMapDims[1],
+		SceneDiffuseMatNodeIndex:   diffuseMatNodeIndex,
+		SceneBackplateMatNodeIndex: backplateMatNodeIndex,
+		SceneEnvMatNodeIndex:       envMatNodeIndex,
+		FrameW:                     blockReq.FrameW,
+		FrameH:                     blockReq.FrameH,
+		TexMeta:                    dr.buffers.TextureMetadata,
+		TexData:                    dr.buffers.Textures,
+		Bounce:                     bounce,
+		MinBouncesForRR:            minBouncesForRR,
+		RandSeed:                   randSeed,
+		SamplerType:                uint32(sampler.sampler),
+		SamplerSeed:                sampler.seed,
+		SampleIndex:                sampler.index,
+		BlueNoise:                  dr.buffers.BlueNoise,
+		ShadingNormalFix:           uint32(normalCorrection),
+		TextureFilter:              uint32(textureFilter),
+		ClampDirect:                clamp.Direct,
+		ClampIndirect:              clamp.Indirect,
+		OcclusionRays:// Occlusion rays and emissive samples
+		dr.buffers.Rays[2],
+		NumOcclusionRays:// occlusion rays always go to last ray buf
 		dr.buffers.RayCounters[2],
-		dr.buffers.EmissiveSamples,
-		// Indirect rays
+		EmissiveSamples: dr.buffers.EmissiveSamples,
+		OcclusionCones:  dr.buffers.OcclusionCones,
+		IndirectRays:// Indirect rays
 		dr.buffers.Rays[1-rayBufferIndex],
-		dr.buffers.RayCounters[1-rayBufferIndex],
-		//
+		NumIndirectRays: dr.buffers.RayCounters[1-rayBufferIndex],
+		Accumulator://
 		dr.buffers.TraceAccumulator,
-	)
+		NumLpeExpressions:// Light path expressions
+		uint32(len(dr.lightPathExpressions)),
+		LpeTransitions:          dr.buffers.LpeTransitions,
+		LpeStates:               dr.buffers.LpeStates,
+		EmissiveSampleLpeMasks:  dr.buffers.EmissiveSampleLpeMasks,
+		LpeAccumulator:          dr.buffers.TraceLpeAccumulator,
+		LightGroupMasks:         dr.buffers.LightGroupMasks,
+		NumBounces:              blockReq.NumBounces,
+		NumLightVertices:        numLightVertices,
+		PathMedia:               dr.buffers.PathMedia,
+		SceneMediumMatNodeIndex: mediumMatNodeIndex,
+		VolumeGrids:             dr.buffers.VolumeGrids,
+		VolumeData:              dr.buffers.VolumeData,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -273,22 +1014,32 @@ func (dr *deviceResources) ShadeHits(bounce, minBouncesForRR, randSeed, numEmiss
 }
 
 // Shade primary ray misses by sampling the scene background. This kernel samples
-// the background color or envmap using the ray direction and sets the
-// accumulator to the sampled value.
-func (dr *deviceResources) ShadePrimaryRayMisses(diffuseMatNodeIndex, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+// the env map (envMatNodeIndex >= 0) or the background color using the ray
+// direction and sets the accumulator to the sampled value. If a backplate
+// material is specified (backplateMatNodeIndex >= 0), it is sampled using the
+// pixel coordinates instead.
+func (dr *deviceResources) ShadePrimaryRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex int32, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[shadePrimaryRayMisses]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.MaterialNodes,
-		diffuseMatNodeIndex,
-		dr.buffers.TextureMetadata,
-		dr.buffers.Textures,
-		dr.buffers.TraceAccumulator,
-	)
+	err := shadePrimaryRayMissesArgs{
+		Rays:                       dr.buffers.Rays[rayBufferIndex],
+		NumRays:                    dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                      dr.buffers.Paths,
+		HitFlags:                   dr.buffers.HitFlags,
+		MaterialNodes:              dr.buffers.MaterialNodes,
+		SceneDiffuseMatNodeIndex:   diffuseMatNodeIndex,
+		SceneBackplateMatNodeIndex: backplateMatNodeIndex,
+		SceneEnvMatNodeIndex:       envMatNodeIndex,
+		FrameW:                     blockReq.FrameW,
+		FrameH:                     blockReq.FrameH,
+		TexMeta:                    dr.buffers.TextureMetadata,
+		TexData:                    dr.buffers.Textures,
+		Accumulator:                dr.buffers.TraceAccumulator,
+		NumLpeExpressions:          uint32(len(dr.lightPathExpressions)),
+		LpeTransitions:             dr.buffers.LpeTransitions,
+		LpeAccumulator:             dr.buffers.TraceLpeAccumulator,
+		LightGroupMasks:            dr.buffers.LightGroupMasks,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -298,21 +1049,75 @@ func (dr *deviceResources) ShadePrimaryRayMisses(diffuseMatNodeIndex, rayBufferI
 
 // Shade indirect ray misses by sampling the scene background. The main difference
 // with ShadePrimaryRayMisses is that this kernel multiplies the path throughput
-// with the bg sample and adds that to the accumulator.
-func (dr *deviceResources) ShadeIndirectRayMisses(diffuseMatNodeIndex, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+// with the bg sample and adds that to the accumulator. The env map is sampled
+// instead of the background color if envMatNodeIndex >= 0. The bounce argument
+// specifies the number of times that the missed rays were scattered and is
+// used for clamping the samples.
+func (dr *deviceResources) ShadeIndirectRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, envMatNodeIndex int32, bounce uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[shadeIndirectRayMisses]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.MaterialNodes,
-		diffuseMatNodeIndex,
-		dr.buffers.TextureMetadata,
-		dr.buffers.Textures,
-		dr.buffers.TraceAccumulator,
-	)
+	err := shadeIndirectRayMissesArgs{
+		Rays:                     dr.buffers.Rays[rayBufferIndex],
+		NumRays:                  dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                    dr.buffers.Paths,
+		HitFlags:                 dr.buffers.HitFlags,
+		MaterialNodes:            dr.buffers.MaterialNodes,
+		SceneDiffuseMatNodeIndex: diffuseMatNodeIndex,
+		SceneEnvMatNodeIndex:     envMatNodeIndex,
+		Bounce:                   bounce,
+		ClampDirect:              clamp.Direct,
+		ClampIndirect:            clamp.Indirect,
+		TexMeta:                  dr.buffers.TextureMetadata,
+		TexData:                  dr.buffers.Textures,
+		Accumulator:              dr.buffers.TraceAccumulator,
+		NumLpeExpressions:        uint32(len(dr.lightPathExpressions)),
+		LpeTransitions:           dr.buffers.LpeTransitions,
+		LpeStates:                dr.buffers.LpeStates,
+		NumPixels:                blockReq.FrameW * blockReq.FrameH,
+		LpeAccumulator:           dr.buffers.TraceLpeAccumulator,
+		LightGroupMasks:          dr.buffers.LightGroupMasks,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Accumulate the radiance emitted by emissive surfaces that were hit by the
+// rays in the specified buffer. Unlike ShadeHits, this kernel does not scatter
+// the paths or sample any lights. The bounce argument specifies the number of
+// times that the rays were scattered and is used for clamping the samples.
+func (dr *deviceResources) ShadeEmissiveHits(blockReq *tracer.BlockRequest, bounce, randSeed uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[shadeEmissiveHits]
+
+	err := shadeEmissiveHitsArgs{
+		Rays:              dr.buffers.Rays[rayBufferIndex],
+		NumRays:           dr.buffers.RayCounters[rayBufferIndex],
+		Paths:             dr.buffers.Paths,
+		HitFlags:          dr.buffers.HitFlags,
+		Intersections:     dr.buffers.Intersections,
+		Vertices:          dr.buffers.Vertices,
+		Normals:           dr.buffers.Normals,
+		Tangents:          dr.buffers.Tangents,
+		Uv:                dr.buffers.UV,
+		MaterialIndices:   dr.buffers.MaterialIndices,
+		VertexOcclusion:   dr.buffers.VertexOcclusion,
+		MaterialNodes:     dr.buffers.MaterialNodes,
+		TexMeta:           dr.buffers.TextureMetadata,
+		TexData:           dr.buffers.Textures,
+		Bounce:            bounce,
+		RandSeed:          randSeed,
+		ClampDirect:       clamp.Direct,
+		ClampIndirect:     clamp.Indirect,
+		Accumulator:       dr.buffers.TraceAccumulator,
+		NumLpeExpressions: uint32(len(dr.lightPathExpressions)),
+		LpeTransitions:    dr.buffers.LpeTransitions,
+		LpeStates:         dr.buffers.LpeStates,
+		NumPixels:         blockReq.FrameW * blockReq.FrameH,
+		LpeAccumulator:    dr.buffers.TraceLpeAccumulator,
+		LightGroupMasks:   dr.buffers.LightGroupMasks,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -322,17 +1127,283 @@ func (dr *deviceResources) ShadeIndirectRayMisses(diffuseMatNodeIndex, rayBuffer
 
 // Accumulate emissive samples for which no occlusion has been detected
 // between the surface and the emissive primitive.
-func (dr *deviceResources) AccumulateEmissiveSamples(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+func (dr *deviceResources) AccumulateEmissiveSamples(blockReq *tracer.BlockRequest, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
 	kernel := dr.kernels[accumulateEmissiveSamples]
 
-	err := kernel.SetArgs(
-		dr.buffers.Rays[rayBufferIndex],
-		dr.buffers.RayCounters[rayBufferIndex],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.EmissiveSamples,
-		dr.buffers.TraceAccumulator,
-	)
+	err := accumulateEmissiveSamplesArgs{
+		Rays:                   dr.buffers.Rays[rayBufferIndex],
+		NumRays:                dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                  dr.buffers.Paths,
+		HitFlags:               dr.buffers.HitFlags,
+		EmissiveSamples:        dr.buffers.EmissiveSamples,
+		Accumulator:            dr.buffers.TraceAccumulator,
+		EmissiveSampleLpeMasks: dr.buffers.EmissiveSampleLpeMasks,
+		NumPixels:              blockReq.FrameW * blockReq.FrameH,
+		LpeAccumulator:         dr.buffers.TraceLpeAccumulator,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Generate a light ray for each light subpath of the bidirectional integrator
+// and clear the stored light subpath vertices. The light path buffers are
+// allocated on first use.
+func (dr *deviceResources) GenerateLightRays(blockReq *tracer.BlockRequest, randSeed, numEmissives, numLightVertices uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[generateLightRays]
+
+	if dr.buffers.LightVertices.Size() < numPixels*int(numLightVertices)*sizeofLightVertex {
+		err := dr.buffers.ResizeLightPaths(blockReq.FrameW, blockReq.FrameH, int(numLightVertices))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := dr.buffers.LightRayCounters[0].WriteData(counterResetPattern, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	err = generateLightRaysArgs{
+		Rays:                dr.buffers.LightRays[0],
+		NumRays:             dr.buffers.LightRayCounters[0],
+		Paths:               dr.buffers.LightPaths,
+		LightVertices:       dr.buffers.LightVertices,
+		NumLightVertices:    numLightVertices,
+		NumPaths:            uint32(numPixels),
+		Vertices:            dr.buffers.Vertices,
+		Normals:             dr.buffers.Normals,
+		Uv:                  dr.buffers.UV,
+		MaterialNodes:       dr.buffers.MaterialNodes,
+		Emissives:           dr.buffers.EmissivePrimitives,
+		NumEmissives:        numEmissives,
+		TexMeta:             dr.buffers.TextureMetadata,
+		TexData:             dr.buffers.Textures,
+		RandSeed:            randSeed,
+		LightGroupMasks:     dr.buffers.LightGroupMasks,
+		LightPathGroupMasks: dr.buffers.LightPathGroupMasks,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Calculate the closest intersections for a light ray buffer. The hit flag and
+// intersection buffers are shared with the eye paths so light subpaths must be
+// traced before the primary ray intersections are calculated.
+func (dr *deviceResources) LightRayIntersectionQuery(rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[rayIntersectionQuery]
+
+	err := rayIntersectionQueryArgs{
+		Rays:               dr.buffers.LightRays[rayBufferIndex],
+		NumRays:            dr.buffers.LightRayCounters[rayBufferIndex],
+		BvhNodes:           dr.buffers.BvhNodes,
+		MeshInstances:      dr.buffers.MeshInstances,
+		VertexList:         dr.buffers.Vertices,
+		HitFlag:            dr.buffers.HitFlags,
+		Intersections:      dr.buffers.Intersections,
+		StackOverflows:     dr.buffers.StackOverflows,
+		MaterialIndices:    dr.buffers.MaterialIndices,
+		MaterialNodes:      dr.buffers.MaterialNodes,
+		Uv:                 dr.buffers.UV,
+		TexMeta:            dr.buffers.TextureMetadata,
+		TexData:            dr.buffers.Textures,
+		CompressedBvhNodes: dr.buffers.CompressedBvhNodes,
+		CompressedBvhRoots: dr.buffers.CompressedBvhRoots,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Store the light subpath vertices at the given depth and emit the light rays
+// for the next depth to the other light ray buffer.
+func (dr *deviceResources) ShadeLightHits(depth, minBouncesForRR, randSeed, numLightVertices uint32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[shadeLightHits]
+
+	err := dr.buffers.LightRayCounters[1-rayBufferIndex].WriteData(counterResetPattern, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	err = shadeLightHitsArgs{
+		Rays:             dr.buffers.LightRays[rayBufferIndex],
+		NumRays:          dr.buffers.LightRayCounters[rayBufferIndex],
+		Paths:            dr.buffers.LightPaths,
+		HitFlags:         dr.buffers.HitFlags,
+		Intersections:    dr.buffers.Intersections,
+		MeshInstances:    dr.buffers.MeshInstances,
+		Vertices:         dr.buffers.Vertices,
+		Normals:          dr.buffers.Normals,
+		Tangents:         dr.buffers.Tangents,
+		Uv:               dr.buffers.UV,
+		MaterialIndices:  dr.buffers.MaterialIndices,
+		VertexOcclusion:  dr.buffers.VertexOcclusion,
+		MaterialNodes:    dr.buffers.MaterialNodes,
+		TexMeta:          dr.buffers.TextureMetadata,
+		TexData:          dr.buffers.Textures,
+		Depth:            depth,
+		MinBouncesForRR:  minBouncesForRR,
+		RandSeed:         randSeed,
+		ShadingNormalFix: uint32(normalCorrection),
+		LightVertices:    dr.buffers.LightVertices,
+		NumLightVertices: numLightVertices,
+		OutRays:          dr.buffers.LightRays[1-rayBufferIndex],
+		NumOutRays:       dr.buffers.LightRayCounters[1-rayBufferIndex],
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	return kernel.Exec1D(0, numPixels, 0)
+}
+
+// Connect the eye subpath vertices for the hits in the given ray buffer to the
+// light subpath vertices at lightDepth. The connection rays are tested for
+// occlusion and the samples of non-occluded connections are accumulated. This
+// method must be invoked before ShadeHits updates the path throughput.
+func (dr *deviceResources) ConnectLightVertex(blockReq *tracer.BlockRequest, bounce, randSeed, lightDepth, numLightVertices uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	start := time.Now()
+
+	err := dr.buffers.ConnectionRayCounter.WriteData(counterResetPattern, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	kernel := dr.kernels[connectLightVertex]
+	err = connectLightVertexArgs{
+		Rays:                     dr.buffers.Rays[rayBufferIndex],
+		NumRays:                  dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                    dr.buffers.Paths,
+		HitFlags:                 dr.buffers.HitFlags,
+		Intersections:            dr.buffers.Intersections,
+		MeshInstances:            dr.buffers.MeshInstances,
+		Vertices:                 dr.buffers.Vertices,
+		Normals:                  dr.buffers.Normals,
+		Tangents:                 dr.buffers.Tangents,
+		Uv:                       dr.buffers.UV,
+		MaterialIndices:          dr.buffers.MaterialIndices,
+		VertexOcclusion:          dr.buffers.VertexOcclusion,
+		MaterialNodes:            dr.buffers.MaterialNodes,
+		TexMeta:                  dr.buffers.TextureMetadata,
+		TexData:                  dr.buffers.Textures,
+		Bounce:                   bounce,
+		NumBounces:               blockReq.NumBounces,
+		RandSeed:                 randSeed,
+		ShadingNormalFix:         uint32(normalCorrection),
+		TextureFilter:            uint32(textureFilter),
+		ClampIndirect:            clamp.Indirect,
+		LightVertices:            dr.buffers.LightVertices,
+		LightDepth:               lightDepth,
+		NumLightVertices:         numLightVertices,
+		LightPathGroupMasks:      dr.buffers.LightPathGroupMasks,
+		ConnectionRays:           dr.buffers.ConnectionRays,
+		NumConnectionRays:        dr.buffers.ConnectionRayCounter,
+		ConnectionSamples:        dr.buffers.ConnectionSamples,
+		ConnectionSampleLpeMasks: dr.buffers.ConnectionLpeMasks,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+	_, err = kernel.Exec1D(0, numPixels, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	// Test connection rays for occlusion using a separate hit flag buffer
+	// as the eye path hit flags are still needed by ShadeHits.
+	kernel = dr.kernels[rayIntersectionTest]
+	err = rayIntersectionTestArgs{
+		Rays:               dr.buffers.ConnectionRays,
+		NumRays:            dr.buffers.ConnectionRayCounter,
+		BvhNodes:           dr.buffers.BvhNodes,
+		MeshInstances:      dr.buffers.MeshInstances,
+		VertexList:         dr.buffers.Vertices,
+		HitFlag:            dr.buffers.ConnectionHitFlags,
+		StackOverflows:     dr.buffers.StackOverflows,
+		MaterialIndices:    dr.buffers.MaterialIndices,
+		MaterialNodes:      dr.buffers.MaterialNodes,
+		Uv:                 dr.buffers.UV,
+		TexMeta:            dr.buffers.TextureMetadata,
+		TexData:            dr.buffers.Textures,
+		CompressedBvhNodes: dr.buffers.CompressedBvhNodes,
+		CompressedBvhRoots: dr.buffers.CompressedBvhRoots,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+	_, err = kernel.Exec1D(0, numPixels, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	kernel = dr.kernels[accumulateEmissiveSamples]
+	err = accumulateEmissiveSamplesArgs{
+		Rays:                   dr.buffers.ConnectionRays,
+		NumRays:                dr.buffers.ConnectionRayCounter,
+		Paths:                  dr.buffers.Paths,
+		HitFlags:               dr.buffers.ConnectionHitFlags,
+		EmissiveSamples:        dr.buffers.ConnectionSamples,
+		Accumulator:            dr.buffers.TraceAccumulator,
+		EmissiveSampleLpeMasks: dr.buffers.ConnectionLpeMasks,
+		NumPixels:              blockReq.FrameW * blockReq.FrameH,
+		LpeAccumulator:         dr.buffers.TraceLpeAccumulator,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+	_, err = kernel.Exec1D(0, numPixels, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+// Shade ray intersections using ambient occlusion. Each hit emits an
+// occlusion ray limited to maxDistance into the occlusion ray buffer with a
+// white sample that is added to the accumulator by AccumulateEmissiveSamples
+// if the ray is not occluded. Misses add a white sample to the accumulator.
+func (dr *deviceResources) ShadeAmbientOcclusion(blockReq *tracer.BlockRequest, randSeed uint32, sampler pathSampler, maxDistance float32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error) {
+	kernel := dr.kernels[shadeAmbientOcclusion]
+
+	// Clear occlusion ray counter
+	err := dr.buffers.RayCounters[2].WriteData(counterResetPattern, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	err = shadeAmbientOcclusionArgs{
+		Rays:                   dr.buffers.Rays[rayBufferIndex],
+		NumRays:                dr.buffers.RayCounters[rayBufferIndex],
+		Paths:                  dr.buffers.Paths,
+		HitFlags:               dr.buffers.HitFlags,
+		Intersections:          dr.buffers.Intersections,
+		MeshInstances:          dr.buffers.MeshInstances,
+		Vertices:               dr.buffers.Vertices,
+		Normals:                dr.buffers.Normals,
+		Uv:                     dr.buffers.UV,
+		MaterialIndices:        dr.buffers.MaterialIndices,
+		RandSeed:               randSeed,
+		SamplerType:            uint32(sampler.sampler),
+		SamplerSeed:            sampler.seed,
+		SampleIndex:            sampler.index,
+		FrameW:                 blockReq.FrameW,
+		BlueNoise:              dr.buffers.BlueNoise,
+		ShadingNormalFix:       uint32(normalCorrection),
+		MaxDistance:            maxDistance,
+		OcclusionRays:          dr.buffers.Rays[2],
+		NumOcclusionRays:       dr.buffers.RayCounters[2],
+		EmissiveSamples:        dr.buffers.EmissiveSamples,
+		EmissiveSampleLpeMasks: dr.buffers.EmissiveSampleLpeMasks,
+		Accumulator:            dr.buffers.TraceAccumulator,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -344,14 +1415,13 @@ func (dr *deviceResources) AccumulateEmissiveSamples(rayBufferIndex uint32, numP
 func (dr *deviceResources) TonemapSimpleReinhard(blockReq *tracer.BlockRequest) (time.Duration, error) {
 	kernel := dr.kernels[tonemapSimpleReinhard]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
-	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
-	err := kernel.SetArgs(
-		dr.buffers.FrameAccumulator,
-		dr.buffers.Paths,
-		dr.buffers.FrameBuffer,
-		sampleWeight,
-		blockReq.Exposure,
-	)
+	err := tonemapSimpleReinhardArgs{
+		Accumulator:  dr.outputAccumulator(),
+		Paths:        dr.buffers.Paths,
+		FrameBuffer:  dr.buffers.FrameBuffer,
+		SampleWeight: blockReq.SampleWeight(),
+		Exposure:     blockReq.Exposure,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -359,14 +1429,283 @@ func (dr *deviceResources) TonemapSimpleReinhard(blockReq *tracer.BlockRequest)
 	return kernel.Exec1D(0, numPixels, 0)
 }
 
+// Read the frame accumulator contents and normalize them by the total number
+// of accumulated samples. The output slice receives 3 float32 values (RGB)
+// per frame pixel.
+func (dr *deviceResources) ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	start := time.Now()
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	if len(out) < numPixels*3 {
+		return 0, ErrBufferTooSmall
+	}
+
+	// Accumulator samples are stored as float3 values which occupy the
+	// same space as a float4 value.
+	if len(dr.radianceScratch) != numPixels*4 {
+		dr.radianceScratch = make([]float32, numPixels*4)
+	}
+	err := dr.outputAccumulator().ReadData(0, 0, numPixels*sizeofAccumulatorSample, dr.radianceScratch)
+	if err != nil {
+		return 0, err
+	}
+
+	sampleWeight := blockReq.SampleWeight()
+	for pixel, rOffset, wOffset := 0, 0, 0; pixel < numPixels; pixel, rOffset, wOffset = pixel+1, rOffset+4, wOffset+3 {
+		out[wOffset] = dr.radianceScratch[rOffset] * sampleWeight
+		out[wOffset+1] = dr.radianceScratch[rOffset+1] * sampleWeight
+		out[wOffset+2] = dr.radianceScratch[rOffset+2] * sampleWeight
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the values of a single frame pixel. Only the accumulator elements for
+// the probed pixel are transferred from the device.
+func (dr *deviceResources) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	probe, pixel, err := tracer.NewPixelProbe(blockReq, x, y)
+	if err != nil {
+		return probe, err
+	}
+
+	// Accumulator samples are stored as float3 values which occupy the
+	// same space as a float4 value.
+	sample := make([]float32, 4)
+	err = dr.outputAccumulator().ReadData(pixel*sizeofAccumulatorSample, 0, sizeofAccumulatorSample, sample)
+	if err != nil {
+		return probe, err
+	}
+
+	sampleWeight := blockReq.SampleWeight()
+	probe.Radiance = [3]float32{sample[0] * sampleWeight, sample[1] * sampleWeight, sample[2] * sampleWeight}
+
+	if dr.collectSampleStats {
+		err = dr.buffers.FrameSampleStats.ReadData(pixel*sizeofAccumulatorSample, 0, sizeofAccumulatorSample, sample)
+		if err != nil {
+			return probe, err
+		}
+		probe.RelativeError = relativeError(sample[0], sample[1], sample[2])
+	}
+
+	if len(dr.lightPathExpressions) != 0 {
+		numPixels := int(blockReq.FrameW * blockReq.FrameH)
+		probe.LightPaths = make(map[string][3]float32, len(dr.lightPathExpressions))
+		for pass, expr := range dr.lightPathExpressions {
+			err = dr.buffers.FrameLpeAccumulator.ReadData((pass*numPixels+pixel)*sizeofAccumulatorSample, 0, sizeofAccumulatorSample, sample)
+			if err != nil {
+				return probe, err
+			}
+			probe.LightPaths[expr.Name] = [3]float32{sample[0] * sampleWeight, sample[1] * sampleWeight, sample[2] * sampleWeight}
+		}
+	}
+
+	return probe, nil
+}
+
+// Get the accumulator used by the post-processing kernels. This is the post
+// accumulator if a host post-processing stage has processed the current frame
+// or the frame accumulator otherwise.
+func (dr *deviceResources) outputAccumulator() *device.Buffer {
+	if dr.postProcessed {
+		return dr.buffers.PostAccumulator
+	}
+	return dr.buffers.FrameAccumulator
+}
+
+// Scale the light group passes of the frame using the intensity multipliers of
+// the block request and store the mixed radiance in the post accumulator so
+// that the post-processing stages operate on it. If light groups are not
+// collected or all multipliers are 1, the post accumulator is not modified.
+func (dr *deviceResources) MixLightGroups(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	scales := lightGroupScales(blockReq.LightGroupScales, dr.numLightGroups)
+	if scales == nil {
+		return 0, nil
+	}
+
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	if dr.buffers.PostAccumulator.Size() != numPixels*sizeofAccumulatorSample {
+		err := dr.buffers.PostAccumulator.Allocate(numPixels*sizeofAccumulatorSample, cl.MEM_READ_WRITE)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	dr.lightGroupScales = scales
+	err := dr.buffers.LightGroupScales.AllocateAndWriteData(dr.lightGroupScales, cl.MEM_READ_ONLY)
+	if err != nil {
+		return 0, err
+	}
+
+	kernel := dr.kernels[mixLightGroups]
+	err = mixLightGroupsArgs{
+		Accumulator:         dr.buffers.FrameAccumulator,
+		LpeAccumulator:      dr.buffers.FrameLpeAccumulator,
+		LightGroupScales:    dr.buffers.LightGroupScales,
+		FirstLightGroupPass: uint32(len(dr.lightPathExpressions)),
+		NumLightGroups:      uint32(dr.numLightGroups),
+		NumPixels:           uint32(numPixels),
+		OutAccumulator:      dr.buffers.PostAccumulator,
+	}.bind(kernel)
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed, err := kernel.Exec1D(0, numPixels, 0)
+	if err != nil {
+		return elapsed, err
+	}
+
+	dr.postProcessed = true
+	return elapsed, nil
+}
+
+// Discard the output of any host post-processing stages so that the
+// post-processing kernels operate on the frame accumulator.
+func (dr *deviceResources) ResetPostProcess() {
+	dr.postProcessed = false
+}
+
+// Upload the output of a host post-processing stage to the post accumulator.
+// The radiance slice contains 3 float32 values per frame pixel normalized by
+// the total number of accumulated samples. Subsequent post-processing stages
+// operate on the uploaded values until ResetPostProcess is invoked.
+func (dr *deviceResources) WritePostRadiance(blockReq *tracer.BlockRequest, radiance []float32) (time.Duration, error) {
+	start := time.Now()
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	if len(radiance) < numPixels*3 {
+		return 0, ErrBufferTooSmall
+	}
+
+	if dr.buffers.PostAccumulator.Size() != numPixels*sizeofAccumulatorSample {
+		err := dr.buffers.PostAccumulator.Allocate(numPixels*sizeofAccumulatorSample, cl.MEM_READ_WRITE)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(dr.radianceScratch) != numPixels*4 {
+		dr.radianceScratch = make([]float32, numPixels*4)
+	}
+	packPostRadiance(dr.radianceScratch, radiance[:numPixels*3], blockReq.SampleWeight())
+
+	err := dr.buffers.PostAccumulator.WriteData(dr.radianceScratch, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	dr.postProcessed = true
+	return time.Since(start), nil
+}
+
+// Convert the normalized radiance (3 float32 values per pixel) of a host
+// post-processing stage into accumulator samples. The post-processing kernels
+// normalize the accumulator contents using the sample weight so we need to
+// undo the normalization.
+func packPostRadiance(out, radiance []float32, sampleWeight float32) {
+	invSampleWeight := float32(1.0)
+	if sampleWeight > 0 {
+		invSampleWeight = 1.0 / sampleWeight
+	}
+
+	for rOffset, wOffset := 0, 0; rOffset < len(radiance); rOffset, wOffset = rOffset+3, wOffset+4 {
+		out[wOffset] = radiance[rOffset] * invSampleWeight
+		out[wOffset+1] = radiance[rOffset+1] * invSampleWeight
+		out[wOffset+2] = radiance[rOffset+2] * invSampleWeight
+		out[wOffset+3] = 0
+	}
+}
+
+// Read the linear radiance of a light path pass normalized by the total
+// number of accumulated samples. The output slice receives 3 float32 values
+// per frame pixel.
+func (dr *deviceResources) ReadLightPathPass(blockReq *tracer.BlockRequest, pass int, out []float32) (time.Duration, error) {
+	start := time.Now()
+	if pass < 0 || pass >= len(dr.lightPathExpressions) {
+		return 0, ErrNoLightPathExpressions
+	}
+
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+	if len(out) < numPixels*3 {
+		return 0, ErrBufferTooSmall
+	}
+
+	if len(dr.radianceScratch) != numPixels*4 {
+		dr.radianceScratch = make([]float32, numPixels*4)
+	}
+	err := dr.buffers.FrameLpeAccumulator.ReadData(pass*numPixels*sizeofAccumulatorSample, 0, numPixels*sizeofAccumulatorSample, dr.radianceScratch)
+	if err != nil {
+		return 0, err
+	}
+
+	sampleWeight := blockReq.SampleWeight()
+	for pixel, rOffset, wOffset := 0, 0, 0; pixel < numPixels; pixel, rOffset, wOffset = pixel+1, rOffset+4, wOffset+3 {
+		out[wOffset] = dr.radianceScratch[rOffset] * sampleWeight
+		out[wOffset+1] = dr.radianceScratch[rOffset+1] * sampleWeight
+		out[wOffset+2] = dr.radianceScratch[rOffset+2] * sampleWeight
+	}
+
+	return time.Since(start), nil
+}
+
+// Read the RGBA frame buffer contents into dst. If dst provides a contiguous
+// block of pixels the data is read directly into it; otherwise it is read
+// into a scratch buffer and then copied to dst using the appropriate stride.
+func (dr *deviceResources) ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error) {
+	start := time.Now()
+	frameSize := int(blockReq.FrameW * blockReq.FrameH * 4)
+
+	pix, err := tracer.FrameTarget(dst, blockReq.FrameW, blockReq.FrameH)
+	if err != nil {
+		return 0, err
+	}
+
+	if pix != nil {
+		err = dr.buffers.FrameBuffer.ReadData(0, 0, frameSize, pix)
+		return time.Since(start), err
+	}
+
+	if len(dr.frameScratch) != frameSize {
+		dr.frameScratch = make([]byte, frameSize)
+	}
+	err = dr.buffers.FrameBuffer.ReadData(0, 0, frameSize, dr.frameScratch)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tracer.CopyFrame(dst, dr.frameScratch, blockReq.FrameW, blockReq.FrameH)
+	return time.Since(start), err
+}
+
+// Read the RGBA contents of the debug buffer into out. The output slice must
+// hold 4 bytes for each frame pixel.
+func (dr *deviceResources) ReadDebugOutput(blockReq *tracer.BlockRequest, out []byte) error {
+	debugBuf := dr.buffers.DebugOutput
+	if debugBuf.Size() < len(out) {
+		return fmt.Errorf("%s: debug buffer size is %d bytes; %d bytes are required for a %dx%d frame", ErrInvalidDebugOutput.Error(), debugBuf.Size(), len(out), blockReq.FrameW, blockReq.FrameH)
+	}
+
+	return debugBuf.ReadData(0, 0, len(out), out)
+}
+
+// Read the raw values generated by the throughput, accumulator and emissive
+// sample debug kernels into out. The output slice must hold 4 float32 values
+// for each frame pixel.
+func (dr *deviceResources) ReadDebugValues(blockReq *tracer.BlockRequest, out []float32) error {
+	debugBuf := dr.buffers.DebugValues
+	if debugBuf.Size() < len(out)*4 {
+		return fmt.Errorf("%s: debug value buffer size is %d bytes; %d bytes are required for a %dx%d frame", ErrInvalidDebugOutput.Error(), debugBuf.Size(), len(out)*4, blockReq.FrameW, blockReq.FrameH)
+	}
+
+	return debugBuf.ReadData(0, 0, len(out)*4, out)
+}
+
 // Clear debug buffer
 func (dr *deviceResources) DebugClearBuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
 	kernel := dr.kernels[debugClearBuffer]
 	numPixels := int(blockReq.FrameW * blockReq.FrameH)
 
-	err := kernel.SetArgs(
-		dr.buffers.DebugOutput,
-	)
+	err := debugClearBufferArgs{
+		Output: dr.buffers.DebugOutput,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -374,43 +1713,38 @@ func (dr *deviceResources) DebugClearBuffer(blockReq *tracer.BlockRequest) (time
 	return kernel.Exec1D(0, numPixels, 0)
 }
 
-// Generate a depth map based on the primary ray intersections.
-func (dr *deviceResources) DebugRayIntersectionDepth(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error) {
-	_, err := dr.DebugClearBuffer(blockReq)
+// Clear debug value buffer
+func (dr *deviceResources) DebugClearValues(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	kernel := dr.kernels[debugClearValues]
+	numPixels := int(blockReq.FrameW * blockReq.FrameH)
+
+	err := debugClearValuesArgs{
+		Output: dr.buffers.DebugValues,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
 
-	type intersection struct {
-		wuvt         types.Vec4
-		meshInstance uint32
-		triIndex     uint32
-		_padding1    uint32
-		_padding2    uint32
-	}
+	return kernel.Exec1D(0, numPixels, 0)
+}
 
-	data, err := dr.buffers.Intersections.ReadDataIntoSlice(make([]intersection, 0))
+// Render the hit distance of the primary ray intersections.
+func (dr *deviceResources) DebugRayIntersectionDepth(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error) {
+	_, err := dr.DebugClearValues(blockReq)
 	if err != nil {
 		return 0, err
 	}
-	var maxDepth float32 = 1.0
-	for _, i := range data.([]intersection) {
-		if i.wuvt[3] != math.MaxFloat32 && i.wuvt[3] > maxDepth {
-			maxDepth = i.wuvt[3]
-		}
-	}
 
 	kernel := dr.kernels[debugRayIntersectionDepth]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
 
-	err = kernel.SetArgs(
-		dr.buffers.RayCounters[activeRayBuf],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.Intersections,
-		maxDepth,
-		dr.buffers.DebugOutput,
-	)
+	err = debugRayIntersectionDepthArgs{
+		NumRays:       dr.buffers.RayCounters[activeRayBuf],
+		Paths:         dr.buffers.Paths,
+		HitFlags:      dr.buffers.HitFlags,
+		Intersections: dr.buffers.Intersections,
+		Output:        dr.buffers.DebugValues,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -428,21 +1762,23 @@ func (dr *deviceResources) DebugRayIntersectionNormals(blockReq *tracer.BlockReq
 	kernel := dr.kernels[debugRayIntersectionNormals]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
 
-	err = kernel.SetArgs(
-		dr.buffers.Rays[activeRayBuf],
-		dr.buffers.RayCounters[activeRayBuf],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.Intersections,
-		dr.buffers.Vertices,
-		dr.buffers.Normals,
-		dr.buffers.UV,
-		dr.buffers.MaterialIndices,
-		dr.buffers.MaterialNodes,
-		dr.buffers.TextureMetadata,
-		dr.buffers.Textures,
-		dr.buffers.DebugOutput,
-	)
+	err = debugRayIntersectionNormalsArgs{
+		Rays:            dr.buffers.Rays[activeRayBuf],
+		NumRays:         dr.buffers.RayCounters[activeRayBuf],
+		Paths:           dr.buffers.Paths,
+		HitFlags:        dr.buffers.HitFlags,
+		Intersections:   dr.buffers.Intersections,
+		Vertices:        dr.buffers.Vertices,
+		Normals:         dr.buffers.Normals,
+		Tangents:        dr.buffers.Tangents,
+		Uv:              dr.buffers.UV,
+		MaterialIndices: dr.buffers.MaterialIndices,
+		VertexOcclusion: dr.buffers.VertexOcclusion,
+		MaterialNodes:   dr.buffers.MaterialNodes,
+		TexMeta:         dr.buffers.TextureMetadata,
+		TexData:         dr.buffers.Textures,
+		Output:          dr.buffers.DebugOutput,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -452,7 +1788,7 @@ func (dr *deviceResources) DebugRayIntersectionNormals(blockReq *tracer.BlockReq
 
 // Render emissiveSamples optionally masking occluded/not-occluded rays.
 func (dr *deviceResources) DebugEmissiveSamples(blockReq *tracer.BlockRequest, maskOccluded, maskNotOccluded uint32) (time.Duration, error) {
-	_, err := dr.DebugClearBuffer(blockReq)
+	_, err := dr.DebugClearValues(blockReq)
 	if err != nil {
 		return 0, err
 	}
@@ -460,16 +1796,16 @@ func (dr *deviceResources) DebugEmissiveSamples(blockReq *tracer.BlockRequest, m
 	kernel := dr.kernels[debugEmissiveSamples]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
 
-	err = kernel.SetArgs(
-		dr.buffers.Rays[2],
-		dr.buffers.RayCounters[2],
-		dr.buffers.Paths,
-		dr.buffers.HitFlags,
-		dr.buffers.EmissiveSamples,
-		maskOccluded,
-		maskNotOccluded,
-		dr.buffers.DebugOutput,
-	)
+	err = debugEmissiveSamplesArgs{
+		Rays:            dr.buffers.Rays[2],
+		NumRays:         dr.buffers.RayCounters[2],
+		Paths:           dr.buffers.Paths,
+		HitFlags:        dr.buffers.HitFlags,
+		EmissiveSamples: dr.buffers.EmissiveSamples,
+		MaskOccluded:    maskOccluded,
+		MaskNotOccluded: maskNotOccluded,
+		Output:          dr.buffers.DebugValues,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -479,7 +1815,7 @@ func (dr *deviceResources) DebugEmissiveSamples(blockReq *tracer.BlockRequest, m
 
 // Render path throughput.
 func (dr *deviceResources) DebugThroughput(blockReq *tracer.BlockRequest) (time.Duration, error) {
-	_, err := dr.DebugClearBuffer(blockReq)
+	_, err := dr.DebugClearValues(blockReq)
 	if err != nil {
 		return 0, err
 	}
@@ -487,10 +1823,10 @@ func (dr *deviceResources) DebugThroughput(blockReq *tracer.BlockRequest) (time.
 	kernel := dr.kernels[debugThroughput]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
 
-	err = kernel.SetArgs(
-		dr.buffers.Paths,
-		dr.buffers.DebugOutput,
-	)
+	err = debugThroughputArgs{
+		Paths:  dr.buffers.Paths,
+		Output: dr.buffers.DebugValues,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -498,23 +1834,27 @@ func (dr *deviceResources) DebugThroughput(blockReq *tracer.BlockRequest) (time.
 	return kernel.Exec1D(0, numPixels, 0)
 }
 
-// Render accumulator contents.
-func (dr *deviceResources) DebugAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error) {
-	_, err := dr.DebugClearBuffer(blockReq)
+// Render trace accumulator contents. The tracedSamples argument specifies the
+// number of samples that have been collected into the trace accumulator so far.
+func (dr *deviceResources) DebugAccumulator(blockReq *tracer.BlockRequest, tracedSamples uint32) (time.Duration, error) {
+	_, err := dr.DebugClearValues(blockReq)
 	if err != nil {
 		return 0, err
 	}
 
 	kernel := dr.kernels[debugAccumulator]
 	numPixels := int(blockReq.FrameW * blockReq.BlockH)
-	sampleWeight := float32(1.0 / float32(blockReq.AccumulatedSamples+blockReq.SamplesPerPixel))
+	var sampleWeight float32 = 1.0
+	if tracedSamples > 0 {
+		sampleWeight = 1.0 / float32(tracedSamples)
+	}
 
-	err = kernel.SetArgs(
-		sampleWeight,
-		dr.buffers.Paths,
-		dr.buffers.TraceAccumulator,
-		dr.buffers.DebugOutput,
-	)
+	err = debugAccumulatorArgs{
+		SampleWeight: sampleWeight,
+		Paths:        dr.buffers.Paths,
+		Accumulator:  dr.buffers.TraceAccumulator,
+		Output:       dr.buffers.DebugValues,
+	}.bind(kernel)
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,83 @@
+package opencl
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+)
+
+// Create a grayscale image with the per-pixel sample counts normalized by the
+// max sample count. The stats slice contains 3 values per pixel (luminance
+// sum, squared luminance sum and sample count).
+func sampleCountImage(stats []float32, frameW, frameH uint32) *image.Gray {
+	var maxCount float32
+	for offset := 2; offset < len(stats); offset += 3 {
+		if stats[offset] > maxCount {
+			maxCount = stats[offset]
+		}
+	}
+
+	im := image.NewGray(image.Rect(0, 0, int(frameW), int(frameH)))
+	if maxCount == 0 {
+		return im
+	}
+
+	for pixel, offset := 0, 0; pixel < int(frameW*frameH); pixel, offset = pixel+1, offset+3 {
+		im.SetGray(pixel%int(frameW), pixel/int(frameW), color.Gray{Y: toGray(stats[offset+2] / maxCount)})
+	}
+
+	return im
+}
+
+// Create a grayscale image with the per-pixel relative standard error of the
+// luminance estimate. Pixels with less than two samples are reported as
+// having the max error.
+func sampleErrorImage(stats []float32, frameW, frameH uint32) *image.Gray {
+	im := image.NewGray(image.Rect(0, 0, int(frameW), int(frameH)))
+	for pixel, offset := 0, 0; pixel < int(frameW*frameH); pixel, offset = pixel+1, offset+3 {
+		im.SetGray(pixel%int(frameW), pixel/int(frameW), color.Gray{Y: toGray(relativeError(stats[offset], stats[offset+1], stats[offset+2]))})
+	}
+
+	return im
+}
+
+// Estimate the relative standard error of a pixel's mean luminance given the
+// sum and squared sum of its sample luminances and the sample count.
+func relativeError(sum, sumSq, count float32) float32 {
+	if count < 2 {
+		return 1
+	}
+
+	n := float64(count)
+	mean := float64(sum) / n
+	variance := (float64(sumSq) - n*mean*mean) / (n - 1)
+	if variance <= 0 {
+		return 0
+	}
+
+	stdErr := math.Sqrt(variance / n)
+	return float32(stdErr / math.Max(math.Abs(mean), 1e-3))
+}
+
+// Map a value in the [0, 1] range to a gray level. Out of range values are clamped.
+func toGray(v float32) uint8 {
+	if !(v > 0) {
+		return 0
+	} else if v >= 1 {
+		return 255
+	}
+	return uint8(v*255 + 0.5)
+}
+
+// Encode image as PNG and write it to a file.
+func writePNG(imgFile string, im image.Image) error {
+	f, err := os.Create(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return png.Encode(f, im)
+}
@@ -0,0 +1,41 @@
+package opencl
+
+import "testing"
+
+func TestSampleCountImage(t *testing.T) {
+	stats := []float32{
+		0, 0, 4,
+		0, 0, 2,
+		0, 0, 0,
+		0, 0, 1,
+	}
+
+	im := sampleCountImage(stats, 2, 2)
+	expGray := []uint8{255, 128, 0, 64}
+	for index, exp := range expGray {
+		if got := im.GrayAt(index%2, index/2).Y; got != exp {
+			t.Errorf("[pixel %d] expected gray level %d; got %d", index, exp, got)
+		}
+	}
+}
+
+func TestRelativeError(t *testing.T) {
+	specs := []struct {
+		sum, sumSq, count float32
+		exp               float32
+	}{
+		// Not enough samples
+		{1, 1, 1, 1},
+		// Constant samples
+		{4, 4, 4, 0},
+		// Samples: 0, 2 => mean 1, variance 2
+		{2, 4, 2, 1},
+	}
+
+	for index, spec := range specs {
+		got := relativeError(spec.sum, spec.sumSq, spec.count)
+		if d := got - spec.exp; d < -1e-5 || d > 1e-5 {
+			t.Errorf("[spec %d] expected relative error %f; got %f", index, spec.exp, got)
+		}
+	}
+}
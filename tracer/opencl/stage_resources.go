@@ -0,0 +1,74 @@
+package opencl
+
+import (
+	"time"
+
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/tracer"
+	"github.com/achilleasa/polaris/types"
+)
+
+// The stageResources interface describes the device operations that are
+// invoked by the pipeline stages. It is implemented by deviceResources;
+// pipeline stages only access the device via this interface so that they can
+// be tested against a mock implementation without an opencl device.
+type stageResources interface {
+	// Accumulators
+	ClearFrameAccumulator(blockReq *tracer.BlockRequest) (time.Duration, error)
+
+	// Primary ray generation
+	GeneratePrimaryRays(blockReq *tracer.BlockRequest, cameraEyePos types.Vec3, cameraFrustrum [4]types.Vec4, lens cameraLens, pixelFilter PixelFilter, rayOrder RayOrder, sampler pathSampler) (time.Duration, error)
+	UploadCameraRays(rays []CameraRay) error
+	WriteBlockCameraRays(rays []CameraRay) error
+	GenerateCustomRays(blockReq *tracer.BlockRequest, rayOffset uint32, rayOrder RayOrder) (time.Duration, error)
+	CameraRayScratch(numRays int) []CameraRay
+
+	// First-hit cache
+	HasPrimaryHits(blockReq *tracer.BlockRequest) bool
+	StorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error)
+	RestorePrimaryHits(blockReq *tracer.BlockRequest) (time.Duration, error)
+
+	// Intersection queries
+	RayIntersectionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ConeOcclusionTest(rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	RayIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error)
+	RayPacketIntersectionQuery(rayBufferIndex uint32, skipInstanceFlags scene.MeshInstanceFlag, numPixels int) (time.Duration, error)
+
+	// Texture streaming
+	RecordTextureFeedback(textureFilter TextureFilter, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+
+	// Shading
+	SampleMediumInteractions(blockReq *tracer.BlockRequest, mediumMatNodeIndex int32, bounce, randSeed uint32, sampler pathSampler, numEmissives uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ShadeHits(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex, mediumMatNodeIndex int32, bounce, minBouncesForRR, randSeed uint32, sampler pathSampler, numEmissives uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, numLightVertices, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ShadePrimaryRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, backplateMatNodeIndex, envMatNodeIndex int32, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ShadeIndirectRayMisses(blockReq *tracer.BlockRequest, diffuseMatNodeIndex, envMatNodeIndex int32, bounce uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ShadeEmissiveHits(blockReq *tracer.BlockRequest, bounce, randSeed uint32, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	AccumulateEmissiveSamples(blockReq *tracer.BlockRequest, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+
+	// Bidirectional path tracing
+	GenerateLightRays(blockReq *tracer.BlockRequest, randSeed, numEmissives, numLightVertices uint32, numPixels int) (time.Duration, error)
+	LightRayIntersectionQuery(rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ShadeLightHits(depth, minBouncesForRR, randSeed, numLightVertices uint32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+	ConnectLightVertex(blockReq *tracer.BlockRequest, bounce, randSeed, lightDepth, numLightVertices uint32, normalCorrection NormalCorrection, textureFilter TextureFilter, clamp SampleClamp, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+
+	// Ambient occlusion
+	ShadeAmbientOcclusion(blockReq *tracer.BlockRequest, randSeed uint32, sampler pathSampler, maxDistance float32, normalCorrection NormalCorrection, rayBufferIndex uint32, numPixels int) (time.Duration, error)
+
+	// Post-processing and output
+	TonemapSimpleReinhard(blockReq *tracer.BlockRequest) (time.Duration, error)
+	ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error)
+	WritePostRadiance(blockReq *tracer.BlockRequest, radiance []float32) (time.Duration, error)
+	ReadSampleStats(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error)
+	ReadLightPathPass(blockReq *tracer.BlockRequest, pass int, out []float32) (time.Duration, error)
+	ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error)
+	ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error)
+
+	// Debugging
+	ReadDebugOutput(blockReq *tracer.BlockRequest, out []byte) error
+	ReadDebugValues(blockReq *tracer.BlockRequest, out []float32) error
+	DebugRayIntersectionDepth(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error)
+	DebugRayIntersectionNormals(blockReq *tracer.BlockRequest, activeRayBuf uint32) (time.Duration, error)
+	DebugEmissiveSamples(blockReq *tracer.BlockRequest, maskOccluded, maskNotOccluded uint32) (time.Duration, error)
+	DebugThroughput(blockReq *tracer.BlockRequest) (time.Duration, error)
+	DebugAccumulator(blockReq *tracer.BlockRequest, tracedSamples uint32) (time.Duration, error)
+}
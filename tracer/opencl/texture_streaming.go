@@ -0,0 +1,310 @@
+package opencl
+
+import (
+	"math"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+)
+
+// The value of texture feedback slots that have not recorded any footprints.
+const textureFeedbackNone = math.MaxInt32
+
+// Streamed textures that have not been requested by the texture feedback
+// are kept at the first mip level whose dimensions do not exceed this value.
+const streamedTextureSize = 64
+
+// The textureResidency type decides which mip levels of the scene textures
+// are kept on the device when texture streaming is enabled.
+//
+// The recordTextureFeedback kernel records the texture footprint of each
+// camera ray hit into a feedback slot for the hit material. Each texture
+// referenced by the material is then streamed starting at the mip level that
+// matches the smallest recorded footprint; the finer levels are dropped. The
+// requested levels only get finer until the scene is replaced so textures
+// that go out of view stay resident until the residency exceeds the budget.
+// In that case, textures that are not visible from the current camera are
+// coarsened first (largest first) followed by the visible ones.
+//
+// Textures that the kernels always sample at the top mip level (bump, normal
+// and opacity maps as well as textures used by emissive and background
+// materials) are always kept at full resolution.
+type textureResidency struct {
+	sc *scene.Scene
+
+	// The max size of the resident texture data in bytes.
+	budget uint64
+
+	// The textures whose mip level depends on the footprint recorded for
+	// each material root node.
+	rootTextures map[uint32][]int32
+
+	// Set for textures that are always kept at full resolution.
+	pinned []bool
+
+	// The finest mip level requested for each texture and the first mip
+	// level of each texture that is currently resident.
+	requested []uint32
+	resident  []uint32
+
+	// Set for textures referenced by surfaces that are visible from the
+	// current camera.
+	visible []bool
+
+	// The packed resident texture data and metadata.
+	data     []byte
+	metadata []scene.TextureMetadata
+}
+
+// Create a texture residency for a scene. Streamable textures start at a
+// coarse mip level until the texture feedback requests finer levels.
+func newTextureResidency(sc *scene.Scene, budget uint64) *textureResidency {
+	numTextures := len(sc.TextureMetadata)
+	r := &textureResidency{
+		sc:           sc,
+		budget:       budget,
+		rootTextures: make(map[uint32][]int32),
+		pinned:       make([]bool, numTextures),
+		requested:    make([]uint32, numTextures),
+		visible:      make([]bool, numTextures),
+	}
+
+	for _, root := range sc.MaterialIndex {
+		if _, seen := r.rootTextures[root]; !seen {
+			r.rootTextures[root] = r.collectTextures(int32(root), nil)
+		}
+	}
+
+	// Background materials are sampled at the top mip level. Emissive
+	// materials (including the env map) are pinned while being collected.
+	for _, root := range []int32{sc.SceneDiffuseMatIndex, sc.SceneBackplateMatIndex} {
+		for _, tex := range r.collectTextures(root, nil) {
+			r.pin(tex)
+		}
+	}
+	for _, emissive := range sc.EmissivePrimitives {
+		r.collectTextures(int32(emissive.MaterialNodeIndex), nil)
+	}
+
+	for tex := range r.requested {
+		if !r.pinned[tex] {
+			r.requested[tex] = coarseMipLevel(&sc.TextureMetadata[tex])
+		}
+	}
+
+	r.resident = r.fit()
+	r.pack()
+	return r
+}
+
+// Append the streamable textures of the material tree with the given root
+// node to the textures list and pin the textures that are always sampled at
+// the top mip level.
+func (r *textureResidency) collectTextures(nodeIndex int32, textures []int32) []int32 {
+	if nodeIndex < 0 || int(nodeIndex) >= len(r.sc.MaterialNodeList) {
+		return textures
+	}
+
+	node := &r.sc.MaterialNodeList[nodeIndex]
+	nodeType := uint32(node.Union1[0])
+	if !material.IsOpType(nodeType) {
+		if material.BxdfType(nodeType) == material.BxdfEmissive {
+			r.pin(node.Union1[3])
+			return textures
+		}
+		return appendTextures(textures, node.Union1[2], node.Union1[3], node.Union5[0])
+	}
+
+	switch material.OpType(nodeType) {
+	case material.OpMix, material.OpMixCurvature, material.OpMixOcclusion:
+		textures = r.collectTextures(node.Union1[1], textures)
+		return r.collectTextures(node.Union1[2], textures)
+	case material.OpMixMap:
+		textures = appendTextures(textures, node.Union1[3])
+		textures = r.collectTextures(node.Union1[1], textures)
+		return r.collectTextures(node.Union1[2], textures)
+	case material.OpBumpMap, material.OpNormalMap, material.OpAlphaCutout:
+		r.pin(node.Union1[3])
+	}
+	return r.collectTextures(node.Union1[1], textures)
+}
+
+// Keep a texture at full resolution.
+func (r *textureResidency) pin(tex int32) {
+	if tex >= 0 && int(tex) < len(r.pinned) {
+		r.pinned[tex] = true
+	}
+}
+
+// Append the valid entries of a list of texture indices to a texture list.
+func appendTextures(textures []int32, texIndices ...int32) []int32 {
+	for _, tex := range texIndices {
+		if tex >= 0 {
+			textures = append(textures, tex)
+		}
+	}
+	return textures
+}
+
+// Update the requested mip levels using the footprints recorded by the
+// texture feedback kernel and repack the resident texture data if the
+// resident levels have changed. The feedback slice stores the footprint
+// for each material node.
+func (r *textureResidency) update(feedback []int32) bool {
+	for tex := range r.visible {
+		r.visible[tex] = false
+	}
+
+	for root, textures := range r.rootTextures {
+		if int(root) >= len(feedback) || feedback[root] == textureFeedbackNone {
+			continue
+		}
+
+		for _, tex := range textures {
+			if int(tex) >= len(r.requested) {
+				continue
+			}
+			r.visible[tex] = true
+			if level := requestedMipLevel(&r.sc.TextureMetadata[tex], feedback[root]); level < r.requested[tex] {
+				r.requested[tex] = level
+			}
+		}
+	}
+
+	resident := r.fit()
+	changed := false
+	for tex, level := range resident {
+		changed = changed || level != r.resident[tex]
+	}
+	if !changed {
+		return false
+	}
+
+	r.resident = resident
+	r.pack()
+	return true
+}
+
+// Select the resident mip levels for the requested levels so that the
+// resident data fits in the budget. Pinned textures are never coarsened so
+// the budget may be exceeded if they do not fit.
+func (r *textureResidency) fit() []uint32 {
+	levels := append([]uint32(nil), r.requested...)
+
+	var size uint64
+	for tex, level := range levels {
+		size += mipChainSize(&r.sc.TextureMetadata[tex], level)
+	}
+
+	for _, visible := range []bool{false, true} {
+		for size > r.budget {
+			// Coarsen the texture with the largest resident top level
+			candidate, candidateSize := -1, uint64(0)
+			for tex, level := range levels {
+				meta := &r.sc.TextureMetadata[tex]
+				if r.pinned[tex] || r.visible[tex] != visible || level >= maxMipLevel(meta) {
+					continue
+				}
+				if levelSize := uint64(meta.MipLevelSize(level)); levelSize > candidateSize {
+					candidate, candidateSize = tex, levelSize
+				}
+			}
+			if candidate == -1 {
+				break
+			}
+
+			size -= candidateSize
+			levels[candidate]++
+		}
+	}
+
+	return levels
+}
+
+// Pack the resident mip levels of each texture into a contiguous block and
+// generate the matching metadata. The metadata of each texture describes its
+// first resident level as the top level so the kernels are not aware of the
+// dropped levels.
+func (r *textureResidency) pack() {
+	var size uint64
+	for tex, level := range r.resident {
+		size += mipChainSize(&r.sc.TextureMetadata[tex], level)
+	}
+
+	r.data = make([]byte, 0, size)
+	r.metadata = make([]scene.TextureMetadata, len(r.resident))
+	for tex, level := range r.resident {
+		meta := r.sc.TextureMetadata[tex]
+		offset, width, height := meta.MipLevel(level)
+		start := minUint64(uint64(offset), uint64(len(r.sc.TextureData)))
+		end := minUint64(start+mipChainSize(&meta, level), uint64(len(r.sc.TextureData)))
+
+		r.metadata[tex] = scene.TextureMetadata{
+			Format:     meta.Format,
+			Width:      width,
+			Height:     height,
+			DataOffset: uint32(len(r.data)),
+			MipLevels:  maxMipLevel(&meta) - level + 1,
+		}
+		r.data = append(r.data, r.sc.TextureData[start:end]...)
+	}
+}
+
+// Get the size of the resident texture data in bytes.
+func (r *textureResidency) size() int {
+	return len(r.data)
+}
+
+// Get the first mip level that does not exceed the streamed texture size.
+func coarseMipLevel(meta *scene.TextureMetadata) uint32 {
+	var level uint32
+	maxLevel := maxMipLevel(meta)
+	for width, height := meta.Width, meta.Height; level < maxLevel && (width > streamedTextureSize || height > streamedTextureSize); level++ {
+		width, height = maxUint32(width>>1, 1), maxUint32(height>>1, 1)
+	}
+	return level
+}
+
+// Convert a recorded texture footprint into the finest mip level sampled by
+// the kernels. It mirrors the level selection of texSelectMipLevel.
+func requestedMipLevel(meta *scene.TextureMetadata, footprint int32) uint32 {
+	level := int64(footprint) + int64(math.Floor(0.5*math.Log2(float64(meta.Width)*float64(meta.Height))))
+	if level < 0 {
+		return 0
+	}
+	if maxLevel := int64(maxMipLevel(meta)); level > maxLevel {
+		return uint32(maxLevel)
+	}
+	return uint32(level)
+}
+
+// Get the index of the last mip level of a texture.
+func maxMipLevel(meta *scene.TextureMetadata) uint32 {
+	if meta.MipLevels == 0 {
+		return 0
+	}
+	return meta.MipLevels - 1
+}
+
+// Get the size of the mip levels of a texture starting at the given level.
+func mipChainSize(meta *scene.TextureMetadata, level uint32) uint64 {
+	var size uint64
+	for ; level <= maxMipLevel(meta); level++ {
+		size += uint64(meta.MipLevelSize(level))
+	}
+	return size
+}
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
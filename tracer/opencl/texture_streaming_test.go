@@ -0,0 +1,189 @@
+package opencl
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/achilleasa/polaris/asset/material"
+	"github.com/achilleasa/polaris/asset/scene"
+	"github.com/achilleasa/polaris/asset/texure"
+)
+
+// Create a scene with the following textures:
+// - 0: a 256x256 reflectance texture of material 0
+// - 1: a 128x128 bump map of material 1
+// - 2: a 512x256 reflectance texture of material 1
+// - 3: a 128x64 radiance texture of an emissive material
+//
+// Each texture mip level is filled with a byte that identifies the texture
+// and the level.
+func textureStreamingScene() *scene.Scene {
+	sc := &scene.Scene{
+		MaterialNodeList: []scene.MaterialNode{
+			{Union1: [4]int32{int32(material.BxdfDiffuse), -1, -1, 0}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.OpBumpMap), 2, -1, 1}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.BxdfDiffuse), -1, -1, 2}, Union5: [1]int32{-1}},
+			{Union1: [4]int32{int32(material.BxdfEmissive), -1, -1, 3}, Union5: [1]int32{-1}},
+		},
+		MaterialIndex:          []uint32{0, 0, 1},
+		EmissivePrimitives:     []scene.EmissivePrimitive{{MaterialNodeIndex: 3}},
+		SceneDiffuseMatIndex:   -1,
+		SceneBackplateMatIndex: -1,
+	}
+
+	dims := [][2]uint32{{256, 256}, {128, 128}, {512, 256}, {128, 64}}
+	formats := []texture.Format{texture.Rgba8, texture.Luminance8, texture.Rgba8, texture.Rgba32F}
+	for tex, dim := range dims {
+		meta := scene.TextureMetadata{
+			Format:     formats[tex],
+			Width:      dim[0],
+			Height:     dim[1],
+			DataOffset: uint32(len(sc.TextureData)),
+			MipLevels:  1,
+		}
+		for w, h := dim[0], dim[1]; w > 1 || h > 1; w, h = maxUint32(w>>1, 1), maxUint32(h>>1, 1) {
+			meta.MipLevels++
+		}
+		for level := uint32(0); level < meta.MipLevels; level++ {
+			sc.TextureData = append(sc.TextureData, bytes.Repeat([]byte{byte(tex<<4) | byte(level)}, int(meta.MipLevelSize(level)))...)
+		}
+		sc.TextureMetadata = append(sc.TextureMetadata, meta)
+	}
+
+	return sc
+}
+
+func TestTextureResidencyLevels(t *testing.T) {
+	sc := textureStreamingScene()
+	r := newTextureResidency(sc, 1<<30)
+
+	// Streamed textures start at the first level that fits in 64x64
+	// texels while bump maps and emissive textures are pinned
+	if exp := []uint32{2, 0, 3, 0}; !reflect.DeepEqual(r.resident, exp) {
+		t.Fatalf("expected initial resident levels to be %v; got %v", exp, r.resident)
+	}
+	if exp := []bool{false, true, false, true}; !reflect.DeepEqual(r.pinned, exp) {
+		t.Fatalf("expected pinned textures to be %v; got %v", exp, r.pinned)
+	}
+
+	// The footprint of material 1 (-9) selects level 0 of its 512x256
+	// texture (0.5 * log2(512*256) = 8.5) while material 0 is not visible
+	feedback := []int32{textureFeedbackNone, -9, textureFeedbackNone, textureFeedbackNone}
+	if !r.update(feedback) {
+		t.Fatal("expected the resident levels to change")
+	}
+	if exp := []uint32{2, 0, 0, 0}; !reflect.DeepEqual(r.resident, exp) {
+		t.Fatalf("expected resident levels to be %v; got %v", exp, r.resident)
+	}
+	if exp := []bool{false, false, true, false}; !reflect.DeepEqual(r.visible, exp) {
+		t.Fatalf("expected visible textures to be %v; got %v", exp, r.visible)
+	}
+
+	// Coarser footprints do not drop the requested levels
+	feedback[1] = 2
+	if r.update(feedback) {
+		t.Fatal("expected the resident levels to remain unchanged")
+	}
+
+	// The footprint of material 0 (-7) selects level 1 of its 256x256
+	// texture
+	feedback[0] = -7
+	if !r.update(feedback) {
+		t.Fatal("expected the resident levels to change")
+	}
+	if exp := []uint32{1, 0, 0, 0}; !reflect.DeepEqual(r.resident, exp) {
+		t.Fatalf("expected resident levels to be %v; got %v", exp, r.resident)
+	}
+}
+
+func TestTextureResidencyBudget(t *testing.T) {
+	sc := textureStreamingScene()
+	meta := sc.TextureMetadata
+
+	// Fit the pinned textures, the full texture 0 and texture 2 from level 2
+	budget := mipChainSize(&meta[0], 0) + mipChainSize(&meta[1], 0) + mipChainSize(&meta[2], 2) + mipChainSize(&meta[3], 0)
+	r := newTextureResidency(sc, budget)
+
+	// Both textures are visible so the largest one gets coarsened
+	feedback := []int32{-8, -9, textureFeedbackNone, textureFeedbackNone}
+	r.update(feedback)
+	if exp := []uint32{1, 0, 1, 0}; !reflect.DeepEqual(r.resident, exp) {
+		t.Fatalf("expected resident levels to be %v; got %v", exp, r.resident)
+	}
+	if size := uint64(r.size()); size > budget {
+		t.Fatalf("expected resident size %d to fit in budget %d", size, budget)
+	}
+
+	// After a camera change that only sees material 0, texture 2 is
+	// coarsened first
+	feedback[1] = textureFeedbackNone
+	r.update(feedback)
+	if exp := []uint32{0, 0, 2, 0}; !reflect.DeepEqual(r.resident, exp) {
+		t.Fatalf("expected resident levels to be %v; got %v", exp, r.resident)
+	}
+
+	// Pinned textures are kept even if they exceed the budget
+	r = newTextureResidency(sc, 1)
+	if r.resident[1] != 0 || r.resident[3] != 0 {
+		t.Fatalf("expected pinned textures to remain at level 0; got %v", r.resident)
+	}
+	if r.resident[0] != maxMipLevel(&meta[0]) || r.resident[2] != maxMipLevel(&meta[2]) {
+		t.Fatalf("expected streamed textures to be coarsened to their last level; got %v", r.resident)
+	}
+}
+
+func TestTextureResidencyPack(t *testing.T) {
+	sc := textureStreamingScene()
+	r := newTextureResidency(sc, 1<<30)
+
+	for tex, level := range r.resident {
+		orig := sc.TextureMetadata[tex]
+		meta := r.metadata[tex]
+		_, expW, expH := orig.MipLevel(level)
+		if meta.Format != orig.Format || meta.Width != expW || meta.Height != expH || meta.MipLevels != orig.MipLevels-level {
+			t.Errorf("[tex %d] unexpected metadata for level %d: %+v", tex, level, meta)
+			continue
+		}
+
+		// Each resident level must match the original level data
+		for residentLevel := uint32(0); residentLevel < meta.MipLevels; residentLevel++ {
+			offset, _, _ := meta.MipLevel(residentLevel)
+			if offset%4 != 0 {
+				t.Errorf("[tex %d] level %d is not dword-aligned", tex, residentLevel)
+			}
+			if exp := byte(tex<<4) | byte(level+residentLevel); r.data[offset] != exp {
+				t.Errorf("[tex %d] expected resident level %d to store level %d; got data 0x%x", tex, residentLevel, level+residentLevel, r.data[offset])
+			}
+		}
+	}
+
+	var expSize uint64
+	for tex, level := range r.resident {
+		expSize += mipChainSize(&sc.TextureMetadata[tex], level)
+	}
+	if uint64(r.size()) != expSize {
+		t.Fatalf("expected packed data size to be %d; got %d", expSize, r.size())
+	}
+}
+
+func TestRequestedMipLevel(t *testing.T) {
+	meta := &scene.TextureMetadata{Width: 512, Height: 256, MipLevels: 10}
+	specs := []struct {
+		footprint int32
+		exp       uint32
+	}{
+		// 0.5 * log2(512*256) = 8.5 is rounded down
+		{-8, 0},
+		{-9, 0},
+		{-64, 0},
+		{-5, 3},
+		{64, 9},
+	}
+
+	for index, spec := range specs {
+		if level := requestedMipLevel(meta, spec.footprint); level != spec.exp {
+			t.Errorf("[spec %d] expected footprint %d to select level %d; got %d", index, spec.footprint, spec.exp, level)
+		}
+	}
+}
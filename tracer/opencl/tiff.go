@@ -0,0 +1,166 @@
+package opencl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// Values for the TIFF SampleFormat tag.
+const (
+	tiffSampleFormatUint  uint16 = 1
+	tiffSampleFormatFloat uint16 = 3
+)
+
+// TIFF tag data types.
+const (
+	tiffShort    uint16 = 3
+	tiffLong     uint16 = 4
+	tiffRational uint16 = 5
+)
+
+// A TIFF IFD entry. Values that do not fit in the 4-byte entry value field
+// are stored after the IFD and referenced by their offset.
+// Rationals are stored as numerator/denominator value pairs.
+type tiffEntry struct {
+	tag      uint16
+	dataType uint16
+	values   []uint32
+}
+
+// Get the encoded entry value size in bytes.
+func (e tiffEntry) size() int {
+	if e.dataType == tiffShort {
+		return 2 * len(e.values)
+	}
+	return 4 * len(e.values)
+}
+
+// Encode the entry values.
+func (e tiffEntry) encode() []byte {
+	buf := make([]byte, e.size())
+	for index, v := range e.values {
+		if e.dataType == tiffShort {
+			binary.LittleEndian.PutUint16(buf[index*2:], uint16(v))
+		} else {
+			binary.LittleEndian.PutUint32(buf[index*4:], v)
+		}
+	}
+	return buf
+}
+
+// Save an uncompressed RGB TIFF image. The data slice contains the pixel
+// samples in little-endian byte order using the specified bits per sample.
+func writeTIFF(imgFile string, data []byte, frameW, frameH uint32, bitsPerSample int, sampleFormat uint16, dpi float32) error {
+	return ioutil.WriteFile(imgFile, encodeTIFF(data, frameW, frameH, bitsPerSample, sampleFormat, dpi), 0644)
+}
+
+// Encode an uncompressed RGB TIFF image. The pixel data is stored as a single
+// strip right after the file header and is followed by the image IFD.
+func encodeTIFF(data []byte, frameW, frameH uint32, bitsPerSample int, sampleFormat uint16, dpi float32) []byte {
+	const headerLen = 8
+	bps := uint32(bitsPerSample)
+
+	entries := []tiffEntry{
+		{256, tiffLong, []uint32{frameW}},
+		{257, tiffLong, []uint32{frameH}},
+		{258, tiffShort, []uint32{bps, bps, bps}},
+		{259, tiffShort, []uint32{1}},        // no compression
+		{262, tiffShort, []uint32{2}},        // RGB
+		{273, tiffLong, []uint32{headerLen}}, // strip offset
+		{277, tiffShort, []uint32{3}},        // samples per pixel
+		{278, tiffLong, []uint32{frameH}},    // rows per strip
+		{279, tiffLong, []uint32{uint32(len(data))}},
+		{284, tiffShort, []uint32{1}}, // chunky planar config
+		{339, tiffShort, []uint32{uint32(sampleFormat), uint32(sampleFormat), uint32(sampleFormat)}},
+	}
+	if dpi > 0 {
+		// Store the resolution as a rational with a fixed denominator
+		res := uint32(math.Floor(float64(dpi)*1000 + 0.5))
+		entries = append(entries,
+			tiffEntry{282, tiffRational, []uint32{res, 1000}},
+			tiffEntry{283, tiffRational, []uint32{res, 1000}},
+			tiffEntry{296, tiffShort, []uint32{2}}, // inches
+		)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// The IFD must start at a word boundary
+	ifdOffset := uint32(headerLen + len(data))
+	ifdOffset += ifdOffset & 1
+	extraOffset := ifdOffset + 2 + uint32(len(entries))*12 + 4
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, ifdOffset)
+	buf.Write(data)
+	if buf.Len() < int(ifdOffset) {
+		buf.WriteByte(0)
+	}
+
+	var extra bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, entry := range entries {
+		binary.Write(&buf, binary.LittleEndian, entry.tag)
+		binary.Write(&buf, binary.LittleEndian, entry.dataType)
+		count := uint32(len(entry.values))
+		if entry.dataType == tiffRational {
+			count /= 2
+		}
+		binary.Write(&buf, binary.LittleEndian, count)
+
+		value := entry.encode()
+		if len(value) > 4 {
+			binary.Write(&buf, binary.LittleEndian, extraOffset+uint32(extra.Len()))
+			extra.Write(value)
+			continue
+		}
+		buf.Write(append(value, make([]byte, 4-len(value))...))
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0)) // no more IFDs
+	buf.Write(extra.Bytes())
+
+	return buf.Bytes()
+}
+
+// Pack the RGB channels of an RGBA image into 8-bit TIFF samples.
+func tiff8Data(im *image.RGBA) []byte {
+	w, h := im.Rect.Dx(), im.Rect.Dy()
+	data := make([]byte, 0, w*h*3)
+	for y := 0; y < h; y++ {
+		row := im.Pix[im.PixOffset(im.Rect.Min.X, im.Rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			data = append(data, row[x*4], row[x*4+1], row[x*4+2])
+		}
+	}
+	return data
+}
+
+// Pack the RGB channels of an RGBA64 image into 16-bit TIFF samples.
+func tiff16Data(im *image.RGBA64) []byte {
+	w, h := im.Rect.Dx(), im.Rect.Dy()
+	data := make([]byte, 0, w*h*6)
+	for y := 0; y < h; y++ {
+		row := im.Pix[im.PixOffset(im.Rect.Min.X, im.Rect.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			// RGBA64 images store big-endian values
+			for channel := 0; channel < 3; channel++ {
+				data = append(data, row[x*8+channel*2+1], row[x*8+channel*2])
+			}
+		}
+	}
+	return data
+}
+
+// Pack a RGB radiance buffer into 32-bit float TIFF samples.
+func tiffFloatData(radiance []float32) []byte {
+	data := make([]byte, len(radiance)*4)
+	for index, v := range radiance {
+		binary.LittleEndian.PutUint32(data[index*4:], math.Float32bits(v))
+	}
+	return data
+}
@@ -0,0 +1,79 @@
+package opencl
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestEncodeFloatTIFF(t *testing.T) {
+	radiance := []float32{
+		0.5, 1, 2,
+		0, 0.25, 100,
+	}
+	data := encodeTIFF(tiffFloatData(radiance), 2, 1, 32, tiffSampleFormatFloat, 300)
+
+	if string(data[0:2]) != "II" || binary.LittleEndian.Uint16(data[2:]) != 42 {
+		t.Fatalf("invalid TIFF header: %v", data[0:4])
+	}
+
+	// Parse IFD entries
+	ifdOffset := binary.LittleEndian.Uint32(data[4:])
+	if ifdOffset%2 != 0 {
+		t.Fatalf("expected IFD offset to be word-aligned; got %d", ifdOffset)
+	}
+	numEntries := int(binary.LittleEndian.Uint16(data[ifdOffset:]))
+	tags := make(map[uint16][]byte)
+	var prevTag uint16
+	for index := 0; index < numEntries; index++ {
+		entry := data[int(ifdOffset)+2+index*12:]
+		tag := binary.LittleEndian.Uint16(entry)
+		if tag <= prevTag {
+			t.Fatalf("expected IFD entries to be sorted by tag; tag %d follows tag %d", tag, prevTag)
+		}
+		prevTag = tag
+		tags[tag] = entry[4:12]
+	}
+
+	readShort := func(tag uint16) uint16 { return binary.LittleEndian.Uint16(tags[tag][4:]) }
+	readLong := func(tag uint16) uint32 { return binary.LittleEndian.Uint32(tags[tag][4:]) }
+
+	if readLong(256) != 2 || readLong(257) != 1 {
+		t.Fatalf("expected image dims to be 2x1; got %dx%d", readLong(256), readLong(257))
+	}
+	if readShort(277) != 3 {
+		t.Fatalf("expected 3 samples per pixel; got %d", readShort(277))
+	}
+
+	// Values that do not fit in the entry are stored at an offset
+	for _, spec := range []struct {
+		tag uint16
+		exp uint16
+	}{{258, 32}, {339, tiffSampleFormatFloat}} {
+		offset := readLong(spec.tag)
+		for sample := uint32(0); sample < 3; sample++ {
+			if got := binary.LittleEndian.Uint16(data[offset+sample*2:]); got != spec.exp {
+				t.Errorf("[tag %d, sample %d] expected value %d; got %d", spec.tag, sample, spec.exp, got)
+			}
+		}
+	}
+
+	resOffset := readLong(282)
+	if num, den := binary.LittleEndian.Uint32(data[resOffset:]), binary.LittleEndian.Uint32(data[resOffset+4:]); float32(num)/float32(den) != 300 {
+		t.Fatalf("expected X resolution to be 300 DPI; got %d/%d", num, den)
+	}
+	if readShort(296) != 2 {
+		t.Fatalf("expected resolution unit to be inches; got %d", readShort(296))
+	}
+
+	// Check pixel data
+	stripOffset := readLong(273)
+	if readLong(279) != uint32(len(radiance)*4) {
+		t.Fatalf("expected strip byte count to be %d; got %d", len(radiance)*4, readLong(279))
+	}
+	for index, exp := range radiance {
+		if got := math.Float32frombits(binary.LittleEndian.Uint32(data[stripOffset+uint32(index*4):])); got != exp {
+			t.Errorf("[sample %d] expected %f; got %f", index, exp, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package opencl
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"path"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/achilleasa/gopencl/v1.2/cl"
+	"github.com/achilleasa/polaris/asset/material"
 	"github.com/achilleasa/polaris/asset/scene"
 	"github.com/achilleasa/polaris/log"
 	"github.com/achilleasa/polaris/tracer"
@@ -30,9 +32,16 @@ type Tracer struct {
 	// the same context.
 	ctx *cl.Context
 
+	// Additional options for building the kernels.
+	buildOpts device.BuildOptions
+
 	// The allocated device resources.
 	resources *deviceResources
 
+	// The device resources accessed by the pipeline stages. Once the
+	// tracer is initialized, it refers to the allocated device resources.
+	stageRes stageResources
+
 	// The tracer id.
 	id string
 
@@ -49,28 +58,119 @@ type Tracer struct {
 	// The uploaded optimized scene data.
 	sceneData *scene.Scene
 
+	// If set, the compressed version of the uploaded scene BVH is
+	// traversed instead of the binary BVH.
+	compressBvh   bool
+	compressedBvh *scene.CompressedBvh
+
+	// The importance sampling distribution for the scene environment
+	// light or nil if the scene does not define an env map.
+	envMap *scene.EnvMapDistribution
+
+	// Set if the uploaded scene defines any participating media.
+	hasMedia bool
+
+	// The resident texture mip levels when texture streaming is enabled
+	// or nil if all scene textures are uploaded.
+	textures *textureResidency
+
 	// Camera attributes
 	cameraPosition types.Vec3
 	cameraFrustrum scene.Frustrum
-}
+	cameraLens     cameraLens
+
+	// The number of samples that have been traced into the trace
+	// accumulator while processing the current block request.
+	tracedSamples uint32
+
+	// The context for the block request that is currently being traced.
+	// Pipeline stages check it between kernel invocations.
+	traceCtx context.Context
 
-// Create a new opencl tracer.
-func NewTracer(id string, device *device.Device, ctx *cl.Context, pipeline *Pipeline) (tracer.Tracer, error) {
-	loggerName := fmt.Sprintf("opencl tracer (%s)", device.Name)
+	// An optional random number generator for generating kernel seeds.
+	// If nil, the global generator is used.
+	rng *rand.Rand
+
+	// The seed for scrambling the samples of the low-discrepancy samplers.
+	// It must remain the same for all samples of a frame so the samples
+	// of each pixel stay stratified.
+	samplerSeed uint32
+}
 
+// Create a new opencl tracer. The tracer device must be specified via the
+// WithDevice option.
+func NewTracer(id string, opts ...TracerOption) (tracer.Tracer, error) {
 	tr := &Tracer{
-		logger:       log.New(loggerName),
-		device:       device,
 		id:           id,
 		changeBuffer: make(map[tracer.ChangeType]interface{}, 0),
 		stats:        &tracer.Stats{},
-		pipeline:     pipeline,
-		ctx:          ctx,
 	}
 
+	for _, opt := range opts {
+		err := opt(tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tr.device == nil {
+		return nil, fmt.Errorf("%s: no device specified", ErrInvalidOption.Error())
+	}
+	if tr.pipeline == nil {
+		tr.pipeline = DefaultPipeline()
+	}
+
+	tr.logger = log.New(fmt.Sprintf("opencl tracer (%s)", tr.device.Name))
 	return tr, nil
 }
 
+// Get the material node index of the scene env map or -1 if the scene does not
+// define an environment light with a radiance texture.
+func (tr *Tracer) envMatNodeIndex() int32 {
+	if tr.envMap == nil {
+		return -1
+	}
+	return int32(tr.envMap.MaterialNodeIndex)
+}
+
+// Check whether any of the scene materials defines a participating medium.
+func sceneHasMedia(sc *scene.Scene) bool {
+	for _, node := range sc.MaterialNodeList {
+		if material.OpType(node.Union1[0]) == material.OpMedium {
+			return true
+		}
+	}
+	return false
+}
+
+// Generate a random seed for the rendering kernels.
+func (tr *Tracer) randUint32() uint32 {
+	if tr.rng != nil {
+		return tr.rng.Uint32()
+	}
+	return rand.Uint32()
+}
+
+// The sampler configuration for the kernels that trace camera paths.
+type pathSampler struct {
+	sampler Sampler
+	seed    uint32
+
+	// The index of the traced sample within the sample sequence of each
+	// pixel.
+	index uint32
+}
+
+// Get the path sampler configuration for the sample that is currently being
+// traced.
+func (tr *Tracer) currentPathSampler(blockReq *tracer.BlockRequest, sampler Sampler) pathSampler {
+	index := blockReq.FirstSample
+	if tr.tracedSamples > 0 {
+		index += tr.tracedSamples - 1
+	}
+	return pathSampler{sampler: sampler, seed: tr.samplerSeed, index: index}
+}
+
 // Get tracer id.
 func (tr *Tracer) Id() string {
 	return tr.id
@@ -91,6 +191,12 @@ func (tr *Tracer) Speed() uint32 {
 	return tr.device.Speed
 }
 
+// Poll the thermal and clock readings of the tracer device. Implements
+// tracer.ThermalReporter.
+func (tr *Tracer) Thermals() (tracer.Thermals, error) {
+	return tr.device.Thermals()
+}
+
 // Initialize tracer
 func (tr *Tracer) Init() error {
 	var err error
@@ -100,7 +206,7 @@ func (tr *Tracer) Init() error {
 	// Init device
 	_, thisFile, _, _ := runtime.Caller(0)
 	pathToMainKernel := path.Join(path.Dir(thisFile), relativePathToMainKernel)
-	err = tr.device.Init(pathToMainKernel, tr.ctx)
+	err = tr.device.InitWithOptions(pathToMainKernel, tr.ctx, tr.buildOpts)
 	if err != nil {
 		tr.cleanup()
 		return err
@@ -113,6 +219,24 @@ func (tr *Tracer) Init() error {
 		return err
 	}
 
+	tr.stageRes = tr.resources
+	tr.resources.collectSampleStats = tr.pipeline.CollectSampleStats
+	tr.resources.compensatedAccumulation = tr.pipeline.CompensatedAccumulation
+	tr.resources.collectLightGroups = tr.pipeline.LightGroups
+
+	err = tr.resources.SetLightPathExpressions(tr.pipeline.LightPathExpressions)
+	if err != nil {
+		tr.cleanup()
+		return err
+	}
+
+	// Ensure that the kernels agree with the host on the shared data layout
+	err = tr.resources.CheckLayouts(tr.device)
+	if err != nil {
+		tr.cleanup()
+		return tracer.WrapError(tracer.ErrKernelBuild, err)
+	}
+
 	return nil
 }
 
@@ -131,6 +255,7 @@ func (tr *Tracer) cleanup() {
 		tr.resources.Close()
 		tr.resources = nil
 	}
+	tr.stageRes = nil
 
 	// Shutdown device
 	if tr.device != nil {
@@ -139,6 +264,9 @@ func (tr *Tracer) cleanup() {
 	}
 
 	tr.sceneData = nil
+	tr.envMap = nil
+	tr.hasMedia = false
+	tr.textures = nil
 }
 
 // Retrieve last frame statistics.
@@ -171,12 +299,76 @@ func (tr *Tracer) commitChanges() (time.Duration, error) {
 			dims := data.([2]uint32)
 			err = tr.resources.ResizeBuffers(dims[0], dims[1])
 		case tracer.SceneData:
-			tr.sceneData = data.(*scene.Scene)
+			sc, isScene := data.(*scene.Scene)
+			if !isScene || sc == nil {
+				err = tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+				break
+			}
+			tr.sceneData = sc
+			tr.hasMedia = sceneHasMedia(sc)
+			tr.resources.InvalidatePrimaryHits()
 			err = tr.resources.buffers.UploadSceneData(tr.sceneData)
+			if err != nil {
+				break
+			}
+
+			err = tr.uploadTextures(sc)
+			if err != nil {
+				break
+			}
+
+			err = tr.resources.buffers.UploadVolumes(sc.VolumeGrids, sc.VolumeData)
+			if err != nil {
+				break
+			}
+
+			err = tr.resources.UploadVertexTangents(sc.VertexTangents())
+			if err != nil {
+				break
+			}
+
+			err = tr.resources.UploadLightGroups(sc)
+			if err != nil {
+				break
+			}
+
+			err = tr.uploadCompressedBvh(sc)
+			if err != nil {
+				break
+			}
+
+			tr.envMap = sc.EnvMapDistribution(maxEnvMapDistributionWidth)
+			err = tr.resources.UploadEnvMapDistribution(tr.envMap)
+			if err != nil {
+				break
+			}
+
+			// Estimate the local occlusion used by mixOcclusion material nodes
+			_, err = tr.resources.UpdateLocalOcclusion(sc.LocalOcclusionTargets(), len(sc.VertexList), tr.randUint32())
 		case tracer.CameraData:
 			camera := data.(*scene.Camera)
 			tr.cameraPosition = camera.Position
 			tr.cameraFrustrum = camera.Frustrum
+			tr.resources.InvalidatePrimaryHits()
+
+			// Restart collecting the textures that are visible from
+			// the new camera position
+			if tr.textures != nil {
+				err = tr.resources.ResetTextureFeedback(len(tr.sceneData.MaterialNodeList))
+				if err != nil {
+					break
+				}
+			}
+
+			// Only upload the bokeh samples if they have changed
+			lens := newCameraLens(camera)
+			if !sameBokehSamples(lens.bokehSamples, tr.cameraLens.bokehSamples) || tr.resources.buffers.BokehSamples.Size() == 0 {
+				err = tr.resources.buffers.UploadBokehSamples(lens.bokehSamples)
+			}
+			tr.cameraLens = lens
+		case tracer.InstanceData:
+			// Instance updates are applied after any pending scene upload
+			continue
 		default:
 			err = fmt.Errorf("unsupported change type %d", changeType)
 		}
@@ -186,22 +378,118 @@ func (tr *Tracer) commitChanges() (time.Duration, error) {
 		}
 	}
 
+	if data, hasUpdate := tr.changeBuffer[tracer.InstanceData]; hasUpdate {
+		err = tr.uploadInstanceData(data)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
 	tr.changeBuffer = make(map[tracer.ChangeType]interface{}, 0)
 	return time.Since(start), nil
 }
 
+// Upload the mesh instance data for the next frame. The host scene data is
+// shared with other tracers so it is left untouched; the tracer only uses it
+// for values that an instance update cannot change.
+func (tr *Tracer) uploadInstanceData(data interface{}) error {
+	update, isUpdate := data.(*scene.InstanceUpdate)
+	if !isUpdate || update == nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, ErrInvalidChangeData)
+	}
+	if tr.sceneData == nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
+	}
+	if err := tr.sceneData.CheckInstanceUpdate(update); err != nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, err)
+	}
+
+	tr.resources.InvalidatePrimaryHits()
+	err := tr.resources.buffers.UploadInstanceData(update)
+	if err != nil || tr.compressedBvh == nil {
+		return err
+	}
+
+	// The compressed BVH is owned by this tracer so it can be refitted in place
+	if err = tr.compressedBvh.Refit(update.TopLevelBvhNodes); err != nil {
+		return tracer.WrapError(tracer.ErrSceneInvalid, err)
+	}
+	return tr.resources.buffers.UploadCompressedTopLevelBvh(tr.compressedBvh)
+}
+
+// Upload the scene textures. If the pipeline enables texture streaming, only
+// a coarse version of each streamed texture is initially uploaded; the
+// resident mip levels are updated after tracing each block using the texture
+// feedback of the primary ray hits.
+func (tr *Tracer) uploadTextures(sc *scene.Scene) error {
+	tr.textures = nil
+	if tr.pipeline.TextureStreamingBudget == 0 {
+		return tr.resources.buffers.UploadTextures(sc.TextureData, sc.TextureMetadata)
+	}
+
+	tr.textures = newTextureResidency(sc, tr.pipeline.TextureStreamingBudget)
+	err := tr.resources.ResetTextureFeedback(len(sc.MaterialNodeList))
+	if err != nil {
+		return err
+	}
+	return tr.resources.buffers.UploadTextures(tr.textures.data, tr.textures.metadata)
+}
+
+// Update the resident texture mip levels using the texture feedback recorded
+// while tracing and upload the resident textures if they have changed.
+func (tr *Tracer) updateResidentTextures() error {
+	feedback := make([]int32, len(tr.sceneData.MaterialNodeList))
+	err := tr.resources.ReadTextureFeedback(feedback)
+	if err != nil || !tr.textures.update(feedback) {
+		return err
+	}
+
+	tr.logger.Debugf("streamed textures: %d bytes resident", tr.textures.size())
+	return tr.resources.buffers.UploadTextures(tr.textures.data, tr.textures.metadata)
+}
+
+// Build and upload the compressed version of the scene BVH if the tracer is
+// configured to use it. Otherwise, placeholder buffers are uploaded so that
+// the intersection kernel arguments can always be bound.
+func (tr *Tracer) uploadCompressedBvh(sc *scene.Scene) error {
+	tr.compressedBvh = nil
+	if tr.compressBvh {
+		cb, err := scene.CompressBvh(sc)
+		if err != nil {
+			return tracer.WrapError(tracer.ErrSceneInvalid, err)
+		}
+		tr.compressedBvh = cb
+		tr.logger.Infof("compressed %d BVH nodes (%d bytes) into %d nodes (%d bytes)",
+			len(sc.BvhNodeList), len(sc.BvhNodeList)*scene.SizeofBvhNode,
+			len(cb.Nodes), len(cb.Nodes)*scene.SizeofCompressedBvhNode,
+		)
+	}
+	return tr.resources.buffers.UploadCompressedBvh(tr.compressedBvh)
+}
+
 // Process block request.
 func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
+	return tr.TraceContext(context.Background(), blockReq)
+}
+
+// Process block request. The context is checked before each sample and
+// between the integrator bounces; if it gets cancelled, the remaining
+// pipeline stages are skipped and the context error is returned.
+// Implements tracer.ContextTracer.
+func (tr *Tracer) TraceContext(ctx context.Context, blockReq *tracer.BlockRequest) (time.Duration, error) {
 	var err error
 	start := time.Now()
 
+	tr.traceCtx = ctx
+	defer func() { tr.traceCtx = nil }()
+
 	_, err = tr.commitChanges()
 	if err != nil {
 		return time.Since(start), err
 	}
 
 	if tr.sceneData == nil {
-		return time.Since(start), ErrNoSceneData
+		return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
 	}
 
 	// If we have reset our sample counter, reset the accumulator
@@ -217,9 +505,36 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 		return time.Since(start), err
 	}
 
+	err = tr.resources.ClearStackOverflows()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if tr.pipeline.CollectSampleStats {
+		if blockReq.AccumulatedSamples == 0 {
+			_, err = tr.resources.ClearFrameSampleStats(blockReq)
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+
+		_, err = tr.resources.ClearTraceSampleStats(blockReq)
+		if err != nil {
+			return time.Since(start), err
+		}
+	}
+
+	// Note: blockReq.AccumulatedSamples is intentionally left untouched
+	// while tracing so that post-processing stages can always calculate the
+	// correct sample weight via blockReq.SampleWeight()
 	var sample uint32
 	for sample = 0; sample < blockReq.SamplesPerPixel; sample++ {
-		blockReq.Seed = rand.Uint32()
+		if err = tr.interrupted(); err != nil {
+			return time.Since(start), err
+		}
+
+		tr.tracedSamples = sample + 1
+		blockReq.Seed = tr.randUint32()
 
 		// Generate primary rays
 		if tr.pipeline.PrimaryRayGenerator != nil {
@@ -237,7 +552,25 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 			}
 		}
 
-		blockReq.AccumulatedSamples++
+		// Update sample statistics
+		if tr.pipeline.CollectSampleStats {
+			_, err = tr.resources.AccumulateSampleStats(blockReq)
+			if err != nil {
+				return time.Since(start), err
+			}
+		}
+	}
+
+	tr.stats.StackOverflows, err = tr.resources.ReadStackOverflows()
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if tr.textures != nil {
+		err = tr.updateResidentTextures()
+		if err != nil {
+			return time.Since(start), err
+		}
 	}
 
 	tr.stats.BlockW = blockReq.BlockW
@@ -246,13 +579,22 @@ func (tr *Tracer) Trace(blockReq *tracer.BlockRequest) (time.Duration, error) {
 	return tr.stats.RenderTime, nil
 }
 
+// Check whether the context of the block request that is currently being
+// traced has been cancelled and return its error.
+func (tr *Tracer) interrupted() error {
+	if tr.traceCtx == nil {
+		return nil
+	}
+	return tr.traceCtx.Err()
+}
+
 // Run post-process filters and update the framebuffer with the processed output.
 func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration, error) {
 	var err error
 	start := time.Now()
 
 	if tr.sceneData == nil {
-		return time.Since(start), ErrNoSceneData
+		return time.Since(start), tracer.WrapError(tracer.ErrSceneInvalid, ErrNoSceneData)
 	}
 
 	// Wait for async kernels to finish
@@ -265,6 +607,15 @@ func (tr *Tracer) SyncFramebuffer(blockReq *tracer.BlockRequest) (time.Duration,
 		return time.Since(start), nil
 	}
 
+	// Host post-processing stages always start from the frame accumulator
+	// or, if the light group intensities were changed, the mixed light
+	// group passes
+	tr.resources.ResetPostProcess()
+	_, err = tr.resources.MixLightGroups(blockReq)
+	if err != nil {
+		return time.Since(start), err
+	}
+
 	for _, stage := range tr.pipeline.PostProcess {
 		_, err = stage(tr, blockReq)
 		if err != nil {
@@ -282,5 +633,79 @@ func (tr *Tracer) MergeOutput(other tracer.Tracer, blockReq *tracer.BlockRequest
 		return 0, fmt.Errorf("merge failed: unsupported tracer instance")
 	}
 
-	return tr.resources.AggregateAccumulator(src.resources.buffers.TraceAccumulator, blockReq)
+	if tr.resources == nil || src.resources == nil {
+		return 0, ErrNotInitialized
+	}
+
+	elapsed, err := tr.resources.AggregateAccumulator(src.resources.buffers.TraceAccumulator, blockReq)
+	if err != nil {
+		return elapsed, err
+	}
+
+	if tr.resources.numLightPathPasses() != 0 {
+		lpeElapsed, err := tr.resources.AggregateLightPathAccumulator(src.resources.buffers.TraceLpeAccumulator, blockReq)
+		elapsed += lpeElapsed
+		if err != nil {
+			return elapsed, err
+		}
+	}
+
+	if !tr.pipeline.CollectSampleStats {
+		return elapsed, nil
+	}
+
+	statsElapsed, err := tr.resources.AggregateSampleStats(src.resources.buffers.TraceSampleStats, blockReq)
+	return elapsed + statsElapsed, err
+}
+
+// Read the linear radiance stored in the frame accumulator normalized by
+// the total number of accumulated samples. If called by a post-processing
+// stage that follows a HostPostProcess stage, the processed radiance is
+// returned instead.
+func (tr *Tracer) ReadRadiance(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	if tr.stageRes == nil {
+		return 0, ErrNotInitialized
+	}
+
+	return tr.stageRes.ReadRadiance(blockReq, out)
+}
+
+// Read the per-pixel sample statistics collected by the tracer. The output
+// slice receives the luminance sum, the squared luminance sum and the sample
+// count for each frame pixel.
+func (tr *Tracer) ReadSampleStats(blockReq *tracer.BlockRequest, out []float32) (time.Duration, error) {
+	if tr.stageRes == nil {
+		return 0, ErrNotInitialized
+	}
+
+	return tr.stageRes.ReadSampleStats(blockReq, out)
+}
+
+// Read the linear radiance of the output pass for the light path expression
+// with the given index normalized by the total number of accumulated samples.
+func (tr *Tracer) ReadLightPathPass(blockReq *tracer.BlockRequest, pass int, out []float32) (time.Duration, error) {
+	if tr.stageRes == nil {
+		return 0, ErrNotInitialized
+	}
+
+	return tr.stageRes.ReadLightPathPass(blockReq, pass, out)
+}
+
+// Read the linear radiance, sample statistics and light path expression
+// passes of a single frame pixel. Implements tracer.PixelProber.
+func (tr *Tracer) ProbePixel(blockReq *tracer.BlockRequest, x, y uint32) (tracer.PixelProbe, error) {
+	if tr.stageRes == nil {
+		return tracer.PixelProbe{}, ErrNotInitialized
+	}
+
+	return tr.stageRes.ProbePixel(blockReq, x, y)
+}
+
+// Read the RGBA output frame buffer into a user-provided target.
+func (tr *Tracer) ReadFrame(blockReq *tracer.BlockRequest, dst interface{}) (time.Duration, error) {
+	if tr.stageRes == nil {
+		return 0, ErrNotInitialized
+	}
+
+	return tr.stageRes.ReadFrame(blockReq, dst)
 }
@@ -0,0 +1,53 @@
+package tracer
+
+// The linear HDR values of a single frame pixel.
+type PixelProbe struct {
+	// Pixel coordinates.
+	X, Y uint32
+
+	// The number of samples per pixel accumulated into the frame.
+	Samples uint32
+
+	// The linear radiance of the pixel normalized by the number of
+	// accumulated samples.
+	Radiance [3]float32
+
+	// The relative standard error of the pixel's mean luminance or a
+	// negative value if the tracer does not collect sample statistics.
+	RelativeError float32
+
+	// The normalized radiance of any light path expression passes keyed
+	// by pass name.
+	LightPaths map[string][3]float32
+}
+
+// Get the luminance of the probed radiance.
+func (p *PixelProbe) Luminance() float32 {
+	return 0.2126*p.Radiance[0] + 0.7152*p.Radiance[1] + 0.0722*p.Radiance[2]
+}
+
+// The PixelProber interface is implemented by tracers that can read back the
+// values of a single frame pixel without reading back the entire frame. It
+// allows viewers to implement color pickers and light meters.
+type PixelProber interface {
+	// Read the values of the frame pixel at (x, y) for the frame described
+	// by the block request.
+	ProbePixel(blockReq *BlockRequest, x, y uint32) (PixelProbe, error)
+}
+
+// Create a probe for the frame pixel at (x, y) and return it together with
+// the pixel index. Tracers use this helper to validate the probed pixel
+// coordinates before reading the pixel values.
+func NewPixelProbe(blockReq *BlockRequest, x, y uint32) (PixelProbe, int, error) {
+	if x >= blockReq.FrameW || y >= blockReq.FrameH {
+		return PixelProbe{}, 0, ErrPixelOutOfBounds
+	}
+
+	probe := PixelProbe{
+		X:             x,
+		Y:             y,
+		Samples:       blockReq.TotalSamples(),
+		RelativeError: -1,
+	}
+	return probe, int(y*blockReq.FrameW + x), nil
+}
@@ -79,6 +79,159 @@ func (sch *perfectScheduler) Schedule(tracers []Tracer, frameH uint32) []uint32
 	return sch.blockAssignment
 }
 
+// The weight of the latest measurement when updating the throughput estimates
+// of the dynamic scheduler. Lower values react slower to changes but are less
+// sensitive to frame-to-frame timing noise.
+const dynamicSchedulerSmoothing = 0.5
+
+// The dynamic scheduler starts with a distribution based on the reported
+// tracer speeds and rebalances the block assignments after each frame using
+// the measured tracer throughput (rows traced per second).
+type dynamicScheduler struct {
+	blockAssignment []uint32
+
+	// Smoothed throughput estimate for each tracer or 0 if the tracer
+	// has not been measured yet.
+	throughput []float64
+}
+
+// Create a new dynamic scheduler instance.
+func DynamicScheduler() BlockScheduler {
+	return &dynamicScheduler{}
+}
+
+// Split frame into blocks of variable height and assign to the pool of
+// tracers using feedback collected from previous frames.
+//
+// Unlike the perfect scheduler, the throughput of each tracer is smoothed
+// using an exponential moving average so that a single slow frame does not
+// cause large swings in the assigned rows. Tracers without valid statistics
+// for the last frame keep their previous estimate; tracers that have never
+// been measured are weighted by their reported speed relative to the measured
+// tracers. Each tracer is always assigned at least one row (as long as the
+// frame is tall enough) so that its throughput can be measured and the
+// assigned rows always add up to frameH.
+func (sch *dynamicScheduler) Schedule(tracers []Tracer, frameH uint32) []uint32 {
+	// Use a speed-based distribution for the first assignment or when the
+	// set of tracers changes
+	if len(sch.blockAssignment) != len(tracers) {
+		sch.throughput = make([]float64, len(tracers))
+		sch.blockAssignment = distributeRows(speedWeights(tracers), frameH)
+		return sch.blockAssignment
+	}
+
+	// Update throughput estimates using the stats for the last frame
+	var measuredThroughput, measuredSpeed float64
+	for idx, tr := range tracers {
+		stats := tr.Stats()
+		if stats != nil && stats.BlockH != 0 && stats.BlockH == sch.blockAssignment[idx] && stats.RenderTime > 0 {
+			measured := float64(stats.BlockH) / stats.RenderTime.Seconds()
+			if sch.throughput[idx] == 0 {
+				sch.throughput[idx] = measured
+			} else {
+				sch.throughput[idx] += dynamicSchedulerSmoothing * (measured - sch.throughput[idx])
+			}
+		}
+
+		if sch.throughput[idx] > 0 {
+			measuredThroughput += sch.throughput[idx]
+			measuredSpeed += float64(tr.Speed())
+		}
+	}
+
+	if measuredThroughput == 0 {
+		sch.blockAssignment = distributeRows(speedWeights(tracers), frameH)
+		return sch.blockAssignment
+	}
+
+	// Convert the speed of tracers without measurements into a throughput
+	// estimate using the ratio of throughput to speed of the measured ones
+	weights := make([]float64, len(tracers))
+	for idx, tr := range tracers {
+		switch {
+		case sch.throughput[idx] > 0:
+			weights[idx] = sch.throughput[idx]
+		case measuredSpeed > 0:
+			weights[idx] = float64(tr.Speed()) * measuredThroughput / measuredSpeed
+		}
+	}
+
+	sch.blockAssignment = distributeRows(weights, frameH)
+	return sch.blockAssignment
+}
+
+// Get the reported speed of each tracer.
+func speedWeights(tracers []Tracer) []float64 {
+	weights := make([]float64, len(tracers))
+	for idx, tr := range tracers {
+		weights[idx] = float64(tr.Speed())
+	}
+	return weights
+}
+
+// Split frameH rows proportionally to a list of weights using the largest
+// remainder method so that the assignments add up to frameH. If all weights
+// are zero, the rows are distributed evenly. Entries that would not get any
+// rows take a row from the entry with the most rows as long as frameH allows
+// it.
+func distributeRows(weights []float64, frameH uint32) []uint32 {
+	rows := make([]uint32, len(weights))
+	if len(weights) == 0 {
+		return rows
+	}
+
+	var weightSum float64
+	for _, w := range weights {
+		weightSum += math.Max(w, 0)
+	}
+
+	remainders := make([]float64, len(weights))
+	var assigned uint32
+	for idx, w := range weights {
+		share := float64(frameH) / float64(len(weights))
+		if weightSum > 0 {
+			share = float64(frameH) * math.Max(w, 0) / weightSum
+		}
+		whole := math.Floor(share)
+		rows[idx] = uint32(whole)
+		remainders[idx] = share - whole
+		assigned += rows[idx]
+	}
+
+	// Hand out the leftover rows to the entries with the largest remainders
+	for ; assigned < frameH; assigned++ {
+		best := 0
+		for idx := range remainders {
+			if remainders[idx] > remainders[best] {
+				best = idx
+			}
+		}
+		rows[best]++
+		remainders[best] = -1
+	}
+
+	// Ensure that each entry gets at least one row
+	for idx := range rows {
+		if rows[idx] != 0 {
+			continue
+		}
+
+		donor := 0
+		for other := range rows {
+			if rows[other] > rows[donor] {
+				donor = other
+			}
+		}
+		if rows[donor] < 2 {
+			break
+		}
+		rows[donor]--
+		rows[idx]++
+	}
+
+	return rows
+}
+
 // Assign blocks to tracers based on reported speed.
 func assignBlocksBasedOnSpeed(tracers []Tracer, frameH uint32) []uint32 {
 	blockAssignment := make([]uint32, len(tracers))
@@ -79,6 +79,81 @@ func TestPerfectScheduler(t *testing.T) {
 	}
 }
 
+func TestDynamicScheduler(t *testing.T) {
+	type spec struct {
+		frameH   uint32
+		rTime1   time.Duration
+		rTime2   time.Duration
+		expRows1 uint32
+		expRows2 uint32
+	}
+	specs := []spec{
+		// First call distributes rows based on the tracer speeds
+		spec{100, 0, 0, 25, 75},
+		// Tracer 1 traces 1000 rows/sec; tracer 2 3000 rows/sec
+		spec{100, 25 * time.Millisecond, 25 * time.Millisecond, 25, 75},
+		// Tracer 1 slows down to 250 rows/sec; the smoothed estimate
+		// becomes 625 rows/sec
+		spec{100, 100 * time.Millisecond, 25 * time.Millisecond, 17, 83},
+		// Missing stats keep the previous estimates
+		spec{100, 0, 0, 17, 83},
+	}
+
+	tr1 := makeMockTracer("mock-1", 1)
+	tr2 := makeMockTracer("mock-2", 3)
+	tracers := []Tracer{tr1, tr2}
+
+	sch := DynamicScheduler()
+	for index, s := range specs {
+		tr1.stats.RenderTime = s.rTime1
+		tr2.stats.RenderTime = s.rTime2
+
+		blockAssignment := sch.Schedule(tracers, s.frameH)
+
+		if blockAssignment[0] != s.expRows1 || blockAssignment[1] != s.expRows2 {
+			t.Fatalf("[spec %d] expected tracers to be assigned %d and %d rows; got %v", index, s.expRows1, s.expRows2, blockAssignment)
+		}
+
+		tr1.stats.BlockH = blockAssignment[0]
+		tr2.stats.BlockH = blockAssignment[1]
+	}
+
+	// Adding a tracer resets the estimates
+	tr3 := makeMockTracer("mock-3", 4)
+	blockAssignment := sch.Schedule([]Tracer{tr1, tr2, tr3}, 80)
+	if blockAssignment[0] != 10 || blockAssignment[1] != 30 || blockAssignment[2] != 40 {
+		t.Fatalf("expected speed-based assignment after adding a tracer; got %v", blockAssignment)
+	}
+}
+
+func TestDistributeRows(t *testing.T) {
+	type spec struct {
+		weights []float64
+		frameH  uint32
+		exp     []uint32
+	}
+	specs := []spec{
+		spec{[]float64{1, 1, 1}, 10, []uint32{4, 3, 3}},
+		spec{[]float64{0, 0}, 5, []uint32{3, 2}},
+		spec{[]float64{1000, 1}, 10, []uint32{9, 1}},
+		spec{[]float64{1, 1, 1}, 2, []uint32{1, 1, 0}},
+	}
+
+	for index, s := range specs {
+		rows := distributeRows(s.weights, s.frameH)
+		var total uint32
+		for idx := range rows {
+			total += rows[idx]
+			if rows[idx] != s.exp[idx] {
+				t.Fatalf("[spec %d] expected rows %v; got %v", index, s.exp, rows)
+			}
+		}
+		if total != s.frameH {
+			t.Fatalf("[spec %d] expected rows to add up to %d; got %d", index, s.frameH, total)
+		}
+	}
+}
+
 type mockTracer struct {
 	id    string
 	speed uint32
@@ -112,12 +187,30 @@ func (mt *mockTracer) Init() error {
 func (mt *mockTracer) Close() {
 }
 
-func (mt *mockTracer) Enqueue(_ BlockRequest) {
+func (mt *mockTracer) Stats() *Stats {
+	return mt.stats
 }
 
-func (mt *mockTracer) Update(_ UpdateType, _ interface{}) {
+func (mt *mockTracer) UpdateState(_ UpdateMode, _ ChangeType, _ interface{}) (time.Duration, error) {
+	return 0, nil
 }
 
-func (mt *mockTracer) Stats() *Stats {
-	return mt.stats
+func (mt *mockTracer) Trace(_ *BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) MergeOutput(_ Tracer, _ *BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) SyncFramebuffer(_ *BlockRequest) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) ReadRadiance(_ *BlockRequest, _ []float32) (time.Duration, error) {
+	return 0, nil
+}
+
+func (mt *mockTracer) ReadFrame(_ *BlockRequest, _ interface{}) (time.Duration, error) {
+	return 0, nil
 }
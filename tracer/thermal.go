@@ -0,0 +1,71 @@
+package tracer
+
+import "fmt"
+
+// Thresholds for detecting throttled devices.
+const (
+	// Devices running hotter than this temperature (in degrees Celsius)
+	// are likely to reduce their clocks to stay within their thermal
+	// envelope.
+	ThrottleTemperature float32 = 90
+
+	// Devices running below this fraction of their max clock while
+	// rendering are considered to be throttled.
+	ThrottleClockRatio float32 = 0.7
+)
+
+// Thermal and clock readings for a tracer device. Zero values indicate
+// readings that are not exposed by the platform.
+type Thermals struct {
+	// Device temperature in degrees Celsius.
+	Temperature float32 `json:"temperature,omitempty"`
+
+	// The current and max device clock in MHz.
+	ClockMHz    uint32 `json:"clock_mhz,omitempty"`
+	MaxClockMHz uint32 `json:"max_clock_mhz,omitempty"`
+}
+
+// Check whether the platform exposes any thermal or clock readings.
+func (t Thermals) Available() bool {
+	return t.Temperature > 0 || t.ClockMHz > 0
+}
+
+// Check whether the readings indicate that the device is being throttled.
+// As devices reduce their clocks while idle, the readings should be collected
+// while the device is busy.
+func (t Thermals) Throttled() bool {
+	if t.Temperature >= ThrottleTemperature {
+		return true
+	}
+	return t.ClockMHz > 0 && t.MaxClockMHz > 0 && float32(t.ClockMHz) < ThrottleClockRatio*float32(t.MaxClockMHz)
+}
+
+// Implements Stringer.
+func (t Thermals) String() string {
+	if !t.Available() {
+		return "n/a"
+	}
+
+	var str string
+	if t.Temperature > 0 {
+		str = fmt.Sprintf("%.0f°C", t.Temperature)
+	}
+	if t.ClockMHz > 0 {
+		if str != "" {
+			str += ", "
+		}
+		str += fmt.Sprintf("%d", t.ClockMHz)
+		if t.MaxClockMHz > 0 {
+			str += fmt.Sprintf("/%d", t.MaxClockMHz)
+		}
+		str += " MHz"
+	}
+	return str
+}
+
+// The ThermalReporter interface is implemented by tracers whose devices can
+// report their temperature and clock speed.
+type ThermalReporter interface {
+	// Poll the device thermal and clock readings.
+	Thermals() (Thermals, error)
+}
@@ -0,0 +1,26 @@
+package tracer
+
+import "testing"
+
+func TestThermalsThrottled(t *testing.T) {
+	specs := []struct {
+		thermals Thermals
+		exp      bool
+		expStr   string
+	}{
+		{Thermals{}, false, "n/a"},
+		{Thermals{Temperature: 65, ClockMHz: 1800, MaxClockMHz: 2000}, false, "65°C, 1800/2000 MHz"},
+		{Thermals{Temperature: 92}, true, "92°C"},
+		{Thermals{ClockMHz: 1000, MaxClockMHz: 2000}, true, "1000/2000 MHz"},
+		{Thermals{ClockMHz: 1000}, false, "1000 MHz"},
+	}
+
+	for index, spec := range specs {
+		if got := spec.thermals.Throttled(); got != spec.exp {
+			t.Errorf("[spec %d] expected Throttled() to return %t; got %t", index, spec.exp, got)
+		}
+		if got := spec.thermals.String(); got != spec.expStr {
+			t.Errorf("[spec %d] expected String() to return %q; got %q", index, spec.expStr, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package tracer
+
+import (
+	"image"
+	"math"
+)
+
+// The gamma value used by the tone-mapping kernels.
+const DefaultGamma float32 = 2.2
+
+// A PostStage transforms the linear RGB value of a pixel. Post stages are
+// executed on the host and allow applications to re-tonemap a radiance
+// buffer retrieved via ReadRadiance (e.g. when the user moves an exposure
+// slider) without invoking any device kernels.
+type PostStage func(r, g, b float32) (float32, float32, float32)
+
+// Scale pixel values by the given exposure.
+func ExposureStage(exposure float32) PostStage {
+	return func(r, g, b float32) (float32, float32, float32) {
+		return r * exposure, g * exposure, b * exposure
+	}
+}
+
+// Apply simple Reinhard tone-mapping.
+func SimpleReinhardStage() PostStage {
+	return func(r, g, b float32) (float32, float32, float32) {
+		return r / (r + 1), g / (g + 1), b / (b + 1)
+	}
+}
+
+// Apply gamma correction. Negative values are clamped to zero.
+func GammaStage(gamma float32) PostStage {
+	invGamma := 1.0 / float64(gamma)
+	return func(r, g, b float32) (float32, float32, float32) {
+		return float32(math.Pow(math.Max(float64(r), 0), invGamma)),
+			float32(math.Pow(math.Max(float64(g), 0), invGamma)),
+			float32(math.Pow(math.Max(float64(b), 0), invGamma))
+	}
+}
+
+// Get the list of post stages that replicate the output of the default
+// pipeline's tone-mapping kernel for the given exposure.
+func DefaultPostStages(exposure float32) []PostStage {
+	return []PostStage{
+		ExposureStage(exposure),
+		SimpleReinhardStage(),
+		GammaStage(DefaultGamma),
+	}
+}
+
+// Apply a list of post stages to a linear radiance buffer containing 3
+// float32 values (RGB) per pixel and write the result to dst. The radiance
+// buffer is not modified so it can be processed multiple times using
+// different stages. Stage output is clamped to the [0, 1] range and
+// quantized in the same way as the device kernels. The dst argument accepts
+// the same frame targets as CopyFrame.
+func Tonemap(dst interface{}, radiance []float32, frameW, frameH uint32, stages ...PostStage) error {
+	pix, err := FrameTarget(dst, frameW, frameH)
+	if err != nil {
+		return err
+	}
+
+	numPixels := int(frameW * frameH)
+	if len(radiance) < numPixels*3 {
+		return ErrFrameSourceTooSmall
+	}
+
+	// If the target is not directly writable, render into a temporary
+	// buffer and copy it over
+	directWrite := pix != nil
+	if !directWrite {
+		pix = make([]byte, numPixels*4)
+	}
+
+	for pixel := 0; pixel < numPixels; pixel++ {
+		r, g, b := radiance[pixel*3], radiance[pixel*3+1], radiance[pixel*3+2]
+		for _, stage := range stages {
+			r, g, b = stage(r, g, b)
+		}
+
+		pix[pixel*4] = quantize(r)
+		pix[pixel*4+1] = quantize(g)
+		pix[pixel*4+2] = quantize(b)
+		pix[pixel*4+3] = 255
+	}
+
+	if directWrite {
+		return nil
+	}
+
+	return CopyFrame(dst, pix, frameW, frameH)
+}
+
+// Apply a list of post stages to a linear radiance buffer and write the
+// result to a 16-bit per channel image. This method works like Tonemap but
+// preserves the additional precision of the radiance buffer which avoids
+// banding in smooth gradients when frames are post-processed further.
+func Tonemap16(dst *image.RGBA64, radiance []float32, frameW, frameH uint32, stages ...PostStage) error {
+	if dst.Rect.Dx() < int(frameW) || dst.Rect.Dy() < int(frameH) {
+		return ErrFrameTargetTooSmall
+	}
+
+	numPixels := int(frameW * frameH)
+	if len(radiance) < numPixels*3 {
+		return ErrFrameSourceTooSmall
+	}
+
+	for pixel := 0; pixel < numPixels; pixel++ {
+		r, g, b := radiance[pixel*3], radiance[pixel*3+1], radiance[pixel*3+2]
+		for _, stage := range stages {
+			r, g, b = stage(r, g, b)
+		}
+
+		offset := dst.PixOffset(dst.Rect.Min.X+pixel%int(frameW), dst.Rect.Min.Y+pixel/int(frameW))
+		for channel, v := range [4]uint16{quantize16(r), quantize16(g), quantize16(b), 0xffff} {
+			dst.Pix[offset+channel*2] = uint8(v >> 8)
+			dst.Pix[offset+channel*2+1] = uint8(v)
+		}
+	}
+
+	return nil
+}
+
+// Clamp a value to the [0, 1] range and convert it to a 16-bit value.
+func quantize16(v float32) uint16 {
+	if !(v > 0) {
+		return 0
+	} else if v >= 1 {
+		return 0xffff
+	}
+	return uint16(v*0xffff + 0.5)
+}
+
+// Clamp a value to the [0, 1] range and convert it to an 8-bit value. Like
+// the device kernels, the scaled value is truncated rather than rounded.
+func quantize(v float32) uint8 {
+	if !(v > 0) {
+		return 0
+	} else if v >= 1 {
+		return 255
+	}
+	return uint8(v * 255)
+}
@@ -0,0 +1,117 @@
+package tracer
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestTonemapDefaultStages(t *testing.T) {
+	radiance := []float32{
+		0, 0, 0,
+		1, 0.5, 0.25,
+		100, -1, float32(math.NaN()),
+	}
+
+	im := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	err := Tonemap(im, radiance, 3, 1, DefaultPostStages(1.0)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replicate the computation performed by the tonemapSimpleReinhard kernel
+	expect := func(v float32) uint8 {
+		mapped := float64(v) / (float64(v) + 1)
+		return uint8(math.Min(math.Max(math.Pow(mapped, 1.0/2.2), 0), 1) * 255)
+	}
+
+	specs := []struct {
+		x   int
+		exp [3]uint8
+	}{
+		{0, [3]uint8{0, 0, 0}},
+		{1, [3]uint8{expect(1), expect(0.5), expect(0.25)}},
+		{2, [3]uint8{expect(100), 0, 0}},
+	}
+
+	for _, spec := range specs {
+		c := im.RGBAAt(spec.x, 0)
+		if got := [3]uint8{c.R, c.G, c.B}; got != spec.exp || c.A != 255 {
+			t.Errorf("[pixel %d] expected color %v; got %v (alpha %d)", spec.x, spec.exp, got, c.A)
+		}
+	}
+}
+
+func TestTonemapExposure(t *testing.T) {
+	radiance := []float32{0.5, 0.5, 0.5}
+
+	var prev uint8
+	for index, exposure := range []float32{0.5, 1, 2, 4} {
+		out := make([]float32, 4)
+		err := Tonemap(out, radiance, 1, 1, DefaultPostStages(exposure)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The radiance buffer must not be modified
+		if radiance[0] != 0.5 {
+			t.Fatalf("expected radiance buffer to remain unmodified; got %v", radiance)
+		}
+
+		v := uint8(out[0] * 255)
+		if index > 0 && v <= prev {
+			t.Errorf("expected exposure %f to produce a brighter pixel than %d; got %d", exposure, prev, v)
+		}
+		prev = v
+	}
+}
+
+func TestTonemapErrors(t *testing.T) {
+	err := Tonemap(image.NewRGBA(image.Rect(0, 0, 2, 2)), make([]float32, 3), 2, 2)
+	if err != ErrFrameSourceTooSmall {
+		t.Fatalf("expected to get ErrFrameSourceTooSmall; got %v", err)
+	}
+
+	err = Tonemap(image.NewGray(image.Rect(0, 0, 2, 2)), make([]float32, 12), 2, 2)
+	if err != ErrUnsupportedFrameTarget {
+		t.Fatalf("expected to get ErrUnsupportedFrameTarget; got %v", err)
+	}
+}
+
+func TestTonemap16(t *testing.T) {
+	radiance := []float32{
+		0, 0, 0,
+		1, 0.5, 0.25,
+		100, -1, float32(math.NaN()),
+	}
+
+	im := image.NewRGBA64(image.Rect(0, 0, 3, 1))
+	err := Tonemap16(im, radiance, 3, 1, DefaultPostStages(1.0)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 16-bit output should match the 8-bit output when truncated
+	im8 := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	err = Tonemap(im8, radiance, 3, 1, DefaultPostStages(1.0)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for x := 0; x < 3; x++ {
+		c16, c8 := im.RGBA64At(x, 0), im8.RGBAAt(x, 0)
+		if c16.A != 0xffff {
+			t.Errorf("[pixel %d] expected alpha to be 0xffff; got %d", x, c16.A)
+		}
+		for channel, v := range [3][2]uint16{{c16.R, uint16(c8.R)}, {c16.G, uint16(c8.G)}, {c16.B, uint16(c8.B)}} {
+			if diff := int(v[0]>>8) - int(v[1]); diff < -1 || diff > 1 {
+				t.Errorf("[pixel %d, channel %d] expected 16-bit value %d to match 8-bit value %d", x, channel, v[0], v[1])
+			}
+		}
+	}
+
+	err = Tonemap16(image.NewRGBA64(image.Rect(0, 0, 1, 1)), radiance, 3, 1)
+	if err != ErrFrameTargetTooSmall {
+		t.Fatalf("expected to get ErrFrameTargetTooSmall; got %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package tracer
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // A unit of work that is processed by a tracer.
 type BlockRequest struct {
@@ -26,11 +29,44 @@ type BlockRequest struct {
 	// The exposure value controls HDR -> LDR mapping.
 	Exposure float32
 
+	// The intensity multipliers for the light groups of tracers that
+	// accumulate a separate pass for each light group. Groups without a
+	// multiplier use 1. Like the exposure, the multipliers are applied
+	// when post-processing the accumulated frame.
+	LightGroupScales []float32
+
 	// A random seed value for the tracer's random number generator.
 	Seed uint32
 
-	// Number of sequential rendered frames from current camera position.
+	// Number of samples per pixel that have already been accumulated into
+	// the frame accumulator from the current camera position. This value
+	// does not include the samples requested by this block request.
 	AccumulatedSamples uint32
+
+	// The index of the first sample traced by this block request within
+	// the sample sequence of each pixel. Tracers that use low-discrepancy
+	// samplers draw the samples of this request starting at this index.
+	// Unlike AccumulatedSamples, it is not reset by remote tracers that
+	// trace each request into an empty accumulator.
+	FirstSample uint32
+}
+
+// Get the total number of samples per pixel that will be stored in the
+// frame accumulator once this block request has been processed.
+func (req *BlockRequest) TotalSamples() uint32 {
+	return req.AccumulatedSamples + req.SamplesPerPixel
+}
+
+// Get the weight that needs to be applied to the frame accumulator contents
+// to obtain the average radiance for each pixel once this block request
+// has been processed.
+func (req *BlockRequest) SampleWeight() float32 {
+	totalSamples := req.TotalSamples()
+	if totalSamples == 0 {
+		return 0.0
+	}
+
+	return 1.0 / float32(totalSamples)
 }
 
 // Tracer statistics.
@@ -44,6 +80,10 @@ type Stats struct {
 
 	// The time for rendering this block
 	RenderTime time.Duration
+
+	// The number of rays whose BVH traversal ran out of stack space while
+	// rendering this block. Such rays may miss scene geometry.
+	StackOverflows uint32
 }
 
 type Flag uint8
@@ -75,6 +115,11 @@ const (
 	FrameDimensions ChangeType = iota
 	SceneData
 	CameraData
+
+	// A *scene.InstanceUpdate with the mesh instance data for the next
+	// frame. Tracers keep the remaining scene data resident and only
+	// upload the changed instance data.
+	InstanceData
 )
 
 type Tracer interface {
@@ -108,4 +153,43 @@ type Tracer interface {
 	// Run post-process filters to the accumulated trace data and
 	// update the output frame buffer.
 	SyncFramebuffer(*BlockRequest) (time.Duration, error)
+
+	// Read the linear radiance stored in the frame accumulator normalized
+	// by the total number of accumulated samples. The output slice must be
+	// able to hold 3 float32 values (RGB) for each frame pixel.
+	ReadRadiance(*BlockRequest, []float32) (time.Duration, error)
+
+	// Read the output frame buffer into a user-provided target. See
+	// CopyFrame for the list of supported target types.
+	ReadFrame(*BlockRequest, interface{}) (time.Duration, error)
+}
+
+// The ContextTracer interface is implemented by tracers that can abort the
+// processing of a block request when a context is cancelled. Tracers check
+// the context between pipeline stages so cancellation takes effect once the
+// currently executing stage completes.
+type ContextTracer interface {
+	// Process block request. If ctx is cancelled before the request has
+	// been processed, TraceContext returns the context error and the
+	// contents of the trace accumulator are undefined.
+	TraceContext(context.Context, *BlockRequest) (time.Duration, error)
+}
+
+// Process a block request using tr. If tr implements ContextTracer, the
+// request can be aborted by cancelling ctx; otherwise the context is only
+// checked before and after tracing.
+func TraceContext(ctx context.Context, tr Tracer, blockReq *BlockRequest) (time.Duration, error) {
+	if ctxTracer, ok := tr.(ContextTracer); ok {
+		return ctxTracer.TraceContext(ctx, blockReq)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	elapsed, err := tr.Trace(blockReq)
+	if err == nil {
+		err = ctx.Err()
+	}
+	return elapsed, err
 }
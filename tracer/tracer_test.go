@@ -0,0 +1,30 @@
+package tracer
+
+import "testing"
+
+func TestBlockRequestSampleWeight(t *testing.T) {
+	type spec struct {
+		accumulated uint32
+		spp         uint32
+		expTotal    uint32
+		expWeight   float32
+	}
+	specs := []spec{
+		spec{0, 0, 0, 0.0},
+		spec{0, 1, 1, 1.0},
+		spec{3, 1, 4, 0.25},
+		spec{6, 2, 8, 0.125},
+	}
+
+	for index, s := range specs {
+		req := &BlockRequest{AccumulatedSamples: s.accumulated, SamplesPerPixel: s.spp}
+
+		if total := req.TotalSamples(); total != s.expTotal {
+			t.Fatalf("[spec %d] expected total samples to be %d; got %d", index, s.expTotal, total)
+		}
+
+		if weight := req.SampleWeight(); weight != s.expWeight {
+			t.Fatalf("[spec %d] expected sample weight to be %f; got %f", index, s.expWeight, weight)
+		}
+	}
+}